@@ -1780,6 +1780,9 @@ async fn run_batch_job(
         stream: Some(false),
         stop_sequences: None,
         seed: None,
+        stop_token_ids: vec![],
+        ignore_eos: false,
+        skip_special_tokens: None,
     };
 
     let job_start = Instant::now();
@@ -0,0 +1,151 @@
+// Command example tours the Inferno Go client against a local server:
+//
+//	go run ./cmd/example
+package main
+
+import (
+	"fmt"
+
+	inferno "inferno-example"
+)
+
+func main() {
+	fmt.Println("=== Inferno Go Client Example ===")
+	fmt.Println()
+
+	// Initialize client
+	client := inferno.NewClient("http://localhost:8080", "your_api_key_here")
+
+	// 1. Health check
+	fmt.Println("1. Health Check")
+	if health, err := client.HealthCheck(); err != nil {
+		fmt.Printf("   Error: %v\n", err)
+	} else {
+		fmt.Printf("   Status: %s\n", health.Status)
+		fmt.Printf("   Version: %s\n\n", health.Version)
+	}
+
+	// 2. List models
+	fmt.Println("2. Available Models")
+	if models, err := client.OpenAIModels(); err != nil {
+		fmt.Printf("   Error: %v\n", err)
+	} else {
+		for _, model := range models {
+			fmt.Printf("   - %s (%s)\n", model.ID, model.OwnedBy)
+		}
+		fmt.Println()
+	}
+
+	// 3. Load a model
+	fmt.Println("3. Loading Model")
+	modelID := "llama-2-7b"
+	// Uncomment to actually load:
+	// if result, err := client.LoadModel(modelID, nil); err != nil {
+	//     fmt.Printf("   Error: %v\n", err)
+	// } else {
+	//     fmt.Printf("   Model loaded: %s\n\n", result.Status)
+	// }
+
+	// 4. Simple inference
+	fmt.Println("4. Simple Inference")
+	prompt := "What is artificial intelligence?"
+	fmt.Printf("   Prompt: %s\n", prompt)
+	// Uncomment to run inference:
+	// if response, err := client.Inference(modelID, prompt, 50, 0.7); err != nil {
+	//     fmt.Printf("   Error: %v\n", err)
+	// } else {
+	//     fmt.Printf("   Response: %s\n\n", response)
+	// }
+
+	// 5. Generate embeddings
+	fmt.Println("5. Text Embeddings")
+	texts := []string{"Hello world", "How are you?", "Machine learning is fascinating"}
+	fmt.Printf("   Texts: %v\n", texts)
+	// Uncomment to generate embeddings:
+	// if embeddings, err := client.Embeddings(modelID, texts); err != nil {
+	//     fmt.Printf("   Error: %v\n", err)
+	// } else {
+	//     fmt.Printf("   Generated %d embeddings\n", len(embeddings))
+	//     if len(embeddings) > 0 {
+	//         fmt.Printf("   Embedding dimension: %d\n\n", len(embeddings[0]))
+	//     }
+	// }
+
+	// 6. Chat completion (OpenAI compatible)
+	fmt.Println("6. Chat Completion")
+	messages := []inferno.ChatMessage{
+		{Role: inferno.RoleSystem, Content: "You are a helpful assistant."},
+		{Role: inferno.RoleUser, Content: "What is the capital of France?"},
+	}
+	fmt.Printf("   Messages: %d\n", len(messages))
+	// Uncomment to run chat:
+	// if response, err := client.ChatCompletion(modelID, messages); err != nil {
+	//     fmt.Printf("   Error: %v\n", err)
+	// } else {
+	//     fmt.Printf("   Assistant: %s\n\n", response)
+	// }
+
+	// 7. Batch processing
+	fmt.Println("7. Batch Processing")
+	prompts := []string{
+		"What is Python?",
+		"Explain quantum computing",
+		"How does photosynthesis work?",
+	}
+	fmt.Printf("   Batch size: %d\n", len(prompts))
+	// Uncomment to run the prompts as a /v1/batches job (admin token
+	// required; `inferno batch` does the same from a file):
+	// var input bytes.Buffer
+	// for i, prompt := range prompts {
+	//     line, _ := json.Marshal(inferno.BatchRequestLine{
+	//         CustomID: fmt.Sprintf("req_%d", i),
+	//         Method:   "POST",
+	//         URL:      "/v1/completions",
+	//         Body:     inferno.InferenceRequest{Model: modelID, Prompt: prompt},
+	//     })
+	//     input.Write(append(line, '\n'))
+	// }
+	// ctx := context.Background()
+	// if file, err := client.UploadFile(ctx, "prompts.jsonl", "batch", &input); err != nil {
+	//     fmt.Printf("   Error: %v\n", err)
+	// } else if batch, err := client.CreateBatch(inferno.CreateBatchRequest{InputFileID: file.ID, Endpoint: "/v1/completions"}); err != nil {
+	//     fmt.Printf("   Error: %v\n", err)
+	// } else if batch, err = client.WaitForBatch(ctx, batch.ID, nil); err != nil {
+	//     fmt.Printf("   Status check error: %v\n", err)
+	// } else {
+	//     fmt.Printf("   Completed: %d responses\n\n", batch.RequestCounts.Completed)
+	// }
+
+	// 8. Queue introspection
+	fmt.Println("8. Queue Stats")
+	if stats, err := client.QueueStats(); err != nil {
+		fmt.Printf("   Error: %v\n", err)
+	} else {
+		fmt.Printf("   Queued: %d, running: %d\n", stats.TotalQueued, stats.TotalRunning)
+		if model := stats.Model(modelID); model != nil {
+			fmt.Printf("   %s estimated wait: %dms\n", modelID, model.EstimatedWaitMs)
+		}
+		fmt.Println()
+	}
+
+	// 9. WebSocket streaming (uncomment to test)
+	fmt.Println("9. WebSocket Streaming")
+	fmt.Println("   Setting up WebSocket client...")
+	// wsClient := inferno.NewWebSocketClient("ws://localhost:8080/ws", "your_api_key_here")
+	// if err := wsClient.Connect(); err != nil {
+	//     fmt.Printf("   Connection error: %v\n", err)
+	// } else {
+	//     fmt.Println("   Sending inference request...")
+	//     if err := wsClient.SendInference(modelID, "Tell me a joke", 50); err != nil {
+	//         fmt.Printf("   Send error: %v\n", err)
+	//     } else {
+	//         fmt.Print("   Response: ")
+	//         if err := wsClient.Listen(); err != nil {
+	//             fmt.Printf("   Listen error: %v\n", err)
+	//         }
+	//     }
+	//     wsClient.Close()
+	// }
+
+	fmt.Println("\n=== Example Complete ===")
+}
@@ -0,0 +1,400 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	inferno "inferno-example"
+)
+
+const batchUsage = `usage: inferno batch <command> [flags] [argument]
+
+Commands:
+  submit            run a JSONL file of prompts as a batch and save the results
+  status <batch>    a batch's progress and result files
+  cancel <batch>    stop a batch, keeping the results already in
+  results <batch>   download a finished batch's results as JSONL
+
+Each takes -server and -api-key; "inferno batch <command> -h" lists the rest.
+Batches need the admin token.
+`
+
+// batchCommands are the subcommands of "inferno batch"
+var batchCommands = map[string]func(args []string) error{
+	"submit":  batchSubmit,
+	"status":  batchStatus,
+	"cancel":  batchCancel,
+	"results": batchResults,
+}
+
+// batchEndpoints are the -endpoint values of "inferno batch submit"
+var batchEndpoints = map[string]string{
+	"chat":        inferno.BatchEndpointChat,
+	"completions": inferno.BatchEndpointCompletions,
+	"embeddings":  inferno.BatchEndpointEmbeddings,
+}
+
+// runBatch is the "batch" command, for running many prompts through the
+// OpenAI-style batch API: results go to stdout or -output, progress to
+// stderr, and any failure exits 1.
+func runBatch(args []string) error {
+	if len(args) == 0 || args[0] == "-h" || args[0] == "-help" || args[0] == "help" {
+		fmt.Fprint(os.Stderr, batchUsage)
+		return flag.ErrHelp
+	}
+	run, ok := batchCommands[args[0]]
+	if !ok {
+		fmt.Fprint(os.Stderr, batchUsage)
+		return fmt.Errorf("unknown command %q", args[0])
+	}
+	return run(args[1:])
+}
+
+// batchPrompt is a line of a prompts file that is not a batch request
+type batchPrompt struct {
+	CustomID string `json:"custom_id"`
+	Prompt   string `json:"prompt"`
+}
+
+// batchInput turns a prompts file into a batch input file. Each line is a
+// JSON string, {"prompt": ..., "custom_id": ...} or a full batch request
+// line, which is passed through. Prompts without a custom_id get "line-N".
+// The custom IDs are returned in file order.
+func batchInput(r io.Reader, endpoint, model, system string, maxTokens int) ([]byte, []string, error) {
+	var out bytes.Buffer
+	var ids []string
+	encoder := json.NewEncoder(&out)
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+
+	for number := 1; scanner.Scan(); number++ {
+		text := bytes.TrimSpace(scanner.Bytes())
+		if len(text) == 0 {
+			continue
+		}
+
+		var line inferno.BatchRequestLine
+		var prompt batchPrompt
+		switch {
+		case json.Unmarshal(text, &prompt.Prompt) == nil:
+		case json.Unmarshal(text, &line) == nil && line.Body != nil:
+		case json.Unmarshal(text, &prompt) == nil && prompt.Prompt != "":
+		default:
+			return nil, nil, fmt.Errorf("line %d: expected a JSON string, {\"prompt\": ...} or a batch request", number)
+		}
+
+		if line.Body == nil {
+			if model == "" {
+				return nil, nil, errors.New("pass -model, which prompts are sent to")
+			}
+			line = inferno.BatchRequestLine{CustomID: prompt.CustomID, Body: batchBody(endpoint, model, system, prompt.Prompt, maxTokens)}
+		}
+		if line.CustomID == "" {
+			line.CustomID = fmt.Sprintf("line-%d", number)
+		}
+		if line.Method == "" {
+			line.Method = "POST"
+		}
+		if line.URL == "" {
+			line.URL = endpoint
+		}
+		if err := encoder.Encode(line); err != nil {
+			return nil, nil, err
+		}
+		ids = append(ids, line.CustomID)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, nil, err
+	}
+	if len(ids) == 0 {
+		return nil, nil, errors.New("no prompts")
+	}
+	return out.Bytes(), ids, nil
+}
+
+// batchBody is the request body sending prompt to endpoint
+func batchBody(endpoint, model, system, prompt string, maxTokens int) interface{} {
+	switch endpoint {
+	case inferno.BatchEndpointEmbeddings:
+		return map[string]interface{}{"model": model, "input": prompt}
+	case inferno.BatchEndpointCompletions:
+		body := map[string]interface{}{"model": model, "prompt": prompt}
+		if maxTokens > 0 {
+			body["max_tokens"] = maxTokens
+		}
+		return body
+	}
+
+	var messages []inferno.ChatMessage
+	if system != "" {
+		messages = append(messages, inferno.ChatMessage{Role: inferno.RoleSystem, Content: system})
+	}
+	messages = append(messages, inferno.ChatMessage{Role: inferno.RoleUser, Content: prompt})
+	body := map[string]interface{}{"model": model, "messages": messages}
+	if maxTokens > 0 {
+		body["max_tokens"] = maxTokens
+	}
+	return body
+}
+
+// batchProgress returns a WaitForBatch progress function drawing on bar
+func batchProgress(bar *progressBar) func(*inferno.Batch) {
+	return func(batch *inferno.Batch) {
+		counts := batch.RequestCounts
+		detail := fmt.Sprintf("%d / %d", counts.Completed+counts.Failed, counts.Total)
+		if counts.Failed > 0 {
+			detail += fmt.Sprintf(" (%d failed)", counts.Failed)
+		}
+		if elapsed := time.Since(bar.started).Seconds(); elapsed >= 1 && counts.Total > 0 {
+			detail += fmt.Sprintf("  %.1f/s", float64(counts.Completed+counts.Failed)/elapsed)
+		}
+		bar.draw(string(batch.Status), counts.Fraction(), detail)
+	}
+}
+
+func batchSubmit(args []string) error {
+	fs, newClient := commandFlags("batch", "submit", "")
+	file := fs.String("file", "", "JSONL file of prompts: one JSON string, {\"prompt\": ...} or batch request per line")
+	model := fs.String("model", "", "model to send the prompts to")
+	endpoint := fs.String("endpoint", "chat", "chat, completions or embeddings")
+	system := fs.String("system", "", "system prompt for chat prompts")
+	maxTokens := fs.Int("max-tokens", 0, "max_tokens of each prompt; 0 for the server's default")
+	output := fs.String("output", "", `file for the results; "-" for stdout (default: the input's name with .results.jsonl)`)
+	detach := fs.Bool("detach", false, "start the batch, print its id and return")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *file == "" || fs.NArg() != 0 {
+		fs.Usage()
+		return flag.ErrHelp
+	}
+	url, ok := batchEndpoints[*endpoint]
+	if !ok {
+		return fmt.Errorf("unknown -endpoint %q; expected chat, completions or embeddings", *endpoint)
+	}
+	if *output == "" {
+		*output = strings.TrimSuffix(*file, filepath.Ext(*file)) + ".results.jsonl"
+	}
+
+	prompts, err := os.Open(*file)
+	if err != nil {
+		return err
+	}
+	input, ids, err := batchInput(prompts, url, *model, *system, *maxTokens)
+	prompts.Close()
+	if err != nil {
+		return fmt.Errorf("%s: %w", *file, err)
+	}
+
+	ctx, stop := interruptible()
+	defer stop()
+	client := newClient()
+	uploaded, err := client.UploadFile(ctx, filepath.Base(*file), "batch", bytes.NewReader(input))
+	if err != nil {
+		return fmt.Errorf("uploading %s: %w", *file, err)
+	}
+	batch, err := client.CreateBatch(inferno.CreateBatchRequest{InputFileID: uploaded.ID, Endpoint: url})
+	if err != nil {
+		return err
+	}
+	if *detach {
+		fmt.Println(batch.ID)
+		return nil
+	}
+	fmt.Fprintf(os.Stderr, "Batch %s: %d requests to %s (Ctrl-C cancels)\n", batch.ID, len(ids), url)
+
+	var progress func(*inferno.Batch)
+	var bar *progressBar
+	if isTerminal(os.Stderr) {
+		bar = &progressBar{w: os.Stderr, started: time.Now()}
+		progress = batchProgress(bar)
+	}
+	id := batch.ID
+	batch, err = client.WaitForBatch(ctx, id, progress)
+	if ctx.Err() != nil {
+		// Ctrl-C: cancel the batch but still save what finished, which a
+		// second Ctrl-C abandons
+		stop()
+		if bar != nil {
+			bar.finish()
+		}
+		fmt.Fprintln(os.Stderr, "Cancelling; saving the results already in")
+		if _, err := client.CancelBatch(id); err != nil {
+			return err
+		}
+		batch, err = client.WaitForBatch(context.Background(), id, progress)
+	}
+	if bar != nil {
+		bar.finish()
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := saveBatchResults(client, batch, *output, ids); err != nil {
+		return err
+	}
+	counts := batch.RequestCounts
+	fmt.Fprintf(os.Stderr, "%d succeeded, %d failed", counts.Completed, counts.Failed)
+	if *output != "-" {
+		fmt.Fprintf(os.Stderr, "; results in %s", *output)
+	}
+	fmt.Fprintln(os.Stderr)
+	if batch.Status != inferno.OpenAIBatchCompleted {
+		return fmt.Errorf("batch %s %s after %d of %d requests", batch.ID, batch.Status,
+			counts.Completed+counts.Failed, counts.Total)
+	}
+	return nil
+}
+
+// saveBatchResults writes a batch's results to path, or stdout for "-", in
+// the order of ids, the custom IDs of its input
+func saveBatchResults(client *inferno.Client, batch *inferno.Batch, path string, ids []string) error {
+	var results bytes.Buffer
+	if _, err := client.BatchResults(context.Background(), batch, &results); err != nil {
+		return err
+	}
+
+	order := make(map[string]int, len(ids))
+	for i, id := range ids {
+		order[id] = i
+	}
+	lines := bytes.Split(bytes.TrimSpace(results.Bytes()), []byte("\n"))
+	position := func(line []byte) int {
+		var result inferno.BatchResultLine
+		if json.Unmarshal(line, &result) != nil {
+			return len(ids)
+		}
+		if i, ok := order[result.CustomID]; ok {
+			return i
+		}
+		return len(ids)
+	}
+	sort.SliceStable(lines, func(i, j int) bool { return position(lines[i]) < position(lines[j]) })
+
+	return writeOutput(path, func(w io.Writer) error {
+		for _, line := range lines {
+			if len(line) == 0 {
+				continue
+			}
+			if _, err := fmt.Fprintf(w, "%s\n", line); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// writeOutput calls write with the file at path, created anew, or stdout
+// for "-"
+func writeOutput(path string, write func(w io.Writer) error) error {
+	if path == "-" {
+		return write(os.Stdout)
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := write(file); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+func batchStatus(args []string) error {
+	fs, newClient := commandFlags("batch", "status", "<batch>")
+	asJSON := fs.Bool("json", false, "print the batch as JSON")
+	id, err := parseOne(fs, args)
+	if err != nil {
+		return err
+	}
+
+	batch, err := newClient().Batch(id)
+	if err != nil {
+		return err
+	}
+	if *asJSON {
+		return printJSON(batch)
+	}
+
+	counts := batch.RequestCounts
+	table := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(table, "id:\t%s\n", batch.ID)
+	fmt.Fprintf(table, "status:\t%s\n", batch.Status)
+	fmt.Fprintf(table, "endpoint:\t%s\n", batch.Endpoint)
+	fmt.Fprintf(table, "requests:\t%d completed, %d failed of %d\n", counts.Completed, counts.Failed, counts.Total)
+	fmt.Fprintf(table, "created:\t%s\n", time.Unix(batch.CreatedAt, 0).Format(time.RFC3339))
+	fmt.Fprintf(table, "input file:\t%s\n", batch.InputFileID)
+	if batch.OutputFileID != nil {
+		fmt.Fprintf(table, "output file:\t%s\n", *batch.OutputFileID)
+	}
+	if batch.ErrorFileID != nil {
+		fmt.Fprintf(table, "error file:\t%s\n", *batch.ErrorFileID)
+	}
+	if batch.Errors != nil {
+		for _, inputError := range batch.Errors.Data {
+			where := ""
+			if inputError.Line != nil {
+				where = fmt.Sprintf("line %d: ", *inputError.Line)
+			}
+			fmt.Fprintf(table, "error:\t%s%s (%s)\n", where, inputError.Message, inputError.Code)
+		}
+	}
+	return table.Flush()
+}
+
+func batchCancel(args []string) error {
+	fs, newClient := commandFlags("batch", "cancel", "<batch>")
+	id, err := parseOne(fs, args)
+	if err != nil {
+		return err
+	}
+
+	batch, err := newClient().CancelBatch(id)
+	if err != nil {
+		return err
+	}
+	fmt.Println(batch.ID, batch.Status)
+	return nil
+}
+
+func batchResults(args []string) error {
+	fs, newClient := commandFlags("batch", "results", "<batch>")
+	output := fs.String("o", "-", `file for the results; "-" for stdout`)
+	id, err := parseOne(fs, args)
+	if err != nil {
+		return err
+	}
+
+	client := newClient()
+	batch, err := client.Batch(id)
+	if err != nil {
+		return err
+	}
+	if !batch.Done() {
+		return fmt.Errorf("batch %s is %s; its results are saved once it finishes", id, batch.Status)
+	}
+	if batch.OutputFileID == nil && batch.ErrorFileID == nil {
+		return fmt.Errorf("batch %s %s without results", id, batch.Status)
+	}
+
+	ctx, stop := interruptible()
+	defer stop()
+	return writeOutput(*output, func(w io.Writer) error {
+		_, err := client.BatchResults(ctx, batch, w)
+		return err
+	})
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	inferno "inferno-example"
+	"inferno-example/infernobench"
+)
+
+// defaultBenchPrompts are sent when "inferno bench" is given no -prompts file
+var defaultBenchPrompts = []string{
+	"Explain how a hash map works in two sentences.",
+	"Write a haiku about autumn.",
+	"List three uses of a Raspberry Pi.",
+	"Summarise the plot of Hamlet in one paragraph.",
+}
+
+// runBench is the "bench" command: load against a model for a fixed time,
+// reported as latency percentiles, throughput, time to first token and
+// errors. -max-error-rate and -max-p99 make it exit 1 when the server falls
+// short, to gate a deployment on it.
+//
+//	inferno bench -model llama-3-8b -concurrency 8 -duration 60s
+func runBench(args []string) error {
+	fs := flag.NewFlagSet("bench", flag.ContinueOnError)
+	newClient := clientFlags(fs)
+	model := fs.String("model", "", "model to load; empty uses the first one the server lists")
+	concurrency := fs.Int("concurrency", 8, "requests in flight, each sent as soon as the last finishes")
+	rate := fs.Float64("rate", 0, "send this many requests per second instead, however fast the server answers")
+	duration := fs.Duration("duration", time.Minute, "how long to send requests")
+	requests := fs.Int("requests", 0, "stop after this many requests; 0 for no limit")
+	maxTokens := fs.Int("max-tokens", 128, "tokens to generate per request")
+	stream := fs.Bool("stream", true, "stream responses, which measures time to first token")
+	timeout := fs.Duration("timeout", 0, "bound on each request; 0 for none")
+	promptsFile := fs.String("prompts", "", "file of prompts, one per line, sent in turn (default: a few built in)")
+	asJSON := fs.Bool("json", false, "print the report as JSON")
+	htmlFile := fs.String("html", "", "also write the report as an HTML page to this file")
+	maxErrorRate := fs.Float64("max-error-rate", -1, "exit 1 if more than this fraction of requests fail, as 0.01")
+	maxP99 := fs.Duration("max-p99", 0, "exit 1 if the 99th percentile latency exceeds this")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 0 {
+		fs.Usage()
+		return flag.ErrHelp
+	}
+
+	prompts := defaultBenchPrompts
+	if *promptsFile != "" {
+		var err error
+		if prompts, err = readPrompts(*promptsFile); err != nil {
+			return err
+		}
+	}
+
+	client := newClient()
+	if *model == "" {
+		models, err := client.OpenAIModels()
+		if err != nil {
+			return fmt.Errorf("listing models: %w", err)
+		}
+		if len(models) == 0 {
+			return fmt.Errorf("%s has no models; pass -model", client.BaseURL)
+		}
+		*model = models[0].ID
+	}
+
+	load := fmt.Sprintf("%d concurrent requests", *concurrency)
+	if *rate > 0 {
+		load = fmt.Sprintf("%g requests/s", *rate)
+	}
+	switch {
+	case *requests > 0:
+		load += fmt.Sprintf(", %d requests", *requests)
+	case *duration > 0:
+		load += fmt.Sprintf(" for %s", *duration)
+	}
+	fmt.Fprintf(os.Stderr, "Benchmarking %s on %s: %s (Ctrl-C stops early)\n", *model, client.BaseURL, load)
+
+	// Ctrl-C ends the run early; requests it interrupts count as "canceled"
+	ctx, stop := interruptible()
+	defer stop()
+	report, err := infernobench.Run(ctx, client.BenchTarget(*model, *maxTokens), infernobench.Config{
+		Concurrency:    *concurrency,
+		Rate:           *rate,
+		Duration:       *duration,
+		Requests:       *requests,
+		Prompts:        prompts,
+		Stream:         *stream,
+		RequestTimeout: *timeout,
+		ClassifyError:  inferno.ClassifyBenchError,
+	})
+	if err != nil {
+		return err
+	}
+
+	if *htmlFile != "" {
+		if err := writeOutput(*htmlFile, report.WriteHTML); err != nil {
+			return err
+		}
+	}
+	if *asJSON {
+		if err := printJSON(report); err != nil {
+			return err
+		}
+	} else if err := printBenchReport(report); err != nil {
+		return err
+	}
+
+	if *maxErrorRate >= 0 && report.ErrorRate() > *maxErrorRate {
+		return fmt.Errorf("error rate %.2f%% is above %.2f%%", report.ErrorRate()*100, *maxErrorRate*100)
+	}
+	if *maxP99 > 0 && report.Latency.P99 > *maxP99 {
+		return fmt.Errorf("p99 latency %s is above %s", report.Latency.P99.Round(time.Millisecond), *maxP99)
+	}
+	return nil
+}
+
+// readPrompts returns the non-blank lines of path
+func readPrompts(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var prompts []string
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		if prompt := strings.TrimSpace(scanner.Text()); prompt != "" {
+			prompts = append(prompts, prompt)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(prompts) == 0 {
+		return nil, fmt.Errorf("%s has no prompts", path)
+	}
+	return prompts, nil
+}
+
+// printBenchReport writes report to stdout as tables
+func printBenchReport(report *infernobench.Report) error {
+	ms := func(d time.Duration) string { return d.Round(time.Millisecond).String() }
+
+	table := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(table, "requests:\t%d in %s (%s loop)\n", report.Requests, ms(report.Elapsed), report.Mode)
+	fmt.Fprintf(table, "succeeded:\t%d\n", report.Succeeded)
+	fmt.Fprintf(table, "failed:\t%d (%.2f%%)\n", report.Failed, report.ErrorRate()*100)
+	fmt.Fprintf(table, "throughput:\t%.2f req/s\n", report.Throughput)
+	fmt.Fprintf(table, "tokens:\t%d (%.1f tokens/s)\n", report.TotalTokens, report.TokensPerSecond)
+	if err := table.Flush(); err != nil {
+		return err
+	}
+
+	fmt.Println()
+	table = tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(table, "\tmean\tp50\tp90\tp95\tp99\tmax\t")
+	row := func(name string, p infernobench.Percentiles) {
+		fmt.Fprintf(table, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t\n",
+			name, ms(p.Mean), ms(p.P50), ms(p.P90), ms(p.P95), ms(p.P99), ms(p.Max))
+	}
+	row("latency", report.Latency)
+	if report.TimeToFirstToken != nil {
+		row("first token", *report.TimeToFirstToken)
+	}
+	if err := table.Flush(); err != nil {
+		return err
+	}
+
+	if len(report.Errors) == 0 {
+		return nil
+	}
+	fmt.Println()
+	table = tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	categories := make([]string, 0, len(report.Errors))
+	for category := range report.Errors {
+		categories = append(categories, category)
+	}
+	sort.Strings(categories)
+	for _, category := range categories {
+		fmt.Fprintf(table, "error %s:\t%d\n", category, report.Errors[category])
+	}
+	return table.Flush()
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+
+	"inferno-example/infernorepl"
+)
+
+// runChat is the "chat" command: an interactive chat with any Inferno
+// server. Ctrl-C stops the reply being generated; Ctrl-D or /exit quits.
+//
+//	inferno chat -model llama-3-8b -system "Be brief."
+func runChat(args []string) error {
+	fs := flag.NewFlagSet("chat", flag.ContinueOnError)
+	newClient := clientFlags(fs)
+	model := fs.String("model", "", "model to chat with; empty uses the first one the server lists")
+	system := fs.String("system", "", "system prompt")
+	load := fs.String("load", "", "continue a conversation saved with /save")
+	history := fs.String("history", defaultHistoryFile(), "file keeping inputs across runs; empty keeps none")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	client := newClient()
+	session := &infernorepl.Session{Model: *model, System: *system}
+	if *load != "" {
+		loaded, err := infernorepl.LoadSession(*load)
+		if err != nil {
+			return err
+		}
+		session = loaded
+		if *model != "" {
+			session.Model = *model
+		}
+		if *system != "" {
+			session.System = *system
+		}
+	}
+	if session.Model == "" {
+		models, err := client.OpenAIModels()
+		if err != nil {
+			return fmt.Errorf("listing models: %w", err)
+		}
+		if len(models) == 0 {
+			return fmt.Errorf("%s has no models; pass -model", client.BaseURL)
+		}
+		session.Model = models[0].ID
+	}
+
+	interrupts := make(chan os.Signal, 1)
+	signal.Notify(interrupts, os.Interrupt)
+	defer signal.Stop(interrupts)
+
+	fmt.Printf("Chatting with %s on %s. /help lists commands.\n", session.Model, client.BaseURL)
+	repl := &infernorepl.REPL{
+		Chat:        client.REPLChat(),
+		Session:     session,
+		In:          os.Stdin,
+		Out:         os.Stdout,
+		HistoryFile: *history,
+		Interrupts:  interrupts,
+	}
+	return repl.Run(context.Background())
+}
+
+// defaultHistoryFile is ~/.inferno_history, or none without a home directory
+func defaultHistoryFile() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".inferno_history")
+}
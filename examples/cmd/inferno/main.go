@@ -0,0 +1,91 @@
+// Command inferno is a command line client for an Inferno server:
+//
+//	go install ./cmd/inferno
+//	inferno chat -model llama-3-8b
+//	inferno models list
+//	inferno bench -model llama-3-8b -concurrency 8 -duration 60s
+//	inferno batch submit -file prompts.jsonl -model llama-3-8b
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+
+	inferno "inferno-example"
+)
+
+// commands are what inferno runs, by the name given as its first argument
+var commands = map[string]func(args []string) error{
+	"batch":  runBatch,
+	"bench":  runBench,
+	"chat":   runChat,
+	"models": runModels,
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+	run, ok := commands[os.Args[1]]
+	if !ok {
+		if os.Args[1] != "help" && os.Args[1] != "-h" && os.Args[1] != "-help" {
+			fmt.Fprintf(os.Stderr, "inferno: unknown command %q\n", os.Args[1])
+		}
+		usage()
+		os.Exit(2)
+	}
+	if err := run(os.Args[2:]); err != nil {
+		if err != flag.ErrHelp {
+			fmt.Fprintf(os.Stderr, "inferno %s: %v\n", os.Args[1], err)
+		}
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	fmt.Fprintln(os.Stderr, "usage: inferno <command> [arguments]")
+	fmt.Fprintln(os.Stderr)
+	fmt.Fprintln(os.Stderr, "commands:")
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %s\n", name)
+	}
+	fmt.Fprintln(os.Stderr)
+	fmt.Fprintln(os.Stderr, `"inferno <command> -h" describes a command's flags`)
+}
+
+// commandFlags returns the flag set of "inferno <command> <name>", which
+// takes one argument, described by arg, unless arg is empty
+func commandFlags(command, name, arg string) (*flag.FlagSet, func() *inferno.Client) {
+	fs := flag.NewFlagSet(command+" "+name, flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: inferno %s %s [flags] %s\n", command, name, arg)
+		fs.PrintDefaults()
+	}
+	return fs, clientFlags(fs)
+}
+
+// clientFlags adds the flags every command connects with to fs, returning
+// the client they describe once fs is parsed
+func clientFlags(fs *flag.FlagSet) func() *inferno.Client {
+	server := fs.String("server", envOr("INFERNO_URL", "http://localhost:8080"), "server URL ($INFERNO_URL)")
+	apiKey := fs.String("api-key", envOr("INFERNO_API_KEY", os.Getenv("INFERNO_ADMIN_TOKEN")),
+		"API key, or the admin token for admin commands ($INFERNO_API_KEY, $INFERNO_ADMIN_TOKEN)")
+	return func() *inferno.Client {
+		return inferno.NewClient(*server, *apiKey)
+	}
+}
+
+func envOr(name, fallback string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+	return fallback
+}
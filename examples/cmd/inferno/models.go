@@ -0,0 +1,375 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	inferno "inferno-example"
+)
+
+const modelsUsage = `usage: inferno models <command> [flags] [argument]
+
+Commands:
+  list              models the server serves
+  info <model>      format, size, GGUF header and verification
+  load <model>      load a model and keep it loaded; -wait returns once it has (admin)
+  unload <model>    free a loaded model (admin)
+  download <source> pull hf://org/repo[:revision] or ollama://model[:tag] (admin)
+  delete <model>    delete a model's file (admin)
+
+Each takes -server and -api-key; "inferno models <command> -h" lists the rest.
+`
+
+// modelCommands are the subcommands of "inferno models"
+var modelCommands = map[string]func(args []string) error{
+	"list":     modelsList,
+	"info":     modelsInfo,
+	"load":     modelsLoad,
+	"unload":   modelsUnload,
+	"download": modelsDownload,
+	"delete":   modelsDelete,
+}
+
+// runModels is the "models" command, for scripting model rollout: results
+// go to stdout, progress and prompts to stderr, and any failure exits 1.
+func runModels(args []string) error {
+	if len(args) == 0 || args[0] == "-h" || args[0] == "-help" || args[0] == "help" {
+		fmt.Fprint(os.Stderr, modelsUsage)
+		return flag.ErrHelp
+	}
+	run, ok := modelCommands[args[0]]
+	if !ok {
+		fmt.Fprint(os.Stderr, modelsUsage)
+		return fmt.Errorf("unknown command %q", args[0])
+	}
+	return run(args[1:])
+}
+
+// parseOne parses args into fs and returns its one positional argument
+func parseOne(fs *flag.FlagSet, args []string) (string, error) {
+	if err := fs.Parse(args); err != nil {
+		return "", err
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return "", flag.ErrHelp
+	}
+	return fs.Arg(0), nil
+}
+
+// interruptible returns a context that Ctrl-C cancels
+func interruptible() (context.Context, context.CancelFunc) {
+	return signal.NotifyContext(context.Background(), os.Interrupt)
+}
+
+func printJSON(value interface{}) error {
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(value)
+}
+
+func modelsList(args []string) error {
+	fs, newClient := commandFlags("models", "list", "")
+	asJSON := fs.Bool("json", false, "print the models as JSON")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	models, err := newClient().OpenAIModels()
+	if err != nil {
+		return err
+	}
+	if *asJSON {
+		return printJSON(models)
+	}
+	table := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(table, "ID\tOWNED BY\tCREATED")
+	for _, model := range models {
+		fmt.Fprintf(table, "%s\t%s\t%s\n", model.ID, model.OwnedBy, time.Unix(model.Created, 0).Format("2006-01-02 15:04"))
+	}
+	return table.Flush()
+}
+
+func modelsInfo(args []string) error {
+	fs, newClient := commandFlags("models", "info", "<model>")
+	asJSON := fs.Bool("json", false, "print the metadata as JSON")
+	id, err := parseOne(fs, args)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := interruptible()
+	defer cancel()
+	metadata, err := newClient().ModelMetadata(ctx, id)
+	if err != nil {
+		return err
+	}
+	if *asJSON {
+		return printJSON(metadata)
+	}
+
+	table := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(table, "id:\t%s\n", metadata.ID)
+	fmt.Fprintf(table, "format:\t%s (backend %s)\n", metadata.Format, metadata.BackendType)
+	fmt.Fprintf(table, "size:\t%s\n", formatBytes(metadata.SizeBytes))
+	fmt.Fprintf(table, "modified:\t%s\n", metadata.Modified.Format(time.RFC3339))
+	if metadata.Checksum != nil {
+		fmt.Fprintf(table, "sha256:\t%s\n", *metadata.Checksum)
+	}
+	if gguf := metadata.GGUF; gguf != nil {
+		fmt.Fprintf(table, "architecture:\t%s\n", gguf.Architecture)
+		fmt.Fprintf(table, "parameters:\t%.1fB\n", float64(gguf.ParameterCount)/1e9)
+		fmt.Fprintf(table, "quantization:\t%s\n", gguf.Quantization)
+		fmt.Fprintf(table, "context length:\t%d\n", gguf.ContextLength)
+	}
+	if v := metadata.Verification; v != nil {
+		fmt.Fprintf(table, "verification:\tchecksum %s, signature %s, trusted %t (%s)\n",
+			v.Checksum, v.Signature, v.Trusted, v.VerifiedAt.Format(time.RFC3339))
+	} else {
+		fmt.Fprintf(table, "verification:\tnever verified\n")
+	}
+	return table.Flush()
+}
+
+func modelsLoad(args []string) error {
+	fs, newClient := commandFlags("models", "load", "<model>")
+	wait := fs.Bool("wait", false, "return once the server reports the model loaded, failing if the load fails")
+	timeout := fs.Duration("timeout", 10*time.Minute, "with -wait, how long to wait; 0 waits indefinitely")
+	gpuLayers := fs.Int("gpu-layers", -1, "layers to offload to the GPU; -1 for the server's default")
+	contextSize := fs.Int("context-size", 0, "context size; 0 for the server's default")
+	batchSize := fs.Int("batch-size", 0, "batch size; 0 for the server's default")
+	id, err := parseOne(fs, args)
+	if err != nil {
+		return err
+	}
+
+	options := &inferno.LoadModelRequest{}
+	if *gpuLayers >= 0 {
+		options.GPULayers = gpuLayers
+	}
+	if *contextSize > 0 {
+		options.ContextSize = contextSize
+	}
+	if *batchSize > 0 {
+		options.BatchSize = batchSize
+	}
+
+	client := newClient()
+	if !*wait {
+		result, err := client.LoadModel(id, options)
+		if err != nil {
+			return err
+		}
+		fmt.Println(result.ModelID, result.Status)
+		return nil
+	}
+
+	ctx, cancel := interruptible()
+	defer cancel()
+	if *timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, *timeout)
+		defer cancel()
+	}
+	// Watch before asking, so the event cannot be missed
+	events, err := client.WatchModelEvents(ctx, inferno.ModelEventFilter{Types: []inferno.ModelEventType{inferno.ModelLoaded, inferno.ModelFailed}})
+	if err != nil {
+		return err
+	}
+	result, err := client.LoadModel(id, options)
+	if err != nil {
+		return err
+	}
+	if result.Status == "loaded" {
+		fmt.Println(result.ModelID, result.Status)
+		return nil
+	}
+
+	fmt.Fprintf(os.Stderr, "waiting for %s to load...\n", id)
+	for event := range events {
+		if event.Model != id {
+			continue
+		}
+		if event.Type == inferno.ModelFailed {
+			if event.Stage != inferno.ModelLoaded {
+				continue
+			}
+			return fmt.Errorf("loading %s failed: %s", event.Model, event.Message)
+		}
+		fmt.Println(event.Model, "loaded")
+		return nil
+	}
+	if ctx.Err() != nil {
+		return fmt.Errorf("waiting for %s to load: %w", id, ctx.Err())
+	}
+	return fmt.Errorf("waiting for %s to load: event stream closed", id)
+}
+
+func modelsUnload(args []string) error {
+	fs, newClient := commandFlags("models", "unload", "<model>")
+	id, err := parseOne(fs, args)
+	if err != nil {
+		return err
+	}
+	if err := newClient().UnloadModel(id); err != nil {
+		return err
+	}
+	fmt.Println(id, "unloaded")
+	return nil
+}
+
+func modelsDownload(args []string) error {
+	fs, newClient := commandFlags("models", "download", "<hf://org/repo[:revision] | ollama://model[:tag]>")
+	quantizations := fs.String("quant", "", "comma-separated quantizations to look for, most preferred first (hf:// only)")
+	file := fs.String("file", "", "exact file in the repo to download (hf:// only)")
+	name := fs.String("name", "", "save the model under another file name")
+	detach := fs.Bool("detach", false, "start the download, print its id and return")
+	source, err := parseOne(fs, args)
+	if err != nil {
+		return err
+	}
+
+	request := inferno.ModelDownloadRequest{Source: source}
+	if *quantizations != "" {
+		request.Quantization = strings.Split(*quantizations, ",")
+	}
+	if *file != "" {
+		request.File = file
+	}
+	if *name != "" {
+		request.Name = name
+	}
+
+	client := newClient()
+	if *detach {
+		download, err := client.DownloadModel(request)
+		if err != nil {
+			return err
+		}
+		fmt.Println(download.ID)
+		return nil
+	}
+
+	// Ctrl-C cancels the download on the server too
+	ctx, cancel := interruptible()
+	defer cancel()
+	var progress func(*inferno.ModelDownload)
+	if isTerminal(os.Stderr) {
+		bar := &progressBar{w: os.Stderr, started: time.Now()}
+		progress = bar.update
+		defer bar.finish()
+	}
+	download, err := client.PullModel(ctx, request, progress)
+	if err != nil {
+		return err
+	}
+	// The model name alone on stdout, for scripts to load it next
+	fmt.Println(download.Model)
+	return nil
+}
+
+func modelsDelete(args []string) error {
+	fs, newClient := commandFlags("models", "delete", "<model>")
+	yes := fs.Bool("yes", false, "delete without asking")
+	id, err := parseOne(fs, args)
+	if err != nil {
+		return err
+	}
+
+	client := newClient()
+	if !*yes {
+		if !isTerminal(os.Stdin) {
+			return errors.New("pass -yes to delete without confirmation")
+		}
+		fmt.Fprintf(os.Stderr, "Delete %s from %s? [y/N] ", id, client.BaseURL)
+		answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+		if answer = strings.ToLower(strings.TrimSpace(answer)); answer != "y" && answer != "yes" {
+			return errors.New("not deleted")
+		}
+	}
+
+	ctx, cancel := interruptible()
+	defer cancel()
+	if err := client.DeleteModel(ctx, id); err != nil {
+		return err
+	}
+	fmt.Println(id, "deleted")
+	return nil
+}
+
+// isTerminal reports whether f is a terminal rather than a file or pipe
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// progressBarWidth is the characters the bar itself takes
+const progressBarWidth = 30
+
+// progressBar redraws a download's progress on one terminal line
+type progressBar struct {
+	w       io.Writer
+	started time.Time
+	drawn   bool
+}
+
+func (p *progressBar) update(download *inferno.ModelDownload) {
+	received := formatBytes(download.DownloadedBytes)
+	rate := ""
+	if elapsed := time.Since(p.started).Seconds(); elapsed >= 1 {
+		rate = formatBytes(uint64(float64(download.DownloadedBytes)/elapsed)) + "/s"
+	}
+
+	if fraction := download.Fraction(); fraction >= 0 {
+		p.draw(download.Status, fraction, fmt.Sprintf("%s / %s  %s", received, formatBytes(*download.TotalBytes), rate))
+		return
+	}
+	p.draw(download.Status, -1, received+"  "+rate)
+}
+
+// draw replaces the progress line, with a bar filled to fraction unless it
+// is negative
+func (p *progressBar) draw(status string, fraction float64, detail string) {
+	line := fmt.Sprintf("%-11s %s", status, detail)
+	if fraction >= 0 {
+		filled := int(fraction * progressBarWidth)
+		if filled > progressBarWidth {
+			filled = progressBarWidth
+		}
+		bar := strings.Repeat("=", filled) + strings.Repeat(" ", progressBarWidth-filled)
+		line = fmt.Sprintf("%-11s [%s] %3.0f%%  %s", status, bar, fraction*100, detail)
+	}
+	// \033[K clears what a longer previous line left
+	fmt.Fprintf(p.w, "\r%s\033[K", line)
+	p.drawn = true
+}
+
+// finish ends the progress line
+func (p *progressBar) finish() {
+	if p.drawn {
+		fmt.Fprintln(p.w)
+	}
+}
+
+// formatBytes renders n in binary units, as 1.5 GiB
+func formatBytes(n uint64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := uint64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
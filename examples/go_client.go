@@ -1,28 +1,37 @@
-package main
-
 /*
-Inferno Go Client Example
+Package inferno is a Go client for the Inferno API: inference, streaming,
+WebSocket communication, and more.
+
+The client is split across the go_client*.go files in this directory, one
+file per API area. cmd/inferno is the inferno command line tool built on it
+and cmd/example a tour of the API. To use them:
 
-This example demonstrates how to use the Inferno API with Go.
-Includes basic inference, streaming, WebSocket communication, and more.
+	go mod init inferno-example
+	go get github.com/gorilla/websocket
+	go get github.com/vmihailenco/msgpack/v5 google.golang.org/protobuf
+	go run ./cmd/example
 
-To run this example:
-go mod init inferno-example
-go get github.com/gorilla/websocket
-go run go_client.go
+The infernolangchain adapter also needs github.com/tmc/langchaingo, the
+infernoopenai shim github.com/sashabaranov/go-openai and the infernogenkit
+plugin github.com/firebase/genkit/go v0.5.1.
 */
+package inferno
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
 	"net/url"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/gorilla/websocket"
+
+	"inferno-example/infernotools"
 )
 
 // Client represents the Inferno API client
@@ -30,40 +39,240 @@ type Client struct {
 	BaseURL    string
 	APIKey     string
 	HTTPClient *http.Client
+	// StrictResponses makes decoding fail with a *SchemaDriftError when a
+	// response has fields the client's types do not know or lacks ones they
+	// require, so server changes surface in staging instead of silently
+	// dropping data. Off by default, since newer servers may add fields.
+	StrictResponses bool
+	// Endpoints are further servers, equivalent to BaseURL, that requests
+	// move to while BaseURL reports it is draining, as during a rolling
+	// restart, or for a while after a request to it failed in transit
+	Endpoints []string
+	// Regions maps endpoints (BaseURL and Endpoints) to the region they
+	// are in, such as "eu", for WithRegion and StartGeoFailover
+	Regions map[string]string
+	// LatencyRouting sends each request to the fastest healthy endpoint,
+	// as measured from the client's GET requests and StartLatencyProbes,
+	// instead of the first one that is not draining
+	LatencyRouting bool
+	// Tenant is sent as X-Inferno-Tenant. The server takes the tenant from
+	// the API key or JWT and refuses a header naming another one, so this
+	// only needs setting to act for a tenant with the admin token
+	Tenant string
+	// Codec encodes request bodies and decodes responses; nil means JSON.
+	// WithCodec overrides it for one call.
+	Codec Codec
+	// Transport, when set, carries requests in place of
+	// HTTPClient.Transport, as for an auth gateway or a request signing
+	// RoundTripper. OnRequest and OnResponse add lighter hooks.
+	Transport http.RoundTripper
+	// RetryBudget caps the retries the client makes, such as resending a
+	// request a draining server refused, at a share of its requests; nil
+	// allows every retry. NewClient sets DefaultRetryBudget.
+	RetryBudget *RetryBudget
+	// MinAttemptTime is the least time that must be left before a
+	// request's context deadline for it to be retried; zero means 100ms.
+	// Each attempt's timeout is also shrunk to the time left.
+	MinAttemptTime time.Duration
+	// TokenSource, when set, supplies short-lived JWTs sent in place of
+	// APIKey. Tokens are refreshed shortly before they expire, and a
+	// request refused for an expired token is retried once with a new one.
+	TokenSource TokenSource
+	// ContentCache, when set, keeps embeddings and tokenizations by model
+	// and content hash, so repeated texts are sent to the server only once
+	ContentCache ContentCache
+	// Deduplicate collapses identical non-streaming requests made at the
+	// same time, as by parallel workers or retry loops, into one server
+	// call whose response each caller receives
+	Deduplicate bool
+	// Limiter, when set, caps the requests in flight at a limit adapted to
+	// the server's latency and overload responses; see ConcurrencyLimiter
+	Limiter *ConcurrencyLimiter
+	// Moderator, when set, is given to each ChatStream to redact or abort
+	// its output as it arrives
+	Moderator Moderator
+
+	tokenMu sync.Mutex
+	token   *Token
+
+	hooksMu       sync.Mutex
+	requestHooks  []RequestHook
+	responseHooks []ResponseHook
+
+	drainingMu    sync.Mutex
+	drainingUntil map[string]time.Time
+
+	latency latencyTracker
+
+	geoMu sync.Mutex
+	geo   *geoFailover
+
+	versionMu sync.Mutex
+	version   *ServerVersionInfo
+
+	capabilitiesMu      sync.Mutex
+	capabilities        *Capabilities
+	capabilitiesFetched bool
+
+	conditionalMu sync.Mutex
+	conditional   map[string]conditionalEntry
+
+	inflightMu sync.Mutex
+	inflight   map[string]*inflightCall
+
+	subscribersMu sync.RWMutex
+	subscribers   map[*clientSubscriber]struct{}
 }
 
 // NewClient creates a new Inferno client
 func NewClient(baseURL, apiKey string) *Client {
 	return &Client{
-		BaseURL:    strings.TrimSuffix(baseURL, "/"),
-		APIKey:     apiKey,
-		HTTPClient: &http.Client{Timeout: 30 * time.Second},
+		BaseURL:     strings.TrimSuffix(baseURL, "/"),
+		APIKey:      apiKey,
+		HTTPClient:  &http.Client{Timeout: 30 * time.Second},
+		RetryBudget: DefaultRetryBudget(),
 	}
 }
 
 // Request makes an HTTP request to the Inferno server
 func (c *Client) Request(method, endpoint string, body interface{}) (*http.Response, error) {
+	return c.RequestContext(context.Background(), method, endpoint, body)
+}
+
+// RequestContext makes an HTTP request that is aborted when ctx is done
+func (c *Client) RequestContext(ctx context.Context, method, endpoint string, body interface{}) (*http.Response, error) {
+	req, err := c.newRequest(ctx, method, endpoint, body)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.send(c.HTTPClient, req)
+}
+
+// StreamContext is like RequestContext but ignores HTTPClient.Timeout, for
+// streamed responses that legitimately outlast it; ctx bounds it instead
+func (c *Client) StreamContext(ctx context.Context, method, endpoint string, body interface{}) (*http.Response, error) {
+	return c.longRunningRequest(ctx, method, endpoint, body)
+}
+
+// longRunningRequest is like RequestContext but ignores HTTPClient.Timeout,
+// for streams and jobs that legitimately outlast it; ctx bounds it instead
+func (c *Client) longRunningRequest(ctx context.Context, method, endpoint string, body interface{}) (*http.Response, error) {
+	req, err := c.newRequest(ctx, method, endpoint, body)
+	if err != nil {
+		return nil, err
+	}
+
+	httpClient := *c.HTTPClient
+	httpClient.Timeout = 0
+	return c.send(&httpClient, req)
+}
+
+// newRequest builds a request encoded with the call's codec and carrying
+// the client's credentials
+func (c *Client) newRequest(ctx context.Context, method, endpoint string, body interface{}) (*http.Request, error) {
 	var reqBody io.Reader
 
+	codec := c.codecFor(ctx)
 	if body != nil {
-		jsonData, err := json.Marshal(body)
+		data, err := codec.Marshal(body)
 		if err != nil {
 			return nil, err
 		}
-		reqBody = bytes.NewBuffer(jsonData)
+		reqBody = bytes.NewBuffer(data)
 	}
 
-	req, err := http.NewRequest(method, c.BaseURL+endpoint, reqBody)
+	if c.StrictResponses {
+		ctx = context.WithValue(ctx, strictResponsesKey{}, true)
+	}
+	ctx = context.WithValue(ctx, codecKey{}, codec)
+	if err := c.checkRegion(ctx); err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL(ctx)+endpoint, reqBody)
 	if err != nil {
 		return nil, err
 	}
 
-	req.Header.Set("Content-Type", "application/json")
-	if c.APIKey != "" {
+	req.Header.Set("Content-Type", codec.ContentType())
+	if codec.ContentType() != "application/json" {
+		// JSON stays acceptable, since streams and errors are always JSON
+		req.Header.Set("Accept", codec.ContentType()+", application/json;q=0.5")
+	}
+	req.Header.Set("Accept-Version", strings.Join(clientAPIVersions, ", "))
+	if c.TokenSource != nil {
+		token, err := c.bearerToken(ctx)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	} else if c.APIKey != "" {
 		req.Header.Set("Authorization", "Bearer "+c.APIKey)
 	}
+	if c.Tenant != "" {
+		req.Header.Set(TenantHeader, c.Tenant)
+	}
+	setTraceParent(ctx, req)
+
+	return req, nil
+}
+
+// APIError is returned when the server answers with a non-2xx status
+type APIError struct {
+	StatusCode int
+	Body       string
+}
 
-	return c.HTTPClient.Do(req)
+func (e *APIError) Error() string {
+	return fmt.Sprintf("inferno: server returned %d: %s", e.StatusCode, e.Body)
+}
+
+// decodeResponse closes the response body, turning error statuses into an
+// *APIError (or a *TenantThrottledError wrapping one) and decoding
+// successful bodies into out (if non-nil) with the call's codec
+func decodeResponse(resp *http.Response, out interface{}) error {
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		body, _ := io.ReadAll(resp.Body)
+		apiErr := &APIError{StatusCode: resp.StatusCode, Body: strings.TrimSpace(string(body))}
+		if throttled := tenantThrottled(resp, apiErr); throttled != nil {
+			return throttled
+		}
+		if exceeded := budgetExceeded(resp, apiErr); exceeded != nil {
+			return exceeded
+		}
+		return apiErr
+	}
+
+	if out == nil {
+		return nil
+	}
+	if codec := responseCodec(resp); codec.ContentType() != "application/json" {
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return err
+		}
+		return codec.Unmarshal(body, out)
+	}
+	if isStrict(resp) {
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return err
+		}
+		return decodeStrict(resp, body, out)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// CheckResponse returns the error decodeResponse would for an error status,
+// closing the body, and nil otherwise, for callers that read the body
+// themselves
+func CheckResponse(resp *http.Response) error {
+	if resp.StatusCode < 400 {
+		return nil
+	}
+	return decodeResponse(resp, nil)
 }
 
 // Health check structures
@@ -76,19 +285,33 @@ type HealthResponse struct {
 
 // Model structures
 type ModelInfo struct {
-	ID           string   `json:"id"`
-	Name         string   `json:"name"`
-	Type         string   `json:"type"`
-	SizeBytes    int64    `json:"size_bytes"`
-	Loaded       bool     `json:"loaded"`
-	ContextSize  *int     `json:"context_size,omitempty"`
-	Capabilities []string `json:"capabilities"`
+	ID           string    `json:"id"`
+	Name         string    `json:"name"`
+	Type         ModelType `json:"type"`
+	SizeBytes    int64     `json:"size_bytes"`
+	Loaded       bool      `json:"loaded"`
+	ContextSize  *int      `json:"context_size,omitempty"`
+	Capabilities []string  `json:"capabilities"`
+	// Permission says what the client's key may do with the model; set
+	// only for managed API keys
+	Permission []ModelPermission `json:"permission,omitempty"`
 }
 
 type ModelsResponse struct {
 	Models []ModelInfo `json:"models"`
 }
 
+// OpenAIModel is a model as /v1/models describes it
+type OpenAIModel struct {
+	ID      string `json:"id"`
+	Object  string `json:"object"`
+	Created int64  `json:"created"`
+	OwnedBy string `json:"owned_by"`
+	// Permission says what the client's key may do with the model; set
+	// only for managed API keys, which see only the models they may use
+	Permission []ModelPermission `json:"permission,omitempty"`
+}
+
 type LoadModelRequest struct {
 	GPULayers   *int `json:"gpu_layers,omitempty"`
 	ContextSize *int `json:"context_size,omitempty"`
@@ -96,10 +319,10 @@ type LoadModelRequest struct {
 }
 
 type LoadModelResponse struct {
-	Status            string `json:"status"`
-	ModelID           string `json:"model_id"`
-	MemoryUsageBytes  *int64 `json:"memory_usage_bytes,omitempty"`
-	LoadTimeMs        *int64 `json:"load_time_ms,omitempty"`
+	Status           string `json:"status"`
+	ModelID          string `json:"model_id"`
+	MemoryUsageBytes *int64 `json:"memory_usage_bytes,omitempty"`
+	LoadTimeMs       *int64 `json:"load_time_ms,omitempty"`
 }
 
 // Inference structures
@@ -112,12 +335,71 @@ type InferenceRequest struct {
 	TopK        int      `json:"top_k"`
 	Stop        []string `json:"stop,omitempty"`
 	Stream      bool     `json:"stream"`
+	// StreamOptions asks for a final usage chunk when streaming
+	StreamOptions *StreamOptions `json:"stream_options,omitempty"`
+	// Logprobs (0-5) asks for per-token log-probabilities; needs a backend
+	// that supports scoring and a non-streaming request
+	Logprobs *int `json:"logprobs,omitempty"`
+	// Echo prepends the prompt to the returned text
+	Echo bool `json:"echo,omitempty"`
+	// TimeoutMs and Deadline are enforced by the server, which returns the
+	// partial output with finish_reason "timeout" once either passes
+	TimeoutMs *int64     `json:"timeout_ms,omitempty"`
+	Deadline  *time.Time `json:"deadline,omitempty"`
+	// Seed fixes sampling so the same request reproduces the same output
+	Seed *uint64 `json:"seed,omitempty"`
+	// PriorityClass names the scheduler class the request waits in, such as
+	// "interactive" or "background"; empty uses the server's default
+	PriorityClass string `json:"priority_class,omitempty"`
+	// Score asks the server to score this continuation of Prompt instead of
+	// generating; see ScoreCompletion
+	Score *string `json:"score,omitempty"`
+	// InputIDs are generated from in place of Prompt, skipping the server's
+	// tokenizer; leave Prompt empty when setting them
+	InputIDs []uint32 `json:"input_ids,omitempty"`
+	// ReturnTokenIDs adds the prompt and completion token IDs to the choice
+	// (not with Stream)
+	ReturnTokenIDs bool `json:"return_token_ids,omitempty"`
+	// CacheBypass skips the response cache for this request; see
+	// CacheStatus
+	CacheBypass bool `json:"cache_bypass,omitempty"`
+	// Encryption carries the wrapped data key of a prompt sealed with
+	// EnvelopeKey.SealInference
+	Encryption *Envelope `json:"encryption,omitempty"`
+	// Metadata tags the request's usage, audit events and trace span with
+	// up to 16 key/value pairs, such as a team or customer ID; see Usage
+	Metadata map[string]string `json:"metadata,omitempty"`
+	SamplingExtensions
+}
+
+// SamplingExtensions are the vLLM sampling fields the OpenAI-compatible
+// endpoints accept; embedded in InferenceRequest and ChatCompletionRequest
+type SamplingExtensions struct {
+	// BestOf samples this many candidates (up to 20, not with Stream) and
+	// returns the most likely; needs a backend that supports scoring
+	BestOf *int `json:"best_of,omitempty"`
+	// UseBeamSearch is rejected by the server; use BestOf instead
+	UseBeamSearch bool `json:"use_beam_search,omitempty"`
+	// LengthPenalty is the length exponent used to rank BestOf candidates
+	LengthPenalty *float32 `json:"length_penalty,omitempty"`
+	// StopTokenIDs end generation like the model's EOS token
+	StopTokenIDs []int `json:"stop_token_ids,omitempty"`
+	// IgnoreEOS keeps generating past EOS until MaxTokens
+	IgnoreEOS bool `json:"ignore_eos,omitempty"`
+	// SkipSpecialTokens strips special tokens from the output (server
+	// default when nil)
+	SkipSpecialTokens *bool `json:"skip_special_tokens,omitempty"`
 }
 
 type Choice struct {
-	Text         string  `json:"text"`
-	Index        int     `json:"index"`
-	FinishReason *string `json:"finish_reason,omitempty"`
+	Text         string        `json:"text"`
+	Index        int           `json:"index"`
+	FinishReason *FinishReason `json:"finish_reason,omitempty"`
+	// Logprobs is set for scoring requests and when Logprobs is requested
+	Logprobs *Logprobs `json:"logprobs,omitempty"`
+	// PromptTokenIDs and TokenIDs are set when ReturnTokenIDs is requested
+	PromptTokenIDs []uint32 `json:"prompt_token_ids,omitempty"`
+	TokenIDs       []uint32 `json:"token_ids,omitempty"`
 }
 
 type Usage struct {
@@ -127,19 +409,34 @@ type Usage struct {
 }
 
 type InferenceResponse struct {
-	ID               string   `json:"id"`
-	Model            string   `json:"model"`
-	Choices          []Choice `json:"choices"`
-	Usage            *Usage   `json:"usage,omitempty"`
-	Created          int64    `json:"created"`
-	ProcessingTimeMs *int64   `json:"processing_time_ms,omitempty"`
+	ID string `json:"id"`
+	// Model is the model that served the request; when the request named a
+	// routing alias it identifies the chosen arm
+	Model string `json:"model"`
+	// SystemFingerprint identifies the server build, backend and model; a
+	// change means the same Seed may no longer reproduce the same output
+	SystemFingerprint string   `json:"system_fingerprint,omitempty"`
+	Choices           []Choice `json:"choices"`
+	Usage             *Usage   `json:"usage,omitempty"`
+	Created           int64    `json:"created"`
+	ProcessingTimeMs  *int64   `json:"processing_time_ms,omitempty"`
+	// Cache says whether the response cache answered the request
+	Cache *CacheInfo `json:"cache,omitempty"`
+}
+
+// StreamOptions configures streamed responses
+type StreamOptions struct {
+	// IncludeUsage adds a final chunk with no choices carrying the usage
+	IncludeUsage bool `json:"include_usage"`
 }
 
 // Embeddings structures
 type EmbeddingsRequest struct {
-	Model          string   `json:"model"`
-	Input          []string `json:"input"`
-	EncodingFormat string   `json:"encoding_format"`
+	Model          string         `json:"model"`
+	Input          []string       `json:"input"`
+	EncodingFormat EncodingFormat `json:"encoding_format,omitempty"`
+	// Dimensions truncates each embedding and re-normalizes it
+	Dimensions *int `json:"dimensions,omitempty"`
 }
 
 type EmbeddingData struct {
@@ -155,55 +452,108 @@ type EmbeddingsResponse struct {
 
 // Chat completion structures
 type ChatMessage struct {
-	Role    string `json:"role"`
+	Role    Role   `json:"role"`
 	Content string `json:"content"`
+	Name    string `json:"name,omitempty"`
+	// ToolCalls are the calls made by an assistant message
+	ToolCalls []ToolCall `json:"tool_calls,omitempty"`
+	// ToolCallID names the call a "tool" message answers
+	ToolCallID string `json:"tool_call_id,omitempty"`
 }
 
-type ChatCompletionRequest struct {
-	Model       string        `json:"model"`
-	Messages    []ChatMessage `json:"messages"`
-	Temperature *float32      `json:"temperature,omitempty"`
-	MaxTokens   *int          `json:"max_tokens,omitempty"`
+// Tool offers a function the model may call
+type Tool struct {
+	// Type is "function"
+	Type     string             `json:"type"`
+	Function FunctionDefinition `json:"function"`
 }
 
-type ChatChoice struct {
-	Message      ChatMessage `json:"message"`
-	Index        int         `json:"index"`
-	FinishReason *string     `json:"finish_reason,omitempty"`
+type FunctionDefinition struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	// Parameters is the JSON Schema of the arguments object
+	Parameters interface{} `json:"parameters,omitempty"`
 }
 
-type ChatCompletionResponse struct {
-	Choices []ChatChoice `json:"choices"`
-	Usage   *Usage       `json:"usage,omitempty"`
+// ToolCall and FunctionCall are the infernotools types, so messages move
+// between the client and a tool registry without conversion
+type (
+	ToolCall     = infernotools.ToolCall
+	FunctionCall = infernotools.FunctionCall
+)
+
+type ChatCompletionRequest struct {
+	Model         string         `json:"model"`
+	Messages      []ChatMessage  `json:"messages"`
+	Temperature   *float32       `json:"temperature,omitempty"`
+	TopP          *float32       `json:"top_p,omitempty"`
+	MaxTokens     *int           `json:"max_tokens,omitempty"`
+	Stop          []string       `json:"stop,omitempty"`
+	Stream        bool           `json:"stream,omitempty"`
+	StreamOptions *StreamOptions `json:"stream_options,omitempty"`
+	// Logprobs asks for each generated token's log-probability; needs a
+	// backend that supports scoring and a non-streaming request
+	Logprobs    bool   `json:"logprobs,omitempty"`
+	TopLogprobs *int   `json:"top_logprobs,omitempty"`
+	Tools       []Tool `json:"tools,omitempty"`
+	// ToolChoice is "none", "auto", "required" or
+	// {"type": "function", "function": {"name": ...}}
+	ToolChoice        interface{} `json:"tool_choice,omitempty"`
+	ParallelToolCalls *bool       `json:"parallel_tool_calls,omitempty"`
+	User              string      `json:"user,omitempty"`
+	TimeoutMs         *int64      `json:"timeout_ms,omitempty"`
+	Deadline          *time.Time  `json:"deadline,omitempty"`
+	Seed              *uint64     `json:"seed,omitempty"`
+	// PriorityClass names the scheduler class; see InferenceRequest
+	PriorityClass string `json:"priority_class,omitempty"`
+	// CacheBypass skips the response cache; see InferenceRequest
+	CacheBypass bool `json:"cache_bypass,omitempty"`
+	// Encryption is set by EnvelopeKey.SealChat; see InferenceRequest
+	Encryption *Envelope `json:"encryption,omitempty"`
+	// Metadata tags the request's usage; see InferenceRequest
+	Metadata map[string]string `json:"metadata,omitempty"`
+	SamplingExtensions
 }
 
-// Batch structures
-type BatchRequestItem struct {
-	ID     string `json:"id"`
-	Prompt string `json:"prompt"`
+type ChatChoice struct {
+	Message  ChatMessage   `json:"message"`
+	Index    int           `json:"index"`
+	Logprobs *ChatLogprobs `json:"logprobs,omitempty"`
+	// FinishReason is "tool_calls" when Message.ToolCalls is set
+	FinishReason *FinishReason `json:"finish_reason,omitempty"`
 }
 
-type BatchRequest struct {
-	Model      string             `json:"model"`
-	Requests   []BatchRequestItem `json:"requests"`
-	MaxTokens  int                `json:"max_tokens"`
-	WebhookURL *string            `json:"webhook_url,omitempty"`
+type ChatLogprobs struct {
+	Content []ChatTokenLogprob `json:"content"`
 }
 
-type BatchResponse struct {
-	BatchID       string `json:"batch_id"`
-	Status        string `json:"status"`
-	TotalRequests int    `json:"total_requests"`
-	Created       int64  `json:"created"`
+type ChatTokenLogprob struct {
+	Token   string  `json:"token"`
+	Logprob float64 `json:"logprob"`
+	Bytes   []int   `json:"bytes"`
+	// TopLogprobs holds at most the sampled token, which is all the
+	// backends score
+	TopLogprobs []TopLogprob `json:"top_logprobs"`
+}
+
+type TopLogprob struct {
+	Token   string  `json:"token"`
+	Logprob float64 `json:"logprob"`
+	Bytes   []int   `json:"bytes"`
 }
 
-type BatchStatusResponse struct {
-	BatchID    string  `json:"batch_id"`
-	Status     string  `json:"status"`
-	Completed  int     `json:"completed"`
-	Failed     int     `json:"failed"`
-	Total      int     `json:"total"`
-	ResultsURL *string `json:"results_url,omitempty"`
+type ChatCompletionResponse struct {
+	ID      string `json:"id"`
+	Object  string `json:"object"`
+	Created int64  `json:"created"`
+	// Model is the model that served the request; when the request named a
+	// routing alias it identifies the chosen arm
+	Model             string       `json:"model"`
+	SystemFingerprint string       `json:"system_fingerprint,omitempty"`
+	Choices           []ChatChoice `json:"choices"`
+	Usage             *Usage       `json:"usage,omitempty"`
+	// Cache says whether the response cache answered the request
+	Cache *CacheInfo `json:"cache,omitempty"`
 }
 
 // Client methods
@@ -224,53 +574,80 @@ func (c *Client) HealthCheck() (*HealthResponse, error) {
 	return &health, nil
 }
 
-// ListModels lists all available models
+// ListModels lists the models the server offers, filling in only ID, Name
+// and Permission
+//
+// Deprecated: the server lists models only at /v1/models; use OpenAIModels.
 func (c *Client) ListModels() ([]ModelInfo, error) {
-	resp, err := c.Request("GET", "/models", nil)
+	listed, err := c.OpenAIModels()
 	if err != nil {
 		return nil, err
 	}
-	defer resp.Body.Close()
 
-	var models ModelsResponse
-	if err := json.NewDecoder(resp.Body).Decode(&models); err != nil {
+	models := make([]ModelInfo, len(listed))
+	for i, model := range listed {
+		models[i] = ModelInfo{ID: model.ID, Name: model.ID, Permission: model.Permission}
+	}
+	return models, nil
+}
+
+// OpenAIModels lists the models served through the OpenAI-compatible API
+func (c *Client) OpenAIModels() ([]OpenAIModel, error) {
+	var list struct {
+		Data []OpenAIModel `json:"data"`
+	}
+	if err := c.getConditional(context.Background(), "/v1/models", &list); err != nil {
 		return nil, err
 	}
 
-	return models.Models, nil
+	return list.Data, nil
 }
 
-// LoadModel loads a model into memory
+// RetrieveModel returns one model from /v1/models
+func (c *Client) RetrieveModel(modelID string) (*OpenAIModel, error) {
+	var model OpenAIModel
+	if err := c.getConditional(context.Background(), "/v1/models/"+url.PathEscape(modelID), &model); err != nil {
+		return nil, err
+	}
+
+	return &model, nil
+}
+
+// LoadModel loads a model in the background and keeps it loaded; Status is
+// "loading" until a ModelLoaded or ModelFailed event says how it went, or
+// "loaded" if it already was. options may be nil. Requires the admin token.
 func (c *Client) LoadModel(modelID string, options *LoadModelRequest) (*LoadModelResponse, error) {
-	endpoint := fmt.Sprintf("/models/%s/load", modelID)
-	resp, err := c.Request("POST", endpoint, options)
+	resp, err := c.Request("POST", "/v1/models/"+url.PathEscape(modelID)+"/load", options)
 	if err != nil {
 		return nil, err
 	}
-	defer resp.Body.Close()
 
 	var result LoadModelResponse
-	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+	if err := decodeResponse(resp, &result); err != nil {
 		return nil, err
 	}
 
 	return &result, nil
 }
 
-// UnloadModel unloads a model from memory
+// UnloadModel frees a loaded model. Requires the admin token.
 func (c *Client) UnloadModel(modelID string) error {
-	endpoint := fmt.Sprintf("/models/%s/unload", modelID)
-	resp, err := c.Request("POST", endpoint, nil)
+	resp, err := c.Request("POST", "/v1/models/"+url.PathEscape(modelID)+"/unload", nil)
 	if err != nil {
 		return err
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode >= 400 {
-		return fmt.Errorf("failed to unload model: %s", resp.Status)
-	}
+	return decodeResponse(resp, nil)
+}
 
-	return nil
+// DeleteModel removes a model's file from the server's models directory. The
+// model the server has loaded cannot be deleted. Requires the admin token.
+func (c *Client) DeleteModel(ctx context.Context, modelID string) error {
+	resp, err := c.RequestContext(ctx, "DELETE", "/v1/models/"+url.PathEscape(modelID), nil)
+	if err != nil {
+		return err
+	}
+	return decodeResponse(resp, nil)
 }
 
 // Inference runs synchronous inference
@@ -285,16 +662,10 @@ func (c *Client) Inference(model, prompt string, maxTokens int, temperature floa
 		Stream:      false,
 	}
 
-	resp, err := c.Request("POST", "/inference", request)
+	result, err := c.InferenceContext(context.Background(), request)
 	if err != nil {
 		return "", err
 	}
-	defer resp.Body.Close()
-
-	var result InferenceResponse
-	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-		return "", err
-	}
 
 	if len(result.Choices) == 0 {
 		return "", fmt.Errorf("no response received")
@@ -305,22 +676,20 @@ func (c *Client) Inference(model, prompt string, maxTokens int, temperature floa
 
 // Embeddings generates embeddings for text inputs
 func (c *Client) Embeddings(model string, texts []string) ([][]float32, error) {
+	if err := c.requireCapabilities(context.Background(), nil, model, CapabilityEmbeddings); err != nil {
+		return nil, err
+	}
+
 	request := EmbeddingsRequest{
 		Model:          model,
 		Input:          texts,
-		EncodingFormat: "float",
+		EncodingFormat: EncodingFloat,
 	}
 
-	resp, err := c.Request("POST", "/embeddings", request)
+	result, err := c.EmbeddingsContext(context.Background(), request)
 	if err != nil {
 		return nil, err
 	}
-	defer resp.Body.Close()
-
-	var result EmbeddingsResponse
-	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-		return nil, err
-	}
 
 	embeddings := make([][]float32, len(result.Data))
 	for i, data := range result.Data {
@@ -330,6 +699,28 @@ func (c *Client) Embeddings(model string, texts []string) ([][]float32, error) {
 	return embeddings, nil
 }
 
+// EmbeddingsContext creates embeddings, returning the full response. With
+// a ContentCache, only texts it does not hold are sent.
+func (c *Client) EmbeddingsContext(ctx context.Context, request EmbeddingsRequest) (*EmbeddingsResponse, error) {
+	if c.ContentCache != nil && request.EncodingFormat != EncodingBase64 {
+		return c.cachedEmbeddings(ctx, request)
+	}
+	return c.fetchEmbeddings(ctx, request)
+}
+
+func (c *Client) fetchEmbeddings(ctx context.Context, request EmbeddingsRequest) (*EmbeddingsResponse, error) {
+	resp, err := c.RequestContext(ctx, "POST", "/v1/embeddings", request)
+	if err != nil {
+		return nil, err
+	}
+
+	var result EmbeddingsResponse
+	if err := decodeResponse(resp, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
 // ChatCompletion performs OpenAI-compatible chat completion
 func (c *Client) ChatCompletion(model string, messages []ChatMessage) (string, error) {
 	temperature := float32(0.7)
@@ -360,50 +751,18 @@ func (c *Client) ChatCompletion(model string, messages []ChatMessage) (string, e
 	return result.Choices[0].Message.Content, nil
 }
 
-// BatchInference submits a batch of prompts for processing
-func (c *Client) BatchInference(model string, prompts []string) (string, error) {
-	requests := make([]BatchRequestItem, len(prompts))
-	for i, prompt := range prompts {
-		requests[i] = BatchRequestItem{
-			ID:     fmt.Sprintf("req_%d", i),
-			Prompt: prompt,
-		}
-	}
-
-	request := BatchRequest{
-		Model:     model,
-		Requests:  requests,
-		MaxTokens: 100,
-	}
-
-	resp, err := c.Request("POST", "/batch", request)
-	if err != nil {
-		return "", err
-	}
-	defer resp.Body.Close()
-
-	var result BatchResponse
-	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-		return "", err
-	}
-
-	return result.BatchID, nil
-}
-
-// GetBatchStatus gets the status of a batch job
-func (c *Client) GetBatchStatus(batchID string) (*BatchStatusResponse, error) {
-	endpoint := fmt.Sprintf("/batch/%s", batchID)
-	resp, err := c.Request("GET", endpoint, nil)
+// ChatCompletionContext runs a chat completion tied to ctx, returning the
+// full response
+func (c *Client) ChatCompletionContext(ctx context.Context, request ChatCompletionRequest) (*ChatCompletionResponse, error) {
+	resp, err := c.RequestContext(ctx, "POST", "/v1/chat/completions", request)
 	if err != nil {
 		return nil, err
 	}
-	defer resp.Body.Close()
 
-	var result BatchStatusResponse
-	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+	var result ChatCompletionResponse
+	if err := decodeResponse(resp, &result); err != nil {
 		return nil, err
 	}
-
 	return &result, nil
 }
 
@@ -412,6 +771,11 @@ type WebSocketClient struct {
 	URL    string
 	APIKey string
 	conn   *websocket.Conn
+	// OnModelEvent, if set, receives the model lifecycle events the server
+	// pushes while Listen runs
+	OnModelEvent func(ModelEvent)
+	// draining is set once the server sends goaway
+	draining bool
 }
 
 // NewWebSocketClient creates a new WebSocket client
@@ -457,6 +821,9 @@ func (ws *WebSocketClient) SendInference(model, prompt string, maxTokens int) er
 	if ws.conn == nil {
 		return fmt.Errorf("WebSocket not connected")
 	}
+	if ws.draining {
+		return ErrServerDraining
+	}
 
 	request := map[string]interface{}{
 		"type":       "inference",
@@ -488,8 +855,27 @@ func (ws *WebSocketClient) Listen() error {
 			if token, ok := message["token"].(string); ok {
 				fmt.Print(token)
 			}
+		case "goaway":
+			// Let the current stream finish; new requests go elsewhere
+			ws.draining = true
+		case "model_event":
+			if ws.OnModelEvent == nil {
+				continue
+			}
+			raw, err := json.Marshal(message["event"])
+			if err != nil {
+				return err
+			}
+			var event ModelEvent
+			if err := json.Unmarshal(raw, &event); err != nil {
+				return err
+			}
+			ws.OnModelEvent(event)
 		case "complete":
 			fmt.Println("\n[Inference complete]")
+			if ws.draining {
+				return ws.Close()
+			}
 			return nil
 		case "error":
 			if errorMsg, ok := message["message"].(string); ok {
@@ -500,6 +886,12 @@ func (ws *WebSocketClient) Listen() error {
 	}
 }
 
+// Draining reports whether the server has asked this client to reconnect
+// elsewhere
+func (ws *WebSocketClient) Draining() bool {
+	return ws.draining
+}
+
 // Close closes the WebSocket connection
 func (ws *WebSocketClient) Close() error {
 	if ws.conn != nil {
@@ -507,127 +899,3 @@ func (ws *WebSocketClient) Close() error {
 	}
 	return nil
 }
-
-func main() {
-	fmt.Println("=== Inferno Go Client Example ===\n")
-
-	// Initialize client
-	client := NewClient("http://localhost:8080", "your_api_key_here")
-
-	// 1. Health check
-	fmt.Println("1. Health Check")
-	if health, err := client.HealthCheck(); err != nil {
-		fmt.Printf("   Error: %v\n", err)
-	} else {
-		fmt.Printf("   Status: %s\n", health.Status)
-		fmt.Printf("   Version: %s\n\n", health.Version)
-	}
-
-	// 2. List models
-	fmt.Println("2. Available Models")
-	if models, err := client.ListModels(); err != nil {
-		fmt.Printf("   Error: %v\n", err)
-	} else {
-		for _, model := range models {
-			fmt.Printf("   - %s: %s (%s)\n", model.ID, model.Name, model.Type)
-		}
-		fmt.Println()
-	}
-
-	// 3. Load a model
-	fmt.Println("3. Loading Model")
-	modelID := "llama-2-7b"
-	// Uncomment to actually load:
-	// if result, err := client.LoadModel(modelID, nil); err != nil {
-	//     fmt.Printf("   Error: %v\n", err)
-	// } else {
-	//     fmt.Printf("   Model loaded: %s\n\n", result.Status)
-	// }
-
-	// 4. Simple inference
-	fmt.Println("4. Simple Inference")
-	prompt := "What is artificial intelligence?"
-	fmt.Printf("   Prompt: %s\n", prompt)
-	// Uncomment to run inference:
-	// if response, err := client.Inference(modelID, prompt, 50, 0.7); err != nil {
-	//     fmt.Printf("   Error: %v\n", err)
-	// } else {
-	//     fmt.Printf("   Response: %s\n\n", response)
-	// }
-
-	// 5. Generate embeddings
-	fmt.Println("5. Text Embeddings")
-	texts := []string{"Hello world", "How are you?", "Machine learning is fascinating"}
-	fmt.Printf("   Texts: %v\n", texts)
-	// Uncomment to generate embeddings:
-	// if embeddings, err := client.Embeddings(modelID, texts); err != nil {
-	//     fmt.Printf("   Error: %v\n", err)
-	// } else {
-	//     fmt.Printf("   Generated %d embeddings\n", len(embeddings))
-	//     if len(embeddings) > 0 {
-	//         fmt.Printf("   Embedding dimension: %d\n\n", len(embeddings[0]))
-	//     }
-	// }
-
-	// 6. Chat completion (OpenAI compatible)
-	fmt.Println("6. Chat Completion")
-	messages := []ChatMessage{
-		{Role: "system", Content: "You are a helpful assistant."},
-		{Role: "user", Content: "What is the capital of France?"},
-	}
-	fmt.Printf("   Messages: %d\n", len(messages))
-	// Uncomment to run chat:
-	// if response, err := client.ChatCompletion(modelID, messages); err != nil {
-	//     fmt.Printf("   Error: %v\n", err)
-	// } else {
-	//     fmt.Printf("   Assistant: %s\n\n", response)
-	// }
-
-	// 7. Batch processing
-	fmt.Println("7. Batch Processing")
-	prompts := []string{
-		"What is Python?",
-		"Explain quantum computing",
-		"How does photosynthesis work?",
-	}
-	fmt.Printf("   Batch size: %d\n", len(prompts))
-	// Uncomment to submit batch:
-	// if batchID, err := client.BatchInference(modelID, prompts); err != nil {
-	//     fmt.Printf("   Error: %v\n", err)
-	// } else {
-	//     fmt.Printf("   Batch ID: %s\n", batchID)
-	//
-	//     // Wait for completion
-	//     for {
-	//         if status, err := client.GetBatchStatus(batchID); err != nil {
-	//             fmt.Printf("   Status check error: %v\n", err)
-	//             break
-	//         } else if status.Status == "completed" {
-	//             fmt.Printf("   Completed: %d responses\n\n", status.Completed)
-	//             break
-	//         }
-	//         time.Sleep(1 * time.Second)
-	//     }
-	// }
-
-	// 8. WebSocket streaming (uncomment to test)
-	fmt.Println("8. WebSocket Streaming")
-	fmt.Println("   Setting up WebSocket client...")
-	// wsClient := NewWebSocketClient("ws://localhost:8080/ws", "your_api_key_here")
-	// if err := wsClient.Connect(); err != nil {
-	//     fmt.Printf("   Connection error: %v\n", err)
-	// } else {
-	//     fmt.Println("   Sending inference request...")
-	//     if err := wsClient.SendInference(modelID, "Tell me a joke", 50); err != nil {
-	//         fmt.Printf("   Send error: %v\n", err)
-	//     } else {
-	//         fmt.Print("   Response: ")
-	//         if err := wsClient.Listen(); err != nil {
-	//             fmt.Printf("   Listen error: %v\n", err)
-	//         }
-	//     }
-	//     wsClient.Close()
-	// }
-
-	fmt.Println("\n=== Example Complete ===")
-}
\ No newline at end of file
@@ -0,0 +1,155 @@
+package inferno
+
+import (
+	"context"
+	"time"
+)
+
+// ServerMode is whether a server is accepting new work
+type ServerMode string
+
+const (
+	ModeServing     ServerMode = "serving"
+	ModeMaintenance ServerMode = "maintenance"
+	ModeDraining    ServerMode = "draining"
+)
+
+// Admin operation structures
+type OperationsStatus struct {
+	Mode ServerMode `json:"mode"`
+	// Since is when the server entered Mode
+	Since  time.Time `json:"since"`
+	Reason *string   `json:"reason"`
+	// ShuttingDown is set once a drain has triggered shutdown
+	ShuttingDown      bool       `json:"shutting_down"`
+	WorkerRestarts    int        `json:"worker_restarts"`
+	LastWorkerRestart *time.Time `json:"last_worker_restart"`
+}
+
+type WorkerStatus struct {
+	WorkerID       int      `json:"worker_id"`
+	ActiveRequests int      `json:"active_requests"`
+	TotalRequests  int64    `json:"total_requests"`
+	LoadedModels   []string `json:"loaded_models"`
+}
+
+type ServerStatus struct {
+	Status OperationsStatus `json:"status"`
+	// InFlight counts queued and running generation requests
+	InFlight    int     `json:"in_flight"`
+	LoadedModel *string `json:"loaded_model"`
+	// Workers is set in distributed mode
+	Workers []WorkerStatus `json:"workers"`
+}
+
+type DrainOptions struct {
+	// Timeout bounds the wait for in-flight requests; the server defaults
+	// to 30s and allows up to an hour. Whole seconds are sent.
+	Timeout time.Duration
+	// Shutdown stops the server once it has drained
+	Shutdown bool
+	Reason   string
+}
+
+type DrainResult struct {
+	// Drained is false if requests were still in flight at the timeout
+	Drained      bool             `json:"drained"`
+	InFlight     int              `json:"in_flight"`
+	WaitedMs     int64            `json:"waited_ms"`
+	ShuttingDown bool             `json:"shutting_down"`
+	Status       OperationsStatus `json:"status"`
+}
+
+type WorkerRestartResult struct {
+	Restarted int              `json:"restarted"`
+	Status    OperationsStatus `json:"status"`
+}
+
+// AdminClient is a Client holding the admin token, with the operations
+// deployment tooling runs during a rollout: take the server out of service,
+// drain it, restart its workers and put it back.
+type AdminClient struct {
+	*Client
+}
+
+// NewAdminClient creates a client authenticated with the admin token
+func NewAdminClient(baseURL, adminToken string) *AdminClient {
+	return &AdminClient{Client: NewClient(baseURL, adminToken)}
+}
+
+// Status returns the server's mode, in-flight requests and workers
+func (a *AdminClient) Status(ctx context.Context) (*ServerStatus, error) {
+	var status ServerStatus
+	if err := a.adminRequest(ctx, "GET", "/admin/status", nil, &status); err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
+
+// EnterMaintenance makes the server refuse new work with 503 and report
+// itself unhealthy; requests already running finish
+func (a *AdminClient) EnterMaintenance(ctx context.Context, reason string) (*ServerStatus, error) {
+	return a.setMaintenance(ctx, true, reason)
+}
+
+// ExitMaintenance puts the server back in service, also ending a drain that
+// did not shut it down
+func (a *AdminClient) ExitMaintenance(ctx context.Context) (*ServerStatus, error) {
+	return a.setMaintenance(ctx, false, "")
+}
+
+func (a *AdminClient) setMaintenance(ctx context.Context, enabled bool, reason string) (*ServerStatus, error) {
+	body := map[string]interface{}{"enabled": enabled}
+	if reason != "" {
+		body["reason"] = reason
+	}
+
+	var status ServerStatus
+	if err := a.adminRequest(ctx, "POST", "/admin/maintenance", body, &status); err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
+
+// Drain makes the server refuse new work and waits for in-flight requests
+// to finish, shutting it down afterwards if options.Shutdown is set. The
+// call lasts as long as the drain, so ctx rather than HTTPClient.Timeout
+// bounds it.
+func (a *AdminClient) Drain(ctx context.Context, options DrainOptions) (*DrainResult, error) {
+	body := map[string]interface{}{"shutdown": options.Shutdown}
+	if options.Timeout > 0 {
+		body["timeout_seconds"] = int64(options.Timeout / time.Second)
+	}
+	if options.Reason != "" {
+		body["reason"] = options.Reason
+	}
+
+	resp, err := a.longRunningRequest(ctx, "POST", "/admin/drain", body)
+	if err != nil {
+		return nil, err
+	}
+
+	var result DrainResult
+	if err := decodeResponse(resp, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// RestartWorkers reloads the server's backends. The server must be in
+// maintenance or draining; otherwise the call fails with a 409 *APIError.
+func (a *AdminClient) RestartWorkers(ctx context.Context) (*WorkerRestartResult, error) {
+	var result WorkerRestartResult
+	if err := a.adminRequest(ctx, "POST", "/admin/workers/restart", nil, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+func (a *AdminClient) adminRequest(ctx context.Context, method, endpoint string, body, out interface{}) error {
+	resp, err := a.RequestContext(ctx, method, endpoint, body)
+	if err != nil {
+		return err
+	}
+	return decodeResponse(resp, out)
+}
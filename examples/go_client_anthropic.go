@@ -0,0 +1,105 @@
+package inferno
+
+import "strings"
+
+// Anthropic Messages structures
+type AnthropicContentBlock struct {
+	// Type is "text"; other block types are rejected by the server
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+type AnthropicMessage struct {
+	// Role is "user" or "assistant"
+	Role    string                  `json:"role"`
+	Content []AnthropicContentBlock `json:"content"`
+}
+
+type AnthropicMetadata struct {
+	UserID string `json:"user_id,omitempty"`
+}
+
+type AnthropicMessagesRequest struct {
+	Model         string             `json:"model"`
+	Messages      []AnthropicMessage `json:"messages"`
+	MaxTokens     int                `json:"max_tokens"`
+	System        string             `json:"system,omitempty"`
+	StopSequences []string           `json:"stop_sequences,omitempty"`
+	Temperature   *float32           `json:"temperature,omitempty"`
+	TopP          *float32           `json:"top_p,omitempty"`
+	TopK          *int               `json:"top_k,omitempty"`
+	Metadata      *AnthropicMetadata `json:"metadata,omitempty"`
+	TimeoutMs     *int64             `json:"timeout_ms,omitempty"`
+}
+
+type AnthropicUsage struct {
+	InputTokens  int `json:"input_tokens"`
+	OutputTokens int `json:"output_tokens"`
+}
+
+type AnthropicMessagesResponse struct {
+	ID      string                  `json:"id"`
+	Type    string                  `json:"type"`
+	Role    string                  `json:"role"`
+	Model   string                  `json:"model"`
+	Content []AnthropicContentBlock `json:"content"`
+	// StopReason is "end_turn", "max_tokens", "cancelled" or "timeout"
+	StopReason   string         `json:"stop_reason"`
+	StopSequence *string        `json:"stop_sequence"`
+	Usage        AnthropicUsage `json:"usage"`
+}
+
+// Text joins the response's text content blocks
+func (r *AnthropicMessagesResponse) Text() string {
+	var text strings.Builder
+	for _, block := range r.Content {
+		if block.Type == "text" {
+			text.WriteString(block.Text)
+		}
+	}
+	return text.String()
+}
+
+// AnthropicText wraps text as a single-block message content
+func AnthropicText(text string) []AnthropicContentBlock {
+	return []AnthropicContentBlock{{Type: "text", Text: text}}
+}
+
+// CreateMessage sends an Anthropic-style Messages request. Streaming is not
+// supported here; use an Anthropic SDK pointed at the server for that.
+func (c *Client) CreateMessage(req AnthropicMessagesRequest) (*AnthropicMessagesResponse, error) {
+	resp, err := c.Request("POST", "/v1/messages", req)
+	if err != nil {
+		return nil, err
+	}
+
+	var message AnthropicMessagesResponse
+	if err := decodeResponse(resp, &message); err != nil {
+		return nil, err
+	}
+
+	return &message, nil
+}
+
+// CountMessageTokens estimates the input tokens a Messages request would use
+func (c *Client) CountMessageTokens(model, system string, messages []AnthropicMessage) (int, error) {
+	request := struct {
+		Model    string             `json:"model"`
+		Messages []AnthropicMessage `json:"messages"`
+		System   string             `json:"system,omitempty"`
+	}{model, messages, system}
+
+	resp, err := c.Request("POST", "/v1/messages/count_tokens", request)
+	if err != nil {
+		return 0, err
+	}
+
+	var count struct {
+		InputTokens int `json:"input_tokens"`
+	}
+	if err := decodeResponse(resp, &count); err != nil {
+		return 0, err
+	}
+
+	return count.InputTokens, nil
+}
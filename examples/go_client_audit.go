@@ -0,0 +1,131 @@
+package inferno
+
+import (
+	"context"
+	"encoding/json"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// AuditKind is what an audit event records
+type AuditKind string
+
+const (
+	AuditAuthFailure     AuditKind = "auth_failure"
+	AuditAdminAction     AuditKind = "admin_action"
+	AuditPolicyViolation AuditKind = "policy_violation"
+	// AuditModelIncident is emitted by the model watchdog; it has no
+	// Method or Status
+	AuditModelIncident AuditKind = "model_incident"
+	// AuditMemoryPressure is emitted by the memory pressure monitor; its
+	// Details decode into MemoryPressureDetails
+	AuditMemoryPressure AuditKind = "memory_pressure"
+)
+
+// Audit structures
+type AuditEvent struct {
+	// ID increases by one per event; pass the last one seen as
+	// AuditFilter.SinceID to resume
+	ID        uint64    `json:"id"`
+	Timestamp time.Time `json:"timestamp"`
+	Kind      AuditKind `json:"kind"`
+	Method    string    `json:"method,omitempty"`
+	Path      string    `json:"path"`
+	Status    int       `json:"status,omitempty"`
+	Code      string    `json:"code,omitempty"`
+	Message   string    `json:"message,omitempty"`
+	Tenant    string    `json:"tenant,omitempty"`
+	ClientIP  string    `json:"client_ip,omitempty"`
+	RequestID string    `json:"request_id,omitempty"`
+	// Metadata is the request's Metadata tags
+	Metadata map[string]string `json:"metadata,omitempty"`
+	// Details carries structured data for events no request caused
+	Details json.RawMessage `json:"details,omitempty"`
+}
+
+type AuditEventsResponse struct {
+	Object string       `json:"object"`
+	Data   []AuditEvent `json:"data"`
+}
+
+// AuditFilter selects audit events; zero fields match everything
+type AuditFilter struct {
+	Kinds      []AuditKind
+	Tenant     string
+	PathPrefix string
+	// SinceID skips events up to and including this id
+	SinceID uint64
+}
+
+func (f AuditFilter) query() url.Values {
+	query := url.Values{}
+	if len(f.Kinds) > 0 {
+		kinds := make([]string, len(f.Kinds))
+		for i, kind := range f.Kinds {
+			kinds[i] = string(kind)
+		}
+		query.Set("kind", strings.Join(kinds, ","))
+	}
+	if f.Tenant != "" {
+		query.Set("tenant", f.Tenant)
+	}
+	if f.PathPrefix != "" {
+		query.Set("path_prefix", f.PathPrefix)
+	}
+	if f.SinceID > 0 {
+		query.Set("since_id", strconv.FormatUint(f.SinceID, 10))
+	}
+	return query
+}
+
+func auditEndpoint(path string, query url.Values) string {
+	if len(query) == 0 {
+		return path
+	}
+	return path + "?" + query.Encode()
+}
+
+// AuditEvents returns up to limit of the most recent audit events the
+// server still holds that match filter, oldest first
+func (a *AdminClient) AuditEvents(ctx context.Context, filter AuditFilter, limit int) ([]AuditEvent, error) {
+	query := filter.query()
+	if limit > 0 {
+		query.Set("limit", strconv.Itoa(limit))
+	}
+
+	var result AuditEventsResponse
+	if err := a.adminRequest(ctx, "GET", auditEndpoint("/admin/audit/events", query), nil, &result); err != nil {
+		return nil, err
+	}
+	return result.Data, nil
+}
+
+// WatchAuditEvents calls handle for each audit event matching filter,
+// first those the server still holds after filter.SinceID and then new ones
+// as they happen, until ctx is done, the stream ends or handle returns an
+// error. To resume after a disconnect, call it again with SinceID set to
+// the last event's ID.
+func (a *AdminClient) WatchAuditEvents(ctx context.Context, filter AuditFilter, handle func(AuditEvent) error) error {
+	resp, err := a.longRunningRequest(ctx, "GET", auditEndpoint("/admin/audit/stream", filter.query()), nil)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode >= 400 {
+		return decodeResponse(resp, nil)
+	}
+	defer resp.Body.Close()
+
+	return ReadServerSentEvents(resp.Body, func(data []byte) error {
+		var event AuditEvent
+		if err := json.Unmarshal(data, &event); err != nil {
+			return err
+		}
+		// Lag notices carry no event id
+		if event.ID == 0 {
+			return nil
+		}
+		return handle(event)
+	})
+}
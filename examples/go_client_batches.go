@@ -0,0 +1,221 @@
+package inferno
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"time"
+)
+
+// Endpoints an OpenAI-style batch may target
+const (
+	BatchEndpointChat        = "/v1/chat/completions"
+	BatchEndpointCompletions = "/v1/completions"
+	BatchEndpointEmbeddings  = "/v1/embeddings"
+)
+
+// batchPollInterval is how often WaitForBatch checks a batch, often enough
+// for a live progress bar
+const batchPollInterval = time.Second
+
+// Batch structures (OpenAI Batch API)
+type Batch struct {
+	ID               string             `json:"id"`
+	Object           string             `json:"object"`
+	Endpoint         string             `json:"endpoint"`
+	Errors           *BatchErrors       `json:"errors,omitempty"`
+	InputFileID      string             `json:"input_file_id"`
+	CompletionWindow string             `json:"completion_window"`
+	Status           OpenAIBatchStatus  `json:"status"`
+	OutputFileID     *string            `json:"output_file_id,omitempty"`
+	ErrorFileID      *string            `json:"error_file_id,omitempty"`
+	CreatedAt        int64              `json:"created_at"`
+	InProgressAt     *int64             `json:"in_progress_at,omitempty"`
+	ExpiresAt        int64              `json:"expires_at"`
+	FinalizingAt     *int64             `json:"finalizing_at,omitempty"`
+	CompletedAt      *int64             `json:"completed_at,omitempty"`
+	FailedAt         *int64             `json:"failed_at,omitempty"`
+	ExpiredAt        *int64             `json:"expired_at,omitempty"`
+	CancellingAt     *int64             `json:"cancelling_at,omitempty"`
+	CancelledAt      *int64             `json:"cancelled_at,omitempty"`
+	RequestCounts    BatchRequestCounts `json:"request_counts"`
+	Metadata         map[string]string  `json:"metadata,omitempty"`
+}
+
+type BatchRequestCounts struct {
+	Total     uint64 `json:"total"`
+	Completed uint64 `json:"completed"`
+	Failed    uint64 `json:"failed"`
+}
+
+// Fraction returns how many of the batch's requests have finished, or -1
+// while they are still being counted
+func (c BatchRequestCounts) Fraction() float64 {
+	if c.Total == 0 {
+		return -1
+	}
+	return float64(c.Completed+c.Failed) / float64(c.Total)
+}
+
+type BatchErrors struct {
+	Object string            `json:"object"`
+	Data   []BatchInputError `json:"data"`
+}
+
+// BatchInputError is a problem with a batch's input file
+type BatchInputError struct {
+	Code    string  `json:"code"`
+	Message string  `json:"message"`
+	Param   *string `json:"param,omitempty"`
+	// Line is 1-based
+	Line *int `json:"line,omitempty"`
+}
+
+// Done reports whether the batch has stopped running
+func (b *Batch) Done() bool {
+	return b.Status.Done()
+}
+
+type CreateBatchRequest struct {
+	InputFileID string `json:"input_file_id"`
+	Endpoint    string `json:"endpoint"`
+	// CompletionWindow defaults to "24h", the only window offered
+	CompletionWindow string            `json:"completion_window,omitempty"`
+	Metadata         map[string]string `json:"metadata,omitempty"`
+}
+
+// BatchRequestLine is one line of a batch's input file
+type BatchRequestLine struct {
+	CustomID string      `json:"custom_id"`
+	Method   string      `json:"method"`
+	URL      string      `json:"url"`
+	Body     interface{} `json:"body"`
+}
+
+// BatchResultLine is one line of a batch's output or error file
+type BatchResultLine struct {
+	ID       string `json:"id"`
+	CustomID string `json:"custom_id"`
+	Response *struct {
+		StatusCode int             `json:"status_code"`
+		RequestID  string          `json:"request_id"`
+		Body       json.RawMessage `json:"body"`
+	} `json:"response"`
+	Error *struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+type BatchesResponse struct {
+	Object  string  `json:"object"`
+	Data    []Batch `json:"data"`
+	HasMore bool    `json:"has_more"`
+}
+
+func batchEndpoint(id string) string {
+	return "/v1/batches/" + url.PathEscape(id)
+}
+
+// CreateBatch runs every request in an uploaded file (purpose "batch") in
+// the background and returns immediately; poll with Batch or block with
+// WaitForBatch. Requires the admin token.
+func (c *Client) CreateBatch(req CreateBatchRequest) (*Batch, error) {
+	return c.batchRequest("POST", "/v1/batches", req)
+}
+
+// Batches lists batches, newest first. Requires the admin token.
+func (c *Client) Batches() ([]Batch, error) {
+	resp, err := c.Request("GET", "/v1/batches", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var result BatchesResponse
+	if err := decodeResponse(resp, &result); err != nil {
+		return nil, err
+	}
+
+	return result.Data, nil
+}
+
+// Batch returns a batch's status and request counts. Requires the admin
+// token.
+func (c *Client) Batch(id string) (*Batch, error) {
+	return c.batchRequest("GET", batchEndpoint(id), nil)
+}
+
+// CancelBatch stops a batch; requests already finished keep their results.
+// Requires the admin token.
+func (c *Client) CancelBatch(id string) (*Batch, error) {
+	return c.batchRequest("POST", batchEndpoint(id)+"/cancel", nil)
+}
+
+func (c *Client) batchRequest(method, endpoint string, body interface{}) (*Batch, error) {
+	resp, err := c.Request(method, endpoint, body)
+	if err != nil {
+		return nil, err
+	}
+
+	var batch Batch
+	if err := decodeResponse(resp, &batch); err != nil {
+		return nil, err
+	}
+
+	return &batch, nil
+}
+
+// WaitForBatch polls a batch until it finishes or ctx is done, calling
+// progress (if non-nil) after every poll. The batch keeps running if ctx
+// ends first; cancel it with CancelBatch. A batch that failed validation is
+// returned along with an error. Requires the admin token.
+func (c *Client) WaitForBatch(ctx context.Context, id string, progress func(*Batch)) (*Batch, error) {
+	for {
+		batch, err := c.Batch(id)
+		if err != nil {
+			return nil, err
+		}
+		if progress != nil {
+			progress(batch)
+		}
+
+		if batch.Done() {
+			if batch.Status == OpenAIBatchFailed {
+				reason := "failed"
+				if batch.Errors != nil && len(batch.Errors.Data) > 0 {
+					reason = batch.Errors.Data[0].Message
+					if line := batch.Errors.Data[0].Line; line != nil {
+						reason = fmt.Sprintf("line %d: %s", *line, reason)
+					}
+				}
+				return batch, fmt.Errorf("batch %s failed: %s", id, reason)
+			}
+			return batch, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(batchPollInterval):
+		}
+	}
+}
+
+// BatchResults writes a finished batch's output file and then its error
+// file, if it has them, to w as JSONL, returning the bytes written
+func (c *Client) BatchResults(ctx context.Context, batch *Batch, w io.Writer) (int64, error) {
+	var written int64
+	for _, fileID := range []*string{batch.OutputFileID, batch.ErrorFileID} {
+		if fileID == nil {
+			continue
+		}
+		n, err := c.FileContent(ctx, *fileID, w)
+		written += n
+		if err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
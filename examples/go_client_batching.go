@@ -0,0 +1,122 @@
+package inferno
+
+import (
+	"fmt"
+	"time"
+)
+
+// Batching structures
+type BatchingConfig struct {
+	Enabled                bool    `json:"enabled"`
+	MaxBatchSize           int     `json:"max_batch_size"`
+	MaxWaitTimeMs          int64   `json:"max_wait_time_ms"`
+	MinBatchSize           int     `json:"min_batch_size"`
+	AdaptiveBatching       bool    `json:"adaptive_batching"`
+	PriorityLevels         int     `json:"priority_levels"`
+	SequenceLengthGrouping bool    `json:"sequence_length_grouping"`
+	PaddingStrategy        string  `json:"padding_strategy"`
+	ThroughputTarget       float64 `json:"throughput_target"`
+}
+
+// BatchingConfigUpdate changes only the fields that are set
+type BatchingConfigUpdate struct {
+	Enabled                *bool    `json:"enabled,omitempty"`
+	MaxBatchSize           *int     `json:"max_batch_size,omitempty"`
+	MaxWaitTimeMs          *int64   `json:"max_wait_time_ms,omitempty"`
+	MinBatchSize           *int     `json:"min_batch_size,omitempty"`
+	AdaptiveBatching       *bool    `json:"adaptive_batching,omitempty"`
+	SequenceLengthGrouping *bool    `json:"sequence_length_grouping,omitempty"`
+	PaddingStrategy        string   `json:"padding_strategy,omitempty"`
+	ThroughputTarget       *float64 `json:"throughput_target,omitempty"`
+}
+
+type EffectiveBatchingParams struct {
+	CurrentBatchSize  int     `json:"current_batch_size"`
+	CurrentWaitTimeMs int64   `json:"current_wait_time_ms"`
+	RecentThroughput  float64 `json:"recent_throughput"`
+}
+
+type BatchingConfigResponse struct {
+	Config    BatchingConfig          `json:"config"`
+	Effective EffectiveBatchingParams `json:"effective"`
+}
+
+type BatchingMetrics struct {
+	ThroughputImprovement  float64 `json:"throughput_improvement"`
+	EfficiencyRatio        float64 `json:"efficiency_ratio"`
+	AvgBatchSize           float64 `json:"avg_batch_size"`
+	AvgWaitTimeMs          float64 `json:"avg_wait_time_ms"`
+	TotalRequestsProcessed int64   `json:"total_requests_processed"`
+	TotalBatchesProcessed  int64   `json:"total_batches_processed"`
+	RequestsPerSecond      float64 `json:"requests_per_second"`
+}
+
+type BatchRecord struct {
+	ID                string    `json:"id"`
+	Size              int       `json:"size"`
+	AvgSequenceLength float64   `json:"avg_sequence_length"`
+	MaxQueueWaitMs    int64     `json:"max_queue_wait_ms"`
+	ProcessingTimeMs  int64     `json:"processing_time_ms"`
+	CompletedAt       time.Time `json:"completed_at"`
+}
+
+type BatchingStatsResponse struct {
+	Metrics          BatchingMetrics         `json:"metrics"`
+	Effective        EffectiveBatchingParams `json:"effective"`
+	QueuedByPriority map[string]int          `json:"queued_by_priority"`
+	RecentBatches    []BatchRecord           `json:"recent_batches"`
+	Timestamp        time.Time               `json:"timestamp"`
+}
+
+// BatchingConfig returns the configured batching limits and the values
+// adaptive batching is currently using
+func (c *Client) BatchingConfig() (*BatchingConfigResponse, error) {
+	resp, err := c.Request("GET", "/v1/batching/config", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var result BatchingConfigResponse
+	if err := decodeResponse(resp, &result); err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+// UpdateBatchingConfig changes batching limits without restarting the
+// server. Requires the admin token.
+func (c *Client) UpdateBatchingConfig(update BatchingConfigUpdate) (*BatchingConfigResponse, error) {
+	resp, err := c.Request("PUT", "/v1/batching/config", update)
+	if err != nil {
+		return nil, err
+	}
+
+	var result BatchingConfigResponse
+	if err := decodeResponse(resp, &result); err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+// BatchingStats returns aggregate batching metrics and statistics for up to
+// limit recent batches (server default when limit is 0)
+func (c *Client) BatchingStats(limit int) (*BatchingStatsResponse, error) {
+	endpoint := "/v1/batching/stats"
+	if limit > 0 {
+		endpoint = fmt.Sprintf("%s?limit=%d", endpoint, limit)
+	}
+
+	resp, err := c.Request("GET", endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var stats BatchingStatsResponse
+	if err := decodeResponse(resp, &stats); err != nil {
+		return nil, err
+	}
+
+	return &stats, nil
+}
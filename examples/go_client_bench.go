@@ -0,0 +1,95 @@
+package inferno
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"inferno-example/infernobench"
+)
+
+// BenchTarget returns an infernobench.Target that sends each prompt to
+// /v1/completions for model, generating up to maxTokens tokens
+func (c *Client) BenchTarget(model string, maxTokens int) infernobench.Target {
+	return func(ctx context.Context, prompt string, stream bool) (infernobench.Result, error) {
+		request := InferenceRequest{
+			Model:       model,
+			Prompt:      prompt,
+			MaxTokens:   maxTokens,
+			Temperature: 0.7,
+			TopP:        0.9,
+			TopK:        40,
+		}
+
+		if !stream {
+			resp, err := c.InferenceContext(ctx, request)
+			if err != nil {
+				return infernobench.Result{}, err
+			}
+			result := infernobench.Result{}
+			if resp.Usage != nil {
+				result.Tokens = resp.Usage.CompletionTokens
+			}
+			return result, nil
+		}
+
+		return c.streamCompletion(ctx, request)
+	}
+}
+
+// streamCompletion sends a streamed completion, counting chunks as tokens
+// and timing the first one
+func (c *Client) streamCompletion(ctx context.Context, request InferenceRequest) (infernobench.Result, error) {
+	request.Stream = true
+	started := time.Now()
+
+	resp, err := c.longRunningRequest(ctx, "POST", "/v1/completions", request)
+	if err != nil {
+		return infernobench.Result{}, err
+	}
+	if resp.StatusCode >= 400 {
+		return infernobench.Result{}, decodeResponse(resp, nil)
+	}
+	defer resp.Body.Close()
+
+	var result infernobench.Result
+	err = ReadServerSentEvents(resp.Body, func(data []byte) error {
+		if string(data) == "[DONE]" {
+			return nil
+		}
+
+		var chunk InferenceResponse
+		if err := json.Unmarshal(data, &chunk); err != nil {
+			return fmt.Errorf("decoding stream chunk: %w", err)
+		}
+		if len(chunk.Choices) == 0 || chunk.Choices[0].Text == "" {
+			return nil
+		}
+
+		if result.Tokens == 0 {
+			result.TimeToFirstToken = time.Since(started)
+		}
+		result.Tokens++
+		return nil
+	})
+
+	return result, err
+}
+
+// ClassifyBenchError groups errors for infernobench reports, splitting
+// server errors out by HTTP status
+func ClassifyBenchError(err error) string {
+	var apiErr *APIError
+	switch {
+	case errors.As(err, &apiErr):
+		return fmt.Sprintf("http_%d", apiErr.StatusCode)
+	case errors.Is(err, context.DeadlineExceeded):
+		return "timeout"
+	case errors.Is(err, context.Canceled):
+		return "canceled"
+	default:
+		return "transport"
+	}
+}
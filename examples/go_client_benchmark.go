@@ -0,0 +1,66 @@
+package inferno
+
+import (
+	"context"
+	"net/url"
+	"time"
+)
+
+// Benchmark structures
+type BenchmarkSuite struct {
+	PromptTokens     []int `json:"prompt_tokens,omitempty"`
+	GenerationTokens []int `json:"generation_tokens,omitempty"`
+	Iterations       int   `json:"iterations,omitempty"`
+	WarmupIterations int   `json:"warmup_iterations,omitempty"`
+}
+
+type BenchmarkCase struct {
+	PromptTokens          int     `json:"prompt_tokens"`
+	MaxTokens             int     `json:"max_tokens"`
+	Iterations            int     `json:"iterations"`
+	AvgGeneratedTokens    float64 `json:"avg_generated_tokens"`
+	AvgTimeToFirstTokenMs float64 `json:"avg_time_to_first_token_ms"`
+	P50TimeToFirstTokenMs float64 `json:"p50_time_to_first_token_ms"`
+	AvgTotalLatencyMs     float64 `json:"avg_total_latency_ms"`
+	AvgTokensPerSecond    float64 `json:"avg_tokens_per_second"`
+}
+
+type BenchmarkMemory struct {
+	StartRSSBytes int64 `json:"start_rss_bytes"`
+	PeakRSSBytes  int64 `json:"peak_rss_bytes"`
+}
+
+type BenchmarkReport struct {
+	Model                 string          `json:"model"`
+	StartedAt             time.Time       `json:"started_at"`
+	DurationMs            int64           `json:"duration_ms"`
+	Cases                 []BenchmarkCase `json:"cases"`
+	AvgTokensPerSecond    float64         `json:"avg_tokens_per_second"`
+	AvgTimeToFirstTokenMs float64         `json:"avg_time_to_first_token_ms"`
+	Memory                BenchmarkMemory `json:"memory"`
+	// Incomplete is set when the run was cancelled or timed out
+	Incomplete *string `json:"incomplete,omitempty"`
+}
+
+// BenchmarkModel runs the server's benchmark suite against model. A nil
+// suite runs the standard prompt length × generation length matrix. The run
+// can take minutes, so it is bounded by ctx rather than HTTPClient.Timeout.
+// Requires the admin token.
+func (c *Client) BenchmarkModel(ctx context.Context, model string, suite *BenchmarkSuite) (*BenchmarkReport, error) {
+	if suite == nil {
+		suite = &BenchmarkSuite{}
+	}
+
+	endpoint := "/v1/models/" + url.PathEscape(model) + "/benchmark"
+	resp, err := c.longRunningRequest(ctx, "POST", endpoint, suite)
+	if err != nil {
+		return nil, err
+	}
+
+	var report BenchmarkReport
+	if err := decodeResponse(resp, &report); err != nil {
+		return nil, err
+	}
+
+	return &report, nil
+}
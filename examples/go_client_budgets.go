@@ -0,0 +1,167 @@
+package inferno
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// BudgetWarningHeader is set on responses to requests charged to a budget
+// past its soft limit
+const BudgetWarningHeader = "X-Inferno-Budget-Warning"
+
+// Budget periods, units and states
+const (
+	BudgetDaily   = "daily"
+	BudgetMonthly = "monthly"
+
+	BudgetTokens = "tokens"
+	BudgetCost   = "cost"
+
+	BudgetOK       = "ok"
+	BudgetWarning  = "warning"
+	BudgetExceeded = "exceeded"
+)
+
+// Budget limits what a tenant or key may use in a day or a month
+type Budget struct {
+	// Period is BudgetDaily or BudgetMonthly; periods follow UTC
+	Period string `json:"period"`
+	// Unit is BudgetTokens (completion tokens requested, the default) or
+	// BudgetCost (those tokens at the model's completion price)
+	Unit string `json:"unit,omitempty"`
+	// SoftLimit is the usage past which responses carry BudgetWarningHeader
+	SoftLimit *float64 `json:"soft_limit,omitempty"`
+	// HardLimit is the usage past which requests are refused
+	HardLimit *float64 `json:"hard_limit,omitempty"`
+	// WebhookURL is posted an event when either limit is first reached in
+	// a period
+	WebhookURL string `json:"webhook_url,omitempty"`
+}
+
+// BudgetStatus is a budget and its usage this period
+type BudgetStatus struct {
+	Object string `json:"object"`
+	// Subject is "tenant" or "key"
+	Subject string `json:"subject"`
+	ID      string `json:"id"`
+	Budget
+	Used      float64 `json:"used"`
+	Remaining float64 `json:"remaining"`
+	// State is BudgetOK, BudgetWarning or BudgetExceeded
+	State       string    `json:"state"`
+	PeriodStart time.Time `json:"period_start"`
+	ResetsAt    time.Time `json:"resets_at"`
+}
+
+type BudgetsResponse struct {
+	Object string         `json:"object"`
+	Data   []BudgetStatus `json:"data"`
+}
+
+// BudgetExceededError is returned when a request is refused because it
+// would take a budget past its hard limit. It wraps the *APIError, so
+// errors.As finds either.
+type BudgetExceededError struct {
+	Budget  BudgetStatus
+	Message string
+	// RetryAfter is how long until the budget's period resets
+	RetryAfter time.Duration
+	Err        *APIError
+}
+
+func (e *BudgetExceededError) Error() string {
+	return fmt.Sprintf("inferno: %s budget of %s %q exceeded (%g of %g), resets at %s",
+		e.Budget.Period, e.Budget.Subject, e.Budget.ID, e.Budget.Used,
+		derefFloat(e.Budget.HardLimit), e.Budget.ResetsAt.Format(time.RFC3339))
+}
+
+func (e *BudgetExceededError) Unwrap() error {
+	return e.Err
+}
+
+func derefFloat(f *float64) float64 {
+	if f == nil {
+		return 0
+	}
+	return *f
+}
+
+// budgetExceeded returns a *BudgetExceededError when apiErr is a 429 for a
+// budget's hard limit, and nil otherwise
+func budgetExceeded(resp *http.Response, apiErr *APIError) *BudgetExceededError {
+	if resp.StatusCode != http.StatusTooManyRequests {
+		return nil
+	}
+
+	var body struct {
+		Error struct {
+			Message string       `json:"message"`
+			Code    string       `json:"code"`
+			Budget  BudgetStatus `json:"budget"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal([]byte(apiErr.Body), &body); err != nil || body.Error.Code != "budget_exceeded" {
+		return nil
+	}
+
+	var retryAfter time.Duration
+	if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil {
+		retryAfter = time.Duration(seconds) * time.Second
+	}
+	return &BudgetExceededError{
+		Budget:     body.Error.Budget,
+		Message:    body.Error.Message,
+		RetryAfter: retryAfter,
+		Err:        apiErr,
+	}
+}
+
+// BudgetStatus returns the budgets the client's requests are charged to:
+// its tenant's and, with a managed API key, the key's
+func (c *Client) BudgetStatus(ctx context.Context) ([]BudgetStatus, error) {
+	resp, err := c.RequestContext(ctx, "GET", "/v1/budget", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var result BudgetsResponse
+	if err := decodeResponse(resp, &result); err != nil {
+		return nil, err
+	}
+	return result.Data, nil
+}
+
+// Budgets lists every tenant and key budget with its usage
+func (a *AdminClient) Budgets(ctx context.Context) ([]BudgetStatus, error) {
+	var result BudgetsResponse
+	if err := a.adminRequest(ctx, "GET", "/admin/budgets", nil, &result); err != nil {
+		return nil, err
+	}
+	return result.Data, nil
+}
+
+// SetBudgets replaces the budgets of a tenant (kind "tenants") or managed
+// key (kind "keys"), at most one per period. Usage so far this period is
+// kept for budgets whose period and unit are unchanged.
+func (a *AdminClient) SetBudgets(ctx context.Context, kind, id string, budgets []Budget) ([]BudgetStatus, error) {
+	var result BudgetsResponse
+	body := map[string][]Budget{"budgets": budgets}
+	if err := a.adminRequest(ctx, "PUT", budgetPath(kind, id), body, &result); err != nil {
+		return nil, err
+	}
+	return result.Data, nil
+}
+
+// DeleteBudgets removes a tenant's or key's budgets
+func (a *AdminClient) DeleteBudgets(ctx context.Context, kind, id string) error {
+	return a.adminRequest(ctx, "DELETE", budgetPath(kind, id), nil, nil)
+}
+
+func budgetPath(kind, id string) string {
+	return "/admin/budgets/" + url.PathEscape(kind) + "/" + url.PathEscape(id)
+}
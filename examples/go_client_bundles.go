@@ -0,0 +1,128 @@
+package inferno
+
+import (
+	"context"
+	"io"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// Bundle structures
+type BundleRequest struct {
+	Models        []string `json:"models,omitempty"`
+	Adapters      []string `json:"adapters,omitempty"`
+	Templates     []string `json:"templates,omitempty"`
+	IncludeConfig bool     `json:"include_config,omitempty"`
+}
+
+type ImportedBundleItem struct {
+	// Kind is "model", "adapter", "template" or "config"
+	Kind   string `json:"kind"`
+	Name   string `json:"name"`
+	Path   string `json:"path"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+type BundleImport struct {
+	ID             string               `json:"id"`
+	Format         int                  `json:"format"`
+	InfernoVersion string               `json:"inferno_version"`
+	CreatedAt      time.Time            `json:"created_at"`
+	Items          []ImportedBundleItem `json:"items"`
+}
+
+// ExportBundle streams a tar archive of the selected models, adapters,
+// templates and config into w and returns the number of bytes written. The
+// archive is never held in memory and ctx bounds the download instead of
+// HTTPClient.Timeout. Requires the admin token.
+func (c *Client) ExportBundle(ctx context.Context, req BundleRequest, w io.Writer) (int64, error) {
+	resp, err := c.longRunningRequest(ctx, "POST", "/v1/export/bundle", req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		body, _ := io.ReadAll(resp.Body)
+		return 0, &APIError{StatusCode: resp.StatusCode, Body: strings.TrimSpace(string(body))}
+	}
+
+	return io.Copy(w, resp.Body)
+}
+
+// ExportBundleFile writes the bundle to the file at path, removing it if the
+// export fails part way. Requires the admin token.
+func (c *Client) ExportBundleFile(ctx context.Context, req BundleRequest, path string) (int64, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return 0, err
+	}
+
+	written, err := c.ExportBundle(ctx, req, file)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path)
+		return 0, err
+	}
+
+	return written, nil
+}
+
+// ImportBundle streams a bundle archive from r to the server, which checks
+// every file against the bundle's manifest before installing anything. Unless
+// overwrite is set, the import fails with a 409 if it would replace existing
+// files. Requires the admin token.
+func (c *Client) ImportBundle(ctx context.Context, r io.Reader, overwrite bool) (*BundleImport, error) {
+	return c.importBundle(ctx, r, -1, overwrite)
+}
+
+// ImportBundleFile imports the bundle at path like ImportBundle, sending its
+// length up front. Requires the admin token.
+func (c *Client) ImportBundleFile(ctx context.Context, path string, overwrite bool) (*BundleImport, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	return c.importBundle(ctx, file, info.Size(), overwrite)
+}
+
+func (c *Client) importBundle(ctx context.Context, r io.Reader, size int64, overwrite bool) (*BundleImport, error) {
+	endpoint := "/v1/import/bundle"
+	if overwrite {
+		endpoint += "?" + url.Values{"overwrite": {"true"}}.Encode()
+	}
+
+	req, err := c.newRequest(ctx, "POST", endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Body = io.NopCloser(r)
+	req.ContentLength = size
+	req.Header.Set("Content-Type", "application/x-tar")
+
+	httpClient := *c.HTTPClient
+	httpClient.Timeout = 0
+	resp, err := c.send(&httpClient, req)
+	if err != nil {
+		return nil, err
+	}
+
+	var imported BundleImport
+	if err := decodeResponse(resp, &imported); err != nil {
+		return nil, err
+	}
+
+	return &imported, nil
+}
@@ -0,0 +1,181 @@
+package inferno
+
+import (
+	"context"
+	"time"
+)
+
+// CacheKind is a kind of file the server caches on disk
+type CacheKind string
+
+const (
+	// CacheDownload is models fetched from remote model stores
+	CacheDownload CacheKind = "download"
+	// CachePartial is .part files left by interrupted downloads, untouched
+	// for at least 10 minutes
+	CachePartial CacheKind = "partial"
+	// CacheMetadata is parsed model metadata
+	CacheMetadata CacheKind = "metadata"
+)
+
+// Cache structures
+type CacheStats struct {
+	Kind      CacheKind  `json:"kind"`
+	Directory string     `json:"directory"`
+	Files     int        `json:"files"`
+	Bytes     uint64     `json:"bytes"`
+	MaxBytes  *uint64    `json:"max_bytes,omitempty"`
+	Oldest    *time.Time `json:"oldest,omitempty"`
+	Newest    *time.Time `json:"newest,omitempty"`
+}
+
+type DownloadCacheCounts struct {
+	Hits   uint64 `json:"hits"`
+	Misses uint64 `json:"misses"`
+	// HitRatio is nil until a model store fetch has happened
+	HitRatio *float64 `json:"hit_ratio"`
+}
+
+type CacheStatsResponse struct {
+	Object     string              `json:"object"`
+	TotalBytes uint64              `json:"total_bytes"`
+	Caches     []CacheStats        `json:"caches"`
+	Downloads  DownloadCacheCounts `json:"downloads"`
+}
+
+// Cache returns the stats for one kind, or nil if the server did not
+// report it
+func (r *CacheStatsResponse) Cache(kind CacheKind) *CacheStats {
+	for i := range r.Caches {
+		if r.Caches[i].Kind == kind {
+			return &r.Caches[i]
+		}
+	}
+	return nil
+}
+
+// CacheClearRequest selects the files to remove; zero fields match
+// everything
+type CacheClearRequest struct {
+	Types []CacheKind `json:"types,omitempty"`
+	// OlderThanSecs keeps files modified within this many seconds
+	OlderThanSecs uint64 `json:"older_than_secs,omitempty"`
+}
+
+type CacheRemoved struct {
+	Kind  CacheKind `json:"kind,omitempty"`
+	Files int       `json:"files"`
+	Bytes uint64    `json:"bytes"`
+}
+
+type CacheClearResponse struct {
+	Object     string         `json:"object"`
+	FreedBytes uint64         `json:"freed_bytes"`
+	Cleared    []CacheRemoved `json:"cleared"`
+}
+
+// CacheConfig holds the size limit, in bytes, of each limited kind
+type CacheConfig struct {
+	MaxBytes map[CacheKind]uint64 `json:"max_bytes"`
+}
+
+// CacheConfigUpdate sets the limits in MaxBytes; a nil value removes that
+// kind's limit and kinds left out keep theirs
+type CacheConfigUpdate struct {
+	MaxBytes map[CacheKind]*uint64 `json:"max_bytes"`
+}
+
+type CacheConfigResponse struct {
+	Config CacheConfig `json:"config"`
+	// Removed is what applying the new limits deleted, by kind
+	Removed map[CacheKind]CacheRemoved `json:"removed"`
+}
+
+// CacheStats returns the size and age of each disk cache and how often
+// model store fetches were served from the cache
+func (c *Client) CacheStats(ctx context.Context) (*CacheStatsResponse, error) {
+	resp, err := c.RequestContext(ctx, "GET", "/cache/stats", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var stats CacheStatsResponse
+	if err := decodeResponse(resp, &stats); err != nil {
+		return nil, err
+	}
+	return &stats, nil
+}
+
+// ClearCache removes cached files matching request
+func (a *AdminClient) ClearCache(ctx context.Context, request CacheClearRequest) (*CacheClearResponse, error) {
+	var result CacheClearResponse
+	if err := a.adminRequest(ctx, "POST", "/cache/clear", request, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// CacheConfig returns the cache size limits
+func (a *AdminClient) CacheConfig(ctx context.Context) (*CacheConfig, error) {
+	var config CacheConfig
+	if err := a.adminRequest(ctx, "GET", "/cache/config", nil, &config); err != nil {
+		return nil, err
+	}
+	return &config, nil
+}
+
+// UpdateCacheConfig changes the cache size limits and applies them at once,
+// removing the oldest files of any kind now over its limit
+func (a *AdminClient) UpdateCacheConfig(ctx context.Context, update CacheConfigUpdate) (*CacheConfigResponse, error) {
+	var result CacheConfigResponse
+	if err := a.adminRequest(ctx, "PUT", "/cache/config", update, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// CacheStatus says whether a completion came from the response cache
+type CacheStatus string
+
+const (
+	// CacheHit is a completion replayed from the cache
+	CacheHit CacheStatus = "hit"
+	// CacheMiss is a completion generated and then stored
+	CacheMiss CacheStatus = "miss"
+	// CacheBypassed is a request that set CacheBypass or sent
+	// Cache-Control: no-cache
+	CacheBypassed CacheStatus = "bypass"
+	// CacheUncacheable is a streaming, sampled (no Seed and a non-zero
+	// Temperature) or multi-choice request
+	CacheUncacheable CacheStatus = "uncacheable"
+)
+
+// CacheStatusHeader carries the CacheStatus on completion responses
+const CacheStatusHeader = "X-Inferno-Cache"
+
+// CacheInfo is the cache object on completion responses
+type CacheInfo struct {
+	Status CacheStatus `json:"status"`
+	// Type is the cache consulted; "response" for exact-match replays
+	Type string `json:"type"`
+	// AgeSeconds is how long ago a hit was stored
+	AgeSeconds *uint64 `json:"age_seconds,omitempty"`
+}
+
+// CacheStatus reports whether the completion was served from the cache;
+// empty when the server did not say
+func (r *InferenceResponse) CacheStatus() CacheStatus {
+	if r.Cache == nil {
+		return ""
+	}
+	return r.Cache.Status
+}
+
+// CacheStatus reports whether the completion was served from the cache;
+// empty when the server did not say
+func (r *ChatCompletionResponse) CacheStatus() CacheStatus {
+	if r.Cache == nil {
+		return ""
+	}
+	return r.Cache.Status
+}
@@ -0,0 +1,93 @@
+package inferno
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"time"
+)
+
+// RequestIDHeader carries the ID the server tracks a generation under
+const RequestIDHeader = "X-Request-ID"
+
+// cancelTimeout bounds the best-effort cancel call issued after the caller
+// has given up on a request
+const cancelTimeout = 5 * time.Second
+
+// deadlineMargin is subtracted from the context deadline before it is sent,
+// leaving time for the partial response to reach the caller
+const deadlineMargin = 250 * time.Millisecond
+
+// NewRequestID returns a random ID suitable for the X-Request-ID header
+func NewRequestID() string {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return fmt.Sprintf("req_%d", time.Now().UnixNano())
+	}
+	return "req_" + hex.EncodeToString(buf)
+}
+
+// InferenceContext runs a completion tied to ctx. A context deadline is sent
+// as the request deadline so the server stops decoding in time and returns
+// partial output. If ctx is cancelled before the response arrives, the client
+// tells the server to cancel the generation so it stops using the GPU.
+func (c *Client) InferenceContext(ctx context.Context, request InferenceRequest) (*InferenceResponse, error) {
+	requestID := NewRequestID()
+
+	if deadline, ok := ctx.Deadline(); ok && request.Deadline == nil {
+		if time.Until(deadline) > 2*deadlineMargin {
+			deadline = deadline.Add(-deadlineMargin)
+		}
+		utc := deadline.UTC()
+		request.Deadline = &utc
+	}
+
+	req, err := c.newRequest(ctx, "POST", "/v1/completions", request)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set(RequestIDHeader, requestID)
+
+	done := make(chan struct{})
+	defer close(done)
+	go c.cancelOnDone(ctx, requestID, done)
+
+	resp, err := c.send(c.HTTPClient, req)
+	if err != nil {
+		return nil, err
+	}
+
+	var result InferenceResponse
+	if err := decodeResponse(resp, &result); err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+// cancelOnDone issues a server-side cancel if ctx ends before done is closed
+func (c *Client) cancelOnDone(ctx context.Context, requestID string, done <-chan struct{}) {
+	select {
+	case <-done:
+	case <-ctx.Done():
+		// The request context is already dead, so the cancel gets its own
+		_ = c.CancelInference(requestID)
+	}
+}
+
+// CancelInference asks the server to stop an in-flight generation. The
+// client must use the API key the request was made with, or the admin token.
+func (c *Client) CancelInference(requestID string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), cancelTimeout)
+	defer cancel()
+
+	endpoint := fmt.Sprintf("/v1/inference/%s/cancel", url.PathEscape(requestID))
+	resp, err := c.RequestContext(ctx, "POST", endpoint, nil)
+	if err != nil {
+		return err
+	}
+
+	return decodeResponse(resp, nil)
+}
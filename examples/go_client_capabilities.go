@@ -0,0 +1,157 @@
+package inferno
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// ErrNotSupported is returned, wrapped with the missing capability, when
+// the server's /capabilities says it cannot handle a request; the request
+// is not sent
+var ErrNotSupported = errors.New("inferno: not supported by this server")
+
+// Server features reported by /capabilities
+const (
+	FeatureStreaming      = "streaming"
+	FeatureTools          = "tools"
+	FeatureVision         = "vision"
+	FeatureGrammars       = "grammars"
+	FeatureBatch          = "batch"
+	FeatureVectorStore    = "vector_store"
+	FeatureAsyncInference = "async_inference"
+	FeatureSessions       = "sessions"
+)
+
+// Per-model capabilities reported by /capabilities
+const (
+	CapabilityChat         = "chat"
+	CapabilityCompletions  = "completions"
+	CapabilityStreaming    = "streaming"
+	CapabilityTools        = "tools"
+	CapabilityEmbeddings   = "embeddings"
+	CapabilityTokenize     = "tokenize"
+	CapabilityLogprobs     = "logprobs"
+	CapabilityRerank       = "rerank"
+	CapabilityHiddenStates = "hidden_states"
+	CapabilityLogits       = "logits"
+)
+
+// Capability structures
+type Capabilities struct {
+	APIVersion    string              `json:"api_version"`
+	ContextWindow int                 `json:"context_window"`
+	Features      map[string]bool     `json:"features"`
+	Models        []ModelCapabilities `json:"models"`
+}
+
+type ModelCapabilities struct {
+	ID           string   `json:"id"`
+	Format       string   `json:"format"`
+	Capabilities []string `json:"capabilities"`
+}
+
+// Supports reports whether the model has capability
+func (m *ModelCapabilities) Supports(capability string) bool {
+	for _, c := range m.Capabilities {
+		if c == capability {
+			return true
+		}
+	}
+	return false
+}
+
+// Model returns the capabilities of the model with id, or nil if the
+// server does not list it
+func (c *Capabilities) Model(id string) *ModelCapabilities {
+	for i := range c.Models {
+		if c.Models[i].ID == id {
+			return &c.Models[i]
+		}
+	}
+	return nil
+}
+
+// Capabilities returns what the server and its models support. The result
+// is cached after the first successful call; nil with no error means the
+// server predates /capabilities.
+func (c *Client) Capabilities(ctx context.Context) (*Capabilities, error) {
+	c.capabilitiesMu.Lock()
+	defer c.capabilitiesMu.Unlock()
+	if c.capabilitiesFetched {
+		return c.capabilities, nil
+	}
+
+	resp, err := c.RequestContext(ctx, "GET", "/capabilities", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var capabilities *Capabilities
+	if resp.StatusCode == http.StatusNotFound {
+		resp.Body.Close()
+	} else {
+		capabilities = &Capabilities{}
+		if err := decodeResponse(resp, capabilities); err != nil {
+			return nil, err
+		}
+	}
+
+	c.capabilities, c.capabilitiesFetched = capabilities, true
+	return capabilities, nil
+}
+
+// RefreshCapabilities drops the cached capabilities, for after models are
+// added or the server is upgraded
+func (c *Client) RefreshCapabilities() {
+	c.capabilitiesMu.Lock()
+	defer c.capabilitiesMu.Unlock()
+	c.capabilities, c.capabilitiesFetched = nil, false
+}
+
+// requireCapabilities fails with ErrNotSupported unless the server has each
+// of features and model (when non-empty) has each of capabilities. Servers
+// without /capabilities and models it does not list, such as routing
+// aliases, are given the benefit of the doubt.
+func (c *Client) requireCapabilities(ctx context.Context, features []string, model string, capabilities ...string) error {
+	server, err := c.Capabilities(ctx)
+	if err != nil || server == nil {
+		return err
+	}
+
+	for _, feature := range features {
+		if !server.Features[feature] {
+			return fmt.Errorf("%w: %s", ErrNotSupported, feature)
+		}
+	}
+
+	info := server.Model(model)
+	if info == nil {
+		return nil
+	}
+	for _, capability := range capabilities {
+		if !info.Supports(capability) {
+			return fmt.Errorf("%w: model %s (%s) does not support %s", ErrNotSupported, model, info.Format, capability)
+		}
+	}
+	return nil
+}
+
+// requireChatCapabilities checks the features a chat request relies on
+func (c *Client) requireChatCapabilities(ctx context.Context, request ChatCompletionRequest) error {
+	var features []string
+	capabilities := []string{CapabilityChat}
+	if request.Stream {
+		features = append(features, FeatureStreaming)
+		capabilities = append(capabilities, CapabilityStreaming)
+	}
+	if len(request.Tools) > 0 {
+		features = append(features, FeatureTools)
+		capabilities = append(capabilities, CapabilityTools)
+	}
+	if request.Logprobs {
+		capabilities = append(capabilities, CapabilityLogprobs)
+	}
+	return c.requireCapabilities(ctx, features, request.Model, capabilities...)
+}
@@ -0,0 +1,97 @@
+package inferno
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// ModelChangeType is what happened to a model in the catalog
+type ModelChangeType string
+
+const (
+	ModelAdded ModelChangeType = "added"
+	// ModelModified means the file's size or modification time changed
+	ModelModified ModelChangeType = "modified"
+	ModelRemoved  ModelChangeType = "removed"
+)
+
+// ModelChange is one change to the model catalog
+type ModelChange struct {
+	Revision uint64          `json:"revision"`
+	Type     ModelChangeType `json:"type"`
+	ID       string          `json:"id"`
+	// Model is nil on removals
+	Model     *OpenAIModel `json:"model,omitempty"`
+	Timestamp time.Time    `json:"timestamp"`
+}
+
+type ModelChangesResponse struct {
+	Object string `json:"object"`
+	// Revision is the since to pass on the next call
+	Revision uint64        `json:"revision"`
+	Changes  []ModelChange `json:"changes"`
+}
+
+// ErrModelRevisionExpired is returned when the server no longer keeps the
+// changes after the requested revision; list the models again and watch
+// from the listing's revision
+var ErrModelRevisionExpired = errors.New("inferno: model catalog revision expired")
+
+// OpenAIModelsRevision lists the models along with the catalog revision the
+// listing reflects, to watch from with ModelChanges or WatchModelCatalog
+func (c *Client) OpenAIModelsRevision(ctx context.Context) ([]OpenAIModel, uint64, error) {
+	var list struct {
+		Data     []OpenAIModel `json:"data"`
+		Revision uint64        `json:"revision"`
+	}
+	if err := c.getConditional(ctx, "/v1/models", &list); err != nil {
+		return nil, 0, err
+	}
+	return list.Data, list.Revision, nil
+}
+
+// ModelChanges returns the catalog changes after since, waiting up to wait
+// (at most 5 minutes) for one; zero uses the server's 30 second default.
+// An empty Changes means nothing changed in time.
+func (c *Client) ModelChanges(ctx context.Context, since uint64, wait time.Duration) (*ModelChangesResponse, error) {
+	endpoint := fmt.Sprintf("/v1/models?watch=true&since=%d", since)
+	if wait > 0 {
+		endpoint += fmt.Sprintf("&timeout_secs=%d", int(wait.Seconds()))
+	}
+
+	resp, err := c.longRunningRequest(ctx, "GET", endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var changes ModelChangesResponse
+	if err := decodeResponse(resp, &changes); err != nil {
+		var apiErr *APIError
+		if errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusGone {
+			return nil, ErrModelRevisionExpired
+		}
+		return nil, err
+	}
+	return &changes, nil
+}
+
+// WatchModelCatalog calls handle for each catalog change after since, in
+// revision order, until ctx is done or handle returns an error. It returns
+// ErrModelRevisionExpired if it falls too far behind the server.
+func (c *Client) WatchModelCatalog(ctx context.Context, since uint64, handle func(ModelChange) error) error {
+	for {
+		changes, err := c.ModelChanges(ctx, since, 0)
+		if err != nil {
+			return err
+		}
+		for _, change := range changes.Changes {
+			if err := handle(change); err != nil {
+				return err
+			}
+		}
+		since = changes.Revision
+	}
+}
@@ -0,0 +1,194 @@
+package inferno
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"time"
+)
+
+// NodeRole is a job a cluster node does
+type NodeRole string
+
+const (
+	NodeRoleAPI         NodeRole = "api"
+	NodeRoleWorker      NodeRole = "worker"
+	NodeRoleCoordinator NodeRole = "coordinator"
+)
+
+// NodeHealth is a cluster node's state as its coordinator sees it
+type NodeHealth string
+
+const (
+	NodeHealthy     NodeHealth = "healthy"
+	NodeStale       NodeHealth = "stale"
+	NodeUnreachable NodeHealth = "unreachable"
+	NodeMaintenance NodeHealth = "maintenance"
+	NodeDraining    NodeHealth = "draining"
+)
+
+// Cluster event types
+const (
+	ClusterEventNodeJoined = "node_joined"
+	ClusterEventNodeLeft   = "node_left"
+)
+
+// Cluster structures
+type NodeGPU struct {
+	Index              int     `json:"index"`
+	Name               string  `json:"name"`
+	Vendor             string  `json:"vendor"`
+	MemoryTotalMB      int64   `json:"memory_total_mb"`
+	MemoryFreeMB       int64   `json:"memory_free_mb"`
+	UtilizationPercent float32 `json:"utilization_percent"`
+}
+
+type ClusterNode struct {
+	ID           string     `json:"id"`
+	Address      *string    `json:"address"`
+	Roles        []NodeRole `json:"roles"`
+	Version      *string    `json:"version"`
+	LoadedModels []string   `json:"loaded_models"`
+	GPUs         []NodeGPU  `json:"gpus"`
+	Health       NodeHealth `json:"health"`
+	// Local is set on the node that answered the request
+	Local    bool      `json:"local"`
+	JoinedAt time.Time `json:"joined_at"`
+	LastSeen time.Time `json:"last_seen"`
+}
+
+// HasRole reports whether the node has role
+func (n *ClusterNode) HasRole(role NodeRole) bool {
+	for _, r := range n.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+type ClusterNodesResponse struct {
+	Object string `json:"object"`
+	// NodeID is the id of the node that answered
+	NodeID string        `json:"node_id"`
+	Data   []ClusterNode `json:"data"`
+}
+
+type ClusterEvent struct {
+	// Sequence increases by one per event; pass the last one seen to
+	// ClusterEvents to get only newer events
+	Sequence int64     `json:"sequence"`
+	Type     string    `json:"type"`
+	NodeID   string    `json:"node_id"`
+	At       time.Time `json:"at"`
+	Reason   *string   `json:"reason"`
+}
+
+type ClusterEventsResponse struct {
+	Object string         `json:"object"`
+	Data   []ClusterEvent `json:"data"`
+}
+
+// NodeReport is what a node sends when it joins and with each heartbeat.
+// On a heartbeat, empty Address, Roles and Version keep the values sent
+// before.
+type NodeReport struct {
+	Address      string     `json:"address,omitempty"`
+	Roles        []NodeRole `json:"roles,omitempty"`
+	Version      string     `json:"version,omitempty"`
+	LoadedModels []string   `json:"loaded_models,omitempty"`
+	GPUs         []NodeGPU  `json:"gpus,omitempty"`
+	// Mode is the node's own mode; empty means serving
+	Mode ServerMode `json:"mode,omitempty"`
+}
+
+func clusterNodeEndpoint(id string) string {
+	return "/cluster/nodes/" + url.PathEscape(id)
+}
+
+// ClusterNodes lists every node the server knows of, itself first
+func (c *Client) ClusterNodes(ctx context.Context) (*ClusterNodesResponse, error) {
+	resp, err := c.RequestContext(ctx, "GET", "/cluster/nodes", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var result ClusterNodesResponse
+	if err := decodeResponse(resp, &result); err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+// ClusterNode returns one node
+func (c *Client) ClusterNode(ctx context.Context, id string) (*ClusterNode, error) {
+	return c.clusterNodeRequest(ctx, "GET", clusterNodeEndpoint(id), nil)
+}
+
+// ClusterEvents lists node joins and leaves after sequence number after,
+// oldest first; pass 0 for all that are kept
+func (c *Client) ClusterEvents(ctx context.Context, after int64) ([]ClusterEvent, error) {
+	endpoint := "/cluster/events"
+	if after > 0 {
+		endpoint += fmt.Sprintf("?after=%d", after)
+	}
+
+	resp, err := c.RequestContext(ctx, "GET", endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var result ClusterEventsResponse
+	if err := decodeResponse(resp, &result); err != nil {
+		return nil, err
+	}
+
+	return result.Data, nil
+}
+
+// JoinCluster registers a node with the server, or re-registers one that
+// restarted
+func (a *AdminClient) JoinCluster(ctx context.Context, id string, report NodeReport) (*ClusterNode, error) {
+	body := struct {
+		ID string `json:"id"`
+		NodeReport
+	}{id, report}
+	return a.clusterNodeRequest(ctx, "POST", "/cluster/nodes", body)
+}
+
+// NodeHeartbeat reports a node's current state. Nodes that stop sending
+// heartbeats are shown as stale after 30s and unreachable after 90s. A 404
+// *APIError means the server has forgotten the node, for example after a
+// restart, and it should join again.
+func (a *AdminClient) NodeHeartbeat(ctx context.Context, id string, report NodeReport) (*ClusterNode, error) {
+	return a.clusterNodeRequest(ctx, "POST", clusterNodeEndpoint(id)+"/heartbeat", report)
+}
+
+// LeaveCluster removes a node, recording reason in the node_left event
+func (a *AdminClient) LeaveCluster(ctx context.Context, id, reason string) error {
+	endpoint := clusterNodeEndpoint(id)
+	if reason != "" {
+		endpoint += "?" + url.Values{"reason": {reason}}.Encode()
+	}
+
+	resp, err := a.RequestContext(ctx, "DELETE", endpoint, nil)
+	if err != nil {
+		return err
+	}
+	return decodeResponse(resp, nil)
+}
+
+func (c *Client) clusterNodeRequest(ctx context.Context, method, endpoint string, body interface{}) (*ClusterNode, error) {
+	resp, err := c.RequestContext(ctx, method, endpoint, body)
+	if err != nil {
+		return nil, err
+	}
+
+	var node ClusterNode
+	if err := decodeResponse(resp, &node); err != nil {
+		return nil, err
+	}
+
+	return &node, nil
+}
@@ -0,0 +1,271 @@
+package inferno
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Defaults of a Coalescer's zero fields
+const (
+	defaultCoalesceWait  = 5 * time.Millisecond
+	defaultCoalesceBatch = 32
+)
+
+// Coalescer buffers individual embeddings and completion calls for up to
+// MaxWait and sends those it can combine as one server request, an
+// embeddings request with every input or a /v1/completions/batch, handing
+// each caller its own part of the result. It implements InfernoAPI, so code
+// written against that gets the throughput of batching unchanged.
+//
+// Calls combine when they name the same model and options (embedding
+// dimensions, completion metadata). Streaming and encrypted completions,
+// and all chat completions, are sent on their own. A batch is sent with a
+// context of its own, so it is not cut short when one caller gives up, and
+// per-call context options such as WithRegion do not apply to it. Coalesced
+// embeddings responses carry no Usage, since the server reports it only for
+// the whole request.
+type Coalescer struct {
+	client *Client
+	// MaxWait is how long the first call of a batch waits for others;
+	// zero means 5ms
+	MaxWait time.Duration
+	// MaxBatch sends a batch as soon as it has this many calls; zero means
+	// 32, and completions are capped at 64, the server's limit
+	MaxBatch int
+
+	mu      sync.Mutex
+	pending map[string]*pendingBatch
+	// unbatched is set once the server has no /v1/completions/batch, after
+	// which completions are sent one by one
+	unbatched atomic.Bool
+}
+
+// coalescedCall is one caller's request waiting in a batch
+type coalescedCall struct {
+	request interface{}
+	done    chan coalescedResult
+}
+
+type coalescedResult struct {
+	value interface{}
+	err   error
+}
+
+// pendingBatch collects calls until it is full or its timer fires
+type pendingBatch struct {
+	calls []*coalescedCall
+	timer *time.Timer
+}
+
+// NewCoalescer returns a coalescer sending through client, waiting up to
+// maxWait (zero for the default) for calls to combine
+func NewCoalescer(client *Client, maxWait time.Duration) *Coalescer {
+	return &Coalescer{client: client, MaxWait: maxWait}
+}
+
+var _ InfernoAPI = (*Coalescer)(nil)
+
+// submit adds request to the batch under key, sending the batch through
+// flush once it is full or MaxWait has passed, and waits for its result
+func (co *Coalescer) submit(ctx context.Context, key string, limit int, request interface{}, flush func([]*coalescedCall)) (interface{}, error) {
+	maxWait, maxBatch := co.MaxWait, co.MaxBatch
+	if maxWait <= 0 {
+		maxWait = defaultCoalesceWait
+	}
+	if maxBatch <= 0 {
+		maxBatch = defaultCoalesceBatch
+	}
+	if maxBatch > limit {
+		maxBatch = limit
+	}
+
+	call := &coalescedCall{request: request, done: make(chan coalescedResult, 1)}
+	co.mu.Lock()
+	if co.pending == nil {
+		co.pending = map[string]*pendingBatch{}
+	}
+	batch, ok := co.pending[key]
+	if !ok {
+		batch = &pendingBatch{}
+		batch.timer = time.AfterFunc(maxWait, func() {
+			co.mu.Lock()
+			calls := co.take(key, batch)
+			co.mu.Unlock()
+			if calls != nil {
+				flush(calls)
+			}
+		})
+		co.pending[key] = batch
+	}
+	batch.calls = append(batch.calls, call)
+	var full []*coalescedCall
+	if len(batch.calls) >= maxBatch {
+		full = co.take(key, batch)
+	}
+	co.mu.Unlock()
+	if full != nil {
+		go flush(full)
+	}
+
+	select {
+	case result := <-call.done:
+		return result.value, result.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// take removes batch from the pending ones, if it still is, and returns its
+// calls; co.mu must be held
+func (co *Coalescer) take(key string, batch *pendingBatch) []*coalescedCall {
+	if co.pending[key] != batch {
+		return nil
+	}
+	delete(co.pending, key)
+	batch.timer.Stop()
+	return batch.calls
+}
+
+// EmbeddingsContext creates embeddings, sending request's inputs together
+// with those of other calls for the same model and dimensions
+func (co *Coalescer) EmbeddingsContext(ctx context.Context, request EmbeddingsRequest) (*EmbeddingsResponse, error) {
+	if request.EncodingFormat == EncodingBase64 {
+		return co.client.EmbeddingsContext(ctx, request)
+	}
+	options, _ := json.Marshal(request.Dimensions)
+	key := "embeddings\x00" + request.Model + "\x00" + string(options)
+	value, err := co.submit(ctx, key, int(^uint(0)>>1), request, co.flushEmbeddings)
+	if err != nil {
+		return nil, err
+	}
+	return value.(*EmbeddingsResponse), nil
+}
+
+func (co *Coalescer) flushEmbeddings(calls []*coalescedCall) {
+	first := calls[0].request.(EmbeddingsRequest)
+	merged := EmbeddingsRequest{Model: first.Model, EncodingFormat: first.EncodingFormat, Dimensions: first.Dimensions}
+	offsets := make([]int, len(calls))
+	for i, call := range calls {
+		offsets[i] = len(merged.Input)
+		merged.Input = append(merged.Input, call.request.(EmbeddingsRequest).Input...)
+	}
+
+	result, err := co.client.EmbeddingsContext(context.Background(), merged)
+	for i, call := range calls {
+		if err != nil {
+			call.done <- coalescedResult{err: err}
+			continue
+		}
+		count := len(call.request.(EmbeddingsRequest).Input)
+		response := &EmbeddingsResponse{Model: result.Model, Data: []EmbeddingData{}}
+		for _, data := range result.Data {
+			if data.Index >= offsets[i] && data.Index < offsets[i]+count {
+				response.Data = append(response.Data, EmbeddingData{Embedding: data.Embedding, Index: data.Index - offsets[i]})
+			}
+		}
+		call.done <- coalescedResult{value: response}
+	}
+}
+
+// maxCompletionBatch is the most completions the server runs in one batch
+const maxCompletionBatch = 64
+
+// completionBatchRequest is the body of POST /v1/completions/batch
+type completionBatchRequest struct {
+	Model string `json:"model"`
+	// MaxTokens is the items' total, what budgets charge
+	MaxTokens int                `json:"max_tokens"`
+	Requests  []InferenceRequest `json:"requests"`
+	Metadata  map[string]string  `json:"metadata,omitempty"`
+}
+
+type completionBatchItem struct {
+	Index    int                `json:"index"`
+	Response *InferenceResponse `json:"response,omitempty"`
+	Status   int                `json:"status,omitempty"`
+	Error    json.RawMessage    `json:"error,omitempty"`
+}
+
+type completionBatchResponse struct {
+	Data  []completionBatchItem `json:"data"`
+	Usage *Usage                `json:"usage,omitempty"`
+}
+
+// InferenceContext runs a completion, sending it in one batch with other
+// calls for the same model and metadata. A context deadline is sent as the
+// request's deadline, as Client.InferenceContext does.
+func (co *Coalescer) InferenceContext(ctx context.Context, request InferenceRequest) (*InferenceResponse, error) {
+	if request.Stream || request.Encryption != nil || co.unbatched.Load() {
+		return co.client.InferenceContext(ctx, request)
+	}
+	if deadline, ok := ctx.Deadline(); ok && request.Deadline == nil {
+		utc := deadline.UTC()
+		request.Deadline = &utc
+	}
+	metadata, _ := json.Marshal(request.Metadata)
+	key := "completions\x00" + request.Model + "\x00" + string(metadata)
+	value, err := co.submit(ctx, key, maxCompletionBatch, request, co.flushCompletions)
+	if err != nil {
+		return nil, err
+	}
+	return value.(*InferenceResponse), nil
+}
+
+func (co *Coalescer) flushCompletions(calls []*coalescedCall) {
+	first := calls[0].request.(InferenceRequest)
+	batch := completionBatchRequest{Model: first.Model, Metadata: first.Metadata}
+	for _, call := range calls {
+		request := call.request.(InferenceRequest)
+		request.Metadata = nil
+		batch.MaxTokens += request.MaxTokens
+		batch.Requests = append(batch.Requests, request)
+	}
+
+	var result completionBatchResponse
+	resp, err := co.client.RequestContext(context.Background(), "POST", "/v1/completions/batch", batch)
+	if err == nil {
+		err = decodeResponse(resp, &result)
+	}
+	var apiErr *APIError
+	if errors.As(err, &apiErr) && (apiErr.StatusCode == http.StatusNotFound || apiErr.StatusCode == http.StatusMethodNotAllowed) {
+		// An older server: send each call on its own from now on
+		co.unbatched.Store(true)
+		for _, call := range calls {
+			go func(call *coalescedCall) {
+				value, err := co.client.InferenceContext(context.Background(), call.request.(InferenceRequest))
+				call.done <- coalescedResult{value: value, err: err}
+			}(call)
+		}
+		return
+	}
+
+	items := map[int]completionBatchItem{}
+	for _, item := range result.Data {
+		items[item.Index] = item
+	}
+	for i, call := range calls {
+		item, ok := items[i]
+		switch {
+		case err != nil:
+			call.done <- coalescedResult{err: err}
+		case !ok:
+			call.done <- coalescedResult{err: errors.New("inferno: batch response has no result for the request")}
+		case item.Response != nil:
+			call.done <- coalescedResult{value: item.Response}
+		default:
+			body, _ := json.Marshal(map[string]json.RawMessage{"error": item.Error})
+			call.done <- coalescedResult{err: &APIError{StatusCode: item.Status, Body: string(body)}}
+		}
+	}
+}
+
+// ChatCompletionContext sends request on its own; chat completions are not
+// coalesced
+func (co *Coalescer) ChatCompletionContext(ctx context.Context, request ChatCompletionRequest) (*ChatCompletionResponse, error) {
+	return co.client.ChatCompletionContext(ctx, request)
+}
@@ -0,0 +1,165 @@
+package inferno
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// batchServer answers /v1/embeddings and /v1/completions/batch, or 404s
+// the batch endpoint like an older server when noBatch is set, and counts
+// the requests to each path
+type batchServer struct {
+	noBatch bool
+
+	mu    sync.Mutex
+	paths map[string]int
+}
+
+func (s *batchServer) count(path string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.paths[path]
+}
+
+func (s *batchServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	if s.paths == nil {
+		s.paths = map[string]int{}
+	}
+	s.paths[r.URL.Path]++
+	s.mu.Unlock()
+
+	switch r.URL.Path {
+	case "/v1/embeddings":
+		var request EmbeddingsRequest
+		json.NewDecoder(r.Body).Decode(&request)
+		result := EmbeddingsResponse{Model: request.Model}
+		for i, text := range request.Input {
+			result.Data = append(result.Data, EmbeddingData{Embedding: []float32{float32(len(text))}, Index: i})
+		}
+		json.NewEncoder(w).Encode(result)
+	case "/v1/completions/batch":
+		if s.noBatch {
+			http.NotFound(w, r)
+			return
+		}
+		var batch completionBatchRequest
+		json.NewDecoder(r.Body).Decode(&batch)
+		var result completionBatchResponse
+		for i, request := range batch.Requests {
+			result.Data = append(result.Data, completionBatchItem{
+				Index:    i,
+				Response: &InferenceResponse{Model: batch.Model, Choices: []Choice{{Text: "re: " + request.Prompt}}},
+			})
+		}
+		json.NewEncoder(w).Encode(result)
+	case "/v1/completions":
+		var request InferenceRequest
+		json.NewDecoder(r.Body).Decode(&request)
+		json.NewEncoder(w).Encode(InferenceResponse{Model: request.Model, Choices: []Choice{{Text: "re: " + request.Prompt}}})
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func TestCoalescerCombinesEmbeddings(t *testing.T) {
+	server := &batchServer{}
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	co := NewCoalescer(NewClient(ts.URL, ""), time.Second)
+	co.MaxBatch = 3
+	texts := []string{"a", "bb", "ccc"}
+
+	var wg sync.WaitGroup
+	results := make([]*EmbeddingsResponse, len(texts))
+	errs := make([]error, len(texts))
+	for i, text := range texts {
+		wg.Add(1)
+		go func(i int, text string) {
+			defer wg.Done()
+			results[i], errs[i] = co.EmbeddingsContext(context.Background(), EmbeddingsRequest{Model: "bge", Input: []string{text}})
+		}(i, text)
+	}
+	wg.Wait()
+
+	if n := server.count("/v1/embeddings"); n != 1 {
+		t.Fatalf("sent %d requests to /v1/embeddings, want 1", n)
+	}
+	for i, text := range texts {
+		if errs[i] != nil {
+			t.Fatal(errs[i])
+		}
+		data := results[i].Data
+		if len(data) != 1 || data[0].Index != 0 || data[0].Embedding[0] != float32(len(text)) {
+			t.Errorf("call %d got %+v, want its own embedding at index 0", i, data)
+		}
+	}
+}
+
+func TestCoalescerBatchesCompletions(t *testing.T) {
+	server := &batchServer{}
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	co := NewCoalescer(NewClient(ts.URL, ""), time.Second)
+	co.MaxBatch = 4
+	var wg sync.WaitGroup
+	texts := make([]string, 4)
+	for i := range texts {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			response, err := co.InferenceContext(context.Background(), InferenceRequest{Model: "llama", Prompt: fmt.Sprint(i)})
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			texts[i] = response.Choices[0].Text
+		}(i)
+	}
+	wg.Wait()
+
+	if n := server.count("/v1/completions/batch"); n != 1 {
+		t.Errorf("sent %d requests to /v1/completions/batch, want 1", n)
+	}
+	if n := server.count("/v1/completions"); n != 0 {
+		t.Errorf("sent %d single completions, want none", n)
+	}
+	for i, text := range texts {
+		if text != fmt.Sprintf("re: %d", i) {
+			t.Errorf("call %d got %q", i, text)
+		}
+	}
+}
+
+func TestCoalescerFallsBackWithoutBatchEndpoint(t *testing.T) {
+	server := &batchServer{noBatch: true}
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	co := NewCoalescer(NewClient(ts.URL, ""), time.Millisecond)
+	for i := 0; i < 2; i++ {
+		response, err := co.InferenceContext(context.Background(), InferenceRequest{Model: "llama", Prompt: "hi"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if response.Choices[0].Text != "re: hi" {
+			t.Errorf("got %q", response.Choices[0].Text)
+		}
+	}
+
+	// Once the batch endpoint is known to be missing it is not tried again
+	if n := server.count("/v1/completions/batch"); n != 1 {
+		t.Errorf("sent %d requests to /v1/completions/batch, want 1", n)
+	}
+	if n := server.count("/v1/completions"); n != 2 {
+		t.Errorf("sent %d single completions, want 2", n)
+	}
+}
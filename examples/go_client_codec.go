@@ -0,0 +1,118 @@
+package inferno
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+
+	"github.com/vmihailenco/msgpack/v5"
+	"google.golang.org/protobuf/proto"
+)
+
+// Codec encodes request bodies and decodes response bodies. The client
+// uses JSON unless Client.Codec or WithCodec names another.
+//
+// The server reads and writes JSON only today: requests in another format
+// are refused with 415 Unsupported Media Type, and responses fall back to
+// JSON, which decodeResponse accepts whatever the codec. The binary codecs
+// are here so methods need no changes once the server speaks them.
+type Codec interface {
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+	// ContentType is the media type of encoded bodies, sent as
+	// Content-Type and Accept
+	ContentType() string
+}
+
+// JSONCodec is the default codec
+type JSONCodec struct{}
+
+func (JSONCodec) Marshal(v interface{}) ([]byte, error)      { return json.Marshal(v) }
+func (JSONCodec) Unmarshal(data []byte, v interface{}) error { return json.Unmarshal(data, v) }
+func (JSONCodec) ContentType() string                        { return "application/json" }
+
+// MsgpackCodec encodes MessagePack. Struct fields keep their json tags as
+// names, so every request and response type works unchanged.
+type MsgpackCodec struct{}
+
+func (MsgpackCodec) Marshal(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := msgpack.NewEncoder(&buf)
+	enc.SetCustomStructTag("json")
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (MsgpackCodec) Unmarshal(data []byte, v interface{}) error {
+	dec := msgpack.NewDecoder(bytes.NewReader(data))
+	dec.SetCustomStructTag("json")
+	return dec.Decode(v)
+}
+
+func (MsgpackCodec) ContentType() string { return "application/msgpack" }
+
+// ProtobufCodec encodes Protocol Buffers. Only generated message types can
+// be encoded, so it suits calls whose request and response are
+// proto.Message values; others fail with an error.
+type ProtobufCodec struct{}
+
+func (ProtobufCodec) Marshal(v interface{}) ([]byte, error) {
+	message, ok := v.(proto.Message)
+	if !ok {
+		return nil, fmt.Errorf("inferno: protobuf codec cannot encode %T", v)
+	}
+	return proto.Marshal(message)
+}
+
+func (ProtobufCodec) Unmarshal(data []byte, v interface{}) error {
+	message, ok := v.(proto.Message)
+	if !ok {
+		return fmt.Errorf("inferno: protobuf codec cannot decode into %T", v)
+	}
+	return proto.Unmarshal(data, message)
+}
+
+func (ProtobufCodec) ContentType() string { return "application/x-protobuf" }
+
+// codecKey carries the codec of a call, set by WithCodec or from
+// Client.Codec, so decodeResponse can tell from the response alone
+type codecKey struct{}
+
+// WithCodec returns a context whose requests use codec instead of the
+// client's
+func WithCodec(ctx context.Context, codec Codec) context.Context {
+	return context.WithValue(ctx, codecKey{}, codec)
+}
+
+// codecFor returns the codec requests made with ctx use
+func (c *Client) codecFor(ctx context.Context) Codec {
+	if codec, ok := ctx.Value(codecKey{}).(Codec); ok && codec != nil {
+		return codec
+	}
+	if c.Codec != nil {
+		return c.Codec
+	}
+	return JSONCodec{}
+}
+
+// responseCodec returns the codec for resp's body: the request's codec
+// when the server answered in its format, JSON otherwise
+func responseCodec(resp *http.Response) Codec {
+	if resp.Request == nil {
+		return JSONCodec{}
+	}
+	codec, ok := resp.Request.Context().Value(codecKey{}).(Codec)
+	if !ok || codec == nil {
+		return JSONCodec{}
+	}
+	mediaType, _, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if err != nil || mediaType != codec.ContentType() {
+		return JSONCodec{}
+	}
+	return codec
+}
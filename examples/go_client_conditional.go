@@ -0,0 +1,118 @@
+package inferno
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// conditionalCacheSize bounds how many responses getConditional keeps; the
+// least recently validated is dropped first
+const conditionalCacheSize = 32
+
+// conditionalEntry is a response body kept for revalidation
+type conditionalEntry struct {
+	etag      string
+	body      []byte
+	validated time.Time
+}
+
+// ModelMetadata is what /v1/models/{id}/metadata reports about a model file
+type ModelMetadata struct {
+	ID          string    `json:"id"`
+	Object      string    `json:"object"`
+	Format      string    `json:"format"`
+	BackendType string    `json:"backend_type"`
+	SizeBytes   uint64    `json:"size_bytes"`
+	Modified    time.Time `json:"modified"`
+	Checksum    *string   `json:"checksum"`
+	// GGUF is parsed from the file header; nil for other formats
+	GGUF     *GGUFMetadata     `json:"gguf,omitempty"`
+	Metadata map[string]string `json:"metadata"`
+	// Verification is the last VerifyModel result, without Model and Path;
+	// nil if the model was never verified
+	Verification *ModelVerification `json:"verification"`
+}
+
+type GGUFMetadata struct {
+	Architecture   string `json:"architecture"`
+	ParameterCount uint64 `json:"parameter_count"`
+	Quantization   string `json:"quantization"`
+	ContextLength  uint32 `json:"context_length"`
+}
+
+// ModelMetadata returns a model's format, size, GGUF header and
+// verification. Like OpenAIModels it is revalidated with If-None-Match, so
+// polling an unchanged model costs no body transfer.
+func (c *Client) ModelMetadata(ctx context.Context, modelID string) (*ModelMetadata, error) {
+	var metadata ModelMetadata
+	endpoint := "/v1/models/" + url.PathEscape(modelID) + "/metadata"
+	if err := c.getConditional(ctx, endpoint, &metadata); err != nil {
+		return nil, err
+	}
+	return &metadata, nil
+}
+
+// getConditional GETs endpoint, sending the ETag of the last response it
+// returned. On 304 Not Modified the kept body is decoded instead, so a
+// poller only downloads a catalog when it changes.
+func (c *Client) getConditional(ctx context.Context, endpoint string, out interface{}) error {
+	req, err := c.newRequest(ctx, "GET", endpoint, nil)
+	if err != nil {
+		return err
+	}
+
+	c.conditionalMu.Lock()
+	cached, ok := c.conditional[endpoint]
+	c.conditionalMu.Unlock()
+	if ok {
+		req.Header.Set("If-None-Match", cached.etag)
+	}
+
+	resp, err := c.send(c.HTTPClient, req)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case resp.StatusCode == http.StatusNotModified && ok:
+		resp.Body.Close()
+		c.storeConditional(endpoint, cached.etag, cached.body)
+		resp.StatusCode = http.StatusOK
+		resp.Body = io.NopCloser(bytes.NewReader(cached.body))
+	case resp.StatusCode == http.StatusOK && resp.Header.Get("ETag") != "":
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return err
+		}
+		c.storeConditional(endpoint, resp.Header.Get("ETag"), body)
+		resp.Body = io.NopCloser(bytes.NewReader(body))
+	}
+
+	return decodeResponse(resp, out)
+}
+
+// storeConditional keeps body under endpoint, dropping the least recently
+// validated entry when the cache is full
+func (c *Client) storeConditional(endpoint, etag string, body []byte) {
+	c.conditionalMu.Lock()
+	defer c.conditionalMu.Unlock()
+
+	if c.conditional == nil {
+		c.conditional = map[string]conditionalEntry{}
+	}
+	if _, ok := c.conditional[endpoint]; !ok && len(c.conditional) >= conditionalCacheSize {
+		var oldest string
+		for key, entry := range c.conditional {
+			if oldest == "" || entry.validated.Before(c.conditional[oldest].validated) {
+				oldest = key
+			}
+		}
+		delete(c.conditional, oldest)
+	}
+	c.conditional[endpoint] = conditionalEntry{etag: etag, body: body, validated: time.Now()}
+}
@@ -0,0 +1,157 @@
+package inferno
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"time"
+
+	"inferno-example/infernoprompt"
+)
+
+// chatMessageOverhead approximates the tokens a chat template adds around
+// each message
+const chatMessageOverhead = 4
+
+// ModelPricing is a model's price per million tokens
+type ModelPricing struct {
+	Object               string  `json:"object"`
+	Model                string  `json:"model"`
+	Currency             string  `json:"currency"`
+	PromptPerMillion     float64 `json:"prompt_per_million"`
+	CompletionPerMillion float64 `json:"completion_per_million"`
+	// DefaultMaxTokens bounds the completion of requests without MaxTokens
+	DefaultMaxTokens int       `json:"default_max_tokens"`
+	UpdatedAt        time.Time `json:"updated_at"`
+}
+
+// PricingRule is what SetModelPricing sets
+type PricingRule struct {
+	// Currency defaults to USD on the server
+	Currency             string  `json:"currency,omitempty"`
+	PromptPerMillion     float64 `json:"prompt_per_million"`
+	CompletionPerMillion float64 `json:"completion_per_million"`
+}
+
+// CostEstimate is the most a request may cost: its prompt tokens and the
+// completion tokens it allows, at the model's prices
+type CostEstimate struct {
+	Model               string
+	PromptTokens        int
+	MaxCompletionTokens int
+	// Priced is false when the server has no prices for the model; the
+	// costs are then zero and only the token counts are meaningful
+	Priced            bool
+	Currency          string
+	PromptCost        float64
+	MaxCompletionCost float64
+	MaxCost           float64
+}
+
+// ModelPricing returns the prices set for model, or nil when it has none
+func (c *Client) ModelPricing(ctx context.Context, model string) (*ModelPricing, error) {
+	var pricing ModelPricing
+	err := c.getConditional(ctx, pricingPath(model), &pricing)
+	var apiErr *APIError
+	if errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &pricing, nil
+}
+
+// SetModelPricing sets a model's prices for cost estimates
+func (a *AdminClient) SetModelPricing(ctx context.Context, model string, rule PricingRule) (*ModelPricing, error) {
+	var pricing ModelPricing
+	if err := a.adminRequest(ctx, "PUT", pricingPath(model), rule, &pricing); err != nil {
+		return nil, err
+	}
+	return &pricing, nil
+}
+
+// DeleteModelPricing drops a model's prices
+func (a *AdminClient) DeleteModelPricing(ctx context.Context, model string) error {
+	return a.adminRequest(ctx, "DELETE", pricingPath(model), nil, nil)
+}
+
+func pricingPath(model string) string {
+	return "/v1/models/" + url.PathEscape(model) + "/pricing"
+}
+
+// EstimateCost estimates request's cost before it is sent, counting the
+// prompt with the model's tokenizer on the server. Use it to hold calls to
+// a budget.
+func (c *Client) EstimateCost(ctx context.Context, request InferenceRequest) (*CostEstimate, error) {
+	return c.EstimateCostWith(ctx, request, c.TokenCounter(request.Model))
+}
+
+// EstimateCostWith estimates request's cost counting the prompt with
+// counter, such as infernoprompt.Estimate to avoid a round trip
+func (c *Client) EstimateCostWith(ctx context.Context, request InferenceRequest, counter infernoprompt.Counter) (*CostEstimate, error) {
+	promptTokens := len(request.InputIDs)
+	if promptTokens == 0 {
+		counts, err := counter.CountTokens(ctx, []string{request.Prompt})
+		if err != nil {
+			return nil, err
+		}
+		promptTokens = counts[0]
+	}
+	return c.estimate(ctx, request.Model, promptTokens, request.MaxTokens)
+}
+
+// EstimateChatCost estimates a chat request's cost, counting message
+// contents with counter (the server's tokenizer when nil) plus an allowance
+// for the chat template
+func (c *Client) EstimateChatCost(ctx context.Context, request ChatCompletionRequest, counter infernoprompt.Counter) (*CostEstimate, error) {
+	if counter == nil {
+		counter = c.TokenCounter(request.Model)
+	}
+	texts := make([]string, len(request.Messages))
+	for i, message := range request.Messages {
+		texts[i] = message.Content
+	}
+	counts, err := counter.CountTokens(ctx, texts)
+	if err != nil {
+		return nil, err
+	}
+	promptTokens := 0
+	for _, count := range counts {
+		promptTokens += count + chatMessageOverhead
+	}
+
+	maxTokens := 0
+	if request.MaxTokens != nil {
+		maxTokens = *request.MaxTokens
+	}
+	return c.estimate(ctx, request.Model, promptTokens, maxTokens)
+}
+
+// estimate prices promptTokens and maxTokens of completion, or the
+// server's default completion length when maxTokens is zero
+func (c *Client) estimate(ctx context.Context, model string, promptTokens, maxTokens int) (*CostEstimate, error) {
+	pricing, err := c.ModelPricing(ctx, model)
+	if err != nil {
+		return nil, err
+	}
+
+	estimate := &CostEstimate{
+		Model:               model,
+		PromptTokens:        promptTokens,
+		MaxCompletionTokens: maxTokens,
+	}
+	if pricing == nil {
+		return estimate, nil
+	}
+	if maxTokens <= 0 {
+		estimate.MaxCompletionTokens = pricing.DefaultMaxTokens
+	}
+	estimate.Priced = true
+	estimate.Currency = pricing.Currency
+	estimate.PromptCost = float64(estimate.PromptTokens) * pricing.PromptPerMillion / 1e6
+	estimate.MaxCompletionCost = float64(estimate.MaxCompletionTokens) * pricing.CompletionPerMillion / 1e6
+	estimate.MaxCost = estimate.PromptCost + estimate.MaxCompletionCost
+	return estimate, nil
+}
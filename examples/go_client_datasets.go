@@ -0,0 +1,204 @@
+package inferno
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"time"
+)
+
+// Dataset structures
+type DatasetValidationError struct {
+	Line    uint64 `json:"line"`
+	Message string `json:"message"`
+}
+
+type DatasetValidation struct {
+	Valid          bool                     `json:"valid"`
+	Records        uint64                   `json:"records"`
+	InvalidRecords uint64                   `json:"invalid_records"`
+	Errors         []DatasetValidationError `json:"errors"`
+}
+
+type DatasetVersion struct {
+	Name       string            `json:"name"`
+	Version    int               `json:"version"`
+	Bytes      uint64            `json:"bytes"`
+	SHA256     string            `json:"sha256"`
+	CreatedAt  time.Time         `json:"created_at"`
+	Validation DatasetValidation `json:"validation"`
+	Path       string            `json:"path"`
+}
+
+// Reference returns the name@vN form accepted as a fine-tuning dataset
+func (v *DatasetVersion) Reference() string {
+	return fmt.Sprintf("%s@v%d", v.Name, v.Version)
+}
+
+type Dataset struct {
+	Name     string           `json:"name"`
+	Versions []DatasetVersion `json:"versions"`
+}
+
+// Latest returns the newest version
+func (d *Dataset) Latest() *DatasetVersion {
+	if len(d.Versions) == 0 {
+		return nil
+	}
+	return &d.Versions[len(d.Versions)-1]
+}
+
+type DatasetsResponse struct {
+	Object string    `json:"object"`
+	Data   []Dataset `json:"data"`
+}
+
+type DatasetSampleResponse struct {
+	Object       string                   `json:"object"`
+	Data         []map[string]interface{} `json:"data"`
+	TotalRecords uint64                   `json:"total_records"`
+}
+
+func datasetEndpoint(name string) string {
+	return "/v1/datasets/" + url.PathEscape(name)
+}
+
+// datasetVersionEndpoint accepts a version number or 0 for the latest
+func datasetVersionEndpoint(name string, version int) string {
+	selector := "latest"
+	if version > 0 {
+		selector = fmt.Sprint(version)
+	}
+	return datasetEndpoint(name) + "/versions/" + selector
+}
+
+// UploadDataset streams JSONL chat records from r to the server as a new
+// version of the named dataset. The body is sent chunked and never held in
+// memory, so multi-gigabyte files are fine; ctx bounds the upload instead of
+// HTTPClient.Timeout. The returned version carries the validation report.
+// Requires the admin token.
+func (c *Client) UploadDataset(ctx context.Context, name string, r io.Reader) (*DatasetVersion, error) {
+	return c.uploadDataset(ctx, name, r, -1)
+}
+
+// UploadDatasetFile uploads the file at path like UploadDataset, sending its
+// length up front. Requires the admin token.
+func (c *Client) UploadDatasetFile(ctx context.Context, name, path string) (*DatasetVersion, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	return c.uploadDataset(ctx, name, file, info.Size())
+}
+
+func (c *Client) uploadDataset(ctx context.Context, name string, r io.Reader, size int64) (*DatasetVersion, error) {
+	req, err := c.newRequest(ctx, "PUT", datasetEndpoint(name), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Body = io.NopCloser(r)
+	req.ContentLength = size
+	req.Header.Set("Content-Type", "application/jsonl")
+
+	httpClient := *c.HTTPClient
+	httpClient.Timeout = 0
+	resp, err := c.send(&httpClient, req)
+	if err != nil {
+		return nil, err
+	}
+
+	var version DatasetVersion
+	if err := decodeResponse(resp, &version); err != nil {
+		return nil, err
+	}
+
+	return &version, nil
+}
+
+// Datasets lists all datasets with their versions
+func (c *Client) Datasets() ([]Dataset, error) {
+	resp, err := c.Request("GET", "/v1/datasets", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var result DatasetsResponse
+	if err := decodeResponse(resp, &result); err != nil {
+		return nil, err
+	}
+
+	return result.Data, nil
+}
+
+// Dataset returns one dataset's versions, oldest first
+func (c *Client) Dataset(name string) (*Dataset, error) {
+	resp, err := c.Request("GET", datasetEndpoint(name), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var dataset Dataset
+	if err := decodeResponse(resp, &dataset); err != nil {
+		return nil, err
+	}
+
+	return &dataset, nil
+}
+
+// DatasetVersion returns one version and its validation report; version 0
+// selects the latest
+func (c *Client) DatasetVersion(name string, version int) (*DatasetVersion, error) {
+	resp, err := c.Request("GET", datasetVersionEndpoint(name, version), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var result DatasetVersion
+	if err := decodeResponse(resp, &result); err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+// SampleDataset returns up to n records (at most 100) chosen at random from
+// a version; the same seed returns the same records. Version 0 selects the
+// latest.
+func (c *Client) SampleDataset(name string, version, n int, seed uint64) (*DatasetSampleResponse, error) {
+	query := url.Values{}
+	query.Set("n", fmt.Sprint(n))
+	query.Set("seed", fmt.Sprint(seed))
+
+	endpoint := datasetVersionEndpoint(name, version) + "/sample?" + query.Encode()
+	resp, err := c.Request("GET", endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var result DatasetSampleResponse
+	if err := decodeResponse(resp, &result); err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+// DeleteDataset removes every version of a dataset. Requires the admin
+// token.
+func (c *Client) DeleteDataset(name string) error {
+	resp, err := c.Request("DELETE", datasetEndpoint(name), nil)
+	if err != nil {
+		return err
+	}
+
+	return decodeResponse(resp, nil)
+}
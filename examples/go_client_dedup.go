@@ -0,0 +1,129 @@
+package inferno
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// dedupIgnoredHeaders differ between otherwise identical calls without
+// changing what the server does, so they are left out of the key
+var dedupIgnoredHeaders = map[string]bool{
+	http.CanonicalHeaderKey(RequestIDHeader): true,
+	"Traceparent":                            true,
+	"Tracestate":                             true,
+}
+
+// inflightCall is a request being sent on behalf of every caller that made
+// it; its fields are set before done is closed
+type inflightCall struct {
+	done chan struct{}
+	resp *http.Response
+	body []byte
+	err  error
+}
+
+// response returns a copy of the shared response for req, with a body of
+// its own
+func (call *inflightCall) response(req *http.Request) *http.Response {
+	resp := *call.resp
+	resp.Header = call.resp.Header.Clone()
+	resp.Body = io.NopCloser(bytes.NewReader(call.body))
+	resp.ContentLength = int64(len(call.body))
+	resp.Request = req
+	return &resp
+}
+
+// dedupKey returns the key identical requests share, and false for requests
+// not deduplicated: all of them unless Deduplicate is set, and streams and
+// long-running calls, which do not use the client's own HTTPClient
+func (c *Client) dedupKey(httpClient *http.Client, req *http.Request) (string, bool) {
+	if !c.Deduplicate || httpClient != c.HTTPClient {
+		return "", false
+	}
+	h := sha256.New()
+	io.WriteString(h, req.Method+" "+req.URL.String()+"\n")
+
+	names := make([]string, 0, len(req.Header))
+	for name := range req.Header {
+		if !dedupIgnoredHeaders[name] {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		io.WriteString(h, name+": "+strings.Join(req.Header[name], ", ")+"\n")
+	}
+
+	if req.Body != nil && req.Body != http.NoBody {
+		if req.GetBody == nil {
+			return "", false
+		}
+		body, err := req.GetBody()
+		if err != nil {
+			return "", false
+		}
+		_, err = io.Copy(h, body)
+		body.Close()
+		if err != nil {
+			return "", false
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil)), true
+}
+
+// sendShared sends req unless an identical request is already in flight,
+// in which case it waits for that one's response. If the request in flight
+// fails because its own caller gave up, a waiter whose context is still
+// live sends req itself.
+func (c *Client) sendShared(httpClient *http.Client, req *http.Request, key string) (*http.Response, error) {
+	c.inflightMu.Lock()
+	if call, ok := c.inflight[key]; ok {
+		c.inflightMu.Unlock()
+		c.emit(ClientEvent{Type: EventRequestDeduplicated, Method: req.Method, URL: req.URL.String()})
+		select {
+		case <-call.done:
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		}
+		if call.err != nil {
+			if errors.Is(call.err, context.Canceled) || errors.Is(call.err, context.DeadlineExceeded) {
+				return c.sendDirect(httpClient, req)
+			}
+			return nil, call.err
+		}
+		return call.response(req), nil
+	}
+	call := &inflightCall{done: make(chan struct{})}
+	if c.inflight == nil {
+		c.inflight = map[string]*inflightCall{}
+	}
+	c.inflight[key] = call
+	c.inflightMu.Unlock()
+
+	defer func() {
+		c.inflightMu.Lock()
+		delete(c.inflight, key)
+		c.inflightMu.Unlock()
+		close(call.done)
+	}()
+
+	resp, err := c.sendDirect(httpClient, req)
+	if err != nil {
+		call.err = err
+		return nil, err
+	}
+	call.body, call.err = io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if call.err != nil {
+		return nil, call.err
+	}
+	call.resp = resp
+	return call.response(req), nil
+}
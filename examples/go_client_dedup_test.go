@@ -0,0 +1,129 @@
+package inferno
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// heldServer answers embeddings requests once release is closed, counting
+// the requests it receives
+type heldServer struct {
+	release chan struct{}
+	hits    int32
+}
+
+func (s *heldServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	atomic.AddInt32(&s.hits, 1)
+	<-s.release
+	var request EmbeddingsRequest
+	json.NewDecoder(r.Body).Decode(&request)
+	result := EmbeddingsResponse{Model: request.Model}
+	for i, text := range request.Input {
+		result.Data = append(result.Data, EmbeddingData{Embedding: []float32{float32(len(text))}, Index: i})
+	}
+	json.NewEncoder(w).Encode(result)
+}
+
+func TestDeduplicateSharesOneResponse(t *testing.T) {
+	server := &heldServer{release: make(chan struct{})}
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	client := NewClient(ts.URL, "")
+	client.Deduplicate = true
+	const callers = 4
+	var waiting sync.WaitGroup
+	waiting.Add(callers - 1)
+	unsubscribe := client.Subscribe(func(ClientEvent) { waiting.Done() }, EventRequestDeduplicated)
+	defer unsubscribe()
+
+	var wg sync.WaitGroup
+	results := make([]*EmbeddingsResponse, callers)
+	errs := make([]error, callers)
+	for i := 0; i < callers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i], errs[i] = client.EmbeddingsContext(context.Background(), EmbeddingsRequest{Model: "bge", Input: []string{"abc"}})
+		}(i)
+	}
+	// Every caller but the one sending waits on its response
+	waiting.Wait()
+	close(server.release)
+	wg.Wait()
+
+	if hits := atomic.LoadInt32(&server.hits); hits != 1 {
+		t.Errorf("server got %d requests, want 1", hits)
+	}
+	for i := range results {
+		if errs[i] != nil {
+			t.Fatal(errs[i])
+		}
+		if results[i].Data[0].Embedding[0] != 3 {
+			t.Errorf("caller %d got %+v", i, results[i].Data)
+		}
+	}
+}
+
+func TestDeduplicateKeepsDifferentRequestsApart(t *testing.T) {
+	server := &heldServer{release: make(chan struct{})}
+	close(server.release)
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	client := NewClient(ts.URL, "")
+	client.Deduplicate = true
+	var wg sync.WaitGroup
+	for _, text := range []string{"a", "bb"} {
+		wg.Add(1)
+		go func(text string) {
+			defer wg.Done()
+			result, err := client.EmbeddingsContext(context.Background(), EmbeddingsRequest{Model: "bge", Input: []string{text}})
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if result.Data[0].Embedding[0] != float32(len(text)) {
+				t.Errorf("%q got another request's response %+v", text, result.Data)
+			}
+		}(text)
+	}
+	wg.Wait()
+
+	if hits := atomic.LoadInt32(&server.hits); hits != 2 {
+		t.Errorf("server got %d requests, want 2", hits)
+	}
+}
+
+func TestDedupKeyIgnoresRequestID(t *testing.T) {
+	client := NewClient("http://inferno.test", "")
+	client.Deduplicate = true
+
+	key := func(requestID string) string {
+		req, err := client.newRequest(context.Background(), "POST", "/v1/embeddings", EmbeddingsRequest{Model: "bge", Input: []string{"a"}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set(RequestIDHeader, requestID)
+		key, ok := client.dedupKey(client.HTTPClient, req)
+		if !ok {
+			t.Fatal("request was not deduplicated")
+		}
+		return key
+	}
+	if key("req_1") != key("req_2") {
+		t.Error("requests differing only in X-Request-ID got different keys")
+	}
+
+	// Streams and long-running calls use another HTTPClient and are never
+	// shared
+	req, _ := client.newRequest(context.Background(), "GET", "/v1/models", nil)
+	if _, ok := client.dedupKey(&http.Client{}, req); ok {
+		t.Error("a request on another HTTPClient was deduplicated")
+	}
+}
@@ -0,0 +1,171 @@
+package inferno
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"time"
+)
+
+// Distillation structures
+type DistillationRequest struct {
+	TeacherModel     string               `json:"teacher_model"`
+	StudentModel     string               `json:"student_model"`
+	Prompts          []string             `json:"prompts"`
+	SystemPrompt     *string              `json:"system_prompt,omitempty"`
+	SamplesPerPrompt int                  `json:"samples_per_prompt,omitempty"`
+	Temperature      *float32             `json:"temperature,omitempty"`
+	MaxTokens        int                  `json:"max_tokens,omitempty"`
+	DatasetName      *string              `json:"dataset_name,omitempty"`
+	Hyperparameters  *LoraHyperparameters `json:"hyperparameters,omitempty"`
+	Suffix           *string              `json:"suffix,omitempty"`
+	// Register defaults to true on the server
+	Register *bool `json:"register,omitempty"`
+}
+
+type PipelineStage struct {
+	// Name is "generate" or "train"
+	Name       string     `json:"name"`
+	Status     string     `json:"status"`
+	Completed  uint64     `json:"completed"`
+	Total      *uint64    `json:"total,omitempty"`
+	Failed     uint64     `json:"failed"`
+	StartedAt  *time.Time `json:"started_at,omitempty"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+	Error      *string    `json:"error,omitempty"`
+}
+
+// Fraction returns how much of the stage is done, or -1 while the total is
+// unknown
+func (s *PipelineStage) Fraction() float64 {
+	if s.Total == nil || *s.Total == 0 {
+		return -1
+	}
+	return float64(s.Completed) / float64(*s.Total)
+}
+
+type DistillationJob struct {
+	ID              string          `json:"id"`
+	TeacherModel    string          `json:"teacher_model"`
+	StudentModel    string          `json:"student_model"`
+	Status          string          `json:"status"`
+	Stages          []PipelineStage `json:"stages"`
+	Dataset         *string         `json:"dataset,omitempty"`
+	FineTuningJobID *string         `json:"fine_tuning_job_id,omitempty"`
+	FineTunedModel  *string         `json:"fine_tuned_model,omitempty"`
+	CreatedAt       time.Time       `json:"created_at"`
+	FinishedAt      *time.Time      `json:"finished_at,omitempty"`
+	Error           *string         `json:"error,omitempty"`
+}
+
+// Done reports whether the job has reached a terminal state
+func (j *DistillationJob) Done() bool {
+	switch j.Status {
+	case "succeeded", "failed", "cancelled":
+		return true
+	}
+	return false
+}
+
+// Stage returns the named stage, if the job has it
+func (j *DistillationJob) Stage(name string) *PipelineStage {
+	for i := range j.Stages {
+		if j.Stages[i].Name == name {
+			return &j.Stages[i]
+		}
+	}
+	return nil
+}
+
+type DistillationJobsResponse struct {
+	Object string            `json:"object"`
+	Data   []DistillationJob `json:"data"`
+}
+
+func distillationJobEndpoint(id string) string {
+	return "/v1/distillation/jobs/" + url.PathEscape(id)
+}
+
+// CreateDistillationJob starts a teacher-to-student pipeline and returns
+// immediately; poll with DistillationJob or block with WaitForDistillation.
+// Requires the admin token.
+func (c *Client) CreateDistillationJob(req DistillationRequest) (*DistillationJob, error) {
+	return c.distillationJobRequest("POST", "/v1/distillation/jobs", req)
+}
+
+// DistillationJobs lists jobs, newest first. Requires the admin token.
+func (c *Client) DistillationJobs() ([]DistillationJob, error) {
+	resp, err := c.Request("GET", "/v1/distillation/jobs", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var result DistillationJobsResponse
+	if err := decodeResponse(resp, &result); err != nil {
+		return nil, err
+	}
+
+	return result.Data, nil
+}
+
+// DistillationJob returns a job with the status of each stage. Requires the
+// admin token.
+func (c *Client) DistillationJob(id string) (*DistillationJob, error) {
+	return c.distillationJobRequest("GET", distillationJobEndpoint(id), nil)
+}
+
+// CancelDistillationJob stops whichever stage is running. Requires the
+// admin token.
+func (c *Client) CancelDistillationJob(id string) (*DistillationJob, error) {
+	return c.distillationJobRequest("POST", distillationJobEndpoint(id)+"/cancel", nil)
+}
+
+func (c *Client) distillationJobRequest(method, endpoint string, body interface{}) (*DistillationJob, error) {
+	resp, err := c.Request(method, endpoint, body)
+	if err != nil {
+		return nil, err
+	}
+
+	var job DistillationJob
+	if err := decodeResponse(resp, &job); err != nil {
+		return nil, err
+	}
+
+	return &job, nil
+}
+
+// WaitForDistillation polls a job with exponential backoff until it
+// finishes or ctx is done, calling progress (if non-nil) after every poll so
+// callers can report each stage. A failed job is returned along with an
+// error. Requires the admin token.
+func (c *Client) WaitForDistillation(ctx context.Context, id string, progress func(*DistillationJob)) (*DistillationJob, error) {
+	delay := jobPollInitial
+
+	for {
+		job, err := c.DistillationJob(id)
+		if err != nil {
+			return nil, err
+		}
+		if progress != nil {
+			progress(job)
+		}
+
+		if job.Done() {
+			if job.Status == "failed" && job.Error != nil {
+				return job, fmt.Errorf("distillation job %s failed: %s", id, *job.Error)
+			}
+			return job, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(delay):
+		}
+
+		delay *= 2
+		if delay > jobPollMax {
+			delay = jobPollMax
+		}
+	}
+}
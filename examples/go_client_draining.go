@@ -0,0 +1,182 @@
+package inferno
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// DrainingHeader is set to "true" on every response from a draining server
+const DrainingHeader = "X-Inferno-Draining"
+
+// drainingBackoff is how long an endpoint that reported draining is passed
+// over; a drained server is usually replaced well within it
+const drainingBackoff = time.Minute
+
+// ErrServerDraining is returned by WebSocketClient.SendInference after the
+// server sent goaway; connect to another instance for new requests
+var ErrServerDraining = errors.New("inferno: server is draining")
+
+// endpoints lists BaseURL followed by the fallback Endpoints
+func (c *Client) endpoints() []string {
+	endpoints := []string{c.BaseURL}
+	for _, endpoint := range c.Endpoints {
+		endpoints = append(endpoints, strings.TrimSuffix(endpoint, "/"))
+	}
+	return endpoints
+}
+
+// baseURL returns the endpoint for requests made with ctx: of those in its
+// region (see WithRegion), or else geo-failover's active region, the ones
+// not known to be draining go to pickEndpoint. If every one is draining,
+// the region's first endpoint, or BaseURL, is used anyway.
+func (c *Client) baseURL(ctx context.Context) string {
+	region := regionFor(ctx)
+	if region == "" {
+		region = c.ActiveRegion()
+	}
+	candidates := c.regionEndpoints(region)
+	if len(candidates) == 0 {
+		return c.BaseURL
+	}
+
+	c.drainingMu.Lock()
+	now := time.Now()
+	var available []string
+	for _, endpoint := range candidates {
+		if until, ok := c.drainingUntil[endpoint]; !ok || now.After(until) {
+			available = append(available, endpoint)
+		}
+	}
+	c.drainingMu.Unlock()
+
+	if len(available) == 0 {
+		return candidates[0]
+	}
+	return c.pickEndpoint(available)
+}
+
+// markDraining passes over the endpoint req was sent to for drainingBackoff
+func (c *Client) markDraining(req *http.Request) {
+	target := req.URL.String()
+	from := ""
+	c.drainingMu.Lock()
+	for _, endpoint := range c.endpoints() {
+		if strings.HasPrefix(target, endpoint) {
+			if c.drainingUntil == nil {
+				c.drainingUntil = map[string]time.Time{}
+			}
+			// Only the first draining response moves the client off it
+			if until, ok := c.drainingUntil[endpoint]; !ok || time.Now().After(until) {
+				from = endpoint
+			}
+			c.drainingUntil[endpoint] = time.Now().Add(drainingBackoff)
+			break
+		}
+	}
+	c.drainingMu.Unlock()
+
+	if to := c.baseURL(req.Context()); from != "" && to != from {
+		c.emit(ClientEvent{Type: EventEndpointFailedOver, From: from, To: to})
+	}
+}
+
+// send performs req, noting servers that say they are draining. Their
+// responses also carry Connection: close, so net/http does not reuse the
+// connection. A request a draining server refused with 503 was never run,
+// so it is sent again to the next endpoint, if there is one and the body
+// can be replayed, time is left before its deadline and the retry budget
+// allows it. A request refused for an expired token is likewise retried
+// once with a fresh one. Subscribers see the request start, each retry and
+// the outcome. With Deduplicate, identical requests in flight at once are
+// sent once; with a Limiter, requests over its limit wait for a slot.
+func (c *Client) send(httpClient *http.Client, req *http.Request) (*http.Response, error) {
+	if key, ok := c.dedupKey(httpClient, req); ok {
+		return c.sendShared(httpClient, req, key)
+	}
+	return c.sendDirect(httpClient, req)
+}
+
+// sendAttempts is send without deduplication or the Limiter
+func (c *Client) sendAttempts(httpClient *http.Client, req *http.Request) (*http.Response, error) {
+	started := time.Now()
+	c.emit(ClientEvent{Type: EventRequestStarted, Method: req.Method, URL: req.URL.String(), Attempt: 1})
+	if c.RetryBudget != nil {
+		c.RetryBudget.recordRequest()
+	}
+
+	refreshed := false
+	for attempt := 2; ; attempt++ {
+		sent := time.Now()
+		resp, err := c.do(attemptClient(req.Context(), httpClient), req)
+		c.observe(req, resp, err, time.Since(sent))
+		if err == nil && !refreshed && tokenExpired(resp) {
+			if retry, ok := c.refreshRequest(req); ok && c.mayRetry(req) {
+				refreshed = true
+				resp.Body.Close()
+				req = retry
+				c.emit(ClientEvent{Type: EventRetryAttempted, Method: req.Method, URL: req.URL.String(), Attempt: attempt})
+				continue
+			}
+		}
+		if err != nil || resp.Header.Get(DrainingHeader) != "true" {
+			c.emitFinished(req, started, resp, err)
+			return resp, err
+		}
+
+		c.markDraining(req)
+		if resp.StatusCode != http.StatusServiceUnavailable {
+			c.emitFinished(req, started, resp, nil)
+			return resp, nil
+		}
+
+		retry, ok := c.redirectRequest(req)
+		if !ok || !c.mayRetry(req) {
+			c.emitFinished(req, started, resp, nil)
+			return resp, nil
+		}
+		resp.Body.Close()
+		req = retry
+		c.emit(ClientEvent{Type: EventRetryAttempted, Method: req.Method, URL: req.URL.String(), Attempt: attempt})
+	}
+}
+
+// redirectRequest copies req onto the current endpoint, if that differs from
+// the one req went to and req's body can be sent again
+func (c *Client) redirectRequest(req *http.Request) (*http.Request, bool) {
+	base := c.baseURL(req.Context())
+	if strings.HasPrefix(req.URL.String(), base) {
+		return nil, false
+	}
+	if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+		return nil, false
+	}
+
+	// The path after the old endpoint's own prefix
+	endpoint := req.URL.RequestURI()
+	for _, candidate := range c.endpoints() {
+		if strings.HasPrefix(req.URL.String(), candidate) {
+			endpoint = strings.TrimPrefix(req.URL.String(), candidate)
+			break
+		}
+	}
+	target, err := url.Parse(base + endpoint)
+	if err != nil {
+		return nil, false
+	}
+
+	retry := req.Clone(req.Context())
+	retry.URL = target
+	retry.Host = ""
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, false
+		}
+		retry.Body = body
+	}
+	return retry, true
+}
@@ -0,0 +1,393 @@
+package inferno
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Kinds of result a ContentCache holds
+const (
+	cacheKindEmbedding = "embedding"
+	cacheKindTokens    = "tokens"
+	cacheKindCount     = "count"
+)
+
+// ContentCache stores results of deterministic calls, embeddings and
+// tokenization, under a hash of the model and the content. MemoryCache and
+// DiskCache implement it; any shared store, such as Redis, can instead.
+type ContentCache interface {
+	// Get returns the value stored under key, unless it is missing or has
+	// expired
+	Get(key string) ([]byte, bool)
+	// Set stores value under key
+	Set(key string, value []byte)
+}
+
+// contentKey is the cache key of one input: a SHA-256 over the kind of
+// result, the model, the options that change the result and the text
+func contentKey(kind, model, options, text string) string {
+	h := sha256.New()
+	for _, part := range []string{kind, model, options, text} {
+		h.Write([]byte(part))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// ContentCacheStats counts a MemoryCache's use
+type ContentCacheStats struct {
+	Entries   int
+	Bytes     int64
+	Hits      int64
+	Misses    int64
+	Evictions int64
+}
+
+type memoryEntry struct {
+	key     string
+	value   []byte
+	expires time.Time
+}
+
+// MemoryCache is a ContentCache in process memory, evicting the least
+// recently used entries past MaxEntries or MaxBytes
+type MemoryCache struct {
+	// TTL is how long entries live; zero keeps them until evicted
+	TTL time.Duration
+	// MaxEntries and MaxBytes bound the cache; zero means no bound
+	MaxEntries int
+	MaxBytes   int64
+
+	mu    sync.Mutex
+	order *list.List
+	items map[string]*list.Element
+	stats ContentCacheStats
+}
+
+// NewMemoryCache returns a cache of at most maxBytes whose entries live for
+// ttl
+func NewMemoryCache(maxBytes int64, ttl time.Duration) *MemoryCache {
+	return &MemoryCache{TTL: ttl, MaxBytes: maxBytes}
+}
+
+func (m *MemoryCache) Get(key string) ([]byte, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	element, ok := m.items[key]
+	if !ok {
+		m.stats.Misses++
+		return nil, false
+	}
+	entry := element.Value.(*memoryEntry)
+	if !entry.expires.IsZero() && time.Now().After(entry.expires) {
+		m.remove(element)
+		m.stats.Misses++
+		return nil, false
+	}
+	m.order.MoveToFront(element)
+	m.stats.Hits++
+	return entry.value, true
+}
+
+func (m *MemoryCache) Set(key string, value []byte) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.items == nil {
+		m.items = map[string]*list.Element{}
+		m.order = list.New()
+	}
+	if element, ok := m.items[key]; ok {
+		m.remove(element)
+	}
+	if m.MaxBytes > 0 && int64(len(value)) > m.MaxBytes {
+		return
+	}
+
+	entry := &memoryEntry{key: key, value: value}
+	if m.TTL > 0 {
+		entry.expires = time.Now().Add(m.TTL)
+	}
+	m.items[key] = m.order.PushFront(entry)
+	m.stats.Entries++
+	m.stats.Bytes += int64(len(value))
+
+	for (m.MaxEntries > 0 && m.stats.Entries > m.MaxEntries) || (m.MaxBytes > 0 && m.stats.Bytes > m.MaxBytes) {
+		m.remove(m.order.Back())
+		m.stats.Evictions++
+	}
+}
+
+func (m *MemoryCache) remove(element *list.Element) {
+	entry := m.order.Remove(element).(*memoryEntry)
+	delete(m.items, entry.key)
+	m.stats.Entries--
+	m.stats.Bytes -= int64(len(entry.value))
+}
+
+// Stats returns the cache's size and hit counts
+func (m *MemoryCache) Stats() ContentCacheStats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.stats
+}
+
+// DiskCache is a ContentCache keeping each entry as a file in a directory,
+// so results survive restarts and can be shared by processes on one host.
+// Entries expire TTL after they were written; once the directory grows
+// past MaxBytes, the oldest are removed.
+type DiskCache struct {
+	dir string
+	// TTL is how long entries live; zero keeps them until pruned
+	TTL time.Duration
+	// MaxBytes bounds the directory; zero means no bound
+	MaxBytes int64
+
+	mu    sync.Mutex
+	bytes int64
+}
+
+// NewDiskCache opens the cache in dir, creating dir if needed
+func NewDiskCache(dir string, maxBytes int64, ttl time.Duration) (*DiskCache, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	d := &DiskCache{dir: dir, TTL: ttl, MaxBytes: maxBytes}
+	files, err := d.files()
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range files {
+		d.bytes += file.size
+	}
+	return d, nil
+}
+
+// path shards entries over subdirectories named by the key's first byte
+func (d *DiskCache) path(key string) string {
+	return filepath.Join(d.dir, key[:2], key)
+}
+
+func (d *DiskCache) Get(key string) ([]byte, bool) {
+	path := d.path(key)
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, false
+	}
+	if d.TTL > 0 && time.Since(info.ModTime()) > d.TTL {
+		d.mu.Lock()
+		if os.Remove(path) == nil {
+			d.bytes -= info.Size()
+		}
+		d.mu.Unlock()
+		return nil, false
+	}
+	value, err := os.ReadFile(path)
+	if err != nil {
+		return nil, false
+	}
+	return value, true
+}
+
+func (d *DiskCache) Set(key string, value []byte) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	path := d.path(key)
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return
+	}
+	var replaced int64
+	if info, err := os.Stat(path); err == nil {
+		replaced = info.Size()
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), "entry-*.tmp")
+	if err != nil {
+		return
+	}
+	_, err = tmp.Write(value)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return
+	}
+
+	d.bytes += int64(len(value)) - replaced
+	if d.MaxBytes > 0 && d.bytes > d.MaxBytes {
+		d.prune()
+	}
+}
+
+type diskEntry struct {
+	path     string
+	size     int64
+	modified time.Time
+}
+
+// files lists the entries, skipping temporary files of interrupted writes
+func (d *DiskCache) files() ([]diskEntry, error) {
+	var files []diskEntry
+	err := filepath.WalkDir(d.dir, func(path string, entry os.DirEntry, err error) error {
+		if err != nil || entry.IsDir() || filepath.Ext(path) == ".tmp" {
+			return err
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return nil
+		}
+		files = append(files, diskEntry{path: path, size: info.Size(), modified: info.ModTime()})
+		return nil
+	})
+	return files, err
+}
+
+// prune removes expired entries, then the oldest, until the directory is
+// back to 90% of MaxBytes so pruning does not run on every Set
+func (d *DiskCache) prune() {
+	files, err := d.files()
+	if err != nil {
+		return
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].modified.Before(files[j].modified) })
+
+	d.bytes = 0
+	for _, file := range files {
+		d.bytes += file.size
+	}
+	target := d.MaxBytes / 10 * 9
+	for _, file := range files {
+		expired := d.TTL > 0 && time.Since(file.modified) > d.TTL
+		if !expired && d.bytes <= target {
+			continue
+		}
+		if os.Remove(file.path) == nil {
+			d.bytes -= file.size
+		}
+	}
+}
+
+// cachedEmbeddings answers what it can of request from the cache, sends
+// the rest and caches their embeddings. Usage counts only what was sent.
+func (c *Client) cachedEmbeddings(ctx context.Context, request EmbeddingsRequest) (*EmbeddingsResponse, error) {
+	cache := c.ContentCache
+	options := ""
+	if request.Dimensions != nil {
+		options = fmt.Sprint(*request.Dimensions)
+	}
+
+	result := &EmbeddingsResponse{Model: request.Model, Data: make([]EmbeddingData, len(request.Input))}
+	var missing []int
+	for i, text := range request.Input {
+		value, ok := cache.Get(contentKey(cacheKindEmbedding, request.Model, options, text))
+		if ok && json.Unmarshal(value, &result.Data[i].Embedding) == nil {
+			result.Data[i].Index = i
+			continue
+		}
+		missing = append(missing, i)
+	}
+	if len(missing) == 0 {
+		result.Usage = &Usage{}
+		return result, nil
+	}
+
+	sent := request
+	sent.Input = make([]string, len(missing))
+	for j, i := range missing {
+		sent.Input[j] = request.Input[i]
+	}
+	fetched, err := c.fetchEmbeddings(ctx, sent)
+	if err != nil {
+		return nil, err
+	}
+	result.Model = fetched.Model
+	result.Usage = fetched.Usage
+	for _, data := range fetched.Data {
+		if data.Index < 0 || data.Index >= len(missing) {
+			continue
+		}
+		i := missing[data.Index]
+		result.Data[i] = EmbeddingData{Embedding: data.Embedding, Index: i}
+		if value, err := json.Marshal(data.Embedding); err == nil {
+			cache.Set(contentKey(cacheKindEmbedding, request.Model, options, request.Input[i]), value)
+		}
+	}
+	return result, nil
+}
+
+// cachedTokenize is tokenize through the cache. Entries with token IDs
+// also answer counts; count-only entries answer only counts.
+func (c *Client) cachedTokenize(ctx context.Context, request TokenizeRequest) (*TokenizeResponse, error) {
+	cache := c.ContentCache
+	lookup := func(text string) (TokenizedText, bool) {
+		var cached TokenizedText
+		for _, kind := range []string{cacheKindTokens, cacheKindCount} {
+			if kind == cacheKindCount && !request.CountOnly {
+				break
+			}
+			if value, ok := cache.Get(contentKey(kind, request.Model, "", text)); ok && json.Unmarshal(value, &cached) == nil {
+				if request.CountOnly {
+					cached.Tokens = nil
+				}
+				return cached, true
+			}
+		}
+		return cached, false
+	}
+
+	result := &TokenizeResponse{Model: request.Model, Data: make([]TokenizedText, len(request.Input))}
+	var missing []int
+	for i, text := range request.Input {
+		if cached, ok := lookup(text); ok {
+			cached.Index = i
+			result.Data[i] = cached
+			continue
+		}
+		missing = append(missing, i)
+	}
+
+	if len(missing) > 0 {
+		sent := request
+		sent.Input = make([]string, len(missing))
+		for j, i := range missing {
+			sent.Input[j] = request.Input[i]
+		}
+		fetched, err := c.fetchTokens(ctx, sent)
+		if err != nil {
+			return nil, err
+		}
+		result.Model = fetched.Model
+		kind := cacheKindTokens
+		if request.CountOnly {
+			kind = cacheKindCount
+		}
+		for _, data := range fetched.Data {
+			if data.Index < 0 || data.Index >= len(missing) {
+				continue
+			}
+			i := missing[data.Index]
+			data.Index = i
+			result.Data[i] = data
+			if value, err := json.Marshal(TokenizedText{Count: data.Count, Tokens: data.Tokens}); err == nil {
+				cache.Set(contentKey(kind, request.Model, "", request.Input[i]), value)
+			}
+		}
+	}
+
+	for _, data := range result.Data {
+		result.TotalTokens += data.Count
+	}
+	return result, nil
+}
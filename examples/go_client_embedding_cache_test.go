@@ -0,0 +1,136 @@
+package inferno
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+// recordingServer answers embeddings and tokenize calls, keeping the path
+// and inputs of each request it was sent
+type recordingServer struct {
+	mu     sync.Mutex
+	paths  []string
+	inputs [][]string
+}
+
+func (s *recordingServer) requests() ([]string, [][]string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.paths...), append([][]string(nil), s.inputs...)
+}
+
+func (s *recordingServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Model string   `json:"model"`
+		Input []string `json:"input"`
+	}
+	json.NewDecoder(r.Body).Decode(&body)
+	s.mu.Lock()
+	s.paths = append(s.paths, r.URL.Path)
+	s.inputs = append(s.inputs, body.Input)
+	s.mu.Unlock()
+
+	switch r.URL.Path {
+	case "/v1/embeddings":
+		result := EmbeddingsResponse{Model: body.Model, Usage: &Usage{PromptTokens: len(body.Input)}}
+		for i, text := range body.Input {
+			result.Data = append(result.Data, EmbeddingData{Embedding: []float32{float32(len(text))}, Index: i})
+		}
+		json.NewEncoder(w).Encode(result)
+	case "/v1/tokenize":
+		result := TokenizeResponse{Model: body.Model}
+		for i, text := range body.Input {
+			result.Data = append(result.Data, TokenizedText{Index: i, Count: len(text)})
+		}
+		json.NewEncoder(w).Encode(result)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func TestContentCacheSendsOnlyMissingTexts(t *testing.T) {
+	server := &recordingServer{}
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	client := NewClient(ts.URL, "")
+	client.ContentCache = NewMemoryCache(0, 0)
+	ctx := context.Background()
+
+	first, err := client.EmbeddingsContext(ctx, EmbeddingsRequest{Model: "bge", Input: []string{"a", "bb"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := client.EmbeddingsContext(ctx, EmbeddingsRequest{Model: "bge", Input: []string{"bb", "ccc"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	paths, inputs := server.requests()
+	if len(paths) != 2 || paths[0] != "/v1/embeddings" || paths[1] != "/v1/embeddings" {
+		t.Fatalf("requested paths %v, want /v1/embeddings twice", paths)
+	}
+	if len(inputs[1]) != 1 || inputs[1][0] != "ccc" {
+		t.Errorf("second request sent %v, want only the uncached text", inputs[1])
+	}
+	if first.Data[1].Embedding[0] != 2 || second.Data[0].Embedding[0] != 2 || second.Data[1].Embedding[0] != 3 {
+		t.Errorf("embeddings out of order: %+v, %+v", first.Data, second.Data)
+	}
+	if second.Data[0].Index != 0 || second.Data[1].Index != 1 {
+		t.Errorf("indexes %d, %d, want 0, 1", second.Data[0].Index, second.Data[1].Index)
+	}
+
+	// A different model is a different key
+	if _, err := client.EmbeddingsContext(ctx, EmbeddingsRequest{Model: "e5", Input: []string{"a"}}); err != nil {
+		t.Fatal(err)
+	}
+	if paths, _ := server.requests(); len(paths) != 3 {
+		t.Errorf("got %d requests, want the other model's text sent", len(paths))
+	}
+}
+
+func TestContentCacheTokenCounts(t *testing.T) {
+	server := &recordingServer{}
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	client := NewClient(ts.URL, "")
+	client.ContentCache = NewMemoryCache(0, 0)
+	ctx := context.Background()
+
+	for i := 0; i < 2; i++ {
+		counts, err := client.CountTokens(ctx, "llama", "four", "hi")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if counts[0] != 4 || counts[1] != 2 {
+			t.Fatalf("counts %v, want [4 2]", counts)
+		}
+	}
+	paths, _ := server.requests()
+	if len(paths) != 1 || paths[0] != "/v1/tokenize" {
+		t.Errorf("requested paths %v, want one /v1/tokenize", paths)
+	}
+}
+
+func TestMemoryCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	cache := &MemoryCache{MaxEntries: 2}
+	cache.Set("a", []byte("1"))
+	cache.Set("b", []byte("2"))
+	cache.Get("a")
+	cache.Set("c", []byte("3"))
+
+	if _, ok := cache.Get("b"); ok {
+		t.Error("b should have been evicted")
+	}
+	if _, ok := cache.Get("a"); !ok {
+		t.Error("a was used last and should be kept")
+	}
+	if stats := cache.Stats(); stats.Entries != 2 || stats.Evictions != 1 {
+		t.Errorf("stats %+v, want 2 entries and 1 eviction", stats)
+	}
+}
@@ -0,0 +1,189 @@
+package inferno
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// The types below replace free-form strings for values the API defines.
+// Encoding one that is not listed fails, so a typo such as Role("asistant")
+// surfaces as an error instead of a request the server misreads. Decoding
+// accepts any value, so a newer server's additions do not break older
+// clients; Valid reports whether a decoded value is one this client knows.
+
+// Role is the author of a chat message
+type Role string
+
+const (
+	RoleSystem    Role = "system"
+	RoleUser      Role = "user"
+	RoleAssistant Role = "assistant"
+	RoleTool      Role = "tool"
+)
+
+func (r Role) Valid() bool {
+	switch r {
+	case RoleSystem, RoleUser, RoleAssistant, RoleTool:
+		return true
+	}
+	return false
+}
+
+func (r Role) MarshalJSON() ([]byte, error) {
+	return marshalEnum("role", string(r), r.Valid())
+}
+
+// FinishReason is why generation stopped
+type FinishReason string
+
+const (
+	// FinishStop covers EOS, a stop sequence and the token limit
+	FinishStop      FinishReason = "stop"
+	FinishToolCalls FinishReason = "tool_calls"
+	// FinishCancelled and FinishTimeout mark output cut short by a cancel
+	// request or the request's deadline
+	FinishCancelled FinishReason = "cancelled"
+	FinishTimeout   FinishReason = "timeout"
+	// FinishLength is reported by /v1/debug/logits when it stops at the
+	// token limit
+	FinishLength FinishReason = "length"
+	// FinishScored marks a completion that scored a given continuation
+	// instead of generating one
+	FinishScored FinishReason = "scored"
+)
+
+func (f FinishReason) Valid() bool {
+	switch f {
+	case FinishStop, FinishToolCalls, FinishCancelled, FinishTimeout, FinishLength, FinishScored:
+		return true
+	}
+	return false
+}
+
+func (f FinishReason) MarshalJSON() ([]byte, error) {
+	return marshalEnum("finish reason", string(f), f.Valid())
+}
+
+// ModelType is the format of a model file, which decides its backend
+type ModelType string
+
+const (
+	ModelGGUF    ModelType = "gguf"
+	ModelONNX    ModelType = "onnx"
+	ModelUnknown ModelType = "unknown"
+)
+
+func (m ModelType) Valid() bool {
+	switch m {
+	case ModelGGUF, ModelONNX, ModelUnknown:
+		return true
+	}
+	return false
+}
+
+func (m ModelType) MarshalJSON() ([]byte, error) {
+	return marshalEnum("model type", string(m), m.Valid())
+}
+
+// EncodingFormat is how embeddings are returned; empty means the server
+// default, EncodingFloat
+type EncodingFormat string
+
+const (
+	EncodingFloat EncodingFormat = "float"
+	// EncodingBase64 packs each embedding as base64 little-endian float32s
+	EncodingBase64 EncodingFormat = "base64"
+)
+
+func (e EncodingFormat) Valid() bool {
+	switch e {
+	case "", EncodingFloat, EncodingBase64:
+		return true
+	}
+	return false
+}
+
+func (e EncodingFormat) MarshalJSON() ([]byte, error) {
+	return marshalEnum("encoding format", string(e), e.Valid())
+}
+
+// OpenAIBatchStatus is the state of a batch from the OpenAI-style
+// /v1/batches API (CreateBatch)
+type OpenAIBatchStatus string
+
+const (
+	OpenAIBatchValidating OpenAIBatchStatus = "validating"
+	OpenAIBatchInProgress OpenAIBatchStatus = "in_progress"
+	OpenAIBatchFinalizing OpenAIBatchStatus = "finalizing"
+	OpenAIBatchCompleted  OpenAIBatchStatus = "completed"
+	// OpenAIBatchFailed means the input file was rejected; requests that
+	// fail individually are counted and leave the batch completed
+	OpenAIBatchFailed     OpenAIBatchStatus = "failed"
+	OpenAIBatchExpired    OpenAIBatchStatus = "expired"
+	OpenAIBatchCancelling OpenAIBatchStatus = "cancelling"
+	OpenAIBatchCancelled  OpenAIBatchStatus = "cancelled"
+)
+
+func (b OpenAIBatchStatus) Valid() bool {
+	switch b {
+	case OpenAIBatchValidating, OpenAIBatchInProgress, OpenAIBatchFinalizing, OpenAIBatchCompleted,
+		OpenAIBatchFailed, OpenAIBatchExpired, OpenAIBatchCancelling, OpenAIBatchCancelled:
+		return true
+	}
+	return false
+}
+
+// Done reports whether the batch has stopped running
+func (b OpenAIBatchStatus) Done() bool {
+	switch b {
+	case OpenAIBatchCompleted, OpenAIBatchFailed, OpenAIBatchExpired, OpenAIBatchCancelled:
+		return true
+	}
+	return false
+}
+
+func (b OpenAIBatchStatus) MarshalJSON() ([]byte, error) {
+	return marshalEnum("batch status", string(b), b.Valid())
+}
+
+// InferenceJobStatus is the state of an asynchronous inference job
+// (SubmitInference)
+type InferenceJobStatus string
+
+const (
+	InferenceJobQueued    InferenceJobStatus = "queued"
+	InferenceJobRunning   InferenceJobStatus = "running"
+	InferenceJobCompleted InferenceJobStatus = "completed"
+	InferenceJobFailed    InferenceJobStatus = "failed"
+	InferenceJobCancelled InferenceJobStatus = "cancelled"
+)
+
+func (j InferenceJobStatus) Valid() bool {
+	switch j {
+	case InferenceJobQueued, InferenceJobRunning, InferenceJobCompleted, InferenceJobFailed, InferenceJobCancelled:
+		return true
+	}
+	return false
+}
+
+// Done reports whether the job has stopped running
+func (j InferenceJobStatus) Done() bool {
+	switch j {
+	case InferenceJobCompleted, InferenceJobFailed, InferenceJobCancelled:
+		return true
+	}
+	return false
+}
+
+func (j InferenceJobStatus) MarshalJSON() ([]byte, error) {
+	return marshalEnum("inference job status", string(j), j.Valid())
+}
+
+// marshalEnum encodes value as a JSON string, refusing values its type
+// does not define
+func marshalEnum(kind, value string, valid bool) ([]byte, error) {
+	if !valid {
+		return nil, fmt.Errorf("invalid %s %q", kind, value)
+	}
+	return json.Marshal(value)
+}
@@ -0,0 +1,109 @@
+package inferno
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"strings"
+)
+
+// EncryptedPrefix marks content sealed with an envelope's data key
+const EncryptedPrefix = "enc:v1:"
+
+// Envelope is the encryption field of a sealed request: the request's data
+// key, wrapped under a key-encryption key the server also holds
+type Envelope struct {
+	KeyID        string `json:"key_id"`
+	EncryptedKey string `json:"encrypted_key"`
+	Algorithm    string `json:"algorithm"`
+}
+
+// EnvelopeKey is a key-encryption key shared with the server, which reads
+// it from INFERNO_ENVELOPE_KEYS under the same KeyID. Fetch it from your
+// KMS; the client never sends it.
+type EnvelopeKey struct {
+	KeyID string
+	// Key is the 32-byte AES-256 key
+	Key []byte
+}
+
+// SealInference encrypts request's prompt under a fresh data key, so
+// proxies and logs between here and the server see only ciphertext
+func (k *EnvelopeKey) SealInference(request *InferenceRequest) error {
+	seal, envelope, err := k.newDataKey()
+	if err != nil {
+		return err
+	}
+	if request.Prompt, err = seal(request.Prompt); err != nil {
+		return err
+	}
+	request.Encryption = envelope
+	return nil
+}
+
+// SealChat encrypts the content of each of request's messages under a
+// fresh data key; see SealInference
+func (k *EnvelopeKey) SealChat(request *ChatCompletionRequest) error {
+	seal, envelope, err := k.newDataKey()
+	if err != nil {
+		return err
+	}
+	messages := make([]ChatMessage, len(request.Messages))
+	for i, message := range request.Messages {
+		if message.Content, err = seal(message.Content); err != nil {
+			return err
+		}
+		messages[i] = message
+	}
+	request.Messages = messages
+	request.Encryption = envelope
+	return nil
+}
+
+// newDataKey generates a data key, returning a function sealing content
+// with it and the envelope carrying it wrapped under k
+func (k *EnvelopeKey) newDataKey() (func(string) (string, error), *Envelope, error) {
+	if len(k.Key) != 32 {
+		return nil, nil, fmt.Errorf("inferno: envelope key %q must be 32 bytes", k.KeyID)
+	}
+	dataKey := make([]byte, 32)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, nil, err
+	}
+	wrapped, err := sealBytes(k.Key, dataKey)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	seal := func(content string) (string, error) {
+		if content == "" || strings.HasPrefix(content, EncryptedPrefix) {
+			return content, nil
+		}
+		sealed, err := sealBytes(dataKey, []byte(content))
+		if err != nil {
+			return "", err
+		}
+		return EncryptedPrefix + sealed, nil
+	}
+	return seal, &Envelope{KeyID: k.KeyID, EncryptedKey: wrapped, Algorithm: "AES-256-GCM"}, nil
+}
+
+// sealBytes returns base64 of a random nonce followed by the AES-256-GCM
+// encryption of plaintext under key
+func sealBytes(key, plaintext []byte) (string, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return "", err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(gcm.Seal(nonce, nonce, plaintext, nil)), nil
+}
@@ -0,0 +1,259 @@
+package inferno
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"time"
+)
+
+// Eval suite structures
+type EvalCase struct {
+	ID     string `json:"id,omitempty"`
+	Prompt string `json:"prompt"`
+	// Expected is compared with the output by the exact_match, contains and
+	// regex graders
+	Expected *string `json:"expected,omitempty"`
+	// Rubric is scored by the suite's judge model for the rubric grader
+	Rubric    *string `json:"rubric,omitempty"`
+	Grader    string  `json:"grader,omitempty"`
+	MaxTokens *int    `json:"max_tokens,omitempty"`
+}
+
+type EvalSuiteSpec struct {
+	Name          string     `json:"name"`
+	Description   *string    `json:"description,omitempty"`
+	Cases         []EvalCase `json:"cases"`
+	JudgeModel    *string    `json:"judge_model,omitempty"`
+	PassThreshold *float64   `json:"pass_threshold,omitempty"`
+	MaxTokens     int        `json:"max_tokens,omitempty"`
+}
+
+type EvalSuite struct {
+	ID string `json:"id"`
+	EvalSuiteSpec
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+type EvalSuitesResponse struct {
+	Object string      `json:"object"`
+	Data   []EvalSuite `json:"data"`
+}
+
+type ModelEvalSummary struct {
+	Model     string  `json:"model"`
+	Cases     int     `json:"cases"`
+	Passed    int     `json:"passed"`
+	Errors    int     `json:"errors"`
+	PassRate  float64 `json:"pass_rate"`
+	MeanScore float64 `json:"mean_score"`
+}
+
+type EvalRun struct {
+	ID         string             `json:"id"`
+	SuiteID    string             `json:"suite_id"`
+	Models     []string           `json:"models"`
+	Status     string             `json:"status"`
+	CreatedAt  time.Time          `json:"created_at"`
+	StartedAt  *time.Time         `json:"started_at,omitempty"`
+	FinishedAt *time.Time         `json:"finished_at,omitempty"`
+	Error      *string            `json:"error,omitempty"`
+	Summary    []ModelEvalSummary `json:"summary"`
+}
+
+// Done reports whether the run has reached a terminal state
+func (r *EvalRun) Done() bool {
+	switch r.Status {
+	case "completed", "failed", "cancelled":
+		return true
+	}
+	return false
+}
+
+// ModelSummary returns the aggregate scores for model, if it was in the run
+func (r *EvalRun) ModelSummary(model string) *ModelEvalSummary {
+	for i := range r.Summary {
+		if r.Summary[i].Model == model {
+			return &r.Summary[i]
+		}
+	}
+	return nil
+}
+
+type EvalRunsResponse struct {
+	Object string    `json:"object"`
+	Data   []EvalRun `json:"data"`
+}
+
+type EvalCaseResult struct {
+	CaseID      string  `json:"case_id"`
+	Model       string  `json:"model"`
+	Output      string  `json:"output"`
+	Score       float64 `json:"score"`
+	Passed      bool    `json:"passed"`
+	LatencyMs   int64   `json:"latency_ms"`
+	JudgeOutput *string `json:"judge_output,omitempty"`
+	Error       *string `json:"error,omitempty"`
+}
+
+type EvalResultsResponse struct {
+	Object string           `json:"object"`
+	Data   []EvalCaseResult `json:"data"`
+}
+
+func evalSuiteEndpoint(id string) string {
+	return "/v1/evals/" + url.PathEscape(id)
+}
+
+func evalRunEndpoint(suiteID, runID string) string {
+	return evalSuiteEndpoint(suiteID) + "/runs/" + url.PathEscape(runID)
+}
+
+// CreateEvalSuite stores a new suite. Requires the admin token.
+func (c *Client) CreateEvalSuite(spec EvalSuiteSpec) (*EvalSuite, error) {
+	return c.evalSuiteRequest("POST", "/v1/evals", spec)
+}
+
+// EvalSuites lists all suites
+func (c *Client) EvalSuites() ([]EvalSuite, error) {
+	resp, err := c.Request("GET", "/v1/evals", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var result EvalSuitesResponse
+	if err := decodeResponse(resp, &result); err != nil {
+		return nil, err
+	}
+
+	return result.Data, nil
+}
+
+// EvalSuite returns a suite with its cases
+func (c *Client) EvalSuite(id string) (*EvalSuite, error) {
+	return c.evalSuiteRequest("GET", evalSuiteEndpoint(id), nil)
+}
+
+// UpdateEvalSuite replaces a suite's definition. Requires the admin token.
+func (c *Client) UpdateEvalSuite(id string, spec EvalSuiteSpec) (*EvalSuite, error) {
+	return c.evalSuiteRequest("PUT", evalSuiteEndpoint(id), spec)
+}
+
+// DeleteEvalSuite removes a suite and its runs. Requires the admin token.
+func (c *Client) DeleteEvalSuite(id string) error {
+	resp, err := c.Request("DELETE", evalSuiteEndpoint(id), nil)
+	if err != nil {
+		return err
+	}
+
+	return decodeResponse(resp, nil)
+}
+
+func (c *Client) evalSuiteRequest(method, endpoint string, body interface{}) (*EvalSuite, error) {
+	resp, err := c.Request(method, endpoint, body)
+	if err != nil {
+		return nil, err
+	}
+
+	var suite EvalSuite
+	if err := decodeResponse(resp, &suite); err != nil {
+		return nil, err
+	}
+
+	return &suite, nil
+}
+
+// RunEvalSuite starts a run of the suite against models and returns
+// immediately; poll with EvalRun or block with WaitForEvalRun. Requires the
+// admin token.
+func (c *Client) RunEvalSuite(suiteID string, models []string) (*EvalRun, error) {
+	body := map[string][]string{"models": models}
+	resp, err := c.Request("POST", evalSuiteEndpoint(suiteID)+"/runs", body)
+	if err != nil {
+		return nil, err
+	}
+
+	var run EvalRun
+	if err := decodeResponse(resp, &run); err != nil {
+		return nil, err
+	}
+
+	return &run, nil
+}
+
+// EvalRuns lists a suite's runs, newest first
+func (c *Client) EvalRuns(suiteID string) ([]EvalRun, error) {
+	resp, err := c.Request("GET", evalSuiteEndpoint(suiteID)+"/runs", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var result EvalRunsResponse
+	if err := decodeResponse(resp, &result); err != nil {
+		return nil, err
+	}
+
+	return result.Data, nil
+}
+
+// EvalRun returns a run's status and per-model scores
+func (c *Client) EvalRun(suiteID, runID string) (*EvalRun, error) {
+	resp, err := c.Request("GET", evalRunEndpoint(suiteID, runID), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var run EvalRun
+	if err := decodeResponse(resp, &run); err != nil {
+		return nil, err
+	}
+
+	return &run, nil
+}
+
+// EvalRunResults returns the per-case results recorded so far
+func (c *Client) EvalRunResults(suiteID, runID string) ([]EvalCaseResult, error) {
+	resp, err := c.Request("GET", evalRunEndpoint(suiteID, runID)+"/results", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var result EvalResultsResponse
+	if err := decodeResponse(resp, &result); err != nil {
+		return nil, err
+	}
+
+	return result.Data, nil
+}
+
+// WaitForEvalRun polls a run with exponential backoff until it finishes or
+// ctx is done. A failed run is returned along with an error.
+func (c *Client) WaitForEvalRun(ctx context.Context, suiteID, runID string) (*EvalRun, error) {
+	delay := jobPollInitial
+
+	for {
+		run, err := c.EvalRun(suiteID, runID)
+		if err != nil {
+			return nil, err
+		}
+
+		if run.Done() {
+			if run.Status == "failed" && run.Error != nil {
+				return run, fmt.Errorf("eval run %s failed: %s", runID, *run.Error)
+			}
+			return run, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(delay):
+		}
+
+		delay *= 2
+		if delay > jobPollMax {
+			delay = jobPollMax
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package inferno
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+)
+
+// Evaluation structures
+type PerplexityRequest struct {
+	Texts         []string `json:"texts"`
+	IncludeTokens bool     `json:"include_tokens,omitempty"`
+}
+
+type TokenLogprob struct {
+	Token   string  `json:"token"`
+	Logprob float64 `json:"logprob"`
+}
+
+type DocumentScore struct {
+	Index         int            `json:"index"`
+	Tokens        int            `json:"tokens"`
+	LogLikelihood float64        `json:"log_likelihood"`
+	Perplexity    *float64       `json:"perplexity"`
+	TokenLogprobs []TokenLogprob `json:"token_logprobs,omitempty"`
+	// Error is set when the document could not be scored, e.g. because it
+	// does not fit the context window; it is left out of the totals
+	Error *string `json:"error,omitempty"`
+}
+
+type PerplexityReport struct {
+	Model         string          `json:"model"`
+	Documents     []DocumentScore `json:"documents"`
+	TotalTokens   int64           `json:"total_tokens"`
+	LogLikelihood float64         `json:"log_likelihood"`
+	Perplexity    *float64        `json:"perplexity"`
+	DurationMs    int64           `json:"duration_ms"`
+	Incomplete    *string         `json:"incomplete,omitempty"`
+}
+
+// Logprobs is the per-token scoring of a completion, in the legacy OpenAI
+// layout plus sequence totals
+type Logprobs struct {
+	Tokens        []string  `json:"tokens"`
+	TokenLogprobs []float64 `json:"token_logprobs"`
+	TextOffset    []int     `json:"text_offset"`
+	// TopLogprobs maps each token's listed alternatives to their
+	// log-probabilities; set when logprobs is requested on a completion
+	TopLogprobs []map[string]float64 `json:"top_logprobs,omitempty"`
+	// LogLikelihood and Perplexity are only set for scoring requests
+	LogLikelihood float64  `json:"log_likelihood"`
+	Perplexity    *float64 `json:"perplexity"`
+}
+
+// EvaluatePerplexity scores each text under model and returns per-document
+// and corpus perplexity. Large corpora take a while, so the call is bounded
+// by ctx rather than HTTPClient.Timeout.
+func (c *Client) EvaluatePerplexity(ctx context.Context, model string, request PerplexityRequest) (*PerplexityReport, error) {
+	endpoint := "/v1/models/" + url.PathEscape(model) + "/evaluate/perplexity"
+	resp, err := c.longRunningRequest(ctx, "POST", endpoint, request)
+	if err != nil {
+		return nil, err
+	}
+
+	var report PerplexityReport
+	if err := decodeResponse(resp, &report); err != nil {
+		return nil, err
+	}
+
+	return &report, nil
+}
+
+// ScoreCompletion returns the log-likelihood model assigns to completion as
+// the continuation of prompt, without generating anything
+func (c *Client) ScoreCompletion(ctx context.Context, model, prompt, completion string) (*Logprobs, error) {
+	request := InferenceRequest{
+		Model:  model,
+		Prompt: prompt,
+		Score:  &completion,
+	}
+
+	result, err := c.InferenceContext(ctx, request)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(result.Choices) == 0 || result.Choices[0].Logprobs == nil {
+		return nil, fmt.Errorf("no scores received")
+	}
+
+	return result.Choices[0].Logprobs, nil
+}
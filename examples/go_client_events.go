@@ -0,0 +1,155 @@
+package inferno
+
+import (
+	"net/http"
+	"time"
+)
+
+// ClientEventType is a kind of event the client emits as it works
+type ClientEventType string
+
+const (
+	// EventRequestStarted is emitted before a request is sent
+	EventRequestStarted ClientEventType = "request_started"
+	// EventRequestFinished is emitted once the response headers arrive or
+	// the request fails; for streams that is before the first token
+	EventRequestFinished ClientEventType = "request_finished"
+	// EventRetryAttempted is emitted when a request a draining server
+	// refused, or one refused for an expired token, is sent again
+	EventRetryAttempted ClientEventType = "retry_attempted"
+	// EventStreamToken is emitted for each piece of text a ChatStream reads
+	EventStreamToken ClientEventType = "stream_token"
+	// EventBreakerOpened is reserved for a circuit breaker; the client has
+	// none yet, so it is never emitted
+	EventBreakerOpened ClientEventType = "breaker_opened"
+	// EventEndpointFailedOver is emitted when the client stops using an
+	// endpoint that reported draining and moves to another
+	EventEndpointFailedOver ClientEventType = "endpoint_failed_over"
+	// EventBudgetWarning is emitted after EventRequestFinished when the
+	// request was charged to a budget past its soft limit
+	EventBudgetWarning ClientEventType = "budget_warning"
+	// EventRegionFailedOver is emitted when geo-failover moves traffic off
+	// a region that failed its probes to a standby
+	EventRegionFailedOver ClientEventType = "region_failed_over"
+	// EventRegionRecovered is emitted when geo-failover moves traffic back
+	// to a more preferred region that has recovered
+	EventRegionRecovered ClientEventType = "region_recovered"
+	// EventRequestDeduplicated is emitted when a request waits for the
+	// response of an identical one in flight instead of being sent
+	EventRequestDeduplicated ClientEventType = "request_deduplicated"
+	// EventConcurrencyLimitChanged is emitted when the client's Limiter
+	// raises or cuts its limit
+	EventConcurrencyLimitChanged ClientEventType = "concurrency_limit_changed"
+)
+
+// ClientEvent describes something the client did. Fields that do not apply
+// to the event's Type are zero.
+type ClientEvent struct {
+	Type ClientEventType
+	Time time.Time
+	// Method and URL identify the request, for request and retry events
+	Method string
+	URL    string
+	// StatusCode, Duration and Err are set on EventRequestFinished; Err is
+	// a transport error, not a non-2xx status
+	StatusCode int
+	Duration   time.Duration
+	Err        error
+	// Attempt counts sends of the request: 1 on EventRequestStarted, 2 on
+	// the first EventRetryAttempted
+	Attempt int
+	// From and To are the endpoints of EventEndpointFailedOver, and the
+	// regions of EventRegionFailedOver and EventRegionRecovered
+	From string
+	To   string
+	// Token is the text of EventStreamToken
+	Token string
+	// Budget is the BudgetWarningHeader of EventBudgetWarning, naming the
+	// budgets past their soft limit
+	Budget string
+	// Limit is the new limit of EventConcurrencyLimitChanged
+	Limit int
+}
+
+// clientSubscriber is one Subscribe registration
+type clientSubscriber struct {
+	types   map[ClientEventType]bool
+	handler func(ClientEvent)
+}
+
+// Subscribe calls handler for each event of the given types, or of every
+// type when none are given, until the returned function is called.
+// Handlers run on the goroutine making the request, so they should return
+// quickly; hand slow work to another goroutine.
+func (c *Client) Subscribe(handler func(ClientEvent), types ...ClientEventType) (unsubscribe func()) {
+	subscriber := &clientSubscriber{handler: handler}
+	if len(types) > 0 {
+		subscriber.types = map[ClientEventType]bool{}
+		for _, t := range types {
+			subscriber.types[t] = true
+		}
+	}
+
+	c.subscribersMu.Lock()
+	defer c.subscribersMu.Unlock()
+	if c.subscribers == nil {
+		c.subscribers = map[*clientSubscriber]struct{}{}
+	}
+	c.subscribers[subscriber] = struct{}{}
+
+	return func() {
+		c.subscribersMu.Lock()
+		defer c.subscribersMu.Unlock()
+		delete(c.subscribers, subscriber)
+	}
+}
+
+// emit sends event to the subscribers that want it
+func (c *Client) emit(event ClientEvent) {
+	c.subscribersMu.RLock()
+	if len(c.subscribers) == 0 {
+		c.subscribersMu.RUnlock()
+		return
+	}
+	handlers := make([]func(ClientEvent), 0, len(c.subscribers))
+	for subscriber := range c.subscribers {
+		if subscriber.types == nil || subscriber.types[event.Type] {
+			handlers = append(handlers, subscriber.handler)
+		}
+	}
+	c.subscribersMu.RUnlock()
+
+	event.Time = time.Now()
+	for _, handler := range handlers {
+		handler(event)
+	}
+}
+
+// emitFinished reports the outcome of req, sent at started
+func (c *Client) emitFinished(req *http.Request, started time.Time, resp *http.Response, err error) {
+	event := ClientEvent{
+		Type:     EventRequestFinished,
+		Method:   req.Method,
+		URL:      req.URL.String(),
+		Duration: time.Since(started),
+		Err:      err,
+	}
+	if resp != nil {
+		event.StatusCode = resp.StatusCode
+		if resp.Request != nil {
+			event.URL = resp.Request.URL.String()
+		}
+	}
+	c.emit(event)
+
+	if resp != nil {
+		if warning := resp.Header.Get(BudgetWarningHeader); warning != "" {
+			c.emit(ClientEvent{
+				Type:   EventBudgetWarning,
+				Method: event.Method,
+				URL:    event.URL,
+				Budget: warning,
+			})
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package inferno
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+)
+
+// Extraction field types
+const (
+	FieldString  = "string"
+	FieldNumber  = "number"
+	FieldInteger = "integer"
+	FieldBoolean = "boolean"
+	// FieldDate values are YYYY-MM-DD strings
+	FieldDate = "date"
+)
+
+// Extraction structures
+type EntitySpec struct {
+	Type        string `json:"type"`
+	Description string `json:"description,omitempty"`
+}
+
+type FieldSpec struct {
+	Name string `json:"name"`
+	// Type is one of the Field* constants; the server defaults to FieldString
+	Type        string `json:"type,omitempty"`
+	Description string `json:"description,omitempty"`
+	// Required makes the server retry until the model fills the field in
+	Required bool `json:"required,omitempty"`
+}
+
+type ExtractRequest struct {
+	Model    string       `json:"model"`
+	Text     string       `json:"text"`
+	Entities []EntitySpec `json:"entities,omitempty"`
+	Fields   []FieldSpec  `json:"fields,omitempty"`
+	// MaxTokens bounds each generation (server default 1024)
+	MaxTokens int `json:"max_tokens,omitempty"`
+	// MaxRetries is how often an answer that misses the schema is sent back
+	// for correction (server default 2, at most 5)
+	MaxRetries *int `json:"max_retries,omitempty"`
+	TimeoutMs  int  `json:"timeout_ms,omitempty"`
+	// Metadata tags the request's usage; see InferenceRequest
+	Metadata map[string]string `json:"metadata,omitempty"`
+}
+
+// ExtractedEntity is one mention; Start and End are character (rune)
+// offsets into the request text, End exclusive
+type ExtractedEntity struct {
+	Type  string `json:"type"`
+	Text  string `json:"text"`
+	Start int    `json:"start"`
+	End   int    `json:"end"`
+	// Confidence is nil when the model cannot score its own output
+	Confidence *float32 `json:"confidence"`
+}
+
+type ExtractedField struct {
+	// Value is JSON null when the text does not state the field
+	Value json.RawMessage `json:"value"`
+	// Start and End are set when the value appears verbatim in the text
+	Start      *int     `json:"start"`
+	End        *int     `json:"end"`
+	Confidence *float32 `json:"confidence"`
+}
+
+type ExtractResponse struct {
+	Model    string                    `json:"model"`
+	Entities []ExtractedEntity         `json:"entities"`
+	Fields   map[string]ExtractedField `json:"fields"`
+	// Attempts counts the generations needed for an answer that fits
+	Attempts int `json:"attempts"`
+}
+
+// Extract pulls the requested entities and fields out of a text. The
+// server validates the model's answer against the schema and retries, so a
+// successful response always matches the requested types.
+func (c *Client) Extract(ctx context.Context, request ExtractRequest) (*ExtractResponse, error) {
+	if err := c.requireFeature(ctx, "extract"); err != nil {
+		return nil, err
+	}
+
+	resp, err := c.RequestContext(ctx, "POST", "/v1/extract", request)
+	if err != nil {
+		return nil, err
+	}
+
+	var result ExtractResponse
+	if err := decodeResponse(resp, &result); err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+// Decode unmarshals the extracted fields into out, a pointer to a struct
+// whose json tags match the field names; fields the text did not state
+// leave their zero value
+func (r *ExtractResponse) Decode(out interface{}) error {
+	values := make(map[string]json.RawMessage, len(r.Fields))
+	for name, field := range r.Fields {
+		values[name] = field.Value
+	}
+
+	data, err := json.Marshal(values)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, out); err != nil {
+		return fmt.Errorf("decode extracted fields: %w", err)
+	}
+	return nil
+}
+
+// EntitiesOfType returns the mentions of one entity type, in text order
+func (r *ExtractResponse) EntitiesOfType(entityType string) []ExtractedEntity {
+	var entities []ExtractedEntity
+	for _, entity := range r.Entities {
+		if entity.Type == entityType {
+			entities = append(entities, entity)
+		}
+	}
+	return entities
+}
@@ -0,0 +1,192 @@
+package inferno
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Defaults of GeoFailover's zero fields
+const (
+	defaultFailoverInterval  = 10 * time.Second
+	defaultFailoverProbePath = "/health/ready"
+	defaultFailAfter         = 3
+	defaultRecoverAfter      = 5
+)
+
+// GeoFailover configures StartGeoFailover
+type GeoFailover struct {
+	// Regions in order of preference: the first is the primary, the rest
+	// standbys. Their endpoints are those Client.Regions assigns them.
+	Regions []string
+	// Interval between probe rounds; zero means 10s
+	Interval time.Duration
+	// ProbePath is requested on every endpoint each round; empty means
+	// /health/ready, which fails while a server is loading or draining
+	ProbePath string
+	// FailAfter is how many rounds in a row a region must fail, with no
+	// endpoint answering, before traffic leaves it; zero means 3
+	FailAfter int
+	// RecoverAfter is how many rounds in a row a failed region must pass
+	// before traffic returns to it; zero means 5
+	RecoverAfter int
+}
+
+// regionHealth is a region's probe history
+type regionHealth struct {
+	down bool
+	// streak counts rounds in a row that disagreed with down
+	streak int
+}
+
+// geoFailover is the state of StartGeoFailover
+type geoFailover struct {
+	mu      sync.Mutex
+	config  GeoFailover
+	regions map[string]*regionHealth
+	active  string
+}
+
+// StartGeoFailover probes the endpoints of each of config's regions until
+// ctx is done, sending requests made without WithRegion to the first region
+// that is up. A region goes down after FailAfter failed rounds and comes
+// back after RecoverAfter good ones, so a flaky region does not flap.
+// Subscribers see EventRegionFailedOver and EventRegionRecovered as traffic
+// moves.
+func (c *Client) StartGeoFailover(ctx context.Context, config GeoFailover) error {
+	if len(config.Regions) == 0 {
+		return fmt.Errorf("geo-failover needs at least one region")
+	}
+	for _, region := range config.Regions {
+		if len(c.regionEndpoints(region)) == 0 {
+			return fmt.Errorf("%w: %q", ErrNoRegionEndpoint, region)
+		}
+	}
+	if config.Interval <= 0 {
+		config.Interval = defaultFailoverInterval
+	}
+	if config.ProbePath == "" {
+		config.ProbePath = defaultFailoverProbePath
+	}
+	if config.FailAfter <= 0 {
+		config.FailAfter = defaultFailAfter
+	}
+	if config.RecoverAfter <= 0 {
+		config.RecoverAfter = defaultRecoverAfter
+	}
+
+	failover := &geoFailover{
+		config:  config,
+		regions: map[string]*regionHealth{},
+		active:  config.Regions[0],
+	}
+	for _, region := range config.Regions {
+		failover.regions[region] = &regionHealth{}
+	}
+	c.geoMu.Lock()
+	c.geo = failover
+	c.geoMu.Unlock()
+
+	go func() {
+		ticker := time.NewTicker(config.Interval)
+		defer ticker.Stop()
+		for {
+			c.probeRegions(ctx, failover)
+			select {
+			case <-ctx.Done():
+				c.geoMu.Lock()
+				if c.geo == failover {
+					c.geo = nil
+				}
+				c.geoMu.Unlock()
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	return nil
+}
+
+// ActiveRegion returns the region geo-failover sends requests to, or ""
+// when it is not running
+func (c *Client) ActiveRegion() string {
+	c.geoMu.Lock()
+	failover := c.geo
+	c.geoMu.Unlock()
+	if failover == nil {
+		return ""
+	}
+	failover.mu.Lock()
+	defer failover.mu.Unlock()
+	return failover.active
+}
+
+// probeRegions runs one round: probes every endpoint, updates each region's
+// state and moves traffic if the first region up has changed
+func (c *Client) probeRegions(ctx context.Context, failover *geoFailover) {
+	config := failover.config
+	passed := map[string]bool{}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, region := range config.Regions {
+		for _, endpoint := range c.regionEndpoints(region) {
+			wg.Add(1)
+			go func(region, endpoint string) {
+				defer wg.Done()
+				if c.probe(ctx, endpoint, config.ProbePath) {
+					mu.Lock()
+					passed[region] = true
+					mu.Unlock()
+				}
+			}(region, endpoint)
+		}
+	}
+	wg.Wait()
+	if ctx.Err() != nil {
+		return
+	}
+
+	failover.mu.Lock()
+	for region, health := range failover.regions {
+		if passed[region] != health.down {
+			health.streak = 0
+			continue
+		}
+		health.streak++
+		if health.down && health.streak >= config.RecoverAfter {
+			health.down, health.streak = false, 0
+		} else if !health.down && health.streak >= config.FailAfter {
+			health.down, health.streak = true, 0
+		}
+	}
+
+	from := failover.active
+	for _, region := range config.Regions {
+		if !failover.regions[region].down {
+			failover.active = region
+			break
+		}
+	}
+	to := failover.active
+	failover.mu.Unlock()
+
+	if to == from {
+		return
+	}
+	eventType := EventRegionFailedOver
+	if preference(config.Regions, to) < preference(config.Regions, from) {
+		eventType = EventRegionRecovered
+	}
+	c.emit(ClientEvent{Type: eventType, From: from, To: to})
+}
+
+// preference returns region's place in regions
+func preference(regions []string, region string) int {
+	for i, candidate := range regions {
+		if candidate == region {
+			return i
+		}
+	}
+	return len(regions)
+}
@@ -0,0 +1,98 @@
+package inferno
+
+import (
+	"context"
+	"errors"
+	"net/http"
+)
+
+// ErrLocalUnavailable is returned by the local backend of a build without
+// the local tag
+var ErrLocalUnavailable = errors.New("inferno: local backend not built; build with -tags local")
+
+// InfernoAPI is the inference the server offers, implemented by *Client,
+// by the in-process *LocalBackend and by *FallbackClient combining them
+type InfernoAPI interface {
+	InferenceContext(ctx context.Context, request InferenceRequest) (*InferenceResponse, error)
+	ChatCompletionContext(ctx context.Context, request ChatCompletionRequest) (*ChatCompletionResponse, error)
+	EmbeddingsContext(ctx context.Context, request EmbeddingsRequest) (*EmbeddingsResponse, error)
+}
+
+var (
+	_ InfernoAPI = (*Client)(nil)
+	_ InfernoAPI = (*LocalBackend)(nil)
+	_ InfernoAPI = (*FallbackClient)(nil)
+)
+
+// LocalOptions configures NewLocalBackend
+type LocalOptions struct {
+	// ModelPath is the GGUF file to load, typically a small model
+	ModelPath string
+	// ContextSize is the context window in tokens; zero means 2048
+	ContextSize int
+	// Threads used for decoding; zero means the runtime's default
+	Threads int
+	// GPULayers offloads that many layers when built with GPU support
+	GPULayers int
+}
+
+// FallbackClient sends requests to Primary and, when the server cannot be
+// reached, runs them on Local instead. Errors the server answered with, and
+// those of a done ctx, are returned as they are: only an unreachable or
+// unavailable server falls back.
+type FallbackClient struct {
+	Primary InfernoAPI
+	Local   InfernoAPI
+	// OnFallback, if set, receives the error of each request that fell
+	// back
+	OnFallback func(err error)
+}
+
+// NewFallbackClient returns a FallbackClient trying primary, then local
+func NewFallbackClient(primary, local InfernoAPI) *FallbackClient {
+	return &FallbackClient{Primary: primary, Local: local}
+}
+
+// shouldFallBack reports whether err means the server is down rather than
+// that it rejected the request
+func (f *FallbackClient) shouldFallBack(ctx context.Context, err error) bool {
+	if err == nil || f.Local == nil || ctx.Err() != nil {
+		return false
+	}
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		switch apiErr.StatusCode {
+		case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		default:
+			return false
+		}
+	}
+	if f.OnFallback != nil {
+		f.OnFallback(err)
+	}
+	return true
+}
+
+func (f *FallbackClient) InferenceContext(ctx context.Context, request InferenceRequest) (*InferenceResponse, error) {
+	result, err := f.Primary.InferenceContext(ctx, request)
+	if f.shouldFallBack(ctx, err) {
+		return f.Local.InferenceContext(ctx, request)
+	}
+	return result, err
+}
+
+func (f *FallbackClient) ChatCompletionContext(ctx context.Context, request ChatCompletionRequest) (*ChatCompletionResponse, error) {
+	result, err := f.Primary.ChatCompletionContext(ctx, request)
+	if f.shouldFallBack(ctx, err) {
+		return f.Local.ChatCompletionContext(ctx, request)
+	}
+	return result, err
+}
+
+func (f *FallbackClient) EmbeddingsContext(ctx context.Context, request EmbeddingsRequest) (*EmbeddingsResponse, error) {
+	result, err := f.Primary.EmbeddingsContext(ctx, request)
+	if f.shouldFallBack(ctx, err) {
+		return f.Local.EmbeddingsContext(ctx, request)
+	}
+	return result, err
+}
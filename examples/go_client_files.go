@@ -0,0 +1,148 @@
+package inferno
+
+import (
+	"context"
+	"io"
+	"mime/multipart"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// File structures
+type FileObject struct {
+	ID        string `json:"id"`
+	Object    string `json:"object"`
+	Bytes     int64  `json:"bytes"`
+	CreatedAt int64  `json:"created_at"`
+	Filename  string `json:"filename"`
+	// Purpose is "assistants", "batch", "fine-tune", "vision", "user_data"
+	// or "evals"
+	Purpose       string  `json:"purpose"`
+	Status        string  `json:"status"`
+	StatusDetails *string `json:"status_details,omitempty"`
+}
+
+// UploadFile streams r to the server as a multipart upload named filename.
+// The body is produced while it is sent, so large files are never held in
+// memory; ctx bounds the upload instead of HTTPClient.Timeout. Requires the
+// admin token.
+func (c *Client) UploadFile(ctx context.Context, filename, purpose string, r io.Reader) (*FileObject, error) {
+	pipeReader, pipeWriter := io.Pipe()
+	form := multipart.NewWriter(pipeWriter)
+
+	go func() {
+		err := form.WriteField("purpose", purpose)
+		if err == nil {
+			var part io.Writer
+			part, err = form.CreateFormFile("file", filename)
+			if err == nil {
+				_, err = io.Copy(part, r)
+			}
+		}
+		if err == nil {
+			err = form.Close()
+		}
+		pipeWriter.CloseWithError(err)
+	}()
+
+	req, err := c.newRequest(ctx, "POST", "/v1/files", nil)
+	if err != nil {
+		pipeReader.Close()
+		return nil, err
+	}
+	req.Body = pipeReader
+	req.ContentLength = -1
+	req.Header.Set("Content-Type", form.FormDataContentType())
+
+	httpClient := *c.HTTPClient
+	httpClient.Timeout = 0
+	resp, err := c.send(&httpClient, req)
+	if err != nil {
+		return nil, err
+	}
+
+	var file FileObject
+	if err := decodeResponse(resp, &file); err != nil {
+		return nil, err
+	}
+
+	return &file, nil
+}
+
+// UploadFilePath uploads the file at path like UploadFile. Requires the admin
+// token.
+func (c *Client) UploadFilePath(ctx context.Context, path, purpose string) (*FileObject, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return c.UploadFile(ctx, filepath.Base(path), purpose, file)
+}
+
+// Files lists uploaded files, newest first; an empty purpose lists all
+func (c *Client) Files(purpose string) ([]FileObject, error) {
+	endpoint := "/v1/files"
+	if purpose != "" {
+		endpoint += "?" + url.Values{"purpose": {purpose}}.Encode()
+	}
+
+	resp, err := c.Request("GET", endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var list struct {
+		Data []FileObject `json:"data"`
+	}
+	if err := decodeResponse(resp, &list); err != nil {
+		return nil, err
+	}
+
+	return list.Data, nil
+}
+
+// GetFile returns one file's metadata
+func (c *Client) GetFile(fileID string) (*FileObject, error) {
+	resp, err := c.Request("GET", "/v1/files/"+url.PathEscape(fileID), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var file FileObject
+	if err := decodeResponse(resp, &file); err != nil {
+		return nil, err
+	}
+
+	return &file, nil
+}
+
+// DeleteFile removes an uploaded file. Requires the admin token.
+func (c *Client) DeleteFile(fileID string) error {
+	resp, err := c.Request("DELETE", "/v1/files/"+url.PathEscape(fileID), nil)
+	if err != nil {
+		return err
+	}
+
+	return decodeResponse(resp, nil)
+}
+
+// FileContent streams a file's bytes into w and returns how many were
+// written; ctx bounds the download instead of HTTPClient.Timeout
+func (c *Client) FileContent(ctx context.Context, fileID string, w io.Writer) (int64, error) {
+	resp, err := c.longRunningRequest(ctx, "GET", "/v1/files/"+url.PathEscape(fileID)+"/content", nil)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		body, _ := io.ReadAll(resp.Body)
+		return 0, &APIError{StatusCode: resp.StatusCode, Body: strings.TrimSpace(string(body))}
+	}
+
+	return io.Copy(w, resp.Body)
+}
@@ -0,0 +1,283 @@
+package inferno
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Fine-tuning structures
+type LoraHyperparameters struct {
+	Rank          int     `json:"rank,omitempty"`
+	Alpha         float64 `json:"alpha,omitempty"`
+	LearningRate  float64 `json:"learning_rate,omitempty"`
+	Epochs        int     `json:"epochs,omitempty"`
+	BatchSize     int     `json:"batch_size,omitempty"`
+	ContextLength int     `json:"context_length,omitempty"`
+	Seed          *uint64 `json:"seed,omitempty"`
+}
+
+type FineTuningJobRequest struct {
+	// BaseModel is the name or path of a local GGUF model
+	BaseModel string `json:"base_model"`
+	// Dataset is a managed dataset ("name" or "name@v2"; see
+	// DatasetVersion.Reference) or a training file, absolute or relative to
+	// the server's fine_tuning.datasets_dir
+	Dataset         string               `json:"dataset"`
+	Hyperparameters *LoraHyperparameters `json:"hyperparameters,omitempty"`
+	Suffix          *string              `json:"suffix,omitempty"`
+	// Register defaults to true on the server
+	Register *bool `json:"register,omitempty"`
+}
+
+type TrainingProgress struct {
+	Step         uint64   `json:"step"`
+	TotalSteps   *uint64  `json:"total_steps,omitempty"`
+	Epoch        *int     `json:"epoch,omitempty"`
+	Loss         *float64 `json:"loss,omitempty"`
+	LearningRate *float64 `json:"learning_rate,omitempty"`
+	Fraction     *float64 `json:"fraction,omitempty"`
+	EtaSeconds   *uint64  `json:"eta_seconds,omitempty"`
+}
+
+type TrainingMetric struct {
+	Step         uint64    `json:"step"`
+	TotalSteps   *uint64   `json:"total_steps,omitempty"`
+	Epoch        *int      `json:"epoch,omitempty"`
+	Loss         *float64  `json:"loss,omitempty"`
+	LearningRate *float64  `json:"learning_rate,omitempty"`
+	EtaSeconds   *uint64   `json:"eta_seconds,omitempty"`
+	RecordedAt   time.Time `json:"recorded_at"`
+}
+
+// String renders the metric as a one-line progress report, e.g.
+// "step 40/400 (10%) loss 1.9200 lr 0.0002 eta 5m0s"
+func (m *TrainingMetric) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "step %d", m.Step)
+	if m.TotalSteps != nil && *m.TotalSteps > 0 {
+		fmt.Fprintf(&b, "/%d (%d%%)", *m.TotalSteps, m.Step*100 / *m.TotalSteps)
+	}
+	if m.Epoch != nil {
+		fmt.Fprintf(&b, " epoch %d", *m.Epoch)
+	}
+	if m.Loss != nil {
+		fmt.Fprintf(&b, " loss %.4f", *m.Loss)
+	}
+	if m.LearningRate != nil {
+		fmt.Fprintf(&b, " lr %g", *m.LearningRate)
+	}
+	if m.EtaSeconds != nil {
+		fmt.Fprintf(&b, " eta %s", time.Duration(*m.EtaSeconds)*time.Second)
+	}
+	return b.String()
+}
+
+type TrainingCheckpoint struct {
+	Step      uint64    `json:"step"`
+	Path      string    `json:"path"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// TrainingEvent is one live update from WatchFineTuning. Type is "metric",
+// "checkpoint" or "status", and the matching field is set.
+type TrainingEvent struct {
+	Type       string              `json:"type"`
+	JobID      string              `json:"job_id"`
+	Metric     *TrainingMetric     `json:"metric,omitempty"`
+	Checkpoint *TrainingCheckpoint `json:"checkpoint,omitempty"`
+	Job        *FineTuningJob      `json:"job,omitempty"`
+}
+
+type TrainingMetricsResponse struct {
+	Object string           `json:"object"`
+	Data   []TrainingMetric `json:"data"`
+}
+
+type FineTuningJob struct {
+	ID              string               `json:"id"`
+	BaseModel       string               `json:"base_model"`
+	Dataset         string               `json:"dataset"`
+	Hyperparameters LoraHyperparameters  `json:"hyperparameters"`
+	Status          string               `json:"status"`
+	Progress        TrainingProgress     `json:"progress"`
+	FineTunedModel  string               `json:"fine_tuned_model"`
+	OutputPath      string               `json:"output_path"`
+	Registered      bool                 `json:"registered"`
+	Checkpoints     []TrainingCheckpoint `json:"checkpoints"`
+	CreatedAt       time.Time            `json:"created_at"`
+	StartedAt       *time.Time           `json:"started_at,omitempty"`
+	FinishedAt      *time.Time           `json:"finished_at,omitempty"`
+	Error           *string              `json:"error,omitempty"`
+}
+
+// Done reports whether the job has reached a terminal state
+func (j *FineTuningJob) Done() bool {
+	switch j.Status {
+	case "succeeded", "failed", "cancelled":
+		return true
+	}
+	return false
+}
+
+type FineTuningJobsResponse struct {
+	Object string          `json:"object"`
+	Data   []FineTuningJob `json:"data"`
+}
+
+type FineTuningEvent struct {
+	CreatedAt time.Time `json:"created_at"`
+	Message   string    `json:"message"`
+}
+
+type FineTuningEventsResponse struct {
+	Object string            `json:"object"`
+	Data   []FineTuningEvent `json:"data"`
+}
+
+func fineTuningJobEndpoint(id string) string {
+	return "/v1/fine_tuning/jobs/" + url.PathEscape(id)
+}
+
+// CreateFineTuningJob starts training a LoRA adapter and returns
+// immediately; poll with FineTuningJob or block with WaitForFineTuning.
+// Requires the admin token.
+func (c *Client) CreateFineTuningJob(req FineTuningJobRequest) (*FineTuningJob, error) {
+	return c.fineTuningJobRequest("POST", "/v1/fine_tuning/jobs", req)
+}
+
+// FineTuningJobs lists jobs, newest first. Requires the admin token.
+func (c *Client) FineTuningJobs() ([]FineTuningJob, error) {
+	resp, err := c.Request("GET", "/v1/fine_tuning/jobs", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var result FineTuningJobsResponse
+	if err := decodeResponse(resp, &result); err != nil {
+		return nil, err
+	}
+
+	return result.Data, nil
+}
+
+// FineTuningJob returns a job's status and training progress. Requires the
+// admin token.
+func (c *Client) FineTuningJob(id string) (*FineTuningJob, error) {
+	return c.fineTuningJobRequest("GET", fineTuningJobEndpoint(id), nil)
+}
+
+// FineTuningEvents returns the trainer's recent output lines, oldest first.
+// Requires the admin token.
+func (c *Client) FineTuningEvents(id string) ([]FineTuningEvent, error) {
+	resp, err := c.Request("GET", fineTuningJobEndpoint(id)+"/events", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var result FineTuningEventsResponse
+	if err := decodeResponse(resp, &result); err != nil {
+		return nil, err
+	}
+
+	return result.Data, nil
+}
+
+// FineTuningMetrics returns the job's recorded metric history, oldest
+// first. Requires the admin token.
+func (c *Client) FineTuningMetrics(id string) ([]TrainingMetric, error) {
+	resp, err := c.Request("GET", fineTuningJobEndpoint(id)+"/metrics", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var result TrainingMetricsResponse
+	if err := decodeResponse(resp, &result); err != nil {
+		return nil, err
+	}
+
+	return result.Data, nil
+}
+
+// WatchFineTuning calls handle for each live update until the job finishes,
+// ctx is done or handle returns an error. The first event is a status event
+// with the job's current state. Requires the admin token.
+func (c *Client) WatchFineTuning(ctx context.Context, id string, handle func(TrainingEvent) error) error {
+	resp, err := c.longRunningRequest(ctx, "GET", fineTuningJobEndpoint(id)+"/stream", nil)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode >= 400 {
+		return decodeResponse(resp, nil)
+	}
+	defer resp.Body.Close()
+
+	return ReadServerSentEvents(resp.Body, func(data []byte) error {
+		var event TrainingEvent
+		if err := json.Unmarshal(data, &event); err != nil {
+			return err
+		}
+		return handle(event)
+	})
+}
+
+// CancelFineTuningJob stops a queued or running job. Requires the admin
+// token.
+func (c *Client) CancelFineTuningJob(id string) (*FineTuningJob, error) {
+	return c.fineTuningJobRequest("POST", fineTuningJobEndpoint(id)+"/cancel", nil)
+}
+
+// RegisterFineTunedModel adds a succeeded job's adapter to the model
+// registry, for jobs submitted with Register set to false. Requires the
+// admin token.
+func (c *Client) RegisterFineTunedModel(id string) (*FineTuningJob, error) {
+	return c.fineTuningJobRequest("POST", fineTuningJobEndpoint(id)+"/register", nil)
+}
+
+func (c *Client) fineTuningJobRequest(method, endpoint string, body interface{}) (*FineTuningJob, error) {
+	resp, err := c.Request(method, endpoint, body)
+	if err != nil {
+		return nil, err
+	}
+
+	var job FineTuningJob
+	if err := decodeResponse(resp, &job); err != nil {
+		return nil, err
+	}
+
+	return &job, nil
+}
+
+// WaitForFineTuning polls a job with exponential backoff until it finishes
+// or ctx is done. A failed job is returned along with an error.
+func (c *Client) WaitForFineTuning(ctx context.Context, id string) (*FineTuningJob, error) {
+	delay := jobPollInitial
+
+	for {
+		job, err := c.FineTuningJob(id)
+		if err != nil {
+			return nil, err
+		}
+
+		if job.Done() {
+			if job.Status == "failed" && job.Error != nil {
+				return job, fmt.Errorf("fine-tuning job %s failed: %s", id, *job.Error)
+			}
+			return job, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(delay):
+		}
+
+		delay *= 2
+		if delay > jobPollMax {
+			delay = jobPollMax
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package inferno
+
+import (
+	"net/url"
+	"time"
+)
+
+// Feature flag structures
+type FlagOverrides struct {
+	// Enabled replaces the flag's built-in default when set
+	Enabled *bool `json:"enabled,omitempty"`
+	// Environments holds per-environment settings, by environment name
+	Environments map[string]bool `json:"environments,omitempty"`
+	// APIKeys holds per-key settings. Keys may be given in the clear; the
+	// server stores and returns them as "sha256:<hex>" digests, which it
+	// also accepts.
+	APIKeys map[string]bool `json:"api_keys,omitempty"`
+}
+
+type FeatureFlag struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Default     bool   `json:"default"`
+	// Enabled is the flag's state in the server's environment, before any
+	// per-key override
+	Enabled   bool          `json:"enabled"`
+	Overrides FlagOverrides `json:"overrides"`
+	UpdatedAt *time.Time    `json:"updated_at"`
+}
+
+type FeatureFlagsResponse struct {
+	Object string `json:"object"`
+	// Environment is the server's INFERNO_ENV
+	Environment string        `json:"environment"`
+	Data        []FeatureFlag `json:"data"`
+}
+
+func flagEndpoint(name string) string {
+	return "/admin/flags/" + url.PathEscape(name)
+}
+
+// FeatureFlags lists the server's feature flags. Requires the admin token.
+func (c *Client) FeatureFlags() (*FeatureFlagsResponse, error) {
+	resp, err := c.Request("GET", "/admin/flags", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var result FeatureFlagsResponse
+	if err := decodeResponse(resp, &result); err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+// FeatureFlag returns one flag. Requires the admin token.
+func (c *Client) FeatureFlag(name string) (*FeatureFlag, error) {
+	return c.flagRequest("GET", flagEndpoint(name), nil)
+}
+
+// SetFeatureFlag replaces a flag's overrides. Requires the admin token.
+func (c *Client) SetFeatureFlag(name string, overrides FlagOverrides) (*FeatureFlag, error) {
+	return c.flagRequest("PUT", flagEndpoint(name), overrides)
+}
+
+// ResetFeatureFlag drops a flag's overrides, returning it to its default.
+// Requires the admin token.
+func (c *Client) ResetFeatureFlag(name string) (*FeatureFlag, error) {
+	return c.flagRequest("DELETE", flagEndpoint(name), nil)
+}
+
+// SetFeatureFlagForEnvironment turns a flag on or off in one environment,
+// keeping its other overrides. Requires the admin token.
+func (c *Client) SetFeatureFlagForEnvironment(name, environment string, enabled bool) (*FeatureFlag, error) {
+	return c.updateFeatureFlag(name, func(overrides *FlagOverrides) {
+		if overrides.Environments == nil {
+			overrides.Environments = map[string]bool{}
+		}
+		overrides.Environments[environment] = enabled
+	})
+}
+
+// SetFeatureFlagForKey turns a flag on or off for one API key, keeping its
+// other overrides. Requires the admin token.
+func (c *Client) SetFeatureFlagForKey(name, apiKey string, enabled bool) (*FeatureFlag, error) {
+	return c.updateFeatureFlag(name, func(overrides *FlagOverrides) {
+		if overrides.APIKeys == nil {
+			overrides.APIKeys = map[string]bool{}
+		}
+		overrides.APIKeys[apiKey] = enabled
+	})
+}
+
+// updateFeatureFlag reads a flag's overrides, applies update and writes
+// them back. Concurrent updates to the same flag can overwrite each other.
+func (c *Client) updateFeatureFlag(name string, update func(*FlagOverrides)) (*FeatureFlag, error) {
+	flag, err := c.FeatureFlag(name)
+	if err != nil {
+		return nil, err
+	}
+
+	update(&flag.Overrides)
+	return c.SetFeatureFlag(name, flag.Overrides)
+}
+
+func (c *Client) flagRequest(method, endpoint string, body interface{}) (*FeatureFlag, error) {
+	resp, err := c.Request(method, endpoint, body)
+	if err != nil {
+		return nil, err
+	}
+
+	var flag FeatureFlag
+	if err := decodeResponse(resp, &flag); err != nil {
+		return nil, err
+	}
+
+	return &flag, nil
+}
@@ -0,0 +1,187 @@
+package inferno
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"inferno-example/infernotools"
+)
+
+// DefaultGenerateRetries is how many corrections Generate asks for when
+// StructuredRequest.MaxRetries is zero
+const DefaultGenerateRetries = 2
+
+// StructuredRequest asks a model for JSON matching the type of the value
+// it is decoded into
+type StructuredRequest struct {
+	Model  string
+	Prompt string
+	// System is prepended to the schema instructions
+	System      string
+	MaxTokens   *int
+	Temperature *float32
+	Seed        *uint64
+	// MaxRetries bounds the follow-up requests sent when a reply is not
+	// valid JSON for the schema (default DefaultGenerateRetries; negative
+	// disables retries)
+	MaxRetries int
+	// Schema overrides the schema derived from the output type
+	Schema map[string]interface{}
+}
+
+// StructuredOutputError reports that no reply matched the schema
+type StructuredOutputError struct {
+	// Attempts counts the model calls made
+	Attempts int
+	// Output is the last reply
+	Output string
+	Err    error
+}
+
+func (e *StructuredOutputError) Error() string {
+	return fmt.Sprintf("inferno: no valid structured output after %d attempts: %v", e.Attempts, e.Err)
+}
+
+func (e *StructuredOutputError) Unwrap() error {
+	return e.Err
+}
+
+// Generate asks model to answer prompt with JSON shaped like out, which
+// must be a non-nil pointer, and decodes the reply into it:
+//
+//	var invoice struct {
+//		Number string  `json:"number"`
+//		Total  float64 `json:"total" description:"Amount due, in euros"`
+//	}
+//	err := client.Generate(ctx, "llama-3-8b", "Extract the invoice: "+text, &invoice)
+//
+// See GenerateStructured for the details.
+func (c *Client) Generate(ctx context.Context, model, prompt string, out interface{}) error {
+	return c.GenerateStructured(ctx, StructuredRequest{Model: model, Prompt: prompt}, out)
+}
+
+// GenerateStructured derives a JSON Schema from out's type (see
+// infernotools.SchemaFor), instructs the model to reply with matching JSON
+// and checks the reply against it. A reply that does not parse or match is
+// sent back to the model with the error, up to MaxRetries times; a
+// *StructuredOutputError is returned if none succeeds. Markdown code fences
+// around the JSON are tolerated.
+func (c *Client) GenerateStructured(ctx context.Context, request StructuredRequest, out interface{}) error {
+	target := reflect.ValueOf(out)
+	if target.Kind() != reflect.Ptr || target.IsNil() {
+		return errors.New("inferno: Generate needs a non-nil pointer to decode into")
+	}
+
+	schema := request.Schema
+	if schema == nil {
+		schema = infernotools.SchemaFor(out)
+	}
+	encodedSchema, err := json.Marshal(schema)
+	if err != nil {
+		return err
+	}
+
+	retries := request.MaxRetries
+	if retries == 0 {
+		retries = DefaultGenerateRetries
+	} else if retries < 0 {
+		retries = 0
+	}
+
+	temperature := float32(0)
+	if request.Temperature != nil {
+		temperature = *request.Temperature
+	}
+	chat := ChatCompletionRequest{
+		Model: request.Model,
+		Messages: []ChatMessage{
+			{Role: RoleSystem, Content: structuredInstructions(request.System, encodedSchema)},
+			{Role: RoleUser, Content: request.Prompt},
+		},
+		MaxTokens:   request.MaxTokens,
+		Temperature: &temperature,
+		Seed:        request.Seed,
+	}
+
+	var lastErr error
+	var output string
+	for attempt := 1; attempt <= retries+1; attempt++ {
+		output, err = c.chatContent(ctx, chat)
+		if err != nil {
+			return err
+		}
+
+		document := extractJSON(output)
+		if lastErr = checkStructured(schema, document); lastErr == nil {
+			return json.Unmarshal([]byte(document), out)
+		}
+
+		chat.Messages = append(chat.Messages,
+			ChatMessage{Role: RoleAssistant, Content: output},
+			ChatMessage{Role: RoleUser, Content: fmt.Sprintf(
+				"That reply is invalid: %v. Reply again with only the corrected JSON.", lastErr)},
+		)
+	}
+
+	return &StructuredOutputError{Attempts: retries + 1, Output: output, Err: lastErr}
+}
+
+func structuredInstructions(system string, schema []byte) string {
+	instructions := "Reply with a single JSON value that matches this JSON Schema, " +
+		"and nothing else:\n" + string(schema)
+	if system == "" {
+		return instructions
+	}
+	return system + "\n\n" + instructions
+}
+
+// checkStructured parses document and validates it against schema
+func checkStructured(schema map[string]interface{}, document string) error {
+	var value interface{}
+	if err := json.Unmarshal([]byte(document), &value); err != nil {
+		return fmt.Errorf("not valid JSON: %v", err)
+	}
+	return infernotools.Validate(schema, value)
+}
+
+// extractJSON strips the Markdown code fence models often wrap JSON in
+func extractJSON(output string) string {
+	output = strings.TrimSpace(output)
+	if !strings.HasPrefix(output, "```") {
+		return output
+	}
+
+	output = strings.TrimPrefix(output, "```")
+	if newline := strings.IndexByte(output, '\n'); newline >= 0 {
+		// Drop the info string, e.g. "json"
+		output = output[newline+1:]
+	}
+	output = strings.TrimSuffix(strings.TrimSpace(output), "```")
+	return strings.TrimSpace(output)
+}
+
+// chatContent runs a chat completion and returns the reply's content
+func (c *Client) chatContent(ctx context.Context, request ChatCompletionRequest) (string, error) {
+	if err := c.requireChatCapabilities(ctx, request); err != nil {
+		return "", err
+	}
+
+	resp, err := c.RequestContext(ctx, "POST", "/v1/chat/completions", request)
+	if err != nil {
+		return "", err
+	}
+
+	var result ChatCompletionResponse
+	if err := decodeResponse(resp, &result); err != nil {
+		return "", err
+	}
+	if len(result.Choices) == 0 {
+		return "", fmt.Errorf("no response received")
+	}
+
+	return result.Choices[0].Message.Content, nil
+}
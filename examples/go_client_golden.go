@@ -0,0 +1,35 @@
+package inferno
+
+import (
+	"context"
+	"fmt"
+
+	"inferno-example/infernotest"
+)
+
+// GoldenGenerator returns an infernotest.Generator that sends each prompt to
+// /v1/completions for model with the golden seed, generating up to maxTokens
+// tokens
+func (c *Client) GoldenGenerator(model string, maxTokens int) infernotest.Generator {
+	return func(ctx context.Context, prompt string, seed uint64) (string, error) {
+		request := InferenceRequest{
+			Model:       model,
+			Prompt:      prompt,
+			MaxTokens:   maxTokens,
+			Temperature: 0.7,
+			TopP:        0.9,
+			TopK:        40,
+			Seed:        &seed,
+		}
+
+		resp, err := c.InferenceContext(ctx, request)
+		if err != nil {
+			return "", err
+		}
+		if len(resp.Choices) == 0 {
+			return "", fmt.Errorf("no response received")
+		}
+
+		return resp.Choices[0].Text, nil
+	}
+}
@@ -0,0 +1,105 @@
+package inferno
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// HealthStatus is the outcome of a health check, from best to worst
+type HealthStatus string
+
+const (
+	HealthPass HealthStatus = "pass"
+	HealthWarn HealthStatus = "warn"
+	HealthFail HealthStatus = "fail"
+)
+
+// Health probe structures
+type HealthCheck struct {
+	// Name is the component checked: process, mode, model, queue, gpu,
+	// disk or inference
+	Name       string          `json:"name"`
+	Status     HealthStatus    `json:"status"`
+	Message    string          `json:"message"`
+	Details    json.RawMessage `json:"details,omitempty"`
+	DurationMs int64           `json:"duration_ms,omitempty"`
+}
+
+type HealthReport struct {
+	// Status is the worst of the checks' statuses
+	Status    HealthStatus  `json:"status"`
+	Probe     string        `json:"probe"`
+	Version   string        `json:"version"`
+	Timestamp time.Time     `json:"timestamp"`
+	Checks    []HealthCheck `json:"checks"`
+}
+
+// Healthy reports whether no check failed; warnings still count as healthy
+func (r *HealthReport) Healthy() bool {
+	return r.Status != HealthFail
+}
+
+// Check returns the named check, or nil if the probe did not run it
+func (r *HealthReport) Check(name string) *HealthCheck {
+	for i := range r.Checks {
+		if r.Checks[i].Name == name {
+			return &r.Checks[i]
+		}
+	}
+	return nil
+}
+
+// HealthLive asks whether the server process is up. Restart the server when
+// it fails.
+func (c *Client) HealthLive(ctx context.Context) (*HealthReport, error) {
+	return c.healthProbe(ctx, "/health/live", false)
+}
+
+// HealthReady asks whether the server is taking work: serving, its startup
+// model loaded and its queue below the concurrency limit. Route traffic
+// elsewhere while it is not Healthy.
+func (c *Client) HealthReady(ctx context.Context) (*HealthReport, error) {
+	return c.healthProbe(ctx, "/health/ready", false)
+}
+
+// HealthDeep runs the readiness checks plus a GPU probe, a models disk space
+// check and, unless skipInference, a one-token test inference. The test
+// inference can take up to 30 seconds, so HTTPClient.Timeout does not apply.
+func (c *Client) HealthDeep(ctx context.Context, skipInference bool) (*HealthReport, error) {
+	endpoint := "/health/deep"
+	if skipInference {
+		endpoint += "?skip_inference=true"
+	}
+	return c.healthProbe(ctx, endpoint, true)
+}
+
+// healthProbe returns the report for both healthy (200) and failing (503)
+// probes; other statuses are errors
+func (c *Client) healthProbe(ctx context.Context, endpoint string, long bool) (*HealthReport, error) {
+	var resp *http.Response
+	var err error
+	if long {
+		resp, err = c.longRunningRequest(ctx, "GET", endpoint, nil)
+	} else {
+		resp, err = c.RequestContext(ctx, "GET", endpoint, nil)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		var report HealthReport
+		if err := decodeResponse(resp, &report); err != nil {
+			return nil, err
+		}
+		return &report, nil
+	}
+	defer resp.Body.Close()
+
+	var report HealthReport
+	if err := json.NewDecoder(resp.Body).Decode(&report); err != nil {
+		return nil, err
+	}
+	return &report, nil
+}
@@ -0,0 +1,88 @@
+package inferno
+
+import "context"
+
+// Hidden-state pooling modes
+const (
+	PoolingNone = "none"
+	PoolingMean = "mean"
+	PoolingLast = "last"
+	PoolingMax  = "max"
+)
+
+// Hidden-state structures
+type HiddenStatesRequest struct {
+	Model string   `json:"model"`
+	Input []string `json:"input"`
+	// Pooling reduces each input's token vectors to one (server default
+	// PoolingMean); PoolingNone returns a vector per token
+	Pooling string `json:"pooling,omitempty"`
+	// Normalize scales every vector to unit length
+	Normalize bool `json:"normalize,omitempty"`
+}
+
+// LayerInfo describes where hidden states were taken from
+type LayerInfo struct {
+	// Layer counts transformer blocks from 1; nil when the model's metadata
+	// does not record its depth
+	Layer      *int   `json:"layer,omitempty"`
+	NumLayers  *int   `json:"num_layers,omitempty"`
+	HiddenSize int    `json:"hidden_size"`
+	Pooling    string `json:"pooling"`
+	Normalized bool   `json:"normalized"`
+}
+
+type HiddenStateData struct {
+	Index int `json:"index"`
+	// Tokens is the input's token count, including any BOS token
+	Tokens       int         `json:"tokens"`
+	HiddenStates [][]float32 `json:"hidden_states"`
+}
+
+type HiddenStatesResponse struct {
+	Model string            `json:"model"`
+	Layer LayerInfo         `json:"layer"`
+	Data  []HiddenStateData `json:"data"`
+}
+
+// HiddenStates returns a generative model's final-layer hidden states for
+// each text, per token or pooled as the request asks
+func (c *Client) HiddenStates(ctx context.Context, request HiddenStatesRequest) (*HiddenStatesResponse, error) {
+	if err := c.requireCapabilities(ctx, nil, request.Model, CapabilityHiddenStates); err != nil {
+		return nil, err
+	}
+
+	resp, err := c.RequestContext(ctx, "POST", "/v1/hidden_states", request)
+	if err != nil {
+		return nil, err
+	}
+
+	var result HiddenStatesResponse
+	if err := decodeResponse(resp, &result); err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+// PooledHiddenStates returns one pooled, unit-length representation per
+// text, in input order, for similarity search and classifiers
+func (c *Client) PooledHiddenStates(ctx context.Context, model, pooling string, texts ...string) ([][]float32, *LayerInfo, error) {
+	result, err := c.HiddenStates(ctx, HiddenStatesRequest{
+		Model:     model,
+		Input:     texts,
+		Pooling:   pooling,
+		Normalize: true,
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	vectors := make([][]float32, len(texts))
+	for _, data := range result.Data {
+		if data.Index < len(vectors) && len(data.HiddenStates) > 0 {
+			vectors[data.Index] = data.HiddenStates[0]
+		}
+	}
+	return vectors, &result.Layer, nil
+}
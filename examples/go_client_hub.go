@@ -0,0 +1,178 @@
+package inferno
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"time"
+)
+
+// How often PullFromHub checks on a download
+const downloadPollInterval = time.Second
+
+// Model download structures
+type ModelDownloadRequest struct {
+	// Source is hf://org/repo[:revision] or ollama://[namespace/]model[:tag]
+	Source string `json:"source"`
+	// File picks an exact file in the repo instead of matching Quantization
+	// (hf:// only)
+	File *string `json:"file,omitempty"`
+	// Quantization lists quantizations to look for, most preferred first;
+	// empty uses the server's hub.quantization_preference (hf:// only)
+	Quantization []string `json:"quantization,omitempty"`
+	// Name saves the model under another file name
+	Name *string `json:"name,omitempty"`
+}
+
+type ModelDownload struct {
+	ID              string     `json:"id"`
+	Source          string     `json:"source"`
+	Registry        string     `json:"registry"`
+	Repo            string     `json:"repo"`
+	Revision        string     `json:"revision"`
+	Commit          string     `json:"commit"`
+	File            string     `json:"file"`
+	Quantization    *string    `json:"quantization,omitempty"`
+	Status          string     `json:"status"`
+	DownloadedBytes uint64     `json:"downloaded_bytes"`
+	TotalBytes      *uint64    `json:"total_bytes,omitempty"`
+	ExpectedSHA256  *string    `json:"expected_sha256,omitempty"`
+	SHA256          *string    `json:"sha256,omitempty"`
+	Verified        bool       `json:"verified"`
+	Model           string     `json:"model"`
+	Path            string     `json:"path"`
+	Registered      bool       `json:"registered"`
+	CreatedAt       time.Time  `json:"created_at"`
+	FinishedAt      *time.Time `json:"finished_at,omitempty"`
+	Error           *string    `json:"error,omitempty"`
+}
+
+// Done reports whether the download has reached a terminal state
+func (d *ModelDownload) Done() bool {
+	switch d.Status {
+	case "succeeded", "failed", "cancelled":
+		return true
+	}
+	return false
+}
+
+// Fraction returns how much has been received, or -1 while the size is
+// unknown
+func (d *ModelDownload) Fraction() float64 {
+	if d.TotalBytes == nil || *d.TotalBytes == 0 {
+		return -1
+	}
+	return float64(d.DownloadedBytes) / float64(*d.TotalBytes)
+}
+
+type ModelDownloadsResponse struct {
+	Object string          `json:"object"`
+	Data   []ModelDownload `json:"data"`
+}
+
+func modelDownloadEndpoint(id string) string {
+	return "/v1/models/downloads/" + url.PathEscape(id)
+}
+
+// DownloadModel starts pulling a GGUF model from the Hugging Face Hub or an
+// Ollama registry and returns immediately. Requires the admin token.
+func (c *Client) DownloadModel(req ModelDownloadRequest) (*ModelDownload, error) {
+	return c.modelDownloadRequest("POST", "/v1/models/download", req)
+}
+
+// ModelDownloads lists downloads, newest first. Requires the admin token.
+func (c *Client) ModelDownloads() ([]ModelDownload, error) {
+	resp, err := c.Request("GET", "/v1/models/downloads", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var result ModelDownloadsResponse
+	if err := decodeResponse(resp, &result); err != nil {
+		return nil, err
+	}
+
+	return result.Data, nil
+}
+
+// ModelDownload returns a download's progress. Requires the admin token.
+func (c *Client) ModelDownload(id string) (*ModelDownload, error) {
+	return c.modelDownloadRequest("GET", modelDownloadEndpoint(id), nil)
+}
+
+// CancelModelDownload stops a download and discards the partial file.
+// Requires the admin token.
+func (c *Client) CancelModelDownload(id string) (*ModelDownload, error) {
+	return c.modelDownloadRequest("POST", modelDownloadEndpoint(id)+"/cancel", nil)
+}
+
+func (c *Client) modelDownloadRequest(method, endpoint string, body interface{}) (*ModelDownload, error) {
+	resp, err := c.Request(method, endpoint, body)
+	if err != nil {
+		return nil, err
+	}
+
+	var download ModelDownload
+	if err := decodeResponse(resp, &download); err != nil {
+		return nil, err
+	}
+
+	return &download, nil
+}
+
+// PullFromOllama pulls a model from the Ollama registry by its Ollama name,
+// such as "llama3.2:3b", and waits like PullFromHub. The model keeps that
+// name, so it can be passed as the model in completion requests. Requires the
+// admin token.
+func (c *Client) PullFromOllama(ctx context.Context, name string, progress func(*ModelDownload)) (*ModelDownload, error) {
+	return c.PullFromHub(ctx, "ollama://"+name, nil, progress)
+}
+
+// PullFromHub downloads a model from the Hugging Face Hub and waits until it
+// is verified and registered, calling progress (if non-nil) about once a
+// second. source is hf://org/repo[:revision] or an ollama:// name;
+// quantizations lists the preferred quantizations for hf:// sources, or none
+// for the server's default. If ctx is done first the download is cancelled on
+// the server. Requires the admin token.
+func (c *Client) PullFromHub(ctx context.Context, source string, quantizations []string, progress func(*ModelDownload)) (*ModelDownload, error) {
+	return c.PullModel(ctx, ModelDownloadRequest{Source: source, Quantization: quantizations}, progress)
+}
+
+// PullModel is PullFromHub for a full download request, to pick an exact
+// file or save the model under another name. Requires the admin token.
+func (c *Client) PullModel(ctx context.Context, req ModelDownloadRequest, progress func(*ModelDownload)) (*ModelDownload, error) {
+	source := req.Source
+	download, err := c.DownloadModel(req)
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		if progress != nil {
+			progress(download)
+		}
+
+		if download.Done() {
+			if download.Status != "succeeded" {
+				reason := download.Status
+				if download.Error != nil {
+					reason = *download.Error
+				}
+				return download, fmt.Errorf("pulling %s failed: %s", source, reason)
+			}
+			return download, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			c.CancelModelDownload(download.ID)
+			return nil, ctx.Err()
+		case <-time.After(downloadPollInterval):
+		}
+
+		download, err = c.ModelDownload(download.ID)
+		if err != nil {
+			return nil, err
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package inferno
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Asynchronous inference job structures
+type InferenceJob struct {
+	ID         string             `json:"id"`
+	Model      string             `json:"model"`
+	Status     InferenceJobStatus `json:"status"`
+	CreatedAt  time.Time          `json:"created_at"`
+	StartedAt  *time.Time         `json:"started_at,omitempty"`
+	FinishedAt *time.Time         `json:"finished_at,omitempty"`
+	Error      *string            `json:"error,omitempty"`
+	// Metadata is the submitted request's Metadata
+	Metadata map[string]string `json:"metadata,omitempty"`
+}
+
+// Done reports whether the job has reached a terminal state
+func (j *InferenceJob) Done() bool {
+	return j.Status.Done()
+}
+
+// ErrJobPending is returned by InferenceJobResult while the job is still running
+var ErrJobPending = errors.New("inferno: inference job has not finished")
+
+// Polling backoff for WaitForInference
+const (
+	jobPollInitial = 500 * time.Millisecond
+	jobPollMax     = 10 * time.Second
+)
+
+// SubmitInference queues a completion as an asynchronous job and returns
+// immediately, so long generations survive load balancer idle timeouts
+func (c *Client) SubmitInference(request InferenceRequest) (*InferenceJob, error) {
+	request.Stream = false
+
+	resp, err := c.Request("POST", "/v1/inference/async", request)
+	if err != nil {
+		return nil, err
+	}
+
+	var job InferenceJob
+	if err := decodeResponse(resp, &job); err != nil {
+		return nil, err
+	}
+
+	return &job, nil
+}
+
+// InferenceJob gets the current state of an asynchronous job
+func (c *Client) InferenceJob(ctx context.Context, jobID string) (*InferenceJob, error) {
+	endpoint := fmt.Sprintf("/v1/inference/jobs/%s", jobID)
+	resp, err := c.RequestContext(ctx, "GET", endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var job InferenceJob
+	if err := decodeResponse(resp, &job); err != nil {
+		return nil, err
+	}
+
+	return &job, nil
+}
+
+// InferenceJobResult fetches the output of a finished job, returning
+// ErrJobPending if it is still queued or running
+func (c *Client) InferenceJobResult(ctx context.Context, jobID string) (*InferenceResponse, error) {
+	endpoint := fmt.Sprintf("/v1/inference/jobs/%s/result", jobID)
+	resp, err := c.RequestContext(ctx, "GET", endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode == http.StatusAccepted {
+		resp.Body.Close()
+		return nil, ErrJobPending
+	}
+
+	var result InferenceResponse
+	if err := decodeResponse(resp, &result); err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+// WaitForInference polls a job with exponential backoff until it finishes or
+// ctx is done, then returns its result
+func (c *Client) WaitForInference(ctx context.Context, jobID string) (*InferenceResponse, error) {
+	delay := jobPollInitial
+
+	for {
+		job, err := c.InferenceJob(ctx, jobID)
+		if err != nil {
+			return nil, err
+		}
+
+		if job.Done() {
+			if job.Status == InferenceJobFailed && job.Error != nil {
+				return nil, fmt.Errorf("inference job %s failed: %s", jobID, *job.Error)
+			}
+			return c.InferenceJobResult(ctx, jobID)
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(delay):
+		}
+
+		delay *= 2
+		if delay > jobPollMax {
+			delay = jobPollMax
+		}
+	}
+}
@@ -0,0 +1,152 @@
+package inferno
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// tokenRefreshMargin is how long before expiry a token is replaced, so a
+// request never leaves with one about to lapse
+const tokenRefreshMargin = 30 * time.Second
+
+// Token is a bearer token and when it stops being valid
+type Token struct {
+	AccessToken string
+	// Expiry is zero for tokens that do not expire
+	Expiry time.Time
+}
+
+// TokenSource supplies the short-lived JWTs a client sends as bearer
+// tokens when Client.TokenSource is set
+type TokenSource interface {
+	Token(ctx context.Context) (*Token, error)
+}
+
+// IssuerTokenSource fetches tokens from an OAuth 2.0 issuer with the client
+// credentials grant
+type IssuerTokenSource struct {
+	// TokenURL is the issuer's token endpoint
+	TokenURL     string
+	ClientID     string
+	ClientSecret string
+	// Scope and Audience are sent when set
+	Scope    string
+	Audience string
+	// HTTPClient defaults to one with a 10 second timeout
+	HTTPClient *http.Client
+}
+
+func (s *IssuerTokenSource) Token(ctx context.Context) (*Token, error) {
+	form := url.Values{
+		"grant_type":    {"client_credentials"},
+		"client_id":     {s.ClientID},
+		"client_secret": {s.ClientSecret},
+	}
+	if s.Scope != "" {
+		form.Set("scope", s.Scope)
+	}
+	if s.Audience != "" {
+		form.Set("audience", s.Audience)
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", s.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	httpClient := s.HTTPClient
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: 10 * time.Second}
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("inferno: token issuer returned %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	var result struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int64  `json:"expires_in"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, err
+	}
+	if result.AccessToken == "" {
+		return nil, fmt.Errorf("inferno: token issuer returned no access_token")
+	}
+	token := &Token{AccessToken: result.AccessToken}
+	if result.ExpiresIn > 0 {
+		token.Expiry = time.Now().Add(time.Duration(result.ExpiresIn) * time.Second)
+	}
+	return token, nil
+}
+
+// bearerToken returns the current token, fetching a new one when there is
+// none or it is about to expire
+func (c *Client) bearerToken(ctx context.Context) (string, error) {
+	c.tokenMu.Lock()
+	defer c.tokenMu.Unlock()
+
+	if c.token != nil && (c.token.Expiry.IsZero() || time.Until(c.token.Expiry) > tokenRefreshMargin) {
+		return c.token.AccessToken, nil
+	}
+	token, err := c.TokenSource.Token(ctx)
+	if err != nil {
+		return "", fmt.Errorf("inferno: refreshing token: %w", err)
+	}
+	c.token = token
+	return token.AccessToken, nil
+}
+
+// invalidateToken drops the cached token if it is still stale, so the next
+// request fetches another
+func (c *Client) invalidateToken(stale string) {
+	c.tokenMu.Lock()
+	defer c.tokenMu.Unlock()
+	if c.token != nil && c.token.AccessToken == stale {
+		c.token = nil
+	}
+}
+
+// tokenExpired reports whether resp refused an expired bearer token
+func tokenExpired(resp *http.Response) bool {
+	return resp.StatusCode == http.StatusUnauthorized &&
+		strings.Contains(resp.Header.Get("WWW-Authenticate"), `error_description="token expired"`)
+}
+
+// refreshRequest copies req with a freshly fetched token, if the client has
+// a TokenSource and req's body can be sent again
+func (c *Client) refreshRequest(req *http.Request) (*http.Request, bool) {
+	if c.TokenSource == nil {
+		return nil, false
+	}
+	if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+		return nil, false
+	}
+	c.invalidateToken(strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer "))
+	token, err := c.bearerToken(req.Context())
+	if err != nil {
+		return nil, false
+	}
+
+	retry := req.Clone(req.Context())
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, false
+		}
+		retry.Body = body
+	}
+	retry.Header.Set("Authorization", "Bearer "+token)
+	return retry, true
+}
@@ -0,0 +1,137 @@
+package inferno
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// API key structures
+type KeyScopes struct {
+	// Models the key may use; empty allows every model
+	Models []string `json:"models,omitempty"`
+	// Endpoints are path prefixes the key may call, such as
+	// "/v1/embeddings"; empty allows every endpoint
+	Endpoints []string `json:"endpoints,omitempty"`
+	// Sampling limits; unset limits are unlimited
+	MaxTokens      *int64   `json:"max_tokens,omitempty"`
+	MaxN           *int64   `json:"max_n,omitempty"`
+	MaxTemperature *float64 `json:"max_temperature,omitempty"`
+}
+
+type APIKey struct {
+	Object string `json:"object"`
+	ID     string `json:"id"`
+	Name   string `json:"name,omitempty"`
+	// Prefix is the start of the secret, to tell keys apart
+	Prefix     string     `json:"prefix"`
+	Scopes     KeyScopes  `json:"scopes"`
+	Revoked    bool       `json:"revoked"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+}
+
+// CreatedAPIKey is a new key with its secret, which the server does not
+// show again
+type CreatedAPIKey struct {
+	APIKey
+	Secret string `json:"secret"`
+}
+
+type APIKeysResponse struct {
+	Object string   `json:"object"`
+	Data   []APIKey `json:"data"`
+}
+
+type CreateAPIKeyRequest struct {
+	Name   string    `json:"name,omitempty"`
+	Scopes KeyScopes `json:"scopes"`
+}
+
+// UpdateAPIKeyRequest changes the fields that are set and leaves the rest
+type UpdateAPIKeyRequest struct {
+	Name   *string    `json:"name,omitempty"`
+	Scopes *KeyScopes `json:"scopes,omitempty"`
+}
+
+// ModelPermission is what the requesting key may do with a model, as
+// /v1/models reports it for managed keys
+type ModelPermission struct {
+	ID             string   `json:"id"`
+	Object         string   `json:"object"`
+	KeyID          string   `json:"key_id,omitempty"`
+	AllowView      bool     `json:"allow_view"`
+	AllowSampling  bool     `json:"allow_sampling"`
+	Endpoints      []string `json:"endpoints,omitempty"`
+	MaxTokens      *int64   `json:"max_tokens,omitempty"`
+	MaxN           *int64   `json:"max_n,omitempty"`
+	MaxTemperature *float64 `json:"max_temperature,omitempty"`
+}
+
+// CurrentKey describes the managed API key the client authenticates with.
+// It returns nil and no error when the key is not managed by the server,
+// and so is not scoped.
+func (c *Client) CurrentKey(ctx context.Context) (*APIKey, error) {
+	resp, err := c.RequestContext(ctx, "GET", "/v1/keys/current", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var key APIKey
+	if err := decodeResponse(resp, &key); err != nil {
+		var apiErr *APIError
+		if errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &key, nil
+}
+
+// APIKeys lists every managed key, without secrets
+func (a *AdminClient) APIKeys(ctx context.Context) ([]APIKey, error) {
+	var result APIKeysResponse
+	if err := a.adminRequest(ctx, "GET", "/admin/keys", nil, &result); err != nil {
+		return nil, err
+	}
+	return result.Data, nil
+}
+
+// APIKeyByID returns one key, without its secret
+func (a *AdminClient) APIKeyByID(ctx context.Context, id string) (*APIKey, error) {
+	return a.keyRequest(ctx, "GET", keyPath(id), nil)
+}
+
+// CreateAPIKey issues a key with the given scopes. Keep the returned
+// Secret: the server stores only its digest.
+func (a *AdminClient) CreateAPIKey(ctx context.Context, req CreateAPIKeyRequest) (*CreatedAPIKey, error) {
+	var created CreatedAPIKey
+	if err := a.adminRequest(ctx, "POST", "/admin/keys", req, &created); err != nil {
+		return nil, err
+	}
+	return &created, nil
+}
+
+// UpdateAPIKey changes a key's name or scopes
+func (a *AdminClient) UpdateAPIKey(ctx context.Context, id string, req UpdateAPIKeyRequest) (*APIKey, error) {
+	return a.keyRequest(ctx, "PATCH", keyPath(id), req)
+}
+
+// RevokeAPIKey refuses the key from now on; it stays listed as revoked
+func (a *AdminClient) RevokeAPIKey(ctx context.Context, id string) (*APIKey, error) {
+	return a.keyRequest(ctx, "DELETE", keyPath(id), nil)
+}
+
+func (a *AdminClient) keyRequest(ctx context.Context, method, endpoint string, body interface{}) (*APIKey, error) {
+	var key APIKey
+	if err := a.adminRequest(ctx, method, endpoint, body, &key); err != nil {
+		return nil, err
+	}
+	return &key, nil
+}
+
+func keyPath(id string) string {
+	return "/admin/keys/" + url.PathEscape(id)
+}
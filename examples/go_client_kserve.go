@@ -0,0 +1,148 @@
+package inferno
+
+import (
+	"fmt"
+	"net/url"
+)
+
+// KServe v2 inference protocol structures
+type KServeTensorMetadata struct {
+	Name     string `json:"name"`
+	Datatype string `json:"datatype"`
+	// Shape uses -1 for variable dimensions
+	Shape []int64 `json:"shape"`
+}
+
+type KServeModelMetadata struct {
+	Name     string                 `json:"name"`
+	Versions []string               `json:"versions"`
+	Platform string                 `json:"platform"`
+	Inputs   []KServeTensorMetadata `json:"inputs"`
+	Outputs  []KServeTensorMetadata `json:"outputs"`
+}
+
+// KServeInferParameters are the sampling options of an infer request
+type KServeInferParameters struct {
+	MaxTokens   *int     `json:"max_tokens,omitempty"`
+	Temperature *float32 `json:"temperature,omitempty"`
+	TopP        *float32 `json:"top_p,omitempty"`
+	TopK        *int     `json:"top_k,omitempty"`
+	Stop        []string `json:"stop,omitempty"`
+	Seed        *uint64  `json:"seed,omitempty"`
+	// TimeoutMs budgets the whole request, every prompt included
+	TimeoutMs *int64 `json:"timeout_ms,omitempty"`
+}
+
+// KServeInferInput is an input tensor; Inferno accepts a single BYTES
+// tensor named "text_input"
+type KServeInferInput struct {
+	Name     string   `json:"name"`
+	Shape    []int64  `json:"shape"`
+	Datatype string   `json:"datatype"`
+	Data     []string `json:"data"`
+}
+
+type KServeRequestedOutput struct {
+	// Name is "text_output" or "finish_reason"
+	Name string `json:"name"`
+}
+
+type KServeInferRequest struct {
+	ID         string                  `json:"id,omitempty"`
+	Parameters *KServeInferParameters  `json:"parameters,omitempty"`
+	Inputs     []KServeInferInput      `json:"inputs"`
+	Outputs    []KServeRequestedOutput `json:"outputs,omitempty"`
+}
+
+type KServeInferOutput struct {
+	Name     string   `json:"name"`
+	Shape    []int64  `json:"shape"`
+	Datatype string   `json:"datatype"`
+	Data     []string `json:"data"`
+}
+
+type KServeInferResponse struct {
+	ModelName    string              `json:"model_name"`
+	ModelVersion string              `json:"model_version"`
+	ID           string              `json:"id"`
+	Outputs      []KServeInferOutput `json:"outputs"`
+}
+
+// Output returns the named output tensor, or nil if it was not returned
+func (r *KServeInferResponse) Output(name string) *KServeInferOutput {
+	for i := range r.Outputs {
+		if r.Outputs[i].Name == name {
+			return &r.Outputs[i]
+		}
+	}
+	return nil
+}
+
+// KServeTextInput wraps prompts as the "text_input" tensor
+func KServeTextInput(prompts ...string) KServeInferInput {
+	return KServeInferInput{
+		Name:     "text_input",
+		Shape:    []int64{int64(len(prompts))},
+		Datatype: "BYTES",
+		Data:     prompts,
+	}
+}
+
+// KServeModelMetadata fetches a model's v2 metadata
+func (c *Client) KServeModelMetadata(model string) (*KServeModelMetadata, error) {
+	resp, err := c.Request("GET", "/v2/models/"+url.PathEscape(model), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var metadata KServeModelMetadata
+	if err := decodeResponse(resp, &metadata); err != nil {
+		return nil, err
+	}
+
+	return &metadata, nil
+}
+
+// KServeModelReady reports whether the server can serve model
+func (c *Client) KServeModelReady(model string) (bool, error) {
+	resp, err := c.Request("GET", "/v2/models/"+url.PathEscape(model)+"/ready", nil)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	return resp.StatusCode == 200, nil
+}
+
+// KServeInfer sends a v2 infer request to model
+func (c *Client) KServeInfer(model string, req KServeInferRequest) (*KServeInferResponse, error) {
+	resp, err := c.Request("POST", "/v2/models/"+url.PathEscape(model)+"/infer", req)
+	if err != nil {
+		return nil, err
+	}
+
+	var result KServeInferResponse
+	if err := decodeResponse(resp, &result); err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+// KServeGenerate runs each prompt through model and returns the texts in order
+func (c *Client) KServeGenerate(model string, params *KServeInferParameters, prompts ...string) ([]string, error) {
+	result, err := c.KServeInfer(model, KServeInferRequest{
+		Parameters: params,
+		Inputs:     []KServeInferInput{KServeTextInput(prompts...)},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	output := result.Output("text_output")
+	if output == nil {
+		return nil, fmt.Errorf("kserve: response has no text_output")
+	}
+
+	return output.Data, nil
+}
@@ -0,0 +1,266 @@
+package inferno
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Defaults of a ConcurrencyLimiter's zero fields
+const (
+	defaultInitialLimit   = 10
+	defaultMaxLimit       = 200
+	defaultLimitBackoff   = 0.9
+	defaultLimitTolerance = 2.0
+)
+
+// minLatencyWindow is how long the lowest latency seen is kept as the
+// no-load baseline before a newer sample may replace it
+const minLatencyWindow = time.Minute
+
+// minCutInterval is the least time between cuts, for when no round trip
+// has been measured or it is very short
+const minCutInterval = 100 * time.Millisecond
+
+// smoothingWeight is the weight of each new sample in the smoothed latency
+const smoothingWeight = 0.1
+
+// ConcurrencyLimiter caps the requests a client has in flight at a limit it
+// adjusts as it goes. Each success while the limit is in use raises it by
+// one per limit's worth of requests; a 429 or 503, a transport error or a
+// timeout cuts it by Backoff, as does latency rising past Tolerance times
+// the lowest seen, in proportion. Cuts happen at most once per round trip,
+// so a burst of refusals counts once. Requests over the limit wait in
+// order for a slot. A ConcurrencyLimiter is safe for use by several
+// clients, which then share the limit.
+//
+// Latency is measured to the response headers, so it reflects queueing on
+// the server best for workloads of similar requests; leave Tolerance high
+// for mixed generation lengths.
+type ConcurrencyLimiter struct {
+	// MinLimit and MaxLimit bound the limit; zero means 1 and 200
+	MinLimit int
+	MaxLimit int
+	// Backoff multiplies the limit on overload; zero means 0.9
+	Backoff float64
+	// Tolerance is how many times the no-load latency requests may take
+	// before the limit shrinks; zero means 2
+	Tolerance float64
+
+	mu         sync.Mutex
+	limit      float64
+	inFlight   int
+	waiters    []chan struct{}
+	minLatency time.Duration
+	minSince   time.Time
+	smoothed   time.Duration
+	lastCut    time.Time
+}
+
+// NewConcurrencyLimiter returns a limiter starting at initial requests in
+// flight (zero for 10)
+func NewConcurrencyLimiter(initial int) *ConcurrencyLimiter {
+	if initial <= 0 {
+		initial = defaultInitialLimit
+	}
+	return &ConcurrencyLimiter{limit: float64(initial)}
+}
+
+// LimiterStats is a snapshot of a ConcurrencyLimiter, for metrics
+type LimiterStats struct {
+	// Limit is the requests allowed in flight now
+	Limit    int
+	InFlight int
+	// Queued is the requests waiting for a slot
+	Queued int
+	// MinLatency is the no-load baseline; Latency is the smoothed latency
+	MinLatency time.Duration
+	Latency    time.Duration
+}
+
+// Stats returns the limit, requests in flight and queue depth
+func (l *ConcurrencyLimiter) Stats() LimiterStats {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.init()
+	return LimiterStats{
+		Limit:      int(l.limit),
+		InFlight:   l.inFlight,
+		Queued:     len(l.waiters),
+		MinLatency: l.minLatency,
+		Latency:    l.smoothed,
+	}
+}
+
+// init applies the defaults; l.mu is held
+func (l *ConcurrencyLimiter) init() {
+	if l.limit == 0 {
+		l.limit = defaultInitialLimit
+	}
+	l.limit = math.Max(float64(l.minLimit()), math.Min(float64(l.maxLimit()), l.limit))
+}
+
+func (l *ConcurrencyLimiter) minLimit() int {
+	if l.MinLimit <= 0 {
+		return 1
+	}
+	return l.MinLimit
+}
+
+func (l *ConcurrencyLimiter) maxLimit() int {
+	if l.MaxLimit <= 0 {
+		return defaultMaxLimit
+	}
+	return l.MaxLimit
+}
+
+// acquire waits for a slot, or for ctx to be done
+func (l *ConcurrencyLimiter) acquire(ctx context.Context) error {
+	l.mu.Lock()
+	l.init()
+	if len(l.waiters) == 0 && l.inFlight < int(l.limit) {
+		l.inFlight++
+		l.mu.Unlock()
+		return nil
+	}
+	granted := make(chan struct{})
+	l.waiters = append(l.waiters, granted)
+	l.mu.Unlock()
+
+	select {
+	case <-granted:
+		return nil
+	case <-ctx.Done():
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		for i, waiter := range l.waiters {
+			if waiter == granted {
+				l.waiters = append(l.waiters[:i], l.waiters[i+1:]...)
+				return ctx.Err()
+			}
+		}
+		// The slot was granted as ctx ended; hand it on
+		l.inFlight--
+		l.grant()
+		return ctx.Err()
+	}
+}
+
+// release frees a slot
+func (l *ConcurrencyLimiter) release() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.inFlight--
+	l.grant()
+}
+
+// grant gives free slots to waiters in order; l.mu is held
+func (l *ConcurrencyLimiter) grant() {
+	for len(l.waiters) > 0 && l.inFlight < int(l.limit) {
+		close(l.waiters[0])
+		l.waiters = l.waiters[1:]
+		l.inFlight++
+	}
+}
+
+// record adjusts the limit by one request's outcome, returning the limit
+// before and after
+func (l *ConcurrencyLimiter) record(overloaded bool, latency time.Duration) (before, after int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.init()
+	before = int(l.limit)
+	now := time.Now()
+
+	if !overloaded {
+		if l.minLatency == 0 || latency < l.minLatency || now.Sub(l.minSince) > minLatencyWindow {
+			l.minLatency, l.minSince = latency, now
+		}
+		if l.smoothed == 0 {
+			l.smoothed = latency
+		} else {
+			l.smoothed += time.Duration(smoothingWeight * float64(latency-l.smoothed))
+		}
+	}
+
+	// Cut at most once per round trip
+	cooldown := l.smoothed
+	if cooldown < minCutInterval {
+		cooldown = minCutInterval
+	}
+	mayCut := now.Sub(l.lastCut) >= cooldown
+	tolerance := l.Tolerance
+	if tolerance <= 0 {
+		tolerance = defaultLimitTolerance
+	}
+	switch {
+	case overloaded:
+		if mayCut {
+			backoff := l.Backoff
+			if backoff <= 0 || backoff >= 1 {
+				backoff = defaultLimitBackoff
+			}
+			l.limit *= backoff
+			l.lastCut = now
+		}
+	case l.minLatency > 0 && float64(l.smoothed) > tolerance*float64(l.minLatency):
+		if mayCut {
+			l.limit *= math.Max(0.5, tolerance*float64(l.minLatency)/float64(l.smoothed))
+			l.lastCut = now
+		}
+	case float64(l.inFlight) >= l.limit/2:
+		// Grow only while the limit is what holds requests back
+		l.limit += 1 / l.limit
+	}
+	l.init()
+	l.grant()
+	return before, int(l.limit)
+}
+
+// limitedBody releases the request's slot when the response is closed, so
+// a stream holds its slot while it is read
+type limitedBody struct {
+	io.ReadCloser
+	once    sync.Once
+	release func()
+}
+
+func (b *limitedBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(b.release)
+	return err
+}
+
+// sendDirect is send without deduplication: with a Limiter, it waits for a
+// slot first and feeds the outcome back
+func (c *Client) sendDirect(httpClient *http.Client, req *http.Request) (*http.Response, error) {
+	limiter := c.Limiter
+	if limiter == nil {
+		return c.sendAttempts(httpClient, req)
+	}
+	if err := limiter.acquire(req.Context()); err != nil {
+		return nil, err
+	}
+
+	started := time.Now()
+	resp, err := c.sendAttempts(httpClient, req)
+	latency := time.Since(started)
+
+	ctxErr := req.Context().Err()
+	if err == nil || ctxErr == nil || errors.Is(ctxErr, context.DeadlineExceeded) {
+		overloaded := err != nil || resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusServiceUnavailable
+		if before, after := limiter.record(overloaded, latency); after != before {
+			c.emit(ClientEvent{Type: EventConcurrencyLimitChanged, Limit: after})
+		}
+	}
+	if err != nil {
+		limiter.release()
+		return nil, err
+	}
+	resp.Body = &limitedBody{ReadCloser: resp.Body, release: limiter.release}
+	return resp, nil
+}
@@ -0,0 +1,395 @@
+//go:build local
+
+package inferno
+
+/*
+#cgo LDFLAGS: -lllama
+#include <stdlib.h>
+#include <llama.h>
+*/
+import "C"
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+	"unsafe"
+)
+
+// Defaults of the local backend
+const (
+	defaultLocalContextSize = 2048
+	defaultLocalMaxTokens   = 256
+)
+
+var llamaInit sync.Once
+
+// LocalBackend runs inference in-process on a GGUF model through llama.cpp,
+// for applications to fall back on when the server is down. Calls are
+// serialized; each gets a fresh context, so none sees another's tokens.
+type LocalBackend struct {
+	mu      sync.Mutex
+	options LocalOptions
+	name    string
+	model   *C.struct_llama_model
+	vocab   *C.struct_llama_vocab
+}
+
+// NewLocalBackend loads options.ModelPath. Close the backend to free it.
+func NewLocalBackend(options LocalOptions) (*LocalBackend, error) {
+	if options.ModelPath == "" {
+		return nil, errors.New("inferno: local backend needs a model path")
+	}
+	if options.ContextSize <= 0 {
+		options.ContextSize = defaultLocalContextSize
+	}
+	llamaInit.Do(func() { C.llama_backend_init() })
+
+	params := C.llama_model_default_params()
+	params.n_gpu_layers = C.int32_t(options.GPULayers)
+	path := C.CString(options.ModelPath)
+	defer C.free(unsafe.Pointer(path))
+	model := C.llama_model_load_from_file(path, params)
+	if model == nil {
+		return nil, fmt.Errorf("inferno: loading local model %s failed", options.ModelPath)
+	}
+	return &LocalBackend{
+		options: options,
+		name:    strings.TrimSuffix(filepath.Base(options.ModelPath), filepath.Ext(options.ModelPath)),
+		model:   model,
+		vocab:   C.llama_model_get_vocab(model),
+	}, nil
+}
+
+// Close frees the model
+func (l *LocalBackend) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.model != nil {
+		C.llama_model_free(l.model)
+		l.model = nil
+	}
+	return nil
+}
+
+// newContext creates a context for one call
+func (l *LocalBackend) newContext(embeddings bool) (*C.struct_llama_context, error) {
+	if l.model == nil {
+		return nil, errors.New("inferno: local backend is closed")
+	}
+	params := C.llama_context_default_params()
+	params.n_ctx = C.uint32_t(l.options.ContextSize)
+	params.n_batch = C.uint32_t(l.options.ContextSize)
+	params.embeddings = C.bool(embeddings)
+	if l.options.Threads > 0 {
+		params.n_threads = C.int32_t(l.options.Threads)
+		params.n_threads_batch = C.int32_t(l.options.Threads)
+	}
+	lctx := C.llama_init_from_model(l.model, params)
+	if lctx == nil {
+		return nil, errors.New("inferno: creating a local context failed")
+	}
+	return lctx, nil
+}
+
+// tokenize turns text into tokens, adding BOS and the like
+func (l *LocalBackend) tokenize(text string) ([]C.llama_token, error) {
+	ctext := C.CString(text)
+	defer C.free(unsafe.Pointer(ctext))
+
+	tokens := make([]C.llama_token, len(text)+8)
+	n := C.llama_tokenize(l.vocab, ctext, C.int32_t(len(text)), &tokens[0], C.int32_t(len(tokens)), true, true)
+	if n < 0 {
+		tokens = make([]C.llama_token, -n)
+		n = C.llama_tokenize(l.vocab, ctext, C.int32_t(len(text)), &tokens[0], C.int32_t(len(tokens)), true, true)
+	}
+	if n < 0 {
+		return nil, errors.New("inferno: tokenizing for the local model failed")
+	}
+	if int(n) > l.options.ContextSize {
+		return nil, fmt.Errorf("inferno: prompt of %d tokens exceeds the local context of %d", n, l.options.ContextSize)
+	}
+	return tokens[:n], nil
+}
+
+func (l *LocalBackend) piece(token C.llama_token) string {
+	buf := make([]byte, 64)
+	n := C.llama_token_to_piece(l.vocab, token, (*C.char)(unsafe.Pointer(&buf[0])), C.int32_t(len(buf)), 0, false)
+	if n < 0 {
+		buf = make([]byte, -n)
+		n = C.llama_token_to_piece(l.vocab, token, (*C.char)(unsafe.Pointer(&buf[0])), C.int32_t(len(buf)), 0, false)
+	}
+	if n <= 0 {
+		return ""
+	}
+	return string(buf[:n])
+}
+
+// sampling is what generate needs of a request
+type sampling struct {
+	maxTokens   int
+	temperature float32
+	topP        float32
+	topK        int
+	stop        []string
+	seed        *uint64
+}
+
+func newSampler(s sampling) *C.struct_llama_sampler {
+	chain := C.llama_sampler_chain_init(C.llama_sampler_chain_default_params())
+	if s.temperature <= 0 {
+		C.llama_sampler_chain_add(chain, C.llama_sampler_init_greedy())
+		return chain
+	}
+	if s.topK > 0 {
+		C.llama_sampler_chain_add(chain, C.llama_sampler_init_top_k(C.int32_t(s.topK)))
+	}
+	if s.topP > 0 && s.topP < 1 {
+		C.llama_sampler_chain_add(chain, C.llama_sampler_init_top_p(C.float(s.topP), 1))
+	}
+	C.llama_sampler_chain_add(chain, C.llama_sampler_init_temp(C.float(s.temperature)))
+	seed := C.uint32_t(C.LLAMA_DEFAULT_SEED)
+	if s.seed != nil {
+		seed = C.uint32_t(*s.seed)
+	}
+	C.llama_sampler_chain_add(chain, C.llama_sampler_init_dist(seed))
+	return chain
+}
+
+// generate continues prompt until the end of generation, a stop sequence,
+// the token limit or ctx is done, which ends it with FinishCancelled or
+// FinishTimeout like the server does
+func (l *LocalBackend) generate(ctx context.Context, prompt string, s sampling) (string, FinishReason, Usage, error) {
+	var usage Usage
+	tokens, err := l.tokenize(prompt)
+	if err != nil {
+		return "", "", usage, err
+	}
+	usage.PromptTokens = len(tokens)
+
+	lctx, err := l.newContext(false)
+	if err != nil {
+		return "", "", usage, err
+	}
+	defer C.llama_free(lctx)
+	sampler := newSampler(s)
+	defer C.llama_sampler_free(sampler)
+
+	maxTokens := s.maxTokens
+	if maxTokens <= 0 {
+		maxTokens = defaultLocalMaxTokens
+	}
+	if room := l.options.ContextSize - len(tokens); maxTokens > room {
+		maxTokens = room
+	}
+
+	var text strings.Builder
+	batch := C.llama_batch_get_one(&tokens[0], C.int32_t(len(tokens)))
+	next := make([]C.llama_token, 1)
+	for usage.CompletionTokens < maxTokens {
+		if err := ctx.Err(); err != nil {
+			reason := FinishCancelled
+			if errors.Is(err, context.DeadlineExceeded) {
+				reason = FinishTimeout
+			}
+			return text.String(), reason, usage, nil
+		}
+		if C.llama_decode(lctx, batch) != 0 {
+			return "", "", usage, errors.New("inferno: local decode failed")
+		}
+		token := C.llama_sampler_sample(sampler, lctx, -1)
+		if C.llama_vocab_is_eog(l.vocab, token) {
+			break
+		}
+		usage.CompletionTokens++
+		text.WriteString(l.piece(token))
+		if out, ok := cutStop(text.String(), s.stop); ok {
+			usage.TotalTokens = usage.PromptTokens + usage.CompletionTokens
+			return out, FinishStop, usage, nil
+		}
+		next[0] = token
+		batch = C.llama_batch_get_one(&next[0], 1)
+	}
+	usage.TotalTokens = usage.PromptTokens + usage.CompletionTokens
+	return text.String(), FinishStop, usage, nil
+}
+
+// cutStop trims text at the first stop sequence in it
+func cutStop(text string, stop []string) (string, bool) {
+	cut := -1
+	for _, sequence := range stop {
+		if i := strings.Index(text, sequence); sequence != "" && i >= 0 && (cut < 0 || i < cut) {
+			cut = i
+		}
+	}
+	if cut < 0 {
+		return text, false
+	}
+	return text[:cut], true
+}
+
+func (l *LocalBackend) InferenceContext(ctx context.Context, request InferenceRequest) (*InferenceResponse, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	text, reason, usage, err := l.generate(ctx, request.Prompt, sampling{
+		maxTokens:   request.MaxTokens,
+		temperature: request.Temperature,
+		topP:        request.TopP,
+		topK:        request.TopK,
+		stop:        request.Stop,
+		seed:        request.Seed,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if request.Echo {
+		text = request.Prompt + text
+	}
+	return &InferenceResponse{
+		ID:      NewRequestID(),
+		Model:   l.name,
+		Choices: []Choice{{Text: text, FinishReason: &reason}},
+		Usage:   &usage,
+		Created: time.Now().Unix(),
+	}, nil
+}
+
+func (l *LocalBackend) ChatCompletionContext(ctx context.Context, request ChatCompletionRequest) (*ChatCompletionResponse, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	prompt, err := l.applyTemplate(request.Messages)
+	if err != nil {
+		return nil, err
+	}
+	s := sampling{temperature: 0.7, stop: request.Stop, seed: request.Seed}
+	if request.MaxTokens != nil {
+		s.maxTokens = *request.MaxTokens
+	}
+	if request.Temperature != nil {
+		s.temperature = *request.Temperature
+	}
+	if request.TopP != nil {
+		s.topP = *request.TopP
+	}
+	text, reason, usage, err := l.generate(ctx, prompt, s)
+	if err != nil {
+		return nil, err
+	}
+	return &ChatCompletionResponse{
+		ID:      NewRequestID(),
+		Object:  "chat.completion",
+		Created: time.Now().Unix(),
+		Model:   l.name,
+		Choices: []ChatChoice{{
+			Message:      ChatMessage{Role: RoleAssistant, Content: text},
+			FinishReason: &reason,
+		}},
+		Usage: &usage,
+	}, nil
+}
+
+// applyTemplate renders messages with the model's own chat template
+func (l *LocalBackend) applyTemplate(messages []ChatMessage) (string, error) {
+	if len(messages) == 0 {
+		return "", errors.New("inferno: chat completion needs at least one message")
+	}
+	tmpl := C.llama_model_chat_template(l.model, nil)
+
+	size := C.size_t(unsafe.Sizeof(C.struct_llama_chat_message{}))
+	chat := (*[1 << 20]C.struct_llama_chat_message)(C.malloc(size * C.size_t(len(messages))))[:len(messages):len(messages)]
+	defer C.free(unsafe.Pointer(&chat[0]))
+	length := 0
+	for i, message := range messages {
+		chat[i].role = C.CString(string(message.Role))
+		chat[i].content = C.CString(message.Content)
+		length += len(message.Role) + len(message.Content)
+	}
+	defer func() {
+		for i := range chat {
+			C.free(unsafe.Pointer(chat[i].role))
+			C.free(unsafe.Pointer(chat[i].content))
+		}
+	}()
+
+	buf := make([]byte, 2*length+256)
+	n := C.llama_chat_apply_template(tmpl, &chat[0], C.size_t(len(chat)), true, (*C.char)(unsafe.Pointer(&buf[0])), C.int32_t(len(buf)))
+	if int(n) > len(buf) {
+		buf = make([]byte, n)
+		n = C.llama_chat_apply_template(tmpl, &chat[0], C.size_t(len(chat)), true, (*C.char)(unsafe.Pointer(&buf[0])), C.int32_t(len(buf)))
+	}
+	if n < 0 {
+		return "", errors.New("inferno: the local model has no usable chat template")
+	}
+	return string(buf[:n]), nil
+}
+
+func (l *LocalBackend) EmbeddingsContext(ctx context.Context, request EmbeddingsRequest) (*EmbeddingsResponse, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	dims := int(C.llama_model_n_embd(l.model))
+	result := &EmbeddingsResponse{Model: l.name, Usage: &Usage{}}
+	for i, input := range request.Input {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		tokens, err := l.tokenize(input)
+		if err != nil {
+			return nil, err
+		}
+		embedding, err := l.embed(tokens, dims)
+		if err != nil {
+			return nil, err
+		}
+		if request.Dimensions != nil && *request.Dimensions > 0 && *request.Dimensions < len(embedding) {
+			embedding = embedding[:*request.Dimensions]
+		}
+		normalize(embedding)
+		result.Data = append(result.Data, EmbeddingData{Embedding: embedding, Index: i})
+		result.Usage.PromptTokens += len(tokens)
+	}
+	result.Usage.TotalTokens = result.Usage.PromptTokens
+	return result, nil
+}
+
+// embed returns the pooled embedding of tokens
+func (l *LocalBackend) embed(tokens []C.llama_token, dims int) ([]float32, error) {
+	lctx, err := l.newContext(true)
+	if err != nil {
+		return nil, err
+	}
+	defer C.llama_free(lctx)
+
+	if C.llama_decode(lctx, C.llama_batch_get_one(&tokens[0], C.int32_t(len(tokens)))) != 0 {
+		return nil, errors.New("inferno: local decode failed")
+	}
+	pooled := C.llama_get_embeddings_seq(lctx, 0)
+	if pooled == nil {
+		return nil, errors.New("inferno: the local model does not pool embeddings")
+	}
+	embedding := make([]float32, dims)
+	copy(embedding, unsafe.Slice((*float32)(unsafe.Pointer(pooled)), dims))
+	return embedding, nil
+}
+
+// normalize scales v to unit length, as the server returns embeddings
+func normalize(v []float32) {
+	var sum float64
+	for _, x := range v {
+		sum += float64(x) * float64(x)
+	}
+	if sum == 0 {
+		return
+	}
+	norm := float32(math.Sqrt(sum))
+	for i := range v {
+		v[i] /= norm
+	}
+}
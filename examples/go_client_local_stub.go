@@ -0,0 +1,30 @@
+//go:build !local
+
+package inferno
+
+import "context"
+
+// LocalBackend runs inference in-process. This build has no local runtime,
+// so NewLocalBackend fails with ErrLocalUnavailable; build with -tags local
+// and llama.cpp installed for the real one.
+type LocalBackend struct{}
+
+// NewLocalBackend fails with ErrLocalUnavailable in this build
+func NewLocalBackend(options LocalOptions) (*LocalBackend, error) {
+	return nil, ErrLocalUnavailable
+}
+
+func (l *LocalBackend) InferenceContext(ctx context.Context, request InferenceRequest) (*InferenceResponse, error) {
+	return nil, ErrLocalUnavailable
+}
+
+func (l *LocalBackend) ChatCompletionContext(ctx context.Context, request ChatCompletionRequest) (*ChatCompletionResponse, error) {
+	return nil, ErrLocalUnavailable
+}
+
+func (l *LocalBackend) EmbeddingsContext(ctx context.Context, request EmbeddingsRequest) (*EmbeddingsResponse, error) {
+	return nil, ErrLocalUnavailable
+}
+
+// Close does nothing in this build
+func (l *LocalBackend) Close() error { return nil }
@@ -0,0 +1,252 @@
+package inferno
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// maxLoggedBodyBytes is the largest body the request log reads for the
+// model, token counts and content; larger ones are logged without them
+const maxLoggedBodyBytes = 1 << 20
+
+// defaultTruncateLength is RequestLogOptions.TruncateLength when zero
+const defaultTruncateLength = 64
+
+// RedactionMode says how prompts and completions appear in the request log
+type RedactionMode string
+
+const (
+	// RedactOmit leaves content out of the log; the default
+	RedactOmit RedactionMode = "omit"
+	// RedactHash logs a SHA-256 prefix, so identical prompts can be matched
+	// without being readable
+	RedactHash RedactionMode = "hash"
+	// RedactTruncate logs the first TruncateLength characters
+	RedactTruncate RedactionMode = "truncate"
+	// RedactNone logs content in full; for development only
+	RedactNone RedactionMode = "none"
+)
+
+// RequestLogOptions configures EnableRequestLogging
+type RequestLogOptions struct {
+	// Redaction applies to prompts and completions; empty means RedactOmit
+	Redaction RedactionMode
+	// TruncateLength is the characters kept by RedactTruncate; zero means 64
+	TruncateLength int
+	// Level of successful requests; failures and error statuses are logged
+	// at slog.LevelError
+	Level slog.Level
+}
+
+// EnableRequestLogging logs every request the client sends to logger: the
+// method, endpoint, model, status, latency and token counts, with prompts
+// and completions redacted as options say. It wraps Transport, so call it
+// after setting a custom one.
+func (c *Client) EnableRequestLogging(logger *slog.Logger, options RequestLogOptions) {
+	base := c.Transport
+	if base == nil {
+		base = c.HTTPClient.Transport
+	}
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	if options.Redaction == "" {
+		options.Redaction = RedactOmit
+	}
+	if options.TruncateLength <= 0 {
+		options.TruncateLength = defaultTruncateLength
+	}
+	c.Transport = &loggingTransport{base: base, logger: logger, options: options}
+}
+
+// loggingTransport is the RoundTripper EnableRequestLogging installs
+type loggingTransport struct {
+	base    http.RoundTripper
+	logger  *slog.Logger
+	options RequestLogOptions
+}
+
+// loggedBody holds the fields the log takes from a request or response body
+type loggedBody struct {
+	Model    string          `json:"model"`
+	Prompt   json.RawMessage `json:"prompt"`
+	Messages []struct {
+		Content json.RawMessage `json:"content"`
+	} `json:"messages"`
+	Choices []struct {
+		Text    string `json:"text"`
+		Message struct {
+			Content json.RawMessage `json:"content"`
+		} `json:"message"`
+	} `json:"choices"`
+	Usage *struct {
+		PromptTokens     int `json:"prompt_tokens"`
+		CompletionTokens int `json:"completion_tokens"`
+	} `json:"usage"`
+}
+
+func (t *loggingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	attrs := []slog.Attr{
+		slog.String("method", req.Method),
+		slog.String("endpoint", req.URL.Path),
+	}
+	if request, ok := peekRequest(req); ok {
+		if request.Model != "" {
+			attrs = append(attrs, slog.String("model", request.Model))
+		}
+		if prompt := promptText(request); prompt != "" && t.options.Redaction != RedactOmit {
+			attrs = append(attrs, slog.String("prompt", t.redact(prompt)))
+		}
+	}
+
+	started := time.Now()
+	resp, err := t.base.RoundTrip(req)
+	attrs = append(attrs, slog.Duration("latency", time.Since(started)))
+	if err != nil {
+		attrs = append(attrs, slog.String("error", err.Error()))
+		t.logger.LogAttrs(req.Context(), slog.LevelError, "inferno request failed", attrs...)
+		return nil, err
+	}
+
+	attrs = append(attrs, slog.Int("status", resp.StatusCode))
+	if response, ok := peekResponse(resp); ok {
+		if response.Usage != nil {
+			attrs = append(attrs,
+				slog.Int("prompt_tokens", response.Usage.PromptTokens),
+				slog.Int("completion_tokens", response.Usage.CompletionTokens),
+			)
+		}
+		if completion := completionText(response); completion != "" && t.options.Redaction != RedactOmit {
+			attrs = append(attrs, slog.String("completion", t.redact(completion)))
+		}
+	}
+
+	level := t.options.Level
+	if resp.StatusCode >= 400 {
+		level = slog.LevelError
+	}
+	t.logger.LogAttrs(req.Context(), level, "inferno request", attrs...)
+	return resp, nil
+}
+
+// redact applies the configured redaction to content
+func (t *loggingTransport) redact(content string) string {
+	switch t.options.Redaction {
+	case RedactHash:
+		sum := sha256.Sum256([]byte(content))
+		return "sha256:" + hex.EncodeToString(sum[:8])
+	case RedactTruncate:
+		if runes := []rune(content); len(runes) > t.options.TruncateLength {
+			return string(runes[:t.options.TruncateLength]) + "…"
+		}
+		return content
+	default:
+		return content
+	}
+}
+
+// isJSON reports whether header names a JSON body
+func isJSON(header http.Header) bool {
+	mediaType, _, err := mime.ParseMediaType(header.Get("Content-Type"))
+	return err == nil && mediaType == "application/json"
+}
+
+// peekRequest decodes req's body without consuming it
+func peekRequest(req *http.Request) (loggedBody, bool) {
+	var body loggedBody
+	if req.GetBody == nil || !isJSON(req.Header) {
+		return body, false
+	}
+	reader, err := req.GetBody()
+	if err != nil {
+		return body, false
+	}
+	defer reader.Close()
+	data, err := io.ReadAll(io.LimitReader(reader, maxLoggedBodyBytes+1))
+	if err != nil || len(data) > maxLoggedBodyBytes {
+		return body, false
+	}
+	return body, json.Unmarshal(data, &body) == nil
+}
+
+// peekResponse decodes resp's body and puts it back for the caller. Streams
+// and large bodies are left alone.
+func peekResponse(resp *http.Response) (loggedBody, bool) {
+	var body loggedBody
+	if !isJSON(resp.Header) || resp.ContentLength > maxLoggedBodyBytes {
+		return body, false
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxLoggedBodyBytes+1))
+	resp.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(data), resp.Body), resp.Body}
+	if err != nil || len(data) > maxLoggedBodyBytes {
+		return body, false
+	}
+	return body, json.Unmarshal(data, &body) == nil
+}
+
+// promptText joins a request's prompt or message contents
+func promptText(body loggedBody) string {
+	parts := []string{contentText(body.Prompt)}
+	for _, message := range body.Messages {
+		parts = append(parts, contentText(message.Content))
+	}
+	return joinNonEmpty(parts)
+}
+
+// completionText joins a response's choices
+func completionText(body loggedBody) string {
+	var parts []string
+	for _, choice := range body.Choices {
+		parts = append(parts, choice.Text, contentText(choice.Message.Content))
+	}
+	return joinNonEmpty(parts)
+}
+
+// contentText flattens a string, a list of strings or a list of content
+// parts into text
+func contentText(raw json.RawMessage) string {
+	if len(raw) == 0 {
+		return ""
+	}
+	var text string
+	if json.Unmarshal(raw, &text) == nil {
+		return text
+	}
+	var items []json.RawMessage
+	if json.Unmarshal(raw, &items) != nil {
+		return ""
+	}
+	var parts []string
+	for _, item := range items {
+		var part struct {
+			Text string `json:"text"`
+		}
+		if json.Unmarshal(item, &text) == nil {
+			parts = append(parts, text)
+		} else if json.Unmarshal(item, &part) == nil {
+			parts = append(parts, part.Text)
+		}
+	}
+	return joinNonEmpty(parts)
+}
+
+func joinNonEmpty(parts []string) string {
+	kept := parts[:0]
+	for _, part := range parts {
+		if part != "" {
+			kept = append(kept, part)
+		}
+	}
+	return strings.Join(kept, "\n")
+}
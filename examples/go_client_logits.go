@@ -0,0 +1,87 @@
+package inferno
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// Logit inspection structures
+type InspectLogitsRequest struct {
+	Model string `json:"model"`
+	// Prompt or InputIDs, not both
+	Prompt   string   `json:"prompt,omitempty"`
+	InputIDs []uint32 `json:"input_ids,omitempty"`
+	// MaxTokens is the number of steps to trace (server default 16, at most 64)
+	MaxTokens int `json:"max_tokens,omitempty"`
+	// TopLogits is the number of alternatives per step (server default 5,
+	// at most 20)
+	TopLogits int `json:"top_logits,omitempty"`
+	// Temperature zero (the default) traces greedy decoding
+	Temperature float32  `json:"temperature,omitempty"`
+	TopK        int      `json:"top_k,omitempty"`
+	TopP        *float32 `json:"top_p,omitempty"`
+	Seed        *uint64  `json:"seed,omitempty"`
+	// Attention asks for attention summaries, which the server rejects
+	// with code attention_not_supported until a backend exposes them
+	Attention bool `json:"attention,omitempty"`
+}
+
+// LogitCandidate is one token the model could have produced at a step
+type LogitCandidate struct {
+	ID      uint32  `json:"id"`
+	Token   string  `json:"token"`
+	Logit   float32 `json:"logit"`
+	Logprob float32 `json:"logprob"`
+}
+
+// LogitStep is the model's distribution at one generation step
+type LogitStep struct {
+	Chosen LogitCandidate   `json:"chosen"`
+	Top    []LogitCandidate `json:"top"`
+	// Entropy is in nats; spikes show where the model was unsure
+	Entropy float32 `json:"entropy"`
+}
+
+type LogitTrace struct {
+	Model        string       `json:"model"`
+	PromptTokens int          `json:"prompt_tokens"`
+	Text         string       `json:"text"`
+	FinishReason FinishReason `json:"finish_reason"`
+	Steps        []LogitStep  `json:"steps"`
+}
+
+// String renders the trace as a table, one step per line with the chosen
+// token first and the alternatives after it
+func (t *LogitTrace) String() string {
+	var b strings.Builder
+	for i, step := range t.Steps {
+		fmt.Fprintf(&b, "%3d %-16q %7.3f  H=%.2f |", i, step.Chosen.Token, step.Chosen.Logprob, step.Entropy)
+		for _, candidate := range step.Top {
+			fmt.Fprintf(&b, " %q %.3f", candidate.Token, candidate.Logprob)
+		}
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
+
+// InspectLogits generates a short completion and returns the top logits at
+// every step, for diagnosing sampling settings and quantization. Requires
+// the admin token and a backend that exposes its logits (GGUF).
+func (c *Client) InspectLogits(ctx context.Context, request InspectLogitsRequest) (*LogitTrace, error) {
+	if err := c.requireCapabilities(ctx, nil, request.Model, CapabilityLogits); err != nil {
+		return nil, err
+	}
+
+	resp, err := c.RequestContext(ctx, "POST", "/v1/debug/logits", request)
+	if err != nil {
+		return nil, err
+	}
+
+	var trace LogitTrace
+	if err := decodeResponse(resp, &trace); err != nil {
+		return nil, err
+	}
+
+	return &trace, nil
+}
@@ -0,0 +1,123 @@
+package inferno
+
+import (
+	"context"
+	"encoding/json"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// LogLevel is a log line's severity
+type LogLevel string
+
+const (
+	LogTrace LogLevel = "trace"
+	LogDebug LogLevel = "debug"
+	LogInfo  LogLevel = "info"
+	LogWarn  LogLevel = "warn"
+	LogError LogLevel = "error"
+)
+
+// Log structures
+type LogEntry struct {
+	// ID increases by one per line; pass the last one seen as
+	// LogFilter.SinceID to resume
+	ID        uint64    `json:"id"`
+	Timestamp time.Time `json:"timestamp"`
+	Level     LogLevel  `json:"level"`
+	// Component is the part of the server that logged the line, such as
+	// inference, api, models or gpu
+	Component string                 `json:"component"`
+	Target    string                 `json:"target"`
+	Message   string                 `json:"message"`
+	Fields    map[string]interface{} `json:"fields,omitempty"`
+	File      string                 `json:"file,omitempty"`
+	Line      int                    `json:"line,omitempty"`
+}
+
+type LogsResponse struct {
+	Object string     `json:"object"`
+	Data   []LogEntry `json:"data"`
+}
+
+// LogFilter selects log lines; zero fields match everything
+type LogFilter struct {
+	// Level is the lowest severity included
+	Level LogLevel
+	// Component is a component name or a module path such as api::openai
+	Component string
+	// SinceID skips lines up to and including this id
+	SinceID uint64
+	// Limit is how many recent lines to return or replay (server default 100)
+	Limit int
+}
+
+func (f LogFilter) query(follow bool) url.Values {
+	query := url.Values{}
+	if follow {
+		query.Set("follow", "true")
+	}
+	if f.Level != "" {
+		query.Set("level", string(f.Level))
+	}
+	if f.Component != "" {
+		query.Set("component", f.Component)
+	}
+	if f.SinceID > 0 {
+		query.Set("since_id", strconv.FormatUint(f.SinceID, 10))
+	}
+	if f.Limit > 0 {
+		query.Set("limit", strconv.Itoa(f.Limit))
+	}
+	return query
+}
+
+// Logs returns the most recent log lines the server holds that match
+// filter, oldest first
+func (a *AdminClient) Logs(ctx context.Context, filter LogFilter) ([]LogEntry, error) {
+	var result LogsResponse
+	endpoint := "/admin/logs?" + filter.query(false).Encode()
+	if err := a.adminRequest(ctx, "GET", endpoint, nil, &result); err != nil {
+		return nil, err
+	}
+	return result.Data, nil
+}
+
+// FollowLogs tails the server's logs: the channel receives the recent lines
+// matching filter and then each new one as it is logged. It is closed when
+// ctx is done or the connection drops; to resume, call FollowLogs again with
+// SinceID set to the last entry's ID.
+func (a *AdminClient) FollowLogs(ctx context.Context, filter LogFilter) (<-chan LogEntry, error) {
+	resp, err := a.longRunningRequest(ctx, "GET", "/admin/logs?"+filter.query(true).Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 400 {
+		return nil, decodeResponse(resp, nil)
+	}
+
+	entries := make(chan LogEntry, 64)
+	go func() {
+		defer close(entries)
+		defer resp.Body.Close()
+
+		ReadServerSentEvents(resp.Body, func(data []byte) error {
+			var entry LogEntry
+			if err := json.Unmarshal(data, &entry); err != nil {
+				return err
+			}
+			// Lag notices carry no line id
+			if entry.ID == 0 {
+				return nil
+			}
+			select {
+			case entries <- entry:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		})
+	}()
+	return entries, nil
+}
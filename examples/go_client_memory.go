@@ -0,0 +1,131 @@
+package inferno
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+)
+
+// PressureLevel is how close a memory resource is to its limit
+type PressureLevel string
+
+const (
+	PressureNormal   PressureLevel = "normal"
+	PressureWarning  PressureLevel = "warning"
+	PressureCritical PressureLevel = "critical"
+)
+
+// EvictionTarget is what a memory pressure eviction drops
+type EvictionTarget string
+
+const (
+	EvictSession      EvictionTarget = "session"
+	EvictInferenceJob EvictionTarget = "inference_job"
+)
+
+// Memory pressure structures
+type MemoryUsage struct {
+	// Resource is "ram" or "gpu:<index>"
+	Resource    string        `json:"resource"`
+	UsedMB      uint64        `json:"used_mb"`
+	TotalMB     uint64        `json:"total_mb"`
+	UsedPercent float64       `json:"used_percent"`
+	Level       PressureLevel `json:"level"`
+}
+
+type MemoryPressureConfig struct {
+	Enabled         bool    `json:"enabled"`
+	IntervalSecs    uint64  `json:"interval_secs"`
+	WarningPercent  float64 `json:"warning_percent"`
+	CriticalPercent float64 `json:"critical_percent"`
+	// EvictOnCritical makes the server drop idle sessions and finished job
+	// results at critical; when false events only list them
+	EvictOnCritical bool   `json:"evict_on_critical"`
+	SessionIdleSecs uint64 `json:"session_idle_secs"`
+}
+
+// MemoryPressureConfigUpdate changes the fields that are set and leaves the
+// rest
+type MemoryPressureConfigUpdate struct {
+	Enabled         *bool    `json:"enabled,omitempty"`
+	IntervalSecs    *uint64  `json:"interval_secs,omitempty"`
+	WarningPercent  *float64 `json:"warning_percent,omitempty"`
+	CriticalPercent *float64 `json:"critical_percent,omitempty"`
+	EvictOnCritical *bool    `json:"evict_on_critical,omitempty"`
+	SessionIdleSecs *uint64  `json:"session_idle_secs,omitempty"`
+}
+
+type MemoryPressureStatus struct {
+	Object string `json:"object"`
+	// Level is the worst of the resources' levels
+	Level           PressureLevel        `json:"level"`
+	Config          MemoryPressureConfig `json:"config"`
+	Resources       []MemoryUsage        `json:"resources"`
+	LastChecked     *time.Time           `json:"last_checked"`
+	EvictedSessions uint64               `json:"evicted_sessions"`
+	EvictedJobs     uint64               `json:"evicted_jobs"`
+}
+
+type EvictionDecision struct {
+	Target EvictionTarget `json:"target"`
+	// IDs are least recently used first
+	IDs []string `json:"ids"`
+}
+
+// MemoryPressureDetails is the Details of an AuditMemoryPressure event
+type MemoryPressureDetails struct {
+	Resource      string             `json:"resource"`
+	Level         PressureLevel      `json:"level"`
+	PreviousLevel PressureLevel      `json:"previous_level"`
+	UsedMB        uint64             `json:"used_mb"`
+	TotalMB       uint64             `json:"total_mb"`
+	UsedPercent   float64            `json:"used_percent"`
+	Evictions     []EvictionDecision `json:"evictions"`
+	// Executed is true when the server is making the evictions now and
+	// false when they are what it would drop at critical
+	Executed    bool   `json:"executed"`
+	LoadedModel string `json:"loaded_model,omitempty"`
+}
+
+// MemoryPressureEvent is a memory_pressure audit event with its details
+// decoded
+type MemoryPressureEvent struct {
+	AuditEvent
+	Pressure MemoryPressureDetails
+}
+
+// MemoryPressure returns current RAM and GPU memory use and the pressure
+// settings
+func (a *AdminClient) MemoryPressure(ctx context.Context) (*MemoryPressureStatus, error) {
+	var status MemoryPressureStatus
+	if err := a.adminRequest(ctx, "GET", "/admin/memory", nil, &status); err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
+
+// UpdateMemoryPressure changes the pressure thresholds and eviction settings
+func (a *AdminClient) UpdateMemoryPressure(ctx context.Context, update MemoryPressureConfigUpdate) (*MemoryPressureStatus, error) {
+	var status MemoryPressureStatus
+	if err := a.adminRequest(ctx, "PUT", "/admin/memory", update, &status); err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
+
+// WatchMemoryPressure calls handle for each memory pressure event after
+// sinceID, as resources move between levels and as the server evicts,
+// until ctx is done, the stream ends or handle returns an error. Events
+// with Pressure.Executed set are sent before the evictions happen.
+func (a *AdminClient) WatchMemoryPressure(ctx context.Context, sinceID uint64, handle func(MemoryPressureEvent) error) error {
+	filter := AuditFilter{Kinds: []AuditKind{AuditMemoryPressure}, SinceID: sinceID}
+	return a.WatchAuditEvents(ctx, filter, func(event AuditEvent) error {
+		pressure := MemoryPressureEvent{AuditEvent: event}
+		if len(event.Details) > 0 {
+			if err := json.Unmarshal(event.Details, &pressure.Pressure); err != nil {
+				return err
+			}
+		}
+		return handle(pressure)
+	})
+}
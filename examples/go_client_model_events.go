@@ -0,0 +1,115 @@
+package inferno
+
+import (
+	"context"
+	"encoding/json"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ModelEventType is a step in a model's life on the server
+type ModelEventType string
+
+const (
+	ModelDownloaded ModelEventType = "downloaded"
+	// ModelConverted is reserved; the server does not convert models yet
+	ModelConverted ModelEventType = "converted"
+	ModelLoaded    ModelEventType = "loaded"
+	ModelUnloaded  ModelEventType = "unloaded"
+	// ModelEvicted means a cached model store download was removed
+	ModelEvicted ModelEventType = "evicted"
+	// ModelDeleted means an admin deleted the model's file
+	ModelDeleted ModelEventType = "deleted"
+	ModelFailed  ModelEventType = "failed"
+)
+
+// ModelEvent is one model lifecycle event
+type ModelEvent struct {
+	ID        uint64         `json:"id"`
+	Timestamp time.Time      `json:"timestamp"`
+	Type      ModelEventType `json:"type"`
+	// Model is the model name, or the store URL of a remote model
+	Model string `json:"model"`
+	// Source is what caused the event: "hub", "model_store", "startup",
+	// "request", "watchdog", "disk_cache" or "admin"
+	Source string `json:"source"`
+	// Stage is the step that did not complete, on ModelFailed
+	Stage   ModelEventType `json:"stage,omitempty"`
+	Message string         `json:"message,omitempty"`
+}
+
+// ModelEventFilter selects model events; zero fields match everything
+type ModelEventFilter struct {
+	Types []ModelEventType
+	Model string
+	// SinceID replays held events after this id before live ones
+	SinceID uint64
+}
+
+func (f ModelEventFilter) query() url.Values {
+	query := url.Values{}
+	if len(f.Types) > 0 {
+		types := make([]string, len(f.Types))
+		for i, t := range f.Types {
+			types[i] = string(t)
+		}
+		query.Set("type", strings.Join(types, ","))
+	}
+	if f.Model != "" {
+		query.Set("model", f.Model)
+	}
+	if f.SinceID > 0 {
+		query.Set("since_id", strconv.FormatUint(f.SinceID, 10))
+	}
+	return query
+}
+
+// WatchModels delivers every model lifecycle event from now on: downloads,
+// loads, unloads, evictions, deletions and failures. The channel is closed when ctx is
+// done or the connection drops.
+func (c *Client) WatchModels(ctx context.Context) (<-chan ModelEvent, error) {
+	return c.WatchModelEvents(ctx, ModelEventFilter{})
+}
+
+// WatchModelEvents is WatchModels for the events matching filter. To resume
+// after the channel closes, call it again with SinceID set to the last
+// event's ID; the server replays the recent events it still holds.
+func (c *Client) WatchModelEvents(ctx context.Context, filter ModelEventFilter) (<-chan ModelEvent, error) {
+	endpoint := "/v1/models/events"
+	if query := filter.query(); len(query) > 0 {
+		endpoint += "?" + query.Encode()
+	}
+	resp, err := c.longRunningRequest(ctx, "GET", endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 400 {
+		return nil, decodeResponse(resp, nil)
+	}
+
+	events := make(chan ModelEvent, 64)
+	go func() {
+		defer close(events)
+		defer resp.Body.Close()
+
+		ReadServerSentEvents(resp.Body, func(data []byte) error {
+			var event ModelEvent
+			if err := json.Unmarshal(data, &event); err != nil {
+				return err
+			}
+			// Lag notices carry no event id
+			if event.ID == 0 {
+				return nil
+			}
+			select {
+			case events <- event:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		})
+	}()
+	return events, nil
+}
@@ -0,0 +1,179 @@
+package inferno
+
+import (
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Model store structures
+type StoreCredentials struct {
+	// S3
+	AccessKeyID     *string `json:"access_key_id,omitempty"`
+	SecretAccessKey *string `json:"secret_access_key,omitempty"`
+	SessionToken    *string `json:"session_token,omitempty"`
+	// GCS OAuth access token
+	Token *string `json:"token,omitempty"`
+	// Azure shared access signature
+	SASToken *string `json:"sas_token,omitempty"`
+}
+
+type ModelStoreRequest struct {
+	// URL is s3://bucket[/prefix], gs://bucket[/prefix] or
+	// az://account/container[/prefix]
+	URL      string  `json:"url"`
+	Region   *string `json:"region,omitempty"`
+	Endpoint *string `json:"endpoint,omitempty"`
+	// Credentials fall back to the server's environment when unset
+	Credentials *StoreCredentials `json:"credentials,omitempty"`
+}
+
+type ModelStore struct {
+	Name     string  `json:"name"`
+	URL      string  `json:"url"`
+	Kind     string  `json:"kind"`
+	Region   *string `json:"region,omitempty"`
+	Endpoint *string `json:"endpoint,omitempty"`
+	// Credentials is "configured" or "environment"; secrets are never returned
+	Credentials string    `json:"credentials"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+type ModelStoresResponse struct {
+	Object string       `json:"object"`
+	Data   []ModelStore `json:"data"`
+}
+
+type CacheEntry struct {
+	// Status is "not_cached", "fetching", "cached" or "failed"
+	Status string  `json:"status"`
+	Bytes  uint64  `json:"bytes"`
+	Path   *string `json:"path,omitempty"`
+	Error  *string `json:"error,omitempty"`
+}
+
+type RemoteModel struct {
+	Key          string     `json:"key"`
+	URL          string     `json:"url"`
+	Size         *uint64    `json:"size,omitempty"`
+	LastModified *string    `json:"last_modified,omitempty"`
+	ETag         *string    `json:"etag,omitempty"`
+	Cache        CacheEntry `json:"cache"`
+}
+
+type RemoteModelsResponse struct {
+	Object string        `json:"object"`
+	Data   []RemoteModel `json:"data"`
+}
+
+type PrefetchRequest struct {
+	Models []string `json:"models"`
+}
+
+func modelStoreEndpoint(name string) string {
+	return "/v1/model_stores/" + url.PathEscape(name)
+}
+
+// remoteModelEndpoint escapes each segment of an object key, keeping the
+// slashes the server matches on
+func remoteModelEndpoint(store, key string) string {
+	segments := strings.Split(key, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	return modelStoreEndpoint(store) + "/models/" + strings.Join(segments, "/")
+}
+
+// ModelStores lists the registered object storage stores
+func (c *Client) ModelStores() ([]ModelStore, error) {
+	resp, err := c.Request("GET", "/v1/model_stores", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var result ModelStoresResponse
+	if err := decodeResponse(resp, &result); err != nil {
+		return nil, err
+	}
+
+	return result.Data, nil
+}
+
+// ModelStore returns a store's configuration
+func (c *Client) ModelStore(name string) (*ModelStore, error) {
+	return c.modelStoreRequest("GET", modelStoreEndpoint(name), nil)
+}
+
+// PutModelStore registers or replaces a store. Requires the admin token.
+func (c *Client) PutModelStore(name string, req ModelStoreRequest) (*ModelStore, error) {
+	return c.modelStoreRequest("PUT", modelStoreEndpoint(name), req)
+}
+
+// DeleteModelStore removes a store and the models cached from it. Requires
+// the admin token.
+func (c *Client) DeleteModelStore(name string) error {
+	resp, err := c.Request("DELETE", modelStoreEndpoint(name), nil)
+	if err != nil {
+		return err
+	}
+
+	return decodeResponse(resp, nil)
+}
+
+func (c *Client) modelStoreRequest(method, endpoint string, body interface{}) (*ModelStore, error) {
+	resp, err := c.Request(method, endpoint, body)
+	if err != nil {
+		return nil, err
+	}
+
+	var store ModelStore
+	if err := decodeResponse(resp, &store); err != nil {
+		return nil, err
+	}
+
+	return &store, nil
+}
+
+// ListRemoteModels lists the models in a store with their cache state, the
+// remote counterpart of ListModels. Any RemoteModel.URL can be passed as the
+// model in completion requests.
+func (c *Client) ListRemoteModels(store string) ([]RemoteModel, error) {
+	resp, err := c.Request("GET", modelStoreEndpoint(store)+"/models", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var result RemoteModelsResponse
+	if err := decodeResponse(resp, &result); err != nil {
+		return nil, err
+	}
+
+	return result.Data, nil
+}
+
+// PrefetchRemoteModels starts caching models by key and returns immediately;
+// poll ListRemoteModels for their cache state. Requires the admin token.
+func (c *Client) PrefetchRemoteModels(store string, keys ...string) ([]RemoteModel, error) {
+	resp, err := c.Request("POST", modelStoreEndpoint(store)+"/prefetch", PrefetchRequest{Models: keys})
+	if err != nil {
+		return nil, err
+	}
+
+	var result RemoteModelsResponse
+	if err := decodeResponse(resp, &result); err != nil {
+		return nil, err
+	}
+
+	return result.Data, nil
+}
+
+// EvictRemoteModel deletes a model's cached copy; the next request for it
+// fetches it again. Requires the admin token.
+func (c *Client) EvictRemoteModel(store, key string) error {
+	resp, err := c.Request("DELETE", remoteModelEndpoint(store, key), nil)
+	if err != nil {
+		return err
+	}
+
+	return decodeResponse(resp, nil)
+}
@@ -0,0 +1,76 @@
+package inferno
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ModerationAction is what a Moderator decides for a streamed token
+type ModerationAction int
+
+const (
+	// ModerationAllow passes the token through
+	ModerationAllow ModerationAction = iota
+	// ModerationRedact passes the verdict's Replacement instead of the
+	// token; an empty Replacement drops it
+	ModerationRedact
+	// ModerationAbort stops the stream and cancels the generation on the
+	// server, so it stops using the GPU
+	ModerationAbort
+)
+
+// ModerationVerdict is a Moderator's decision on one token
+type ModerationVerdict struct {
+	Action      ModerationAction
+	Replacement string
+	// Reason is reported in the *ModerationError of an abort
+	Reason string
+}
+
+// Moderator inspects a stream as it arrives. It is called for each token
+// with the raw output generated so far, that token included, and decides
+// what the reader gets. Tokens already returned cannot be taken back, so a
+// policy that matches phrases should redact or abort on the token that
+// completes them.
+type Moderator func(output, token string) ModerationVerdict
+
+// ErrModerationAborted is matched by the *ModerationError a moderated
+// stream returns when its Moderator aborts it
+var ErrModerationAborted = errors.New("inferno: stream aborted by moderation")
+
+// ModerationError says a Moderator stopped a stream
+type ModerationError struct {
+	Reason string
+	// Output is the raw output up to and including the offending token
+	Output string
+}
+
+func (e *ModerationError) Error() string {
+	if e.Reason == "" {
+		return ErrModerationAborted.Error()
+	}
+	return fmt.Sprintf("%v: %s", ErrModerationAborted, e.Reason)
+}
+
+func (e *ModerationError) Is(target error) bool { return target == ErrModerationAborted }
+
+// moderate runs the stream's Moderator on token, returning what the
+// reader gets. An abort closes the stream and cancels the generation.
+func (s *ChatStream) moderate(token string) (string, error) {
+	s.output.WriteString(token)
+	verdict := s.Moderator(s.output.String(), token)
+	switch verdict.Action {
+	case ModerationRedact:
+		return verdict.Replacement, nil
+	case ModerationAbort:
+		s.FinishReason = FinishCancelled
+		s.body.Close()
+		if s.client != nil && s.requestID != "" {
+			// The connection is already closed, which the server notices;
+			// the cancel reaches it even through proxies that keep theirs
+			go s.client.CancelInference(s.requestID)
+		}
+		return "", &ModerationError{Reason: verdict.Reason, Output: s.output.String()}
+	}
+	return token, nil
+}
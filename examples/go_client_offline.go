@@ -0,0 +1,398 @@
+package inferno
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrQueuedOffline is matched by the *QueuedOfflineError an OfflineQueue
+// returns when it held a request for later
+var ErrQueuedOffline = errors.New("inferno: server unreachable, request queued")
+
+// QueuedOfflineError says a request was stored for replay instead of sent
+type QueuedOfflineError struct {
+	// ID identifies the request in the queue and, on replay, to the server
+	// as its X-Request-ID
+	ID string
+	// Err is why the server could not be reached; nil when the request was
+	// queued behind others still waiting
+	Err error
+}
+
+func (e *QueuedOfflineError) Error() string {
+	if e.Err == nil {
+		return fmt.Sprintf("%v: %s", ErrQueuedOffline, e.ID)
+	}
+	return fmt.Sprintf("%v: %s (%v)", ErrQueuedOffline, e.ID, e.Err)
+}
+
+func (e *QueuedOfflineError) Is(target error) bool { return target == ErrQueuedOffline }
+
+func (e *QueuedOfflineError) Unwrap() error { return e.Err }
+
+// QueuedRequest is a request held until the server can be reached
+type QueuedRequest struct {
+	ID       string          `json:"id"`
+	Seq      uint64          `json:"seq"`
+	Method   string          `json:"method"`
+	Path     string          `json:"path"`
+	Body     json.RawMessage `json:"body,omitempty"`
+	QueuedAt time.Time       `json:"queued_at"`
+}
+
+// OfflineStore persists an OfflineQueue. FileQueue keeps it in a
+// directory; a bolt or SQLite store can implement it instead.
+type OfflineStore interface {
+	// Append stores item, setting its Seq after every held item's, unless
+	// an item with its ID is already held; it reports whether it stored it
+	Append(item *QueuedRequest) (bool, error)
+	// Pending returns the held items, lowest Seq first
+	Pending() ([]QueuedRequest, error)
+	// Remove drops the item with id, if held
+	Remove(id string) error
+}
+
+// FileQueue is an OfflineStore keeping each request as a JSON file in a
+// directory, written to a temporary file, synced and renamed into place,
+// so a crash leaves whole requests or none
+type FileQueue struct {
+	dir string
+
+	mu   sync.Mutex
+	ids  map[string]uint64
+	next uint64
+}
+
+// NewFileQueue opens the queue in dir, creating dir if needed and picking
+// up requests an earlier process left there
+func NewFileQueue(dir string) (*FileQueue, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	q := &FileQueue{dir: dir, ids: map[string]uint64{}, next: 1}
+	items, err := q.read()
+	if err != nil {
+		return nil, err
+	}
+	for _, item := range items {
+		q.ids[item.ID] = item.Seq
+		if item.Seq >= q.next {
+			q.next = item.Seq + 1
+		}
+	}
+	return q, nil
+}
+
+func (q *FileQueue) path(seq uint64) string {
+	return filepath.Join(q.dir, fmt.Sprintf("%020d.json", seq))
+}
+
+// read loads every held item, skipping temporary files of interrupted
+// writes
+func (q *FileQueue) read() ([]QueuedRequest, error) {
+	entries, err := os.ReadDir(q.dir)
+	if err != nil {
+		return nil, err
+	}
+	var items []QueuedRequest
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".json") {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(q.dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		var item QueuedRequest
+		if err := json.Unmarshal(data, &item); err != nil {
+			return nil, fmt.Errorf("offline queue file %s: %w", entry.Name(), err)
+		}
+		items = append(items, item)
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].Seq < items[j].Seq })
+	return items, nil
+}
+
+func (q *FileQueue) Append(item *QueuedRequest) (bool, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if _, ok := q.ids[item.ID]; ok {
+		return false, nil
+	}
+
+	item.Seq = q.next
+	data, err := json.Marshal(item)
+	if err != nil {
+		return false, err
+	}
+	tmp, err := os.CreateTemp(q.dir, "queued-*.tmp")
+	if err != nil {
+		return false, err
+	}
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), q.path(item.Seq))
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return false, err
+	}
+
+	q.ids[item.ID] = item.Seq
+	q.next++
+	return true, nil
+}
+
+func (q *FileQueue) Pending() ([]QueuedRequest, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.read()
+}
+
+func (q *FileQueue) Remove(id string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	seq, ok := q.ids[id]
+	if !ok {
+		return nil
+	}
+	if err := os.Remove(q.path(seq)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	delete(q.ids, id)
+	return nil
+}
+
+// OfflineQueue sends non-interactive requests, such as embeddings and
+// batches, through its client, storing them when the server cannot be
+// reached and replaying them in order once it can. A request is stored
+// under an ID, so queuing the same ID twice stores it once, and replayed
+// with that ID as its X-Request-ID. A crash between a replay and its
+// removal replays it again, so delivery is at least once.
+type OfflineQueue struct {
+	client *Client
+	store  OfflineStore
+	// OnReplayed, if set, receives each replayed request's response
+	// status and body; the request is dropped from the queue whatever the
+	// status
+	OnReplayed func(item QueuedRequest, statusCode int, body []byte)
+
+	// flushMu keeps one replay running at a time, so order holds
+	flushMu sync.Mutex
+}
+
+// NewOfflineQueue returns a queue sending through client and storing in
+// store
+func NewOfflineQueue(client *Client, store OfflineStore) *OfflineQueue {
+	return &OfflineQueue{client: client, store: store}
+}
+
+// Do sends a request and decodes its response into out, like the client's
+// own calls. If the server is unreachable, or requests queued earlier are
+// still waiting, the request is stored under id (a random one when empty)
+// and a *QueuedOfflineError returned.
+func (q *OfflineQueue) Do(ctx context.Context, id, method, path string, body, out interface{}) error {
+	if id == "" {
+		id = NewRequestID()
+	}
+	ctx = WithCodec(ctx, JSONCodec{})
+
+	// Requests queued earlier go first
+	if _, err := q.Flush(ctx); err != nil || q.Len() > 0 {
+		return q.enqueue(id, method, path, body, err)
+	}
+
+	resp, err := q.send(ctx, id, method, path, body)
+	if err != nil {
+		if ctx.Err() != nil {
+			return err
+		}
+		return q.enqueue(id, method, path, body, err)
+	}
+	return decodeResponse(resp, out)
+}
+
+func (q *OfflineQueue) enqueue(id, method, path string, body interface{}, cause error) error {
+	item := &QueuedRequest{ID: id, Method: method, Path: path, QueuedAt: time.Now().UTC()}
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		item.Body = data
+	}
+	if _, err := q.store.Append(item); err != nil {
+		return fmt.Errorf("inferno: queueing request %s: %w", id, err)
+	}
+	return &QueuedOfflineError{ID: id, Err: cause}
+}
+
+// Embeddings creates embeddings, or queues the request under id
+func (q *OfflineQueue) Embeddings(ctx context.Context, id string, request EmbeddingsRequest) (*EmbeddingsResponse, error) {
+	var result EmbeddingsResponse
+	if err := q.Do(ctx, id, "POST", "/v1/embeddings", request, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// OfflineBatch is a /v1/batches batch held with its input, which is
+// uploaded as the batch's input file when the batch is sent
+type OfflineBatch struct {
+	// Endpoint is one of the BatchEndpoint constants
+	Endpoint string             `json:"endpoint"`
+	Requests []BatchRequestLine `json:"requests"`
+	Metadata map[string]string  `json:"metadata,omitempty"`
+}
+
+// Batch uploads a batch's input and creates the batch, or queues both under
+// id. A replay interrupted between the two uploads the input again, leaving
+// the first copy behind. Requires the admin token.
+func (q *OfflineQueue) Batch(ctx context.Context, id string, batch OfflineBatch) (*Batch, error) {
+	var result Batch
+	if err := q.Do(ctx, id, "POST", "/v1/batches", batch, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// send makes a request Do was given or Flush replays. A batch goes as two:
+// the upload of its input and then the batch itself.
+func (q *OfflineQueue) send(ctx context.Context, id, method, path string, body interface{}) (*http.Response, error) {
+	if path == "/v1/batches" {
+		var batch OfflineBatch
+		data, err := json.Marshal(body)
+		if err == nil {
+			err = json.Unmarshal(data, &batch)
+		}
+		if err != nil {
+			return nil, err
+		}
+		resp, err := q.uploadBatchInput(ctx, id, batch)
+		if err != nil || resp.StatusCode >= 300 {
+			return resp, err
+		}
+		var file FileObject
+		if err := decodeResponse(resp, &file); err != nil {
+			return nil, err
+		}
+		body = CreateBatchRequest{InputFileID: file.ID, Endpoint: batch.Endpoint, Metadata: batch.Metadata}
+	}
+
+	req, err := q.client.newRequest(ctx, method, path, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set(RequestIDHeader, id)
+	return q.client.send(q.client.HTTPClient, req)
+}
+
+// uploadBatchInput uploads a batch's requests as a JSONL file named after
+// the batch's queue ID
+func (q *OfflineQueue) uploadBatchInput(ctx context.Context, id string, batch OfflineBatch) (*http.Response, error) {
+	var buf bytes.Buffer
+	form := multipart.NewWriter(&buf)
+	err := form.WriteField("purpose", "batch")
+	if err == nil {
+		var part io.Writer
+		part, err = form.CreateFormFile("file", id+".jsonl")
+		encoder := json.NewEncoder(part)
+		for i := 0; err == nil && i < len(batch.Requests); i++ {
+			err = encoder.Encode(batch.Requests[i])
+		}
+	}
+	if err == nil {
+		err = form.Close()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := q.client.newRequest(ctx, "POST", "/v1/files", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Body = io.NopCloser(&buf)
+	req.ContentLength = int64(buf.Len())
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	return q.client.send(q.client.HTTPClient, req)
+}
+
+// Len returns how many requests are waiting
+func (q *OfflineQueue) Len() int {
+	items, err := q.store.Pending()
+	if err != nil {
+		return 0
+	}
+	return len(items)
+}
+
+// Flush replays waiting requests in order until they are all sent or the
+// server is unreachable again, and returns how many it sent
+func (q *OfflineQueue) Flush(ctx context.Context) (int, error) {
+	q.flushMu.Lock()
+	defer q.flushMu.Unlock()
+
+	items, err := q.store.Pending()
+	if err != nil {
+		return 0, err
+	}
+	ctx = WithCodec(ctx, JSONCodec{})
+	sent := 0
+	for _, item := range items {
+		var body interface{}
+		if len(item.Body) > 0 {
+			body = item.Body
+		}
+		resp, err := q.send(ctx, item.ID, item.Method, item.Path, body)
+		if err != nil {
+			return sent, err
+		}
+		data, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return sent, err
+		}
+
+		if err := q.store.Remove(item.ID); err != nil {
+			return sent, err
+		}
+		sent++
+		if q.OnReplayed != nil {
+			q.OnReplayed(item, resp.StatusCode, data)
+		}
+	}
+	return sent, nil
+}
+
+// Run flushes the queue every interval until ctx is done
+func (q *OfflineQueue) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			q.Flush(ctx)
+		}
+	}
+}
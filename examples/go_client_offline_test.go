@@ -0,0 +1,179 @@
+package inferno
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+// replayServer answers embeddings, file uploads and batches, keeping the
+// path and X-Request-ID of each request in the order they arrive
+type replayServer struct {
+	mu       sync.Mutex
+	requests []string
+	batches  []CreateBatchRequest
+}
+
+func (s *replayServer) seen() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.requests...)
+}
+
+func (s *replayServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests = append(s.requests, r.URL.Path+" "+r.Header.Get(RequestIDHeader))
+
+	switch r.URL.Path {
+	case "/v1/embeddings":
+		json.NewEncoder(w).Encode(EmbeddingsResponse{Data: []EmbeddingData{{Embedding: []float32{1}}}})
+	case "/v1/files":
+		_, header, err := r.FormFile("file")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		json.NewEncoder(w).Encode(FileObject{ID: "file_" + header.Filename})
+	case "/v1/batches":
+		var batch CreateBatchRequest
+		json.NewDecoder(r.Body).Decode(&batch)
+		s.batches = append(s.batches, batch)
+		json.NewEncoder(w).Encode(Batch{ID: "batch_1", InputFileID: batch.InputFileID})
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// unreachableURL returns the address of a server that has been shut down
+func unreachableURL() string {
+	ts := httptest.NewServer(http.NotFoundHandler())
+	ts.Close()
+	return ts.URL
+}
+
+func TestOfflineQueueReplaysInOrder(t *testing.T) {
+	server := &replayServer{}
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	dir := t.TempDir()
+	store, err := NewFileQueue(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := NewClient(unreachableURL(), "")
+	queue := NewOfflineQueue(client, store)
+	ctx := context.Background()
+	request := EmbeddingsRequest{Model: "bge", Input: []string{"a"}}
+
+	for _, id := range []string{"emb-1", "emb-2", "emb-1"} {
+		_, err := queue.Embeddings(ctx, id, request)
+		var queued *QueuedOfflineError
+		if !errors.Is(err, ErrQueuedOffline) || !errors.As(err, &queued) || queued.ID != id {
+			t.Fatalf("got %v, want %s queued", err, id)
+		}
+	}
+	if n := queue.Len(); n != 2 {
+		t.Fatalf("%d requests held, want 2: an ID is stored once", n)
+	}
+
+	// The queue survives a restart
+	reopened, err := NewFileQueue(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.BaseURL = ts.URL
+	queue = NewOfflineQueue(client, reopened)
+	var replayed []string
+	queue.OnReplayed = func(item QueuedRequest, statusCode int, body []byte) {
+		if statusCode != http.StatusOK {
+			t.Errorf("%s replayed with status %d", item.ID, statusCode)
+		}
+		replayed = append(replayed, item.ID)
+	}
+
+	// A new request waits for the ones queued before it
+	if _, err := queue.Embeddings(ctx, "emb-3", request); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"/v1/embeddings emb-1", "/v1/embeddings emb-2", "/v1/embeddings emb-3"}
+	if got := server.seen(); !reflect.DeepEqual(got, want) {
+		t.Errorf("server saw %v, want %v", got, want)
+	}
+	if !reflect.DeepEqual(replayed, []string{"emb-1", "emb-2"}) {
+		t.Errorf("OnReplayed saw %v", replayed)
+	}
+	if n := queue.Len(); n != 0 {
+		t.Errorf("%d requests still held", n)
+	}
+}
+
+func TestOfflineQueueReplaysBatch(t *testing.T) {
+	server := &replayServer{}
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	store, err := NewFileQueue(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := NewClient(unreachableURL(), "")
+	queue := NewOfflineQueue(client, store)
+	ctx := context.Background()
+
+	batch := OfflineBatch{
+		Endpoint: "/v1/embeddings",
+		Requests: []BatchRequestLine{{CustomID: "line-1", Method: "POST", URL: "/v1/embeddings"}},
+	}
+	if _, err := queue.Batch(ctx, "nightly", batch); !errors.Is(err, ErrQueuedOffline) {
+		t.Fatalf("got %v, want the batch queued", err)
+	}
+
+	client.BaseURL = ts.URL
+	sent, err := queue.Flush(ctx)
+	if err != nil || sent != 1 {
+		t.Fatalf("flushed %d, %v; want 1", sent, err)
+	}
+
+	// The input is uploaded first, as a file named after the queue ID, and
+	// the batch names the uploaded file
+	want := []string{"/v1/files ", "/v1/batches nightly"}
+	if got := server.seen(); !reflect.DeepEqual(got, want) {
+		t.Errorf("server saw %v, want %v", got, want)
+	}
+	if len(server.batches) != 1 || server.batches[0].InputFileID != "file_nightly.jsonl" {
+		t.Errorf("batches %+v", server.batches)
+	}
+}
+
+func TestOfflineQueueSendsWhenReachable(t *testing.T) {
+	server := &replayServer{}
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	store, err := NewFileQueue(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	queue := NewOfflineQueue(NewClient(ts.URL, ""), store)
+	if _, err := queue.Embeddings(context.Background(), "", EmbeddingsRequest{Model: "bge", Input: []string{"a"}}); err != nil {
+		t.Fatal(err)
+	}
+	if queue.Len() != 0 || len(server.seen()) != 1 {
+		t.Errorf("held %d, sent %v", queue.Len(), server.seen())
+	}
+
+	// An error status means the server was reached: it is returned, not
+	// queued
+	err = queue.Do(context.Background(), "", "GET", "/v1/missing", nil, nil)
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusNotFound || queue.Len() != 0 {
+		t.Errorf("got %v with %d held, want the 404 and none", err, queue.Len())
+	}
+}
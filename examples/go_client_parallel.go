@@ -0,0 +1,201 @@
+package inferno
+
+import (
+	"context"
+	"net/url"
+	"time"
+)
+
+// DeviceState is where a device is in loading its share of a model
+type DeviceState string
+
+const (
+	DevicePending DeviceState = "pending"
+	DeviceLoading DeviceState = "loading"
+	DeviceReady   DeviceState = "ready"
+	DeviceError   DeviceState = "error"
+)
+
+// PlanState sums up the devices serving a model
+type PlanState string
+
+const (
+	PlanReady    PlanState = "ready"
+	PlanLoading  PlanState = "loading"
+	PlanDegraded PlanState = "degraded"
+)
+
+// Parallel serving structures
+
+// LayerRange is the half-open range of layers [Start, End)
+type LayerRange struct {
+	Start int `json:"start"`
+	End   int `json:"end"`
+}
+
+type DeviceRef struct {
+	// Node is a cluster node id; empty means the coordinator itself
+	Node   string `json:"node,omitempty"`
+	Device string `json:"device"`
+}
+
+type ParallelStage struct {
+	Layers LayerRange `json:"layers"`
+	// Devices holds one device per tensor-parallel rank, in rank order
+	Devices []DeviceRef `json:"devices"`
+}
+
+type ParallelConfig struct {
+	NumLayers int `json:"num_layers"`
+	// TensorParallelSize is the devices each stage is sharded across, at
+	// least 1
+	TensorParallelSize int             `json:"tensor_parallel_size"`
+	Stages             []ParallelStage `json:"stages"`
+	Interconnect       string          `json:"interconnect,omitempty"`
+}
+
+type ParallelPlan struct {
+	Model     string         `json:"model"`
+	Config    ParallelConfig `json:"config"`
+	UpdatedAt time.Time      `json:"updated_at"`
+}
+
+type ParallelPlansResponse struct {
+	Object string         `json:"object"`
+	Data   []ParallelPlan `json:"data"`
+}
+
+type StageAssignment struct {
+	Stage  int        `json:"stage"`
+	Layers LayerRange `json:"layers"`
+	Rank   int        `json:"rank"`
+	Device string     `json:"device"`
+	// Peers lists every device in the stage, this one included
+	Peers []DeviceRef `json:"peers"`
+}
+
+type ParallelAssignment struct {
+	Model              string            `json:"model"`
+	Node               string            `json:"node"`
+	NumLayers          int               `json:"num_layers"`
+	TensorParallelSize int               `json:"tensor_parallel_size"`
+	PipelineStages     int               `json:"pipeline_stages"`
+	Interconnect       *string           `json:"interconnect"`
+	Assignments        []StageAssignment `json:"assignments"`
+}
+
+type InterconnectStats struct {
+	Link          string  `json:"link,omitempty"`
+	BandwidthGbps float32 `json:"bandwidth_gbps,omitempty"`
+	LatencyUs     float32 `json:"latency_us,omitempty"`
+	BytesSent     int64   `json:"bytes_sent"`
+	BytesReceived int64   `json:"bytes_received"`
+}
+
+type DeviceReport struct {
+	Device       string             `json:"device"`
+	State        DeviceState        `json:"state"`
+	Error        string             `json:"error,omitempty"`
+	MemoryUsedMB int64              `json:"memory_used_mb,omitempty"`
+	Interconnect *InterconnectStats `json:"interconnect,omitempty"`
+}
+
+type DeviceStatus struct {
+	Node         string             `json:"node"`
+	Device       string             `json:"device"`
+	Rank         int                `json:"rank"`
+	State        DeviceState        `json:"state"`
+	Error        *string            `json:"error"`
+	MemoryUsedMB *int64             `json:"memory_used_mb"`
+	Interconnect *InterconnectStats `json:"interconnect"`
+	ReportedAt   *time.Time         `json:"reported_at"`
+}
+
+type StageStatus struct {
+	Stage   int            `json:"stage"`
+	Layers  LayerRange     `json:"layers"`
+	Devices []DeviceStatus `json:"devices"`
+}
+
+type ParallelStatus struct {
+	Model  string        `json:"model"`
+	State  PlanState     `json:"state"`
+	Stages []StageStatus `json:"stages"`
+}
+
+func parallelEndpoint(model string) string {
+	return "/cluster/parallel/" + url.PathEscape(model)
+}
+
+// ParallelPlans lists every model's parallel serving plan
+func (c *Client) ParallelPlans(ctx context.Context) ([]ParallelPlan, error) {
+	var result ParallelPlansResponse
+	if err := c.parallelRequest(ctx, "GET", "/cluster/parallel", nil, &result); err != nil {
+		return nil, err
+	}
+	return result.Data, nil
+}
+
+// ParallelPlan returns a model's plan
+func (c *Client) ParallelPlan(ctx context.Context, model string) (*ParallelPlan, error) {
+	var plan ParallelPlan
+	if err := c.parallelRequest(ctx, "GET", parallelEndpoint(model), nil, &plan); err != nil {
+		return nil, err
+	}
+	return &plan, nil
+}
+
+// ParallelAssignment returns the stages and ranks node serves for model; a
+// node outside the plan gets no assignments
+func (c *Client) ParallelAssignment(ctx context.Context, model, node string) (*ParallelAssignment, error) {
+	var assignment ParallelAssignment
+	endpoint := parallelEndpoint(model) + "/assignments/" + url.PathEscape(node)
+	if err := c.parallelRequest(ctx, "GET", endpoint, nil, &assignment); err != nil {
+		return nil, err
+	}
+	return &assignment, nil
+}
+
+// ParallelStatus returns each device's latest report for model
+func (c *Client) ParallelStatus(ctx context.Context, model string) (*ParallelStatus, error) {
+	var status ParallelStatus
+	if err := c.parallelRequest(ctx, "GET", parallelEndpoint(model)+"/status", nil, &status); err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
+
+// SetParallelPlan sets or replaces model's plan. The server rejects a plan
+// whose stages leave gaps, overlap or miss ranks, that uses a device twice,
+// or that names a node outside the cluster.
+func (a *AdminClient) SetParallelPlan(ctx context.Context, model string, config ParallelConfig) (*ParallelPlan, error) {
+	var plan ParallelPlan
+	if err := a.parallelRequest(ctx, "PUT", parallelEndpoint(model), config, &plan); err != nil {
+		return nil, err
+	}
+	return &plan, nil
+}
+
+// DeleteParallelPlan drops model's plan
+func (a *AdminClient) DeleteParallelPlan(ctx context.Context, model string) error {
+	return a.parallelRequest(ctx, "DELETE", parallelEndpoint(model), nil, nil)
+}
+
+// ReportParallelStatus records the state of node's devices for model and
+// returns the updated status
+func (a *AdminClient) ReportParallelStatus(ctx context.Context, model, node string, devices []DeviceReport) (*ParallelStatus, error) {
+	body := map[string]interface{}{"node": node, "devices": devices}
+	var status ParallelStatus
+	if err := a.parallelRequest(ctx, "POST", parallelEndpoint(model)+"/status", body, &status); err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
+
+func (c *Client) parallelRequest(ctx context.Context, method, endpoint string, body, out interface{}) error {
+	resp, err := c.RequestContext(ctx, method, endpoint, body)
+	if err != nil {
+		return err
+	}
+	return decodeResponse(resp, out)
+}
@@ -0,0 +1,150 @@
+package inferno
+
+import (
+	"context"
+	"net/url"
+	"time"
+)
+
+// PlacementAction is a change a rebalance asks of a node
+type PlacementAction string
+
+const (
+	PlacementLoad   PlacementAction = "load"
+	PlacementUnload PlacementAction = "unload"
+)
+
+// Placement structures
+
+// Pin requires a model to run on Node, and on GPU there if set
+type Pin struct {
+	Node string `json:"node"`
+	GPU  *int   `json:"gpu,omitempty"`
+}
+
+type PlacementRule struct {
+	// Replicas is the nodes the model should run on, pins included; at
+	// least 1 and at least len(Pins)
+	Replicas int   `json:"replicas"`
+	Pins     []Pin `json:"pins,omitempty"`
+}
+
+type Replica struct {
+	Node   string `json:"node"`
+	GPU    *int   `json:"gpu"`
+	Pinned bool   `json:"pinned"`
+}
+
+type ModelPlacement struct {
+	Model     string        `json:"model"`
+	Rule      PlacementRule `json:"rule"`
+	UpdatedAt time.Time     `json:"updated_at"`
+	// Current lists the nodes that report the model loaded
+	Current []string `json:"current"`
+	// Target is where the last rebalance put the model; nil before one
+	Target []Replica `json:"target"`
+}
+
+type PlacementsResponse struct {
+	Object       string           `json:"object"`
+	Data         []ModelPlacement `json:"data"`
+	RebalancedAt *time.Time       `json:"rebalanced_at"`
+}
+
+type PlacementChange struct {
+	Action PlacementAction `json:"action"`
+	Model  string          `json:"model"`
+	Node   string          `json:"node"`
+	GPU    *int            `json:"gpu"`
+}
+
+// Shortfall is a model with fewer healthy worker nodes than replicas
+type Shortfall struct {
+	Model    string `json:"model"`
+	Replicas int    `json:"replicas"`
+	Placed   int    `json:"placed"`
+}
+
+type RebalanceResult struct {
+	DryRun     bool                 `json:"dry_run"`
+	Targets    map[string][]Replica `json:"targets"`
+	Changes    []PlacementChange    `json:"changes"`
+	Shortfalls []Shortfall          `json:"shortfalls"`
+}
+
+type NodeModel struct {
+	Model string `json:"model"`
+	GPU   *int   `json:"gpu"`
+}
+
+type NodePlacement struct {
+	Node         string      `json:"node"`
+	Models       []NodeModel `json:"models"`
+	RebalancedAt *time.Time  `json:"rebalanced_at"`
+}
+
+func placementEndpoint(model string) string {
+	return "/cluster/placement/" + url.PathEscape(model)
+}
+
+// Placements lists every model's rule with its current and target nodes
+func (c *Client) Placements(ctx context.Context) (*PlacementsResponse, error) {
+	var result PlacementsResponse
+	if err := c.placementRequest(ctx, "GET", "/cluster/placement", nil, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// Placement returns one model's rule and placement
+func (c *Client) Placement(ctx context.Context, model string) (*ModelPlacement, error) {
+	var placement ModelPlacement
+	if err := c.placementRequest(ctx, "GET", placementEndpoint(model), nil, &placement); err != nil {
+		return nil, err
+	}
+	return &placement, nil
+}
+
+// NodePlacement returns the models a node should serve after the last
+// rebalance; a node agent loads the ones it lacks and unloads the rest
+func (c *Client) NodePlacement(ctx context.Context, node string) (*NodePlacement, error) {
+	var placement NodePlacement
+	endpoint := "/cluster/placement/nodes/" + url.PathEscape(node)
+	if err := c.placementRequest(ctx, "GET", endpoint, nil, &placement); err != nil {
+		return nil, err
+	}
+	return &placement, nil
+}
+
+// SetPlacement sets model's rule. It takes effect at the next Rebalance.
+func (a *AdminClient) SetPlacement(ctx context.Context, model string, rule PlacementRule) (*ModelPlacement, error) {
+	var placement ModelPlacement
+	if err := a.placementRequest(ctx, "PUT", placementEndpoint(model), rule, &placement); err != nil {
+		return nil, err
+	}
+	return &placement, nil
+}
+
+// DeletePlacement drops model's rule, leaving its replicas where they are
+func (a *AdminClient) DeletePlacement(ctx context.Context, model string) error {
+	return a.placementRequest(ctx, "DELETE", placementEndpoint(model), nil, nil)
+}
+
+// Rebalance recomputes every model's placement from the rules and the
+// nodes' loaded models. With dryRun the changes are only reported.
+func (a *AdminClient) Rebalance(ctx context.Context, dryRun bool) (*RebalanceResult, error) {
+	var result RebalanceResult
+	body := map[string]bool{"dry_run": dryRun}
+	if err := a.placementRequest(ctx, "POST", "/cluster/placement/rebalance", body, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+func (c *Client) placementRequest(ctx context.Context, method, endpoint string, body, out interface{}) error {
+	resp, err := c.RequestContext(ctx, method, endpoint, body)
+	if err != nil {
+		return err
+	}
+	return decodeResponse(resp, out)
+}
@@ -0,0 +1,86 @@
+package inferno
+
+import (
+	"context"
+	"io"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+)
+
+// ProfileKind is a profile the server can capture
+type ProfileKind string
+
+const (
+	// ProfileCPU is a pprof protobuf for go tool pprof; the server needs the
+	// profiling feature
+	ProfileCPU ProfileKind = "cpu"
+	// ProfileThreads lists the server's threads with state and CPU time,
+	// the native counterpart of a goroutine dump
+	ProfileThreads ProfileKind = "threads"
+	// ProfileHeap reports the server process's memory use
+	ProfileHeap ProfileKind = "heap"
+)
+
+// Profile structures
+type ProfileInfo struct {
+	Kind ProfileKind `json:"kind"`
+	// Format is "pprof" or "text"
+	Format      string `json:"format"`
+	Supported   bool   `json:"supported"`
+	Description string `json:"description"`
+}
+
+type ProfilesResponse struct {
+	Object string        `json:"object"`
+	Data   []ProfileInfo `json:"data"`
+}
+
+// Profiles lists the profiles the server can capture
+func (a *AdminClient) Profiles(ctx context.Context) ([]ProfileInfo, error) {
+	var result ProfilesResponse
+	if err := a.adminRequest(ctx, "GET", "/admin/profile", nil, &result); err != nil {
+		return nil, err
+	}
+	return result.Data, nil
+}
+
+// FetchProfile captures a profile and writes it to w. CPU profiles sample
+// for duration (rounded to whole seconds; 0 uses the server's default of
+// 30s) and ignore HTTPClient.Timeout while they run.
+func (a *AdminClient) FetchProfile(ctx context.Context, kind ProfileKind, duration time.Duration, w io.Writer) error {
+	endpoint := "/admin/profile/" + url.PathEscape(string(kind))
+	if kind == ProfileCPU && duration > 0 {
+		seconds := int((duration + time.Second - 1) / time.Second)
+		endpoint += "?seconds=" + strconv.Itoa(seconds)
+	}
+
+	resp, err := a.longRunningRequest(ctx, "GET", endpoint, nil)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode >= 400 {
+		return decodeResponse(resp, nil)
+	}
+	defer resp.Body.Close()
+
+	_, err = io.Copy(w, resp.Body)
+	return err
+}
+
+// SaveProfile captures a profile into path, such as cpu.pb for
+// `go tool pprof cpu.pb`
+func (a *AdminClient) SaveProfile(ctx context.Context, kind ProfileKind, duration time.Duration, path string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	if err := a.FetchProfile(ctx, kind, duration, file); err != nil {
+		file.Close()
+		os.Remove(path)
+		return err
+	}
+	return file.Close()
+}
@@ -0,0 +1,79 @@
+package inferno
+
+import "time"
+
+// Queue introspection structures
+type ModelQueueStats struct {
+	Model              string         `json:"model"`
+	Queued             int            `json:"queued"`
+	Running            int            `json:"running"`
+	ByPriority         map[string]int `json:"by_priority"`
+	EstimatedWaitMs    int64          `json:"estimated_wait_ms"`
+	OldestRequestAgeMs int64          `json:"oldest_request_age_ms"`
+	AvgServiceTimeMs   int64          `json:"avg_service_time_ms"`
+}
+
+type QueueStatsResponse struct {
+	TotalQueued        int               `json:"total_queued"`
+	TotalRunning       int               `json:"total_running"`
+	ByPriority         map[string]int    `json:"by_priority"`
+	OldestRequestAgeMs int64             `json:"oldest_request_age_ms"`
+	Models             []ModelQueueStats `json:"models"`
+	Timestamp          time.Time         `json:"timestamp"`
+}
+
+// Model returns the stats for a single model, or nil if nothing is queued for it
+func (s *QueueStatsResponse) Model(model string) *ModelQueueStats {
+	for i := range s.Models {
+		if s.Models[i].Model == model {
+			return &s.Models[i]
+		}
+	}
+	return nil
+}
+
+type QueuedRequestInfo struct {
+	RequestID  string    `json:"request_id"`
+	Model      string    `json:"model"`
+	Priority   string    `json:"priority"`
+	State      string    `json:"state"`
+	EnqueuedAt time.Time `json:"enqueued_at"`
+	AgeMs      int64     `json:"age_ms"`
+}
+
+type QueuedRequestsResponse struct {
+	Total int                 `json:"total"`
+	Data  []QueuedRequestInfo `json:"data"`
+}
+
+// QueueStats returns queue depth per model and priority, the estimated wait
+// for new requests and the age of the oldest queued request
+func (c *Client) QueueStats() (*QueueStatsResponse, error) {
+	resp, err := c.Request("GET", "/v1/queue/stats", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var stats QueueStatsResponse
+	if err := decodeResponse(resp, &stats); err != nil {
+		return nil, err
+	}
+
+	return &stats, nil
+}
+
+// QueuedRequests lists queued and running requests, oldest first.
+// Requires the client's API key to be the server's admin token.
+func (c *Client) QueuedRequests() ([]QueuedRequestInfo, error) {
+	resp, err := c.Request("GET", "/v1/queue/requests", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var result QueuedRequestsResponse
+	if err := decodeResponse(resp, &result); err != nil {
+		return nil, err
+	}
+
+	return result.Data, nil
+}
@@ -0,0 +1,120 @@
+package inferno
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Quota units
+const (
+	QuotaRequests = "requests"
+	QuotaTokens   = "tokens"
+)
+
+// Quota structures
+type QuotaWindow struct {
+	// Unit is QuotaRequests or QuotaTokens
+	Unit          string `json:"unit"`
+	Limit         int64  `json:"limit"`
+	Used          int64  `json:"used"`
+	Remaining     int64  `json:"remaining"`
+	WindowSeconds int    `json:"window_seconds"`
+	// ResetsAt is when the usage counted now has all left the window;
+	// capacity frees up gradually before then
+	ResetsAt time.Time `json:"resets_at"`
+}
+
+type QuotaConcurrency struct {
+	Limit     int `json:"limit"`
+	InFlight  int `json:"in_flight"`
+	Remaining int `json:"remaining"`
+}
+
+// Quota is what the client's tenant may still send
+type Quota struct {
+	Object string `json:"object"`
+	Tenant string `json:"tenant"`
+	// Limited is false when the tenant has no limits
+	Limited     bool              `json:"limited"`
+	Suspended   bool              `json:"suspended"`
+	Windows     []QuotaWindow     `json:"windows"`
+	Concurrency *QuotaConcurrency `json:"concurrency,omitempty"`
+}
+
+// Window returns the window counting unit, or nil when that unit is
+// unlimited
+func (q *Quota) Window(unit string) *QuotaWindow {
+	for i := range q.Windows {
+		if q.Windows[i].Unit == unit {
+			return &q.Windows[i]
+		}
+	}
+	return nil
+}
+
+// QuotaExceededError is returned by pre-flight checks when work would not
+// fit the tenant's remaining quota, before any of it is sent
+type QuotaExceededError struct {
+	Tenant string
+	// Unit is QuotaRequests or QuotaTokens
+	Unit      string
+	Needed    int64
+	Remaining int64
+	ResetsAt  time.Time
+}
+
+func (e *QuotaExceededError) Error() string {
+	return fmt.Sprintf("inferno: tenant %q needs %d %s but has %d left until %s",
+		e.Tenant, e.Needed, e.Unit, e.Remaining, e.ResetsAt.Format(time.RFC3339))
+}
+
+// Check returns a *QuotaExceededError when requests, asking for tokens
+// completion tokens in all (max_tokens times n for each), would not fit
+// the remaining quota. Unlimited windows always fit.
+func (q *Quota) Check(requests int, tokens int64) error {
+	for _, need := range []struct {
+		unit   string
+		amount int64
+	}{{QuotaRequests, int64(requests)}, {QuotaTokens, tokens}} {
+		window := q.Window(need.unit)
+		if window == nil || need.amount <= window.Remaining {
+			continue
+		}
+		return &QuotaExceededError{
+			Tenant:    q.Tenant,
+			Unit:      need.unit,
+			Needed:    need.amount,
+			Remaining: window.Remaining,
+			ResetsAt:  window.ResetsAt,
+		}
+	}
+	return nil
+}
+
+// Quota returns the requests and tokens the client's tenant may still send
+// in each window
+func (c *Client) Quota(ctx context.Context) (*Quota, error) {
+	resp, err := c.RequestContext(ctx, "GET", "/usage/quota", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var quota Quota
+	if err := decodeResponse(resp, &quota); err != nil {
+		return nil, err
+	}
+	return &quota, nil
+}
+
+// CheckQuota fetches the quota and fails fast with a *QuotaExceededError
+// when a batch of requests asking for tokens in all would not fit, so a
+// large batch is not cut off by 429s part way through. Other clients of
+// the tenant share the quota, so a passing check is not a reservation.
+func (c *Client) CheckQuota(ctx context.Context, requests int, tokens int64) error {
+	quota, err := c.Quota(ctx)
+	if err != nil {
+		return err
+	}
+	return quota.Check(requests, tokens)
+}
@@ -0,0 +1,296 @@
+package inferno
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// latencyWeight is the weight of each new latency sample in an endpoint's
+// moving average
+const latencyWeight = 0.3
+
+// unhealthyBackoff is how long an endpoint that failed a request or probe
+// is passed over, unless a probe succeeds sooner
+const unhealthyBackoff = 30 * time.Second
+
+// probeTimeout bounds each probe
+const probeTimeout = 5 * time.Second
+
+// latencyProbePath is what ProbeLatency requests
+const latencyProbePath = "/health/live"
+
+// ErrNoRegionEndpoint is returned for requests made WithRegion a region
+// none of the client's endpoints is in
+var ErrNoRegionEndpoint = errors.New("inferno: no endpoint in the requested region")
+
+// EndpointLatency is what the client has measured of one endpoint
+type EndpointLatency struct {
+	Endpoint string
+	Region   string
+	// Latency is a moving average, zero until measured
+	Latency time.Duration
+	Samples int
+	// Healthy is false while the endpoint is passed over after a failure
+	Healthy bool
+}
+
+// endpointHealth is the measurements of one endpoint
+type endpointHealth struct {
+	latency        time.Duration
+	samples        int
+	unhealthyUntil time.Time
+}
+
+// latencyTracker holds the client's endpoint measurements
+type latencyTracker struct {
+	mu        sync.Mutex
+	endpoints map[string]*endpointHealth
+}
+
+// regionKey carries the region set by WithRegion
+type regionKey struct{}
+
+// WithRegion returns a context whose requests go only to endpoints in
+// region, as Client.Regions assigns them, for data that must stay there.
+// The fastest healthy one is preferred with LatencyRouting; requests fail
+// with ErrNoRegionEndpoint if the client has none in region.
+func WithRegion(ctx context.Context, region string) context.Context {
+	return context.WithValue(ctx, regionKey{}, region)
+}
+
+func regionFor(ctx context.Context) string {
+	region, _ := ctx.Value(regionKey{}).(string)
+	return region
+}
+
+// regionOf returns the region Regions assigns endpoint
+func (c *Client) regionOf(endpoint string) string {
+	for candidate, region := range c.Regions {
+		if strings.TrimSuffix(candidate, "/") == endpoint {
+			return region
+		}
+	}
+	return ""
+}
+
+// regionEndpoints lists the endpoints in region, or all of them when region
+// is empty
+func (c *Client) regionEndpoints(region string) []string {
+	if region == "" {
+		return c.endpoints()
+	}
+	var endpoints []string
+	for _, endpoint := range c.endpoints() {
+		if c.regionOf(endpoint) == region {
+			endpoints = append(endpoints, endpoint)
+		}
+	}
+	return endpoints
+}
+
+// checkRegion fails requests made with ctx if their region has no endpoint
+func (c *Client) checkRegion(ctx context.Context) error {
+	if region := regionFor(ctx); region != "" && len(c.regionEndpoints(region)) == 0 {
+		return fmt.Errorf("%w: %q", ErrNoRegionEndpoint, region)
+	}
+	return nil
+}
+
+// endpointOf returns the endpoint req was sent to
+func (c *Client) endpointOf(req *http.Request) string {
+	target := req.URL.String()
+	for _, endpoint := range c.endpoints() {
+		if strings.HasPrefix(target, endpoint) {
+			return endpoint
+		}
+	}
+	return ""
+}
+
+// pickEndpoint chooses among endpoints that are not draining: the first
+// healthy one, or with LatencyRouting the fastest measured healthy one
+func (c *Client) pickEndpoint(available []string) string {
+	c.latency.mu.Lock()
+	defer c.latency.mu.Unlock()
+
+	now := time.Now()
+	var healthy []string
+	for _, endpoint := range available {
+		health := c.latency.endpoints[endpoint]
+		if health == nil || !now.Before(health.unhealthyUntil) {
+			healthy = append(healthy, endpoint)
+		}
+	}
+	if len(healthy) == 0 {
+		return available[0]
+	}
+	if !c.LatencyRouting {
+		return healthy[0]
+	}
+
+	// Unmeasured endpoints rank after measured ones, in order
+	best := healthy[0]
+	var bestLatency time.Duration
+	for _, endpoint := range healthy {
+		health := c.latency.endpoints[endpoint]
+		if health == nil || health.samples == 0 {
+			continue
+		}
+		if bestLatency == 0 || health.latency < bestLatency {
+			best, bestLatency = endpoint, health.latency
+		}
+	}
+	return best
+}
+
+// recordLatency adds a sample to endpoint's moving average and counts it
+// healthy again
+func (c *Client) recordLatency(endpoint string, sample time.Duration) {
+	if endpoint == "" {
+		return
+	}
+	c.latency.mu.Lock()
+	defer c.latency.mu.Unlock()
+
+	health := c.latency.health(endpoint)
+	if health.samples == 0 {
+		health.latency = sample
+	} else {
+		health.latency += time.Duration(latencyWeight * float64(sample-health.latency))
+	}
+	health.samples++
+	health.unhealthyUntil = time.Time{}
+}
+
+// markUnhealthy passes over endpoint for unhealthyBackoff
+func (c *Client) markUnhealthy(endpoint string) {
+	if endpoint == "" {
+		return
+	}
+	c.latency.mu.Lock()
+	defer c.latency.mu.Unlock()
+	c.latency.health(endpoint).unhealthyUntil = time.Now().Add(unhealthyBackoff)
+}
+
+func (t *latencyTracker) health(endpoint string) *endpointHealth {
+	if t.endpoints == nil {
+		t.endpoints = map[string]*endpointHealth{}
+	}
+	health, ok := t.endpoints[endpoint]
+	if !ok {
+		health = &endpointHealth{}
+		t.endpoints[endpoint] = health
+	}
+	return health
+}
+
+// observe notes what one attempt of req says about its endpoint. A
+// transport error marks it unhealthy. GET and HEAD requests, which the
+// server answers without generating, are latency samples; other requests
+// take as long as their work does, so they are not.
+func (c *Client) observe(req *http.Request, resp *http.Response, err error, elapsed time.Duration) {
+	endpoint := c.endpointOf(req)
+	switch {
+	case err != nil:
+		if req.Context().Err() == nil {
+			c.markUnhealthy(endpoint)
+		}
+	case resp.StatusCode >= 500:
+	case req.Method == http.MethodGet || req.Method == http.MethodHead:
+		c.recordLatency(endpoint, elapsed)
+	}
+}
+
+// ProbeLatency measures every endpoint once with GET /health/live,
+// concurrently, and returns the measurements
+func (c *Client) ProbeLatency(ctx context.Context) []EndpointLatency {
+	var wg sync.WaitGroup
+	for _, endpoint := range c.endpoints() {
+		wg.Add(1)
+		go func(endpoint string) {
+			defer wg.Done()
+			c.probe(ctx, endpoint, latencyProbePath)
+		}(endpoint)
+	}
+	wg.Wait()
+	return c.EndpointLatencies()
+}
+
+// probe requests path from endpoint, recording its latency or marking it
+// unhealthy, and reports whether it answered below 500 in time
+func (c *Client) probe(ctx context.Context, endpoint, path string) bool {
+	ctx, cancel := context.WithTimeout(ctx, probeTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint+path, nil)
+	if err != nil {
+		return false
+	}
+	started := time.Now()
+	resp, err := c.do(c.HTTPClient, req)
+	if err != nil {
+		if ctx.Err() == nil || errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			c.markUnhealthy(endpoint)
+		}
+		return false
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 500 {
+		c.markUnhealthy(endpoint)
+		return false
+	}
+	c.recordLatency(endpoint, time.Since(started))
+	return true
+}
+
+// StartLatencyProbes probes every endpoint each interval until ctx is done,
+// so LatencyRouting knows endpoints the client's own requests have not
+// used and notices when a failed one recovers
+func (c *Client) StartLatencyProbes(ctx context.Context, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			c.ProbeLatency(ctx)
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// EndpointLatencies returns the measurements of each endpoint, fastest
+// first, unmeasured last
+func (c *Client) EndpointLatencies() []EndpointLatency {
+	endpoints := c.endpoints()
+	c.latency.mu.Lock()
+	now := time.Now()
+	latencies := make([]EndpointLatency, 0, len(endpoints))
+	for _, endpoint := range endpoints {
+		latency := EndpointLatency{Endpoint: endpoint, Region: c.regionOf(endpoint), Healthy: true}
+		if health := c.latency.endpoints[endpoint]; health != nil {
+			latency.Latency = health.latency
+			latency.Samples = health.samples
+			latency.Healthy = !now.Before(health.unhealthyUntil)
+		}
+		latencies = append(latencies, latency)
+	}
+	c.latency.mu.Unlock()
+
+	sort.SliceStable(latencies, func(i, j int) bool {
+		a, b := latencies[i], latencies[j]
+		if (a.Samples == 0) != (b.Samples == 0) {
+			return b.Samples == 0
+		}
+		return a.Latency < b.Latency
+	})
+	return latencies
+}
@@ -0,0 +1,43 @@
+package inferno
+
+import (
+	"context"
+	"io"
+
+	"inferno-example/infernorepl"
+)
+
+// REPLChat returns an infernorepl.Chat that streams each reply from
+// /v1/chat/completions
+func (c *Client) REPLChat() infernorepl.Chat {
+	return func(ctx context.Context, model string, messages []infernorepl.Message, w io.Writer) (string, error) {
+		request := ChatCompletionRequest{Model: model}
+		for _, message := range messages {
+			request.Messages = append(request.Messages, ChatMessage{Role: Role(message.Role), Content: message.Content})
+		}
+
+		stream, err := c.ChatStream(ctx, request)
+		if err != nil {
+			return "", err
+		}
+		defer stream.Close()
+
+		var reply []byte
+		for {
+			token, err := stream.Recv()
+			if err == io.EOF {
+				return string(reply), nil
+			}
+			if err != nil {
+				if ctx.Err() != nil {
+					err = ctx.Err()
+				}
+				return string(reply), err
+			}
+			reply = append(reply, token...)
+			if _, err := io.WriteString(w, token); err != nil {
+				return string(reply), err
+			}
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package inferno
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// defaultRetryWindow is the RetryBudget window when none is set
+const defaultRetryWindow = 10 * time.Second
+
+// defaultMinAttemptTime is the Client.MinAttemptTime used when it is zero
+const defaultMinAttemptTime = 100 * time.Millisecond
+
+// RetryBudget caps retries at a share of the requests sent, so that during
+// an outage each caller adds at most that much load instead of multiplying
+// it. Requests and retries are counted over a window that restarts when it
+// ends; a RetryBudget is safe for use by several clients.
+type RetryBudget struct {
+	// Ratio is the most retries allowed per request, such as 0.2 for one
+	// retry in five requests
+	Ratio float64
+	// MinRetries are allowed in each window whatever Ratio says, so a
+	// client sending few requests can still retry
+	MinRetries int
+	// Window is how long requests and retries are counted for; zero means
+	// ten seconds
+	Window time.Duration
+
+	mu          sync.Mutex
+	windowStart time.Time
+	requests    int
+	retries     int
+}
+
+// NewRetryBudget returns a budget allowing ratio retries per request and
+// minRetries per window regardless
+func NewRetryBudget(ratio float64, minRetries int) *RetryBudget {
+	return &RetryBudget{Ratio: ratio, MinRetries: minRetries}
+}
+
+// DefaultRetryBudget is the budget NewClient gives each client: retries up
+// to 20% of requests, and at least 10 every ten seconds
+func DefaultRetryBudget() *RetryBudget {
+	return NewRetryBudget(0.2, 10)
+}
+
+// roll starts a new window when the current one has ended. b.mu is held.
+func (b *RetryBudget) roll(now time.Time) {
+	window := b.Window
+	if window <= 0 {
+		window = defaultRetryWindow
+	}
+	if now.Sub(b.windowStart) >= window {
+		b.windowStart = now
+		b.requests = 0
+		b.retries = 0
+	}
+}
+
+// recordRequest counts a request sent for the first time
+func (b *RetryBudget) recordRequest() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.roll(time.Now())
+	b.requests++
+}
+
+// withdraw counts a retry if the budget allows one, reporting whether it
+// does
+func (b *RetryBudget) withdraw() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.roll(time.Now())
+	if b.retries >= b.MinRetries && float64(b.retries) >= b.Ratio*float64(b.requests) {
+		return false
+	}
+	b.retries++
+	return true
+}
+
+// mayRetry reports whether req can be sent again: enough of its context's
+// deadline must be left for another attempt, and the retry budget must
+// allow it
+func (c *Client) mayRetry(req *http.Request) bool {
+	minAttempt := c.MinAttemptTime
+	if minAttempt <= 0 {
+		minAttempt = defaultMinAttemptTime
+	}
+	if deadline, ok := req.Context().Deadline(); ok && time.Until(deadline) < minAttempt {
+		return false
+	}
+	return c.RetryBudget == nil || c.RetryBudget.withdraw()
+}
+
+// attemptClient returns httpClient with its Timeout shrunk to the time left
+// before ctx's deadline, so one attempt cannot claim time the caller no
+// longer has
+func attemptClient(ctx context.Context, httpClient *http.Client) *http.Client {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return httpClient
+	}
+	remaining := time.Until(deadline)
+	if httpClient.Timeout > 0 && httpClient.Timeout <= remaining {
+		return httpClient
+	}
+	if remaining <= 0 {
+		// The request fails at once on the expired context
+		return httpClient
+	}
+	shrunk := *httpClient
+	shrunk.Timeout = remaining
+	return &shrunk
+}
@@ -0,0 +1,122 @@
+package inferno
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// drainingServer refuses every request with 503 as a draining server does,
+// counting them
+func drainingServer(hits *int32) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(hits, 1)
+		w.Header().Set(DrainingHeader, "true")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte(`{"error":{"message":"draining"}}`))
+	}))
+}
+
+func TestDrainingRequestMovesToNextEndpoint(t *testing.T) {
+	var drainingHits, healthyHits int32
+	draining := drainingServer(&drainingHits)
+	defer draining.Close()
+	healthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&healthyHits, 1)
+		w.Write([]byte(`{"data":[{"embedding":[1],"index":0}]}`))
+	}))
+	defer healthy.Close()
+
+	client := NewClient(draining.URL, "")
+	client.Endpoints = []string{healthy.URL}
+	ctx := context.Background()
+
+	for i := 0; i < 2; i++ {
+		if _, err := client.EmbeddingsContext(ctx, EmbeddingsRequest{Model: "bge", Input: []string{"a"}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The refused request is resent with its body, and the draining server
+	// is then passed over
+	if d, h := atomic.LoadInt32(&drainingHits), atomic.LoadInt32(&healthyHits); d != 1 || h != 2 {
+		t.Errorf("draining server got %d requests, healthy one %d; want 1 and 2", d, h)
+	}
+}
+
+func TestDrainingRetryNeedsTimeLeft(t *testing.T) {
+	var drainingHits, nextHits int32
+	draining := drainingServer(&drainingHits)
+	defer draining.Close()
+	next := drainingServer(&nextHits)
+	defer next.Close()
+
+	client := NewClient(draining.URL, "")
+	client.Endpoints = []string{next.URL}
+	client.MinAttemptTime = time.Hour
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	resp, err := client.RequestContext(ctx, "GET", "/v1/models", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = decodeResponse(resp, nil)
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("got %v, want the 503", err)
+	}
+	if atomic.LoadInt32(&nextHits) != 0 {
+		t.Errorf("retried with less than MinAttemptTime left")
+	}
+}
+
+func TestDrainingRetryDrawsOnBudget(t *testing.T) {
+	var firstHits, secondHits int32
+	first := drainingServer(&firstHits)
+	defer first.Close()
+	second := drainingServer(&secondHits)
+	defer second.Close()
+
+	client := NewClient(first.URL, "")
+	client.Endpoints = []string{second.URL}
+	client.RetryBudget = NewRetryBudget(0, 0)
+
+	resp, err := client.RequestContext(context.Background(), "GET", "/v1/models", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if retries := atomic.LoadInt32(&secondHits); resp.StatusCode != http.StatusServiceUnavailable || retries != 0 {
+		t.Errorf("status %d after %d retries, want the 503 and none", resp.StatusCode, retries)
+	}
+}
+
+func TestRetryBudget(t *testing.T) {
+	budget := NewRetryBudget(0.5, 1)
+	budget.recordRequest()
+	budget.recordRequest()
+
+	if !budget.withdraw() {
+		t.Fatal("MinRetries should allow the first retry")
+	}
+	if budget.withdraw() {
+		t.Fatal("a second retry for two requests exceeds a ratio of 0.5")
+	}
+	budget.recordRequest()
+	budget.recordRequest()
+	if !budget.withdraw() {
+		t.Fatal("four requests allow two retries")
+	}
+
+	// A new window starts the count again
+	budget.Window = time.Millisecond
+	time.Sleep(2 * time.Millisecond)
+	if !budget.withdraw() {
+		t.Fatal("the next window should allow MinRetries again")
+	}
+}
@@ -0,0 +1,200 @@
+package inferno
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/url"
+	"time"
+)
+
+// Rollout structures
+type RolloutSpec struct {
+	Alias                string  `json:"alias"`
+	BaselineModel        string  `json:"baseline_model"`
+	CandidateModel       string  `json:"candidate_model"`
+	Steps                []int   `json:"steps,omitempty"`
+	StepDurationSecs     int64   `json:"step_duration_secs,omitempty"`
+	MinRequestsPerStep   int64   `json:"min_requests_per_step,omitempty"`
+	MaxErrorRateIncrease float64 `json:"max_error_rate_increase,omitempty"`
+	MaxLatencyRatio      float64 `json:"max_latency_ratio,omitempty"`
+}
+
+type ArmHealth struct {
+	Requests     int64   `json:"requests"`
+	Errors       int64   `json:"errors"`
+	ErrorRate    float64 `json:"error_rate"`
+	AvgLatencyMs float64 `json:"avg_latency_ms"`
+}
+
+type Rollout struct {
+	ID               string      `json:"id"`
+	Spec             RolloutSpec `json:"spec"`
+	State            string      `json:"state"`
+	Step             int         `json:"step"`
+	CandidatePercent int         `json:"candidate_percent"`
+	StepStartedAt    time.Time   `json:"step_started_at"`
+	CreatedAt        time.Time   `json:"created_at"`
+	FinishedAt       *time.Time  `json:"finished_at,omitempty"`
+	Baseline         ArmHealth   `json:"baseline"`
+	Candidate        ArmHealth   `json:"candidate"`
+	Reason           *string     `json:"reason,omitempty"`
+}
+
+// Done reports whether the rollout has completed or rolled back
+func (r *Rollout) Done() bool {
+	return r.State != "progressing"
+}
+
+type RolloutEvent struct {
+	RolloutID        string    `json:"rollout_id"`
+	State            string    `json:"state"`
+	Step             int       `json:"step"`
+	CandidatePercent int       `json:"candidate_percent"`
+	Message          string    `json:"message"`
+	Timestamp        time.Time `json:"timestamp"`
+}
+
+type RolloutsResponse struct {
+	Object string    `json:"object"`
+	Data   []Rollout `json:"data"`
+}
+
+func rolloutEndpoint(id string) string {
+	return "/v1/rollouts/" + url.PathEscape(id)
+}
+
+// StartRollout begins a canary rollout of spec.CandidateModel on
+// spec.Alias. Zero-valued tuning fields use the server defaults. Requires
+// the admin token.
+func (c *Client) StartRollout(spec RolloutSpec) (*Rollout, error) {
+	return c.rolloutRequest("POST", "/v1/rollouts", spec)
+}
+
+// Rollouts lists all rollouts, newest first
+func (c *Client) Rollouts() ([]Rollout, error) {
+	resp, err := c.Request("GET", "/v1/rollouts", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var result RolloutsResponse
+	if err := decodeResponse(resp, &result); err != nil {
+		return nil, err
+	}
+
+	return result.Data, nil
+}
+
+// Rollout returns a rollout's status and per-arm health
+func (c *Client) Rollout(id string) (*Rollout, error) {
+	return c.rolloutRequest("GET", rolloutEndpoint(id), nil)
+}
+
+// RollbackRollout returns all traffic to the baseline. Requires the admin token.
+func (c *Client) RollbackRollout(id string) (*Rollout, error) {
+	return c.rolloutRequest("POST", rolloutEndpoint(id)+"/rollback", nil)
+}
+
+// PromoteRollout sends all traffic to the candidate without waiting for the
+// remaining steps. Requires the admin token.
+func (c *Client) PromoteRollout(id string) (*Rollout, error) {
+	return c.rolloutRequest("POST", rolloutEndpoint(id)+"/promote", nil)
+}
+
+func (c *Client) rolloutRequest(method, endpoint string, body interface{}) (*Rollout, error) {
+	resp, err := c.Request(method, endpoint, body)
+	if err != nil {
+		return nil, err
+	}
+
+	var rollout Rollout
+	if err := decodeResponse(resp, &rollout); err != nil {
+		return nil, err
+	}
+
+	return &rollout, nil
+}
+
+// WatchRollout calls handle for each progress event until the rollout
+// finishes, ctx is done or handle returns an error. The first event is the
+// rollout's current status.
+func (c *Client) WatchRollout(ctx context.Context, id string, handle func(RolloutEvent) error) error {
+	resp, err := c.longRunningRequest(ctx, "GET", rolloutEndpoint(id)+"/events", nil)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode >= 400 {
+		return decodeResponse(resp, nil)
+	}
+	defer resp.Body.Close()
+
+	return ReadServerSentEvents(resp.Body, func(data []byte) error {
+		var event RolloutEvent
+		if err := json.Unmarshal(data, &event); err != nil {
+			return err
+		}
+		return handle(event)
+	})
+}
+
+// ReadServerSentEvents passes the data of each server-sent event in body to
+// handle until the stream ends or handle returns an error. Multi-line data
+// is joined with newlines.
+func ReadServerSentEvents(body io.Reader, handle func(data []byte) error) error {
+	events := newSSEReader(body)
+	for {
+		data, err := events.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := handle(data); err != nil {
+			return err
+		}
+	}
+}
+
+// sseReader reads server-sent events one at a time, for callers that pull
+// events rather than handle them in a callback
+type sseReader struct {
+	scanner *bufio.Scanner
+}
+
+func newSSEReader(body io.Reader) *sseReader {
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	return &sseReader{scanner: scanner}
+}
+
+// Next returns the data of the next event, or io.EOF once the stream ends
+func (r *sseReader) Next() ([]byte, error) {
+	var data []byte
+	for r.scanner.Scan() {
+		line := r.scanner.Bytes()
+		switch {
+		case len(line) == 0:
+			if len(data) > 0 {
+				return data, nil
+			}
+		case bytes.HasPrefix(line, []byte("data:")):
+			chunk := bytes.TrimPrefix(bytes.TrimPrefix(line, []byte("data:")), []byte(" "))
+			if len(data) > 0 {
+				data = append(data, '\n')
+			}
+			data = append(data, chunk...)
+		}
+	}
+
+	if err := r.scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(data) > 0 {
+		return data, nil
+	}
+	return nil, io.EOF
+}
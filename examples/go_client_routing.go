@@ -0,0 +1,100 @@
+package inferno
+
+import (
+	"net/url"
+	"time"
+)
+
+// Routing structures
+type RouteArm struct {
+	Model  string `json:"model"`
+	Weight int    `json:"weight"`
+}
+
+type RoutingRule struct {
+	Alias       string     `json:"alias,omitempty"`
+	Arms        []RouteArm `json:"arms"`
+	Description string     `json:"description,omitempty"`
+}
+
+type ArmUsage struct {
+	Model           string  `json:"model"`
+	Weight          int     `json:"weight"`
+	ConfiguredShare float64 `json:"configured_share"`
+	Requests        int64   `json:"requests"`
+	ObservedShare   float64 `json:"observed_share"`
+}
+
+type RoutingRuleStatus struct {
+	Alias         string     `json:"alias"`
+	Arms          []RouteArm `json:"arms"`
+	Description   string     `json:"description,omitempty"`
+	CreatedAt     time.Time  `json:"created_at"`
+	TotalRequests int64      `json:"total_requests"`
+	Usage         []ArmUsage `json:"usage"`
+}
+
+type RoutingRulesResponse struct {
+	Object string              `json:"object"`
+	Data   []RoutingRuleStatus `json:"data"`
+}
+
+func routeEndpoint(alias string) string {
+	return "/v1/routes/" + url.PathEscape(alias)
+}
+
+// RoutingRules lists all routing rules with per-arm usage
+func (c *Client) RoutingRules() ([]RoutingRuleStatus, error) {
+	resp, err := c.Request("GET", "/v1/routes", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var result RoutingRulesResponse
+	if err := decodeResponse(resp, &result); err != nil {
+		return nil, err
+	}
+
+	return result.Data, nil
+}
+
+// RoutingRule returns a single routing rule with per-arm usage
+func (c *Client) RoutingRule(alias string) (*RoutingRuleStatus, error) {
+	resp, err := c.Request("GET", routeEndpoint(alias), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var status RoutingRuleStatus
+	if err := decodeResponse(resp, &status); err != nil {
+		return nil, err
+	}
+
+	return &status, nil
+}
+
+// SetRoutingRule creates or replaces the rule for alias. Requests naming the
+// alias are then split across the arms by weight. Requires the admin token.
+func (c *Client) SetRoutingRule(alias string, rule RoutingRule) (*RoutingRuleStatus, error) {
+	resp, err := c.Request("PUT", routeEndpoint(alias), rule)
+	if err != nil {
+		return nil, err
+	}
+
+	var status RoutingRuleStatus
+	if err := decodeResponse(resp, &status); err != nil {
+		return nil, err
+	}
+
+	return &status, nil
+}
+
+// DeleteRoutingRule removes the rule for alias. Requires the admin token.
+func (c *Client) DeleteRoutingRule(alias string) error {
+	resp, err := c.Request("DELETE", routeEndpoint(alias), nil)
+	if err != nil {
+		return err
+	}
+
+	return decodeResponse(resp, nil)
+}
@@ -0,0 +1,114 @@
+package inferno
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Runtime configuration structures
+type SamplingDefaults struct {
+	MaxTokens   int     `json:"max_tokens"`
+	Temperature float32 `json:"temperature"`
+	TopP        float32 `json:"top_p"`
+	TopK        int     `json:"top_k"`
+}
+
+type RuntimeSettings struct {
+	// MaxConcurrentRequests caps generation requests in flight; 0 for no
+	// limit. Requests beyond it get 429 with code
+	// "concurrency_limit_exceeded".
+	MaxConcurrentRequests int `json:"max_concurrent_requests"`
+	// MaxSessions caps the sessions held in memory; 0 for no limit
+	MaxSessions int `json:"max_sessions"`
+	// SessionIdleSeconds is how long an unused session is kept
+	SessionIdleSeconds int `json:"session_idle_seconds"`
+	// Sampling holds the values used when a request omits them
+	Sampling SamplingDefaults `json:"sampling"`
+}
+
+// RuntimeSettingsUpdate changes the settings that are set; nil fields keep
+// their current value
+type RuntimeSettingsUpdate struct {
+	MaxConcurrentRequests *int                   `json:"max_concurrent_requests,omitempty"`
+	MaxSessions           *int                   `json:"max_sessions,omitempty"`
+	SessionIdleSeconds    *int                   `json:"session_idle_seconds,omitempty"`
+	Sampling              SamplingDefaultsUpdate `json:"sampling"`
+	// Reason is kept with the change in the audit history
+	Reason string `json:"reason,omitempty"`
+}
+
+type SamplingDefaultsUpdate struct {
+	MaxTokens   *int     `json:"max_tokens,omitempty"`
+	Temperature *float32 `json:"temperature,omitempty"`
+	TopP        *float32 `json:"top_p,omitempty"`
+	TopK        *int     `json:"top_k,omitempty"`
+}
+
+// FieldChange is the old and new value of one setting
+type FieldChange struct {
+	From json.RawMessage `json:"from"`
+	To   json.RawMessage `json:"to"`
+}
+
+// ConfigChange is one entry of the audit history
+type ConfigChange struct {
+	ChangedAt time.Time `json:"changed_at"`
+	// Changes is keyed by dotted setting path, such as "sampling.top_p"
+	Changes   map[string]FieldChange `json:"changes"`
+	Reason    *string                `json:"reason"`
+	RequestID *string                `json:"request_id"`
+}
+
+type RuntimeConfigResponse struct {
+	Settings  RuntimeSettings `json:"settings"`
+	UpdatedAt *time.Time      `json:"updated_at"`
+	// Change is set on updates that changed something
+	Change *ConfigChange `json:"change,omitempty"`
+}
+
+type ConfigHistoryResponse struct {
+	Object string         `json:"object"`
+	Data   []ConfigChange `json:"data"`
+}
+
+// RuntimeConfig returns the settings that can change without a restart.
+// Requires the admin token.
+func (c *Client) RuntimeConfig() (*RuntimeConfigResponse, error) {
+	return c.runtimeConfigRequest("GET", nil)
+}
+
+// UpdateRuntimeConfig applies update, which the server validates as a
+// whole before changing anything. Requires the admin token.
+func (c *Client) UpdateRuntimeConfig(update RuntimeSettingsUpdate) (*RuntimeConfigResponse, error) {
+	return c.runtimeConfigRequest("PATCH", update)
+}
+
+// RuntimeConfigHistory lists recent changes to the runtime settings,
+// newest first. Requires the admin token.
+func (c *Client) RuntimeConfigHistory() ([]ConfigChange, error) {
+	resp, err := c.Request("GET", "/admin/config/history", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var result ConfigHistoryResponse
+	if err := decodeResponse(resp, &result); err != nil {
+		return nil, err
+	}
+
+	return result.Data, nil
+}
+
+func (c *Client) runtimeConfigRequest(method string, body interface{}) (*RuntimeConfigResponse, error) {
+	resp, err := c.Request(method, "/admin/config", body)
+	if err != nil {
+		return nil, err
+	}
+
+	var result RuntimeConfigResponse
+	if err := decodeResponse(resp, &result); err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
@@ -0,0 +1,110 @@
+package inferno
+
+import "context"
+
+// Scheduler header names, for requests without a PriorityClass field
+const (
+	PriorityClassHeader = "X-Inferno-Priority-Class"
+	TenantHeader        = "X-Inferno-Tenant"
+)
+
+// Built-in priority classes of the default policy
+const (
+	ClassInteractive = "interactive"
+	ClassBatch       = "batch"
+	ClassBackground  = "background"
+)
+
+// Scheduler structures
+type PriorityClass struct {
+	Name string `json:"name"`
+	// Priority orders classes; higher is admitted first
+	Priority int `json:"priority"`
+	// Preemptible requests may be cancelled for waiting non-preemptible ones
+	Preemptible bool `json:"preemptible"`
+}
+
+type PreemptionPolicy struct {
+	Enabled bool `json:"enabled"`
+	// AfterMs is how long a non-preemptible request waits with every slot
+	// taken before a preemptible one is cancelled
+	AfterMs int64 `json:"after_ms"`
+}
+
+type SchedulerPolicy struct {
+	// MaxConcurrent bounds requests generating at once; 0 admits every
+	// request immediately, so classes and weights only matter above 0
+	MaxConcurrent int             `json:"max_concurrent"`
+	DefaultClass  string          `json:"default_class"`
+	Classes       []PriorityClass `json:"classes"`
+	// TenantWeights sets fair-share weights; unlisted tenants weigh 1
+	TenantWeights map[string]float64 `json:"tenant_weights,omitempty"`
+	Preemption    PreemptionPolicy   `json:"preemption"`
+}
+
+type TenantStats struct {
+	Tenant   string  `json:"tenant"`
+	Weight   float64 `json:"weight"`
+	Queued   int     `json:"queued"`
+	Running  int     `json:"running"`
+	Admitted int64   `json:"admitted"`
+	// Share is the fraction of all admissions that went to the tenant
+	Share float64 `json:"share"`
+}
+
+type SchedulerStatus struct {
+	Policy  SchedulerPolicy `json:"policy"`
+	Tenants []TenantStats   `json:"tenants"`
+}
+
+type ClassStats struct {
+	Class        string `json:"class"`
+	Priority     int    `json:"priority"`
+	Preemptible  bool   `json:"preemptible"`
+	Queued       int    `json:"queued"`
+	Running      int    `json:"running"`
+	Admitted     int64  `json:"admitted"`
+	Preempted    int64  `json:"preempted"`
+	AvgWaitMs    int64  `json:"avg_wait_ms"`
+	OldestWaitMs int64  `json:"oldest_wait_ms"`
+}
+
+type ClassStatsResponse struct {
+	MaxConcurrent int          `json:"max_concurrent"`
+	Data          []ClassStats `json:"data"`
+}
+
+// QueueClasses returns queue depth, average wait and preemptions per
+// priority class
+func (c *Client) QueueClasses(ctx context.Context) (*ClassStatsResponse, error) {
+	resp, err := c.RequestContext(ctx, "GET", "/v1/queue/classes", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var result ClassStatsResponse
+	if err := decodeResponse(resp, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// SchedulerPolicy returns the scheduler policy with per-tenant fair-share
+// accounting
+func (a *AdminClient) SchedulerPolicy(ctx context.Context) (*SchedulerStatus, error) {
+	var status SchedulerStatus
+	if err := a.adminRequest(ctx, "GET", "/admin/scheduler", nil, &status); err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
+
+// SetSchedulerPolicy replaces the scheduler policy. Requests already waiting
+// keep their class; a larger MaxConcurrent admits them right away.
+func (a *AdminClient) SetSchedulerPolicy(ctx context.Context, policy SchedulerPolicy) (*SchedulerStatus, error) {
+	var status SchedulerStatus
+	if err := a.adminRequest(ctx, "PUT", "/admin/scheduler", policy, &status); err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
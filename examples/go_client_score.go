@@ -0,0 +1,102 @@
+package inferno
+
+import (
+	"context"
+	"encoding/json"
+)
+
+// MaxScorePairs is the most pairs the server scores per request; Score
+// splits longer lists into requests of this size
+const MaxScorePairs = 256
+
+// ScorePair is a query and a candidate to judge against it
+type ScorePair struct {
+	Query     string
+	Candidate string
+}
+
+// MarshalJSON encodes the pair as the server's [query, candidate] array
+func (p ScorePair) MarshalJSON() ([]byte, error) {
+	return json.Marshal([2]string{p.Query, p.Candidate})
+}
+
+// Pair scoring structures
+type ScoreRequest struct {
+	Model string      `json:"model"`
+	Pairs []ScorePair `json:"pairs"`
+	// RawScores returns the classifier's logit instead of its sigmoid
+	RawScores bool `json:"raw_scores,omitempty"`
+}
+
+type PairScore struct {
+	// Index is the pair's position in the request's Pairs
+	Index int     `json:"index"`
+	Score float32 `json:"score"`
+	// Logits holds every classifier output for multi-class models such as
+	// entailment classifiers
+	Logits []float32 `json:"logits,omitempty"`
+}
+
+type ScoreResponse struct {
+	Model string      `json:"model"`
+	Data  []PairScore `json:"data"`
+}
+
+// Score returns a cross-encoder score for each pair, in request order.
+// Lists longer than MaxScorePairs are sent in chunks, one after another;
+// the first failing chunk aborts the call.
+func (c *Client) Score(ctx context.Context, request ScoreRequest) (*ScoreResponse, error) {
+	if err := c.requireCapabilities(ctx, nil, request.Model, CapabilityRerank); err != nil {
+		return nil, err
+	}
+
+	result := &ScoreResponse{Model: request.Model, Data: make([]PairScore, 0, len(request.Pairs))}
+
+	for start := 0; start < len(request.Pairs); start += MaxScorePairs {
+		end := start + MaxScorePairs
+		if end > len(request.Pairs) {
+			end = len(request.Pairs)
+		}
+
+		chunk := request
+		chunk.Pairs = request.Pairs[start:end]
+		resp, err := c.RequestContext(ctx, "POST", "/v1/score", chunk)
+		if err != nil {
+			return nil, err
+		}
+
+		var scored ScoreResponse
+		if err := decodeResponse(resp, &scored); err != nil {
+			return nil, err
+		}
+		result.Model = scored.Model
+		for _, score := range scored.Data {
+			score.Index += start
+			result.Data = append(result.Data, score)
+		}
+	}
+
+	return result, nil
+}
+
+// ScoreCandidates scores every candidate against query with model and
+// returns the scores in candidate order
+func (c *Client) ScoreCandidates(ctx context.Context, model, query string, candidates []string) ([]float32, error) {
+	pairs := make([]ScorePair, len(candidates))
+	for i, candidate := range candidates {
+		pairs[i] = ScorePair{Query: query, Candidate: candidate}
+	}
+
+	result, err := c.Score(ctx, ScoreRequest{Model: model, Pairs: pairs})
+	if err != nil {
+		return nil, err
+	}
+
+	scores := make([]float32, len(candidates))
+	for _, score := range result.Data {
+		if score.Index < len(scores) {
+			scores[score.Index] = score.Score
+		}
+	}
+	return scores, nil
+}
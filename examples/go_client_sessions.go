@@ -0,0 +1,220 @@
+package inferno
+
+import (
+	"context"
+	"net/url"
+	"time"
+)
+
+// Session compaction strategies
+const (
+	CompactionSummarize = "summarize"
+	CompactionTruncate  = "truncate"
+	CompactionNone      = "none"
+)
+
+// Session structures
+type CompactionConfig struct {
+	// Strategy is one of the Compaction* constants (server default
+	// CompactionSummarize)
+	Strategy string `json:"strategy,omitempty"`
+	// Threshold is the fraction of the context window a turn may fill before
+	// older turns are compacted (server default 0.75)
+	Threshold float32 `json:"threshold,omitempty"`
+	// KeepRecent is how many of the latest messages stay verbatim (server
+	// default 6)
+	KeepRecent int `json:"keep_recent,omitempty"`
+	// MemoryWords bounds the summarized memory (server default 200)
+	MemoryWords int `json:"memory_words,omitempty"`
+}
+
+// CompactionEvent records older turns being folded into memory or dropped
+type CompactionEvent struct {
+	Strategy          string    `json:"strategy"`
+	CompactedMessages int       `json:"compacted_messages"`
+	TokensBefore      int       `json:"tokens_before"`
+	TokensAfter       int       `json:"tokens_after"`
+	Manual            bool      `json:"manual"`
+	CreatedAt         time.Time `json:"created_at"`
+}
+
+type CreateSessionRequest struct {
+	Model      string            `json:"model"`
+	System     string            `json:"system,omitempty"`
+	Compaction *CompactionConfig `json:"compaction,omitempty"`
+}
+
+type Session struct {
+	ID         string           `json:"id"`
+	Model      string           `json:"model"`
+	System     *string          `json:"system"`
+	Compaction CompactionConfig `json:"compaction"`
+	// Memory is the rolling summary of compacted turns
+	Memory            *string           `json:"memory"`
+	Messages          []ChatMessage     `json:"messages"`
+	CompactedMessages int               `json:"compacted_messages"`
+	Compactions       []CompactionEvent `json:"compactions"`
+	CreatedAt         time.Time         `json:"created_at"`
+	UpdatedAt         time.Time         `json:"updated_at"`
+}
+
+type SessionMessageRequest struct {
+	Content     string   `json:"content"`
+	MaxTokens   int      `json:"max_tokens,omitempty"`
+	Temperature *float32 `json:"temperature,omitempty"`
+	TopP        *float32 `json:"top_p,omitempty"`
+	Seed        *uint64  `json:"seed,omitempty"`
+	TimeoutMs   int      `json:"timeout_ms,omitempty"`
+	// Metadata tags the request's usage; see InferenceRequest
+	Metadata map[string]string `json:"metadata,omitempty"`
+}
+
+type SessionMessageResponse struct {
+	SessionID    string       `json:"session_id"`
+	Message      ChatMessage  `json:"message"`
+	FinishReason FinishReason `json:"finish_reason"`
+	// Compaction is set when older turns were compacted before this reply
+	Compaction    *CompactionEvent `json:"compaction"`
+	ContextTokens int              `json:"context_tokens"`
+	ContextWindow int              `json:"context_window"`
+}
+
+type SessionMemory struct {
+	SessionID         string            `json:"session_id"`
+	Memory            *string           `json:"memory"`
+	CompactedMessages int               `json:"compacted_messages"`
+	Compactions       []CompactionEvent `json:"compactions"`
+}
+
+func sessionEndpoint(id string) string {
+	return "/v1/sessions/" + url.PathEscape(id)
+}
+
+// CreateSession opens a conversation kept on the server
+func (c *Client) CreateSession(ctx context.Context, request CreateSessionRequest) (*Session, error) {
+	if err := c.requireFeature(ctx, "sessions"); err != nil {
+		return nil, err
+	}
+
+	var session Session
+	if err := c.sessionRequest(ctx, "POST", "/v1/sessions", request, &session); err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+// GetSession returns a session with its memory and uncompacted turns
+func (c *Client) GetSession(ctx context.Context, id string) (*Session, error) {
+	var session Session
+	if err := c.sessionRequest(ctx, "GET", sessionEndpoint(id), nil, &session); err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+// DeleteSession closes a session
+func (c *Client) DeleteSession(ctx context.Context, id string) error {
+	return c.sessionRequest(ctx, "DELETE", sessionEndpoint(id), nil, nil)
+}
+
+// SendSessionMessage adds a user turn to a session and returns the reply,
+// along with any compaction the server ran to make room for it
+func (c *Client) SendSessionMessage(ctx context.Context, id string, request SessionMessageRequest) (*SessionMessageResponse, error) {
+	var result SessionMessageResponse
+	if err := c.sessionRequest(ctx, "POST", sessionEndpoint(id)+"/messages", request, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// CompactSession compacts a session's older turns now. An empty strategy
+// uses the session's own.
+func (c *Client) CompactSession(ctx context.Context, id, strategy string) (*SessionMemory, error) {
+	body := map[string]string{}
+	if strategy != "" {
+		body["strategy"] = strategy
+	}
+
+	var memory SessionMemory
+	if err := c.sessionRequest(ctx, "POST", sessionEndpoint(id)+"/compact", body, &memory); err != nil {
+		return nil, err
+	}
+	return &memory, nil
+}
+
+// SessionMemory returns a session's rolling memory and compaction history
+func (c *Client) SessionMemory(ctx context.Context, id string) (*SessionMemory, error) {
+	var memory SessionMemory
+	if err := c.sessionRequest(ctx, "GET", sessionEndpoint(id)+"/memory", nil, &memory); err != nil {
+		return nil, err
+	}
+	return &memory, nil
+}
+
+// SetSessionMemory replaces a session's memory block; nil clears it
+func (c *Client) SetSessionMemory(ctx context.Context, id string, memory *string) (*SessionMemory, error) {
+	var result SessionMemory
+	body := map[string]*string{"memory": memory}
+	if err := c.sessionRequest(ctx, "PUT", sessionEndpoint(id)+"/memory", body, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+func (c *Client) sessionRequest(ctx context.Context, method, endpoint string, body, out interface{}) error {
+	resp, err := c.RequestContext(ctx, method, endpoint, body)
+	if err != nil {
+		return err
+	}
+	return decodeResponse(resp, out)
+}
+
+// ChatSession is a conversation handle that reports compactions as they
+// happen
+type ChatSession struct {
+	client *Client
+	ID     string
+	// OnCompaction, when set, is called with every compaction the server
+	// runs while answering Send
+	OnCompaction func(CompactionEvent)
+}
+
+// NewChatSession opens a session on model with an optional system prompt
+// and compaction settings (nil for the server defaults)
+func (c *Client) NewChatSession(ctx context.Context, model, system string, compaction *CompactionConfig) (*ChatSession, error) {
+	session, err := c.CreateSession(ctx, CreateSessionRequest{Model: model, System: system, Compaction: compaction})
+	if err != nil {
+		return nil, err
+	}
+	return &ChatSession{client: c, ID: session.ID}, nil
+}
+
+// Send adds a user turn and returns the reply text
+func (s *ChatSession) Send(ctx context.Context, content string) (string, error) {
+	result, err := s.client.SendSessionMessage(ctx, s.ID, SessionMessageRequest{Content: content})
+	if err != nil {
+		return "", err
+	}
+	if result.Compaction != nil && s.OnCompaction != nil {
+		s.OnCompaction(*result.Compaction)
+	}
+	return result.Message.Content, nil
+}
+
+// Memory returns the session's current rolling memory, empty before the
+// first compaction
+func (s *ChatSession) Memory(ctx context.Context) (string, error) {
+	memory, err := s.client.SessionMemory(ctx, s.ID)
+	if err != nil {
+		return "", err
+	}
+	if memory.Memory == nil {
+		return "", nil
+	}
+	return *memory.Memory, nil
+}
+
+// Close deletes the session on the server
+func (s *ChatSession) Close(ctx context.Context) error {
+	return s.client.DeleteSession(ctx, s.ID)
+}
@@ -0,0 +1,117 @@
+package inferno
+
+import (
+	"fmt"
+	"net/url"
+	"time"
+)
+
+// Shadow traffic structures
+type ShadowConfig struct {
+	TargetModel   string  `json:"target_model"`
+	SamplePercent float64 `json:"sample_percent"`
+	MaxConcurrent int     `json:"max_concurrent,omitempty"`
+}
+
+type ShadowStats struct {
+	Mirrored int64 `json:"mirrored"`
+	Dropped  int64 `json:"dropped"`
+	Failed   int64 `json:"failed"`
+}
+
+type ShadowStatus struct {
+	Model  string       `json:"model"`
+	Config ShadowConfig `json:"config"`
+	Stats  ShadowStats  `json:"stats"`
+}
+
+type ShadowResult struct {
+	ID               string    `json:"id"`
+	RequestID        string    `json:"request_id"`
+	SourceModel      string    `json:"source_model"`
+	TargetModel      string    `json:"target_model"`
+	Prompt           string    `json:"prompt"`
+	PrimaryOutput    *string   `json:"primary_output,omitempty"`
+	PrimaryLatencyMs int64     `json:"primary_latency_ms"`
+	ShadowOutput     *string   `json:"shadow_output,omitempty"`
+	ShadowLatencyMs  int64     `json:"shadow_latency_ms"`
+	ShadowError      *string   `json:"shadow_error,omitempty"`
+	Similarity       *float64  `json:"similarity,omitempty"`
+	CreatedAt        time.Time `json:"created_at"`
+}
+
+type ShadowConfigsResponse struct {
+	Object string         `json:"object"`
+	Data   []ShadowStatus `json:"data"`
+}
+
+type ShadowResultsResponse struct {
+	Object string         `json:"object"`
+	Data   []ShadowResult `json:"data"`
+}
+
+func shadowEndpoint(model string) string {
+	return "/v1/shadow/" + url.PathEscape(model)
+}
+
+// ShadowConfigs lists mirroring configurations and their counters
+func (c *Client) ShadowConfigs() ([]ShadowStatus, error) {
+	resp, err := c.Request("GET", "/v1/shadow", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var result ShadowConfigsResponse
+	if err := decodeResponse(resp, &result); err != nil {
+		return nil, err
+	}
+
+	return result.Data, nil
+}
+
+// SetShadowConfig mirrors a sample of model's requests to
+// config.TargetModel. Requires the admin token.
+func (c *Client) SetShadowConfig(model string, config ShadowConfig) (*ShadowStatus, error) {
+	resp, err := c.Request("PUT", shadowEndpoint(model), config)
+	if err != nil {
+		return nil, err
+	}
+
+	var status ShadowStatus
+	if err := decodeResponse(resp, &status); err != nil {
+		return nil, err
+	}
+
+	return &status, nil
+}
+
+// DeleteShadowConfig stops mirroring model's requests. Requires the admin token.
+func (c *Client) DeleteShadowConfig(model string) error {
+	resp, err := c.Request("DELETE", shadowEndpoint(model), nil)
+	if err != nil {
+		return err
+	}
+
+	return decodeResponse(resp, nil)
+}
+
+// ShadowResults returns up to limit recorded comparisons for model, newest
+// first (server default when limit is 0). Requires the admin token.
+func (c *Client) ShadowResults(model string, limit int) ([]ShadowResult, error) {
+	endpoint := shadowEndpoint(model) + "/results"
+	if limit > 0 {
+		endpoint = fmt.Sprintf("%s?limit=%d", endpoint, limit)
+	}
+
+	resp, err := c.Request("GET", endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var result ShadowResultsResponse
+	if err := decodeResponse(resp, &result); err != nil {
+		return nil, err
+	}
+
+	return result.Data, nil
+}
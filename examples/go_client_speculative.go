@@ -0,0 +1,74 @@
+package inferno
+
+import "net/url"
+
+// Speculative decoding structures
+type SpeculativeConfig struct {
+	// Enabled defaults to true on the server when left nil
+	Enabled             *bool   `json:"enabled,omitempty"`
+	DraftModel          string  `json:"draft_model"`
+	LookaheadTokens     int     `json:"lookahead_tokens,omitempty"`
+	AcceptanceThreshold float64 `json:"acceptance_threshold,omitempty"`
+	AdaptiveLookahead   bool    `json:"adaptive_lookahead"`
+}
+
+type SpeculativeStats struct {
+	VerificationSteps   int64   `json:"verification_steps"`
+	ProposedTokens      int64   `json:"proposed_tokens"`
+	AcceptedTokens      int64   `json:"accepted_tokens"`
+	AcceptanceRate      float64 `json:"acceptance_rate"`
+	MeanAcceptedPerStep float64 `json:"mean_accepted_per_step"`
+}
+
+type SpeculativeStatus struct {
+	Model  string             `json:"model"`
+	Config *SpeculativeConfig `json:"config"`
+	Stats  SpeculativeStats   `json:"stats"`
+}
+
+func speculativeEndpoint(model string) string {
+	return "/v1/models/" + url.PathEscape(model) + "/speculative"
+}
+
+// SpeculativeConfig returns the draft-model configuration for a target model
+// (nil Config when none is set) along with its acceptance-rate statistics
+func (c *Client) SpeculativeConfig(model string) (*SpeculativeStatus, error) {
+	resp, err := c.Request("GET", speculativeEndpoint(model), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var status SpeculativeStatus
+	if err := decodeResponse(resp, &status); err != nil {
+		return nil, err
+	}
+
+	return &status, nil
+}
+
+// SetSpeculativeConfig attaches a draft model to a target model. Zero-valued
+// lookahead and threshold use the server defaults. Requires the admin token.
+func (c *Client) SetSpeculativeConfig(model string, config SpeculativeConfig) (*SpeculativeStatus, error) {
+	resp, err := c.Request("PUT", speculativeEndpoint(model), config)
+	if err != nil {
+		return nil, err
+	}
+
+	var status SpeculativeStatus
+	if err := decodeResponse(resp, &status); err != nil {
+		return nil, err
+	}
+
+	return &status, nil
+}
+
+// DisableSpeculative removes the draft-model configuration for a target
+// model. Requires the admin token.
+func (c *Client) DisableSpeculative(model string) error {
+	resp, err := c.Request("DELETE", speculativeEndpoint(model), nil)
+	if err != nil {
+		return err
+	}
+
+	return decodeResponse(resp, nil)
+}
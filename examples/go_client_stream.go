@@ -0,0 +1,231 @@
+package inferno
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// Chat streaming structures
+type ChatCompletionChunk struct {
+	ID      string            `json:"id"`
+	Object  string            `json:"object"`
+	Created int64             `json:"created"`
+	Model   string            `json:"model"`
+	Choices []ChatChunkChoice `json:"choices"`
+	// Usage is set on the final chunk when StreamOptions.IncludeUsage is
+	Usage *Usage `json:"usage,omitempty"`
+}
+
+type ChatChunkChoice struct {
+	Index        int           `json:"index"`
+	Delta        ChatDelta     `json:"delta"`
+	FinishReason *FinishReason `json:"finish_reason"`
+}
+
+type ChatDelta struct {
+	Role      Role            `json:"role,omitempty"`
+	Content   string          `json:"content,omitempty"`
+	ToolCalls []ToolCallDelta `json:"tool_calls,omitempty"`
+}
+
+// ToolCallDelta is a fragment of a streamed tool call. The first fragment
+// of a call carries its ID and name; later ones append to its arguments.
+type ToolCallDelta struct {
+	// Index orders the calls of a streamed reply
+	Index    int          `json:"index"`
+	ID       string       `json:"id,omitempty"`
+	Type     string       `json:"type,omitempty"`
+	Function FunctionCall `json:"function"`
+}
+
+// MergeToolCalls folds streamed tool call deltas into calls by index,
+// appending argument fragments for servers that split them
+func MergeToolCalls(calls []ToolCall, deltas []ToolCallDelta) []ToolCall {
+	for _, delta := range deltas {
+		for len(calls) <= delta.Index {
+			calls = append(calls, ToolCall{Type: "function"})
+		}
+		call := &calls[delta.Index]
+		if delta.ID != "" {
+			call.ID = delta.ID
+		}
+		if delta.Type != "" {
+			call.Type = delta.Type
+		}
+		if delta.Function.Name != "" {
+			call.Function.Name = delta.Function.Name
+		}
+		call.Function.Arguments += delta.Function.Arguments
+	}
+	return calls
+}
+
+// ChatStream is a streamed chat completion, read a token at a time with
+// Recv or copied into a writer with WriteTo
+type ChatStream struct {
+	body   io.ReadCloser
+	events *sseReader
+	client *Client
+	// requestID is the X-Request-ID the stream is cancelled by
+	requestID string
+	// output is the raw text so far, for the Moderator
+	output strings.Builder
+
+	// FlushEachToken makes WriteTo flush the writer after every token when
+	// it can be flushed (http.ResponseWriter, bufio.Writer and the like), so
+	// readers on the other end see tokens as they arrive. On by default.
+	FlushEachToken bool
+	// FinishReason is set once the final chunk has been read
+	FinishReason FinishReason
+	// ToolCalls collects the calls the model makes; they are complete once
+	// Recv has returned io.EOF
+	ToolCalls []ToolCall
+	// Usage is set at the end when the request asked for it
+	Usage *Usage
+	// Moderator, when set, sees each token before Recv returns it and may
+	// redact it or abort the stream; it starts as Client.Moderator
+	Moderator Moderator
+}
+
+// ChatStream starts a streamed chat completion. The caller must Close the
+// stream unless it reads it to the end with WriteTo.
+func (c *Client) ChatStream(ctx context.Context, request ChatCompletionRequest) (*ChatStream, error) {
+	request.Stream = true
+	if err := c.requireChatCapabilities(ctx, request); err != nil {
+		return nil, err
+	}
+	req, err := c.newRequest(ctx, "POST", "/v1/chat/completions", request)
+	if err != nil {
+		return nil, err
+	}
+	requestID := NewRequestID()
+	req.Header.Set(RequestIDHeader, requestID)
+
+	// Streams outlast HTTPClient.Timeout; ctx bounds them instead
+	httpClient := *c.HTTPClient
+	httpClient.Timeout = 0
+	resp, err := c.send(&httpClient, req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 400 {
+		return nil, decodeResponse(resp, nil)
+	}
+
+	return &ChatStream{
+		body:           resp.Body,
+		events:         newSSEReader(resp.Body),
+		client:         c,
+		requestID:      requestID,
+		FlushEachToken: true,
+		Moderator:      c.Moderator,
+	}, nil
+}
+
+// Recv returns the next piece of generated text, or io.EOF once the model
+// has finished
+func (s *ChatStream) Recv() (string, error) {
+	for {
+		data, err := s.events.Next()
+		if err != nil {
+			return "", err
+		}
+		if bytes.Equal(data, []byte("[DONE]")) {
+			return "", io.EOF
+		}
+
+		var chunk ChatCompletionChunk
+		if err := json.Unmarshal(data, &chunk); err != nil {
+			return "", fmt.Errorf("decoding stream chunk: %w", err)
+		}
+		if chunk.Usage != nil {
+			s.Usage = chunk.Usage
+		}
+		if len(chunk.Choices) == 0 {
+			continue
+		}
+		if reason := chunk.Choices[0].FinishReason; reason != nil {
+			s.FinishReason = *reason
+		}
+		s.ToolCalls = MergeToolCalls(s.ToolCalls, chunk.Choices[0].Delta.ToolCalls)
+		if content := chunk.Choices[0].Delta.Content; content != "" {
+			if s.Moderator != nil {
+				if content, err = s.moderate(content); err != nil {
+					return "", err
+				}
+				if content == "" {
+					continue
+				}
+			}
+			if s.client != nil {
+				s.client.emit(ClientEvent{Type: EventStreamToken, Token: content})
+			}
+			return content, nil
+		}
+	}
+}
+
+// Close releases the underlying connection, stopping generation on the
+// server if the stream has not finished
+func (s *ChatStream) Close() error {
+	return s.body.Close()
+}
+
+// WriteTo copies the generated text into w as it streams, then closes the
+// stream. It implements io.WriterTo, so io.Copy(w, stream) works too.
+func (s *ChatStream) WriteTo(w io.Writer) (int64, error) {
+	defer s.Close()
+
+	var written int64
+	for {
+		token, err := s.Recv()
+		if err == io.EOF {
+			return written, nil
+		}
+		if err != nil {
+			return written, err
+		}
+
+		n, err := io.WriteString(w, token)
+		written += int64(n)
+		if err != nil {
+			return written, err
+		}
+		if s.FlushEachToken {
+			if err := flushWriter(w); err != nil {
+				return written, err
+			}
+		}
+	}
+}
+
+// StreamTo streams a chat completion straight into w, flushing after each
+// token when w supports it, and returns the number of bytes written. A
+// streaming proxy handler is then just:
+//
+//	func(w http.ResponseWriter, r *http.Request) {
+//		client.StreamTo(r.Context(), request, w)
+//	}
+func (c *Client) StreamTo(ctx context.Context, request ChatCompletionRequest, w io.Writer) (int64, error) {
+	stream, err := c.ChatStream(ctx, request)
+	if err != nil {
+		return 0, err
+	}
+	return stream.WriteTo(w)
+}
+
+// flushWriter flushes w if it buffers output
+func flushWriter(w io.Writer) error {
+	switch f := w.(type) {
+	case http.Flusher:
+		f.Flush()
+	case interface{ Flush() error }:
+		return f.Flush()
+	}
+	return nil
+}
@@ -0,0 +1,62 @@
+package inferno
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestChatStreamAssemblesToolCalls(t *testing.T) {
+	chunks := []string{
+		`{"choices":[{"index":0,"delta":{"role":"assistant","content":"Checking"}}]}`,
+		`{"choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"id":"call_1","type":"function","function":{"name":"weather","arguments":"{\"city\":"}}]}}]}`,
+		`{"choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"function":{"arguments":"\"Oslo\"}"}},{"index":1,"id":"call_2","function":{"name":"time","arguments":"{}"}}]}}]}`,
+		`{"choices":[{"index":0,"delta":{},"finish_reason":"tool_calls"}]}`,
+		`[DONE]`,
+	}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/chat/completions" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		for _, chunk := range chunks {
+			fmt.Fprintf(w, "data: %s\n\n", chunk)
+		}
+	}))
+	defer ts.Close()
+
+	stream, err := NewClient(ts.URL, "").ChatStream(context.Background(), ChatCompletionRequest{Model: "llama"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stream.Close()
+
+	var content string
+	for {
+		token, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		content += token
+	}
+
+	if content != "Checking" {
+		t.Errorf("content %q, want %q", content, "Checking")
+	}
+	if len(stream.ToolCalls) != 2 {
+		t.Fatalf("got %d tool calls, want 2", len(stream.ToolCalls))
+	}
+	if call := stream.ToolCalls[0]; call.ID != "call_1" || call.Function.Name != "weather" || call.Function.Arguments != `{"city":"Oslo"}` {
+		t.Errorf("first call %+v", call)
+	}
+	if call := stream.ToolCalls[1]; call.ID != "call_2" || call.Type != "function" || call.Function.Arguments != "{}" {
+		t.Errorf("second call %+v", call)
+	}
+}
@@ -0,0 +1,202 @@
+package inferno
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// strictResponsesKey marks requests from a client with StrictResponses set,
+// so decodeResponse can tell from the response alone
+type strictResponsesKey struct{}
+
+// SchemaDriftError reports a response whose JSON does not match the Go type
+// it is decoded into. It is returned instead of decoding when the client
+// has StrictResponses set.
+type SchemaDriftError struct {
+	// Endpoint is the request path that produced the response
+	Endpoint string
+	// Type is the Go type the response was decoded into
+	Type string
+	// UnknownFields are JSON paths the type has no field for, such as
+	// "choices[0].reasoning"
+	UnknownFields []string
+	// MissingFields are JSON paths of fields the type declares without
+	// omitempty that the response left out
+	MissingFields []string
+}
+
+func (e *SchemaDriftError) Error() string {
+	var parts []string
+	if len(e.UnknownFields) > 0 {
+		parts = append(parts, "unknown fields "+strings.Join(e.UnknownFields, ", "))
+	}
+	if len(e.MissingFields) > 0 {
+		parts = append(parts, "missing fields "+strings.Join(e.MissingFields, ", "))
+	}
+	return fmt.Sprintf("inferno: response from %s does not match %s: %s", e.Endpoint, e.Type, strings.Join(parts, "; "))
+}
+
+// isStrict reports whether resp answers a request from a client with
+// StrictResponses set
+func isStrict(resp *http.Response) bool {
+	if resp.Request == nil {
+		return false
+	}
+	strict, _ := resp.Request.Context().Value(strictResponsesKey{}).(bool)
+	return strict
+}
+
+// decodeStrict decodes body into out, failing with a *SchemaDriftError when
+// the JSON has fields out does not declare or lacks ones it requires
+func decodeStrict(resp *http.Response, body []byte, out interface{}) error {
+	var document interface{}
+	if err := json.Unmarshal(body, &document); err != nil {
+		return err
+	}
+
+	drift := &SchemaDriftError{Endpoint: resp.Request.URL.Path, Type: reflect.TypeOf(out).String()}
+	checkSchema("", document, reflect.TypeOf(out), drift)
+	if len(drift.UnknownFields) > 0 || len(drift.MissingFields) > 0 {
+		sort.Strings(drift.UnknownFields)
+		sort.Strings(drift.MissingFields)
+		return drift
+	}
+
+	// The walk above is the strict check; it lets through the "object" tag,
+	// which encoding/json's DisallowUnknownFields would refuse
+	return json.Unmarshal(body, out)
+}
+
+var unmarshalerType = reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()
+
+// checkSchema walks a decoded JSON value alongside the Go type it will be
+// decoded into, recording fields that exist on only one side
+func checkSchema(path string, value interface{}, t reflect.Type, drift *SchemaDriftError) {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	// Types that decode themselves, and interface{} fields, take any shape
+	if value == nil || t.Kind() == reflect.Interface || reflect.PtrTo(t).Implements(unmarshalerType) {
+		return
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return
+		}
+		fields := map[string]jsonField{}
+		collectFields(t, fields)
+		for key, child := range object {
+			field, ok := lookupField(fields, key)
+			if !ok {
+				// Most types leave out the OpenAI-style "object" tag, which
+				// only names the type the client already asked for
+				if key == "object" {
+					continue
+				}
+				drift.UnknownFields = append(drift.UnknownFields, joinPath(path, key))
+				continue
+			}
+			checkSchema(joinPath(path, key), child, field.Type, drift)
+		}
+		for key, field := range fields {
+			if !hasKey(object, key) && !field.OmitEmpty {
+				drift.MissingFields = append(drift.MissingFields, joinPath(path, key))
+			}
+		}
+	case reflect.Slice, reflect.Array:
+		items, ok := value.([]interface{})
+		if !ok {
+			return
+		}
+		for i, item := range items {
+			checkSchema(fmt.Sprintf("%s[%d]", path, i), item, t.Elem(), drift)
+		}
+	case reflect.Map:
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return
+		}
+		for key, child := range object {
+			checkSchema(fmt.Sprintf("%s[%q]", path, key), child, t.Elem(), drift)
+		}
+	}
+}
+
+// jsonField is a struct field as encoding/json sees it
+type jsonField struct {
+	Type      reflect.Type
+	OmitEmpty bool
+}
+
+// collectFields maps the JSON names of t's fields, promoting the fields of
+// embedded structs the way encoding/json does
+func collectFields(t reflect.Type, fields map[string]jsonField) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, options, _ := strings.Cut(tag, ",")
+
+		if field.Anonymous && name == "" {
+			embedded := field.Type
+			if embedded.Kind() == reflect.Ptr {
+				embedded = embedded.Elem()
+			}
+			if embedded.Kind() == reflect.Struct {
+				collectFields(embedded, fields)
+				continue
+			}
+		}
+		if !field.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		fields[name] = jsonField{Type: field.Type, OmitEmpty: strings.Contains(options, "omitempty")}
+	}
+}
+
+// lookupField finds the field a JSON key decodes into, preferring an exact
+// match but, like encoding/json, falling back to one differing only in case
+func lookupField(fields map[string]jsonField, key string) (jsonField, bool) {
+	if field, ok := fields[key]; ok {
+		return field, true
+	}
+	for name, field := range fields {
+		if strings.EqualFold(name, key) {
+			return field, true
+		}
+	}
+	return jsonField{}, false
+}
+
+// hasKey reports whether object holds a key that decodes into the field
+// named name
+func hasKey(object map[string]interface{}, name string) bool {
+	if _, ok := object[name]; ok {
+		return true
+	}
+	for key := range object {
+		if strings.EqualFold(key, name) {
+			return true
+		}
+	}
+	return false
+}
+
+func joinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
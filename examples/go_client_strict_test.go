@@ -0,0 +1,70 @@
+package inferno
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+// jsonServer answers every request with body
+func jsonServer(body string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(body))
+	}))
+}
+
+func TestStrictResponsesReportDrift(t *testing.T) {
+	ts := jsonServer(`{"id":"llama","object":"model","created":1,"permission":[{"id":"perm_1","object":"model_permission","allow_view":true,"allow_sampling":true,"reasoning":true}],"context_length":8192}`)
+	defer ts.Close()
+
+	client := NewClient(ts.URL, "")
+	client.StrictResponses = true
+	_, err := client.RetrieveModel("llama")
+
+	var drift *SchemaDriftError
+	if !errors.As(err, &drift) {
+		t.Fatalf("got %v, want a *SchemaDriftError", err)
+	}
+	if drift.Endpoint != "/v1/models/llama" || drift.Type != "*inferno.OpenAIModel" {
+		t.Errorf("endpoint %q, type %q", drift.Endpoint, drift.Type)
+	}
+	if want := []string{"context_length", "permission[0].reasoning"}; !reflect.DeepEqual(drift.UnknownFields, want) {
+		t.Errorf("unknown fields %v, want %v", drift.UnknownFields, want)
+	}
+	if want := []string{"owned_by"}; !reflect.DeepEqual(drift.MissingFields, want) {
+		t.Errorf("missing fields %v, want %v", drift.MissingFields, want)
+	}
+}
+
+func TestStrictResponsesAcceptMatchingBody(t *testing.T) {
+	// Keys differing only in case decode as encoding/json decodes them, and
+	// the "object" tag is allowed on types without an Object field
+	ts := jsonServer(`{"object":"health","Status":"ok","version":"1.0","uptime_seconds":5,"models_loaded":1}`)
+	defer ts.Close()
+
+	client := NewClient(ts.URL, "")
+	client.StrictResponses = true
+	health, err := client.HealthCheck()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if health.Status != "ok" || health.ModelsLoaded != 1 {
+		t.Errorf("got %+v", health)
+	}
+}
+
+func TestLenientResponsesIgnoreDrift(t *testing.T) {
+	ts := jsonServer(`{"id":"llama","context_length":8192}`)
+	defer ts.Close()
+
+	model, err := NewClient(ts.URL, "").RetrieveModel("llama")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if model.ID != "llama" {
+		t.Errorf("got %+v", model)
+	}
+}
@@ -0,0 +1,79 @@
+package inferno
+
+import (
+	"context"
+	"fmt"
+	"io"
+)
+
+// Summary styles
+const (
+	SummaryParagraph = "paragraph"
+	SummaryBullets   = "bullets"
+	SummaryTLDR      = "tldr"
+)
+
+// Summarization structures
+type SummarizeOptions struct {
+	// Style is one of the Summary* constants (server default SummaryParagraph)
+	Style string `json:"style,omitempty"`
+	// MaxWords bounds the final summary (server default 200)
+	MaxWords int `json:"max_words,omitempty"`
+	// Focus narrows the summary, e.g. "risks and deadlines"
+	Focus string `json:"focus,omitempty"`
+	// ChunkTokens overrides the chunk size; the server defaults to half its
+	// context window
+	ChunkTokens int      `json:"chunk_tokens,omitempty"`
+	Temperature *float32 `json:"temperature,omitempty"`
+	TimeoutMs   int      `json:"timeout_ms,omitempty"`
+}
+
+type SummarizeRequest struct {
+	Model string `json:"model"`
+	Text  string `json:"text"`
+	// Metadata tags the request's usage; see InferenceRequest
+	Metadata map[string]string `json:"metadata,omitempty"`
+	SummarizeOptions
+}
+
+type SummarizeResponse struct {
+	Model   string `json:"model"`
+	Summary string `json:"summary"`
+	Style   string `json:"style"`
+	// Chunks is 1 when the document fit the context window
+	Chunks         int `json:"chunks"`
+	ReduceRounds   int `json:"reduce_rounds"`
+	DocumentTokens int `json:"document_tokens"`
+}
+
+// Summarize reads a document from r and summarizes it with model. The
+// server splits documents longer than its context window, summarizes the
+// chunks and merges the results, so r may hold far more than one prompt's
+// worth of text. opts may be nil.
+func (c *Client) Summarize(ctx context.Context, model string, r io.Reader, opts *SummarizeOptions) (*SummarizeResponse, error) {
+	if err := c.requireFeature(ctx, "summarize"); err != nil {
+		return nil, err
+	}
+
+	text, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("read document: %w", err)
+	}
+
+	request := SummarizeRequest{Model: model, Text: string(text)}
+	if opts != nil {
+		request.SummarizeOptions = *opts
+	}
+
+	resp, err := c.RequestContext(ctx, "POST", "/v1/summarize", request)
+	if err != nil {
+		return nil, err
+	}
+
+	var result SummarizeResponse
+	if err := decodeResponse(resp, &result); err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
@@ -0,0 +1,129 @@
+package inferno
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// ThrottleReason is why a GPU is running below its top clock
+type ThrottleReason string
+
+const (
+	ThrottleThermal  ThrottleReason = "thermal"
+	ThrottlePowerCap ThrottleReason = "power_cap"
+	ThrottleHardware ThrottleReason = "hardware"
+)
+
+// GPU telemetry structures
+type GPUSample struct {
+	Timestamp time.Time `json:"timestamp"`
+	// Readings a GPU does not report are nil
+	TemperatureCelsius *float64         `json:"temperature_celsius,omitempty"`
+	PowerDrawWatts     *float64         `json:"power_draw_watts,omitempty"`
+	PowerLimitWatts    *float64         `json:"power_limit_watts,omitempty"`
+	SMClockMHz         *int             `json:"sm_clock_mhz,omitempty"`
+	MaxSMClockMHz      *int             `json:"max_sm_clock_mhz,omitempty"`
+	MemoryClockMHz     *int             `json:"memory_clock_mhz,omitempty"`
+	UtilizationPercent float64          `json:"utilization_percent"`
+	MemoryUsedMB       int64            `json:"memory_used_mb"`
+	Throttling         bool             `json:"throttling"`
+	ThrottleReasons    []ThrottleReason `json:"throttle_reasons,omitempty"`
+}
+
+type ThrottleSummary struct {
+	WindowMinutes         int      `json:"window_minutes"`
+	Samples               int      `json:"samples"`
+	ThrottledSamples      int      `json:"throttled_samples"`
+	ThrottledRatio        float64  `json:"throttled_ratio"`
+	MaxTemperatureCelsius *float64 `json:"max_temperature_celsius,omitempty"`
+}
+
+type GPUTelemetry struct {
+	Index         int    `json:"index"`
+	Name          string `json:"name"`
+	Vendor        string `json:"vendor"`
+	MemoryTotalMB int64  `json:"memory_total_mb"`
+	// ThrottleSource is "driver" when the reasons come from the GPU driver
+	// and "inferred" when they are worked out from temperature and power
+	ThrottleSource string          `json:"throttle_source"`
+	Current        GPUSample       `json:"current"`
+	Recent         ThrottleSummary `json:"recent"`
+}
+
+type GPUTelemetryResponse struct {
+	Object                string         `json:"object"`
+	SampleIntervalSeconds int            `json:"sample_interval_seconds"`
+	Data                  []GPUTelemetry `json:"data"`
+}
+
+type GPUSamplesResponse struct {
+	Object string      `json:"object"`
+	Index  int         `json:"index"`
+	Data   []GPUSample `json:"data"`
+}
+
+// GPUTelemetry returns each GPU's latest temperature, power, clocks and
+// throttling, with how much of the last few minutes it spent throttled
+func (c *Client) GPUTelemetry(ctx context.Context) ([]GPUTelemetry, error) {
+	resp, err := c.RequestContext(ctx, "GET", "/v1/telemetry/gpus", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var result GPUTelemetryResponse
+	if err := decodeResponse(resp, &result); err != nil {
+		return nil, err
+	}
+	return result.Data, nil
+}
+
+// GPUSamples returns one GPU's samples taken after since, oldest first. The
+// server keeps an hour of samples; a zero since returns all of them and a
+// positive limit keeps only the newest limit.
+func (c *Client) GPUSamples(ctx context.Context, index int, since time.Time, limit int) ([]GPUSample, error) {
+	query := url.Values{}
+	if !since.IsZero() {
+		query.Set("since", since.UTC().Format(time.RFC3339Nano))
+	}
+	if limit > 0 {
+		query.Set("limit", strconv.Itoa(limit))
+	}
+	endpoint := fmt.Sprintf("/v1/telemetry/gpus/%d/samples", index)
+	if len(query) > 0 {
+		endpoint += "?" + query.Encode()
+	}
+
+	resp, err := c.RequestContext(ctx, "GET", endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var result GPUSamplesResponse
+	if err := decodeResponse(resp, &result); err != nil {
+		return nil, err
+	}
+	return result.Data, nil
+}
+
+// ThrottledRatio is the share of samples between start and end that were
+// throttling, for lining up a slow benchmark run with the GPU's state; it is
+// 0 when no sample falls in the window
+func ThrottledRatio(samples []GPUSample, start, end time.Time) float64 {
+	var count, throttled int
+	for _, sample := range samples {
+		if sample.Timestamp.Before(start) || sample.Timestamp.After(end) {
+			continue
+		}
+		count++
+		if sample.Throttling {
+			throttled++
+		}
+	}
+	if count == 0 {
+		return 0
+	}
+	return float64(throttled) / float64(count)
+}
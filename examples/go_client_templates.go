@@ -0,0 +1,44 @@
+package inferno
+
+import (
+	"context"
+	"net/url"
+)
+
+// Chat template structures
+type ApplyTemplateRequest struct {
+	Messages []ChatMessage `json:"messages"`
+	// AddGenerationPrompt ends the prompt with the assistant header; the
+	// server defaults it to true
+	AddGenerationPrompt *bool `json:"add_generation_prompt,omitempty"`
+}
+
+type ApplyTemplateResponse struct {
+	Model string `json:"model"`
+	// Prompt is the messages rendered through the model's chat template
+	Prompt string `json:"prompt"`
+	// Tokens counts Prompt; nil when the backend does not expose its
+	// tokenizer
+	Tokens   *int   `json:"tokens"`
+	Template string `json:"template"`
+	// ChatCompletionsPrompt is what /v1/chat/completions generates from for
+	// the same messages
+	ChatCompletionsPrompt string `json:"chat_completions_prompt"`
+}
+
+// ApplyTemplate renders messages through model's embedded chat template, to
+// see the exact prompt text a chat turns into
+func (c *Client) ApplyTemplate(ctx context.Context, model string, request ApplyTemplateRequest) (*ApplyTemplateResponse, error) {
+	endpoint := "/v1/models/" + url.PathEscape(model) + "/apply_template"
+	resp, err := c.RequestContext(ctx, "POST", endpoint, request)
+	if err != nil {
+		return nil, err
+	}
+
+	var result ApplyTemplateResponse
+	if err := decodeResponse(resp, &result); err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
@@ -0,0 +1,212 @@
+package inferno
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Tenant structures
+type TenantLimits struct {
+	// Unset limits are unlimited
+	MaxConcurrent     *int   `json:"max_concurrent,omitempty"`
+	RequestsPerMinute *int   `json:"requests_per_minute,omitempty"`
+	TokensPerMinute   *int64 `json:"tokens_per_minute,omitempty"`
+	// DedicatedModels are refused to every other tenant
+	DedicatedModels []string `json:"dedicated_models,omitempty"`
+}
+
+type TenantUsage struct {
+	InFlight           int   `json:"in_flight"`
+	RequestsLastMinute int   `json:"requests_last_minute"`
+	TokensLastMinute   int64 `json:"tokens_last_minute"`
+	Throttled          int64 `json:"throttled"`
+}
+
+type TenantInfo struct {
+	Object    string       `json:"object"`
+	Tenant    string       `json:"tenant"`
+	Limits    TenantLimits `json:"limits"`
+	Usage     TenantUsage  `json:"usage"`
+	UpdatedAt time.Time    `json:"updated_at"`
+}
+
+type Tenant struct {
+	Object string `json:"object"`
+	ID     string `json:"id"`
+	Name   string `json:"name,omitempty"`
+	// AllowedModels are the models the tenant may use; empty allows all
+	AllowedModels []string     `json:"allowed_models"`
+	Limits        TenantLimits `json:"limits"`
+	Suspended     bool         `json:"suspended"`
+	Usage         TenantUsage  `json:"usage"`
+	CreatedAt     time.Time    `json:"created_at"`
+	UpdatedAt     time.Time    `json:"updated_at"`
+}
+
+type TenantsResponse struct {
+	Object string   `json:"object"`
+	Data   []Tenant `json:"data"`
+}
+
+type CreateTenantRequest struct {
+	// ID is the value clients send as Client.Tenant
+	ID            string       `json:"id"`
+	Name          string       `json:"name,omitempty"`
+	AllowedModels []string     `json:"allowed_models,omitempty"`
+	Limits        TenantLimits `json:"limits"`
+}
+
+// UpdateTenantRequest changes the fields that are set and leaves the rest
+type UpdateTenantRequest struct {
+	Name          *string       `json:"name,omitempty"`
+	AllowedModels *[]string     `json:"allowed_models,omitempty"`
+	Limits        *TenantLimits `json:"limits,omitempty"`
+}
+
+// TenantThrottledError is returned when a request is refused because its
+// tenant is over one of its limits. It wraps the *APIError, so errors.As
+// finds either.
+type TenantThrottledError struct {
+	Tenant string
+	// Code names the limit: tenant_concurrency_exceeded,
+	// tenant_request_rate_exceeded or tenant_token_rate_exceeded
+	Code    string
+	Message string
+	Limit   int64
+	// RetryAfter is how long until the request would fit the limit
+	RetryAfter time.Duration
+	Err        *APIError
+}
+
+func (e *TenantThrottledError) Error() string {
+	return fmt.Sprintf("inferno: tenant %q throttled (%s), retry after %s", e.Tenant, e.Code, e.RetryAfter)
+}
+
+func (e *TenantThrottledError) Unwrap() error {
+	return e.Err
+}
+
+// tenantThrottled returns a *TenantThrottledError when apiErr is a 429 for
+// a tenant limit, and nil otherwise
+func tenantThrottled(resp *http.Response, apiErr *APIError) *TenantThrottledError {
+	if resp.StatusCode != http.StatusTooManyRequests {
+		return nil
+	}
+
+	var body struct {
+		Error struct {
+			Message      string `json:"message"`
+			Code         string `json:"code"`
+			Tenant       string `json:"tenant"`
+			Limit        int64  `json:"limit"`
+			RetryAfterMs int64  `json:"retry_after_ms"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal([]byte(apiErr.Body), &body); err != nil || !strings.HasPrefix(body.Error.Code, "tenant_") {
+		return nil
+	}
+
+	retryAfter := time.Duration(body.Error.RetryAfterMs) * time.Millisecond
+	if retryAfter == 0 {
+		if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil {
+			retryAfter = time.Duration(seconds) * time.Second
+		}
+	}
+	return &TenantThrottledError{
+		Tenant:     body.Error.Tenant,
+		Code:       body.Error.Code,
+		Message:    body.Error.Message,
+		Limit:      body.Error.Limit,
+		RetryAfter: retryAfter,
+		Err:        apiErr,
+	}
+}
+
+// Tenants lists every tenant with its usage over the last minute
+func (a *AdminClient) Tenants(ctx context.Context) ([]Tenant, error) {
+	var result TenantsResponse
+	if err := a.adminRequest(ctx, "GET", "/admin/tenants", nil, &result); err != nil {
+		return nil, err
+	}
+	return result.Data, nil
+}
+
+// Tenant returns one tenant and its usage
+func (a *AdminClient) Tenant(ctx context.Context, id string) (*Tenant, error) {
+	return a.tenantRequest(ctx, "GET", tenantPath(id), nil)
+}
+
+// CreateTenant onboards a tenant. An existing ID fails with a 409 *APIError.
+func (a *AdminClient) CreateTenant(ctx context.Context, req CreateTenantRequest) (*Tenant, error) {
+	return a.tenantRequest(ctx, "POST", "/admin/tenants", req)
+}
+
+// UpdateTenant changes a tenant's name, allowed models or limits
+func (a *AdminClient) UpdateTenant(ctx context.Context, id string, req UpdateTenantRequest) (*Tenant, error) {
+	return a.tenantRequest(ctx, "PATCH", tenantPath(id), req)
+}
+
+// DeleteTenant removes a tenant, lifting its limits and releasing its
+// dedicated models
+func (a *AdminClient) DeleteTenant(ctx context.Context, id string) error {
+	return a.adminRequest(ctx, "DELETE", tenantPath(id), nil, nil)
+}
+
+// SuspendTenant refuses the tenant's new generation requests with a 403
+// until ResumeTenant; requests already running finish
+func (a *AdminClient) SuspendTenant(ctx context.Context, id string) (*Tenant, error) {
+	return a.tenantRequest(ctx, "POST", tenantPath(id)+"/suspend", nil)
+}
+
+// ResumeTenant lifts a suspension
+func (a *AdminClient) ResumeTenant(ctx context.Context, id string) (*Tenant, error) {
+	return a.tenantRequest(ctx, "POST", tenantPath(id)+"/resume", nil)
+}
+
+func (a *AdminClient) tenantRequest(ctx context.Context, method, endpoint string, body interface{}) (*Tenant, error) {
+	var tenant Tenant
+	if err := a.adminRequest(ctx, method, endpoint, body, &tenant); err != nil {
+		return nil, err
+	}
+	return &tenant, nil
+}
+
+// TenantLimits returns a tenant's limits and usage
+func (a *AdminClient) TenantLimits(ctx context.Context, tenant string) (*TenantInfo, error) {
+	var info TenantInfo
+	if err := a.adminRequest(ctx, "GET", tenantLimitsPath(tenant), nil, &info); err != nil {
+		return nil, err
+	}
+	return &info, nil
+}
+
+// SetTenantLimits replaces a tenant's limits, creating the tenant if it
+// does not exist. Dedicating a model another tenant already holds fails
+// with a 409 *APIError.
+func (a *AdminClient) SetTenantLimits(ctx context.Context, tenant string, limits TenantLimits) (*TenantInfo, error) {
+	var info TenantInfo
+	if err := a.adminRequest(ctx, "PUT", tenantLimitsPath(tenant), limits, &info); err != nil {
+		return nil, err
+	}
+	return &info, nil
+}
+
+// DeleteTenantLimits lifts a tenant's limits and releases its dedicated
+// models, keeping the tenant
+func (a *AdminClient) DeleteTenantLimits(ctx context.Context, tenant string) error {
+	return a.adminRequest(ctx, "DELETE", tenantLimitsPath(tenant), nil, nil)
+}
+
+func tenantPath(tenant string) string {
+	return "/admin/tenants/" + url.PathEscape(tenant)
+}
+
+func tenantLimitsPath(tenant string) string {
+	return tenantPath(tenant) + "/limits"
+}
@@ -0,0 +1,161 @@
+package inferno
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// pathServer answers every request with an empty JSON object, keeping the
+// method and path of each
+type pathServer struct {
+	mu       sync.Mutex
+	requests []string
+}
+
+func (s *pathServer) last() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.requests) == 0 {
+		return ""
+	}
+	return s.requests[len(s.requests)-1]
+}
+
+func (s *pathServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	s.requests = append(s.requests, r.Method+" "+r.URL.Path)
+	s.mu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte("{}"))
+}
+
+func TestClientRequestPaths(t *testing.T) {
+	server := &pathServer{}
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+	client := NewClient(ts.URL, "")
+	ctx := context.Background()
+
+	calls := []struct {
+		want string
+		call func() error
+	}{
+		{"GET /health", func() error { _, err := client.HealthCheck(); return err }},
+		{"GET /health/live", func() error { _, err := client.HealthLive(ctx); return err }},
+		{"GET /v1/models", func() error { _, err := client.OpenAIModels(); return err }},
+		{"GET /v1/models/llama", func() error { _, err := client.RetrieveModel("llama"); return err }},
+		{"POST /v1/models/llama/load", func() error { _, err := client.LoadModel("llama", nil); return err }},
+		{"POST /v1/models/llama/unload", func() error { return client.UnloadModel("llama") }},
+		{"POST /v1/completions", func() error {
+			_, err := client.InferenceContext(ctx, InferenceRequest{Model: "llama", Prompt: "hi"})
+			return err
+		}},
+		{"POST /v1/chat/completions", func() error {
+			_, err := client.ChatCompletionContext(ctx, ChatCompletionRequest{Model: "llama"})
+			return err
+		}},
+		{"POST /v1/embeddings", func() error {
+			_, err := client.EmbeddingsContext(ctx, EmbeddingsRequest{Model: "bge", Input: []string{"a"}})
+			return err
+		}},
+		{"POST /v1/tokenize", func() error { _, err := client.Tokenize(ctx, "llama", "hi"); return err }},
+		{"POST /v1/inference/async", func() error {
+			_, err := client.SubmitInference(InferenceRequest{Model: "llama", Prompt: "hi"})
+			return err
+		}},
+		{"GET /v1/inference/jobs/job_1", func() error { _, err := client.InferenceJob(ctx, "job_1"); return err }},
+		{"GET /v1/inference/jobs/job_1/result", func() error { _, err := client.InferenceJobResult(ctx, "job_1"); return err }},
+		{"POST /v1/inference/req_1/cancel", func() error { return client.CancelInference("req_1") }},
+		{"POST /v1/batches", func() error { _, err := client.CreateBatch(CreateBatchRequest{InputFileID: "file_1"}); return err }},
+		{"GET /v1/batches/batch_1", func() error { _, err := client.Batch("batch_1"); return err }},
+		{"POST /v1/batches/batch_1/cancel", func() error { _, err := client.CancelBatch("batch_1"); return err }},
+		{"GET /v1/files", func() error { _, err := client.Files(""); return err }},
+		{"GET /v1/files/file_1", func() error { _, err := client.GetFile("file_1"); return err }},
+		{"DELETE /v1/files/file_1", func() error { return client.DeleteFile("file_1") }},
+		{"GET /v1/queue/stats", func() error { _, err := client.QueueStats(); return err }},
+		{"GET /v1/keys/current", func() error { _, err := client.CurrentKey(ctx); return err }},
+		{"GET /v1/budget", func() error { _, err := client.BudgetStatus(ctx); return err }},
+		{"GET /usage", func() error { _, err := client.Usage(ctx, UsageQuery{}); return err }},
+	}
+
+	for _, c := range calls {
+		// Calls that check the response's contents may fail on the empty
+		// object; the path is what is under test
+		c.call()
+		if got := server.last(); got != c.want {
+			t.Errorf("sent %q, want %q", got, c.want)
+		}
+	}
+}
+
+// errorServer answers every request with status and body
+func errorServer(status int, body string, header http.Header) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for name, values := range header {
+			w.Header()[name] = values
+		}
+		w.WriteHeader(status)
+		w.Write([]byte(body))
+	}))
+}
+
+func TestDecodeResponseAPIError(t *testing.T) {
+	ts := errorServer(http.StatusNotFound, `{"error":{"message":"no such model"}}`+"\n", nil)
+	defer ts.Close()
+
+	_, err := NewClient(ts.URL, "").RetrieveModel("missing")
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) {
+		t.Fatalf("got %v, want an *APIError", err)
+	}
+	if apiErr.StatusCode != http.StatusNotFound || apiErr.Body != `{"error":{"message":"no such model"}}` {
+		t.Errorf("got status %d, body %q", apiErr.StatusCode, apiErr.Body)
+	}
+}
+
+func TestDecodeResponseTenantThrottled(t *testing.T) {
+	body := `{"error":{"message":"too many requests","code":"tenant_request_rate_exceeded","tenant":"acme","limit":10,"retry_after_ms":1500}}`
+	ts := errorServer(http.StatusTooManyRequests, body, http.Header{"Retry-After": {"2"}})
+	defer ts.Close()
+
+	_, err := NewClient(ts.URL, "").OpenAIModels()
+	var throttled *TenantThrottledError
+	if !errors.As(err, &throttled) {
+		t.Fatalf("got %v, want a *TenantThrottledError", err)
+	}
+	if throttled.Tenant != "acme" || throttled.Limit != 10 || throttled.RetryAfter != 1500*time.Millisecond {
+		t.Errorf("got %+v", throttled)
+	}
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusTooManyRequests {
+		t.Errorf("the *APIError is not wrapped: %v", err)
+	}
+}
+
+func TestDecodeResponseBudgetExceeded(t *testing.T) {
+	body := `{"error":{"message":"over budget","code":"budget_exceeded","budget":{"subject":"tenant","id":"acme","period":"daily","used":12.5}}}`
+	ts := errorServer(http.StatusTooManyRequests, body, http.Header{"Retry-After": {"60"}})
+	defer ts.Close()
+
+	_, err := NewClient(ts.URL, "").OpenAIModels()
+	var exceeded *BudgetExceededError
+	if !errors.As(err, &exceeded) {
+		t.Fatalf("got %v, want a *BudgetExceededError", err)
+	}
+	if exceeded.Budget.ID != "acme" || exceeded.Budget.Used != 12.5 || exceeded.RetryAfter != time.Minute {
+		t.Errorf("got %+v", exceeded)
+	}
+	// A 429 of another kind stays a plain *APIError
+	other := errorServer(http.StatusTooManyRequests, `{"error":{"code":"rate_limited"}}`, nil)
+	defer other.Close()
+	_, err = NewClient(other.URL, "").OpenAIModels()
+	if errors.As(err, &exceeded) || !strings.Contains(err.Error(), "429") {
+		t.Errorf("got %v, want a plain 429", err)
+	}
+}
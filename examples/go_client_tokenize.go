@@ -0,0 +1,117 @@
+package inferno
+
+import (
+	"context"
+	"fmt"
+
+	"inferno-example/infernoprompt"
+)
+
+// Tokenization structures
+type TokenizeRequest struct {
+	Model string   `json:"model"`
+	Input []string `json:"input"`
+	// CountOnly leaves the token IDs out of the response
+	CountOnly bool `json:"count_only,omitempty"`
+}
+
+type TokenizedText struct {
+	Index  int      `json:"index"`
+	Count  int      `json:"count"`
+	Tokens []uint32 `json:"tokens,omitempty"`
+}
+
+type TokenizeResponse struct {
+	Model       string          `json:"model"`
+	Data        []TokenizedText `json:"data"`
+	TotalTokens int             `json:"total_tokens"`
+}
+
+// Tokenize returns the token IDs of each text under model's own tokenizer
+func (c *Client) Tokenize(ctx context.Context, model string, texts ...string) (*TokenizeResponse, error) {
+	return c.tokenize(ctx, TokenizeRequest{Model: model, Input: texts})
+}
+
+// CountTokens returns the exact token count of each text under model
+func (c *Client) CountTokens(ctx context.Context, model string, texts ...string) ([]int, error) {
+	result, err := c.tokenize(ctx, TokenizeRequest{Model: model, Input: texts, CountOnly: true})
+	if err != nil {
+		return nil, err
+	}
+
+	counts := make([]int, len(texts))
+	for _, text := range result.Data {
+		if text.Index < len(counts) {
+			counts[text.Index] = text.Count
+		}
+	}
+	return counts, nil
+}
+
+// TokenCounter returns an infernoprompt.Counter that counts with model's
+// tokenizer, for budgets that must be exact
+func (c *Client) TokenCounter(model string) infernoprompt.Counter {
+	return infernoprompt.CounterFunc(func(ctx context.Context, texts []string) ([]int, error) {
+		return c.CountTokens(ctx, model, texts...)
+	})
+}
+
+// TokenCompletion is a completion generated straight from token IDs
+type TokenCompletion struct {
+	Text string
+	// PromptTokenIDs are the IDs generation started from; TokenIDs are the
+	// generated text re-tokenized
+	PromptTokenIDs []uint32
+	TokenIDs       []uint32
+	FinishReason   FinishReason
+}
+
+// InferenceTokens generates from inputIDs without the server tokenizing a
+// prompt, and returns the completion's token IDs with its text. The
+// request's Prompt is ignored; sampling fields apply as usual.
+func (c *Client) InferenceTokens(ctx context.Context, request InferenceRequest, inputIDs []uint32) (*TokenCompletion, error) {
+	request.Prompt = ""
+	request.InputIDs = inputIDs
+	request.ReturnTokenIDs = true
+	request.Stream = false
+
+	result, err := c.InferenceContext(ctx, request)
+	if err != nil {
+		return nil, err
+	}
+	if len(result.Choices) == 0 {
+		return nil, fmt.Errorf("no response received")
+	}
+
+	choice := result.Choices[0]
+	completion := &TokenCompletion{
+		Text:           choice.Text,
+		PromptTokenIDs: choice.PromptTokenIDs,
+		TokenIDs:       choice.TokenIDs,
+	}
+	if choice.FinishReason != nil {
+		completion.FinishReason = *choice.FinishReason
+	}
+	return completion, nil
+}
+
+func (c *Client) tokenize(ctx context.Context, request TokenizeRequest) (*TokenizeResponse, error) {
+	if c.ContentCache != nil {
+		return c.cachedTokenize(ctx, request)
+	}
+	return c.fetchTokens(ctx, request)
+}
+
+func (c *Client) fetchTokens(ctx context.Context, request TokenizeRequest) (*TokenizeResponse, error) {
+	resp, err := c.RequestContext(ctx, "POST", "/v1/tokenize", request)
+	if err != nil {
+		return nil, err
+	}
+
+	var result TokenizeResponse
+	if err := decodeResponse(resp, &result); err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
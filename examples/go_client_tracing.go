@@ -0,0 +1,101 @@
+package inferno
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"time"
+)
+
+// TraceParentHeader carries W3C trace context between services
+const TraceParentHeader = "traceparent"
+
+// traceParentKey holds the traceparent a request should send
+type traceParentKey struct{}
+
+// WithTraceParent makes requests made with ctx continue the trace named by
+// traceparent, so the server's spans join the caller's trace. Pass the
+// value the caller received or its tracing library produced.
+func WithTraceParent(ctx context.Context, traceparent string) context.Context {
+	return context.WithValue(ctx, traceParentKey{}, traceparent)
+}
+
+// NewTraceParent starts a new trace, returning a traceparent for
+// WithTraceParent. A sampled trace is exported by the server whatever its
+// sampling ratio.
+func NewTraceParent(sampled bool) string {
+	traceID := make([]byte, 16)
+	spanID := make([]byte, 8)
+	rand.Read(traceID)
+	rand.Read(spanID)
+
+	flags := "00"
+	if sampled {
+		flags = "01"
+	}
+	return "00-" + hex.EncodeToString(traceID) + "-" + hex.EncodeToString(spanID) + "-" + flags
+}
+
+// ResponseTraceParent returns the traceparent naming the server's span for
+// resp, to link it from the caller's own spans or logs
+func ResponseTraceParent(resp *http.Response) string {
+	return resp.Header.Get(TraceParentHeader)
+}
+
+func setTraceParent(ctx context.Context, req *http.Request) {
+	if traceparent, ok := ctx.Value(traceParentKey{}).(string); ok && traceparent != "" {
+		req.Header.Set(TraceParentHeader, traceparent)
+	}
+}
+
+// Trace export structures
+type TraceExportConfig struct {
+	Enabled bool `json:"enabled"`
+	// Endpoint is an OTLP/HTTP collector such as http://otel-collector:4318
+	Endpoint    string `json:"endpoint"`
+	ServiceName string `json:"service_name"`
+	// SamplingRatio applies to requests without a traceparent
+	SamplingRatio float64 `json:"sampling_ratio"`
+}
+
+// TraceExportUpdate changes the fields that are set and leaves the rest
+type TraceExportUpdate struct {
+	Enabled       *bool    `json:"enabled,omitempty"`
+	Endpoint      *string  `json:"endpoint,omitempty"`
+	ServiceName   *string  `json:"service_name,omitempty"`
+	SamplingRatio *float64 `json:"sampling_ratio,omitempty"`
+}
+
+type TraceExportStats struct {
+	SpansExported  int64      `json:"spans_exported"`
+	SpansDropped   int64      `json:"spans_dropped"`
+	ExportFailures int64      `json:"export_failures"`
+	LastExportAt   *time.Time `json:"last_export_at,omitempty"`
+	LastError      string     `json:"last_error,omitempty"`
+}
+
+type TraceExportStatus struct {
+	Object string            `json:"object"`
+	Config TraceExportConfig `json:"config"`
+	Stats  TraceExportStats  `json:"stats"`
+}
+
+// TraceExport returns the server's trace exporter settings and counters
+func (a *AdminClient) TraceExport(ctx context.Context) (*TraceExportStatus, error) {
+	var status TraceExportStatus
+	if err := a.adminRequest(ctx, "GET", "/admin/tracing", nil, &status); err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
+
+// UpdateTraceExport changes the exporter's target or sampling ratio, or
+// turns export on or off, without restarting the server
+func (a *AdminClient) UpdateTraceExport(ctx context.Context, update TraceExportUpdate) (*TraceExportStatus, error) {
+	var status TraceExportStatus
+	if err := a.adminRequest(ctx, "PUT", "/admin/tracing", update, &status); err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
@@ -0,0 +1,103 @@
+package inferno
+
+import (
+	"context"
+	"fmt"
+)
+
+// Translation registers
+const (
+	FormalityDefault  = "default"
+	FormalityFormal   = "formal"
+	FormalityInformal = "informal"
+)
+
+// Translation structures
+type TranslateOptions struct {
+	// SourceLanguage is detected by the model when empty
+	SourceLanguage string `json:"source_language,omitempty"`
+	// Formality is one of the Formality* constants
+	Formality string `json:"formality,omitempty"`
+	// Glossary maps source terms to the exact translation each must get
+	Glossary map[string]string `json:"glossary,omitempty"`
+	// ChunkTokens overrides the chunk size; the server defaults to a third
+	// of its context window
+	ChunkTokens int `json:"chunk_tokens,omitempty"`
+	TimeoutMs   int `json:"timeout_ms,omitempty"`
+}
+
+type TranslateRequest struct {
+	Model string `json:"model"`
+	// Text holds one or more texts, translated independently
+	Text           []string `json:"text"`
+	TargetLanguage string   `json:"target_language"`
+	// Metadata tags the request's usage; see InferenceRequest
+	Metadata map[string]string `json:"metadata,omitempty"`
+	TranslateOptions
+}
+
+type Translation struct {
+	Index       int    `json:"index"`
+	Translation string `json:"translation"`
+	// Chunks is 1 when the text was translated in one piece
+	Chunks int `json:"chunks"`
+}
+
+type TranslateResponse struct {
+	Model          string        `json:"model"`
+	SourceLanguage *string       `json:"source_language"`
+	TargetLanguage string        `json:"target_language"`
+	Data           []Translation `json:"data"`
+}
+
+// Translate sends a translation request as is. Long texts are chunked and
+// reassembled on the server, keeping their paragraph layout.
+func (c *Client) Translate(ctx context.Context, request TranslateRequest) (*TranslateResponse, error) {
+	if err := c.requireFeature(ctx, "translate"); err != nil {
+		return nil, err
+	}
+
+	resp, err := c.RequestContext(ctx, "POST", "/v1/translate", request)
+	if err != nil {
+		return nil, err
+	}
+
+	var result TranslateResponse
+	if err := decodeResponse(resp, &result); err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+// TranslateText translates one text into target; opts may be nil
+func (c *Client) TranslateText(ctx context.Context, model, text, target string, opts *TranslateOptions) (string, error) {
+	translations, err := c.TranslateBatch(ctx, model, []string{text}, target, opts)
+	if err != nil {
+		return "", err
+	}
+	return translations[0], nil
+}
+
+// TranslateBatch translates every text into target in one request and
+// returns the translations in input order; opts may be nil
+func (c *Client) TranslateBatch(ctx context.Context, model string, texts []string, target string, opts *TranslateOptions) ([]string, error) {
+	request := TranslateRequest{Model: model, Text: texts, TargetLanguage: target}
+	if opts != nil {
+		request.TranslateOptions = *opts
+	}
+
+	result, err := c.Translate(ctx, request)
+	if err != nil {
+		return nil, err
+	}
+
+	translations := make([]string, len(texts))
+	for _, translation := range result.Data {
+		if translation.Index >= len(translations) {
+			return nil, fmt.Errorf("translation index %d out of range", translation.Index)
+		}
+		translations[translation.Index] = translation.Translation
+	}
+	return translations, nil
+}
@@ -0,0 +1,61 @@
+package inferno
+
+import "net/http"
+
+// RequestHook runs on each request just before it is sent, after the
+// client has set its own headers. It may change the request, as to sign it
+// or add a gateway's credentials; an error aborts the call with that error.
+type RequestHook func(req *http.Request) error
+
+// ResponseHook runs on each response as it arrives, before the client
+// reads it. It may inspect the status and headers or replace the body; an
+// error closes the response and fails the call with that error.
+type ResponseHook func(resp *http.Response) error
+
+// OnRequest adds a hook run on every request the client sends, in the order
+// added. Requests resent to another endpoint run the hooks again, so a
+// signature covers the URL actually used.
+func (c *Client) OnRequest(hook RequestHook) {
+	c.hooksMu.Lock()
+	defer c.hooksMu.Unlock()
+	c.requestHooks = append(c.requestHooks, hook)
+}
+
+// OnResponse adds a hook run on every response the client receives, in the
+// order added, including draining refusals it then retries elsewhere
+func (c *Client) OnResponse(hook ResponseHook) {
+	c.hooksMu.Lock()
+	defer c.hooksMu.Unlock()
+	c.responseHooks = append(c.responseHooks, hook)
+}
+
+// do sends one attempt of req through Transport, if set, and the hooks
+func (c *Client) do(httpClient *http.Client, req *http.Request) (*http.Response, error) {
+	c.hooksMu.Lock()
+	requestHooks, responseHooks := c.requestHooks, c.responseHooks
+	c.hooksMu.Unlock()
+
+	for _, hook := range requestHooks {
+		if err := hook(req); err != nil {
+			return nil, err
+		}
+	}
+
+	if c.Transport != nil {
+		withTransport := *httpClient
+		withTransport.Transport = c.Transport
+		httpClient = &withTransport
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, hook := range responseHooks {
+		if err := hook(resp); err != nil {
+			resp.Body.Close()
+			return nil, err
+		}
+	}
+	return resp, nil
+}
@@ -0,0 +1,91 @@
+package inferno
+
+import (
+	"context"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Usage dimensions to group and filter by; see UsageByMetadata
+const (
+	UsageByModel    = "model"
+	UsageByTenant   = "tenant"
+	UsageByKey      = "key"
+	UsageByEndpoint = "endpoint"
+)
+
+// UsageByMetadata is the dimension of a request Metadata key
+func UsageByMetadata(key string) string {
+	return "metadata." + key
+}
+
+// UsageQuery selects and groups usage records; zero fields match everything
+type UsageQuery struct {
+	Start time.Time
+	End   time.Time
+	// GroupBy lists dimensions, such as UsageByModel or
+	// UsageByMetadata("team"); none totals everything in one group
+	GroupBy []string
+	// Filters keeps records whose dimension has the given value, such as
+	// {UsageByMetadata("team"): "search"}
+	Filters map[string]string
+}
+
+func (q UsageQuery) query() url.Values {
+	query := url.Values{}
+	if !q.Start.IsZero() {
+		query.Set("start", q.Start.UTC().Format(time.RFC3339))
+	}
+	if !q.End.IsZero() {
+		query.Set("end", q.End.UTC().Format(time.RFC3339))
+	}
+	if len(q.GroupBy) > 0 {
+		query.Set("group_by", strings.Join(q.GroupBy, ","))
+	}
+	for dimension, value := range q.Filters {
+		query.Set(dimension, value)
+	}
+	return query
+}
+
+// UsageGroup totals the requests sharing one value of each GroupBy
+// dimension
+type UsageGroup struct {
+	Object string `json:"object"`
+	// Group maps each dimension to its value, nil for requests without it
+	Group            map[string]*string `json:"group"`
+	Requests         int64              `json:"requests"`
+	PromptTokens     int64              `json:"prompt_tokens"`
+	CompletionTokens int64              `json:"completion_tokens"`
+	TotalTokens      int64              `json:"total_tokens"`
+}
+
+// UsageReport is the server's answer to a UsageQuery, largest groups first
+type UsageReport struct {
+	Object  string       `json:"object"`
+	Start   *time.Time   `json:"start,omitempty"`
+	End     *time.Time   `json:"end,omitempty"`
+	GroupBy []string     `json:"group_by"`
+	Data    []UsageGroup `json:"data"`
+}
+
+// Usage returns token usage of completed generation requests matching
+// query. Without the admin token the server reports only the client's
+// tenant.
+func (c *Client) Usage(ctx context.Context, query UsageQuery) (*UsageReport, error) {
+	endpoint := "/usage"
+	if values := query.query(); len(values) > 0 {
+		endpoint += "?" + values.Encode()
+	}
+	resp, err := c.RequestContext(ctx, "GET", endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var report UsageReport
+	if err := decodeResponse(resp, &report); err != nil {
+		return nil, err
+	}
+	return &report, nil
+}
@@ -0,0 +1,165 @@
+package inferno
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// Usage export file formats
+const (
+	UsageExportCSV     = "csv"
+	UsageExportParquet = "parquet"
+)
+
+// UsageExportRequest asks for the usage records of a time range as a file,
+// one row per request
+type UsageExportRequest struct {
+	// Format is UsageExportCSV or UsageExportParquet
+	Format string     `json:"format"`
+	Start  *time.Time `json:"start,omitempty"`
+	End    *time.Time `json:"end,omitempty"`
+	// Dimensions are the columns besides the timestamp and token counts,
+	// such as UsageByModel or UsageByMetadata("team"); model, tenant, key
+	// and endpoint when empty
+	Dimensions []string `json:"dimensions,omitempty"`
+	// Filters keeps records whose dimension has the given value
+	Filters map[string]string `json:"filters,omitempty"`
+}
+
+// UsageExport is an export job. Once Status is "completed", DownloadURL
+// fetches the file without credentials until ExpiresAt.
+type UsageExport struct {
+	ID          string     `json:"id"`
+	Object      string     `json:"object"`
+	Status      string     `json:"status"`
+	Format      string     `json:"format"`
+	Start       *time.Time `json:"start,omitempty"`
+	End         *time.Time `json:"end,omitempty"`
+	Dimensions  []string   `json:"dimensions"`
+	Tenant      string     `json:"tenant,omitempty"`
+	Rows        int64      `json:"rows,omitempty"`
+	Bytes       int64      `json:"bytes,omitempty"`
+	Filename    string     `json:"filename,omitempty"`
+	DownloadURL string     `json:"download_url,omitempty"`
+	Error       string     `json:"error,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+	CompletedAt *time.Time `json:"completed_at,omitempty"`
+	ExpiresAt   time.Time  `json:"expires_at"`
+}
+
+// Done reports whether the export has finished, successfully or not
+func (e *UsageExport) Done() bool {
+	return e.Status == "completed" || e.Status == "failed"
+}
+
+// ExportUsage starts writing usage records to a file on the server. Without
+// the admin token the export covers only the client's tenant.
+func (c *Client) ExportUsage(ctx context.Context, req UsageExportRequest) (*UsageExport, error) {
+	resp, err := c.RequestContext(ctx, "POST", "/usage/export", req)
+	if err != nil {
+		return nil, err
+	}
+
+	var export UsageExport
+	if err := decodeResponse(resp, &export); err != nil {
+		return nil, err
+	}
+	return &export, nil
+}
+
+// UsageExport returns an export's status
+func (c *Client) UsageExport(ctx context.Context, exportID string) (*UsageExport, error) {
+	resp, err := c.RequestContext(ctx, "GET", "/usage/exports/"+url.PathEscape(exportID), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var export UsageExport
+	if err := decodeResponse(resp, &export); err != nil {
+		return nil, err
+	}
+	return &export, nil
+}
+
+// WaitUsageExport polls an export with exponential backoff until it
+// completes or ctx is done
+func (c *Client) WaitUsageExport(ctx context.Context, exportID string) (*UsageExport, error) {
+	delay := jobPollInitial
+
+	for {
+		export, err := c.UsageExport(ctx, exportID)
+		if err != nil {
+			return nil, err
+		}
+
+		if export.Done() {
+			if export.Status == "failed" {
+				return nil, fmt.Errorf("usage export %s failed: %s", exportID, export.Error)
+			}
+			return export, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(delay):
+		}
+
+		delay *= 2
+		if delay > jobPollMax {
+			delay = jobPollMax
+		}
+	}
+}
+
+// DownloadUsageExport streams a completed export's file to w
+func (c *Client) DownloadUsageExport(ctx context.Context, export *UsageExport, w io.Writer) (int64, error) {
+	if export.DownloadURL == "" {
+		return 0, fmt.Errorf("usage export %s has no download URL (status %s)", export.ID, export.Status)
+	}
+	resp, err := c.longRunningRequest(ctx, "GET", export.DownloadURL, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		body, _ := io.ReadAll(resp.Body)
+		return 0, &APIError{StatusCode: resp.StatusCode, Body: strings.TrimSpace(string(body))}
+	}
+
+	return io.Copy(w, resp.Body)
+}
+
+// ExportUsageFile exports usage records, waits for the export and streams
+// it to the file at path, removing the file if the download fails part way
+func (c *Client) ExportUsageFile(ctx context.Context, req UsageExportRequest, path string) (*UsageExport, error) {
+	export, err := c.ExportUsage(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	if export, err = c.WaitUsageExport(ctx, export.ID); err != nil {
+		return nil, err
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = c.DownloadUsageExport(ctx, export, file)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path)
+		return nil, err
+	}
+
+	return export, nil
+}
@@ -0,0 +1,40 @@
+package inferno
+
+import (
+	"net/url"
+	"time"
+)
+
+// Model verification structures
+type ModelVerification struct {
+	Model          string  `json:"model"`
+	Path           string  `json:"path"`
+	SHA256         string  `json:"sha256"`
+	ExpectedSHA256 *string `json:"expected_sha256,omitempty"`
+	// Checksum is "recorded", "matched" or "mismatch"
+	Checksum string `json:"checksum"`
+	// Signature is "unsigned", "valid" or "untrusted"
+	Signature string  `json:"signature"`
+	KeyID     *string `json:"key_id,omitempty"`
+	// Trusted reports whether the server's policy lets the model load
+	Trusted    bool      `json:"trusted"`
+	Reason     *string   `json:"reason,omitempty"`
+	VerifiedAt time.Time `json:"verified_at"`
+}
+
+// VerifyModel hashes a model on the server and checks it against its
+// recorded digest and signature, without loading it. Requires the admin
+// token.
+func (c *Client) VerifyModel(modelID string) (*ModelVerification, error) {
+	resp, err := c.Request("POST", "/v1/models/"+url.PathEscape(modelID)+"/verify", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var verification ModelVerification
+	if err := decodeResponse(resp, &verification); err != nil {
+		return nil, err
+	}
+
+	return &verification, nil
+}
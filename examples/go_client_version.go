@@ -0,0 +1,134 @@
+package inferno
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// clientAPIVersions are the API versions this client speaks, newest first,
+// sent with every request as Accept-Version
+var clientAPIVersions = []string{"1.2", "1.1", "1.0"}
+
+// featureVersions maps features added after API 1.0 to the version that
+// introduced them
+var featureVersions = map[string]string{
+	"extract":   "1.1",
+	"summarize": "1.1",
+	"translate": "1.1",
+	"sessions":  "1.2",
+}
+
+// Version structures
+type ServerVersionInfo struct {
+	ServerVersion string   `json:"server_version"`
+	APIVersions   []string `json:"api_versions"`
+	Current       string   `json:"current"`
+	// Features maps features added after 1.0 to the version that added them
+	Features map[string]string `json:"features,omitempty"`
+	// Negotiated is the highest API version both this client and the server
+	// support
+	Negotiated string `json:"-"`
+}
+
+// VersionError is returned when the server is too old for a feature the
+// caller asked for
+type VersionError struct {
+	Feature string
+	// Required is the API version that introduced Feature
+	Required string
+	// Negotiated is the newest version the server and client share
+	Negotiated    string
+	ServerVersion string
+}
+
+func (e *VersionError) Error() string {
+	server := "the server"
+	if e.ServerVersion != "" {
+		server += " (" + e.ServerVersion + ")"
+	}
+	return fmt.Sprintf("inferno: %s needs API version %s but %s supports up to %s; upgrade the server", e.Feature, e.Required, server, e.Negotiated)
+}
+
+// ServerVersion returns the server's build and API versions, with the
+// version negotiated with this client. The result is cached after the
+// first successful call.
+func (c *Client) ServerVersion() (*ServerVersionInfo, error) {
+	return c.serverVersion(context.Background())
+}
+
+func (c *Client) serverVersion(ctx context.Context) (*ServerVersionInfo, error) {
+	c.versionMu.Lock()
+	defer c.versionMu.Unlock()
+	if c.version != nil {
+		return c.version, nil
+	}
+
+	resp, err := c.RequestContext(ctx, "GET", "/version", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var info ServerVersionInfo
+	if resp.StatusCode == http.StatusNotFound {
+		// Servers from before versioning speak only 1.0
+		resp.Body.Close()
+		info = ServerVersionInfo{APIVersions: []string{"1.0"}, Current: "1.0"}
+	} else if err := decodeResponse(resp, &info); err != nil {
+		return nil, err
+	}
+
+	for _, version := range clientAPIVersions {
+		for _, supported := range info.APIVersions {
+			if version == supported {
+				info.Negotiated = version
+				break
+			}
+		}
+		if info.Negotiated != "" {
+			break
+		}
+	}
+	if info.Negotiated == "" {
+		return nil, fmt.Errorf("inferno: no API version in common: server supports %s, client %s",
+			strings.Join(info.APIVersions, ", "), strings.Join(clientAPIVersions, ", "))
+	}
+
+	c.version = &info
+	return c.version, nil
+}
+
+// requireFeature fails with a *VersionError when the negotiated API
+// version predates feature
+func (c *Client) requireFeature(ctx context.Context, feature string) error {
+	info, err := c.serverVersion(ctx)
+	if err != nil {
+		return err
+	}
+
+	required := featureVersions[feature]
+	if compareAPIVersions(info.Negotiated, required) < 0 {
+		return &VersionError{Feature: feature, Required: required, Negotiated: info.Negotiated, ServerVersion: info.ServerVersion}
+	}
+	return nil
+}
+
+// compareAPIVersions returns a negative number when "major.minor" version a
+// is older than b, zero when they match and a positive number otherwise
+func compareAPIVersions(a, b string) int {
+	aMajor, aMinor := parseAPIVersion(a)
+	bMajor, bMinor := parseAPIVersion(b)
+	if aMajor != bMajor {
+		return aMajor - bMajor
+	}
+	return aMinor - bMinor
+}
+
+func parseAPIVersion(version string) (major, minor int) {
+	majorPart, minorPart, _ := strings.Cut(version, ".")
+	major, _ = strconv.Atoi(majorPart)
+	minor, _ = strconv.Atoi(minorPart)
+	return major, minor
+}
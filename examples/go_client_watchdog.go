@@ -0,0 +1,148 @@
+package inferno
+
+import (
+	"context"
+	"strconv"
+	"time"
+)
+
+// WatchdogState is the model watchdog's overall state
+type WatchdogState string
+
+const (
+	// WatchdogIdle means no model was loaded at startup, so there is
+	// nothing to watch
+	WatchdogIdle       WatchdogState = "idle"
+	WatchdogDisabled   WatchdogState = "disabled"
+	WatchdogHealthy    WatchdogState = "healthy"
+	WatchdogRecovering WatchdogState = "recovering"
+	WatchdogFailed     WatchdogState = "failed"
+)
+
+// IncidentCause is why the watchdog opened an incident
+type IncidentCause string
+
+const (
+	IncidentCrashed IncidentCause = "crashed"
+	IncidentHung    IncidentCause = "hung"
+	IncidentManual  IncidentCause = "manual"
+)
+
+// IncidentStatus is how far an incident's recovery got
+type IncidentStatus string
+
+const (
+	IncidentRecovering IncidentStatus = "recovering"
+	IncidentRecovered  IncidentStatus = "recovered"
+	// IncidentFailed means every reload attempt failed; the watchdog waits
+	// for RecoverModel
+	IncidentFailed IncidentStatus = "failed"
+)
+
+// Watchdog structures
+type WatchdogConfig struct {
+	Enabled         bool   `json:"enabled"`
+	IntervalSecs    uint64 `json:"interval_secs"`
+	HangTimeoutSecs uint64 `json:"hang_timeout_secs"`
+	// ProbeInference also runs a one-token generation on each check
+	ProbeInference     bool   `json:"probe_inference"`
+	MaxAttempts        uint32 `json:"max_attempts"`
+	BackoffInitialSecs uint64 `json:"backoff_initial_secs"`
+	BackoffMaxSecs     uint64 `json:"backoff_max_secs"`
+}
+
+// WatchdogConfigUpdate changes the fields that are set and leaves the rest
+type WatchdogConfigUpdate struct {
+	Enabled            *bool   `json:"enabled,omitempty"`
+	IntervalSecs       *uint64 `json:"interval_secs,omitempty"`
+	HangTimeoutSecs    *uint64 `json:"hang_timeout_secs,omitempty"`
+	ProbeInference     *bool   `json:"probe_inference,omitempty"`
+	MaxAttempts        *uint32 `json:"max_attempts,omitempty"`
+	BackoffInitialSecs *uint64 `json:"backoff_initial_secs,omitempty"`
+	BackoffMaxSecs     *uint64 `json:"backoff_max_secs,omitempty"`
+}
+
+type RecoveryAttempt struct {
+	Attempt    int       `json:"attempt"`
+	StartedAt  time.Time `json:"started_at"`
+	DurationMs int64     `json:"duration_ms"`
+	Succeeded  bool      `json:"succeeded"`
+	Error      string    `json:"error,omitempty"`
+}
+
+type Incident struct {
+	Object     string            `json:"object"`
+	ID         string            `json:"id"`
+	Model      string            `json:"model"`
+	Cause      IncidentCause     `json:"cause"`
+	Status     IncidentStatus    `json:"status"`
+	Message    string            `json:"message"`
+	DetectedAt time.Time         `json:"detected_at"`
+	ResolvedAt *time.Time        `json:"resolved_at,omitempty"`
+	Attempts   []RecoveryAttempt `json:"attempts"`
+}
+
+type WatchdogStatus struct {
+	Object       string         `json:"object"`
+	State        WatchdogState  `json:"state"`
+	Model        string         `json:"model,omitempty"`
+	Config       WatchdogConfig `json:"config"`
+	LastCheckAt  *time.Time     `json:"last_check_at,omitempty"`
+	Incidents    int            `json:"incidents"`
+	LastIncident *Incident      `json:"last_incident,omitempty"`
+}
+
+type IncidentsResponse struct {
+	Object string     `json:"object"`
+	Data   []Incident `json:"data"`
+}
+
+// Watchdog returns the model watchdog's state and settings
+func (a *AdminClient) Watchdog(ctx context.Context) (*WatchdogStatus, error) {
+	var status WatchdogStatus
+	if err := a.adminRequest(ctx, "GET", "/admin/watchdog", nil, &status); err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
+
+// UpdateWatchdog changes the watchdog's settings
+func (a *AdminClient) UpdateWatchdog(ctx context.Context, update WatchdogConfigUpdate) (*WatchdogStatus, error) {
+	var status WatchdogStatus
+	if err := a.adminRequest(ctx, "PUT", "/admin/watchdog", update, &status); err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
+
+// WatchdogIncidents returns up to limit recent incidents, newest first; 0
+// returns all the server holds
+func (a *AdminClient) WatchdogIncidents(ctx context.Context, limit int) ([]Incident, error) {
+	endpoint := "/admin/watchdog/incidents"
+	if limit > 0 {
+		endpoint += "?limit=" + strconv.Itoa(limit)
+	}
+
+	var result IncidentsResponse
+	if err := a.adminRequest(ctx, "GET", endpoint, nil, &result); err != nil {
+		return nil, err
+	}
+	return result.Data, nil
+}
+
+// RecoverModel reloads the server's startup model now, retrying with the
+// watchdog's backoff, and returns the finished incident. Retries can outlast
+// HTTPClient.Timeout, so ctx bounds the call instead. When every attempt
+// fails the server answers 502 and this returns an *APIError.
+func (a *AdminClient) RecoverModel(ctx context.Context) (*Incident, error) {
+	resp, err := a.longRunningRequest(ctx, "POST", "/admin/watchdog/recover", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var incident Incident
+	if err := decodeResponse(resp, &incident); err != nil {
+		return nil, err
+	}
+	return &incident, nil
+}
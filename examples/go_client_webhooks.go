@@ -0,0 +1,238 @@
+package inferno
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Billing webhook events
+const (
+	WebhookUsageThresholdCrossed = "usage.threshold_crossed"
+	WebhookBudgetExhausted       = "budget.exhausted"
+	WebhookPeriodSummary         = "usage.period_summary"
+)
+
+// Headers of a webhook delivery
+const (
+	WebhookSignatureHeader = "X-Inferno-Webhook-Signature"
+	WebhookEventHeader     = "X-Inferno-Webhook-Event"
+)
+
+// DefaultWebhookTolerance is how old a delivery's timestamp may be before
+// ParseWebhook rejects it as a replay
+const DefaultWebhookTolerance = 5 * time.Minute
+
+// maxWebhookBody bounds the body ParseWebhook reads
+const maxWebhookBody = 4 << 20
+
+// ErrWebhookSignature is returned for deliveries whose signature does not
+// match the secret, or is too old
+var ErrWebhookSignature = errors.New("webhook signature is not valid")
+
+// WebhookSubscriptionRequest subscribes a URL to billing events
+type WebhookSubscriptionRequest struct {
+	URL string `json:"url"`
+	// Events defaults to all of them
+	Events []string `json:"events,omitempty"`
+	// Tenant limits the subscription to one tenant's usage and budgets
+	Tenant string `json:"tenant,omitempty"`
+	// Period is BudgetDaily or BudgetMonthly (the default): what Thresholds
+	// count over and what each summary covers
+	Period string `json:"period,omitempty"`
+	// Thresholds are total tokens per tenant and period that each send
+	// WebhookUsageThresholdCrossed once
+	Thresholds  []int64 `json:"thresholds,omitempty"`
+	Description string  `json:"description,omitempty"`
+}
+
+// WebhookDeliveries counts a subscription's deliveries
+type WebhookDeliveries struct {
+	Succeeded     int64      `json:"succeeded"`
+	Failed        int64      `json:"failed"`
+	LastAttemptAt *time.Time `json:"last_attempt_at,omitempty"`
+	LastError     string     `json:"last_error,omitempty"`
+}
+
+// WebhookSubscription is a registered webhook
+type WebhookSubscription struct {
+	ID          string   `json:"id"`
+	Object      string   `json:"object"`
+	URL         string   `json:"url"`
+	Events      []string `json:"events"`
+	Tenant      string   `json:"tenant,omitempty"`
+	Period      string   `json:"period"`
+	Thresholds  []int64  `json:"thresholds"`
+	Description string   `json:"description,omitempty"`
+	// Secret signs deliveries; the server returns it only from
+	// CreateWebhook
+	Secret     string            `json:"secret,omitempty"`
+	Deliveries WebhookDeliveries `json:"deliveries"`
+	CreatedAt  time.Time         `json:"created_at"`
+}
+
+// WebhookEvent is a delivery's body; decode Data with its Threshold,
+// BudgetExhausted or Summary method according to Type
+type WebhookEvent struct {
+	ID             string          `json:"id"`
+	Object         string          `json:"object"`
+	Type           string          `json:"type"`
+	Created        int64           `json:"created"`
+	SubscriptionID string          `json:"subscription_id"`
+	Data           json.RawMessage `json:"data"`
+}
+
+// UsageThresholdData is the data of WebhookUsageThresholdCrossed
+type UsageThresholdData struct {
+	Tenant      string    `json:"tenant"`
+	Period      string    `json:"period"`
+	PeriodStart time.Time `json:"period_start"`
+	PeriodEnd   time.Time `json:"period_end"`
+	Threshold   int64     `json:"threshold"`
+	TotalTokens int64     `json:"total_tokens"`
+}
+
+// BudgetExhaustedData is the data of WebhookBudgetExhausted
+type BudgetExhaustedData struct {
+	Budget BudgetStatus `json:"budget"`
+}
+
+// PeriodSummaryData is the data of WebhookPeriodSummary: the period's usage
+// by tenant and model, largest first
+type PeriodSummaryData struct {
+	Tenant      string       `json:"tenant,omitempty"`
+	Period      string       `json:"period"`
+	PeriodStart time.Time    `json:"period_start"`
+	PeriodEnd   time.Time    `json:"period_end"`
+	Totals      UsageGroup   `json:"totals"`
+	Groups      []UsageGroup `json:"groups"`
+}
+
+// Threshold decodes the data of a WebhookUsageThresholdCrossed event
+func (e *WebhookEvent) Threshold() (*UsageThresholdData, error) {
+	var data UsageThresholdData
+	return &data, e.decode(WebhookUsageThresholdCrossed, &data)
+}
+
+// BudgetExhausted decodes the data of a WebhookBudgetExhausted event
+func (e *WebhookEvent) BudgetExhausted() (*BudgetExhaustedData, error) {
+	var data BudgetExhaustedData
+	return &data, e.decode(WebhookBudgetExhausted, &data)
+}
+
+// Summary decodes the data of a WebhookPeriodSummary event
+func (e *WebhookEvent) Summary() (*PeriodSummaryData, error) {
+	var data PeriodSummaryData
+	return &data, e.decode(WebhookPeriodSummary, &data)
+}
+
+func (e *WebhookEvent) decode(eventType string, v interface{}) error {
+	if e.Type != eventType {
+		return fmt.Errorf("webhook event %s is %s, not %s", e.ID, e.Type, eventType)
+	}
+	return json.Unmarshal(e.Data, v)
+}
+
+// CreateWebhook subscribes a URL to billing events. Keep the returned
+// Secret: it is shown only once and is needed to verify deliveries.
+func (a *AdminClient) CreateWebhook(ctx context.Context, req WebhookSubscriptionRequest) (*WebhookSubscription, error) {
+	var subscription WebhookSubscription
+	if err := a.adminRequest(ctx, "POST", "/admin/webhooks", req, &subscription); err != nil {
+		return nil, err
+	}
+	return &subscription, nil
+}
+
+// Webhooks lists the webhook subscriptions with their delivery counts
+func (a *AdminClient) Webhooks(ctx context.Context) ([]WebhookSubscription, error) {
+	var result struct {
+		Data []WebhookSubscription `json:"data"`
+	}
+	if err := a.adminRequest(ctx, "GET", "/admin/webhooks", nil, &result); err != nil {
+		return nil, err
+	}
+	return result.Data, nil
+}
+
+// Webhook returns one webhook subscription
+func (a *AdminClient) Webhook(ctx context.Context, id string) (*WebhookSubscription, error) {
+	var subscription WebhookSubscription
+	if err := a.adminRequest(ctx, "GET", "/admin/webhooks/"+url.PathEscape(id), nil, &subscription); err != nil {
+		return nil, err
+	}
+	return &subscription, nil
+}
+
+// DeleteWebhook removes a webhook subscription
+func (a *AdminClient) DeleteWebhook(ctx context.Context, id string) error {
+	return a.adminRequest(ctx, "DELETE", "/admin/webhooks/"+url.PathEscape(id), nil, nil)
+}
+
+// VerifyWebhookSignature checks a WebhookSignatureHeader value against body
+// and secret, rejecting timestamps more than tolerance from now (none when
+// tolerance is zero)
+func VerifyWebhookSignature(secret, header string, body []byte, tolerance time.Duration) error {
+	var timestamp, signature string
+	for _, part := range strings.Split(header, ",") {
+		name, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+		switch name {
+		case "timestamp":
+			timestamp = value
+		case "signature":
+			signature = value
+		}
+	}
+	sent, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil || signature == "" {
+		return ErrWebhookSignature
+	}
+	if age := time.Since(time.Unix(sent, 0)); tolerance > 0 && (age > tolerance || age < -tolerance) {
+		return ErrWebhookSignature
+	}
+
+	got, err := hex.DecodeString(signature)
+	if err != nil {
+		return ErrWebhookSignature
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	if !hmac.Equal(got, mac.Sum(nil)) {
+		return ErrWebhookSignature
+	}
+	return nil
+}
+
+// ParseWebhook reads a delivery from an incoming request, verifies its
+// signature with secret and DefaultWebhookTolerance, and decodes it:
+//
+//	event, err := ParseWebhook(r, secret)
+//	if errors.Is(err, ErrWebhookSignature) {
+//		http.Error(w, "bad signature", http.StatusUnauthorized)
+//		return
+//	}
+func ParseWebhook(r *http.Request, secret string) (*WebhookEvent, error) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxWebhookBody))
+	if err != nil {
+		return nil, err
+	}
+	if err := VerifyWebhookSignature(secret, r.Header.Get(WebhookSignatureHeader), body, DefaultWebhookTolerance); err != nil {
+		return nil, err
+	}
+
+	var event WebhookEvent
+	if err := json.Unmarshal(body, &event); err != nil {
+		return nil, err
+	}
+	return &event, nil
+}
@@ -0,0 +1,205 @@
+// Package infernoagent runs the tool-calling loop of an agent: call the
+// model, run the tools it asks for concurrently, append their results and
+// call it again, until it answers without calling a tool.
+//
+//	registry := infernotools.New()
+//	registry.Register(infernotools.Tool{Name: "search", ...})
+//
+//	client := inferno.NewClient("http://localhost:8080", apiKey)
+//	result, err := infernoagent.Run(ctx, client, "llama-3-8b", registry,
+//		[]infernotools.Message{{Role: "user", Content: "What changed in Go 1.22?"}},
+//		&infernoagent.Options{
+//			MaxIterations: 6,
+//			OnToken:       func(text string) { fmt.Print(text) },
+//		})
+//	fmt.Println(result.Message.Content)
+//
+// Tools are validated, timed out and recovered by the infernotools
+// registry, so a failing tool is reported to the model rather than ending
+// the run. The Result records every step, so a run can be logged or
+// replayed even when it stops with an error.
+package infernoagent
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	inferno "inferno-example"
+	"inferno-example/infernotools"
+)
+
+// DefaultMaxIterations bounds the model calls of a run whose
+// Options.MaxIterations is zero
+const DefaultMaxIterations = 10
+
+// Options tune a run; a nil *Options uses the defaults
+type Options struct {
+	// MaxIterations bounds the model calls (default DefaultMaxIterations)
+	MaxIterations int
+	// MaxConcurrency bounds the tools running at once; zero runs every
+	// call of a reply together
+	MaxConcurrency int
+
+	MaxTokens   *int
+	Temperature *float32
+	Seed        *uint64
+	// ToolChoice applies to the first model call only; see
+	// infernotools.ChatRequest
+	ToolChoice interface{}
+
+	// OnToken receives assistant content as it is generated. Setting it
+	// streams every model call.
+	OnToken func(text string)
+	// OnToolCall is called before each tool runs
+	OnToolCall func(call infernotools.ToolCall)
+	// OnToolResult is called with each tool's result message
+	OnToolResult func(call infernotools.ToolCall, result infernotools.Message)
+	// OnStep is called after each iteration, once its tools have finished
+	OnStep func(step Step)
+}
+
+// Step is one iteration of the loop
+type Step struct {
+	// Iteration counts from 1
+	Iteration int
+	// Message is the model's reply
+	Message infernotools.Message
+	// FinishReason is the model call's finish reason
+	FinishReason string
+	// ToolResults answer Message.ToolCalls, in the same order
+	ToolResults []infernotools.Message
+	// ModelDuration and ToolDuration time the model call and the tools
+	ModelDuration time.Duration
+	ToolDuration  time.Duration
+	Usage         infernotools.Usage
+}
+
+// Result is the transcript of a run
+type Result struct {
+	// Message is the model's final reply
+	Message infernotools.Message
+	// Messages is the whole conversation: the input messages, then every
+	// reply and tool result
+	Messages []infernotools.Message
+	Steps    []Step
+	// Usage sums the token usage of every step
+	Usage infernotools.Usage
+}
+
+// ErrMaxIterations is returned when the model is still calling tools after
+// MaxIterations model calls; the Result holds the run so far
+var ErrMaxIterations = errors.New("infernoagent: model still calling tools after the maximum number of iterations")
+
+// Run drives model with the tools in registry, starting from messages. It
+// returns the transcript along with any error, including ctx's.
+func Run(ctx context.Context, client *inferno.Client, model string, registry *infernotools.Registry, messages []infernotools.Message, opts *Options) (*Result, error) {
+	if opts == nil {
+		opts = &Options{}
+	}
+	maxIterations := opts.MaxIterations
+	if maxIterations <= 0 {
+		maxIterations = DefaultMaxIterations
+	}
+
+	request := &chatRequest{
+		ChatRequest: infernotools.ChatRequest{
+			Model:       model,
+			MaxTokens:   opts.MaxTokens,
+			Temperature: opts.Temperature,
+			Seed:        opts.Seed,
+			ToolChoice:  opts.ToolChoice,
+			Tools:       registry.Definitions(),
+		},
+	}
+	result := &Result{Messages: append([]infernotools.Message(nil), messages...)}
+
+	for iteration := 1; iteration <= maxIterations; iteration++ {
+		request.Messages = result.Messages
+
+		started := time.Now()
+		response, err := callModel(ctx, client, request, opts.OnToken)
+		if err != nil {
+			return result, err
+		}
+		step := Step{
+			Iteration:     iteration,
+			Message:       response.message,
+			FinishReason:  response.finishReason,
+			ModelDuration: time.Since(started),
+		}
+		if response.usage != nil {
+			step.Usage = *response.usage
+		}
+
+		result.Message = step.Message
+		result.Messages = append(result.Messages, step.Message)
+
+		if len(step.Message.ToolCalls) > 0 {
+			started = time.Now()
+			step.ToolResults = runTools(ctx, registry, step.Message.ToolCalls, opts)
+			step.ToolDuration = time.Since(started)
+			result.Messages = append(result.Messages, step.ToolResults...)
+		}
+
+		result.Steps = append(result.Steps, step)
+		result.Usage.PromptTokens += step.Usage.PromptTokens
+		result.Usage.CompletionTokens += step.Usage.CompletionTokens
+		result.Usage.TotalTokens += step.Usage.TotalTokens
+		if opts.OnStep != nil {
+			opts.OnStep(step)
+		}
+
+		if len(step.Message.ToolCalls) == 0 {
+			return result, nil
+		}
+		if err := ctx.Err(); err != nil {
+			return result, err
+		}
+		// A forced tool choice would otherwise never let the model answer
+		request.ToolChoice = nil
+	}
+
+	return result, ErrMaxIterations
+}
+
+// runTools dispatches calls concurrently, at most opts.MaxConcurrency at a
+// time, and returns their results in call order
+func runTools(ctx context.Context, registry *infernotools.Registry, calls []infernotools.ToolCall, opts *Options) []infernotools.Message {
+	results := make([]infernotools.Message, len(calls))
+
+	var slots chan struct{}
+	if opts.MaxConcurrency > 0 {
+		slots = make(chan struct{}, opts.MaxConcurrency)
+	}
+	// Callbacks are serialised so they need no locking of their own
+	var callbackMu sync.Mutex
+
+	var wg sync.WaitGroup
+	for i, call := range calls {
+		wg.Add(1)
+		go func(i int, call infernotools.ToolCall) {
+			defer wg.Done()
+			if slots != nil {
+				slots <- struct{}{}
+				defer func() { <-slots }()
+			}
+
+			if opts.OnToolCall != nil {
+				callbackMu.Lock()
+				opts.OnToolCall(call)
+				callbackMu.Unlock()
+			}
+			results[i] = registry.Dispatch(ctx, call)
+			if opts.OnToolResult != nil {
+				callbackMu.Lock()
+				opts.OnToolResult(call, results[i])
+				callbackMu.Unlock()
+			}
+		}(i, call)
+	}
+	wg.Wait()
+
+	return results
+}
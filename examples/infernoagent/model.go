@@ -0,0 +1,138 @@
+package infernoagent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	inferno "inferno-example"
+	"inferno-example/infernotools"
+)
+
+// Wire types for /v1/chat/completions
+
+type chatRequest struct {
+	infernotools.ChatRequest
+	Stream        bool           `json:"stream,omitempty"`
+	StreamOptions *streamOptions `json:"stream_options,omitempty"`
+}
+
+type streamOptions struct {
+	IncludeUsage bool `json:"include_usage"`
+}
+
+type chatResponse struct {
+	Choices []struct {
+		Message      infernotools.Message `json:"message"`
+		FinishReason string               `json:"finish_reason"`
+	} `json:"choices"`
+	Usage *infernotools.Usage `json:"usage"`
+}
+
+type chatChunk struct {
+	Choices []struct {
+		Delta struct {
+			Content   string                  `json:"content"`
+			ToolCalls []inferno.ToolCallDelta `json:"tool_calls"`
+		} `json:"delta"`
+		FinishReason *string `json:"finish_reason"`
+	} `json:"choices"`
+	Usage *infernotools.Usage `json:"usage"`
+	Error *struct {
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// reply is one model call's outcome
+type reply struct {
+	message      infernotools.Message
+	finishReason string
+	usage        *infernotools.Usage
+}
+
+// callModel asks for the next reply, streamed to onToken when it is set
+func callModel(ctx context.Context, client *inferno.Client, request *chatRequest, onToken func(text string)) (*reply, error) {
+	if onToken != nil {
+		request.Stream = true
+		request.StreamOptions = &streamOptions{IncludeUsage: true}
+		return stream(ctx, client, request, onToken)
+	}
+
+	resp, err := client.RequestContext(ctx, "POST", "/v1/chat/completions", request)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if err := inferno.CheckResponse(resp); err != nil {
+		return nil, err
+	}
+
+	var response chatResponse
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return nil, err
+	}
+	if len(response.Choices) == 0 {
+		return nil, errors.New("infernoagent: no choices in response")
+	}
+
+	return &reply{
+		message:      response.Choices[0].Message,
+		finishReason: response.Choices[0].FinishReason,
+		usage:        response.Usage,
+	}, nil
+}
+
+// stream reads the server-sent chunks of one reply, passing content to
+// onToken as it arrives and assembling tool calls from their fragments
+func stream(ctx context.Context, client *inferno.Client, request *chatRequest, onToken func(text string)) (*reply, error) {
+	resp, err := client.StreamContext(ctx, "POST", "/v1/chat/completions", request)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if err := inferno.CheckResponse(resp); err != nil {
+		return nil, err
+	}
+
+	result := &reply{message: infernotools.Message{Role: "assistant"}}
+	var content strings.Builder
+
+	err = inferno.ReadServerSentEvents(resp.Body, func(data []byte) error {
+		if string(data) == "[DONE]" {
+			return nil
+		}
+
+		var chunk chatChunk
+		if err := json.Unmarshal(data, &chunk); err != nil {
+			return fmt.Errorf("infernoagent: decoding stream chunk: %w", err)
+		}
+		if chunk.Error != nil {
+			return fmt.Errorf("infernoagent: %s", chunk.Error.Message)
+		}
+		if chunk.Usage != nil {
+			result.usage = chunk.Usage
+		}
+
+		for _, choice := range chunk.Choices {
+			if choice.Delta.Content != "" {
+				content.WriteString(choice.Delta.Content)
+				onToken(choice.Delta.Content)
+			}
+			result.message.ToolCalls = inferno.MergeToolCalls(result.message.ToolCalls, choice.Delta.ToolCalls)
+			if choice.FinishReason != nil {
+				result.finishReason = *choice.FinishReason
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	result.message.Content = content.String()
+	return result, nil
+}
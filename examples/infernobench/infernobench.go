@@ -0,0 +1,340 @@
+// Package infernobench drives configurable load against an Inferno server
+// and summarises latency percentiles, throughput and errors.
+//
+// The package does not depend on a particular client: a Target performs one
+// request, and the example client provides one with (*Client).BenchTarget.
+//
+//	report, err := infernobench.Run(ctx, client.BenchTarget("llama-3-8b", 128), infernobench.Config{
+//		Concurrency: 8,
+//		Duration:    time.Minute,
+//		Prompts:     []string{"Summarise the plot of Hamlet."},
+//		Stream:      true,
+//	})
+package infernobench
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Result describes one completed request
+type Result struct {
+	// TimeToFirstToken is only meaningful for streamed requests
+	TimeToFirstToken time.Duration
+	// Tokens is the number of completion tokens generated
+	Tokens int
+}
+
+// Target performs a single request for prompt
+type Target func(ctx context.Context, prompt string, stream bool) (Result, error)
+
+// Config controls the shape of the load
+type Config struct {
+	// Concurrency is the number of closed-loop workers, each issuing its
+	// next request as soon as the previous one finishes. Ignored when Rate
+	// is set.
+	Concurrency int
+	// Rate switches to an open loop issuing this many requests per second
+	// regardless of how quickly the server answers
+	Rate float64
+	// MaxInFlight bounds outstanding open-loop requests; arrivals beyond it
+	// are counted as "overloaded" errors. Zero means no bound.
+	MaxInFlight int
+	// Duration stops issuing new requests once elapsed (zero: no limit)
+	Duration time.Duration
+	// Requests stops after this many requests have been issued (zero: no limit)
+	Requests int
+	// Prompts is the corpus; requests cycle through it in order
+	Prompts []string
+	// Stream requests streamed responses so time-to-first-token is measured
+	Stream bool
+	// RequestTimeout bounds each request (zero: no per-request bound)
+	RequestTimeout time.Duration
+	// ClassifyError names an error's category in the report's breakdown.
+	// Defaults to "timeout", "canceled" or "error".
+	ClassifyError func(error) string
+}
+
+func (c *Config) validate() error {
+	if len(c.Prompts) == 0 {
+		return errors.New("infernobench: at least one prompt is required")
+	}
+	if c.Duration <= 0 && c.Requests <= 0 {
+		return errors.New("infernobench: set Duration or Requests so the run ends")
+	}
+	if c.Rate < 0 || c.Concurrency < 0 || c.MaxInFlight < 0 {
+		return errors.New("infernobench: Rate, Concurrency and MaxInFlight must not be negative")
+	}
+	return nil
+}
+
+// Percentiles summarises a latency distribution
+type Percentiles struct {
+	Mean time.Duration `json:"mean"`
+	P50  time.Duration `json:"p50"`
+	P90  time.Duration `json:"p90"`
+	P95  time.Duration `json:"p95"`
+	P99  time.Duration `json:"p99"`
+	Max  time.Duration `json:"max"`
+}
+
+// Report is the outcome of a run
+type Report struct {
+	Mode        string        `json:"mode"`
+	Stream      bool          `json:"stream"`
+	StartedAt   time.Time     `json:"started_at"`
+	Elapsed     time.Duration `json:"elapsed"`
+	Requests    int           `json:"requests"`
+	Succeeded   int           `json:"succeeded"`
+	Failed      int           `json:"failed"`
+	TotalTokens int           `json:"total_tokens"`
+	// Throughput is successful requests per second
+	Throughput      float64     `json:"throughput"`
+	TokensPerSecond float64     `json:"tokens_per_second"`
+	Latency         Percentiles `json:"latency"`
+	// TimeToFirstToken is only populated for streamed runs
+	TimeToFirstToken *Percentiles   `json:"time_to_first_token,omitempty"`
+	Errors           map[string]int `json:"errors"`
+}
+
+// ErrorRate is the fraction of requests that failed
+func (r *Report) ErrorRate() float64 {
+	if r.Requests == 0 {
+		return 0
+	}
+	return float64(r.Failed) / float64(r.Requests)
+}
+
+type sample struct {
+	latency time.Duration
+	result  Result
+	err     error
+}
+
+// Run drives load against target until the configured duration or request
+// count is reached (or ctx is done), waits for outstanding requests and
+// returns the report
+func Run(ctx context.Context, target Target, cfg Config) (*Report, error) {
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	if cfg.Rate == 0 && cfg.Concurrency == 0 {
+		cfg.Concurrency = 1
+	}
+	if cfg.ClassifyError == nil {
+		cfg.ClassifyError = defaultClassify
+	}
+
+	runCtx := ctx
+	if cfg.Duration > 0 {
+		var cancel context.CancelFunc
+		runCtx, cancel = context.WithTimeout(ctx, cfg.Duration)
+		defer cancel()
+	}
+
+	var (
+		mu      sync.Mutex
+		samples []sample
+		issued  int
+		wg      sync.WaitGroup
+	)
+
+	// next reserves the next request slot, returning its prompt
+	next := func() (string, bool) {
+		mu.Lock()
+		defer mu.Unlock()
+		if cfg.Requests > 0 && issued >= cfg.Requests {
+			return "", false
+		}
+		prompt := cfg.Prompts[issued%len(cfg.Prompts)]
+		issued++
+		return prompt, true
+	}
+	record := func(s sample) {
+		mu.Lock()
+		samples = append(samples, s)
+		mu.Unlock()
+	}
+	// do issues one request; latency is measured from scheduled so open-loop
+	// runs include time spent waiting behind a slow server
+	do := func(prompt string, scheduled time.Time) {
+		reqCtx := ctx
+		if cfg.RequestTimeout > 0 {
+			var cancel context.CancelFunc
+			reqCtx, cancel = context.WithTimeout(ctx, cfg.RequestTimeout)
+			defer cancel()
+		}
+		result, err := target(reqCtx, prompt, cfg.Stream)
+		record(sample{latency: time.Since(scheduled), result: result, err: err})
+	}
+
+	started := time.Now()
+	mode := "closed"
+	if cfg.Rate > 0 {
+		mode = "open"
+		runOpenLoop(runCtx, cfg, next, do, record, &wg)
+	} else {
+		for i := 0; i < cfg.Concurrency; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for runCtx.Err() == nil {
+					prompt, ok := next()
+					if !ok {
+						return
+					}
+					do(prompt, time.Now())
+				}
+			}()
+		}
+	}
+	wg.Wait()
+
+	return buildReport(mode, cfg, started, time.Since(started), samples), nil
+}
+
+func runOpenLoop(ctx context.Context, cfg Config, next func() (string, bool), do func(string, time.Time), record func(sample), wg *sync.WaitGroup) {
+	interval := time.Duration(float64(time.Second) / cfg.Rate)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var inFlight chan struct{}
+	if cfg.MaxInFlight > 0 {
+		inFlight = make(chan struct{}, cfg.MaxInFlight)
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case scheduled := <-ticker.C:
+			prompt, ok := next()
+			if !ok {
+				return
+			}
+			if inFlight != nil {
+				select {
+				case inFlight <- struct{}{}:
+				default:
+					record(sample{err: errOverloaded})
+					continue
+				}
+			}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if inFlight != nil {
+					defer func() { <-inFlight }()
+				}
+				do(prompt, scheduled)
+			}()
+		}
+	}
+}
+
+var errOverloaded = errors.New("infernobench: too many requests in flight")
+
+func defaultClassify(err error) string {
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return "timeout"
+	case errors.Is(err, context.Canceled):
+		return "canceled"
+	default:
+		return "error"
+	}
+}
+
+func buildReport(mode string, cfg Config, started time.Time, elapsed time.Duration, samples []sample) *Report {
+	report := &Report{
+		Mode:      mode,
+		Stream:    cfg.Stream,
+		StartedAt: started,
+		Elapsed:   elapsed,
+		Requests:  len(samples),
+		Errors:    map[string]int{},
+	}
+
+	var latencies, ttfts []time.Duration
+	for _, s := range samples {
+		if s.err != nil {
+			report.Failed++
+			if errors.Is(s.err, errOverloaded) {
+				report.Errors["overloaded"]++
+			} else {
+				report.Errors[cfg.ClassifyError(s.err)]++
+			}
+			continue
+		}
+		report.Succeeded++
+		report.TotalTokens += s.result.Tokens
+		latencies = append(latencies, s.latency)
+		if cfg.Stream {
+			ttfts = append(ttfts, s.result.TimeToFirstToken)
+		}
+	}
+
+	if seconds := elapsed.Seconds(); seconds > 0 {
+		report.Throughput = float64(report.Succeeded) / seconds
+		report.TokensPerSecond = float64(report.TotalTokens) / seconds
+	}
+	report.Latency = percentiles(latencies)
+	if cfg.Stream {
+		p := percentiles(ttfts)
+		report.TimeToFirstToken = &p
+	}
+
+	return report
+}
+
+func percentiles(values []time.Duration) Percentiles {
+	if len(values) == 0 {
+		return Percentiles{}
+	}
+	sorted := append([]time.Duration(nil), values...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	var total time.Duration
+	for _, v := range sorted {
+		total += v
+	}
+	at := func(q float64) time.Duration {
+		idx := int(math.Ceil(q*float64(len(sorted)))) - 1
+		if idx < 0 {
+			idx = 0
+		}
+		return sorted[idx]
+	}
+
+	return Percentiles{
+		Mean: total / time.Duration(len(sorted)),
+		P50:  at(0.50),
+		P90:  at(0.90),
+		P95:  at(0.95),
+		P99:  at(0.99),
+		Max:  sorted[len(sorted)-1],
+	}
+}
+
+// String renders a short plain-text summary
+func (r *Report) String() string {
+	s := fmt.Sprintf("%s loop, %d requests in %s: %d ok, %d failed (%.1f%%)\n",
+		r.Mode, r.Requests, r.Elapsed.Round(time.Millisecond), r.Succeeded, r.Failed, r.ErrorRate()*100)
+	s += fmt.Sprintf("throughput %.2f req/s, %.1f tokens/s\n", r.Throughput, r.TokensPerSecond)
+	s += fmt.Sprintf("latency p50 %s  p90 %s  p99 %s  max %s\n",
+		r.Latency.P50.Round(time.Millisecond), r.Latency.P90.Round(time.Millisecond),
+		r.Latency.P99.Round(time.Millisecond), r.Latency.Max.Round(time.Millisecond))
+	if r.TimeToFirstToken != nil {
+		s += fmt.Sprintf("time to first token p50 %s  p99 %s\n",
+			r.TimeToFirstToken.P50.Round(time.Millisecond), r.TimeToFirstToken.P99.Round(time.Millisecond))
+	}
+	for category, count := range r.Errors {
+		s += fmt.Sprintf("error %s: %d\n", category, count)
+	}
+	return s
+}
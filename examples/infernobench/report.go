@@ -0,0 +1,57 @@
+package infernobench
+
+import (
+	"fmt"
+	"html/template"
+	"io"
+	"time"
+)
+
+var reportTemplate = template.Must(template.New("report").Funcs(template.FuncMap{
+	"ms":  func(d time.Duration) string { return d.Round(time.Millisecond).String() },
+	"pct": func(f float64) string { return fmt.Sprintf("%.1f%%", f*100) },
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Inferno load test {{.StartedAt.Format "2006-01-02 15:04:05"}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; margin-bottom: 1.5em; }
+th, td { border: 1px solid #ccc; padding: 4px 10px; text-align: right; }
+th { background: #f4f4f4; text-align: left; }
+</style>
+</head>
+<body>
+<h1>Inferno load test</h1>
+<p>{{.Mode}} loop, stream={{.Stream}}, started {{.StartedAt.Format "2006-01-02 15:04:05 MST"}}, ran {{ms .Elapsed}}</p>
+
+<h2>Summary</h2>
+<table>
+<tr><th>Requests</th><td>{{.Requests}}</td></tr>
+<tr><th>Succeeded</th><td>{{.Succeeded}}</td></tr>
+<tr><th>Failed</th><td>{{.Failed}} ({{pct .ErrorRate}})</td></tr>
+<tr><th>Throughput</th><td>{{printf "%.2f" .Throughput}} req/s</td></tr>
+<tr><th>Tokens</th><td>{{.TotalTokens}} ({{printf "%.1f" .TokensPerSecond}} tokens/s)</td></tr>
+</table>
+
+<h2>Latency</h2>
+<table>
+<tr><th></th><th>Mean</th><th>p50</th><th>p90</th><th>p95</th><th>p99</th><th>Max</th></tr>
+<tr><th>Request</th><td>{{ms .Latency.Mean}}</td><td>{{ms .Latency.P50}}</td><td>{{ms .Latency.P90}}</td><td>{{ms .Latency.P95}}</td><td>{{ms .Latency.P99}}</td><td>{{ms .Latency.Max}}</td></tr>
+{{with .TimeToFirstToken}}<tr><th>First token</th><td>{{ms .Mean}}</td><td>{{ms .P50}}</td><td>{{ms .P90}}</td><td>{{ms .P95}}</td><td>{{ms .P99}}</td><td>{{ms .Max}}</td></tr>{{end}}
+</table>
+
+{{if .Errors}}<h2>Errors</h2>
+<table>
+<tr><th>Category</th><th>Count</th></tr>
+{{range $category, $count := .Errors}}<tr><th>{{$category}}</th><td>{{$count}}</td></tr>
+{{end}}</table>{{end}}
+</body>
+</html>
+`))
+
+// WriteHTML renders the report as a standalone HTML page
+func (r *Report) WriteHTML(w io.Writer) error {
+	return reportTemplate.Execute(w, r)
+}
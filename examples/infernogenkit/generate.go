@@ -0,0 +1,354 @@
+package infernogenkit
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/firebase/genkit/go/ai"
+
+	inferno "inferno-example"
+)
+
+// Wire types for /v1/chat/completions
+
+type chatRequest struct {
+	Model         string         `json:"model"`
+	Messages      []chatMessage  `json:"messages"`
+	MaxTokens     int            `json:"max_tokens,omitempty"`
+	Temperature   float64        `json:"temperature,omitempty"`
+	TopP          float64        `json:"top_p,omitempty"`
+	TopK          int            `json:"top_k,omitempty"`
+	Stop          []string       `json:"stop,omitempty"`
+	Tools         []tool         `json:"tools,omitempty"`
+	ToolChoice    string         `json:"tool_choice,omitempty"`
+	Stream        bool           `json:"stream,omitempty"`
+	StreamOptions *streamOptions `json:"stream_options,omitempty"`
+}
+
+type streamOptions struct {
+	IncludeUsage bool `json:"include_usage"`
+}
+
+type chatMessage struct {
+	Role       string             `json:"role"`
+	Content    string             `json:"content"`
+	Name       string             `json:"name,omitempty"`
+	ToolCalls  []inferno.ToolCall `json:"tool_calls,omitempty"`
+	ToolCallID string             `json:"tool_call_id,omitempty"`
+}
+
+type tool struct {
+	Type     string             `json:"type"`
+	Function functionDefinition `json:"function"`
+}
+
+type functionDefinition struct {
+	Name        string         `json:"name"`
+	Description string         `json:"description,omitempty"`
+	Parameters  map[string]any `json:"parameters,omitempty"`
+}
+
+type usage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	TotalTokens      int `json:"total_tokens"`
+}
+
+type chatChoice struct {
+	Message      chatMessage `json:"message"`
+	FinishReason string      `json:"finish_reason"`
+}
+
+type chatResponse struct {
+	Choices []chatChoice `json:"choices"`
+	Usage   *usage       `json:"usage"`
+}
+
+type chatChunk struct {
+	Choices []struct {
+		Delta struct {
+			Content   string                  `json:"content"`
+			ToolCalls []inferno.ToolCallDelta `json:"tool_calls"`
+		} `json:"delta"`
+		FinishReason *string `json:"finish_reason"`
+	} `json:"choices"`
+	Usage *usage `json:"usage"`
+	Error *struct {
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// generate serves one Genkit model request, streaming text to cb when set
+func (i *Inferno) generate(ctx context.Context, model string, req *ai.ModelRequest, cb ai.ModelStreamCallback) (*ai.ModelResponse, error) {
+	request, err := chatRequestFor(model, req)
+	if err != nil {
+		return nil, err
+	}
+
+	var choice *chatChoice
+	var tokens *usage
+	if cb != nil {
+		request.Stream = true
+		request.StreamOptions = &streamOptions{IncludeUsage: true}
+		choice, tokens, err = i.stream(ctx, request, cb)
+	} else {
+		choice, tokens, err = i.complete(ctx, request)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return modelResponse(req, choice, tokens)
+}
+
+func chatRequestFor(model string, req *ai.ModelRequest) (*chatRequest, error) {
+	request := &chatRequest{Model: model, ToolChoice: string(req.ToolChoice)}
+
+	config, err := generationConfig(req.Config)
+	if err != nil {
+		return nil, err
+	}
+	if config != nil {
+		request.MaxTokens = config.MaxOutputTokens
+		request.Temperature = config.Temperature
+		request.TopP = config.TopP
+		request.TopK = config.TopK
+		request.Stop = config.StopSequences
+		if config.Version != "" {
+			request.Model = config.Version
+		}
+	}
+
+	for _, definition := range req.Tools {
+		request.Tools = append(request.Tools, tool{Type: "function", Function: functionDefinition{
+			Name:        definition.Name,
+			Description: definition.Description,
+			Parameters:  definition.InputSchema,
+		}})
+	}
+
+	for _, message := range req.Messages {
+		converted, err := chatMessages(message)
+		if err != nil {
+			return nil, err
+		}
+		request.Messages = append(request.Messages, converted...)
+	}
+
+	return request, nil
+}
+
+// generationConfig accepts the config forms Genkit passes: the common
+// config by value or pointer, or a map decoded from JSON
+func generationConfig(config any) (*ai.GenerationCommonConfig, error) {
+	switch config := config.(type) {
+	case nil:
+		return nil, nil
+	case *ai.GenerationCommonConfig:
+		return config, nil
+	case ai.GenerationCommonConfig:
+		return &config, nil
+	case map[string]any:
+		encoded, err := json.Marshal(config)
+		if err != nil {
+			return nil, err
+		}
+		var common ai.GenerationCommonConfig
+		if err := json.Unmarshal(encoded, &common); err != nil {
+			return nil, fmt.Errorf("infernogenkit: invalid config: %w", err)
+		}
+		return &common, nil
+	default:
+		return nil, fmt.Errorf("infernogenkit: unsupported config type %T", config)
+	}
+}
+
+// chatMessages converts one Genkit message; a tool message carrying several
+// responses becomes one "tool" message per response
+func chatMessages(message *ai.Message) ([]chatMessage, error) {
+	var role string
+	switch message.Role {
+	case ai.RoleSystem:
+		role = "system"
+	case ai.RoleUser:
+		role = "user"
+	case ai.RoleModel:
+		role = "assistant"
+	case ai.RoleTool:
+		role = "tool"
+	default:
+		return nil, fmt.Errorf("infernogenkit: unsupported message role %q", message.Role)
+	}
+
+	converted := chatMessage{Role: role}
+	var responses []chatMessage
+	for index, part := range message.Content {
+		switch {
+		case part.IsText():
+			converted.Content += part.Text
+		case part.IsToolRequest():
+			arguments, err := json.Marshal(part.ToolRequest.Input)
+			if err != nil {
+				return nil, fmt.Errorf("infernogenkit: encoding %s input: %w", part.ToolRequest.Name, err)
+			}
+			id := part.ToolRequest.Ref
+			if id == "" {
+				id = fmt.Sprintf("call_%d", index)
+			}
+			converted.ToolCalls = append(converted.ToolCalls, inferno.ToolCall{
+				ID:       id,
+				Type:     "function",
+				Function: inferno.FunctionCall{Name: part.ToolRequest.Name, Arguments: string(arguments)},
+			})
+		case part.IsToolResponse():
+			output, err := toolOutput(part.ToolResponse.Output)
+			if err != nil {
+				return nil, fmt.Errorf("infernogenkit: encoding %s output: %w", part.ToolResponse.Name, err)
+			}
+			responses = append(responses, chatMessage{
+				Role:       "tool",
+				Content:    output,
+				Name:       part.ToolResponse.Name,
+				ToolCallID: part.ToolResponse.Ref,
+			})
+		default:
+			return nil, fmt.Errorf("infernogenkit: only text and tool parts are supported, got %q", part.ContentType)
+		}
+	}
+
+	if len(responses) > 0 {
+		return responses, nil
+	}
+	return []chatMessage{converted}, nil
+}
+
+// toolOutput sends string outputs as they are and anything else as JSON
+func toolOutput(output any) (string, error) {
+	if text, ok := output.(string); ok {
+		return text, nil
+	}
+	encoded, err := json.Marshal(output)
+	return string(encoded), err
+}
+
+func modelResponse(req *ai.ModelRequest, choice *chatChoice, tokens *usage) (*ai.ModelResponse, error) {
+	var content []*ai.Part
+	if choice.Message.Content != "" {
+		content = append(content, ai.NewTextPart(choice.Message.Content))
+	}
+	for _, call := range choice.Message.ToolCalls {
+		var input any
+		if err := json.Unmarshal([]byte(call.Function.Arguments), &input); err != nil {
+			return nil, fmt.Errorf("infernogenkit: %s arguments are not JSON: %w", call.Function.Name, err)
+		}
+		content = append(content, ai.NewToolRequestPart(&ai.ToolRequest{
+			Name:  call.Function.Name,
+			Input: input,
+			Ref:   call.ID,
+		}))
+	}
+
+	response := &ai.ModelResponse{
+		Request:      req,
+		Message:      &ai.Message{Role: ai.RoleModel, Content: content},
+		FinishReason: finishReason(choice.FinishReason),
+	}
+	if tokens != nil {
+		response.Usage = &ai.GenerationUsage{
+			InputTokens:  tokens.PromptTokens,
+			OutputTokens: tokens.CompletionTokens,
+			TotalTokens:  tokens.TotalTokens,
+		}
+	}
+	return response, nil
+}
+
+func finishReason(reason string) ai.FinishReason {
+	switch reason {
+	case "stop", "tool_calls":
+		return ai.FinishReasonStop
+	case "length":
+		return ai.FinishReasonLength
+	case "":
+		return ai.FinishReasonUnknown
+	default:
+		// "cancelled" and "timeout"
+		return ai.FinishReasonOther
+	}
+}
+
+func (i *Inferno) complete(ctx context.Context, request *chatRequest) (*chatChoice, *usage, error) {
+	resp, err := i.Client.RequestContext(ctx, "POST", "/v1/chat/completions", request)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+
+	if err := inferno.CheckResponse(resp); err != nil {
+		return nil, nil, err
+	}
+
+	var response chatResponse
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return nil, nil, err
+	}
+	if len(response.Choices) == 0 {
+		return nil, nil, fmt.Errorf("infernogenkit: no choices in response")
+	}
+
+	return &response.Choices[0], response.Usage, nil
+}
+
+// stream reads the server-sent chunks into a single choice, passing text to
+// cb as it arrives
+func (i *Inferno) stream(ctx context.Context, request *chatRequest, cb ai.ModelStreamCallback) (*chatChoice, *usage, error) {
+	resp, err := i.Client.StreamContext(ctx, "POST", "/v1/chat/completions", request)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+
+	if err := inferno.CheckResponse(resp); err != nil {
+		return nil, nil, err
+	}
+
+	choice := &chatChoice{Message: chatMessage{Role: "assistant"}}
+	var tokens *usage
+	err = inferno.ReadServerSentEvents(resp.Body, func(data []byte) error {
+		if string(data) == "[DONE]" {
+			return nil
+		}
+
+		var chunk chatChunk
+		if err := json.Unmarshal(data, &chunk); err != nil {
+			return fmt.Errorf("infernogenkit: decoding stream chunk: %w", err)
+		}
+		if chunk.Error != nil {
+			return fmt.Errorf("infernogenkit: %s", chunk.Error.Message)
+		}
+		if chunk.Usage != nil {
+			tokens = chunk.Usage
+		}
+
+		for _, delta := range chunk.Choices {
+			if delta.Delta.Content != "" {
+				choice.Message.Content += delta.Delta.Content
+				streamed := &ai.ModelResponseChunk{Content: []*ai.Part{ai.NewTextPart(delta.Delta.Content)}}
+				if err := cb(ctx, streamed); err != nil {
+					return err
+				}
+			}
+			choice.Message.ToolCalls = inferno.MergeToolCalls(choice.Message.ToolCalls, delta.Delta.ToolCalls)
+			if delta.FinishReason != nil {
+				choice.FinishReason = *delta.FinishReason
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return choice, tokens, nil
+}
@@ -0,0 +1,197 @@
+// Package infernogenkit is a Firebase Genkit plugin that registers Inferno
+// models and embedders as Genkit actions, so flows can run entirely against
+// a local Inferno deployment. Models support multi-turn chat, system
+// prompts, streaming and tool calling; they are served by
+// /v1/chat/completions and embedders by /v1/embeddings.
+//
+//	client := inferno.NewClient("http://localhost:8080", apiKey)
+//	g, err := genkit.Init(ctx, genkit.WithPlugins(&infernogenkit.Inferno{
+//		Client:    client,
+//		Embedders: []string{"nomic-embed-text"},
+//	}))
+//	resp, err := genkit.Generate(ctx, g,
+//		ai.WithModel(infernogenkit.Model(g, "llama-3-8b")),
+//		ai.WithPrompt("Name three primes."))
+//
+// The plugin is written against github.com/firebase/genkit/go v0.5.1 and
+// does not build against v1, whose plugin API differs; require that version
+// with go get github.com/firebase/genkit/go@v0.5.1. Tool calls are parsed
+// by the server from the model's output, so they work best with models
+// trained on function calling.
+package infernogenkit
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/firebase/genkit/go/ai"
+	"github.com/firebase/genkit/go/genkit"
+
+	inferno "inferno-example"
+)
+
+const provider = "inferno"
+
+// Inferno is the Genkit plugin. Its actions are named "inferno/<model>".
+type Inferno struct {
+	Client *inferno.Client
+	// Models to register at Init; empty registers every model the server
+	// lists at /v1/models. Routing aliases may be named here too.
+	Models []string
+	// Embedders to register at Init
+	Embedders []string
+}
+
+// Name returns the plugin's provider name
+func (i *Inferno) Name() string {
+	return provider
+}
+
+// Init registers the plugin's models and embedders with g
+func (i *Inferno) Init(ctx context.Context, g *genkit.Genkit) error {
+	if i.Client == nil {
+		return fmt.Errorf("infernogenkit: Inferno.Client is required")
+	}
+
+	models := i.Models
+	if len(models) == 0 {
+		listed, err := i.listModels(ctx)
+		if err != nil {
+			return fmt.Errorf("infernogenkit: listing models: %w", err)
+		}
+		models = listed
+	}
+
+	for _, name := range models {
+		i.DefineModel(g, name)
+	}
+	for _, name := range i.Embedders {
+		i.DefineEmbedder(g, name)
+	}
+
+	return nil
+}
+
+// DefineModel registers an Inferno model, or returns it if it is already
+// registered
+func (i *Inferno) DefineModel(g *genkit.Genkit, name string) ai.Model {
+	if model := genkit.LookupModel(g, provider, name); model != nil {
+		return model
+	}
+
+	info := &ai.ModelInfo{
+		Label: "Inferno - " + name,
+		Supports: &ai.ModelSupports{
+			Multiturn:  true,
+			SystemRole: true,
+			Tools:      true,
+			ToolChoice: true,
+		},
+	}
+	return genkit.DefineModel(g, provider, name, info, func(ctx context.Context, req *ai.ModelRequest, cb ai.ModelStreamCallback) (*ai.ModelResponse, error) {
+		return i.generate(ctx, name, req, cb)
+	})
+}
+
+// DefineEmbedder registers an Inferno embedding model
+func (i *Inferno) DefineEmbedder(g *genkit.Genkit, name string) ai.Embedder {
+	return genkit.DefineEmbedder(g, provider, name, func(ctx context.Context, req *ai.EmbedRequest) (*ai.EmbedResponse, error) {
+		return i.embed(ctx, name, req)
+	})
+}
+
+// Model returns the Inferno model registered under name
+func Model(g *genkit.Genkit, name string) ai.Model {
+	return genkit.LookupModel(g, provider, name)
+}
+
+// Embedder returns the Inferno embedder registered under name
+func Embedder(g *genkit.Genkit, name string) ai.Embedder {
+	return genkit.LookupEmbedder(g, provider, name)
+}
+
+func (i *Inferno) listModels(ctx context.Context) ([]string, error) {
+	resp, err := i.Client.RequestContext(ctx, "GET", "/v1/models", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if err := inferno.CheckResponse(resp); err != nil {
+		return nil, err
+	}
+
+	var list struct {
+		Data []struct {
+			ID string `json:"id"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(list.Data))
+	for _, model := range list.Data {
+		names = append(names, model.ID)
+	}
+	return names, nil
+}
+
+func (i *Inferno) embed(ctx context.Context, model string, req *ai.EmbedRequest) (*ai.EmbedResponse, error) {
+	input := make([]string, len(req.Input))
+	for index, document := range req.Input {
+		input[index] = textOf(document.Content)
+	}
+
+	request := struct {
+		Model string   `json:"model"`
+		Input []string `json:"input"`
+	}{model, input}
+
+	resp, err := i.Client.RequestContext(ctx, "POST", "/v1/embeddings", request)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if err := inferno.CheckResponse(resp); err != nil {
+		return nil, err
+	}
+
+	var response struct {
+		Data []struct {
+			Embedding []float32 `json:"embedding"`
+			Index     int       `json:"index"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return nil, err
+	}
+
+	embeddings := make([]*ai.Embedding, len(input))
+	for _, data := range response.Data {
+		if data.Index < 0 || data.Index >= len(embeddings) {
+			return nil, fmt.Errorf("infernogenkit: embedding index %d out of range", data.Index)
+		}
+		embeddings[data.Index] = &ai.Embedding{Embedding: data.Embedding}
+	}
+	for index, embedding := range embeddings {
+		if embedding == nil {
+			return nil, fmt.Errorf("infernogenkit: no embedding returned for input %d", index)
+		}
+	}
+
+	return &ai.EmbedResponse{Embeddings: embeddings}, nil
+}
+
+// textOf joins the text parts of content
+func textOf(content []*ai.Part) string {
+	var text string
+	for _, part := range content {
+		if part.IsText() {
+			text += part.Text
+		}
+	}
+	return text
+}
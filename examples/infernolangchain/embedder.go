@@ -0,0 +1,114 @@
+package infernolangchain
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/tmc/langchaingo/embeddings"
+
+	inferno "inferno-example"
+)
+
+// DefaultBatchSize is how many texts an Embedder sends per request
+const DefaultBatchSize = 64
+
+// Embedder is an embeddings.Embedder served by Inferno
+type Embedder struct {
+	client *inferno.Client
+	model  string
+
+	// BatchSize caps the texts sent per request (zero: DefaultBatchSize)
+	BatchSize int
+	// StripNewLines replaces newlines with spaces before embedding, which
+	// some embedding models expect
+	StripNewLines bool
+}
+
+var _ embeddings.Embedder = (*Embedder)(nil)
+
+// NewEmbedder returns an Embedder using model
+func NewEmbedder(client *inferno.Client, model string) *Embedder {
+	return &Embedder{client: client, model: model}
+}
+
+// EmbedDocuments embeds texts in batches, returning one vector per text in
+// order
+func (e *Embedder) EmbedDocuments(ctx context.Context, texts []string) ([][]float32, error) {
+	batchSize := e.BatchSize
+	if batchSize <= 0 {
+		batchSize = DefaultBatchSize
+	}
+
+	vectors := make([][]float32, 0, len(texts))
+	for start := 0; start < len(texts); start += batchSize {
+		end := min(start+batchSize, len(texts))
+		batch, err := e.embed(ctx, texts[start:end])
+		if err != nil {
+			return nil, err
+		}
+		vectors = append(vectors, batch...)
+	}
+
+	return vectors, nil
+}
+
+// EmbedQuery embeds a single text
+func (e *Embedder) EmbedQuery(ctx context.Context, text string) ([]float32, error) {
+	vectors, err := e.embed(ctx, []string{text})
+	if err != nil {
+		return nil, err
+	}
+
+	return vectors[0], nil
+}
+
+func (e *Embedder) embed(ctx context.Context, texts []string) ([][]float32, error) {
+	input := texts
+	if e.StripNewLines {
+		input = make([]string, len(texts))
+		for i, text := range texts {
+			input[i] = strings.ReplaceAll(text, "\n", " ")
+		}
+	}
+
+	request := struct {
+		Model string   `json:"model"`
+		Input []string `json:"input"`
+	}{e.model, input}
+
+	resp, err := e.client.RequestContext(ctx, "POST", "/v1/embeddings", request)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if err := inferno.CheckResponse(resp); err != nil {
+		return nil, err
+	}
+
+	var response struct {
+		Data []struct {
+			Embedding []float32 `json:"embedding"`
+			Index     int       `json:"index"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return nil, err
+	}
+	if len(response.Data) != len(texts) {
+		return nil, fmt.Errorf("infernolangchain: got %d embeddings for %d texts", len(response.Data), len(texts))
+	}
+
+	sort.Slice(response.Data, func(i, j int) bool {
+		return response.Data[i].Index < response.Data[j].Index
+	})
+	vectors := make([][]float32, len(response.Data))
+	for i, data := range response.Data {
+		vectors[i] = data.Embedding
+	}
+
+	return vectors, nil
+}
@@ -0,0 +1,388 @@
+// Package infernolangchain plugs Inferno into LangChainGo: LLM implements
+// llms.Model over /v1/chat/completions, with streaming and tool calls, and
+// Embedder implements embeddings.Embedder over /v1/embeddings.
+//
+// The package sends its requests through the example Go client, so it
+// shares the client's base URL, API key and HTTP settings:
+//
+//	client := inferno.NewClient("http://localhost:8080", apiKey)
+//	llm := infernolangchain.New(client, "llama-3-8b")
+//	answer, err := llms.GenerateFromSinglePrompt(ctx, llm, "Name three primes.")
+//
+//	embedder := infernolangchain.NewEmbedder(client, "nomic-embed-text")
+//	store, err := chroma.New(chroma.WithEmbedder(embedder))
+//
+// Tool calls are parsed by the server from the model's output, so they work
+// best with models trained on function calling.
+package infernolangchain
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/tmc/langchaingo/llms"
+
+	inferno "inferno-example"
+)
+
+// LLM is an llms.Model served by Inferno
+type LLM struct {
+	client *inferno.Client
+	model  string
+}
+
+var _ llms.Model = (*LLM)(nil)
+
+// New returns an LLM generating with model, which may be a routing alias.
+// llms.WithModel overrides it per call.
+func New(client *inferno.Client, model string) *LLM {
+	return &LLM{client: client, model: model}
+}
+
+// Call generates a completion for a single prompt
+func (l *LLM) Call(ctx context.Context, prompt string, options ...llms.CallOption) (string, error) {
+	return llms.GenerateFromSinglePrompt(ctx, l, prompt, options...)
+}
+
+// GenerateContent sends messages as a chat completion. With
+// llms.WithStreamingFunc set the response is streamed and the function
+// receives the content as it is generated; tool calls arrive whole in the
+// returned choice either way. Zero-valued numeric options leave the server's
+// defaults in place, and image or binary parts are rejected.
+func (l *LLM) GenerateContent(ctx context.Context, messages []llms.MessageContent, options ...llms.CallOption) (*llms.ContentResponse, error) {
+	opts := llms.CallOptions{}
+	for _, option := range options {
+		option(&opts)
+	}
+
+	request, err := l.chatRequest(messages, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	var response *chatResponse
+	if opts.StreamingFunc != nil {
+		request.Stream = true
+		request.StreamOptions = &streamOptions{IncludeUsage: true}
+		response, err = l.stream(ctx, request, opts.StreamingFunc)
+	} else {
+		response, err = l.complete(ctx, request)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return contentResponse(response), nil
+}
+
+// Wire types for /v1/chat/completions
+
+type chatRequest struct {
+	Model            string         `json:"model"`
+	Messages         []chatMessage  `json:"messages"`
+	MaxTokens        int            `json:"max_tokens,omitempty"`
+	Temperature      float64        `json:"temperature,omitempty"`
+	TopP             float64        `json:"top_p,omitempty"`
+	TopK             int            `json:"top_k,omitempty"`
+	Stop             []string       `json:"stop,omitempty"`
+	Seed             *int           `json:"seed,omitempty"`
+	PresencePenalty  float64        `json:"presence_penalty,omitempty"`
+	FrequencyPenalty float64        `json:"frequency_penalty,omitempty"`
+	Tools            []tool         `json:"tools,omitempty"`
+	ToolChoice       interface{}    `json:"tool_choice,omitempty"`
+	Stream           bool           `json:"stream,omitempty"`
+	StreamOptions    *streamOptions `json:"stream_options,omitempty"`
+}
+
+type streamOptions struct {
+	IncludeUsage bool `json:"include_usage"`
+}
+
+type chatMessage struct {
+	Role       string             `json:"role"`
+	Content    string             `json:"content"`
+	Name       string             `json:"name,omitempty"`
+	ToolCalls  []inferno.ToolCall `json:"tool_calls,omitempty"`
+	ToolCallID string             `json:"tool_call_id,omitempty"`
+}
+
+type tool struct {
+	Type     string             `json:"type"`
+	Function functionDefinition `json:"function"`
+}
+
+type functionDefinition struct {
+	Name        string      `json:"name"`
+	Description string      `json:"description,omitempty"`
+	Parameters  interface{} `json:"parameters,omitempty"`
+}
+
+type usage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	TotalTokens      int `json:"total_tokens"`
+}
+
+type chatChoice struct {
+	Message      chatMessage `json:"message"`
+	FinishReason string      `json:"finish_reason"`
+}
+
+type chatResponse struct {
+	Model   string       `json:"model"`
+	Choices []chatChoice `json:"choices"`
+	Usage   *usage       `json:"usage"`
+}
+
+type chatChunk struct {
+	Choices []struct {
+		Delta struct {
+			Content   string                  `json:"content"`
+			ToolCalls []inferno.ToolCallDelta `json:"tool_calls"`
+		} `json:"delta"`
+		FinishReason *string `json:"finish_reason"`
+	} `json:"choices"`
+	Usage *usage `json:"usage"`
+	Error *struct {
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+func (l *LLM) chatRequest(messages []llms.MessageContent, opts llms.CallOptions) (*chatRequest, error) {
+	request := &chatRequest{
+		Model:            l.model,
+		MaxTokens:        opts.MaxTokens,
+		Temperature:      opts.Temperature,
+		TopP:             opts.TopP,
+		TopK:             opts.TopK,
+		Stop:             opts.StopWords,
+		PresencePenalty:  opts.PresencePenalty,
+		FrequencyPenalty: opts.FrequencyPenalty,
+		ToolChoice:       toolChoice(opts.ToolChoice),
+	}
+	if opts.Model != "" {
+		request.Model = opts.Model
+	}
+	if opts.Seed != 0 {
+		request.Seed = &opts.Seed
+	}
+
+	for _, t := range opts.Tools {
+		if t.Function == nil {
+			return nil, fmt.Errorf("infernolangchain: tool of type %q has no function", t.Type)
+		}
+		request.Tools = append(request.Tools, tool{Type: "function", Function: functionDefinition{
+			Name:        t.Function.Name,
+			Description: t.Function.Description,
+			Parameters:  t.Function.Parameters,
+		}})
+	}
+	for _, f := range opts.Functions {
+		request.Tools = append(request.Tools, tool{Type: "function", Function: functionDefinition{
+			Name:        f.Name,
+			Description: f.Description,
+			Parameters:  f.Parameters,
+		}})
+	}
+
+	for _, message := range messages {
+		converted, err := chatMessages(message)
+		if err != nil {
+			return nil, err
+		}
+		request.Messages = append(request.Messages, converted...)
+	}
+
+	return request, nil
+}
+
+// chatMessages converts one LangChainGo message; a tool message carrying
+// several responses becomes one "tool" message per response
+func chatMessages(message llms.MessageContent) ([]chatMessage, error) {
+	var role string
+	switch message.Role {
+	case llms.ChatMessageTypeSystem:
+		role = "system"
+	case llms.ChatMessageTypeHuman, llms.ChatMessageTypeGeneric:
+		role = "user"
+	case llms.ChatMessageTypeAI:
+		role = "assistant"
+	case llms.ChatMessageTypeTool, llms.ChatMessageTypeFunction:
+		role = "tool"
+	default:
+		return nil, fmt.Errorf("infernolangchain: unsupported message role %q", message.Role)
+	}
+
+	converted := chatMessage{Role: role}
+	var text []string
+	var responses []chatMessage
+	for _, part := range message.Parts {
+		switch part := part.(type) {
+		case llms.TextContent:
+			text = append(text, part.Text)
+		case llms.ToolCall:
+			if part.FunctionCall == nil {
+				continue
+			}
+			converted.ToolCalls = append(converted.ToolCalls, inferno.ToolCall{
+				ID:   part.ID,
+				Type: "function",
+				Function: inferno.FunctionCall{
+					Name:      part.FunctionCall.Name,
+					Arguments: part.FunctionCall.Arguments,
+				},
+			})
+		case llms.ToolCallResponse:
+			responses = append(responses, chatMessage{
+				Role:       "tool",
+				Content:    part.Content,
+				Name:       part.Name,
+				ToolCallID: part.ToolCallID,
+			})
+		default:
+			return nil, fmt.Errorf("infernolangchain: unsupported content part %T", part)
+		}
+	}
+
+	if len(responses) > 0 {
+		return responses, nil
+	}
+	converted.Content = strings.Join(text, "\n")
+	return []chatMessage{converted}, nil
+}
+
+// toolChoice translates llms.ToolChoice values; strings and maps pass through
+func toolChoice(choice any) interface{} {
+	switch choice := choice.(type) {
+	case llms.ToolChoice:
+		return toolChoice(&choice)
+	case *llms.ToolChoice:
+		if choice == nil {
+			return nil
+		}
+		if choice.Function == nil {
+			return choice.Type
+		}
+		return map[string]interface{}{
+			"type":     "function",
+			"function": map[string]string{"name": choice.Function.Name},
+		}
+	default:
+		return choice
+	}
+}
+
+func (l *LLM) complete(ctx context.Context, request *chatRequest) (*chatResponse, error) {
+	resp, err := l.client.RequestContext(ctx, "POST", "/v1/chat/completions", request)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if err := inferno.CheckResponse(resp); err != nil {
+		return nil, err
+	}
+
+	var response chatResponse
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return nil, err
+	}
+	if len(response.Choices) == 0 {
+		return nil, errors.New("infernolangchain: no choices in response")
+	}
+
+	return &response, nil
+}
+
+// stream reads the server-sent chunks into a single response, passing
+// content to onContent as it arrives
+func (l *LLM) stream(ctx context.Context, request *chatRequest, onContent func(ctx context.Context, chunk []byte) error) (*chatResponse, error) {
+	resp, err := l.client.StreamContext(ctx, "POST", "/v1/chat/completions", request)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if err := inferno.CheckResponse(resp); err != nil {
+		return nil, err
+	}
+
+	response := &chatResponse{Model: request.Model}
+	message := chatMessage{Role: "assistant"}
+	var finishReason string
+
+	err = inferno.ReadServerSentEvents(resp.Body, func(data []byte) error {
+		if string(data) == "[DONE]" {
+			return nil
+		}
+
+		var chunk chatChunk
+		if err := json.Unmarshal(data, &chunk); err != nil {
+			return fmt.Errorf("infernolangchain: decoding stream chunk: %w", err)
+		}
+		if chunk.Error != nil {
+			return fmt.Errorf("infernolangchain: %s", chunk.Error.Message)
+		}
+		if chunk.Usage != nil {
+			response.Usage = chunk.Usage
+		}
+
+		for _, choice := range chunk.Choices {
+			if choice.Delta.Content != "" {
+				message.Content += choice.Delta.Content
+				if err := onContent(ctx, []byte(choice.Delta.Content)); err != nil {
+					return err
+				}
+			}
+			message.ToolCalls = inferno.MergeToolCalls(message.ToolCalls, choice.Delta.ToolCalls)
+			if choice.FinishReason != nil {
+				finishReason = *choice.FinishReason
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	response.Choices = []chatChoice{{Message: message, FinishReason: finishReason}}
+	return response, nil
+}
+
+func contentResponse(response *chatResponse) *llms.ContentResponse {
+	content := &llms.ContentResponse{}
+	for _, choice := range response.Choices {
+		generationInfo := map[string]any{}
+		if response.Usage != nil {
+			generationInfo["PromptTokens"] = response.Usage.PromptTokens
+			generationInfo["CompletionTokens"] = response.Usage.CompletionTokens
+			generationInfo["TotalTokens"] = response.Usage.TotalTokens
+		}
+
+		converted := &llms.ContentChoice{
+			Content:        choice.Message.Content,
+			StopReason:     choice.FinishReason,
+			GenerationInfo: generationInfo,
+		}
+		for _, call := range choice.Message.ToolCalls {
+			converted.ToolCalls = append(converted.ToolCalls, llms.ToolCall{
+				ID:   call.ID,
+				Type: call.Type,
+				FunctionCall: &llms.FunctionCall{
+					Name:      call.Function.Name,
+					Arguments: call.Function.Arguments,
+				},
+			})
+		}
+		// Older LangChainGo agents read the first call from FuncCall
+		if len(converted.ToolCalls) > 0 {
+			converted.FuncCall = converted.ToolCalls[0].FunctionCall
+		}
+
+		content.Choices = append(content.Choices, converted)
+	}
+	return content
+}
@@ -0,0 +1,283 @@
+// Package infernomcp serves Inferno to MCP clients over stdio with custom
+// tools alongside Inferno's own. Desktop agents and editors launch the
+// program and speak the Model Context Protocol on its stdin and stdout;
+// requests are forwarded to the server's POST /mcp endpoint, except that
+// tools registered here are listed with Inferno's and run in-process:
+//
+//	client := inferno.NewClient("http://localhost:8080", apiKey)
+//	server := infernomcp.New(client)
+//	server.Register(infernomcp.Tool{
+//		Name:        "lookup_ticket",
+//		Description: "Fetch a support ticket by ID",
+//		InputSchema: map[string]interface{}{
+//			"type":       "object",
+//			"properties": map[string]interface{}{"id": map[string]interface{}{"type": "string"}},
+//			"required":   []string{"id"},
+//		},
+//		Handler: func(ctx context.Context, args json.RawMessage) (string, error) { ... },
+//	})
+//	log.Fatal(server.ServeStdio(ctx))
+//
+// Logs must go to stderr, since stdout carries the protocol.
+package infernomcp
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+
+	inferno "inferno-example"
+)
+
+// Handler runs a custom tool with its raw JSON arguments. The returned text
+// is the tool result; an error is reported to the client as a failed call.
+type Handler func(ctx context.Context, args json.RawMessage) (string, error)
+
+// Tool is a custom MCP tool
+type Tool struct {
+	Name        string
+	Description string
+	// InputSchema is the JSON Schema of the arguments object
+	InputSchema map[string]interface{}
+	Handler     Handler
+}
+
+// Server answers MCP messages with Inferno's tools and resources plus the
+// registered custom tools
+type Server struct {
+	client *inferno.Client
+
+	mu    sync.RWMutex
+	tools []Tool
+}
+
+// New returns a Server forwarding to the Inferno server behind client
+func New(client *inferno.Client) *Server {
+	return &Server{client: client}
+}
+
+// Register adds a custom tool. A custom tool hides an Inferno tool of the
+// same name.
+func (s *Server) Register(tool Tool) error {
+	if tool.Name == "" || tool.Handler == nil {
+		return errors.New("infernomcp: a tool needs a name and a handler")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, existing := range s.tools {
+		if existing.Name == tool.Name {
+			return fmt.Errorf("infernomcp: tool %q is already registered", tool.Name)
+		}
+	}
+	s.tools = append(s.tools, tool)
+
+	return nil
+}
+
+// ServeStdio serves MCP on the process's stdin and stdout until stdin
+// closes or ctx is done
+func (s *Server) ServeStdio(ctx context.Context) error {
+	return s.Serve(ctx, os.Stdin, os.Stdout)
+}
+
+// Serve reads one JSON-RPC message per line from r and writes responses to
+// w. Messages are handled concurrently, so responses may arrive out of
+// order, as JSON-RPC allows.
+func (s *Server) Serve(ctx context.Context, r io.Reader, w io.Writer) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		writeMu sync.Mutex
+		wg      sync.WaitGroup
+	)
+	write := func(response json.RawMessage) {
+		writeMu.Lock()
+		defer writeMu.Unlock()
+		w.Write(append(response, '\n'))
+	}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		message := append(json.RawMessage(nil), line...)
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if response := s.Handle(ctx, message); response != nil {
+				write(response)
+			}
+		}()
+	}
+
+	wg.Wait()
+	return scanner.Err()
+}
+
+type rpcMessage struct {
+	ID     json.RawMessage `json:"id,omitempty"`
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params,omitempty"`
+}
+
+// Handle answers one JSON-RPC message, returning nil for notifications.
+// Batches are forwarded to Inferno as they are.
+func (s *Server) Handle(ctx context.Context, message json.RawMessage) json.RawMessage {
+	var request rpcMessage
+	if json.Unmarshal(message, &request) != nil {
+		return s.forward(ctx, nil, message)
+	}
+
+	switch request.Method {
+	case "tools/list":
+		return s.listTools(ctx, request, message)
+	case "tools/call":
+		var params struct {
+			Name      string          `json:"name"`
+			Arguments json.RawMessage `json:"arguments"`
+		}
+		json.Unmarshal(request.Params, &params)
+		if tool, ok := s.tool(params.Name); ok {
+			return s.callTool(ctx, request.ID, tool, params.Arguments)
+		}
+	}
+
+	return s.forward(ctx, request.ID, message)
+}
+
+func (s *Server) tool(name string) (Tool, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, tool := range s.tools {
+		if tool.Name == name {
+			return tool, true
+		}
+	}
+	return Tool{}, false
+}
+
+// listTools merges the custom tools into Inferno's tools/list result
+func (s *Server) listTools(ctx context.Context, request rpcMessage, message json.RawMessage) json.RawMessage {
+	upstream := s.forward(ctx, request.ID, message)
+
+	var response struct {
+		JSONRPC string          `json:"jsonrpc"`
+		ID      json.RawMessage `json:"id"`
+		Result  *struct {
+			Tools []map[string]interface{} `json:"tools"`
+		} `json:"result,omitempty"`
+	}
+	if json.Unmarshal(upstream, &response) != nil || response.Result == nil {
+		return upstream
+	}
+
+	s.mu.RLock()
+	custom := make(map[string]bool, len(s.tools))
+	tools := make([]map[string]interface{}, 0, len(s.tools)+len(response.Result.Tools))
+	for _, tool := range s.tools {
+		custom[tool.Name] = true
+		schema := tool.InputSchema
+		if schema == nil {
+			schema = map[string]interface{}{"type": "object"}
+		}
+		tools = append(tools, map[string]interface{}{
+			"name":        tool.Name,
+			"description": tool.Description,
+			"inputSchema": schema,
+		})
+	}
+	s.mu.RUnlock()
+
+	for _, tool := range response.Result.Tools {
+		if name, _ := tool["name"].(string); !custom[name] {
+			tools = append(tools, tool)
+		}
+	}
+	response.Result.Tools = tools
+
+	merged, err := json.Marshal(response)
+	if err != nil {
+		return errorResponse(request.ID, -32603, err.Error())
+	}
+	return merged
+}
+
+func (s *Server) callTool(ctx context.Context, id json.RawMessage, tool Tool, args json.RawMessage) json.RawMessage {
+	if len(args) == 0 {
+		args = json.RawMessage("{}")
+	}
+
+	text, err := tool.Handler(ctx, args)
+	isError := err != nil
+	if isError {
+		text = err.Error()
+	}
+
+	return result(id, map[string]interface{}{
+		"content": []map[string]string{{"type": "text", "text": text}},
+		"isError": isError,
+	})
+}
+
+// forward sends message to Inferno's POST /mcp and returns its response,
+// or a JSON-RPC error if the server could not be reached
+func (s *Server) forward(ctx context.Context, id json.RawMessage, message json.RawMessage) json.RawMessage {
+	resp, err := s.client.RequestContext(ctx, "POST", "/mcp", message)
+	if err != nil {
+		return errorResponse(id, -32603, fmt.Sprintf("infernomcp: %v", err))
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return errorResponse(id, -32603, fmt.Sprintf("infernomcp: %v", err))
+	}
+	if resp.StatusCode == http.StatusAccepted {
+		return nil
+	}
+	if resp.StatusCode >= 400 {
+		return errorResponse(id, -32603, fmt.Sprintf("infernomcp: server returned %d: %s",
+			resp.StatusCode, strings.TrimSpace(string(body))))
+	}
+
+	return bytes.TrimSpace(body)
+}
+
+func result(id json.RawMessage, value interface{}) json.RawMessage {
+	encoded, err := json.Marshal(map[string]interface{}{
+		"jsonrpc": "2.0",
+		"id":      id,
+		"result":  value,
+	})
+	if err != nil {
+		return errorResponse(id, -32603, err.Error())
+	}
+	return encoded
+}
+
+// errorResponse builds a JSON-RPC error; notifications (no id) get none
+func errorResponse(id json.RawMessage, code int, message string) json.RawMessage {
+	if len(id) == 0 {
+		id = json.RawMessage("null")
+	}
+	encoded, _ := json.Marshal(map[string]interface{}{
+		"jsonrpc": "2.0",
+		"id":      id,
+		"error":   map[string]interface{}{"code": code, "message": message},
+	})
+	return encoded
+}
@@ -0,0 +1,122 @@
+// Package infernoopenai points code written against
+// github.com/sashabaranov/go-openai (v1.20 or later) at an Inferno server.
+// Switching providers is a one-line change:
+//
+//	client := openai.NewClient(os.Getenv("OPENAI_API_KEY"))
+//
+// becomes
+//
+//	client := infernoopenai.NewClient("http://localhost:8080", apiKey)
+//
+// The result is an ordinary *openai.Client, so CreateChatCompletion,
+// CreateChatCompletionStream, CreateCompletion, CreateEmbeddings, ListModels
+// and the files API work unchanged against Inferno's OpenAI-compatible
+// endpoints. Requests are sent through a Doer that tags each one with an
+// X-Request-ID and, when set with WithPriority, a queue priority, and that
+// asks the server to cancel the generation when the context ends before the
+// response arrives.
+//
+// Model names are Inferno model names or routing aliases, not OpenAI's.
+package infernoopenai
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	openai "github.com/sashabaranov/go-openai"
+
+	inferno "inferno-example"
+)
+
+// RequestIDHeader carries the ID the server tracks a generation under
+const RequestIDHeader = inferno.RequestIDHeader
+
+// PriorityHeader sets a request's queue priority: "low", "normal", "high"
+// or "vip"
+const PriorityHeader = "X-Inferno-Priority"
+
+// NewClient returns a go-openai client for the Inferno server at baseURL
+// (without the /v1 suffix); apiKey may be empty when auth is disabled
+func NewClient(baseURL, apiKey string) *openai.Client {
+	return openai.NewClientWithConfig(DefaultConfig(baseURL, apiKey))
+}
+
+// DefaultConfig returns the configuration NewClient uses, for callers that
+// want to adjust it before calling openai.NewClientWithConfig
+func DefaultConfig(baseURL, apiKey string) openai.ClientConfig {
+	baseURL = strings.TrimSuffix(baseURL, "/")
+
+	config := openai.DefaultConfig(apiKey)
+	config.BaseURL = baseURL + "/v1"
+	config.HTTPClient = &Doer{BaseURL: baseURL, APIKey: apiKey}
+	return config
+}
+
+type contextKey int
+
+const (
+	priorityKey contextKey = iota
+	requestIDKey
+)
+
+// WithPriority returns a context whose requests are queued at priority
+func WithPriority(ctx context.Context, priority string) context.Context {
+	return context.WithValue(ctx, priorityKey, priority)
+}
+
+// WithRequestID returns a context whose request is tracked under id, for
+// example to cancel it from elsewhere with the Inferno client's
+// CancelInference. Without one the Doer generates an ID per request.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey, id)
+}
+
+// Doer is the openai.HTTPDoer behind DefaultConfig
+type Doer struct {
+	// BaseURL and APIKey address the server for cancel calls
+	BaseURL string
+	APIKey  string
+	// HTTPClient sends the requests. Nil uses a client without a timeout,
+	// since streamed responses are read through it too; contexts bound
+	// requests instead.
+	HTTPClient *http.Client
+}
+
+// Do sends req with Inferno's headers, cancelling the generation on the
+// server if req's context ends before the response headers arrive. Streams
+// abandoned later stop on their own when the connection closes.
+func (d *Doer) Do(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+
+	requestID, _ := ctx.Value(requestIDKey).(string)
+	if requestID == "" {
+		requestID = inferno.NewRequestID()
+	}
+	req.Header.Set(RequestIDHeader, requestID)
+	if priority, _ := ctx.Value(priorityKey).(string); priority != "" {
+		req.Header.Set(PriorityHeader, priority)
+	}
+
+	resp, err := d.httpClient().Do(req)
+	if err != nil && ctx.Err() != nil {
+		go d.cancel(requestID)
+	}
+	return resp, err
+}
+
+func (d *Doer) httpClient() *http.Client {
+	if d.HTTPClient != nil {
+		return d.HTTPClient
+	}
+	return http.DefaultClient
+}
+
+// cancel asks the server to stop an abandoned generation through the
+// Inferno client; failures are ignored since the request is already over
+// for the caller
+func (d *Doer) cancel(requestID string) {
+	client := inferno.NewClient(d.BaseURL, d.APIKey)
+	client.HTTPClient = d.httpClient()
+	_ = client.CancelInference(requestID)
+}
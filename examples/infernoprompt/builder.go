@@ -0,0 +1,384 @@
+// Package infernoprompt assembles prompts from role-tagged parts: plain
+// system, user and assistant text, named sections wrapped in delimiters,
+// and few-shot examples. Parts may use {{variables}}, and with a token
+// budget set the lowest-priority parts are dropped until the prompt fits:
+//
+//	prompt, err := infernoprompt.New().
+//		System("You answer questions about {{product}} using the documents given.").
+//		Section("manual", manual, infernoprompt.Priority(2)).
+//		Section("forum_posts", posts, infernoprompt.Priority(1)).
+//		Example("How do I reset it?", "Hold the power button for ten seconds.").
+//		User("{{question}}").
+//		Var("product", "the X200 router").
+//		Var("question", question).
+//		Budget(3000, client.TokenCounter("llama-3-8b")).
+//		Build(ctx)
+//
+//	answer, err := client.Inference("llama-3-8b", prompt.Text, 256, 0.2)
+//
+// System, User and Assistant text is always kept; sections and examples
+// are dropped lowest Priority first, and among equal priorities the one
+// added last goes first. Counting through the server's tokenizer (see
+// (*Client).TokenCounter) makes the budget exact; Estimate needs no server.
+package infernoprompt
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Message roles
+const (
+	RoleSystem    = "system"
+	RoleUser      = "user"
+	RoleAssistant = "assistant"
+)
+
+// Message is one chat message of a built prompt
+type Message struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+// Delimiter is how sections are marked off from the surrounding text
+type Delimiter int
+
+const (
+	// XMLTags wraps a section in <name>...</name> (the default)
+	XMLTags Delimiter = iota
+	// Markdown puts a "## name" heading above a section
+	Markdown
+	// TripleQuotes puts "name:" above a section quoted in """
+	TripleQuotes
+)
+
+type partKind int
+
+const (
+	textPart partKind = iota
+	sectionPart
+	examplePart
+)
+
+type part struct {
+	kind     partKind
+	role     string
+	name     string
+	text     string
+	output   string // the answer of an example
+	priority int
+	required bool
+}
+
+// Option adjusts a section or example
+type Option func(*part)
+
+// Priority ranks a part against others under a budget; higher priorities
+// are kept longer. The default is 0.
+func Priority(priority int) Option {
+	return func(p *part) { p.priority = priority }
+}
+
+// Required keeps a part whatever the budget
+func Required() Option {
+	return func(p *part) { p.required = true }
+}
+
+// As puts a section in a message of the given role (default RoleUser)
+func As(role string) Option {
+	return func(p *part) { p.role = role }
+}
+
+// Builder collects the parts of a prompt. Its methods return the Builder so
+// calls can be chained; it is not safe for concurrent use.
+type Builder struct {
+	parts     []*part
+	vars      map[string]string
+	delimiter Delimiter
+	budget    int
+	counter   Counter
+}
+
+// New returns an empty Builder
+func New() *Builder {
+	return &Builder{vars: map[string]string{}}
+}
+
+// System adds system instructions
+func (b *Builder) System(text string) *Builder {
+	return b.add(&part{kind: textPart, role: RoleSystem, text: text, required: true})
+}
+
+// User adds user text
+func (b *Builder) User(text string) *Builder {
+	return b.add(&part{kind: textPart, role: RoleUser, text: text, required: true})
+}
+
+// Assistant adds assistant text, e.g. to prefill the start of the answer
+func (b *Builder) Assistant(text string) *Builder {
+	return b.add(&part{kind: textPart, role: RoleAssistant, text: text, required: true})
+}
+
+// Section adds named, delimited text such as a document or context, in a
+// user message unless As says otherwise
+func (b *Builder) Section(name, text string, options ...Option) *Builder {
+	return b.add(&part{kind: sectionPart, role: RoleUser, name: name, text: text}, options...)
+}
+
+// Example adds a few-shot example, sent as a user message with input and
+// an assistant message with output
+func (b *Builder) Example(input, output string, options ...Option) *Builder {
+	return b.add(&part{kind: examplePart, role: RoleUser, text: input, output: output}, options...)
+}
+
+// Var sets the value substituted for {{name}} in every part
+func (b *Builder) Var(name, value string) *Builder {
+	b.vars[name] = value
+	return b
+}
+
+// Vars sets several variables at once
+func (b *Builder) Vars(vars map[string]string) *Builder {
+	for name, value := range vars {
+		b.vars[name] = value
+	}
+	return b
+}
+
+// Delimiter sets how sections are marked off
+func (b *Builder) Delimiter(delimiter Delimiter) *Builder {
+	b.delimiter = delimiter
+	return b
+}
+
+// Budget limits the prompt to tokens as counted by counter (Estimate when
+// nil); a budget of zero or less removes the limit
+func (b *Builder) Budget(tokens int, counter Counter) *Builder {
+	b.budget = tokens
+	b.counter = counter
+	return b
+}
+
+func (b *Builder) add(p *part, options ...Option) *Builder {
+	for _, option := range options {
+		option(p)
+	}
+	b.parts = append(b.parts, p)
+	return b
+}
+
+// Prompt is a built prompt
+type Prompt struct {
+	// Messages is the prompt for chat completions; consecutive parts of the
+	// same role share a message, examples get their own
+	Messages []Message
+	// Text is the prompt for plain completions, parts separated by blank
+	// lines
+	Text string
+	// Tokens is the counted size of Text; zero without a budget
+	Tokens int
+	// Dropped names the parts left out to fit the budget; examples are
+	// named "example 1", "example 2", ... in the order added
+	Dropped []string
+}
+
+// ErrOverBudget is returned when the parts that cannot be dropped exceed
+// the budget on their own; the Prompt holds what remains
+var ErrOverBudget = errors.New("infernoprompt: required parts exceed the token budget")
+
+var variable = regexp.MustCompile(`\{\{\s*([A-Za-z_][A-Za-z0-9_]*)\s*\}\}`)
+
+// Build interpolates the variables, applies the budget and renders the
+// prompt. A variable used but never set is an error.
+func (b *Builder) Build(ctx context.Context) (*Prompt, error) {
+	parts := make([]*part, len(b.parts))
+	for i, p := range b.parts {
+		interpolated := *p
+		var err error
+		if interpolated.text, err = b.interpolate(p.text); err != nil {
+			return nil, err
+		}
+		if interpolated.output, err = b.interpolate(p.output); err != nil {
+			return nil, err
+		}
+		parts[i] = &interpolated
+	}
+
+	kept := make([]bool, len(parts))
+	for i := range kept {
+		kept[i] = true
+	}
+	prompt := &Prompt{}
+
+	var budgetErr error
+	if b.budget > 0 {
+		prompt.Tokens, budgetErr = b.fit(ctx, parts, kept)
+		if budgetErr != nil && !errors.Is(budgetErr, ErrOverBudget) {
+			return nil, budgetErr
+		}
+	}
+
+	b.render(prompt, parts, kept)
+	return prompt, budgetErr
+}
+
+func (b *Builder) interpolate(text string) (string, error) {
+	var missing string
+	result := variable.ReplaceAllStringFunc(text, func(match string) string {
+		name := variable.FindStringSubmatch(match)[1]
+		value, ok := b.vars[name]
+		if !ok && missing == "" {
+			missing = name
+		}
+		return value
+	})
+	if missing != "" {
+		return "", fmt.Errorf("infernoprompt: variable %q is not set", missing)
+	}
+	return result, nil
+}
+
+// fit clears kept for parts until the rendered text fits the budget and
+// returns its token count. Per-part counts pick the drops in one pass; the
+// whole text is then counted exactly, dropping further parts if the joins
+// between parts pushed it over.
+func (b *Builder) fit(ctx context.Context, parts []*part, kept []bool) (int, error) {
+	counter := b.counter
+	if counter == nil {
+		counter = Estimate
+	}
+
+	// Drop order: lowest priority first, later parts before earlier ones
+	var droppable []int
+	for i, p := range parts {
+		if !p.required {
+			droppable = append(droppable, i)
+		}
+	}
+	sort.SliceStable(droppable, func(i, j int) bool {
+		a, c := parts[droppable[i]], parts[droppable[j]]
+		if a.priority != c.priority {
+			return a.priority < c.priority
+		}
+		return droppable[i] > droppable[j]
+	})
+
+	texts := make([]string, len(parts))
+	for i, p := range parts {
+		texts[i] = b.partText(p)
+	}
+	counts, err := counter.CountTokens(ctx, texts)
+	if err != nil {
+		return 0, err
+	}
+	if len(counts) != len(texts) {
+		return 0, fmt.Errorf("infernoprompt: counter returned %d counts for %d texts", len(counts), len(texts))
+	}
+
+	total := 0
+	for _, count := range counts {
+		total += count
+	}
+	for total > b.budget && len(droppable) > 0 {
+		kept[droppable[0]] = false
+		total -= counts[droppable[0]]
+		droppable = droppable[1:]
+	}
+
+	for {
+		exact, err := counter.CountTokens(ctx, []string{b.text(parts, kept)})
+		if err != nil {
+			return 0, err
+		}
+		if len(exact) != 1 {
+			return 0, fmt.Errorf("infernoprompt: counter returned %d counts for 1 text", len(exact))
+		}
+		if exact[0] <= b.budget {
+			return exact[0], nil
+		}
+		if len(droppable) == 0 {
+			return exact[0], ErrOverBudget
+		}
+		kept[droppable[0]] = false
+		droppable = droppable[1:]
+	}
+}
+
+func (b *Builder) render(prompt *Prompt, parts []*part, kept []bool) {
+	prompt.Text = b.text(parts, kept)
+
+	examples := 0
+	merge := false
+	for i, p := range parts {
+		if p.kind == examplePart {
+			examples++
+		}
+		if !kept[i] {
+			prompt.Dropped = append(prompt.Dropped, partName(p, examples))
+			continue
+		}
+
+		if p.kind == examplePart {
+			prompt.Messages = append(prompt.Messages,
+				Message{Role: RoleUser, Content: p.text},
+				Message{Role: RoleAssistant, Content: p.output})
+			merge = false
+			continue
+		}
+
+		content := b.partContent(p)
+		last := len(prompt.Messages) - 1
+		if merge && prompt.Messages[last].Role == p.role {
+			prompt.Messages[last].Content += "\n\n" + content
+		} else {
+			prompt.Messages = append(prompt.Messages, Message{Role: p.role, Content: content})
+		}
+		merge = true
+	}
+}
+
+func partName(p *part, example int) string {
+	if p.kind == examplePart {
+		return fmt.Sprintf("example %d", example)
+	}
+	return p.name
+}
+
+// text renders the kept parts as a single completion prompt
+func (b *Builder) text(parts []*part, kept []bool) string {
+	var rendered []string
+	for i, p := range parts {
+		if kept[i] {
+			rendered = append(rendered, b.partText(p))
+		}
+	}
+	return strings.Join(rendered, "\n\n")
+}
+
+// partText is how a part reads in the completion prompt
+func (b *Builder) partText(p *part) string {
+	if p.kind == examplePart {
+		return "Input: " + p.text + "\nOutput: " + p.output
+	}
+	return b.partContent(p)
+}
+
+// partContent is how a text or section part reads in its message
+func (b *Builder) partContent(p *part) string {
+	if p.kind != sectionPart {
+		return p.text
+	}
+
+	switch b.delimiter {
+	case Markdown:
+		return "## " + p.name + "\n\n" + p.text
+	case TripleQuotes:
+		return p.name + ":\n\"\"\"\n" + p.text + "\n\"\"\""
+	default:
+		return "<" + p.name + ">\n" + p.text + "\n</" + p.name + ">"
+	}
+}
@@ -0,0 +1,30 @@
+package infernoprompt
+
+import (
+	"context"
+	"unicode/utf8"
+)
+
+// Counter counts the tokens of each text, returning one count per text
+type Counter interface {
+	CountTokens(ctx context.Context, texts []string) ([]int, error)
+}
+
+// CounterFunc adapts a function to Counter
+type CounterFunc func(ctx context.Context, texts []string) ([]int, error)
+
+// CountTokens calls f
+func (f CounterFunc) CountTokens(ctx context.Context, texts []string) ([]int, error) {
+	return f(ctx, texts)
+}
+
+// Estimate approximates counts at four characters per token, which is
+// close for English text with common tokenizers. Leave headroom in the
+// budget when relying on it.
+var Estimate Counter = CounterFunc(func(ctx context.Context, texts []string) ([]int, error) {
+	counts := make([]int, len(texts))
+	for i, text := range texts {
+		counts[i] = (utf8.RuneCountInString(text) + 3) / 4
+	}
+	return counts, nil
+})
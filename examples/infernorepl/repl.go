@@ -0,0 +1,381 @@
+// Package infernorepl is an interactive chat loop for trying models from a
+// terminal: replies stream as they are generated, input may span lines,
+// earlier inputs can be recalled, and conversations can be saved and
+// loaded as JSON.
+//
+// The package does not depend on a particular client: a Chat streams one
+// reply, and the example client provides one with (*Client).REPLChat.
+//
+//	repl := &infernorepl.REPL{
+//		Chat:    client.REPLChat(),
+//		Session: &infernorepl.Session{Model: "llama-3-8b", System: "Be brief."},
+//		In:      os.Stdin,
+//		Out:     os.Stdout,
+//	}
+//	err := repl.Run(ctx)
+//
+// Type /help at the prompt for the commands.
+package infernorepl
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Message is one turn of a conversation
+type Message struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+// Session is a conversation, saved and loaded as JSON
+type Session struct {
+	Model  string `json:"model"`
+	System string `json:"system,omitempty"`
+	// Messages are the user and assistant turns, without the system prompt
+	Messages []Message `json:"messages"`
+}
+
+// LoadSession reads a session written by Save
+func LoadSession(path string) (*Session, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var session Session
+	if err := json.Unmarshal(data, &session); err != nil {
+		return nil, fmt.Errorf("session %s: %w", path, err)
+	}
+	return &session, nil
+}
+
+// Save writes the session to path as JSON
+func (s *Session) Save(path string) error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0o600)
+}
+
+// conversation returns the messages to send: the system prompt, if any,
+// then the turns
+func (s *Session) conversation() []Message {
+	messages := make([]Message, 0, len(s.Messages)+1)
+	if s.System != "" {
+		messages = append(messages, Message{Role: "system", Content: s.System})
+	}
+	return append(messages, s.Messages...)
+}
+
+// Chat streams the assistant's reply to messages from model into w as it is
+// generated, and returns it whole
+type Chat func(ctx context.Context, model string, messages []Message, w io.Writer) (string, error)
+
+// REPL reads inputs, sends each with the conversation so far and prints
+// the streamed reply
+type REPL struct {
+	Chat    Chat
+	Session *Session
+	In      io.Reader
+	Out     io.Writer
+	// Prompt is printed before each input; empty means "> "
+	Prompt string
+	// HistoryFile, when set, keeps inputs across runs for !N recall
+	HistoryFile string
+	// Interrupts, when set, stops the reply being generated on each value
+	// received, as from signal.Notify for os.Interrupt
+	Interrupts <-chan os.Signal
+
+	history []string
+	lines   *bufio.Scanner
+}
+
+// errExit ends Run without an error
+var errExit = errors.New("exit")
+
+const helpText = `Enter a message to send it. End a line with \ to continue it on the next,
+or write a block between lines of """.
+  /system [text]   show or set the system prompt
+  /model [name]    show or set the model
+  /reset           start the conversation over
+  /undo            drop the last exchange
+  /retry           generate the last reply again
+  /save <file>     save the conversation
+  /load <file>     load a saved conversation
+  /history         list earlier inputs; !N sends input N again, !! the last
+  /exit            quit (or Ctrl-D)
+`
+
+// Run reads inputs until the input ends, /exit or ctx is done
+func (r *REPL) Run(ctx context.Context) error {
+	if r.Session == nil {
+		r.Session = &Session{}
+	}
+	if r.Prompt == "" {
+		r.Prompt = "> "
+	}
+	r.lines = bufio.NewScanner(r.In)
+	r.lines.Buffer(make([]byte, 64*1024), 4*1024*1024)
+	r.loadHistory()
+
+	for ctx.Err() == nil {
+		input, err := r.readInput()
+		if err == io.EOF {
+			fmt.Fprintln(r.Out)
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		input = strings.TrimSpace(input)
+		if input == "" {
+			continue
+		}
+
+		if input, err = r.recall(input); err != nil {
+			fmt.Fprintln(r.Out, err)
+			continue
+		}
+		if strings.HasPrefix(input, "/") {
+			err := r.command(ctx, input)
+			if errors.Is(err, errExit) {
+				return nil
+			}
+			if err != nil {
+				fmt.Fprintln(r.Out, err)
+			}
+			continue
+		}
+
+		r.remember(input)
+		r.Session.Messages = append(r.Session.Messages, Message{Role: "user", Content: input})
+		if err := r.reply(ctx); err != nil {
+			// Keep the conversation as it was, so the input can be retried
+			r.Session.Messages = r.Session.Messages[:len(r.Session.Messages)-1]
+			fmt.Fprintln(r.Out, "error:", err)
+		}
+	}
+	return ctx.Err()
+}
+
+// readInput reads one input, joining continued lines and """ blocks
+func (r *REPL) readInput() (string, error) {
+	fmt.Fprint(r.Out, r.Prompt)
+	line, err := r.readLine()
+	if err != nil {
+		return "", err
+	}
+
+	if strings.TrimSpace(line) == `"""` {
+		var block []string
+		for {
+			fmt.Fprint(r.Out, "... ")
+			line, err := r.readLine()
+			if err != nil {
+				return "", err
+			}
+			if strings.TrimSpace(line) == `"""` {
+				return strings.Join(block, "\n"), nil
+			}
+			block = append(block, line)
+		}
+	}
+
+	var joined []string
+	for strings.HasSuffix(line, `\`) {
+		joined = append(joined, strings.TrimSuffix(line, `\`))
+		fmt.Fprint(r.Out, "... ")
+		if line, err = r.readLine(); err != nil {
+			return "", err
+		}
+	}
+	return strings.Join(append(joined, line), "\n"), nil
+}
+
+func (r *REPL) readLine() (string, error) {
+	if !r.lines.Scan() {
+		if err := r.lines.Err(); err != nil {
+			return "", err
+		}
+		return "", io.EOF
+	}
+	return r.lines.Text(), nil
+}
+
+// recall expands !! and !N into the inputs they name
+func (r *REPL) recall(input string) (string, error) {
+	if !strings.HasPrefix(input, "!") {
+		return input, nil
+	}
+	if input == "!!" {
+		if len(r.history) == 0 {
+			return "", errors.New("no earlier input")
+		}
+		return r.history[len(r.history)-1], nil
+	}
+	n, err := strconv.Atoi(input[1:])
+	if err != nil {
+		return input, nil
+	}
+	if n < 1 || n > len(r.history) {
+		return "", fmt.Errorf("no input %d; /history lists them", n)
+	}
+	return r.history[n-1], nil
+}
+
+// reply streams the assistant's answer to the conversation and adds it
+func (r *REPL) reply(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	if r.Interrupts != nil {
+		// Drop interrupts sent while no reply was being generated
+		for len(r.Interrupts) > 0 {
+			<-r.Interrupts
+		}
+		done := make(chan struct{})
+		defer close(done)
+		go func() {
+			select {
+			case <-r.Interrupts:
+				cancel()
+			case <-done:
+			}
+		}()
+	}
+
+	text, err := r.Chat(ctx, r.Session.Model, r.Session.conversation(), r.Out)
+	fmt.Fprintln(r.Out)
+	if err != nil && text == "" {
+		return err
+	}
+	if err != nil {
+		fmt.Fprintln(r.Out, "(reply cut short:", err.Error()+")")
+	}
+	r.Session.Messages = append(r.Session.Messages, Message{Role: "assistant", Content: text})
+	return nil
+}
+
+// command runs a /command
+func (r *REPL) command(ctx context.Context, input string) error {
+	name, arg, _ := strings.Cut(input, " ")
+	arg = strings.TrimSpace(arg)
+	session := r.Session
+
+	switch name {
+	case "/help":
+		fmt.Fprint(r.Out, helpText)
+	case "/exit", "/quit":
+		return errExit
+	case "/system":
+		if arg != "" {
+			session.System = arg
+		}
+		fmt.Fprintf(r.Out, "system: %q\n", session.System)
+	case "/model":
+		if arg != "" {
+			session.Model = arg
+		}
+		fmt.Fprintln(r.Out, "model:", session.Model)
+	case "/reset":
+		session.Messages = nil
+		fmt.Fprintln(r.Out, "conversation cleared")
+	case "/undo":
+		session.Messages = dropLastExchange(session.Messages)
+	case "/retry":
+		messages := session.Messages
+		if len(messages) > 0 && messages[len(messages)-1].Role == "assistant" {
+			messages = messages[:len(messages)-1]
+		}
+		if len(messages) == 0 || messages[len(messages)-1].Role != "user" {
+			return errors.New("nothing to retry")
+		}
+		previous := session.Messages
+		session.Messages = messages
+		if err := r.reply(ctx); err != nil {
+			session.Messages = previous
+			return err
+		}
+	case "/save":
+		if arg == "" {
+			return errors.New("usage: /save <file>")
+		}
+		if err := session.Save(arg); err != nil {
+			return err
+		}
+		fmt.Fprintln(r.Out, "saved to", arg)
+	case "/load":
+		if arg == "" {
+			return errors.New("usage: /load <file>")
+		}
+		loaded, err := LoadSession(arg)
+		if err != nil {
+			return err
+		}
+		if loaded.Model == "" {
+			loaded.Model = session.Model
+		}
+		*session = *loaded
+		fmt.Fprintf(r.Out, "loaded %d messages for %s\n", len(session.Messages), session.Model)
+	case "/history":
+		for i, input := range r.history {
+			fmt.Fprintf(r.Out, "%4d  %s\n", i+1, strings.ReplaceAll(input, "\n", "\n      "))
+		}
+	default:
+		return fmt.Errorf("unknown command %s; /help lists them", name)
+	}
+	return nil
+}
+
+// dropLastExchange removes the last user turn and everything after it
+func dropLastExchange(messages []Message) []Message {
+	for i := len(messages) - 1; i >= 0; i-- {
+		if messages[i].Role == "user" {
+			return messages[:i]
+		}
+	}
+	return messages
+}
+
+// loadHistory reads HistoryFile, one JSON string per input
+func (r *REPL) loadHistory() {
+	if r.HistoryFile == "" {
+		return
+	}
+	data, err := os.ReadFile(r.HistoryFile)
+	if err != nil {
+		return
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		var input string
+		if json.Unmarshal([]byte(line), &input) == nil && input != "" {
+			r.history = append(r.history, input)
+		}
+	}
+}
+
+// remember adds input to the history and HistoryFile, unless it repeats
+// the last one
+func (r *REPL) remember(input string) {
+	if len(r.history) > 0 && r.history[len(r.history)-1] == input {
+		return
+	}
+	r.history = append(r.history, input)
+	if r.HistoryFile == "" {
+		return
+	}
+	f, err := os.OpenFile(r.HistoryFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	line, _ := json.Marshal(input)
+	f.Write(append(line, '\n'))
+}
@@ -0,0 +1,85 @@
+package infernotest
+
+import "strings"
+
+// Similarity scores two outputs from 0 to 1 as one minus the word-level
+// edit distance divided by the longer output's word count, so a single
+// changed word in a long answer barely moves the score
+func Similarity(a, b string) float64 {
+	wordsA := strings.Fields(a)
+	wordsB := strings.Fields(b)
+
+	longest := len(wordsA)
+	if len(wordsB) > longest {
+		longest = len(wordsB)
+	}
+	if longest == 0 {
+		return 1
+	}
+
+	return 1 - float64(editDistance(wordsA, wordsB))/float64(longest)
+}
+
+// editDistance is the Levenshtein distance between two word sequences
+func editDistance(a, b []string) int {
+	previous := make([]int, len(b)+1)
+	current := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = min(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous, current = current, previous
+	}
+
+	return previous[len(b)]
+}
+
+// Diff returns a line diff from want to got: unchanged lines are indented
+// two spaces, removed lines start with "- " and added lines with "+ "
+func Diff(want, got string) string {
+	a := strings.Split(want, "\n")
+	b := strings.Split(got, "\n")
+
+	// lcs[i][j] is the longest common subsequence of a[i:] and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	var out strings.Builder
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			out.WriteString("  " + a[i] + "\n")
+			i++
+			j++
+		case i < len(a) && (j == len(b) || lcs[i+1][j] >= lcs[i][j+1]):
+			out.WriteString("- " + a[i] + "\n")
+			i++
+		default:
+			out.WriteString("+ " + b[j] + "\n")
+			j++
+		}
+	}
+
+	return out.String()
+}
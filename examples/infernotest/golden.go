@@ -0,0 +1,244 @@
+// Package infernotest records model outputs as golden files and compares
+// later runs against them, so prompt and model changes can be reviewed like
+// code.
+//
+// Outputs are generated with a fixed seed and stored under Dir, one JSON
+// file per case. A later run passes when its output is at least Threshold
+// similar to the recording; otherwise the test fails with a line diff. Set
+// INFERNO_UPDATE_GOLDEN=1 (or Golden.Update) to re-record.
+//
+//	func TestPrompts(t *testing.T) {
+//		g := &infernotest.Golden{
+//			Dir:      "testdata/golden",
+//			Generate: client.GoldenGenerator("llama-3-8b", 128),
+//		}
+//		g.Run(t, map[string]string{
+//			"summary": "Summarise the plot of Hamlet in two sentences.",
+//		})
+//	}
+package infernotest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"testing"
+	"time"
+)
+
+// UpdateEnv names the environment variable that switches Golden to
+// re-recording when set to a non-empty value other than "0"
+const UpdateEnv = "INFERNO_UPDATE_GOLDEN"
+
+// Defaults used when the corresponding Golden field is zero
+const (
+	DefaultSeed      uint64  = 42
+	DefaultThreshold float64 = 0.9
+)
+
+// Generator produces the model's output for prompt using seed
+type Generator func(ctx context.Context, prompt string, seed uint64) (string, error)
+
+// Golden compares generated outputs against recorded golden files
+type Golden struct {
+	// Dir holds the golden files, conventionally "testdata/golden"
+	Dir string
+	// Generate produces outputs; see (*Client).GoldenGenerator
+	Generate Generator
+	// Seed is passed to every generation (default DefaultSeed)
+	Seed uint64
+	// Threshold is the minimum Similarity for a case to pass
+	// (default DefaultThreshold; 1 requires an exact match)
+	Threshold float64
+	// Update re-records golden files instead of comparing. It is also
+	// enabled by the UpdateEnv environment variable.
+	Update bool
+	// Timeout bounds each generation (zero: no bound)
+	Timeout time.Duration
+}
+
+// Recording is the on-disk form of one golden case
+type Recording struct {
+	Name       string    `json:"name"`
+	Prompt     string    `json:"prompt"`
+	Seed       uint64    `json:"seed"`
+	Output     string    `json:"output"`
+	RecordedAt time.Time `json:"recorded_at"`
+}
+
+// Result is the outcome of checking one case
+type Result struct {
+	Name   string
+	Prompt string
+	// Golden is the recorded output; empty when the case was just recorded
+	Golden string
+	Output string
+	// Similarity is 1 for identical outputs and 0 for nothing in common
+	Similarity float64
+	Threshold  float64
+	Passed     bool
+	// Recorded is set when the golden file was written by this run
+	Recorded bool
+}
+
+// Diff returns a line diff from the golden output to the new output
+func (r *Result) Diff() string {
+	return Diff(r.Golden, r.Output)
+}
+
+// ErrNoGolden is returned by Check when a case has never been recorded and
+// updating is off
+var ErrNoGolden = errors.New("infernotest: no golden file recorded")
+
+// Check generates the output for prompt and compares it with the golden
+// file for name, recording it instead when updating
+func (g *Golden) Check(ctx context.Context, name, prompt string) (*Result, error) {
+	if g.Generate == nil {
+		return nil, errors.New("infernotest: Golden.Generate is not set")
+	}
+
+	seed := g.Seed
+	if seed == 0 {
+		seed = DefaultSeed
+	}
+	threshold := g.Threshold
+	if threshold == 0 {
+		threshold = DefaultThreshold
+	}
+
+	if g.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, g.Timeout)
+		defer cancel()
+	}
+
+	output, err := g.Generate(ctx, prompt, seed)
+	if err != nil {
+		return nil, fmt.Errorf("infernotest: generating %q: %w", name, err)
+	}
+
+	result := &Result{
+		Name:      name,
+		Prompt:    prompt,
+		Output:    output,
+		Threshold: threshold,
+	}
+
+	if g.updating() {
+		recording := Recording{
+			Name:       name,
+			Prompt:     prompt,
+			Seed:       seed,
+			Output:     output,
+			RecordedAt: time.Now().UTC(),
+		}
+		if err := g.write(recording); err != nil {
+			return nil, err
+		}
+		result.Golden = output
+		result.Similarity = 1
+		result.Passed = true
+		result.Recorded = true
+		return result, nil
+	}
+
+	recording, err := g.read(name)
+	if err != nil {
+		return nil, err
+	}
+	if recording.Prompt != prompt || recording.Seed != seed {
+		return nil, fmt.Errorf("infernotest: golden file for %q was recorded with a different prompt or seed; re-record with %s=1", name, UpdateEnv)
+	}
+
+	result.Golden = recording.Output
+	result.Similarity = Similarity(recording.Output, output)
+	result.Passed = result.Similarity >= threshold
+	return result, nil
+}
+
+// Assert checks one case and fails t when the output has drifted below the
+// threshold or cannot be compared
+func (g *Golden) Assert(t testing.TB, name, prompt string) *Result {
+	t.Helper()
+
+	result, err := g.Check(context.Background(), name, prompt)
+	if err != nil {
+		t.Fatal(err)
+		return nil
+	}
+
+	if result.Recorded {
+		t.Logf("recorded golden output for %q", name)
+	} else if !result.Passed {
+		t.Errorf("output for %q is %.2f similar to the golden file (threshold %.2f):\n%s",
+			name, result.Similarity, result.Threshold, result.Diff())
+	}
+	return result
+}
+
+// Run checks every case as a subtest, in name order
+func (g *Golden) Run(t *testing.T, cases map[string]string) {
+	t.Helper()
+
+	names := make([]string, 0, len(cases))
+	for name := range cases {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		prompt := cases[name]
+		t.Run(name, func(t *testing.T) {
+			g.Assert(t, name, prompt)
+		})
+	}
+}
+
+func (g *Golden) updating() bool {
+	if g.Update {
+		return true
+	}
+	value := os.Getenv(UpdateEnv)
+	return value != "" && value != "0"
+}
+
+var unsafeFileChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// path maps a case name to its golden file
+func (g *Golden) path(name string) string {
+	return filepath.Join(g.Dir, unsafeFileChars.ReplaceAllString(name, "_")+".golden.json")
+}
+
+func (g *Golden) read(name string) (*Recording, error) {
+	data, err := os.ReadFile(g.path(name))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("%w for %q; record it with %s=1", ErrNoGolden, name, UpdateEnv)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var recording Recording
+	if err := json.Unmarshal(data, &recording); err != nil {
+		return nil, fmt.Errorf("infernotest: reading golden file for %q: %w", name, err)
+	}
+	return &recording, nil
+}
+
+func (g *Golden) write(recording Recording) error {
+	if err := os.MkdirAll(g.Dir, 0o755); err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(recording, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(g.path(recording.Name), append(data, '\n'), 0o644)
+}
@@ -0,0 +1,173 @@
+package infernotools
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// DefaultMaxRounds bounds the model calls of a Chat whose MaxRounds is zero
+const DefaultMaxRounds = 8
+
+// Requester sends a JSON request to an Inferno server; the example Go
+// client's *Client satisfies it
+type Requester interface {
+	RequestContext(ctx context.Context, method, endpoint string, body interface{}) (*http.Response, error)
+}
+
+// Wire types for /v1/chat/completions
+
+// Message is one chat message
+type Message struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+	Name    string `json:"name,omitempty"`
+	// ToolCalls are the calls made by an assistant message
+	ToolCalls []ToolCall `json:"tool_calls,omitempty"`
+	// ToolCallID names the call a "tool" message answers
+	ToolCallID string `json:"tool_call_id,omitempty"`
+}
+
+// ToolDefinition offers a function to the model
+type ToolDefinition struct {
+	Type     string             `json:"type"`
+	Function FunctionDefinition `json:"function"`
+}
+
+type FunctionDefinition struct {
+	Name        string                 `json:"name"`
+	Description string                 `json:"description,omitempty"`
+	Parameters  map[string]interface{} `json:"parameters,omitempty"`
+}
+
+// ToolCall is a call the model made
+type ToolCall struct {
+	ID       string       `json:"id"`
+	Type     string       `json:"type"`
+	Function FunctionCall `json:"function"`
+}
+
+type FunctionCall struct {
+	Name string `json:"name"`
+	// Arguments is the JSON-encoded arguments object
+	Arguments string `json:"arguments"`
+}
+
+type Usage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	TotalTokens      int `json:"total_tokens"`
+}
+
+// ChatRequest is a chat completion; the registry fills in Tools
+type ChatRequest struct {
+	Model       string    `json:"model"`
+	Messages    []Message `json:"messages"`
+	MaxTokens   *int      `json:"max_tokens,omitempty"`
+	Temperature *float32  `json:"temperature,omitempty"`
+	Seed        *uint64   `json:"seed,omitempty"`
+	// ToolChoice is "auto" (the default), "none", "required" or
+	// {"type": "function", "function": {"name": ...}}. It applies to the
+	// first round only, so a forced call cannot repeat forever.
+	ToolChoice interface{}      `json:"tool_choice,omitempty"`
+	Tools      []ToolDefinition `json:"tools,omitempty"`
+	// MaxRounds bounds the model calls (default DefaultMaxRounds)
+	MaxRounds int `json:"-"`
+}
+
+type chatResponse struct {
+	Choices []struct {
+		Message      Message `json:"message"`
+		FinishReason string  `json:"finish_reason"`
+	} `json:"choices"`
+	Usage *Usage `json:"usage"`
+}
+
+// ChatResult is the outcome of a Chat
+type ChatResult struct {
+	// Message is the model's final answer
+	Message Message
+	// Messages is the whole conversation: the request's messages, then
+	// every assistant message and tool result, ending with Message
+	Messages []Message
+	// Rounds counts the model calls made
+	Rounds int
+	// Usage sums the token usage of every round
+	Usage Usage
+}
+
+// ErrMaxRounds is returned when the model is still calling tools after
+// MaxRounds rounds; the ChatResult holds the conversation so far
+var ErrMaxRounds = errors.New("infernotools: model still calling tools after the maximum number of rounds")
+
+// Chat sends request with the registered tools offered, runs the calls the
+// model makes, appends the results and asks again, until the model replies
+// without calling a tool. Calls of one reply run in order.
+func (r *Registry) Chat(ctx context.Context, client Requester, request ChatRequest) (*ChatResult, error) {
+	maxRounds := request.MaxRounds
+	if maxRounds <= 0 {
+		maxRounds = DefaultMaxRounds
+	}
+
+	request.Tools = r.Definitions()
+	result := &ChatResult{Messages: append([]Message(nil), request.Messages...)}
+
+	for result.Rounds < maxRounds {
+		request.Messages = result.Messages
+		response, err := complete(ctx, client, &request)
+		if err != nil {
+			return result, err
+		}
+		result.Rounds++
+		if response.Usage != nil {
+			result.Usage.PromptTokens += response.Usage.PromptTokens
+			result.Usage.CompletionTokens += response.Usage.CompletionTokens
+			result.Usage.TotalTokens += response.Usage.TotalTokens
+		}
+
+		message := response.Choices[0].Message
+		result.Message = message
+		result.Messages = append(result.Messages, message)
+		if len(message.ToolCalls) == 0 {
+			return result, nil
+		}
+
+		for _, call := range message.ToolCalls {
+			result.Messages = append(result.Messages, r.Dispatch(ctx, call))
+		}
+		if err := ctx.Err(); err != nil {
+			return result, err
+		}
+		request.ToolChoice = nil
+	}
+
+	return result, ErrMaxRounds
+}
+
+func complete(ctx context.Context, client Requester, request *ChatRequest) (*chatResponse, error) {
+	resp, err := client.RequestContext(ctx, "POST", "/v1/chat/completions", request)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("infernotools: server returned %d: %s",
+			resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	var response chatResponse
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return nil, err
+	}
+	if len(response.Choices) == 0 {
+		return nil, errors.New("infernotools: no choices in response")
+	}
+
+	return &response, nil
+}
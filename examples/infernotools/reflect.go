@@ -0,0 +1,133 @@
+package infernotools
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"time"
+)
+
+var (
+	timeType      = reflect.TypeOf(time.Time{})
+	rawType       = reflect.TypeOf(json.RawMessage(nil))
+	marshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+)
+
+// SchemaFor derives a JSON Schema from the Go type of v, following
+// encoding/json's field names and rules so a value matching the schema
+// unmarshals into v. Struct fields are required unless tagged omitempty;
+// a `description:"..."` tag documents a field and an `enum:"a,b,c"` tag
+// restricts a string field to the listed values. Types with custom JSON
+// marshalling and interface fields accept any value.
+func SchemaFor(v interface{}) map[string]interface{} {
+	return schemaFor(reflect.TypeOf(v), map[reflect.Type]bool{})
+}
+
+// Validate checks value, as decoded by encoding/json into an interface{},
+// against schema. It understands type, properties, required,
+// additionalProperties: false, items, enum and minimum/maximum, and ignores
+// other keywords.
+func Validate(schema map[string]interface{}, value interface{}) error {
+	return validate(schema, value, "value")
+}
+
+func schemaFor(t reflect.Type, seen map[reflect.Type]bool) map[string]interface{} {
+	if t == nil {
+		return map[string]interface{}{}
+	}
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	switch {
+	case t == timeType:
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	case t == rawType, t.Implements(marshalerType), reflect.PtrTo(t).Implements(marshalerType):
+		return map[string]interface{}{}
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		// encoding/json writes []byte as a base64 string
+		if t.Elem().Kind() == reflect.Uint8 {
+			return map[string]interface{}{"type": "string"}
+		}
+		return map[string]interface{}{"type": "array", "items": schemaFor(t.Elem(), seen)}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": schemaFor(t.Elem(), seen)}
+	case reflect.Struct:
+		// A recursive type is described once; deeper levels accept any object
+		if seen[t] {
+			return map[string]interface{}{"type": "object"}
+		}
+		seen[t] = true
+		defer delete(seen, t)
+		return structSchema(t, seen)
+	default:
+		return map[string]interface{}{}
+	}
+}
+
+func structSchema(t reflect.Type, seen map[reflect.Type]bool) map[string]interface{} {
+	properties := map[string]interface{}{}
+	required := []string{}
+	addFields(t, seen, properties, &required)
+
+	schema := map[string]interface{}{"type": "object", "properties": properties}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+	return schema
+}
+
+// addFields adds t's fields to properties, flattening embedded structs as
+// encoding/json does
+func addFields(t reflect.Type, seen map[reflect.Type]bool, properties map[string]interface{}, required *[]string) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, options, _ := strings.Cut(tag, ",")
+
+		if field.Anonymous && name == "" {
+			embedded := field.Type
+			if embedded.Kind() == reflect.Ptr {
+				embedded = embedded.Elem()
+			}
+			if embedded.Kind() == reflect.Struct {
+				addFields(embedded, seen, properties, required)
+				continue
+			}
+		}
+		if !field.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+
+		property := schemaFor(field.Type, seen)
+		if description := field.Tag.Get("description"); description != "" {
+			property["description"] = description
+		}
+		if enum := field.Tag.Get("enum"); enum != "" {
+			property["enum"] = strings.Split(enum, ",")
+		}
+		properties[name] = property
+
+		if !strings.Contains(","+options+",", ",omitempty,") {
+			*required = append(*required, name)
+		}
+	}
+}
@@ -0,0 +1,226 @@
+// Package infernotools lets a model call Go functions. Functions are
+// registered with a JSON Schema for their arguments; the registry offers
+// them in chat requests, checks each call the model makes against the
+// schema, runs the handler under a timeout with panics recovered, and sends
+// the results back until the model answers in plain text:
+//
+//	registry := infernotools.New()
+//	registry.Register(infernotools.Tool{
+//		Name:        "get_weather",
+//		Description: "Current weather for a city",
+//		Parameters: map[string]interface{}{
+//			"type":       "object",
+//			"properties": map[string]interface{}{"city": map[string]interface{}{"type": "string"}},
+//			"required":   []string{"city"},
+//		},
+//		Handler: func(ctx context.Context, args json.RawMessage) (string, error) { ... },
+//	})
+//
+//	client := NewClient("http://localhost:8080", apiKey)
+//	result, err := registry.Chat(ctx, client, infernotools.ChatRequest{
+//		Model:    "llama-3-8b",
+//		Messages: []infernotools.Message{{Role: "user", Content: "Is it raining in Oslo?"}},
+//	})
+//	fmt.Println(result.Message.Content)
+//
+// A failed call (invalid arguments, handler error, timeout or panic) is
+// reported to the model as the tool's result, so it can correct itself.
+package infernotools
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// DefaultTimeout bounds a handler whose Tool.Timeout is zero
+const DefaultTimeout = 30 * time.Second
+
+// Handler runs a tool with its JSON arguments, already checked against the
+// tool's schema. The returned text is the tool result given to the model.
+type Handler func(ctx context.Context, args json.RawMessage) (string, error)
+
+// Tool is a Go function the model may call
+type Tool struct {
+	Name        string
+	Description string
+	// Parameters is the JSON Schema of the arguments object; nil accepts
+	// any object
+	Parameters map[string]interface{}
+	Handler    Handler
+	// Timeout bounds each call (default DefaultTimeout); a negative value
+	// disables it
+	Timeout time.Duration
+}
+
+// Registry holds the tools offered to the model. It is safe for
+// concurrent use.
+type Registry struct {
+	mu    sync.RWMutex
+	tools map[string]Tool
+}
+
+// New returns an empty Registry
+func New() *Registry {
+	return &Registry{tools: make(map[string]Tool)}
+}
+
+// Register adds a tool; names must be unique
+func (r *Registry) Register(tool Tool) error {
+	if tool.Name == "" || tool.Handler == nil {
+		return errors.New("infernotools: a tool needs a name and a handler")
+	}
+	if tool.Parameters != nil {
+		if typ, ok := tool.Parameters["type"]; ok && typ != "object" {
+			return fmt.Errorf("infernotools: parameters of %q must be an object schema", tool.Name)
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, exists := r.tools[tool.Name]; exists {
+		return fmt.Errorf("infernotools: tool %q is already registered", tool.Name)
+	}
+	r.tools[tool.Name] = tool
+
+	return nil
+}
+
+// Lookup returns the tool registered under name
+func (r *Registry) Lookup(name string) (Tool, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	tool, ok := r.tools[name]
+	return tool, ok
+}
+
+// Definitions returns the tools in the form chat requests expect, sorted by
+// name so requests are stable
+func (r *Registry) Definitions() []ToolDefinition {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	definitions := make([]ToolDefinition, 0, len(r.tools))
+	for _, tool := range r.tools {
+		parameters := tool.Parameters
+		if parameters == nil {
+			parameters = map[string]interface{}{"type": "object"}
+		}
+		definitions = append(definitions, ToolDefinition{
+			Type: "function",
+			Function: FunctionDefinition{
+				Name:        tool.Name,
+				Description: tool.Description,
+				Parameters:  parameters,
+			},
+		})
+	}
+	sort.Slice(definitions, func(i, j int) bool {
+		return definitions[i].Function.Name < definitions[j].Function.Name
+	})
+
+	return definitions
+}
+
+// CallError describes why a tool call produced no result
+type CallError struct {
+	Tool string
+	Err  error
+}
+
+func (e *CallError) Error() string {
+	return fmt.Sprintf("tool %s: %v", e.Tool, e.Err)
+}
+
+func (e *CallError) Unwrap() error {
+	return e.Err
+}
+
+// ErrUnknownTool is wrapped by the CallError of a call to an unregistered
+// tool
+var ErrUnknownTool = errors.New("unknown tool")
+
+// Call runs one tool call and returns its result text. Unknown tools,
+// arguments that do not match the schema, handler errors, timeouts and
+// panics all return a *CallError.
+func (r *Registry) Call(ctx context.Context, call ToolCall) (string, error) {
+	name := call.Function.Name
+	tool, ok := r.Lookup(name)
+	if !ok {
+		return "", &CallError{Tool: name, Err: ErrUnknownTool}
+	}
+
+	args := json.RawMessage(call.Function.Arguments)
+	if len(args) == 0 {
+		args = json.RawMessage("{}")
+	}
+	var decoded interface{}
+	if err := json.Unmarshal(args, &decoded); err != nil {
+		return "", &CallError{Tool: name, Err: fmt.Errorf("arguments are not valid JSON: %w", err)}
+	}
+	if err := validate(tool.Parameters, decoded, "arguments"); err != nil {
+		return "", &CallError{Tool: name, Err: err}
+	}
+
+	timeout := tool.Timeout
+	if timeout == 0 {
+		timeout = DefaultTimeout
+	}
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	text, err := run(ctx, tool.Handler, args)
+	if err != nil {
+		return "", &CallError{Tool: name, Err: err}
+	}
+	return text, nil
+}
+
+// Dispatch runs call and returns the "tool" message answering it. Failures
+// become the message content so the model sees what went wrong.
+func (r *Registry) Dispatch(ctx context.Context, call ToolCall) Message {
+	text, err := r.Call(ctx, call)
+	if err != nil {
+		text = "error: " + err.Error()
+	}
+	return Message{
+		Role:       "tool",
+		Content:    text,
+		Name:       call.Function.Name,
+		ToolCallID: call.ID,
+	}
+}
+
+type outcome struct {
+	text string
+	err  error
+}
+
+// run calls handler on its own goroutine, so a handler that ignores ctx
+// cannot hold the caller past the timeout, and turns a panic into an error
+func run(ctx context.Context, handler Handler, args json.RawMessage) (string, error) {
+	done := make(chan outcome, 1)
+	go func() {
+		defer func() {
+			if recovered := recover(); recovered != nil {
+				done <- outcome{err: fmt.Errorf("panic: %v", recovered)}
+			}
+		}()
+		text, err := handler(ctx, args)
+		done <- outcome{text: text, err: err}
+	}()
+
+	select {
+	case result := <-done:
+		return result.text, result.err
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+}
@@ -0,0 +1,193 @@
+package infernotools
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// validate checks value against the subset of JSON Schema that tool
+// parameters use in practice: type, properties, required,
+// additionalProperties: false, items, enum, and minimum/maximum. Other
+// keywords are ignored rather than rejected. path names value in errors.
+func validate(schema map[string]interface{}, value interface{}, path string) error {
+	if schema == nil {
+		return nil
+	}
+
+	if typ, ok := schema["type"]; ok {
+		if err := checkType(typ, value, path); err != nil {
+			return err
+		}
+	}
+
+	if enum, ok := schema["enum"]; ok {
+		if err := checkEnum(enum, value, path); err != nil {
+			return err
+		}
+	}
+
+	switch value := value.(type) {
+	case map[string]interface{}:
+		return validateObject(schema, value, path)
+	case []interface{}:
+		items, _ := asSchema(schema["items"])
+		for i, item := range value {
+			if err := validate(items, item, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+				return err
+			}
+		}
+	case float64:
+		if minimum, ok := asNumber(schema["minimum"]); ok && value < minimum {
+			return fmt.Errorf("%s must be at least %v", path, minimum)
+		}
+		if maximum, ok := asNumber(schema["maximum"]); ok && value > maximum {
+			return fmt.Errorf("%s must be at most %v", path, maximum)
+		}
+	}
+
+	return nil
+}
+
+func validateObject(schema map[string]interface{}, object map[string]interface{}, path string) error {
+	for _, name := range asStrings(schema["required"]) {
+		if _, ok := object[name]; !ok {
+			return fmt.Errorf("%s is missing required field %q", path, name)
+		}
+	}
+
+	properties, _ := asSchema(schema["properties"])
+	names := make([]string, 0, len(object))
+	for name := range object {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		property, known := asSchema(properties[name])
+		if !known {
+			if schema["additionalProperties"] == false {
+				return fmt.Errorf("%s has unexpected field %q", path, name)
+			}
+			continue
+		}
+		if err := validate(property, object[name], path+"."+name); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// checkType accepts a type name or a list of them
+func checkType(typ interface{}, value interface{}, path string) error {
+	types := asStrings(typ)
+	if name, ok := typ.(string); ok {
+		types = []string{name}
+	}
+	if len(types) == 0 {
+		return nil
+	}
+
+	for _, name := range types {
+		if hasType(name, value) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%s must be of type %s", path, strings.Join(types, " or "))
+}
+
+func hasType(name string, value interface{}) bool {
+	switch name {
+	case "object":
+		_, ok := value.(map[string]interface{})
+		return ok
+	case "array":
+		_, ok := value.([]interface{})
+		return ok
+	case "string":
+		_, ok := value.(string)
+		return ok
+	case "boolean":
+		_, ok := value.(bool)
+		return ok
+	case "number":
+		_, ok := value.(float64)
+		return ok
+	case "integer":
+		number, ok := value.(float64)
+		return ok && number == math.Trunc(number)
+	case "null":
+		return value == nil
+	default:
+		// Unknown type names are not ours to reject
+		return true
+	}
+}
+
+func checkEnum(enum interface{}, value interface{}, path string) error {
+	options := reflect.ValueOf(enum)
+	if options.Kind() != reflect.Slice {
+		return nil
+	}
+
+	for i := 0; i < options.Len(); i++ {
+		option := options.Index(i).Interface()
+		if number, ok := asNumber(option); ok {
+			option = number
+		}
+		if reflect.DeepEqual(option, value) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%s must be one of %v", path, enum)
+}
+
+// asSchema accepts the map types a hand-written schema literal may use
+func asSchema(value interface{}) (map[string]interface{}, bool) {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		return value, true
+	case map[string]map[string]interface{}:
+		converted := make(map[string]interface{}, len(value))
+		for name, property := range value {
+			converted[name] = property
+		}
+		return converted, true
+	}
+	return nil, false
+}
+
+// asStrings accepts []string as well as decoded []interface{} lists
+func asStrings(value interface{}) []string {
+	switch value := value.(type) {
+	case []string:
+		return value
+	case []interface{}:
+		strs := make([]string, 0, len(value))
+		for _, item := range value {
+			if str, ok := item.(string); ok {
+				strs = append(strs, str)
+			}
+		}
+		return strs
+	}
+	return nil
+}
+
+// asNumber converts schema numbers, which may be any Go numeric type, to
+// the float64 that decoded JSON uses
+func asNumber(value interface{}) (float64, bool) {
+	number := reflect.ValueOf(value)
+	switch number.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(number.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(number.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		return number.Float(), true
+	}
+	return 0, false
+}
@@ -0,0 +1,90 @@
+//! Admin Authorization for Operational Endpoints
+//!
+//! Endpoints that expose other clients' requests or change server behaviour
+//! are restricted to holders of the admin token configured through the
+//! `INFERNO_ADMIN_TOKEN` environment variable. When the variable is unset the
+//! admin endpoints are disabled rather than left open.
+
+use crate::api::audit_events::{self, AuditKind};
+use axum::{
+    Json,
+    http::{HeaderMap, StatusCode, header},
+    response::{IntoResponse, Response},
+};
+use serde_json::json;
+
+/// Environment variable holding the shared admin bearer token
+pub const ADMIN_TOKEN_ENV: &str = "INFERNO_ADMIN_TOKEN";
+
+/// Verify that the request carries the configured admin bearer token.
+///
+/// Returns the error response to send back when the caller is not an admin.
+pub fn authorize_admin(headers: &HeaderMap) -> Result<(), Response> {
+    let expected = match std::env::var(ADMIN_TOKEN_ENV) {
+        Ok(token) if !token.is_empty() => token,
+        _ => {
+            return Err(admin_error(
+                StatusCode::FORBIDDEN,
+                format!(
+                    "Admin endpoints are disabled: set {} to enable them",
+                    ADMIN_TOKEN_ENV
+                ),
+            ));
+        }
+    };
+
+    let provided = headers
+        .get(header::AUTHORIZATION)
+        .and_then(|value| value.to_str().ok())
+        .and_then(|value| value.strip_prefix("Bearer "))
+        .unwrap_or_default();
+
+    if constant_time_eq(provided.as_bytes(), expected.as_bytes()) {
+        Ok(())
+    } else {
+        Err(admin_error(
+            StatusCode::UNAUTHORIZED,
+            "Admin token missing or invalid".to_string(),
+        ))
+    }
+}
+
+fn admin_error(status: StatusCode, message: String) -> Response {
+    let response = (
+        status,
+        Json(json!({
+            "error": {
+                "message": message,
+                "type": "authentication_error",
+                "param": null,
+                "code": null
+            }
+        })),
+    )
+        .into_response();
+    audit_events::mark(response, AuditKind::AuthFailure, None, &message)
+}
+
+/// Compare two byte strings without short-circuiting on the first mismatch
+fn constant_time_eq(a: &[u8], b: &[u8]) -> bool {
+    if a.len() != b.len() {
+        return false;
+    }
+    a.iter()
+        .zip(b.iter())
+        .fold(0u8, |acc, (x, y)| acc | (x ^ y))
+        == 0
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_constant_time_eq() {
+        assert!(constant_time_eq(b"secret-token", b"secret-token"));
+        assert!(!constant_time_eq(b"secret-token", b"secret-tokem"));
+        assert!(!constant_time_eq(b"short", b"longer-value"));
+        assert!(constant_time_eq(b"", b""));
+    }
+}
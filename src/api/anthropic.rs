@@ -0,0 +1,560 @@
+//! Anthropic Messages API Compatibility
+//!
+//! `POST /v1/messages` accepts requests in the shape of Anthropic's Messages
+//! API (a top-level `system` prompt, `user`/`assistant` messages whose content
+//! is a string or a list of content blocks, a required `max_tokens`) and
+//! answers in the same shape, including the named server-sent events of a
+//! streamed message. Tools written against the Anthropic SDKs work by
+//! pointing their base URL at Inferno. Only text content blocks can be
+//! served; images, documents and tool use are rejected. Errors use
+//! Anthropic's `{"type": "error", "error": {...}}` envelope.
+//!
+//! Requests go through the same routing, queue, cancellation and deadline
+//! handling as the OpenAI-compatible endpoints.
+
+use crate::{
+    api::{
+        cancellation::{FinishReason, generate_cancellable, next_token, with_request_id},
+        deadline::resolve_deadline,
+        openai::{ChatMessage, estimate_tokens, format_chat_messages, get_or_load_backend},
+        queue::{QueueTicket, priority_from_headers},
+        routing::with_route,
+    },
+    backends::{BackendHandle, InferenceParams},
+    cli::serve::ServerState,
+};
+use axum::{
+    Json,
+    extract::State,
+    http::{HeaderMap, StatusCode},
+    response::{
+        IntoResponse, Response,
+        sse::{Event, KeepAlive, Sse},
+    },
+};
+use serde::{Deserialize, Serialize};
+use serde_json::json;
+use std::sync::Arc;
+use uuid::Uuid;
+
+/// Body of `POST /v1/messages`
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct MessagesRequest {
+    pub model: String,
+    pub messages: Vec<InputMessage>,
+    pub max_tokens: u32,
+    #[serde(default)]
+    pub system: Option<MessageContent>,
+    #[serde(default)]
+    pub stop_sequences: Option<Vec<String>>,
+    #[serde(default)]
+    pub stream: bool,
+    /// Defaults to 1.0, as in Anthropic's API
+    #[serde(default)]
+    pub temperature: Option<f32>,
+    #[serde(default)]
+    pub top_p: Option<f32>,
+    #[serde(default)]
+    pub top_k: Option<u32>,
+    #[serde(default)]
+    pub metadata: Option<RequestMetadata>,
+    /// Server-enforced time budget for the generation, in milliseconds
+    #[serde(default)]
+    pub timeout_ms: Option<u64>,
+    /// Absolute deadline for the generation (RFC 3339)
+    #[serde(default)]
+    pub deadline: Option<chrono::DateTime<chrono::Utc>>,
+}
+
+#[derive(Debug, Clone, Default, Serialize, Deserialize)]
+pub struct RequestMetadata {
+    /// Opaque end-user ID, used for sticky routing
+    #[serde(default)]
+    pub user_id: Option<String>,
+}
+
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct InputMessage {
+    pub role: String,
+    pub content: MessageContent,
+}
+
+/// A plain string or a list of content blocks
+#[derive(Debug, Clone, Serialize, Deserialize)]
+#[serde(untagged)]
+pub enum MessageContent {
+    Text(String),
+    Blocks(Vec<ContentBlock>),
+}
+
+#[derive(Debug, Clone, Serialize, Deserialize)]
+#[serde(tag = "type", rename_all = "snake_case")]
+pub enum ContentBlock {
+    Text {
+        text: String,
+    },
+    /// Images, documents, tool use and anything else Inferno cannot serve
+    #[serde(other)]
+    Unsupported,
+}
+
+impl MessageContent {
+    /// The text of the content, or `None` if it has non-text blocks
+    fn text(&self) -> Option<String> {
+        match self {
+            MessageContent::Text(text) => Some(text.clone()),
+            MessageContent::Blocks(blocks) => blocks
+                .iter()
+                .map(|block| match block {
+                    ContentBlock::Text { text } => Some(text.as_str()),
+                    ContentBlock::Unsupported => None,
+                })
+                .collect::<Option<Vec<_>>>()
+                .map(|parts| parts.join("\n")),
+        }
+    }
+}
+
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct MessagesUsage {
+    pub input_tokens: u32,
+    pub output_tokens: u32,
+}
+
+/// Response of a non-streamed request
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct MessageResponse {
+    pub id: String,
+    #[serde(rename = "type")]
+    pub kind: String,
+    pub role: String,
+    pub model: String,
+    pub content: Vec<ContentBlock>,
+    pub stop_reason: Option<String>,
+    pub stop_sequence: Option<String>,
+    pub usage: MessagesUsage,
+}
+
+/// Convert the request to Inferno's chat messages, system prompt first
+fn chat_messages(request: &MessagesRequest) -> Result<Vec<ChatMessage>, (String, String)> {
+    let unsupported = |param: String| ("only text content blocks are supported".to_string(), param);
+
+    let mut messages = Vec::with_capacity(request.messages.len() + 1);
+    if let Some(system) = &request.system {
+        let content = system
+            .text()
+            .ok_or_else(|| unsupported("system".to_string()))?;
+        messages.push(ChatMessage {
+            role: "system".to_string(),
+            content,
+            name: None,
+            tool_calls: None,
+            tool_call_id: None,
+        });
+    }
+
+    if request.messages.is_empty() {
+        return Err((
+            "messages must contain at least one message".to_string(),
+            "messages".to_string(),
+        ));
+    }
+    for (index, message) in request.messages.iter().enumerate() {
+        if message.role != "user" && message.role != "assistant" {
+            return Err((
+                format!(
+                    "messages.{}.role must be \"user\" or \"assistant\", not \"{}\"",
+                    index, message.role
+                ),
+                format!("messages.{}.role", index),
+            ));
+        }
+        let content = message
+            .content
+            .text()
+            .ok_or_else(|| unsupported(format!("messages.{}.content", index)))?;
+        messages.push(ChatMessage {
+            role: message.role.clone(),
+            content,
+            name: None,
+            tool_calls: None,
+            tool_call_id: None,
+        });
+    }
+    Ok(messages)
+}
+
+/// Anthropic's `stop_reason` for how a generation ended. Backends stop at
+/// stop sequences without reporting which one matched, so that case reads as
+/// `end_turn`; cancellations and timeouts keep Inferno's own reasons.
+fn stop_reason(finish_reason: FinishReason, output_tokens: u32, max_tokens: u32) -> String {
+    match finish_reason {
+        FinishReason::Stop if output_tokens >= max_tokens => "max_tokens".to_string(),
+        FinishReason::Stop => "end_turn".to_string(),
+        reason => reason.as_str().to_string(),
+    }
+}
+
+/// An error in Anthropic's envelope
+fn api_error(status: StatusCode, kind: &str, message: String) -> Response {
+    (
+        status,
+        Json(json!({
+            "type": "error",
+            "error": {
+                "type": kind,
+                "message": message
+            }
+        })),
+    )
+        .into_response()
+}
+
+fn sse_event(kind: &str, data: serde_json::Value) -> Result<Event, axum::Error> {
+    Ok(Event::default().event(kind).data(data.to_string()))
+}
+
+// API Handlers
+
+/// `POST /v1/messages` - create a message, Anthropic style
+pub async fn create_message(
+    State(state): State<Arc<ServerState>>,
+    headers: HeaderMap,
+    Json(mut request): Json<MessagesRequest>,
+) -> Response {
+    let started = std::time::Instant::now();
+
+    if request.max_tokens == 0 {
+        return api_error(
+            StatusCode::BAD_REQUEST,
+            "invalid_request_error",
+            "max_tokens must be at least 1".to_string(),
+        );
+    }
+    let messages = match chat_messages(&request) {
+        Ok(messages) => messages,
+        Err((message, param)) => {
+            return api_error(
+                StatusCode::BAD_REQUEST,
+                "invalid_request_error",
+                format!("{}: {}", param, message),
+            );
+        }
+    };
+
+    // Resolve a routing alias to the arm that will serve this request
+    let user = request
+        .metadata
+        .as_ref()
+        .and_then(|metadata| metadata.user_id.clone());
+    let route = state
+        .model_router
+        .route(&request.model, user.as_deref())
+        .await;
+    if let Some(route) = &route {
+        request.model = route.model.clone();
+    }
+
+    let ticket = match state.request_queue.enqueue_request(
+        &headers,
+        &request.model,
+        priority_from_headers(&headers),
+    ) {
+        Ok(ticket) => ticket
+            .with_scheduling(None, &headers)
+            .with_deadline(resolve_deadline(request.timeout_ms, request.deadline)),
+        Err(response) => return response,
+    };
+    let request_id = ticket.id().to_string();
+
+    let prompt = format_chat_messages(&messages);
+    let backend = match get_or_load_backend(&state, &request.model).await {
+        Ok(backend) => backend,
+        Err(e) => {
+            return api_error(
+                StatusCode::NOT_FOUND,
+                "not_found_error",
+                format!("Failed to load model {}: {}", request.model, e),
+            );
+        }
+    };
+
+    let params = InferenceParams {
+        max_tokens: request.max_tokens,
+        temperature: request.temperature.unwrap_or(1.0),
+        top_k: request.top_k.unwrap_or(40),
+        top_p: request.top_p.unwrap_or(0.9),
+        stream: request.stream,
+        stop_sequences: request.stop_sequences.clone().unwrap_or_default(),
+        seed: None,
+        stop_token_ids: vec![],
+        ignore_eos: false,
+        skip_special_tokens: None,
+        prompt_token_ids: None,
+    };
+
+    let response = if request.stream {
+        stream_message(&request, backend, prompt, params, ticket)
+    } else {
+        complete_message(&request, backend, prompt, params, ticket).await
+    };
+
+    // Feed routed outcomes to any canary rollout watching the alias
+    if let Some(route) = &route {
+        state
+            .rollouts
+            .record_outcome(
+                route,
+                started.elapsed(),
+                response.status().is_server_error(),
+            )
+            .await;
+    }
+
+    with_route(with_request_id(response, &request_id), route.as_ref())
+}
+
+async fn complete_message(
+    request: &MessagesRequest,
+    backend: BackendHandle,
+    prompt: String,
+    params: InferenceParams,
+    ticket: QueueTicket,
+) -> Response {
+    ticket.start().await;
+
+    match generate_cancellable(
+        &backend,
+        &prompt,
+        &params,
+        ticket.cancel_signal(),
+        ticket.deadline(),
+    )
+    .await
+    {
+        Ok(generation) => {
+            let output_tokens = estimate_tokens(&generation.text);
+            let response = MessageResponse {
+                id: format!("msg_{}", Uuid::new_v4().simple()),
+                kind: "message".to_string(),
+                role: "assistant".to_string(),
+                model: request.model.clone(),
+                stop_reason: Some(stop_reason(
+                    generation.finish_reason,
+                    output_tokens,
+                    request.max_tokens,
+                )),
+                stop_sequence: None,
+                content: vec![ContentBlock::Text {
+                    text: generation.text,
+                }],
+                usage: MessagesUsage {
+                    input_tokens: estimate_tokens(&prompt),
+                    output_tokens,
+                },
+            };
+            Json(response).into_response()
+        }
+        Err(e) => api_error(
+            StatusCode::INTERNAL_SERVER_ERROR,
+            "api_error",
+            format!("Inference failed: {}", e),
+        ),
+    }
+}
+
+/// Stream the message as Anthropic's `message_start`, `content_block_*`,
+/// `message_delta` and `message_stop` events
+fn stream_message(
+    request: &MessagesRequest,
+    backend: BackendHandle,
+    prompt: String,
+    params: InferenceParams,
+    ticket: QueueTicket,
+) -> Response {
+    let model = request.model.clone();
+    let max_tokens = request.max_tokens;
+    let message_id = format!("msg_{}", Uuid::new_v4().simple());
+
+    let stream = async_stream::stream! {
+        ticket.start().await;
+
+        let mut token_stream = match backend.infer_stream(&prompt, &params).await {
+            Ok(token_stream) => token_stream,
+            Err(e) => {
+                yield sse_event("error", json!({
+                    "type": "error",
+                    "error": {"type": "api_error", "message": format!("Stream failed: {}", e)}
+                }));
+                return;
+            }
+        };
+
+        yield sse_event("message_start", json!({
+            "type": "message_start",
+            "message": {
+                "id": message_id,
+                "type": "message",
+                "role": "assistant",
+                "model": model,
+                "content": [],
+                "stop_reason": null,
+                "stop_sequence": null,
+                "usage": {"input_tokens": estimate_tokens(&prompt), "output_tokens": 0}
+            }
+        }));
+        yield sse_event("content_block_start", json!({
+            "type": "content_block_start",
+            "index": 0,
+            "content_block": {"type": "text", "text": ""}
+        }));
+        yield sse_event("ping", json!({"type": "ping"}));
+
+        // Stream tokens until the backend finishes or the request is
+        // cancelled or times out; dropping the token stream stops generation
+        let mut finish_reason = FinishReason::Stop;
+        let mut output_tokens = 0u32;
+        loop {
+            let next = next_token(&mut token_stream, ticket.cancel_signal(), ticket.deadline()).await;
+            let token = match next {
+                Ok(Some(Ok(token))) => token,
+                Ok(Some(Err(e))) => {
+                    tracing::error!("Stream error: {}", e);
+                    yield sse_event("error", json!({
+                        "type": "error",
+                        "error": {"type": "api_error", "message": format!("Stream failed: {}", e)}
+                    }));
+                    return;
+                }
+                Ok(None) => break,
+                Err(reason) => {
+                    finish_reason = reason;
+                    break;
+                }
+            };
+            output_tokens += 1;
+            yield sse_event("content_block_delta", json!({
+                "type": "content_block_delta",
+                "index": 0,
+                "delta": {"type": "text_delta", "text": token}
+            }));
+        }
+
+        yield sse_event("content_block_stop", json!({
+            "type": "content_block_stop",
+            "index": 0
+        }));
+        yield sse_event("message_delta", json!({
+            "type": "message_delta",
+            "delta": {
+                "stop_reason": stop_reason(finish_reason, output_tokens, max_tokens),
+                "stop_sequence": null
+            },
+            "usage": {"output_tokens": output_tokens}
+        }));
+        yield sse_event("message_stop", json!({"type": "message_stop"}));
+    };
+
+    Sse::new(stream)
+        .keep_alive(KeepAlive::default())
+        .into_response()
+}
+
+/// Body of `POST /v1/messages/count_tokens`
+#[derive(Debug, Clone, Deserialize)]
+pub struct CountTokensRequest {
+    pub model: String,
+    pub messages: Vec<InputMessage>,
+    #[serde(default)]
+    pub system: Option<MessageContent>,
+}
+
+/// `POST /v1/messages/count_tokens` - estimate a request's input tokens
+pub async fn count_tokens(Json(request): Json<CountTokensRequest>) -> Response {
+    let request = MessagesRequest {
+        model: request.model,
+        messages: request.messages,
+        max_tokens: 1,
+        system: request.system,
+        stop_sequences: None,
+        stream: false,
+        temperature: None,
+        top_p: None,
+        top_k: None,
+        metadata: None,
+        timeout_ms: None,
+        deadline: None,
+    };
+    match chat_messages(&request) {
+        Ok(messages) => Json(json!({
+            "input_tokens": estimate_tokens(&format_chat_messages(&messages))
+        }))
+        .into_response(),
+        Err((message, param)) => api_error(
+            StatusCode::BAD_REQUEST,
+            "invalid_request_error",
+            format!("{}: {}", param, message),
+        ),
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn request(body: serde_json::Value) -> MessagesRequest {
+        serde_json::from_value(body).unwrap()
+    }
+
+    #[test]
+    fn flattens_system_and_text_blocks() {
+        let request = request(json!({
+            "model": "llama",
+            "max_tokens": 256,
+            "system": [{"type": "text", "text": "Be brief."}],
+            "messages": [
+                {"role": "user", "content": "Hi"},
+                {"role": "assistant", "content": [{"type": "text", "text": "Hello"}]},
+                {"role": "user", "content": [
+                    {"type": "text", "text": "Tell me"},
+                    {"type": "text", "text": "a joke"}
+                ]}
+            ]
+        }));
+
+        let messages = chat_messages(&request).unwrap();
+        let roles: Vec<&str> = messages.iter().map(|m| m.role.as_str()).collect();
+        assert_eq!(roles, ["system", "user", "assistant", "user"]);
+        assert_eq!(messages[0].content, "Be brief.");
+        assert_eq!(messages[3].content, "Tell me\na joke");
+    }
+
+    #[test]
+    fn rejects_non_text_blocks_and_unknown_roles() {
+        let image = request(json!({
+            "model": "llama",
+            "max_tokens": 16,
+            "messages": [{"role": "user", "content": [
+                {"type": "image", "source": {"type": "base64", "media_type": "image/png", "data": ""}}
+            ]}]
+        }));
+        assert_eq!(chat_messages(&image).unwrap_err().1, "messages.0.content");
+
+        let system_role = request(json!({
+            "model": "llama",
+            "max_tokens": 16,
+            "messages": [{"role": "system", "content": "Hi"}]
+        }));
+        assert_eq!(
+            chat_messages(&system_role).unwrap_err().1,
+            "messages.0.role"
+        );
+    }
+
+    #[test]
+    fn maps_stop_reasons() {
+        assert_eq!(stop_reason(FinishReason::Stop, 10, 100), "end_turn");
+        assert_eq!(stop_reason(FinishReason::Stop, 100, 100), "max_tokens");
+        assert_eq!(stop_reason(FinishReason::Timeout, 3, 100), "timeout");
+    }
+}
@@ -0,0 +1,779 @@
+//! Scoped API Keys
+//!
+//! An admin creates API keys through `/admin/keys` and can scope each one:
+//! to a list of models, to endpoint path prefixes (an embeddings-only key
+//! has `"endpoints": ["/v1/embeddings"]`) and to sampling limits on
+//! `max_tokens`, `n` and `temperature`. A key can belong to a tenant, whose
+//! limits, budgets and usage its requests then count against. The secret is
+//! returned once, when the key is created; the server keeps only its SHA-256
+//! digest.
+//!
+//! The [`enforce_scopes`] middleware refuses requests made with a managed
+//! key that step outside its scopes, with 403 and a code naming the scope,
+//! and revoked keys with 401. Bearer tokens the store does not know, such as
+//! the admin token and JWTs, pass through as before. A key can always call
+//! the read-only routes in [`ALWAYS_ALLOWED`], matched by method and exact
+//! path: listing models, which shows only the models it may use, its usage,
+//! quota and budgets, and `GET /v1/keys/current`, which describes the key. Keys are held in memory.
+
+use crate::{
+    api::{
+        admin::authorize_admin,
+        audit_events::{self, AuditKind},
+        error_response,
+        runtime_config::sampling_defaults,
+        tenants::{model_from_path, validate_tenant_id},
+    },
+    cli::serve::ServerState,
+};
+use axum::{
+    Json,
+    body::Body,
+    extract::{Path, Request, State},
+    http::{HeaderMap, Method, StatusCode, header},
+    middleware::Next,
+    response::{IntoResponse, Response},
+};
+use chrono::{DateTime, Utc};
+use serde::{Deserialize, Serialize};
+use serde_json::json;
+use sha2::{Digest, Sha256};
+use std::{
+    collections::HashMap,
+    sync::{Arc, Mutex},
+};
+use tracing::info;
+
+/// Prefix of every key the server issues
+pub const KEY_PREFIX: &str = "sk-inferno-";
+
+/// Largest body the middleware buffers to read `model` and the sampling
+/// parameters, matching axum's default JSON body limit
+const MAX_INSPECTED_BODY: usize = 2 * 1024 * 1024;
+
+/// Characters of the secret kept to tell keys apart in listings
+const DISPLAY_PREFIX_LEN: usize = KEY_PREFIX.len() + 6;
+
+/// Routes every managed key may call, whatever its endpoint scope, as a
+/// method and an exact path; `*` stands for one path segment
+const ALWAYS_ALLOWED: &[(&str, &str)] = &[
+    ("GET", "/health"),
+    ("GET", "/health/live"),
+    ("GET", "/health/ready"),
+    ("GET", "/health/deep"),
+    ("GET", "/v1/models"),
+    ("GET", "/v1/models/*"),
+    ("GET", "/v1/keys/current"),
+    ("GET", "/usage"),
+    ("GET", "/usage/quota"),
+    ("GET", "/v1/budget"),
+];
+
+/// Routes under `/v1/models/` that are not a model id, so `*` does not
+/// stand for them
+const MODEL_ROUTES: &[&str] = &["events", "download", "downloads"];
+
+/// True when `method` and `path` name a route in [`ALWAYS_ALLOWED`]
+fn always_allowed(method: &Method, path: &str) -> bool {
+    let path = path.trim_end_matches('/');
+    ALWAYS_ALLOWED.iter().any(|(allowed_method, pattern)| {
+        if method.as_str() != *allowed_method {
+            return false;
+        }
+        let mut segments = path.split('/');
+        let mut patterns = pattern.split('/');
+        loop {
+            match (segments.next(), patterns.next()) {
+                (None, None) => return true,
+                (Some(segment), Some("*")) => {
+                    if segment.is_empty() || MODEL_ROUTES.contains(&segment) {
+                        return false;
+                    }
+                }
+                (Some(segment), Some(expected)) if segment == expected => {}
+                _ => return false,
+            }
+        }
+    })
+}
+
+/// What a key may do; empty lists and unset limits are unrestricted
+#[derive(Debug, Clone, Default, PartialEq, Serialize, Deserialize)]
+pub struct KeyScopes {
+    /// Models the key may use
+    #[serde(default)]
+    pub models: Vec<String>,
+    /// Path prefixes the key may call, such as `/v1/embeddings`
+    #[serde(default)]
+    pub endpoints: Vec<String>,
+    /// Largest `max_tokens` a request may ask for
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub max_tokens: Option<u64>,
+    /// Largest `n` a request may ask for
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub max_n: Option<u64>,
+    /// Highest `temperature` a request may ask for
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub max_temperature: Option<f32>,
+}
+
+impl KeyScopes {
+    fn validate(&self) -> Result<(), (String, &'static str)> {
+        if self.models.iter().any(|m| m.trim().is_empty()) {
+            return Err((
+                "models may not contain empty model ids".to_string(),
+                "scopes.models",
+            ));
+        }
+        if self.endpoints.iter().any(|e| !e.starts_with('/')) {
+            return Err((
+                "endpoints must be paths starting with '/'".to_string(),
+                "scopes.endpoints",
+            ));
+        }
+        if self.max_tokens == Some(0) {
+            return Err((
+                "max_tokens must be positive; omit it for no limit".to_string(),
+                "scopes.max_tokens",
+            ));
+        }
+        if self.max_n == Some(0) {
+            return Err((
+                "max_n must be positive; omit it for no limit".to_string(),
+                "scopes.max_n",
+            ));
+        }
+        if let Some(temperature) = self.max_temperature
+            && !(0.0..=2.0).contains(&temperature)
+        {
+            return Err((
+                "max_temperature must be between 0 and 2".to_string(),
+                "scopes.max_temperature",
+            ));
+        }
+        Ok(())
+    }
+
+    pub fn allows_model(&self, model: &str) -> bool {
+        self.models.is_empty() || self.models.iter().any(|m| m == model)
+    }
+
+    /// True when `path` is under one of the key's endpoint prefixes; the
+    /// routes in [`ALWAYS_ALLOWED`] are checked separately
+    pub fn allows_endpoint(&self, path: &str) -> bool {
+        let matches = |prefix: &str| {
+            let prefix = prefix.trim_end_matches('/');
+            path == prefix || path.starts_with(&format!("{}/", prefix))
+        };
+        self.endpoints.is_empty() || self.endpoints.iter().any(|p| matches(p))
+    }
+
+    /// True when requests need their body read to be checked
+    fn inspects_body(&self) -> bool {
+        !self.models.is_empty()
+            || self.max_tokens.is_some()
+            || self.max_n.is_some()
+            || self.max_temperature.is_some()
+    }
+
+    /// Check a request's sampling parameters, returning the message and
+    /// parameter of the first one over its limit
+    fn check_sampling(&self, summary: &RequestSummary) -> Result<(), (String, &'static str)> {
+        if let Some(limit) = self.max_tokens {
+            let requested = summary
+                .max_tokens
+                .unwrap_or_else(|| sampling_defaults().max_tokens as u64);
+            if requested > limit {
+                let message = match summary.max_tokens {
+                    Some(_) => format!(
+                        "max_tokens {} is more than this key allows ({})",
+                        requested, limit
+                    ),
+                    None => format!(
+                        "This key allows at most {} max_tokens; set max_tokens, as the default is {}",
+                        limit, requested
+                    ),
+                };
+                return Err((message, "max_tokens"));
+            }
+        }
+        if let Some(limit) = self.max_n
+            && let Some(n) = summary.n
+            && n > limit
+        {
+            return Err((
+                format!("n {} is more than this key allows ({})", n, limit),
+                "n",
+            ));
+        }
+        if let Some(limit) = self.max_temperature {
+            let requested = summary
+                .temperature
+                .unwrap_or_else(|| sampling_defaults().temperature);
+            if requested > limit {
+                return Err((
+                    format!(
+                        "temperature {} is higher than this key allows ({})",
+                        requested, limit
+                    ),
+                    "temperature",
+                ));
+            }
+        }
+        Ok(())
+    }
+}
+
+/// A key as returned by `/admin/keys` and `/v1/keys/current`
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct ApiKey {
+    pub object: String,
+    pub id: String,
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub name: Option<String>,
+    /// The start of the secret, to tell keys apart
+    pub prefix: String,
+    /// Tenant the key's requests are counted against
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub tenant: Option<String>,
+    pub scopes: KeyScopes,
+    pub revoked: bool,
+    pub created_at: DateTime<Utc>,
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub last_used_at: Option<DateTime<Utc>>,
+}
+
+/// A new key with its secret, which is not shown again
+#[derive(Debug, Clone, Serialize)]
+pub struct CreatedApiKey {
+    #[serde(flatten)]
+    pub key: ApiKey,
+    pub secret: String,
+}
+
+#[derive(Debug, Clone, Deserialize)]
+pub struct CreateKeyRequest {
+    #[serde(default)]
+    pub name: Option<String>,
+    #[serde(default)]
+    pub tenant: Option<String>,
+    #[serde(default)]
+    pub scopes: KeyScopes,
+}
+
+/// Fields to change on a key; absent fields are left as they are
+#[derive(Debug, Clone, Default, Deserialize)]
+pub struct UpdateKeyRequest {
+    #[serde(default)]
+    pub name: Option<String>,
+    /// New tenant; an empty string leaves the key without one
+    #[serde(default)]
+    pub tenant: Option<String>,
+    #[serde(default)]
+    pub scopes: Option<KeyScopes>,
+}
+
+#[derive(Debug)]
+struct KeyState {
+    id: String,
+    name: Option<String>,
+    prefix: String,
+    tenant: Option<String>,
+    scopes: KeyScopes,
+    revoked: bool,
+    created_at: DateTime<Utc>,
+    last_used_at: Option<DateTime<Utc>>,
+}
+
+impl KeyState {
+    fn key(&self) -> ApiKey {
+        ApiKey {
+            object: "api_key".to_string(),
+            id: self.id.clone(),
+            name: self.name.clone(),
+            prefix: self.prefix.clone(),
+            tenant: self.tenant.clone(),
+            scopes: self.scopes.clone(),
+            revoked: self.revoked,
+            created_at: self.created_at,
+            last_used_at: self.last_used_at,
+        }
+    }
+}
+
+/// The managed key a request presented
+#[derive(Debug, Clone)]
+pub struct KeyScope {
+    pub id: String,
+    pub tenant: Option<String>,
+    pub scopes: KeyScopes,
+    pub revoked: bool,
+}
+
+impl KeyScope {
+    /// The OpenAI `permission` entry for `model`, with the key's endpoint
+    /// scope and sampling limits
+    pub fn model_permission(&self, model: &str) -> serde_json::Value {
+        let samples = self.scopes.allows_endpoint("/v1/completions")
+            || self.scopes.allows_endpoint("/v1/chat/completions");
+        json!({
+            "id": format!("modelperm-{}", self.id),
+            "object": "model_permission",
+            "key_id": self.id,
+            "allow_view": true,
+            "allow_sampling": samples && self.scopes.allows_model(model),
+            "endpoints": self.scopes.endpoints,
+            "max_tokens": self.scopes.max_tokens,
+            "max_n": self.scopes.max_n,
+            "max_temperature": self.scopes.max_temperature,
+        })
+    }
+}
+
+fn digest(secret: &str) -> String {
+    hex::encode(Sha256::digest(secret.as_bytes()))
+}
+
+/// The bearer token of a request
+fn bearer(headers: &HeaderMap) -> Option<&str> {
+    headers
+        .get(header::AUTHORIZATION)
+        .and_then(|value| value.to_str().ok())
+        .and_then(|value| value.strip_prefix("Bearer "))
+        .map(str::trim)
+}
+
+/// The digest of a request's bearer token, which identifies the caller
+/// without keeping the token itself
+pub fn bearer_digest(headers: &HeaderMap) -> Option<String> {
+    bearer(headers)
+        .filter(|token| !token.is_empty())
+        .map(digest)
+}
+
+/// Managed keys, by the digest of their secret
+#[derive(Default)]
+pub struct ApiKeyStore {
+    keys: Mutex<HashMap<String, KeyState>>,
+}
+
+// Digests stay out of logs along with everything else about the keys
+impl std::fmt::Debug for ApiKeyStore {
+    fn fmt(&self, f: &mut std::fmt::Formatter<'_>) -> std::fmt::Result {
+        f.debug_struct("ApiKeyStore")
+            .field("keys", &self.keys.lock().unwrap().len())
+            .finish()
+    }
+}
+
+impl ApiKeyStore {
+    pub fn new() -> Self {
+        Self::default()
+    }
+
+    fn create(&self, request: CreateKeyRequest) -> CreatedApiKey {
+        let secret = format!("{}{}", KEY_PREFIX, hex::encode(rand::random::<[u8; 24]>()));
+        let state = KeyState {
+            id: format!("key_{}", hex::encode(rand::random::<[u8; 8]>())),
+            name: request.name.filter(|name| !name.is_empty()),
+            prefix: secret[..DISPLAY_PREFIX_LEN].to_string(),
+            tenant: request.tenant.filter(|tenant| !tenant.is_empty()),
+            scopes: request.scopes,
+            revoked: false,
+            created_at: Utc::now(),
+            last_used_at: None,
+        };
+        let key = state.key();
+        self.keys.lock().unwrap().insert(digest(&secret), state);
+        CreatedApiKey { key, secret }
+    }
+
+    fn keys(&self) -> Vec<ApiKey> {
+        let keys = self.keys.lock().unwrap();
+        let mut keys: Vec<ApiKey> = keys.values().map(KeyState::key).collect();
+        keys.sort_by(|a, b| a.created_at.cmp(&b.created_at).then(a.id.cmp(&b.id)));
+        keys
+    }
+
+    fn with_key<T>(&self, id: &str, f: impl FnOnce(&mut KeyState) -> T) -> Option<T> {
+        let mut keys = self.keys.lock().unwrap();
+        keys.values_mut().find(|state| state.id == id).map(f)
+    }
+
+    fn key(&self, id: &str) -> Option<ApiKey> {
+        self.with_key(id, |state| state.key())
+    }
+
+    /// The managed key a request presents, if any, noting that it was used
+    pub fn scope(&self, headers: &HeaderMap) -> Option<KeyScope> {
+        let token = bearer(headers)?;
+        let mut keys = self.keys.lock().unwrap();
+        let state = keys.get_mut(&digest(token))?;
+        if !state.revoked {
+            state.last_used_at = Some(Utc::now());
+        }
+        Some(KeyScope {
+            id: state.id.clone(),
+            tenant: state.tenant.clone(),
+            scopes: state.scopes.clone(),
+            revoked: state.revoked,
+        })
+    }
+
+    fn current(&self, headers: &HeaderMap) -> Option<ApiKey> {
+        let token = bearer(headers)?;
+        let keys = self.keys.lock().unwrap();
+        keys.get(&digest(token)).map(KeyState::key)
+    }
+}
+
+/// The fields of a request the sampling limits look at
+#[derive(Debug, Default, Deserialize)]
+struct RequestSummary {
+    #[serde(default)]
+    model: Option<String>,
+    #[serde(default, alias = "max_completion_tokens")]
+    max_tokens: Option<u64>,
+    #[serde(default)]
+    n: Option<u64>,
+    #[serde(default)]
+    temperature: Option<f32>,
+}
+
+/// A refusal recorded as a policy violation audit event
+fn violation(message: String, param: &str, code: &str) -> Response {
+    let response = error_response(
+        StatusCode::FORBIDDEN,
+        message.clone(),
+        Some(param),
+        Some(code),
+    );
+    audit_events::mark(response, AuditKind::PolicyViolation, Some(code), &message)
+}
+
+fn key_not_found(id: &str) -> Response {
+    error_response(
+        StatusCode::NOT_FOUND,
+        format!("API key '{}' not found", id),
+        Some("key_id"),
+        Some("api_key_not_found"),
+    )
+}
+
+/// Middleware holding requests made with a managed key to its scopes
+pub async fn enforce_scopes(
+    State(state): State<Arc<ServerState>>,
+    request: Request,
+    next: Next,
+) -> Response {
+    let Some(key) = state.api_keys.scope(request.headers()) else {
+        return next.run(request).await;
+    };
+    if key.revoked {
+        let message = format!("API key '{}' has been revoked", key.id);
+        let response = (
+            StatusCode::UNAUTHORIZED,
+            Json(json!({
+                "error": {
+                    "message": message,
+                    "type": "authentication_error",
+                    "param": null,
+                    "code": "api_key_revoked"
+                }
+            })),
+        )
+            .into_response();
+        return audit_events::mark(
+            response,
+            AuditKind::AuthFailure,
+            Some("api_key_revoked"),
+            &message,
+        );
+    }
+
+    let path = request.uri().path().to_string();
+    if !always_allowed(request.method(), &path) && !key.scopes.allows_endpoint(&path) {
+        return violation(
+            format!("API key '{}' may not call {}", key.id, path),
+            "endpoint",
+            "endpoint_not_allowed",
+        );
+    }
+    if request.method() != Method::POST || !key.scopes.inspects_body() {
+        return next.run(request).await;
+    }
+
+    // Buffer the body to see the model and sampling parameters
+    let (parts, body) = request.into_parts();
+    let bytes = match axum::body::to_bytes(body, MAX_INSPECTED_BODY).await {
+        Ok(bytes) => bytes,
+        Err(_) => {
+            return error_response(
+                StatusCode::PAYLOAD_TOO_LARGE,
+                "Request body is too large".to_string(),
+                Some("body"),
+                Some("body_too_large"),
+            );
+        }
+    };
+    let summary: RequestSummary = serde_json::from_slice(&bytes).unwrap_or_default();
+    let request = Request::from_parts(parts, Body::from(bytes));
+
+    let model = summary.model.clone().or_else(|| model_from_path(&path));
+    if let Some(model) = &model
+        && !key.scopes.allows_model(model)
+    {
+        return violation(
+            format!("API key '{}' may not use model '{}'", key.id, model),
+            "model",
+            "model_not_allowed",
+        );
+    }
+    if let Err((message, param)) = key.scopes.check_sampling(&summary) {
+        return violation(message, param, "sampling_limit_exceeded");
+    }
+
+    next.run(request).await
+}
+
+// API Handlers
+
+/// `GET /admin/keys` - every managed key, without secrets (admin only)
+pub async fn list_keys(State(state): State<Arc<ServerState>>, headers: HeaderMap) -> Response {
+    if let Err(response) = authorize_admin(&headers) {
+        return response;
+    }
+    Json(json!({ "object": "list", "data": state.api_keys.keys() })).into_response()
+}
+
+/// `POST /admin/keys` - create a key; the response holds its secret, which
+/// is not shown again (admin only)
+pub async fn create_key(
+    State(state): State<Arc<ServerState>>,
+    headers: HeaderMap,
+    Json(request): Json<CreateKeyRequest>,
+) -> Response {
+    if let Err(response) = authorize_admin(&headers) {
+        return response;
+    }
+    if let Err((message, param)) = request.scopes.validate() {
+        return error_response(
+            StatusCode::BAD_REQUEST,
+            message,
+            Some(param),
+            Some("invalid_scopes"),
+        );
+    }
+    if let Some(tenant) = request.tenant.as_deref().filter(|t| !t.is_empty())
+        && let Err(message) = validate_tenant_id(tenant)
+    {
+        return error_response(
+            StatusCode::BAD_REQUEST,
+            message,
+            Some("tenant"),
+            Some("invalid_tenant_id"),
+        );
+    }
+
+    let created = state.api_keys.create(request);
+    info!(
+        "API key {} created: {} models, {} endpoints",
+        created.key.id,
+        created.key.scopes.models.len(),
+        created.key.scopes.endpoints.len()
+    );
+    (StatusCode::CREATED, Json(created)).into_response()
+}
+
+/// `GET /admin/keys/:key_id` - one key, without its secret (admin only)
+pub async fn get_key(
+    State(state): State<Arc<ServerState>>,
+    Path(id): Path<String>,
+    headers: HeaderMap,
+) -> Response {
+    if let Err(response) = authorize_admin(&headers) {
+        return response;
+    }
+    match state.api_keys.key(&id) {
+        Some(key) => Json(key).into_response(),
+        None => key_not_found(&id),
+    }
+}
+
+/// `PATCH /admin/keys/:key_id` - change a key's name, tenant or scopes; requests
+/// already running keep the scopes they started with (admin only)
+pub async fn update_key(
+    State(state): State<Arc<ServerState>>,
+    Path(id): Path<String>,
+    headers: HeaderMap,
+    Json(request): Json<UpdateKeyRequest>,
+) -> Response {
+    if let Err(response) = authorize_admin(&headers) {
+        return response;
+    }
+    if let Some(scopes) = &request.scopes
+        && let Err((message, param)) = scopes.validate()
+    {
+        return error_response(
+            StatusCode::BAD_REQUEST,
+            message,
+            Some(param),
+            Some("invalid_scopes"),
+        );
+    }
+    if let Some(tenant) = request.tenant.as_deref().filter(|t| !t.is_empty())
+        && let Err(message) = validate_tenant_id(tenant)
+    {
+        return error_response(
+            StatusCode::BAD_REQUEST,
+            message,
+            Some("tenant"),
+            Some("invalid_tenant_id"),
+        );
+    }
+
+    let updated = state.api_keys.with_key(&id, |key| {
+        if let Some(name) = request.name {
+            key.name = Some(name).filter(|name| !name.is_empty());
+        }
+        if let Some(tenant) = request.tenant {
+            key.tenant = Some(tenant).filter(|tenant| !tenant.is_empty());
+        }
+        if let Some(scopes) = request.scopes {
+            key.scopes = scopes;
+        }
+        key.key()
+    });
+    match updated {
+        Some(key) => Json(key).into_response(),
+        None => key_not_found(&id),
+    }
+}
+
+/// `DELETE /admin/keys/:key_id` - revoke a key; it is refused from then on
+/// and stays listed as revoked (admin only)
+pub async fn revoke_key(
+    State(state): State<Arc<ServerState>>,
+    Path(id): Path<String>,
+    headers: HeaderMap,
+) -> Response {
+    if let Err(response) = authorize_admin(&headers) {
+        return response;
+    }
+    match state.api_keys.with_key(&id, |key| {
+        key.revoked = true;
+        key.key()
+    }) {
+        Some(key) => {
+            info!("API key {} revoked", id);
+            Json(key).into_response()
+        }
+        None => key_not_found(&id),
+    }
+}
+
+/// `GET /v1/keys/current` - the key the request was made with and what it
+/// may do
+pub async fn current_key(State(state): State<Arc<ServerState>>, headers: HeaderMap) -> Response {
+    match state.api_keys.current(&headers) {
+        Some(key) => Json(key).into_response(),
+        None => error_response(
+            StatusCode::NOT_FOUND,
+            "The request's bearer token is not a managed API key, so it is not scoped".to_string(),
+            Some("authorization"),
+            Some("api_key_not_managed"),
+        ),
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_endpoint_scope() {
+        let scopes = KeyScopes {
+            endpoints: vec!["/v1/embeddings".to_string()],
+            ..Default::default()
+        };
+        assert!(scopes.allows_endpoint("/v1/embeddings"));
+        assert!(!scopes.allows_endpoint("/v1/embeddings-batch"));
+        assert!(!scopes.allows_endpoint("/v1/chat/completions"));
+        assert!(KeyScopes::default().allows_endpoint("/v1/chat/completions"));
+    }
+
+    #[test]
+    fn test_always_allowed_routes() {
+        assert!(always_allowed(&Method::GET, "/v1/models"));
+        assert!(always_allowed(&Method::GET, "/v1/models/llama"));
+        assert!(always_allowed(&Method::GET, "/v1/keys/current"));
+        assert!(always_allowed(&Method::GET, "/usage"));
+        assert!(always_allowed(&Method::GET, "/usage/quota"));
+
+        // Only the listed method, and nothing below the listed paths
+        assert!(!always_allowed(&Method::DELETE, "/v1/models/llama"));
+        assert!(!always_allowed(&Method::POST, "/usage/export"));
+        assert!(!always_allowed(&Method::GET, "/usage/exports/export_1"));
+        assert!(!always_allowed(&Method::GET, "/v1/models/events"));
+        assert!(!always_allowed(&Method::GET, "/v1/models/downloads"));
+        assert!(!always_allowed(
+            &Method::POST,
+            "/v1/models/llama/apply_template"
+        ));
+        assert!(!always_allowed(
+            &Method::POST,
+            "/v1/models/llama/evaluate/perplexity"
+        ));
+    }
+
+    #[test]
+    fn test_sampling_limits() {
+        let scopes = KeyScopes {
+            max_tokens: Some(256),
+            max_n: Some(1),
+            max_temperature: Some(1.0),
+            ..Default::default()
+        };
+        let summary = |max_tokens, n, temperature| RequestSummary {
+            model: None,
+            max_tokens,
+            n,
+            temperature,
+        };
+        assert!(
+            scopes
+                .check_sampling(&summary(Some(256), Some(1), Some(0.5)))
+                .is_ok()
+        );
+        let over = scopes.check_sampling(&summary(Some(512), None, Some(0.5)));
+        assert_eq!(over.unwrap_err().1, "max_tokens");
+        let over = scopes.check_sampling(&summary(Some(16), Some(4), Some(0.5)));
+        assert_eq!(over.unwrap_err().1, "n");
+        let over = scopes.check_sampling(&summary(Some(16), None, Some(1.5)));
+        assert_eq!(over.unwrap_err().1, "temperature");
+    }
+
+    #[test]
+    fn test_store_keeps_digests() {
+        let store = ApiKeyStore::new();
+        let created = store.create(CreateKeyRequest {
+            name: Some("embedder".to_string()),
+            tenant: Some("acme".to_string()),
+            scopes: KeyScopes::default(),
+        });
+        assert!(created.secret.starts_with(KEY_PREFIX));
+        assert!(created.secret.starts_with(&created.key.prefix));
+        assert!(!store.keys.lock().unwrap().contains_key(&created.secret));
+
+        let mut headers = HeaderMap::new();
+        headers.insert(
+            header::AUTHORIZATION,
+            format!("Bearer {}", created.secret).parse().unwrap(),
+        );
+        let scope = store.scope(&headers).unwrap();
+        assert_eq!(scope.id, created.key.id);
+        assert_eq!(scope.tenant.as_deref(), Some("acme"));
+        store.with_key(&created.key.id, |key| key.revoked = true);
+        assert!(store.scope(&headers).unwrap().revoked);
+    }
+}
@@ -0,0 +1,491 @@
+//! Asynchronous Inference Jobs
+//!
+//! Very long generations can outlive load balancer idle timeouts, so clients
+//! may submit a completion with `POST /v1/inference/async`, receive a job ID
+//! immediately, then poll `GET /v1/inference/jobs/:job_id` and fetch the
+//! output from `GET /v1/inference/jobs/:job_id/result`.
+//!
+//! Jobs run through the same request queue as synchronous completions, so
+//! they appear in queue introspection and can be stopped with
+//! `POST /v1/inference/:job_id/cancel`. A job's `metadata` tags are kept
+//! with it and its usage is recorded when it finishes; see `api::usage`.
+
+use crate::{
+    api::{
+        cancellation::{FinishReason, generate_cancellable},
+        deadline::resolve_deadline,
+        envelope,
+        openai::{
+            CompletionChoice, CompletionRequest, CompletionResponse, StringOrArray, Usage,
+            estimate_tokens, get_or_load_backend, system_fingerprint,
+        },
+        queue::priority_from_headers,
+        usage::UsageRecord,
+    },
+    backends::InferenceParams,
+    cli::serve::ServerState,
+};
+use axum::{
+    Json,
+    extract::{Path, State},
+    http::{HeaderMap, StatusCode},
+    response::{IntoResponse, Response},
+};
+use serde::{Deserialize, Serialize};
+use serde_json::json;
+use std::{
+    collections::{BTreeMap, HashMap},
+    sync::Arc,
+    time::Duration,
+};
+use tokio::sync::RwLock;
+use tracing::{info, warn};
+
+/// How long finished jobs (and their results) are kept for retrieval
+const JOB_RETENTION: Duration = Duration::from_secs(60 * 60);
+
+/// Lifecycle state of an asynchronous job
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Serialize, Deserialize)]
+#[serde(rename_all = "snake_case")]
+pub enum JobStatus {
+    Queued,
+    Running,
+    Completed,
+    Failed,
+    Cancelled,
+}
+
+impl JobStatus {
+    pub fn is_terminal(&self) -> bool {
+        matches!(
+            self,
+            JobStatus::Completed | JobStatus::Failed | JobStatus::Cancelled
+        )
+    }
+}
+
+/// Job metadata returned by the status endpoint
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct InferenceJob {
+    pub id: String,
+    pub object: String,
+    pub model: String,
+    pub status: JobStatus,
+    pub created_at: chrono::DateTime<chrono::Utc>,
+    pub started_at: Option<chrono::DateTime<chrono::Utc>>,
+    pub finished_at: Option<chrono::DateTime<chrono::Utc>>,
+    pub error: Option<String>,
+    #[serde(default, skip_serializing_if = "BTreeMap::is_empty")]
+    pub metadata: BTreeMap<String, String>,
+    #[serde(skip)]
+    result: Option<CompletionResponse>,
+}
+
+/// In-memory store of submitted jobs
+#[derive(Debug, Default)]
+pub struct InferenceJobStore {
+    jobs: RwLock<HashMap<String, InferenceJob>>,
+}
+
+impl InferenceJobStore {
+    pub fn new() -> Self {
+        Self::default()
+    }
+
+    async fn insert(&self, job: InferenceJob) {
+        let mut jobs = self.jobs.write().await;
+        prune_expired(&mut jobs);
+        jobs.insert(job.id.clone(), job);
+    }
+
+    async fn update<F: FnOnce(&mut InferenceJob)>(&self, id: &str, f: F) {
+        if let Some(job) = self.jobs.write().await.get_mut(id) {
+            f(job);
+        }
+    }
+
+    pub async fn get(&self, id: &str) -> Option<InferenceJob> {
+        self.jobs.read().await.get(id).cloned()
+    }
+
+    /// Ids of finished jobs whose results are still held, oldest first
+    pub(crate) async fn finished_ids(&self) -> Vec<String> {
+        let jobs = self.jobs.read().await;
+        let mut finished: Vec<_> = jobs
+            .values()
+            .filter_map(|job| Some((job.finished_at?, job.id.clone())))
+            .collect();
+        finished.sort();
+        finished.into_iter().map(|(_, id)| id).collect()
+    }
+
+    /// Drop the given finished jobs and their results before the retention
+    /// window ends; returns how many were dropped
+    pub(crate) async fn evict(&self, ids: &[String]) -> usize {
+        let mut jobs = self.jobs.write().await;
+        let mut evicted = 0;
+        for id in ids {
+            if jobs.get(id).is_some_and(|job| job.status.is_terminal()) {
+                jobs.remove(id);
+                evicted += 1;
+            }
+        }
+        evicted
+    }
+}
+
+/// Drop finished jobs older than the retention window
+fn prune_expired(jobs: &mut HashMap<String, InferenceJob>) {
+    let cutoff = chrono::Utc::now() - chrono::Duration::from_std(JOB_RETENTION).unwrap();
+    jobs.retain(|_, job| job.finished_at.is_none_or(|finished| finished > cutoff));
+}
+
+// API Handlers
+
+/// `POST /v1/inference/async` - submit a completion and return its job ID
+pub async fn submit_inference(
+    State(state): State<Arc<ServerState>>,
+    headers: HeaderMap,
+    Json(mut request): Json<CompletionRequest>,
+) -> impl IntoResponse {
+    if request.stream {
+        return (
+            StatusCode::BAD_REQUEST,
+            Json(json!({
+                "error": {
+                    "message": "Asynchronous jobs cannot stream; poll the job result instead",
+                    "type": "invalid_request_error",
+                    "param": "stream",
+                    "code": null
+                }
+            })),
+        )
+            .into_response();
+    }
+
+    if request.score.is_some() {
+        return (
+            StatusCode::BAD_REQUEST,
+            Json(json!({
+                "error": {
+                    "message": "Scoring runs synchronously; send it to /v1/completions instead",
+                    "type": "invalid_request_error",
+                    "param": "score",
+                    "code": null
+                }
+            })),
+        )
+            .into_response();
+    }
+
+    if request.prompt.is_none() || request.input_ids.is_some() {
+        return (
+            StatusCode::BAD_REQUEST,
+            Json(json!({
+                "error": {
+                    "message": "Asynchronous jobs need a text prompt; send input_ids to /v1/completions instead",
+                    "type": "invalid_request_error",
+                    "param": "prompt",
+                    "code": null
+                }
+            })),
+        )
+            .into_response();
+    }
+
+    // Check the data key now; the prompt itself stays encrypted in the job
+    // until it runs
+    if let Some(envelope) = &request.encryption
+        && let Err(e) = state.envelope_keys.open(envelope)
+    {
+        return (
+            StatusCode::BAD_REQUEST,
+            Json(json!({
+                "error": {
+                    "message": e,
+                    "type": "invalid_request_error",
+                    "param": "encryption",
+                    "code": null
+                }
+            })),
+        )
+            .into_response();
+    }
+
+    if let Some(route) = state
+        .model_router
+        .route(&request.model, request.user.as_deref())
+        .await
+    {
+        request.model = route.model;
+    }
+
+    // The job ID doubles as the queue request ID so the job can be cancelled
+    let ticket = match state.request_queue.enqueue_request(
+        &headers,
+        &request.model,
+        priority_from_headers(&headers),
+    ) {
+        Ok(ticket) => ticket
+            .with_scheduling(None, &headers)
+            .with_deadline(resolve_deadline(request.timeout_ms, request.deadline)),
+        Err(response) => return response,
+    };
+    let job_id = ticket.id().to_string();
+
+    let job = InferenceJob {
+        id: job_id.clone(),
+        object: "inference.job".to_string(),
+        model: request.model.clone(),
+        status: JobStatus::Queued,
+        created_at: chrono::Utc::now(),
+        started_at: None,
+        finished_at: None,
+        error: None,
+        metadata: request.metadata.clone().unwrap_or_default(),
+        result: None,
+    };
+    state.inference_jobs.insert(job.clone()).await;
+
+    let mut usage = UsageRecord::new(&state, &headers, "/v1/inference/async", &request.model);
+    usage.metadata = job.metadata.clone();
+
+    info!("Accepted async inference job {}", job_id);
+
+    let task_state = Arc::clone(&state);
+    tokio::spawn(async move {
+        let store = &task_state.inference_jobs;
+        if let Err(e) = envelope::open_fields(
+            &task_state.envelope_keys,
+            request.encryption.as_ref(),
+            request.prompt.iter_mut().flat_map(StringOrArray::iter_mut),
+        ) {
+            store
+                .update(ticket.id(), |job| {
+                    job.status = JobStatus::Failed;
+                    job.error = Some(e);
+                    job.finished_at = Some(chrono::Utc::now());
+                })
+                .await;
+            return;
+        }
+        let prompt = request.prompt_text().unwrap_or_default();
+
+        let backend = match get_or_load_backend(&task_state, &request.model).await {
+            Ok(backend) => backend,
+            Err(e) => {
+                warn!("Async job {} failed to load model: {}", ticket.id(), e);
+                store
+                    .update(ticket.id(), |job| {
+                        job.status = JobStatus::Failed;
+                        job.error = Some(format!("Failed to load model: {}", e));
+                        job.finished_at = Some(chrono::Utc::now());
+                    })
+                    .await;
+                return;
+            }
+        };
+
+        let params = InferenceParams {
+            max_tokens: request.max_tokens,
+            temperature: request.temperature,
+            top_k: request.top_k,
+            top_p: request.top_p,
+            stream: false,
+            stop_sequences: request.stop.clone().unwrap_or_default(),
+            seed: request.seed,
+            stop_token_ids: vec![],
+            ignore_eos: false,
+            skip_special_tokens: None,
+            prompt_token_ids: None,
+        };
+
+        ticket.start().await;
+        store
+            .update(ticket.id(), |job| {
+                job.status = JobStatus::Running;
+                job.started_at = Some(chrono::Utc::now());
+            })
+            .await;
+
+        let fingerprint = system_fingerprint(&request.model, backend.get_backend_type());
+        let mut finished_usage = None;
+        let outcome = generate_cancellable(
+            &backend,
+            &prompt,
+            &params,
+            ticket.cancel_signal(),
+            ticket.deadline(),
+        )
+        .await;
+
+        store
+            .update(ticket.id(), |job| {
+                job.finished_at = Some(chrono::Utc::now());
+                match outcome {
+                    Ok(generation) => {
+                        job.status = if generation.finish_reason == FinishReason::Cancelled {
+                            JobStatus::Cancelled
+                        } else {
+                            JobStatus::Completed
+                        };
+                        let prompt_tokens = estimate_tokens(&prompt);
+                        let completion_tokens = estimate_tokens(&generation.text);
+                        usage.timestamp = chrono::Utc::now();
+                        usage.prompt_tokens = prompt_tokens as u64;
+                        usage.completion_tokens = completion_tokens as u64;
+                        finished_usage = Some(usage);
+                        job.result = Some(CompletionResponse {
+                            id: job.id.clone(),
+                            object: "text_completion".to_string(),
+                            created: job.created_at.timestamp(),
+                            model: job.model.clone(),
+                            system_fingerprint: Some(fingerprint),
+                            choices: vec![CompletionChoice {
+                                text: generation.text,
+                                index: 0,
+                                logprobs: None,
+                                finish_reason: Some(generation.finish_reason.as_str().to_string()),
+                                prompt_token_ids: None,
+                                token_ids: None,
+                            }],
+                            usage: Some(Usage {
+                                prompt_tokens,
+                                completion_tokens,
+                                total_tokens: prompt_tokens + completion_tokens,
+                            }),
+                        });
+                    }
+                    Err(e) => {
+                        job.status = JobStatus::Failed;
+                        job.error = Some(format!("Inference failed: {}", e));
+                    }
+                }
+            })
+            .await;
+        if let Some(usage) = finished_usage {
+            task_state.billing_webhooks.observe(&usage);
+            task_state.usage.record(usage);
+        }
+    });
+
+    (StatusCode::ACCEPTED, Json(job)).into_response()
+}
+
+/// `GET /v1/inference/jobs/:job_id` - job status and timing
+pub async fn inference_job_status(
+    State(state): State<Arc<ServerState>>,
+    Path(job_id): Path<String>,
+) -> Response {
+    match state.inference_jobs.get(&job_id).await {
+        Some(job) => Json(job).into_response(),
+        None => job_not_found(&job_id),
+    }
+}
+
+/// `GET /v1/inference/jobs/:job_id/result` - the completion once finished.
+///
+/// Returns `202 Accepted` with the job status while it is still pending.
+pub async fn inference_job_result(
+    State(state): State<Arc<ServerState>>,
+    Path(job_id): Path<String>,
+) -> Response {
+    let job = match state.inference_jobs.get(&job_id).await {
+        Some(job) => job,
+        None => return job_not_found(&job_id),
+    };
+
+    match (job.status, job.result) {
+        (_, Some(result)) => Json(result).into_response(),
+        (JobStatus::Failed, None) => (
+            StatusCode::INTERNAL_SERVER_ERROR,
+            Json(json!({
+                "error": {
+                    "message": job.error.unwrap_or_else(|| "Job failed".to_string()),
+                    "type": "internal_error",
+                    "param": null,
+                    "code": "job_failed"
+                }
+            })),
+        )
+            .into_response(),
+        (status, None) => (
+            StatusCode::ACCEPTED,
+            Json(json!({
+                "id": job.id,
+                "status": status
+            })),
+        )
+            .into_response(),
+    }
+}
+
+fn job_not_found(job_id: &str) -> Response {
+    (
+        StatusCode::NOT_FOUND,
+        Json(json!({
+            "error": {
+                "message": format!("No inference job with id {}", job_id),
+                "type": "invalid_request_error",
+                "param": "job_id",
+                "code": "job_not_found"
+            }
+        })),
+    )
+        .into_response()
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn job(id: &str, finished_at: Option<chrono::DateTime<chrono::Utc>>) -> InferenceJob {
+        InferenceJob {
+            id: id.to_string(),
+            object: "inference.job".to_string(),
+            model: "test".to_string(),
+            status: JobStatus::Completed,
+            created_at: chrono::Utc::now(),
+            started_at: None,
+            finished_at,
+            error: None,
+            result: None,
+        }
+    }
+
+    #[test]
+    fn test_terminal_statuses() {
+        assert!(!JobStatus::Queued.is_terminal());
+        assert!(!JobStatus::Running.is_terminal());
+        assert!(JobStatus::Completed.is_terminal());
+        assert!(JobStatus::Failed.is_terminal());
+        assert!(JobStatus::Cancelled.is_terminal());
+    }
+
+    #[test]
+    fn test_prune_expired_keeps_recent_and_pending() {
+        let stale = chrono::Utc::now() - chrono::Duration::hours(2);
+        let mut jobs = HashMap::new();
+        jobs.insert("pending".to_string(), job("pending", None));
+        jobs.insert("fresh".to_string(), job("fresh", Some(chrono::Utc::now())));
+        jobs.insert("stale".to_string(), job("stale", Some(stale)));
+
+        prune_expired(&mut jobs);
+
+        assert!(jobs.contains_key("pending"));
+        assert!(jobs.contains_key("fresh"));
+        assert!(!jobs.contains_key("stale"));
+    }
+
+    #[tokio::test]
+    async fn test_store_update() {
+        let store = InferenceJobStore::new();
+        store.insert(job("a", None)).await;
+        store
+            .update("a", |job| job.status = JobStatus::Running)
+            .await;
+
+        assert_eq!(store.get("a").await.unwrap().status, JobStatus::Running);
+        assert!(store.get("missing").await.is_none());
+    }
+}
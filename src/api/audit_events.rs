@@ -0,0 +1,600 @@
+//! Audit Event Streaming
+//!
+//! Security-relevant requests are recorded as structured audit events:
+//! failed admin authentication, state-changing requests made with the admin
+//! token, and requests refused by a tenant rule. The [`record_events`]
+//! middleware turns responses into events, using the [`AuditMark`] a
+//! handler attaches to say why a request was refused. The model watchdog
+//! and the memory pressure monitor record on the same log, without a
+//! request behind their events.
+//!
+//! SIEM forwarders subscribe instead of polling: `/admin/audit/stream`
+//! sends events as server-sent events and `/admin/audit/ws` over a
+//! WebSocket, both with the same filters. `/admin/audit/events` returns the
+//! recent events kept in memory, and streams replay them from `since_id`
+//! (or `Last-Event-ID`) so a reconnecting forwarder misses nothing still
+//! held.
+
+use crate::{
+    api::{
+        admin::authorize_admin, cancellation::REQUEST_ID_HEADER, invalid_request,
+        queue::tenant_from_headers, usage::UsageMetadata,
+    },
+    cli::serve::ServerState,
+};
+use axum::{
+    Json,
+    extract::{
+        Query, Request, State,
+        ws::{Message, WebSocket, WebSocketUpgrade},
+    },
+    http::{HeaderMap, Method},
+    middleware::Next,
+    response::{
+        IntoResponse, Response,
+        sse::{Event, KeepAlive, Sse},
+    },
+};
+use chrono::{DateTime, Utc};
+use serde::{Deserialize, Serialize};
+use serde_json::json;
+use std::{
+    collections::{BTreeMap, VecDeque},
+    sync::{
+        Arc, Mutex,
+        atomic::{AtomicU64, Ordering},
+    },
+};
+use tokio::sync::broadcast;
+use tracing::info;
+
+/// Events kept for `/admin/audit/events` and stream replay
+const RECENT_EVENTS: usize = 1000;
+
+/// Live events buffered per subscriber before it is considered lagging
+const EVENT_CHANNEL_CAPACITY: usize = 256;
+
+/// Most events one `/admin/audit/events` request returns
+const MAX_EVENTS_LIMIT: usize = RECENT_EVENTS;
+
+/// What an audit event records
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Serialize, Deserialize)]
+#[serde(rename_all = "snake_case")]
+pub enum AuditKind {
+    /// A request to an admin endpoint without a valid admin token, or one
+    /// with a missing or invalid request signature
+    AuthFailure,
+    /// A state-changing request made with the admin token
+    AdminAction,
+    /// A request refused by a tenant's limits, allowed models or suspension,
+    /// an API key's scopes or a budget's hard limit
+    PolicyViolation,
+    /// The model watchdog found a crashed or hung backend, or recovered it
+    ModelIncident,
+    /// RAM or VRAM use crossed a pressure threshold, with the evictions the
+    /// server is making
+    MemoryPressure,
+}
+
+impl AuditKind {
+    pub fn as_str(&self) -> &'static str {
+        match self {
+            AuditKind::AuthFailure => "auth_failure",
+            AuditKind::AdminAction => "admin_action",
+            AuditKind::PolicyViolation => "policy_violation",
+            AuditKind::ModelIncident => "model_incident",
+            AuditKind::MemoryPressure => "memory_pressure",
+        }
+    }
+
+    fn parse(name: &str) -> Option<Self> {
+        match name {
+            "auth_failure" => Some(AuditKind::AuthFailure),
+            "admin_action" => Some(AuditKind::AdminAction),
+            "policy_violation" => Some(AuditKind::PolicyViolation),
+            "model_incident" => Some(AuditKind::ModelIncident),
+            "memory_pressure" => Some(AuditKind::MemoryPressure),
+            _ => None,
+        }
+    }
+}
+
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct AuditEvent {
+    /// Increases by one per event, for resuming a stream
+    pub id: u64,
+    pub timestamp: DateTime<Utc>,
+    pub kind: AuditKind,
+    /// Empty for events no request caused
+    #[serde(default, skip_serializing_if = "String::is_empty")]
+    pub method: String,
+    /// Request path, without the query string
+    pub path: String,
+    /// Zero for events no request caused
+    #[serde(default, skip_serializing_if = "is_zero")]
+    pub status: u16,
+    /// Error code of a refused request
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub code: Option<String>,
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub message: Option<String>,
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub tenant: Option<String>,
+    /// From `X-Forwarded-For` or `X-Real-IP`, when a proxy sets them
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub client_ip: Option<String>,
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub request_id: Option<String>,
+    /// The request's `metadata` tags
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub metadata: Option<BTreeMap<String, String>>,
+    /// Structured data for events no request caused
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub details: Option<serde_json::Value>,
+}
+
+fn is_zero(status: &u16) -> bool {
+    *status == 0
+}
+
+/// Attached to a response to record it as an audit event
+#[derive(Debug, Clone)]
+pub struct AuditMark {
+    pub kind: AuditKind,
+    pub code: Option<String>,
+    pub message: String,
+}
+
+/// Mark `response` as an audit event of `kind`
+pub fn mark(
+    mut response: Response,
+    kind: AuditKind,
+    code: Option<&str>,
+    message: &str,
+) -> Response {
+    response.extensions_mut().insert(AuditMark {
+        kind,
+        code: code.map(str::to_string),
+        message: message.to_string(),
+    });
+    response
+}
+
+/// Which events a reader wants; empty fields match everything
+#[derive(Debug, Clone, Default, Deserialize)]
+pub struct AuditFilter {
+    /// Comma-separated kinds
+    #[serde(default)]
+    pub kind: Option<String>,
+    #[serde(default)]
+    pub tenant: Option<String>,
+    #[serde(default)]
+    pub path_prefix: Option<String>,
+    /// Only events with a greater id
+    #[serde(default)]
+    pub since_id: Option<u64>,
+    /// Most events `/admin/audit/events` returns, newest kept
+    #[serde(default)]
+    pub limit: Option<usize>,
+}
+
+/// An [`AuditFilter`] with its kinds parsed
+#[derive(Debug, Clone, Default)]
+struct EventFilter {
+    kinds: Vec<AuditKind>,
+    tenant: Option<String>,
+    path_prefix: Option<String>,
+    since_id: u64,
+}
+
+impl EventFilter {
+    fn parse(filter: AuditFilter) -> Result<Self, String> {
+        let mut kinds = Vec::new();
+        for name in filter.kind.iter().flat_map(|kinds| kinds.split(',')) {
+            let name = name.trim();
+            if name.is_empty() {
+                continue;
+            }
+            match AuditKind::parse(name) {
+                Some(kind) => kinds.push(kind),
+                None => {
+                    return Err(format!(
+                        "Unknown audit event kind '{}'; expected auth_failure, admin_action, policy_violation, model_incident or memory_pressure",
+                        name
+                    ));
+                }
+            }
+        }
+        Ok(Self {
+            kinds,
+            tenant: filter.tenant.filter(|t| !t.is_empty()),
+            path_prefix: filter.path_prefix.filter(|p| !p.is_empty()),
+            since_id: filter.since_id.unwrap_or(0),
+        })
+    }
+
+    fn matches(&self, event: &AuditEvent) -> bool {
+        event.id > self.since_id
+            && (self.kinds.is_empty() || self.kinds.contains(&event.kind))
+            && self
+                .tenant
+                .as_ref()
+                .is_none_or(|tenant| event.tenant.as_ref() == Some(tenant))
+            && self
+                .path_prefix
+                .as_ref()
+                .is_none_or(|prefix| event.path.starts_with(prefix.as_str()))
+    }
+}
+
+/// Recent audit events and the live feed of new ones
+#[derive(Debug)]
+pub struct AuditLog {
+    recent: Mutex<VecDeque<AuditEvent>>,
+    next_id: AtomicU64,
+    events: broadcast::Sender<AuditEvent>,
+}
+
+impl Default for AuditLog {
+    fn default() -> Self {
+        let (events, _) = broadcast::channel(EVENT_CHANNEL_CAPACITY);
+        Self {
+            recent: Mutex::new(VecDeque::new()),
+            next_id: AtomicU64::new(1),
+            events,
+        }
+    }
+}
+
+impl AuditLog {
+    pub fn new() -> Self {
+        Self::default()
+    }
+
+    /// Assign the event an id, keep it and send it to subscribers
+    pub fn record(&self, mut event: AuditEvent) {
+        let mut recent = self.recent.lock().unwrap();
+        event.id = self.next_id.fetch_add(1, Ordering::Relaxed);
+        if recent.len() == RECENT_EVENTS {
+            recent.pop_front();
+        }
+        recent.push_back(event.clone());
+        // Send under the lock so subscribers see ids in order
+        let _ = self.events.send(event);
+    }
+
+    pub fn subscribe(&self) -> broadcast::Receiver<AuditEvent> {
+        self.events.subscribe()
+    }
+
+    fn recent(&self, filter: &EventFilter) -> Vec<AuditEvent> {
+        let recent = self.recent.lock().unwrap();
+        recent
+            .iter()
+            .filter(|event| filter.matches(event))
+            .cloned()
+            .collect()
+    }
+
+    /// Held events matching `filter` and a receiver for the ones after them
+    fn replay_and_subscribe(
+        &self,
+        filter: &EventFilter,
+    ) -> (Vec<AuditEvent>, broadcast::Receiver<AuditEvent>) {
+        let recent = self.recent.lock().unwrap();
+        let receiver = self.events.subscribe();
+        let replay = recent
+            .iter()
+            .filter(|event| filter.matches(event))
+            .cloned()
+            .collect();
+        (replay, receiver)
+    }
+}
+
+fn header(headers: &HeaderMap, name: &str) -> Option<String> {
+    headers
+        .get(name)
+        .and_then(|v| v.to_str().ok())
+        .map(|v| v.trim().to_string())
+        .filter(|v| !v.is_empty())
+}
+
+fn client_ip(headers: &HeaderMap) -> Option<String> {
+    header(headers, "x-forwarded-for")
+        .and_then(|v| v.split(',').next().map(|ip| ip.trim().to_string()))
+        .or_else(|| header(headers, "x-real-ip"))
+}
+
+/// Middleware recording marked responses and the admin's state-changing
+/// requests as audit events
+pub async fn record_events(
+    State(state): State<Arc<ServerState>>,
+    request: Request,
+    next: Next,
+) -> Response {
+    let method = request.method().clone();
+    let path = request.uri().path().to_string();
+    let headers = request.headers().clone();
+    let changes_state = !matches!(method, Method::GET | Method::HEAD | Method::OPTIONS);
+
+    let response = next.run(request).await;
+
+    let mark = response.extensions().get::<AuditMark>().cloned();
+    let (kind, code, message) = match mark {
+        Some(mark) => (mark.kind, mark.code, Some(mark.message)),
+        None if changes_state
+            && response.status().is_success()
+            && authorize_admin(&headers).is_ok() =>
+        {
+            (AuditKind::AdminAction, None, None)
+        }
+        None => return response,
+    };
+
+    state.audit.record(AuditEvent {
+        id: 0,
+        timestamp: Utc::now(),
+        kind,
+        method: method.to_string(),
+        path,
+        status: response.status().as_u16(),
+        code,
+        message,
+        tenant: tenant_from_headers(&headers),
+        client_ip: client_ip(&headers),
+        request_id: response
+            .headers()
+            .get(REQUEST_ID_HEADER)
+            .and_then(|v| v.to_str().ok())
+            .map(str::to_string)
+            .or_else(|| header(&headers, REQUEST_ID_HEADER)),
+        metadata: response
+            .extensions()
+            .get::<UsageMetadata>()
+            .map(|metadata| metadata.0.clone()),
+        details: None,
+    });
+    response
+}
+
+/// The filter from the query, resuming after `Last-Event-ID` when the query
+/// names no `since_id`
+fn stream_filter(filter: AuditFilter, headers: &HeaderMap) -> Result<EventFilter, Response> {
+    let mut filter =
+        EventFilter::parse(filter).map_err(|e| invalid_request(e, Some("kind"), None))?;
+    if filter.since_id == 0
+        && let Some(last) = header(headers, "last-event-id").and_then(|id| id.parse().ok())
+    {
+        filter.since_id = last;
+    }
+    Ok(filter)
+}
+
+// API Handlers
+
+/// `GET /admin/audit/events` - recent audit events matching the filter,
+/// oldest first (admin only)
+pub async fn list_events(
+    State(state): State<Arc<ServerState>>,
+    headers: HeaderMap,
+    Query(query): Query<AuditFilter>,
+) -> Response {
+    if let Err(response) = authorize_admin(&headers) {
+        return response;
+    }
+
+    let limit = query.limit.unwrap_or(100).clamp(1, MAX_EVENTS_LIMIT);
+    let filter = match EventFilter::parse(query) {
+        Ok(filter) => filter,
+        Err(e) => return invalid_request(e, Some("kind"), None),
+    };
+    let mut data = state.audit.recent(&filter);
+    let skip = data.len().saturating_sub(limit);
+    data.drain(..skip);
+    Json(json!({ "object": "list", "data": data })).into_response()
+}
+
+/// `GET /admin/audit/stream` - audit events as server-sent events, replaying
+/// held events after `since_id` first (admin only)
+pub async fn stream_events(
+    State(state): State<Arc<ServerState>>,
+    headers: HeaderMap,
+    Query(query): Query<AuditFilter>,
+) -> Response {
+    if let Err(response) = authorize_admin(&headers) {
+        return response;
+    }
+    let filter = match stream_filter(query, &headers) {
+        Ok(filter) => filter,
+        Err(response) => return response,
+    };
+
+    let (replay, mut receiver) = state.audit.replay_and_subscribe(&filter);
+    let stream = async_stream::stream! {
+        let mut last_id = filter.since_id;
+        for event in replay {
+            last_id = event.id;
+            yield Ok::<Event, axum::Error>(sse_event(&event));
+        }
+
+        loop {
+            match receiver.recv().await {
+                Ok(event) if event.id > last_id && filter.matches(&event) => {
+                    last_id = event.id;
+                    yield Ok(sse_event(&event));
+                }
+                Ok(_) => continue,
+                Err(broadcast::error::RecvError::Lagged(missed)) => {
+                    yield Ok(Event::default().event("lagged").data(json!({ "missed": missed }).to_string()));
+                }
+                Err(broadcast::error::RecvError::Closed) => break,
+            }
+        }
+    };
+
+    Sse::new(stream)
+        .keep_alive(KeepAlive::default())
+        .into_response()
+}
+
+fn sse_event(event: &AuditEvent) -> Event {
+    Event::default()
+        .id(event.id.to_string())
+        .event(event.kind.as_str())
+        .data(serde_json::to_string(event).unwrap())
+}
+
+/// `GET /admin/audit/ws` - audit events over a WebSocket, one JSON event
+/// per text frame (admin only)
+pub async fn stream_events_websocket(
+    ws: WebSocketUpgrade,
+    State(state): State<Arc<ServerState>>,
+    headers: HeaderMap,
+    Query(query): Query<AuditFilter>,
+) -> Response {
+    if let Err(response) = authorize_admin(&headers) {
+        return response;
+    }
+    let filter = match stream_filter(query, &headers) {
+        Ok(filter) => filter,
+        Err(response) => return response,
+    };
+
+    ws.on_upgrade(move |socket| serve_websocket(socket, state, filter))
+}
+
+async fn serve_websocket(mut socket: WebSocket, state: Arc<ServerState>, filter: EventFilter) {
+    info!("Audit event WebSocket subscriber connected");
+    let (replay, mut receiver) = state.audit.replay_and_subscribe(&filter);
+    let mut last_id = filter.since_id;
+
+    for event in replay {
+        last_id = event.id;
+        if send_event(&mut socket, &event).await.is_err() {
+            return;
+        }
+    }
+
+    loop {
+        tokio::select! {
+            received = receiver.recv() => match received {
+                Ok(event) if event.id > last_id && filter.matches(&event) => {
+                    last_id = event.id;
+                    if send_event(&mut socket, &event).await.is_err() {
+                        break;
+                    }
+                }
+                Ok(_) => continue,
+                Err(broadcast::error::RecvError::Lagged(missed)) => {
+                    let notice = json!({ "type": "lagged", "missed": missed }).to_string();
+                    if socket.send(Message::Text(notice)).await.is_err() {
+                        break;
+                    }
+                }
+                Err(broadcast::error::RecvError::Closed) => break,
+            },
+            frame = socket.recv() => match frame {
+                Some(Ok(Message::Close(_))) | None | Some(Err(_)) => break,
+                Some(Ok(_)) => continue,
+            },
+        }
+    }
+
+    info!("Audit event WebSocket subscriber disconnected");
+}
+
+async fn send_event(socket: &mut WebSocket, event: &AuditEvent) -> Result<(), axum::Error> {
+    socket
+        .send(Message::Text(serde_json::to_string(event).unwrap()))
+        .await
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn event(kind: AuditKind, path: &str, tenant: Option<&str>) -> AuditEvent {
+        AuditEvent {
+            id: 0,
+            timestamp: Utc::now(),
+            kind,
+            method: "POST".to_string(),
+            path: path.to_string(),
+            status: 403,
+            code: None,
+            message: None,
+            tenant: tenant.map(str::to_string),
+            client_ip: None,
+            request_id: None,
+            metadata: None,
+            details: None,
+        }
+    }
+
+    #[test]
+    fn test_record_assigns_increasing_ids() {
+        let log = AuditLog::new();
+        let mut receiver = log.subscribe();
+        log.record(event(AuditKind::AuthFailure, "/admin/status", None));
+        log.record(event(AuditKind::AdminAction, "/admin/drain", None));
+
+        assert_eq!(receiver.try_recv().unwrap().id, 1);
+        assert_eq!(receiver.try_recv().unwrap().id, 2);
+        let all = log.recent(&EventFilter::default());
+        assert_eq!(all.iter().map(|e| e.id).collect::<Vec<_>>(), vec![1, 2]);
+    }
+
+    #[test]
+    fn test_filter_by_kind_tenant_path_and_id() {
+        let log = AuditLog::new();
+        log.record(event(AuditKind::AuthFailure, "/admin/status", None));
+        log.record(event(
+            AuditKind::PolicyViolation,
+            "/v1/chat/completions",
+            Some("acme"),
+        ));
+        log.record(event(
+            AuditKind::PolicyViolation,
+            "/v1/completions",
+            Some("globex"),
+        ));
+
+        let filter = EventFilter::parse(AuditFilter {
+            kind: Some("policy_violation".to_string()),
+            tenant: Some("acme".to_string()),
+            ..Default::default()
+        })
+        .unwrap();
+        let matched = log.recent(&filter);
+        assert_eq!(matched.len(), 1);
+        assert_eq!(matched[0].id, 2);
+
+        let filter = EventFilter::parse(AuditFilter {
+            path_prefix: Some("/admin/".to_string()),
+            since_id: Some(1),
+            ..Default::default()
+        })
+        .unwrap();
+        assert!(log.recent(&filter).is_empty());
+
+        assert!(
+            EventFilter::parse(AuditFilter {
+                kind: Some("auth_failure,bogus".to_string()),
+                ..Default::default()
+            })
+            .is_err()
+        );
+    }
+
+    #[test]
+    fn test_recent_is_bounded() {
+        let log = AuditLog::new();
+        for _ in 0..RECENT_EVENTS + 5 {
+            log.record(event(AuditKind::AdminAction, "/admin/drain", None));
+        }
+        let all = log.recent(&EventFilter::default());
+        assert_eq!(all.len(), RECENT_EVENTS);
+        assert_eq!(all[0].id, 6);
+    }
+}
@@ -0,0 +1,779 @@
+//! OpenAI Batch API
+//!
+//! `POST /v1/batches` runs every request in an uploaded JSONL file (purpose
+//! `batch`) in the background, as OpenAI's Batch API does, so SDK code and
+//! `inferno batch submit` work unchanged. Each line is
+//! `{"custom_id", "method": "POST", "url", "body"}` for the batch's endpoint:
+//! `/v1/chat/completions`, `/v1/completions` or `/v1/embeddings`.
+//!
+//! The file is checked first (`validating`); any bad line fails the whole
+//! batch with the line numbers in `errors`. Requests then run a few at a time
+//! through the same handlers as synchronous calls, with the headers the batch
+//! was created with, so they share the queue, limits and usage attribution.
+//! Successful responses go to an output file and the rest to an error file,
+//! both in the Files API with purpose `batch_output`, one line per request
+//! keyed by `custom_id`. Cancelling, or running past `completion_window`,
+//! stops new requests and keeps the results of those already finished.
+//!
+//! Batch records are kept in memory; their files outlive a restart.
+
+use crate::{
+    api::{
+        admin::authorize_admin,
+        cancellation::CancelSignal,
+        invalid_request,
+        openai::{self, ChatCompletionRequest, CompletionRequest, EmbeddingRequest},
+    },
+    cli::serve::ServerState,
+};
+use axum::{
+    Json,
+    extract::{Path, State},
+    http::{HeaderMap, StatusCode},
+    response::{IntoResponse, Response},
+};
+use futures::StreamExt;
+use serde::{Deserialize, Serialize};
+use serde_json::{Value, json};
+use std::{
+    collections::{BTreeMap, HashMap, HashSet},
+    sync::Arc,
+    time::Duration,
+};
+use tokio::sync::RwLock;
+use tracing::{info, warn};
+use uuid::Uuid;
+
+/// Endpoints a batch may target
+const ENDPOINTS: [&str; 3] = ["/v1/chat/completions", "/v1/completions", "/v1/embeddings"];
+
+/// The only completion window OpenAI offers
+const COMPLETION_WINDOW: &str = "24h";
+const COMPLETION_WINDOW_SECS: i64 = 24 * 60 * 60;
+
+/// Most requests one batch may hold, matching OpenAI's limit
+const MAX_BATCH_REQUESTS: usize = 50_000;
+
+/// Requests of one batch running at the same time
+const BATCH_CONCURRENCY: usize = 4;
+
+/// Input errors listed on a failed batch; the count covers the rest
+const MAX_LISTED_ERRORS: usize = 100;
+
+/// Largest response read back from a handler
+const MAX_RESPONSE_BODY: usize = 16 * 1024 * 1024;
+
+/// Finished batches kept for retrieval; the oldest are dropped first
+const MAX_RETAINED_BATCHES: usize = 100;
+
+/// Purpose of the files a batch writes
+const OUTPUT_PURPOSE: &str = "batch_output";
+
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Serialize, Deserialize)]
+#[serde(rename_all = "snake_case")]
+pub enum BatchStatus {
+    Validating,
+    Failed,
+    InProgress,
+    Finalizing,
+    Completed,
+    Expired,
+    Cancelling,
+    Cancelled,
+}
+
+impl BatchStatus {
+    fn is_finished(self) -> bool {
+        matches!(
+            self,
+            BatchStatus::Failed
+                | BatchStatus::Completed
+                | BatchStatus::Expired
+                | BatchStatus::Cancelled
+        )
+    }
+}
+
+#[derive(Debug, Clone, Default, Serialize, Deserialize)]
+pub struct RequestCounts {
+    pub total: u64,
+    pub completed: u64,
+    pub failed: u64,
+}
+
+/// A problem with the input file
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct BatchError {
+    pub code: String,
+    pub message: String,
+    pub param: Option<String>,
+    /// 1-based line of the input file
+    pub line: Option<u64>,
+}
+
+impl BatchError {
+    fn new(code: &str, message: String, line: Option<u64>) -> Self {
+        Self {
+            code: code.to_string(),
+            message,
+            param: None,
+            line,
+        }
+    }
+}
+
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct BatchErrors {
+    pub object: String,
+    pub data: Vec<BatchError>,
+}
+
+/// A batch, as OpenAI describes it
+#[derive(Debug, Clone, Serialize)]
+pub struct Batch {
+    pub id: String,
+    pub object: String,
+    pub endpoint: String,
+    pub errors: Option<BatchErrors>,
+    pub input_file_id: String,
+    pub completion_window: String,
+    pub status: BatchStatus,
+    pub output_file_id: Option<String>,
+    pub error_file_id: Option<String>,
+    pub created_at: i64,
+    pub in_progress_at: Option<i64>,
+    pub expires_at: i64,
+    pub finalizing_at: Option<i64>,
+    pub completed_at: Option<i64>,
+    pub failed_at: Option<i64>,
+    pub expired_at: Option<i64>,
+    pub cancelling_at: Option<i64>,
+    pub cancelled_at: Option<i64>,
+    pub request_counts: RequestCounts,
+    pub metadata: Option<BTreeMap<String, String>>,
+    #[serde(skip)]
+    cancel: Arc<CancelSignal>,
+}
+
+/// Body for `POST /v1/batches`
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct CreateBatchRequest {
+    pub input_file_id: String,
+    pub endpoint: String,
+    #[serde(default = "default_completion_window")]
+    pub completion_window: String,
+    #[serde(default)]
+    pub metadata: Option<BTreeMap<String, String>>,
+}
+
+fn default_completion_window() -> String {
+    COMPLETION_WINDOW.to_string()
+}
+
+/// One line of an input file
+#[derive(Debug, Deserialize)]
+struct BatchInputLine {
+    custom_id: String,
+    method: String,
+    url: String,
+    body: Value,
+}
+
+/// Batches by ID
+#[derive(Debug, Default)]
+pub struct BatchStore {
+    batches: RwLock<HashMap<String, Batch>>,
+}
+
+impl BatchStore {
+    pub fn new() -> Self {
+        Self::default()
+    }
+
+    async fn insert(&self, batch: Batch) {
+        let mut batches = self.batches.write().await;
+        if batches.len() >= MAX_RETAINED_BATCHES {
+            let oldest = batches
+                .values()
+                .filter(|batch| batch.status.is_finished())
+                .min_by_key(|batch| batch.created_at)
+                .map(|batch| batch.id.clone());
+            if let Some(oldest) = oldest {
+                batches.remove(&oldest);
+            }
+        }
+        batches.insert(batch.id.clone(), batch);
+    }
+
+    async fn update<F: FnOnce(&mut Batch)>(&self, id: &str, f: F) {
+        if let Some(batch) = self.batches.write().await.get_mut(id) {
+            f(batch);
+        }
+    }
+
+    pub async fn get(&self, id: &str) -> Option<Batch> {
+        self.batches.read().await.get(id).cloned()
+    }
+
+    /// All batches, newest first
+    pub async fn list(&self) -> Vec<Batch> {
+        let mut batches: Vec<Batch> = self.batches.read().await.values().cloned().collect();
+        batches.sort_by(|a, b| b.created_at.cmp(&a.created_at).then(a.id.cmp(&b.id)));
+        batches
+    }
+
+    /// Stop an unfinished batch; `None` if it does not exist
+    pub async fn cancel(&self, id: &str) -> Option<Batch> {
+        let mut batches = self.batches.write().await;
+        let batch = batches.get_mut(id)?;
+        if !batch.status.is_finished() && batch.status != BatchStatus::Cancelling {
+            batch.status = BatchStatus::Cancelling;
+            batch.cancelling_at = Some(now());
+            batch.cancel.cancel();
+        }
+        Some(batch.clone())
+    }
+}
+
+fn now() -> i64 {
+    chrono::Utc::now().timestamp()
+}
+
+/// Check every line of an input file, returning the requests' custom IDs and
+/// bodies, or the problems found
+fn parse_input(content: &[u8], endpoint: &str) -> Result<Vec<(String, Value)>, Vec<BatchError>> {
+    let mut requests = Vec::new();
+    let mut errors = Vec::new();
+    let mut seen = HashSet::new();
+
+    for (index, line) in content.split(|&b| b == b'\n').enumerate() {
+        let number = Some(index as u64 + 1);
+        if line.iter().all(u8::is_ascii_whitespace) {
+            continue;
+        }
+        let input: BatchInputLine = match serde_json::from_slice(line) {
+            Ok(input) => input,
+            Err(e) => {
+                errors.push(BatchError::new(
+                    "invalid_json_line",
+                    format!("not a batch request: {}", e),
+                    number,
+                ));
+                continue;
+            }
+        };
+        if !input.method.eq_ignore_ascii_case("POST") {
+            errors.push(BatchError::new(
+                "invalid_method",
+                format!("method must be POST, not {}", input.method),
+                number,
+            ));
+        }
+        if input.url != endpoint {
+            errors.push(BatchError::new(
+                "mismatched_endpoint",
+                format!("url {} is not the batch's endpoint {}", input.url, endpoint),
+                number,
+            ));
+        }
+        if input.body.get("stream").and_then(Value::as_bool) == Some(true) {
+            errors.push(BatchError::new(
+                "streaming_unsupported",
+                "batched requests cannot stream".to_string(),
+                number,
+            ));
+        }
+        if !seen.insert(input.custom_id.clone()) {
+            errors.push(BatchError::new(
+                "duplicate_custom_id",
+                format!("custom_id {} is used more than once", input.custom_id),
+                number,
+            ));
+        }
+        requests.push((input.custom_id, input.body));
+    }
+
+    if requests.is_empty() && errors.is_empty() {
+        errors.push(BatchError::new(
+            "empty_file",
+            "the input file has no requests".to_string(),
+            None,
+        ));
+    }
+    if requests.len() > MAX_BATCH_REQUESTS {
+        errors.push(BatchError::new(
+            "too_many_requests",
+            format!(
+                "the input file has {} requests; a batch may have at most {}",
+                requests.len(),
+                MAX_BATCH_REQUESTS
+            ),
+            None,
+        ));
+    }
+    if errors.is_empty() {
+        Ok(requests)
+    } else {
+        Err(errors)
+    }
+}
+
+/// Run one request through its endpoint's handler
+async fn dispatch(
+    state: Arc<ServerState>,
+    headers: HeaderMap,
+    endpoint: &str,
+    body: Value,
+) -> Result<Response, String> {
+    let response = match endpoint {
+        "/v1/chat/completions" => {
+            let request: ChatCompletionRequest =
+                serde_json::from_value(body).map_err(|e| e.to_string())?;
+            openai::chat_completions(State(state), headers, Json(request))
+                .await
+                .into_response()
+        }
+        "/v1/completions" => {
+            let request: CompletionRequest =
+                serde_json::from_value(body).map_err(|e| e.to_string())?;
+            openai::completions(State(state), headers, Json(request))
+                .await
+                .into_response()
+        }
+        _ => {
+            let request: EmbeddingRequest =
+                serde_json::from_value(body).map_err(|e| e.to_string())?;
+            openai::embeddings(State(state), Json(request))
+                .await
+                .into_response()
+        }
+    };
+    Ok(response)
+}
+
+/// Run one request, returning whether it succeeded and its result line
+async fn run_request(
+    state: Arc<ServerState>,
+    headers: HeaderMap,
+    endpoint: String,
+    custom_id: String,
+    body: Value,
+) -> (bool, Value) {
+    let request_id = format!("batch_req_{}", Uuid::new_v4().simple());
+    let response = match dispatch(state, headers, &endpoint, body).await {
+        Ok(response) => response,
+        Err(message) => {
+            return (
+                false,
+                json!({
+                    "id": request_id,
+                    "custom_id": custom_id,
+                    "response": null,
+                    "error": { "code": "invalid_request", "message": message }
+                }),
+            );
+        }
+    };
+
+    let status = response.status();
+    let body = match axum::body::to_bytes(response.into_body(), MAX_RESPONSE_BODY).await {
+        Ok(bytes) => serde_json::from_slice::<Value>(&bytes).unwrap_or(Value::Null),
+        Err(_) => Value::Null,
+    };
+    let line = json!({
+        "id": request_id,
+        "custom_id": custom_id,
+        "response": {
+            "status_code": status.as_u16(),
+            "request_id": request_id,
+            "body": body
+        },
+        "error": null
+    });
+    (status.is_success(), line)
+}
+
+/// Fail a batch before any request has run
+async fn fail_batch(state: &ServerState, id: &str, errors: Vec<BatchError>) {
+    let count = errors.len();
+    warn!("Batch {} failed validation with {} errors", id, count);
+    state
+        .batches
+        .update(id, |batch| {
+            batch.status = BatchStatus::Failed;
+            batch.failed_at = Some(now());
+            batch.errors = Some(BatchErrors {
+                object: "list".to_string(),
+                data: errors.into_iter().take(MAX_LISTED_ERRORS).collect(),
+            });
+        })
+        .await;
+}
+
+/// Store `lines` as a batch output file, returning its ID
+async fn write_results(state: &ServerState, name: String, lines: &[Value]) -> Option<String> {
+    if lines.is_empty() {
+        return None;
+    }
+    let mut content = Vec::new();
+    for line in lines {
+        content.extend_from_slice(line.to_string().as_bytes());
+        content.push(b'\n');
+    }
+    match state.files.create(&name, OUTPUT_PURPOSE, &content).await {
+        Ok(file) => Some(file.id),
+        Err(e) => {
+            warn!("Cannot store batch results {}: {}", name, e);
+            None
+        }
+    }
+}
+
+async fn execute_batch(
+    state: Arc<ServerState>,
+    id: String,
+    endpoint: String,
+    input_file_id: String,
+    headers: HeaderMap,
+    expires_at: i64,
+    cancel: Arc<CancelSignal>,
+) {
+    let content = match state.files.read(&input_file_id).await {
+        Ok(Some(content)) => content,
+        Ok(None) => {
+            let message = format!("input file {} no longer exists", input_file_id);
+            fail_batch(
+                &state,
+                &id,
+                vec![BatchError::new("file_not_found", message, None)],
+            )
+            .await;
+            return;
+        }
+        Err(e) => {
+            let message = format!("cannot read input file: {}", e);
+            fail_batch(
+                &state,
+                &id,
+                vec![BatchError::new("file_unreadable", message, None)],
+            )
+            .await;
+            return;
+        }
+    };
+    let requests = match parse_input(&content, &endpoint) {
+        Ok(requests) => requests,
+        Err(errors) => {
+            fail_batch(&state, &id, errors).await;
+            return;
+        }
+    };
+    drop(content);
+
+    let total = requests.len() as u64;
+    state
+        .batches
+        .update(&id, |batch| {
+            batch.status = BatchStatus::InProgress;
+            batch.in_progress_at = Some(now());
+            batch.request_counts.total = total;
+        })
+        .await;
+    info!("Running batch {}: {} requests to {}", id, total, endpoint);
+
+    let mut results = futures::stream::iter(requests)
+        .map(|(custom_id, body)| {
+            run_request(
+                Arc::clone(&state),
+                headers.clone(),
+                endpoint.clone(),
+                custom_id,
+                body,
+            )
+        })
+        .buffer_unordered(BATCH_CONCURRENCY);
+    let deadline = Duration::from_secs((expires_at - now()).max(0) as u64);
+    let expiry = tokio::time::sleep(deadline);
+    tokio::pin!(expiry);
+
+    let mut output = Vec::new();
+    let mut failures = Vec::new();
+    let mut expired = false;
+    loop {
+        tokio::select! {
+            result = results.next() => match result {
+                Some((succeeded, line)) => {
+                    if succeeded {
+                        output.push(line);
+                    } else {
+                        failures.push(line);
+                    }
+                    state
+                        .batches
+                        .update(&id, |batch| {
+                            if succeeded {
+                                batch.request_counts.completed += 1;
+                            } else {
+                                batch.request_counts.failed += 1;
+                            }
+                        })
+                        .await;
+                }
+                None => break,
+            },
+            _ = cancel.cancelled() => break,
+            _ = &mut expiry => {
+                expired = true;
+                break;
+            }
+        }
+    }
+    // Requests still running are dropped, which stops their generation
+    drop(results);
+
+    state
+        .batches
+        .update(&id, |batch| {
+            batch.status = BatchStatus::Finalizing;
+            batch.finalizing_at = Some(now());
+        })
+        .await;
+    let output_file_id = write_results(&state, format!("{}_output.jsonl", id), &output).await;
+    let error_file_id = write_results(&state, format!("{}_error.jsonl", id), &failures).await;
+
+    let cancelled = cancel.is_cancelled();
+    state
+        .batches
+        .update(&id, |batch| {
+            batch.output_file_id = output_file_id;
+            batch.error_file_id = error_file_id;
+            let finished = Some(now());
+            if cancelled {
+                batch.status = BatchStatus::Cancelled;
+                batch.cancelled_at = finished;
+            } else if expired {
+                batch.status = BatchStatus::Expired;
+                batch.expired_at = finished;
+            } else {
+                batch.status = BatchStatus::Completed;
+                batch.completed_at = finished;
+            }
+        })
+        .await;
+    info!(
+        "Batch {} finished: {} succeeded, {} failed of {}",
+        id,
+        output.len(),
+        failures.len(),
+        total
+    );
+}
+
+// API Handlers
+
+/// `POST /v1/batches` - run an uploaded JSONL file of requests (admin only)
+pub async fn create_batch(
+    State(state): State<Arc<ServerState>>,
+    headers: HeaderMap,
+    Json(request): Json<CreateBatchRequest>,
+) -> Response {
+    if let Err(response) = authorize_admin(&headers) {
+        return response;
+    }
+
+    if !ENDPOINTS.contains(&request.endpoint.as_str()) {
+        return invalid_request(
+            format!(
+                "'{}' is not a supported endpoint; expected one of {}",
+                request.endpoint,
+                ENDPOINTS.join(", ")
+            ),
+            Some("endpoint"),
+            None,
+        );
+    }
+    if request.completion_window != COMPLETION_WINDOW {
+        return invalid_request(
+            format!("completion_window must be {}", COMPLETION_WINDOW),
+            Some("completion_window"),
+            None,
+        );
+    }
+    match state.files.get(&request.input_file_id).await {
+        Some(file) if file.purpose == "batch" => {}
+        Some(file) => {
+            return invalid_request(
+                format!(
+                    "file {} has purpose '{}'; batch inputs need purpose 'batch'",
+                    file.id, file.purpose
+                ),
+                Some("input_file_id"),
+                None,
+            );
+        }
+        None => {
+            return invalid_request(
+                format!("No such File object: {}", request.input_file_id),
+                Some("input_file_id"),
+                None,
+            );
+        }
+    }
+
+    let created_at = now();
+    let cancel = Arc::new(CancelSignal::new());
+    let batch = Batch {
+        id: format!("batch_{}", Uuid::new_v4().simple()),
+        object: "batch".to_string(),
+        endpoint: request.endpoint.clone(),
+        errors: None,
+        input_file_id: request.input_file_id.clone(),
+        completion_window: request.completion_window,
+        status: BatchStatus::Validating,
+        output_file_id: None,
+        error_file_id: None,
+        created_at,
+        in_progress_at: None,
+        expires_at: created_at + COMPLETION_WINDOW_SECS,
+        finalizing_at: None,
+        completed_at: None,
+        failed_at: None,
+        expired_at: None,
+        cancelling_at: None,
+        cancelled_at: None,
+        request_counts: RequestCounts::default(),
+        metadata: request.metadata,
+        cancel: Arc::clone(&cancel),
+    };
+    state.batches.insert(batch.clone()).await;
+
+    info!(
+        "Created batch {} for {} from {}",
+        batch.id, batch.endpoint, batch.input_file_id
+    );
+    tokio::spawn(execute_batch(
+        Arc::clone(&state),
+        batch.id.clone(),
+        request.endpoint,
+        request.input_file_id,
+        headers,
+        batch.expires_at,
+        cancel,
+    ));
+
+    Json(batch).into_response()
+}
+
+/// `GET /v1/batches` - all batches, newest first (admin only)
+pub async fn list_batches(State(state): State<Arc<ServerState>>, headers: HeaderMap) -> Response {
+    if let Err(response) = authorize_admin(&headers) {
+        return response;
+    }
+
+    Json(json!({
+        "object": "list",
+        "data": state.batches.list().await,
+        "has_more": false
+    }))
+    .into_response()
+}
+
+/// `GET /v1/batches/:batch_id` - a batch's status and counts (admin only)
+pub async fn get_batch(
+    State(state): State<Arc<ServerState>>,
+    Path(batch_id): Path<String>,
+    headers: HeaderMap,
+) -> Response {
+    if let Err(response) = authorize_admin(&headers) {
+        return response;
+    }
+
+    match state.batches.get(&batch_id).await {
+        Some(batch) => Json(batch).into_response(),
+        None => batch_not_found(&batch_id),
+    }
+}
+
+/// `POST /v1/batches/:batch_id/cancel` - stop a batch, keeping the results
+/// of requests already finished (admin only)
+pub async fn cancel_batch(
+    State(state): State<Arc<ServerState>>,
+    Path(batch_id): Path<String>,
+    headers: HeaderMap,
+) -> Response {
+    if let Err(response) = authorize_admin(&headers) {
+        return response;
+    }
+
+    match state.batches.cancel(&batch_id).await {
+        Some(batch) => Json(batch).into_response(),
+        None => batch_not_found(&batch_id),
+    }
+}
+
+fn batch_not_found(batch_id: &str) -> Response {
+    (
+        StatusCode::NOT_FOUND,
+        Json(json!({
+            "error": {
+                "message": format!("No such Batch object: {}", batch_id),
+                "type": "invalid_request_error",
+                "param": "batch_id",
+                "code": "batch_not_found"
+            }
+        })),
+    )
+        .into_response()
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    const CHAT: &str = "/v1/chat/completions";
+
+    fn line(custom_id: &str, url: &str, body: Value) -> String {
+        json!({"custom_id": custom_id, "method": "POST", "url": url, "body": body}).to_string()
+    }
+
+    #[test]
+    fn parses_requests_and_skips_blank_lines() {
+        let body = json!({"model": "m", "messages": []});
+        let content = format!(
+            "{}\n\n{}\n",
+            line("a", CHAT, body.clone()),
+            line("b", CHAT, body)
+        );
+        let requests = parse_input(content.as_bytes(), CHAT).unwrap();
+        let ids: Vec<&str> = requests.iter().map(|(id, _)| id.as_str()).collect();
+        assert_eq!(ids, ["a", "b"]);
+    }
+
+    #[test]
+    fn reports_bad_lines_by_number() {
+        let body = json!({"model": "m", "messages": []});
+        let content = [
+            line("a", CHAT, body.clone()),
+            "not json".to_string(),
+            line("a", CHAT, body.clone()),
+            line("c", "/v1/embeddings", body),
+            line("d", CHAT, json!({"model": "m", "stream": true})),
+        ]
+        .join("\n");
+        let errors = parse_input(content.as_bytes(), CHAT).unwrap_err();
+        let found: Vec<(&str, Option<u64>)> = errors
+            .iter()
+            .map(|error| (error.code.as_str(), error.line))
+            .collect();
+        assert_eq!(
+            found,
+            [
+                ("invalid_json_line", Some(2)),
+                ("duplicate_custom_id", Some(3)),
+                ("mismatched_endpoint", Some(4)),
+                ("streaming_unsupported", Some(5)),
+            ]
+        );
+        assert_eq!(parse_input(b"\n", CHAT).unwrap_err()[0].code, "empty_file");
+    }
+}
@@ -0,0 +1,174 @@
+//! Continuous Batching Tuning
+//!
+//! Exposes the dynamic batcher's limits so operators can trade throughput
+//! against latency at runtime: `GET /v1/batching/config` returns the
+//! configured and currently effective limits, the admin-only
+//! `PUT /v1/batching/config` changes them, and `GET /v1/batching/stats`
+//! reports aggregate metrics plus per-batch statistics for recent batches.
+
+use crate::{
+    api::admin::authorize_admin,
+    cli::serve::ServerState,
+    optimization::batching::{
+        BatchRecord, BatchingConfig, BatchingMetrics, EffectiveBatchingParams, PaddingStrategy,
+    },
+};
+use axum::{
+    Json,
+    extract::{Query, State},
+    http::{HeaderMap, StatusCode},
+    response::{IntoResponse, Response},
+};
+use serde::{Deserialize, Serialize};
+use serde_json::json;
+use std::sync::Arc;
+
+/// Batches returned by the stats endpoint when no limit is given
+const DEFAULT_RECENT_BATCHES: usize = 20;
+
+/// Configured limits alongside the values adaptive batching settled on
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct BatchingConfigResponse {
+    pub config: BatchingConfig,
+    pub effective: EffectiveBatchingParams,
+}
+
+/// Partial update; omitted fields keep their current value
+#[derive(Debug, Clone, Default, Deserialize)]
+pub struct BatchingConfigUpdate {
+    pub enabled: Option<bool>,
+    pub max_batch_size: Option<usize>,
+    pub max_wait_time_ms: Option<u64>,
+    pub min_batch_size: Option<usize>,
+    pub adaptive_batching: Option<bool>,
+    pub sequence_length_grouping: Option<bool>,
+    pub padding_strategy: Option<PaddingStrategy>,
+    pub throughput_target: Option<f64>,
+}
+
+impl BatchingConfigUpdate {
+    fn apply(self, mut config: BatchingConfig) -> BatchingConfig {
+        if let Some(enabled) = self.enabled {
+            config.enabled = enabled;
+        }
+        if let Some(max_batch_size) = self.max_batch_size {
+            config.max_batch_size = max_batch_size;
+        }
+        if let Some(max_wait_time_ms) = self.max_wait_time_ms {
+            config.max_wait_time_ms = max_wait_time_ms;
+        }
+        if let Some(min_batch_size) = self.min_batch_size {
+            config.min_batch_size = min_batch_size;
+        }
+        if let Some(adaptive_batching) = self.adaptive_batching {
+            config.adaptive_batching = adaptive_batching;
+        }
+        if let Some(grouping) = self.sequence_length_grouping {
+            config.sequence_length_grouping = grouping;
+        }
+        if let Some(padding_strategy) = self.padding_strategy {
+            config.padding_strategy = padding_strategy;
+        }
+        if let Some(throughput_target) = self.throughput_target {
+            config.throughput_target = throughput_target;
+        }
+        config
+    }
+}
+
+#[derive(Debug, Deserialize)]
+pub struct BatchStatsQuery {
+    pub limit: Option<usize>,
+}
+
+/// Aggregate and per-batch statistics returned by `/v1/batching/stats`
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct BatchingStatsResponse {
+    pub metrics: BatchingMetrics,
+    pub effective: EffectiveBatchingParams,
+    pub queued_by_priority: std::collections::HashMap<String, usize>,
+    pub recent_batches: Vec<BatchRecord>,
+    pub timestamp: chrono::DateTime<chrono::Utc>,
+}
+
+// API Handlers
+
+/// `GET /v1/batching/config` - configured and effective batching limits
+pub async fn get_batching_config(State(state): State<Arc<ServerState>>) -> impl IntoResponse {
+    Json(BatchingConfigResponse {
+        config: state.batcher.config().await,
+        effective: state.batcher.effective_params().await,
+    })
+}
+
+/// `PUT /v1/batching/config` - change batching limits at runtime (admin only)
+pub async fn update_batching_config(
+    State(state): State<Arc<ServerState>>,
+    headers: HeaderMap,
+    Json(update): Json<BatchingConfigUpdate>,
+) -> Response {
+    if let Err(response) = authorize_admin(&headers) {
+        return response;
+    }
+
+    let config = update.apply(state.batcher.config().await);
+    if let Err(e) = state.batcher.update_config(config).await {
+        return (
+            StatusCode::BAD_REQUEST,
+            Json(json!({
+                "error": {
+                    "message": e.to_string(),
+                    "type": "invalid_request_error",
+                    "param": null,
+                    "code": null
+                }
+            })),
+        )
+            .into_response();
+    }
+
+    Json(BatchingConfigResponse {
+        config: state.batcher.config().await,
+        effective: state.batcher.effective_params().await,
+    })
+    .into_response()
+}
+
+/// `GET /v1/batching/stats` - batching metrics and recent per-batch statistics
+pub async fn batching_stats(
+    State(state): State<Arc<ServerState>>,
+    Query(query): Query<BatchStatsQuery>,
+) -> impl IntoResponse {
+    let limit = query.limit.unwrap_or(DEFAULT_RECENT_BATCHES);
+
+    Json(BatchingStatsResponse {
+        metrics: state.batcher.get_metrics().await,
+        effective: state.batcher.effective_params().await,
+        queued_by_priority: state.batcher.get_queue_status().await,
+        recent_batches: state.batcher.recent_batches(limit).await,
+        timestamp: chrono::Utc::now(),
+    })
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_partial_update_keeps_other_fields() {
+        let update: BatchingConfigUpdate =
+            serde_json::from_str(r#"{"max_batch_size": 8, "padding_strategy": "RightPadding"}"#)
+                .unwrap();
+        let config = update.apply(BatchingConfig::default());
+
+        assert_eq!(config.max_batch_size, 8);
+        assert!(matches!(
+            config.padding_strategy,
+            PaddingStrategy::RightPadding
+        ));
+        assert_eq!(
+            config.max_wait_time_ms,
+            BatchingConfig::default().max_wait_time_ms
+        );
+    }
+}
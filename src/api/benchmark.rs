@@ -0,0 +1,445 @@
+//! Model Benchmarking
+//!
+//! `POST /v1/models/:model_id/benchmark` runs a standard suite of prompt
+//! lengths × generation lengths against a model and reports tokens/sec,
+//! time-to-first-token and the server's peak resident memory, so users no
+//! longer need ad-hoc benchmark scripts. Benchmarks monopolise the backend,
+//! so the endpoint is admin-only and runs through the request queue at low
+//! priority, which also makes a running benchmark cancellable by its
+//! request ID.
+
+use crate::{
+    api::{
+        admin::authorize_admin,
+        cancellation::{CancelSignal, FinishReason, next_token, with_request_id},
+        openai::{estimate_tokens, get_or_load_backend},
+    },
+    backends::{BackendHandle, InferenceParams},
+    cli::serve::ServerState,
+    operations::queue::Priority,
+};
+use axum::{
+    Json,
+    extract::{Path, State},
+    http::{HeaderMap, StatusCode},
+    response::{IntoResponse, Response},
+};
+use serde::{Deserialize, Serialize};
+use serde_json::json;
+use std::{
+    sync::{
+        Arc,
+        atomic::{AtomicU64, Ordering},
+    },
+    time::{Duration, Instant},
+};
+use tracing::info;
+
+/// Most cases (prompt lengths × generation lengths × iterations) per run
+const MAX_BENCHMARK_RUNS: usize = 100;
+
+/// Longest prompt a benchmark case may request, in tokens
+const MAX_PROMPT_TOKENS: u32 = 32_768;
+
+/// How often resident memory is sampled while the suite runs
+const MEMORY_SAMPLE_INTERVAL: Duration = Duration::from_millis(100);
+
+/// Filler used to build prompts of a given length
+const PROMPT_FILLER: &str = "The quick brown fox jumps over the lazy dog. ";
+
+fn default_prompt_tokens() -> Vec<u32> {
+    vec![128, 512, 2048]
+}
+
+fn default_generation_tokens() -> Vec<u32> {
+    vec![32, 128]
+}
+
+fn default_iterations() -> usize {
+    3
+}
+
+/// Suite definition; an empty body runs the standard suite
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct BenchmarkRequest {
+    #[serde(default = "default_prompt_tokens")]
+    pub prompt_tokens: Vec<u32>,
+    #[serde(default = "default_generation_tokens")]
+    pub generation_tokens: Vec<u32>,
+    #[serde(default = "default_iterations")]
+    pub iterations: usize,
+    /// Untimed generations run first to warm caches
+    #[serde(default)]
+    pub warmup_iterations: usize,
+}
+
+impl Default for BenchmarkRequest {
+    fn default() -> Self {
+        Self {
+            prompt_tokens: default_prompt_tokens(),
+            generation_tokens: default_generation_tokens(),
+            iterations: default_iterations(),
+            warmup_iterations: 0,
+        }
+    }
+}
+
+impl BenchmarkRequest {
+    pub fn validate(&self) -> Result<(), String> {
+        if self.prompt_tokens.is_empty() || self.generation_tokens.is_empty() {
+            return Err("prompt_tokens and generation_tokens must not be empty".to_string());
+        }
+        if self.iterations == 0 {
+            return Err("iterations must be at least 1".to_string());
+        }
+        if self
+            .prompt_tokens
+            .iter()
+            .any(|&n| n == 0 || n > MAX_PROMPT_TOKENS)
+        {
+            return Err(format!(
+                "prompt_tokens must be between 1 and {}",
+                MAX_PROMPT_TOKENS
+            ));
+        }
+        if self.generation_tokens.contains(&0) {
+            return Err("generation_tokens must be positive".to_string());
+        }
+        let runs = self.prompt_tokens.len()
+            * self.generation_tokens.len()
+            * (self.iterations + self.warmup_iterations);
+        if runs > MAX_BENCHMARK_RUNS {
+            return Err(format!(
+                "suite would run {} generations; the limit is {}",
+                runs, MAX_BENCHMARK_RUNS
+            ));
+        }
+        Ok(())
+    }
+}
+
+/// Timings for one prompt length × generation length combination
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct BenchmarkCase {
+    pub prompt_tokens: u32,
+    pub max_tokens: u32,
+    pub iterations: usize,
+    /// Mean tokens actually generated (generation may stop early at EOS)
+    pub avg_generated_tokens: f64,
+    pub avg_time_to_first_token_ms: f64,
+    pub p50_time_to_first_token_ms: f64,
+    pub avg_total_latency_ms: f64,
+    /// Decode throughput after the first token
+    pub avg_tokens_per_second: f64,
+}
+
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct BenchmarkMemory {
+    pub start_rss_bytes: u64,
+    pub peak_rss_bytes: u64,
+}
+
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct BenchmarkReport {
+    pub model: String,
+    pub started_at: chrono::DateTime<chrono::Utc>,
+    pub duration_ms: u64,
+    pub cases: Vec<BenchmarkCase>,
+    pub avg_tokens_per_second: f64,
+    pub avg_time_to_first_token_ms: f64,
+    pub memory: BenchmarkMemory,
+    /// Set when the run was cancelled or timed out; `cases` holds what finished
+    pub incomplete: Option<String>,
+}
+
+/// Timing of a single generation
+struct RunTiming {
+    generated_tokens: usize,
+    time_to_first_token: Duration,
+    total: Duration,
+}
+
+/// Build a prompt of roughly `tokens` tokens, by the server's token estimate
+pub fn synthetic_prompt(tokens: u32) -> String {
+    let chars = tokens as usize * 4;
+    PROMPT_FILLER.chars().cycle().take(chars).collect()
+}
+
+async fn timed_generation(
+    backend: &BackendHandle,
+    prompt: &str,
+    max_tokens: u32,
+    cancel: &CancelSignal,
+    deadline: Option<tokio::time::Instant>,
+) -> anyhow::Result<Result<RunTiming, FinishReason>> {
+    let params = InferenceParams {
+        max_tokens,
+        temperature: 0.0,
+        top_k: 1,
+        top_p: 1.0,
+        stream: true,
+        stop_sequences: Vec::new(),
+        seed: Some(0),
+        stop_token_ids: vec![],
+        ignore_eos: false,
+        skip_special_tokens: None,
+        prompt_token_ids: None,
+    };
+
+    let started = Instant::now();
+    let mut stream = backend.infer_stream(prompt, &params).await?;
+    let mut first_token = None;
+    let mut generated_tokens = 0;
+
+    loop {
+        match next_token(&mut stream, cancel, deadline).await {
+            Ok(Some(token)) => {
+                token?;
+                first_token.get_or_insert_with(|| started.elapsed());
+                generated_tokens += 1;
+            }
+            Ok(None) => break,
+            Err(reason) => return Ok(Err(reason)),
+        }
+    }
+
+    let total = started.elapsed();
+    Ok(Ok(RunTiming {
+        generated_tokens,
+        time_to_first_token: first_token.unwrap_or(total),
+        total,
+    }))
+}
+
+fn summarize(prompt_tokens: u32, max_tokens: u32, runs: &[RunTiming]) -> BenchmarkCase {
+    let n = runs.len().max(1) as f64;
+    let ms = |d: Duration| d.as_secs_f64() * 1000.0;
+
+    let mut ttfts: Vec<f64> = runs.iter().map(|r| ms(r.time_to_first_token)).collect();
+    ttfts.sort_by(|a, b| a.total_cmp(b));
+    let p50 = ttfts.get(ttfts.len() / 2).copied().unwrap_or(0.0);
+
+    let tokens_per_second = runs
+        .iter()
+        .map(|r| {
+            let decode = (r.total - r.time_to_first_token).as_secs_f64();
+            if decode > 0.0 && r.generated_tokens > 1 {
+                (r.generated_tokens - 1) as f64 / decode
+            } else {
+                0.0
+            }
+        })
+        .sum::<f64>()
+        / n;
+
+    BenchmarkCase {
+        prompt_tokens,
+        max_tokens,
+        iterations: runs.len(),
+        avg_generated_tokens: runs.iter().map(|r| r.generated_tokens as f64).sum::<f64>() / n,
+        avg_time_to_first_token_ms: ttfts.iter().sum::<f64>() / n,
+        p50_time_to_first_token_ms: p50,
+        avg_total_latency_ms: runs.iter().map(|r| ms(r.total)).sum::<f64>() / n,
+        avg_tokens_per_second: tokens_per_second,
+    }
+}
+
+/// Resident memory of the server process in bytes
+fn process_rss_bytes() -> u64 {
+    use sysinfo::{PidExt, ProcessExt, System, SystemExt};
+
+    let pid = sysinfo::Pid::from_u32(std::process::id());
+    let mut system = System::new();
+    system.refresh_process(pid);
+    system.process(pid).map(|p| p.memory()).unwrap_or(0)
+}
+
+// API Handlers
+
+/// `POST /v1/models/:model_id/benchmark` - run the benchmark suite (admin only)
+pub async fn benchmark_model(
+    State(state): State<Arc<ServerState>>,
+    Path(model_id): Path<String>,
+    headers: HeaderMap,
+    body: Option<Json<BenchmarkRequest>>,
+) -> Response {
+    if let Err(response) = authorize_admin(&headers) {
+        return response;
+    }
+
+    let suite = body.map(|Json(suite)| suite).unwrap_or_default();
+    if let Err(message) = suite.validate() {
+        return (
+            StatusCode::BAD_REQUEST,
+            Json(json!({
+                "error": {
+                    "message": message,
+                    "type": "invalid_request_error",
+                    "param": null,
+                    "code": null
+                }
+            })),
+        )
+            .into_response();
+    }
+
+    let ticket = match state
+        .request_queue
+        .enqueue_request(&headers, &model_id, Priority::Low)
+    {
+        Ok(ticket) => ticket.with_scheduling(None, &headers),
+        Err(response) => return response,
+    };
+    let request_id = ticket.id().to_string();
+
+    let backend = match get_or_load_backend(&state, &model_id).await {
+        Ok(backend) => backend,
+        Err(e) => {
+            return (
+                StatusCode::BAD_REQUEST,
+                Json(json!({
+                    "error": {
+                        "message": format!("Failed to load model: {}", e),
+                        "type": "invalid_request_error",
+                        "param": "model_id",
+                        "code": null
+                    }
+                })),
+            )
+                .into_response();
+        }
+    };
+
+    ticket.start().await;
+    info!("Benchmarking {} ({})", model_id, request_id);
+
+    let started_at = chrono::Utc::now();
+    let started = Instant::now();
+    let start_rss = process_rss_bytes();
+
+    // Sample memory in the background so peaks during decoding are caught
+    let peak_rss = Arc::new(AtomicU64::new(start_rss));
+    let sampler = {
+        let peak_rss = Arc::clone(&peak_rss);
+        tokio::spawn(async move {
+            let mut interval = tokio::time::interval(MEMORY_SAMPLE_INTERVAL);
+            loop {
+                interval.tick().await;
+                peak_rss.fetch_max(process_rss_bytes(), Ordering::Relaxed);
+            }
+        })
+    };
+
+    let mut cases = Vec::new();
+    let mut incomplete = None;
+
+    'suite: for &prompt_tokens in &suite.prompt_tokens {
+        let prompt = synthetic_prompt(prompt_tokens);
+        for &max_tokens in &suite.generation_tokens {
+            let mut runs = Vec::with_capacity(suite.iterations);
+            for iteration in 0..suite.warmup_iterations + suite.iterations {
+                let timing = match timed_generation(
+                    &backend,
+                    &prompt,
+                    max_tokens,
+                    ticket.cancel_signal(),
+                    ticket.deadline(),
+                )
+                .await
+                {
+                    Ok(Ok(timing)) => timing,
+                    Ok(Err(reason)) => {
+                        incomplete = Some(format!("benchmark {}", reason.as_str()));
+                        break 'suite;
+                    }
+                    Err(e) => {
+                        sampler.abort();
+                        return (
+                            StatusCode::INTERNAL_SERVER_ERROR,
+                            Json(json!({
+                                "error": {
+                                    "message": format!("Benchmark failed: {}", e),
+                                    "type": "internal_error",
+                                    "param": null,
+                                    "code": null
+                                }
+                            })),
+                        )
+                            .into_response();
+                    }
+                };
+                if iteration >= suite.warmup_iterations {
+                    runs.push(timing);
+                }
+            }
+            cases.push(summarize(estimate_tokens(&prompt), max_tokens, &runs));
+        }
+    }
+
+    sampler.abort();
+    peak_rss.fetch_max(process_rss_bytes(), Ordering::Relaxed);
+
+    let n = cases.len().max(1) as f64;
+    let report = BenchmarkReport {
+        model: model_id,
+        started_at,
+        duration_ms: started.elapsed().as_millis() as u64,
+        avg_tokens_per_second: cases.iter().map(|c| c.avg_tokens_per_second).sum::<f64>() / n,
+        avg_time_to_first_token_ms: cases
+            .iter()
+            .map(|c| c.avg_time_to_first_token_ms)
+            .sum::<f64>()
+            / n,
+        cases,
+        memory: BenchmarkMemory {
+            start_rss_bytes: start_rss,
+            peak_rss_bytes: peak_rss.load(Ordering::Relaxed),
+        },
+        incomplete,
+    };
+
+    with_request_id(Json(report).into_response(), &request_id)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_synthetic_prompt_length() {
+        let prompt = synthetic_prompt(128);
+        assert_eq!(estimate_tokens(&prompt), 128);
+    }
+
+    #[test]
+    fn test_validate_limits_suite_size() {
+        assert!(BenchmarkRequest::default().validate().is_ok());
+
+        let huge = BenchmarkRequest {
+            iterations: 50,
+            ..Default::default()
+        };
+        assert!(huge.validate().is_err());
+    }
+
+    #[test]
+    fn test_summarize() {
+        let runs = vec![
+            RunTiming {
+                generated_tokens: 11,
+                time_to_first_token: Duration::from_millis(100),
+                total: Duration::from_millis(1100),
+            },
+            RunTiming {
+                generated_tokens: 11,
+                time_to_first_token: Duration::from_millis(300),
+                total: Duration::from_millis(1300),
+            },
+        ];
+        let case = summarize(128, 32, &runs);
+        assert_eq!(case.iterations, 2);
+        assert!((case.avg_tokens_per_second - 10.0).abs() < 1e-6);
+        assert!((case.avg_time_to_first_token_ms - 200.0).abs() < 1e-6);
+        assert!((case.avg_generated_tokens - 11.0).abs() < 1e-6);
+    }
+}
@@ -0,0 +1,711 @@
+//! Billing Webhooks
+//!
+//! Operators subscribe an endpoint to billing events through
+//! `/admin/webhooks` (admin only):
+//!
+//! - `usage.threshold_crossed` when a tenant's tokens this period pass one
+//!   of the subscription's `thresholds`
+//! - `budget.exhausted` when a tenant or key budget reaches its hard limit
+//!   or first refuses a request
+//! - `usage.period_summary` after each day or month ends, with the
+//!   period's usage by tenant and model
+//!
+//! A subscription may be limited to one tenant. Each delivery is a JSON
+//! event signed with the subscription's secret, which is returned only when
+//! the subscription is created: `X-Inferno-Webhook-Signature` carries
+//! `timestamp=<unix>,signature=<hex>`, the HMAC-SHA256 of
+//! `<timestamp>.<body>`. Failed deliveries are retried a few times, then
+//! dropped. Subscriptions are held in memory.
+
+use crate::{
+    api::{
+        admin::authorize_admin,
+        budgets::{BudgetPeriod, BudgetStatus},
+        error_response,
+        usage::{ParsedQuery, UsageGroup, UsageQuery, UsageRecord},
+    },
+    cli::serve::ServerState,
+};
+use axum::{
+    Json,
+    extract::{Path, State},
+    http::{HeaderMap, StatusCode},
+    response::{IntoResponse, Response},
+};
+use chrono::{DateTime, Utc};
+use ring::hmac;
+use serde::{Deserialize, Serialize};
+use serde_json::{Value, json};
+use std::{
+    collections::HashMap,
+    sync::{Arc, Mutex},
+    time::Duration,
+};
+use tracing::{info, warn};
+use uuid::Uuid;
+
+pub const SIGNATURE_HEADER: &str = "x-inferno-webhook-signature";
+pub const EVENT_HEADER: &str = "x-inferno-webhook-event";
+
+/// Subscriptions the server holds
+const MAX_SUBSCRIPTIONS: usize = 32;
+
+/// Usage thresholds one subscription may set
+const MAX_THRESHOLDS: usize = 16;
+
+/// How often ended periods are checked for summaries to send
+const SUMMARY_INTERVAL: Duration = Duration::from_secs(60);
+
+/// Attempts at each delivery, and the wait before the first retry, which
+/// doubles after each
+const DELIVERY_ATTEMPTS: u32 = 3;
+const RETRY_DELAY: Duration = Duration::from_secs(2);
+
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Hash, Serialize, Deserialize)]
+pub enum WebhookEvent {
+    #[serde(rename = "usage.threshold_crossed")]
+    UsageThresholdCrossed,
+    #[serde(rename = "budget.exhausted")]
+    BudgetExhausted,
+    #[serde(rename = "usage.period_summary")]
+    PeriodSummary,
+}
+
+impl WebhookEvent {
+    const ALL: [WebhookEvent; 3] = [
+        WebhookEvent::UsageThresholdCrossed,
+        WebhookEvent::BudgetExhausted,
+        WebhookEvent::PeriodSummary,
+    ];
+
+    fn as_str(&self) -> &'static str {
+        match self {
+            WebhookEvent::UsageThresholdCrossed => "usage.threshold_crossed",
+            WebhookEvent::BudgetExhausted => "budget.exhausted",
+            WebhookEvent::PeriodSummary => "usage.period_summary",
+        }
+    }
+}
+
+fn default_period() -> BudgetPeriod {
+    BudgetPeriod::Monthly
+}
+
+/// `POST /admin/webhooks` body
+#[derive(Debug, Clone, Deserialize)]
+pub struct CreateSubscription {
+    pub url: String,
+    /// Every event when omitted
+    #[serde(default)]
+    pub events: Option<Vec<WebhookEvent>>,
+    /// Only this tenant's usage and budgets; every tenant's when omitted
+    #[serde(default)]
+    pub tenant: Option<String>,
+    /// The period thresholds count over and summaries cover
+    #[serde(default = "default_period")]
+    pub period: BudgetPeriod,
+    /// Total tokens per tenant and period that trigger
+    /// `usage.threshold_crossed`
+    #[serde(default)]
+    pub thresholds: Vec<u64>,
+    #[serde(default)]
+    pub description: Option<String>,
+}
+
+impl CreateSubscription {
+    fn validate(&self) -> Result<(), (String, &'static str)> {
+        if !(self.url.starts_with("http://") || self.url.starts_with("https://")) {
+            return Err(("url must be an http or https URL".to_string(), "url"));
+        }
+        if self.events.as_ref().is_some_and(Vec::is_empty) {
+            return Err(("events may not be empty".to_string(), "events"));
+        }
+        if self.thresholds.len() > MAX_THRESHOLDS {
+            return Err((
+                format!("At most {} thresholds may be set", MAX_THRESHOLDS),
+                "thresholds",
+            ));
+        }
+        if self.thresholds.contains(&0) {
+            return Err(("thresholds must be above zero".to_string(), "thresholds"));
+        }
+        Ok(())
+    }
+}
+
+/// Deliveries made to a subscription
+#[derive(Debug, Clone, Default, Serialize, Deserialize)]
+pub struct DeliveryStats {
+    pub succeeded: u64,
+    pub failed: u64,
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub last_attempt_at: Option<DateTime<Utc>>,
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub last_error: Option<String>,
+}
+
+/// A webhook subscription as the API returns it
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct Subscription {
+    pub id: String,
+    pub object: String,
+    pub url: String,
+    pub events: Vec<WebhookEvent>,
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub tenant: Option<String>,
+    pub period: BudgetPeriod,
+    pub thresholds: Vec<u64>,
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub description: Option<String>,
+    /// Only in the response creating the subscription
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub secret: Option<String>,
+    pub deliveries: DeliveryStats,
+    pub created_at: DateTime<Utc>,
+}
+
+impl Subscription {
+    fn wants(&self, event: WebhookEvent, tenant: Option<&str>) -> bool {
+        self.events.contains(&event)
+            && self
+                .tenant
+                .as_deref()
+                .is_none_or(|scope| tenant == Some(scope))
+    }
+}
+
+/// A tenant's tokens in a subscription's current period
+#[derive(Debug, Clone)]
+struct PeriodUsage {
+    period_start: DateTime<Utc>,
+    tokens: u64,
+    /// Thresholds already crossed
+    crossed: usize,
+}
+
+#[derive(Debug)]
+struct Entry {
+    subscription: Subscription,
+    secret: String,
+    usage: HashMap<String, PeriodUsage>,
+    /// End of the period whose summary is sent next
+    summary_due: DateTime<Utc>,
+}
+
+/// A signed event waiting to be sent
+#[derive(Debug, Clone)]
+struct Delivery {
+    subscription_id: String,
+    url: String,
+    secret: String,
+    event: WebhookEvent,
+    body: String,
+}
+
+/// Webhook subscriptions and what they have seen
+#[derive(Debug, Default)]
+pub struct WebhookStore {
+    entries: Arc<Mutex<HashMap<String, Entry>>>,
+    client: reqwest::Client,
+}
+
+impl WebhookStore {
+    pub fn new() -> Self {
+        Self {
+            entries: Arc::new(Mutex::new(HashMap::new())),
+            client: reqwest::Client::builder()
+                .user_agent("inferno/1.0")
+                .timeout(Duration::from_secs(10))
+                .build()
+                .unwrap_or_default(),
+        }
+    }
+
+    fn create(&self, request: CreateSubscription) -> Result<Subscription, String> {
+        let now = Utc::now();
+        let mut thresholds = request.thresholds;
+        thresholds.sort_unstable();
+        thresholds.dedup();
+        let mut events = request.events.unwrap_or_else(|| WebhookEvent::ALL.to_vec());
+        events.dedup();
+
+        let secret = format!("whsec_{}", hex::encode(rand::random::<[u8; 24]>()));
+        let subscription = Subscription {
+            id: format!("whsub_{}", Uuid::new_v4().simple()),
+            object: "webhook_subscription".to_string(),
+            url: request.url,
+            events,
+            tenant: request.tenant,
+            period: request.period,
+            thresholds,
+            description: request.description,
+            secret: None,
+            deliveries: DeliveryStats::default(),
+            created_at: now,
+        };
+
+        let mut entries = self.entries.lock().unwrap();
+        if entries.len() >= MAX_SUBSCRIPTIONS {
+            return Err(format!(
+                "At most {} webhook subscriptions may be registered",
+                MAX_SUBSCRIPTIONS
+            ));
+        }
+        entries.insert(
+            subscription.id.clone(),
+            Entry {
+                summary_due: subscription.period.bounds(now).1,
+                subscription: subscription.clone(),
+                secret: secret.clone(),
+                usage: HashMap::new(),
+            },
+        );
+        Ok(Subscription {
+            secret: Some(secret),
+            ..subscription
+        })
+    }
+
+    fn get(&self, id: &str) -> Option<Subscription> {
+        let entries = self.entries.lock().unwrap();
+        entries.get(id).map(|entry| entry.subscription.clone())
+    }
+
+    fn list(&self) -> Vec<Subscription> {
+        let entries = self.entries.lock().unwrap();
+        let mut subscriptions: Vec<Subscription> = entries
+            .values()
+            .map(|entry| entry.subscription.clone())
+            .collect();
+        subscriptions.sort_by(|a, b| a.created_at.cmp(&b.created_at));
+        subscriptions
+    }
+
+    fn remove(&self, id: &str) -> bool {
+        self.entries.lock().unwrap().remove(id).is_some()
+    }
+
+    /// Count a completed request's tokens, notifying subscriptions whose
+    /// thresholds it crosses
+    pub fn observe(&self, record: &UsageRecord) {
+        let tokens = record.prompt_tokens + record.completion_tokens;
+        let mut deliveries = Vec::new();
+        {
+            let mut entries = self.entries.lock().unwrap();
+            for entry in entries.values_mut() {
+                if entry.subscription.thresholds.is_empty()
+                    || !entry
+                        .subscription
+                        .wants(WebhookEvent::UsageThresholdCrossed, Some(&record.tenant))
+                {
+                    continue;
+                }
+                let (period_start, period_end) = entry.subscription.period.bounds(record.timestamp);
+                let usage = entry
+                    .usage
+                    .entry(record.tenant.clone())
+                    .or_insert(PeriodUsage {
+                        period_start,
+                        tokens: 0,
+                        crossed: 0,
+                    });
+                if usage.period_start != period_start {
+                    *usage = PeriodUsage {
+                        period_start,
+                        tokens: 0,
+                        crossed: 0,
+                    };
+                }
+                usage.tokens += tokens;
+
+                let thresholds = &entry.subscription.thresholds;
+                while usage.crossed < thresholds.len() && usage.tokens >= thresholds[usage.crossed]
+                {
+                    let threshold = thresholds[usage.crossed];
+                    usage.crossed += 1;
+                    deliveries.push(entry.delivery(
+                        WebhookEvent::UsageThresholdCrossed,
+                        json!({
+                            "tenant": record.tenant,
+                            "period": entry.subscription.period.as_str(),
+                            "period_start": period_start,
+                            "period_end": period_end,
+                            "threshold": threshold,
+                            "total_tokens": usage.tokens
+                        }),
+                    ));
+                }
+            }
+        }
+        self.deliver(deliveries);
+    }
+
+    /// Notify subscriptions that a budget has run out
+    pub fn budget_exhausted(&self, status: &BudgetStatus) {
+        let tenant = (status.subject == "tenant").then_some(status.id.as_str());
+        let deliveries = {
+            let entries = self.entries.lock().unwrap();
+            entries
+                .values()
+                // Key budgets go only to subscriptions for every tenant
+                .filter(|entry| {
+                    entry
+                        .subscription
+                        .wants(WebhookEvent::BudgetExhausted, tenant)
+                })
+                .map(|entry| {
+                    entry.delivery(WebhookEvent::BudgetExhausted, json!({ "budget": status }))
+                })
+                .collect()
+        };
+        self.deliver(deliveries);
+    }
+
+    /// Send the summaries of periods that have ended by `now`
+    fn send_summaries(&self, state: &ServerState, now: DateTime<Utc>) {
+        let mut deliveries = Vec::new();
+        {
+            let mut entries = self.entries.lock().unwrap();
+            for entry in entries.values_mut() {
+                if entry.summary_due > now {
+                    continue;
+                }
+                let period = entry.subscription.period;
+                let (period_start, period_end) =
+                    period.bounds(entry.summary_due - chrono::Duration::seconds(1));
+                entry.summary_due = period.bounds(now).1;
+                if !entry
+                    .subscription
+                    .events
+                    .contains(&WebhookEvent::PeriodSummary)
+                {
+                    continue;
+                }
+
+                let mut filters = HashMap::new();
+                if let Some(tenant) = &entry.subscription.tenant {
+                    filters.insert("tenant".to_string(), tenant.clone());
+                }
+                let Ok(query) = ParsedQuery::parse(UsageQuery {
+                    start: Some(period_start),
+                    end: Some(period_end),
+                    group_by: Some("tenant,model".to_string()),
+                    filters,
+                }) else {
+                    continue;
+                };
+                let groups = state.usage.summarize(&query);
+                deliveries.push(entry.delivery(
+                    WebhookEvent::PeriodSummary,
+                    summary(&entry.subscription, period_start, period_end, groups),
+                ));
+            }
+        }
+        self.deliver(deliveries);
+    }
+
+    /// Send `deliveries` in the background, retrying failures
+    fn deliver(&self, deliveries: Vec<Delivery>) {
+        for delivery in deliveries {
+            let client = self.client.clone();
+            let entries = Arc::clone(&self.entries);
+            tokio::spawn(async move {
+                let result = send(&client, &delivery).await;
+                if let Err(e) = &result {
+                    warn!(
+                        "Webhook {} ({}) failed: {}",
+                        delivery.subscription_id,
+                        delivery.event.as_str(),
+                        e
+                    );
+                }
+
+                let mut entries = entries.lock().unwrap();
+                let Some(entry) = entries.get_mut(&delivery.subscription_id) else {
+                    return;
+                };
+                let stats = &mut entry.subscription.deliveries;
+                stats.last_attempt_at = Some(Utc::now());
+                match result {
+                    Ok(()) => stats.succeeded += 1,
+                    Err(e) => {
+                        stats.failed += 1;
+                        stats.last_error = Some(e);
+                    }
+                }
+            });
+        }
+    }
+}
+
+impl Entry {
+    fn delivery(&self, event: WebhookEvent, data: Value) -> Delivery {
+        let body = json!({
+            "id": format!("evt_{}", Uuid::new_v4().simple()),
+            "object": "event",
+            "type": event.as_str(),
+            "created": Utc::now().timestamp(),
+            "subscription_id": self.subscription.id,
+            "data": data
+        });
+        Delivery {
+            subscription_id: self.subscription.id.clone(),
+            url: self.subscription.url.clone(),
+            secret: self.secret.clone(),
+            event,
+            body: body.to_string(),
+        }
+    }
+}
+
+/// The `data` of a `usage.period_summary` event
+fn summary(
+    subscription: &Subscription,
+    period_start: DateTime<Utc>,
+    period_end: DateTime<Utc>,
+    groups: Vec<UsageGroup>,
+) -> Value {
+    let mut totals = UsageGroup {
+        object: "usage.group".to_string(),
+        ..UsageGroup::default()
+    };
+    for group in &groups {
+        totals.requests += group.requests;
+        totals.prompt_tokens += group.prompt_tokens;
+        totals.completion_tokens += group.completion_tokens;
+        totals.total_tokens += group.total_tokens;
+    }
+    json!({
+        "tenant": subscription.tenant,
+        "period": subscription.period.as_str(),
+        "period_start": period_start,
+        "period_end": period_end,
+        "totals": totals,
+        "groups": groups
+    })
+}
+
+/// `X-Inferno-Webhook-Signature` for `body` sent at `timestamp`
+fn sign(secret: &str, timestamp: i64, body: &str) -> String {
+    let key = hmac::Key::new(hmac::HMAC_SHA256, secret.as_bytes());
+    let tag = hmac::sign(&key, format!("{}.{}", timestamp, body).as_bytes());
+    format!(
+        "timestamp={},signature={}",
+        timestamp,
+        hex::encode(tag.as_ref())
+    )
+}
+
+/// POST a delivery, retrying with backoff; each attempt is signed afresh
+async fn send(client: &reqwest::Client, delivery: &Delivery) -> Result<(), String> {
+    let mut delay = RETRY_DELAY;
+    let mut attempt = 1;
+    loop {
+        let result = client
+            .post(&delivery.url)
+            .header(reqwest::header::CONTENT_TYPE, "application/json")
+            .header(EVENT_HEADER, delivery.event.as_str())
+            .header(
+                SIGNATURE_HEADER,
+                sign(&delivery.secret, Utc::now().timestamp(), &delivery.body),
+            )
+            .body(delivery.body.clone())
+            .send()
+            .await
+            .and_then(|response| response.error_for_status());
+        match result {
+            Ok(_) => return Ok(()),
+            Err(e) if attempt >= DELIVERY_ATTEMPTS => return Err(e.to_string()),
+            Err(_) => {
+                tokio::time::sleep(delay).await;
+                delay *= 2;
+                attempt += 1;
+            }
+        }
+    }
+}
+
+/// Background loop sending period summaries once each period ends
+pub async fn run_summaries(state: Arc<ServerState>) {
+    loop {
+        tokio::time::sleep(SUMMARY_INTERVAL).await;
+        state.billing_webhooks.send_summaries(&state, Utc::now());
+    }
+}
+
+fn subscription_not_found(id: &str) -> Response {
+    error_response(
+        StatusCode::NOT_FOUND,
+        format!("Webhook subscription '{}' not found", id),
+        Some("webhook_id"),
+        Some("webhook_not_found"),
+    )
+}
+
+// API Handlers
+
+/// `GET /admin/webhooks` - every webhook subscription (admin only)
+pub async fn list_subscriptions(
+    State(state): State<Arc<ServerState>>,
+    headers: HeaderMap,
+) -> Response {
+    if let Err(response) = authorize_admin(&headers) {
+        return response;
+    }
+    Json(json!({ "object": "list", "data": state.billing_webhooks.list() })).into_response()
+}
+
+/// `POST /admin/webhooks` - subscribe a URL to billing events; the response
+/// carries the signing secret, shown only once (admin only)
+pub async fn create_subscription(
+    State(state): State<Arc<ServerState>>,
+    headers: HeaderMap,
+    Json(request): Json<CreateSubscription>,
+) -> Response {
+    if let Err(response) = authorize_admin(&headers) {
+        return response;
+    }
+    if let Err((message, param)) = request.validate() {
+        return error_response(
+            StatusCode::BAD_REQUEST,
+            message,
+            Some(param),
+            Some("invalid_webhook"),
+        );
+    }
+    match state.billing_webhooks.create(request) {
+        Ok(subscription) => {
+            info!(
+                "Webhook subscription {} created for {}",
+                subscription.id, subscription.url
+            );
+            (StatusCode::CREATED, Json(subscription)).into_response()
+        }
+        Err(message) => error_response(
+            StatusCode::CONFLICT,
+            message,
+            Some("url"),
+            Some("webhook_limit_reached"),
+        ),
+    }
+}
+
+/// `GET /admin/webhooks/:webhook_id` - a subscription and its delivery
+/// counts (admin only)
+pub async fn get_subscription(
+    State(state): State<Arc<ServerState>>,
+    Path(id): Path<String>,
+    headers: HeaderMap,
+) -> Response {
+    if let Err(response) = authorize_admin(&headers) {
+        return response;
+    }
+    match state.billing_webhooks.get(&id) {
+        Some(subscription) => Json(subscription).into_response(),
+        None => subscription_not_found(&id),
+    }
+}
+
+/// `DELETE /admin/webhooks/:webhook_id` - stop a subscription (admin only)
+pub async fn delete_subscription(
+    State(state): State<Arc<ServerState>>,
+    Path(id): Path<String>,
+    headers: HeaderMap,
+) -> Response {
+    if let Err(response) = authorize_admin(&headers) {
+        return response;
+    }
+    if state.billing_webhooks.remove(&id) {
+        info!("Webhook subscription {} deleted", id);
+        Json(json!({ "id": id, "object": "webhook_subscription", "deleted": true })).into_response()
+    } else {
+        subscription_not_found(&id)
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use std::collections::BTreeMap;
+
+    fn subscription(thresholds: Vec<u64>, tenant: Option<&str>) -> CreateSubscription {
+        CreateSubscription {
+            url: "http://hooks.local/billing".to_string(),
+            events: None,
+            tenant: tenant.map(str::to_string),
+            period: BudgetPeriod::Daily,
+            thresholds,
+            description: None,
+        }
+    }
+
+    fn record(tenant: &str, tokens: u64) -> UsageRecord {
+        UsageRecord {
+            timestamp: Utc::now(),
+            endpoint: "/v1/completions".to_string(),
+            model: "llama".to_string(),
+            tenant: tenant.to_string(),
+            key_id: None,
+            prompt_tokens: 0,
+            completion_tokens: tokens,
+            metadata: BTreeMap::new(),
+        }
+    }
+
+    fn crossed(store: &WebhookStore, id: &str, tenant: &str) -> usize {
+        let entries = store.entries.lock().unwrap();
+        entries[id]
+            .usage
+            .get(tenant)
+            .map_or(0, |usage| usage.crossed)
+    }
+
+    #[tokio::test]
+    async fn test_thresholds_cross_once() {
+        let store = WebhookStore::new();
+        let created = store
+            .create(subscription(vec![1000, 100, 100], Some("acme")))
+            .unwrap();
+        assert!(created.secret.unwrap().starts_with("whsec_"));
+        assert_eq!(created.thresholds, vec![100, 1000]);
+
+        store.observe(&record("acme", 60));
+        assert_eq!(crossed(&store, &created.id, "acme"), 0);
+        store.observe(&record("acme", 60));
+        assert_eq!(crossed(&store, &created.id, "acme"), 1);
+        store.observe(&record("acme", 2000));
+        assert_eq!(crossed(&store, &created.id, "acme"), 2);
+
+        // Other tenants are outside the subscription
+        store.observe(&record("globex", 5000));
+        assert_eq!(crossed(&store, &created.id, "globex"), 0);
+    }
+
+    #[test]
+    fn test_validate() {
+        assert!(subscription(vec![100], None).validate().is_ok());
+        assert_eq!(
+            subscription(vec![0], None).validate().unwrap_err().1,
+            "thresholds"
+        );
+        let mut bad_url = subscription(vec![], None);
+        bad_url.url = "ftp://hooks.local".to_string();
+        assert_eq!(bad_url.validate().unwrap_err().1, "url");
+    }
+
+    #[test]
+    fn test_signature() {
+        let header = sign("whsec_test", 1700000000, r#"{"type":"budget.exhausted"}"#);
+        let (timestamp, signature) = header.split_once(",signature=").unwrap();
+        assert_eq!(timestamp, "timestamp=1700000000");
+        let key = hmac::Key::new(hmac::HMAC_SHA256, b"whsec_test");
+        assert!(
+            hmac::verify(
+                &key,
+                br#"1700000000.{"type":"budget.exhausted"}"#,
+                &hex::decode(signature).unwrap()
+            )
+            .is_ok()
+        );
+    }
+}
@@ -0,0 +1,807 @@
+//! Spending Budgets
+//!
+//! Admins attach daily or monthly budgets to a tenant or a managed API key
+//! through `/admin/budgets/{tenants|keys}/{id}`. A budget counts tokens, or
+//! cost at the model's [pricing](crate::api::pricing), and has a soft limit,
+//! a hard limit or both. Usage is charged when a generation request is
+//! admitted, as the completion tokens it asks for (`max_tokens` times `n`),
+//! like the tenant token limits.
+//!
+//! Past the soft limit requests still run, but responses carry
+//! `X-Inferno-Budget-Warning` and the budget's webhook, if it has one, is
+//! called once per period. A request that would take usage past the hard
+//! limit is refused with 429 and code `budget_exceeded` until the period
+//! resets at midnight UTC, or on the first of the month; subscribers of
+//! `budget.exhausted` (see `api::billing_webhooks`) are told the first time.
+//! Requests are charged to the tenant of their API key or JWT, never one
+//! named only by a header. Callers read their own budgets at
+//! `GET /v1/budget`. Budgets and usage are
+//! held in memory.
+
+use crate::{
+    api::{
+        admin::authorize_admin,
+        audit_events::{self, AuditKind},
+        error_response,
+        queue::tenant_from_headers,
+        runtime_config::sampling_defaults,
+        scheduler::DEFAULT_TENANT,
+        tenants::model_from_path,
+    },
+    cli::serve::ServerState,
+};
+use axum::{
+    Json,
+    body::Body,
+    extract::{Path, Request, State},
+    http::{HeaderMap, HeaderValue, StatusCode, header},
+    middleware::Next,
+    response::{IntoResponse, Response},
+};
+use chrono::{DateTime, Datelike, Duration as ChronoDuration, NaiveDate, Utc};
+use serde::{Deserialize, Serialize};
+use serde_json::json;
+use std::{
+    collections::HashMap,
+    sync::{Arc, Mutex},
+    time::Duration,
+};
+use tracing::{info, warn};
+
+pub const WARNING_HEADER: &str = "x-inferno-budget-warning";
+
+/// Largest body the middleware buffers to read `model` and `max_tokens`,
+/// matching axum's default JSON body limit
+const MAX_INSPECTED_BODY: usize = 2 * 1024 * 1024;
+
+/// Budgets one tenant or key may have
+const MAX_BUDGETS: usize = 8;
+
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Hash, Serialize, Deserialize)]
+#[serde(rename_all = "snake_case")]
+pub enum BudgetPeriod {
+    Daily,
+    Monthly,
+}
+
+impl BudgetPeriod {
+    pub(crate) fn as_str(&self) -> &'static str {
+        match self {
+            BudgetPeriod::Daily => "daily",
+            BudgetPeriod::Monthly => "monthly",
+        }
+    }
+
+    /// The start of the period `now` falls in, and the start of the next
+    pub(crate) fn bounds(&self, now: DateTime<Utc>) -> (DateTime<Utc>, DateTime<Utc>) {
+        let today = now.date_naive();
+        let (start, end) = match self {
+            BudgetPeriod::Daily => (today, today + ChronoDuration::days(1)),
+            BudgetPeriod::Monthly => {
+                let start = today.with_day(1).unwrap_or(today);
+                let end = if start.month() == 12 {
+                    NaiveDate::from_ymd_opt(start.year() + 1, 1, 1)
+                } else {
+                    NaiveDate::from_ymd_opt(start.year(), start.month() + 1, 1)
+                };
+                (start, end.unwrap_or(start))
+            }
+        };
+        let midnight = |date: NaiveDate| date.and_hms_opt(0, 0, 0).unwrap_or_default().and_utc();
+        (midnight(start), midnight(end))
+    }
+}
+
+/// What a budget counts
+#[derive(Debug, Clone, Copy, Default, PartialEq, Eq, Serialize, Deserialize)]
+#[serde(rename_all = "snake_case")]
+pub enum BudgetUnit {
+    /// Completion tokens requested
+    #[default]
+    Tokens,
+    /// Completion tokens requested at the model's completion price; models
+    /// without a price cost nothing
+    Cost,
+}
+
+/// A budget as an admin sets it
+#[derive(Debug, Clone, PartialEq, Serialize, Deserialize)]
+pub struct Budget {
+    pub period: BudgetPeriod,
+    #[serde(default)]
+    pub unit: BudgetUnit,
+    /// Usage past which responses carry a warning and the webhook is called
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub soft_limit: Option<f64>,
+    /// Usage past which requests are refused
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub hard_limit: Option<f64>,
+    /// Called with a JSON event when the soft or hard limit is first reached
+    /// in a period
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub webhook_url: Option<String>,
+}
+
+impl Budget {
+    fn validate(&self) -> Result<(), (String, &'static str)> {
+        if self.soft_limit.is_none() && self.hard_limit.is_none() {
+            return Err((
+                "A budget needs a soft_limit, a hard_limit or both".to_string(),
+                "budgets",
+            ));
+        }
+        for (limit, param) in [
+            (self.soft_limit, "soft_limit"),
+            (self.hard_limit, "hard_limit"),
+        ] {
+            if let Some(limit) = limit
+                && !(limit.is_finite() && limit > 0.0)
+            {
+                return Err((format!("{} must be positive", param), param));
+            }
+        }
+        if let (Some(soft), Some(hard)) = (self.soft_limit, self.hard_limit)
+            && soft > hard
+        {
+            return Err((
+                "soft_limit may not be above hard_limit".to_string(),
+                "soft_limit",
+            ));
+        }
+        if let Some(url) = &self.webhook_url
+            && !(url.starts_with("http://") || url.starts_with("https://"))
+        {
+            return Err((
+                "webhook_url must be an http or https URL".to_string(),
+                "webhook_url",
+            ));
+        }
+        Ok(())
+    }
+}
+
+#[derive(Debug, Clone, Default, Deserialize)]
+pub struct SetBudgetsRequest {
+    /// At most one budget per period
+    pub budgets: Vec<Budget>,
+}
+
+/// Whether a budget is under, past its soft limit or at its hard limit
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Serialize, Deserialize)]
+#[serde(rename_all = "snake_case")]
+pub enum BudgetState {
+    Ok,
+    Warning,
+    Exceeded,
+}
+
+/// A budget and its usage this period
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct BudgetStatus {
+    pub object: String,
+    /// `tenant` or `key`
+    pub subject: String,
+    pub id: String,
+    #[serde(flatten)]
+    pub budget: Budget,
+    pub used: f64,
+    /// Until the hard limit, or the soft limit when there is no hard limit
+    pub remaining: f64,
+    pub state: BudgetState,
+    pub period_start: DateTime<Utc>,
+    pub resets_at: DateTime<Utc>,
+}
+
+/// Who a budget belongs to
+#[derive(Debug, Clone, PartialEq, Eq, Hash)]
+pub enum Subject {
+    Tenant(String),
+    Key(String),
+}
+
+impl Subject {
+    /// The subject named by `/admin/budgets/{kind}/{id}`
+    fn parse(kind: &str, id: String) -> Option<Self> {
+        match kind {
+            "tenants" => Some(Subject::Tenant(id)),
+            "keys" => Some(Subject::Key(id)),
+            _ => None,
+        }
+    }
+
+    fn kind(&self) -> &'static str {
+        match self {
+            Subject::Tenant(_) => "tenant",
+            Subject::Key(_) => "key",
+        }
+    }
+
+    fn id(&self) -> &str {
+        match self {
+            Subject::Tenant(id) | Subject::Key(id) => id,
+        }
+    }
+}
+
+#[derive(Debug, Clone)]
+struct Tracked {
+    budget: Budget,
+    period_start: DateTime<Utc>,
+    used: f64,
+    warned: bool,
+    exhausted: bool,
+}
+
+impl Tracked {
+    fn new(budget: Budget, now: DateTime<Utc>) -> Self {
+        Self {
+            period_start: budget.period.bounds(now).0,
+            budget,
+            used: 0.0,
+            warned: false,
+            exhausted: false,
+        }
+    }
+
+    /// Start a new period when `now` is past the current one
+    fn roll(&mut self, now: DateTime<Utc>) {
+        let start = self.budget.period.bounds(now).0;
+        if start != self.period_start {
+            self.period_start = start;
+            self.used = 0.0;
+            self.warned = false;
+            self.exhausted = false;
+        }
+    }
+
+    fn state(&self) -> BudgetState {
+        if self
+            .budget
+            .hard_limit
+            .is_some_and(|limit| self.used >= limit)
+        {
+            BudgetState::Exceeded
+        } else if self
+            .budget
+            .soft_limit
+            .is_some_and(|limit| self.used >= limit)
+        {
+            BudgetState::Warning
+        } else {
+            BudgetState::Ok
+        }
+    }
+
+    fn status(&self, subject: &Subject) -> BudgetStatus {
+        let limit = self.budget.hard_limit.or(self.budget.soft_limit);
+        BudgetStatus {
+            object: "budget".to_string(),
+            subject: subject.kind().to_string(),
+            id: subject.id().to_string(),
+            budget: self.budget.clone(),
+            used: self.used,
+            remaining: limit.map_or(0.0, |limit| (limit - self.used).max(0.0)),
+            state: self.state(),
+            period_start: self.period_start,
+            resets_at: self.budget.period.bounds(self.period_start).1,
+        }
+    }
+}
+
+/// A webhook to call for a limit reached
+#[derive(Debug, Clone)]
+struct Notice {
+    url: String,
+    event: &'static str,
+    status: BudgetStatus,
+}
+
+/// What charging a request did
+#[derive(Debug, Default)]
+struct Charge {
+    /// Budgets past their soft limit after the charge
+    warnings: Vec<BudgetStatus>,
+    notices: Vec<Notice>,
+    /// Budgets that reached their hard limit with the charge
+    exhausted: Vec<BudgetStatus>,
+}
+
+/// Budgets by tenant and key, and their usage
+#[derive(Debug, Default)]
+pub struct BudgetStore {
+    budgets: Mutex<HashMap<Subject, Vec<Tracked>>>,
+    client: reqwest::Client,
+}
+
+impl BudgetStore {
+    pub fn new() -> Self {
+        Self {
+            budgets: Mutex::new(HashMap::new()),
+            client: reqwest::Client::builder()
+                .user_agent("inferno/1.0")
+                .timeout(Duration::from_secs(10))
+                .build()
+                .unwrap_or_default(),
+        }
+    }
+
+    fn set(&self, subject: Subject, budgets: Vec<Budget>) {
+        let now = Utc::now();
+        let mut all = self.budgets.lock().unwrap();
+        let previous = all.remove(&subject).unwrap_or_default();
+        // Usage carries over when a period's budget is replaced
+        let tracked = budgets
+            .into_iter()
+            .map(|budget| {
+                let mut tracked = Tracked::new(budget, now);
+                if let Some(old) = previous
+                    .iter()
+                    .find(|old| old.budget.period == tracked.budget.period)
+                    && old.budget.unit == tracked.budget.unit
+                {
+                    tracked.used = old.used;
+                    tracked.period_start = old.period_start;
+                    tracked.roll(now);
+                }
+                tracked
+            })
+            .collect();
+        all.insert(subject, tracked);
+    }
+
+    fn remove(&self, subject: &Subject) -> bool {
+        self.budgets.lock().unwrap().remove(subject).is_some()
+    }
+
+    fn has_budgets(&self, subjects: &[Subject]) -> bool {
+        let all = self.budgets.lock().unwrap();
+        subjects.iter().any(|subject| all.contains_key(subject))
+    }
+
+    /// Statuses of `subjects`' budgets, or of every budget when `None`
+    fn statuses(&self, subjects: Option<&[Subject]>) -> Vec<BudgetStatus> {
+        let now = Utc::now();
+        let mut all = self.budgets.lock().unwrap();
+        let mut statuses: Vec<BudgetStatus> = all
+            .iter_mut()
+            .filter(|(subject, _)| subjects.is_none_or(|s| s.contains(subject)))
+            .flat_map(|(subject, tracked)| {
+                tracked.iter_mut().map(|tracked| {
+                    tracked.roll(now);
+                    tracked.status(subject)
+                })
+            })
+            .collect();
+        statuses.sort_by(|a, b| {
+            (a.subject.as_str(), a.id.as_str()).cmp(&(b.subject.as_str(), b.id.as_str()))
+        });
+        statuses
+    }
+
+    /// Charge `tokens` and `cost` to every budget of `subjects`, or refuse
+    /// without charging any when one would pass its hard limit, saying
+    /// whether it is the budget's first refusal this period
+    fn charge(
+        &self,
+        subjects: &[Subject],
+        tokens: f64,
+        cost: f64,
+        now: DateTime<Utc>,
+    ) -> Result<Charge, (BudgetStatus, bool)> {
+        let mut all = self.budgets.lock().unwrap();
+        let amount = |budget: &Budget| match budget.unit {
+            BudgetUnit::Tokens => tokens,
+            BudgetUnit::Cost => cost,
+        };
+
+        for subject in subjects {
+            for tracked in all.get_mut(subject).into_iter().flatten() {
+                tracked.roll(now);
+                if let Some(limit) = tracked.budget.hard_limit
+                    && tracked.used + amount(&tracked.budget) > limit
+                {
+                    let mut status = tracked.status(subject);
+                    status.state = BudgetState::Exceeded;
+                    let first = !tracked.exhausted;
+                    tracked.exhausted = true;
+                    return Err((status, first));
+                }
+            }
+        }
+
+        let mut charge = Charge::default();
+        for subject in subjects {
+            for tracked in all.get_mut(subject).into_iter().flatten() {
+                tracked.used += amount(&tracked.budget);
+                let state = tracked.state();
+                let status = tracked.status(subject);
+                let url = tracked.budget.webhook_url.clone();
+                if state != BudgetState::Ok {
+                    charge.warnings.push(status.clone());
+                }
+                if state != BudgetState::Ok && !tracked.warned {
+                    tracked.warned = true;
+                    if let Some(url) = url.clone() {
+                        charge.notices.push(Notice {
+                            url,
+                            event: "budget.soft_limit_reached",
+                            status: status.clone(),
+                        });
+                    }
+                }
+                if state == BudgetState::Exceeded && !tracked.exhausted {
+                    tracked.exhausted = true;
+                    charge.exhausted.push(status.clone());
+                    if let Some(url) = url {
+                        charge.notices.push(Notice {
+                            url,
+                            event: "budget.hard_limit_reached",
+                            status,
+                        });
+                    }
+                }
+            }
+        }
+        Ok(charge)
+    }
+
+    /// Call the webhooks of `notices` in the background
+    fn notify(&self, notices: Vec<Notice>) {
+        for notice in notices {
+            let client = self.client.clone();
+            tokio::spawn(async move {
+                let body = json!({ "type": notice.event, "budget": notice.status });
+                let result = client
+                    .post(&notice.url)
+                    .json(&body)
+                    .send()
+                    .await
+                    .and_then(|response| response.error_for_status());
+                if let Err(e) = result {
+                    warn!("Budget webhook {} failed: {}", notice.url, e);
+                }
+            });
+        }
+    }
+}
+
+/// The fields of a generation request a budget charges for
+#[derive(Debug, Default, Deserialize)]
+struct RequestSummary {
+    #[serde(default)]
+    model: Option<String>,
+    #[serde(default, alias = "max_completion_tokens")]
+    max_tokens: Option<u64>,
+    #[serde(default)]
+    n: Option<u64>,
+}
+
+impl RequestSummary {
+    fn requested_tokens(&self) -> u64 {
+        let max_tokens = self
+            .max_tokens
+            .unwrap_or_else(|| sampling_defaults().max_tokens as u64);
+        max_tokens * self.n.unwrap_or(1).max(1)
+    }
+}
+
+/// The tenant and managed key a request is charged to. The tenant is the
+/// managed key's own, or else the one `authenticate_tenant` took from the
+/// request's JWT and left in the header
+fn subjects(state: &ServerState, headers: &HeaderMap) -> Vec<Subject> {
+    let key = state.api_keys.scope(headers);
+    let tenant = key
+        .as_ref()
+        .and_then(|key| key.tenant.clone())
+        .or_else(|| tenant_from_headers(headers))
+        .unwrap_or_else(|| DEFAULT_TENANT.to_string());
+    let mut subjects = vec![Subject::Tenant(tenant)];
+    if let Some(key) = key {
+        subjects.push(Subject::Key(key.id));
+    }
+    subjects
+}
+
+/// `X-Inferno-Budget-Warning` for budgets past their soft limit
+fn warning_header(warnings: &[BudgetStatus]) -> Option<HeaderValue> {
+    let value = warnings
+        .iter()
+        .map(|status| {
+            format!(
+                "{}={}; period={}; used={}; limit={}",
+                status.subject,
+                status.id,
+                status.budget.period.as_str(),
+                status.used,
+                status
+                    .budget
+                    .hard_limit
+                    .or(status.budget.soft_limit)
+                    .unwrap_or_default()
+            )
+        })
+        .collect::<Vec<_>>()
+        .join(", ");
+    HeaderValue::from_str(&value).ok()
+}
+
+fn budget_exceeded(status: &BudgetStatus) -> Response {
+    let retry = (status.resets_at - Utc::now()).num_seconds().max(1);
+    let message = format!(
+        "The {} budget of {} '{}' is used up ({} of {}); it resets at {}",
+        status.budget.period.as_str(),
+        status.subject,
+        status.id,
+        status.used,
+        status.budget.hard_limit.unwrap_or_default(),
+        status.resets_at.to_rfc3339()
+    );
+    let mut response = (
+        StatusCode::TOO_MANY_REQUESTS,
+        Json(json!({
+            "error": {
+                "message": message,
+                "type": "insufficient_quota",
+                "param": null,
+                "code": "budget_exceeded",
+                "budget": status
+            }
+        })),
+    )
+        .into_response();
+    if let Ok(value) = HeaderValue::from_str(&retry.to_string()) {
+        response.headers_mut().insert(header::RETRY_AFTER, value);
+    }
+    audit_events::mark(
+        response,
+        AuditKind::PolicyViolation,
+        Some("budget_exceeded"),
+        &message,
+    )
+}
+
+/// Middleware charging generation requests to their tenant's and key's
+/// budgets, warning past soft limits and refusing past hard limits
+pub async fn enforce_budgets(
+    State(state): State<Arc<ServerState>>,
+    request: Request,
+    next: Next,
+) -> Response {
+    let subjects = subjects(&state, request.headers());
+    if !state.budgets.has_budgets(&subjects) {
+        return next.run(request).await;
+    }
+
+    // Buffer the body to see the model and token budget, then hand it on
+    let (parts, body) = request.into_parts();
+    let bytes = match axum::body::to_bytes(body, MAX_INSPECTED_BODY).await {
+        Ok(bytes) => bytes,
+        Err(_) => {
+            return error_response(
+                StatusCode::PAYLOAD_TOO_LARGE,
+                "Request body is too large".to_string(),
+                Some("body"),
+                Some("body_too_large"),
+            );
+        }
+    };
+    let summary: RequestSummary = serde_json::from_slice(&bytes).unwrap_or_default();
+    let model = summary
+        .model
+        .clone()
+        .or_else(|| model_from_path(parts.uri.path()));
+    let request = Request::from_parts(parts, Body::from(bytes));
+
+    let tokens = summary.requested_tokens() as f64;
+    let cost = model
+        .and_then(|model| state.pricing.get(&model))
+        .map_or(0.0, |pricing| {
+            tokens * pricing.rule.completion_per_million / 1_000_000.0
+        });
+    let charge = match state.budgets.charge(&subjects, tokens, cost, Utc::now()) {
+        Ok(charge) => charge,
+        Err((status, first)) => {
+            if first {
+                state.billing_webhooks.budget_exhausted(&status);
+            }
+            return budget_exceeded(&status);
+        }
+    };
+    state.budgets.notify(charge.notices);
+    for status in &charge.exhausted {
+        state.billing_webhooks.budget_exhausted(status);
+    }
+
+    let mut response = next.run(request).await;
+    if !charge.warnings.is_empty()
+        && let Some(value) = warning_header(&charge.warnings)
+    {
+        response.headers_mut().insert(WARNING_HEADER, value);
+    }
+    response
+}
+
+// API Handlers
+
+fn subject_not_found(kind: &str) -> Response {
+    error_response(
+        StatusCode::NOT_FOUND,
+        format!("Budgets are set on 'tenants' or 'keys', not '{}'", kind),
+        Some("subject"),
+        Some("invalid_budget_subject"),
+    )
+}
+
+/// `GET /admin/budgets` - every budget and its usage (admin only)
+pub async fn list_budgets(State(state): State<Arc<ServerState>>, headers: HeaderMap) -> Response {
+    if let Err(response) = authorize_admin(&headers) {
+        return response;
+    }
+    Json(json!({ "object": "list", "data": state.budgets.statuses(None) })).into_response()
+}
+
+/// `GET /admin/budgets/:kind/:id` - a tenant's or key's budgets (admin
+/// only)
+pub async fn get_budgets(
+    State(state): State<Arc<ServerState>>,
+    Path((kind, id)): Path<(String, String)>,
+    headers: HeaderMap,
+) -> Response {
+    if let Err(response) = authorize_admin(&headers) {
+        return response;
+    }
+    let Some(subject) = Subject::parse(&kind, id) else {
+        return subject_not_found(&kind);
+    };
+    let data = state.budgets.statuses(Some(&[subject]));
+    Json(json!({ "object": "list", "data": data })).into_response()
+}
+
+/// `PUT /admin/budgets/:kind/:id` - replace a tenant's or key's budgets,
+/// keeping this period's usage of budgets with the same period and unit
+/// (admin only)
+pub async fn put_budgets(
+    State(state): State<Arc<ServerState>>,
+    Path((kind, id)): Path<(String, String)>,
+    headers: HeaderMap,
+    Json(request): Json<SetBudgetsRequest>,
+) -> Response {
+    if let Err(response) = authorize_admin(&headers) {
+        return response;
+    }
+    let Some(subject) = Subject::parse(&kind, id.clone()) else {
+        return subject_not_found(&kind);
+    };
+    if request.budgets.len() > MAX_BUDGETS {
+        return error_response(
+            StatusCode::BAD_REQUEST,
+            format!("At most {} budgets may be set", MAX_BUDGETS),
+            Some("budgets"),
+            Some("invalid_budget"),
+        );
+    }
+    for (i, budget) in request.budgets.iter().enumerate() {
+        if let Err((message, param)) = budget.validate() {
+            return error_response(
+                StatusCode::BAD_REQUEST,
+                message,
+                Some(param),
+                Some("invalid_budget"),
+            );
+        }
+        if request.budgets[..i]
+            .iter()
+            .any(|other| other.period == budget.period)
+        {
+            return error_response(
+                StatusCode::BAD_REQUEST,
+                "Only one budget per period may be set".to_string(),
+                Some("period"),
+                Some("invalid_budget"),
+            );
+        }
+    }
+
+    info!(
+        "Budgets for {} {} set: {}",
+        subject.kind(),
+        id,
+        request.budgets.len()
+    );
+    state.budgets.set(subject, request.budgets);
+    get_budgets(State(state), Path((kind, id)), headers).await
+}
+
+/// `DELETE /admin/budgets/:kind/:id` - remove a tenant's or key's budgets
+/// (admin only)
+pub async fn delete_budgets(
+    State(state): State<Arc<ServerState>>,
+    Path((kind, id)): Path<(String, String)>,
+    headers: HeaderMap,
+) -> Response {
+    if let Err(response) = authorize_admin(&headers) {
+        return response;
+    }
+    let Some(subject) = Subject::parse(&kind, id.clone()) else {
+        return subject_not_found(&kind);
+    };
+    if state.budgets.remove(&subject) {
+        Json(json!({ "id": id, "object": "budget", "deleted": true })).into_response()
+    } else {
+        error_response(
+            StatusCode::NOT_FOUND,
+            format!("No budgets are set for {} '{}'", subject.kind(), id),
+            Some("id"),
+            Some("budget_not_found"),
+        )
+    }
+}
+
+/// `GET /v1/budget` - the budgets the request is charged to: its tenant's
+/// and, for a managed API key, the key's
+pub async fn current_budget(State(state): State<Arc<ServerState>>, headers: HeaderMap) -> Response {
+    let subjects = subjects(&state, &headers);
+    let data = state.budgets.statuses(Some(&subjects));
+    Json(json!({ "object": "list", "data": data })).into_response()
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn budget(soft: Option<f64>, hard: Option<f64>) -> Budget {
+        Budget {
+            period: BudgetPeriod::Daily,
+            unit: BudgetUnit::Tokens,
+            soft_limit: soft,
+            hard_limit: hard,
+            webhook_url: Some("http://hooks.local/budget".to_string()),
+        }
+    }
+
+    #[test]
+    fn test_soft_and_hard_limits() {
+        let store = BudgetStore::new();
+        let acme = Subject::Tenant("acme".to_string());
+        store.set(acme.clone(), vec![budget(Some(100.0), Some(150.0))]);
+        let now = Utc::now();
+
+        let charge = store.charge(&[acme.clone()], 90.0, 0.0, now).unwrap();
+        assert!(charge.warnings.is_empty());
+
+        let charge = store.charge(&[acme.clone()], 20.0, 0.0, now).unwrap();
+        assert_eq!(charge.warnings[0].state, BudgetState::Warning);
+        assert_eq!(charge.notices[0].event, "budget.soft_limit_reached");
+        let charge = store.charge(&[acme.clone()], 10.0, 0.0, now).unwrap();
+        assert!(charge.notices.is_empty());
+
+        let (refused, first) = store.charge(&[acme.clone()], 50.0, 0.0, now).unwrap_err();
+        assert_eq!(refused.state, BudgetState::Exceeded);
+        assert_eq!(refused.used, 120.0);
+        assert!(first);
+        assert!(!store.charge(&[acme.clone()], 50.0, 0.0, now).unwrap_err().1);
+
+        // A new day starts over
+        let tomorrow = now + ChronoDuration::days(1);
+        assert!(store.charge(&[acme], 50.0, 0.0, tomorrow).is_ok());
+    }
+
+    #[test]
+    fn test_period_bounds() {
+        let at = |s: &str| DateTime::parse_from_rfc3339(s).unwrap().with_timezone(&Utc);
+        let (start, end) = BudgetPeriod::Monthly.bounds(at("2026-12-15T10:00:00Z"));
+        assert_eq!(start, at("2026-12-01T00:00:00Z"));
+        assert_eq!(end, at("2027-01-01T00:00:00Z"));
+        let (start, end) = BudgetPeriod::Daily.bounds(at("2026-03-31T23:59:00Z"));
+        assert_eq!(start, at("2026-03-31T00:00:00Z"));
+        assert_eq!(end, at("2026-04-01T00:00:00Z"));
+    }
+
+    #[test]
+    fn test_validate() {
+        assert!(budget(None, None).validate().is_err());
+        assert!(budget(Some(200.0), Some(100.0)).validate().is_err());
+        assert!(budget(None, Some(-1.0)).validate().is_err());
+        assert!(budget(Some(50.0), Some(100.0)).validate().is_ok());
+    }
+}
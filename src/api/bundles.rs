@@ -0,0 +1,1017 @@
+//! Air-Gapped Bundles
+//!
+//! `POST /v1/export/bundle` packs selected models, LoRA adapters, prompt
+//! templates and (optionally) the server config into one tar archive that can
+//! be carried into an environment without network access, and
+//! `POST /v1/import/bundle` installs such an archive. Both directions stream:
+//! the export is written straight into the response and the import is
+//! unpacked as it arrives, so multi-gigabyte bundles never sit in memory.
+//!
+//! The archive holds `models/<file>`, `adapters/<file>`, `templates/<file>`,
+//! `config.toml` and, last, `bundle.json`, a manifest with each file's size,
+//! SHA-256 and registry tags. Detached signatures (`<file>.sig`) travel with
+//! their models. An import is checked against the manifest before anything is
+//! moved into place, so a truncated or altered bundle installs nothing.
+//!
+//! Templates are the files in `<models_dir>/templates`. The exported config
+//! has its secrets (the hub token and the JWT secret) removed, and an imported
+//! config is saved under `<cache_dir>/imports/` for review rather than applied.
+
+use crate::{
+    api::{admin::authorize_admin, error_response, internal_error, invalid_request},
+    cli::serve::ServerState,
+    config::Config,
+};
+use axum::{
+    Json,
+    body::{Body, Bytes},
+    extract::{Query, State},
+    http::{HeaderMap, HeaderValue, StatusCode, header},
+    response::{IntoResponse, Response},
+};
+use futures::StreamExt;
+use serde::{Deserialize, Serialize};
+use serde_json::json;
+use sha2::{Digest, Sha256};
+use std::{
+    collections::{HashMap, HashSet},
+    fs::File,
+    io::{self, Read, Write},
+    path::{Component, Path as FsPath, PathBuf},
+    sync::Arc,
+};
+use tokio::{fs, sync::mpsc};
+use tracing::{info, warn};
+use uuid::Uuid;
+
+/// Manifest format written by this version
+const BUNDLE_FORMAT: u32 = 1;
+
+/// Name of the manifest inside the archive
+const MANIFEST_NAME: &str = "bundle.json";
+
+/// Name of the config inside the archive
+const CONFIG_NAME: &str = "config.toml";
+
+/// Largest manifest accepted on import
+const MAX_METADATA_BYTES: u64 = 16 * 1024 * 1024;
+
+/// Bytes buffered before a chunk of the export is sent
+const CHUNK_BYTES: usize = 256 * 1024;
+
+/// Chunks in flight between the archive thread and the connection
+const CHANNEL_CHUNKS: usize = 16;
+
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Hash, Serialize, Deserialize)]
+#[serde(rename_all = "snake_case")]
+pub enum BundleItemKind {
+    Model,
+    Adapter,
+    Template,
+    Config,
+}
+
+impl BundleItemKind {
+    /// Directory of the archive the kind is stored in
+    fn directory(self) -> &'static str {
+        match self {
+            BundleItemKind::Model => "models",
+            BundleItemKind::Adapter => "adapters",
+            BundleItemKind::Template => "templates",
+            BundleItemKind::Config => "",
+        }
+    }
+
+    fn from_directory(directory: &str) -> Option<Self> {
+        match directory {
+            "models" => Some(BundleItemKind::Model),
+            "adapters" => Some(BundleItemKind::Adapter),
+            "templates" => Some(BundleItemKind::Template),
+            _ => None,
+        }
+    }
+}
+
+/// Body of `POST /v1/export/bundle`
+#[derive(Debug, Clone, Default, Serialize, Deserialize)]
+pub struct BundleRequest {
+    /// Model names or paths, as accepted in completion requests
+    #[serde(default)]
+    pub models: Vec<String>,
+    /// Fine-tuned adapter names (file names in the adapters directory, with
+    /// or without `.gguf`)
+    #[serde(default)]
+    pub adapters: Vec<String>,
+    /// File names in `<models_dir>/templates`
+    #[serde(default)]
+    pub templates: Vec<String>,
+    #[serde(default)]
+    pub include_config: bool,
+}
+
+/// One file described by the manifest
+#[derive(Debug, Clone, PartialEq, Eq, Serialize, Deserialize)]
+pub struct BundleItem {
+    pub kind: BundleItemKind,
+    pub name: String,
+    /// Location inside the archive
+    pub path: String,
+    pub size: u64,
+    pub sha256: String,
+    #[serde(default)]
+    pub tags: Vec<String>,
+    /// Archive path of the detached signature, if the model has one
+    #[serde(default)]
+    pub signature: Option<String>,
+}
+
+/// `bundle.json`
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct BundleManifest {
+    pub format: u32,
+    pub inferno_version: String,
+    pub created_at: chrono::DateTime<chrono::Utc>,
+    pub items: Vec<BundleItem>,
+}
+
+/// A file chosen for export
+#[derive(Debug, Clone)]
+struct ExportSource {
+    kind: BundleItemKind,
+    name: String,
+    path: PathBuf,
+    tags: Vec<String>,
+    signature: Option<PathBuf>,
+}
+
+impl ExportSource {
+    fn archive_path(&self) -> String {
+        format!("{}/{}", self.kind.directory(), self.name)
+    }
+}
+
+/// Query of `POST /v1/import/bundle`
+#[derive(Debug, Clone, Default, Deserialize)]
+pub struct ImportQuery {
+    /// Replace files that already exist instead of refusing the import
+    #[serde(default)]
+    pub overwrite: bool,
+}
+
+/// Why a bundle could not be exported or imported
+#[derive(Debug)]
+pub enum BundleError {
+    Invalid(String, &'static str),
+    /// Files the import would replace
+    Conflict(Vec<PathBuf>),
+    Io(io::Error),
+}
+
+impl From<io::Error> for BundleError {
+    fn from(e: io::Error) -> Self {
+        BundleError::Io(e)
+    }
+}
+
+impl BundleError {
+    fn into_response(self) -> Response {
+        match self {
+            BundleError::Invalid(message, param) => invalid_request(message, Some(param), None),
+            BundleError::Conflict(paths) => error_response(
+                StatusCode::CONFLICT,
+                format!(
+                    "the bundle would replace {}; retry with ?overwrite=true",
+                    paths
+                        .iter()
+                        .map(|path| path.display().to_string())
+                        .collect::<Vec<_>>()
+                        .join(", ")
+                ),
+                Some("overwrite"),
+                Some("bundle_conflict"),
+            ),
+            BundleError::Io(e) => internal_error(format!("bundle I/O failed: {}", e)),
+        }
+    }
+}
+
+/// Whether a name is a plain file name, safe to join onto a directory
+fn valid_file_name(name: &str) -> bool {
+    !name.is_empty()
+        && name.len() <= 255
+        && !name.starts_with('.')
+        && !name.contains(['/', '\\', '\0'])
+}
+
+/// Where templates live
+fn templates_dir(config: &Config) -> PathBuf {
+    config.models_dir.join("templates")
+}
+
+/// Where an imported item is installed
+fn install_path(config: &Config, kind: BundleItemKind, name: &str, bundle_id: &str) -> PathBuf {
+    match kind {
+        BundleItemKind::Model => config.models_dir.join(name),
+        BundleItemKind::Adapter => config
+            .fine_tuning
+            .adapters_dir(&config.models_dir)
+            .join(name),
+        BundleItemKind::Template => templates_dir(config).join(name),
+        BundleItemKind::Config => config
+            .cache_dir
+            .join("imports")
+            .join(bundle_id)
+            .join(CONFIG_NAME),
+    }
+}
+
+/// The config with its secrets removed
+fn redacted_config(config: &Config) -> Result<String, toml::ser::Error> {
+    let mut config = config.clone();
+    config.hub.token = None;
+    if let Some(auth) = config.auth_security.as_mut() {
+        auth.jwt_secret = String::new();
+    }
+    toml::to_string_pretty(&config)
+}
+
+/// Resolve the request to files on disk
+async fn export_sources(
+    state: &ServerState,
+    request: &BundleRequest,
+) -> Result<Vec<ExportSource>, BundleError> {
+    let registry = state
+        .model_manager
+        .load_registry()
+        .await
+        .unwrap_or_default();
+    let tags_of = |path: &FsPath| {
+        let canonical = path.canonicalize().unwrap_or_else(|_| path.to_path_buf());
+        registry
+            .entries
+            .get(&canonical.to_string_lossy().to_string())
+            .map(|entry| entry.tags.clone())
+            .unwrap_or_default()
+    };
+    let signature_of = |path: &FsPath| {
+        let signature = crate::models::verification::signature_path(path);
+        signature.is_file().then_some(signature)
+    };
+
+    let mut sources = Vec::new();
+    for model in &request.models {
+        let info = state
+            .model_manager
+            .resolve_model(model)
+            .await
+            .map_err(|_| BundleError::Invalid(format!("model {} not found", model), "models"))?;
+        sources.push(ExportSource {
+            kind: BundleItemKind::Model,
+            name: info.name,
+            tags: tags_of(&info.path),
+            signature: signature_of(&info.path),
+            path: info.path,
+        });
+    }
+
+    let adapters_dir = state
+        .config
+        .fine_tuning
+        .adapters_dir(&state.config.models_dir);
+    for adapter in &request.adapters {
+        let name = if adapter.ends_with(".gguf") {
+            adapter.clone()
+        } else {
+            format!("{}.gguf", adapter)
+        };
+        let path = adapters_dir.join(&name);
+        if !valid_file_name(&name) || !path.is_file() {
+            return Err(BundleError::Invalid(
+                format!(
+                    "adapter {} not found in {}",
+                    adapter,
+                    adapters_dir.display()
+                ),
+                "adapters",
+            ));
+        }
+        sources.push(ExportSource {
+            kind: BundleItemKind::Adapter,
+            name,
+            tags: tags_of(&path),
+            signature: signature_of(&path),
+            path,
+        });
+    }
+
+    let templates_dir = templates_dir(&state.config);
+    for template in &request.templates {
+        let path = templates_dir.join(template);
+        if !valid_file_name(template) || !path.is_file() {
+            return Err(BundleError::Invalid(
+                format!(
+                    "template {} not found in {}",
+                    template,
+                    templates_dir.display()
+                ),
+                "templates",
+            ));
+        }
+        sources.push(ExportSource {
+            kind: BundleItemKind::Template,
+            name: template.clone(),
+            path,
+            tags: Vec::new(),
+            signature: None,
+        });
+    }
+
+    let mut seen = HashSet::new();
+    if let Some(duplicate) = sources
+        .iter()
+        .find(|source| !seen.insert(source.archive_path()))
+    {
+        return Err(BundleError::Invalid(
+            format!("{} is selected twice", duplicate.archive_path()),
+            "models",
+        ));
+    }
+    Ok(sources)
+}
+
+/// A reader that hashes and counts what passes through it
+struct HashingReader<R> {
+    inner: R,
+    hasher: Sha256,
+    bytes: u64,
+}
+
+impl<R: Read> HashingReader<R> {
+    fn new(inner: R) -> Self {
+        Self {
+            inner,
+            hasher: Sha256::new(),
+            bytes: 0,
+        }
+    }
+
+    fn finish(self) -> (u64, String) {
+        (self.bytes, hex::encode(self.hasher.finalize()))
+    }
+}
+
+impl<R: Read> Read for HashingReader<R> {
+    fn read(&mut self, buf: &mut [u8]) -> io::Result<usize> {
+        let read = self.inner.read(buf)?;
+        self.hasher.update(&buf[..read]);
+        self.bytes += read as u64;
+        Ok(read)
+    }
+}
+
+fn file_header(size: u64) -> tar::Header {
+    let mut header = tar::Header::new_gnu();
+    header.set_entry_type(tar::EntryType::Regular);
+    header.set_size(size);
+    header.set_mode(0o644);
+    header.set_mtime(chrono::Utc::now().timestamp().max(0) as u64);
+    header
+}
+
+/// Append a file, returning its size and SHA-256
+fn append_file<W: Write>(
+    builder: &mut tar::Builder<W>,
+    archive_path: &str,
+    path: &FsPath,
+) -> io::Result<(u64, String)> {
+    let file = File::open(path)?;
+    let size = file.metadata()?.len();
+    let mut reader = HashingReader::new(file.take(size));
+    builder.append_data(&mut file_header(size), archive_path, &mut reader)?;
+
+    let (bytes, sha256) = reader.finish();
+    if bytes != size {
+        return Err(io::Error::other(format!(
+            "{} changed while it was being exported",
+            path.display()
+        )));
+    }
+    Ok((size, sha256))
+}
+
+fn append_bytes<W: Write>(
+    builder: &mut tar::Builder<W>,
+    archive_path: &str,
+    data: &[u8],
+) -> io::Result<()> {
+    builder.append_data(&mut file_header(data.len() as u64), archive_path, data)
+}
+
+/// Write the archive, manifest last so a truncated export cannot be imported
+fn write_bundle<W: Write>(
+    sources: &[ExportSource],
+    config: Option<&str>,
+    writer: W,
+) -> io::Result<BundleManifest> {
+    let mut builder = tar::Builder::new(writer);
+    let mut items = Vec::new();
+
+    for source in sources {
+        let archive_path = source.archive_path();
+        let (size, sha256) = append_file(&mut builder, &archive_path, &source.path)?;
+        let signature = match &source.signature {
+            Some(signature) => {
+                let signature_path = format!("{}.sig", archive_path);
+                append_file(&mut builder, &signature_path, signature)?;
+                Some(signature_path)
+            }
+            None => None,
+        };
+        items.push(BundleItem {
+            kind: source.kind,
+            name: source.name.clone(),
+            path: archive_path,
+            size,
+            sha256,
+            tags: source.tags.clone(),
+            signature,
+        });
+    }
+
+    if let Some(config) = config {
+        append_bytes(&mut builder, CONFIG_NAME, config.as_bytes())?;
+        items.push(BundleItem {
+            kind: BundleItemKind::Config,
+            name: CONFIG_NAME.to_string(),
+            path: CONFIG_NAME.to_string(),
+            size: config.len() as u64,
+            sha256: hex::encode(Sha256::digest(config.as_bytes())),
+            tags: Vec::new(),
+            signature: None,
+        });
+    }
+
+    let manifest = BundleManifest {
+        format: BUNDLE_FORMAT,
+        inferno_version: env!("CARGO_PKG_VERSION").to_string(),
+        created_at: chrono::Utc::now(),
+        items,
+    };
+    let data = serde_json::to_vec_pretty(&manifest).map_err(io::Error::other)?;
+    append_bytes(&mut builder, MANIFEST_NAME, &data)?;
+    builder.into_inner()?.flush()?;
+    Ok(manifest)
+}
+
+/// Feeds written bytes to the response body in chunks, from a blocking thread
+struct ChannelWriter {
+    sender: mpsc::Sender<io::Result<Bytes>>,
+    buffer: Vec<u8>,
+}
+
+impl ChannelWriter {
+    fn send_buffer(&mut self) -> io::Result<()> {
+        if self.buffer.is_empty() {
+            return Ok(());
+        }
+        let chunk = Bytes::from(std::mem::take(&mut self.buffer));
+        self.sender
+            .blocking_send(Ok(chunk))
+            .map_err(|_| io::Error::new(io::ErrorKind::BrokenPipe, "the client disconnected"))
+    }
+}
+
+impl Write for ChannelWriter {
+    fn write(&mut self, buf: &[u8]) -> io::Result<usize> {
+        self.buffer.extend_from_slice(buf);
+        if self.buffer.len() >= CHUNK_BYTES {
+            self.send_buffer()?;
+        }
+        Ok(buf.len())
+    }
+
+    fn flush(&mut self) -> io::Result<()> {
+        self.send_buffer()
+    }
+}
+
+/// Reads the request body in a blocking thread as it arrives
+struct ChannelReader {
+    receiver: mpsc::Receiver<Bytes>,
+    chunk: Bytes,
+}
+
+impl Read for ChannelReader {
+    fn read(&mut self, buf: &mut [u8]) -> io::Result<usize> {
+        while self.chunk.is_empty() {
+            match self.receiver.blocking_recv() {
+                Some(chunk) => self.chunk = chunk,
+                None => return Ok(0),
+            }
+        }
+        let read = buf.len().min(self.chunk.len());
+        buf[..read].copy_from_slice(&self.chunk.split_to(read));
+        Ok(read)
+    }
+}
+
+/// A file unpacked into the staging directory
+#[derive(Debug, Clone)]
+struct StagedFile {
+    path: PathBuf,
+    size: u64,
+    sha256: String,
+}
+
+/// Check an archive path against the bundle layout
+fn valid_archive_path(path: &FsPath) -> Option<String> {
+    let parts: Vec<&str> = path
+        .components()
+        .map(|component| match component {
+            Component::Normal(part) => part.to_str(),
+            _ => None,
+        })
+        .collect::<Option<_>>()?;
+    match parts.as_slice() {
+        [name] if *name == MANIFEST_NAME || *name == CONFIG_NAME => Some(name.to_string()),
+        [directory, name]
+            if BundleItemKind::from_directory(directory).is_some() && valid_file_name(name) =>
+        {
+            Some(format!("{}/{}", directory, name))
+        }
+        _ => None,
+    }
+}
+
+/// Unpack an archive into `staging`, hashing each file
+fn unpack_bundle<R: Read>(
+    reader: R,
+    staging: &FsPath,
+) -> Result<(BundleManifest, HashMap<String, StagedFile>), BundleError> {
+    let mut archive = tar::Archive::new(reader);
+    let mut files = HashMap::new();
+    let mut manifest = None;
+
+    for entry in archive.entries()? {
+        let mut entry = entry?;
+        let entry_type = entry.header().entry_type();
+        if entry_type.is_dir() {
+            continue;
+        }
+        let raw_path = entry.path()?.into_owned();
+        let Some(archive_path) = valid_archive_path(&raw_path).filter(|_| entry_type.is_file())
+        else {
+            return Err(BundleError::Invalid(
+                format!("unexpected entry {} in the bundle", raw_path.display()),
+                "body",
+            ));
+        };
+        if files.contains_key(&archive_path) {
+            return Err(BundleError::Invalid(
+                format!("{} appears twice in the bundle", archive_path),
+                "body",
+            ));
+        }
+
+        if archive_path == MANIFEST_NAME {
+            if entry.size() > MAX_METADATA_BYTES {
+                return Err(BundleError::Invalid(
+                    "the bundle manifest is too large".to_string(),
+                    "body",
+                ));
+            }
+            let mut data = Vec::new();
+            entry.read_to_end(&mut data)?;
+            manifest = Some(
+                serde_json::from_slice::<BundleManifest>(&data).map_err(|e| {
+                    BundleError::Invalid(format!("the bundle manifest is invalid: {}", e), "body")
+                })?,
+            );
+            continue;
+        }
+
+        let path = staging.join(&archive_path);
+        if let Some(parent) = path.parent() {
+            std::fs::create_dir_all(parent)?;
+        }
+        let mut reader = HashingReader::new(&mut entry);
+        io::copy(&mut reader, &mut File::create(&path)?)?;
+        let (size, sha256) = reader.finish();
+        files.insert(archive_path, StagedFile { path, size, sha256 });
+    }
+
+    let manifest = manifest.ok_or_else(|| {
+        BundleError::Invalid(
+            "the bundle has no manifest; it may be truncated".to_string(),
+            "body",
+        )
+    })?;
+    Ok((manifest, files))
+}
+
+/// Check the staged files against the manifest
+fn check_manifest(
+    manifest: &BundleManifest,
+    files: &HashMap<String, StagedFile>,
+) -> Result<(), BundleError> {
+    if manifest.format != BUNDLE_FORMAT {
+        return Err(BundleError::Invalid(
+            format!(
+                "bundle format {} is not supported (expected {})",
+                manifest.format, BUNDLE_FORMAT
+            ),
+            "body",
+        ));
+    }
+
+    let mut described = HashSet::new();
+    for item in &manifest.items {
+        let expected_path = match item.kind {
+            BundleItemKind::Config => CONFIG_NAME.to_string(),
+            kind => format!("{}/{}", kind.directory(), item.name),
+        };
+        if item.path != expected_path || valid_archive_path(FsPath::new(&item.path)).is_none() {
+            return Err(BundleError::Invalid(
+                format!("manifest entry {} has an unexpected path", item.path),
+                "body",
+            ));
+        }
+        let Some(file) = files.get(&item.path) else {
+            return Err(BundleError::Invalid(
+                format!("{} is missing from the bundle", item.path),
+                "body",
+            ));
+        };
+        if file.size != item.size || !file.sha256.eq_ignore_ascii_case(&item.sha256) {
+            return Err(BundleError::Invalid(
+                format!(
+                    "{} does not match the manifest (sha256 {}, expected {})",
+                    item.path, file.sha256, item.sha256
+                ),
+                "body",
+            ));
+        }
+        described.insert(item.path.as_str());
+
+        if let Some(signature) = &item.signature {
+            if *signature != format!("{}.sig", item.path) || !files.contains_key(signature) {
+                return Err(BundleError::Invalid(
+                    format!("the signature of {} is missing", item.path),
+                    "body",
+                ));
+            }
+            described.insert(signature.as_str());
+        }
+    }
+
+    if let Some(extra) = files.keys().find(|path| !described.contains(path.as_str())) {
+        return Err(BundleError::Invalid(
+            format!("{} is not described by the manifest", extra),
+            "body",
+        ));
+    }
+    Ok(())
+}
+
+/// Move a file into place, copying when it crosses filesystems
+async fn move_file(from: &FsPath, to: &FsPath) -> io::Result<()> {
+    if let Some(parent) = to.parent() {
+        fs::create_dir_all(parent).await?;
+    }
+    if fs::rename(from, to).await.is_err() {
+        fs::copy(from, to).await?;
+        fs::remove_file(from).await?;
+    }
+    Ok(())
+}
+
+/// An installed item, as reported by the import
+#[derive(Debug, Clone, Serialize)]
+pub struct ImportedItem {
+    pub kind: BundleItemKind,
+    pub name: String,
+    pub path: PathBuf,
+    pub size: u64,
+    pub sha256: String,
+}
+
+/// Stream a bundle into `staging` and install it
+async fn import_bundle(
+    state: &ServerState,
+    body: Body,
+    staging: &FsPath,
+    bundle_id: &str,
+    overwrite: bool,
+) -> Result<(BundleManifest, Vec<ImportedItem>), BundleError> {
+    fs::create_dir_all(staging).await?;
+
+    let (sender, receiver) = mpsc::channel(CHANNEL_CHUNKS);
+    let unpack_dir = staging.to_path_buf();
+    let unpacking = tokio::task::spawn_blocking(move || {
+        unpack_bundle(
+            ChannelReader {
+                receiver,
+                chunk: Bytes::new(),
+            },
+            &unpack_dir,
+        )
+    });
+
+    let mut stream = body.into_data_stream();
+    let mut interrupted = None;
+    while let Some(chunk) = stream.next().await {
+        match chunk {
+            // A closed channel means unpacking stopped; its error is reported
+            Ok(chunk) => {
+                if sender.send(chunk).await.is_err() {
+                    break;
+                }
+            }
+            Err(e) => {
+                interrupted = Some(e.to_string());
+                break;
+            }
+        }
+    }
+    drop(sender);
+
+    let unpacked = unpacking
+        .await
+        .map_err(|e| BundleError::Io(io::Error::other(e)))?;
+    if let Some(message) = interrupted {
+        return Err(BundleError::Invalid(
+            format!("upload interrupted: {}", message),
+            "body",
+        ));
+    }
+    let (manifest, files) = unpacked?;
+    check_manifest(&manifest, &files)?;
+
+    let config = &state.config;
+    let targets: Vec<(&BundleItem, PathBuf)> = manifest
+        .items
+        .iter()
+        .map(|item| (item, install_path(config, item.kind, &item.name, bundle_id)))
+        .collect();
+    let conflicts: Vec<PathBuf> = targets
+        .iter()
+        .filter(|(_, target)| target.exists())
+        .map(|(_, target)| target.clone())
+        .collect();
+    if !overwrite && !conflicts.is_empty() {
+        return Err(BundleError::Conflict(conflicts));
+    }
+
+    let manager = &state.model_manager;
+    let mut imported = Vec::new();
+    for (item, target) in targets {
+        move_file(&files[&item.path].path, &target).await?;
+        if let Some(signature) = &item.signature {
+            let signature_target = crate::models::verification::signature_path(&target);
+            move_file(&files[signature].path, &signature_target).await?;
+        }
+
+        if matches!(item.kind, BundleItemKind::Model | BundleItemKind::Adapter) {
+            let mut tags = item.tags.clone();
+            tags.push("imported".to_string());
+            let registered = async {
+                manager.record_digest(&target, &item.sha256).await?;
+                manager.tag_model(&target, &tags).await
+            }
+            .await;
+            if let Err(e) = registered {
+                warn!(
+                    "Imported {} but could not register it: {}",
+                    target.display(),
+                    e
+                );
+            }
+        }
+
+        imported.push(ImportedItem {
+            kind: item.kind,
+            name: item.name.clone(),
+            path: target,
+            size: item.size,
+            sha256: item.sha256.clone(),
+        });
+    }
+    Ok((manifest, imported))
+}
+
+// API Handlers
+
+/// `POST /v1/export/bundle` - stream a tar archive of the selected models,
+/// adapters, templates and config (admin only)
+pub async fn export_bundle(
+    State(state): State<Arc<ServerState>>,
+    headers: HeaderMap,
+    Json(request): Json<BundleRequest>,
+) -> Response {
+    if let Err(response) = authorize_admin(&headers) {
+        return response;
+    }
+
+    if request.models.is_empty()
+        && request.adapters.is_empty()
+        && request.templates.is_empty()
+        && !request.include_config
+    {
+        return invalid_request(
+            "select at least one model, adapter, template or the config".to_string(),
+            Some("models"),
+            None,
+        );
+    }
+    let sources = match export_sources(&state, &request).await {
+        Ok(sources) => sources,
+        Err(e) => return e.into_response(),
+    };
+    let config = if request.include_config {
+        match redacted_config(&state.config) {
+            Ok(config) => Some(config),
+            Err(e) => return internal_error(format!("cannot serialize the config: {}", e)),
+        }
+    } else {
+        None
+    };
+
+    let (sender, receiver) = mpsc::channel(CHANNEL_CHUNKS);
+    tokio::task::spawn_blocking(move || {
+        let mut writer = ChannelWriter {
+            sender: sender.clone(),
+            buffer: Vec::with_capacity(CHUNK_BYTES),
+        };
+        match write_bundle(&sources, config.as_deref(), &mut writer) {
+            Ok(manifest) => info!("Exported a bundle of {} items", manifest.items.len()),
+            Err(e) => {
+                warn!("Bundle export failed: {}", e);
+                // Fail the response so the client does not keep a partial archive
+                let _ = sender.blocking_send(Err(e));
+            }
+        }
+    });
+    let stream = futures::stream::unfold(receiver, |mut receiver| async move {
+        receiver.recv().await.map(|chunk| (chunk, receiver))
+    });
+
+    let file_name = format!(
+        "inferno-bundle-{}.tar",
+        chrono::Utc::now().format("%Y%m%dT%H%M%SZ")
+    );
+    let mut response = Body::from_stream(stream).into_response();
+    let response_headers = response.headers_mut();
+    response_headers.insert(
+        header::CONTENT_TYPE,
+        HeaderValue::from_static("application/x-tar"),
+    );
+    if let Ok(disposition) =
+        HeaderValue::from_str(&format!("attachment; filename=\"{}\"", file_name))
+    {
+        response_headers.insert(header::CONTENT_DISPOSITION, disposition);
+    }
+    response
+}
+
+/// `POST /v1/import/bundle` - unpack, verify and install a bundle (admin
+/// only)
+pub async fn import_bundle_handler(
+    State(state): State<Arc<ServerState>>,
+    Query(query): Query<ImportQuery>,
+    headers: HeaderMap,
+    body: Body,
+) -> Response {
+    if let Err(response) = authorize_admin(&headers) {
+        return response;
+    }
+
+    let bundle_id = format!("bundle-{}", Uuid::new_v4());
+    let staging = state
+        .config
+        .models_dir
+        .join(format!(".inferno_import-{}", bundle_id));
+    let result = import_bundle(&state, body, &staging, &bundle_id, query.overwrite).await;
+    if let Err(e) = fs::remove_dir_all(&staging).await {
+        if e.kind() != io::ErrorKind::NotFound {
+            warn!("Could not remove {}: {}", staging.display(), e);
+        }
+    }
+
+    match result {
+        Ok((manifest, items)) => {
+            info!(
+                "Imported bundle {} ({} items, exported by inferno {})",
+                bundle_id,
+                items.len(),
+                manifest.inferno_version
+            );
+            (
+                StatusCode::CREATED,
+                Json(json!({
+                    "object": "bundle.import",
+                    "id": bundle_id,
+                    "format": manifest.format,
+                    "inferno_version": manifest.inferno_version,
+                    "created_at": manifest.created_at,
+                    "items": items
+                })),
+            )
+                .into_response()
+        }
+        Err(e) => e.into_response(),
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn source(dir: &FsPath, kind: BundleItemKind, name: &str, data: &[u8]) -> ExportSource {
+        let path = dir.join(name);
+        std::fs::write(&path, data).unwrap();
+        ExportSource {
+            kind,
+            name: name.to_string(),
+            path,
+            tags: vec!["prod".to_string()],
+            signature: None,
+        }
+    }
+
+    #[test]
+    fn archive_paths_follow_the_layout() {
+        assert_eq!(
+            valid_archive_path(FsPath::new("models/llama.gguf")).as_deref(),
+            Some("models/llama.gguf")
+        );
+        assert_eq!(
+            valid_archive_path(FsPath::new("bundle.json")).as_deref(),
+            Some("bundle.json")
+        );
+        assert!(valid_archive_path(FsPath::new("../etc/passwd")).is_none());
+        assert!(valid_archive_path(FsPath::new("/models/llama.gguf")).is_none());
+        assert!(valid_archive_path(FsPath::new("models/sub/llama.gguf")).is_none());
+        assert!(valid_archive_path(FsPath::new("models/.hidden")).is_none());
+        assert!(valid_archive_path(FsPath::new("secrets/key")).is_none());
+    }
+
+    #[test]
+    fn round_trips_a_bundle() {
+        let source_dir = tempfile::tempdir().unwrap();
+        let sources = vec![
+            source(
+                source_dir.path(),
+                BundleItemKind::Model,
+                "tiny.gguf",
+                b"GGUF model",
+            ),
+            source(
+                source_dir.path(),
+                BundleItemKind::Template,
+                "chat.txt",
+                b"{{prompt}}",
+            ),
+        ];
+        let mut archive = Vec::new();
+        let written =
+            write_bundle(&sources, Some("models_dir = \"models\"\n"), &mut archive).unwrap();
+        assert_eq!(written.items.len(), 3);
+
+        let staging = tempfile::tempdir().unwrap();
+        let (manifest, files) = unpack_bundle(archive.as_slice(), staging.path()).unwrap();
+        check_manifest(&manifest, &files).unwrap();
+        assert_eq!(manifest.items, written.items);
+        assert_eq!(
+            std::fs::read(&files["models/tiny.gguf"].path).unwrap(),
+            b"GGUF model"
+        );
+    }
+
+    #[test]
+    fn rejects_altered_and_truncated_bundles() {
+        let source_dir = tempfile::tempdir().unwrap();
+        let sources = vec![source(
+            source_dir.path(),
+            BundleItemKind::Model,
+            "tiny.gguf",
+            b"GGUF model",
+        )];
+        let mut archive = Vec::new();
+        write_bundle(&sources, None, &mut archive).unwrap();
+
+        let staging = tempfile::tempdir().unwrap();
+        let (mut manifest, files) = unpack_bundle(archive.as_slice(), staging.path()).unwrap();
+        manifest.items[0].sha256 = "0".repeat(64);
+        assert!(matches!(
+            check_manifest(&manifest, &files),
+            Err(BundleError::Invalid(..))
+        ));
+
+        // Cutting the archive before the manifest leaves nothing to trust
+        let staging = tempfile::tempdir().unwrap();
+        assert!(matches!(
+            unpack_bundle(&archive[..1024], staging.path()),
+            Err(BundleError::Invalid(..))
+        ));
+    }
+}
@@ -0,0 +1,248 @@
+//! Server-side Cancellation of In-flight Inference
+//!
+//! Every tracked request carries a [`CancelSignal`]. Generation runs through
+//! the backend token stream and checks the signal between tokens; dropping the
+//! stream makes the backend stop decoding, so a cancelled request frees the
+//! GPU immediately. Client disconnects drop the handler future (and with it
+//! the stream), while `POST /v1/inference/:request_id/cancel` lets a client
+//! that is still connected abort a generation it no longer needs.
+
+use crate::{
+    api::deadline::expired,
+    backends::{BackendHandle, InferenceParams},
+    cli::serve::ServerState,
+};
+use axum::{
+    Json,
+    extract::{Path, State},
+    http::{HeaderMap, HeaderValue, StatusCode},
+    response::{IntoResponse, Response},
+};
+use futures::StreamExt;
+use serde_json::json;
+use std::sync::{
+    Arc,
+    atomic::{AtomicBool, Ordering},
+};
+use tokio::{sync::Notify, time::Instant};
+
+/// Header carrying the client-chosen request ID, echoed on responses
+pub const REQUEST_ID_HEADER: &str = "x-request-id";
+
+/// Longest client-supplied request ID the server accepts
+const MAX_REQUEST_ID_LEN: usize = 128;
+
+/// One-shot cancellation flag that async tasks can wait on
+#[derive(Debug, Default)]
+pub struct CancelSignal {
+    cancelled: AtomicBool,
+    notify: Notify,
+}
+
+impl CancelSignal {
+    pub fn new() -> Self {
+        Self::default()
+    }
+
+    /// Request cancellation and wake every waiter
+    pub fn cancel(&self) {
+        self.cancelled.store(true, Ordering::SeqCst);
+        self.notify.notify_waiters();
+    }
+
+    pub fn is_cancelled(&self) -> bool {
+        self.cancelled.load(Ordering::SeqCst)
+    }
+
+    /// Resolve once [`cancel`](Self::cancel) has been called
+    pub async fn cancelled(&self) {
+        loop {
+            // Register interest before checking the flag so a concurrent
+            // cancel cannot slip in between the check and the wait.
+            let notified = self.notify.notified();
+            if self.is_cancelled() {
+                return;
+            }
+            notified.await;
+        }
+    }
+}
+
+/// Why a generation stopped
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub enum FinishReason {
+    /// The backend finished on its own (EOS, stop sequence or token limit)
+    Stop,
+    /// The request was cancelled before the backend finished
+    Cancelled,
+    /// The request's deadline passed before the backend finished
+    Timeout,
+}
+
+impl FinishReason {
+    pub fn as_str(&self) -> &'static str {
+        match self {
+            FinishReason::Stop => "stop",
+            FinishReason::Cancelled => "cancelled",
+            FinishReason::Timeout => "timeout",
+        }
+    }
+}
+
+/// Text produced by a cancellable generation
+#[derive(Debug, Clone)]
+pub struct Generation {
+    pub text: String,
+    pub finish_reason: FinishReason,
+}
+
+/// Generate a complete response while honouring `cancel` and `deadline`.
+///
+/// Tokens are pulled from the backend stream one at a time; on cancellation
+/// or timeout the stream is dropped, which stops the backend, and the text
+/// produced so far is returned.
+pub async fn generate_cancellable(
+    backend: &BackendHandle,
+    prompt: &str,
+    params: &InferenceParams,
+    cancel: &CancelSignal,
+    deadline: Option<Instant>,
+) -> anyhow::Result<Generation> {
+    let interrupted = |text: String, finish_reason: FinishReason| Generation {
+        text,
+        finish_reason,
+    };
+
+    let mut stream = tokio::select! {
+        stream = backend.infer_stream(prompt, params) => stream?,
+        _ = cancel.cancelled() => return Ok(interrupted(String::new(), FinishReason::Cancelled)),
+        _ = expired(deadline) => return Ok(interrupted(String::new(), FinishReason::Timeout)),
+    };
+
+    let mut text = String::new();
+    loop {
+        tokio::select! {
+            token = stream.next() => match token {
+                Some(Ok(token)) => text.push_str(&token),
+                Some(Err(e)) => return Err(e.into()),
+                None => return Ok(interrupted(text, FinishReason::Stop)),
+            },
+            _ = cancel.cancelled() => return Ok(interrupted(text, FinishReason::Cancelled)),
+            _ = expired(deadline) => return Ok(interrupted(text, FinishReason::Timeout)),
+        }
+    }
+}
+
+/// Wait for the next token unless the request is cancelled or times out first.
+///
+/// Returns `Err` with the reason generation must stop, used by streaming
+/// handlers that forward tokens as they arrive.
+pub async fn next_token<S>(
+    stream: &mut S,
+    cancel: &CancelSignal,
+    deadline: Option<Instant>,
+) -> Result<Option<S::Item>, FinishReason>
+where
+    S: futures::Stream + Unpin,
+{
+    tokio::select! {
+        next = stream.next() => Ok(next),
+        _ = cancel.cancelled() => Err(FinishReason::Cancelled),
+        _ = expired(deadline) => Err(FinishReason::Timeout),
+    }
+}
+
+/// Take the request ID from the `X-Request-ID` header when it is usable,
+/// otherwise return `None` so the queue assigns one
+pub fn request_id_from_headers(headers: &HeaderMap) -> Option<String> {
+    let id = headers.get(REQUEST_ID_HEADER)?.to_str().ok()?.trim();
+    let valid = !id.is_empty()
+        && id.len() <= MAX_REQUEST_ID_LEN
+        && id
+            .chars()
+            .all(|c| c.is_ascii_alphanumeric() || matches!(c, '-' | '_' | '.'));
+
+    valid.then(|| id.to_string())
+}
+
+/// Attach the request ID to a response so clients can cancel by ID
+pub fn with_request_id(mut response: Response, request_id: &str) -> Response {
+    if let Ok(value) = HeaderValue::from_str(request_id) {
+        response.headers_mut().insert(REQUEST_ID_HEADER, value);
+    }
+    response
+}
+
+// API Handlers
+
+/// `POST /v1/inference/:request_id/cancel` - abort an in-flight generation.
+///
+/// Only the bearer token the request was made with, or the admin token, may
+/// cancel it; anyone else is told there is no such request.
+pub async fn cancel_inference(
+    State(state): State<Arc<ServerState>>,
+    Path(request_id): Path<String>,
+    headers: HeaderMap,
+) -> impl IntoResponse {
+    if state.request_queue.cancel_for(&request_id, &headers) {
+        Json(json!({
+            "request_id": request_id,
+            "cancelled": true
+        }))
+        .into_response()
+    } else {
+        (
+            StatusCode::NOT_FOUND,
+            Json(json!({
+                "error": {
+                    "message": format!("No in-flight request with id {}", request_id),
+                    "type": "invalid_request_error",
+                    "param": "request_id",
+                    "code": "request_not_found"
+                }
+            })),
+        )
+            .into_response()
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[tokio::test]
+    async fn test_cancel_signal_wakes_waiter() {
+        let signal = Arc::new(CancelSignal::new());
+        let waiter = {
+            let signal = Arc::clone(&signal);
+            tokio::spawn(async move { signal.cancelled().await })
+        };
+
+        assert!(!signal.is_cancelled());
+        signal.cancel();
+        waiter.await.unwrap();
+        assert!(signal.is_cancelled());
+    }
+
+    #[tokio::test]
+    async fn test_cancelled_returns_immediately_after_cancel() {
+        let signal = CancelSignal::new();
+        signal.cancel();
+        signal.cancelled().await;
+    }
+
+    #[test]
+    fn test_request_id_from_headers() {
+        let mut headers = HeaderMap::new();
+        assert_eq!(request_id_from_headers(&headers), None);
+
+        headers.insert(REQUEST_ID_HEADER, HeaderValue::from_static("req_123-abc"));
+        assert_eq!(
+            request_id_from_headers(&headers),
+            Some("req_123-abc".to_string())
+        );
+
+        headers.insert(REQUEST_ID_HEADER, HeaderValue::from_static("bad id/../"));
+        assert_eq!(request_id_from_headers(&headers), None);
+    }
+}
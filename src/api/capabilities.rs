@@ -0,0 +1,125 @@
+//! Capability Discovery
+//!
+//! `GET /capabilities` tells clients what this server can do before they
+//! send a request it would reject: server-wide features, and for every model
+//! the endpoints its backend implements. What a model can do follows from
+//! its format, since backends differ in which optional trait methods they
+//! implement (only GGUF exposes logits, hidden states and scoring, for
+//! example).
+
+use crate::{api::version::CURRENT_API_VERSION, cli::serve::ServerState};
+use axum::{
+    Json,
+    extract::State,
+    http::StatusCode,
+    response::{IntoResponse, Response},
+};
+use serde::Serialize;
+use serde_json::json;
+use std::{collections::BTreeMap, sync::Arc};
+
+/// Server-wide features and whether this build supports them
+pub const SERVER_FEATURES: &[(&str, bool)] = &[
+    // Server-sent events on chat and text completions
+    ("streaming", true),
+    // Function calling on chat completions
+    ("tools", true),
+    // Chat message content is text only
+    ("vision", false),
+    // No constrained decoding; structured output is validated and retried
+    ("grammars", false),
+    // OpenAI-style /v1/batches; async single requests are separate
+    ("batch", false),
+    ("vector_store", false),
+    ("async_inference", true),
+    ("sessions", true),
+];
+
+/// Per-model capabilities by model format
+fn model_capabilities(format: &str) -> &'static [&'static str] {
+    match format {
+        "gguf" => &[
+            "chat",
+            "completions",
+            "streaming",
+            "tools",
+            "embeddings",
+            "tokenize",
+            "logprobs",
+            "rerank",
+            "hidden_states",
+            "logits",
+        ],
+        "onnx" => &[
+            "chat",
+            "completions",
+            "streaming",
+            "tools",
+            "embeddings",
+            "tokenize",
+        ],
+        _ => &[],
+    }
+}
+
+#[derive(Debug, Serialize)]
+pub struct ModelCapabilities {
+    pub id: String,
+    pub format: String,
+    pub capabilities: Vec<&'static str>,
+}
+
+// API Handlers
+
+/// `GET /capabilities` - server features and what each model supports
+pub async fn get_capabilities(State(state): State<Arc<ServerState>>) -> Response {
+    let models = match state.model_manager.list_models().await {
+        Ok(models) => models,
+        Err(e) => {
+            return (
+                StatusCode::INTERNAL_SERVER_ERROR,
+                Json(json!({
+                    "error": {
+                        "message": format!("Failed to list models: {}", e),
+                        "type": "internal_error",
+                        "param": null,
+                        "code": null
+                    }
+                })),
+            )
+                .into_response();
+        }
+    };
+
+    let features: BTreeMap<&str, bool> = SERVER_FEATURES.iter().copied().collect();
+    let models: Vec<ModelCapabilities> = models
+        .into_iter()
+        .map(|model| ModelCapabilities {
+            capabilities: model_capabilities(&model.format).to_vec(),
+            id: model.name,
+            format: model.format,
+        })
+        .collect();
+
+    Json(json!({
+        "object": "capabilities",
+        "api_version": CURRENT_API_VERSION.to_string(),
+        "context_window": state.config.backend_config.context_size,
+        "features": features,
+        "models": models,
+    }))
+    .into_response()
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_model_capabilities_by_format() {
+        assert!(model_capabilities("gguf").contains(&"logits"));
+        assert!(model_capabilities("onnx").contains(&"embeddings"));
+        assert!(!model_capabilities("onnx").contains(&"hidden_states"));
+        assert!(model_capabilities("unknown").is_empty());
+    }
+}
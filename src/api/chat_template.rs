@@ -0,0 +1,135 @@
+//! Chat Template Rendering
+//!
+//! `POST /v1/models/:model_id/apply_template` renders a message list through
+//! the chat template embedded in the model (GGUF `tokenizer.chat_template`)
+//! and returns the prompt with its token count. The response also carries
+//! the prompt `/v1/chat/completions` builds for the same messages, which is
+//! usually what explains a chat reply differing from a raw completion of the
+//! templated prompt.
+
+use crate::{
+    api::{
+        invalid_request,
+        openai::{ChatMessage, format_chat_messages, get_or_load_backend},
+        tools,
+    },
+    cli::serve::ServerState,
+};
+use axum::{
+    Json,
+    extract::{Path, State},
+    response::{IntoResponse, Response},
+};
+use serde::{Deserialize, Serialize};
+use serde_json::json;
+use std::sync::Arc;
+
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct ApplyTemplateRequest {
+    pub messages: Vec<ChatMessage>,
+    /// End with the assistant header so the model answers next (default true)
+    #[serde(default = "default_add_generation_prompt")]
+    pub add_generation_prompt: bool,
+}
+
+fn default_add_generation_prompt() -> bool {
+    true
+}
+
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct ApplyTemplateResponse {
+    pub model: String,
+    /// The messages rendered through the model's chat template
+    pub prompt: String,
+    /// Tokens in `prompt` under the model's tokenizer, when it is exposed
+    pub tokens: Option<usize>,
+    /// Source of the template that was applied
+    pub template: String,
+    /// The prompt `/v1/chat/completions` generates from for these messages
+    pub chat_completions_prompt: String,
+}
+
+// API Handlers
+
+/// `POST /v1/models/:model_id/apply_template` - render messages through the
+/// model's chat template
+pub async fn apply_template(
+    State(state): State<Arc<ServerState>>,
+    Path(model_id): Path<String>,
+    Json(request): Json<ApplyTemplateRequest>,
+) -> Response {
+    if request.messages.is_empty() {
+        return invalid_request(
+            "messages must contain at least one message".to_string(),
+            Some("messages"),
+            None,
+        );
+    }
+
+    let backend = match get_or_load_backend(&state, &model_id).await {
+        Ok(backend) => backend,
+        Err(e) => {
+            return invalid_request(
+                format!("Failed to load model: {}", e),
+                Some("model_id"),
+                None,
+            );
+        }
+    };
+
+    // Render earlier tool calls into the text the same way chat completions do
+    let messages = tools::prompt_messages(&request.messages, &[], None);
+    let turns: Vec<(String, String)> = messages
+        .iter()
+        .map(|message| (message.role.clone(), message.content.clone()))
+        .collect();
+
+    let rendered = match backend
+        .apply_chat_template(&turns, request.add_generation_prompt)
+        .await
+    {
+        Ok(rendered) => rendered,
+        Err(e) => {
+            return invalid_request(
+                e.to_string(),
+                Some("model_id"),
+                Some("chat_template_unavailable"),
+            );
+        }
+    };
+    let tokens = backend
+        .tokenize(&rendered.prompt)
+        .await
+        .ok()
+        .map(|tokens| tokens.len());
+
+    Json(ApplyTemplateResponse {
+        model: model_id,
+        prompt: rendered.prompt,
+        tokens,
+        template: rendered.template,
+        chat_completions_prompt: format_chat_messages(&messages),
+    })
+    .into_response()
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_generation_prompt_defaults_on() {
+        let request: ApplyTemplateRequest = serde_json::from_value(json!({
+            "messages": [{ "role": "user", "content": "Hi" }]
+        }))
+        .unwrap();
+        assert!(request.add_generation_prompt);
+
+        let request: ApplyTemplateRequest = serde_json::from_value(json!({
+            "messages": [],
+            "add_generation_prompt": false
+        }))
+        .unwrap();
+        assert!(!request.add_generation_prompt);
+    }
+}
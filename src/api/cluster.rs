@@ -0,0 +1,615 @@
+//! Cluster Membership
+//!
+//! `/cluster/nodes` is the inventory multi-node tooling starts from: every
+//! node this server knows of, with its roles, loaded models, GPUs and
+//! health. The node answering is always listed first. Other nodes join by
+//! registering with the admin token and then report in with heartbeats; a
+//! node whose heartbeats stop is shown as `stale` and then `unreachable`,
+//! but stays listed until it leaves. Joins and leaves are recorded as
+//! events at `/cluster/events` for tooling that follows membership.
+//!
+//! Membership is held in memory by the node that receives the
+//! registrations, usually one coordinator; nodes do not gossip.
+
+use crate::{
+    api::{admin::authorize_admin, invalid_request, operations::ServerMode},
+    cli::serve::ServerState,
+    gpu::{GpuConfiguration, GpuManager, GpuVendor},
+};
+use axum::{
+    Json,
+    extract::{Path, Query, State},
+    http::{HeaderMap, StatusCode},
+    response::{IntoResponse, Response},
+};
+use chrono::{DateTime, Utc};
+use serde::{Deserialize, Serialize};
+use serde_json::json;
+use std::{
+    collections::{BTreeMap, VecDeque},
+    sync::{Arc, Mutex, RwLock},
+};
+use tokio::sync::OnceCell;
+use tracing::{info, warn};
+
+/// Environment variable naming this node; defaults to `HOSTNAME`
+pub const NODE_ID_ENV: &str = "INFERNO_NODE_ID";
+
+/// Environment variable with the address other nodes reach this one at
+pub const NODE_ADDRESS_ENV: &str = "INFERNO_NODE_ADDRESS";
+
+/// Environment variable with this node's comma-separated roles
+pub const NODE_ROLES_ENV: &str = "INFERNO_NODE_ROLES";
+
+/// Seconds without a heartbeat before a node is `stale`
+const STALE_AFTER_SECONDS: i64 = 30;
+
+/// Seconds without a heartbeat before a node is `unreachable`
+const UNREACHABLE_AFTER_SECONDS: i64 = 90;
+
+/// Membership events kept, oldest dropped first
+const MAX_EVENTS: usize = 500;
+
+#[derive(Debug, Clone, Copy, PartialEq, Eq, PartialOrd, Ord, Serialize, Deserialize)]
+#[serde(rename_all = "snake_case")]
+pub enum NodeRole {
+    /// Serves the HTTP API
+    Api,
+    /// Runs inference
+    Worker,
+    /// Keeps the membership other nodes register with
+    Coordinator,
+}
+
+impl NodeRole {
+    fn parse(role: &str) -> Option<Self> {
+        match role.trim() {
+            "api" => Some(Self::Api),
+            "worker" => Some(Self::Worker),
+            "coordinator" => Some(Self::Coordinator),
+            _ => None,
+        }
+    }
+}
+
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Serialize, Deserialize)]
+#[serde(rename_all = "snake_case")]
+pub enum NodeHealth {
+    Healthy,
+    /// Heartbeats are late
+    Stale,
+    /// Heartbeats have stopped
+    Unreachable,
+    Maintenance,
+    Draining,
+}
+
+/// One GPU in a node's inventory
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct NodeGpu {
+    pub index: u32,
+    pub name: String,
+    pub vendor: String,
+    pub memory_total_mb: u64,
+    pub memory_free_mb: u64,
+    pub utilization_percent: f32,
+}
+
+/// What a node reports when it joins and with each heartbeat
+#[derive(Debug, Clone, Default, Deserialize)]
+pub struct NodeReport {
+    pub address: Option<String>,
+    #[serde(default)]
+    pub roles: Vec<NodeRole>,
+    pub version: Option<String>,
+    #[serde(default)]
+    pub loaded_models: Vec<String>,
+    #[serde(default)]
+    pub gpus: Vec<NodeGpu>,
+    /// The node's own mode; `serving` if omitted
+    pub mode: Option<ServerMode>,
+}
+
+#[derive(Debug, Deserialize)]
+pub struct JoinRequest {
+    pub id: String,
+    #[serde(flatten)]
+    pub report: NodeReport,
+}
+
+#[derive(Debug, Clone, Serialize)]
+pub struct ClusterNode {
+    pub id: String,
+    pub address: Option<String>,
+    pub roles: Vec<NodeRole>,
+    pub version: Option<String>,
+    pub loaded_models: Vec<String>,
+    pub gpus: Vec<NodeGpu>,
+    pub health: NodeHealth,
+    /// Whether this is the node that answered
+    pub local: bool,
+    pub joined_at: DateTime<Utc>,
+    pub last_seen: DateTime<Utc>,
+}
+
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Serialize)]
+#[serde(rename_all = "snake_case")]
+pub enum ClusterEventType {
+    NodeJoined,
+    NodeLeft,
+}
+
+#[derive(Debug, Clone, Serialize)]
+pub struct ClusterEvent {
+    /// Increases by one per event; poll with `?after=` the last one seen
+    pub sequence: u64,
+    #[serde(rename = "type")]
+    pub event_type: ClusterEventType,
+    pub node_id: String,
+    pub at: DateTime<Utc>,
+    pub reason: Option<String>,
+}
+
+#[derive(Debug)]
+struct Member {
+    report: NodeReport,
+    joined_at: DateTime<Utc>,
+    last_seen: DateTime<Utc>,
+}
+
+#[derive(Debug, Default)]
+struct EventLog {
+    next_sequence: u64,
+    events: VecDeque<ClusterEvent>,
+}
+
+/// Nodes registered with this one, and the membership event log
+#[derive(Debug)]
+pub struct ClusterRegistry {
+    node_id: String,
+    address: Option<String>,
+    roles: Vec<NodeRole>,
+    started_at: DateTime<Utc>,
+    gpus: OnceCell<Vec<NodeGpu>>,
+    members: RwLock<BTreeMap<String, Member>>,
+    events: Mutex<EventLog>,
+}
+
+impl ClusterRegistry {
+    pub fn new() -> Self {
+        let node_id = [NODE_ID_ENV, "HOSTNAME"]
+            .iter()
+            .filter_map(|var| std::env::var(var).ok())
+            .find(|id| !id.is_empty())
+            .unwrap_or_else(|| "local".to_string());
+        let mut roles: Vec<NodeRole> = std::env::var(NODE_ROLES_ENV)
+            .map(|roles| roles.split(',').filter_map(NodeRole::parse).collect())
+            .unwrap_or_default();
+        if roles.is_empty() {
+            roles = vec![NodeRole::Api, NodeRole::Worker];
+        }
+        roles.sort();
+        roles.dedup();
+
+        Self {
+            node_id,
+            address: std::env::var(NODE_ADDRESS_ENV)
+                .ok()
+                .filter(|address| !address.is_empty()),
+            roles,
+            started_at: Utc::now(),
+            gpus: OnceCell::new(),
+            members: RwLock::new(BTreeMap::new()),
+            events: Mutex::new(EventLog::default()),
+        }
+    }
+
+    pub fn node_id(&self) -> &str {
+        &self.node_id
+    }
+
+    /// Register a node, or re-register one that restarted
+    pub fn join(&self, request: JoinRequest) -> Result<ClusterNode, String> {
+        let id = request.id.trim().to_string();
+        if id.is_empty() {
+            return Err("id must not be empty".to_string());
+        }
+        if id == self.node_id {
+            return Err(format!("'{}' is this node's own id", id));
+        }
+
+        let mut report = request.report;
+        if report.roles.is_empty() {
+            report.roles = vec![NodeRole::Worker];
+        }
+        let now = Utc::now();
+        let member = Member {
+            report,
+            joined_at: now,
+            last_seen: now,
+        };
+        let node = self.member_node(&id, &member, now);
+        self.members.write().unwrap().insert(id.clone(), member);
+        self.record(ClusterEventType::NodeJoined, id, None);
+        Ok(node)
+    }
+
+    /// Record a heartbeat; `None` if the node has not joined, in which case
+    /// it should join again
+    pub fn heartbeat(&self, id: &str, report: NodeReport) -> Option<ClusterNode> {
+        let mut members = self.members.write().unwrap();
+        let member = members.get_mut(id)?;
+        let now = Utc::now();
+
+        // Identity fields are kept unless the node reports new ones
+        if report.address.is_some() {
+            member.report.address = report.address;
+        }
+        if !report.roles.is_empty() {
+            member.report.roles = report.roles;
+        }
+        if report.version.is_some() {
+            member.report.version = report.version;
+        }
+        member.report.loaded_models = report.loaded_models;
+        member.report.gpus = report.gpus;
+        member.report.mode = report.mode;
+        member.last_seen = now;
+        Some(self.member_node(id, member, now))
+    }
+
+    /// Remove a node; `false` if it had not joined
+    pub fn leave(&self, id: &str, reason: Option<String>) -> bool {
+        if self.members.write().unwrap().remove(id).is_none() {
+            return false;
+        }
+        self.record(ClusterEventType::NodeLeft, id.to_string(), reason);
+        true
+    }
+
+    /// Events after sequence number `after`, oldest first
+    pub fn events(&self, after: Option<u64>) -> Vec<ClusterEvent> {
+        self.events
+            .lock()
+            .unwrap()
+            .events
+            .iter()
+            .filter(|event| after.is_none_or(|after| event.sequence > after))
+            .cloned()
+            .collect()
+    }
+
+    /// Every node, this one first
+    pub async fn nodes(&self, state: &ServerState) -> Vec<ClusterNode> {
+        let mut nodes = vec![self.local_node(state).await];
+        let now = Utc::now();
+        nodes.extend(
+            self.members
+                .read()
+                .unwrap()
+                .iter()
+                .map(|(id, member)| self.member_node(id, member, now)),
+        );
+        nodes
+    }
+
+    pub async fn node(&self, state: &ServerState, id: &str) -> Option<ClusterNode> {
+        if id == self.node_id {
+            return Some(self.local_node(state).await);
+        }
+        let members = self.members.read().unwrap();
+        members
+            .get(id)
+            .map(|member| self.member_node(id, member, Utc::now()))
+    }
+
+    async fn local_node(&self, state: &ServerState) -> ClusterNode {
+        let mut loaded_models: Vec<String> = state.loaded_model.iter().cloned().collect();
+        if let Some(distributed) = &state.distributed {
+            for stats in distributed.get_stats().await.into_values() {
+                loaded_models.extend(stats.loaded_models);
+            }
+        }
+        loaded_models.sort();
+        loaded_models.dedup();
+
+        let now = Utc::now();
+        ClusterNode {
+            id: self.node_id.clone(),
+            address: self.address.clone(),
+            roles: self.roles.clone(),
+            version: Some(env!("CARGO_PKG_VERSION").to_string()),
+            loaded_models,
+            gpus: self.local_gpus().await,
+            health: mode_health(state.operations.mode()),
+            local: true,
+            joined_at: self.started_at,
+            last_seen: now,
+        }
+    }
+
+    /// This node's GPUs, detected on first use
+    async fn local_gpus(&self) -> Vec<NodeGpu> {
+        self.gpus
+            .get_or_init(|| async {
+                // Detection only; the inventory does not need monitoring
+                let manager = GpuManager::new(GpuConfiguration {
+                    enabled: false,
+                    ..Default::default()
+                });
+                if let Err(e) = manager.refresh_gpu_info().await {
+                    warn!("GPU detection failed: {}", e);
+                }
+                manager
+                    .list_gpus()
+                    .await
+                    .into_iter()
+                    .map(|gpu| NodeGpu {
+                        index: gpu.id,
+                        name: gpu.name,
+                        vendor: vendor_name(&gpu.vendor),
+                        memory_total_mb: gpu.memory_total_mb,
+                        memory_free_mb: gpu.memory_free_mb,
+                        utilization_percent: gpu.utilization_percent,
+                    })
+                    .collect()
+            })
+            .await
+            .clone()
+    }
+
+    fn member_node(&self, id: &str, member: &Member, now: DateTime<Utc>) -> ClusterNode {
+        ClusterNode {
+            id: id.to_string(),
+            address: member.report.address.clone(),
+            roles: member.report.roles.clone(),
+            version: member.report.version.clone(),
+            loaded_models: member.report.loaded_models.clone(),
+            gpus: member.report.gpus.clone(),
+            health: member_health(member, now),
+            local: false,
+            joined_at: member.joined_at,
+            last_seen: member.last_seen,
+        }
+    }
+
+    fn record(&self, event_type: ClusterEventType, node_id: String, reason: Option<String>) {
+        info!("Cluster node {}: {:?}", node_id, event_type);
+        let mut log = self.events.lock().unwrap();
+        log.next_sequence += 1;
+        let event = ClusterEvent {
+            sequence: log.next_sequence,
+            event_type,
+            node_id,
+            at: Utc::now(),
+            reason,
+        };
+        log.events.push_back(event);
+        if log.events.len() > MAX_EVENTS {
+            log.events.pop_front();
+        }
+    }
+}
+
+impl Default for ClusterRegistry {
+    fn default() -> Self {
+        Self::new()
+    }
+}
+
+fn mode_health(mode: ServerMode) -> NodeHealth {
+    match mode {
+        ServerMode::Serving => NodeHealth::Healthy,
+        ServerMode::Maintenance => NodeHealth::Maintenance,
+        ServerMode::Draining => NodeHealth::Draining,
+    }
+}
+
+/// A registered node's health: missed heartbeats first, then its own mode
+fn member_health(member: &Member, now: DateTime<Utc>) -> NodeHealth {
+    let silent = (now - member.last_seen).num_seconds();
+    if silent >= UNREACHABLE_AFTER_SECONDS {
+        NodeHealth::Unreachable
+    } else if silent >= STALE_AFTER_SECONDS {
+        NodeHealth::Stale
+    } else {
+        mode_health(member.report.mode.unwrap_or(ServerMode::Serving))
+    }
+}
+
+pub(crate) fn vendor_name(vendor: &GpuVendor) -> String {
+    match vendor {
+        GpuVendor::Nvidia => "nvidia".to_string(),
+        GpuVendor::Amd => "amd".to_string(),
+        GpuVendor::Intel => "intel".to_string(),
+        GpuVendor::Apple => "apple".to_string(),
+        GpuVendor::Unknown(name) => name.to_lowercase(),
+    }
+}
+
+fn node_not_found(id: &str) -> Response {
+    (
+        StatusCode::NOT_FOUND,
+        Json(json!({
+            "error": {
+                "message": format!("No cluster node '{}'", id),
+                "type": "invalid_request_error",
+                "param": "node_id",
+                "code": "node_not_found"
+            }
+        })),
+    )
+        .into_response()
+}
+
+/// Query for `GET /cluster/events`
+#[derive(Debug, Deserialize)]
+pub struct EventsQuery {
+    pub after: Option<u64>,
+}
+
+/// Query for `DELETE /cluster/nodes/:node_id`
+#[derive(Debug, Deserialize)]
+pub struct LeaveQuery {
+    pub reason: Option<String>,
+}
+
+// API Handlers
+
+/// `GET /cluster/nodes` - every known node, this one first
+pub async fn list_nodes(State(state): State<Arc<ServerState>>) -> Response {
+    Json(json!({
+        "object": "list",
+        "node_id": state.cluster.node_id(),
+        "data": state.cluster.nodes(&state).await,
+    }))
+    .into_response()
+}
+
+/// `GET /cluster/nodes/:node_id` - one node
+pub async fn get_node(
+    State(state): State<Arc<ServerState>>,
+    Path(node_id): Path<String>,
+) -> Response {
+    match state.cluster.node(&state, &node_id).await {
+        Some(node) => Json(node).into_response(),
+        None => node_not_found(&node_id),
+    }
+}
+
+/// `POST /cluster/nodes` - register a node (admin only)
+pub async fn join_node(
+    State(state): State<Arc<ServerState>>,
+    headers: HeaderMap,
+    Json(request): Json<JoinRequest>,
+) -> Response {
+    if let Err(response) = authorize_admin(&headers) {
+        return response;
+    }
+
+    match state.cluster.join(request) {
+        Ok(node) => (StatusCode::CREATED, Json(node)).into_response(),
+        Err(message) => invalid_request(message, Some("id"), None),
+    }
+}
+
+/// `POST /cluster/nodes/:node_id/heartbeat` - a node's current state (admin
+/// only)
+pub async fn node_heartbeat(
+    State(state): State<Arc<ServerState>>,
+    Path(node_id): Path<String>,
+    headers: HeaderMap,
+    Json(report): Json<NodeReport>,
+) -> Response {
+    if let Err(response) = authorize_admin(&headers) {
+        return response;
+    }
+
+    match state.cluster.heartbeat(&node_id, report) {
+        Some(node) => Json(node).into_response(),
+        None => node_not_found(&node_id),
+    }
+}
+
+/// `DELETE /cluster/nodes/:node_id` - deregister a node (admin only)
+pub async fn leave_node(
+    State(state): State<Arc<ServerState>>,
+    Path(node_id): Path<String>,
+    Query(query): Query<LeaveQuery>,
+    headers: HeaderMap,
+) -> Response {
+    if let Err(response) = authorize_admin(&headers) {
+        return response;
+    }
+
+    if state.cluster.leave(&node_id, query.reason) {
+        Json(json!({ "id": node_id, "object": "cluster.node", "deleted": true })).into_response()
+    } else {
+        node_not_found(&node_id)
+    }
+}
+
+/// `GET /cluster/events` - joins and leaves, oldest first
+pub async fn list_events(
+    State(state): State<Arc<ServerState>>,
+    Query(query): Query<EventsQuery>,
+) -> Response {
+    Json(json!({
+        "object": "list",
+        "data": state.cluster.events(query.after),
+    }))
+    .into_response()
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn registry() -> ClusterRegistry {
+        ClusterRegistry {
+            node_id: "coordinator".to_string(),
+            address: None,
+            roles: vec![NodeRole::Coordinator],
+            started_at: Utc::now(),
+            gpus: OnceCell::new(),
+            members: RwLock::new(BTreeMap::new()),
+            events: Mutex::new(EventLog::default()),
+        }
+    }
+
+    fn join(id: &str) -> JoinRequest {
+        JoinRequest {
+            id: id.to_string(),
+            report: NodeReport::default(),
+        }
+    }
+
+    #[test]
+    fn test_join_heartbeat_leave_events() {
+        let cluster = registry();
+        assert!(cluster.join(join("coordinator")).is_err());
+
+        let node = cluster.join(join("gpu-1")).unwrap();
+        assert_eq!(node.roles, vec![NodeRole::Worker]);
+        assert_eq!(node.health, NodeHealth::Healthy);
+
+        let report = NodeReport {
+            loaded_models: vec!["llama-2-7b".to_string()],
+            mode: Some(ServerMode::Draining),
+            ..Default::default()
+        };
+        let node = cluster.heartbeat("gpu-1", report).unwrap();
+        assert_eq!(node.loaded_models, vec!["llama-2-7b"]);
+        assert_eq!(node.roles, vec![NodeRole::Worker]);
+        assert_eq!(node.health, NodeHealth::Draining);
+        assert!(cluster.heartbeat("gpu-2", NodeReport::default()).is_none());
+
+        assert!(cluster.leave("gpu-1", Some("scale down".to_string())));
+        assert!(!cluster.leave("gpu-1", None));
+
+        let events = cluster.events(None);
+        assert_eq!(events.len(), 2);
+        assert_eq!(events[1].event_type, ClusterEventType::NodeLeft);
+        assert_eq!(cluster.events(Some(events[0].sequence)).len(), 1);
+    }
+
+    #[test]
+    fn test_missed_heartbeats_override_mode() {
+        let now = Utc::now();
+        let mut member = Member {
+            report: NodeReport {
+                mode: Some(ServerMode::Maintenance),
+                ..Default::default()
+            },
+            joined_at: now,
+            last_seen: now,
+        };
+        assert_eq!(member_health(&member, now), NodeHealth::Maintenance);
+
+        member.last_seen = now - chrono::Duration::seconds(STALE_AFTER_SECONDS);
+        assert_eq!(member_health(&member, now), NodeHealth::Stale);
+
+        member.last_seen = now - chrono::Duration::seconds(UNREACHABLE_AFTER_SECONDS);
+        assert_eq!(member_health(&member, now), NodeHealth::Unreachable);
+    }
+}
@@ -0,0 +1,205 @@
+//! Synchronous Completion Batches
+//!
+//! `POST /v1/completions/batch` runs several independent completions of one
+//! model in a single request and answers once all have finished, for clients
+//! that coalesce many small calls. Unlike `/batch`, which queues a job and
+//! delivers results later, the results come back in the response, in order,
+//! each either a completion or the error that item alone failed with.
+//!
+//! Items run through the completions handler at the same time, so they share
+//! the scheduler and dynamic batcher with every other request. The batch is
+//! one request to the concurrency and tenant limits; its `max_tokens` is the
+//! total budgets are charged for, which the items' own `max_tokens` (times
+//! `n`) must fit in, and its usage is the sum of the items'.
+
+use crate::{
+    api::{
+        invalid_request,
+        openai::{self, CompletionRequest},
+    },
+    cli::serve::ServerState,
+};
+use axum::{
+    Json,
+    extract::State,
+    http::{HeaderMap, StatusCode},
+    response::{IntoResponse, Response},
+};
+use futures::future::join_all;
+use serde::{Deserialize, Serialize};
+use serde_json::{Value, json};
+use std::{collections::BTreeMap, sync::Arc};
+
+/// Most completions one batch may carry
+const MAX_BATCH_ITEMS: usize = 64;
+
+/// Largest item response read back from the completions handler
+const MAX_ITEM_BODY: usize = 16 * 1024 * 1024;
+
+/// Usage fields summed over the items
+const USAGE_FIELDS: [&str; 3] = ["prompt_tokens", "completion_tokens", "total_tokens"];
+
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct CompletionBatchRequest {
+    /// Model every item runs on; items' own `model` is ignored
+    pub model: String,
+    /// Total completion tokens the items may generate
+    pub max_tokens: u64,
+    pub requests: Vec<CompletionRequest>,
+    /// Tags the batch's usage is attributed by; items' own are ignored
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub metadata: Option<BTreeMap<String, String>>,
+}
+
+impl CompletionBatchRequest {
+    /// Checks the batch before any item runs, returning the message and
+    /// offending field of the first problem
+    fn validate(&self) -> Result<(), (String, &'static str)> {
+        if self.requests.is_empty() {
+            return Err(("requests must not be empty".to_string(), "requests"));
+        }
+        if self.requests.len() > MAX_BATCH_ITEMS {
+            return Err((
+                format!("At most {} requests may be batched", MAX_BATCH_ITEMS),
+                "requests",
+            ));
+        }
+        if let Some(i) = self.requests.iter().position(|item| item.stream) {
+            return Err((
+                format!("requests[{}] streams; batched completions cannot", i),
+                "stream",
+            ));
+        }
+        let requested: u64 = self
+            .requests
+            .iter()
+            .map(|item| item.max_tokens as u64 * item.n.unwrap_or(1).max(1) as u64)
+            .sum();
+        if requested > self.max_tokens {
+            return Err((
+                format!(
+                    "The requests ask for {} tokens, more than max_tokens ({})",
+                    requested, self.max_tokens
+                ),
+                "max_tokens",
+            ));
+        }
+        Ok(())
+    }
+}
+
+/// Runs one item, returning its entry in the batch response and its usage
+async fn run_item(
+    state: Arc<ServerState>,
+    headers: HeaderMap,
+    index: usize,
+    request: CompletionRequest,
+) -> (Value, Option<Value>) {
+    let response = openai::completions(State(state), headers, Json(request))
+        .await
+        .into_response();
+    let status = response.status();
+    let body = match axum::body::to_bytes(response.into_body(), MAX_ITEM_BODY).await {
+        Ok(bytes) => serde_json::from_slice::<Value>(&bytes).unwrap_or(Value::Null),
+        Err(_) => Value::Null,
+    };
+
+    if status == StatusCode::OK {
+        let usage = body.get("usage").cloned();
+        return (json!({ "index": index, "response": body }), usage);
+    }
+    let error = body.get("error").cloned().unwrap_or_else(|| {
+        json!({
+            "message": format!("Completion failed with status {}", status.as_u16()),
+            "type": "server_error",
+            "param": null,
+            "code": null
+        })
+    });
+    (
+        json!({ "index": index, "status": status.as_u16(), "error": error }),
+        None,
+    )
+}
+
+/// `POST /v1/completions/batch` - run several completions of one model and
+/// return every result
+pub async fn create_batch(
+    State(state): State<Arc<ServerState>>,
+    headers: HeaderMap,
+    Json(batch): Json<CompletionBatchRequest>,
+) -> Response {
+    if let Err((message, param)) = batch.validate() {
+        return invalid_request(message, Some(param), Some("invalid_batch"));
+    }
+
+    let items = batch
+        .requests
+        .into_iter()
+        .enumerate()
+        .map(|(index, mut request)| {
+            request.model = batch.model.clone();
+            request.metadata = None;
+            run_item(Arc::clone(&state), headers.clone(), index, request)
+        });
+    let results = join_all(items).await;
+
+    let mut usage = BTreeMap::new();
+    let mut data = Vec::with_capacity(results.len());
+    for (entry, item_usage) in results {
+        for field in USAGE_FIELDS {
+            let tokens = item_usage
+                .as_ref()
+                .and_then(|usage| usage.get(field))
+                .and_then(Value::as_u64)
+                .unwrap_or(0);
+            *usage.entry(field).or_insert(0) += tokens;
+        }
+        data.push(entry);
+    }
+
+    Json(json!({
+        "object": "list",
+        "model": batch.model,
+        "data": data,
+        "usage": usage
+    }))
+    .into_response()
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn batch(max_tokens: u64, items: Value) -> CompletionBatchRequest {
+        serde_json::from_value(json!({
+            "model": "m",
+            "max_tokens": max_tokens,
+            "requests": items
+        }))
+        .unwrap()
+    }
+
+    #[test]
+    fn test_items_must_fit_max_tokens() {
+        let items = json!([
+            { "model": "", "prompt": "a", "max_tokens": 16 },
+            { "model": "", "prompt": "b", "max_tokens": 8, "n": 2 }
+        ]);
+        assert!(batch(32, items.clone()).validate().is_ok());
+        let (_, param) = batch(31, items).validate().unwrap_err();
+        assert_eq!(param, "max_tokens");
+    }
+
+    #[test]
+    fn test_streaming_items_are_refused() {
+        let items = json!([
+            { "model": "", "prompt": "a", "max_tokens": 16 },
+            { "model": "", "prompt": "b", "max_tokens": 16, "stream": true }
+        ]);
+        let (message, param) = batch(64, items).validate().unwrap_err();
+        assert_eq!(param, "stream");
+        assert!(message.contains("requests[1]"));
+        assert!(batch(64, json!([])).validate().is_err());
+    }
+}
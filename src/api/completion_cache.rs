@@ -0,0 +1,331 @@
+//! Completion Caching
+//!
+//! Non-streaming `/v1/completions` and `/v1/chat/completions` responses are
+//! kept in the response cache (`[response_cache]` in the config) and
+//! replayed when an identical request arrives. Only deterministic requests
+//! are cached: `temperature` 0 or a fixed `seed`, one choice and no
+//! `best_of`. Other requests would sample a different completion each time.
+//!
+//! Every response says what the cache did in the `X-Inferno-Cache` header
+//! (`hit`, `miss`, `bypass` or `uncacheable`). JSON bodies carry the same as
+//! a `cache` object, with the entry's age on hits; hits also set `Age`. A
+//! request opts out with `"cache_bypass": true` or `Cache-Control:
+//! no-cache`/`no-store`; it is then neither answered from nor stored in the
+//! cache. The cache matches requests exactly; there is no semantic or
+//! prompt-prefix matching.
+//!
+//! Entries belong to the caller that stored them: the key includes the
+//! request's tenant and the digest of its API key, so one caller's
+//! completion is never replayed to another sending the same prompt.
+
+use crate::{
+    api::{api_keys::bearer_digest, queue::tenant_from_headers},
+    backends::InferenceParams,
+    cli::serve::ServerState,
+    response_cache::{CacheKey, ResponseMetadata},
+};
+use axum::{
+    body::Body,
+    http::{HeaderMap, HeaderValue, header},
+    response::{IntoResponse, Response},
+};
+use serde::{Deserialize, Serialize};
+use std::time::Instant;
+use tracing::warn;
+use uuid::Uuid;
+
+pub const CACHE_STATUS_HEADER: &str = "x-inferno-cache";
+
+/// Largest response body annotated with its cache status
+const MAX_BUFFERED_BODY_BYTES: usize = 64 * 1024 * 1024;
+
+/// Largest response body stored
+const MAX_CACHED_BODY_BYTES: usize = 4 * 1024 * 1024;
+
+/// Request fields that do not change the completion and are left out of the
+/// cache key
+const UNKEYED_FIELDS: &[&str] = &[
+    "stream",
+    "stream_options",
+    "user",
+    "timeout_ms",
+    "deadline",
+    "priority_class",
+    "cache_bypass",
+];
+
+/// What the cache did for a request
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Serialize, Deserialize)]
+#[serde(rename_all = "snake_case")]
+pub enum CacheStatus {
+    /// Answered from the cache
+    Hit,
+    /// Not in the cache; the completion was generated and stored
+    Miss,
+    /// The request opted out of the cache
+    Bypass,
+    /// Streaming or non-deterministic, so never cached
+    Uncacheable,
+}
+
+impl CacheStatus {
+    pub fn as_str(&self) -> &'static str {
+        match self {
+            CacheStatus::Hit => "hit",
+            CacheStatus::Miss => "miss",
+            CacheStatus::Bypass => "bypass",
+            CacheStatus::Uncacheable => "uncacheable",
+        }
+    }
+}
+
+/// The `cache` object added to completion bodies
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct CacheInfo {
+    pub status: CacheStatus,
+    /// The cache consulted; only the exact-match `response` cache exists
+    #[serde(rename = "type")]
+    pub kind: String,
+    /// Seconds since the entry was stored, on hits
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub age_seconds: Option<u64>,
+}
+
+/// A stored completion
+#[derive(Debug, Serialize, Deserialize)]
+struct CachedCompletion {
+    cached_at: i64,
+    body: serde_json::Value,
+}
+
+/// Whether and under which key a request uses the cache
+#[derive(Debug)]
+pub struct CachePlan {
+    status: CacheStatus,
+    key: Option<CacheKey>,
+    model: String,
+    object: &'static str,
+    started: Instant,
+}
+
+/// True when the client asked to skip the cache with `Cache-Control`
+fn bypass_requested(headers: &HeaderMap) -> bool {
+    headers
+        .get_all(header::CACHE_CONTROL)
+        .iter()
+        .filter_map(|value| value.to_str().ok())
+        .flat_map(|value| value.split(','))
+        .any(|directive| matches!(directive.trim(), "no-cache" | "no-store"))
+}
+
+/// The text a request is keyed by: its keyed fields, scoped to the tenant
+/// and API key it was sent with
+fn key_text(headers: &HeaderMap, fields: &serde_json::Value) -> String {
+    serde_json::json!({
+        "tenant": tenant_from_headers(headers),
+        "api_key": bearer_digest(headers),
+        "request": fields,
+    })
+    .to_string()
+}
+
+impl CachePlan {
+    /// Decide how `request` uses the cache. `eligible` is false for
+    /// streaming, scoring and multi-choice requests; `object` is the
+    /// response's `object`, such as `chat.completion`.
+    pub fn new<T: Serialize>(
+        state: &ServerState,
+        headers: &HeaderMap,
+        request: &T,
+        bypass: bool,
+        eligible: bool,
+        params: &InferenceParams,
+        object: &'static str,
+    ) -> Self {
+        let mut fields = serde_json::to_value(request).unwrap_or_default();
+        let model = fields
+            .get("model")
+            .and_then(|model| model.as_str())
+            .unwrap_or_default()
+            .to_string();
+
+        let deterministic = params.temperature <= 0.0 || params.seed.is_some();
+        let config = &state.config.response_cache;
+        let (status, key) = if !eligible || !deterministic || !config.enabled {
+            (CacheStatus::Uncacheable, None)
+        } else if bypass || bypass_requested(headers) {
+            (CacheStatus::Bypass, None)
+        } else {
+            if let Some(fields) = fields.as_object_mut() {
+                for field in UNKEYED_FIELDS {
+                    fields.remove(*field);
+                }
+            }
+            // The endpoint is part of the key: the same fields sent to
+            // chat and text completions produce different bodies
+            let key = CacheKey::new(
+                &key_text(headers, &fields),
+                &model,
+                object,
+                &config.hash_algorithm,
+            );
+            (CacheStatus::Miss, Some(key))
+        };
+        Self {
+            status,
+            key,
+            model,
+            object,
+            started: Instant::now(),
+        }
+    }
+
+    /// The cached response for this request, if there is one
+    pub async fn lookup(&self, state: &ServerState) -> Option<Response> {
+        let key = self.key.as_ref()?;
+        let bytes = state.response_cache.get(key).await?;
+        let cached: CachedCompletion = serde_json::from_slice(&bytes).ok()?;
+
+        let age = (chrono::Utc::now().timestamp() - cached.cached_at).max(0) as u64;
+        let mut body = cached.body;
+        // Each response keeps its own id; the prefix says what it is
+        if let Some(id) = body.get_mut("id")
+            && let Some(prefix) = id.as_str().and_then(|id| id.split_once('-')).map(|p| p.0)
+        {
+            *id = format!("{}-{}", prefix, Uuid::new_v4()).into();
+        }
+        annotate(&mut body, CacheStatus::Hit, Some(age));
+
+        let mut response = axum::Json(body).into_response();
+        response
+            .headers_mut()
+            .insert(header::AGE, HeaderValue::from(age));
+        Some(with_cache_status(response, CacheStatus::Hit))
+    }
+
+    /// Store a generated response on a miss, and mark the response with
+    /// what the cache did
+    pub async fn finish(self, state: &ServerState, response: Response) -> Response {
+        if !response.status().is_success() {
+            return response;
+        }
+        let is_json = response
+            .headers()
+            .get(header::CONTENT_TYPE)
+            .and_then(|value| value.to_str().ok())
+            .is_some_and(|value| value.starts_with("application/json"));
+        if !is_json {
+            return with_cache_status(response, self.status);
+        }
+
+        let (mut parts, body) = response.into_parts();
+        let bytes = match axum::body::to_bytes(body, MAX_BUFFERED_BODY_BYTES).await {
+            Ok(bytes) => bytes,
+            Err(e) => {
+                warn!("Cannot buffer completion for the response cache: {}", e);
+                return axum::http::StatusCode::INTERNAL_SERVER_ERROR.into_response();
+            }
+        };
+        let Ok(mut body) = serde_json::from_slice::<serde_json::Value>(&bytes) else {
+            let response = Response::from_parts(parts, Body::from(bytes));
+            return with_cache_status(response, self.status);
+        };
+
+        if let Some(key) = self
+            .key
+            .as_ref()
+            .filter(|_| bytes.len() <= MAX_CACHED_BODY_BYTES)
+        {
+            let cached = CachedCompletion {
+                cached_at: chrono::Utc::now().timestamp(),
+                body: body.clone(),
+            };
+            let metadata = ResponseMetadata {
+                model_id: self.model.clone(),
+                response_type: self.object.to_string(),
+                token_count: body
+                    .pointer("/usage/completion_tokens")
+                    .and_then(|tokens| tokens.as_u64())
+                    .map(|tokens| tokens as u32),
+                processing_time_ms: self.started.elapsed().as_millis() as u64,
+                quality_score: None,
+                content_type: "application/json".to_string(),
+            };
+            let data = serde_json::to_vec(&cached).unwrap_or_default();
+            if let Err(e) = state.response_cache.put(key, data, metadata).await {
+                warn!("Cannot store completion in the response cache: {}", e);
+            }
+        }
+
+        annotate(&mut body, self.status, None);
+        let bytes = serde_json::to_vec(&body).unwrap_or_default();
+        parts.headers.remove(header::CONTENT_LENGTH);
+        with_cache_status(Response::from_parts(parts, Body::from(bytes)), self.status)
+    }
+}
+
+fn annotate(body: &mut serde_json::Value, status: CacheStatus, age_seconds: Option<u64>) {
+    if let Some(body) = body.as_object_mut() {
+        let info = CacheInfo {
+            status,
+            kind: "response".to_string(),
+            age_seconds,
+        };
+        body.insert(
+            "cache".to_string(),
+            serde_json::to_value(info).unwrap_or_default(),
+        );
+    }
+}
+
+/// Set `X-Inferno-Cache` on a response
+pub fn with_cache_status(mut response: Response, status: CacheStatus) -> Response {
+    response.headers_mut().insert(
+        CACHE_STATUS_HEADER,
+        HeaderValue::from_static(status.as_str()),
+    );
+    response
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::api::scheduler::TENANT_HEADER;
+
+    #[test]
+    fn test_cache_control_bypass() {
+        let mut headers = HeaderMap::new();
+        assert!(!bypass_requested(&headers));
+        headers.insert(
+            header::CACHE_CONTROL,
+            HeaderValue::from_static("max-age=0, no-cache"),
+        );
+        assert!(bypass_requested(&headers));
+    }
+
+    #[test]
+    fn test_key_is_scoped_to_caller() {
+        let fields = serde_json::json!({"model": "llama", "prompt": "hi"});
+        let caller = |tenant: &'static str, token: &'static str| {
+            let mut headers = HeaderMap::new();
+            headers.insert(TENANT_HEADER, HeaderValue::from_static(tenant));
+            headers.insert(header::AUTHORIZATION, HeaderValue::from_static(token));
+            key_text(&headers, &fields)
+        };
+
+        assert_eq!(caller("acme", "Bearer a"), caller("acme", "Bearer a"));
+        assert_ne!(caller("acme", "Bearer a"), caller("acme", "Bearer b"));
+        assert_ne!(caller("acme", "Bearer a"), caller("globex", "Bearer a"));
+        // The token itself stays out of the key
+        assert!(!caller("acme", "Bearer secret").contains("secret"));
+    }
+
+    #[test]
+    fn test_annotate_adds_cache_object() {
+        let mut body = serde_json::json!({"id": "cmpl-1", "choices": []});
+        annotate(&mut body, CacheStatus::Hit, Some(12));
+        assert_eq!(body["cache"]["status"], "hit");
+        assert_eq!(body["cache"]["type"], "response");
+        assert_eq!(body["cache"]["age_seconds"], 12);
+    }
+}
@@ -0,0 +1,109 @@
+//! Conditional Requests
+//!
+//! Model listings are polled by dashboards and can be large, so they carry
+//! a strong `ETag` computed from the response body. A `GET` whose
+//! `If-None-Match` names the current tag gets `304 Not Modified` with no
+//! body. Tags depend only on the body, so every replica serving the same
+//! models returns the same tag.
+
+use axum::{
+    http::{HeaderMap, HeaderValue, StatusCode, header},
+    response::{IntoResponse, Response},
+};
+use serde::Serialize;
+use sha2::{Digest, Sha256};
+
+/// The strong entity tag for a response body
+pub fn etag(body: &[u8]) -> String {
+    let digest = Sha256::digest(body);
+    format!("\"{}\"", hex::encode(&digest[..16]))
+}
+
+/// True when `If-None-Match` lists `etag` or is `*`. Comparison is weak, as
+/// RFC 9110 requires for `If-None-Match`, so a `W/` prefix is ignored.
+fn not_modified(headers: &HeaderMap, etag: &str) -> bool {
+    headers
+        .get_all(header::IF_NONE_MATCH)
+        .iter()
+        .filter_map(|value| value.to_str().ok())
+        .flat_map(|value| value.split(','))
+        .map(|tag| tag.trim())
+        .any(|tag| tag == "*" || tag.trim_start_matches("W/") == etag)
+}
+
+/// Serialize `value` as JSON with its `ETag`, or answer `304 Not Modified`
+/// when the client already has it
+pub fn json_with_etag<T: Serialize>(headers: &HeaderMap, value: &T) -> Response {
+    let body = match serde_json::to_vec(value) {
+        Ok(body) => body,
+        Err(e) => return (StatusCode::INTERNAL_SERVER_ERROR, e.to_string()).into_response(),
+    };
+    let etag = etag(&body);
+
+    let mut response = if not_modified(headers, &etag) {
+        StatusCode::NOT_MODIFIED.into_response()
+    } else {
+        (
+            [(
+                header::CONTENT_TYPE,
+                HeaderValue::from_static("application/json"),
+            )],
+            body,
+        )
+            .into_response()
+    };
+    if let Ok(value) = HeaderValue::from_str(&etag) {
+        response.headers_mut().insert(header::ETAG, value);
+    }
+    // Clients may keep the body but must revalidate before reusing it
+    response
+        .headers_mut()
+        .insert(header::CACHE_CONTROL, HeaderValue::from_static("no-cache"));
+    response
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_etag_is_stable() {
+        assert_eq!(etag(b"{\"data\":[]}"), etag(b"{\"data\":[]}"));
+        assert_ne!(etag(b"{\"data\":[]}"), etag(b"{\"data\":[1]}"));
+        assert!(etag(b"").starts_with('"') && etag(b"").ends_with('"'));
+    }
+
+    #[test]
+    fn test_if_none_match() {
+        let tag = etag(b"models");
+        let mut headers = HeaderMap::new();
+        assert!(!not_modified(&headers, &tag));
+
+        let listed = format!("\"other\", W/{}", tag);
+        headers.insert(
+            header::IF_NONE_MATCH,
+            HeaderValue::from_str(&listed).unwrap(),
+        );
+        assert!(not_modified(&headers, &tag));
+
+        headers.insert(header::IF_NONE_MATCH, HeaderValue::from_static("\"other\""));
+        assert!(!not_modified(&headers, &tag));
+
+        headers.insert(header::IF_NONE_MATCH, HeaderValue::from_static("*"));
+        assert!(not_modified(&headers, &tag));
+    }
+
+    #[test]
+    fn test_not_modified_response() {
+        let value = serde_json::json!({"object": "list", "data": []});
+        let first = json_with_etag(&HeaderMap::new(), &value);
+        assert_eq!(first.status(), StatusCode::OK);
+        let tag = first.headers().get(header::ETAG).unwrap().clone();
+
+        let mut headers = HeaderMap::new();
+        headers.insert(header::IF_NONE_MATCH, tag.clone());
+        let second = json_with_etag(&headers, &value);
+        assert_eq!(second.status(), StatusCode::NOT_MODIFIED);
+        assert_eq!(second.headers().get(header::ETAG), Some(&tag));
+    }
+}
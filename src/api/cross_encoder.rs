@@ -0,0 +1,204 @@
+//! Cross-Encoder Pair Scoring
+//!
+//! `POST /v1/score` (also served at `/score`, where vLLM puts it) runs
+//! (query, candidate) pairs through a cross-encoder such as a GGUF reranker
+//! and returns one relevance or entailment score per pair. Both texts are
+//! read together, which ranks far better than comparing separate embeddings,
+//! at the cost of one forward pass per pair.
+
+use crate::{
+    api::{
+        cancellation::with_request_id, invalid_request, openai::get_or_load_backend,
+        queue::priority_from_headers,
+    },
+    cli::serve::ServerState,
+};
+use axum::{
+    Json,
+    extract::State,
+    http::HeaderMap,
+    response::{IntoResponse, Response},
+};
+use serde::{Deserialize, Serialize};
+use serde_json::json;
+use std::sync::Arc;
+
+/// Most pairs one request may score; clients chunk longer lists
+pub const MAX_SCORE_PAIRS: usize = 256;
+
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct ScoreRequest {
+    pub model: String,
+    /// `[query, candidate]` pairs
+    #[serde(default)]
+    pub pairs: Vec<(String, String)>,
+    /// Shorthand for pairing one query with every entry of `candidates`
+    #[serde(default)]
+    pub query: Option<String>,
+    #[serde(default)]
+    pub candidates: Vec<String>,
+    /// Return the classifier's raw logit instead of its sigmoid
+    #[serde(default)]
+    pub raw_scores: bool,
+}
+
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct PairScore {
+    pub index: usize,
+    /// Sigmoid of the first classifier output, or the output itself with
+    /// `raw_scores`
+    pub score: f32,
+    /// Every classifier output, for models with more than one (such as
+    /// entailment / neutral / contradiction)
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub logits: Option<Vec<f32>>,
+}
+
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct ScoreResponse {
+    pub object: String,
+    pub model: String,
+    pub data: Vec<PairScore>,
+}
+
+impl ScoreRequest {
+    /// The pairs to score, from `pairs` or from `query` and `candidates`
+    fn into_pairs(self) -> Result<Vec<(String, String)>, (String, &'static str)> {
+        let pairs = match self.query {
+            Some(_) if !self.pairs.is_empty() => {
+                return Err((
+                    "Set either pairs or query and candidates, not both".to_string(),
+                    "pairs",
+                ));
+            }
+            Some(query) => self
+                .candidates
+                .into_iter()
+                .map(|candidate| (query.clone(), candidate))
+                .collect(),
+            None => self.pairs,
+        };
+
+        if pairs.is_empty() || pairs.len() > MAX_SCORE_PAIRS {
+            return Err((
+                format!("Between 1 and {} pairs may be scored", MAX_SCORE_PAIRS),
+                "pairs",
+            ));
+        }
+        Ok(pairs)
+    }
+}
+
+fn sigmoid(x: f32) -> f32 {
+    1.0 / (1.0 + (-x).exp())
+}
+
+fn pair_score(index: usize, outputs: Vec<f32>, raw_scores: bool) -> PairScore {
+    let first = outputs.first().copied().unwrap_or(f32::NAN);
+    PairScore {
+        index,
+        score: if raw_scores { first } else { sigmoid(first) },
+        logits: (outputs.len() > 1).then_some(outputs),
+    }
+}
+
+// API Handlers
+
+/// `POST /v1/score` - cross-encoder scores for (query, candidate) pairs
+pub async fn score_pairs(
+    State(state): State<Arc<ServerState>>,
+    headers: HeaderMap,
+    Json(request): Json<ScoreRequest>,
+) -> Response {
+    let model = request.model.clone();
+    let raw_scores = request.raw_scores;
+    let pairs = match request.into_pairs() {
+        Ok(pairs) => pairs,
+        Err((message, param)) => return invalid_request(message, Some(param), None),
+    };
+
+    let ticket =
+        match state
+            .request_queue
+            .enqueue_request(&headers, &model, priority_from_headers(&headers))
+        {
+            Ok(ticket) => ticket.with_scheduling(None, &headers),
+            Err(response) => return response,
+        };
+    let request_id = ticket.id().to_string();
+
+    let backend = match get_or_load_backend(&state, &model).await {
+        Ok(backend) => backend,
+        Err(e) => {
+            return invalid_request(format!("Failed to load model: {}", e), Some("model"), None);
+        }
+    };
+
+    ticket.start().await;
+    let outputs = match backend.rank_pairs(&pairs).await {
+        Ok(outputs) => outputs,
+        Err(e) => {
+            return invalid_request(
+                e.to_string(),
+                Some("model"),
+                Some("cross_encoder_not_supported"),
+            );
+        }
+    };
+
+    let data = outputs
+        .into_iter()
+        .enumerate()
+        .map(|(index, outputs)| pair_score(index, outputs, raw_scores))
+        .collect();
+    let response = Json(ScoreResponse {
+        object: "list".to_string(),
+        model,
+        data,
+    })
+    .into_response();
+
+    with_request_id(response, &request_id)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn request(body: serde_json::Value) -> ScoreRequest {
+        serde_json::from_value(body).unwrap()
+    }
+
+    #[test]
+    fn test_query_expands_to_pairs() {
+        let pairs = request(json!({
+            "model": "m",
+            "query": "q",
+            "candidates": ["a", "b"]
+        }))
+        .into_pairs()
+        .unwrap();
+        assert_eq!(
+            pairs,
+            vec![
+                ("q".to_string(), "a".to_string()),
+                ("q".to_string(), "b".to_string())
+            ]
+        );
+
+        let both = request(json!({ "model": "m", "query": "q", "pairs": [["q", "a"]] }));
+        assert!(both.into_pairs().is_err());
+        assert!(request(json!({ "model": "m" })).into_pairs().is_err());
+    }
+
+    #[test]
+    fn test_scores_are_sigmoid_of_first_output() {
+        let score = pair_score(0, vec![0.0], false);
+        assert_eq!(score.score, 0.5);
+        assert!(score.logits.is_none());
+
+        let raw = pair_score(1, vec![2.0, -1.0, 0.5], true);
+        assert_eq!(raw.score, 2.0);
+        assert_eq!(raw.logits, Some(vec![2.0, -1.0, 0.5]));
+    }
+}
@@ -0,0 +1,699 @@
+//! Dataset Management
+//!
+//! Training and eval datasets are JSONL files in the chat format, one
+//! `{"messages": [{"role": ..., "content": ...}, ...]}` record per line.
+//! `PUT /v1/datasets/:name` streams an upload to disk as a new numbered
+//! version, validating each record and hashing the content on the way so
+//! multi-gigabyte files never have to be buffered or re-read.
+//!
+//! Versions live under `fine_tuning.datasets_dir` as `<name>/v<N>.jsonl`
+//! with a `v<N>.meta.json` sidecar holding the validation report. Fine-tuning
+//! jobs accept `<name>` (latest version) or `<name>@v<N>` as their dataset.
+
+use crate::{
+    api::{admin::authorize_admin, error_response, internal_error, invalid_request},
+    cli::serve::ServerState,
+};
+use axum::{
+    Json,
+    body::Body,
+    extract::{Path, Query, State},
+    http::{HeaderMap, StatusCode},
+    response::{IntoResponse, Response},
+};
+use futures::StreamExt;
+use rand::{Rng, SeedableRng, rngs::StdRng};
+use serde::{Deserialize, Serialize};
+use serde_json::json;
+use sha2::{Digest, Sha256};
+use std::{
+    path::{Path as FsPath, PathBuf},
+    sync::Arc,
+};
+use tokio::{
+    fs,
+    io::{AsyncBufReadExt, AsyncWriteExt, BufReader},
+    sync::Mutex,
+};
+use tracing::{info, warn};
+use uuid::Uuid;
+
+/// Largest upload accepted, in bytes
+const MAX_DATASET_BYTES: u64 = 64 * 1024 * 1024 * 1024;
+
+/// Validation errors reported per version; the record count still covers
+/// every line
+const MAX_REPORTED_ERRORS: usize = 20;
+
+/// Most records a single sample may return
+const MAX_SAMPLE_SIZE: usize = 100;
+
+const VALID_ROLES: [&str; 4] = ["system", "user", "assistant", "tool"];
+
+/// A record that failed validation
+#[derive(Debug, Clone, PartialEq, Serialize, Deserialize)]
+pub struct ValidationError {
+    pub line: u64,
+    pub message: String,
+}
+
+/// Outcome of validating a dataset against the chat format
+#[derive(Debug, Clone, Default, Serialize, Deserialize)]
+pub struct ValidationReport {
+    pub valid: bool,
+    pub records: u64,
+    pub invalid_records: u64,
+    /// The first few errors, in line order
+    pub errors: Vec<ValidationError>,
+}
+
+/// One stored version of a dataset
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct DatasetVersion {
+    pub object: String,
+    pub name: String,
+    pub version: u32,
+    pub bytes: u64,
+    pub sha256: String,
+    pub created_at: chrono::DateTime<chrono::Utc>,
+    pub validation: ValidationReport,
+    /// Absolute location of the data, as passed to trainers
+    pub path: PathBuf,
+}
+
+/// A dataset and its versions, oldest first
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct Dataset {
+    pub object: String,
+    pub name: String,
+    pub versions: Vec<DatasetVersion>,
+}
+
+impl Dataset {
+    pub fn latest(&self) -> Option<&DatasetVersion> {
+        self.versions.last()
+    }
+}
+
+/// Check one JSONL line against the chat format
+fn validate_record(line: &str) -> Result<(), String> {
+    let record: serde_json::Value =
+        serde_json::from_str(line).map_err(|e| format!("invalid JSON: {}", e))?;
+    let messages = record
+        .get("messages")
+        .and_then(|m| m.as_array())
+        .ok_or_else(|| "record has no \"messages\" array".to_string())?;
+    if messages.is_empty() {
+        return Err("\"messages\" is empty".to_string());
+    }
+
+    let mut has_assistant = false;
+    for (index, message) in messages.iter().enumerate() {
+        let role = message
+            .get("role")
+            .and_then(|r| r.as_str())
+            .ok_or_else(|| format!("message {} has no role", index))?;
+        if !VALID_ROLES.contains(&role) {
+            return Err(format!("message {} has unknown role {:?}", index, role));
+        }
+        if !message.get("content").is_some_and(|c| c.is_string()) {
+            return Err(format!("message {} has no string content", index));
+        }
+        has_assistant |= role == "assistant";
+    }
+    if !has_assistant {
+        return Err("record has no assistant message to learn from".to_string());
+    }
+    Ok(())
+}
+
+/// Validates JSONL incrementally as chunks of an upload arrive
+#[derive(Debug, Default)]
+struct ChatValidator {
+    line: u64,
+    pending: Vec<u8>,
+    report: ValidationReport,
+}
+
+impl ChatValidator {
+    fn feed(&mut self, chunk: &[u8]) {
+        let mut rest = chunk;
+        while let Some(newline) = rest.iter().position(|b| *b == b'\n') {
+            self.pending.extend_from_slice(&rest[..newline]);
+            let line = std::mem::take(&mut self.pending);
+            self.check_line(&line);
+            rest = &rest[newline + 1..];
+        }
+        self.pending.extend_from_slice(rest);
+    }
+
+    fn check_line(&mut self, line: &[u8]) {
+        self.line += 1;
+        let result = match std::str::from_utf8(line) {
+            Ok(text) if text.trim().is_empty() => return,
+            Ok(text) => validate_record(text.trim_end_matches('\r')),
+            Err(_) => Err("line is not valid UTF-8".to_string()),
+        };
+
+        self.report.records += 1;
+        if let Err(message) = result {
+            self.report.invalid_records += 1;
+            if self.report.errors.len() < MAX_REPORTED_ERRORS {
+                self.report.errors.push(ValidationError {
+                    line: self.line,
+                    message,
+                });
+            }
+        }
+    }
+
+    fn finish(mut self) -> ValidationReport {
+        if !self.pending.is_empty() {
+            let line = std::mem::take(&mut self.pending);
+            self.check_line(&line);
+        }
+        self.report.valid = self.report.records > 0 && self.report.invalid_records == 0;
+        self.report
+    }
+}
+
+pub(crate) fn valid_name(name: &str) -> bool {
+    !name.is_empty()
+        && name.len() <= 64
+        && !name.starts_with('.')
+        && name
+            .chars()
+            .all(|c| c.is_ascii_alphanumeric() || matches!(c, '-' | '_' | '.'))
+}
+
+/// Parse a version selector: `3`, `v3` or `latest` (`None`)
+fn parse_version(selector: &str) -> Result<Option<u32>, String> {
+    if selector == "latest" {
+        return Ok(None);
+    }
+    selector
+        .trim_start_matches('v')
+        .parse()
+        .map(Some)
+        .map_err(|_| format!("invalid version {:?}; use a number or \"latest\"", selector))
+}
+
+/// Split a dataset reference into its name and version selector
+fn parse_reference(reference: &str) -> Option<(&str, Option<u32>)> {
+    let (name, version) = match reference.split_once('@') {
+        Some((name, version)) => (name, parse_version(version).ok()?),
+        None => (reference, None),
+    };
+    valid_name(name).then_some((name, version))
+}
+
+/// Versioned datasets on disk
+#[derive(Debug)]
+pub struct DatasetStore {
+    root: PathBuf,
+    /// Serializes version allocation and deletion
+    lock: Mutex<()>,
+}
+
+impl DatasetStore {
+    pub fn new(root: impl Into<PathBuf>) -> Self {
+        Self {
+            root: root.into(),
+            lock: Mutex::new(()),
+        }
+    }
+
+    fn data_path(&self, name: &str, version: u32) -> PathBuf {
+        self.root.join(name).join(format!("v{}.jsonl", version))
+    }
+
+    fn meta_path(&self, name: &str, version: u32) -> PathBuf {
+        self.root.join(name).join(format!("v{}.meta.json", version))
+    }
+
+    /// Versions of `name`, oldest first; empty if it does not exist
+    async fn versions(&self, name: &str) -> Vec<DatasetVersion> {
+        let mut versions = Vec::new();
+        let Ok(mut entries) = fs::read_dir(self.root.join(name)).await else {
+            return versions;
+        };
+        while let Ok(Some(entry)) = entries.next_entry().await {
+            let file_name = entry.file_name();
+            let Some(file_name) = file_name.to_str() else {
+                continue;
+            };
+            if !file_name.ends_with(".meta.json") {
+                continue;
+            }
+            match fs::read(entry.path()).await.map(|bytes| {
+                serde_json::from_slice::<DatasetVersion>(&bytes).map_err(|e| e.to_string())
+            }) {
+                Ok(Ok(version)) => versions.push(version),
+                Ok(Err(e)) => warn!("Skipping dataset metadata {}: {}", file_name, e),
+                Err(e) => warn!("Cannot read dataset metadata {}: {}", file_name, e),
+            }
+        }
+        versions.sort_by_key(|v| v.version);
+        versions
+    }
+
+    pub async fn get(&self, name: &str) -> Option<Dataset> {
+        if !valid_name(name) {
+            return None;
+        }
+        let versions = self.versions(name).await;
+        (!versions.is_empty()).then(|| Dataset {
+            object: "dataset".to_string(),
+            name: name.to_string(),
+            versions,
+        })
+    }
+
+    /// All datasets, by name
+    pub async fn list(&self) -> Vec<Dataset> {
+        let mut names = Vec::new();
+        if let Ok(mut entries) = fs::read_dir(&self.root).await {
+            while let Ok(Some(entry)) = entries.next_entry().await {
+                if let Some(name) = entry.file_name().to_str() {
+                    if valid_name(name) && entry.path().is_dir() {
+                        names.push(name.to_string());
+                    }
+                }
+            }
+        }
+        names.sort();
+
+        let mut datasets = Vec::new();
+        for name in names {
+            if let Some(dataset) = self.get(&name).await {
+                datasets.push(dataset);
+            }
+        }
+        datasets
+    }
+
+    /// One version; `None` selects the latest
+    pub async fn version(&self, name: &str, version: Option<u32>) -> Option<DatasetVersion> {
+        let dataset = self.get(name).await?;
+        match version {
+            Some(version) => dataset.versions.into_iter().find(|v| v.version == version),
+            None => dataset.versions.into_iter().last(),
+        }
+    }
+
+    /// Look up a `name` or `name@vN` reference
+    pub async fn resolve(&self, reference: &str) -> Option<DatasetVersion> {
+        let (name, version) = parse_reference(reference)?;
+        self.version(name, version).await
+    }
+
+    /// Stream `body` to disk as the next version of `name`
+    pub async fn upload(&self, name: &str, body: Body) -> Result<DatasetVersion, UploadError> {
+        let dir = self.root.join(name);
+        fs::create_dir_all(&dir).await?;
+
+        let temp_path = dir.join(format!(".upload-{}", Uuid::new_v4()));
+        let written = self.write_upload(&temp_path, body).await;
+        let (bytes, sha256, validation) = match written {
+            Ok(written) => written,
+            Err(e) => {
+                let _ = fs::remove_file(&temp_path).await;
+                return Err(e);
+            }
+        };
+
+        let _guard = self.lock.lock().await;
+        let version = self
+            .versions(name)
+            .await
+            .last()
+            .map_or(1, |latest| latest.version + 1);
+        let path = self.data_path(name, version);
+        fs::rename(&temp_path, &path).await?;
+
+        let record = DatasetVersion {
+            object: "dataset.version".to_string(),
+            name: name.to_string(),
+            version,
+            bytes,
+            sha256,
+            created_at: chrono::Utc::now(),
+            validation,
+            path: path.canonicalize().unwrap_or(path),
+        };
+        let meta = serde_json::to_vec_pretty(&record).map_err(std::io::Error::other)?;
+        fs::write(self.meta_path(name, version), meta).await?;
+        Ok(record)
+    }
+
+    async fn write_upload(
+        &self,
+        path: &FsPath,
+        body: Body,
+    ) -> Result<(u64, String, ValidationReport), UploadError> {
+        let mut file = fs::File::create(path).await?;
+        let mut hasher = Sha256::new();
+        let mut validator = ChatValidator::default();
+        let mut bytes = 0u64;
+
+        let mut stream = body.into_data_stream();
+        while let Some(chunk) = stream.next().await {
+            let chunk = chunk.map_err(|e| UploadError::Body(e.to_string()))?;
+            bytes += chunk.len() as u64;
+            if bytes > MAX_DATASET_BYTES {
+                return Err(UploadError::TooLarge);
+            }
+            hasher.update(&chunk);
+            validator.feed(&chunk);
+            file.write_all(&chunk).await?;
+        }
+        file.flush().await?;
+
+        if bytes == 0 {
+            return Err(UploadError::Empty);
+        }
+        Ok((bytes, hex::encode(hasher.finalize()), validator.finish()))
+    }
+
+    /// Delete every version of `name`
+    pub async fn remove(&self, name: &str) -> std::io::Result<bool> {
+        if !valid_name(name) {
+            return Ok(false);
+        }
+        let _guard = self.lock.lock().await;
+        match fs::remove_dir_all(self.root.join(name)).await {
+            Ok(()) => Ok(true),
+            Err(e) if e.kind() == std::io::ErrorKind::NotFound => Ok(false),
+            Err(e) => Err(e),
+        }
+    }
+
+    /// Up to `size` records chosen uniformly at random with a reservoir, so
+    /// the file is read once without being held in memory
+    pub async fn sample(
+        &self,
+        version: &DatasetVersion,
+        size: usize,
+        seed: u64,
+    ) -> std::io::Result<Vec<serde_json::Value>> {
+        let file = fs::File::open(&version.path).await?;
+        let mut lines = BufReader::new(file).lines();
+        let mut rng = StdRng::seed_from_u64(seed);
+        let mut reservoir = Vec::with_capacity(size);
+        let mut seen = 0usize;
+
+        while let Some(line) = lines.next_line().await? {
+            if line.trim().is_empty() {
+                continue;
+            }
+            seen += 1;
+            if reservoir.len() < size {
+                reservoir.push(line);
+            } else {
+                let slot = rng.random_range(0..seen);
+                if slot < size {
+                    reservoir[slot] = line;
+                }
+            }
+        }
+
+        Ok(reservoir
+            .iter()
+            .filter_map(|line| serde_json::from_str(line).ok())
+            .collect())
+    }
+}
+
+/// Why an upload was not stored
+#[derive(Debug)]
+pub enum UploadError {
+    Empty,
+    TooLarge,
+    Body(String),
+    Io(std::io::Error),
+}
+
+impl std::fmt::Display for UploadError {
+    fn fmt(&self, f: &mut std::fmt::Formatter<'_>) -> std::fmt::Result {
+        match self {
+            UploadError::Empty => write!(f, "upload is empty"),
+            UploadError::TooLarge => {
+                write!(f, "datasets may be at most {} bytes", MAX_DATASET_BYTES)
+            }
+            UploadError::Body(message) => write!(f, "upload interrupted: {}", message),
+            UploadError::Io(e) => write!(f, "{}", e),
+        }
+    }
+}
+
+impl From<std::io::Error> for UploadError {
+    fn from(e: std::io::Error) -> Self {
+        UploadError::Io(e)
+    }
+}
+
+fn default_sample_size() -> usize {
+    10
+}
+
+/// Query for `GET /v1/datasets/:name/versions/:version/sample`
+#[derive(Debug, Deserialize)]
+pub struct SampleQuery {
+    #[serde(default = "default_sample_size")]
+    pub n: usize,
+    #[serde(default)]
+    pub seed: u64,
+}
+
+// API Handlers
+
+/// `GET /v1/datasets` - all datasets with their versions
+pub async fn list_datasets(State(state): State<Arc<ServerState>>) -> impl IntoResponse {
+    Json(json!({
+        "object": "list",
+        "data": state.datasets.list().await
+    }))
+}
+
+/// `GET /v1/datasets/:name` - one dataset's versions
+pub async fn get_dataset(
+    State(state): State<Arc<ServerState>>,
+    Path(name): Path<String>,
+) -> Response {
+    match state.datasets.get(&name).await {
+        Some(dataset) => Json(dataset).into_response(),
+        None => dataset_not_found(&name),
+    }
+}
+
+/// `PUT /v1/datasets/:name` - upload a new version (admin only)
+pub async fn upload_dataset(
+    State(state): State<Arc<ServerState>>,
+    Path(name): Path<String>,
+    headers: HeaderMap,
+    body: Body,
+) -> Response {
+    if let Err(response) = authorize_admin(&headers) {
+        return response;
+    }
+
+    if !valid_name(&name) {
+        return invalid_request(
+            "dataset names are 1-64 letters, digits, '-', '_' or '.'".to_string(),
+            Some("name"),
+            None,
+        );
+    }
+
+    match state.datasets.upload(&name, body).await {
+        Ok(version) => {
+            info!(
+                "Stored dataset {} v{} ({} records, {} invalid)",
+                name,
+                version.version,
+                version.validation.records,
+                version.validation.invalid_records
+            );
+            (StatusCode::CREATED, Json(version)).into_response()
+        }
+        Err(UploadError::Empty) => {
+            invalid_request("upload is empty".to_string(), Some("body"), None)
+        }
+        Err(UploadError::TooLarge) => error_response(
+            StatusCode::PAYLOAD_TOO_LARGE,
+            format!("datasets may be at most {} bytes", MAX_DATASET_BYTES),
+            Some("body"),
+            Some("dataset_too_large"),
+        ),
+        Err(UploadError::Body(message)) => invalid_request(
+            format!("upload interrupted: {}", message),
+            Some("body"),
+            None,
+        ),
+        Err(UploadError::Io(e)) => internal_error(format!("Failed to store dataset: {}", e)),
+    }
+}
+
+/// `DELETE /v1/datasets/:name` - delete every version (admin only)
+pub async fn delete_dataset(
+    State(state): State<Arc<ServerState>>,
+    Path(name): Path<String>,
+    headers: HeaderMap,
+) -> Response {
+    if let Err(response) = authorize_admin(&headers) {
+        return response;
+    }
+
+    match state.datasets.remove(&name).await {
+        Ok(true) => StatusCode::NO_CONTENT.into_response(),
+        Ok(false) => dataset_not_found(&name),
+        Err(e) => internal_error(format!("Failed to delete dataset: {}", e)),
+    }
+}
+
+/// `GET /v1/datasets/:name/versions/:version` - one version and its
+/// validation report; `latest` selects the newest
+pub async fn get_version(
+    State(state): State<Arc<ServerState>>,
+    Path((name, version)): Path<(String, String)>,
+) -> Response {
+    let selector = match parse_version(&version) {
+        Ok(selector) => selector,
+        Err(message) => return invalid_request(message, Some("version"), None),
+    };
+
+    match state.datasets.version(&name, selector).await {
+        Some(version) => Json(version).into_response(),
+        None => version_not_found(&name, &version),
+    }
+}
+
+/// `GET /v1/datasets/:name/versions/:version/sample` - random records
+pub async fn sample_version(
+    State(state): State<Arc<ServerState>>,
+    Path((name, version)): Path<(String, String)>,
+    Query(query): Query<SampleQuery>,
+) -> Response {
+    let selector = match parse_version(&version) {
+        Ok(selector) => selector,
+        Err(message) => return invalid_request(message, Some("version"), None),
+    };
+    if query.n == 0 || query.n > MAX_SAMPLE_SIZE {
+        return invalid_request(
+            format!("n must be between 1 and {}", MAX_SAMPLE_SIZE),
+            Some("n"),
+            None,
+        );
+    }
+
+    let Some(dataset_version) = state.datasets.version(&name, selector).await else {
+        return version_not_found(&name, &version);
+    };
+
+    match state
+        .datasets
+        .sample(&dataset_version, query.n, query.seed)
+        .await
+    {
+        Ok(records) => Json(json!({
+            "object": "list",
+            "data": records,
+            "total_records": dataset_version.validation.records
+        }))
+        .into_response(),
+        Err(e) => internal_error(format!("Failed to read dataset: {}", e)),
+    }
+}
+
+fn dataset_not_found(name: &str) -> Response {
+    error_response(
+        StatusCode::NOT_FOUND,
+        format!("No dataset named {}", name),
+        Some("name"),
+        Some("dataset_not_found"),
+    )
+}
+
+fn version_not_found(name: &str, version: &str) -> Response {
+    error_response(
+        StatusCode::NOT_FOUND,
+        format!("Dataset {} has no version {}", name, version),
+        Some("version"),
+        Some("dataset_version_not_found"),
+    )
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    const GOOD: &str =
+        r#"{"messages":[{"role":"user","content":"Hi"},{"role":"assistant","content":"Hello"}]}"#;
+
+    #[test]
+    fn accepts_chat_records() {
+        assert!(validate_record(GOOD).is_ok());
+    }
+
+    #[test]
+    fn rejects_malformed_records() {
+        assert!(validate_record("not json").is_err());
+        assert!(validate_record(r#"{"prompt":"x"}"#).is_err());
+        assert!(validate_record(r#"{"messages":[]}"#).is_err());
+        assert!(validate_record(r#"{"messages":[{"role":"user","content":"Hi"}]}"#).is_err());
+        assert!(
+            validate_record(r#"{"messages":[{"role":"robot","content":"Hi"},{"role":"assistant","content":"x"}]}"#)
+                .is_err()
+        );
+    }
+
+    #[test]
+    fn validates_lines_split_across_chunks() {
+        let data = format!("{}\n\n{{\"messages\":1}}\n{}", GOOD, GOOD);
+        let mut validator = ChatValidator::default();
+        for chunk in data.as_bytes().chunks(7) {
+            validator.feed(chunk);
+        }
+        let report = validator.finish();
+        assert_eq!(report.records, 3);
+        assert_eq!(report.invalid_records, 1);
+        assert_eq!(report.errors[0].line, 3);
+        assert!(!report.valid);
+    }
+
+    #[test]
+    fn parses_references_and_versions() {
+        assert_eq!(parse_reference("support"), Some(("support", None)));
+        assert_eq!(parse_reference("support@v2"), Some(("support", Some(2))));
+        assert_eq!(parse_reference("support@latest"), Some(("support", None)));
+        assert_eq!(parse_reference("data/train.txt"), None);
+        assert_eq!(parse_version("3"), Ok(Some(3)));
+        assert!(parse_version("three").is_err());
+    }
+
+    #[tokio::test]
+    async fn stores_versions_and_samples_reproducibly() {
+        let dir = tempfile::tempdir().unwrap();
+        let store = DatasetStore::new(dir.path());
+        let data: String = (0..50).map(|_| format!("{}\n", GOOD)).collect();
+
+        let first = store
+            .upload("chat", Body::from(data.clone()))
+            .await
+            .unwrap();
+        let second = store.upload("chat", Body::from(data)).await.unwrap();
+        assert_eq!((first.version, second.version), (1, 2));
+        assert!(second.validation.valid);
+        assert_eq!(first.sha256, second.sha256);
+        assert_eq!(store.resolve("chat").await.unwrap().version, 2);
+        assert_eq!(store.resolve("chat@v1").await.unwrap().version, 1);
+
+        let a = store.sample(&second, 5, 7).await.unwrap();
+        let b = store.sample(&second, 5, 7).await.unwrap();
+        assert_eq!(a.len(), 5);
+        assert_eq!(a, b);
+
+        assert!(store.remove("chat").await.unwrap());
+        assert!(store.get("chat").await.is_none());
+    }
+}
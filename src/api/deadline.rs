@@ -0,0 +1,72 @@
+//! Per-request Deadlines
+//!
+//! Clients may bound a generation with `timeout_ms` (relative) and/or
+//! `deadline` (absolute RFC 3339 timestamp). The server stops decoding when
+//! the earlier of the two passes and returns what was generated so far with
+//! `finish_reason: "timeout"`, so abandoned requests do not keep the GPU busy.
+
+use std::time::Duration;
+use tokio::time::Instant;
+
+/// Resolve the request's timeout fields into a single monotonic deadline.
+///
+/// A deadline already in the past resolves to "now", so decoding stops at
+/// the first opportunity.
+pub fn resolve_deadline(
+    timeout_ms: Option<u64>,
+    deadline: Option<chrono::DateTime<chrono::Utc>>,
+) -> Option<Instant> {
+    let now = Instant::now();
+    let relative = timeout_ms.map(|ms| now + Duration::from_millis(ms));
+    let absolute = deadline.map(|at| {
+        let remaining = (at - chrono::Utc::now()).to_std().unwrap_or(Duration::ZERO);
+        now + remaining
+    });
+
+    match (relative, absolute) {
+        (Some(a), Some(b)) => Some(a.min(b)),
+        (a, b) => a.or(b),
+    }
+}
+
+/// Resolve when `deadline` passes; never resolves without a deadline
+pub async fn expired(deadline: Option<Instant>) {
+    match deadline {
+        Some(at) => tokio::time::sleep_until(at).await,
+        None => std::future::pending::<()>().await,
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_no_deadline() {
+        assert!(resolve_deadline(None, None).is_none());
+    }
+
+    #[test]
+    fn test_earliest_deadline_wins() {
+        let far = chrono::Utc::now() + chrono::Duration::seconds(60);
+        let resolved = resolve_deadline(Some(100), Some(far)).unwrap();
+        assert!(resolved <= Instant::now() + Duration::from_millis(100));
+
+        let near = chrono::Utc::now() + chrono::Duration::milliseconds(50);
+        let resolved = resolve_deadline(Some(60_000), Some(near)).unwrap();
+        assert!(resolved <= Instant::now() + Duration::from_millis(50));
+    }
+
+    #[test]
+    fn test_past_deadline_is_immediate() {
+        let past = chrono::Utc::now() - chrono::Duration::seconds(5);
+        let resolved = resolve_deadline(None, Some(past)).unwrap();
+        assert!(resolved <= Instant::now());
+    }
+
+    #[tokio::test]
+    async fn test_expired_fires() {
+        let deadline = resolve_deadline(Some(1), None);
+        expired(deadline).await;
+    }
+}
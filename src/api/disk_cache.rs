@@ -0,0 +1,452 @@
+//! Disk Cache Management
+//!
+//! The server keeps three kinds of file on disk that it can recreate:
+//!
+//! - `download`: models fetched from remote model stores, under
+//!   `<cache_dir>/model-stores/<store>/`
+//! - `partial`: `.part` files left by interrupted model store fetches and hub
+//!   pulls. Only files untouched for [`PARTIAL_STALE_AFTER`] count, since
+//!   newer ones may still be written.
+//! - `metadata`: parsed model metadata under `<models_dir>/.inferno_cache/`
+//!
+//! `GET /cache/stats` reports each cache's size and age, and how often model
+//! store fetches were served from the cache. `POST /cache/clear` removes
+//! files by type and age. `GET`/`PUT /cache/config` set a size limit per
+//! type; a background task removes the oldest files of a type over its limit
+//! every minute. Clearing and limits require the admin token.
+
+use crate::{
+    api::{admin::authorize_admin, model_events::ModelEventType},
+    cli::serve::ServerState,
+};
+use axum::{
+    Json,
+    extract::State,
+    http::HeaderMap,
+    response::{IntoResponse, Response},
+};
+use chrono::{DateTime, Utc};
+use serde::{Deserialize, Serialize};
+use serde_json::json;
+use std::{
+    collections::HashMap,
+    path::{Path, PathBuf},
+    sync::{Arc, RwLock},
+    time::{Duration, SystemTime},
+};
+use tracing::{info, warn};
+
+/// `.part` files modified more recently than this may belong to a running
+/// download and are left alone
+pub const PARTIAL_STALE_AFTER: Duration = Duration::from_secs(10 * 60);
+
+/// Time between size limit checks
+const ENFORCE_INTERVAL: Duration = Duration::from_secs(60);
+
+/// A kind of cached file
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Hash, Serialize, Deserialize)]
+#[serde(rename_all = "snake_case")]
+pub enum CacheKind {
+    Download,
+    Partial,
+    Metadata,
+}
+
+impl CacheKind {
+    const ALL: [CacheKind; 3] = [CacheKind::Download, CacheKind::Partial, CacheKind::Metadata];
+}
+
+/// Size limits per kind, in bytes; kinds without one grow freely
+#[derive(Debug, Clone, Default, Serialize, Deserialize)]
+pub struct DiskCacheConfig {
+    #[serde(default)]
+    pub max_bytes: HashMap<CacheKind, u64>,
+}
+
+/// Partial update: a limit of `null` removes it, omitted kinds keep theirs
+#[derive(Debug, Clone, Default, Deserialize)]
+pub struct DiskCacheConfigUpdate {
+    #[serde(default)]
+    pub max_bytes: HashMap<CacheKind, Option<u64>>,
+}
+
+impl DiskCacheConfigUpdate {
+    fn apply(self, mut config: DiskCacheConfig) -> DiskCacheConfig {
+        for (kind, limit) in self.max_bytes {
+            match limit {
+                Some(limit) => config.max_bytes.insert(kind, limit),
+                None => config.max_bytes.remove(&kind),
+            };
+        }
+        config
+    }
+}
+
+/// Cache settings, changed at runtime through `PUT /cache/config`
+#[derive(Debug, Default)]
+pub struct DiskCache {
+    config: RwLock<DiskCacheConfig>,
+}
+
+impl DiskCache {
+    pub fn new() -> Self {
+        Self::default()
+    }
+
+    pub fn config(&self) -> DiskCacheConfig {
+        self.config.read().unwrap().clone()
+    }
+
+    fn set_config(&self, config: DiskCacheConfig) {
+        *self.config.write().unwrap() = config;
+    }
+}
+
+/// A file in one of the caches
+#[derive(Debug, Clone)]
+struct CachedFile {
+    kind: CacheKind,
+    path: PathBuf,
+    bytes: u64,
+    modified: SystemTime,
+}
+
+/// Size and age of one cache
+#[derive(Debug, Clone, Serialize)]
+pub struct CacheStats {
+    pub kind: CacheKind,
+    pub directory: PathBuf,
+    pub files: usize,
+    pub bytes: u64,
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub max_bytes: Option<u64>,
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub oldest: Option<DateTime<Utc>>,
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub newest: Option<DateTime<Utc>>,
+}
+
+/// What a clear or limit check removed from one cache
+#[derive(Debug, Clone, Default, Serialize)]
+pub struct Removed {
+    pub files: usize,
+    pub bytes: u64,
+}
+
+fn directory(state: &ServerState, kind: CacheKind) -> PathBuf {
+    match kind {
+        CacheKind::Download => state.model_stores.root().to_path_buf(),
+        CacheKind::Partial => state.config.models_dir.clone(),
+        CacheKind::Metadata => state.model_manager.metadata_cache_dir(),
+    }
+}
+
+fn is_partial(path: &Path) -> bool {
+    path.extension().is_some_and(|ext| ext == "part")
+}
+
+/// Regular files under `dir`, recursively; unreadable entries are skipped
+fn walk(dir: &Path, files: &mut Vec<(PathBuf, std::fs::Metadata)>) {
+    let Ok(entries) = std::fs::read_dir(dir) else {
+        return;
+    };
+    for entry in entries.flatten() {
+        let Ok(metadata) = entry.metadata() else {
+            continue;
+        };
+        if metadata.is_dir() {
+            walk(&entry.path(), files);
+        } else if metadata.is_file() {
+            files.push((entry.path(), metadata));
+        }
+    }
+}
+
+/// Files of `kind`, oldest first
+fn scan_kind(kind: CacheKind, dir: &Path, store_root: &Path) -> Vec<CachedFile> {
+    let mut found = Vec::new();
+    match kind {
+        CacheKind::Download => walk(dir, &mut found),
+        CacheKind::Partial => {
+            walk(dir, &mut found);
+            walk(store_root, &mut found);
+        }
+        CacheKind::Metadata => walk(dir, &mut found),
+    }
+    // The store cache may sit inside the models directory
+    found.sort_by(|a, b| a.0.cmp(&b.0));
+    found.dedup_by(|a, b| a.0 == b.0);
+
+    let stale_before = SystemTime::now() - PARTIAL_STALE_AFTER;
+    let mut files: Vec<CachedFile> = found
+        .into_iter()
+        .filter_map(|(path, metadata)| {
+            let modified = metadata.modified().unwrap_or(SystemTime::UNIX_EPOCH);
+            let keep = match kind {
+                // Store definitions sit at the top, next to the store directories
+                CacheKind::Download => !is_partial(&path) && path.parent() != Some(dir),
+                CacheKind::Partial => is_partial(&path) && modified < stale_before,
+                CacheKind::Metadata => path.extension().is_some_and(|ext| ext == "json"),
+            };
+            keep.then(|| CachedFile {
+                kind,
+                path,
+                bytes: metadata.len(),
+                modified,
+            })
+        })
+        .collect();
+    files.sort_by_key(|file| file.modified);
+    files
+}
+
+async fn scan(state: &ServerState, kind: CacheKind) -> Vec<CachedFile> {
+    let dir = directory(state, kind);
+    let store_root = state.model_stores.root().to_path_buf();
+    tokio::task::spawn_blocking(move || scan_kind(kind, &dir, &store_root))
+        .await
+        .unwrap_or_default()
+}
+
+/// Remove a cached file, going through the model store registry for
+/// downloads so a concurrent fetch of the same object is not cut short
+async fn remove(state: &ServerState, file: &CachedFile) -> std::io::Result<bool> {
+    if file.kind == CacheKind::Download {
+        let root = state.model_stores.root();
+        if let Ok(relative) = file.path.strip_prefix(root) {
+            let mut parts = relative.iter().map(|part| part.to_string_lossy());
+            if let Some(name) = parts.next()
+                && let Some(store) = state.model_stores.get(&name).await
+            {
+                let key = parts.collect::<Vec<_>>().join("/");
+                let removed = state.model_stores.evict(&store, &key).await?;
+                if removed {
+                    let url = store.location().object_url(&key);
+                    state
+                        .model_events
+                        .publish(ModelEventType::Evicted, &url, "disk_cache");
+                }
+                return Ok(removed);
+            }
+        }
+    }
+
+    match tokio::fs::remove_file(&file.path).await {
+        Ok(()) => Ok(true),
+        Err(e) if e.kind() == std::io::ErrorKind::NotFound => Ok(false),
+        Err(e) => Err(e),
+    }
+}
+
+/// Remove `files` in order until `enough` says to stop
+async fn remove_files(
+    state: &ServerState,
+    files: &[CachedFile],
+    mut enough: impl FnMut(&Removed) -> bool,
+) -> Removed {
+    let mut removed = Removed::default();
+    for file in files {
+        if enough(&removed) {
+            break;
+        }
+        match remove(state, file).await {
+            Ok(true) => {
+                removed.files += 1;
+                removed.bytes += file.bytes;
+            }
+            Ok(false) => {}
+            Err(e) => warn!("Cannot remove cached file {}: {}", file.path.display(), e),
+        }
+    }
+    removed
+}
+
+/// Remove the oldest files of every kind over its size limit
+async fn enforce_limits(state: &ServerState) -> HashMap<CacheKind, Removed> {
+    let config = state.disk_cache.config();
+    let mut results = HashMap::new();
+    for (kind, limit) in config.max_bytes {
+        let files = scan(state, kind).await;
+        let total: u64 = files.iter().map(|file| file.bytes).sum();
+        if total <= limit {
+            continue;
+        }
+        let excess = total - limit;
+        let removed = remove_files(state, &files, |removed| removed.bytes >= excess).await;
+        info!(
+            "Cache {:?} over its {} byte limit: removed {} files ({} bytes)",
+            kind, limit, removed.files, removed.bytes
+        );
+        results.insert(kind, removed);
+    }
+    results
+}
+
+/// Background loop keeping each cache under its size limit
+pub async fn run_enforcer(state: Arc<ServerState>) {
+    loop {
+        tokio::time::sleep(ENFORCE_INTERVAL).await;
+        enforce_limits(&state).await;
+    }
+}
+
+/// Body of `POST /cache/clear`
+#[derive(Debug, Clone, Default, Deserialize)]
+pub struct ClearRequest {
+    /// Kinds to clear; all when omitted
+    #[serde(default)]
+    pub types: Option<Vec<CacheKind>>,
+    /// Only files last modified more than this many seconds ago
+    #[serde(default)]
+    pub older_than_secs: Option<u64>,
+}
+
+// API Handlers
+
+/// `GET /cache/stats` - size and age of each cache and the download hit rate
+pub async fn cache_stats(State(state): State<Arc<ServerState>>) -> Response {
+    let config = state.disk_cache.config();
+    let mut caches = Vec::new();
+    for kind in CacheKind::ALL {
+        let files = scan(&state, kind).await;
+        caches.push(CacheStats {
+            kind,
+            directory: directory(&state, kind),
+            files: files.len(),
+            bytes: files.iter().map(|file| file.bytes).sum(),
+            max_bytes: config.max_bytes.get(&kind).copied(),
+            oldest: files.first().map(|file| file.modified.into()),
+            newest: files.last().map(|file| file.modified.into()),
+        });
+    }
+
+    let (hits, misses) = state.model_stores.fetch_counts();
+    let hit_ratio = if hits + misses == 0 {
+        None
+    } else {
+        Some(hits as f64 / (hits + misses) as f64)
+    };
+    Json(json!({
+        "object": "cache.stats",
+        "total_bytes": caches.iter().map(|cache| cache.bytes).sum::<u64>(),
+        "caches": caches,
+        "downloads": {
+            "hits": hits,
+            "misses": misses,
+            "hit_ratio": hit_ratio,
+        },
+    }))
+    .into_response()
+}
+
+/// `POST /cache/clear` - remove cached files by type and age (admin only)
+pub async fn clear_cache(
+    State(state): State<Arc<ServerState>>,
+    headers: HeaderMap,
+    Json(request): Json<ClearRequest>,
+) -> Response {
+    if let Err(response) = authorize_admin(&headers) {
+        return response;
+    }
+
+    let kinds = request.types.unwrap_or_else(|| CacheKind::ALL.to_vec());
+    let cutoff = request
+        .older_than_secs
+        .map(|secs| SystemTime::now() - Duration::from_secs(secs));
+
+    let mut cleared = Vec::new();
+    let mut freed = 0;
+    for kind in CacheKind::ALL
+        .into_iter()
+        .filter(|kind| kinds.contains(kind))
+    {
+        let files: Vec<CachedFile> = scan(&state, kind)
+            .await
+            .into_iter()
+            .filter(|file| cutoff.is_none_or(|cutoff| file.modified < cutoff))
+            .collect();
+        let removed = remove_files(&state, &files, |_| false).await;
+        freed += removed.bytes;
+        cleared.push(json!({
+            "kind": kind,
+            "files": removed.files,
+            "bytes": removed.bytes,
+        }));
+    }
+    info!("Cleared caches: {} bytes freed", freed);
+
+    Json(json!({
+        "object": "cache.clear",
+        "freed_bytes": freed,
+        "cleared": cleared,
+    }))
+    .into_response()
+}
+
+/// `GET /cache/config` - size limits per cache type (admin only)
+pub async fn get_cache_config(
+    State(state): State<Arc<ServerState>>,
+    headers: HeaderMap,
+) -> Response {
+    if let Err(response) = authorize_admin(&headers) {
+        return response;
+    }
+    Json(state.disk_cache.config()).into_response()
+}
+
+/// `PUT /cache/config` - set or remove size limits, applying them now
+/// (admin only)
+pub async fn update_cache_config(
+    State(state): State<Arc<ServerState>>,
+    headers: HeaderMap,
+    Json(update): Json<DiskCacheConfigUpdate>,
+) -> Response {
+    if let Err(response) = authorize_admin(&headers) {
+        return response;
+    }
+
+    state
+        .disk_cache
+        .set_config(update.apply(state.disk_cache.config()));
+    let removed = enforce_limits(&state).await;
+    Json(json!({
+        "config": state.disk_cache.config(),
+        "removed": removed,
+    }))
+    .into_response()
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_update_sets_and_removes_limits() {
+        let config = DiskCacheConfig {
+            max_bytes: HashMap::from([(CacheKind::Download, 100), (CacheKind::Metadata, 5)]),
+        };
+        let update: DiskCacheConfigUpdate =
+            serde_json::from_str(r#"{"max_bytes": {"download": 200, "metadata": null}}"#).unwrap();
+        let config = update.apply(config);
+        assert_eq!(config.max_bytes.get(&CacheKind::Download), Some(&200));
+        assert!(!config.max_bytes.contains_key(&CacheKind::Metadata));
+    }
+
+    #[test]
+    fn test_scan_separates_downloads_from_partials() {
+        let dir = tempfile::tempdir().unwrap();
+        let root = dir.path().join("model-stores");
+        std::fs::create_dir_all(root.join("models/llama")).unwrap();
+        std::fs::write(root.join("stores.json"), b"[]").unwrap();
+        std::fs::write(root.join("models/llama/q4.gguf"), b"gguf").unwrap();
+        std::fs::write(root.join("models/llama/q8.gguf.part"), b"gg").unwrap();
+
+        let downloads = scan_kind(CacheKind::Download, &root, &root);
+        assert_eq!(downloads.len(), 1);
+        assert_eq!(downloads[0].bytes, 4);
+
+        // Just written, so possibly still downloading
+        let partials = scan_kind(CacheKind::Partial, dir.path(), &root);
+        assert!(partials.is_empty());
+    }
+}
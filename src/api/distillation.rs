@@ -0,0 +1,782 @@
+//! Knowledge Distillation Pipelines
+//!
+//! A distillation job runs two stages in order:
+//!
+//! 1. `generate` - a large teacher model answers each prompt, and the answers
+//!    are stored as a new version of a managed chat dataset.
+//! 2. `train` - a fine-tuning job trains a LoRA adapter for the smaller
+//!    student model on that dataset.
+//!
+//! Each stage reports its own status and progress, so a failed training run
+//! can be diagnosed without regenerating the data. Generation is one
+//! low-priority queue entry identified by the job ID; cancelling the job
+//! cancels whichever stage is running, including the fine-tuning job.
+
+use crate::{
+    api::{
+        admin::authorize_admin,
+        cancellation::{CancelSignal, FinishReason, generate_cancellable},
+        datasets,
+        fine_tuning::{
+            self, FineTuningJobRequest, FineTuningStatus, LoraHyperparameters, Rejection,
+        },
+        invalid_request,
+        openai::get_or_load_backend,
+        queue::QueueTicket,
+    },
+    backends::InferenceParams,
+    cli::serve::ServerState,
+    operations::queue::Priority,
+};
+use axum::{
+    Json,
+    body::Body,
+    extract::{Path, State},
+    http::{HeaderMap, StatusCode},
+    response::{IntoResponse, Response},
+};
+use serde::{Deserialize, Serialize};
+use serde_json::json;
+use std::{collections::HashMap, sync::Arc, time::Duration};
+use tokio::sync::RwLock;
+use tracing::{info, warn};
+use uuid::Uuid;
+
+/// Most prompts a single job may distil
+const MAX_PROMPTS: usize = 10_000;
+
+/// Most teacher answers generated per prompt
+const MAX_SAMPLES_PER_PROMPT: u32 = 8;
+
+/// Finished jobs kept for retrieval; the oldest are dropped first
+const MAX_RETAINED_JOBS: usize = 100;
+
+/// How often the train stage checks on its fine-tuning job
+const TRAIN_POLL_INTERVAL: Duration = Duration::from_secs(2);
+
+fn default_samples_per_prompt() -> u32 {
+    1
+}
+
+fn default_temperature() -> f32 {
+    0.7
+}
+
+fn default_max_tokens() -> u32 {
+    512
+}
+
+fn default_register() -> bool {
+    true
+}
+
+/// Body for `POST /v1/distillation/jobs`
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct DistillationRequest {
+    /// Model that answers the prompts
+    pub teacher_model: String,
+    /// Local GGUF model the adapter is trained for
+    pub student_model: String,
+    pub prompts: Vec<String>,
+    /// Stored as the system message of every generated record
+    #[serde(default)]
+    pub system_prompt: Option<String>,
+    #[serde(default = "default_samples_per_prompt")]
+    pub samples_per_prompt: u32,
+    #[serde(default = "default_temperature")]
+    pub temperature: f32,
+    #[serde(default = "default_max_tokens")]
+    pub max_tokens: u32,
+    /// Managed dataset the teacher's answers are stored in; defaults to
+    /// `distill-<job>`
+    #[serde(default)]
+    pub dataset_name: Option<String>,
+    #[serde(default)]
+    pub hyperparameters: LoraHyperparameters,
+    #[serde(default)]
+    pub suffix: Option<String>,
+    #[serde(default = "default_register")]
+    pub register: bool,
+}
+
+impl DistillationRequest {
+    fn validate(&self) -> Result<(), Rejection> {
+        if self.prompts.is_empty() {
+            return Err(("prompts must not be empty".to_string(), "prompts"));
+        }
+        if self.prompts.len() > MAX_PROMPTS {
+            return Err((
+                format!("a job may distil at most {} prompts", MAX_PROMPTS),
+                "prompts",
+            ));
+        }
+        if self.prompts.iter().any(|p| p.trim().is_empty()) {
+            return Err((
+                "prompts must not contain empty prompts".to_string(),
+                "prompts",
+            ));
+        }
+        if !(1..=MAX_SAMPLES_PER_PROMPT).contains(&self.samples_per_prompt) {
+            return Err((
+                format!(
+                    "samples_per_prompt must be between 1 and {}",
+                    MAX_SAMPLES_PER_PROMPT
+                ),
+                "samples_per_prompt",
+            ));
+        }
+        if !(0.0..=2.0).contains(&self.temperature) {
+            return Err((
+                "temperature must be between 0 and 2".to_string(),
+                "temperature",
+            ));
+        }
+        if self.max_tokens == 0 {
+            return Err(("max_tokens must be positive".to_string(), "max_tokens"));
+        }
+        if self
+            .dataset_name
+            .as_deref()
+            .is_some_and(|name| !datasets::valid_name(name))
+        {
+            return Err((
+                "dataset_name must be 1-64 letters, digits, '-', '_' or '.'".to_string(),
+                "dataset_name",
+            ));
+        }
+        fine_tuning::check_options(&self.hyperparameters, self.suffix.as_deref())
+    }
+}
+
+/// Lifecycle state of a job or stage
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Serialize, Deserialize)]
+#[serde(rename_all = "snake_case")]
+pub enum DistillationStatus {
+    Pending,
+    Running,
+    Succeeded,
+    Failed,
+    Cancelled,
+}
+
+impl DistillationStatus {
+    fn is_finished(self) -> bool {
+        !matches!(
+            self,
+            DistillationStatus::Pending | DistillationStatus::Running
+        )
+    }
+}
+
+/// One stage of a pipeline
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct PipelineStage {
+    /// `generate` or `train`
+    pub name: String,
+    pub status: DistillationStatus,
+    /// Answers generated, or training steps completed
+    pub completed: u64,
+    pub total: Option<u64>,
+    /// Teacher generations that failed and were left out of the dataset
+    pub failed: u64,
+    pub started_at: Option<chrono::DateTime<chrono::Utc>>,
+    pub finished_at: Option<chrono::DateTime<chrono::Utc>>,
+    pub error: Option<String>,
+}
+
+impl PipelineStage {
+    fn new(name: &str, total: Option<u64>) -> Self {
+        Self {
+            name: name.to_string(),
+            status: DistillationStatus::Pending,
+            completed: 0,
+            total,
+            failed: 0,
+            started_at: None,
+            finished_at: None,
+            error: None,
+        }
+    }
+
+    fn start(&mut self) {
+        self.status = DistillationStatus::Running;
+        self.started_at = Some(chrono::Utc::now());
+    }
+
+    fn end(&mut self, status: DistillationStatus, error: Option<String>) {
+        self.status = status;
+        self.error = error;
+        self.finished_at = Some(chrono::Utc::now());
+    }
+}
+
+const GENERATE_STAGE: usize = 0;
+const TRAIN_STAGE: usize = 1;
+
+/// A distillation pipeline run
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct DistillationJob {
+    pub id: String,
+    pub object: String,
+    pub teacher_model: String,
+    pub student_model: String,
+    pub status: DistillationStatus,
+    pub stages: Vec<PipelineStage>,
+    /// `name@v<N>` of the generated dataset, once stored
+    pub dataset: Option<String>,
+    pub fine_tuning_job_id: Option<String>,
+    pub fine_tuned_model: Option<String>,
+    pub created_at: chrono::DateTime<chrono::Utc>,
+    pub finished_at: Option<chrono::DateTime<chrono::Utc>>,
+    pub error: Option<String>,
+    #[serde(skip)]
+    cancel: Arc<CancelSignal>,
+}
+
+/// In-memory store of distillation jobs
+#[derive(Debug, Default)]
+pub struct DistillationStore {
+    jobs: RwLock<HashMap<String, DistillationJob>>,
+}
+
+impl DistillationStore {
+    pub fn new() -> Self {
+        Self::default()
+    }
+
+    async fn insert(&self, job: DistillationJob) {
+        let mut jobs = self.jobs.write().await;
+        if jobs.len() >= MAX_RETAINED_JOBS {
+            let oldest = jobs
+                .values()
+                .filter(|job| job.status.is_finished())
+                .min_by_key(|job| job.created_at)
+                .map(|job| job.id.clone());
+            if let Some(oldest) = oldest {
+                jobs.remove(&oldest);
+            }
+        }
+        jobs.insert(job.id.clone(), job);
+    }
+
+    async fn update<F: FnOnce(&mut DistillationJob)>(&self, id: &str, f: F) {
+        if let Some(job) = self.jobs.write().await.get_mut(id) {
+            f(job);
+        }
+    }
+
+    pub async fn get(&self, id: &str) -> Option<DistillationJob> {
+        self.jobs.read().await.get(id).cloned()
+    }
+
+    /// All jobs, newest first
+    pub async fn list(&self) -> Vec<DistillationJob> {
+        let mut jobs: Vec<DistillationJob> = self.jobs.read().await.values().cloned().collect();
+        jobs.sort_by(|a, b| b.created_at.cmp(&a.created_at));
+        jobs
+    }
+
+    /// Signal an unfinished job to stop; `None` if it does not exist
+    pub async fn cancel(&self, id: &str) -> Option<DistillationJob> {
+        let jobs = self.jobs.read().await;
+        let job = jobs.get(id)?;
+        if !job.status.is_finished() {
+            job.cancel.cancel();
+        }
+        Some(job.clone())
+    }
+}
+
+/// Chat record for one teacher answer
+fn chat_record(system_prompt: Option<&str>, prompt: &str, answer: &str) -> serde_json::Value {
+    let mut messages = Vec::with_capacity(3);
+    if let Some(system) = system_prompt {
+        messages.push(json!({"role": "system", "content": system}));
+    }
+    messages.push(json!({"role": "user", "content": prompt}));
+    messages.push(json!({"role": "assistant", "content": answer.trim()}));
+    json!({ "messages": messages })
+}
+
+/// Teacher prompt, with the system prompt ahead of the user's
+fn teacher_prompt(system_prompt: Option<&str>, prompt: &str) -> String {
+    match system_prompt {
+        Some(system) => format!("{}\n\n{}", system, prompt),
+        None => prompt.to_string(),
+    }
+}
+
+/// Answer every prompt with the teacher; `None` if cancelled, otherwise the
+/// JSONL dataset and the number of failed generations
+async fn generate_dataset(
+    state: &Arc<ServerState>,
+    request: &DistillationRequest,
+    job_id: &str,
+    ticket: &QueueTicket,
+) -> Option<Result<Vec<u8>, String>> {
+    let backend = match get_or_load_backend(state, &request.teacher_model).await {
+        Ok(backend) => backend,
+        Err(e) => return Some(Err(format!("Failed to load teacher model: {}", e))),
+    };
+
+    let mut dataset = Vec::new();
+    let mut sample = 0u64;
+    for prompt in &request.prompts {
+        for _ in 0..request.samples_per_prompt {
+            // Distinct seeds keep repeated samples of one prompt apart while
+            // making the whole job reproducible
+            let params = InferenceParams {
+                max_tokens: request.max_tokens,
+                temperature: request.temperature,
+                seed: Some(sample),
+                ..Default::default()
+            };
+            sample += 1;
+
+            let generation = generate_cancellable(
+                &backend,
+                &teacher_prompt(request.system_prompt.as_deref(), prompt),
+                &params,
+                ticket.cancel_signal(),
+                ticket.deadline(),
+            )
+            .await;
+
+            let answer = match generation {
+                Ok(generation) if generation.finish_reason == FinishReason::Cancelled => {
+                    return None;
+                }
+                Ok(generation) if !generation.text.trim().is_empty() => Some(generation.text),
+                Ok(_) => None,
+                Err(e) => {
+                    warn!("Distillation job {}: teacher failed: {}", job_id, e);
+                    None
+                }
+            };
+
+            match answer {
+                Some(answer) => {
+                    let record = chat_record(request.system_prompt.as_deref(), prompt, &answer);
+                    dataset.extend_from_slice(record.to_string().as_bytes());
+                    dataset.push(b'\n');
+                    state
+                        .distillation
+                        .update(job_id, |job| job.stages[GENERATE_STAGE].completed += 1)
+                        .await;
+                }
+                None => {
+                    state
+                        .distillation
+                        .update(job_id, |job| job.stages[GENERATE_STAGE].failed += 1)
+                        .await;
+                }
+            }
+        }
+    }
+
+    if dataset.is_empty() {
+        return Some(Err("the teacher produced no usable answers".to_string()));
+    }
+    Some(Ok(dataset))
+}
+
+/// Follow a fine-tuning job until it finishes, mirroring its progress into
+/// the train stage and cancelling it if the pipeline is cancelled
+async fn follow_training(
+    state: &Arc<ServerState>,
+    job_id: &str,
+    training_id: &str,
+    cancel: &CancelSignal,
+) -> (DistillationStatus, Option<String>) {
+    let mut cancel_forwarded = false;
+    loop {
+        let Some(training) = state.fine_tuning.get(training_id).await else {
+            return (
+                DistillationStatus::Failed,
+                Some("the fine-tuning job is no longer retained".to_string()),
+            );
+        };
+
+        state
+            .distillation
+            .update(job_id, |job| {
+                let stage = &mut job.stages[TRAIN_STAGE];
+                stage.completed = training.progress.step;
+                stage.total = training.progress.total_steps;
+            })
+            .await;
+
+        match training.status {
+            FineTuningStatus::Succeeded => return (DistillationStatus::Succeeded, None),
+            FineTuningStatus::Failed => return (DistillationStatus::Failed, training.error),
+            FineTuningStatus::Cancelled => return (DistillationStatus::Cancelled, None),
+            FineTuningStatus::Queued | FineTuningStatus::Running => {}
+        }
+
+        tokio::select! {
+            _ = tokio::time::sleep(TRAIN_POLL_INTERVAL) => {}
+            _ = cancel.cancelled(), if !cancel_forwarded => {
+                state.fine_tuning.cancel(training_id).await;
+                cancel_forwarded = true;
+            }
+        }
+    }
+}
+
+/// Run both stages, recording each stage's outcome as it goes
+async fn execute_job(
+    state: Arc<ServerState>,
+    request: DistillationRequest,
+    dataset_name: String,
+    ticket: QueueTicket,
+    cancel: Arc<CancelSignal>,
+) {
+    let store = &state.distillation;
+    let job_id = ticket.id().to_string();
+
+    ticket.start().await;
+    store
+        .update(&job_id, |job| {
+            job.status = DistillationStatus::Running;
+            job.stages[GENERATE_STAGE].start();
+        })
+        .await;
+
+    // Stage 1: generate the dataset with the teacher
+    let generated = tokio::select! {
+        generated = generate_dataset(&state, &request, &job_id, &ticket) => generated,
+        _ = cancel.cancelled() => None,
+    };
+    drop(ticket);
+
+    let dataset = match generated {
+        None => {
+            finish(
+                &state,
+                &job_id,
+                GENERATE_STAGE,
+                DistillationStatus::Cancelled,
+                None,
+            )
+            .await;
+            return;
+        }
+        Some(Err(message)) => {
+            finish(
+                &state,
+                &job_id,
+                GENERATE_STAGE,
+                DistillationStatus::Failed,
+                Some(message),
+            )
+            .await;
+            return;
+        }
+        Some(Ok(dataset)) => dataset,
+    };
+
+    let version = match state
+        .datasets
+        .upload(&dataset_name, Body::from(dataset))
+        .await
+    {
+        Ok(version) => version,
+        Err(e) => {
+            let message = format!("Failed to store the generated dataset: {}", e);
+            finish(
+                &state,
+                &job_id,
+                GENERATE_STAGE,
+                DistillationStatus::Failed,
+                Some(message),
+            )
+            .await;
+            return;
+        }
+    };
+    let reference = format!("{}@v{}", version.name, version.version);
+    info!(
+        "Distillation job {} stored {} teacher answers as {}",
+        job_id, version.validation.records, reference
+    );
+    store
+        .update(&job_id, |job| {
+            job.dataset = Some(reference.clone());
+            job.stages[GENERATE_STAGE].end(DistillationStatus::Succeeded, None);
+            job.stages[TRAIN_STAGE].start();
+        })
+        .await;
+
+    if cancel.is_cancelled() {
+        finish(
+            &state,
+            &job_id,
+            TRAIN_STAGE,
+            DistillationStatus::Cancelled,
+            None,
+        )
+        .await;
+        return;
+    }
+
+    // Stage 2: fine-tune the student on it
+    let training = fine_tuning::submit_job(
+        &state,
+        FineTuningJobRequest {
+            base_model: request.student_model.clone(),
+            dataset: reference,
+            hyperparameters: request.hyperparameters.clone(),
+            suffix: request.suffix.clone(),
+            register: request.register,
+        },
+    )
+    .await;
+    let training = match training {
+        Ok(training) => training,
+        Err((message, _)) => {
+            finish(
+                &state,
+                &job_id,
+                TRAIN_STAGE,
+                DistillationStatus::Failed,
+                Some(message),
+            )
+            .await;
+            return;
+        }
+    };
+    store
+        .update(&job_id, |job| {
+            job.fine_tuning_job_id = Some(training.id.clone());
+            job.fine_tuned_model = Some(training.fine_tuned_model.clone());
+        })
+        .await;
+
+    let (status, error) = follow_training(&state, &job_id, &training.id, &cancel).await;
+    finish(&state, &job_id, TRAIN_STAGE, status, error).await;
+}
+
+/// End `stage` and the job with `status`, skipping any later stages
+async fn finish(
+    state: &ServerState,
+    job_id: &str,
+    stage: usize,
+    status: DistillationStatus,
+    error: Option<String>,
+) {
+    state
+        .distillation
+        .update(job_id, |job| {
+            job.stages[stage].end(status, error.clone());
+            for later in job.stages.iter_mut().skip(stage + 1) {
+                later.end(DistillationStatus::Cancelled, None);
+            }
+            job.status = status;
+            job.error = error.clone();
+            job.finished_at = Some(chrono::Utc::now());
+        })
+        .await;
+
+    match status {
+        DistillationStatus::Failed => warn!(
+            "Distillation job {} failed: {}",
+            job_id,
+            error.as_deref().unwrap_or("unknown error")
+        ),
+        _ => info!("Distillation job {} {:?}", job_id, status),
+    }
+}
+
+// API Handlers
+
+/// `POST /v1/distillation/jobs` - start a distillation pipeline (admin only)
+pub async fn create_job(
+    State(state): State<Arc<ServerState>>,
+    headers: HeaderMap,
+    Json(request): Json<DistillationRequest>,
+) -> Response {
+    if let Err(response) = authorize_admin(&headers) {
+        return response;
+    }
+
+    if let Err((message, param)) = request.validate() {
+        return invalid_request(message, Some(param), None);
+    }
+    if let Err((message, _)) = fine_tuning::resolve_base_model(&state, &request.student_model).await
+    {
+        return invalid_request(message, Some("student_model"), None);
+    }
+
+    // The job ID doubles as the queue request ID of the generate stage
+    let ticket = state.request_queue.enqueue(
+        Some(format!("distill-{}", Uuid::new_v4())),
+        &request.teacher_model,
+        Priority::Low,
+    );
+    let id = ticket.id().to_string();
+
+    let dataset_name = request
+        .dataset_name
+        .clone()
+        .unwrap_or_else(|| id.chars().take("distill-".len() + 8).collect());
+
+    let cancel = Arc::new(CancelSignal::new());
+    let samples = request.prompts.len() as u64 * u64::from(request.samples_per_prompt);
+    let job = DistillationJob {
+        id: id.clone(),
+        object: "distillation.job".to_string(),
+        teacher_model: request.teacher_model.clone(),
+        student_model: request.student_model.clone(),
+        status: DistillationStatus::Pending,
+        stages: vec![
+            PipelineStage::new("generate", Some(samples)),
+            PipelineStage::new("train", None),
+        ],
+        dataset: None,
+        fine_tuning_job_id: None,
+        fine_tuned_model: None,
+        created_at: chrono::Utc::now(),
+        finished_at: None,
+        error: None,
+        cancel: Arc::clone(&cancel),
+    };
+    state.distillation.insert(job.clone()).await;
+
+    info!(
+        "Started distillation job {} from {} to {} over {} prompts",
+        id,
+        request.teacher_model,
+        request.student_model,
+        request.prompts.len()
+    );
+    tokio::spawn(execute_job(
+        Arc::clone(&state),
+        request,
+        dataset_name,
+        ticket,
+        cancel,
+    ));
+
+    (StatusCode::ACCEPTED, Json(job)).into_response()
+}
+
+/// `GET /v1/distillation/jobs` - all jobs, newest first (admin only)
+pub async fn list_jobs(State(state): State<Arc<ServerState>>, headers: HeaderMap) -> Response {
+    if let Err(response) = authorize_admin(&headers) {
+        return response;
+    }
+
+    Json(json!({
+        "object": "list",
+        "data": state.distillation.list().await
+    }))
+    .into_response()
+}
+
+/// `GET /v1/distillation/jobs/:job_id` - job and per-stage status (admin only)
+pub async fn get_job(
+    State(state): State<Arc<ServerState>>,
+    Path(job_id): Path<String>,
+    headers: HeaderMap,
+) -> Response {
+    if let Err(response) = authorize_admin(&headers) {
+        return response;
+    }
+
+    match state.distillation.get(&job_id).await {
+        Some(job) => Json(job).into_response(),
+        None => job_not_found(&job_id),
+    }
+}
+
+/// `POST /v1/distillation/jobs/:job_id/cancel` - stop whichever stage is
+/// running (admin only)
+pub async fn cancel_job(
+    State(state): State<Arc<ServerState>>,
+    Path(job_id): Path<String>,
+    headers: HeaderMap,
+) -> Response {
+    if let Err(response) = authorize_admin(&headers) {
+        return response;
+    }
+
+    match state.distillation.cancel(&job_id).await {
+        Some(job) => {
+            state.request_queue.cancel(&job_id);
+            Json(job).into_response()
+        }
+        None => job_not_found(&job_id),
+    }
+}
+
+fn job_not_found(job_id: &str) -> Response {
+    (
+        StatusCode::NOT_FOUND,
+        Json(json!({
+            "error": {
+                "message": format!("No distillation job with id {}", job_id),
+                "type": "invalid_request_error",
+                "param": "job_id",
+                "code": "distillation_job_not_found"
+            }
+        })),
+    )
+        .into_response()
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn request() -> DistillationRequest {
+        serde_json::from_value(json!({
+            "teacher_model": "llama-3-70b",
+            "student_model": "llama-3-8b",
+            "prompts": ["Explain TCP slow start."]
+        }))
+        .unwrap()
+    }
+
+    #[test]
+    fn applies_defaults() {
+        let request = request();
+        assert_eq!(request.samples_per_prompt, 1);
+        assert_eq!(request.max_tokens, 512);
+        assert!(request.register);
+        assert!(request.validate().is_ok());
+    }
+
+    #[test]
+    fn rejects_bad_requests() {
+        let mut empty = request();
+        empty.prompts.clear();
+        assert_eq!(empty.validate().unwrap_err().1, "prompts");
+
+        let mut samples = request();
+        samples.samples_per_prompt = 0;
+        assert_eq!(samples.validate().unwrap_err().1, "samples_per_prompt");
+
+        let mut suffix = request();
+        suffix.suffix = Some("../x".to_string());
+        assert_eq!(suffix.validate().unwrap_err().1, "suffix");
+    }
+
+    #[test]
+    fn builds_chat_records_the_dataset_validator_accepts() {
+        let record = chat_record(Some("Be brief."), "Hi", " Hello! \n");
+        let messages = record["messages"].as_array().unwrap();
+        assert_eq!(messages.len(), 3);
+        assert_eq!(messages[0]["role"], "system");
+        assert_eq!(messages[2]["content"], "Hello!");
+
+        let without_system = chat_record(None, "Hi", "Hello");
+        assert_eq!(without_system["messages"].as_array().unwrap().len(), 2);
+    }
+
+    #[test]
+    fn finished_stages_are_terminal() {
+        assert!(!DistillationStatus::Pending.is_finished());
+        assert!(!DistillationStatus::Running.is_finished());
+        assert!(DistillationStatus::Cancelled.is_finished());
+    }
+}
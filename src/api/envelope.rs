@@ -0,0 +1,231 @@
+//! Envelope Encryption
+//!
+//! Prompts carrying regulated data can cross shared infrastructure (load
+//! balancers, proxies, request logs) encrypted. The client generates a
+//! random data key, encrypts each prompt or message content with it and
+//! sends the data key wrapped under a key-encryption key it shares with the
+//! server, usually one held in a KMS:
+//!
+//! ```json
+//! "encryption": {"key_id": "prod-2024", "encrypted_key": "<base64>"}
+//! ```
+//!
+//! `encrypted_key` is base64 of a 12-byte nonce followed by the AES-256-GCM
+//! encryption of the 32-byte data key under the key-encryption key. An
+//! encrypted content string is `enc:v1:` followed by base64 of a fresh
+//! 12-byte nonce and the AES-256-GCM encryption of the text under the data
+//! key. Strings without the prefix are plain, so a request may mix them.
+//!
+//! The server reads key-encryption keys from `INFERNO_ENVELOPE_KEYS`, a
+//! comma-separated list of `key_id:base64-key` pairs; it does not call a
+//! KMS itself. Content is decrypted only when the prompt is built, so
+//! queued asynchronous jobs hold ciphertext, and encrypted requests skip the
+//! response cache and shadow replays.
+
+use aes_gcm::{
+    Aes256Gcm, Key, Nonce,
+    aead::{Aead, KeyInit},
+};
+use anyhow::Result;
+use base64::{Engine as _, engine::general_purpose};
+use serde::{Deserialize, Serialize};
+use std::collections::HashMap;
+
+/// Environment variable holding the key-encryption keys
+pub const KEYS_ENV: &str = "INFERNO_ENVELOPE_KEYS";
+
+/// Marks an encrypted content string
+pub const ENCRYPTED_PREFIX: &str = "enc:v1:";
+
+/// The only supported algorithm, for both the data key and the content
+pub const ALGORITHM: &str = "AES-256-GCM";
+
+const NONCE_LEN: usize = 12;
+
+/// The `encryption` field of a completion request
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct Envelope {
+    /// Which key-encryption key wrapped the data key
+    pub key_id: String,
+    /// The wrapped data key, base64
+    pub encrypted_key: String,
+    #[serde(default = "default_algorithm")]
+    pub algorithm: String,
+}
+
+fn default_algorithm() -> String {
+    ALGORITHM.to_string()
+}
+
+/// Key-encryption keys by id
+#[derive(Default)]
+pub struct EnvelopeKeys {
+    keys: HashMap<String, [u8; 32]>,
+}
+
+// Keys never reach logs, not even through Debug
+impl std::fmt::Debug for EnvelopeKeys {
+    fn fmt(&self, f: &mut std::fmt::Formatter<'_>) -> std::fmt::Result {
+        let mut ids: Vec<_> = self.keys.keys().collect();
+        ids.sort();
+        f.debug_struct("EnvelopeKeys")
+            .field("key_ids", &ids)
+            .finish()
+    }
+}
+
+impl EnvelopeKeys {
+    /// Keys from `INFERNO_ENVELOPE_KEYS`; none when it is unset
+    pub fn from_env() -> Result<Self> {
+        match std::env::var(KEYS_ENV) {
+            Ok(spec) => Self::parse(&spec),
+            Err(_) => Ok(Self::default()),
+        }
+    }
+
+    fn parse(spec: &str) -> Result<Self> {
+        let mut keys = HashMap::new();
+        for entry in spec.split(',').map(str::trim).filter(|e| !e.is_empty()) {
+            let (id, encoded) = entry
+                .split_once(':')
+                .ok_or_else(|| anyhow::anyhow!("{} entries must be key_id:base64-key", KEYS_ENV))?;
+            let bytes = general_purpose::STANDARD
+                .decode(encoded.trim())
+                .map_err(|e| anyhow::anyhow!("Invalid envelope key '{}': {}", id, e))?;
+            let key: [u8; 32] = bytes.try_into().map_err(|_| {
+                anyhow::anyhow!("Envelope key '{}' must be 32 bytes (256 bits)", id)
+            })?;
+            keys.insert(id.trim().to_string(), key);
+        }
+        Ok(Self { keys })
+    }
+
+    /// Unwrap the data key of `envelope`
+    pub fn open(&self, envelope: &Envelope) -> Result<DataKey, String> {
+        if envelope.algorithm != ALGORITHM {
+            return Err(format!(
+                "Unsupported encryption algorithm '{}'; expected {}",
+                envelope.algorithm, ALGORITHM
+            ));
+        }
+        let kek = self
+            .keys
+            .get(&envelope.key_id)
+            .ok_or_else(|| format!("Unknown encryption key_id '{}'", envelope.key_id))?;
+        let data_key = decrypt(kek, &envelope.encrypted_key)
+            .map_err(|e| format!("Cannot unwrap the data key: {}", e))?;
+        let data_key: [u8; 32] = data_key
+            .try_into()
+            .map_err(|_| "The data key must be 32 bytes (256 bits)".to_string())?;
+        Ok(DataKey(data_key))
+    }
+}
+
+/// An unwrapped data key
+pub struct DataKey([u8; 32]);
+
+impl DataKey {
+    /// Replace an encrypted `value` with its plaintext; plain values are
+    /// left as they are
+    pub fn open_in_place(&self, value: &mut String) -> Result<(), String> {
+        let Some(sealed) = value.strip_prefix(ENCRYPTED_PREFIX) else {
+            return Ok(());
+        };
+        let plaintext = decrypt(&self.0, sealed)?;
+        *value = String::from_utf8(plaintext)
+            .map_err(|_| "Decrypted content is not UTF-8".to_string())?;
+        Ok(())
+    }
+}
+
+/// True when `value` is encrypted content
+pub fn is_sealed(value: &str) -> bool {
+    value.starts_with(ENCRYPTED_PREFIX)
+}
+
+/// Decrypt the encrypted strings among `fields` with the data key of
+/// `envelope`. Errors name what is wrong, for a 400 on the `encryption`
+/// parameter.
+pub fn open_fields<'a>(
+    keys: &EnvelopeKeys,
+    envelope: Option<&Envelope>,
+    fields: impl IntoIterator<Item = &'a mut String>,
+) -> Result<(), String> {
+    let mut fields = fields.into_iter().filter(|field| is_sealed(field));
+    let Some(envelope) = envelope else {
+        return match fields.next() {
+            Some(_) => Err(format!(
+                "Content starting with '{}' is encrypted but the request has no encryption envelope",
+                ENCRYPTED_PREFIX
+            )),
+            None => Ok(()),
+        };
+    };
+    let data_key = keys.open(envelope)?;
+    for field in fields {
+        data_key.open_in_place(field)?;
+    }
+    Ok(())
+}
+
+/// Decrypt base64 of a nonce followed by AES-256-GCM ciphertext
+fn decrypt(key: &[u8; 32], encoded: &str) -> Result<Vec<u8>, String> {
+    let sealed = general_purpose::STANDARD
+        .decode(encoded)
+        .map_err(|e| format!("invalid base64: {}", e))?;
+    if sealed.len() < NONCE_LEN {
+        return Err("ciphertext is too short".to_string());
+    }
+    let (nonce, ciphertext) = sealed.split_at(NONCE_LEN);
+    Aes256Gcm::new(Key::<Aes256Gcm>::from_slice(key))
+        .decrypt(Nonce::from_slice(nonce), ciphertext)
+        .map_err(|_| "decryption failed".to_string())
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use aes_gcm::aead::{AeadCore, OsRng};
+
+    fn seal(key: &[u8; 32], plaintext: &[u8]) -> String {
+        let nonce = Aes256Gcm::generate_nonce(&mut OsRng);
+        let ciphertext = Aes256Gcm::new(Key::<Aes256Gcm>::from_slice(key))
+            .encrypt(&nonce, plaintext)
+            .unwrap();
+        general_purpose::STANDARD.encode([nonce.as_slice(), &ciphertext].concat())
+    }
+
+    #[test]
+    fn test_open_fields() {
+        let kek = [7u8; 32];
+        let data_key = [9u8; 32];
+        let keys = EnvelopeKeys::parse(&format!("prod:{}", general_purpose::STANDARD.encode(kek)))
+            .unwrap();
+        let envelope = Envelope {
+            key_id: "prod".to_string(),
+            encrypted_key: seal(&kek, &data_key),
+            algorithm: default_algorithm(),
+        };
+
+        let mut system = "You are terse.".to_string();
+        let mut user = format!("{}{}", ENCRYPTED_PREFIX, seal(&data_key, b"patient notes"));
+        open_fields(&keys, Some(&envelope), [&mut system, &mut user]).unwrap();
+        assert_eq!(system, "You are terse.");
+        assert_eq!(user, "patient notes");
+    }
+
+    #[test]
+    fn test_rejects_missing_envelope_and_unknown_key() {
+        let keys = EnvelopeKeys::default();
+        let mut sealed = format!("{}AAAA", ENCRYPTED_PREFIX);
+        assert!(open_fields(&keys, None, [&mut sealed]).is_err());
+
+        let envelope = Envelope {
+            key_id: "missing".to_string(),
+            encrypted_key: String::new(),
+            algorithm: default_algorithm(),
+        };
+        assert!(keys.open(&envelope).is_err());
+        assert!(EnvelopeKeys::parse("short:AAAA").is_err());
+    }
+}
@@ -0,0 +1,867 @@
+//! Evaluation Suites
+//!
+//! An eval suite is a list of cases, each a prompt plus either an expected
+//! output (graded by exact match, substring or regex) or a rubric that a
+//! judge model scores from 0 to 10. `POST /v1/evals/:suite_id/runs` runs a
+//! suite against one or more models in the background; the run reports
+//! per-model pass rates and mean scores, and per-case results are fetched
+//! separately so CI can gate a model upgrade on quality.
+//!
+//! A run is one low-priority entry in the request queue, identified by the
+//! run ID, so it can be cancelled with `POST /v1/inference/:run_id/cancel`.
+
+use crate::{
+    api::{
+        admin::authorize_admin,
+        cancellation::{FinishReason, generate_cancellable},
+        invalid_request,
+        openai::get_or_load_backend,
+        queue::QueueTicket,
+    },
+    backends::InferenceParams,
+    cli::serve::ServerState,
+    operations::queue::Priority,
+};
+use axum::{
+    Json,
+    extract::{Path, State},
+    http::{HeaderMap, StatusCode},
+    response::{IntoResponse, Response},
+};
+use regex::Regex;
+use serde::{Deserialize, Serialize};
+use serde_json::json;
+use std::{collections::HashMap, sync::Arc, time::Instant};
+use tokio::sync::RwLock;
+use tracing::{info, warn};
+use uuid::Uuid;
+
+/// Most cases a suite may hold
+const MAX_EVAL_CASES: usize = 1000;
+
+/// Most models a single run may compare
+const MAX_RUN_MODELS: usize = 8;
+
+/// Finished runs kept for retrieval; the oldest are dropped first
+const MAX_RETAINED_RUNS: usize = 200;
+
+fn default_max_tokens() -> u32 {
+    256
+}
+
+fn default_pass_threshold() -> f64 {
+    1.0
+}
+
+/// How a case's output is scored
+#[derive(Debug, Clone, Copy, Default, PartialEq, Eq, Serialize, Deserialize)]
+#[serde(rename_all = "snake_case")]
+pub enum Grader {
+    /// Output equals `expected` once surrounding whitespace is trimmed
+    #[default]
+    ExactMatch,
+    /// Output contains `expected`, ignoring case
+    Contains,
+    /// Output matches the regular expression in `expected`
+    Regex,
+    /// The suite's judge model scores the output against `rubric`
+    Rubric,
+}
+
+/// One prompt and how to grade the answer
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct EvalCase {
+    /// Stable identifier; defaults to `case-<n>`
+    #[serde(default)]
+    pub id: String,
+    pub prompt: String,
+    #[serde(default)]
+    pub expected: Option<String>,
+    #[serde(default)]
+    pub rubric: Option<String>,
+    #[serde(default)]
+    pub grader: Grader,
+    /// Overrides the suite's `max_tokens`
+    #[serde(default)]
+    pub max_tokens: Option<u32>,
+}
+
+/// Suite definition as submitted by clients
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct EvalSuiteSpec {
+    pub name: String,
+    #[serde(default)]
+    pub description: Option<String>,
+    pub cases: Vec<EvalCase>,
+    /// Model that scores rubric-graded cases
+    #[serde(default)]
+    pub judge_model: Option<String>,
+    /// Score (0 to 1) a case needs to count as passed
+    #[serde(default = "default_pass_threshold")]
+    pub pass_threshold: f64,
+    #[serde(default = "default_max_tokens")]
+    pub max_tokens: u32,
+}
+
+impl EvalSuiteSpec {
+    /// Check the suite and fill in default case IDs
+    pub fn validate(&mut self) -> Result<(), String> {
+        if self.name.trim().is_empty() {
+            return Err("name must not be empty".to_string());
+        }
+        if self.cases.is_empty() {
+            return Err("a suite needs at least one case".to_string());
+        }
+        if self.cases.len() > MAX_EVAL_CASES {
+            return Err(format!("a suite may hold at most {} cases", MAX_EVAL_CASES));
+        }
+        if !(0.0..=1.0).contains(&self.pass_threshold) {
+            return Err("pass_threshold must be between 0 and 1".to_string());
+        }
+        if self.max_tokens == 0 {
+            return Err("max_tokens must be positive".to_string());
+        }
+
+        let mut seen = std::collections::HashSet::new();
+        for (index, case) in self.cases.iter_mut().enumerate() {
+            if case.id.trim().is_empty() {
+                case.id = format!("case-{}", index + 1);
+            }
+            if !seen.insert(case.id.clone()) {
+                return Err(format!("duplicate case id {}", case.id));
+            }
+            if case.prompt.is_empty() {
+                return Err(format!("case {} has an empty prompt", case.id));
+            }
+
+            match case.grader {
+                Grader::Rubric => {
+                    if case.rubric.as_deref().is_none_or(|r| r.trim().is_empty()) {
+                        return Err(format!(
+                            "case {} is rubric-graded but has no rubric",
+                            case.id
+                        ));
+                    }
+                    if self.judge_model.is_none() {
+                        return Err(format!(
+                            "case {} is rubric-graded but the suite has no judge_model",
+                            case.id
+                        ));
+                    }
+                }
+                grader => {
+                    let Some(expected) = &case.expected else {
+                        return Err(format!("case {} needs an expected output", case.id));
+                    };
+                    if grader == Grader::Regex {
+                        Regex::new(expected)
+                            .map_err(|e| format!("case {} has an invalid regex: {}", case.id, e))?;
+                    }
+                }
+            }
+        }
+        Ok(())
+    }
+}
+
+/// A stored suite
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct EvalSuite {
+    pub id: String,
+    #[serde(flatten)]
+    pub spec: EvalSuiteSpec,
+    pub created_at: chrono::DateTime<chrono::Utc>,
+    pub updated_at: chrono::DateTime<chrono::Utc>,
+}
+
+/// Lifecycle state of an eval run
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Serialize, Deserialize)]
+#[serde(rename_all = "snake_case")]
+pub enum EvalRunStatus {
+    Queued,
+    Running,
+    Completed,
+    Failed,
+    Cancelled,
+}
+
+/// Graded output of one case against one model
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct EvalCaseResult {
+    pub case_id: String,
+    pub model: String,
+    pub output: String,
+    /// 0 to 1
+    pub score: f64,
+    pub passed: bool,
+    pub latency_ms: u64,
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub judge_output: Option<String>,
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub error: Option<String>,
+}
+
+/// Aggregate scores for one model in a run
+#[derive(Debug, Clone, Default, Serialize, Deserialize)]
+pub struct ModelEvalSummary {
+    pub model: String,
+    pub cases: usize,
+    pub passed: usize,
+    pub errors: usize,
+    pub pass_rate: f64,
+    pub mean_score: f64,
+}
+
+/// Run body for `POST /v1/evals/:suite_id/runs`
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct EvalRunRequest {
+    pub models: Vec<String>,
+}
+
+/// A suite run; per-case results are served by the results endpoint
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct EvalRun {
+    pub id: String,
+    pub object: String,
+    pub suite_id: String,
+    pub models: Vec<String>,
+    pub status: EvalRunStatus,
+    pub created_at: chrono::DateTime<chrono::Utc>,
+    pub started_at: Option<chrono::DateTime<chrono::Utc>>,
+    pub finished_at: Option<chrono::DateTime<chrono::Utc>>,
+    pub error: Option<String>,
+    pub summary: Vec<ModelEvalSummary>,
+    #[serde(skip)]
+    results: Vec<EvalCaseResult>,
+}
+
+impl EvalRun {
+    fn is_finished(&self) -> bool {
+        !matches!(self.status, EvalRunStatus::Queued | EvalRunStatus::Running)
+    }
+}
+
+/// In-memory store of suites and their runs
+#[derive(Debug, Default)]
+pub struct EvalStore {
+    suites: RwLock<HashMap<String, EvalSuite>>,
+    runs: RwLock<HashMap<String, EvalRun>>,
+}
+
+impl EvalStore {
+    pub fn new() -> Self {
+        Self::default()
+    }
+
+    pub async fn create_suite(&self, spec: EvalSuiteSpec) -> EvalSuite {
+        let now = chrono::Utc::now();
+        let suite = EvalSuite {
+            id: format!("eval-{}", Uuid::new_v4()),
+            spec,
+            created_at: now,
+            updated_at: now,
+        };
+        self.suites
+            .write()
+            .await
+            .insert(suite.id.clone(), suite.clone());
+        suite
+    }
+
+    /// Replace a suite's definition; `None` if it does not exist
+    pub async fn replace_suite(&self, id: &str, spec: EvalSuiteSpec) -> Option<EvalSuite> {
+        let mut suites = self.suites.write().await;
+        let suite = suites.get_mut(id)?;
+        suite.spec = spec;
+        suite.updated_at = chrono::Utc::now();
+        Some(suite.clone())
+    }
+
+    /// Remove a suite along with its runs
+    pub async fn remove_suite(&self, id: &str) -> bool {
+        self.runs.write().await.retain(|_, run| run.suite_id != id);
+        self.suites.write().await.remove(id).is_some()
+    }
+
+    pub async fn suite(&self, id: &str) -> Option<EvalSuite> {
+        self.suites.read().await.get(id).cloned()
+    }
+
+    pub async fn suites(&self) -> Vec<EvalSuite> {
+        let mut suites: Vec<EvalSuite> = self.suites.read().await.values().cloned().collect();
+        suites.sort_by(|a, b| a.created_at.cmp(&b.created_at));
+        suites
+    }
+
+    async fn insert_run(&self, run: EvalRun) {
+        let mut runs = self.runs.write().await;
+        if runs.len() >= MAX_RETAINED_RUNS {
+            let oldest = runs
+                .values()
+                .filter(|run| run.is_finished())
+                .min_by_key(|run| run.created_at)
+                .map(|run| run.id.clone());
+            if let Some(oldest) = oldest {
+                runs.remove(&oldest);
+            }
+        }
+        runs.insert(run.id.clone(), run);
+    }
+
+    async fn update_run<F: FnOnce(&mut EvalRun)>(&self, id: &str, f: F) {
+        if let Some(run) = self.runs.write().await.get_mut(id) {
+            f(run);
+        }
+    }
+
+    /// A run, provided it belongs to `suite_id`
+    pub async fn run(&self, suite_id: &str, run_id: &str) -> Option<EvalRun> {
+        self.runs
+            .read()
+            .await
+            .get(run_id)
+            .filter(|run| run.suite_id == suite_id)
+            .cloned()
+    }
+
+    pub async fn run_results(&self, suite_id: &str, run_id: &str) -> Option<Vec<EvalCaseResult>> {
+        self.run(suite_id, run_id).await.map(|run| run.results)
+    }
+
+    /// Runs of a suite, newest first
+    pub async fn runs(&self, suite_id: &str) -> Vec<EvalRun> {
+        let mut runs: Vec<EvalRun> = self
+            .runs
+            .read()
+            .await
+            .values()
+            .filter(|run| run.suite_id == suite_id)
+            .cloned()
+            .collect();
+        runs.sort_by(|a, b| b.created_at.cmp(&a.created_at));
+        runs
+    }
+}
+
+/// Score `output` for a case graded against an expected output
+fn grade(case: &EvalCase, output: &str) -> f64 {
+    let expected = case.expected.as_deref().unwrap_or_default();
+    let matched = match case.grader {
+        Grader::ExactMatch => output.trim() == expected.trim(),
+        Grader::Contains => output.to_lowercase().contains(&expected.to_lowercase()),
+        Grader::Regex => Regex::new(expected).is_ok_and(|re| re.is_match(output)),
+        Grader::Rubric => false,
+    };
+    if matched { 1.0 } else { 0.0 }
+}
+
+/// Prompt asking the judge model to score an answer against a rubric
+fn judge_prompt(case: &EvalCase, output: &str) -> String {
+    format!(
+        "You are grading an answer against a rubric.\n\n\
+         Rubric:\n{}\n\n\
+         Question:\n{}\n\n\
+         Answer:\n{}\n\n\
+         Reply with a single integer score from 0 (fails the rubric) to 10 \
+         (fully meets it).\nScore:",
+        case.rubric.as_deref().unwrap_or_default(),
+        case.prompt,
+        output
+    )
+}
+
+/// First number in the judge's reply, scaled from 0..=10 to 0..=1
+fn parse_judge_score(reply: &str) -> Option<f64> {
+    let number = Regex::new(r"\d+(\.\d+)?").ok()?;
+    let score: f64 = number.find(reply)?.as_str().parse().ok()?;
+    Some((score / 10.0).clamp(0.0, 1.0))
+}
+
+fn summarize(models: &[String], results: &[EvalCaseResult]) -> Vec<ModelEvalSummary> {
+    models
+        .iter()
+        .map(|model| {
+            let results: Vec<&EvalCaseResult> =
+                results.iter().filter(|r| &r.model == model).collect();
+            let cases = results.len();
+            let passed = results.iter().filter(|r| r.passed).count();
+            let n = cases.max(1) as f64;
+            ModelEvalSummary {
+                model: model.clone(),
+                cases,
+                passed,
+                errors: results.iter().filter(|r| r.error.is_some()).count(),
+                pass_rate: passed as f64 / n,
+                mean_score: results.iter().map(|r| r.score).sum::<f64>() / n,
+            }
+        })
+        .collect()
+}
+
+/// Generate deterministically for grading
+fn eval_params(max_tokens: u32) -> InferenceParams {
+    InferenceParams {
+        max_tokens,
+        temperature: 0.0,
+        top_k: 1,
+        seed: Some(0),
+        ..Default::default()
+    }
+}
+
+/// Run one case against `model`; `None` if the run was cancelled
+async fn run_case(
+    state: &Arc<ServerState>,
+    suite: &EvalSuite,
+    case: &EvalCase,
+    model: &str,
+    ticket: &QueueTicket,
+) -> Option<EvalCaseResult> {
+    let mut result = EvalCaseResult {
+        case_id: case.id.clone(),
+        model: model.to_string(),
+        output: String::new(),
+        score: 0.0,
+        passed: false,
+        latency_ms: 0,
+        judge_output: None,
+        error: None,
+    };
+
+    let backend = match get_or_load_backend(state, model).await {
+        Ok(backend) => backend,
+        Err(e) => {
+            result.error = Some(format!("Failed to load model: {}", e));
+            return Some(result);
+        }
+    };
+
+    let started = Instant::now();
+    let params = eval_params(case.max_tokens.unwrap_or(suite.spec.max_tokens));
+    let generation = generate_cancellable(
+        &backend,
+        &case.prompt,
+        &params,
+        ticket.cancel_signal(),
+        ticket.deadline(),
+    )
+    .await;
+    result.latency_ms = started.elapsed().as_millis() as u64;
+
+    match generation {
+        Ok(generation) if generation.finish_reason == FinishReason::Cancelled => return None,
+        Ok(generation) => result.output = generation.text,
+        Err(e) => {
+            result.error = Some(format!("Inference failed: {}", e));
+            return Some(result);
+        }
+    }
+
+    result.score = if case.grader == Grader::Rubric {
+        let judge_model = suite.spec.judge_model.as_deref().unwrap_or_default();
+        let judge = match get_or_load_backend(state, judge_model).await {
+            Ok(judge) => judge,
+            Err(e) => {
+                result.error = Some(format!("Failed to load judge model: {}", e));
+                return Some(result);
+            }
+        };
+        let reply = match generate_cancellable(
+            &judge,
+            &judge_prompt(case, &result.output),
+            &eval_params(8),
+            ticket.cancel_signal(),
+            ticket.deadline(),
+        )
+        .await
+        {
+            Ok(reply) if reply.finish_reason == FinishReason::Cancelled => return None,
+            Ok(reply) => reply.text,
+            Err(e) => {
+                result.error = Some(format!("Judge failed: {}", e));
+                return Some(result);
+            }
+        };
+        let score = parse_judge_score(&reply);
+        if score.is_none() {
+            result.error = Some("Judge reply did not contain a score".to_string());
+        }
+        result.judge_output = Some(reply);
+        score.unwrap_or(0.0)
+    } else {
+        grade(case, &result.output)
+    };
+    result.passed = result.error.is_none() && result.score >= suite.spec.pass_threshold;
+
+    Some(result)
+}
+
+/// Work through every case for every model, publishing progress as it goes
+async fn execute_run(
+    state: Arc<ServerState>,
+    suite: EvalSuite,
+    models: Vec<String>,
+    ticket: QueueTicket,
+) {
+    let store = &state.evals;
+    let run_id = ticket.id().to_string();
+
+    ticket.start().await;
+    store
+        .update_run(&run_id, |run| {
+            run.status = EvalRunStatus::Running;
+            run.started_at = Some(chrono::Utc::now());
+        })
+        .await;
+
+    let mut cancelled = false;
+    'models: for model in &models {
+        for case in &suite.spec.cases {
+            let Some(result) = run_case(&state, &suite, case, model, &ticket).await else {
+                cancelled = true;
+                break 'models;
+            };
+            if let Some(error) = &result.error {
+                warn!(
+                    "Eval run {} case {} on {}: {}",
+                    run_id, case.id, model, error
+                );
+            }
+            store
+                .update_run(&run_id, |run| {
+                    run.results.push(result);
+                    run.summary = summarize(&run.models, &run.results);
+                })
+                .await;
+        }
+    }
+
+    store
+        .update_run(&run_id, |run| {
+            run.finished_at = Some(chrono::Utc::now());
+            run.status = if cancelled {
+                EvalRunStatus::Cancelled
+            } else if !run.results.is_empty() && run.results.iter().all(|r| r.error.is_some()) {
+                run.error = Some("every case failed to run".to_string());
+                EvalRunStatus::Failed
+            } else {
+                EvalRunStatus::Completed
+            };
+        })
+        .await;
+
+    info!("Eval run {} finished", run_id);
+}
+
+// API Handlers
+
+/// `GET /v1/evals` - all suites
+pub async fn list_suites(State(state): State<Arc<ServerState>>) -> impl IntoResponse {
+    Json(json!({
+        "object": "list",
+        "data": state.evals.suites().await
+    }))
+}
+
+/// `POST /v1/evals` - create a suite (admin only)
+pub async fn create_suite(
+    State(state): State<Arc<ServerState>>,
+    headers: HeaderMap,
+    Json(mut spec): Json<EvalSuiteSpec>,
+) -> Response {
+    if let Err(response) = authorize_admin(&headers) {
+        return response;
+    }
+
+    if let Err(message) = spec.validate() {
+        return invalid_request(message, Some("cases"), None);
+    }
+
+    let suite = state.evals.create_suite(spec).await;
+    info!("Created eval suite {} ({})", suite.id, suite.spec.name);
+    (StatusCode::CREATED, Json(suite)).into_response()
+}
+
+/// `GET /v1/evals/:suite_id` - one suite with its cases
+pub async fn get_suite(
+    State(state): State<Arc<ServerState>>,
+    Path(suite_id): Path<String>,
+) -> Response {
+    match state.evals.suite(&suite_id).await {
+        Some(suite) => Json(suite).into_response(),
+        None => suite_not_found(&suite_id),
+    }
+}
+
+/// `PUT /v1/evals/:suite_id` - replace a suite's definition (admin only)
+pub async fn put_suite(
+    State(state): State<Arc<ServerState>>,
+    Path(suite_id): Path<String>,
+    headers: HeaderMap,
+    Json(mut spec): Json<EvalSuiteSpec>,
+) -> Response {
+    if let Err(response) = authorize_admin(&headers) {
+        return response;
+    }
+
+    if let Err(message) = spec.validate() {
+        return invalid_request(message, Some("cases"), None);
+    }
+
+    match state.evals.replace_suite(&suite_id, spec).await {
+        Some(suite) => Json(suite).into_response(),
+        None => suite_not_found(&suite_id),
+    }
+}
+
+/// `DELETE /v1/evals/:suite_id` - remove a suite and its runs (admin only)
+pub async fn delete_suite(
+    State(state): State<Arc<ServerState>>,
+    Path(suite_id): Path<String>,
+    headers: HeaderMap,
+) -> Response {
+    if let Err(response) = authorize_admin(&headers) {
+        return response;
+    }
+
+    if state.evals.remove_suite(&suite_id).await {
+        StatusCode::NO_CONTENT.into_response()
+    } else {
+        suite_not_found(&suite_id)
+    }
+}
+
+/// `POST /v1/evals/:suite_id/runs` - run a suite against models (admin only)
+pub async fn create_run(
+    State(state): State<Arc<ServerState>>,
+    Path(suite_id): Path<String>,
+    headers: HeaderMap,
+    Json(request): Json<EvalRunRequest>,
+) -> Response {
+    if let Err(response) = authorize_admin(&headers) {
+        return response;
+    }
+
+    let Some(suite) = state.evals.suite(&suite_id).await else {
+        return suite_not_found(&suite_id);
+    };
+
+    if request.models.is_empty() {
+        return invalid_request(
+            "models must name at least one model".to_string(),
+            Some("models"),
+            None,
+        );
+    }
+    if request.models.len() > MAX_RUN_MODELS {
+        return invalid_request(
+            format!("a run may compare at most {} models", MAX_RUN_MODELS),
+            Some("models"),
+            None,
+        );
+    }
+
+    // The run ID doubles as the queue request ID so the run can be cancelled
+    let ticket = state.request_queue.enqueue(
+        Some(format!("evalrun-{}", Uuid::new_v4())),
+        &request.models.join(","),
+        Priority::Low,
+    );
+
+    let run = EvalRun {
+        id: ticket.id().to_string(),
+        object: "eval.run".to_string(),
+        suite_id: suite.id.clone(),
+        models: request.models.clone(),
+        status: EvalRunStatus::Queued,
+        created_at: chrono::Utc::now(),
+        started_at: None,
+        finished_at: None,
+        error: None,
+        summary: summarize(&request.models, &[]),
+        results: Vec::new(),
+    };
+    state.evals.insert_run(run.clone()).await;
+
+    info!(
+        "Started eval run {} of suite {} against {}",
+        run.id,
+        suite.id,
+        request.models.join(", ")
+    );
+    tokio::spawn(execute_run(
+        Arc::clone(&state),
+        suite,
+        request.models,
+        ticket,
+    ));
+
+    (StatusCode::ACCEPTED, Json(run)).into_response()
+}
+
+/// `GET /v1/evals/:suite_id/runs` - runs of a suite, newest first
+pub async fn list_runs(
+    State(state): State<Arc<ServerState>>,
+    Path(suite_id): Path<String>,
+) -> Response {
+    if state.evals.suite(&suite_id).await.is_none() {
+        return suite_not_found(&suite_id);
+    }
+
+    Json(json!({
+        "object": "list",
+        "data": state.evals.runs(&suite_id).await
+    }))
+    .into_response()
+}
+
+/// `GET /v1/evals/:suite_id/runs/:run_id` - run status and per-model scores
+pub async fn get_run(
+    State(state): State<Arc<ServerState>>,
+    Path((suite_id, run_id)): Path<(String, String)>,
+) -> Response {
+    match state.evals.run(&suite_id, &run_id).await {
+        Some(run) => Json(run).into_response(),
+        None => run_not_found(&run_id),
+    }
+}
+
+/// `GET /v1/evals/:suite_id/runs/:run_id/results` - per-case results so far
+pub async fn run_results(
+    State(state): State<Arc<ServerState>>,
+    Path((suite_id, run_id)): Path<(String, String)>,
+) -> Response {
+    match state.evals.run_results(&suite_id, &run_id).await {
+        Some(results) => Json(json!({
+            "object": "list",
+            "data": results
+        }))
+        .into_response(),
+        None => run_not_found(&run_id),
+    }
+}
+
+fn suite_not_found(suite_id: &str) -> Response {
+    (
+        StatusCode::NOT_FOUND,
+        Json(json!({
+            "error": {
+                "message": format!("No eval suite with id {}", suite_id),
+                "type": "invalid_request_error",
+                "param": "suite_id",
+                "code": "eval_suite_not_found"
+            }
+        })),
+    )
+        .into_response()
+}
+
+fn run_not_found(run_id: &str) -> Response {
+    (
+        StatusCode::NOT_FOUND,
+        Json(json!({
+            "error": {
+                "message": format!("No eval run with id {}", run_id),
+                "type": "invalid_request_error",
+                "param": "run_id",
+                "code": "eval_run_not_found"
+            }
+        })),
+    )
+        .into_response()
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn case(grader: Grader, expected: &str) -> EvalCase {
+        EvalCase {
+            id: String::new(),
+            prompt: "What is the capital of France?".to_string(),
+            expected: Some(expected.to_string()),
+            rubric: None,
+            grader,
+            max_tokens: None,
+        }
+    }
+
+    fn spec(cases: Vec<EvalCase>) -> EvalSuiteSpec {
+        EvalSuiteSpec {
+            name: "geography".to_string(),
+            description: None,
+            cases,
+            judge_model: None,
+            pass_threshold: default_pass_threshold(),
+            max_tokens: default_max_tokens(),
+        }
+    }
+
+    #[test]
+    fn test_validate_assigns_ids_and_checks_graders() {
+        let mut suite = spec(vec![
+            case(Grader::ExactMatch, "Paris"),
+            case(Grader::Contains, "paris"),
+        ]);
+        assert!(suite.validate().is_ok());
+        assert_eq!(suite.cases[0].id, "case-1");
+        assert_eq!(suite.cases[1].id, "case-2");
+
+        let mut bad_regex = spec(vec![case(Grader::Regex, "(unclosed")]);
+        assert!(bad_regex.validate().is_err());
+
+        let mut rubric = spec(vec![EvalCase {
+            rubric: Some("Names Paris".to_string()),
+            ..case(Grader::Rubric, "")
+        }]);
+        assert!(rubric.validate().is_err());
+        rubric.judge_model = Some("judge".to_string());
+        assert!(rubric.validate().is_ok());
+    }
+
+    #[test]
+    fn test_grade() {
+        assert_eq!(grade(&case(Grader::ExactMatch, "Paris"), " Paris\n"), 1.0);
+        assert_eq!(
+            grade(&case(Grader::ExactMatch, "Paris"), "It is Paris"),
+            0.0
+        );
+        assert_eq!(grade(&case(Grader::Contains, "paris"), "It is Paris."), 1.0);
+        assert_eq!(
+            grade(&case(Grader::Regex, r"^\s*Paris\b"), "Paris, France"),
+            1.0
+        );
+        assert_eq!(grade(&case(Grader::Regex, r"^\s*Paris\b"), "Lyon"), 0.0);
+    }
+
+    #[test]
+    fn test_parse_judge_score() {
+        assert_eq!(parse_judge_score(" 7\n"), Some(0.7));
+        assert_eq!(parse_judge_score("Score: 10/10"), Some(1.0));
+        assert_eq!(parse_judge_score("42"), Some(1.0));
+        assert_eq!(parse_judge_score("excellent"), None);
+    }
+
+    #[test]
+    fn test_summarize() {
+        let models = vec!["a".to_string(), "b".to_string()];
+        let result = |model: &str, score: f64| EvalCaseResult {
+            case_id: "case-1".to_string(),
+            model: model.to_string(),
+            output: String::new(),
+            score,
+            passed: score >= 1.0,
+            latency_ms: 0,
+            judge_output: None,
+            error: None,
+        };
+        let summary = summarize(
+            &models,
+            &[result("a", 1.0), result("a", 0.0), result("b", 1.0)],
+        );
+        assert_eq!(summary[0].cases, 2);
+        assert_eq!(summary[0].passed, 1);
+        assert!((summary[0].pass_rate - 0.5).abs() < 1e-9);
+        assert!((summary[1].mean_score - 1.0).abs() < 1e-9);
+    }
+}
@@ -0,0 +1,283 @@
+//! Likelihood-based Evaluation
+//!
+//! `POST /v1/models/:model_id/evaluate/perplexity` scores a text corpus under
+//! a model and reports per-document and corpus perplexity, which gives an
+//! objective way to compare quantizations of the same model. Scoring a
+//! single continuation is also available inline on `/v1/completions` via the
+//! request's `score` field. Both need a backend that exposes token
+//! probabilities; others answer with a 400.
+
+use crate::{
+    api::{cancellation::with_request_id, openai::get_or_load_backend},
+    backends::{BackendHandle, ScoredText, TokenLogprob},
+    cli::serve::ServerState,
+    operations::queue::Priority,
+};
+use axum::{
+    Json,
+    extract::{Path, State},
+    http::{HeaderMap, StatusCode},
+    response::{IntoResponse, Response},
+};
+use serde::{Deserialize, Serialize};
+use serde_json::json;
+use std::{sync::Arc, time::Instant};
+use tracing::info;
+
+/// Most documents a single perplexity request may score
+const MAX_PERPLEXITY_DOCUMENTS: usize = 1000;
+
+/// Corpus to score
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct PerplexityRequest {
+    /// Documents scored independently; each must fit the context window
+    pub texts: Vec<String>,
+    /// Include per-token log-probabilities in each document's result
+    #[serde(default)]
+    pub include_tokens: bool,
+}
+
+impl PerplexityRequest {
+    pub fn validate(&self) -> Result<(), String> {
+        if self.texts.is_empty() {
+            return Err("texts must contain at least one document".to_string());
+        }
+        if self.texts.len() > MAX_PERPLEXITY_DOCUMENTS {
+            return Err(format!(
+                "at most {} documents may be scored per request",
+                MAX_PERPLEXITY_DOCUMENTS
+            ));
+        }
+        Ok(())
+    }
+}
+
+/// Score of one document in the corpus
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct DocumentScore {
+    pub index: usize,
+    /// Tokens scored (the BOS token is context, not scored)
+    pub tokens: u32,
+    pub log_likelihood: f64,
+    pub perplexity: Option<f64>,
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub token_logprobs: Option<Vec<TokenLogprob>>,
+    /// Why the document could not be scored; it is left out of the totals
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub error: Option<String>,
+}
+
+/// Corpus-level result of `POST /v1/models/:model_id/evaluate/perplexity`
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct PerplexityReport {
+    pub model: String,
+    pub documents: Vec<DocumentScore>,
+    /// Tokens scored across every successfully scored document
+    pub total_tokens: u64,
+    pub log_likelihood: f64,
+    /// `exp(-log_likelihood / total_tokens)`; `None` when nothing was scored
+    pub perplexity: Option<f64>,
+    pub duration_ms: u64,
+    /// Set when the run stopped early, e.g. because it was cancelled
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub incomplete: Option<String>,
+}
+
+impl DocumentScore {
+    fn scored(index: usize, scored: ScoredText, include_tokens: bool) -> Self {
+        Self {
+            index,
+            tokens: scored.tokens.len() as u32,
+            log_likelihood: scored.log_likelihood(),
+            perplexity: scored.perplexity(),
+            token_logprobs: include_tokens.then_some(scored.tokens),
+            error: None,
+        }
+    }
+
+    fn failed(index: usize, error: String) -> Self {
+        Self {
+            index,
+            tokens: 0,
+            log_likelihood: 0.0,
+            perplexity: None,
+            token_logprobs: None,
+            error: Some(error),
+        }
+    }
+}
+
+/// Aggregate document scores into corpus totals
+fn corpus_totals(documents: &[DocumentScore]) -> (u64, f64, Option<f64>) {
+    let scored = documents.iter().filter(|d| d.error.is_none());
+    let total_tokens: u64 = scored.clone().map(|d| d.tokens as u64).sum();
+    let log_likelihood: f64 = scored.map(|d| d.log_likelihood).sum();
+    let perplexity = (total_tokens > 0).then(|| (-log_likelihood / total_tokens as f64).exp());
+    (total_tokens, log_likelihood, perplexity)
+}
+
+// API Handlers
+
+/// `POST /v1/models/:model_id/evaluate/perplexity` - score a text corpus
+pub async fn evaluate_perplexity(
+    State(state): State<Arc<ServerState>>,
+    Path(model_id): Path<String>,
+    headers: HeaderMap,
+    Json(request): Json<PerplexityRequest>,
+) -> Response {
+    if let Err(message) = request.validate() {
+        return (
+            StatusCode::BAD_REQUEST,
+            Json(json!({
+                "error": {
+                    "message": message,
+                    "type": "invalid_request_error",
+                    "param": "texts",
+                    "code": null
+                }
+            })),
+        )
+            .into_response();
+    }
+
+    let ticket = match state
+        .request_queue
+        .enqueue_request(&headers, &model_id, Priority::Low)
+    {
+        Ok(ticket) => ticket.with_scheduling(None, &headers),
+        Err(response) => return response,
+    };
+    let request_id = ticket.id().to_string();
+
+    let backend = match get_or_load_backend(&state, &model_id).await {
+        Ok(backend) => backend,
+        Err(e) => {
+            return (
+                StatusCode::BAD_REQUEST,
+                Json(json!({
+                    "error": {
+                        "message": format!("Failed to load model: {}", e),
+                        "type": "invalid_request_error",
+                        "param": "model_id",
+                        "code": null
+                    }
+                })),
+            )
+                .into_response();
+        }
+    };
+
+    if !backend.supports_scoring() {
+        return scoring_not_supported(&backend);
+    }
+
+    ticket.start().await;
+    info!(
+        "Scoring {} documents with {} ({})",
+        request.texts.len(),
+        model_id,
+        request_id
+    );
+
+    let started = Instant::now();
+    let mut documents = Vec::with_capacity(request.texts.len());
+    let mut incomplete = None;
+
+    for (index, text) in request.texts.iter().enumerate() {
+        if ticket.cancel_signal().is_cancelled() {
+            incomplete = Some("evaluation cancelled".to_string());
+            break;
+        }
+
+        let document = match backend.score("", text).await {
+            Ok(scored) => DocumentScore::scored(index, scored, request.include_tokens),
+            Err(e) => DocumentScore::failed(index, e.to_string()),
+        };
+        documents.push(document);
+    }
+
+    let (total_tokens, log_likelihood, perplexity) = corpus_totals(&documents);
+    let report = PerplexityReport {
+        model: model_id,
+        documents,
+        total_tokens,
+        log_likelihood,
+        perplexity,
+        duration_ms: started.elapsed().as_millis() as u64,
+        incomplete,
+    };
+
+    with_request_id(Json(report).into_response(), &request_id)
+}
+
+/// 400 for a backend that cannot report token probabilities
+pub(crate) fn scoring_not_supported(backend: &BackendHandle) -> Response {
+    (
+        StatusCode::BAD_REQUEST,
+        Json(json!({
+            "error": {
+                "message": format!(
+                    "The {} backend does not support log-likelihood scoring",
+                    backend.get_backend_type()
+                ),
+                "type": "invalid_request_error",
+                "param": "model",
+                "code": "scoring_not_supported"
+            }
+        })),
+    )
+        .into_response()
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn scored(logprobs: &[f32]) -> ScoredText {
+        ScoredText {
+            context_tokens: 1,
+            tokens: logprobs
+                .iter()
+                .map(|&logprob| TokenLogprob {
+                    token: "x".to_string(),
+                    logprob,
+                })
+                .collect(),
+        }
+    }
+
+    #[test]
+    fn test_validate() {
+        let empty = PerplexityRequest {
+            texts: vec![],
+            include_tokens: false,
+        };
+        assert!(empty.validate().is_err());
+
+        let one = PerplexityRequest {
+            texts: vec!["hello".to_string()],
+            include_tokens: false,
+        };
+        assert!(one.validate().is_ok());
+    }
+
+    #[test]
+    fn test_corpus_perplexity_weights_by_tokens() {
+        let half = (0.5f32).ln();
+        let quarter = (0.25f32).ln();
+        let documents = vec![
+            DocumentScore::scored(0, scored(&[half, half]), false),
+            DocumentScore::scored(1, scored(&[quarter]), false),
+            DocumentScore::failed(2, "too long".to_string()),
+        ];
+
+        assert!((documents[0].perplexity.unwrap() - 2.0).abs() < 1e-6);
+        assert!((documents[1].perplexity.unwrap() - 4.0).abs() < 1e-6);
+
+        let (total_tokens, log_likelihood, perplexity) = corpus_totals(&documents);
+        assert_eq!(total_tokens, 3);
+        assert!((log_likelihood - (2.0 * half as f64 + quarter as f64)).abs() < 1e-6);
+        // exp(-(ln 1/16) / 3) = 16^(1/3)
+        assert!((perplexity.unwrap() - 16f64.powf(1.0 / 3.0)).abs() < 1e-6);
+    }
+}
@@ -0,0 +1,624 @@
+//! Structured Extraction
+//!
+//! `POST /v1/extract` pulls named entities and typed fields out of a text.
+//! The model is asked for a JSON object in a fixed shape; the reply is
+//! parsed and checked against the requested schema, and an invalid reply is
+//! sent back with the error for another attempt. Entities must be copied
+//! verbatim so each can be given its character offsets in the text, and on
+//! backends that support scoring every value carries a confidence: the
+//! geometric-mean probability of the tokens the model spelled it with.
+
+use crate::{
+    api::{
+        cancellation::{FinishReason, generate_cancellable, with_request_id},
+        deadline::resolve_deadline,
+        invalid_request,
+        openai::get_or_load_backend,
+        queue::priority_from_headers,
+    },
+    backends::{BackendHandle, InferenceParams, ScoredText},
+    cli::serve::ServerState,
+};
+use axum::{
+    Json,
+    extract::State,
+    http::{HeaderMap, StatusCode},
+    response::{IntoResponse, Response},
+};
+use serde::{Deserialize, Serialize};
+use serde_json::{Value, json};
+use std::{
+    collections::{BTreeMap, HashSet},
+    sync::Arc,
+};
+
+/// Most entity types plus fields one request may ask for
+const MAX_EXTRACT_TARGETS: usize = 64;
+
+/// Most attempts after the first when a reply does not fit the schema
+const MAX_EXTRACT_RETRIES: u32 = 5;
+
+fn default_max_tokens() -> u32 {
+    1024
+}
+
+fn default_max_retries() -> u32 {
+    2
+}
+
+/// Type a field's value must have
+#[derive(Debug, Clone, Copy, Default, PartialEq, Eq, Serialize, Deserialize)]
+#[serde(rename_all = "lowercase")]
+pub enum FieldType {
+    #[default]
+    String,
+    Number,
+    Integer,
+    Boolean,
+    /// A calendar date, `YYYY-MM-DD`
+    Date,
+}
+
+impl FieldType {
+    fn as_str(&self) -> &'static str {
+        match self {
+            FieldType::String => "string",
+            FieldType::Number => "number",
+            FieldType::Integer => "integer",
+            FieldType::Boolean => "boolean",
+            FieldType::Date => "date, YYYY-MM-DD",
+        }
+    }
+
+    fn accepts(&self, value: &Value) -> bool {
+        match self {
+            FieldType::String => value.is_string(),
+            FieldType::Number => value.is_number(),
+            FieldType::Integer => value.is_i64() || value.is_u64(),
+            FieldType::Boolean => value.is_boolean(),
+            FieldType::Date => value
+                .as_str()
+                .is_some_and(|date| chrono::NaiveDate::parse_from_str(date, "%Y-%m-%d").is_ok()),
+        }
+    }
+}
+
+/// A kind of entity to find every mention of
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct EntitySpec {
+    #[serde(rename = "type")]
+    pub entity_type: String,
+    #[serde(default)]
+    pub description: Option<String>,
+}
+
+/// A single value to read from the text
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct FieldSpec {
+    pub name: String,
+    #[serde(rename = "type", default)]
+    pub field_type: FieldType,
+    #[serde(default)]
+    pub description: Option<String>,
+    /// Reject replies that leave the field null
+    #[serde(default)]
+    pub required: bool,
+}
+
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct ExtractRequest {
+    pub model: String,
+    pub text: String,
+    #[serde(default)]
+    pub entities: Vec<EntitySpec>,
+    #[serde(default)]
+    pub fields: Vec<FieldSpec>,
+    #[serde(default = "default_max_tokens")]
+    pub max_tokens: u32,
+    /// Further attempts when a reply does not fit the schema (at most 5)
+    #[serde(default = "default_max_retries")]
+    pub max_retries: u32,
+    /// Server-enforced time budget for all attempts, in milliseconds
+    #[serde(default)]
+    pub timeout_ms: Option<u64>,
+}
+
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct ExtractedEntity {
+    #[serde(rename = "type")]
+    pub entity_type: String,
+    pub text: String,
+    /// Character offsets of the mention in the input, end exclusive
+    pub start: usize,
+    pub end: usize,
+    pub confidence: Option<f32>,
+}
+
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct ExtractedField {
+    /// `null` when the text does not state the field
+    pub value: Value,
+    /// Character offsets when the value appears verbatim in the input
+    pub start: Option<usize>,
+    pub end: Option<usize>,
+    pub confidence: Option<f32>,
+}
+
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct ExtractResponse {
+    pub model: String,
+    pub entities: Vec<ExtractedEntity>,
+    pub fields: BTreeMap<String, ExtractedField>,
+    /// Generations it took to get a reply that fits the schema
+    pub attempts: u32,
+}
+
+/// The reply shape the model is asked for
+#[derive(Debug, Deserialize)]
+struct ModelReply {
+    #[serde(default)]
+    entities: Vec<ReplyEntity>,
+    #[serde(default)]
+    fields: serde_json::Map<String, Value>,
+}
+
+#[derive(Debug, Deserialize)]
+struct ReplyEntity {
+    #[serde(rename = "type")]
+    entity_type: String,
+    text: String,
+}
+
+impl ExtractRequest {
+    fn validate(&self) -> Result<(), (String, &'static str)> {
+        if self.text.trim().is_empty() {
+            return Err(("text must not be empty".to_string(), "text"));
+        }
+        if self.entities.is_empty() && self.fields.is_empty() {
+            return Err((
+                "Ask for at least one entity type or field".to_string(),
+                "fields",
+            ));
+        }
+        if self.entities.len() + self.fields.len() > MAX_EXTRACT_TARGETS {
+            return Err((
+                format!(
+                    "At most {} entity types and fields may be extracted at once",
+                    MAX_EXTRACT_TARGETS
+                ),
+                "fields",
+            ));
+        }
+        let mut names = HashSet::new();
+        if let Some(field) = self.fields.iter().find(|f| !names.insert(f.name.as_str())) {
+            return Err((format!("Field {} is listed twice", field.name), "fields"));
+        }
+        if self.max_retries > MAX_EXTRACT_RETRIES {
+            return Err((
+                format!("max_retries must be at most {}", MAX_EXTRACT_RETRIES),
+                "max_retries",
+            ));
+        }
+        Ok(())
+    }
+
+    fn prompt(&self) -> String {
+        let mut prompt = String::from(
+            "Extract information from the text below. Reply with one JSON object and \
+             nothing else, in this form:\n\
+             {\"entities\": [{\"type\": \"<entity type>\", \"text\": \"<mention copied exactly from the text>\"}], \
+             \"fields\": {\"<field name>\": <value or null>}}\n",
+        );
+        if !self.entities.is_empty() {
+            prompt.push_str("\nEntity types (list every mention, in order of appearance):\n");
+            for entity in &self.entities {
+                prompt.push_str(&format!("- {}", entity.entity_type));
+                if let Some(description) = &entity.description {
+                    prompt.push_str(&format!(": {}", description));
+                }
+                prompt.push('\n');
+            }
+        }
+        if !self.fields.is_empty() {
+            prompt.push_str("\nFields (use null when the text does not say):\n");
+            for field in &self.fields {
+                prompt.push_str(&format!("- {} ({}", field.name, field.field_type.as_str()));
+                if field.required {
+                    prompt.push_str(", required");
+                }
+                prompt.push(')');
+                if let Some(description) = &field.description {
+                    prompt.push_str(&format!(": {}", description));
+                }
+                prompt.push('\n');
+            }
+        }
+        prompt.push_str(&format!("\nText:\n\"\"\"\n{}\n\"\"\"\n\nJSON:", self.text));
+        prompt
+    }
+
+    /// Parse and check a reply against the requested schema
+    fn parse_reply(&self, output: &str) -> Result<ModelReply, String> {
+        let json = json_object(output).ok_or("the reply contains no JSON object")?;
+        let mut reply: ModelReply = serde_json::from_str(json)
+            .map_err(|e| format!("the reply is not valid JSON: {}", e))?;
+
+        let types: HashSet<&str> = self
+            .entities
+            .iter()
+            .map(|e| e.entity_type.as_str())
+            .collect();
+        if let Some(entity) = reply
+            .entities
+            .iter()
+            .find(|e| !types.contains(e.entity_type.as_str()))
+        {
+            return Err(format!("unknown entity type {:?}", entity.entity_type));
+        }
+        // Mentions not copied from the text have no offsets and are most
+        // likely invented, so they are left out rather than retried
+        reply
+            .entities
+            .retain(|e| !e.text.is_empty() && self.text.contains(&e.text));
+
+        for field in &self.fields {
+            match reply.fields.get(&field.name).unwrap_or(&Value::Null) {
+                Value::Null if field.required => {
+                    return Err(format!("field {} is required", field.name));
+                }
+                Value::Null => {}
+                value if !field.field_type.accepts(value) => {
+                    return Err(format!(
+                        "field {} must be a {}, not {}",
+                        field.name,
+                        field.field_type.as_str(),
+                        value
+                    ));
+                }
+                _ => {}
+            }
+        }
+        Ok(reply)
+    }
+}
+
+/// The outermost `{...}` of a reply, skipping code fences and chatter
+fn json_object(output: &str) -> Option<&str> {
+    let start = output.find('{')?;
+    let end = output.rfind('}')?;
+    (start < end).then(|| &output[start..=end])
+}
+
+/// Character offsets of `needle` in `text`, searching from byte `from`
+fn char_span(text: &str, needle: &str, from: usize) -> Option<(usize, usize, usize)> {
+    let start = text.get(from..)?.find(needle)? + from;
+    let end = start + needle.len();
+    let char_start = text[..start].chars().count();
+    let char_end = char_start + needle.chars().count();
+    Some((char_start, char_end, end))
+}
+
+/// Geometric-mean probability of the scored tokens covering bytes
+/// `start..end` of the reply
+fn span_confidence(scored: &ScoredText, start: usize, end: usize) -> Option<f32> {
+    let mut offset = 0;
+    let mut logprobs = Vec::new();
+    for token in &scored.tokens {
+        let token_end = offset + token.token.len();
+        if token_end > start && offset < end {
+            logprobs.push(token.logprob);
+        }
+        offset = token_end;
+    }
+    if logprobs.is_empty() {
+        return None;
+    }
+    Some((logprobs.iter().sum::<f32>() / logprobs.len() as f32).exp())
+}
+
+/// Confidence of a JSON value spelled in the reply after byte `from`
+fn value_confidence(
+    scored: Option<&ScoredText>,
+    output: &str,
+    value: &Value,
+    from: usize,
+) -> (Option<f32>, usize) {
+    let Some(scored) = scored else {
+        return (None, from);
+    };
+    let encoded = value.to_string();
+    match output.get(from..).and_then(|rest| rest.find(&encoded)) {
+        Some(at) => {
+            let start = from + at;
+            let end = start + encoded.len();
+            (span_confidence(scored, start, end), end)
+        }
+        None => (None, from),
+    }
+}
+
+fn build_response(
+    request: &ExtractRequest,
+    reply: ModelReply,
+    output: &str,
+    scored: Option<&ScoredText>,
+    attempts: u32,
+) -> ExtractResponse {
+    // Repeated mentions take successive occurrences in the text
+    let mut text_cursor: BTreeMap<&str, usize> = BTreeMap::new();
+    let mut reply_cursor = 0;
+    let mut entities = Vec::with_capacity(reply.entities.len());
+    for entity in &reply.entities {
+        let from = text_cursor.get(entity.text.as_str()).copied().unwrap_or(0);
+        let Some((start, end, next)) = char_span(&request.text, &entity.text, from)
+            .or_else(|| char_span(&request.text, &entity.text, 0))
+        else {
+            continue;
+        };
+        text_cursor.insert(&entity.text, next);
+
+        let (confidence, after) = value_confidence(
+            scored,
+            output,
+            &Value::String(entity.text.clone()),
+            reply_cursor,
+        );
+        reply_cursor = after;
+        entities.push(ExtractedEntity {
+            entity_type: entity.entity_type.clone(),
+            text: entity.text.clone(),
+            start,
+            end,
+            confidence,
+        });
+    }
+
+    let mut fields = BTreeMap::new();
+    for field in &request.fields {
+        let value = reply
+            .fields
+            .get(&field.name)
+            .cloned()
+            .unwrap_or(Value::Null);
+        let span = value
+            .as_str()
+            .and_then(|text| char_span(&request.text, text, 0));
+        let confidence = if value.is_null() {
+            None
+        } else {
+            let key = Value::String(field.name.clone()).to_string();
+            let from = output.find(&key).map_or(0, |at| at + key.len());
+            value_confidence(scored, output, &value, from).0
+        };
+        fields.insert(
+            field.name.clone(),
+            ExtractedField {
+                value,
+                start: span.map(|(start, _, _)| start),
+                end: span.map(|(_, end, _)| end),
+                confidence,
+            },
+        );
+    }
+
+    ExtractResponse {
+        model: request.model.clone(),
+        entities,
+        fields,
+        attempts,
+    }
+}
+
+fn extraction_error(status: StatusCode, message: String, code: &str, output: &str) -> Response {
+    (
+        status,
+        Json(json!({
+            "error": {
+                "message": message,
+                "type": "extraction_error",
+                "param": null,
+                "code": code,
+                "output": output
+            }
+        })),
+    )
+        .into_response()
+}
+
+/// Score the model's own reply so values can be given confidences
+async fn score_reply(backend: &BackendHandle, prompt: &str, output: &str) -> Option<ScoredText> {
+    if !backend.supports_scoring() {
+        return None;
+    }
+    backend.score(prompt, output).await.ok()
+}
+
+// API Handlers
+
+/// `POST /v1/extract` - entities and typed fields from a text
+pub async fn extract(
+    State(state): State<Arc<ServerState>>,
+    headers: HeaderMap,
+    Json(request): Json<ExtractRequest>,
+) -> Response {
+    if let Err(response) = state.flags.require("extract", &headers).await {
+        return response;
+    }
+    if let Err((message, param)) = request.validate() {
+        return invalid_request(message, Some(param), None);
+    }
+
+    let ticket = match state.request_queue.enqueue_request(
+        &headers,
+        &request.model,
+        priority_from_headers(&headers),
+    ) {
+        Ok(ticket) => ticket
+            .with_scheduling(None, &headers)
+            .with_deadline(resolve_deadline(request.timeout_ms, None)),
+        Err(response) => return response,
+    };
+    let request_id = ticket.id().to_string();
+
+    let backend = match get_or_load_backend(&state, &request.model).await {
+        Ok(backend) => backend,
+        Err(e) => {
+            return invalid_request(format!("Failed to load model: {}", e), Some("model"), None);
+        }
+    };
+
+    // Greedy decoding: extraction wants the most likely reading, not variety
+    let params = InferenceParams {
+        max_tokens: request.max_tokens,
+        temperature: 0.0,
+        top_k: 1,
+        seed: Some(0),
+        ..Default::default()
+    };
+
+    ticket.start().await;
+    let mut prompt = request.prompt();
+    let mut attempts = 0;
+    let response = loop {
+        attempts += 1;
+        let generation = match generate_cancellable(
+            &backend,
+            &prompt,
+            &params,
+            ticket.cancel_signal(),
+            ticket.deadline(),
+        )
+        .await
+        {
+            Ok(generation) => generation,
+            Err(e) => {
+                return extraction_error(
+                    StatusCode::INTERNAL_SERVER_ERROR,
+                    format!("Inference failed: {}", e),
+                    "inference_failed",
+                    "",
+                );
+            }
+        };
+        if generation.finish_reason != FinishReason::Stop {
+            let reason = generation.finish_reason.as_str();
+            return extraction_error(
+                StatusCode::REQUEST_TIMEOUT,
+                format!("Extraction stopped early: {}", reason),
+                reason,
+                &generation.text,
+            );
+        }
+
+        match request.parse_reply(&generation.text) {
+            Ok(reply) => {
+                let scored = score_reply(&backend, &prompt, &generation.text).await;
+                break build_response(&request, reply, &generation.text, scored.as_ref(), attempts);
+            }
+            Err(error) if attempts > request.max_retries => {
+                return extraction_error(
+                    StatusCode::UNPROCESSABLE_ENTITY,
+                    format!(
+                        "No reply fit the schema after {} attempts: {}",
+                        attempts, error
+                    ),
+                    "extraction_failed",
+                    &generation.text,
+                );
+            }
+            Err(error) => {
+                prompt = format!(
+                    "{} {}\n\nThat reply is invalid: {}. Reply again with corrected JSON only.\n\nJSON:",
+                    prompt,
+                    generation.text.trim(),
+                    error
+                );
+            }
+        }
+    };
+
+    with_request_id(Json(response).into_response(), &request_id)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::backends::TokenLogprob;
+
+    fn request() -> ExtractRequest {
+        serde_json::from_value(json!({
+            "model": "m",
+            "text": "Ada met Ada Lovelace on 1843-07-10 and paid £12.",
+            "entities": [{ "type": "person" }],
+            "fields": [
+                { "name": "date", "type": "date", "required": true },
+                { "name": "amount", "type": "number" }
+            ]
+        }))
+        .unwrap()
+    }
+
+    #[test]
+    fn test_reply_is_checked_against_schema() {
+        let request = request();
+        assert!(
+            request
+                .parse_reply("```json\n{\"fields\": {\"date\": \"1843-07-10\"}}\n```")
+                .is_ok()
+        );
+        assert!(request.parse_reply("{\"fields\": {}}").is_err());
+        assert!(
+            request
+                .parse_reply("{\"fields\": {\"date\": \"10 July 1843\"}}")
+                .is_err()
+        );
+        assert!(
+            request
+                .parse_reply(
+                    "{\"entities\": [{\"type\": \"place\", \"text\": \"Ada\"}], \
+                     \"fields\": {\"date\": \"1843-07-10\"}}"
+                )
+                .is_err()
+        );
+    }
+
+    #[test]
+    fn test_repeated_mentions_get_successive_offsets() {
+        let request = request();
+        let output = "{\"entities\": [{\"type\": \"person\", \"text\": \"Ada\"}, \
+                      {\"type\": \"person\", \"text\": \"Ada\"}, \
+                      {\"type\": \"person\", \"text\": \"Grace\"}], \
+                      \"fields\": {\"date\": \"1843-07-10\", \"amount\": 12}}";
+        let reply = request.parse_reply(output).unwrap();
+        let response = build_response(&request, reply, output, None, 1);
+
+        let spans: Vec<(usize, usize)> =
+            response.entities.iter().map(|e| (e.start, e.end)).collect();
+        assert_eq!(spans, vec![(0, 3), (8, 11)]);
+        assert_eq!(response.fields["date"].start, Some(24));
+        assert_eq!(response.fields["amount"].value, json!(12));
+        assert_eq!(response.fields["amount"].start, None);
+    }
+
+    #[test]
+    fn test_confidence_averages_value_tokens() {
+        let scored = ScoredText {
+            context_tokens: 1,
+            tokens: vec![
+                TokenLogprob {
+                    token: "{\"a\": ".to_string(),
+                    logprob: -5.0,
+                },
+                TokenLogprob {
+                    token: "12".to_string(),
+                    logprob: 0.0,
+                },
+                TokenLogprob {
+                    token: "}".to_string(),
+                    logprob: -5.0,
+                },
+            ],
+        };
+        assert_eq!(span_confidence(&scored, 6, 8), Some(1.0));
+    }
+}
@@ -0,0 +1,737 @@
+//! OpenAI Files API
+//!
+//! `/v1/files` stores uploads the way OpenAI's Files endpoint does, so SDK
+//! code that uploads training data, batch inputs or other documents runs
+//! unchanged. Uploads are `multipart/form-data` with a `file` part and a
+//! `purpose` field; the multipart body is parsed as it streams in and the
+//! file part goes straight to disk. Each file is kept as
+//! `<cache_dir>/files/<id>` next to an `<id>.json` metadata record.
+
+use crate::{
+    api::{admin::authorize_admin, error_response, internal_error, invalid_request},
+    cli::serve::ServerState,
+};
+use axum::{
+    Json,
+    body::{Body, Bytes},
+    extract::{Path, Query, State},
+    http::{HeaderMap, HeaderValue, StatusCode, header},
+    response::{IntoResponse, Response},
+};
+use futures::StreamExt;
+use serde::{Deserialize, Serialize};
+use serde_json::json;
+use std::{path::PathBuf, sync::Arc};
+use tokio::{
+    fs,
+    io::{AsyncReadExt, AsyncWriteExt},
+};
+use tracing::{info, warn};
+use uuid::Uuid;
+
+/// Largest file accepted, matching OpenAI's limit
+const MAX_FILE_BYTES: u64 = 512 * 1024 * 1024;
+
+/// Largest part header block or non-file field accepted
+const MAX_FIELD_BYTES: usize = 16 * 1024;
+
+/// Purposes OpenAI accepts
+const PURPOSES: [&str; 6] = [
+    "assistants",
+    "batch",
+    "fine-tune",
+    "vision",
+    "user_data",
+    "evals",
+];
+
+/// Bytes read per chunk when serving file content
+const READ_CHUNK_BYTES: usize = 64 * 1024;
+
+/// An uploaded file, as OpenAI describes it
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct FileObject {
+    pub id: String,
+    pub object: String,
+    pub bytes: u64,
+    pub created_at: i64,
+    pub filename: String,
+    pub purpose: String,
+    pub status: String,
+    pub status_details: Option<String>,
+}
+
+/// Whether `id` looks like an ID this store issued
+fn valid_id(id: &str) -> bool {
+    id.strip_prefix("file-").is_some_and(|rest| {
+        !rest.is_empty() && rest.chars().all(|c| c.is_ascii_hexdigit() || c == '-')
+    })
+}
+
+/// Uploaded files on disk
+#[derive(Debug)]
+pub struct FileStore {
+    root: PathBuf,
+}
+
+impl FileStore {
+    pub fn new(root: impl Into<PathBuf>) -> Self {
+        Self { root: root.into() }
+    }
+
+    fn content_path(&self, id: &str) -> PathBuf {
+        self.root.join(id)
+    }
+
+    fn meta_path(&self, id: &str) -> PathBuf {
+        self.root.join(format!("{}.json", id))
+    }
+
+    pub async fn get(&self, id: &str) -> Option<FileObject> {
+        if !valid_id(id) {
+            return None;
+        }
+        let bytes = fs::read(self.meta_path(id)).await.ok()?;
+        serde_json::from_slice(&bytes).ok()
+    }
+
+    /// All files, newest first, optionally only those with `purpose`
+    pub async fn list(&self, purpose: Option<&str>) -> Vec<FileObject> {
+        let mut files = Vec::new();
+        let Ok(mut entries) = fs::read_dir(&self.root).await else {
+            return files;
+        };
+        while let Ok(Some(entry)) = entries.next_entry().await {
+            let file_name = entry.file_name();
+            let Some(id) = file_name.to_str().and_then(|n| n.strip_suffix(".json")) else {
+                continue;
+            };
+            match self.get(id).await {
+                Some(file) if purpose.is_none_or(|purpose| file.purpose == purpose) => {
+                    files.push(file)
+                }
+                Some(_) => {}
+                None => warn!("Skipping unreadable file metadata {}", id),
+            }
+        }
+        files.sort_by(|a, b| b.created_at.cmp(&a.created_at).then(a.id.cmp(&b.id)));
+        files
+    }
+
+    /// Parse a multipart upload as it streams in and store its file part
+    pub async fn upload(&self, boundary: &str, body: Body) -> Result<FileObject, UploadError> {
+        fs::create_dir_all(&self.root).await?;
+
+        let id = format!("file-{}", Uuid::new_v4().simple());
+        let temp_path = self.root.join(format!(".upload-{}", id));
+        let received = self.receive(boundary, body, &temp_path).await;
+        let (filename, purpose, bytes) = match received {
+            Ok(received) => received,
+            Err(e) => {
+                let _ = fs::remove_file(&temp_path).await;
+                return Err(e);
+            }
+        };
+
+        fs::rename(&temp_path, self.content_path(&id)).await?;
+        self.record(id, filename, purpose, bytes)
+            .await
+            .map_err(UploadError::Io)
+    }
+
+    /// Store a file the server produced, such as a batch's output
+    pub async fn create(
+        &self,
+        filename: &str,
+        purpose: &str,
+        content: &[u8],
+    ) -> std::io::Result<FileObject> {
+        fs::create_dir_all(&self.root).await?;
+        let id = format!("file-{}", Uuid::new_v4().simple());
+        let temp_path = self.root.join(format!(".upload-{}", id));
+        fs::write(&temp_path, content).await?;
+        fs::rename(&temp_path, self.content_path(&id)).await?;
+        self.record(
+            id,
+            filename.to_string(),
+            purpose.to_string(),
+            content.len() as u64,
+        )
+        .await
+    }
+
+    /// Write the metadata of a file whose content is in place
+    async fn record(
+        &self,
+        id: String,
+        filename: String,
+        purpose: String,
+        bytes: u64,
+    ) -> std::io::Result<FileObject> {
+        let file = FileObject {
+            id: id.clone(),
+            object: "file".to_string(),
+            bytes,
+            created_at: chrono::Utc::now().timestamp(),
+            filename,
+            purpose,
+            status: "processed".to_string(),
+            status_details: None,
+        };
+        let meta = serde_json::to_vec_pretty(&file).map_err(std::io::Error::other)?;
+        fs::write(self.meta_path(&id), meta).await?;
+        Ok(file)
+    }
+
+    /// A stored file's content; `None` if there is no such file
+    pub async fn read(&self, id: &str) -> std::io::Result<Option<Vec<u8>>> {
+        if self.get(id).await.is_none() {
+            return Ok(None);
+        }
+        fs::read(self.content_path(id)).await.map(Some)
+    }
+
+    async fn receive(
+        &self,
+        boundary: &str,
+        body: Body,
+        temp_path: &std::path::Path,
+    ) -> Result<(String, String, u64), UploadError> {
+        let mut parser = MultipartParser::new(boundary);
+        let mut file: Option<fs::File> = None;
+        let mut filename = None;
+        let mut bytes = 0u64;
+        let mut purpose: Option<Vec<u8>> = None;
+        // Which field the current part feeds
+        let mut current = Field::Ignored;
+
+        let mut stream = body.into_data_stream();
+        while let Some(chunk) = stream.next().await {
+            let chunk = chunk.map_err(|e| UploadError::Body(e.to_string()))?;
+            for event in parser.feed(&chunk).map_err(UploadError::Malformed)? {
+                match event {
+                    MultipartEvent::Part {
+                        name,
+                        filename: part_filename,
+                    } => {
+                        current = match name.as_str() {
+                            "file" if file.is_none() => {
+                                file = Some(fs::File::create(temp_path).await?);
+                                filename =
+                                    Some(part_filename.unwrap_or_else(|| "file".to_string()));
+                                Field::File
+                            }
+                            "file" => {
+                                return Err(UploadError::Malformed(
+                                    "only one file may be uploaded per request".to_string(),
+                                ));
+                            }
+                            "purpose" => {
+                                purpose = Some(Vec::new());
+                                Field::Purpose
+                            }
+                            _ => Field::Ignored,
+                        };
+                    }
+                    MultipartEvent::Data(data) => match current {
+                        Field::File => {
+                            bytes += data.len() as u64;
+                            if bytes > MAX_FILE_BYTES {
+                                return Err(UploadError::TooLarge);
+                            }
+                            if let Some(file) = file.as_mut() {
+                                file.write_all(&data).await?;
+                            }
+                        }
+                        Field::Purpose => {
+                            let value = purpose.get_or_insert_with(Vec::new);
+                            if value.len() + data.len() > MAX_FIELD_BYTES {
+                                return Err(UploadError::Malformed(
+                                    "the purpose field is too long".to_string(),
+                                ));
+                            }
+                            value.extend_from_slice(&data);
+                        }
+                        Field::Ignored => {}
+                    },
+                    MultipartEvent::End => current = Field::Ignored,
+                }
+            }
+        }
+        if !parser.is_done() {
+            return Err(UploadError::Malformed(
+                "the multipart body ended early".to_string(),
+            ));
+        }
+
+        let Some(mut file) = file else {
+            return Err(UploadError::Missing("file"));
+        };
+        file.flush().await?;
+        let purpose = purpose
+            .map(|value| String::from_utf8_lossy(&value).trim().to_string())
+            .ok_or(UploadError::Missing("purpose"))?;
+        if !PURPOSES.contains(&purpose.as_str()) {
+            return Err(UploadError::Purpose(purpose));
+        }
+        if bytes == 0 {
+            return Err(UploadError::Empty);
+        }
+
+        let filename = filename.unwrap_or_default();
+        // Browsers may send a full client-side path
+        let filename = filename
+            .rsplit(['/', '\\'])
+            .next()
+            .unwrap_or_default()
+            .to_string();
+        Ok((filename, purpose, bytes))
+    }
+
+    pub async fn remove(&self, id: &str) -> std::io::Result<bool> {
+        if !valid_id(id) {
+            return Ok(false);
+        }
+        match fs::remove_file(self.meta_path(id)).await {
+            Ok(()) => {}
+            Err(e) if e.kind() == std::io::ErrorKind::NotFound => return Ok(false),
+            Err(e) => return Err(e),
+        }
+        match fs::remove_file(self.content_path(id)).await {
+            Ok(()) => Ok(true),
+            Err(e) if e.kind() == std::io::ErrorKind::NotFound => Ok(true),
+            Err(e) => Err(e),
+        }
+    }
+}
+
+enum Field {
+    File,
+    Purpose,
+    Ignored,
+}
+
+/// Why an upload was not stored
+#[derive(Debug)]
+pub enum UploadError {
+    Empty,
+    TooLarge,
+    Missing(&'static str),
+    Purpose(String),
+    Malformed(String),
+    Body(String),
+    Io(std::io::Error),
+}
+
+impl From<std::io::Error> for UploadError {
+    fn from(e: std::io::Error) -> Self {
+        UploadError::Io(e)
+    }
+}
+
+/// What the multipart parser found in the bytes fed so far
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub(crate) enum MultipartEvent {
+    /// A part begins
+    Part {
+        name: String,
+        filename: Option<String>,
+    },
+    /// Body bytes of the current part
+    Data(Vec<u8>),
+    /// The current part ends
+    End,
+}
+
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+enum ParserState {
+    Preamble,
+    AfterDelimiter,
+    Headers,
+    Body,
+    Done,
+}
+
+/// Incremental `multipart/form-data` parser: bytes go in as they arrive and
+/// part bodies come out without being buffered whole
+pub(crate) struct MultipartParser {
+    /// `CRLF--boundary`
+    delimiter: Vec<u8>,
+    buffer: Vec<u8>,
+    state: ParserState,
+}
+
+fn find(haystack: &[u8], needle: &[u8]) -> Option<usize> {
+    haystack
+        .windows(needle.len())
+        .position(|window| window == needle)
+}
+
+impl MultipartParser {
+    pub(crate) fn new(boundary: &str) -> Self {
+        Self {
+            delimiter: format!("\r\n--{}", boundary).into_bytes(),
+            // The first delimiter has no preceding line break
+            buffer: b"\r\n".to_vec(),
+            state: ParserState::Preamble,
+        }
+    }
+
+    /// Whether the closing delimiter has been seen
+    pub(crate) fn is_done(&self) -> bool {
+        self.state == ParserState::Done
+    }
+
+    pub(crate) fn feed(&mut self, chunk: &[u8]) -> Result<Vec<MultipartEvent>, String> {
+        let mut events = Vec::new();
+        if self.state == ParserState::Done {
+            return Ok(events);
+        }
+        self.buffer.extend_from_slice(chunk);
+
+        loop {
+            match self.state {
+                ParserState::Preamble => match find(&self.buffer, &self.delimiter) {
+                    Some(at) => {
+                        self.buffer.drain(..at + self.delimiter.len());
+                        self.state = ParserState::AfterDelimiter;
+                    }
+                    None => {
+                        let keep = self.buffer.len().min(self.delimiter.len() - 1);
+                        self.buffer.drain(..self.buffer.len() - keep);
+                        break;
+                    }
+                },
+                ParserState::AfterDelimiter => {
+                    if self.buffer.len() < 2 {
+                        break;
+                    }
+                    if self.buffer.starts_with(b"--") {
+                        self.buffer.clear();
+                        self.state = ParserState::Done;
+                        break;
+                    }
+                    if !self.buffer.starts_with(b"\r\n") {
+                        return Err("malformed multipart boundary".to_string());
+                    }
+                    self.buffer.drain(..2);
+                    self.state = ParserState::Headers;
+                }
+                ParserState::Headers => match find(&self.buffer, b"\r\n\r\n") {
+                    Some(at) => {
+                        let headers = String::from_utf8_lossy(&self.buffer[..at]).to_string();
+                        self.buffer.drain(..at + 4);
+                        events.push(part_event(&headers)?);
+                        self.state = ParserState::Body;
+                    }
+                    None if self.buffer.len() > MAX_FIELD_BYTES => {
+                        return Err("multipart part headers are too large".to_string());
+                    }
+                    None => break,
+                },
+                ParserState::Body => match find(&self.buffer, &self.delimiter) {
+                    Some(at) => {
+                        if at > 0 {
+                            events.push(MultipartEvent::Data(self.buffer[..at].to_vec()));
+                        }
+                        self.buffer.drain(..at + self.delimiter.len());
+                        events.push(MultipartEvent::End);
+                        self.state = ParserState::AfterDelimiter;
+                    }
+                    None => {
+                        // Hold back what could be the start of a split delimiter
+                        let keep = self.delimiter.len() - 1;
+                        if self.buffer.len() > keep {
+                            let data: Vec<u8> =
+                                self.buffer.drain(..self.buffer.len() - keep).collect();
+                            events.push(MultipartEvent::Data(data));
+                        }
+                        break;
+                    }
+                },
+                ParserState::Done => break,
+            }
+        }
+        Ok(events)
+    }
+}
+
+/// A parameter of a header value such as `form-data; name="file"`
+fn header_param(value: &str, key: &str) -> Option<String> {
+    value.split(';').skip(1).find_map(|param| {
+        let (name, value) = param.trim().split_once('=')?;
+        name.trim()
+            .eq_ignore_ascii_case(key)
+            .then(|| value.trim().trim_matches('"').to_string())
+    })
+}
+
+fn part_event(headers: &str) -> Result<MultipartEvent, String> {
+    let disposition = headers
+        .split("\r\n")
+        .find_map(|line| {
+            let (name, value) = line.split_once(':')?;
+            name.trim()
+                .eq_ignore_ascii_case("content-disposition")
+                .then_some(value.trim())
+        })
+        .ok_or_else(|| "a multipart part has no Content-Disposition".to_string())?;
+    let name = header_param(disposition, "name")
+        .ok_or_else(|| "a multipart part has no field name".to_string())?;
+    Ok(MultipartEvent::Part {
+        name,
+        filename: header_param(disposition, "filename"),
+    })
+}
+
+/// The boundary of a `multipart/form-data` content type
+pub(crate) fn multipart_boundary(content_type: &str) -> Option<String> {
+    let media_type = content_type.split(';').next()?.trim();
+    if !media_type.eq_ignore_ascii_case("multipart/form-data") {
+        return None;
+    }
+    header_param(content_type, "boundary").filter(|boundary| !boundary.is_empty())
+}
+
+/// Query for `GET /v1/files`
+#[derive(Debug, Default, Deserialize)]
+pub struct ListFilesQuery {
+    #[serde(default)]
+    pub purpose: Option<String>,
+}
+
+// API Handlers
+
+/// `POST /v1/files` - upload a file with its purpose (admin only)
+pub async fn upload_file(
+    State(state): State<Arc<ServerState>>,
+    headers: HeaderMap,
+    body: Body,
+) -> Response {
+    if let Err(response) = authorize_admin(&headers) {
+        return response;
+    }
+
+    let Some(boundary) = headers
+        .get(header::CONTENT_TYPE)
+        .and_then(|value| value.to_str().ok())
+        .and_then(multipart_boundary)
+    else {
+        return invalid_request(
+            "uploads must be multipart/form-data with a boundary".to_string(),
+            Some("file"),
+            None,
+        );
+    };
+
+    match state.files.upload(&boundary, body).await {
+        Ok(file) => {
+            info!(
+                "Stored file {} ({}, {} bytes, purpose {})",
+                file.id, file.filename, file.bytes, file.purpose
+            );
+            Json(file).into_response()
+        }
+        Err(UploadError::Empty) => {
+            invalid_request("the file is empty".to_string(), Some("file"), None)
+        }
+        Err(UploadError::TooLarge) => error_response(
+            StatusCode::PAYLOAD_TOO_LARGE,
+            format!("files may be at most {} bytes", MAX_FILE_BYTES),
+            Some("file"),
+            Some("file_too_large"),
+        ),
+        Err(UploadError::Missing(field)) => invalid_request(
+            format!("the {} field is required", field),
+            Some(field),
+            None,
+        ),
+        Err(UploadError::Purpose(purpose)) => invalid_request(
+            format!(
+                "'{}' is not a valid purpose; expected one of {}",
+                purpose,
+                PURPOSES.join(", ")
+            ),
+            Some("purpose"),
+            None,
+        ),
+        Err(UploadError::Malformed(message)) => invalid_request(message, Some("file"), None),
+        Err(UploadError::Body(message)) => invalid_request(
+            format!("upload interrupted: {}", message),
+            Some("file"),
+            None,
+        ),
+        Err(UploadError::Io(e)) => internal_error(format!("Failed to store file: {}", e)),
+    }
+}
+
+/// `GET /v1/files` - uploaded files, newest first
+pub async fn list_files(
+    State(state): State<Arc<ServerState>>,
+    Query(query): Query<ListFilesQuery>,
+) -> impl IntoResponse {
+    Json(json!({
+        "object": "list",
+        "data": state.files.list(query.purpose.as_deref()).await,
+        "has_more": false
+    }))
+}
+
+/// `GET /v1/files/:file_id` - one file's metadata
+pub async fn get_file(
+    State(state): State<Arc<ServerState>>,
+    Path(file_id): Path<String>,
+) -> Response {
+    match state.files.get(&file_id).await {
+        Some(file) => Json(file).into_response(),
+        None => file_not_found(&file_id),
+    }
+}
+
+/// `DELETE /v1/files/:file_id` - delete a file (admin only)
+pub async fn delete_file(
+    State(state): State<Arc<ServerState>>,
+    Path(file_id): Path<String>,
+    headers: HeaderMap,
+) -> Response {
+    if let Err(response) = authorize_admin(&headers) {
+        return response;
+    }
+
+    match state.files.remove(&file_id).await {
+        Ok(true) => Json(json!({
+            "id": file_id,
+            "object": "file",
+            "deleted": true
+        }))
+        .into_response(),
+        Ok(false) => file_not_found(&file_id),
+        Err(e) => internal_error(format!("Failed to delete file: {}", e)),
+    }
+}
+
+/// `GET /v1/files/:file_id/content` - the file's bytes, streamed
+pub async fn file_content(
+    State(state): State<Arc<ServerState>>,
+    Path(file_id): Path<String>,
+) -> Response {
+    let Some(file) = state.files.get(&file_id).await else {
+        return file_not_found(&file_id);
+    };
+    let handle = match fs::File::open(state.files.content_path(&file.id)).await {
+        Ok(handle) => handle,
+        Err(e) => return internal_error(format!("Failed to read file: {}", e)),
+    };
+
+    let stream = futures::stream::unfold(handle, |mut handle| async move {
+        let mut buffer = vec![0u8; READ_CHUNK_BYTES];
+        match handle.read(&mut buffer).await {
+            Ok(0) => None,
+            Ok(read) => {
+                buffer.truncate(read);
+                Some((Ok::<_, std::io::Error>(Bytes::from(buffer)), handle))
+            }
+            Err(e) => Some((Err(e), handle)),
+        }
+    });
+
+    let mut response = Body::from_stream(stream).into_response();
+    let response_headers = response.headers_mut();
+    response_headers.insert(
+        header::CONTENT_TYPE,
+        HeaderValue::from_static("application/octet-stream"),
+    );
+    response_headers.insert(header::CONTENT_LENGTH, HeaderValue::from(file.bytes));
+    if let Ok(disposition) =
+        HeaderValue::from_str(&format!("attachment; filename=\"{}\"", file.filename))
+    {
+        response_headers.insert(header::CONTENT_DISPOSITION, disposition);
+    }
+    response
+}
+
+fn file_not_found(file_id: &str) -> Response {
+    error_response(
+        StatusCode::NOT_FOUND,
+        format!("No such File object: {}", file_id),
+        Some("id"),
+        Some("file_not_found"),
+    )
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    const BODY: &[u8] = b"preamble\r\n--XyZ\r\n\
+Content-Disposition: form-data; name=\"purpose\"\r\n\r\n\
+fine-tune\r\n--XyZ\r\n\
+Content-Disposition: form-data; name=\"file\"; filename=\"train.jsonl\"\r\n\
+Content-Type: application/octet-stream\r\n\r\n\
+{\"a\":1}\r\n--X\r\n{\"b\":2}\r\n--XyZ--\r\n";
+
+    /// Feed `body` in `size`-byte chunks and reassemble the parts
+    fn parse(body: &[u8], size: usize) -> (Vec<(String, Option<String>, Vec<u8>)>, bool) {
+        let mut parser = MultipartParser::new("XyZ");
+        let mut parts: Vec<(String, Option<String>, Vec<u8>)> = Vec::new();
+        for chunk in body.chunks(size) {
+            for event in parser.feed(chunk).unwrap() {
+                match event {
+                    MultipartEvent::Part { name, filename } => {
+                        parts.push((name, filename, Vec::new()))
+                    }
+                    MultipartEvent::Data(data) => {
+                        parts.last_mut().unwrap().2.extend_from_slice(&data)
+                    }
+                    MultipartEvent::End => {}
+                }
+            }
+        }
+        (parts, parser.is_done())
+    }
+
+    #[test]
+    fn parses_parts_split_anywhere() {
+        for size in 1..=BODY.len() {
+            let (parts, done) = parse(BODY, size);
+            assert!(done, "chunk size {}", size);
+            assert_eq!(parts.len(), 2);
+            assert_eq!(
+                parts[0],
+                ("purpose".to_string(), None, b"fine-tune".to_vec())
+            );
+            assert_eq!(parts[1].0, "file");
+            assert_eq!(parts[1].1.as_deref(), Some("train.jsonl"));
+            // A line that only resembles the boundary stays in the data
+            assert_eq!(parts[1].2, b"{\"a\":1}\r\n--X\r\n{\"b\":2}".to_vec());
+        }
+    }
+
+    #[test]
+    fn notices_a_truncated_body() {
+        let (_, done) = parse(&BODY[..BODY.len() - 10], 64);
+        assert!(!done);
+    }
+
+    #[test]
+    fn reads_the_boundary() {
+        assert_eq!(
+            multipart_boundary("multipart/form-data; boundary=\"abc 123\"").as_deref(),
+            Some("abc 123")
+        );
+        assert_eq!(
+            multipart_boundary("Multipart/Form-Data;boundary=xyz").as_deref(),
+            Some("xyz")
+        );
+        assert!(multipart_boundary("application/json").is_none());
+        assert!(multipart_boundary("multipart/form-data").is_none());
+    }
+
+    #[test]
+    fn accepts_only_issued_ids() {
+        assert!(valid_id("file-0123abcd"));
+        assert!(!valid_id("file-"));
+        assert!(!valid_id("file-../x"));
+        assert!(!valid_id("dataset-1"));
+    }
+}
@@ -0,0 +1,1362 @@
+//! Fine-Tuning Jobs
+//!
+//! `POST /v1/fine_tuning/jobs` trains a LoRA adapter for a local base model on
+//! a dataset file. Training is delegated to an external trainer command
+//! (`fine_tuning.trainer_command`, llama.cpp's `llama-finetune` by default),
+//! run with llama.cpp-style flags:
+//!
+//! ```text
+//! <trainer_command> --model-base <base.gguf> --train-data <dataset>
+//!     --lora-out <adapter.gguf> --lora-r <rank> --lora-alpha <alpha>
+//!     --adam-alpha <learning_rate> --epochs <epochs> --batch <batch_size>
+//!     --ctx <context_length> [--seed <seed>]
+//! ```
+//!
+//! Progress is read from the trainer's output: any `key=value` pairs named
+//! `iter`/`step`, `total`/`steps`, `epoch`, `loss` and `lr` update the job, so a
+//! wrapper script only has to print lines such as `step=40 total=400 loss=1.92`.
+//! The adapter is written under `fine_tuning.output_dir` (inside the models
+//! directory by default) and registered in the model registry with the
+//! `fine-tuned` tag once training succeeds.
+//!
+//! Each progress update is appended to `<adapter>.metrics.jsonl` beside the
+//! adapter and published to `GET /v1/fine_tuning/jobs/:job_id/stream` as a
+//! server-sent event, along with checkpoints (`checkpoint=<path>`, or
+//! llama.cpp's `saving to <path>` lines) and status changes.
+
+use crate::{
+    api::{admin::authorize_admin, cancellation::CancelSignal, invalid_request},
+    cli::serve::ServerState,
+    models::ModelInfo,
+};
+use axum::{
+    Json,
+    extract::{Path, State},
+    http::{HeaderMap, StatusCode},
+    response::{
+        IntoResponse, Response,
+        sse::{Event, KeepAlive, Sse},
+    },
+};
+use serde::{Deserialize, Serialize};
+use serde_json::json;
+use std::{collections::HashMap, path::PathBuf, process::Stdio, sync::Arc, time::Instant};
+use tokio::{
+    fs::OpenOptions,
+    io::{AsyncBufReadExt, AsyncRead, AsyncWriteExt, BufReader},
+    process::Command,
+    sync::{RwLock, Semaphore, broadcast, mpsc},
+};
+use tracing::{info, warn};
+use uuid::Uuid;
+
+/// Trainer output lines kept per job; the oldest are dropped first
+const MAX_JOB_EVENTS: usize = 200;
+
+/// Finished jobs kept for retrieval; the oldest are dropped first
+const MAX_RETAINED_JOBS: usize = 100;
+
+/// Live updates buffered for slow stream subscribers
+const EVENT_CHANNEL_CAPACITY: usize = 256;
+
+/// Fine-tuning settings under `[fine_tuning]` in the config file
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct FineTuningConfig {
+    /// Trainer executable, optionally followed by fixed arguments
+    pub trainer_command: String,
+    /// Where adapters are written; defaults to `<models_dir>/fine-tuned`
+    pub output_dir: Option<PathBuf>,
+    /// Base directory for relative dataset paths
+    pub datasets_dir: PathBuf,
+    /// Jobs that may train at once; the rest wait in `queued`
+    pub max_concurrent_jobs: usize,
+}
+
+impl Default for FineTuningConfig {
+    fn default() -> Self {
+        let data_dir = dirs::data_dir()
+            .unwrap_or_else(|| PathBuf::from("."))
+            .join("inferno");
+
+        Self {
+            trainer_command: "llama-finetune".to_string(),
+            output_dir: None,
+            datasets_dir: data_dir.join("datasets"),
+            max_concurrent_jobs: 1,
+        }
+    }
+}
+
+impl FineTuningConfig {
+    /// Where adapters are written: `output_dir`, or `<models_dir>/fine-tuned`
+    pub fn adapters_dir(&self, models_dir: &std::path::Path) -> PathBuf {
+        self.output_dir
+            .clone()
+            .unwrap_or_else(|| models_dir.join("fine-tuned"))
+    }
+}
+
+fn default_rank() -> u32 {
+    8
+}
+
+fn default_alpha() -> f32 {
+    16.0
+}
+
+fn default_learning_rate() -> f64 {
+    1e-4
+}
+
+fn default_epochs() -> u32 {
+    1
+}
+
+fn default_batch_size() -> u32 {
+    4
+}
+
+fn default_context_length() -> u32 {
+    512
+}
+
+fn default_register() -> bool {
+    true
+}
+
+/// LoRA training hyperparameters
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct LoraHyperparameters {
+    #[serde(default = "default_rank")]
+    pub rank: u32,
+    #[serde(default = "default_alpha")]
+    pub alpha: f32,
+    #[serde(default = "default_learning_rate")]
+    pub learning_rate: f64,
+    #[serde(default = "default_epochs")]
+    pub epochs: u32,
+    #[serde(default = "default_batch_size")]
+    pub batch_size: u32,
+    #[serde(default = "default_context_length")]
+    pub context_length: u32,
+    #[serde(default)]
+    pub seed: Option<u64>,
+}
+
+impl Default for LoraHyperparameters {
+    fn default() -> Self {
+        Self {
+            rank: default_rank(),
+            alpha: default_alpha(),
+            learning_rate: default_learning_rate(),
+            epochs: default_epochs(),
+            batch_size: default_batch_size(),
+            context_length: default_context_length(),
+            seed: None,
+        }
+    }
+}
+
+impl LoraHyperparameters {
+    pub fn validate(&self) -> Result<(), String> {
+        if !(1..=256).contains(&self.rank) {
+            return Err("rank must be between 1 and 256".to_string());
+        }
+        if !self.alpha.is_finite() || self.alpha <= 0.0 {
+            return Err("alpha must be positive".to_string());
+        }
+        if !(f64::MIN_POSITIVE..1.0).contains(&self.learning_rate) {
+            return Err("learning_rate must be between 0 and 1".to_string());
+        }
+        if !(1..=100).contains(&self.epochs) {
+            return Err("epochs must be between 1 and 100".to_string());
+        }
+        if !(1..=1024).contains(&self.batch_size) {
+            return Err("batch_size must be between 1 and 1024".to_string());
+        }
+        if !(16..=131_072).contains(&self.context_length) {
+            return Err("context_length must be between 16 and 131072".to_string());
+        }
+        Ok(())
+    }
+}
+
+/// Body for `POST /v1/fine_tuning/jobs`
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct FineTuningJobRequest {
+    /// Name or path of a local GGUF base model
+    pub base_model: String,
+    /// A managed dataset (`name` or `name@v<N>`), or a training data file
+    /// absolute or relative to `fine_tuning.datasets_dir`
+    pub dataset: String,
+    #[serde(default)]
+    pub hyperparameters: LoraHyperparameters,
+    /// Appended to the adapter name, e.g. `llama-3-8b-ft-support`
+    #[serde(default)]
+    pub suffix: Option<String>,
+    /// Register the adapter in the model registry when training succeeds
+    #[serde(default = "default_register")]
+    pub register: bool,
+}
+
+/// Lifecycle state of a fine-tuning job
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Serialize, Deserialize)]
+#[serde(rename_all = "snake_case")]
+pub enum FineTuningStatus {
+    Queued,
+    Running,
+    Succeeded,
+    Failed,
+    Cancelled,
+}
+
+/// Training progress as last reported by the trainer
+#[derive(Debug, Clone, Default, PartialEq, Serialize, Deserialize)]
+pub struct TrainingProgress {
+    pub step: u64,
+    pub total_steps: Option<u64>,
+    pub epoch: Option<u32>,
+    pub loss: Option<f64>,
+    pub learning_rate: Option<f64>,
+    /// `step / total_steps`, once the trainer has reported a total
+    pub fraction: Option<f64>,
+    /// Seconds left at the average pace so far, once a total is known
+    pub eta_seconds: Option<u64>,
+}
+
+/// One point in a job's metric history
+#[derive(Debug, Clone, PartialEq, Serialize, Deserialize)]
+pub struct TrainingMetric {
+    pub step: u64,
+    pub total_steps: Option<u64>,
+    pub epoch: Option<u32>,
+    pub loss: Option<f64>,
+    pub learning_rate: Option<f64>,
+    pub eta_seconds: Option<u64>,
+    pub recorded_at: chrono::DateTime<chrono::Utc>,
+}
+
+impl TrainingMetric {
+    fn from_progress(progress: &TrainingProgress) -> Self {
+        Self {
+            step: progress.step,
+            total_steps: progress.total_steps,
+            epoch: progress.epoch,
+            loss: progress.loss,
+            learning_rate: progress.learning_rate,
+            eta_seconds: progress.eta_seconds,
+            recorded_at: chrono::Utc::now(),
+        }
+    }
+}
+
+/// A checkpoint the trainer reported writing
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct TrainingCheckpoint {
+    pub step: u64,
+    pub path: PathBuf,
+    pub created_at: chrono::DateTime<chrono::Utc>,
+}
+
+/// What a [`TrainingEvent`] carries
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Serialize, Deserialize)]
+#[serde(rename_all = "snake_case")]
+pub enum TrainingEventKind {
+    Metric,
+    Checkpoint,
+    Status,
+}
+
+impl TrainingEventKind {
+    /// Server-sent event name
+    fn as_str(self) -> &'static str {
+        match self {
+            TrainingEventKind::Metric => "metric",
+            TrainingEventKind::Checkpoint => "checkpoint",
+            TrainingEventKind::Status => "status",
+        }
+    }
+}
+
+/// Live update published while a job runs
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct TrainingEvent {
+    #[serde(rename = "type")]
+    pub kind: TrainingEventKind,
+    pub job_id: String,
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub metric: Option<TrainingMetric>,
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub checkpoint: Option<TrainingCheckpoint>,
+    /// Snapshot of the job, for status changes
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub job: Option<FineTuningJob>,
+}
+
+impl TrainingEvent {
+    fn status(job: &FineTuningJob) -> Self {
+        Self {
+            kind: TrainingEventKind::Status,
+            job_id: job.id.clone(),
+            metric: None,
+            checkpoint: None,
+            job: Some(job.clone()),
+        }
+    }
+}
+
+/// One line of trainer output
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct FineTuningEvent {
+    pub created_at: chrono::DateTime<chrono::Utc>,
+    pub message: String,
+}
+
+/// A fine-tuning job; trainer output is served by the events endpoint
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct FineTuningJob {
+    pub id: String,
+    pub object: String,
+    pub base_model: String,
+    pub dataset: String,
+    pub hyperparameters: LoraHyperparameters,
+    pub status: FineTuningStatus,
+    pub progress: TrainingProgress,
+    /// Name the adapter resolves by once written
+    pub fine_tuned_model: String,
+    pub output_path: PathBuf,
+    pub registered: bool,
+    pub checkpoints: Vec<TrainingCheckpoint>,
+    pub created_at: chrono::DateTime<chrono::Utc>,
+    pub started_at: Option<chrono::DateTime<chrono::Utc>>,
+    pub finished_at: Option<chrono::DateTime<chrono::Utc>>,
+    pub error: Option<String>,
+    #[serde(skip)]
+    register_on_success: bool,
+    #[serde(skip)]
+    events: Vec<FineTuningEvent>,
+    #[serde(skip)]
+    cancel: Arc<CancelSignal>,
+}
+
+impl FineTuningJob {
+    fn is_finished(&self) -> bool {
+        !matches!(
+            self.status,
+            FineTuningStatus::Queued | FineTuningStatus::Running
+        )
+    }
+
+    /// Metric history, one JSON object per line, kept beside the adapter
+    fn metrics_path(&self) -> PathBuf {
+        self.output_path.with_extension("metrics.jsonl")
+    }
+
+    fn push_event(&mut self, message: String) {
+        if self.events.len() >= MAX_JOB_EVENTS {
+            self.events.remove(0);
+        }
+        self.events.push(FineTuningEvent {
+            created_at: chrono::Utc::now(),
+            message,
+        });
+    }
+}
+
+/// In-memory store of fine-tuning jobs and the channel their live updates
+/// are published on
+#[derive(Debug)]
+pub struct FineTuningStore {
+    jobs: RwLock<HashMap<String, FineTuningJob>>,
+    /// Bounds how many trainers run at once
+    slots: Arc<Semaphore>,
+    events: broadcast::Sender<TrainingEvent>,
+}
+
+impl FineTuningStore {
+    pub fn new(max_concurrent_jobs: usize) -> Self {
+        let (events, _) = broadcast::channel(EVENT_CHANNEL_CAPACITY);
+        Self {
+            jobs: RwLock::new(HashMap::new()),
+            slots: Arc::new(Semaphore::new(max_concurrent_jobs.max(1))),
+            events,
+        }
+    }
+
+    pub fn subscribe(&self) -> broadcast::Receiver<TrainingEvent> {
+        self.events.subscribe()
+    }
+
+    fn publish(&self, event: TrainingEvent) {
+        // Nobody listening is fine; updates are also reflected in the job itself
+        let _ = self.events.send(event);
+    }
+
+    async fn insert(&self, job: FineTuningJob) {
+        let mut jobs = self.jobs.write().await;
+        if jobs.len() >= MAX_RETAINED_JOBS {
+            let oldest = jobs
+                .values()
+                .filter(|job| job.is_finished())
+                .min_by_key(|job| job.created_at)
+                .map(|job| job.id.clone());
+            if let Some(oldest) = oldest {
+                jobs.remove(&oldest);
+            }
+        }
+        jobs.insert(job.id.clone(), job);
+    }
+
+    async fn update<F: FnOnce(&mut FineTuningJob)>(&self, id: &str, f: F) {
+        if let Some(job) = self.jobs.write().await.get_mut(id) {
+            f(job);
+        }
+    }
+
+    pub async fn get(&self, id: &str) -> Option<FineTuningJob> {
+        self.jobs.read().await.get(id).cloned()
+    }
+
+    pub async fn events(&self, id: &str) -> Option<Vec<FineTuningEvent>> {
+        self.jobs.read().await.get(id).map(|job| job.events.clone())
+    }
+
+    /// All jobs, newest first
+    pub async fn list(&self) -> Vec<FineTuningJob> {
+        let mut jobs: Vec<FineTuningJob> = self.jobs.read().await.values().cloned().collect();
+        jobs.sort_by(|a, b| b.created_at.cmp(&a.created_at));
+        jobs
+    }
+
+    /// Signal a queued or running job to stop; `None` if it does not exist
+    pub async fn cancel(&self, id: &str) -> Option<FineTuningJob> {
+        let jobs = self.jobs.read().await;
+        let job = jobs.get(id)?;
+        if !job.is_finished() {
+            job.cancel.cancel();
+        }
+        Some(job.clone())
+    }
+}
+
+/// Update `progress` from the `key=value` pairs in one line of trainer
+/// output, returning whether anything changed. Both `loss=1.9` and
+/// llama.cpp's padded `iter=    12` forms are understood.
+fn parse_progress(line: &str, progress: &mut TrainingProgress) -> bool {
+    let before = progress.clone();
+
+    let mut tokens = line.split_whitespace();
+    while let Some(token) = tokens.next() {
+        let Some((key, value)) = token.split_once('=') else {
+            continue;
+        };
+        let value = if value.is_empty() {
+            match tokens.next() {
+                Some(next) => next,
+                None => break,
+            }
+        } else {
+            value
+        };
+        let value = value.trim_end_matches([',', ';']);
+
+        match key.trim_start_matches("--").to_ascii_lowercase().as_str() {
+            "iter" | "step" => {
+                if let Ok(step) = value.parse() {
+                    progress.step = step;
+                }
+            }
+            "total" | "steps" | "total_steps" => {
+                if let Ok(total) = value.parse() {
+                    progress.total_steps = Some(total);
+                }
+            }
+            "epoch" => {
+                if let Ok(epoch) = value.parse() {
+                    progress.epoch = Some(epoch);
+                }
+            }
+            "loss" => {
+                if let Ok(loss) = value.parse::<f64>() {
+                    if loss.is_finite() {
+                        progress.loss = Some(loss);
+                    }
+                }
+            }
+            "lr" | "learning_rate" => {
+                if let Ok(rate) = value.parse::<f64>() {
+                    if rate.is_finite() {
+                        progress.learning_rate = Some(rate);
+                    }
+                }
+            }
+            _ => {}
+        }
+    }
+
+    progress.fraction = progress
+        .total_steps
+        .filter(|total| *total > 0)
+        .map(|total| (progress.step as f64 / total as f64).min(1.0));
+
+    *progress != before
+}
+
+/// Seconds remaining if the rest of training runs at the pace so far
+fn estimate_eta(elapsed_secs: f64, progress: &TrainingProgress) -> Option<u64> {
+    let total = progress.total_steps?;
+    if progress.step == 0 || progress.step >= total {
+        return None;
+    }
+    let per_step = elapsed_secs / progress.step as f64;
+    Some((per_step * (total - progress.step) as f64).round() as u64)
+}
+
+/// The path in a checkpoint announcement: `checkpoint=<path>`, or llama.cpp's
+/// `save_checkpoint_lora_file: saving to <path>`
+fn parse_checkpoint(line: &str) -> Option<String> {
+    let path = if let Some((_, rest)) = line.split_once("checkpoint=") {
+        rest.split_whitespace().next()?
+    } else if line.contains("checkpoint") {
+        let (_, rest) = line.split_once("saving to ")?;
+        rest.split_whitespace().next()?
+    } else {
+        return None;
+    };
+    Some(path.trim_matches(['\'', '"']).to_string())
+}
+
+/// Command-line arguments passed to the trainer after `trainer_command`
+fn trainer_args(
+    base_path: &std::path::Path,
+    dataset_path: &std::path::Path,
+    output_path: &std::path::Path,
+    hyperparameters: &LoraHyperparameters,
+) -> Vec<String> {
+    let mut args = vec![
+        "--model-base".to_string(),
+        base_path.display().to_string(),
+        "--train-data".to_string(),
+        dataset_path.display().to_string(),
+        "--lora-out".to_string(),
+        output_path.display().to_string(),
+        "--lora-r".to_string(),
+        hyperparameters.rank.to_string(),
+        "--lora-alpha".to_string(),
+        hyperparameters.alpha.to_string(),
+        "--adam-alpha".to_string(),
+        hyperparameters.learning_rate.to_string(),
+        "--epochs".to_string(),
+        hyperparameters.epochs.to_string(),
+        "--batch".to_string(),
+        hyperparameters.batch_size.to_string(),
+        "--ctx".to_string(),
+        hyperparameters.context_length.to_string(),
+    ];
+    if let Some(seed) = hyperparameters.seed {
+        args.push("--seed".to_string());
+        args.push(seed.to_string());
+    }
+    args
+}
+
+/// Registry name for a job's adapter: the base model's file stem, `-ft-`,
+/// then the suffix or a short job ID
+fn adapter_name(base_path: &std::path::Path, suffix: Option<&str>, job_id: &str) -> String {
+    let stem = base_path
+        .file_stem()
+        .and_then(|s| s.to_str())
+        .unwrap_or("model");
+    let tail = match suffix {
+        Some(suffix) => suffix.to_string(),
+        None => job_id
+            .trim_start_matches("ftjob-")
+            .chars()
+            .take(8)
+            .collect(),
+    };
+    format!("{}-ft-{}", stem, tail)
+}
+
+fn valid_suffix(suffix: &str) -> bool {
+    !suffix.is_empty()
+        && suffix.len() <= 40
+        && suffix
+            .chars()
+            .all(|c| c.is_ascii_alphanumeric() || c == '-' || c == '_')
+}
+
+/// Append one metric to a job's history file
+async fn append_metric(file: &mut tokio::fs::File, metric: &TrainingMetric) -> std::io::Result<()> {
+    let mut line = serde_json::to_vec(metric).map_err(std::io::Error::other)?;
+    line.push(b'\n');
+    file.write_all(&line).await
+}
+
+/// Read back a job's metric history; a missing file is an empty history
+async fn read_metrics(path: &std::path::Path) -> std::io::Result<Vec<TrainingMetric>> {
+    let contents = match tokio::fs::read_to_string(path).await {
+        Ok(contents) => contents,
+        Err(e) if e.kind() == std::io::ErrorKind::NotFound => return Ok(Vec::new()),
+        Err(e) => return Err(e),
+    };
+    Ok(contents
+        .lines()
+        .filter_map(|line| serde_json::from_str(line).ok())
+        .collect())
+}
+
+/// Forward each line of a trainer output stream to `lines`
+async fn forward_lines<R: AsyncRead + Unpin>(stream: R, lines: mpsc::Sender<String>) {
+    let mut reader = BufReader::new(stream).lines();
+    while let Ok(Some(line)) = reader.next_line().await {
+        if lines.send(line).await.is_err() {
+            break;
+        }
+    }
+}
+
+/// Add the adapter to the model registry with tags naming its base model
+async fn register_adapter(state: &ServerState, job: &FineTuningJob) -> anyhow::Result<()> {
+    let manager = &state.model_manager;
+    manager.register_model(&job.output_path).await?;
+    manager
+        .tag_model(
+            &job.output_path,
+            &[
+                "fine-tuned".to_string(),
+                "lora".to_string(),
+                format!("base:{}", job.base_model),
+            ],
+        )
+        .await
+}
+
+/// Run the trainer for a job, publishing progress as it goes
+async fn execute_job(
+    state: Arc<ServerState>,
+    job_id: String,
+    base_path: PathBuf,
+    dataset_path: PathBuf,
+) {
+    let store = &state.fine_tuning;
+    let Some(job) = store.get(&job_id).await else {
+        return;
+    };
+    let cancel = Arc::clone(&job.cancel);
+
+    // Wait for a training slot unless cancelled first
+    let permit = tokio::select! {
+        permit = Arc::clone(&store.slots).acquire_owned() => permit.ok(),
+        _ = cancel.cancelled() => None,
+    };
+    let Some(_permit) = permit else {
+        finish(&state, &job_id, FineTuningStatus::Cancelled, None).await;
+        return;
+    };
+
+    if let Some(parent) = job.output_path.parent() {
+        if let Err(e) = tokio::fs::create_dir_all(parent).await {
+            let message = format!("cannot create {}: {}", parent.display(), e);
+            finish(&state, &job_id, FineTuningStatus::Failed, Some(message)).await;
+            return;
+        }
+    }
+
+    let mut command_parts = state.config.fine_tuning.trainer_command.split_whitespace();
+    let Some(program) = command_parts.next() else {
+        let message = "fine_tuning.trainer_command is empty".to_string();
+        finish(&state, &job_id, FineTuningStatus::Failed, Some(message)).await;
+        return;
+    };
+    let mut command = Command::new(program);
+    command
+        .args(command_parts)
+        .args(trainer_args(
+            &base_path,
+            &dataset_path,
+            &job.output_path,
+            &job.hyperparameters,
+        ))
+        .stdin(Stdio::null())
+        .stdout(Stdio::piped())
+        .stderr(Stdio::piped())
+        .kill_on_drop(true);
+
+    let mut child = match command.spawn() {
+        Ok(child) => child,
+        Err(e) => {
+            let message = format!("failed to start trainer {}: {}", program, e);
+            finish(&state, &job_id, FineTuningStatus::Failed, Some(message)).await;
+            return;
+        }
+    };
+
+    store
+        .update(&job_id, |job| {
+            job.status = FineTuningStatus::Running;
+            job.started_at = Some(chrono::Utc::now());
+        })
+        .await;
+    info!("Fine-tuning job {} started training", job_id);
+
+    if let Some(job) = store.get(&job_id).await {
+        store.publish(TrainingEvent::status(&job));
+    }
+
+    let mut metrics_file = match OpenOptions::new()
+        .create(true)
+        .append(true)
+        .open(job.metrics_path())
+        .await
+    {
+        Ok(file) => Some(file),
+        Err(e) => {
+            warn!("Metric history for job {} will not be kept: {}", job_id, e);
+            None
+        }
+    };
+    let training_started = Instant::now();
+
+    let (tx, mut lines) = mpsc::channel(64);
+    if let Some(stdout) = child.stdout.take() {
+        tokio::spawn(forward_lines(stdout, tx.clone()));
+    }
+    if let Some(stderr) = child.stderr.take() {
+        tokio::spawn(forward_lines(stderr, tx.clone()));
+    }
+    drop(tx);
+
+    let mut last_line = None;
+    let cancelled = loop {
+        tokio::select! {
+            line = lines.recv() => {
+                let Some(line) = line else {
+                    break false;
+                };
+                if line.trim().is_empty() {
+                    continue;
+                }
+                last_line = Some(line.clone());
+                let elapsed = training_started.elapsed().as_secs_f64();
+                let checkpoint_path = parse_checkpoint(&line);
+                let mut metric = None;
+                let mut checkpoint = None;
+                store
+                    .update(&job_id, |job| {
+                        if parse_progress(&line, &mut job.progress) {
+                            job.progress.eta_seconds = estimate_eta(elapsed, &job.progress);
+                            metric = Some(TrainingMetric::from_progress(&job.progress));
+                        }
+                        if let Some(path) = checkpoint_path {
+                            let saved = TrainingCheckpoint {
+                                step: job.progress.step,
+                                path: PathBuf::from(path),
+                                created_at: chrono::Utc::now(),
+                            };
+                            job.checkpoints.push(saved.clone());
+                            checkpoint = Some(saved);
+                        }
+                        job.push_event(line);
+                    })
+                    .await;
+
+                if let Some(metric) = metric {
+                    if let Some(file) = metrics_file.as_mut() {
+                        if let Err(e) = append_metric(file, &metric).await {
+                            warn!("Stopped recording metrics for job {}: {}", job_id, e);
+                            metrics_file = None;
+                        }
+                    }
+                    store.publish(TrainingEvent {
+                        kind: TrainingEventKind::Metric,
+                        job_id: job_id.clone(),
+                        metric: Some(metric),
+                        checkpoint: None,
+                        job: None,
+                    });
+                }
+                if let Some(checkpoint) = checkpoint {
+                    info!(
+                        "Fine-tuning job {} saved a checkpoint to {}",
+                        job_id,
+                        checkpoint.path.display()
+                    );
+                    store.publish(TrainingEvent {
+                        kind: TrainingEventKind::Checkpoint,
+                        job_id: job_id.clone(),
+                        metric: None,
+                        checkpoint: Some(checkpoint),
+                        job: None,
+                    });
+                }
+            }
+            _ = cancel.cancelled() => break true,
+        }
+    };
+
+    if cancelled {
+        if let Err(e) = child.kill().await {
+            warn!("Failed to stop trainer for job {}: {}", job_id, e);
+        }
+        finish(&state, &job_id, FineTuningStatus::Cancelled, None).await;
+        return;
+    }
+
+    match child.wait().await {
+        Ok(status) if status.success() => {
+            if !job.output_path.exists() {
+                let message = format!(
+                    "trainer exited successfully but wrote no adapter to {}",
+                    job.output_path.display()
+                );
+                finish(&state, &job_id, FineTuningStatus::Failed, Some(message)).await;
+                return;
+            }
+            store
+                .update(&job_id, |job| {
+                    if let Some(total) = job.progress.total_steps {
+                        job.progress.step = total;
+                        job.progress.fraction = Some(1.0);
+                    }
+                    job.progress.eta_seconds = None;
+                })
+                .await;
+            finish(&state, &job_id, FineTuningStatus::Succeeded, None).await;
+        }
+        Ok(status) => {
+            let message = match last_line {
+                Some(line) => format!("trainer exited with {}: {}", status, line),
+                None => format!("trainer exited with {}", status),
+            };
+            finish(&state, &job_id, FineTuningStatus::Failed, Some(message)).await;
+        }
+        Err(e) => {
+            let message = format!("failed to wait for trainer: {}", e);
+            finish(&state, &job_id, FineTuningStatus::Failed, Some(message)).await;
+        }
+    }
+}
+
+/// Record a job's terminal state, registering the adapter on success when
+/// the job asked for it, and tell stream subscribers
+async fn finish(
+    state: &ServerState,
+    job_id: &str,
+    status: FineTuningStatus,
+    error: Option<String>,
+) {
+    let store = &state.fine_tuning;
+    store
+        .update(job_id, |job| {
+            job.status = status;
+            job.error = error.clone();
+            job.finished_at = Some(chrono::Utc::now());
+        })
+        .await;
+    let Some(job) = store.get(job_id).await else {
+        return;
+    };
+
+    match status {
+        FineTuningStatus::Succeeded => {
+            info!("Fine-tuning job {} succeeded", job_id);
+            if job.register_on_success {
+                match register_adapter(state, &job).await {
+                    Ok(()) => store.update(job_id, |job| job.registered = true).await,
+                    Err(e) => warn!(
+                        "Fine-tuning job {} succeeded but its adapter could not be registered: {}",
+                        job_id, e
+                    ),
+                }
+            }
+        }
+        FineTuningStatus::Failed => warn!(
+            "Fine-tuning job {} failed: {}",
+            job_id,
+            error.as_deref().unwrap_or("unknown error")
+        ),
+        _ => info!("Fine-tuning job {} {:?}", job_id, status),
+    }
+
+    if let Some(job) = store.get(job_id).await {
+        store.publish(TrainingEvent::status(&job));
+    }
+}
+
+// API Handlers
+
+/// Why a job request was refused: a message and the offending parameter
+pub(crate) type Rejection = (String, &'static str);
+
+/// Check the options that do not depend on the base model or dataset
+pub(crate) fn check_options(
+    hyperparameters: &LoraHyperparameters,
+    suffix: Option<&str>,
+) -> Result<(), Rejection> {
+    hyperparameters
+        .validate()
+        .map_err(|message| (message, "hyperparameters"))?;
+    if suffix.is_some_and(|suffix| !valid_suffix(suffix)) {
+        return Err((
+            "suffix must be 1-40 letters, digits, '-' or '_'".to_string(),
+            "suffix",
+        ));
+    }
+    Ok(())
+}
+
+/// Resolve a local model that can be fine-tuned
+pub(crate) async fn resolve_base_model(
+    state: &ServerState,
+    name: &str,
+) -> Result<ModelInfo, Rejection> {
+    let base = state
+        .model_manager
+        .resolve_model(name)
+        .await
+        .map_err(|e| (e.to_string(), "base_model"))?;
+    if base.backend_type != "gguf" {
+        return Err((
+            format!(
+                "{} is not a GGUF model; only GGUF models can be fine-tuned",
+                base.name
+            ),
+            "base_model",
+        ));
+    }
+    Ok(base)
+}
+
+/// Validate a request and queue the job
+pub(crate) async fn submit_job(
+    state: &Arc<ServerState>,
+    request: FineTuningJobRequest,
+) -> Result<FineTuningJob, Rejection> {
+    check_options(&request.hyperparameters, request.suffix.as_deref())?;
+    let base = resolve_base_model(state, &request.base_model).await?;
+
+    let dataset_path = if let Some(version) = state.datasets.resolve(&request.dataset).await {
+        if !version.validation.valid {
+            return Err((
+                format!(
+                    "dataset {} v{} failed validation ({} invalid records)",
+                    version.name, version.version, version.validation.invalid_records
+                ),
+                "dataset",
+            ));
+        }
+        version.path
+    } else {
+        let path = PathBuf::from(&request.dataset);
+        if path.is_absolute() {
+            path
+        } else {
+            state.config.fine_tuning.datasets_dir.join(path)
+        }
+    };
+    if !dataset_path.is_file() {
+        return Err((
+            format!("dataset {} does not exist", dataset_path.display()),
+            "dataset",
+        ));
+    }
+
+    let id = format!("ftjob-{}", Uuid::new_v4());
+    let fine_tuned_model = adapter_name(&base.path, request.suffix.as_deref(), &id);
+    let output_dir = state
+        .config
+        .fine_tuning
+        .adapters_dir(&state.config.models_dir);
+    let output_path = output_dir.join(format!("{}.gguf", fine_tuned_model));
+    if output_path.exists() {
+        return Err((
+            format!(
+                "{} already exists; choose a different suffix",
+                output_path.display()
+            ),
+            "suffix",
+        ));
+    }
+
+    let job = FineTuningJob {
+        id,
+        object: "fine_tuning.job".to_string(),
+        base_model: request.base_model,
+        dataset: request.dataset,
+        hyperparameters: request.hyperparameters,
+        status: FineTuningStatus::Queued,
+        progress: TrainingProgress::default(),
+        fine_tuned_model,
+        output_path,
+        registered: false,
+        checkpoints: Vec::new(),
+        created_at: chrono::Utc::now(),
+        started_at: None,
+        finished_at: None,
+        error: None,
+        register_on_success: request.register,
+        events: Vec::new(),
+        cancel: Arc::new(CancelSignal::new()),
+    };
+    state.fine_tuning.insert(job.clone()).await;
+
+    info!(
+        "Queued fine-tuning job {} of {} on {}",
+        job.id,
+        base.path.display(),
+        dataset_path.display()
+    );
+    tokio::spawn(execute_job(
+        Arc::clone(state),
+        job.id.clone(),
+        base.path,
+        dataset_path,
+    ));
+
+    Ok(job)
+}
+
+/// `POST /v1/fine_tuning/jobs` - start training a LoRA adapter (admin only)
+pub async fn create_job(
+    State(state): State<Arc<ServerState>>,
+    headers: HeaderMap,
+    Json(request): Json<FineTuningJobRequest>,
+) -> Response {
+    if let Err(response) = authorize_admin(&headers) {
+        return response;
+    }
+
+    match submit_job(&state, request).await {
+        Ok(job) => (StatusCode::ACCEPTED, Json(job)).into_response(),
+        Err((message, param)) => invalid_request(message, Some(param), None),
+    }
+}
+
+/// `GET /v1/fine_tuning/jobs` - all jobs, newest first (admin only)
+pub async fn list_jobs(State(state): State<Arc<ServerState>>, headers: HeaderMap) -> Response {
+    if let Err(response) = authorize_admin(&headers) {
+        return response;
+    }
+
+    Json(json!({
+        "object": "list",
+        "data": state.fine_tuning.list().await
+    }))
+    .into_response()
+}
+
+/// `GET /v1/fine_tuning/jobs/:job_id` - status and progress (admin only)
+pub async fn get_job(
+    State(state): State<Arc<ServerState>>,
+    Path(job_id): Path<String>,
+    headers: HeaderMap,
+) -> Response {
+    if let Err(response) = authorize_admin(&headers) {
+        return response;
+    }
+
+    match state.fine_tuning.get(&job_id).await {
+        Some(job) => Json(job).into_response(),
+        None => job_not_found(&job_id),
+    }
+}
+
+/// `GET /v1/fine_tuning/jobs/:job_id/events` - recent trainer output (admin only)
+pub async fn job_events(
+    State(state): State<Arc<ServerState>>,
+    Path(job_id): Path<String>,
+    headers: HeaderMap,
+) -> Response {
+    if let Err(response) = authorize_admin(&headers) {
+        return response;
+    }
+
+    match state.fine_tuning.events(&job_id).await {
+        Some(events) => Json(json!({
+            "object": "list",
+            "data": events
+        }))
+        .into_response(),
+        None => job_not_found(&job_id),
+    }
+}
+
+/// `POST /v1/fine_tuning/jobs/:job_id/cancel` - stop a queued or running job
+/// (admin only)
+pub async fn cancel_job(
+    State(state): State<Arc<ServerState>>,
+    Path(job_id): Path<String>,
+    headers: HeaderMap,
+) -> Response {
+    if let Err(response) = authorize_admin(&headers) {
+        return response;
+    }
+
+    match state.fine_tuning.cancel(&job_id).await {
+        Some(job) => Json(job).into_response(),
+        None => job_not_found(&job_id),
+    }
+}
+
+/// `POST /v1/fine_tuning/jobs/:job_id/register` - add a succeeded job's
+/// adapter to the model registry (admin only)
+pub async fn register_job(
+    State(state): State<Arc<ServerState>>,
+    Path(job_id): Path<String>,
+    headers: HeaderMap,
+) -> Response {
+    if let Err(response) = authorize_admin(&headers) {
+        return response;
+    }
+
+    let Some(job) = state.fine_tuning.get(&job_id).await else {
+        return job_not_found(&job_id);
+    };
+    if job.status != FineTuningStatus::Succeeded {
+        return (
+            StatusCode::CONFLICT,
+            Json(json!({
+                "error": {
+                    "message": format!("Job {} has not succeeded", job_id),
+                    "type": "invalid_request_error",
+                    "param": "job_id",
+                    "code": "fine_tuning_job_not_succeeded"
+                }
+            })),
+        )
+            .into_response();
+    }
+
+    if let Err(e) = register_adapter(&state, &job).await {
+        return (
+            StatusCode::INTERNAL_SERVER_ERROR,
+            Json(json!({
+                "error": {
+                    "message": format!("Failed to register adapter: {}", e),
+                    "type": "internal_error",
+                    "param": null,
+                    "code": null
+                }
+            })),
+        )
+            .into_response();
+    }
+
+    state
+        .fine_tuning
+        .update(&job_id, |job| job.registered = true)
+        .await;
+    info!(
+        "Registered adapter {} from fine-tuning job {}",
+        job.fine_tuned_model, job_id
+    );
+    match state.fine_tuning.get(&job_id).await {
+        Some(job) => Json(job).into_response(),
+        None => job_not_found(&job_id),
+    }
+}
+
+/// `GET /v1/fine_tuning/jobs/:job_id/metrics` - the job's recorded metric
+/// history, oldest first (admin only)
+pub async fn job_metrics(
+    State(state): State<Arc<ServerState>>,
+    Path(job_id): Path<String>,
+    headers: HeaderMap,
+) -> Response {
+    if let Err(response) = authorize_admin(&headers) {
+        return response;
+    }
+
+    let Some(job) = state.fine_tuning.get(&job_id).await else {
+        return job_not_found(&job_id);
+    };
+
+    match read_metrics(&job.metrics_path()).await {
+        Ok(metrics) => Json(json!({
+            "object": "list",
+            "data": metrics
+        }))
+        .into_response(),
+        Err(e) => (
+            StatusCode::INTERNAL_SERVER_ERROR,
+            Json(json!({
+                "error": {
+                    "message": format!("Failed to read metric history: {}", e),
+                    "type": "internal_error",
+                    "param": null,
+                    "code": null
+                }
+            })),
+        )
+            .into_response(),
+    }
+}
+
+/// `GET /v1/fine_tuning/jobs/:job_id/stream` - live metrics, checkpoints and
+/// status changes as server-sent events (admin only)
+pub async fn stream_job(
+    State(state): State<Arc<ServerState>>,
+    Path(job_id): Path<String>,
+    headers: HeaderMap,
+) -> Response {
+    if let Err(response) = authorize_admin(&headers) {
+        return response;
+    }
+
+    let Some(current) = state.fine_tuning.get(&job_id).await else {
+        return job_not_found(&job_id);
+    };
+    let mut receiver = state.fine_tuning.subscribe();
+
+    let stream = async_stream::stream! {
+        let snapshot = TrainingEvent::status(&current);
+        yield Ok::<Event, axum::Error>(Event::default().event("status").data(serde_json::to_string(&snapshot).unwrap()));
+        if current.is_finished() {
+            return;
+        }
+
+        loop {
+            match receiver.recv().await {
+                Ok(event) if event.job_id == job_id => {
+                    let finished = event.job.as_ref().is_some_and(|job| job.is_finished());
+                    yield Ok(Event::default().event(event.kind.as_str()).data(serde_json::to_string(&event).unwrap()));
+                    if finished {
+                        break;
+                    }
+                }
+                Ok(_) | Err(broadcast::error::RecvError::Lagged(_)) => continue,
+                Err(broadcast::error::RecvError::Closed) => break,
+            }
+        }
+    };
+
+    Sse::new(stream)
+        .keep_alive(KeepAlive::default())
+        .into_response()
+}
+
+fn job_not_found(job_id: &str) -> Response {
+    (
+        StatusCode::NOT_FOUND,
+        Json(json!({
+            "error": {
+                "message": format!("No fine-tuning job with id {}", job_id),
+                "type": "invalid_request_error",
+                "param": "job_id",
+                "code": "fine_tuning_job_not_found"
+            }
+        })),
+    )
+        .into_response()
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use std::path::Path;
+
+    #[test]
+    fn parses_key_value_progress() {
+        let mut progress = TrainingProgress::default();
+        assert!(parse_progress("step=40 total=400 loss=1.92", &mut progress));
+        assert_eq!(progress.step, 40);
+        assert_eq!(progress.total_steps, Some(400));
+        assert_eq!(progress.loss, Some(1.92));
+        assert_eq!(progress.fraction, Some(0.1));
+    }
+
+    #[test]
+    fn parses_llama_cpp_padded_values() {
+        let mut progress = TrainingProgress::default();
+        let line = "opt_callback: iter=    12 sample=49/1024 sched=0.100000 loss=2.471650";
+        assert!(parse_progress(line, &mut progress));
+        assert_eq!(progress.step, 12);
+        assert_eq!(progress.loss, Some(2.47165));
+        assert_eq!(progress.fraction, None);
+    }
+
+    #[test]
+    fn ignores_unrelated_output() {
+        let mut progress = TrainingProgress::default();
+        assert!(!parse_progress(
+            "loading model from base.gguf",
+            &mut progress
+        ));
+        assert!(!parse_progress("loss=nan", &mut progress));
+        assert_eq!(progress, TrainingProgress::default());
+    }
+
+    #[test]
+    fn estimates_remaining_time_from_pace() {
+        let mut progress = TrainingProgress::default();
+        parse_progress("step=25 total=100 lr=0.0002", &mut progress);
+        assert_eq!(progress.learning_rate, Some(0.0002));
+        assert_eq!(estimate_eta(50.0, &progress), Some(150));
+
+        progress.total_steps = None;
+        assert_eq!(estimate_eta(50.0, &progress), None);
+    }
+
+    #[test]
+    fn finds_checkpoint_paths() {
+        assert_eq!(
+            parse_checkpoint("checkpoint=/tmp/ckpt-100.gguf step=100").as_deref(),
+            Some("/tmp/ckpt-100.gguf")
+        );
+        assert_eq!(
+            parse_checkpoint("save_checkpoint_lora_file: saving to checkpoint-20.gguf").as_deref(),
+            Some("checkpoint-20.gguf")
+        );
+        assert_eq!(parse_checkpoint("saving to adapter.gguf"), None);
+        assert_eq!(parse_checkpoint("step=3 loss=2.1"), None);
+    }
+
+    #[test]
+    fn builds_trainer_arguments() {
+        let hyperparameters = LoraHyperparameters {
+            seed: Some(7),
+            ..Default::default()
+        };
+        let args = trainer_args(
+            Path::new("/models/base.gguf"),
+            Path::new("/data/train.txt"),
+            Path::new("/models/fine-tuned/base-ft-x.gguf"),
+            &hyperparameters,
+        );
+        let pair = |flag: &str| {
+            let index = args.iter().position(|a| a == flag).unwrap();
+            args[index + 1].clone()
+        };
+        assert_eq!(pair("--model-base"), "/models/base.gguf");
+        assert_eq!(pair("--lora-r"), "8");
+        assert_eq!(pair("--lora-alpha"), "16");
+        assert_eq!(pair("--seed"), "7");
+    }
+
+    #[test]
+    fn names_adapters_after_the_base_model() {
+        let base = Path::new("/models/llama-3-8b.Q4_K_M.gguf");
+        assert_eq!(
+            adapter_name(base, Some("support"), "ftjob-1234"),
+            "llama-3-8b.Q4_K_M-ft-support"
+        );
+        assert_eq!(
+            adapter_name(base, None, "ftjob-0123456789ab"),
+            "llama-3-8b.Q4_K_M-ft-01234567"
+        );
+    }
+
+    #[test]
+    fn validates_hyperparameters_and_suffixes() {
+        assert!(LoraHyperparameters::default().validate().is_ok());
+        let bad_rank = LoraHyperparameters {
+            rank: 0,
+            ..Default::default()
+        };
+        assert!(bad_rank.validate().is_err());
+        assert!(valid_suffix("support_v2"));
+        assert!(!valid_suffix("../escape"));
+        assert!(!valid_suffix(""));
+    }
+}
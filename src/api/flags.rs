@@ -0,0 +1,365 @@
+//! Feature Flags
+//!
+//! Risky features (experimental samplers, newer endpoints) sit behind named
+//! flags so operators can roll them out gradually. Each flag has a default
+//! that can be overridden for the server's environment, named by the
+//! `INFERNO_ENV` variable, and again for individual API keys. The most
+//! specific override wins: API key, then environment, then the flag's own
+//! setting.
+//!
+//! Flags are managed under `/admin/flags` with the admin token. Only flags
+//! the server defines can be set, since an override for a name no code
+//! checks would silently do nothing. Overrides live in memory and reset on
+//! restart. API keys are stored as `sha256:<hex>` digests, so listing flags
+//! never reveals them; either form is accepted when setting overrides.
+
+use crate::{api::admin::authorize_admin, cli::serve::ServerState};
+use axum::{
+    Json,
+    extract::{Path, State},
+    http::{HeaderMap, StatusCode, header},
+    response::{IntoResponse, Response},
+};
+use chrono::{DateTime, Utc};
+use serde::{Deserialize, Serialize};
+use serde_json::json;
+use sha2::{Digest, Sha256};
+use std::{collections::BTreeMap, sync::Arc};
+use tokio::sync::RwLock;
+use tracing::info;
+
+/// Environment variable naming the deployment environment flags resolve in
+pub const ENVIRONMENT_ENV: &str = "INFERNO_ENV";
+
+/// Environment used when `INFERNO_ENV` is unset
+pub const DEFAULT_ENVIRONMENT: &str = "production";
+
+/// Flags the server checks: name, description and default
+pub const FLAGS: &[(&str, &str, bool)] = &[
+    (
+        "best_of",
+        "best_of sampling on chat and text completions",
+        true,
+    ),
+    ("extract", "Structured extraction at /v1/extract", true),
+    (
+        "summarize",
+        "Map-reduce summarization at /v1/summarize",
+        true,
+    ),
+    ("translate", "Chunked translation at /v1/translate", true),
+    ("sessions", "Server-side sessions at /v1/sessions", true),
+];
+
+/// Overrides of one flag
+#[derive(Debug, Clone, Default, Serialize, Deserialize)]
+pub struct FlagOverrides {
+    /// Replaces the flag's built-in default
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub enabled: Option<bool>,
+    /// Per-environment settings, by environment name
+    #[serde(default)]
+    pub environments: BTreeMap<String, bool>,
+    /// Per-API-key settings, by key or `sha256:<hex>` digest
+    #[serde(default)]
+    pub api_keys: BTreeMap<String, bool>,
+}
+
+impl FlagOverrides {
+    /// The setting for a request from `key` (a digest) in `environment`
+    fn resolve(&self, environment: &str, key: Option<&str>, default: bool) -> bool {
+        key.and_then(|key| self.api_keys.get(key).copied())
+            .or_else(|| self.environments.get(environment).copied())
+            .or(self.enabled)
+            .unwrap_or(default)
+    }
+}
+
+#[derive(Debug, Clone, Serialize)]
+pub struct FeatureFlag {
+    pub name: &'static str,
+    pub description: &'static str,
+    pub default: bool,
+    /// Whether the flag is on in this server's environment, before any
+    /// per-key override
+    pub enabled: bool,
+    pub overrides: FlagOverrides,
+    pub updated_at: Option<DateTime<Utc>>,
+}
+
+/// Flag overrides set through the admin API
+#[derive(Debug)]
+pub struct FlagStore {
+    environment: String,
+    overrides: RwLock<BTreeMap<&'static str, (FlagOverrides, DateTime<Utc>)>>,
+}
+
+impl FlagStore {
+    pub fn new() -> Self {
+        let environment = std::env::var(ENVIRONMENT_ENV)
+            .ok()
+            .filter(|env| !env.is_empty())
+            .unwrap_or_else(|| DEFAULT_ENVIRONMENT.to_string());
+        Self {
+            environment,
+            overrides: RwLock::new(BTreeMap::new()),
+        }
+    }
+
+    pub fn environment(&self) -> &str {
+        &self.environment
+    }
+
+    /// Whether `name` is on for a request with these headers
+    pub async fn is_enabled(&self, name: &str, headers: &HeaderMap) -> bool {
+        let Some(&(name, _, default)) = FLAGS.iter().find(|(flag, _, _)| *flag == name) else {
+            return false;
+        };
+        let overrides = self.overrides.read().await;
+        let Some((overrides, _)) = overrides.get(name) else {
+            return default;
+        };
+
+        let key = headers
+            .get(header::AUTHORIZATION)
+            .and_then(|value| value.to_str().ok())
+            .and_then(|value| value.strip_prefix("Bearer "))
+            .map(key_digest);
+        overrides.resolve(&self.environment, key.as_deref(), default)
+    }
+
+    /// Fail with a `feature_disabled` error unless `name` is on for the
+    /// request
+    pub async fn require(&self, name: &str, headers: &HeaderMap) -> Result<(), Response> {
+        if self.is_enabled(name, headers).await {
+            return Ok(());
+        }
+        Err((
+            StatusCode::FORBIDDEN,
+            Json(json!({
+                "error": {
+                    "message": format!("The {} feature is disabled on this server", name),
+                    "type": "invalid_request_error",
+                    "param": null,
+                    "code": "feature_disabled"
+                }
+            })),
+        )
+            .into_response())
+    }
+
+    pub async fn list(&self) -> Vec<FeatureFlag> {
+        let overrides = self.overrides.read().await;
+        FLAGS
+            .iter()
+            .map(|&(name, description, default)| {
+                let (overrides, updated_at) = match overrides.get(name) {
+                    Some((overrides, at)) => (overrides.clone(), Some(*at)),
+                    None => (FlagOverrides::default(), None),
+                };
+                FeatureFlag {
+                    name,
+                    description,
+                    default,
+                    enabled: overrides.resolve(&self.environment, None, default),
+                    overrides,
+                    updated_at,
+                }
+            })
+            .collect()
+    }
+
+    pub async fn get(&self, name: &str) -> Option<FeatureFlag> {
+        self.list().await.into_iter().find(|flag| flag.name == name)
+    }
+
+    /// Replace a flag's overrides; `None` if the server has no such flag
+    pub async fn set(&self, name: &str, mut overrides: FlagOverrides) -> Option<FeatureFlag> {
+        let &(name, _, _) = FLAGS.iter().find(|(flag, _, _)| *flag == name)?;
+        overrides.api_keys = overrides
+            .api_keys
+            .into_iter()
+            .map(|(key, enabled)| (key_digest(&key), enabled))
+            .collect();
+        self.overrides
+            .write()
+            .await
+            .insert(name, (overrides, Utc::now()));
+        self.get(name).await
+    }
+
+    /// Drop a flag's overrides, returning it to its default
+    pub async fn reset(&self, name: &str) -> Option<FeatureFlag> {
+        let &(name, _, _) = FLAGS.iter().find(|(flag, _, _)| *flag == name)?;
+        self.overrides.write().await.remove(name);
+        self.get(name).await
+    }
+}
+
+impl Default for FlagStore {
+    fn default() -> Self {
+        Self::new()
+    }
+}
+
+/// Digest an API key for storage; digests pass through unchanged
+fn key_digest(key: &str) -> String {
+    if key.starts_with("sha256:") {
+        return key.to_string();
+    }
+    format!("sha256:{}", hex::encode(Sha256::digest(key.as_bytes())))
+}
+
+fn flag_not_found(name: &str) -> Response {
+    (
+        StatusCode::NOT_FOUND,
+        Json(json!({
+            "error": {
+                "message": format!("No feature flag named '{}'", name),
+                "type": "invalid_request_error",
+                "param": "name",
+                "code": "flag_not_found"
+            }
+        })),
+    )
+        .into_response()
+}
+
+// API Handlers
+
+/// `GET /admin/flags` - every flag with its overrides (admin only)
+pub async fn list_flags(State(state): State<Arc<ServerState>>, headers: HeaderMap) -> Response {
+    if let Err(response) = authorize_admin(&headers) {
+        return response;
+    }
+
+    Json(json!({
+        "object": "list",
+        "environment": state.flags.environment(),
+        "data": state.flags.list().await,
+    }))
+    .into_response()
+}
+
+/// `GET /admin/flags/:name` - one flag (admin only)
+pub async fn get_flag(
+    State(state): State<Arc<ServerState>>,
+    Path(name): Path<String>,
+    headers: HeaderMap,
+) -> Response {
+    if let Err(response) = authorize_admin(&headers) {
+        return response;
+    }
+
+    match state.flags.get(&name).await {
+        Some(flag) => Json(flag).into_response(),
+        None => flag_not_found(&name),
+    }
+}
+
+/// `PUT /admin/flags/:name` - replace a flag's overrides (admin only)
+pub async fn put_flag(
+    State(state): State<Arc<ServerState>>,
+    Path(name): Path<String>,
+    headers: HeaderMap,
+    Json(overrides): Json<FlagOverrides>,
+) -> Response {
+    if let Err(response) = authorize_admin(&headers) {
+        return response;
+    }
+
+    match state.flags.set(&name, overrides).await {
+        Some(flag) => {
+            info!(
+                "Feature flag {} set: enabled in {} = {}",
+                flag.name,
+                state.flags.environment(),
+                flag.enabled
+            );
+            Json(flag).into_response()
+        }
+        None => flag_not_found(&name),
+    }
+}
+
+/// `DELETE /admin/flags/:name` - drop a flag's overrides (admin only)
+pub async fn reset_flag(
+    State(state): State<Arc<ServerState>>,
+    Path(name): Path<String>,
+    headers: HeaderMap,
+) -> Response {
+    if let Err(response) = authorize_admin(&headers) {
+        return response;
+    }
+
+    match state.flags.reset(&name).await {
+        Some(flag) => {
+            info!("Feature flag {} reset to its default", flag.name);
+            Json(flag).into_response()
+        }
+        None => flag_not_found(&name),
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use axum::http::HeaderValue;
+
+    fn store(environment: &str) -> FlagStore {
+        FlagStore {
+            environment: environment.to_string(),
+            overrides: RwLock::new(BTreeMap::new()),
+        }
+    }
+
+    fn bearer(key: &str) -> HeaderMap {
+        let mut headers = HeaderMap::new();
+        headers.insert(
+            header::AUTHORIZATION,
+            HeaderValue::from_str(&format!("Bearer {}", key)).unwrap(),
+        );
+        headers
+    }
+
+    #[tokio::test]
+    async fn test_defaults_and_unknown_flags() {
+        let flags = store("production");
+        assert!(flags.is_enabled("sessions", &HeaderMap::new()).await);
+        assert!(!flags.is_enabled("no_such_flag", &HeaderMap::new()).await);
+        assert!(
+            flags
+                .set("no_such_flag", FlagOverrides::default())
+                .await
+                .is_none()
+        );
+    }
+
+    #[tokio::test]
+    async fn test_key_overrides_environment_overrides_default() {
+        let overrides = FlagOverrides {
+            enabled: Some(false),
+            environments: BTreeMap::from([("staging".to_string(), true)]),
+            api_keys: BTreeMap::from([("sk-beta".to_string(), false)]),
+        };
+
+        let staging = store("staging");
+        let flag = staging.set("best_of", overrides.clone()).await.unwrap();
+        assert!(flag.enabled);
+        assert!(
+            flag.overrides
+                .api_keys
+                .keys()
+                .all(|key| key.starts_with("sha256:"))
+        );
+        assert!(staging.is_enabled("best_of", &HeaderMap::new()).await);
+        assert!(!staging.is_enabled("best_of", &bearer("sk-beta")).await);
+
+        let production = store("production");
+        production.set("best_of", overrides).await;
+        assert!(!production.is_enabled("best_of", &HeaderMap::new()).await);
+
+        staging.reset("best_of").await;
+        assert!(staging.is_enabled("best_of", &bearer("sk-beta")).await);
+    }
+}
@@ -0,0 +1,550 @@
+//! GPU Thermal and Power Telemetry
+//!
+//! A background sampler reads each GPU's temperature, power draw, clocks and
+//! utilization every few seconds and keeps an hour of samples per device.
+//! Every sample carries a `throttling` flag, so a drop in tokens per second
+//! can be lined up with the moments a GPU slowed itself down.
+//!
+//! On NVIDIA GPUs the readings and the throttle reasons come from
+//! `nvidia-smi`, which reports why the driver is holding clocks down. Other
+//! GPUs fall back to the GPU inventory, and throttling is inferred: a GPU at
+//! its temperature limit or power cap while running below its top clock.
+//!
+//! `GET /v1/telemetry/gpus` returns each device's latest sample with a
+//! summary of recent throttling, and `GET /v1/telemetry/gpus/{index}/samples`
+//! its history.
+
+use crate::{
+    api::cluster::vendor_name,
+    cli::serve::ServerState,
+    gpu::{GpuConfiguration, GpuManager},
+};
+use axum::{
+    Json,
+    extract::{Path, Query, State},
+    http::StatusCode,
+    response::{IntoResponse, Response},
+};
+use chrono::{DateTime, Duration as ChronoDuration, Utc};
+use serde::{Deserialize, Serialize};
+use serde_json::json;
+use std::{
+    collections::{BTreeMap, VecDeque},
+    sync::{Arc, RwLock},
+    time::Duration,
+};
+use tokio::process::Command;
+use tracing::{debug, info};
+
+/// Time between samples
+const SAMPLE_INTERVAL: Duration = Duration::from_secs(5);
+
+/// Samples kept per device: one hour at the sample interval
+const HISTORY_SAMPLES: usize = 720;
+
+/// Window the throttling summary covers, in minutes
+const SUMMARY_MINUTES: i64 = 5;
+
+/// Without driver throttle reasons, a GPU this hot or this close to its
+/// power limit while below its top clock counts as throttling
+const THERMAL_LIMIT_CELSIUS: f32 = 85.0;
+const POWER_CAP_RATIO: f32 = 0.98;
+const CLOCK_DROP_RATIO: f32 = 0.9;
+
+/// `nvidia-smi` throttle reason bits that mean the GPU is slowed down; the
+/// rest (idle, application clocks, sync boost, display clock) are not
+/// throttling
+const NVML_SW_POWER_CAP: u64 = 0x4;
+const NVML_HW_SLOWDOWN: u64 = 0x8;
+const NVML_SW_THERMAL: u64 = 0x20;
+const NVML_HW_THERMAL: u64 = 0x40;
+const NVML_HW_POWER_BRAKE: u64 = 0x80;
+
+/// Why a GPU is throttling
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Serialize, Deserialize)]
+#[serde(rename_all = "snake_case")]
+pub enum ThrottleReason {
+    /// Held back by the driver or hardware because of temperature
+    Thermal,
+    /// Held at its power limit
+    PowerCap,
+    /// Slowed by the hardware, such as an external power brake signal
+    Hardware,
+}
+
+/// One reading of one GPU
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct GpuSample {
+    pub timestamp: DateTime<Utc>,
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub temperature_celsius: Option<f32>,
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub power_draw_watts: Option<f32>,
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub power_limit_watts: Option<f32>,
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub sm_clock_mhz: Option<u32>,
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub max_sm_clock_mhz: Option<u32>,
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub memory_clock_mhz: Option<u32>,
+    pub utilization_percent: f32,
+    pub memory_used_mb: u64,
+    pub throttling: bool,
+    #[serde(default, skip_serializing_if = "Vec::is_empty")]
+    pub throttle_reasons: Vec<ThrottleReason>,
+}
+
+/// A reading with the device it came from
+#[derive(Debug, Clone)]
+struct DeviceReading {
+    index: u32,
+    name: String,
+    vendor: String,
+    memory_total_mb: u64,
+    sample: GpuSample,
+    /// Whether the throttle reasons came from the driver
+    reported: bool,
+}
+
+/// Throttling over the summary window
+#[derive(Debug, Clone, Serialize)]
+pub struct ThrottleSummary {
+    pub window_minutes: i64,
+    pub samples: usize,
+    pub throttled_samples: usize,
+    /// Share of the window's samples that were throttling, 0.0 to 1.0
+    pub throttled_ratio: f64,
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub max_temperature_celsius: Option<f32>,
+}
+
+/// A device's latest reading, returned by `GET /v1/telemetry/gpus`
+#[derive(Debug, Clone, Serialize)]
+pub struct GpuTelemetryEntry {
+    pub index: u32,
+    pub name: String,
+    pub vendor: String,
+    pub memory_total_mb: u64,
+    /// `driver` when throttle reasons come from the driver, `inferred` when
+    /// they are worked out from temperature, power and clocks
+    pub throttle_source: &'static str,
+    pub current: GpuSample,
+    pub recent: ThrottleSummary,
+}
+
+#[derive(Debug)]
+struct DeviceHistory {
+    name: String,
+    vendor: String,
+    memory_total_mb: u64,
+    reported: bool,
+    samples: VecDeque<GpuSample>,
+}
+
+/// Recent samples for every GPU the sampler has seen
+#[derive(Debug, Default)]
+pub struct GpuTelemetry {
+    devices: RwLock<BTreeMap<u32, DeviceHistory>>,
+}
+
+impl GpuTelemetry {
+    pub fn new() -> Self {
+        Self::default()
+    }
+
+    fn record(&self, reading: DeviceReading) {
+        let mut devices = self.devices.write().unwrap();
+        let device = devices
+            .entry(reading.index)
+            .or_insert_with(|| DeviceHistory {
+                name: reading.name.clone(),
+                vendor: reading.vendor.clone(),
+                memory_total_mb: reading.memory_total_mb,
+                reported: reading.reported,
+                samples: VecDeque::new(),
+            });
+        device.reported = reading.reported;
+        if device.samples.len() == HISTORY_SAMPLES {
+            device.samples.pop_front();
+        }
+        device.samples.push_back(reading.sample);
+    }
+
+    /// Every device's latest sample with its recent throttling
+    pub fn current(&self) -> Vec<GpuTelemetryEntry> {
+        let devices = self.devices.read().unwrap();
+        let window_start = Utc::now() - ChronoDuration::minutes(SUMMARY_MINUTES);
+        devices
+            .iter()
+            .filter_map(|(index, device)| {
+                let current = device.samples.back()?.clone();
+                Some(GpuTelemetryEntry {
+                    index: *index,
+                    name: device.name.clone(),
+                    vendor: device.vendor.clone(),
+                    memory_total_mb: device.memory_total_mb,
+                    throttle_source: if device.reported {
+                        "driver"
+                    } else {
+                        "inferred"
+                    },
+                    current,
+                    recent: summarize(
+                        device
+                            .samples
+                            .iter()
+                            .filter(|s| s.timestamp >= window_start),
+                    ),
+                })
+            })
+            .collect()
+    }
+
+    /// A device's samples after `since`, oldest first, keeping the newest
+    /// `limit`; `None` for a device the sampler has not seen
+    pub fn samples(
+        &self,
+        index: u32,
+        since: Option<DateTime<Utc>>,
+        limit: usize,
+    ) -> Option<Vec<GpuSample>> {
+        let devices = self.devices.read().unwrap();
+        let device = devices.get(&index)?;
+        let mut samples: Vec<GpuSample> = device
+            .samples
+            .iter()
+            .filter(|s| since.is_none_or(|since| s.timestamp > since))
+            .cloned()
+            .collect();
+        let skip = samples.len().saturating_sub(limit);
+        samples.drain(..skip);
+        Some(samples)
+    }
+}
+
+fn summarize<'a>(samples: impl Iterator<Item = &'a GpuSample>) -> ThrottleSummary {
+    let mut count = 0;
+    let mut throttled = 0;
+    let mut max_temperature: Option<f32> = None;
+    for sample in samples {
+        count += 1;
+        if sample.throttling {
+            throttled += 1;
+        }
+        if let Some(temperature) = sample.temperature_celsius {
+            max_temperature = Some(max_temperature.map_or(temperature, |t| t.max(temperature)));
+        }
+    }
+    ThrottleSummary {
+        window_minutes: SUMMARY_MINUTES,
+        samples: count,
+        throttled_samples: throttled,
+        throttled_ratio: if count == 0 {
+            0.0
+        } else {
+            throttled as f64 / count as f64
+        },
+        max_temperature_celsius: max_temperature,
+    }
+}
+
+/// Throttle reasons from `nvidia-smi`'s active reasons bitmask
+fn reasons_from_mask(mask: u64) -> Vec<ThrottleReason> {
+    let mut reasons = Vec::new();
+    if mask & (NVML_SW_THERMAL | NVML_HW_THERMAL) != 0 {
+        reasons.push(ThrottleReason::Thermal);
+    }
+    if mask & NVML_SW_POWER_CAP != 0 {
+        reasons.push(ThrottleReason::PowerCap);
+    }
+    if mask & (NVML_HW_SLOWDOWN | NVML_HW_POWER_BRAKE) != 0 {
+        reasons.push(ThrottleReason::Hardware);
+    }
+    reasons
+}
+
+/// Throttle reasons worked out from the readings when the driver gives none:
+/// only a GPU running below its top clock can be throttling
+fn infer_reasons(sample: &GpuSample) -> Vec<ThrottleReason> {
+    let clock_dropped = match (sample.sm_clock_mhz, sample.max_sm_clock_mhz) {
+        (Some(clock), Some(max)) if max > 0 => (clock as f32) < max as f32 * CLOCK_DROP_RATIO,
+        // Without clocks, a hot or capped GPU is assumed to be slowed
+        _ => true,
+    };
+    if !clock_dropped {
+        return Vec::new();
+    }
+
+    let mut reasons = Vec::new();
+    if sample
+        .temperature_celsius
+        .is_some_and(|t| t >= THERMAL_LIMIT_CELSIUS)
+    {
+        reasons.push(ThrottleReason::Thermal);
+    }
+    if let (Some(draw), Some(limit)) = (sample.power_draw_watts, sample.power_limit_watts)
+        && limit > 0.0
+        && draw >= limit * POWER_CAP_RATIO
+    {
+        reasons.push(ThrottleReason::PowerCap);
+    }
+    reasons
+}
+
+fn parse_field<T: std::str::FromStr>(field: &str) -> Option<T> {
+    // nvidia-smi reports unavailable readings as "[N/A]" or "[Not Supported]"
+    field.trim().parse().ok()
+}
+
+/// One line of `nvidia-smi --query-gpu` output for the fields in
+/// [`NVIDIA_QUERY`]
+fn parse_nvidia_line(line: &str, timestamp: DateTime<Utc>) -> Option<DeviceReading> {
+    let fields: Vec<&str> = line.split(',').map(str::trim).collect();
+    if fields.len() < 12 {
+        return None;
+    }
+
+    let mask = u64::from_str_radix(fields[11].trim_start_matches("0x"), 16).ok();
+    let mut sample = GpuSample {
+        timestamp,
+        temperature_celsius: parse_field(fields[2]),
+        power_draw_watts: parse_field(fields[3]),
+        power_limit_watts: parse_field(fields[4]),
+        sm_clock_mhz: parse_field(fields[5]),
+        max_sm_clock_mhz: parse_field(fields[6]),
+        memory_clock_mhz: parse_field(fields[7]),
+        utilization_percent: parse_field(fields[8]).unwrap_or(0.0),
+        memory_used_mb: parse_field(fields[9]).unwrap_or(0),
+        throttling: false,
+        throttle_reasons: Vec::new(),
+    };
+    sample.throttle_reasons = match mask {
+        Some(mask) => reasons_from_mask(mask),
+        None => infer_reasons(&sample),
+    };
+    sample.throttling = !sample.throttle_reasons.is_empty();
+
+    Some(DeviceReading {
+        index: parse_field(fields[0])?,
+        name: fields[1].to_string(),
+        vendor: "nvidia".to_string(),
+        memory_total_mb: parse_field(fields[10]).unwrap_or(0),
+        sample,
+        reported: mask.is_some(),
+    })
+}
+
+const NVIDIA_QUERY: &str = "--query-gpu=index,name,temperature.gpu,power.draw,power.limit,clocks.sm,clocks.max.sm,clocks.mem,utilization.gpu,memory.used,memory.total,clocks_throttle_reasons.active";
+
+/// Readings from `nvidia-smi`, or `None` when it is not installed or fails
+async fn read_nvidia() -> Option<Vec<DeviceReading>> {
+    let output = Command::new("nvidia-smi")
+        .arg(NVIDIA_QUERY)
+        .arg("--format=csv,noheader,nounits")
+        .output()
+        .await
+        .ok()?;
+    if !output.status.success() {
+        return None;
+    }
+
+    let timestamp = Utc::now();
+    Some(
+        String::from_utf8_lossy(&output.stdout)
+            .lines()
+            .filter_map(|line| parse_nvidia_line(line, timestamp))
+            .collect(),
+    )
+}
+
+/// Readings from the GPU inventory, for GPUs `nvidia-smi` does not cover
+async fn read_inventory(manager: &GpuManager) -> Vec<DeviceReading> {
+    if let Err(e) = manager.refresh_gpu_info().await {
+        debug!("GPU telemetry refresh failed: {}", e);
+    }
+    let timestamp = Utc::now();
+    manager
+        .list_gpus()
+        .await
+        .into_iter()
+        .map(|gpu| {
+            let mut sample = GpuSample {
+                timestamp,
+                temperature_celsius: gpu.temperature_celsius,
+                power_draw_watts: gpu.power_usage_watts,
+                power_limit_watts: gpu.power_limit_watts,
+                sm_clock_mhz: gpu.clock_speed_mhz,
+                max_sm_clock_mhz: None,
+                memory_clock_mhz: gpu.memory_clock_mhz,
+                utilization_percent: gpu.utilization_percent,
+                memory_used_mb: gpu.memory_used_mb,
+                throttling: false,
+                throttle_reasons: Vec::new(),
+            };
+            sample.throttle_reasons = infer_reasons(&sample);
+            sample.throttling = !sample.throttle_reasons.is_empty();
+            DeviceReading {
+                index: gpu.id,
+                name: gpu.name,
+                vendor: vendor_name(&gpu.vendor),
+                memory_total_mb: gpu.memory_total_mb,
+                sample,
+                reported: false,
+            }
+        })
+        .collect()
+}
+
+/// Background loop sampling every GPU for the lifetime of the server; it
+/// stops on hosts without GPUs
+pub async fn run_sampler(state: Arc<ServerState>) {
+    // Detection only; the inventory does not need its own monitoring
+    let manager = GpuManager::new(GpuConfiguration {
+        enabled: false,
+        ..Default::default()
+    });
+    let use_nvidia = read_nvidia()
+        .await
+        .is_some_and(|readings| !readings.is_empty());
+    if !use_nvidia && read_inventory(&manager).await.is_empty() {
+        info!("No GPUs found; GPU telemetry is off");
+        return;
+    }
+
+    let mut interval = tokio::time::interval(SAMPLE_INTERVAL);
+    loop {
+        interval.tick().await;
+        let readings = if use_nvidia {
+            read_nvidia().await.unwrap_or_default()
+        } else {
+            read_inventory(&manager).await
+        };
+        for reading in readings {
+            state.gpu_telemetry.record(reading);
+        }
+    }
+}
+
+#[derive(Debug, Default, Deserialize)]
+pub struct SamplesQuery {
+    /// Only samples after this time (RFC 3339)
+    pub since: Option<DateTime<Utc>>,
+    /// Most samples returned, newest kept
+    pub limit: Option<usize>,
+}
+
+// API Handlers
+
+/// `GET /v1/telemetry/gpus` - each GPU's latest thermal and power reading
+/// and recent throttling
+pub async fn list_gpus(State(state): State<Arc<ServerState>>) -> Response {
+    Json(json!({
+        "object": "list",
+        "sample_interval_seconds": SAMPLE_INTERVAL.as_secs(),
+        "data": state.gpu_telemetry.current(),
+    }))
+    .into_response()
+}
+
+/// `GET /v1/telemetry/gpus/:index/samples` - one GPU's sample history,
+/// oldest first
+pub async fn gpu_samples(
+    State(state): State<Arc<ServerState>>,
+    Path(index): Path<u32>,
+    Query(query): Query<SamplesQuery>,
+) -> Response {
+    let limit = query
+        .limit
+        .unwrap_or(HISTORY_SAMPLES)
+        .clamp(1, HISTORY_SAMPLES);
+    match state.gpu_telemetry.samples(index, query.since, limit) {
+        Some(samples) => Json(json!({
+            "object": "list",
+            "index": index,
+            "data": samples,
+        }))
+        .into_response(),
+        None => (
+            StatusCode::NOT_FOUND,
+            Json(json!({
+                "error": {
+                    "message": format!("No telemetry for GPU {}", index),
+                    "type": "invalid_request_error",
+                    "param": "index",
+                    "code": "gpu_not_found"
+                }
+            })),
+        )
+            .into_response(),
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_parse_nvidia_line_with_thermal_throttle() {
+        let line = "0, NVIDIA A100-SXM4-80GB, 87, 398.52, 400.00, 1095, 1410, 1593, 100, 61234, 81920, 0x0000000000000044";
+        let reading = parse_nvidia_line(line, Utc::now()).unwrap();
+        assert_eq!(reading.index, 0);
+        assert_eq!(reading.memory_total_mb, 81920);
+        assert!(reading.reported);
+        assert_eq!(reading.sample.sm_clock_mhz, Some(1095));
+        assert!(reading.sample.throttling);
+        assert_eq!(
+            reading.sample.throttle_reasons,
+            vec![ThrottleReason::Thermal, ThrottleReason::PowerCap]
+        );
+    }
+
+    #[test]
+    fn test_idle_and_unsupported_fields_are_not_throttling() {
+        let line = "1, Tesla T4, 41, [N/A], 70.00, 300, 1590, 405, 0, 3, 15360, 0x0000000000000001";
+        let reading = parse_nvidia_line(line, Utc::now()).unwrap();
+        assert_eq!(reading.sample.power_draw_watts, None);
+        assert!(!reading.sample.throttling);
+    }
+
+    #[test]
+    fn test_inferred_throttle_needs_clock_drop() {
+        let mut sample = GpuSample {
+            timestamp: Utc::now(),
+            temperature_celsius: Some(90.0),
+            power_draw_watts: Some(250.0),
+            power_limit_watts: Some(300.0),
+            sm_clock_mhz: Some(1800),
+            max_sm_clock_mhz: Some(1830),
+            memory_clock_mhz: None,
+            utilization_percent: 100.0,
+            memory_used_mb: 0,
+            throttling: false,
+            throttle_reasons: Vec::new(),
+        };
+        assert!(infer_reasons(&sample).is_empty());
+
+        sample.sm_clock_mhz = Some(1200);
+        assert_eq!(infer_reasons(&sample), vec![ThrottleReason::Thermal]);
+    }
+
+    #[test]
+    fn test_history_is_bounded_and_summarized() {
+        let telemetry = GpuTelemetry::new();
+        let line = "0, GPU, 87, 300, 300, 1000, 1400, 1500, 100, 1, 1, 0x0000000000000040";
+        for _ in 0..HISTORY_SAMPLES + 3 {
+            telemetry.record(parse_nvidia_line(line, Utc::now()).unwrap());
+        }
+        assert_eq!(
+            telemetry.samples(0, None, HISTORY_SAMPLES).unwrap().len(),
+            HISTORY_SAMPLES
+        );
+        assert_eq!(telemetry.samples(0, None, 10).unwrap().len(), 10);
+        assert!(telemetry.samples(1, None, 10).is_none());
+
+        let current = telemetry.current();
+        assert_eq!(current.len(), 1);
+        assert_eq!(current[0].throttle_source, "driver");
+        assert_eq!(current[0].recent.throttled_ratio, 1.0);
+    }
+}
@@ -0,0 +1,409 @@
+//! Tiered Health Checks
+//!
+//! Three probes for orchestrators and load balancers, each cheaper than the
+//! next:
+//!
+//! - `GET /health/live` answers whenever the process can serve HTTP; a
+//!   failing liveness probe means restart the server.
+//! - `GET /health/ready` checks that the server is taking work: it is not in
+//!   maintenance or draining, the startup model (if any) is loaded and the
+//!   request queue is below `max_concurrent_requests`. A failing readiness
+//!   probe means route traffic elsewhere.
+//! - `GET /health/deep` additionally probes the GPUs, the free space on the
+//!   models disk and runs a one-token test inference on the loaded model. It
+//!   is meant for diagnostics and infrequent checks, not every few seconds.
+//!
+//! Every probe returns the same shape: an overall `status` of `pass`, `warn`
+//! or `fail` and the `checks` that produced it. Only `fail` turns the
+//! response into a 503.
+
+use crate::{
+    api::operations::ServerMode,
+    backends::InferenceParams,
+    cli::serve::ServerState,
+    gpu::{GpuConfiguration, GpuManager},
+};
+use axum::{
+    Json,
+    extract::{Query, State},
+    http::StatusCode,
+    response::{IntoResponse, Response},
+};
+use chrono::{DateTime, Utc};
+use serde::{Deserialize, Serialize};
+use serde_json::{Value, json};
+use std::{
+    path::Path,
+    sync::Arc,
+    time::{Duration, Instant},
+};
+use sysinfo::{DiskExt, System, SystemExt};
+use tracing::warn;
+
+/// Free space on the models disk below which the disk check warns and fails
+const DISK_WARN_BYTES: u64 = 10 * 1024 * 1024 * 1024;
+const DISK_FAIL_BYTES: u64 = 1024 * 1024 * 1024;
+
+/// Queue fill, as a fraction of `max_concurrent_requests`, at which the
+/// queue check warns
+const QUEUE_WARN_RATIO: f64 = 0.8;
+
+/// How long the deep probe's test inference may take
+const TEST_INFERENCE_TIMEOUT: Duration = Duration::from_secs(30);
+
+/// Outcome of one check, ordered from best to worst
+#[derive(Debug, Clone, Copy, PartialEq, Eq, PartialOrd, Ord, Serialize, Deserialize)]
+#[serde(rename_all = "snake_case")]
+pub enum CheckStatus {
+    Pass,
+    Warn,
+    Fail,
+}
+
+/// One component's health
+#[derive(Debug, Clone, Serialize)]
+pub struct ComponentCheck {
+    pub name: &'static str,
+    pub status: CheckStatus,
+    pub message: String,
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub details: Option<Value>,
+    /// How long the check took; only reported for checks that do real work
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub duration_ms: Option<u64>,
+}
+
+impl ComponentCheck {
+    fn new(name: &'static str, status: CheckStatus, message: impl Into<String>) -> Self {
+        Self {
+            name,
+            status,
+            message: message.into(),
+            details: None,
+            duration_ms: None,
+        }
+    }
+
+    fn with_details(mut self, details: Value) -> Self {
+        self.details = Some(details);
+        self
+    }
+
+    fn timed(mut self, started: Instant) -> Self {
+        self.duration_ms = Some(started.elapsed().as_millis() as u64);
+        self
+    }
+}
+
+/// A probe's response
+#[derive(Debug, Clone, Serialize)]
+pub struct HealthReport {
+    pub status: CheckStatus,
+    pub probe: &'static str,
+    pub version: &'static str,
+    pub timestamp: DateTime<Utc>,
+    pub checks: Vec<ComponentCheck>,
+}
+
+impl HealthReport {
+    fn new(probe: &'static str, checks: Vec<ComponentCheck>) -> Self {
+        let status = checks
+            .iter()
+            .map(|check| check.status)
+            .max()
+            .unwrap_or(CheckStatus::Pass);
+        Self {
+            status,
+            probe,
+            version: env!("CARGO_PKG_VERSION"),
+            timestamp: Utc::now(),
+            checks,
+        }
+    }
+}
+
+impl IntoResponse for HealthReport {
+    fn into_response(self) -> Response {
+        let code = if self.status == CheckStatus::Fail {
+            StatusCode::SERVICE_UNAVAILABLE
+        } else {
+            StatusCode::OK
+        };
+        (code, Json(self)).into_response()
+    }
+}
+
+/// Query for `GET /health/deep`
+#[derive(Debug, Default, Deserialize)]
+pub struct DeepQuery {
+    /// Skip the test inference (default false)
+    #[serde(default)]
+    pub skip_inference: bool,
+}
+
+/// `GET /health/live`
+pub async fn live() -> HealthReport {
+    HealthReport::new(
+        "live",
+        vec![ComponentCheck::new(
+            "process",
+            CheckStatus::Pass,
+            "serving HTTP",
+        )],
+    )
+}
+
+/// `GET /health/ready`
+pub async fn ready(State(state): State<Arc<ServerState>>) -> HealthReport {
+    HealthReport::new("ready", readiness_checks(&state).await)
+}
+
+/// `GET /health/deep`
+pub async fn deep(
+    State(state): State<Arc<ServerState>>,
+    Query(query): Query<DeepQuery>,
+) -> HealthReport {
+    let mut checks = readiness_checks(&state).await;
+    checks.push(check_gpus().await);
+    checks.push(check_disk(&state.config.models_dir));
+    checks.push(if query.skip_inference {
+        ComponentCheck::new("inference", CheckStatus::Pass, "skipped on request")
+    } else {
+        check_inference(&state).await
+    });
+    HealthReport::new("deep", checks)
+}
+
+async fn readiness_checks(state: &ServerState) -> Vec<ComponentCheck> {
+    vec![
+        check_mode(state),
+        check_model(state).await,
+        check_queue(state),
+    ]
+}
+
+fn check_mode(state: &ServerState) -> ComponentCheck {
+    match state.operations.mode() {
+        ServerMode::Serving => ComponentCheck::new("mode", CheckStatus::Pass, "serving"),
+        ServerMode::Maintenance => {
+            ComponentCheck::new("mode", CheckStatus::Fail, "in maintenance mode")
+        }
+        ServerMode::Draining => {
+            ComponentCheck::new("mode", CheckStatus::Fail, "draining in-flight requests")
+        }
+    }
+}
+
+/// The startup model must be loaded; without one, models load on first use
+/// and the server is ready regardless
+async fn check_model(state: &ServerState) -> ComponentCheck {
+    let Some(backend) = &state.backend else {
+        return ComponentCheck::new(
+            "model",
+            CheckStatus::Pass,
+            "no model preloaded; models load on first use",
+        );
+    };
+
+    let model = state.loaded_model.clone().unwrap_or_default();
+    if backend.is_loaded().await {
+        ComponentCheck::new("model", CheckStatus::Pass, format!("{} loaded", model))
+            .with_details(json!({ "model": model }))
+    } else {
+        ComponentCheck::new(
+            "model",
+            CheckStatus::Fail,
+            format!("{} is not loaded", model),
+        )
+        .with_details(json!({ "model": model }))
+    }
+}
+
+/// The queue counts queued and running requests; once it reaches
+/// `max_concurrent_requests` new generation requests are refused with 429
+fn check_queue(state: &ServerState) -> ComponentCheck {
+    let depth = state.request_queue.len();
+    let limit = state.runtime_config.current().max_concurrent_requests as usize;
+    let details = json!({ "depth": depth, "limit": limit });
+
+    if limit == 0 {
+        return ComponentCheck::new(
+            "queue",
+            CheckStatus::Pass,
+            format!("{} requests, no limit", depth),
+        )
+        .with_details(details);
+    }
+
+    let status = if depth >= limit {
+        CheckStatus::Fail
+    } else if depth as f64 >= limit as f64 * QUEUE_WARN_RATIO {
+        CheckStatus::Warn
+    } else {
+        CheckStatus::Pass
+    };
+    ComponentCheck::new(
+        "queue",
+        status,
+        format!("{} of {} slots in use", depth, limit),
+    )
+    .with_details(details)
+}
+
+/// Probes the GPUs afresh; a host without GPUs runs on the CPU and passes
+async fn check_gpus() -> ComponentCheck {
+    let started = Instant::now();
+    let manager = GpuManager::new(GpuConfiguration {
+        enabled: false,
+        ..Default::default()
+    });
+    if let Err(e) = manager.refresh_gpu_info().await {
+        warn!("Health check GPU probe failed: {}", e);
+        return ComponentCheck::new("gpu", CheckStatus::Fail, format!("GPU probe failed: {}", e))
+            .timed(started);
+    }
+
+    let gpus = manager.list_gpus().await;
+    if gpus.is_empty() {
+        return ComponentCheck::new("gpu", CheckStatus::Pass, "no GPUs detected; using CPU")
+            .timed(started);
+    }
+
+    let details: Vec<Value> = gpus
+        .iter()
+        .map(|gpu| {
+            json!({
+                "index": gpu.id,
+                "name": gpu.name,
+                "memory_total_mb": gpu.memory_total_mb,
+                "memory_free_mb": gpu.memory_free_mb,
+                "utilization_percent": gpu.utilization_percent,
+            })
+        })
+        .collect();
+    ComponentCheck::new(
+        "gpu",
+        CheckStatus::Pass,
+        format!("{} GPU(s) responding", gpus.len()),
+    )
+    .with_details(json!(details))
+    .timed(started)
+}
+
+/// Free space on the disk holding the models directory
+fn check_disk(models_dir: &Path) -> ComponentCheck {
+    let mut system = System::new();
+    system.refresh_disks_list();
+
+    // The deepest mount point containing the directory is its disk
+    let disk = system
+        .disks()
+        .iter()
+        .filter(|disk| models_dir.starts_with(disk.mount_point()))
+        .max_by_key(|disk| disk.mount_point().components().count());
+    let Some(disk) = disk else {
+        return ComponentCheck::new(
+            "disk",
+            CheckStatus::Warn,
+            format!("cannot find the disk holding {}", models_dir.display()),
+        );
+    };
+
+    let available = disk.available_space();
+    let status = if available < DISK_FAIL_BYTES {
+        CheckStatus::Fail
+    } else if available < DISK_WARN_BYTES {
+        CheckStatus::Warn
+    } else {
+        CheckStatus::Pass
+    };
+    ComponentCheck::new(
+        "disk",
+        status,
+        format!("{} MB free", available / 1024 / 1024),
+    )
+    .with_details(json!({
+        "path": models_dir.display().to_string(),
+        "mount_point": disk.mount_point().display().to_string(),
+        "available_bytes": available,
+        "total_bytes": disk.total_space(),
+    }))
+}
+
+/// Generates one token from the loaded model
+async fn check_inference(state: &ServerState) -> ComponentCheck {
+    let Some(backend) = &state.backend else {
+        return ComponentCheck::new(
+            "inference",
+            CheckStatus::Pass,
+            "no model preloaded; nothing to test",
+        );
+    };
+
+    let params = InferenceParams {
+        max_tokens: 1,
+        temperature: 0.0,
+        stream: false,
+        ..Default::default()
+    };
+    let started = Instant::now();
+    let check =
+        match tokio::time::timeout(TEST_INFERENCE_TIMEOUT, backend.infer("Hello", &params)).await {
+            Ok(Ok(_)) => {
+                ComponentCheck::new("inference", CheckStatus::Pass, "test inference succeeded")
+            }
+            Ok(Err(e)) => ComponentCheck::new(
+                "inference",
+                CheckStatus::Fail,
+                format!("test inference failed: {}", e),
+            ),
+            Err(_) => ComponentCheck::new(
+                "inference",
+                CheckStatus::Fail,
+                format!(
+                    "test inference took longer than {}s",
+                    TEST_INFERENCE_TIMEOUT.as_secs()
+                ),
+            ),
+        };
+    check
+        .with_details(json!({ "model": state.loaded_model }))
+        .timed(started)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_report_status_is_worst_check() {
+        let report = HealthReport::new(
+            "ready",
+            vec![
+                ComponentCheck::new("mode", CheckStatus::Pass, "serving"),
+                ComponentCheck::new("queue", CheckStatus::Warn, "busy"),
+            ],
+        );
+        assert_eq!(report.status, CheckStatus::Warn);
+
+        let report = HealthReport::new(
+            "ready",
+            vec![
+                ComponentCheck::new("queue", CheckStatus::Warn, "busy"),
+                ComponentCheck::new("model", CheckStatus::Fail, "not loaded"),
+            ],
+        );
+        assert_eq!(report.status, CheckStatus::Fail);
+        assert_eq!(
+            report.into_response().status(),
+            StatusCode::SERVICE_UNAVAILABLE
+        );
+    }
+
+    #[test]
+    fn test_empty_report_passes() {
+        let report = HealthReport::new("live", Vec::new());
+        assert_eq!(report.status, CheckStatus::Pass);
+        assert_eq!(report.into_response().status(), StatusCode::OK);
+    }
+}
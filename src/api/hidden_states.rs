@@ -0,0 +1,210 @@
+//! Hidden-State Extraction
+//!
+//! `POST /v1/hidden_states` runs inputs through a generative model and
+//! returns its final-layer hidden states, either one vector per token or
+//! pooled into one vector per input. Unlike `/v1/embeddings`, which expects
+//! an embedding model, this works on any GGUF chat or base model, so its
+//! representations can feed custom similarity search or classifiers.
+
+use crate::{
+    api::{
+        invalid_request,
+        openai::{StringOrArray, get_or_load_backend},
+    },
+    cli::serve::ServerState,
+};
+use axum::{
+    Json,
+    extract::State,
+    response::{IntoResponse, Response},
+};
+use serde::{Deserialize, Serialize};
+use serde_json::json;
+use std::sync::Arc;
+
+/// Most texts a single request may carry
+const MAX_HIDDEN_STATE_INPUTS: usize = 64;
+
+/// How per-token hidden states are reduced to one vector per input
+#[derive(Debug, Clone, Copy, Default, PartialEq, Eq, Serialize, Deserialize)]
+#[serde(rename_all = "lowercase")]
+pub enum Pooling {
+    /// Every token's vector, in order
+    None,
+    /// Average over the tokens
+    #[default]
+    Mean,
+    /// The last token's vector, which has attended to the whole input
+    Last,
+    /// Element-wise maximum over the tokens
+    Max,
+}
+
+impl Pooling {
+    fn apply(self, tokens: Vec<Vec<f32>>) -> Vec<Vec<f32>> {
+        let width = tokens.first().map_or(0, |state| state.len());
+        match self {
+            Pooling::None => tokens,
+            Pooling::Last => tokens.into_iter().last().into_iter().collect(),
+            Pooling::Mean => {
+                let mut mean = vec![0.0f32; width];
+                for state in &tokens {
+                    mean.iter_mut().zip(state).for_each(|(m, v)| *m += v);
+                }
+                let count = tokens.len().max(1) as f32;
+                mean.iter_mut().for_each(|m| *m /= count);
+                vec![mean]
+            }
+            Pooling::Max => {
+                let mut max = vec![f32::NEG_INFINITY; width];
+                for state in &tokens {
+                    max.iter_mut().zip(state).for_each(|(m, v)| *m = m.max(*v));
+                }
+                vec![max]
+            }
+        }
+    }
+}
+
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct HiddenStatesRequest {
+    pub model: String,
+    pub input: StringOrArray,
+    #[serde(default)]
+    pub pooling: Pooling,
+    /// Scale every returned vector to unit length
+    #[serde(default)]
+    pub normalize: bool,
+}
+
+/// Which layer the vectors come from
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct LayerInfo {
+    /// Index of the layer, counting transformer blocks from 1; the final
+    /// block when `num_layers` is known
+    pub layer: Option<u32>,
+    pub num_layers: Option<u32>,
+    pub hidden_size: usize,
+    pub pooling: Pooling,
+    pub normalized: bool,
+}
+
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct HiddenStateData {
+    pub index: usize,
+    /// Tokens the input was split into, including any BOS token
+    pub tokens: usize,
+    /// One vector per token without pooling, otherwise a single vector
+    pub hidden_states: Vec<Vec<f32>>,
+}
+
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct HiddenStatesResponse {
+    pub object: String,
+    pub model: String,
+    pub layer: LayerInfo,
+    pub data: Vec<HiddenStateData>,
+}
+
+fn unit_length(mut state: Vec<f32>) -> Vec<f32> {
+    let norm = state.iter().map(|v| v * v).sum::<f32>().sqrt();
+    if norm > 0.0 {
+        state.iter_mut().for_each(|v| *v /= norm);
+    }
+    state
+}
+
+// API Handlers
+
+/// `POST /v1/hidden_states` - final-layer hidden states of each input
+pub async fn hidden_states(
+    State(state): State<Arc<ServerState>>,
+    Json(request): Json<HiddenStatesRequest>,
+) -> Response {
+    let inputs = match request.input {
+        StringOrArray::String(text) => vec![text],
+        StringOrArray::Array(texts) => texts,
+    };
+    if inputs.is_empty() || inputs.len() > MAX_HIDDEN_STATE_INPUTS {
+        return invalid_request(
+            format!("input must hold 1 to {} texts", MAX_HIDDEN_STATE_INPUTS),
+            Some("input"),
+            None,
+        );
+    }
+
+    let backend = match get_or_load_backend(&state, &request.model).await {
+        Ok(backend) => backend,
+        Err(e) => {
+            return invalid_request(format!("Failed to load model: {}", e), Some("model"), None);
+        }
+    };
+
+    let mut data = Vec::with_capacity(inputs.len());
+    let mut num_layers = None;
+    let mut hidden_size = 0;
+    for (index, text) in inputs.iter().enumerate() {
+        let states = match backend.hidden_states(text).await {
+            Ok(states) => states,
+            Err(e) => {
+                return invalid_request(
+                    e.to_string(),
+                    Some("model"),
+                    Some("hidden_states_not_supported"),
+                );
+            }
+        };
+        num_layers = states.num_layers;
+        hidden_size = states.tokens.first().map_or(0, |state| state.len());
+
+        let tokens = states.tokens.len();
+        let mut hidden_states = request.pooling.apply(states.tokens);
+        if request.normalize {
+            hidden_states = hidden_states.into_iter().map(unit_length).collect();
+        }
+        data.push(HiddenStateData {
+            index,
+            tokens,
+            hidden_states,
+        });
+    }
+
+    Json(HiddenStatesResponse {
+        object: "list".to_string(),
+        model: request.model,
+        layer: LayerInfo {
+            layer: num_layers,
+            num_layers,
+            hidden_size,
+            pooling: request.pooling,
+            normalized: request.normalize,
+        },
+        data,
+    })
+    .into_response()
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn states() -> Vec<Vec<f32>> {
+        vec![vec![1.0, -2.0], vec![3.0, 4.0]]
+    }
+
+    #[test]
+    fn test_pooling() {
+        assert_eq!(Pooling::None.apply(states()), states());
+        assert_eq!(Pooling::Mean.apply(states()), vec![vec![2.0, 1.0]]);
+        assert_eq!(Pooling::Last.apply(states()), vec![vec![3.0, 4.0]]);
+        assert_eq!(Pooling::Max.apply(states()), vec![vec![3.0, 4.0]]);
+    }
+
+    #[test]
+    fn test_pooling_defaults_to_mean() {
+        let request: HiddenStatesRequest =
+            serde_json::from_value(json!({ "model": "m", "input": "hi" })).unwrap();
+        assert_eq!(request.pooling, Pooling::Mean);
+        assert!(!request.normalize);
+    }
+}
@@ -0,0 +1,1462 @@
+//! Hugging Face Hub Model Pulls
+//!
+//! `POST /v1/models/download` with `{"source": "hf://org/repo[:revision]"}`
+//! resolves the repo on the hub, picks one GGUF file and downloads it into the
+//! models directory in the background. Without an explicit `file`, the first
+//! quantization in the request's `quantization` list (or
+//! `hub.quantization_preference`) that the repo offers is chosen; split
+//! (`-00001-of-00003`) and `mmproj` files are never picked.
+//!
+//! The revision is pinned to the commit it resolved to, so a branch moving
+//! mid-download cannot mix files. The download is hashed as it streams and
+//! compared with the SHA-256 the hub publishes for LFS files; a mismatch
+//! discards the file. Verified files are validated, registered in the model
+//! registry and tagged `hub` and `hf:<org>/<repo>`.
+//!
+//! Gated and private repos need a token, read from `hub.token` or the
+//! `HF_TOKEN` environment variable.
+//!
+//! `ollama://[namespace/]model[:tag]` sources are pulled from an Ollama
+//! registry (`hub.ollama_registry`): the manifest's
+//! `application/vnd.ollama.image.model` layer is the GGUF file, and its digest
+//! is the checksum. The model is tagged `alias:<model>:<tag>` (and
+//! `alias:<model>` for `latest`) so requests keep using the Ollama name.
+
+use crate::{
+    api::{
+        admin::authorize_admin, cancellation::CancelSignal, error_response, internal_error,
+        invalid_request, model_events::ModelEventType,
+    },
+    cli::serve::ServerState,
+};
+use axum::{
+    Json,
+    extract::{Path, State},
+    http::{HeaderMap, StatusCode},
+    response::{IntoResponse, Response},
+};
+use futures::StreamExt;
+use serde::{Deserialize, Serialize};
+use serde_json::json;
+use sha2::{Digest, Sha256};
+use std::{collections::HashMap, path::PathBuf, sync::Arc, time::Duration};
+use tokio::{fs, io::AsyncWriteExt, sync::RwLock};
+use tracing::{info, warn};
+use uuid::Uuid;
+
+/// Finished downloads kept for retrieval; the oldest are dropped first
+const MAX_RETAINED_DOWNLOADS: usize = 100;
+
+/// Bytes received between progress updates to the stored download
+const PROGRESS_INTERVAL_BYTES: u64 = 4 * 1024 * 1024;
+
+/// Hub settings under `[hub]` in the config file
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct HubConfig {
+    /// Base URL of the hub, without a trailing slash
+    pub endpoint: String,
+    /// Access token for gated and private repos; `HF_TOKEN` is used if unset
+    pub token: Option<String>,
+    /// Quantizations to look for, most preferred first
+    pub quantization_preference: Vec<String>,
+    /// Base URL of the registry `ollama://` sources are pulled from
+    #[serde(default = "default_ollama_registry")]
+    pub ollama_registry: String,
+}
+
+fn default_ollama_registry() -> String {
+    "https://registry.ollama.ai".to_string()
+}
+
+impl Default for HubConfig {
+    fn default() -> Self {
+        Self {
+            endpoint: "https://huggingface.co".to_string(),
+            token: None,
+            quantization_preference: [
+                "Q4_K_M", "Q4_K_S", "Q5_K_M", "Q5_K_S", "Q4_0", "Q6_K", "Q8_0", "IQ4_XS", "F16",
+                "BF16",
+            ]
+            .iter()
+            .map(|quantization| quantization.to_string())
+            .collect(),
+            ollama_registry: default_ollama_registry(),
+        }
+    }
+}
+
+impl HubConfig {
+    fn token(&self) -> Option<String> {
+        self.token
+            .clone()
+            .or_else(|| std::env::var("HF_TOKEN").ok())
+            .filter(|token| !token.is_empty())
+    }
+}
+
+/// Body of `POST /v1/models/download`
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct ModelDownloadRequest {
+    /// `hf://org/repo[:revision]` or `ollama://[namespace/]model[:tag]`
+    pub source: String,
+    /// Exact file in the repo; skips quantization matching (`hf://` only)
+    #[serde(default)]
+    pub file: Option<String>,
+    /// Quantizations to look for, most preferred first (`hf://` only)
+    #[serde(default)]
+    pub quantization: Vec<String>,
+    /// File name to save as in the models directory
+    #[serde(default)]
+    pub name: Option<String>,
+}
+
+/// A parsed `hf://` source
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct HubSource {
+    pub repo: String,
+    pub revision: String,
+}
+
+/// A file in a hub repo
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct HubFile {
+    pub name: String,
+    pub size: Option<u64>,
+    /// Published for LFS files only
+    pub sha256: Option<String>,
+}
+
+/// A repo resolved at one commit
+#[derive(Debug, Clone)]
+pub struct HubRepo {
+    pub commit: String,
+    pub files: Vec<HubFile>,
+}
+
+/// A parsed `ollama://` source
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct OllamaSource {
+    pub namespace: String,
+    pub model: String,
+    pub tag: String,
+}
+
+impl OllamaSource {
+    /// Repository path in the registry, such as `library/llama3.2`
+    fn repo(&self) -> String {
+        format!("{}/{}", self.namespace, self.model)
+    }
+
+    /// The name Ollama users know the model by, such as `llama3.2:3b`
+    fn name(&self) -> String {
+        if self.namespace == "library" {
+            format!("{}:{}", self.model, self.tag)
+        } else {
+            format!("{}/{}:{}", self.namespace, self.model, self.tag)
+        }
+    }
+
+    /// File name in the models directory; `:` and `/` are not portable
+    fn file_name(&self) -> String {
+        format!("{}.gguf", self.name().replace([':', '/'], "-"))
+    }
+
+    /// Names requests may use for the model
+    fn aliases(&self) -> Vec<String> {
+        let mut aliases = vec![self.name()];
+        if self.tag == "latest" {
+            aliases.push(self.name().trim_end_matches(":latest").to_string());
+        }
+        aliases
+    }
+}
+
+/// A blob referenced by an Ollama manifest
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct OllamaLayer {
+    pub digest: String,
+    pub size: Option<u64>,
+}
+
+/// The parts of an Ollama manifest a pull needs
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct OllamaManifest {
+    /// The GGUF weights
+    pub model: OllamaLayer,
+    /// Model config, which names the quantization
+    pub config: Option<OllamaLayer>,
+}
+
+/// Where a download comes from
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Serialize, Deserialize)]
+pub enum Registry {
+    #[serde(rename = "huggingface")]
+    HuggingFace,
+    #[serde(rename = "ollama")]
+    Ollama,
+}
+
+impl Registry {
+    fn label(&self) -> &'static str {
+        match self {
+            Registry::HuggingFace => "the hub",
+            Registry::Ollama => "the Ollama registry",
+        }
+    }
+}
+
+/// Why the hub could not be queried
+#[derive(Debug)]
+pub enum HubError {
+    NotFound(String),
+    Unauthorized(String),
+    Upstream(String),
+}
+
+impl std::fmt::Display for HubError {
+    fn fmt(&self, f: &mut std::fmt::Formatter<'_>) -> std::fmt::Result {
+        match self {
+            HubError::NotFound(message)
+            | HubError::Unauthorized(message)
+            | HubError::Upstream(message) => f.write_str(message),
+        }
+    }
+}
+
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Serialize, Deserialize)]
+#[serde(rename_all = "snake_case")]
+pub enum DownloadStatus {
+    Downloading,
+    Verifying,
+    Succeeded,
+    Failed,
+    Cancelled,
+}
+
+/// A model download and its progress
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct ModelDownload {
+    pub id: String,
+    pub object: String,
+    pub source: String,
+    pub registry: Registry,
+    pub repo: String,
+    /// Revision, or the tag of an Ollama model
+    pub revision: String,
+    /// Commit the revision resolved to (the file is fetched from it), or the
+    /// Ollama manifest digest
+    pub commit: String,
+    /// File in the repo, or the digest of the Ollama model layer
+    pub file: String,
+    pub quantization: Option<String>,
+    pub status: DownloadStatus,
+    pub downloaded_bytes: u64,
+    pub total_bytes: Option<u64>,
+    pub expected_sha256: Option<String>,
+    pub sha256: Option<String>,
+    /// Whether the file matched the hub's checksum
+    pub verified: bool,
+    /// Name the model resolves by once registered
+    pub model: String,
+    pub path: PathBuf,
+    pub registered: bool,
+    pub created_at: chrono::DateTime<chrono::Utc>,
+    pub finished_at: Option<chrono::DateTime<chrono::Utc>>,
+    pub error: Option<String>,
+    #[serde(skip)]
+    url: String,
+    #[serde(skip)]
+    aliases: Vec<String>,
+    #[serde(skip)]
+    cancel: Arc<CancelSignal>,
+}
+
+impl ModelDownload {
+    fn is_finished(&self) -> bool {
+        !matches!(
+            self.status,
+            DownloadStatus::Downloading | DownloadStatus::Verifying
+        )
+    }
+
+    /// Where bytes land until the file is verified
+    fn partial_path(&self) -> PathBuf {
+        let mut name = self.path.clone().into_os_string();
+        name.push(".part");
+        PathBuf::from(name)
+    }
+
+    /// Registry tags naming where the model came from and what it answers to
+    fn registry_tags(&self) -> Vec<String> {
+        let mut tags = match self.registry {
+            Registry::HuggingFace => vec!["hub".to_string(), format!("hf:{}", self.repo)],
+            Registry::Ollama => vec!["ollama".to_string()],
+        };
+        tags.extend(self.aliases.iter().map(|alias| format!("alias:{}", alias)));
+        tags
+    }
+}
+
+/// In-memory store of model downloads and the HTTP client they share
+#[derive(Debug)]
+pub struct ModelDownloadStore {
+    downloads: RwLock<HashMap<String, ModelDownload>>,
+    client: reqwest::Client,
+}
+
+impl Default for ModelDownloadStore {
+    fn default() -> Self {
+        Self::new()
+    }
+}
+
+impl ModelDownloadStore {
+    pub fn new() -> Self {
+        let client = reqwest::Client::builder()
+            .user_agent("inferno/1.0")
+            .connect_timeout(Duration::from_secs(30))
+            .build()
+            .unwrap_or_default();
+        Self {
+            downloads: RwLock::new(HashMap::new()),
+            client,
+        }
+    }
+
+    async fn insert(&self, download: ModelDownload) {
+        let mut downloads = self.downloads.write().await;
+        if downloads.len() >= MAX_RETAINED_DOWNLOADS {
+            let oldest = downloads
+                .values()
+                .filter(|download| download.is_finished())
+                .min_by_key(|download| download.created_at)
+                .map(|download| download.id.clone());
+            if let Some(oldest) = oldest {
+                downloads.remove(&oldest);
+            }
+        }
+        downloads.insert(download.id.clone(), download);
+    }
+
+    async fn update<F: FnOnce(&mut ModelDownload)>(&self, id: &str, f: F) {
+        if let Some(download) = self.downloads.write().await.get_mut(id) {
+            f(download);
+        }
+    }
+
+    pub async fn get(&self, id: &str) -> Option<ModelDownload> {
+        self.downloads.read().await.get(id).cloned()
+    }
+
+    /// All downloads, newest first
+    pub async fn list(&self) -> Vec<ModelDownload> {
+        let mut downloads: Vec<ModelDownload> =
+            self.downloads.read().await.values().cloned().collect();
+        downloads.sort_by(|a, b| b.created_at.cmp(&a.created_at));
+        downloads
+    }
+
+    /// Whether an unfinished download is already writing to `path`
+    async fn is_writing(&self, path: &std::path::Path) -> bool {
+        self.downloads
+            .read()
+            .await
+            .values()
+            .any(|download| !download.is_finished() && download.path == path)
+    }
+
+    /// Signal an unfinished download to stop; `None` if it does not exist
+    pub async fn cancel(&self, id: &str) -> Option<ModelDownload> {
+        let downloads = self.downloads.read().await;
+        let download = downloads.get(id)?;
+        if !download.is_finished() {
+            download.cancel.cancel();
+        }
+        Some(download.clone())
+    }
+
+    fn authorized(
+        &self,
+        request: reqwest::RequestBuilder,
+        config: &HubConfig,
+    ) -> reqwest::RequestBuilder {
+        match config.token() {
+            Some(token) => request.bearer_auth(token),
+            None => request,
+        }
+    }
+
+    /// List a repo's files at a revision
+    pub async fn resolve(
+        &self,
+        config: &HubConfig,
+        source: &HubSource,
+    ) -> Result<HubRepo, HubError> {
+        let url = format!(
+            "{}/api/models/{}/revision/{}?blobs=true",
+            config.endpoint.trim_end_matches('/'),
+            source.repo,
+            source.revision
+        );
+        let response = self
+            .authorized(self.client.get(&url), config)
+            .send()
+            .await
+            .map_err(|e| HubError::Upstream(format!("cannot reach the hub: {}", e)))?;
+
+        match response.status() {
+            status if status.is_success() => {}
+            reqwest::StatusCode::NOT_FOUND => {
+                return Err(HubError::NotFound(format!(
+                    "{} has no revision {} on the hub",
+                    source.repo, source.revision
+                )));
+            }
+            reqwest::StatusCode::UNAUTHORIZED | reqwest::StatusCode::FORBIDDEN => {
+                return Err(HubError::Unauthorized(format!(
+                    "{} is gated or private; set hub.token or HF_TOKEN to a token with access",
+                    source.repo
+                )));
+            }
+            status => {
+                return Err(HubError::Upstream(format!(
+                    "the hub returned {} for {}",
+                    status, source.repo
+                )));
+            }
+        }
+
+        let raw: serde_json::Value = response
+            .json()
+            .await
+            .map_err(|e| HubError::Upstream(format!("unexpected hub response: {}", e)))?;
+        Ok(parse_repo(&raw, &source.revision))
+    }
+
+    /// Fetch a model's manifest, returning it with its digest
+    pub async fn ollama_manifest(
+        &self,
+        config: &HubConfig,
+        source: &OllamaSource,
+    ) -> Result<(OllamaManifest, String), HubError> {
+        let url = format!(
+            "{}/v2/{}/manifests/{}",
+            config.ollama_registry.trim_end_matches('/'),
+            source.repo(),
+            source.tag
+        );
+        let response = self
+            .client
+            .get(&url)
+            .header(reqwest::header::ACCEPT, OLLAMA_MANIFEST_TYPE)
+            .send()
+            .await
+            .map_err(|e| HubError::Upstream(format!("cannot reach the Ollama registry: {}", e)))?;
+
+        match response.status() {
+            status if status.is_success() => {}
+            reqwest::StatusCode::NOT_FOUND => {
+                return Err(HubError::NotFound(format!(
+                    "{} is not in the Ollama registry",
+                    source.name()
+                )));
+            }
+            status => {
+                return Err(HubError::Upstream(format!(
+                    "the Ollama registry returned {} for {}",
+                    status,
+                    source.name()
+                )));
+            }
+        }
+
+        let body = response
+            .bytes()
+            .await
+            .map_err(|e| HubError::Upstream(format!("unexpected registry response: {}", e)))?;
+        let raw: serde_json::Value = serde_json::from_slice(&body)
+            .map_err(|e| HubError::Upstream(format!("unexpected registry response: {}", e)))?;
+        let manifest = parse_manifest(&raw).map_err(HubError::Upstream)?;
+        let digest = format!("sha256:{}", hex::encode(Sha256::digest(&body)));
+        Ok((manifest, digest))
+    }
+
+    /// The quantization recorded in a model's config blob, if it can be read
+    async fn ollama_file_type(
+        &self,
+        config: &HubConfig,
+        source: &OllamaSource,
+        layer: &OllamaLayer,
+    ) -> Option<String> {
+        let url = ollama_blob_url(config, source, &layer.digest);
+        let raw: serde_json::Value = self.client.get(url).send().await.ok()?.json().await.ok()?;
+        raw["file_type"].as_str().map(str::to_string)
+    }
+}
+
+/// Media type registries answer manifest requests with
+const OLLAMA_MANIFEST_TYPE: &str = "application/vnd.docker.distribution.manifest.v2+json";
+
+/// Media type of the layer holding the GGUF weights
+const OLLAMA_MODEL_LAYER: &str = "application/vnd.ollama.image.model";
+
+fn ollama_blob_url(config: &HubConfig, source: &OllamaSource, digest: &str) -> String {
+    format!(
+        "{}/v2/{}/blobs/{}",
+        config.ollama_registry.trim_end_matches('/'),
+        source.repo(),
+        digest
+    )
+}
+
+/// Parse `ollama://[namespace/]model[:tag]`; the namespace defaults to
+/// `library` and the tag to `latest`
+pub fn parse_ollama_source(source: &str) -> Result<OllamaSource, String> {
+    let rest = source
+        .strip_prefix("ollama://")
+        .ok_or_else(|| "source must start with ollama://".to_string())?;
+    let (path, tag) = match rest.rsplit_once(':') {
+        Some((path, tag)) => (path, tag),
+        None => (rest, "latest"),
+    };
+    let (namespace, model) = match path.split_once('/') {
+        Some((namespace, model)) => (namespace, model),
+        None => ("library", path),
+    };
+
+    let valid_part = |part: &str| {
+        !part.is_empty()
+            && !part.starts_with('.')
+            && part
+                .chars()
+                .all(|c| c.is_ascii_alphanumeric() || matches!(c, '-' | '_' | '.'))
+    };
+    if !valid_part(namespace) || !valid_part(model) {
+        return Err(format!("{} is not an Ollama model name", path));
+    }
+    if !valid_part(tag) {
+        return Err(format!(
+            "tag {} must be letters, digits, '-', '_' or '.'",
+            tag
+        ));
+    }
+
+    Ok(OllamaSource {
+        namespace: namespace.to_string(),
+        model: model.to_string(),
+        tag: tag.to_string(),
+    })
+}
+
+/// Find the model and config layers in an Ollama manifest
+fn parse_manifest(raw: &serde_json::Value) -> Result<OllamaManifest, String> {
+    let layer = |value: &serde_json::Value| {
+        let digest = value["digest"].as_str()?;
+        Some(OllamaLayer {
+            digest: digest.to_string(),
+            size: value["size"].as_u64(),
+        })
+    };
+
+    let model = raw["layers"]
+        .as_array()
+        .into_iter()
+        .flatten()
+        .find(|value| value["mediaType"] == OLLAMA_MODEL_LAYER)
+        .and_then(layer)
+        .ok_or_else(|| "the manifest has no model layer".to_string())?;
+    if !model
+        .digest
+        .strip_prefix("sha256:")
+        .is_some_and(|hex| hex.len() == 64 && hex.chars().all(|c| c.is_ascii_hexdigit()))
+    {
+        return Err(format!("unsupported layer digest {}", model.digest));
+    }
+
+    Ok(OllamaManifest {
+        model,
+        config: layer(&raw["config"]),
+    })
+}
+
+/// Parse `hf://org/repo[:revision]`
+pub fn parse_source(source: &str) -> Result<HubSource, String> {
+    let rest = source
+        .strip_prefix("hf://")
+        .ok_or_else(|| "source must start with hf://".to_string())?;
+    let (repo, revision) = match rest.rsplit_once(':') {
+        Some((repo, revision)) => (repo, revision),
+        None => (rest, "main"),
+    };
+
+    let valid_part = |part: &str| {
+        !part.is_empty()
+            && part != "."
+            && part != ".."
+            && part
+                .chars()
+                .all(|c| c.is_ascii_alphanumeric() || matches!(c, '-' | '_' | '.'))
+    };
+    let mut parts = repo.split('/');
+    let (Some(owner), Some(name), None) = (parts.next(), parts.next(), parts.next()) else {
+        return Err(format!("{} is not an org/repo name", repo));
+    };
+    if !valid_part(owner) || !valid_part(name) {
+        return Err(format!("{} is not an org/repo name", repo));
+    }
+    if !valid_part(revision) {
+        return Err(format!(
+            "revision {} must be a branch, tag or commit of letters, digits, '-', '_' or '.'",
+            revision
+        ));
+    }
+
+    Ok(HubSource {
+        repo: repo.to_string(),
+        revision: revision.to_string(),
+    })
+}
+
+/// Read the commit and files from the hub's model info response
+fn parse_repo(raw: &serde_json::Value, revision: &str) -> HubRepo {
+    let files = raw["siblings"]
+        .as_array()
+        .map(|siblings| {
+            siblings
+                .iter()
+                .filter_map(|sibling| {
+                    let name = sibling["rfilename"].as_str()?;
+                    Some(HubFile {
+                        name: name.to_string(),
+                        size: sibling["lfs"]["size"]
+                            .as_u64()
+                            .or_else(|| sibling["size"].as_u64()),
+                        sha256: sibling["lfs"]["sha256"].as_str().map(str::to_string),
+                    })
+                })
+                .collect()
+        })
+        .unwrap_or_default();
+
+    HubRepo {
+        commit: raw["sha"].as_str().unwrap_or(revision).to_string(),
+        files,
+    }
+}
+
+/// The quantization named in a GGUF file name, such as `Q4_K_M` in
+/// `llama-2-7b.Q4_K_M.gguf`
+pub fn quantization_of(file: &str) -> Option<String> {
+    let name = file.rsplit('/').next().unwrap_or(file);
+    let stem = name.strip_suffix(".gguf")?;
+    stem.split(['.', '-'])
+        .rev()
+        .map(|token| token.to_ascii_uppercase())
+        .find(|token| is_quantization(token))
+}
+
+fn is_quantization(token: &str) -> bool {
+    if matches!(token, "F16" | "F32" | "BF16") {
+        return true;
+    }
+    let rest = token.strip_prefix('I').unwrap_or(token);
+    let Some(rest) = rest.strip_prefix('Q') else {
+        return false;
+    };
+    let mut parts = rest.split('_');
+    let bits = parts.next().unwrap_or("");
+    !bits.is_empty()
+        && bits.chars().all(|c| c.is_ascii_digit())
+        && parts.all(|part| !part.is_empty() && part.chars().all(|c| c.is_ascii_alphanumeric()))
+}
+
+/// Whether a file is one shard of a split GGUF (`-00001-of-00003.gguf`)
+fn is_split_shard(file: &str) -> bool {
+    let Some(stem) = file.strip_suffix(".gguf") else {
+        return false;
+    };
+    let mut parts = stem.rsplitn(4, '-');
+    let (Some(total), Some(of), Some(index)) = (parts.next(), parts.next(), parts.next()) else {
+        return false;
+    };
+    let digits = |s: &str| s.len() == 5 && s.chars().all(|c| c.is_ascii_digit());
+    of == "of" && digits(total) && digits(index)
+}
+
+/// Files that can be pulled on their own
+fn candidates(files: &[HubFile]) -> Vec<&HubFile> {
+    files
+        .iter()
+        .filter(|file| {
+            file.name.ends_with(".gguf")
+                && !is_split_shard(&file.name)
+                && !file.name.to_ascii_lowercase().contains("mmproj")
+        })
+        .collect()
+}
+
+/// Choose the file to pull: the named one, else the first preferred
+/// quantization the repo has, else the only GGUF file there is
+pub fn select_file<'a>(
+    files: &'a [HubFile],
+    file: Option<&str>,
+    preference: &[String],
+) -> Result<&'a HubFile, (String, &'static str)> {
+    if let Some(wanted) = file {
+        let found = files
+            .iter()
+            .find(|f| f.name == wanted)
+            .ok_or_else(|| (format!("the repo has no file {}", wanted), "file"))?;
+        if !found.name.ends_with(".gguf") {
+            return Err((format!("{} is not a GGUF file", wanted), "file"));
+        }
+        if is_split_shard(&found.name) {
+            return Err((
+                format!(
+                    "{} is one part of a split model; split models are not supported",
+                    wanted
+                ),
+                "file",
+            ));
+        }
+        return Ok(found);
+    }
+
+    let candidates = candidates(files);
+    for wanted in preference {
+        let found = candidates
+            .iter()
+            .find(|f| quantization_of(&f.name).is_some_and(|q| q.eq_ignore_ascii_case(wanted)));
+        if let Some(found) = found {
+            return Ok(found);
+        }
+    }
+    if let [only] = candidates.as_slice() {
+        return Ok(only);
+    }
+
+    if candidates.is_empty() {
+        return Err((
+            "the repo has no single-file GGUF models".to_string(),
+            "source",
+        ));
+    }
+    let mut available: Vec<String> = candidates
+        .iter()
+        .filter_map(|f| quantization_of(&f.name))
+        .collect();
+    available.sort();
+    available.dedup();
+    Err((
+        format!(
+            "none of the preferred quantizations are available; the repo has {}",
+            available.join(", ")
+        ),
+        "quantization",
+    ))
+}
+
+/// Whether a name is safe to save as in the models directory
+fn valid_file_name(name: &str) -> bool {
+    name.ends_with(".gguf")
+        && name.len() > ".gguf".len()
+        && !name.starts_with('.')
+        && !name.contains(['/', '\\'])
+        && !name.contains("..")
+}
+
+/// Stream the file to disk, hashing it and reporting progress; returns the
+/// SHA-256, or `None` if cancelled
+async fn fetch(state: &ServerState, download: &ModelDownload) -> anyhow::Result<Option<String>> {
+    let store = &state.model_downloads;
+    let config = &state.config.hub;
+
+    let request = store.client.get(&download.url);
+    let request = match download.registry {
+        Registry::HuggingFace => store.authorized(request, config),
+        Registry::Ollama => request,
+    };
+    let response = request.send().await?;
+    if !response.status().is_success() {
+        anyhow::bail!(
+            "{} returned {} for {}",
+            download.registry.label(),
+            response.status(),
+            download.file
+        );
+    }
+    if download.total_bytes.is_none() {
+        let total = response.content_length();
+        store.update(&download.id, |d| d.total_bytes = total).await;
+    }
+
+    let partial = download.partial_path();
+    let mut file = fs::File::create(&partial).await?;
+    let mut hasher = Sha256::new();
+    let mut bytes = 0u64;
+    let mut reported = 0u64;
+    let mut stream = response.bytes_stream();
+
+    loop {
+        let chunk = tokio::select! {
+            chunk = stream.next() => chunk,
+            _ = download.cancel.cancelled() => return Ok(None),
+        };
+        let Some(chunk) = chunk else {
+            break;
+        };
+        let chunk = chunk?;
+        hasher.update(&chunk);
+        file.write_all(&chunk).await?;
+        bytes += chunk.len() as u64;
+
+        if bytes - reported >= PROGRESS_INTERVAL_BYTES {
+            reported = bytes;
+            store
+                .update(&download.id, |d| d.downloaded_bytes = bytes)
+                .await;
+        }
+    }
+    file.flush().await?;
+    store
+        .update(&download.id, |d| d.downloaded_bytes = bytes)
+        .await;
+
+    if let Some(expected) = download.total_bytes {
+        if bytes != expected {
+            anyhow::bail!("received {} bytes, expected {}", bytes, expected);
+        }
+    }
+    Ok(Some(hex::encode(hasher.finalize())))
+}
+
+/// Download, verify and register a model
+async fn execute_download(state: Arc<ServerState>, download_id: String) {
+    let store = &state.model_downloads;
+    let Some(download) = store.get(&download_id).await else {
+        return;
+    };
+    let partial = download.partial_path();
+
+    let sha256 = match fetch(&state, &download).await {
+        Ok(Some(sha256)) => sha256,
+        Ok(None) => {
+            let _ = fs::remove_file(&partial).await;
+            finish(&state, &download_id, DownloadStatus::Cancelled, None).await;
+            return;
+        }
+        Err(e) => {
+            let _ = fs::remove_file(&partial).await;
+            finish(
+                &state,
+                &download_id,
+                DownloadStatus::Failed,
+                Some(e.to_string()),
+            )
+            .await;
+            return;
+        }
+    };
+
+    store
+        .update(&download_id, |d| {
+            d.status = DownloadStatus::Verifying;
+            d.sha256 = Some(sha256.clone());
+        })
+        .await;
+    if let Some(expected) = &download.expected_sha256 {
+        if !expected.eq_ignore_ascii_case(&sha256) {
+            let _ = fs::remove_file(&partial).await;
+            let message = format!("checksum mismatch: expected {}, got {}", expected, sha256);
+            finish(&state, &download_id, DownloadStatus::Failed, Some(message)).await;
+            return;
+        }
+        store.update(&download_id, |d| d.verified = true).await;
+    }
+
+    if let Err(e) = fs::rename(&partial, &download.path).await {
+        let _ = fs::remove_file(&partial).await;
+        let message = format!("cannot move the download into place: {}", e);
+        finish(&state, &download_id, DownloadStatus::Failed, Some(message)).await;
+        return;
+    }
+
+    let manager = &state.model_manager;
+    match manager.validate_model(&download.path).await {
+        Ok(true) => {}
+        Ok(false) | Err(_) => {
+            let _ = fs::remove_file(&download.path).await;
+            let message = format!("{} is not a valid GGUF model", download.file);
+            finish(&state, &download_id, DownloadStatus::Failed, Some(message)).await;
+            return;
+        }
+    }
+
+    let registered = async {
+        manager.record_digest(&download.path, &sha256).await?;
+        manager
+            .tag_model(&download.path, &download.registry_tags())
+            .await
+    }
+    .await;
+    match registered {
+        Ok(()) => store.update(&download_id, |d| d.registered = true).await,
+        Err(e) => warn!(
+            "Downloaded {} but could not register it: {}",
+            download.path.display(),
+            e
+        ),
+    }
+
+    finish(&state, &download_id, DownloadStatus::Succeeded, None).await;
+}
+
+/// Record a download's terminal state
+async fn finish(
+    state: &ServerState,
+    download_id: &str,
+    status: DownloadStatus,
+    error: Option<String>,
+) {
+    state
+        .model_downloads
+        .update(download_id, |download| {
+            download.status = status;
+            download.error = error.clone();
+            download.finished_at = Some(chrono::Utc::now());
+        })
+        .await;
+
+    if let Some(download) = state.model_downloads.get(download_id).await {
+        let events = &state.model_events;
+        match status {
+            DownloadStatus::Succeeded => {
+                events.publish(ModelEventType::Downloaded, &download.model, "hub")
+            }
+            DownloadStatus::Failed => events.publish_failure(
+                ModelEventType::Downloaded,
+                &download.model,
+                "hub",
+                error.as_deref().unwrap_or("unknown error"),
+            ),
+            _ => {}
+        }
+    }
+
+    match status {
+        DownloadStatus::Succeeded => info!("Model download {} succeeded", download_id),
+        DownloadStatus::Failed => warn!(
+            "Model download {} failed: {}",
+            download_id,
+            error.as_deref().unwrap_or("unknown error")
+        ),
+        _ => info!("Model download {} {:?}", download_id, status),
+    }
+}
+
+/// What a download fetches and where it lands
+struct DownloadPlan {
+    registry: Registry,
+    repo: String,
+    revision: String,
+    commit: String,
+    file: String,
+    quantization: Option<String>,
+    total_bytes: Option<u64>,
+    expected_sha256: Option<String>,
+    /// File name in the models directory
+    file_name: String,
+    model: String,
+    url: String,
+    aliases: Vec<String>,
+}
+
+/// Download URL of a file at a commit
+fn hub_file_url(
+    config: &HubConfig,
+    repo: &str,
+    commit: &str,
+    file: &str,
+) -> Result<String, String> {
+    let mut url = reqwest::Url::parse(config.endpoint.trim_end_matches('/'))
+        .map_err(|e| format!("hub.endpoint {} is not a URL: {}", config.endpoint, e))?;
+    url.path_segments_mut()
+        .map_err(|_| format!("hub.endpoint {} cannot be a base URL", config.endpoint))?
+        .pop_if_empty()
+        .extend(repo.split('/'))
+        .push("resolve")
+        .push(commit)
+        .extend(file.split('/'));
+    Ok(url.to_string())
+}
+
+/// Resolve an `hf://` source and choose its file
+async fn plan_hub_download(
+    state: &ServerState,
+    request: &ModelDownloadRequest,
+) -> Result<DownloadPlan, Response> {
+    let source = parse_source(&request.source)
+        .map_err(|message| invalid_request(message, Some("source"), None))?;
+    let config = &state.config.hub;
+    let repo = state
+        .model_downloads
+        .resolve(config, &source)
+        .await
+        .map_err(hub_error)?;
+    let preference = if request.quantization.is_empty() {
+        &config.quantization_preference
+    } else {
+        &request.quantization
+    };
+    let file = select_file(&repo.files, request.file.as_deref(), preference)
+        .map_err(|(message, param)| invalid_request(message, Some(param), None))?
+        .clone();
+
+    let file_name = request.name.clone().unwrap_or_else(|| {
+        file.name
+            .rsplit('/')
+            .next()
+            .unwrap_or(&file.name)
+            .to_string()
+    });
+    let url =
+        hub_file_url(config, &source.repo, &repo.commit, &file.name).map_err(internal_error)?;
+
+    Ok(DownloadPlan {
+        registry: Registry::HuggingFace,
+        repo: source.repo,
+        revision: source.revision,
+        commit: repo.commit,
+        quantization: quantization_of(&file.name),
+        file: file.name,
+        total_bytes: file.size,
+        expected_sha256: file.sha256,
+        model: file_name.clone(),
+        file_name,
+        url,
+        aliases: Vec::new(),
+    })
+}
+
+/// Fetch an `ollama://` source's manifest and find its weights
+async fn plan_ollama_download(
+    state: &ServerState,
+    request: &ModelDownloadRequest,
+) -> Result<DownloadPlan, Response> {
+    if request.file.is_some() {
+        return Err(invalid_request(
+            "file applies to hf:// sources only".to_string(),
+            Some("file"),
+            None,
+        ));
+    }
+    if !request.quantization.is_empty() {
+        return Err(invalid_request(
+            "quantization applies to hf:// sources only; pick an Ollama tag instead".to_string(),
+            Some("quantization"),
+            None,
+        ));
+    }
+
+    let source = parse_ollama_source(&request.source)
+        .map_err(|message| invalid_request(message, Some("source"), None))?;
+    let config = &state.config.hub;
+    let store = &state.model_downloads;
+    let (manifest, digest) = store
+        .ollama_manifest(config, &source)
+        .await
+        .map_err(hub_error)?;
+    let quantization = match &manifest.config {
+        Some(layer) => store.ollama_file_type(config, &source, layer).await,
+        None => None,
+    };
+
+    let file_name = request.name.clone().unwrap_or_else(|| source.file_name());
+    if !valid_file_name(&file_name) {
+        return Err(invalid_request(
+            format!(
+                "{} cannot be saved as {}; pass a name",
+                source.name(),
+                file_name
+            ),
+            Some("name"),
+            None,
+        ));
+    }
+
+    Ok(DownloadPlan {
+        registry: Registry::Ollama,
+        repo: source.repo(),
+        revision: source.tag.clone(),
+        commit: digest,
+        file: manifest.model.digest.clone(),
+        quantization,
+        total_bytes: manifest.model.size,
+        expected_sha256: manifest
+            .model
+            .digest
+            .strip_prefix("sha256:")
+            .map(str::to_string),
+        file_name,
+        model: source.name(),
+        url: ollama_blob_url(config, &source, &manifest.model.digest),
+        aliases: source.aliases(),
+    })
+}
+
+// API Handlers
+
+/// `POST /v1/models/download` - pull a GGUF model from the Hugging Face Hub
+/// or an Ollama registry (admin only)
+pub async fn download_model(
+    State(state): State<Arc<ServerState>>,
+    headers: HeaderMap,
+    Json(request): Json<ModelDownloadRequest>,
+) -> Response {
+    if let Err(response) = authorize_admin(&headers) {
+        return response;
+    }
+
+    if let Some(name) = &request.name {
+        if !valid_file_name(name) {
+            return invalid_request(
+                "name must be a plain file name ending in .gguf".to_string(),
+                Some("name"),
+                None,
+            );
+        }
+    }
+
+    let plan = if request.source.starts_with("ollama://") {
+        plan_ollama_download(&state, &request).await
+    } else {
+        plan_hub_download(&state, &request).await
+    };
+    let plan = match plan {
+        Ok(plan) => plan,
+        Err(response) => return response,
+    };
+
+    let path = state.config.models_dir.join(&plan.file_name);
+    if path.exists() || state.model_downloads.is_writing(&path).await {
+        return error_response(
+            StatusCode::CONFLICT,
+            format!("{} already exists; choose a different name", plan.file_name),
+            Some("name"),
+            Some("model_exists"),
+        );
+    }
+    if let Err(e) = fs::create_dir_all(&state.config.models_dir).await {
+        return internal_error(format!(
+            "cannot create {}: {}",
+            state.config.models_dir.display(),
+            e
+        ));
+    }
+
+    let download = ModelDownload {
+        id: format!("download-{}", Uuid::new_v4()),
+        object: "model.download".to_string(),
+        source: request.source,
+        registry: plan.registry,
+        repo: plan.repo,
+        revision: plan.revision,
+        commit: plan.commit,
+        file: plan.file,
+        quantization: plan.quantization,
+        status: DownloadStatus::Downloading,
+        downloaded_bytes: 0,
+        total_bytes: plan.total_bytes,
+        expected_sha256: plan.expected_sha256,
+        sha256: None,
+        verified: false,
+        model: plan.model,
+        path,
+        registered: false,
+        created_at: chrono::Utc::now(),
+        finished_at: None,
+        error: None,
+        url: plan.url,
+        aliases: plan.aliases,
+        cancel: Arc::new(CancelSignal::new()),
+    };
+    state.model_downloads.insert(download.clone()).await;
+
+    info!(
+        "Downloading {} from {}@{} to {}",
+        download.file,
+        download.repo,
+        download.commit,
+        download.path.display()
+    );
+    tokio::spawn(execute_download(Arc::clone(&state), download.id.clone()));
+
+    (StatusCode::ACCEPTED, Json(download)).into_response()
+}
+
+/// `GET /v1/models/downloads` - all downloads, newest first (admin only)
+pub async fn list_downloads(State(state): State<Arc<ServerState>>, headers: HeaderMap) -> Response {
+    if let Err(response) = authorize_admin(&headers) {
+        return response;
+    }
+
+    Json(json!({
+        "object": "list",
+        "data": state.model_downloads.list().await
+    }))
+    .into_response()
+}
+
+/// `GET /v1/models/downloads/:download_id` - status and progress (admin only)
+pub async fn get_download(
+    State(state): State<Arc<ServerState>>,
+    Path(download_id): Path<String>,
+    headers: HeaderMap,
+) -> Response {
+    if let Err(response) = authorize_admin(&headers) {
+        return response;
+    }
+
+    match state.model_downloads.get(&download_id).await {
+        Some(download) => Json(download).into_response(),
+        None => download_not_found(&download_id),
+    }
+}
+
+/// `POST /v1/models/downloads/:download_id/cancel` - stop a download and
+/// discard the partial file (admin only)
+pub async fn cancel_download(
+    State(state): State<Arc<ServerState>>,
+    Path(download_id): Path<String>,
+    headers: HeaderMap,
+) -> Response {
+    if let Err(response) = authorize_admin(&headers) {
+        return response;
+    }
+
+    match state.model_downloads.cancel(&download_id).await {
+        Some(download) => Json(download).into_response(),
+        None => download_not_found(&download_id),
+    }
+}
+
+fn hub_error(error: HubError) -> Response {
+    let (status, code) = match &error {
+        HubError::NotFound(_) => (StatusCode::NOT_FOUND, "hub_repo_not_found"),
+        HubError::Unauthorized(_) => (StatusCode::BAD_GATEWAY, "hub_unauthorized"),
+        HubError::Upstream(_) => (StatusCode::BAD_GATEWAY, "hub_unavailable"),
+    };
+    (
+        status,
+        Json(json!({
+            "error": {
+                "message": error.to_string(),
+                "type": "upstream_error",
+                "param": "source",
+                "code": code
+            }
+        })),
+    )
+        .into_response()
+}
+
+fn download_not_found(download_id: &str) -> Response {
+    error_response(
+        StatusCode::NOT_FOUND,
+        format!("No model download with id {}", download_id),
+        Some("download_id"),
+        Some("model_download_not_found"),
+    )
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn file(name: &str) -> HubFile {
+        HubFile {
+            name: name.to_string(),
+            size: None,
+            sha256: None,
+        }
+    }
+
+    fn preference(quantizations: &[&str]) -> Vec<String> {
+        quantizations.iter().map(|q| q.to_string()).collect()
+    }
+
+    #[test]
+    fn parses_sources() {
+        assert_eq!(
+            parse_source("hf://TheBloke/Llama-2-7B-GGUF").unwrap(),
+            HubSource {
+                repo: "TheBloke/Llama-2-7B-GGUF".to_string(),
+                revision: "main".to_string(),
+            }
+        );
+        assert_eq!(
+            parse_source("hf://org/repo.v2:v1.0").unwrap().revision,
+            "v1.0"
+        );
+        assert!(parse_source("TheBloke/Llama-2-7B-GGUF").is_err());
+        assert!(parse_source("hf://just-a-repo").is_err());
+        assert!(parse_source("hf://org/repo/extra").is_err());
+        assert!(parse_source("hf://org/../etc").is_err());
+        assert!(parse_source("hf://org/repo:").is_err());
+    }
+
+    #[test]
+    fn finds_quantization_in_file_names() {
+        assert_eq!(
+            quantization_of("llama-2-7b.Q4_K_M.gguf").as_deref(),
+            Some("Q4_K_M")
+        );
+        assert_eq!(
+            quantization_of("qwen2-7b-instruct-q5_k_s.gguf").as_deref(),
+            Some("Q5_K_S")
+        );
+        assert_eq!(
+            quantization_of("sub/Phi-3-mini-IQ4_XS.gguf").as_deref(),
+            Some("IQ4_XS")
+        );
+        assert_eq!(quantization_of("model-f16.gguf").as_deref(), Some("F16"));
+        assert_eq!(quantization_of("model-7b.gguf"), None);
+        assert_eq!(quantization_of("README.md"), None);
+    }
+
+    #[test]
+    fn recognises_split_shards() {
+        assert!(is_split_shard("model-Q8_0-00001-of-00003.gguf"));
+        assert!(!is_split_shard("model-Q8_0.gguf"));
+        assert!(!is_split_shard("model-1-of-3.gguf"));
+    }
+
+    #[test]
+    fn selects_by_preference_order() {
+        let files = vec![
+            file("README.md"),
+            file("m.Q8_0.gguf"),
+            file("m.Q5_K_M.gguf"),
+            file("m.Q4_K_M-00001-of-00002.gguf"),
+            file("mmproj-m-f16.gguf"),
+        ];
+
+        let chosen = select_file(&files, None, &preference(&["Q4_K_M", "Q5_K_M"])).unwrap();
+        assert_eq!(chosen.name, "m.Q5_K_M.gguf");
+
+        let chosen = select_file(&files, None, &preference(&["q8_0"])).unwrap();
+        assert_eq!(chosen.name, "m.Q8_0.gguf");
+
+        let (message, param) = select_file(&files, None, &preference(&["F16"])).unwrap_err();
+        assert_eq!(param, "quantization");
+        assert!(message.contains("Q5_K_M, Q8_0"));
+    }
+
+    #[test]
+    fn falls_back_to_the_only_file() {
+        let files = vec![file("model.gguf"), file("config.json")];
+        let chosen = select_file(&files, None, &preference(&["Q4_K_M"])).unwrap();
+        assert_eq!(chosen.name, "model.gguf");
+    }
+
+    #[test]
+    fn explicit_file_must_be_a_whole_gguf() {
+        let files = vec![
+            file("m.Q8_0.gguf"),
+            file("m.Q4_K_M-00001-of-00002.gguf"),
+            file("config.json"),
+        ];
+        assert_eq!(
+            select_file(&files, Some("m.Q8_0.gguf"), &[]).unwrap().name,
+            "m.Q8_0.gguf"
+        );
+        assert!(select_file(&files, Some("m.Q4_K_M-00001-of-00002.gguf"), &[]).is_err());
+        assert!(select_file(&files, Some("config.json"), &[]).is_err());
+        assert!(select_file(&files, Some("missing.gguf"), &[]).is_err());
+    }
+
+    #[test]
+    fn reads_lfs_checksums() {
+        let raw = json!({
+            "sha": "abc123",
+            "siblings": [
+                {"rfilename": "README.md", "size": 10},
+                {
+                    "rfilename": "m.Q4_K_M.gguf",
+                    "size": 4000,
+                    "lfs": {"sha256": "deadbeef", "size": 4000, "pointerSize": 130}
+                }
+            ]
+        });
+        let repo = parse_repo(&raw, "main");
+        assert_eq!(repo.commit, "abc123");
+        assert_eq!(
+            repo.files[1],
+            HubFile {
+                name: "m.Q4_K_M.gguf".to_string(),
+                size: Some(4000),
+                sha256: Some("deadbeef".to_string()),
+            }
+        );
+        assert_eq!(repo.files[0].sha256, None);
+    }
+
+    #[test]
+    fn parses_ollama_sources() {
+        let source = parse_ollama_source("ollama://llama3.2:3b").unwrap();
+        assert_eq!(source.repo(), "library/llama3.2");
+        assert_eq!(source.name(), "llama3.2:3b");
+        assert_eq!(source.file_name(), "llama3.2-3b.gguf");
+        assert_eq!(source.aliases(), vec!["llama3.2:3b"]);
+
+        let source = parse_ollama_source("ollama://mistral").unwrap();
+        assert_eq!(source.tag, "latest");
+        assert_eq!(source.aliases(), vec!["mistral:latest", "mistral"]);
+
+        let source = parse_ollama_source("ollama://someone/tiny-model:q4").unwrap();
+        assert_eq!(source.repo(), "someone/tiny-model");
+        assert_eq!(source.name(), "someone/tiny-model:q4");
+        assert_eq!(source.file_name(), "someone-tiny-model-q4.gguf");
+
+        assert!(parse_ollama_source("hf://org/repo").is_err());
+        assert!(parse_ollama_source("ollama://a/b/c").is_err());
+        assert!(parse_ollama_source("ollama://../etc:latest").is_err());
+        assert!(parse_ollama_source("ollama://llama3:").is_err());
+    }
+
+    #[test]
+    fn finds_the_model_layer() {
+        let digest = format!("sha256:{}", "a".repeat(64));
+        let raw = json!({
+            "schemaVersion": 2,
+            "config": {"mediaType": "application/vnd.docker.container.image.v1+json", "digest": "sha256:cfg", "size": 485},
+            "layers": [
+                {"mediaType": "application/vnd.ollama.image.template", "digest": "sha256:tpl", "size": 1429},
+                {"mediaType": "application/vnd.ollama.image.model", "digest": digest, "size": 2019377376u64},
+                {"mediaType": "application/vnd.ollama.image.license", "digest": "sha256:lic", "size": 7711}
+            ]
+        });
+        let manifest = parse_manifest(&raw).unwrap();
+        assert_eq!(
+            manifest.model,
+            OllamaLayer {
+                digest,
+                size: Some(2019377376),
+            }
+        );
+        assert_eq!(manifest.config.unwrap().digest, "sha256:cfg");
+
+        let raw = json!({"layers": [{"mediaType": "application/vnd.ollama.image.model", "digest": "md5:abc"}]});
+        assert!(parse_manifest(&raw).is_err());
+        assert!(parse_manifest(&json!({"layers": []})).is_err());
+    }
+
+    #[test]
+    fn rejects_unsafe_file_names() {
+        assert!(valid_file_name("llama.gguf"));
+        assert!(!valid_file_name("../llama.gguf"));
+        assert!(!valid_file_name("dir/llama.gguf"));
+        assert!(!valid_file_name(".gguf"));
+        assert!(!valid_file_name("llama.bin"));
+    }
+}
@@ -0,0 +1,318 @@
+//! JWT Authentication
+//!
+//! Short-lived JWTs from an identity provider can stand in for long-lived
+//! API keys. When `INFERNO_JWKS_URL` names the provider's JWKS, bearer
+//! tokens shaped like a JWT are verified against its keys: the signature,
+//! `exp` and `nbf`, and `iss` and `aud` when `INFERNO_JWT_ISSUER` and
+//! `INFERNO_JWT_AUDIENCE` are set. Other bearer tokens, such as API keys
+//! and the admin token, pass through as before. With
+//! `INFERNO_REQUIRE_JWT=true`, every request other than health probes,
+//! CORS preflights and admin calls must carry a valid JWT.
+//!
+//! The key set is cached for ten minutes and fetched again early when a
+//! token names a key it does not have, as after the provider rotates keys.
+//! Expired tokens get `401` with code `token_expired` and
+//! `WWW-Authenticate: Bearer error="invalid_token", error_description="token expired"`,
+//! so clients know to refresh and retry.
+
+use crate::{
+    api::{
+        admin::authorize_admin,
+        audit_events::{self, AuditKind},
+        usage_export,
+    },
+    cli::serve::ServerState,
+};
+use axum::{
+    Json,
+    extract::{Request, State},
+    http::{HeaderValue, Method, StatusCode, header},
+    middleware::Next,
+    response::{IntoResponse, Response},
+};
+use jsonwebtoken::{
+    Algorithm, DecodingKey, Validation, decode, decode_header, errors::ErrorKind, jwk::JwkSet,
+};
+use serde_json::json;
+use std::{
+    sync::Arc,
+    time::{Duration, Instant},
+};
+use tokio::sync::RwLock;
+use tracing::warn;
+
+pub const JWKS_URL_ENV: &str = "INFERNO_JWKS_URL";
+pub const ISSUER_ENV: &str = "INFERNO_JWT_ISSUER";
+pub const AUDIENCE_ENV: &str = "INFERNO_JWT_AUDIENCE";
+pub const REQUIRE_ENV: &str = "INFERNO_REQUIRE_JWT";
+
+/// How long a fetched key set is used
+const JWKS_TTL: Duration = Duration::from_secs(600);
+
+/// Least time between fetches prompted by an unknown key id, so tokens
+/// naming made-up keys cannot make the server hammer the provider
+const JWKS_MIN_REFRESH: Duration = Duration::from_secs(30);
+
+/// Signature algorithms accepted; symmetric ones are refused, since JWKS
+/// publishes public keys
+const ALLOWED_ALGORITHMS: &[Algorithm] = &[
+    Algorithm::RS256,
+    Algorithm::RS384,
+    Algorithm::RS512,
+    Algorithm::PS256,
+    Algorithm::PS384,
+    Algorithm::PS512,
+    Algorithm::ES256,
+    Algorithm::ES384,
+    Algorithm::EdDSA,
+];
+
+/// Claims of the verified token, added to the request's extensions
+#[derive(Debug, Clone)]
+pub struct JwtClaims(pub serde_json::Value);
+
+#[derive(Debug)]
+struct CachedKeys {
+    keys: JwkSet,
+    fetched_at: Option<Instant>,
+}
+
+/// The JWKS and the claims tokens must have
+#[derive(Debug)]
+pub struct JwtAuth {
+    jwks_url: Option<String>,
+    issuer: Option<String>,
+    audience: Option<String>,
+    required: bool,
+    cache: RwLock<CachedKeys>,
+    client: reqwest::Client,
+}
+
+/// Why a token was refused
+#[derive(Debug, PartialEq, Eq)]
+enum TokenError {
+    Expired,
+    Invalid(String),
+}
+
+impl JwtAuth {
+    /// Settings from the environment; JWTs are not checked when
+    /// `INFERNO_JWKS_URL` is unset
+    pub fn from_env() -> anyhow::Result<Self> {
+        let var = |name| std::env::var(name).ok().filter(|v: &String| !v.is_empty());
+        let jwks_url = var(JWKS_URL_ENV);
+        let required = var(REQUIRE_ENV).is_some_and(|value| value == "true");
+        if required && jwks_url.is_none() {
+            anyhow::bail!("{} is true but {} is not set", REQUIRE_ENV, JWKS_URL_ENV);
+        }
+        Ok(Self {
+            jwks_url,
+            issuer: var(ISSUER_ENV),
+            audience: var(AUDIENCE_ENV),
+            required,
+            cache: RwLock::new(CachedKeys {
+                keys: JwkSet { keys: Vec::new() },
+                fetched_at: None,
+            }),
+            client: reqwest::Client::builder()
+                .user_agent("inferno/1.0")
+                .timeout(Duration::from_secs(10))
+                .build()
+                .unwrap_or_default(),
+        })
+    }
+
+    pub fn enabled(&self) -> bool {
+        self.jwks_url.is_some()
+    }
+
+    /// The key set, fetched again when it is stale or, with `refresh`, at
+    /// most every `JWKS_MIN_REFRESH`
+    async fn keys(&self, refresh: bool) -> Result<JwkSet, String> {
+        {
+            let cache = self.cache.read().await;
+            let age = cache.fetched_at.map(|at| at.elapsed());
+            let fresh = age.is_some_and(|age| age < JWKS_TTL);
+            let recently = age.is_some_and(|age| age < JWKS_MIN_REFRESH);
+            if fresh && (!refresh || recently) {
+                return Ok(cache.keys.clone());
+            }
+        }
+
+        let url = self.jwks_url.as_deref().unwrap_or_default();
+        let fetched = async {
+            self.client
+                .get(url)
+                .send()
+                .await?
+                .error_for_status()?
+                .json::<JwkSet>()
+                .await
+        }
+        .await;
+
+        let mut cache = self.cache.write().await;
+        match fetched {
+            Ok(keys) => {
+                cache.keys = keys;
+                cache.fetched_at = Some(Instant::now());
+                Ok(cache.keys.clone())
+            }
+            // Keep verifying with the keys we have while the provider is down
+            Err(e) if cache.fetched_at.is_some() => {
+                warn!("Cannot refresh JWKS from {}: {}", url, e);
+                Ok(cache.keys.clone())
+            }
+            Err(e) => Err(format!("Cannot fetch JWKS: {}", e)),
+        }
+    }
+
+    /// Verify `token`, returning its claims
+    async fn verify(&self, token: &str) -> Result<serde_json::Value, TokenError> {
+        let header = decode_header(token)
+            .map_err(|e| TokenError::Invalid(format!("Malformed JWT: {}", e)))?;
+        if !ALLOWED_ALGORITHMS.contains(&header.alg) {
+            return Err(TokenError::Invalid(format!(
+                "JWT algorithm {:?} is not accepted",
+                header.alg
+            )));
+        }
+
+        let mut keys = self.keys(false).await.map_err(TokenError::Invalid)?;
+        let find = |keys: &JwkSet| match &header.kid {
+            Some(kid) => keys.find(kid).cloned(),
+            None if keys.keys.len() == 1 => keys.keys.first().cloned(),
+            None => None,
+        };
+        let jwk = match find(&keys) {
+            Some(jwk) => jwk,
+            None => {
+                keys = self.keys(true).await.map_err(TokenError::Invalid)?;
+                find(&keys).ok_or_else(|| {
+                    TokenError::Invalid("JWT signing key is not in the JWKS".to_string())
+                })?
+            }
+        };
+        let key = DecodingKey::from_jwk(&jwk)
+            .map_err(|e| TokenError::Invalid(format!("Unusable JWKS key: {}", e)))?;
+
+        let mut validation = Validation::new(header.alg);
+        validation.set_required_spec_claims(&["exp"]);
+        validation.validate_nbf = true;
+        if let Some(issuer) = &self.issuer {
+            validation.set_issuer(&[issuer]);
+        }
+        match &self.audience {
+            Some(audience) => validation.set_audience(&[audience]),
+            None => validation.validate_aud = false,
+        }
+
+        decode::<serde_json::Value>(token, &key, &validation)
+            .map(|data| data.claims)
+            .map_err(|e| match e.kind() {
+                ErrorKind::ExpiredSignature => TokenError::Expired,
+                _ => TokenError::Invalid(format!("Invalid JWT: {}", e)),
+            })
+    }
+}
+
+/// True when `token` has the three dot-separated parts of a JWT
+fn looks_like_jwt(token: &str) -> bool {
+    token.split('.').count() == 3 && token.starts_with("eyJ")
+}
+
+/// A 401 with `challenge` as `WWW-Authenticate`
+fn unauthorized(code: &str, message: String, challenge: &'static str) -> Response {
+    let mut response = (
+        StatusCode::UNAUTHORIZED,
+        Json(json!({
+            "error": {
+                "message": message,
+                "type": "authentication_error",
+                "param": null,
+                "code": code
+            }
+        })),
+    )
+        .into_response();
+    response.headers_mut().insert(
+        header::WWW_AUTHENTICATE,
+        HeaderValue::from_static(challenge),
+    );
+    audit_events::mark(response, AuditKind::AuthFailure, Some(code), &message)
+}
+
+/// Middleware verifying JWT bearer tokens, and requiring one when
+/// `INFERNO_REQUIRE_JWT` is set
+pub async fn authenticate(
+    State(state): State<Arc<ServerState>>,
+    mut request: Request,
+    next: Next,
+) -> Response {
+    let auth = &state.jwt_auth;
+    if !auth.enabled() {
+        return next.run(request).await;
+    }
+
+    let token = request
+        .headers()
+        .get(header::AUTHORIZATION)
+        .and_then(|value| value.to_str().ok())
+        .and_then(|value| value.strip_prefix("Bearer "))
+        .map(str::trim)
+        .filter(|token| looks_like_jwt(token))
+        .map(str::to_string);
+
+    let Some(token) = token else {
+        let exempt = request.method() == Method::OPTIONS
+            || request.uri().path().starts_with("/health")
+            || usage_export::is_signed_download(&request)
+            || authorize_admin(request.headers()).is_ok();
+        if auth.required && !exempt {
+            return unauthorized(
+                "token_required",
+                "This server requires a JWT bearer token".to_string(),
+                "Bearer",
+            );
+        }
+        return next.run(request).await;
+    };
+
+    match auth.verify(&token).await {
+        Ok(claims) => {
+            request.extensions_mut().insert(JwtClaims(claims));
+            next.run(request).await
+        }
+        Err(TokenError::Expired) => unauthorized(
+            "token_expired",
+            "The JWT has expired; refresh it and retry".to_string(),
+            "Bearer error=\"invalid_token\", error_description=\"token expired\"",
+        ),
+        Err(TokenError::Invalid(message)) => {
+            unauthorized("invalid_token", message, "Bearer error=\"invalid_token\"")
+        }
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_looks_like_jwt() {
+        assert!(looks_like_jwt("eyJhbGciOiJSUzI1NiJ9.eyJzdWIiOiIxIn0.c2ln"));
+        assert!(!looks_like_jwt("sk-inferno-0123456789"));
+        assert!(!looks_like_jwt("eyJhbGciOiJSUzI1NiJ9.eyJzdWIiOiIxIn0"));
+    }
+
+    #[tokio::test]
+    async fn test_rejects_symmetric_algorithms() {
+        let auth = JwtAuth::from_env().unwrap();
+        // {"alg":"HS256","typ":"JWT"}.{"exp":1}
+        let token = "eyJhbGciOiJIUzI1NiIsInR5cCI6IkpXVCJ9.eyJleHAiOjF9.c2ln";
+        match auth.verify(token).await {
+            Err(TokenError::Invalid(message)) => assert!(message.contains("HS256")),
+            other => panic!("expected an invalid token, got {:?}", other),
+        }
+    }
+}
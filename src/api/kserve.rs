@@ -0,0 +1,537 @@
+//! KServe v2 (Open Inference Protocol) Compatibility
+//!
+//! Serving meshes and model routers built around KServe and Triton talk to
+//! models through the v2 REST protocol: server and model health, model
+//! metadata, and `infer` requests carrying named tensors. Inferno exposes its
+//! text models through it with one `BYTES` input, `text_input`, and one
+//! `BYTES` output, `text_output`, each element of the input batch being a
+//! separate prompt. A second output, `finish_reason`, is returned when asked
+//! for. Sampling options travel in the request's `parameters`.
+//!
+//! Inferno does not version models, so every model reports the single version
+//! `"1"`. This module is the REST binding; the protocol's gRPC binding is a
+//! separate item on the roadmap, and clients that need it should use REST
+//! until then. Errors use the protocol's `{"error": "..."}` body.
+//!
+//! Requests go through the same routing, queue, cancellation and deadline
+//! handling as the OpenAI-compatible endpoints.
+
+use crate::{
+    api::{
+        cancellation::{generate_cancellable, with_request_id},
+        deadline::resolve_deadline,
+        openai::get_or_load_backend,
+        operations::ServerMode,
+        queue::priority_from_headers,
+        routing::with_route,
+    },
+    backends::{BackendType, InferenceParams},
+    cli::serve::ServerState,
+};
+use axum::{
+    Json,
+    extract::{Path, State},
+    http::{HeaderMap, StatusCode},
+    response::{IntoResponse, Response},
+};
+use serde::{Deserialize, Serialize};
+use serde_json::{Value, json};
+use std::sync::Arc;
+
+/// The only version reported for, and accepted on, every model
+pub const MODEL_VERSION: &str = "1";
+
+const TEXT_INPUT: &str = "text_input";
+const TEXT_OUTPUT: &str = "text_output";
+const FINISH_REASON_OUTPUT: &str = "finish_reason";
+const BYTES: &str = "BYTES";
+
+/// Name, datatype and shape of a model input or output; -1 is variable
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct TensorMetadata {
+    pub name: String,
+    pub datatype: String,
+    pub shape: Vec<i64>,
+}
+
+/// Body of `GET /v2/models/{name}`
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct ModelMetadata {
+    pub name: String,
+    pub versions: Vec<String>,
+    pub platform: String,
+    pub inputs: Vec<TensorMetadata>,
+    pub outputs: Vec<TensorMetadata>,
+}
+
+/// Sampling options accepted in an infer request's `parameters`
+#[derive(Debug, Clone, Default, Serialize, Deserialize)]
+pub struct InferParameters {
+    #[serde(default)]
+    pub max_tokens: Option<u32>,
+    #[serde(default)]
+    pub temperature: Option<f32>,
+    #[serde(default)]
+    pub top_p: Option<f32>,
+    #[serde(default)]
+    pub top_k: Option<u32>,
+    #[serde(default)]
+    pub stop: Option<Vec<String>>,
+    #[serde(default)]
+    pub seed: Option<u64>,
+    /// Server-enforced time budget for the whole batch, in milliseconds
+    #[serde(default)]
+    pub timeout_ms: Option<u64>,
+}
+
+/// An input tensor; `data` may be flat or nested to match `shape`
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct RequestInput {
+    pub name: String,
+    pub shape: Vec<i64>,
+    pub datatype: String,
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub parameters: Option<Value>,
+    pub data: Value,
+}
+
+/// An output the client wants returned
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct RequestOutput {
+    pub name: String,
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub parameters: Option<Value>,
+}
+
+/// Body of `POST /v2/models/{name}/infer`
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct InferRequest {
+    #[serde(default)]
+    pub id: Option<String>,
+    #[serde(default)]
+    pub parameters: Option<InferParameters>,
+    pub inputs: Vec<RequestInput>,
+    /// Outputs to return; `text_output` alone when omitted
+    #[serde(default)]
+    pub outputs: Option<Vec<RequestOutput>>,
+}
+
+/// An output tensor, with flat row-major `data`
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct ResponseOutput {
+    pub name: String,
+    pub shape: Vec<i64>,
+    pub datatype: String,
+    pub data: Vec<String>,
+}
+
+/// Body of a successful infer response
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct InferResponse {
+    pub model_name: String,
+    pub model_version: String,
+    pub id: String,
+    pub outputs: Vec<ResponseOutput>,
+}
+
+/// An error in the protocol's `{"error": "..."}` body
+fn v2_error(status: StatusCode, message: String) -> Response {
+    (status, Json(json!({ "error": message }))).into_response()
+}
+
+/// Read the prompts out of the request's `text_input` tensor, checking its
+/// datatype and shape. Inputs other than `text_input` are rejected rather
+/// than ignored.
+fn text_inputs(inputs: &[RequestInput]) -> Result<Vec<String>, String> {
+    if let Some(input) = inputs.iter().find(|input| input.name != TEXT_INPUT) {
+        return Err(format!(
+            "Unexpected input '{}'; only '{}' is accepted, with sampling options in parameters",
+            input.name, TEXT_INPUT
+        ));
+    }
+    let input = match inputs {
+        [input] => input,
+        [] => return Err(format!("Missing input '{}'", TEXT_INPUT)),
+        _ => return Err(format!("Input '{}' given more than once", TEXT_INPUT)),
+    };
+    if input.datatype != BYTES {
+        return Err(format!(
+            "Input '{}' must have datatype {}, got {}",
+            TEXT_INPUT, BYTES, input.datatype
+        ));
+    }
+
+    let mut prompts = Vec::new();
+    flatten_strings(&input.data, &mut prompts)?;
+
+    if input.shape.iter().any(|&dim| dim < 0) {
+        return Err(format!("Input '{}' has a negative dimension", TEXT_INPUT));
+    }
+    let elements: i64 = input.shape.iter().product();
+    if elements != prompts.len() as i64 {
+        return Err(format!(
+            "Input '{}' has shape {:?} but {} elements",
+            TEXT_INPUT,
+            input.shape,
+            prompts.len()
+        ));
+    }
+
+    Ok(prompts)
+}
+
+fn flatten_strings(data: &Value, out: &mut Vec<String>) -> Result<(), String> {
+    match data {
+        Value::String(text) => out.push(text.clone()),
+        Value::Array(items) => {
+            for item in items {
+                flatten_strings(item, out)?;
+            }
+        }
+        other => {
+            return Err(format!(
+                "Input '{}' must hold strings, got {}",
+                TEXT_INPUT, other
+            ));
+        }
+    }
+    Ok(())
+}
+
+/// Resolve the requested output names, defaulting to `text_output`
+fn requested_outputs(outputs: Option<&[RequestOutput]>) -> Result<Vec<&'static str>, String> {
+    let Some(outputs) = outputs.filter(|outputs| !outputs.is_empty()) else {
+        return Ok(vec![TEXT_OUTPUT]);
+    };
+    outputs
+        .iter()
+        .map(|output| match output.name.as_str() {
+            TEXT_OUTPUT => Ok(TEXT_OUTPUT),
+            FINISH_REASON_OUTPUT => Ok(FINISH_REASON_OUTPUT),
+            other => Err(format!(
+                "Unknown output '{}'; available outputs are '{}' and '{}'",
+                other, TEXT_OUTPUT, FINISH_REASON_OUTPUT
+            )),
+        })
+        .collect()
+}
+
+fn bytes_output(name: &str, data: Vec<String>) -> ResponseOutput {
+    ResponseOutput {
+        name: name.to_string(),
+        shape: vec![data.len() as i64],
+        datatype: BYTES.to_string(),
+        data,
+    }
+}
+
+fn check_version(version: Option<&str>) -> Result<(), Response> {
+    match version {
+        Some(version) if version != MODEL_VERSION => Err(v2_error(
+            StatusCode::NOT_FOUND,
+            format!(
+                "Model version '{}' not found; Inferno serves version '{}' only",
+                version, MODEL_VERSION
+            ),
+        )),
+        _ => Ok(()),
+    }
+}
+
+async fn find_model(
+    state: &Arc<ServerState>,
+    name: &str,
+) -> Result<crate::models::ModelInfo, Response> {
+    let models = state.model_manager.list_models().await.map_err(|e| {
+        v2_error(
+            StatusCode::INTERNAL_SERVER_ERROR,
+            format!("Failed to list models: {}", e),
+        )
+    })?;
+    models
+        .into_iter()
+        .find(|model| model.name == name)
+        .ok_or_else(|| v2_error(StatusCode::NOT_FOUND, format!("Model '{}' not found", name)))
+}
+
+// API Handlers
+
+/// `GET /v2` - server metadata
+pub async fn server_metadata() -> Response {
+    Json(json!({
+        "name": "inferno",
+        "version": env!("CARGO_PKG_VERSION"),
+        "extensions": []
+    }))
+    .into_response()
+}
+
+/// `GET /v2/health/live`
+pub async fn server_live() -> Response {
+    Json(json!({ "live": true })).into_response()
+}
+
+/// `GET /v2/health/ready` - models load on demand, so a live server is ready
+/// unless it is in maintenance or draining
+pub async fn server_ready(State(state): State<Arc<ServerState>>) -> Response {
+    if state.operations.mode() != ServerMode::Serving {
+        return (
+            StatusCode::SERVICE_UNAVAILABLE,
+            Json(json!({ "ready": false })),
+        )
+            .into_response();
+    }
+    Json(json!({ "ready": true })).into_response()
+}
+
+/// `GET /v2/models/{name}[/versions/{version}]/ready`; any model Inferno can
+/// find is ready, since it is loaded on first use
+pub async fn model_ready(
+    State(state): State<Arc<ServerState>>,
+    Path(path): Path<Vec<String>>,
+) -> Response {
+    let (name, version) = split_path(&path);
+    if let Err(response) = check_version(version) {
+        return response;
+    }
+    match find_model(&state, name).await {
+        Ok(_) => Json(json!({ "name": name, "ready": true })).into_response(),
+        Err(response) => response,
+    }
+}
+
+/// `GET /v2/models/{name}[/versions/{version}]`
+pub async fn model_metadata(
+    State(state): State<Arc<ServerState>>,
+    Path(path): Path<Vec<String>>,
+) -> Response {
+    let (name, version) = split_path(&path);
+    if let Err(response) = check_version(version) {
+        return response;
+    }
+    let model = match find_model(&state, name).await {
+        Ok(model) => model,
+        Err(response) => return response,
+    };
+
+    let platform = BackendType::from_model_path(&model.path)
+        .map(|backend_type| format!("inferno_{}", backend_type))
+        .unwrap_or_else(|| "inferno".to_string());
+    let tensor = |name: &str| TensorMetadata {
+        name: name.to_string(),
+        datatype: BYTES.to_string(),
+        shape: vec![-1],
+    };
+
+    Json(ModelMetadata {
+        name: model.name,
+        versions: vec![MODEL_VERSION.to_string()],
+        platform,
+        inputs: vec![tensor(TEXT_INPUT)],
+        outputs: vec![tensor(TEXT_OUTPUT), tensor(FINISH_REASON_OUTPUT)],
+    })
+    .into_response()
+}
+
+/// `POST /v2/models/{name}[/versions/{version}]/infer` - one generation per
+/// element of `text_input`, run in order
+pub async fn model_infer(
+    State(state): State<Arc<ServerState>>,
+    Path(path): Path<Vec<String>>,
+    headers: HeaderMap,
+    Json(request): Json<InferRequest>,
+) -> Response {
+    let started = std::time::Instant::now();
+    let (name, version) = split_path(&path);
+    if let Err(response) = check_version(version) {
+        return response;
+    }
+
+    let prompts = match text_inputs(&request.inputs) {
+        Ok(prompts) => prompts,
+        Err(message) => return v2_error(StatusCode::BAD_REQUEST, message),
+    };
+    let outputs = match requested_outputs(request.outputs.as_deref()) {
+        Ok(outputs) => outputs,
+        Err(message) => return v2_error(StatusCode::BAD_REQUEST, message),
+    };
+    let parameters = request.parameters.clone().unwrap_or_default();
+
+    // Resolve a routing alias to the arm that will serve this request
+    let mut model = name.to_string();
+    let route = state.model_router.route(&model, None).await;
+    if let Some(route) = &route {
+        model = route.model.clone();
+    }
+
+    let ticket =
+        match state
+            .request_queue
+            .enqueue_request(&headers, &model, priority_from_headers(&headers))
+        {
+            Ok(ticket) => ticket
+                .with_scheduling(None, &headers)
+                .with_deadline(resolve_deadline(parameters.timeout_ms, None)),
+            Err(response) => return response,
+        };
+    let request_id = ticket.id().to_string();
+
+    let backend = match get_or_load_backend(&state, &model).await {
+        Ok(backend) => backend,
+        Err(e) => {
+            return v2_error(
+                StatusCode::NOT_FOUND,
+                format!("Failed to load model {}: {}", model, e),
+            );
+        }
+    };
+
+    let defaults = InferenceParams::default();
+    let params = InferenceParams {
+        max_tokens: parameters.max_tokens.unwrap_or(defaults.max_tokens),
+        temperature: parameters.temperature.unwrap_or(defaults.temperature),
+        top_p: parameters.top_p.unwrap_or(defaults.top_p),
+        top_k: parameters.top_k.unwrap_or(defaults.top_k),
+        stop_sequences: parameters.stop.clone().unwrap_or_default(),
+        seed: parameters.seed,
+        ..defaults
+    };
+
+    ticket.start().await;
+
+    // A cancel or timeout ends each remaining generation immediately, so
+    // every input still gets an (empty) output and a finish reason
+    let mut texts = Vec::with_capacity(prompts.len());
+    let mut finish_reasons = Vec::with_capacity(prompts.len());
+    let mut failure = None;
+    for prompt in &prompts {
+        match generate_cancellable(
+            &backend,
+            prompt,
+            &params,
+            ticket.cancel_signal(),
+            ticket.deadline(),
+        )
+        .await
+        {
+            Ok(generation) => {
+                texts.push(generation.text);
+                finish_reasons.push(generation.finish_reason.as_str().to_string());
+            }
+            Err(e) => {
+                failure = Some(e);
+                break;
+            }
+        }
+    }
+
+    let response = match failure {
+        Some(e) => v2_error(
+            StatusCode::INTERNAL_SERVER_ERROR,
+            format!("Inference failed: {}", e),
+        ),
+        None => {
+            let outputs = outputs
+                .into_iter()
+                .map(|output| match output {
+                    FINISH_REASON_OUTPUT => bytes_output(output, finish_reasons.clone()),
+                    _ => bytes_output(output, texts.clone()),
+                })
+                .collect();
+            Json(InferResponse {
+                model_name: model.clone(),
+                model_version: MODEL_VERSION.to_string(),
+                id: request.id.clone().unwrap_or_else(|| request_id.clone()),
+                outputs,
+            })
+            .into_response()
+        }
+    };
+
+    // Feed routed outcomes to any canary rollout watching the alias
+    if let Some(route) = &route {
+        state
+            .rollouts
+            .record_outcome(
+                route,
+                started.elapsed(),
+                response.status().is_server_error(),
+            )
+            .await;
+    }
+
+    with_route(with_request_id(response, &request_id), route.as_ref())
+}
+
+/// Split `[name]` or `[name, version]` path captures
+fn split_path(path: &[String]) -> (&str, Option<&str>) {
+    (
+        path.first().map(String::as_str).unwrap_or_default(),
+        path.get(1).map(String::as_str),
+    )
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn input(shape: Vec<i64>, data: Value) -> RequestInput {
+        RequestInput {
+            name: TEXT_INPUT.to_string(),
+            shape,
+            datatype: BYTES.to_string(),
+            parameters: None,
+            data,
+        }
+    }
+
+    #[test]
+    fn test_text_inputs_accepts_flat_and_nested_data() {
+        let flat = input(vec![2], json!(["a", "b"]));
+        assert_eq!(text_inputs(&[flat]).unwrap(), vec!["a", "b"]);
+
+        let nested = input(vec![2, 1], json!([["a"], ["b"]]));
+        assert_eq!(text_inputs(&[nested]).unwrap(), vec!["a", "b"]);
+    }
+
+    #[test]
+    fn test_text_inputs_rejects_bad_tensors() {
+        assert!(text_inputs(&[]).is_err());
+        assert!(text_inputs(&[input(vec![3], json!(["a", "b"]))]).is_err());
+        assert!(text_inputs(&[input(vec![1], json!([1]))]).is_err());
+
+        let mut wrong_type = input(vec![1], json!(["a"]));
+        wrong_type.datatype = "FP32".to_string();
+        assert!(text_inputs(&[wrong_type]).is_err());
+
+        let mut extra = input(vec![1], json!([256]));
+        extra.name = "max_tokens".to_string();
+        assert!(text_inputs(&[input(vec![1], json!(["a"])), extra]).is_err());
+    }
+
+    #[test]
+    fn test_requested_outputs() {
+        assert_eq!(requested_outputs(None).unwrap(), vec![TEXT_OUTPUT]);
+
+        let outputs = vec![
+            RequestOutput {
+                name: FINISH_REASON_OUTPUT.to_string(),
+                parameters: None,
+            },
+            RequestOutput {
+                name: TEXT_OUTPUT.to_string(),
+                parameters: None,
+            },
+        ];
+        assert_eq!(
+            requested_outputs(Some(&outputs)).unwrap(),
+            vec![FINISH_REASON_OUTPUT, TEXT_OUTPUT]
+        );
+
+        let unknown = vec![RequestOutput {
+            name: "logits".to_string(),
+            parameters: None,
+        }];
+        assert!(requested_outputs(Some(&unknown)).is_err());
+    }
+}
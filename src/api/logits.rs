@@ -0,0 +1,250 @@
+//! Logit Inspection
+//!
+//! `POST /v1/debug/logits` generates a short completion and returns, for
+//! every step, the sampled token and the highest-logit alternatives with
+//! their log-probabilities and the entropy of the distribution. It is meant
+//! for diagnosing sampling settings and quantization damage, returns a lot
+//! of data per token, and so is admin-only and capped at a few dozen steps.
+
+use crate::{
+    api::{
+        admin::authorize_admin, cancellation::with_request_id, invalid_request,
+        openai::get_or_load_backend, queue::priority_from_headers,
+    },
+    backends::{InferenceParams, LogitStep},
+    cli::serve::ServerState,
+};
+use axum::{
+    Json,
+    extract::State,
+    http::HeaderMap,
+    response::{IntoResponse, Response},
+};
+use serde::{Deserialize, Serialize};
+use serde_json::json;
+use std::sync::Arc;
+
+/// Most generation steps one inspection may trace
+const MAX_INSPECT_STEPS: u32 = 64;
+
+/// Most alternatives reported per step
+const MAX_TOP_LOGITS: usize = 20;
+
+fn default_max_tokens() -> u32 {
+    16
+}
+
+fn default_top_logits() -> usize {
+    5
+}
+
+fn default_top_k() -> u32 {
+    40
+}
+
+fn default_top_p() -> f32 {
+    1.0
+}
+
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct InspectLogitsRequest {
+    pub model: String,
+    /// Required unless `input_ids` is given
+    #[serde(default)]
+    pub prompt: Option<String>,
+    /// Trace from these token IDs instead of tokenizing `prompt`
+    #[serde(default)]
+    pub input_ids: Option<Vec<u32>>,
+    /// Steps to trace (at most 64)
+    #[serde(default = "default_max_tokens")]
+    pub max_tokens: u32,
+    /// Alternatives to report per step (at most 20)
+    #[serde(default = "default_top_logits")]
+    pub top_logits: usize,
+    /// Sampling temperature; the default of 0 traces greedy decoding
+    #[serde(default)]
+    pub temperature: f32,
+    #[serde(default = "default_top_k")]
+    pub top_k: u32,
+    #[serde(default = "default_top_p")]
+    pub top_p: f32,
+    #[serde(default)]
+    pub seed: Option<u64>,
+    /// Attention summaries per step; no backend exposes its attention
+    /// weights yet, so asking for them is rejected
+    #[serde(default)]
+    pub attention: bool,
+}
+
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct InspectLogitsResponse {
+    pub model: String,
+    pub prompt_tokens: u32,
+    /// The sampled tokens joined together
+    pub text: String,
+    /// `stop` when generation hit EOS, `length` when it ran out of steps
+    pub finish_reason: String,
+    pub steps: Vec<LogitStep>,
+}
+
+impl InspectLogitsRequest {
+    fn validate(&self) -> Result<(), (String, &'static str)> {
+        match (&self.prompt, &self.input_ids) {
+            (Some(_), Some(_)) => {
+                return Err((
+                    "Set either prompt or input_ids, not both".to_string(),
+                    "input_ids",
+                ));
+            }
+            (None, None) => return Err(("prompt is required".to_string(), "prompt")),
+            (None, Some(ids)) if ids.is_empty() => {
+                return Err((
+                    "input_ids must contain at least one token".to_string(),
+                    "input_ids",
+                ));
+            }
+            _ => {}
+        }
+        if self.max_tokens == 0 || self.max_tokens > MAX_INSPECT_STEPS {
+            return Err((
+                format!("max_tokens must be between 1 and {}", MAX_INSPECT_STEPS),
+                "max_tokens",
+            ));
+        }
+        if self.top_logits == 0 || self.top_logits > MAX_TOP_LOGITS {
+            return Err((
+                format!("top_logits must be between 1 and {}", MAX_TOP_LOGITS),
+                "top_logits",
+            ));
+        }
+        Ok(())
+    }
+}
+
+// API Handlers
+
+/// `POST /v1/debug/logits` - trace the top logits of a short generation
+/// (admin only)
+pub async fn inspect_logits(
+    State(state): State<Arc<ServerState>>,
+    headers: HeaderMap,
+    Json(request): Json<InspectLogitsRequest>,
+) -> Response {
+    if let Err(response) = authorize_admin(&headers) {
+        return response;
+    }
+    if let Err((message, param)) = request.validate() {
+        return invalid_request(message, Some(param), None);
+    }
+    if request.attention {
+        return invalid_request(
+            "Attention summaries are not available: no backend exposes its attention weights"
+                .to_string(),
+            Some("attention"),
+            Some("attention_not_supported"),
+        );
+    }
+
+    let ticket = match state.request_queue.enqueue_request(
+        &headers,
+        &request.model,
+        priority_from_headers(&headers),
+    ) {
+        Ok(ticket) => ticket.with_scheduling(None, &headers),
+        Err(response) => return response,
+    };
+    let request_id = ticket.id().to_string();
+
+    let backend = match get_or_load_backend(&state, &request.model).await {
+        Ok(backend) => backend,
+        Err(e) => {
+            return invalid_request(format!("Failed to load model: {}", e), Some("model"), None);
+        }
+    };
+
+    let mut prompt = request.prompt.clone().unwrap_or_default();
+    if let Some(ids) = &request.input_ids {
+        if !backend.supports_token_input() {
+            return invalid_request(
+                format!(
+                    "The {} backend cannot generate from input_ids",
+                    backend.get_backend_type()
+                ),
+                Some("input_ids"),
+                Some("token_input_not_supported"),
+            );
+        }
+        prompt = match backend.detokenize(ids).await {
+            Ok(text) => text,
+            Err(e) => return invalid_request(e.to_string(), Some("input_ids"), None),
+        };
+    }
+
+    let params = InferenceParams {
+        max_tokens: request.max_tokens,
+        temperature: request.temperature,
+        top_k: request.top_k,
+        top_p: request.top_p,
+        seed: request.seed,
+        prompt_token_ids: request.input_ids.clone(),
+        ..Default::default()
+    };
+
+    ticket.start().await;
+    let trace = match backend
+        .trace_logits(&prompt, &params, request.top_logits)
+        .await
+    {
+        Ok(trace) => trace,
+        Err(e) => {
+            return invalid_request(e.to_string(), Some("model"), Some("logits_not_supported"));
+        }
+    };
+
+    let text = trace
+        .steps
+        .iter()
+        .map(|step| step.chosen.token.as_str())
+        .collect();
+    let finish_reason = if trace.stopped { "stop" } else { "length" };
+    let response = Json(InspectLogitsResponse {
+        model: request.model,
+        prompt_tokens: trace.prompt_tokens,
+        text,
+        finish_reason: finish_reason.to_string(),
+        steps: trace.steps,
+    })
+    .into_response();
+
+    with_request_id(response, &request_id)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn request(body: serde_json::Value) -> InspectLogitsRequest {
+        serde_json::from_value(body).unwrap()
+    }
+
+    #[test]
+    fn test_defaults_trace_greedy_decoding() {
+        let inspect = request(json!({ "model": "m", "prompt": "Hello" }));
+        assert_eq!(inspect.max_tokens, 16);
+        assert_eq!(inspect.top_logits, 5);
+        assert_eq!(inspect.temperature, 0.0);
+        assert!(inspect.validate().is_ok());
+    }
+
+    #[test]
+    fn test_caps_steps_and_alternatives() {
+        let too_long = request(json!({ "model": "m", "prompt": "Hi", "max_tokens": 65 }));
+        assert_eq!(too_long.validate().unwrap_err().1, "max_tokens");
+
+        let too_wide = request(json!({ "model": "m", "prompt": "Hi", "top_logits": 21 }));
+        assert_eq!(too_wide.validate().unwrap_err().1, "top_logits");
+
+        let both = request(json!({ "model": "m", "prompt": "Hi", "input_ids": [1] }));
+        assert_eq!(both.validate().unwrap_err().1, "input_ids");
+    }
+}
@@ -0,0 +1,457 @@
+//! Server Log Streaming
+//!
+//! On-call engineers tail the server's logs through the API instead of
+//! logging in to the host. [`LogCapture`] is a tracing layer installed next
+//! to the console formatter; it keeps the most recent log lines in memory
+//! and sends each new one to subscribers.
+//!
+//! `GET /admin/logs` returns the recent lines as structured JSON. With
+//! `follow=true` it becomes a server-sent event stream that sends the recent
+//! lines and then each new one as it is logged. `level` keeps lines at or
+//! above a severity and `component` keeps one part of the server: a name
+//! from [`component_of`] such as `inference`, or a module path such as
+//! `api::openai`. Only lines the console logging lets through are captured,
+//! so `RUST_LOG` also bounds what can be tailed.
+
+use crate::api::{admin::authorize_admin, invalid_request};
+use axum::{
+    Json,
+    extract::Query,
+    http::HeaderMap,
+    response::{
+        IntoResponse, Response,
+        sse::{Event, KeepAlive, Sse},
+    },
+};
+use chrono::{DateTime, Utc};
+use serde::{Deserialize, Serialize};
+use serde_json::{Map, Value, json};
+use std::{
+    collections::VecDeque,
+    fmt,
+    sync::{
+        LazyLock, Mutex,
+        atomic::{AtomicU64, Ordering},
+    },
+};
+use tokio::sync::broadcast;
+use tracing::{
+    Event as TracingEvent, Level, Subscriber,
+    field::{Field, Visit},
+};
+use tracing_subscriber::layer::{Context, Layer};
+
+/// Log lines kept for `/admin/logs` and stream replay
+const RECENT_LINES: usize = 2000;
+
+/// Live lines buffered per subscriber before it is considered lagging
+const LINE_CHANNEL_CAPACITY: usize = 1024;
+
+/// Lines `/admin/logs` returns when no limit is given
+const DEFAULT_LIMIT: usize = 100;
+
+/// Severity of a log line, least severe first
+#[derive(Debug, Clone, Copy, PartialEq, Eq, PartialOrd, Ord, Serialize, Deserialize)]
+#[serde(rename_all = "lowercase")]
+pub enum LogLevel {
+    Trace,
+    Debug,
+    Info,
+    Warn,
+    Error,
+}
+
+impl LogLevel {
+    fn parse(name: &str) -> Option<Self> {
+        match name.to_ascii_lowercase().as_str() {
+            "trace" => Some(LogLevel::Trace),
+            "debug" => Some(LogLevel::Debug),
+            "info" => Some(LogLevel::Info),
+            "warn" | "warning" => Some(LogLevel::Warn),
+            "error" => Some(LogLevel::Error),
+            _ => None,
+        }
+    }
+}
+
+impl From<&Level> for LogLevel {
+    fn from(level: &Level) -> Self {
+        match *level {
+            Level::TRACE => LogLevel::Trace,
+            Level::DEBUG => LogLevel::Debug,
+            Level::INFO => LogLevel::Info,
+            Level::WARN => LogLevel::Warn,
+            Level::ERROR => LogLevel::Error,
+        }
+    }
+}
+
+/// One captured log line
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct LogEntry {
+    /// Increases by one per line, for resuming a stream
+    pub id: u64,
+    pub timestamp: DateTime<Utc>,
+    pub level: LogLevel,
+    pub component: String,
+    /// Module that logged the line
+    pub target: String,
+    pub message: String,
+    /// Structured fields logged alongside the message
+    #[serde(default, skip_serializing_if = "Map::is_empty")]
+    pub fields: Map<String, Value>,
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub file: Option<String>,
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub line: Option<u32>,
+}
+
+/// The part of the server a module belongs to. Modules outside Inferno are
+/// named after their crate.
+pub fn component_of(target: &str) -> &str {
+    let Some(path) = target.strip_prefix("inferno::") else {
+        return target.split("::").next().unwrap_or(target);
+    };
+    match path.split("::").next().unwrap_or(path) {
+        "backends" | "optimization" | "batch" | "streaming" => "inference",
+        "api" | "cli" => "api",
+        "models" | "conversion" | "cache" | "response_cache" | "model_versioning" => "models",
+        other => other,
+    }
+}
+
+/// Which lines a reader wants; empty fields match everything
+#[derive(Debug, Clone, Default, Deserialize)]
+pub struct LogQuery {
+    /// Keep streaming new lines after the recent ones
+    #[serde(default)]
+    pub follow: bool,
+    /// Lowest severity to include
+    #[serde(default)]
+    pub level: Option<String>,
+    /// A component name or a module path under `inferno::`
+    #[serde(default)]
+    pub component: Option<String>,
+    /// Only lines with a greater id
+    #[serde(default)]
+    pub since_id: Option<u64>,
+    /// Most recent lines to return or replay, newest kept
+    #[serde(default)]
+    pub limit: Option<usize>,
+}
+
+/// A [`LogQuery`] with its level parsed
+#[derive(Debug, Clone)]
+struct LineFilter {
+    level: LogLevel,
+    component: Option<String>,
+    since_id: u64,
+}
+
+impl Default for LineFilter {
+    fn default() -> Self {
+        Self {
+            level: LogLevel::Trace,
+            component: None,
+            since_id: 0,
+        }
+    }
+}
+
+impl LineFilter {
+    fn parse(query: &LogQuery) -> Result<Self, String> {
+        let level = match query.level.as_deref().filter(|l| !l.is_empty()) {
+            Some(name) => LogLevel::parse(name).ok_or_else(|| {
+                format!(
+                    "Unknown log level '{}'; expected trace, debug, info, warn or error",
+                    name
+                )
+            })?,
+            None => LogLevel::Trace,
+        };
+        Ok(Self {
+            level,
+            component: query.component.clone().filter(|c| !c.is_empty()),
+            since_id: query.since_id.unwrap_or(0),
+        })
+    }
+
+    fn matches(&self, entry: &LogEntry) -> bool {
+        entry.id > self.since_id
+            && entry.level >= self.level
+            && self.component.as_ref().is_none_or(|component| {
+                entry.component == *component
+                    || entry
+                        .target
+                        .strip_prefix("inferno::")
+                        .unwrap_or(&entry.target)
+                        .starts_with(component.as_str())
+            })
+    }
+}
+
+/// Recent log lines and the live feed of new ones
+#[derive(Debug)]
+pub struct LogBuffer {
+    recent: Mutex<VecDeque<LogEntry>>,
+    next_id: AtomicU64,
+    lines: broadcast::Sender<LogEntry>,
+}
+
+impl Default for LogBuffer {
+    fn default() -> Self {
+        let (lines, _) = broadcast::channel(LINE_CHANNEL_CAPACITY);
+        Self {
+            recent: Mutex::new(VecDeque::new()),
+            next_id: AtomicU64::new(1),
+            lines,
+        }
+    }
+}
+
+impl LogBuffer {
+    /// Assign the line an id, keep it and send it to subscribers
+    fn record(&self, mut entry: LogEntry) {
+        let mut recent = self.recent.lock().unwrap();
+        entry.id = self.next_id.fetch_add(1, Ordering::Relaxed);
+        if recent.len() == RECENT_LINES {
+            recent.pop_front();
+        }
+        recent.push_back(entry.clone());
+        // Send under the lock so subscribers see ids in order
+        let _ = self.lines.send(entry);
+    }
+
+    /// Up to `limit` of the newest held lines matching `filter`, oldest first
+    fn recent(&self, filter: &LineFilter, limit: usize) -> Vec<LogEntry> {
+        let recent = self.recent.lock().unwrap();
+        Self::tail(&recent, filter, limit)
+    }
+
+    /// Held lines matching `filter` and a receiver for the ones after them
+    fn replay_and_subscribe(
+        &self,
+        filter: &LineFilter,
+        limit: usize,
+    ) -> (Vec<LogEntry>, broadcast::Receiver<LogEntry>) {
+        let recent = self.recent.lock().unwrap();
+        let receiver = self.lines.subscribe();
+        (Self::tail(&recent, filter, limit), receiver)
+    }
+
+    fn tail(recent: &VecDeque<LogEntry>, filter: &LineFilter, limit: usize) -> Vec<LogEntry> {
+        let mut lines: Vec<LogEntry> = recent
+            .iter()
+            .rev()
+            .filter(|entry| filter.matches(entry))
+            .take(limit)
+            .cloned()
+            .collect();
+        lines.reverse();
+        lines
+    }
+}
+
+/// The process-wide buffer [`LogCapture`] fills
+static LOGS: LazyLock<LogBuffer> = LazyLock::new(LogBuffer::default);
+
+/// Tracing layer copying every log line into the buffer `/admin/logs` reads
+#[derive(Debug, Clone, Copy, Default)]
+pub struct LogCapture;
+
+impl LogCapture {
+    pub fn new() -> Self {
+        Self
+    }
+}
+
+impl<S: Subscriber> Layer<S> for LogCapture {
+    fn on_event(&self, event: &TracingEvent<'_>, _ctx: Context<'_, S>) {
+        let metadata = event.metadata();
+        let mut visitor = FieldVisitor::default();
+        event.record(&mut visitor);
+
+        LOGS.record(LogEntry {
+            id: 0,
+            timestamp: Utc::now(),
+            level: metadata.level().into(),
+            component: component_of(metadata.target()).to_string(),
+            target: metadata.target().to_string(),
+            message: visitor.message,
+            fields: visitor.fields,
+            file: metadata.file().map(str::to_string),
+            line: metadata.line(),
+        });
+    }
+}
+
+/// Collects an event's message and structured fields
+#[derive(Default)]
+struct FieldVisitor {
+    message: String,
+    fields: Map<String, Value>,
+}
+
+impl FieldVisitor {
+    fn insert(&mut self, field: &Field, value: Value) {
+        if field.name() == "message" {
+            self.message = match value {
+                Value::String(message) => message,
+                other => other.to_string(),
+            };
+        } else {
+            self.fields.insert(field.name().to_string(), value);
+        }
+    }
+}
+
+impl Visit for FieldVisitor {
+    fn record_str(&mut self, field: &Field, value: &str) {
+        self.insert(field, Value::from(value));
+    }
+
+    fn record_bool(&mut self, field: &Field, value: bool) {
+        self.insert(field, Value::from(value));
+    }
+
+    fn record_i64(&mut self, field: &Field, value: i64) {
+        self.insert(field, Value::from(value));
+    }
+
+    fn record_u64(&mut self, field: &Field, value: u64) {
+        self.insert(field, Value::from(value));
+    }
+
+    fn record_f64(&mut self, field: &Field, value: f64) {
+        self.insert(field, Value::from(value));
+    }
+
+    fn record_debug(&mut self, field: &Field, value: &dyn fmt::Debug) {
+        self.insert(field, Value::from(format!("{:?}", value)));
+    }
+}
+
+// API Handlers
+
+/// `GET /admin/logs` - recent log lines, oldest first, or with
+/// `follow=true` a server-sent event stream of them and every new one
+/// (admin only)
+pub async fn get_logs(headers: HeaderMap, Query(query): Query<LogQuery>) -> Response {
+    if let Err(response) = authorize_admin(&headers) {
+        return response;
+    }
+
+    let filter = match LineFilter::parse(&query) {
+        Ok(filter) => filter,
+        Err(e) => return invalid_request(e, Some("level"), None),
+    };
+    let limit = query.limit.unwrap_or(DEFAULT_LIMIT).min(RECENT_LINES);
+
+    if !query.follow {
+        let data = LOGS.recent(&filter, limit.max(1));
+        return Json(json!({ "object": "list", "data": data })).into_response();
+    }
+
+    let (replay, mut receiver) = LOGS.replay_and_subscribe(&filter, limit);
+    let stream = async_stream::stream! {
+        let mut last_id = filter.since_id;
+        for entry in replay {
+            last_id = entry.id;
+            yield Ok::<Event, axum::Error>(sse_event(&entry));
+        }
+
+        loop {
+            match receiver.recv().await {
+                Ok(entry) if entry.id > last_id && filter.matches(&entry) => {
+                    last_id = entry.id;
+                    yield Ok(sse_event(&entry));
+                }
+                Ok(_) => continue,
+                Err(broadcast::error::RecvError::Lagged(missed)) => {
+                    yield Ok(Event::default().event("lagged").data(json!({ "missed": missed }).to_string()));
+                }
+                Err(broadcast::error::RecvError::Closed) => break,
+            }
+        }
+    };
+
+    Sse::new(stream)
+        .keep_alive(KeepAlive::default())
+        .into_response()
+}
+
+fn sse_event(entry: &LogEntry) -> Event {
+    Event::default()
+        .id(entry.id.to_string())
+        .data(serde_json::to_string(entry).unwrap())
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn entry(level: LogLevel, target: &str) -> LogEntry {
+        LogEntry {
+            id: 0,
+            timestamp: Utc::now(),
+            level,
+            component: component_of(target).to_string(),
+            target: target.to_string(),
+            message: "line".to_string(),
+            fields: Map::new(),
+            file: None,
+            line: None,
+        }
+    }
+
+    #[test]
+    fn test_component_of_groups_modules() {
+        assert_eq!(component_of("inferno::backends::gguf"), "inference");
+        assert_eq!(component_of("inferno::api::openai"), "api");
+        assert_eq!(component_of("inferno::gpu"), "gpu");
+        assert_eq!(component_of("tower_http::trace::on_response"), "tower_http");
+    }
+
+    #[test]
+    fn test_filter_by_level_and_component() {
+        let buffer = LogBuffer::default();
+        buffer.record(entry(LogLevel::Info, "inferno::backends::gguf"));
+        buffer.record(entry(LogLevel::Warn, "inferno::backends::onnx"));
+        buffer.record(entry(LogLevel::Error, "inferno::api::openai"));
+
+        let filter = LineFilter::parse(&LogQuery {
+            level: Some("warn".to_string()),
+            component: Some("inference".to_string()),
+            ..Default::default()
+        })
+        .unwrap();
+        let lines = buffer.recent(&filter, 10);
+        assert_eq!(lines.len(), 1);
+        assert_eq!(lines[0].target, "inferno::backends::onnx");
+
+        let filter = LineFilter::parse(&LogQuery {
+            component: Some("api::openai".to_string()),
+            ..Default::default()
+        })
+        .unwrap();
+        assert_eq!(buffer.recent(&filter, 10).len(), 1);
+
+        assert!(
+            LineFilter::parse(&LogQuery {
+                level: Some("loud".to_string()),
+                ..Default::default()
+            })
+            .is_err()
+        );
+    }
+
+    #[test]
+    fn test_recent_keeps_newest() {
+        let buffer = LogBuffer::default();
+        for _ in 0..5 {
+            buffer.record(entry(LogLevel::Info, "inferno::api"));
+        }
+        let lines = buffer.recent(&LineFilter::default(), 2);
+        assert_eq!(lines.iter().map(|l| l.id).collect::<Vec<_>>(), vec![4, 5]);
+    }
+}
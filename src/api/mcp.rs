@@ -0,0 +1,581 @@
+//! Model Context Protocol (MCP) Server
+//!
+//! Desktop agents and editors discover local capabilities through MCP, a
+//! JSON-RPC 2.0 protocol. Inferno answers it over a WebSocket at `GET /mcp`,
+//! with one JSON-RPC message (or batch) per text frame; as plain JSON over
+//! `POST /mcp`; and over stdio with `inferno mcp`, one message per line. All
+//! transports share [`handle_message`].
+//!
+//! The server offers tools to list models, generate text, chat and embed, and
+//! exposes the model catalogue as resources (`inferno://models` and
+//! `inferno://models/{name}`). Generations go through the same routing,
+//! queue and cancellation handling as the HTTP API. Tool failures are
+//! reported in the tool result with `isError`, as MCP expects, so the agent
+//! can see and react to them; protocol errors use JSON-RPC error codes.
+
+use crate::{
+    api::{
+        cancellation::generate_cancellable,
+        openai::{ChatMessage, format_chat_messages, get_or_load_backend},
+    },
+    backends::InferenceParams,
+    cli::serve::ServerState,
+    operations::queue::Priority,
+};
+use axum::{
+    Json,
+    extract::{
+        State,
+        ws::{Message, WebSocket, WebSocketUpgrade},
+    },
+    http::StatusCode,
+    response::{IntoResponse, Response},
+};
+use serde::Deserialize;
+use serde_json::{Value, json};
+use std::sync::Arc;
+use tracing::{debug, info};
+
+/// Protocol revisions this server speaks, newest first
+pub const PROTOCOL_VERSIONS: &[&str] = &["2025-03-26", "2024-11-05"];
+
+const PARSE_ERROR: i64 = -32700;
+const INVALID_REQUEST: i64 = -32600;
+const METHOD_NOT_FOUND: i64 = -32601;
+const INVALID_PARAMS: i64 = -32602;
+const INTERNAL_ERROR: i64 = -32603;
+
+const MODELS_URI: &str = "inferno://models";
+const MODEL_URI_PREFIX: &str = "inferno://models/";
+
+/// A JSON-RPC error object
+#[derive(Debug, Clone, PartialEq)]
+pub struct RpcError {
+    pub code: i64,
+    pub message: String,
+}
+
+impl RpcError {
+    fn new(code: i64, message: impl Into<String>) -> Self {
+        Self {
+            code,
+            message: message.into(),
+        }
+    }
+}
+
+/// Arguments of the `generate` tool
+#[derive(Debug, Deserialize)]
+struct GenerateArgs {
+    model: String,
+    prompt: String,
+    #[serde(flatten)]
+    sampling: SamplingArgs,
+}
+
+/// Arguments of the `chat` tool
+#[derive(Debug, Deserialize)]
+struct ChatArgs {
+    model: String,
+    messages: Vec<ChatMessage>,
+    #[serde(default)]
+    system: Option<String>,
+    #[serde(flatten)]
+    sampling: SamplingArgs,
+}
+
+#[derive(Debug, Default, Deserialize)]
+struct SamplingArgs {
+    #[serde(default)]
+    max_tokens: Option<u32>,
+    #[serde(default)]
+    temperature: Option<f32>,
+    #[serde(default)]
+    stop: Option<Vec<String>>,
+}
+
+impl SamplingArgs {
+    fn params(&self) -> InferenceParams {
+        let defaults = InferenceParams::default();
+        InferenceParams {
+            max_tokens: self.max_tokens.unwrap_or(defaults.max_tokens),
+            temperature: self.temperature.unwrap_or(defaults.temperature),
+            stop_sequences: self.stop.clone().unwrap_or_default(),
+            ..defaults
+        }
+    }
+}
+
+/// Arguments of the `embed` tool
+#[derive(Debug, Deserialize)]
+struct EmbedArgs {
+    model: String,
+    input: String,
+}
+
+/// Handle one JSON-RPC message or batch, returning the response to send, if
+/// any; notifications get none.
+pub async fn handle_message(state: &Arc<ServerState>, message: Value) -> Option<Value> {
+    match message {
+        Value::Array(batch) if batch.is_empty() => Some(error_response(
+            Value::Null,
+            RpcError::new(INVALID_REQUEST, "Empty batch"),
+        )),
+        Value::Array(batch) => {
+            let mut responses = Vec::new();
+            for message in batch {
+                if let Some(response) = handle_single(state, message).await {
+                    responses.push(response);
+                }
+            }
+            (!responses.is_empty()).then(|| Value::Array(responses))
+        }
+        message => handle_single(state, message).await,
+    }
+}
+
+/// Parse and handle one line or frame of text
+pub async fn handle_text(state: &Arc<ServerState>, text: &str) -> Option<Value> {
+    match serde_json::from_str(text) {
+        Ok(message) => handle_message(state, message).await,
+        Err(e) => Some(error_response(
+            Value::Null,
+            RpcError::new(PARSE_ERROR, format!("Parse error: {}", e)),
+        )),
+    }
+}
+
+async fn handle_single(state: &Arc<ServerState>, message: Value) -> Option<Value> {
+    if !message.is_object() {
+        return Some(error_response(
+            Value::Null,
+            RpcError::new(INVALID_REQUEST, "Invalid request"),
+        ));
+    }
+    let id = message.get("id").cloned();
+    let Some(method) = message.get("method").and_then(Value::as_str) else {
+        // Responses to requests we never send are dropped, like notifications
+        return id
+            .filter(|_| message.get("result").is_none() && message.get("error").is_none())
+            .map(|id| error_response(id, RpcError::new(INVALID_REQUEST, "Missing method")));
+    };
+    let params = message.get("params").cloned().unwrap_or(Value::Null);
+
+    let Some(id) = id else {
+        debug!("MCP notification: {}", method);
+        return None;
+    };
+
+    Some(match dispatch(state, method, params).await {
+        Ok(result) => json!({ "jsonrpc": "2.0", "id": id, "result": result }),
+        Err(error) => error_response(id, error),
+    })
+}
+
+fn error_response(id: Value, error: RpcError) -> Value {
+    json!({
+        "jsonrpc": "2.0",
+        "id": id,
+        "error": { "code": error.code, "message": error.message }
+    })
+}
+
+async fn dispatch(
+    state: &Arc<ServerState>,
+    method: &str,
+    params: Value,
+) -> Result<Value, RpcError> {
+    match method {
+        "initialize" => Ok(initialize(&params)),
+        "ping" => Ok(json!({})),
+        "tools/list" => Ok(json!({ "tools": tool_definitions() })),
+        "tools/call" => call_tool(state, params).await,
+        "resources/list" => list_resources(state).await,
+        "resources/templates/list" => Ok(json!({
+            "resourceTemplates": [{
+                "uriTemplate": format!("{}{{name}}", MODEL_URI_PREFIX),
+                "name": "Model",
+                "description": "Metadata of one model",
+                "mimeType": "application/json"
+            }]
+        })),
+        "resources/read" => read_resource(state, params).await,
+        _ => Err(RpcError::new(
+            METHOD_NOT_FOUND,
+            format!("Method not found: {}", method),
+        )),
+    }
+}
+
+/// Answer `initialize`, agreeing on the client's protocol revision when we
+/// speak it and offering our newest otherwise
+fn initialize(params: &Value) -> Value {
+    let requested = params.get("protocolVersion").and_then(Value::as_str);
+    let version = requested
+        .filter(|version| PROTOCOL_VERSIONS.contains(version))
+        .unwrap_or(PROTOCOL_VERSIONS[0]);
+
+    json!({
+        "protocolVersion": version,
+        "capabilities": {
+            "tools": { "listChanged": false },
+            "resources": { "subscribe": false, "listChanged": false }
+        },
+        "serverInfo": {
+            "name": "inferno",
+            "version": env!("CARGO_PKG_VERSION")
+        },
+        "instructions": "Local models served by Inferno. Call list_models to see what is \
+                         available, then generate, chat or embed with a model name."
+    })
+}
+
+fn tool_definitions() -> Value {
+    let sampling = json!({
+        "max_tokens": { "type": "integer", "minimum": 1, "description": "Maximum tokens to generate" },
+        "temperature": { "type": "number", "minimum": 0, "maximum": 2 },
+        "stop": { "type": "array", "items": { "type": "string" }, "description": "Stop sequences" }
+    });
+    let with_sampling = |mut properties: Value| {
+        if let (Some(properties), Some(sampling)) =
+            (properties.as_object_mut(), sampling.as_object())
+        {
+            properties.extend(sampling.clone());
+        }
+        properties
+    };
+
+    json!([
+        {
+            "name": "list_models",
+            "description": "List the models Inferno can serve",
+            "inputSchema": { "type": "object", "properties": {} }
+        },
+        {
+            "name": "generate",
+            "description": "Continue a text prompt with a local model",
+            "inputSchema": {
+                "type": "object",
+                "properties": with_sampling(json!({
+                    "model": { "type": "string" },
+                    "prompt": { "type": "string" }
+                })),
+                "required": ["model", "prompt"]
+            }
+        },
+        {
+            "name": "chat",
+            "description": "Answer a conversation with a local model",
+            "inputSchema": {
+                "type": "object",
+                "properties": with_sampling(json!({
+                    "model": { "type": "string" },
+                    "system": { "type": "string" },
+                    "messages": {
+                        "type": "array",
+                        "items": {
+                            "type": "object",
+                            "properties": {
+                                "role": { "type": "string", "enum": ["user", "assistant", "system"] },
+                                "content": { "type": "string" }
+                            },
+                            "required": ["role", "content"]
+                        }
+                    }
+                })),
+                "required": ["model", "messages"]
+            }
+        },
+        {
+            "name": "embed",
+            "description": "Embed text with a local model, returning the vector as JSON",
+            "inputSchema": {
+                "type": "object",
+                "properties": {
+                    "model": { "type": "string" },
+                    "input": { "type": "string" }
+                },
+                "required": ["model", "input"]
+            }
+        }
+    ])
+}
+
+async fn call_tool(state: &Arc<ServerState>, params: Value) -> Result<Value, RpcError> {
+    let name = params
+        .get("name")
+        .and_then(Value::as_str)
+        .ok_or_else(|| RpcError::new(INVALID_PARAMS, "Missing tool name"))?;
+    let arguments = params
+        .get("arguments")
+        .cloned()
+        .unwrap_or_else(|| json!({}));
+
+    let outcome = match name {
+        "list_models" => list_models(state).await.map(|models| models.to_string()),
+        "generate" => {
+            let args: GenerateArgs = parse_arguments(arguments)?;
+            generate(state, &args.model, &args.prompt, args.sampling.params()).await
+        }
+        "chat" => {
+            let args: ChatArgs = parse_arguments(arguments)?;
+            let mut messages = args.messages;
+            if let Some(system) = args.system {
+                messages.insert(0, message("system", system));
+            }
+            let prompt = format_chat_messages(&messages);
+            generate(state, &args.model, &prompt, args.sampling.params()).await
+        }
+        "embed" => {
+            let args: EmbedArgs = parse_arguments(arguments)?;
+            embed(state, &args.model, &args.input).await
+        }
+        _ => {
+            return Err(RpcError::new(
+                INVALID_PARAMS,
+                format!("Unknown tool: {}", name),
+            ));
+        }
+    };
+
+    Ok(match outcome {
+        Ok(text) => json!({ "content": [{ "type": "text", "text": text }], "isError": false }),
+        Err(e) => {
+            json!({ "content": [{ "type": "text", "text": e.to_string() }], "isError": true })
+        }
+    })
+}
+
+fn parse_arguments<T: for<'de> Deserialize<'de>>(arguments: Value) -> Result<T, RpcError> {
+    serde_json::from_value(arguments)
+        .map_err(|e| RpcError::new(INVALID_PARAMS, format!("Invalid arguments: {}", e)))
+}
+
+fn message(role: &str, content: String) -> ChatMessage {
+    ChatMessage {
+        role: role.to_string(),
+        content,
+        name: None,
+        tool_calls: None,
+        tool_call_id: None,
+    }
+}
+
+/// Run one generation through routing and the request queue
+async fn generate(
+    state: &Arc<ServerState>,
+    model: &str,
+    prompt: &str,
+    params: InferenceParams,
+) -> anyhow::Result<String> {
+    let started = std::time::Instant::now();
+    let route = state.model_router.route(model, None).await;
+    let model = route.as_ref().map_or(model, |route| route.model.as_str());
+
+    let ticket = state.request_queue.enqueue(None, model, Priority::Normal);
+    let result = async {
+        let backend = get_or_load_backend(state, model).await?;
+        ticket.start().await;
+        generate_cancellable(
+            &backend,
+            prompt,
+            &params,
+            ticket.cancel_signal(),
+            ticket.deadline(),
+        )
+        .await
+    }
+    .await;
+
+    // Feed routed outcomes to any canary rollout watching the alias
+    if let Some(route) = &route {
+        state
+            .rollouts
+            .record_outcome(route, started.elapsed(), result.is_err())
+            .await;
+    }
+
+    Ok(result?.text)
+}
+
+async fn embed(state: &Arc<ServerState>, model: &str, input: &str) -> anyhow::Result<String> {
+    let backend = get_or_load_backend(state, model).await?;
+    let embedding = backend.get_embeddings(input).await?;
+    Ok(serde_json::to_string(&embedding)?)
+}
+
+/// Summaries of the models Inferno can serve
+async fn list_models(state: &Arc<ServerState>) -> anyhow::Result<Value> {
+    let models = state.model_manager.list_models().await?;
+    Ok(Value::Array(
+        models
+            .into_iter()
+            .map(|model| {
+                json!({
+                    "name": model.name,
+                    "format": model.format,
+                    "backend": model.backend_type,
+                    "size_bytes": model.size_bytes,
+                    "modified": model.modified.to_rfc3339()
+                })
+            })
+            .collect(),
+    ))
+}
+
+async fn list_resources(state: &Arc<ServerState>) -> Result<Value, RpcError> {
+    let models = state
+        .model_manager
+        .list_models()
+        .await
+        .map_err(|e| RpcError::new(INTERNAL_ERROR, format!("Failed to list models: {}", e)))?;
+
+    let mut resources = vec![json!({
+        "uri": MODELS_URI,
+        "name": "Models",
+        "description": "Models Inferno can serve",
+        "mimeType": "application/json"
+    })];
+    resources.extend(models.into_iter().map(|model| {
+        json!({
+            "uri": format!("{}{}", MODEL_URI_PREFIX, model.name),
+            "name": model.name,
+            "mimeType": "application/json"
+        })
+    }));
+
+    Ok(json!({ "resources": resources }))
+}
+
+async fn read_resource(state: &Arc<ServerState>, params: Value) -> Result<Value, RpcError> {
+    let uri = params
+        .get("uri")
+        .and_then(Value::as_str)
+        .ok_or_else(|| RpcError::new(INVALID_PARAMS, "Missing uri"))?;
+
+    let contents = if uri == MODELS_URI {
+        list_models(state)
+            .await
+            .map_err(|e| RpcError::new(INTERNAL_ERROR, e.to_string()))?
+    } else if let Some(name) = uri.strip_prefix(MODEL_URI_PREFIX) {
+        let models = state
+            .model_manager
+            .list_models()
+            .await
+            .map_err(|e| RpcError::new(INTERNAL_ERROR, e.to_string()))?;
+        let model = models
+            .into_iter()
+            .find(|model| model.name == name)
+            .ok_or_else(|| RpcError::new(INVALID_PARAMS, format!("Model not found: {}", name)))?;
+        serde_json::to_value(model).map_err(|e| RpcError::new(INTERNAL_ERROR, e.to_string()))?
+    } else {
+        return Err(RpcError::new(
+            INVALID_PARAMS,
+            format!("Unknown resource: {}", uri),
+        ));
+    };
+
+    Ok(json!({
+        "contents": [{
+            "uri": uri,
+            "mimeType": "application/json",
+            "text": contents.to_string()
+        }]
+    }))
+}
+
+// API Handlers
+
+/// `POST /mcp` - one JSON-RPC message or batch per request, answered with a
+/// JSON body (`202 Accepted` for notifications)
+pub async fn mcp_http(State(state): State<Arc<ServerState>>, body: String) -> Response {
+    match handle_text(&state, &body).await {
+        Some(response) => Json(response).into_response(),
+        None => StatusCode::ACCEPTED.into_response(),
+    }
+}
+
+/// `GET /mcp` - MCP over WebSocket, one JSON-RPC message per text frame
+pub async fn mcp_websocket(
+    ws: WebSocketUpgrade,
+    State(state): State<Arc<ServerState>>,
+) -> Response {
+    ws.on_upgrade(move |socket| serve_websocket(socket, state))
+}
+
+async fn serve_websocket(mut socket: WebSocket, state: Arc<ServerState>) {
+    info!("MCP WebSocket session started");
+
+    while let Some(Ok(frame)) = socket.recv().await {
+        let text = match frame {
+            Message::Text(text) => text,
+            Message::Close(_) => break,
+            _ => continue,
+        };
+        if let Some(response) = handle_text(&state, &text).await {
+            if socket
+                .send(Message::Text(response.to_string()))
+                .await
+                .is_err()
+            {
+                break;
+            }
+        }
+    }
+
+    info!("MCP WebSocket session ended");
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_initialize_negotiates_protocol_version() {
+        let agreed = initialize(&json!({ "protocolVersion": "2024-11-05" }));
+        assert_eq!(agreed["protocolVersion"], "2024-11-05");
+
+        let unknown = initialize(&json!({ "protocolVersion": "1999-01-01" }));
+        assert_eq!(unknown["protocolVersion"], PROTOCOL_VERSIONS[0]);
+        assert_eq!(unknown["serverInfo"]["name"], "inferno");
+    }
+
+    #[test]
+    fn test_tool_schemas_include_sampling_options() {
+        let tools = tool_definitions();
+        let names: Vec<_> = tools
+            .as_array()
+            .unwrap()
+            .iter()
+            .map(|tool| tool["name"].as_str().unwrap())
+            .collect();
+        assert_eq!(names, vec!["list_models", "generate", "chat", "embed"]);
+
+        let generate = &tools[1]["inputSchema"]["properties"];
+        assert!(generate.get("prompt").is_some());
+        assert!(generate.get("max_tokens").is_some());
+        assert!(
+            tools[3]["inputSchema"]["properties"]
+                .get("max_tokens")
+                .is_none()
+        );
+    }
+
+    #[test]
+    fn test_sampling_args_fill_defaults() {
+        let args: GenerateArgs = parse_arguments(json!({
+            "model": "m",
+            "prompt": "p",
+            "max_tokens": 16,
+            "stop": ["\n"]
+        }))
+        .unwrap();
+        let params = args.sampling.params();
+        assert_eq!(params.max_tokens, 16);
+        assert_eq!(params.stop_sequences, vec!["\n"]);
+        assert_eq!(params.temperature, InferenceParams::default().temperature);
+
+        let missing: Result<GenerateArgs, _> = parse_arguments(json!({ "model": "m" }));
+        assert_eq!(missing.unwrap_err().code, INVALID_PARAMS);
+    }
+}
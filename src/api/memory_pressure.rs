@@ -0,0 +1,572 @@
+//! Memory Pressure Events
+//!
+//! A background task compares RAM and each GPU's memory use with two
+//! thresholds every `interval_secs`. When a resource moves into `warning` or
+//! `critical`, or back to `normal`, a `memory_pressure` event goes on the
+//! audit event stream, so orchestrators can shift traffic or unload
+//! low-priority models before the server runs out.
+//!
+//! Each event lists the evictions the server is about to make. At `critical`
+//! it drops sessions idle longer than `session_idle_secs` and the held
+//! results of finished async jobs, emitting the event before it evicts. A
+//! `warning` event lists what would go at `critical`, with `executed` false.
+//! The loaded model is never unloaded here; that decision is left to the
+//! orchestrator.
+//!
+//! `GET /admin/memory` reports current use and settings, and `PUT` changes
+//! the settings.
+
+use crate::{
+    api::{
+        admin::authorize_admin,
+        audit_events::{AuditEvent, AuditKind},
+        invalid_request,
+    },
+    cli::serve::ServerState,
+};
+use axum::{
+    Json,
+    extract::State,
+    http::HeaderMap,
+    response::{IntoResponse, Response},
+};
+use chrono::{DateTime, Utc};
+use serde::{Deserialize, Serialize};
+use serde_json::json;
+use std::{
+    collections::HashMap,
+    sync::{Arc, Mutex},
+    time::Duration,
+};
+use sysinfo::{System, SystemExt};
+use tracing::warn;
+
+/// Path recorded on the audit events the monitor emits
+const EVENT_PATH: &str = "/admin/memory";
+
+/// Memory pressure settings
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct MemoryPressureConfig {
+    pub enabled: bool,
+    /// Seconds between checks
+    pub interval_secs: u64,
+    /// Percent of a resource in use at which it is under pressure
+    pub warning_percent: f64,
+    /// Percent in use at which the server starts evicting
+    pub critical_percent: f64,
+    /// Evict at `critical`; when false the events still list the evictions
+    /// but nothing is dropped
+    pub evict_on_critical: bool,
+    /// Sessions unused for this long are evicted at `critical`
+    pub session_idle_secs: u64,
+}
+
+impl Default for MemoryPressureConfig {
+    fn default() -> Self {
+        Self {
+            enabled: true,
+            interval_secs: 5,
+            warning_percent: 85.0,
+            critical_percent: 95.0,
+            evict_on_critical: true,
+            session_idle_secs: 60,
+        }
+    }
+}
+
+impl MemoryPressureConfig {
+    fn validate(&self) -> Result<(), String> {
+        if self.interval_secs == 0 {
+            return Err("interval_secs must be at least 1".to_string());
+        }
+        if !(self.warning_percent > 0.0 && self.warning_percent <= 100.0) {
+            return Err("warning_percent must be above 0 and at most 100".to_string());
+        }
+        if !(self.critical_percent >= self.warning_percent && self.critical_percent <= 100.0) {
+            return Err(
+                "critical_percent must be at least warning_percent and at most 100".to_string(),
+            );
+        }
+        Ok(())
+    }
+
+    fn level(&self, used_percent: f64) -> PressureLevel {
+        if used_percent >= self.critical_percent {
+            PressureLevel::Critical
+        } else if used_percent >= self.warning_percent {
+            PressureLevel::Warning
+        } else {
+            PressureLevel::Normal
+        }
+    }
+}
+
+/// Partial update; omitted fields keep their current value
+#[derive(Debug, Clone, Default, Deserialize)]
+pub struct MemoryPressureConfigUpdate {
+    pub enabled: Option<bool>,
+    pub interval_secs: Option<u64>,
+    pub warning_percent: Option<f64>,
+    pub critical_percent: Option<f64>,
+    pub evict_on_critical: Option<bool>,
+    pub session_idle_secs: Option<u64>,
+}
+
+impl MemoryPressureConfigUpdate {
+    fn apply(self, mut config: MemoryPressureConfig) -> MemoryPressureConfig {
+        if let Some(enabled) = self.enabled {
+            config.enabled = enabled;
+        }
+        if let Some(interval) = self.interval_secs {
+            config.interval_secs = interval;
+        }
+        if let Some(warning) = self.warning_percent {
+            config.warning_percent = warning;
+        }
+        if let Some(critical) = self.critical_percent {
+            config.critical_percent = critical;
+        }
+        if let Some(evict) = self.evict_on_critical {
+            config.evict_on_critical = evict;
+        }
+        if let Some(idle) = self.session_idle_secs {
+            config.session_idle_secs = idle;
+        }
+        config
+    }
+}
+
+/// How close a resource is to its limit
+#[derive(Debug, Clone, Copy, PartialEq, Eq, PartialOrd, Ord, Serialize, Deserialize)]
+#[serde(rename_all = "snake_case")]
+pub enum PressureLevel {
+    Normal,
+    Warning,
+    Critical,
+}
+
+impl PressureLevel {
+    fn code(&self) -> &'static str {
+        match self {
+            PressureLevel::Normal => "memory_pressure_resolved",
+            PressureLevel::Warning => "memory_pressure_warning",
+            PressureLevel::Critical => "memory_pressure_critical",
+        }
+    }
+}
+
+/// Use of one resource: `ram`, or `gpu:<index>` for a GPU's memory
+#[derive(Debug, Clone, Serialize)]
+pub struct MemoryUsage {
+    pub resource: String,
+    pub used_mb: u64,
+    pub total_mb: u64,
+    pub used_percent: f64,
+    pub level: PressureLevel,
+}
+
+/// What an eviction drops
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Serialize)]
+#[serde(rename_all = "snake_case")]
+pub enum EvictionTarget {
+    /// Idle conversation sessions
+    Session,
+    /// Held results of finished async inference jobs
+    InferenceJob,
+}
+
+#[derive(Debug, Clone, Serialize)]
+pub struct EvictionDecision {
+    pub target: EvictionTarget,
+    /// Least recently used first
+    pub ids: Vec<String>,
+}
+
+/// The `details` of a `memory_pressure` audit event
+#[derive(Debug, Clone, Serialize)]
+struct PressureDetails {
+    resource: String,
+    level: PressureLevel,
+    previous_level: PressureLevel,
+    used_mb: u64,
+    total_mb: u64,
+    used_percent: f64,
+    evictions: Vec<EvictionDecision>,
+    /// Whether the server is making the evictions now, rather than listing
+    /// what it would drop at `critical`
+    executed: bool,
+    #[serde(skip_serializing_if = "Option::is_none")]
+    loaded_model: Option<String>,
+}
+
+/// A resource whose level changed on the latest check
+#[derive(Debug, Clone)]
+struct LevelChange {
+    usage: MemoryUsage,
+    previous: PressureLevel,
+}
+
+#[derive(Debug, Default)]
+struct Inner {
+    config: MemoryPressureConfig,
+    levels: HashMap<String, PressureLevel>,
+    usage: Vec<MemoryUsage>,
+    last_checked: Option<DateTime<Utc>>,
+    evicted_sessions: u64,
+    evicted_jobs: u64,
+}
+
+/// Latest memory readings and the level each resource was last reported at
+#[derive(Debug, Default)]
+pub struct MemoryPressureMonitor {
+    inner: Mutex<Inner>,
+}
+
+impl MemoryPressureMonitor {
+    pub fn new() -> Self {
+        Self::default()
+    }
+
+    pub fn config(&self) -> MemoryPressureConfig {
+        self.inner.lock().unwrap().config.clone()
+    }
+
+    fn set_config(&self, config: MemoryPressureConfig) {
+        self.inner.lock().unwrap().config = config;
+    }
+
+    /// Store a check's readings and return the resources whose level changed
+    fn record(&self, usage: Vec<MemoryUsage>) -> Vec<LevelChange> {
+        let mut inner = self.inner.lock().unwrap();
+        let mut changes = Vec::new();
+        for reading in &usage {
+            let previous = inner
+                .levels
+                .insert(reading.resource.clone(), reading.level)
+                .unwrap_or(PressureLevel::Normal);
+            if previous != reading.level {
+                changes.push(LevelChange {
+                    usage: reading.clone(),
+                    previous,
+                });
+            }
+        }
+        inner.usage = usage;
+        inner.last_checked = Some(Utc::now());
+        changes
+    }
+
+    fn count_evictions(&self, sessions: usize, jobs: usize) {
+        let mut inner = self.inner.lock().unwrap();
+        inner.evicted_sessions += sessions as u64;
+        inner.evicted_jobs += jobs as u64;
+    }
+
+    /// The most pressed resource, if any was read
+    fn worst(&self) -> Option<MemoryUsage> {
+        let inner = self.inner.lock().unwrap();
+        inner
+            .usage
+            .iter()
+            .max_by(|a, b| a.used_percent.total_cmp(&b.used_percent))
+            .cloned()
+    }
+
+    fn status(&self) -> serde_json::Value {
+        let inner = self.inner.lock().unwrap();
+        let level = inner
+            .usage
+            .iter()
+            .map(|usage| usage.level)
+            .max()
+            .unwrap_or(PressureLevel::Normal);
+        json!({
+            "object": "memory.pressure",
+            "level": level,
+            "config": inner.config,
+            "resources": inner.usage,
+            "last_checked": inner.last_checked,
+            "evicted_sessions": inner.evicted_sessions,
+            "evicted_jobs": inner.evicted_jobs,
+        })
+    }
+}
+
+fn usage(
+    resource: String,
+    used_mb: u64,
+    total_mb: u64,
+    config: &MemoryPressureConfig,
+) -> MemoryUsage {
+    let used_percent = used_mb as f64 / total_mb as f64 * 100.0;
+    MemoryUsage {
+        resource,
+        used_mb,
+        total_mb,
+        used_percent,
+        level: config.level(used_percent),
+    }
+}
+
+/// RAM from the OS and GPU memory from the latest GPU telemetry samples
+fn read_usage(
+    state: &ServerState,
+    system: &mut System,
+    config: &MemoryPressureConfig,
+) -> Vec<MemoryUsage> {
+    let mut readings = Vec::new();
+
+    system.refresh_memory();
+    let total_mb = system.total_memory() / (1024 * 1024);
+    let available_mb = system.available_memory() / (1024 * 1024);
+    if total_mb > 0 {
+        readings.push(usage(
+            "ram".to_string(),
+            total_mb.saturating_sub(available_mb),
+            total_mb,
+            config,
+        ));
+    }
+
+    for gpu in state.gpu_telemetry.current() {
+        if gpu.memory_total_mb > 0 {
+            readings.push(usage(
+                format!("gpu:{}", gpu.index),
+                gpu.current.memory_used_mb,
+                gpu.memory_total_mb,
+                config,
+            ));
+        }
+    }
+    readings
+}
+
+/// What the server would drop to relieve pressure right now
+async fn plan_evictions(
+    state: &ServerState,
+    config: &MemoryPressureConfig,
+) -> Vec<EvictionDecision> {
+    let idle = Duration::from_secs(config.session_idle_secs);
+    let mut decisions = Vec::new();
+    let sessions = state.sessions.idle_ids(idle).await;
+    if !sessions.is_empty() {
+        decisions.push(EvictionDecision {
+            target: EvictionTarget::Session,
+            ids: sessions,
+        });
+    }
+    let jobs = state.inference_jobs.finished_ids().await;
+    if !jobs.is_empty() {
+        decisions.push(EvictionDecision {
+            target: EvictionTarget::InferenceJob,
+            ids: jobs,
+        });
+    }
+    decisions
+}
+
+async fn evict(state: &ServerState, config: &MemoryPressureConfig, decisions: &[EvictionDecision]) {
+    let idle = Duration::from_secs(config.session_idle_secs);
+    let mut sessions = 0;
+    let mut jobs = 0;
+    for decision in decisions {
+        match decision.target {
+            EvictionTarget::Session => sessions += state.sessions.evict(&decision.ids, idle).await,
+            EvictionTarget::InferenceJob => jobs += state.inference_jobs.evict(&decision.ids).await,
+        }
+    }
+    warn!(
+        "Memory pressure: evicted {} idle sessions and {} finished job results",
+        sessions, jobs
+    );
+    state.memory_pressure.count_evictions(sessions, jobs);
+}
+
+fn emit(
+    state: &ServerState,
+    code: &str,
+    usage: &MemoryUsage,
+    previous: PressureLevel,
+    evictions: &[EvictionDecision],
+    executed: bool,
+) {
+    let mut message = format!(
+        "{} at {:.1}% ({} of {} MB)",
+        usage.resource, usage.used_percent, usage.used_mb, usage.total_mb
+    );
+    let count: usize = evictions.iter().map(|d| d.ids.len()).sum();
+    if count > 0 {
+        message.push_str(&format!(
+            "; {} {} items",
+            if executed { "evicting" } else { "would evict" },
+            count
+        ));
+    }
+
+    let details = PressureDetails {
+        resource: usage.resource.clone(),
+        level: usage.level,
+        previous_level: previous,
+        used_mb: usage.used_mb,
+        total_mb: usage.total_mb,
+        used_percent: usage.used_percent,
+        evictions: evictions.to_vec(),
+        executed,
+        loaded_model: state.loaded_model.clone(),
+    };
+    state.audit.record(AuditEvent {
+        id: 0,
+        timestamp: Utc::now(),
+        kind: AuditKind::MemoryPressure,
+        method: String::new(),
+        path: EVENT_PATH.to_string(),
+        status: 0,
+        code: Some(code.to_string()),
+        message: Some(message),
+        tenant: None,
+        client_ip: None,
+        request_id: None,
+        metadata: None,
+        details: serde_json::to_value(details).ok(),
+    });
+}
+
+async fn check(state: &ServerState, system: &mut System, config: &MemoryPressureConfig) {
+    let changes = state
+        .memory_pressure
+        .record(read_usage(state, system, config));
+    let Some(worst) = state.memory_pressure.worst() else {
+        return;
+    };
+
+    let evictions = if worst.level > PressureLevel::Normal {
+        plan_evictions(state, config).await
+    } else {
+        Vec::new()
+    };
+    let execute =
+        worst.level == PressureLevel::Critical && config.evict_on_critical && !evictions.is_empty();
+
+    for change in &changes {
+        let listed = if change.usage.level > PressureLevel::Normal {
+            evictions.as_slice()
+        } else {
+            &[]
+        };
+        emit(
+            state,
+            change.usage.level.code(),
+            &change.usage,
+            change.previous,
+            listed,
+            execute && !listed.is_empty(),
+        );
+    }
+    // Still critical: whatever went idle since the last check goes too
+    if changes.is_empty() && execute {
+        emit(
+            state,
+            "memory_pressure_eviction",
+            &worst,
+            worst.level,
+            &evictions,
+            true,
+        );
+    }
+    if execute {
+        evict(state, config, &evictions).await;
+    }
+}
+
+/// Background loop watching memory use for the lifetime of the server
+pub async fn run(state: Arc<ServerState>) {
+    let mut system = System::new();
+    loop {
+        let config = state.memory_pressure.config();
+        tokio::time::sleep(Duration::from_secs(config.interval_secs)).await;
+        if config.enabled {
+            check(&state, &mut system, &config).await;
+        }
+    }
+}
+
+// API Handlers
+
+/// `GET /admin/memory` - memory use per resource and pressure settings
+/// (admin only)
+pub async fn get_memory(State(state): State<Arc<ServerState>>, headers: HeaderMap) -> Response {
+    if let Err(response) = authorize_admin(&headers) {
+        return response;
+    }
+    Json(state.memory_pressure.status()).into_response()
+}
+
+/// `PUT /admin/memory` - change thresholds and eviction settings (admin only)
+pub async fn update_memory(
+    State(state): State<Arc<ServerState>>,
+    headers: HeaderMap,
+    Json(update): Json<MemoryPressureConfigUpdate>,
+) -> Response {
+    if let Err(response) = authorize_admin(&headers) {
+        return response;
+    }
+
+    let config = update.apply(state.memory_pressure.config());
+    if let Err(message) = config.validate() {
+        return invalid_request(message, None, None);
+    }
+    state.memory_pressure.set_config(config);
+    Json(state.memory_pressure.status()).into_response()
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_levels_follow_thresholds() {
+        let config = MemoryPressureConfig::default();
+        assert_eq!(config.level(50.0), PressureLevel::Normal);
+        assert_eq!(config.level(85.0), PressureLevel::Warning);
+        assert_eq!(config.level(97.5), PressureLevel::Critical);
+    }
+
+    #[test]
+    fn test_config_validation() {
+        assert!(MemoryPressureConfig::default().validate().is_ok());
+        let inverted = MemoryPressureConfigUpdate {
+            warning_percent: Some(90.0),
+            critical_percent: Some(80.0),
+            ..Default::default()
+        }
+        .apply(MemoryPressureConfig::default());
+        assert!(inverted.validate().is_err());
+    }
+
+    #[test]
+    fn test_record_reports_only_level_changes() {
+        let monitor = MemoryPressureMonitor::new();
+        let config = MemoryPressureConfig::default();
+
+        let changes = monitor.record(vec![usage("ram".to_string(), 40, 100, &config)]);
+        assert!(changes.is_empty());
+
+        let changes = monitor.record(vec![
+            usage("ram".to_string(), 96, 100, &config),
+            usage("gpu:0".to_string(), 10, 100, &config),
+        ]);
+        assert_eq!(changes.len(), 1);
+        assert_eq!(changes[0].usage.level, PressureLevel::Critical);
+        assert_eq!(changes[0].previous, PressureLevel::Normal);
+
+        assert!(
+            monitor
+                .record(vec![usage("ram".to_string(), 97, 100, &config)])
+                .is_empty()
+        );
+        assert_eq!(monitor.worst().unwrap().resource, "ram");
+
+        let changes = monitor.record(vec![usage("ram".to_string(), 20, 100, &config)]);
+        assert_eq!(changes[0].usage.level.code(), "memory_pressure_resolved");
+    }
+}
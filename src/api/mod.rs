@@ -1,7 +1,76 @@
+pub mod admin;
+pub mod anthropic;
+pub mod api_keys;
+pub mod async_jobs;
+pub mod audit_events;
+pub mod batches;
+pub mod batching;
+pub mod benchmark;
+pub mod billing_webhooks;
+pub mod budgets;
+pub mod bundles;
+pub mod cancellation;
+pub mod capabilities;
+pub mod chat_template;
+pub mod cluster;
+pub mod completion_batch;
+pub mod completion_cache;
+pub mod conditional;
+pub mod cross_encoder;
+pub mod datasets;
+pub mod deadline;
+pub mod disk_cache;
+pub mod distillation;
+pub mod envelope;
+pub mod evals;
+pub mod evaluation;
+pub mod extract;
+pub mod files;
+pub mod fine_tuning;
+pub mod flags;
 pub mod flow_control;
+pub mod gpu_telemetry;
+pub mod health;
+pub mod hidden_states;
+pub mod hub;
+pub mod jwt_auth;
+pub mod kserve;
+pub mod logits;
+pub mod logs;
+pub mod mcp;
+pub mod memory_pressure;
+pub mod model_catalog;
+pub mod model_events;
+pub mod model_stores;
 pub mod openai;
 pub mod openai_compliance;
+pub mod operations;
+pub mod parallel;
+pub mod placement;
+pub mod pricing;
+pub mod profiling;
+pub mod queue;
+pub mod rollout;
+pub mod routing;
+pub mod runtime_config;
+pub mod sampling;
+pub mod scheduler;
+pub mod sessions;
+pub mod shadow;
+pub mod signing;
+pub mod speculative;
 pub mod streaming_enhancements;
+pub mod summarize;
+pub mod tenants;
+pub mod tokenize;
+pub mod tools;
+pub mod trace_export;
+pub mod translate;
+pub mod usage;
+pub mod usage_export;
+pub mod verification;
+pub mod version;
+pub mod watchdog;
 pub mod websocket;
 
 pub use flow_control::{BackpressureLevel, ConnectionPool, FlowControlConfig, StreamFlowControl};
@@ -11,3 +80,54 @@ pub use streaming_enhancements::{
     CompressionFormat, KeepAlive, SSEConfig, SSEMessage, StreamingOptimizationConfig,
     TimeoutManager, TokenBatcher,
 };
+
+use axum::{
+    Json,
+    http::StatusCode,
+    response::{IntoResponse, Response},
+};
+use serde_json::json;
+
+/// An OpenAI-style `invalid_request_error` with `status`, naming the request
+/// field at fault in `param` and a machine-readable `code` when there is one
+pub fn error_response(
+    status: StatusCode,
+    message: String,
+    param: Option<&str>,
+    code: Option<&str>,
+) -> Response {
+    (
+        status,
+        Json(json!({
+            "error": {
+                "message": message,
+                "type": "invalid_request_error",
+                "param": param,
+                "code": code
+            }
+        })),
+    )
+        .into_response()
+}
+
+/// `400` with an OpenAI-style `invalid_request_error`; see [`error_response`]
+pub fn invalid_request(message: String, param: Option<&str>, code: Option<&str>) -> Response {
+    error_response(StatusCode::BAD_REQUEST, message, param, code)
+}
+
+/// `500` with an OpenAI-style `internal_error`, for failures on the server's
+/// side rather than in the request
+pub fn internal_error(message: String) -> Response {
+    (
+        StatusCode::INTERNAL_SERVER_ERROR,
+        Json(json!({
+            "error": {
+                "message": message,
+                "type": "internal_error",
+                "param": null,
+                "code": null
+            }
+        })),
+    )
+        .into_response()
+}
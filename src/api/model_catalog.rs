@@ -0,0 +1,412 @@
+//! Model Catalog Watch
+//!
+//! The catalog is the set of model files `/v1/models` lists. A background
+//! task rescans it every few seconds and gives each change (a model added,
+//! modified or removed) the next catalog revision; listing the models
+//! rescans too. `/v1/models` reports the revision it reflects, and
+//! `GET /v1/models?watch=true&since=<revision>` returns only the changes
+//! after it, waiting up to `timeout_secs` when there are none yet. With
+//! `stream=true` the changes are sent as server-sent events instead, as
+//! they happen. Controllers keeping an inventory in sync list once and then
+//! watch from the listed revision.
+//!
+//! The last [`RECENT_CHANGES`] changes are kept. A `since` older than that
+//! gets `410 Gone` with code `revision_expired`, and the client lists again.
+
+use crate::{
+    api::{
+        invalid_request,
+        openai::{ModelObject, model_object},
+    },
+    cli::serve::ServerState,
+    models::ModelManager,
+};
+use axum::{
+    Json,
+    http::StatusCode,
+    response::{
+        IntoResponse, Response,
+        sse::{Event, KeepAlive, Sse},
+    },
+};
+use chrono::{DateTime, Utc};
+use serde::{Deserialize, Serialize};
+use serde_json::json;
+use std::{
+    collections::{BTreeMap, VecDeque},
+    sync::{Arc, Mutex},
+    time::Duration,
+};
+use tokio::sync::watch;
+use tracing::warn;
+
+/// Changes kept for watchers
+const RECENT_CHANGES: usize = 1000;
+
+/// How often the background task rescans the models directory
+const SCAN_INTERVAL: Duration = Duration::from_secs(5);
+
+/// Default and longest long-poll wait
+const DEFAULT_WATCH_TIMEOUT_SECS: u64 = 30;
+const MAX_WATCH_TIMEOUT_SECS: u64 = 300;
+
+/// What happened to a model
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Serialize, Deserialize)]
+#[serde(rename_all = "snake_case")]
+pub enum ModelChangeType {
+    Added,
+    /// The file's size or modification time changed
+    Modified,
+    Removed,
+}
+
+impl ModelChangeType {
+    fn as_str(&self) -> &'static str {
+        match self {
+            ModelChangeType::Added => "added",
+            ModelChangeType::Modified => "modified",
+            ModelChangeType::Removed => "removed",
+        }
+    }
+}
+
+/// One change to the catalog
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct ModelChange {
+    pub revision: u64,
+    #[serde(rename = "type")]
+    pub kind: ModelChangeType,
+    pub id: String,
+    /// The model as `/v1/models` lists it; absent on removals
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub model: Option<ModelObject>,
+    pub timestamp: DateTime<Utc>,
+}
+
+/// What a rescan compares to tell whether a model changed
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+struct Fingerprint {
+    size_bytes: u64,
+    modified: DateTime<Utc>,
+}
+
+#[derive(Debug, Default)]
+struct CatalogState {
+    revision: u64,
+    models: BTreeMap<String, Fingerprint>,
+    changes: VecDeque<ModelChange>,
+}
+
+impl CatalogState {
+    fn record(&mut self, kind: ModelChangeType, id: String, model: Option<ModelObject>) {
+        self.revision += 1;
+        self.changes.push_back(ModelChange {
+            revision: self.revision,
+            kind,
+            id,
+            model,
+            timestamp: Utc::now(),
+        });
+        while self.changes.len() > RECENT_CHANGES {
+            self.changes.pop_front();
+        }
+    }
+
+    /// Record how `models` differs from the last scan
+    fn apply(&mut self, models: &[crate::models::ModelInfo]) {
+        let mut current = BTreeMap::new();
+        for model in models {
+            let fingerprint = Fingerprint {
+                size_bytes: model.size_bytes,
+                modified: model.modified,
+            };
+            // Two files can share a name; the listing keeps the newest first
+            if current.contains_key(&model.name) {
+                continue;
+            }
+            current.insert(model.name.clone(), fingerprint);
+
+            let kind = match self.models.get(&model.name) {
+                None => ModelChangeType::Added,
+                Some(previous) if *previous != fingerprint => ModelChangeType::Modified,
+                Some(_) => continue,
+            };
+            self.record(kind, model.name.clone(), Some(model_object(model.clone())));
+        }
+
+        let removed: Vec<String> = self
+            .models
+            .keys()
+            .filter(|name| !current.contains_key(*name))
+            .cloned()
+            .collect();
+        for name in removed {
+            self.record(ModelChangeType::Removed, name, None);
+        }
+        self.models = current;
+    }
+
+    /// Changes after `since`, or `None` if some of them are no longer kept
+    fn since(&self, since: u64) -> Option<Vec<ModelChange>> {
+        let oldest = self
+            .changes
+            .front()
+            .map_or(self.revision + 1, |c| c.revision);
+        if since + 1 < oldest {
+            return None;
+        }
+        Some(
+            self.changes
+                .iter()
+                .filter(|change| change.revision > since)
+                .cloned()
+                .collect(),
+        )
+    }
+}
+
+/// Revisioned view of the models directory
+#[derive(Debug)]
+pub struct ModelCatalog {
+    state: Mutex<CatalogState>,
+    /// Serializes rescans, so an older scan never lands after a newer one
+    scan: tokio::sync::Mutex<()>,
+    revisions: watch::Sender<u64>,
+}
+
+impl Default for ModelCatalog {
+    fn default() -> Self {
+        let (revisions, _) = watch::channel(0);
+        Self {
+            state: Mutex::new(CatalogState::default()),
+            scan: tokio::sync::Mutex::new(()),
+            revisions,
+        }
+    }
+}
+
+impl ModelCatalog {
+    pub fn new() -> Self {
+        Self::default()
+    }
+
+    /// Rescan the models directory, recording any changes; returns the
+    /// models and the revision they are at
+    pub async fn refresh(
+        &self,
+        manager: &ModelManager,
+    ) -> anyhow::Result<(Vec<crate::models::ModelInfo>, u64)> {
+        let _scan = self.scan.lock().await;
+        let models = manager.list_models().await?;
+        let revision = {
+            let mut state = self.state.lock().unwrap();
+            state.apply(&models);
+            state.revision
+        };
+        self.revisions.send_replace(revision);
+        Ok((models, revision))
+    }
+
+    pub fn revision(&self) -> u64 {
+        self.state.lock().unwrap().revision
+    }
+
+    fn since(&self, since: u64) -> Option<Vec<ModelChange>> {
+        self.state.lock().unwrap().since(since)
+    }
+}
+
+/// Rescan the catalog until the server stops, so watchers see changes
+/// nobody listed
+pub async fn run(state: Arc<ServerState>) {
+    let mut ticker = tokio::time::interval(SCAN_INTERVAL);
+    loop {
+        ticker.tick().await;
+        if let Err(e) = state.model_catalog.refresh(&state.model_manager).await {
+            warn!("Model catalog scan failed: {}", e);
+        }
+    }
+}
+
+/// Query of `/v1/models`
+#[derive(Debug, Clone, Default, Deserialize)]
+pub struct ModelListQuery {
+    /// Return changes instead of the listing
+    #[serde(default)]
+    pub watch: bool,
+    /// Revision the client has seen; changes after it are returned
+    #[serde(default)]
+    pub since: Option<u64>,
+    /// Longest long-poll wait, in seconds (default 30, at most 300)
+    #[serde(default)]
+    pub timeout_secs: Option<u64>,
+    /// Send changes as server-sent events instead of long-polling
+    #[serde(default)]
+    pub stream: bool,
+}
+
+/// Response of a long-poll watch
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct ModelChangesResponse {
+    pub object: String,
+    /// The latest revision; pass it as `since` on the next watch
+    pub revision: u64,
+    pub changes: Vec<ModelChange>,
+}
+
+fn revision_expired(since: u64) -> Response {
+    (
+        StatusCode::GONE,
+        Json(json!({
+            "error": {
+                "message": format!(
+                    "Changes after revision {} are no longer kept; list /v1/models again",
+                    since
+                ),
+                "type": "invalid_request_error",
+                "param": "since",
+                "code": "revision_expired"
+            }
+        })),
+    )
+        .into_response()
+}
+
+/// `GET /v1/models?watch=true` - catalog changes after `since`
+pub async fn watch_models(state: Arc<ServerState>, query: ModelListQuery) -> Response {
+    let since = query.since.unwrap_or(0);
+    let timeout_secs = query.timeout_secs.unwrap_or(DEFAULT_WATCH_TIMEOUT_SECS);
+    if timeout_secs > MAX_WATCH_TIMEOUT_SECS {
+        return invalid_request(
+            format!("timeout_secs must be at most {}", MAX_WATCH_TIMEOUT_SECS),
+            Some("timeout_secs"),
+            None,
+        );
+    }
+    if since > state.model_catalog.revision() {
+        return invalid_request(
+            format!(
+                "since is ahead of the catalog revision {}",
+                state.model_catalog.revision()
+            ),
+            Some("since"),
+            None,
+        );
+    }
+
+    if query.stream {
+        return stream_changes(state, since);
+    }
+
+    let mut revisions = state.model_catalog.revisions.subscribe();
+    let deadline = tokio::time::Instant::now() + Duration::from_secs(timeout_secs);
+    loop {
+        let Some(changes) = state.model_catalog.since(since) else {
+            return revision_expired(since);
+        };
+        if !changes.is_empty() {
+            return changes_response(&state, changes);
+        }
+        match tokio::time::timeout_at(deadline, revisions.changed()).await {
+            Ok(Ok(())) => continue,
+            _ => return changes_response(&state, Vec::new()),
+        }
+    }
+}
+
+fn changes_response(state: &ServerState, changes: Vec<ModelChange>) -> Response {
+    let revision = changes
+        .last()
+        .map_or_else(|| state.model_catalog.revision(), |change| change.revision);
+    Json(ModelChangesResponse {
+        object: "list.delta".to_string(),
+        revision,
+        changes,
+    })
+    .into_response()
+}
+
+fn stream_changes(state: Arc<ServerState>, since: u64) -> Response {
+    let mut revisions = state.model_catalog.revisions.subscribe();
+    let stream = async_stream::stream! {
+        let mut last = since;
+        loop {
+            let Some(changes) = state.model_catalog.since(last) else {
+                let data = json!({ "since": last }).to_string();
+                yield Ok::<Event, axum::Error>(Event::default().event("expired").data(data));
+                break;
+            };
+            for change in changes {
+                last = change.revision;
+                yield Ok(sse_event(&change));
+            }
+            if revisions.changed().await.is_err() {
+                break;
+            }
+        }
+    };
+
+    Sse::new(stream)
+        .keep_alive(KeepAlive::default())
+        .into_response()
+}
+
+fn sse_event(change: &ModelChange) -> Event {
+    Event::default()
+        .id(change.revision.to_string())
+        .event(change.kind.as_str())
+        .data(serde_json::to_string(change).unwrap())
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use std::{collections::HashMap, path::PathBuf};
+
+    fn model(name: &str, size_bytes: u64) -> crate::models::ModelInfo {
+        crate::models::ModelInfo {
+            name: name.to_string(),
+            path: PathBuf::from(format!("/models/{}.gguf", name)),
+            file_path: PathBuf::from(format!("/models/{}.gguf", name)),
+            size: size_bytes,
+            size_bytes,
+            modified: DateTime::from_timestamp(1_700_000_000, 0).unwrap(),
+            backend_type: "gguf".to_string(),
+            format: "gguf".to_string(),
+            checksum: None,
+            metadata: HashMap::new(),
+            verification: None,
+        }
+    }
+
+    #[test]
+    fn test_changes_are_revisioned() {
+        let mut state = CatalogState::default();
+        state.apply(&[model("a", 1), model("b", 2)]);
+        assert_eq!(state.revision, 2);
+
+        state.apply(&[model("a", 10)]);
+        let changes = state.since(2).unwrap();
+        assert_eq!(changes.len(), 2);
+        assert_eq!(changes[0].kind, ModelChangeType::Modified);
+        assert_eq!(changes[1].kind, ModelChangeType::Removed);
+        assert_eq!(changes[1].id, "b");
+        assert!(changes[1].model.is_none());
+
+        // An unchanged scan records nothing
+        state.apply(&[model("a", 10)]);
+        assert_eq!(state.revision, 4);
+        assert!(state.since(4).unwrap().is_empty());
+    }
+
+    #[test]
+    fn test_old_revisions_expire() {
+        let mut state = CatalogState::default();
+        for i in 0..RECENT_CHANGES + 5 {
+            state.apply(&[model(&format!("m{}", i), 1)]);
+        }
+        assert!(state.since(0).is_none());
+        assert!(state.since(state.revision).unwrap().is_empty());
+        assert!(state.since(state.revision - 1).is_some());
+    }
+}
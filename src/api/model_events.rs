@@ -0,0 +1,354 @@
+//! Model Lifecycle Events
+//!
+//! Each step in a model's life on the server is published as a typed
+//! event: `downloaded` when a hub pull or model store fetch lands,
+//! `loaded` and `unloaded` as backends take it up and let it go, `evicted`
+//! when its cached download is removed, `deleted` when an admin removes its
+//! file, and `failed` when a download or load does not complete.
+//! `converted` is reserved for server-side conversion; today models are
+//! converted with `inferno convert`, which runs outside the server and
+//! publishes nothing.
+//!
+//! `GET /v1/models/events` sends the events as server-sent events,
+//! replaying the recent ones after `since_id` (or `Last-Event-ID`) first.
+//! Connections on `/ws/stream` receive them as `model_event` messages.
+//! Auto-warmers and dashboards react to these instead of polling.
+
+use crate::{api::invalid_request, cli::serve::ServerState};
+use axum::{
+    extract::{Query, State},
+    http::HeaderMap,
+    response::{
+        IntoResponse, Response,
+        sse::{Event, KeepAlive, Sse},
+    },
+};
+use chrono::{DateTime, Utc};
+use serde::{Deserialize, Serialize};
+use serde_json::json;
+use std::{
+    collections::VecDeque,
+    sync::{
+        Arc, Mutex,
+        atomic::{AtomicU64, Ordering},
+    },
+};
+use tokio::sync::broadcast;
+
+/// Events kept for replay
+const RECENT_EVENTS: usize = 500;
+
+/// Live events buffered per subscriber before it is considered lagging
+const EVENT_CHANNEL_CAPACITY: usize = 256;
+
+/// What happened to a model
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Serialize, Deserialize)]
+#[serde(rename_all = "snake_case")]
+pub enum ModelEventType {
+    Downloaded,
+    Converted,
+    Loaded,
+    Unloaded,
+    Evicted,
+    Deleted,
+    Failed,
+}
+
+impl ModelEventType {
+    pub fn as_str(&self) -> &'static str {
+        match self {
+            ModelEventType::Downloaded => "downloaded",
+            ModelEventType::Converted => "converted",
+            ModelEventType::Loaded => "loaded",
+            ModelEventType::Unloaded => "unloaded",
+            ModelEventType::Evicted => "evicted",
+            ModelEventType::Deleted => "deleted",
+            ModelEventType::Failed => "failed",
+        }
+    }
+
+    fn parse(name: &str) -> Option<Self> {
+        match name {
+            "downloaded" => Some(ModelEventType::Downloaded),
+            "converted" => Some(ModelEventType::Converted),
+            "loaded" => Some(ModelEventType::Loaded),
+            "unloaded" => Some(ModelEventType::Unloaded),
+            "evicted" => Some(ModelEventType::Evicted),
+            "deleted" => Some(ModelEventType::Deleted),
+            "failed" => Some(ModelEventType::Failed),
+            _ => None,
+        }
+    }
+}
+
+/// One model lifecycle event
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct ModelEvent {
+    pub id: u64,
+    pub timestamp: DateTime<Utc>,
+    #[serde(rename = "type")]
+    pub kind: ModelEventType,
+    /// Model name, or the store URL of a remote model
+    pub model: String,
+    /// What caused the event: `hub`, `model_store`, `startup`, `request`,
+    /// `watchdog`, `disk_cache` or `admin`
+    pub source: String,
+    /// On `failed`, the step that did not complete
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub stage: Option<ModelEventType>,
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub message: Option<String>,
+}
+
+/// Recent model events and the live feed of new ones
+#[derive(Debug)]
+pub struct ModelEventLog {
+    recent: Mutex<VecDeque<ModelEvent>>,
+    next_id: AtomicU64,
+    events: broadcast::Sender<ModelEvent>,
+}
+
+impl Default for ModelEventLog {
+    fn default() -> Self {
+        let (events, _) = broadcast::channel(EVENT_CHANNEL_CAPACITY);
+        Self {
+            recent: Mutex::new(VecDeque::new()),
+            next_id: AtomicU64::new(1),
+            events,
+        }
+    }
+}
+
+impl ModelEventLog {
+    pub fn new() -> Self {
+        Self::default()
+    }
+
+    /// Publish that `kind` happened to `model`
+    pub fn publish(&self, kind: ModelEventType, model: &str, source: &str) {
+        self.record(kind, model, source, None, None);
+    }
+
+    /// Publish that `stage` failed for `model`
+    pub fn publish_failure(
+        &self,
+        stage: ModelEventType,
+        model: &str,
+        source: &str,
+        error: impl std::fmt::Display,
+    ) {
+        let message = error.to_string();
+        self.record(
+            ModelEventType::Failed,
+            model,
+            source,
+            Some(stage),
+            Some(message),
+        );
+    }
+
+    fn record(
+        &self,
+        kind: ModelEventType,
+        model: &str,
+        source: &str,
+        stage: Option<ModelEventType>,
+        message: Option<String>,
+    ) {
+        let mut recent = self.recent.lock().unwrap();
+        let event = ModelEvent {
+            id: self.next_id.fetch_add(1, Ordering::Relaxed),
+            timestamp: Utc::now(),
+            kind,
+            model: model.to_string(),
+            source: source.to_string(),
+            stage,
+            message,
+        };
+        if recent.len() == RECENT_EVENTS {
+            recent.pop_front();
+        }
+        recent.push_back(event.clone());
+        // Send under the lock so subscribers see ids in order
+        let _ = self.events.send(event);
+    }
+
+    pub fn subscribe(&self) -> broadcast::Receiver<ModelEvent> {
+        self.events.subscribe()
+    }
+
+    /// Held events matching `filter` and a receiver for the ones after them
+    fn replay_and_subscribe(
+        &self,
+        filter: &EventFilter,
+    ) -> (Vec<ModelEvent>, broadcast::Receiver<ModelEvent>) {
+        let recent = self.recent.lock().unwrap();
+        let receiver = self.events.subscribe();
+        let replay = recent
+            .iter()
+            .filter(|event| filter.matches(event))
+            .cloned()
+            .collect();
+        (replay, receiver)
+    }
+}
+
+/// Query of `/v1/models/events`
+#[derive(Debug, Clone, Default, Deserialize)]
+pub struct ModelEventQuery {
+    /// Comma-separated event types
+    #[serde(default, rename = "type")]
+    pub kind: Option<String>,
+    #[serde(default)]
+    pub model: Option<String>,
+    /// Only events with a greater id
+    #[serde(default)]
+    pub since_id: Option<u64>,
+}
+
+#[derive(Debug, Clone, Default)]
+struct EventFilter {
+    kinds: Vec<ModelEventType>,
+    model: Option<String>,
+    since_id: u64,
+}
+
+impl EventFilter {
+    fn parse(query: ModelEventQuery) -> Result<Self, String> {
+        let mut kinds = Vec::new();
+        for name in query.kind.iter().flat_map(|kinds| kinds.split(',')) {
+            let name = name.trim();
+            if name.is_empty() {
+                continue;
+            }
+            match ModelEventType::parse(name) {
+                Some(kind) => kinds.push(kind),
+                None => {
+                    return Err(format!(
+                        "Unknown model event type '{}'; expected downloaded, converted, loaded, unloaded, evicted or failed",
+                        name
+                    ));
+                }
+            }
+        }
+        Ok(Self {
+            kinds,
+            model: query.model.filter(|m| !m.is_empty()),
+            since_id: query.since_id.unwrap_or(0),
+        })
+    }
+
+    fn matches(&self, event: &ModelEvent) -> bool {
+        event.id > self.since_id
+            && (self.kinds.is_empty() || self.kinds.contains(&event.kind))
+            && self
+                .model
+                .as_ref()
+                .is_none_or(|model| &event.model == model)
+    }
+}
+
+// API Handlers
+
+/// `GET /v1/models/events` - model lifecycle events as server-sent events,
+/// replaying held events after `since_id` first
+pub async fn stream_events(
+    State(state): State<Arc<ServerState>>,
+    headers: HeaderMap,
+    Query(query): Query<ModelEventQuery>,
+) -> Response {
+    let mut filter = match EventFilter::parse(query) {
+        Ok(filter) => filter,
+        Err(e) => return invalid_request(e, Some("type"), None),
+    };
+    if filter.since_id == 0
+        && let Some(last) = headers
+            .get("last-event-id")
+            .and_then(|v| v.to_str().ok())
+            .and_then(|v| v.trim().parse().ok())
+    {
+        filter.since_id = last;
+    }
+
+    let (replay, mut receiver) = state.model_events.replay_and_subscribe(&filter);
+    let stream = async_stream::stream! {
+        let mut last_id = filter.since_id;
+        for event in replay {
+            last_id = event.id;
+            yield Ok::<Event, axum::Error>(sse_event(&event));
+        }
+
+        loop {
+            match receiver.recv().await {
+                Ok(event) if event.id > last_id && filter.matches(&event) => {
+                    last_id = event.id;
+                    yield Ok(sse_event(&event));
+                }
+                Ok(_) => continue,
+                Err(broadcast::error::RecvError::Lagged(missed)) => {
+                    yield Ok(Event::default().event("lagged").data(json!({ "missed": missed }).to_string()));
+                }
+                Err(broadcast::error::RecvError::Closed) => break,
+            }
+        }
+    };
+
+    Sse::new(stream)
+        .keep_alive(KeepAlive::default())
+        .into_response()
+}
+
+fn sse_event(event: &ModelEvent) -> Event {
+    Event::default()
+        .id(event.id.to_string())
+        .event(event.kind.as_str())
+        .data(serde_json::to_string(event).unwrap())
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_events_are_numbered_and_filtered() {
+        let log = ModelEventLog::new();
+        log.publish(ModelEventType::Downloaded, "llama", "hub");
+        log.publish(ModelEventType::Loaded, "llama", "request");
+        log.publish_failure(
+            ModelEventType::Loaded,
+            "mistral",
+            "request",
+            "out of memory",
+        );
+
+        let filter = EventFilter::parse(ModelEventQuery {
+            kind: Some("loaded,failed".to_string()),
+            ..Default::default()
+        })
+        .unwrap();
+        let (replay, _) = log.replay_and_subscribe(&filter);
+        assert_eq!(replay.len(), 2);
+        assert_eq!(replay[0].id, 2);
+        assert_eq!(replay[1].kind, ModelEventType::Failed);
+        assert_eq!(replay[1].stage, Some(ModelEventType::Loaded));
+
+        let filter = EventFilter::parse(ModelEventQuery {
+            model: Some("llama".to_string()),
+            since_id: Some(1),
+            ..Default::default()
+        })
+        .unwrap();
+        let (replay, _) = log.replay_and_subscribe(&filter);
+        assert_eq!(replay.len(), 1);
+        assert_eq!(replay[0].kind, ModelEventType::Loaded);
+    }
+
+    #[test]
+    fn test_unknown_type_is_rejected() {
+        let query = ModelEventQuery {
+            kind: Some("warmed".to_string()),
+            ..Default::default()
+        };
+        assert!(EventFilter::parse(query).is_err());
+    }
+}
@@ -1,14 +1,45 @@
 use crate::{
-    backends::{BackendHandle, BackendType, InferenceParams},
+    api::{
+        admin::authorize_admin,
+        api_keys::KeyScope,
+        cancellation::{FinishReason, generate_cancellable, next_token, with_request_id},
+        completion_cache::CachePlan,
+        conditional,
+        deadline::resolve_deadline,
+        envelope::{self, Envelope},
+        evaluation::scoring_not_supported,
+        invalid_request,
+        model_catalog::{self, ModelListQuery},
+        model_events::ModelEventType,
+        model_stores,
+        queue::{QueueTicket, priority_from_headers},
+        routing::with_route,
+        runtime_config,
+        sampling::{self, SamplingExtensions},
+        shadow::{self, MirroredRequest},
+        tools::{self, ChatTool, ToolCall, ToolCallDelta, ToolCallStream, ToolChoice},
+    },
+    backends::{BackendHandle, BackendType, InferenceParams, ScoredText},
     cli::serve::ServerState,
+    models::{
+        GgufMetadata,
+        verification::{ModelVerification, VerificationPolicy},
+    },
 };
 use axum::{
-    extract::{Json, State},
-    http::StatusCode,
+    extract::{Json, Path, Query, State},
+    http::{HeaderMap, StatusCode},
     response::IntoResponse,
 };
 use serde::{Deserialize, Serialize};
-use std::sync::Arc;
+use std::{
+    collections::{BTreeMap, HashMap, HashSet},
+    sync::{
+        Arc,
+        atomic::{AtomicBool, Ordering},
+    },
+    time::{Duration, Instant},
+};
 use uuid::Uuid;
 
 // OpenAI API compatible types
@@ -17,7 +48,7 @@ use uuid::Uuid;
 pub struct ChatCompletionRequest {
     pub model: String,
     pub messages: Vec<ChatMessage>,
-    #[serde(default = "default_max_tokens")]
+    #[serde(default = "default_max_tokens", alias = "max_completion_tokens")]
     pub max_tokens: u32,
     #[serde(default = "default_temperature")]
     pub temperature: f32,
@@ -30,21 +61,70 @@ pub struct ChatCompletionRequest {
     #[serde(default)]
     pub stream: bool,
     #[serde(default)]
+    pub stream_options: Option<StreamOptions>,
+    #[serde(default, deserialize_with = "string_or_vec")]
     pub stop: Option<Vec<String>>,
     #[serde(default)]
     pub presence_penalty: Option<f32>,
     #[serde(default)]
     pub frequency_penalty: Option<f32>,
+    /// Return the log-probability of each generated token
+    #[serde(default)]
+    pub logprobs: bool,
+    /// Alternatives to list per token (0-20); requires `logprobs`
+    #[serde(default)]
+    pub top_logprobs: Option<u32>,
+    /// Functions the model may call; see `api::tools`
+    #[serde(default)]
+    pub tools: Option<Vec<ChatTool>>,
+    #[serde(default)]
+    pub tool_choice: Option<ToolChoice>,
+    /// Allow more than one tool call per response (default true)
+    #[serde(default)]
+    pub parallel_tool_calls: Option<bool>,
     #[serde(default)]
     pub user: Option<String>,
+    /// Server-enforced time budget for the generation, in milliseconds
+    #[serde(default)]
+    pub timeout_ms: Option<u64>,
+    /// Absolute deadline for the generation (RFC 3339)
+    #[serde(default)]
+    pub deadline: Option<chrono::DateTime<chrono::Utc>>,
+    /// Sampling seed; the same seed and parameters reproduce the same output
+    #[serde(default)]
+    pub seed: Option<u64>,
+    /// Scheduler priority class; the `X-Inferno-Priority-Class` header or
+    /// the policy's default class when omitted
+    #[serde(default)]
+    pub priority_class: Option<String>,
+    /// Neither answer from nor store in the response cache
+    #[serde(default)]
+    pub cache_bypass: bool,
+    /// The wrapped data key of `enc:v1:` content; see `api::envelope`
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub encryption: Option<Envelope>,
+    /// Tags the request's usage is attributed by; see `api::usage`
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub metadata: Option<BTreeMap<String, String>>,
+    /// vLLM sampling fields (`best_of`, `stop_token_ids`, ...)
+    #[serde(flatten)]
+    pub sampling: SamplingExtensions,
 }
 
 #[derive(Debug, Clone, Serialize, Deserialize)]
 pub struct ChatMessage {
     pub role: String,
+    /// Plain text; content-part arrays are accepted and their text joined
+    #[serde(default, deserialize_with = "message_content")]
     pub content: String,
     #[serde(skip_serializing_if = "Option::is_none")]
     pub name: Option<String>,
+    /// Calls made by an assistant message
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub tool_calls: Option<Vec<ToolCall>>,
+    /// The call a `tool` message answers
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub tool_call_id: Option<String>,
 }
 
 #[derive(Debug, Clone, Serialize, Deserialize)]
@@ -53,6 +133,7 @@ pub struct ChatCompletionResponse {
     pub object: String,
     pub created: i64,
     pub model: String,
+    pub system_fingerprint: Option<String>,
     pub choices: Vec<ChatChoice>,
     pub usage: Usage,
 }
@@ -61,9 +142,40 @@ pub struct ChatCompletionResponse {
 pub struct ChatChoice {
     pub index: u32,
     pub message: ChatMessage,
+    pub logprobs: Option<ChatLogprobs>,
     pub finish_reason: String,
 }
 
+/// `stream_options` of streaming requests
+#[derive(Debug, Clone, Default, Serialize, Deserialize)]
+pub struct StreamOptions {
+    /// Send a final chunk with empty `choices` carrying the request's usage
+    #[serde(default)]
+    pub include_usage: bool,
+}
+
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct ChatLogprobs {
+    pub content: Vec<ChatTokenLogprob>,
+}
+
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct ChatTokenLogprob {
+    pub token: String,
+    pub logprob: f32,
+    pub bytes: Vec<u8>,
+    pub top_logprobs: Vec<TopLogprob>,
+}
+
+/// The backends score only the sampled token, so this is the single
+/// alternative listed when `top_logprobs` is requested
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct TopLogprob {
+    pub token: String,
+    pub logprob: f32,
+    pub bytes: Vec<u8>,
+}
+
 #[derive(Debug, Clone, Serialize, Deserialize)]
 pub struct Usage {
     pub prompt_tokens: u32,
@@ -74,7 +186,16 @@ pub struct Usage {
 #[derive(Debug, Clone, Serialize, Deserialize)]
 pub struct CompletionRequest {
     pub model: String,
-    pub prompt: StringOrArray,
+    /// Required unless `input_ids` is given
+    #[serde(default)]
+    pub prompt: Option<StringOrArray>,
+    /// Generate from these token IDs instead of `prompt`, skipping
+    /// tokenization
+    #[serde(default)]
+    pub input_ids: Option<Vec<u32>>,
+    /// Return the prompt and completion token IDs on the choice
+    #[serde(default)]
+    pub return_token_ids: bool,
     #[serde(default = "default_max_tokens")]
     pub max_tokens: u32,
     #[serde(default = "default_temperature")]
@@ -88,19 +209,49 @@ pub struct CompletionRequest {
     #[serde(default)]
     pub stream: bool,
     #[serde(default)]
+    pub stream_options: Option<StreamOptions>,
+    /// Return log-probabilities for the generated tokens (0-5 alternatives)
+    #[serde(default)]
     pub logprobs: Option<u32>,
     #[serde(default)]
     pub echo: bool,
-    #[serde(default)]
+    #[serde(default, deserialize_with = "string_or_vec")]
     pub stop: Option<Vec<String>>,
     #[serde(default)]
     pub presence_penalty: Option<f32>,
     #[serde(default)]
     pub frequency_penalty: Option<f32>,
     #[serde(default)]
-    pub best_of: Option<u32>,
-    #[serde(default)]
     pub user: Option<String>,
+    /// Server-enforced time budget for the generation, in milliseconds
+    #[serde(default)]
+    pub timeout_ms: Option<u64>,
+    /// Absolute deadline for the generation (RFC 3339)
+    #[serde(default)]
+    pub deadline: Option<chrono::DateTime<chrono::Utc>>,
+    /// Sampling seed; the same seed and parameters reproduce the same output
+    #[serde(default)]
+    pub seed: Option<u64>,
+    /// Scheduler priority class; the `X-Inferno-Priority-Class` header or
+    /// the policy's default class when omitted
+    #[serde(default)]
+    pub priority_class: Option<String>,
+    /// Score this text as the continuation of `prompt` instead of generating;
+    /// the choice's `logprobs` then carries per-token log-probabilities
+    #[serde(default)]
+    pub score: Option<String>,
+    /// Neither answer from nor store in the response cache
+    #[serde(default)]
+    pub cache_bypass: bool,
+    /// The wrapped data key of `enc:v1:` content; see `api::envelope`
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub encryption: Option<Envelope>,
+    /// Tags the request's usage is attributed by; see `api::usage`
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub metadata: Option<BTreeMap<String, String>>,
+    /// vLLM sampling fields (`best_of`, `stop_token_ids`, ...)
+    #[serde(flatten)]
+    pub sampling: SamplingExtensions,
 }
 
 #[derive(Debug, Clone, Serialize, Deserialize)]
@@ -110,14 +261,27 @@ pub enum StringOrArray {
     Array(Vec<String>),
 }
 
+impl StringOrArray {
+    /// Each string, to rewrite in place
+    pub fn iter_mut(&mut self) -> std::slice::IterMut<'_, String> {
+        match self {
+            StringOrArray::String(s) => std::slice::from_mut(s).iter_mut(),
+            StringOrArray::Array(arr) => arr.iter_mut(),
+        }
+    }
+}
+
 #[derive(Debug, Clone, Serialize, Deserialize)]
 pub struct CompletionResponse {
     pub id: String,
     pub object: String,
     pub created: i64,
     pub model: String,
+    pub system_fingerprint: Option<String>,
     pub choices: Vec<CompletionChoice>,
-    pub usage: Usage,
+    /// Absent from streamed chunks unless `stream_options.include_usage` is set
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub usage: Option<Usage>,
 }
 
 #[derive(Debug, Clone, Serialize, Deserialize)]
@@ -125,13 +289,36 @@ pub struct CompletionChoice {
     pub text: String,
     pub index: u32,
     pub logprobs: Option<serde_json::Value>,
-    pub finish_reason: String,
+    /// `null` on streamed chunks until the last one
+    pub finish_reason: Option<String>,
+    /// Token IDs the generation started from, with `return_token_ids`
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub prompt_token_ids: Option<Vec<u32>>,
+    /// Token IDs of the generated text, with `return_token_ids`
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub token_ids: Option<Vec<u32>>,
+}
+
+impl CompletionRequest {
+    /// The prompt as one text; `None` when only `input_ids` was given
+    pub fn prompt_text(&self) -> Option<String> {
+        self.prompt.as_ref().map(|prompt| match prompt {
+            StringOrArray::String(s) => s.clone(),
+            StringOrArray::Array(arr) => arr.join("\n"),
+        })
+    }
 }
 
 #[derive(Debug, Clone, Serialize, Deserialize)]
 pub struct EmbeddingRequest {
     pub model: String,
     pub input: StringOrArray,
+    /// `float` (the default) or `base64` (little-endian `f32`s)
+    #[serde(default)]
+    pub encoding_format: Option<String>,
+    /// Truncate each embedding to this many dimensions and re-normalize it
+    #[serde(default)]
+    pub dimensions: Option<u32>,
     #[serde(default)]
     pub user: Option<String>,
 }
@@ -147,10 +334,17 @@ pub struct EmbeddingResponse {
 #[derive(Debug, Clone, Serialize, Deserialize)]
 pub struct EmbeddingData {
     pub object: String,
-    pub embedding: Vec<f32>,
+    pub embedding: EmbeddingVector,
     pub index: u32,
 }
 
+#[derive(Debug, Clone, Serialize, Deserialize)]
+#[serde(untagged)]
+pub enum EmbeddingVector {
+    Float(Vec<f32>),
+    Base64(String),
+}
+
 #[derive(Debug, Clone, Serialize, Deserialize)]
 pub struct EmbeddingUsage {
     pub prompt_tokens: u32,
@@ -172,6 +366,9 @@ pub struct ModelObject {
 pub struct ModelListResponse {
     pub object: String,
     pub data: Vec<ModelObject>,
+    /// Catalog revision of this listing; watch from it with
+    /// `?watch=true&since=`
+    pub revision: u64,
 }
 
 // Streaming response types
@@ -182,13 +379,18 @@ pub struct ChatCompletionChunk {
     pub object: String,
     pub created: i64,
     pub model: String,
+    pub system_fingerprint: Option<String>,
     pub choices: Vec<ChatChunkChoice>,
+    /// Set only on the final chunk, when `stream_options.include_usage` is set
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub usage: Option<Usage>,
 }
 
 #[derive(Debug, Clone, Serialize, Deserialize)]
 pub struct ChatChunkChoice {
     pub index: u32,
     pub delta: ChatDelta,
+    pub logprobs: Option<ChatLogprobs>,
     pub finish_reason: Option<String>,
 }
 
@@ -198,24 +400,72 @@ pub struct ChatDelta {
     pub role: Option<String>,
     #[serde(skip_serializing_if = "Option::is_none")]
     pub content: Option<String>,
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub tool_calls: Option<Vec<ToolCallDelta>>,
+}
+
+// Lenient request fields
+
+/// Accept `stop` as a single string as well as an array
+fn string_or_vec<'de, D>(deserializer: D) -> Result<Option<Vec<String>>, D::Error>
+where
+    D: serde::Deserializer<'de>,
+{
+    Ok(match Option::<StringOrArray>::deserialize(deserializer)? {
+        Some(StringOrArray::String(s)) => Some(vec![s]),
+        Some(StringOrArray::Array(arr)) => Some(arr),
+        None => None,
+    })
+}
+
+/// Accept message content as a string, `null` or an array of content parts,
+/// keeping the text parts
+fn message_content<'de, D>(deserializer: D) -> Result<String, D::Error>
+where
+    D: serde::Deserializer<'de>,
+{
+    #[derive(Deserialize)]
+    #[serde(untagged)]
+    enum Content {
+        Text(String),
+        Parts(Vec<ContentPart>),
+    }
+
+    #[derive(Deserialize)]
+    struct ContentPart {
+        #[serde(default)]
+        text: Option<String>,
+    }
+
+    Ok(match Option::<Content>::deserialize(deserializer)? {
+        Some(Content::Text(text)) => text,
+        Some(Content::Parts(parts)) => parts
+            .into_iter()
+            .filter_map(|part| part.text)
+            .collect::<Vec<_>>()
+            .join("\n"),
+        None => String::new(),
+    })
 }
 
 // Default values
 
+// Sampling defaults can be changed at runtime through /admin/config
+
 fn default_max_tokens() -> u32 {
-    512
+    runtime_config::sampling_defaults().max_tokens
 }
 
 fn default_temperature() -> f32 {
-    0.7
+    runtime_config::sampling_defaults().temperature
 }
 
 fn default_top_k() -> u32 {
-    40
+    runtime_config::sampling_defaults().top_k
 }
 
 fn default_top_p() -> f32 {
-    0.9
+    runtime_config::sampling_defaults().top_p
 }
 
 // API State
@@ -226,10 +476,79 @@ fn default_top_p() -> f32 {
 
 pub async fn chat_completions(
     State(state): State<Arc<ServerState>>,
-    Json(request): Json<ChatCompletionRequest>,
+    headers: HeaderMap,
+    Json(mut request): Json<ChatCompletionRequest>,
 ) -> impl IntoResponse {
-    // Convert chat messages to a single prompt
-    let prompt = format_chat_messages(&request.messages);
+    let started = std::time::Instant::now();
+
+    // Resolve a routing alias to the arm that will serve this request
+    let route = state
+        .model_router
+        .route(&request.model, request.user.as_deref())
+        .await;
+    if let Some(route) = &route {
+        request.model = route.model.clone();
+    }
+
+    // Track the request so it shows up in queue introspection and can be
+    // cancelled by ID
+    let ticket = match state.request_queue.enqueue_request(
+        &headers,
+        &request.model,
+        priority_from_headers(&headers),
+    ) {
+        Ok(ticket) => ticket
+            .with_scheduling(request.priority_class.as_deref(), &headers)
+            .with_deadline(resolve_deadline(request.timeout_ms, request.deadline)),
+        Err(response) => return response,
+    };
+    let request_id = ticket.id().to_string();
+
+    if let Some(class) = &request.priority_class {
+        if !state.request_queue.scheduler().has_class(class) {
+            return invalid_request(
+                format!("Unknown priority_class '{}'", class),
+                Some("priority_class"),
+                None,
+            );
+        }
+    }
+
+    let declared_tools = request.tools.clone().unwrap_or_default();
+    if let Err((message, param)) =
+        tools::validate_tools(&declared_tools, request.tool_choice.as_ref())
+    {
+        return invalid_request(message, Some(param), None);
+    }
+    if let Err((message, param)) = request.sampling.validate(request.n, request.stream) {
+        return invalid_request(message, Some(param), None);
+    }
+    if request.sampling.best_of.is_some() {
+        if let Err(response) = state.flags.require("best_of", &headers).await {
+            return response;
+        }
+    }
+    let offered = tools::offered_tools(&declared_tools, request.tool_choice.as_ref());
+
+    // Encrypted content is opened only now, as the prompt is built
+    let encrypted = request.encryption.is_some();
+    if let Err(e) = envelope::open_fields(
+        &state.envelope_keys,
+        request.encryption.as_ref(),
+        request
+            .messages
+            .iter_mut()
+            .map(|message| &mut message.content),
+    ) {
+        return invalid_request(e, Some("encryption"), None);
+    }
+
+    // Convert chat messages, with any tool instructions, to a single prompt
+    let prompt = format_chat_messages(&tools::prompt_messages(
+        &request.messages,
+        &offered,
+        request.tool_choice.as_ref(),
+    ));
 
     // Get or load the backend
     let backend = match get_or_load_backend(&state, &request.model).await {
@@ -250,40 +569,210 @@ pub async fn chat_completions(
         }
     };
 
+    if let Err(response) = check_logprobs(
+        &backend,
+        request.logprobs,
+        request.top_logprobs,
+        20,
+        request.stream,
+    ) {
+        return response;
+    }
+    if request.sampling.candidates() > 1 && !backend.supports_scoring() {
+        return scoring_not_supported(&backend);
+    }
+
     let stream = request.stream;
     let stop_sequences = request.stop.clone().unwrap_or_default();
-    let inference_params = InferenceParams {
+    let mut inference_params = InferenceParams {
         max_tokens: request.max_tokens,
         temperature: request.temperature,
         top_k: request.top_k,
         top_p: request.top_p,
         stream: request.stream,
         stop_sequences,
-        seed: None,
+        seed: request.seed,
+        ..Default::default()
     };
+    request.sampling.apply(&mut inference_params);
+
+    // Deterministic single-choice completions are replayed from the cache;
+    // encrypted ones are not stored in plaintext
+    let cacheable =
+        !stream && !encrypted && request.n.unwrap_or(1) <= 1 && request.sampling.candidates() == 1;
+    let cache = CachePlan::new(
+        &state,
+        &headers,
+        &request,
+        request.cache_bypass,
+        cacheable,
+        &inference_params,
+        "chat.completion",
+    );
+    if let Some(hit) = cache.lookup(&state).await {
+        drop(ticket);
+        return with_route(with_request_id(hit, &request_id), route.as_ref());
+    }
 
-    if stream {
+    // Keep what a shadow replay needs before the handlers take ownership;
+    // encrypted prompts are not replayed
+    let sample = match encrypted {
+        true => None,
+        false => state.shadow.sample(&request.model).await,
+    };
+    let mirrored = sample.map(|sample| {
+        let mirrored = MirroredRequest {
+            request_id: request_id.clone(),
+            prompt: prompt.clone(),
+            params: inference_params.clone(),
+            primary_latency: Duration::ZERO,
+        };
+        (sample, mirrored)
+    });
+
+    let response = if stream {
         // Handle streaming response
-        handle_streaming_chat(&request, backend, prompt, inference_params)
-            .await
-            .into_response()
+        handle_streaming_chat(
+            &request,
+            &offered,
+            backend,
+            prompt,
+            inference_params,
+            ticket,
+        )
+        .await
+        .into_response()
     } else {
         // Handle non-streaming response
-        handle_non_streaming_chat(&request, backend, prompt, inference_params)
-            .await
-            .into_response()
+        handle_non_streaming_chat(
+            &request,
+            &offered,
+            backend,
+            prompt,
+            inference_params,
+            ticket,
+        )
+        .await
+        .into_response()
+    };
+    let mut response = cache.finish(&state, response).await;
+
+    // Feed routed outcomes to any canary rollout watching the alias
+    if let Some(route) = &route {
+        state
+            .rollouts
+            .record_outcome(
+                route,
+                started.elapsed(),
+                response.status().is_server_error(),
+            )
+            .await;
     }
+
+    if let Some((sample, mut mirrored)) = mirrored {
+        mirrored.primary_latency = started.elapsed();
+        response = shadow::mirror(&state, sample, mirrored, response).await;
+    }
+
+    with_route(with_request_id(response, &request_id), route.as_ref())
 }
 
 pub async fn completions(
     State(state): State<Arc<ServerState>>,
-    Json(request): Json<CompletionRequest>,
+    headers: HeaderMap,
+    Json(mut request): Json<CompletionRequest>,
 ) -> impl IntoResponse {
-    // Extract prompt
-    let prompt = match &request.prompt {
-        StringOrArray::String(s) => s.clone(),
-        StringOrArray::Array(arr) => arr.join("\n"),
+    let started = std::time::Instant::now();
+
+    // Resolve a routing alias to the arm that will serve this request
+    let route = state
+        .model_router
+        .route(&request.model, request.user.as_deref())
+        .await;
+    if let Some(route) = &route {
+        request.model = route.model.clone();
+    }
+
+    // Track the request so it shows up in queue introspection and can be
+    // cancelled by ID
+    let ticket = match state.request_queue.enqueue_request(
+        &headers,
+        &request.model,
+        priority_from_headers(&headers),
+    ) {
+        Ok(ticket) => ticket
+            .with_scheduling(request.priority_class.as_deref(), &headers)
+            .with_deadline(resolve_deadline(request.timeout_ms, request.deadline)),
+        Err(response) => return response,
     };
+    let request_id = ticket.id().to_string();
+
+    if let Some(class) = &request.priority_class {
+        if !state.request_queue.scheduler().has_class(class) {
+            return invalid_request(
+                format!("Unknown priority_class '{}'", class),
+                Some("priority_class"),
+                None,
+            );
+        }
+    }
+
+    // Encrypted prompts are opened only now, as the prompt is built
+    let encrypted = request.encryption.is_some();
+    if let Err(e) = envelope::open_fields(
+        &state.envelope_keys,
+        request.encryption.as_ref(),
+        request.prompt.iter_mut().flat_map(StringOrArray::iter_mut),
+    ) {
+        return invalid_request(e, Some("encryption"), None);
+    }
+
+    // Extract prompt; token input is decoded once the backend is loaded,
+    // and an empty prompt alongside it counts as absent
+    let mut prompt = match (request.prompt_text(), &request.input_ids) {
+        (Some(prompt), Some(_)) if !prompt.is_empty() => {
+            return invalid_request(
+                "Set either prompt or input_ids, not both".to_string(),
+                Some("input_ids"),
+                None,
+            );
+        }
+        (_, Some(ids)) if ids.is_empty() => {
+            return invalid_request(
+                "input_ids must contain at least one token".to_string(),
+                Some("input_ids"),
+                None,
+            );
+        }
+        (_, Some(_)) => String::new(),
+        (Some(prompt), None) => prompt,
+        (None, None) => {
+            return invalid_request("prompt is required".to_string(), Some("prompt"), None);
+        }
+    };
+    if request.input_ids.is_some() && request.score.is_some() {
+        return invalid_request(
+            "score takes a text prompt, not input_ids".to_string(),
+            Some("input_ids"),
+            None,
+        );
+    }
+    if request.return_token_ids && request.stream {
+        return invalid_request(
+            "return_token_ids is not supported with stream".to_string(),
+            Some("return_token_ids"),
+            None,
+        );
+    }
+
+    if let Err((message, param)) = request.sampling.validate(request.n, request.stream) {
+        return invalid_request(message, Some(param), None);
+    }
+    if request.sampling.best_of.is_some() {
+        if let Err(response) = state.flags.require("best_of", &headers).await {
+            return response;
+        }
+    }
 
     // Get or load the backend
     let backend = match get_or_load_backend(&state, &request.model).await {
@@ -304,29 +793,122 @@ pub async fn completions(
         }
     };
 
+    if request.score.is_none() {
+        if let Err(response) = check_logprobs(
+            &backend,
+            request.logprobs.is_some(),
+            request.logprobs,
+            5,
+            request.stream,
+        ) {
+            return response;
+        }
+        if request.sampling.candidates() > 1 && !backend.supports_scoring() {
+            return scoring_not_supported(&backend);
+        }
+    }
+
     let stream = request.stream;
     let stop_sequences = request.stop.clone().unwrap_or_default();
-    let inference_params = InferenceParams {
+    let mut inference_params = InferenceParams {
         max_tokens: request.max_tokens,
         temperature: request.temperature,
         top_k: request.top_k,
         top_p: request.top_p,
         stream: request.stream,
         stop_sequences,
-        seed: None,
+        seed: request.seed,
+        ..Default::default()
     };
+    request.sampling.apply(&mut inference_params);
 
-    if stream {
+    // Token input bypasses the tokenizer; the decoded text stands in for
+    // the prompt wherever text is needed (echo, logprobs, shadow replays)
+    if let Some(ids) = &request.input_ids {
+        if !backend.supports_token_input() {
+            return token_input_not_supported(&backend);
+        }
+        prompt = match backend.detokenize(ids).await {
+            Ok(text) => text,
+            Err(e) => return invalid_request(e.to_string(), Some("input_ids"), None),
+        };
+        inference_params.prompt_token_ids = Some(ids.clone());
+    }
+
+    // Deterministic single-choice completions are replayed from the cache;
+    // encrypted ones are not stored in plaintext
+    let cacheable = !stream
+        && !encrypted
+        && request.score.is_none()
+        && request.n.unwrap_or(1) <= 1
+        && request.sampling.candidates() == 1;
+    let cache = CachePlan::new(
+        &state,
+        &headers,
+        &request,
+        request.cache_bypass,
+        cacheable,
+        &inference_params,
+        "text_completion",
+    );
+    if let Some(hit) = cache.lookup(&state).await {
+        drop(ticket);
+        return with_route(with_request_id(hit, &request_id), route.as_ref());
+    }
+
+    // Keep what a shadow replay needs before the handlers take ownership;
+    // scoring requests generate nothing to compare against, and encrypted
+    // prompts are not replayed
+    let mirrored = match request.score {
+        Some(_) => None,
+        None if encrypted => None,
+        None => state.shadow.sample(&request.model).await.map(|sample| {
+            let mirrored = MirroredRequest {
+                request_id: request_id.clone(),
+                prompt: prompt.clone(),
+                params: inference_params.clone(),
+                primary_latency: Duration::ZERO,
+            };
+            (sample, mirrored)
+        }),
+    };
+
+    let response = if let Some(continuation) = request.score.clone() {
+        // Score the given continuation instead of generating one
+        handle_scored_completion(&request, backend, prompt, continuation, ticket)
+            .await
+            .into_response()
+    } else if stream {
         // Handle streaming response
-        handle_streaming_completion(&request, backend, prompt, inference_params)
+        handle_streaming_completion(&request, backend, prompt, inference_params, ticket)
             .await
             .into_response()
     } else {
         // Handle non-streaming response
-        handle_non_streaming_completion(&request, backend, prompt, inference_params)
+        handle_non_streaming_completion(&request, backend, prompt, inference_params, ticket)
             .await
             .into_response()
+    };
+    let mut response = cache.finish(&state, response).await;
+
+    // Feed routed outcomes to any canary rollout watching the alias
+    if let Some(route) = &route {
+        state
+            .rollouts
+            .record_outcome(
+                route,
+                started.elapsed(),
+                response.status().is_server_error(),
+            )
+            .await;
+    }
+
+    if let Some((sample, mut mirrored)) = mirrored {
+        mirrored.primary_latency = started.elapsed();
+        response = shadow::mirror(&state, sample, mirrored, response).await;
     }
+
+    with_route(with_request_id(response, &request_id), route.as_ref())
 }
 
 pub async fn embeddings(
@@ -339,6 +921,28 @@ pub async fn embeddings(
         StringOrArray::Array(arr) => arr,
     };
 
+    let base64 = match request.encoding_format.as_deref() {
+        None | Some("float") => false,
+        Some("base64") => true,
+        Some(other) => {
+            return invalid_request(
+                format!(
+                    "encoding_format must be \"float\" or \"base64\", not \"{}\"",
+                    other
+                ),
+                Some("encoding_format"),
+                None,
+            );
+        }
+    };
+    if request.dimensions == Some(0) {
+        return invalid_request(
+            "dimensions must be at least 1".to_string(),
+            Some("dimensions"),
+            None,
+        );
+    }
+
     // Get or load the backend
     let backend = match get_or_load_backend(&state, &request.model).await {
         Ok(backend) => backend,
@@ -364,10 +968,29 @@ pub async fn embeddings(
     for (index, input) in inputs.iter().enumerate() {
         // BackendHandle already provides async methods, no need for explicit locking
         match backend.get_embeddings(input).await {
-            Ok(embedding) => {
+            Ok(mut embedding) => {
+                if let Some(dimensions) = request.dimensions {
+                    let dimensions = dimensions as usize;
+                    if dimensions > embedding.len() {
+                        return invalid_request(
+                            format!(
+                                "dimensions must be at most {} for {}",
+                                embedding.len(),
+                                request.model
+                            ),
+                            Some("dimensions"),
+                            None,
+                        );
+                    }
+                    embedding = shorten_embedding(embedding, dimensions);
+                }
                 embeddings_data.push(EmbeddingData {
                     object: "embedding".to_string(),
-                    embedding,
+                    embedding: if base64 {
+                        EmbeddingVector::Base64(encode_embedding(&embedding))
+                    } else {
+                        EmbeddingVector::Float(embedding)
+                    },
                     index: index as u32,
                 });
                 total_tokens += estimate_tokens(input);
@@ -402,47 +1025,481 @@ pub async fn embeddings(
     Json(response).into_response()
 }
 
-pub async fn list_models(State(state): State<Arc<ServerState>>) -> impl IntoResponse {
-    match state.model_manager.list_models().await {
-        Ok(models) => {
+pub(crate) fn model_object(model: crate::models::ModelInfo) -> ModelObject {
+    ModelObject {
+        id: model.name.clone(),
+        object: "model".to_string(),
+        created: model.modified.timestamp(),
+        owned_by: "inferno".to_string(),
+        permission: vec![],
+        root: model.name,
+        parent: None,
+    }
+}
+
+pub async fn list_models(
+    State(state): State<Arc<ServerState>>,
+    headers: HeaderMap,
+    Query(query): Query<ModelListQuery>,
+) -> impl IntoResponse {
+    if query.watch {
+        return model_catalog::watch_models(state, query).await;
+    }
+
+    match state.model_catalog.refresh(&state.model_manager).await {
+        Ok((models, revision)) => {
+            // A scoped key sees only the models it may use
+            let key = state.api_keys.scope(&headers);
             let model_objects: Vec<ModelObject> = models
                 .into_iter()
-                .map(|model| ModelObject {
-                    id: model.name.clone(),
-                    object: "model".to_string(),
-                    created: model.modified.timestamp(),
-                    owned_by: "inferno".to_string(),
-                    permission: vec![],
-                    root: model.name,
-                    parent: None,
+                .filter(|model| {
+                    key.as_ref()
+                        .is_none_or(|k| k.scopes.allows_model(&model.name))
                 })
+                .map(|model| scoped_model_object(model, key.as_ref()))
                 .collect();
 
             let response = ModelListResponse {
                 object: "list".to_string(),
                 data: model_objects,
+                revision,
             };
 
-            Json(response).into_response()
+            conditional::json_with_etag(&headers, &response)
         }
-        Err(e) => (
+        Err(e) => list_models_failed(e),
+    }
+}
+
+pub async fn retrieve_model(
+    State(state): State<Arc<ServerState>>,
+    Path(model_id): Path<String>,
+    headers: HeaderMap,
+) -> impl IntoResponse {
+    let key = state.api_keys.scope(&headers);
+    if let Some(key) = &key
+        && !key.scopes.allows_model(&model_id)
+    {
+        return model_not_found(&model_id);
+    }
+    match find_model(&state, &model_id).await {
+        Ok(model) => {
+            conditional::json_with_etag(&headers, &scoped_model_object(model, key.as_ref()))
+        }
+        Err(response) => response,
+    }
+}
+
+/// A model object whose `permission` describes what `key` may do with it
+fn scoped_model_object(model: crate::models::ModelInfo, key: Option<&KeyScope>) -> ModelObject {
+    let mut object = model_object(model);
+    if let Some(key) = key {
+        object.permission = vec![key.model_permission(&object.id)];
+    }
+    object
+}
+
+/// What the server knows about a model file, from `/v1/models/{id}/metadata`
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct ModelMetadataResponse {
+    pub id: String,
+    pub object: String,
+    pub format: String,
+    pub backend_type: String,
+    pub size_bytes: u64,
+    pub modified: chrono::DateTime<chrono::Utc>,
+    pub checksum: Option<String>,
+    /// Parsed from the GGUF header; absent for other formats
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub gguf: Option<GgufMetadata>,
+    /// Sorted, so the response and its ETag are stable
+    pub metadata: BTreeMap<String, String>,
+    pub verification: Option<ModelVerification>,
+}
+
+pub async fn retrieve_model_metadata(
+    State(state): State<Arc<ServerState>>,
+    Path(model_id): Path<String>,
+    headers: HeaderMap,
+) -> impl IntoResponse {
+    let model = match find_model(&state, &model_id).await {
+        Ok(model) => model,
+        Err(response) => return response,
+    };
+
+    let gguf = if model.format.eq_ignore_ascii_case("gguf") {
+        match state.model_manager.get_gguf_metadata(&model.path).await {
+            Ok(gguf) => Some(gguf),
+            Err(e) => {
+                tracing::warn!("Cannot read GGUF metadata for {}: {}", model.name, e);
+                None
+            }
+        }
+    } else {
+        None
+    };
+
+    let response = ModelMetadataResponse {
+        id: model.name,
+        object: "model.metadata".to_string(),
+        format: model.format,
+        backend_type: model.backend_type,
+        size_bytes: model.size_bytes,
+        modified: model.modified,
+        checksum: model.checksum,
+        gguf,
+        metadata: model.metadata.into_iter().collect(),
+        verification: model.verification,
+    };
+    conditional::json_with_etag(&headers, &response)
+}
+
+/// `DELETE /v1/models/:model_id` - remove a model file from the models
+/// directory (OpenAI-compatible, admin only). The model the server has loaded
+/// cannot be deleted.
+pub async fn delete_model(
+    State(state): State<Arc<ServerState>>,
+    Path(model_id): Path<String>,
+    headers: HeaderMap,
+) -> axum::response::Response {
+    if let Err(response) = authorize_admin(&headers) {
+        return response;
+    }
+    let model = match find_model(&state, &model_id).await {
+        Ok(model) => model,
+        Err(response) => return response,
+    };
+    if state.loaded_model.as_deref() == Some(model.name.as_str())
+        || state.loaded_models.get(&model.name).await.is_some()
+    {
+        return (
+            StatusCode::CONFLICT,
+            Json(serde_json::json!({
+                "error": {
+                    "message": format!("The model '{}' is loaded and cannot be deleted", model_id),
+                    "type": "invalid_request_error",
+                    "param": "model",
+                    "code": "model_in_use"
+                }
+            })),
+        )
+            .into_response();
+    }
+
+    if let Err(e) = state.model_manager.remove_model(&model.path).await {
+        return (
             StatusCode::INTERNAL_SERVER_ERROR,
             Json(serde_json::json!({
                 "error": {
-                    "message": format!("Failed to list models: {}", e),
+                    "message": format!("Failed to delete model: {}", e),
                     "type": "internal_error",
                     "param": null,
                     "code": null
                 }
             })),
         )
-            .into_response(),
+            .into_response();
     }
+    tracing::info!("Deleted model {} ({})", model.name, model.path.display());
+    state
+        .model_events
+        .publish(ModelEventType::Deleted, &model.name, "admin");
+
+    Json(serde_json::json!({
+        "id": model.name,
+        "object": "model",
+        "deleted": true
+    }))
+    .into_response()
+}
+
+/// Models loaded with `POST /v1/models/:model_id/load`, each in a backend of
+/// its own that requests for it share until it is unloaded
+#[derive(Default)]
+pub struct LoadedModels {
+    backends: tokio::sync::RwLock<HashMap<String, BackendHandle>>,
+    loading: std::sync::Mutex<HashSet<String>>,
+    /// The startup model was unloaded by an admin, so requests load it
+    /// themselves and the watchdog leaves it be
+    startup_unloaded: AtomicBool,
+}
+
+impl LoadedModels {
+    pub fn new() -> Self {
+        Self::default()
+    }
+
+    pub async fn get(&self, model: &str) -> Option<BackendHandle> {
+        self.backends.read().await.get(model).cloned()
+    }
+
+    pub fn startup_unloaded(&self) -> bool {
+        self.startup_unloaded.load(Ordering::Relaxed)
+    }
+
+    /// Mark `model` as loading, returning false if it already is
+    fn start_loading(&self, model: &str) -> bool {
+        self.loading.lock().unwrap().insert(model.to_string())
+    }
+
+    fn finish_loading(&self, model: &str) {
+        self.loading.lock().unwrap().remove(model);
+    }
+}
+
+/// Backend settings for `POST /v1/models/:model_id/load`; unset fields keep
+/// the server's
+#[derive(Debug, Default, Deserialize)]
+pub struct LoadModelRequest {
+    /// 0 runs the model on the CPU; any other value uses the GPU
+    pub gpu_layers: Option<i32>,
+    pub context_size: Option<u32>,
+    pub batch_size: Option<u32>,
+}
+
+impl LoadModelRequest {
+    fn is_empty(&self) -> bool {
+        self.gpu_layers.is_none() && self.context_size.is_none() && self.batch_size.is_none()
+    }
+}
+
+/// `POST /v1/models/:model_id/load` - load a model and keep it loaded until
+/// unloaded (admin only). Loading runs in the background: the response says
+/// `loading`, and a `loaded` or `failed` model event follows. The startup
+/// model is reloaded into the server's backend; other models get their own.
+pub async fn load_model(
+    State(state): State<Arc<ServerState>>,
+    Path(model_id): Path<String>,
+    headers: HeaderMap,
+    request: Option<Json<LoadModelRequest>>,
+) -> axum::response::Response {
+    if let Err(response) = authorize_admin(&headers) {
+        return response;
+    }
+    if state.distributed.is_some() {
+        return model_conflict(
+            "Models are loaded by the workers in distributed mode".to_string(),
+            "distributed_mode",
+        );
+    }
+    let model = match find_model(&state, &model_id).await {
+        Ok(model) => model,
+        Err(response) => return response,
+    };
+    let request = request.map(|Json(request)| request).unwrap_or_default();
+
+    let startup_backend = state
+        .backend
+        .clone()
+        .filter(|_| state.loaded_model.as_deref() == Some(model.name.as_str()));
+    let loaded = match &startup_backend {
+        Some(backend) => backend.is_loaded().await,
+        None => state.loaded_models.get(&model.name).await.is_some(),
+    };
+    if loaded {
+        return load_status(&model.name, "loaded", StatusCode::OK);
+    }
+    if startup_backend.is_some() && !request.is_empty() {
+        return model_conflict(
+            format!(
+                "'{}' is the server's startup model and loads with the server's backend settings",
+                model.name
+            ),
+            "startup_model",
+        );
+    }
+    if !state.loaded_models.start_loading(&model.name) {
+        return load_status(&model.name, "loading", StatusCode::ACCEPTED);
+    }
+
+    let name = model.name.clone();
+    let task_state = Arc::clone(&state);
+    tokio::spawn(async move {
+        let state = task_state;
+        let started = Instant::now();
+        let loaded = match startup_backend {
+            Some(backend) => match resolve_for_load(&state, &name).await {
+                Ok(model_info) => backend.load_model(&model_info).await.map(|()| {
+                    state
+                        .loaded_models
+                        .startup_unloaded
+                        .store(false, Ordering::Relaxed);
+                }),
+                Err(e) => Err(e),
+            },
+            None => {
+                let mut config = state.config.backend_config.clone();
+                if let Some(gpu_layers) = request.gpu_layers {
+                    config.gpu_enabled = gpu_layers != 0;
+                }
+                if let Some(context_size) = request.context_size {
+                    config.context_size = context_size;
+                }
+                if let Some(batch_size) = request.batch_size {
+                    config.batch_size = batch_size;
+                }
+                match load_backend_with(&state, &name, &config).await {
+                    Ok(backend) => {
+                        state
+                            .loaded_models
+                            .backends
+                            .write()
+                            .await
+                            .insert(name.clone(), backend);
+                        Ok(())
+                    }
+                    Err(e) => Err(e),
+                }
+            }
+        };
+        state.loaded_models.finish_loading(&name);
+        match loaded {
+            Ok(()) => {
+                tracing::info!("Loaded model {} in {:?}", name, started.elapsed());
+                state
+                    .model_events
+                    .publish(ModelEventType::Loaded, &name, "admin");
+            }
+            Err(e) => {
+                tracing::warn!("Loading model {} failed: {}", name, e);
+                state
+                    .model_events
+                    .publish_failure(ModelEventType::Loaded, &name, "admin", &e);
+            }
+        }
+    });
+
+    load_status(&model.name, "loading", StatusCode::ACCEPTED)
+}
+
+/// `POST /v1/models/:model_id/unload` - free a loaded model (admin only).
+/// Requests for it afterwards load it for themselves.
+pub async fn unload_model(
+    State(state): State<Arc<ServerState>>,
+    Path(model_id): Path<String>,
+    headers: HeaderMap,
+) -> axum::response::Response {
+    if let Err(response) = authorize_admin(&headers) {
+        return response;
+    }
+    if state.distributed.is_some() {
+        return model_conflict(
+            "Models are loaded by the workers in distributed mode".to_string(),
+            "distributed_mode",
+        );
+    }
+
+    let pooled = state.loaded_models.backends.write().await.remove(&model_id);
+    let backend = match pooled {
+        Some(backend) => backend,
+        None => match &state.backend {
+            Some(backend)
+                if state.loaded_model.as_deref() == Some(model_id.as_str())
+                    && backend.is_loaded().await =>
+            {
+                state
+                    .loaded_models
+                    .startup_unloaded
+                    .store(true, Ordering::Relaxed);
+                backend.clone()
+            }
+            _ => {
+                return model_conflict(
+                    format!("The model '{}' is not loaded", model_id),
+                    "model_not_loaded",
+                );
+            }
+        },
+    };
+
+    if let Err(e) = backend.unload_model().await {
+        tracing::warn!("Unloading model {} failed: {}", model_id, e);
+    }
+    tracing::info!("Unloaded model {}", model_id);
+    state
+        .model_events
+        .publish(ModelEventType::Unloaded, &model_id, "admin");
+
+    load_status(&model_id, "unloaded", StatusCode::OK)
+}
+
+fn load_status(model: &str, status: &str, code: StatusCode) -> axum::response::Response {
+    (
+        code,
+        Json(serde_json::json!({
+            "object": "model",
+            "model_id": model,
+            "status": status
+        })),
+    )
+        .into_response()
+}
+
+fn model_conflict(message: String, code: &str) -> axum::response::Response {
+    (
+        StatusCode::CONFLICT,
+        Json(serde_json::json!({
+            "error": {
+                "message": message,
+                "type": "invalid_request_error",
+                "param": "model",
+                "code": code
+            }
+        })),
+    )
+        .into_response()
+}
+
+/// The local model named `model_id`, or the error response to send
+async fn find_model(
+    state: &ServerState,
+    model_id: &str,
+) -> Result<crate::models::ModelInfo, axum::response::Response> {
+    let models = state
+        .model_manager
+        .list_models()
+        .await
+        .map_err(list_models_failed)?;
+
+    models
+        .into_iter()
+        .find(|model| model.name == model_id)
+        .ok_or_else(|| model_not_found(model_id))
+}
+
+fn model_not_found(model_id: &str) -> axum::response::Response {
+    (
+        StatusCode::NOT_FOUND,
+        Json(serde_json::json!({
+            "error": {
+                "message": format!("The model '{}' does not exist", model_id),
+                "type": "invalid_request_error",
+                "param": "model",
+                "code": "model_not_found"
+            }
+        })),
+    )
+        .into_response()
+}
+
+fn list_models_failed(e: anyhow::Error) -> axum::response::Response {
+    (
+        StatusCode::INTERNAL_SERVER_ERROR,
+        Json(serde_json::json!({
+            "error": {
+                "message": format!("Failed to list models: {}", e),
+                "type": "internal_error",
+                "param": null,
+                "code": null
+            }
+        })),
+    )
+        .into_response()
 }
 
 // Helper functions
 
-async fn get_or_load_backend(
+pub(crate) async fn get_or_load_backend(
     state: &Arc<ServerState>,
     model_name: &str,
 ) -> anyhow::Result<BackendHandle> {
@@ -456,29 +1513,71 @@ async fn get_or_load_backend(
 
     // Check if we have a loaded backend and if it matches the requested model
     if let Some(ref loaded_model) = state.loaded_model {
-        if loaded_model == model_name {
+        if loaded_model == model_name && !state.loaded_models.startup_unloaded() {
             if let Some(ref backend) = state.backend {
                 return Ok(backend.clone());
             }
         }
     }
+    if let Some(backend) = state.loaded_models.get(model_name).await {
+        return Ok(backend);
+    }
 
     // For now, if the model doesn't match, we load a new one
-    // In a more sophisticated implementation, we'd cache multiple backends
-    let model_info = state.model_manager.resolve_model(model_name).await?;
+    // In a more sophisticated implementation, we'd cache multiple backends.
+    let loaded = load_backend(state, model_name).await;
+    match &loaded {
+        Ok(_) => state
+            .model_events
+            .publish(ModelEventType::Loaded, model_name, "request"),
+        Err(e) => {
+            state
+                .model_events
+                .publish_failure(ModelEventType::Loaded, model_name, "request", e)
+        }
+    }
+    loaded
+}
+
+/// Load `model_name` into a new backend for one request. Object storage
+/// URLs are fetched into the local cache on first use.
+async fn load_backend(state: &ServerState, model_name: &str) -> anyhow::Result<BackendHandle> {
+    load_backend_with(state, model_name, &state.config.backend_config).await
+}
+
+async fn load_backend_with(
+    state: &ServerState,
+    model_name: &str,
+    config: &crate::backends::BackendConfig,
+) -> anyhow::Result<BackendHandle> {
+    let model_info = resolve_for_load(state, model_name).await?;
     let backend_type = BackendType::from_model_path(&model_info.path).ok_or_else(|| {
         anyhow::anyhow!(
             "No suitable backend found for model: {}",
             model_info.path.display()
         )
     })?;
-    let backend_handle = BackendHandle::new_shared(backend_type, &state.config.backend_config)?;
+    let backend_handle = BackendHandle::new_shared(backend_type, config)?;
     backend_handle.load_model(&model_info).await?;
 
     Ok(backend_handle)
 }
 
-fn format_chat_messages(messages: &[ChatMessage]) -> String {
+/// Resolve `model_name` and verify it under the server's policy
+async fn resolve_for_load(
+    state: &ServerState,
+    model_name: &str,
+) -> anyhow::Result<crate::models::ModelInfo> {
+    let mut model_info = model_stores::resolve_model(state, model_name).await?;
+    let policy = VerificationPolicy::from_config(state.config.model_security.as_ref())?;
+    state
+        .model_manager
+        .verify_for_load(&mut model_info, &policy)
+        .await?;
+    Ok(model_info)
+}
+
+pub(crate) fn format_chat_messages(messages: &[ChatMessage]) -> String {
     messages
         .iter()
         .map(|msg| format!("{}: {}", msg.role, msg.content))
@@ -486,39 +1585,267 @@ fn format_chat_messages(messages: &[ChatMessage]) -> String {
         .join("\n")
 }
 
-fn estimate_tokens(text: &str) -> u32 {
+pub(crate) fn estimate_tokens(text: &str) -> u32 {
     (text.len() as f32 / 4.0).ceil() as u32
 }
 
+fn usage_for(prompt: &str, output: &str) -> Usage {
+    Usage {
+        prompt_tokens: estimate_tokens(prompt),
+        completion_tokens: estimate_tokens(output),
+        total_tokens: estimate_tokens(prompt) + estimate_tokens(output),
+    }
+}
+
+/// Identifies the server build, backend and model that produced a response,
+/// so clients relying on `seed` can tell when determinism may have changed
+pub(crate) fn system_fingerprint(model: &str, backend_type: BackendType) -> String {
+    use sha2::{Digest, Sha256};
+
+    let digest = Sha256::digest(format!(
+        "{}:{}:{}",
+        env!("CARGO_PKG_VERSION"),
+        backend_type,
+        model
+    ));
+    format!("fp_{}", &hex::encode(digest)[..12])
+}
+
+/// Check a `logprobs` request against what the backend and mode can serve.
+/// Log-probabilities come from re-scoring the generated text, so they need a
+/// scoring backend and are not available while streaming.
+fn check_logprobs(
+    backend: &BackendHandle,
+    requested: bool,
+    top_logprobs: Option<u32>,
+    max_top_logprobs: u32,
+    stream: bool,
+) -> Result<(), axum::response::Response> {
+    let top_logprobs = top_logprobs.unwrap_or(0);
+    if top_logprobs > max_top_logprobs {
+        return Err(invalid_request(
+            format!("top_logprobs must be between 0 and {}", max_top_logprobs),
+            Some("top_logprobs"),
+            None,
+        ));
+    }
+    if top_logprobs > 0 && !requested {
+        return Err(invalid_request(
+            "top_logprobs requires logprobs to be set".to_string(),
+            Some("top_logprobs"),
+            None,
+        ));
+    }
+    if !requested {
+        return Ok(());
+    }
+    if stream {
+        return Err(invalid_request(
+            "logprobs are not available for streamed responses".to_string(),
+            Some("logprobs"),
+            None,
+        ));
+    }
+    if !backend.supports_scoring() {
+        return Err(scoring_not_supported(backend));
+    }
+    Ok(())
+}
+
+fn chat_logprobs(scored: &ScoredText, top_logprobs: u32) -> ChatLogprobs {
+    ChatLogprobs {
+        content: scored
+            .tokens
+            .iter()
+            .map(|t| ChatTokenLogprob {
+                token: t.token.clone(),
+                logprob: t.logprob,
+                bytes: t.token.as_bytes().to_vec(),
+                top_logprobs: if top_logprobs > 0 {
+                    vec![TopLogprob {
+                        token: t.token.clone(),
+                        logprob: t.logprob,
+                        bytes: t.token.as_bytes().to_vec(),
+                    }]
+                } else {
+                    Vec::new()
+                },
+            })
+            .collect(),
+    }
+}
+
+/// Legacy completions `logprobs` layout; `text_offset` counts from the start
+/// of the prompt
+fn completion_logprobs(
+    scored: &ScoredText,
+    prompt_len: usize,
+    top_logprobs: u32,
+) -> serde_json::Value {
+    let mut text_offset = Vec::with_capacity(scored.tokens.len());
+    let mut offset = prompt_len;
+    for token in &scored.tokens {
+        text_offset.push(offset);
+        offset += token.token.len();
+    }
+    let top: Vec<serde_json::Value> = scored
+        .tokens
+        .iter()
+        .map(|t| {
+            let mut alternatives = serde_json::Map::new();
+            alternatives.insert(t.token.clone(), serde_json::json!(t.logprob));
+            serde_json::Value::Object(alternatives)
+        })
+        .collect();
+    serde_json::json!({
+        "tokens": scored.tokens.iter().map(|t| &t.token).collect::<Vec<_>>(),
+        "token_logprobs": scored.tokens.iter().map(|t| t.logprob).collect::<Vec<_>>(),
+        "top_logprobs": if top_logprobs > 0 { serde_json::Value::Array(top) } else { serde_json::Value::Null },
+        "text_offset": text_offset,
+    })
+}
+
+/// Truncate an embedding to `dimensions` and re-normalize it to unit length
+fn shorten_embedding(mut embedding: Vec<f32>, dimensions: usize) -> Vec<f32> {
+    embedding.truncate(dimensions);
+    let norm = embedding.iter().map(|v| v * v).sum::<f32>().sqrt();
+    if norm > 0.0 {
+        embedding.iter_mut().for_each(|v| *v /= norm);
+    }
+    embedding
+}
+
+/// Embedding as base64 of its little-endian `f32`s
+fn encode_embedding(embedding: &[f32]) -> String {
+    use base64::{Engine as _, engine::general_purpose};
+
+    let bytes: Vec<u8> = embedding.iter().flat_map(|v| v.to_le_bytes()).collect();
+    general_purpose::STANDARD.encode(bytes)
+}
+
+fn token_input_not_supported(backend: &BackendHandle) -> axum::response::Response {
+    (
+        StatusCode::BAD_REQUEST,
+        Json(serde_json::json!({
+            "error": {
+                "message": format!(
+                    "The {} backend cannot generate from input_ids",
+                    backend.get_backend_type()
+                ),
+                "type": "invalid_request_error",
+                "param": "input_ids",
+                "code": "token_input_not_supported"
+            }
+        })),
+    )
+        .into_response()
+}
+
+/// Token IDs of generated text. The text is re-tokenized, less the prefix
+/// the tokenizer puts on every text (such as BOS).
+async fn completion_token_ids(backend: &BackendHandle, text: &str) -> anyhow::Result<Vec<u32>> {
+    let prefix = backend.tokenize("").await?;
+    let mut ids = backend.tokenize(text).await?;
+    if ids.starts_with(&prefix) {
+        ids.drain(..prefix.len());
+    }
+    Ok(ids)
+}
+
+fn scoring_failed(e: anyhow::Error) -> axum::response::Response {
+    (
+        StatusCode::INTERNAL_SERVER_ERROR,
+        Json(serde_json::json!({
+            "error": {
+                "message": format!("Scoring failed: {}", e),
+                "type": "internal_error",
+                "param": null,
+                "code": null
+            }
+        })),
+    )
+        .into_response()
+}
+
 async fn handle_non_streaming_chat(
     request: &ChatCompletionRequest,
+    offered: &[&ChatTool],
     backend: BackendHandle,
     prompt: String,
     params: InferenceParams,
+    ticket: QueueTicket,
 ) -> impl IntoResponse {
     // BackendHandle already provides async methods, no need for explicit locking
+    ticket.start().await;
+
+    // Generate through the token stream so a cancel or timeout stops the
+    // backend, sampling several candidates when best_of asks for them
+    let generation = if request.sampling.candidates() > 1 {
+        sampling::generate_best_of(
+            &backend,
+            &prompt,
+            &params,
+            &request.sampling,
+            ticket.cancel_signal(),
+            ticket.deadline(),
+        )
+        .await
+    } else {
+        generate_cancellable(
+            &backend,
+            &prompt,
+            &params,
+            ticket.cancel_signal(),
+            ticket.deadline(),
+        )
+        .await
+    };
+
+    match generation {
+        Ok(generation) => {
+            let output = generation.text;
+            let logprobs = if request.logprobs {
+                match backend.score(&prompt, &output).await {
+                    Ok(scored) => Some(chat_logprobs(&scored, request.top_logprobs.unwrap_or(0))),
+                    Err(e) => return scoring_failed(e),
+                }
+            } else {
+                None
+            };
+
+            let parallel = request.parallel_tool_calls.unwrap_or(true);
+            let (content, tool_calls, finish_reason) =
+                match tools::parse_tool_calls(&output, offered, parallel) {
+                    Some((content, calls)) => (content, Some(calls), "tool_calls".to_string()),
+                    None => (
+                        output.clone(),
+                        None,
+                        generation.finish_reason.as_str().to_string(),
+                    ),
+                };
 
-    match backend.infer(&prompt, &params).await {
-        Ok(output) => {
             let response = ChatCompletionResponse {
                 id: format!("chatcmpl-{}", Uuid::new_v4()),
                 object: "chat.completion".to_string(),
                 created: chrono::Utc::now().timestamp(),
                 model: request.model.clone(),
+                system_fingerprint: Some(system_fingerprint(
+                    &request.model,
+                    backend.get_backend_type(),
+                )),
                 choices: vec![ChatChoice {
                     index: 0,
                     message: ChatMessage {
                         role: "assistant".to_string(),
-                        content: output.clone(),
+                        content,
                         name: None,
+                        tool_calls,
+                        tool_call_id: None,
                     },
-                    finish_reason: "stop".to_string(),
+                    logprobs,
+                    finish_reason,
                 }],
-                usage: Usage {
-                    prompt_tokens: estimate_tokens(&prompt),
-                    completion_tokens: estimate_tokens(&output),
-                    total_tokens: estimate_tokens(&prompt) + estimate_tokens(&output),
-                },
+                usage: usage_for(&prompt, &output),
             };
 
             Json(response).into_response()
@@ -540,18 +1867,28 @@ async fn handle_non_streaming_chat(
 
 async fn handle_streaming_chat(
     request: &ChatCompletionRequest,
+    offered: &[&ChatTool],
     backend: BackendHandle,
     prompt: String,
     params: InferenceParams,
+    ticket: QueueTicket,
 ) -> impl IntoResponse {
     use axum::response::sse::{Event, Sse};
     use futures::stream::StreamExt;
 
     let model = request.model.clone();
     let request_id = format!("chatcmpl-{}", Uuid::new_v4());
+    let fingerprint = Some(system_fingerprint(&model, backend.get_backend_type()));
+    let include_usage = request
+        .stream_options
+        .as_ref()
+        .is_some_and(|options| options.include_usage);
+    let tools: Vec<ChatTool> = offered.iter().map(|tool| (*tool).clone()).collect();
+    let parallel_tool_calls = request.parallel_tool_calls.unwrap_or(true);
 
     let stream = async_stream::stream! {
         // BackendHandle already provides async methods, no need for explicit locking
+        ticket.start().await;
 
         match backend.infer_stream(&prompt, &params).await {
             Ok(mut token_stream) => {
@@ -561,35 +1898,67 @@ async fn handle_streaming_chat(
                     object: "chat.completion.chunk".to_string(),
                     created: chrono::Utc::now().timestamp(),
                     model: model.clone(),
+                    system_fingerprint: fingerprint.clone(),
                     choices: vec![ChatChunkChoice {
                         index: 0,
                         delta: ChatDelta {
                             role: Some("assistant".to_string()),
                             content: None,
+                            tool_calls: None,
                         },
+                        logprobs: None,
                         finish_reason: None,
                     }],
+                    usage: None,
                 };
 
                 yield Ok::<axum::response::sse::Event, axum::Error>(Event::default().data(serde_json::to_string(&initial_chunk).unwrap()));
 
-                // Stream tokens
-                while let Some(token_result) = token_stream.next().await {
+                // Stream tokens until the backend finishes or the request is
+                // cancelled or times out; dropping the token stream stops generation
+                let mut finish_reason = FinishReason::Stop;
+                let mut output = String::new();
+                let mut tool_stream = ToolCallStream::default();
+                loop {
+                    let next = next_token(&mut token_stream, ticket.cancel_signal(), ticket.deadline()).await;
+                    let token_result = match next {
+                        Ok(Some(token_result)) => token_result,
+                        Ok(None) => break,
+                        Err(reason) => {
+                            finish_reason = reason;
+                            break;
+                        }
+                    };
+
                     match token_result {
                         Ok(token) => {
+                            output.push_str(&token);
+                            // With tools offered, hold back possible call markup
+                            let token = if tools.is_empty() {
+                                token
+                            } else {
+                                match tool_stream.push(&token) {
+                                    Some(text) => text,
+                                    None => continue,
+                                }
+                            };
                             let chunk = ChatCompletionChunk {
                                 id: request_id.clone(),
                                 object: "chat.completion.chunk".to_string(),
                                 created: chrono::Utc::now().timestamp(),
                                 model: model.clone(),
+                                system_fingerprint: fingerprint.clone(),
                                 choices: vec![ChatChunkChoice {
                                     index: 0,
                                     delta: ChatDelta {
                                         role: None,
                                         content: Some(token),
+                                        tool_calls: None,
                                     },
+                                    logprobs: None,
                                     finish_reason: None,
                                 }],
+                                usage: None,
                             };
 
                             yield Ok(Event::default().data(serde_json::to_string(&chunk).unwrap()));
@@ -601,23 +1970,77 @@ async fn handle_streaming_chat(
                     }
                 }
 
+                let mut finish_reason_name = finish_reason.as_str().to_string();
+                if !tools.is_empty() {
+                    let offered: Vec<&ChatTool> = tools.iter().collect();
+                    let (rest, calls) = tool_stream.finish(&offered, parallel_tool_calls);
+                    let tool_calls = (!calls.is_empty()).then(|| {
+                        calls
+                            .into_iter()
+                            .enumerate()
+                            .map(|(index, call)| ToolCallDelta::new(index, call))
+                            .collect::<Vec<_>>()
+                    });
+                    if tool_calls.is_some() {
+                        finish_reason_name = "tool_calls".to_string();
+                    }
+                    if rest.is_some() || tool_calls.is_some() {
+                        let chunk = ChatCompletionChunk {
+                            id: request_id.clone(),
+                            object: "chat.completion.chunk".to_string(),
+                            created: chrono::Utc::now().timestamp(),
+                            model: model.clone(),
+                            system_fingerprint: fingerprint.clone(),
+                            choices: vec![ChatChunkChoice {
+                                index: 0,
+                                delta: ChatDelta {
+                                    role: None,
+                                    content: rest,
+                                    tool_calls,
+                                },
+                                logprobs: None,
+                                finish_reason: None,
+                            }],
+                            usage: None,
+                        };
+                        yield Ok(Event::default().data(serde_json::to_string(&chunk).unwrap()));
+                    }
+                }
+
                 // Send final chunk
                 let final_chunk = ChatCompletionChunk {
                     id: request_id.clone(),
                     object: "chat.completion.chunk".to_string(),
                     created: chrono::Utc::now().timestamp(),
                     model: model.clone(),
+                    system_fingerprint: fingerprint.clone(),
                     choices: vec![ChatChunkChoice {
                         index: 0,
                         delta: ChatDelta {
                             role: None,
                             content: None,
+                            tool_calls: None,
                         },
-                        finish_reason: Some("stop".to_string()),
+                        logprobs: None,
+                        finish_reason: Some(finish_reason_name),
                     }],
+                    usage: None,
                 };
 
                 yield Ok(Event::default().data(serde_json::to_string(&final_chunk).unwrap()));
+
+                if include_usage {
+                    let usage_chunk = ChatCompletionChunk {
+                        id: request_id.clone(),
+                        object: "chat.completion.chunk".to_string(),
+                        created: chrono::Utc::now().timestamp(),
+                        model: model.clone(),
+                        system_fingerprint: fingerprint.clone(),
+                        choices: Vec::new(),
+                        usage: Some(usage_for(&prompt, &output)),
+                    };
+                    yield Ok(Event::default().data(serde_json::to_string(&usage_chunk).unwrap()));
+                }
                 yield Ok(Event::default().data("[DONE]"));
             }
             Err(e) => {
@@ -642,27 +2065,98 @@ async fn handle_non_streaming_completion(
     backend: BackendHandle,
     prompt: String,
     params: InferenceParams,
+    ticket: QueueTicket,
 ) -> impl IntoResponse {
     // BackendHandle already provides async methods, no need for explicit locking
+    ticket.start().await;
+
+    // Generate through the token stream so a cancel or timeout stops the
+    // backend, sampling several candidates when best_of asks for them
+    let generation = if request.sampling.candidates() > 1 {
+        sampling::generate_best_of(
+            &backend,
+            &prompt,
+            &params,
+            &request.sampling,
+            ticket.cancel_signal(),
+            ticket.deadline(),
+        )
+        .await
+    } else {
+        generate_cancellable(
+            &backend,
+            &prompt,
+            &params,
+            ticket.cancel_signal(),
+            ticket.deadline(),
+        )
+        .await
+    };
+
+    match generation {
+        Ok(generation) => {
+            let output = generation.text;
+            let logprobs = match request.logprobs {
+                Some(top_logprobs) => match backend.score(&prompt, &output).await {
+                    Ok(scored) => {
+                        let prompt_len = if request.echo { prompt.len() } else { 0 };
+                        Some(completion_logprobs(&scored, prompt_len, top_logprobs))
+                    }
+                    Err(e) => return scoring_failed(e),
+                },
+                None => None,
+            };
+            let text = if request.echo {
+                format!("{}{}", prompt, output)
+            } else {
+                output.clone()
+            };
+
+            let mut usage = usage_for(&prompt, &output);
+            let (prompt_token_ids, token_ids) = if request.return_token_ids {
+                let prompt_ids = match &params.prompt_token_ids {
+                    Some(ids) => Ok(ids.clone()),
+                    None => backend.tokenize(&prompt).await,
+                };
+                match (prompt_ids, completion_token_ids(&backend, &output).await) {
+                    (Ok(prompt_ids), Ok(ids)) => (Some(prompt_ids), Some(ids)),
+                    (Err(e), _) | (_, Err(e)) => {
+                        return invalid_request(e.to_string(), Some("return_token_ids"), None);
+                    }
+                }
+            } else {
+                (None, None)
+            };
+            // Counted token IDs replace the estimates where there are any
+            if let Some(ids) = prompt_token_ids
+                .as_ref()
+                .or(params.prompt_token_ids.as_ref())
+            {
+                usage.prompt_tokens = ids.len() as u32;
+            }
+            if let Some(ids) = &token_ids {
+                usage.completion_tokens = ids.len() as u32;
+            }
+            usage.total_tokens = usage.prompt_tokens + usage.completion_tokens;
 
-    match backend.infer(&prompt, &params).await {
-        Ok(output) => {
             let response = CompletionResponse {
                 id: format!("cmpl-{}", Uuid::new_v4()),
                 object: "text_completion".to_string(),
                 created: chrono::Utc::now().timestamp(),
                 model: request.model.clone(),
+                system_fingerprint: Some(system_fingerprint(
+                    &request.model,
+                    backend.get_backend_type(),
+                )),
                 choices: vec![CompletionChoice {
-                    text: output.clone(),
+                    text,
                     index: 0,
-                    logprobs: None,
-                    finish_reason: "stop".to_string(),
+                    logprobs,
+                    finish_reason: Some(generation.finish_reason.as_str().to_string()),
+                    prompt_token_ids,
+                    token_ids,
                 }],
-                usage: Usage {
-                    prompt_tokens: estimate_tokens(&prompt),
-                    completion_tokens: estimate_tokens(&output),
-                    total_tokens: estimate_tokens(&prompt) + estimate_tokens(&output),
-                },
+                usage: Some(usage),
             };
 
             Json(response).into_response()
@@ -682,42 +2176,133 @@ async fn handle_non_streaming_completion(
     }
 }
 
+async fn handle_scored_completion(
+    request: &CompletionRequest,
+    backend: BackendHandle,
+    prompt: String,
+    continuation: String,
+    ticket: QueueTicket,
+) -> axum::response::Response {
+    if !backend.supports_scoring() {
+        return scoring_not_supported(&backend);
+    }
+
+    ticket.start().await;
+
+    match backend.score(&prompt, &continuation).await {
+        Ok(scored) => {
+            // Legacy OpenAI logprobs layout, plus the sequence totals
+            let mut text_offset = Vec::with_capacity(scored.tokens.len());
+            let mut offset = prompt.len();
+            for token in &scored.tokens {
+                text_offset.push(offset);
+                offset += token.token.len();
+            }
+            let logprobs = serde_json::json!({
+                "tokens": scored.tokens.iter().map(|t| &t.token).collect::<Vec<_>>(),
+                "token_logprobs": scored.tokens.iter().map(|t| t.logprob).collect::<Vec<_>>(),
+                "text_offset": text_offset,
+                "log_likelihood": scored.log_likelihood(),
+                "perplexity": scored.perplexity(),
+            });
+
+            let completion_tokens = scored.tokens.len() as u32;
+            let response = CompletionResponse {
+                id: format!("cmpl-{}", Uuid::new_v4()),
+                object: "text_completion".to_string(),
+                created: chrono::Utc::now().timestamp(),
+                model: request.model.clone(),
+                system_fingerprint: Some(system_fingerprint(
+                    &request.model,
+                    backend.get_backend_type(),
+                )),
+                choices: vec![CompletionChoice {
+                    text: continuation,
+                    index: 0,
+                    logprobs: Some(logprobs),
+                    finish_reason: Some("scored".to_string()),
+                    prompt_token_ids: None,
+                    token_ids: None,
+                }],
+                usage: Some(Usage {
+                    prompt_tokens: scored.context_tokens,
+                    completion_tokens,
+                    total_tokens: scored.context_tokens + completion_tokens,
+                }),
+            };
+
+            Json(response).into_response()
+        }
+        Err(e) => (
+            StatusCode::INTERNAL_SERVER_ERROR,
+            Json(serde_json::json!({
+                "error": {
+                    "message": format!("Scoring failed: {}", e),
+                    "type": "internal_error",
+                    "param": null,
+                    "code": null
+                }
+            })),
+        )
+            .into_response(),
+    }
+}
+
 async fn handle_streaming_completion(
     request: &CompletionRequest,
     backend: BackendHandle,
     prompt: String,
     params: InferenceParams,
+    ticket: QueueTicket,
 ) -> impl IntoResponse {
     use axum::response::sse::{Event, Sse};
     use futures::stream::StreamExt;
 
     let model = request.model.clone();
     let request_id = format!("cmpl-{}", Uuid::new_v4());
+    let fingerprint = Some(system_fingerprint(&model, backend.get_backend_type()));
+    let include_usage = request
+        .stream_options
+        .as_ref()
+        .is_some_and(|options| options.include_usage);
 
     let stream = async_stream::stream! {
         // BackendHandle already provides async methods, no need for explicit locking
+        ticket.start().await;
 
         match backend.infer_stream(&prompt, &params).await {
             Ok(mut token_stream) => {
-                while let Some(token_result) = token_stream.next().await {
+                let mut finish_reason = FinishReason::Stop;
+                let mut output = String::new();
+                loop {
+                    let next = next_token(&mut token_stream, ticket.cancel_signal(), ticket.deadline()).await;
+                    let token_result = match next {
+                        Ok(Some(token_result)) => token_result,
+                        Ok(None) => break,
+                        Err(reason) => {
+                            finish_reason = reason;
+                            break;
+                        }
+                    };
+
                     match token_result {
                         Ok(token) => {
+                            output.push_str(&token);
                             let response = CompletionResponse {
                                 id: request_id.clone(),
                                 object: "text_completion".to_string(),
                                 created: chrono::Utc::now().timestamp(),
                                 model: model.clone(),
+                                system_fingerprint: fingerprint.clone(),
                                 choices: vec![CompletionChoice {
                                     text: token,
                                     index: 0,
                                     logprobs: None,
-                                    finish_reason: "".to_string(),
+                                    finish_reason: None,
+                                    prompt_token_ids: None,
+                                    token_ids: None,
                                 }],
-                                usage: Usage {
-                                    prompt_tokens: 0,
-                                    completion_tokens: 1,
-                                    total_tokens: 1,
-                                },
+                                usage: None,
                             };
 
                             yield Ok::<axum::response::sse::Event, axum::Error>(Event::default().data(serde_json::to_string(&response).unwrap()));
@@ -729,6 +2314,37 @@ async fn handle_streaming_completion(
                     }
                 }
 
+                let response = CompletionResponse {
+                    id: request_id.clone(),
+                    object: "text_completion".to_string(),
+                    created: chrono::Utc::now().timestamp(),
+                    model: model.clone(),
+                    system_fingerprint: fingerprint.clone(),
+                    choices: vec![CompletionChoice {
+                        text: String::new(),
+                        index: 0,
+                        logprobs: None,
+                        finish_reason: Some(finish_reason.as_str().to_string()),
+                        prompt_token_ids: None,
+                        token_ids: None,
+                    }],
+                    usage: None,
+                };
+                yield Ok(Event::default().data(serde_json::to_string(&response).unwrap()));
+
+                if include_usage {
+                    let response = CompletionResponse {
+                        id: request_id.clone(),
+                        object: "text_completion".to_string(),
+                        created: chrono::Utc::now().timestamp(),
+                        model: model.clone(),
+                        system_fingerprint: fingerprint.clone(),
+                        choices: Vec::new(),
+                        usage: Some(usage_for(&prompt, &output)),
+                    };
+                    yield Ok(Event::default().data(serde_json::to_string(&response).unwrap()));
+                }
+
                 yield Ok(Event::default().data("[DONE]"));
             }
             Err(e) => {
@@ -747,3 +2363,74 @@ async fn handle_streaming_completion(
         .keep_alive(axum::response::sse::KeepAlive::default())
         .into_response()
 }
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn accepts_sdk_request_shapes() {
+        let request: ChatCompletionRequest = serde_json::from_value(serde_json::json!({
+            "model": "llama",
+            "messages": [
+                {"role": "system", "content": "Be brief."},
+                {"role": "user", "content": [
+                    {"type": "text", "text": "Hello"},
+                    {"type": "text", "text": "there"}
+                ]},
+                {"role": "assistant", "content": null}
+            ],
+            "max_completion_tokens": 64,
+            "stop": "\n",
+            "stream": true,
+            "stream_options": {"include_usage": true},
+            "logprobs": true,
+            "top_logprobs": 2
+        }))
+        .unwrap();
+
+        assert_eq!(request.max_tokens, 64);
+        assert_eq!(request.stop, Some(vec!["\n".to_string()]));
+        assert_eq!(request.messages[1].content, "Hello\nthere");
+        assert_eq!(request.messages[2].content, "");
+        assert!(request.stream_options.unwrap().include_usage);
+        assert_eq!(request.top_logprobs, Some(2));
+    }
+
+    #[test]
+    fn accepts_token_input_without_prompt() {
+        let request: CompletionRequest = serde_json::from_value(serde_json::json!({
+            "model": "llama",
+            "input_ids": [1, 15043, 29892],
+            "return_token_ids": true
+        }))
+        .unwrap();
+        assert_eq!(request.prompt_text(), None);
+        assert_eq!(request.input_ids, Some(vec![1, 15043, 29892]));
+        assert!(request.return_token_ids);
+
+        let request: CompletionRequest = serde_json::from_value(serde_json::json!({
+            "model": "llama",
+            "prompt": ["a", "b"]
+        }))
+        .unwrap();
+        assert_eq!(request.prompt_text().as_deref(), Some("a\nb"));
+        assert!(!request.return_token_ids);
+    }
+
+    #[test]
+    fn shortens_embeddings_to_unit_length() {
+        let embedding = shorten_embedding(vec![3.0, 4.0, 12.0], 2);
+        assert_eq!(embedding, vec![0.6, 0.8]);
+    }
+
+    #[test]
+    fn encodes_embeddings_as_little_endian_floats() {
+        use base64::{Engine as _, engine::general_purpose};
+
+        let encoded = encode_embedding(&[1.0, -2.5]);
+        let bytes = general_purpose::STANDARD.decode(encoded).unwrap();
+        assert_eq!(bytes.len(), 8);
+        assert_eq!(f32::from_le_bytes(bytes[4..8].try_into().unwrap()), -2.5);
+    }
+}
@@ -0,0 +1,550 @@
+//! Admin Operations
+//!
+//! Rollout tooling takes a server out of service in steps. Maintenance mode
+//! turns away new work with 503 while reads, cancellations and the admin
+//! endpoints keep answering, and `/health` reports the mode so load
+//! balancers stop routing to the server. A drain does the same, waits for
+//! in-flight requests to finish and, if asked, then shuts the server down
+//! gracefully. A worker restart reloads the backends without restarting the
+//! process, once the server is out of service.
+//!
+//! While draining, every response also carries `Connection: close` and
+//! `X-Inferno-Draining: true`, and WebSocket clients get a `goaway` message.
+//! Cooperating clients finish what they have in flight and send new requests
+//! to another instance, so a rolling restart drops no requests.
+//!
+//! The mode is held in memory; a restarted server always comes up serving.
+
+use crate::{
+    api::{admin::authorize_admin, invalid_request},
+    cli::serve::ServerState,
+};
+use axum::{
+    Json,
+    extract::{Request, State},
+    http::{HeaderMap, HeaderValue, Method, StatusCode, header},
+    middleware::Next,
+    response::{IntoResponse, Response},
+};
+use chrono::{DateTime, Utc};
+use serde::{Deserialize, Serialize};
+use serde_json::json;
+use std::{
+    sync::{
+        Arc, Mutex,
+        atomic::{AtomicUsize, Ordering},
+    },
+    time::Duration,
+};
+use tokio::{
+    sync::{Notify, watch},
+    time::Instant,
+};
+use tracing::{info, warn};
+
+/// Drain wait used when the request gives none
+const DEFAULT_DRAIN_TIMEOUT_SECONDS: u64 = 30;
+
+/// Longest a drain request may wait for in-flight requests
+const MAX_DRAIN_TIMEOUT_SECONDS: u64 = 3600;
+
+/// How often a drain checks whether in-flight requests have finished
+const DRAIN_POLL_INTERVAL: Duration = Duration::from_millis(250);
+
+/// Response header telling clients the server is draining
+pub const DRAINING_HEADER: &str = "x-inferno-draining";
+
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Serialize, Deserialize)]
+#[serde(rename_all = "snake_case")]
+pub enum ServerMode {
+    /// Accepting new work
+    Serving,
+    /// New work refused until maintenance is turned off
+    Maintenance,
+    /// New work refused while in-flight requests finish
+    Draining,
+}
+
+/// Mode and operation history reported by `/admin/status`
+#[derive(Debug, Clone, Serialize)]
+pub struct OperationsStatus {
+    pub mode: ServerMode,
+    /// When the server entered its current mode
+    pub since: DateTime<Utc>,
+    pub reason: Option<String>,
+    /// Set once a drain has triggered shutdown
+    pub shutting_down: bool,
+    pub worker_restarts: u64,
+    pub last_worker_restart: Option<DateTime<Utc>>,
+}
+
+/// Server mode, shared by the admin endpoints and the request guard
+#[derive(Debug)]
+pub struct Operations {
+    status: Mutex<OperationsStatus>,
+    mode: watch::Sender<ServerMode>,
+    shutdown: Notify,
+    websocket_streams: AtomicUsize,
+}
+
+impl Operations {
+    pub fn new() -> Self {
+        Self {
+            status: Mutex::new(OperationsStatus {
+                mode: ServerMode::Serving,
+                since: Utc::now(),
+                reason: None,
+                shutting_down: false,
+                worker_restarts: 0,
+                last_worker_restart: None,
+            }),
+            mode: watch::Sender::new(ServerMode::Serving),
+            shutdown: Notify::new(),
+            websocket_streams: AtomicUsize::new(0),
+        }
+    }
+
+    pub fn status(&self) -> OperationsStatus {
+        self.status.lock().unwrap().clone()
+    }
+
+    pub fn mode(&self) -> ServerMode {
+        self.status.lock().unwrap().mode
+    }
+
+    /// Switch modes; fails once shutdown has been triggered, since the
+    /// server is on its way out regardless
+    pub fn set_mode(&self, mode: ServerMode, reason: Option<String>) -> Result<(), String> {
+        let mut status = self.status.lock().unwrap();
+        if status.shutting_down {
+            return Err("The server is shutting down".to_string());
+        }
+        if status.mode != mode {
+            status.mode = mode;
+            status.since = Utc::now();
+            self.mode.send_replace(mode);
+        }
+        status.reason = reason;
+        Ok(())
+    }
+
+    /// Watch for mode changes, for connections that outlive a request
+    pub fn subscribe(&self) -> watch::Receiver<ServerMode> {
+        self.mode.subscribe()
+    }
+
+    /// Count a WebSocket stream as in flight until the guard is dropped, so
+    /// drains wait for it
+    pub fn track_stream(self: &Arc<Self>) -> StreamGuard {
+        self.websocket_streams.fetch_add(1, Ordering::SeqCst);
+        StreamGuard(Arc::clone(self))
+    }
+
+    /// Ask the server to shut down gracefully
+    pub fn request_shutdown(&self) {
+        self.status.lock().unwrap().shutting_down = true;
+        // notify_one keeps a permit if the server is not yet waiting
+        self.shutdown.notify_one();
+    }
+
+    /// Resolves once a drain has asked the server to shut down
+    pub async fn shutdown_requested(&self) {
+        self.shutdown.notified().await;
+    }
+
+    fn record_worker_restart(&self) {
+        let mut status = self.status.lock().unwrap();
+        status.worker_restarts += 1;
+        status.last_worker_restart = Some(Utc::now());
+    }
+}
+
+impl Default for Operations {
+    fn default() -> Self {
+        Self::new()
+    }
+}
+
+/// Marks a WebSocket stream in flight; see [`Operations::track_stream`]
+pub struct StreamGuard(Arc<Operations>);
+
+impl Drop for StreamGuard {
+    fn drop(&mut self) {
+        self.0.websocket_streams.fetch_sub(1, Ordering::SeqCst);
+    }
+}
+
+/// Generation requests in flight: those the queue tracks plus WebSocket
+/// streams
+fn in_flight(state: &ServerState) -> usize {
+    state.request_queue.len() + state.operations.websocket_streams.load(Ordering::SeqCst)
+}
+
+/// Whether a request starts new work: any POST outside `/admin` and
+/// `/cluster` other than a cancellation, and WebSocket upgrades
+fn is_new_work(method: &Method, path: &str, headers: &HeaderMap) -> bool {
+    if headers.contains_key(header::UPGRADE) {
+        return true;
+    }
+    *method == Method::POST
+        && !path.starts_with("/admin/")
+        && !path.starts_with("/cluster/")
+        && !path.ends_with("/cancel")
+}
+
+fn unavailable(mode: ServerMode) -> Response {
+    let (message, code) = match mode {
+        ServerMode::Draining => (
+            "The server is draining before shutdown; send requests to another instance",
+            "server_draining",
+        ),
+        _ => (
+            "The server is in maintenance mode; retry later",
+            "maintenance_mode",
+        ),
+    };
+    let mut response = (
+        StatusCode::SERVICE_UNAVAILABLE,
+        Json(json!({
+            "error": {
+                "message": message,
+                "type": "server_error",
+                "param": null,
+                "code": code
+            }
+        })),
+    )
+        .into_response();
+    response
+        .headers_mut()
+        .insert(header::RETRY_AFTER, HeaderValue::from_static("30"));
+    response
+}
+
+/// Middleware refusing new work with 503 unless the server is serving, and
+/// asking clients to go elsewhere on every response while draining
+pub async fn guard_availability(
+    State(state): State<Arc<ServerState>>,
+    request: Request,
+    next: Next,
+) -> Response {
+    let mode = state.operations.mode();
+    let mut response = if mode != ServerMode::Serving
+        && is_new_work(request.method(), request.uri().path(), request.headers())
+    {
+        unavailable(mode)
+    } else {
+        next.run(request).await
+    };
+
+    // Checked again, since a long request may see a drain begin
+    if state.operations.mode() == ServerMode::Draining {
+        mark_draining(response.headers_mut());
+    }
+    response
+}
+
+/// Set the headers that tell clients to close the connection and send new
+/// requests to another instance
+fn mark_draining(headers: &mut HeaderMap) {
+    headers.insert(header::CONNECTION, HeaderValue::from_static("close"));
+    headers.insert(DRAINING_HEADER, HeaderValue::from_static("true"));
+}
+
+fn conflict(message: String, code: &str) -> Response {
+    (
+        StatusCode::CONFLICT,
+        Json(json!({
+            "error": {
+                "message": message,
+                "type": "invalid_request_error",
+                "param": null,
+                "code": code
+            }
+        })),
+    )
+        .into_response()
+}
+
+#[derive(Debug, Deserialize)]
+pub struct MaintenanceRequest {
+    pub enabled: bool,
+    pub reason: Option<String>,
+}
+
+#[derive(Debug, Default, Deserialize)]
+pub struct DrainRequest {
+    /// How long to wait for in-flight requests, in seconds
+    pub timeout_seconds: Option<u64>,
+    /// Shut the server down once drained
+    #[serde(default)]
+    pub shutdown: bool,
+    pub reason: Option<String>,
+}
+
+/// Per-worker view for `/admin/status`
+#[derive(Debug, Serialize)]
+struct WorkerStatus {
+    worker_id: usize,
+    active_requests: usize,
+    total_requests: u64,
+    loaded_models: Vec<String>,
+}
+
+async fn status_body(state: &ServerState) -> serde_json::Value {
+    let workers = match &state.distributed {
+        Some(distributed) => {
+            let mut workers: Vec<WorkerStatus> = distributed
+                .get_stats()
+                .await
+                .into_values()
+                .map(|stats| WorkerStatus {
+                    worker_id: stats.worker_id,
+                    active_requests: stats.active_requests,
+                    total_requests: stats.total_requests,
+                    loaded_models: stats.loaded_models,
+                })
+                .collect();
+            workers.sort_by_key(|worker| worker.worker_id);
+            Some(workers)
+        }
+        None => None,
+    };
+
+    json!({
+        "object": "server.status",
+        "status": state.operations.status(),
+        "in_flight": in_flight(state),
+        "loaded_model": state.loaded_model,
+        "workers": workers,
+    })
+}
+
+// API Handlers
+
+/// `GET /admin/status` - mode, in-flight requests and workers (admin only)
+pub async fn get_status(State(state): State<Arc<ServerState>>, headers: HeaderMap) -> Response {
+    if let Err(response) = authorize_admin(&headers) {
+        return response;
+    }
+
+    Json(status_body(&state).await).into_response()
+}
+
+/// `POST /admin/maintenance` - turn maintenance mode on or off (admin only).
+/// Turning it off also ends a drain that has not shut the server down.
+pub async fn set_maintenance(
+    State(state): State<Arc<ServerState>>,
+    headers: HeaderMap,
+    Json(request): Json<MaintenanceRequest>,
+) -> Response {
+    if let Err(response) = authorize_admin(&headers) {
+        return response;
+    }
+
+    let mode = if request.enabled {
+        ServerMode::Maintenance
+    } else {
+        ServerMode::Serving
+    };
+    if let Err(message) = state.operations.set_mode(mode, request.reason.clone()) {
+        return conflict(message, "server_shutting_down");
+    }
+    info!(
+        "Server mode set to {:?}{}",
+        mode,
+        request
+            .reason
+            .map(|reason| format!(": {}", reason))
+            .unwrap_or_default()
+    );
+
+    Json(status_body(&state).await).into_response()
+}
+
+/// `POST /admin/drain` - refuse new work, wait for in-flight requests and
+/// optionally shut down (admin only)
+pub async fn drain(
+    State(state): State<Arc<ServerState>>,
+    headers: HeaderMap,
+    Json(request): Json<DrainRequest>,
+) -> Response {
+    if let Err(response) = authorize_admin(&headers) {
+        return response;
+    }
+
+    let timeout_seconds = request
+        .timeout_seconds
+        .unwrap_or(DEFAULT_DRAIN_TIMEOUT_SECONDS);
+    if timeout_seconds > MAX_DRAIN_TIMEOUT_SECONDS {
+        return invalid_request(
+            format!(
+                "timeout_seconds must be at most {}",
+                MAX_DRAIN_TIMEOUT_SECONDS
+            ),
+            Some("timeout_seconds"),
+            None,
+        );
+    }
+
+    if let Err(message) = state
+        .operations
+        .set_mode(ServerMode::Draining, request.reason.clone())
+    {
+        return conflict(message, "server_shutting_down");
+    }
+    info!(
+        "Draining {} in-flight requests (timeout {}s)",
+        in_flight(&state),
+        timeout_seconds
+    );
+
+    let started = Instant::now();
+    let deadline = started + Duration::from_secs(timeout_seconds);
+    while in_flight(&state) > 0 && Instant::now() < deadline {
+        tokio::time::sleep(DRAIN_POLL_INTERVAL).await;
+    }
+    let in_flight = in_flight(&state);
+    let drained = in_flight == 0;
+
+    if !drained {
+        warn!(
+            "Drain timed out after {}s with {} requests in flight",
+            timeout_seconds, in_flight
+        );
+    } else if request.shutdown {
+        info!("Drain complete; shutting down");
+        state.operations.request_shutdown();
+    }
+
+    Json(json!({
+        "object": "server.drain",
+        "drained": drained,
+        "in_flight": in_flight,
+        "waited_ms": started.elapsed().as_millis() as u64,
+        "shutting_down": drained && request.shutdown,
+        "status": state.operations.status(),
+    }))
+    .into_response()
+}
+
+/// `POST /admin/workers/restart` - reload the backends (admin only). The
+/// server must be in maintenance or draining so no request loses its
+/// backend midway.
+pub async fn restart_workers(
+    State(state): State<Arc<ServerState>>,
+    headers: HeaderMap,
+) -> Response {
+    if let Err(response) = authorize_admin(&headers) {
+        return response;
+    }
+
+    if state.operations.mode() == ServerMode::Serving {
+        return conflict(
+            "Put the server in maintenance mode or drain it before restarting workers".to_string(),
+            "server_in_service",
+        );
+    }
+
+    let restarted = if let Some(distributed) = &state.distributed {
+        // Workers handle the restart after the requests already queued
+        match distributed.restart_workers().await {
+            Ok(count) => count,
+            Err(e) => return restart_failed(e),
+        }
+    } else if let Some(backend) = &state.backend {
+        let in_flight = in_flight(&state);
+        if in_flight > 0 {
+            return conflict(
+                format!(
+                    "{} requests are still in flight; drain the server first",
+                    in_flight
+                ),
+                "requests_in_flight",
+            );
+        }
+        if let Some(model_info) = backend.get_model_info().await {
+            if let Err(e) = backend.unload_model().await {
+                return restart_failed(e);
+            }
+            if let Err(e) = backend.load_model(&model_info).await {
+                return restart_failed(e);
+            }
+        }
+        1
+    } else {
+        0
+    };
+
+    state.operations.record_worker_restart();
+    info!("Restarted {} backend workers", restarted);
+
+    Json(json!({
+        "object": "server.worker_restart",
+        "restarted": restarted,
+        "status": state.operations.status(),
+    }))
+    .into_response()
+}
+
+fn restart_failed(error: anyhow::Error) -> Response {
+    warn!("Worker restart failed: {}", error);
+    (
+        StatusCode::INTERNAL_SERVER_ERROR,
+        Json(json!({
+            "error": {
+                "message": format!("Worker restart failed: {}", error),
+                "type": "internal_error",
+                "param": null,
+                "code": null
+            }
+        })),
+    )
+        .into_response()
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_new_work_excludes_reads_admin_and_cancellation() {
+        let headers = HeaderMap::new();
+        assert!(is_new_work(&Method::POST, "/v1/chat/completions", &headers));
+        assert!(!is_new_work(&Method::GET, "/v1/models", &headers));
+        assert!(!is_new_work(&Method::POST, "/admin/drain", &headers));
+        assert!(!is_new_work(
+            &Method::POST,
+            "/v1/inference/req-1/cancel",
+            &headers
+        ));
+
+        let mut upgrade = HeaderMap::new();
+        upgrade.insert(header::UPGRADE, HeaderValue::from_static("websocket"));
+        assert!(is_new_work(&Method::GET, "/ws/stream", &upgrade));
+    }
+
+    #[test]
+    fn test_mode_is_fixed_once_shutting_down() {
+        let operations = Operations::new();
+        let watcher = operations.subscribe();
+        operations
+            .set_mode(ServerMode::Maintenance, Some("rollout".to_string()))
+            .unwrap();
+        assert_eq!(operations.mode(), ServerMode::Maintenance);
+        assert_eq!(*watcher.borrow(), ServerMode::Maintenance);
+
+        operations.request_shutdown();
+        assert!(operations.set_mode(ServerMode::Serving, None).is_err());
+        assert!(operations.status().shutting_down);
+    }
+
+    #[test]
+    fn test_stream_guard_counts_until_dropped() {
+        let operations = Arc::new(Operations::new());
+        let guard = operations.track_stream();
+        assert_eq!(operations.websocket_streams.load(Ordering::SeqCst), 1);
+        drop(guard);
+        assert_eq!(operations.websocket_streams.load(Ordering::SeqCst), 0);
+    }
+}
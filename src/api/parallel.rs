@@ -0,0 +1,665 @@
+//! Parallel Serving Plans
+//!
+//! A model too large for one device is served split across several: its
+//! layers are cut into pipeline stages, and each stage is sharded across
+//! `tensor_parallel_size` devices, possibly on different cluster nodes. The
+//! plan for each model is kept here, on the coordinator, instead of in a
+//! hand-edited config file on every host. Each host reads its own
+//! assignment from `/cluster/parallel/{model}/assignments/{node_id}`, loads its
+//! layers and reports device state and interconnect statistics back, and
+//! `/cluster/parallel/{model}/status` puts the reports together.
+//!
+//! Plans are validated when set: the stages must cover the model's layers
+//! in order without gaps or overlaps, every stage needs exactly one device
+//! per tensor-parallel rank, no device may serve twice, and every node must
+//! be a cluster member. Plans and reports are held in memory.
+
+use crate::{
+    api::{admin::authorize_admin, invalid_request},
+    cli::serve::ServerState,
+};
+use axum::{
+    Json,
+    extract::{Path, State},
+    http::{HeaderMap, StatusCode},
+    response::{IntoResponse, Response},
+};
+use chrono::{DateTime, Utc};
+use serde::{Deserialize, Serialize};
+use serde_json::json;
+use std::{
+    collections::{BTreeMap, HashSet},
+    sync::{Arc, RwLock},
+};
+use tracing::info;
+
+/// Most tensor-parallel ranks a stage may have
+const MAX_TENSOR_PARALLEL_SIZE: u32 = 64;
+
+/// A half-open range of transformer layers, `[start, end)`
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Serialize, Deserialize)]
+pub struct LayerRange {
+    pub start: u32,
+    pub end: u32,
+}
+
+/// One device: a GPU index such as `cuda:0` or `metal:0`, or `cpu`, on a
+/// cluster node
+#[derive(Debug, Clone, PartialEq, Eq, Hash, Serialize, Deserialize)]
+pub struct DeviceRef {
+    /// Cluster node id; the coordinator itself when omitted
+    #[serde(default)]
+    pub node: String,
+    pub device: String,
+}
+
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct PipelineStage {
+    pub layers: LayerRange,
+    /// One device per tensor-parallel rank, in rank order
+    pub devices: Vec<DeviceRef>,
+}
+
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct ParallelConfig {
+    /// Transformer layers in the model
+    pub num_layers: u32,
+    /// Devices each stage is sharded across
+    #[serde(default = "default_tensor_parallel_size")]
+    pub tensor_parallel_size: u32,
+    /// Pipeline stages in layer order
+    pub stages: Vec<PipelineStage>,
+    /// Expected link between devices, such as `nvlink`, `pcie` or
+    /// `infiniband`; informational
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub interconnect: Option<String>,
+}
+
+fn default_tensor_parallel_size() -> u32 {
+    1
+}
+
+impl ParallelConfig {
+    /// Check the plan, naming the first problem found
+    fn validate(&self) -> Result<(), (String, &'static str)> {
+        if self.num_layers == 0 {
+            return Err(("num_layers must be at least 1".to_string(), "num_layers"));
+        }
+        if self.tensor_parallel_size == 0 || self.tensor_parallel_size > MAX_TENSOR_PARALLEL_SIZE {
+            return Err((
+                format!(
+                    "tensor_parallel_size must be between 1 and {}",
+                    MAX_TENSOR_PARALLEL_SIZE
+                ),
+                "tensor_parallel_size",
+            ));
+        }
+        if self.stages.is_empty() {
+            return Err(("stages must not be empty".to_string(), "stages"));
+        }
+
+        let mut next_layer = 0;
+        let mut seen = HashSet::new();
+        for (index, stage) in self.stages.iter().enumerate() {
+            if stage.layers.start != next_layer || stage.layers.end <= stage.layers.start {
+                return Err((
+                    format!(
+                        "stage {} must cover layers starting at {} and be non-empty",
+                        index, next_layer
+                    ),
+                    "stages",
+                ));
+            }
+            next_layer = stage.layers.end;
+
+            if stage.devices.len() != self.tensor_parallel_size as usize {
+                return Err((
+                    format!(
+                        "stage {} has {} devices but tensor_parallel_size is {}",
+                        index,
+                        stage.devices.len(),
+                        self.tensor_parallel_size
+                    ),
+                    "stages",
+                ));
+            }
+            for device in &stage.devices {
+                if device.device.trim().is_empty() {
+                    return Err((format!("stage {} has an unnamed device", index), "stages"));
+                }
+                if !seen.insert(device) {
+                    return Err((
+                        format!(
+                            "device {} on node '{}' is assigned more than once",
+                            device.device, device.node
+                        ),
+                        "stages",
+                    ));
+                }
+            }
+        }
+
+        if next_layer != self.num_layers {
+            return Err((
+                format!(
+                    "stages cover layers 0 to {} but the model has {}",
+                    next_layer, self.num_layers
+                ),
+                "stages",
+            ));
+        }
+        Ok(())
+    }
+
+    fn nodes(&self) -> impl Iterator<Item = &str> {
+        self.stages
+            .iter()
+            .flat_map(|stage| stage.devices.iter().map(|device| device.node.as_str()))
+    }
+}
+
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Serialize, Deserialize)]
+#[serde(rename_all = "snake_case")]
+pub enum DeviceState {
+    /// No report yet
+    Pending,
+    Loading,
+    Ready,
+    Error,
+}
+
+/// Link statistics a device reports for traffic to its peers
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct InterconnectStats {
+    /// Measured link, such as `nvlink` or `tcp`
+    pub link: Option<String>,
+    pub bandwidth_gbps: Option<f32>,
+    pub latency_us: Option<f32>,
+    #[serde(default)]
+    pub bytes_sent: u64,
+    #[serde(default)]
+    pub bytes_received: u64,
+}
+
+#[derive(Debug, Clone, Deserialize)]
+pub struct DeviceReport {
+    pub device: String,
+    pub state: DeviceState,
+    pub error: Option<String>,
+    pub memory_used_mb: Option<u64>,
+    pub interconnect: Option<InterconnectStats>,
+}
+
+/// A node's report on the devices it serves for one model
+#[derive(Debug, Clone, Deserialize)]
+pub struct StatusReport {
+    /// Reporting node; the coordinator itself when omitted
+    #[serde(default)]
+    pub node: String,
+    pub devices: Vec<DeviceReport>,
+}
+
+#[derive(Debug, Clone, Serialize)]
+pub struct DeviceStatus {
+    pub node: String,
+    pub device: String,
+    pub rank: usize,
+    pub state: DeviceState,
+    pub error: Option<String>,
+    pub memory_used_mb: Option<u64>,
+    pub interconnect: Option<InterconnectStats>,
+    pub reported_at: Option<DateTime<Utc>>,
+}
+
+#[derive(Debug, Clone, Serialize)]
+pub struct StageStatus {
+    pub stage: usize,
+    pub layers: LayerRange,
+    pub devices: Vec<DeviceStatus>,
+}
+
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Serialize)]
+#[serde(rename_all = "snake_case")]
+pub enum PlanState {
+    /// Every device is ready
+    Ready,
+    /// Some devices have not finished loading or reporting
+    Loading,
+    /// A device reported an error
+    Degraded,
+}
+
+#[derive(Debug, Clone, Serialize)]
+pub struct ParallelPlan {
+    pub model: String,
+    pub config: ParallelConfig,
+    pub updated_at: DateTime<Utc>,
+}
+
+#[derive(Debug, Clone)]
+struct Reported {
+    report: DeviceReport,
+    at: DateTime<Utc>,
+}
+
+#[derive(Debug)]
+struct Entry {
+    plan: ParallelPlan,
+    reports: BTreeMap<(String, String), Reported>,
+}
+
+/// Parallel serving plans by model, with the devices' latest reports
+#[derive(Debug, Default)]
+pub struct ParallelPlanStore {
+    plans: RwLock<BTreeMap<String, Entry>>,
+}
+
+impl ParallelPlanStore {
+    pub fn new() -> Self {
+        Self::default()
+    }
+
+    pub fn list(&self) -> Vec<ParallelPlan> {
+        self.plans
+            .read()
+            .unwrap()
+            .values()
+            .map(|entry| entry.plan.clone())
+            .collect()
+    }
+
+    pub fn get(&self, model: &str) -> Option<ParallelPlan> {
+        let plans = self.plans.read().unwrap();
+        plans.get(model).map(|entry| entry.plan.clone())
+    }
+
+    /// Replace a model's plan, dropping reports from devices no longer in it
+    fn set(&self, model: &str, config: ParallelConfig) -> ParallelPlan {
+        let plan = ParallelPlan {
+            model: model.to_string(),
+            config,
+            updated_at: Utc::now(),
+        };
+        let devices: HashSet<(String, String)> = plan
+            .config
+            .stages
+            .iter()
+            .flat_map(|stage| &stage.devices)
+            .map(|device| (device.node.clone(), device.device.clone()))
+            .collect();
+
+        let mut plans = self.plans.write().unwrap();
+        let mut reports = plans
+            .remove(model)
+            .map(|entry| entry.reports)
+            .unwrap_or_default();
+        reports.retain(|key, _| devices.contains(key));
+        plans.insert(
+            model.to_string(),
+            Entry {
+                plan: plan.clone(),
+                reports,
+            },
+        );
+        plan
+    }
+
+    pub fn remove(&self, model: &str) -> bool {
+        self.plans.write().unwrap().remove(model).is_some()
+    }
+
+    /// Record a node's device reports; errors name a device not in the plan
+    fn report(&self, model: &str, report: StatusReport) -> Option<Result<(), String>> {
+        let mut plans = self.plans.write().unwrap();
+        let entry = plans.get_mut(model)?;
+        let assigned = |device: &str| {
+            entry.plan.config.stages.iter().any(|stage| {
+                stage
+                    .devices
+                    .iter()
+                    .any(|d| d.node == report.node && d.device == device)
+            })
+        };
+        if let Some(device) = report.devices.iter().find(|d| !assigned(&d.device)) {
+            return Some(Err(format!(
+                "device {} on node '{}' is not part of the plan for {}",
+                device.device, report.node, model
+            )));
+        }
+
+        let at = Utc::now();
+        for device in report.devices {
+            entry.reports.insert(
+                (report.node.clone(), device.device.clone()),
+                Reported { report: device, at },
+            );
+        }
+        Some(Ok(()))
+    }
+
+    /// The plan's stages with each device's latest report, and the overall
+    /// state
+    pub fn status(&self, model: &str) -> Option<(PlanState, Vec<StageStatus>)> {
+        let plans = self.plans.read().unwrap();
+        let entry = plans.get(model)?;
+
+        let stages: Vec<StageStatus> = entry
+            .plan
+            .config
+            .stages
+            .iter()
+            .enumerate()
+            .map(|(index, stage)| StageStatus {
+                stage: index,
+                layers: stage.layers,
+                devices: stage
+                    .devices
+                    .iter()
+                    .enumerate()
+                    .map(|(rank, device)| {
+                        let reported = entry
+                            .reports
+                            .get(&(device.node.clone(), device.device.clone()));
+                        DeviceStatus {
+                            node: device.node.clone(),
+                            device: device.device.clone(),
+                            rank,
+                            state: reported.map_or(DeviceState::Pending, |r| r.report.state),
+                            error: reported.and_then(|r| r.report.error.clone()),
+                            memory_used_mb: reported.and_then(|r| r.report.memory_used_mb),
+                            interconnect: reported.and_then(|r| r.report.interconnect.clone()),
+                            reported_at: reported.map(|r| r.at),
+                        }
+                    })
+                    .collect(),
+            })
+            .collect();
+
+        let states: Vec<DeviceState> = stages
+            .iter()
+            .flat_map(|stage| stage.devices.iter().map(|device| device.state))
+            .collect();
+        let state = if states.contains(&DeviceState::Error) {
+            PlanState::Degraded
+        } else if states.iter().all(|state| *state == DeviceState::Ready) {
+            PlanState::Ready
+        } else {
+            PlanState::Loading
+        };
+        Some((state, stages))
+    }
+}
+
+/// Fill in omitted node ids with the coordinator's own
+fn resolve_local_node(config: &mut ParallelConfig, local: &str) {
+    for stage in &mut config.stages {
+        for device in &mut stage.devices {
+            if device.node.is_empty() {
+                device.node = local.to_string();
+            }
+        }
+    }
+}
+
+fn plan_not_found(model: &str) -> Response {
+    (
+        StatusCode::NOT_FOUND,
+        Json(json!({
+            "error": {
+                "message": format!("No parallel serving plan for model '{}'", model),
+                "type": "invalid_request_error",
+                "param": "model",
+                "code": "plan_not_found"
+            }
+        })),
+    )
+        .into_response()
+}
+
+// API Handlers
+
+/// `GET /cluster/parallel` - every model's plan
+pub async fn list_plans(State(state): State<Arc<ServerState>>) -> Response {
+    Json(json!({
+        "object": "list",
+        "data": state.parallel_plans.list(),
+    }))
+    .into_response()
+}
+
+/// `GET /cluster/parallel/:model` - one model's plan
+pub async fn get_plan(
+    State(state): State<Arc<ServerState>>,
+    Path(model): Path<String>,
+) -> Response {
+    match state.parallel_plans.get(&model) {
+        Some(plan) => Json(plan).into_response(),
+        None => plan_not_found(&model),
+    }
+}
+
+/// `PUT /cluster/parallel/:model` - set or replace a model's plan (admin only)
+pub async fn put_plan(
+    State(state): State<Arc<ServerState>>,
+    Path(model): Path<String>,
+    headers: HeaderMap,
+    Json(mut config): Json<ParallelConfig>,
+) -> Response {
+    if let Err(response) = authorize_admin(&headers) {
+        return response;
+    }
+
+    resolve_local_node(&mut config, state.cluster.node_id());
+    if let Err((message, param)) = config.validate() {
+        return invalid_request(message, Some(param), None);
+    }
+    let nodes: Vec<String> = state
+        .cluster
+        .nodes(&state)
+        .await
+        .into_iter()
+        .map(|node| node.id)
+        .collect();
+    if let Some(node) = config
+        .nodes()
+        .find(|node| !nodes.iter().any(|id| id == node))
+    {
+        return invalid_request(
+            format!("Node '{}' is not a member of the cluster", node),
+            Some("stages"),
+            None,
+        );
+    }
+
+    let plan = state.parallel_plans.set(&model, config);
+    info!(
+        "Parallel plan for {} set: {} stages x {} tensor-parallel ranks",
+        model,
+        plan.config.stages.len(),
+        plan.config.tensor_parallel_size
+    );
+    Json(plan).into_response()
+}
+
+/// `DELETE /cluster/parallel/:model` - drop a model's plan (admin only)
+pub async fn delete_plan(
+    State(state): State<Arc<ServerState>>,
+    Path(model): Path<String>,
+    headers: HeaderMap,
+) -> Response {
+    if let Err(response) = authorize_admin(&headers) {
+        return response;
+    }
+
+    if state.parallel_plans.remove(&model) {
+        info!("Parallel plan for {} deleted", model);
+        Json(json!({ "id": model, "object": "parallel.plan", "deleted": true })).into_response()
+    } else {
+        plan_not_found(&model)
+    }
+}
+
+/// `GET /cluster/parallel/:model/assignments/:node_id` - the stages and ranks one
+/// node serves
+pub async fn get_assignment(
+    State(state): State<Arc<ServerState>>,
+    Path((model, node_id)): Path<(String, String)>,
+) -> Response {
+    let Some(plan) = state.parallel_plans.get(&model) else {
+        return plan_not_found(&model);
+    };
+
+    let assignments: Vec<_> = plan
+        .config
+        .stages
+        .iter()
+        .enumerate()
+        .flat_map(|(index, stage)| {
+            let node_id = &node_id;
+            stage
+                .devices
+                .iter()
+                .enumerate()
+                .filter(move |(_, device)| device.node == *node_id)
+                .map(move |(rank, device)| {
+                    json!({
+                        "stage": index,
+                        "layers": stage.layers,
+                        "rank": rank,
+                        "device": device.device,
+                        // Every device in the stage, so ranks can find their peers
+                        "peers": stage.devices,
+                    })
+                })
+        })
+        .collect();
+
+    Json(json!({
+        "object": "parallel.assignment",
+        "model": model,
+        "node": node_id,
+        "num_layers": plan.config.num_layers,
+        "tensor_parallel_size": plan.config.tensor_parallel_size,
+        "pipeline_stages": plan.config.stages.len(),
+        "interconnect": plan.config.interconnect,
+        "assignments": assignments,
+    }))
+    .into_response()
+}
+
+/// `GET /cluster/parallel/:model/status` - device state and interconnect stats
+pub async fn get_status(
+    State(state): State<Arc<ServerState>>,
+    Path(model): Path<String>,
+) -> Response {
+    match state.parallel_plans.status(&model) {
+        Some((plan_state, stages)) => Json(json!({
+            "object": "parallel.status",
+            "model": model,
+            "state": plan_state,
+            "stages": stages,
+        }))
+        .into_response(),
+        None => plan_not_found(&model),
+    }
+}
+
+/// `POST /cluster/parallel/:model/status` - a node reports its devices (admin
+/// only)
+pub async fn report_status(
+    State(state): State<Arc<ServerState>>,
+    Path(model): Path<String>,
+    headers: HeaderMap,
+    Json(mut report): Json<StatusReport>,
+) -> Response {
+    if let Err(response) = authorize_admin(&headers) {
+        return response;
+    }
+
+    if report.node.is_empty() {
+        report.node = state.cluster.node_id().to_string();
+    }
+    match state.parallel_plans.report(&model, report) {
+        Some(Ok(())) => get_status(State(state), Path(model)).await,
+        Some(Err(message)) => invalid_request(message, Some("devices"), None),
+        None => plan_not_found(&model),
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn device(node: &str, device: &str) -> DeviceRef {
+        DeviceRef {
+            node: node.to_string(),
+            device: device.to_string(),
+        }
+    }
+
+    fn plan() -> ParallelConfig {
+        ParallelConfig {
+            num_layers: 80,
+            tensor_parallel_size: 2,
+            stages: vec![
+                PipelineStage {
+                    layers: LayerRange { start: 0, end: 40 },
+                    devices: vec![device("a", "cuda:0"), device("a", "cuda:1")],
+                },
+                PipelineStage {
+                    layers: LayerRange { start: 40, end: 80 },
+                    devices: vec![device("b", "cuda:0"), device("b", "cuda:1")],
+                },
+            ],
+            interconnect: Some("infiniband".to_string()),
+        }
+    }
+
+    #[test]
+    fn test_validate_plan() {
+        assert!(plan().validate().is_ok());
+
+        let mut gap = plan();
+        gap.stages[1].layers.start = 41;
+        assert!(gap.validate().is_err());
+
+        let mut short = plan();
+        short.num_layers = 81;
+        assert!(short.validate().is_err());
+
+        let mut ranks = plan();
+        ranks.stages[0].devices.pop();
+        assert!(ranks.validate().is_err());
+
+        let mut reused = plan();
+        reused.stages[1].devices[0] = device("a", "cuda:0");
+        assert!(reused.validate().is_err());
+    }
+
+    #[test]
+    fn test_status_follows_reports() {
+        let store = ParallelPlanStore::new();
+        store.set("llama-70b", plan());
+        assert_eq!(store.status("llama-70b").unwrap().0, PlanState::Loading);
+
+        let ready = |node: &str| StatusReport {
+            node: node.to_string(),
+            devices: ["cuda:0", "cuda:1"]
+                .iter()
+                .map(|device| DeviceReport {
+                    device: device.to_string(),
+                    state: DeviceState::Ready,
+                    error: None,
+                    memory_used_mb: Some(40_000),
+                    interconnect: None,
+                })
+                .collect(),
+        };
+        store.report("llama-70b", ready("a")).unwrap().unwrap();
+        store.report("llama-70b", ready("b")).unwrap().unwrap();
+        assert_eq!(store.status("llama-70b").unwrap().0, PlanState::Ready);
+
+        assert!(store.report("llama-70b", ready("c")).unwrap().is_err());
+        assert!(store.report("other", ready("a")).is_none());
+    }
+}
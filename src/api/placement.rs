@@ -0,0 +1,572 @@
+//! Model Placement
+//!
+//! Capacity management for a cluster: each model gets a placement rule
+//! with a replica count and, optionally, nodes or GPUs it is pinned to.
+//! Rules take effect through a rebalance, which works out the nodes every
+//! model should run on and the loads and unloads that gets there from what
+//! the nodes report. Pins are placed first, nodes already serving a model
+//! keep it where they can, and remaining replicas go to the healthy worker
+//! nodes with the least assigned. Nodes poll
+//! `/cluster/placement/nodes/{node_id}` for the models they should serve.
+//!
+//! Models without a rule are left where they are. Rules and the last
+//! rebalance's targets are held in memory.
+
+use crate::{
+    api::{
+        admin::authorize_admin,
+        cluster::{ClusterNode, NodeHealth, NodeRole},
+        invalid_request,
+    },
+    cli::serve::ServerState,
+};
+use axum::{
+    Json,
+    extract::{Path, State},
+    http::{HeaderMap, StatusCode},
+    response::{IntoResponse, Response},
+};
+use chrono::{DateTime, Utc};
+use serde::{Deserialize, Serialize};
+use serde_json::json;
+use std::{
+    collections::{BTreeMap, HashMap, HashSet},
+    sync::{Arc, RwLock},
+};
+use tracing::info;
+
+/// Most replicas one model may ask for
+const MAX_REPLICAS: u32 = 256;
+
+/// A node, and optionally a GPU on it, a model must run on
+#[derive(Debug, Clone, PartialEq, Eq, Serialize, Deserialize)]
+pub struct Pin {
+    pub node: String,
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub gpu: Option<u32>,
+}
+
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct PlacementRule {
+    /// Nodes the model should run on, pins included
+    #[serde(default = "default_replicas")]
+    pub replicas: u32,
+    #[serde(default)]
+    pub pins: Vec<Pin>,
+}
+
+fn default_replicas() -> u32 {
+    1
+}
+
+impl PlacementRule {
+    fn validate(&self) -> Result<(), String> {
+        if self.replicas == 0 || self.replicas > MAX_REPLICAS {
+            return Err(format!("replicas must be between 1 and {}", MAX_REPLICAS));
+        }
+        if self.pins.len() > self.replicas as usize {
+            return Err(format!(
+                "{} pins need at least {} replicas",
+                self.pins.len(),
+                self.pins.len()
+            ));
+        }
+        let mut nodes = HashSet::new();
+        if let Some(pin) = self.pins.iter().find(|pin| !nodes.insert(&pin.node)) {
+            return Err(format!("node '{}' is pinned more than once", pin.node));
+        }
+        Ok(())
+    }
+}
+
+/// Where one replica of a model runs
+#[derive(Debug, Clone, PartialEq, Eq, Serialize)]
+pub struct Replica {
+    pub node: String,
+    pub gpu: Option<u32>,
+    pub pinned: bool,
+}
+
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Serialize)]
+#[serde(rename_all = "snake_case")]
+pub enum PlacementAction {
+    Load,
+    Unload,
+}
+
+#[derive(Debug, Clone, Serialize)]
+pub struct PlacementChange {
+    pub action: PlacementAction,
+    pub model: String,
+    pub node: String,
+    pub gpu: Option<u32>,
+}
+
+/// A model that could not get all its replicas
+#[derive(Debug, Clone, Serialize)]
+pub struct Shortfall {
+    pub model: String,
+    pub replicas: u32,
+    pub placed: u32,
+}
+
+#[derive(Debug, Clone, Serialize)]
+pub struct RebalancePlan {
+    pub targets: BTreeMap<String, Vec<Replica>>,
+    pub changes: Vec<PlacementChange>,
+    pub shortfalls: Vec<Shortfall>,
+}
+
+/// Whether new replicas may go to a node
+fn eligible(node: &ClusterNode) -> bool {
+    node.roles.contains(&NodeRole::Worker) && node.health == NodeHealth::Healthy
+}
+
+/// Work out where every ruled model should run and the changes that gets
+/// there from the nodes' loaded models
+pub fn plan_rebalance(
+    rules: &BTreeMap<String, PlacementRule>,
+    nodes: &[ClusterNode],
+) -> RebalancePlan {
+    // Replicas assigned per node, counting models without a rule that the
+    // node already serves
+    let mut load: HashMap<&str, usize> = nodes
+        .iter()
+        .map(|node| {
+            let unmanaged = node
+                .loaded_models
+                .iter()
+                .filter(|model| !rules.contains_key(*model))
+                .count();
+            (node.id.as_str(), unmanaged)
+        })
+        .collect();
+    let free_memory =
+        |node: &ClusterNode| -> u64 { node.gpus.iter().map(|gpu| gpu.memory_free_mb).sum() };
+
+    let mut plan = RebalancePlan {
+        targets: BTreeMap::new(),
+        changes: Vec::new(),
+        shortfalls: Vec::new(),
+    };
+
+    for (model, rule) in rules {
+        let mut replicas: Vec<Replica> = rule
+            .pins
+            .iter()
+            .map(|pin| Replica {
+                node: pin.node.clone(),
+                gpu: pin.gpu,
+                pinned: true,
+            })
+            .collect();
+        let chosen =
+            |replicas: &[Replica], node: &ClusterNode| replicas.iter().any(|r| r.node == node.id);
+
+        // Keep the model where it already runs before moving it anywhere
+        for node in nodes {
+            if replicas.len() >= rule.replicas as usize {
+                break;
+            }
+            if eligible(node) && node.loaded_models.contains(model) && !chosen(&replicas, node) {
+                replicas.push(Replica {
+                    node: node.id.clone(),
+                    gpu: None,
+                    pinned: false,
+                });
+            }
+        }
+
+        while replicas.len() < rule.replicas as usize {
+            let candidate = nodes
+                .iter()
+                .filter(|node| eligible(node) && !chosen(&replicas, node))
+                .min_by(|a, b| {
+                    load[a.id.as_str()]
+                        .cmp(&load[b.id.as_str()])
+                        .then(free_memory(b).cmp(&free_memory(a)))
+                        .then(a.id.cmp(&b.id))
+                });
+            let Some(node) = candidate else {
+                plan.shortfalls.push(Shortfall {
+                    model: model.clone(),
+                    replicas: rule.replicas,
+                    placed: replicas.len() as u32,
+                });
+                break;
+            };
+            replicas.push(Replica {
+                node: node.id.clone(),
+                gpu: None,
+                pinned: false,
+            });
+        }
+
+        for replica in &replicas {
+            if let Some(count) = load.get_mut(replica.node.as_str()) {
+                *count += 1;
+            }
+        }
+
+        for node in nodes {
+            let hosting = node.loaded_models.contains(model);
+            match replicas.iter().find(|replica| replica.node == node.id) {
+                Some(replica) if !hosting => plan.changes.push(PlacementChange {
+                    action: PlacementAction::Load,
+                    model: model.clone(),
+                    node: node.id.clone(),
+                    gpu: replica.gpu,
+                }),
+                None if hosting => plan.changes.push(PlacementChange {
+                    action: PlacementAction::Unload,
+                    model: model.clone(),
+                    node: node.id.clone(),
+                    gpu: None,
+                }),
+                _ => {}
+            }
+        }
+
+        plan.targets.insert(model.clone(), replicas);
+    }
+
+    plan
+}
+
+#[derive(Debug, Clone)]
+struct StoredRule {
+    rule: PlacementRule,
+    updated_at: DateTime<Utc>,
+}
+
+#[derive(Debug, Default)]
+struct Placements {
+    rules: BTreeMap<String, StoredRule>,
+    targets: BTreeMap<String, Vec<Replica>>,
+    rebalanced_at: Option<DateTime<Utc>>,
+}
+
+/// Placement rules by model and the targets of the last rebalance
+#[derive(Debug, Default)]
+pub struct PlacementStore {
+    inner: RwLock<Placements>,
+}
+
+impl PlacementStore {
+    pub fn new() -> Self {
+        Self::default()
+    }
+
+    fn set(&self, model: &str, rule: PlacementRule) {
+        self.inner.write().unwrap().rules.insert(
+            model.to_string(),
+            StoredRule {
+                rule,
+                updated_at: Utc::now(),
+            },
+        );
+    }
+
+    fn remove(&self, model: &str) -> bool {
+        let mut inner = self.inner.write().unwrap();
+        inner.targets.remove(model);
+        inner.rules.remove(model).is_some()
+    }
+
+    fn rules(&self) -> BTreeMap<String, PlacementRule> {
+        let inner = self.inner.read().unwrap();
+        inner
+            .rules
+            .iter()
+            .map(|(model, stored)| (model.clone(), stored.rule.clone()))
+            .collect()
+    }
+
+    fn apply(&self, targets: BTreeMap<String, Vec<Replica>>) {
+        let mut inner = self.inner.write().unwrap();
+        inner.targets = targets;
+        inner.rebalanced_at = Some(Utc::now());
+    }
+
+    /// The models, and pinned GPUs, a node should serve
+    pub fn node_targets(&self, node_id: &str) -> Vec<serde_json::Value> {
+        let inner = self.inner.read().unwrap();
+        inner
+            .targets
+            .iter()
+            .filter_map(|(model, replicas)| {
+                replicas
+                    .iter()
+                    .find(|replica| replica.node == node_id)
+                    .map(|replica| json!({ "model": model, "gpu": replica.gpu }))
+            })
+            .collect()
+    }
+
+    fn entry(&self, model: &str, nodes: &[ClusterNode]) -> Option<serde_json::Value> {
+        let inner = self.inner.read().unwrap();
+        let stored = inner.rules.get(model)?;
+        let current: Vec<&str> = nodes
+            .iter()
+            .filter(|node| node.loaded_models.iter().any(|m| m == model))
+            .map(|node| node.id.as_str())
+            .collect();
+        Some(json!({
+            "model": model,
+            "rule": stored.rule,
+            "updated_at": stored.updated_at,
+            "current": current,
+            "target": inner.targets.get(model),
+        }))
+    }
+
+    fn rebalanced_at(&self) -> Option<DateTime<Utc>> {
+        self.inner.read().unwrap().rebalanced_at
+    }
+}
+
+fn rule_not_found(model: &str) -> Response {
+    (
+        StatusCode::NOT_FOUND,
+        Json(json!({
+            "error": {
+                "message": format!("No placement rule for model '{}'", model),
+                "type": "invalid_request_error",
+                "param": "model",
+                "code": "placement_not_found"
+            }
+        })),
+    )
+        .into_response()
+}
+
+// API Handlers
+
+/// `GET /cluster/placement` - every rule with current and target placement
+pub async fn list_placements(State(state): State<Arc<ServerState>>) -> Response {
+    let nodes = state.cluster.nodes(&state).await;
+    let data: Vec<_> = state
+        .placement
+        .rules()
+        .keys()
+        .filter_map(|model| state.placement.entry(model, &nodes))
+        .collect();
+    Json(json!({
+        "object": "list",
+        "data": data,
+        "rebalanced_at": state.placement.rebalanced_at(),
+    }))
+    .into_response()
+}
+
+/// `GET /cluster/placement/:model` - one model's rule and placement
+pub async fn get_placement(
+    State(state): State<Arc<ServerState>>,
+    Path(model): Path<String>,
+) -> Response {
+    let nodes = state.cluster.nodes(&state).await;
+    match state.placement.entry(&model, &nodes) {
+        Some(entry) => Json(entry).into_response(),
+        None => rule_not_found(&model),
+    }
+}
+
+/// `PUT /cluster/placement/:model` - set a model's rule (admin only); it
+/// takes effect at the next rebalance
+pub async fn put_placement(
+    State(state): State<Arc<ServerState>>,
+    Path(model): Path<String>,
+    headers: HeaderMap,
+    Json(rule): Json<PlacementRule>,
+) -> Response {
+    if let Err(response) = authorize_admin(&headers) {
+        return response;
+    }
+
+    if let Err(message) = rule.validate() {
+        return invalid_request(message, Some("replicas"), None);
+    }
+    let nodes = state.cluster.nodes(&state).await;
+    for pin in &rule.pins {
+        let Some(node) = nodes.iter().find(|node| node.id == pin.node) else {
+            return invalid_request(
+                format!("Node '{}' is not a member of the cluster", pin.node),
+                Some("pins"),
+                None,
+            );
+        };
+        if let Some(gpu) = pin.gpu
+            && !node.gpus.is_empty()
+            && !node.gpus.iter().any(|g| g.index == gpu)
+        {
+            return invalid_request(
+                format!("Node '{}' has no GPU {}", pin.node, gpu),
+                Some("pins"),
+                None,
+            );
+        }
+    }
+
+    info!(
+        "Placement rule for {} set: {} replicas, {} pins",
+        model,
+        rule.replicas,
+        rule.pins.len()
+    );
+    state.placement.set(&model, rule);
+    get_placement(State(state), Path(model)).await
+}
+
+/// `DELETE /cluster/placement/:model` - drop a model's rule (admin only),
+/// leaving its replicas where they are
+pub async fn delete_placement(
+    State(state): State<Arc<ServerState>>,
+    Path(model): Path<String>,
+    headers: HeaderMap,
+) -> Response {
+    if let Err(response) = authorize_admin(&headers) {
+        return response;
+    }
+
+    if state.placement.remove(&model) {
+        Json(json!({ "id": model, "object": "placement.rule", "deleted": true })).into_response()
+    } else {
+        rule_not_found(&model)
+    }
+}
+
+#[derive(Debug, Default, Deserialize)]
+pub struct RebalanceRequest {
+    /// Report the changes without making them the nodes' targets
+    #[serde(default)]
+    pub dry_run: bool,
+}
+
+/// `POST /cluster/placement/rebalance` - recompute every model's placement
+/// (admin only)
+pub async fn rebalance(
+    State(state): State<Arc<ServerState>>,
+    headers: HeaderMap,
+    request: Option<Json<RebalanceRequest>>,
+) -> Response {
+    if let Err(response) = authorize_admin(&headers) {
+        return response;
+    }
+
+    let request = request.map(|Json(request)| request).unwrap_or_default();
+    let nodes = state.cluster.nodes(&state).await;
+    let plan = plan_rebalance(&state.placement.rules(), &nodes);
+    if !request.dry_run {
+        state.placement.apply(plan.targets.clone());
+        info!(
+            "Placement rebalanced: {} models, {} changes, {} short of replicas",
+            plan.targets.len(),
+            plan.changes.len(),
+            plan.shortfalls.len()
+        );
+    }
+
+    Json(json!({
+        "object": "placement.rebalance",
+        "dry_run": request.dry_run,
+        "targets": plan.targets,
+        "changes": plan.changes,
+        "shortfalls": plan.shortfalls,
+    }))
+    .into_response()
+}
+
+/// `GET /cluster/placement/nodes/:node_id` - the models a node should serve
+pub async fn node_placement(
+    State(state): State<Arc<ServerState>>,
+    Path(node_id): Path<String>,
+) -> Response {
+    Json(json!({
+        "object": "placement.node",
+        "node": node_id,
+        "models": state.placement.node_targets(&node_id),
+        "rebalanced_at": state.placement.rebalanced_at(),
+    }))
+    .into_response()
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn node(id: &str, loaded: &[&str]) -> ClusterNode {
+        ClusterNode {
+            id: id.to_string(),
+            address: None,
+            roles: vec![NodeRole::Worker],
+            version: None,
+            loaded_models: loaded.iter().map(|m| m.to_string()).collect(),
+            gpus: Vec::new(),
+            health: NodeHealth::Healthy,
+            local: false,
+            joined_at: Utc::now(),
+            last_seen: Utc::now(),
+        }
+    }
+
+    fn rule(replicas: u32, pins: &[&str]) -> PlacementRule {
+        PlacementRule {
+            replicas,
+            pins: pins
+                .iter()
+                .map(|node| Pin {
+                    node: node.to_string(),
+                    gpu: None,
+                })
+                .collect(),
+        }
+    }
+
+    #[test]
+    fn test_validate_rule() {
+        assert!(rule(2, &["a"]).validate().is_ok());
+        assert!(rule(0, &[]).validate().is_err());
+        assert!(rule(1, &["a", "b"]).validate().is_err());
+        assert!(rule(2, &["a", "a"]).validate().is_err());
+    }
+
+    #[test]
+    fn test_rebalance_keeps_existing_and_spreads_new() {
+        let nodes = vec![
+            node("a", &["llama"]),
+            node("b", &[]),
+            node("c", &["mistral"]),
+        ];
+        let rules = BTreeMap::from([
+            ("llama".to_string(), rule(2, &[])),
+            ("qwen".to_string(), rule(1, &["c"])),
+        ]);
+
+        let plan = plan_rebalance(&rules, &nodes);
+        let llama: Vec<&str> = plan.targets["llama"]
+            .iter()
+            .map(|r| r.node.as_str())
+            .collect();
+        assert_eq!(llama, vec!["a", "b"]);
+        assert!(plan.targets["qwen"][0].pinned);
+        assert_eq!(plan.changes.len(), 2);
+        assert!(plan.shortfalls.is_empty());
+    }
+
+    #[test]
+    fn test_rebalance_unloads_and_reports_shortfall() {
+        let mut down = node("b", &["llama"]);
+        down.health = NodeHealth::Unreachable;
+        let nodes = vec![node("a", &["llama"]), down];
+        let rules = BTreeMap::from([("llama".to_string(), rule(3, &[]))]);
+
+        let plan = plan_rebalance(&rules, &nodes);
+        assert_eq!(plan.targets["llama"].len(), 1);
+        assert_eq!(plan.shortfalls[0].placed, 1);
+        assert!(
+            plan.changes
+                .iter()
+                .any(|c| c.action == PlacementAction::Unload && c.node == "b")
+        );
+    }
+}
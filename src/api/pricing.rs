@@ -0,0 +1,206 @@
+//! Model Pricing
+//!
+//! Operators who charge for inference, or account for it internally, set a
+//! per-model price through `PUT /v1/models/{model_id}/pricing` (admin
+//! only): a currency and the price per million prompt and completion
+//! tokens. Anyone can read it back, so clients can estimate a request's
+//! cost from its token counts before sending it. The response also carries
+//! the server's default `max_tokens`, which bounds requests that leave it
+//! unset. Prices are held in memory; the server does not bill anything.
+
+use crate::{
+    api::{admin::authorize_admin, conditional, error_response, runtime_config::sampling_defaults},
+    cli::serve::ServerState,
+};
+use axum::{
+    Json,
+    extract::{Path, State},
+    http::{HeaderMap, StatusCode},
+    response::{IntoResponse, Response},
+};
+use chrono::{DateTime, Utc};
+use serde::{Deserialize, Serialize};
+use serde_json::json;
+use std::{
+    collections::HashMap,
+    sync::{Arc, RwLock},
+};
+use tracing::info;
+
+fn default_currency() -> String {
+    "USD".to_string()
+}
+
+/// A model's prices, as set by an admin
+#[derive(Debug, Clone, PartialEq, Serialize, Deserialize)]
+pub struct PricingRule {
+    /// ISO 4217 code, or any unit the operator accounts in
+    #[serde(default = "default_currency")]
+    pub currency: String,
+    pub prompt_per_million: f64,
+    pub completion_per_million: f64,
+}
+
+impl PricingRule {
+    fn validate(&self) -> Result<(), (String, &'static str)> {
+        if self.currency.trim().is_empty() {
+            return Err(("currency may not be empty".to_string(), "currency"));
+        }
+        for (price, param) in [
+            (self.prompt_per_million, "prompt_per_million"),
+            (self.completion_per_million, "completion_per_million"),
+        ] {
+            if !price.is_finite() || price < 0.0 {
+                return Err((format!("{} must be zero or more", param), param));
+            }
+        }
+        Ok(())
+    }
+}
+
+/// A model's prices as `/v1/models/{model_id}/pricing` returns them
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct ModelPricing {
+    pub object: String,
+    pub model: String,
+    #[serde(flatten)]
+    pub rule: PricingRule,
+    /// Completion tokens a request without `max_tokens` may generate
+    pub default_max_tokens: u32,
+    pub updated_at: DateTime<Utc>,
+}
+
+/// Prices by model
+#[derive(Debug, Default)]
+pub struct PricingStore {
+    rules: RwLock<HashMap<String, (PricingRule, DateTime<Utc>)>>,
+}
+
+impl PricingStore {
+    pub fn new() -> Self {
+        Self::default()
+    }
+
+    pub fn get(&self, model: &str) -> Option<ModelPricing> {
+        let rules = self.rules.read().unwrap();
+        rules.get(model).map(|(rule, updated_at)| ModelPricing {
+            object: "model.pricing".to_string(),
+            model: model.to_string(),
+            rule: rule.clone(),
+            default_max_tokens: sampling_defaults().max_tokens,
+            updated_at: *updated_at,
+        })
+    }
+
+    fn set(&self, model: &str, rule: PricingRule) {
+        self.rules
+            .write()
+            .unwrap()
+            .insert(model.to_string(), (rule, Utc::now()));
+    }
+
+    fn remove(&self, model: &str) -> bool {
+        self.rules.write().unwrap().remove(model).is_some()
+    }
+}
+
+fn pricing_not_found(model: &str) -> Response {
+    error_response(
+        StatusCode::NOT_FOUND,
+        format!("No pricing is set for model '{}'", model),
+        Some("model_id"),
+        Some("pricing_not_found"),
+    )
+}
+
+// API Handlers
+
+/// `GET /v1/models/:model_id/pricing` - a model's prices
+pub async fn get_pricing(
+    State(state): State<Arc<ServerState>>,
+    Path(model): Path<String>,
+    headers: HeaderMap,
+) -> Response {
+    match state.pricing.get(&model) {
+        Some(pricing) => conditional::json_with_etag(&headers, &pricing),
+        None => pricing_not_found(&model),
+    }
+}
+
+/// `PUT /v1/models/:model_id/pricing` - set a model's prices (admin only)
+pub async fn put_pricing(
+    State(state): State<Arc<ServerState>>,
+    Path(model): Path<String>,
+    headers: HeaderMap,
+    Json(rule): Json<PricingRule>,
+) -> Response {
+    if let Err(response) = authorize_admin(&headers) {
+        return response;
+    }
+    if let Err((message, param)) = rule.validate() {
+        return error_response(
+            StatusCode::BAD_REQUEST,
+            message,
+            Some(param),
+            Some("invalid_pricing"),
+        );
+    }
+
+    info!(
+        "Pricing for {} set: {} {} prompt, {} completion per million tokens",
+        model, rule.currency, rule.prompt_per_million, rule.completion_per_million
+    );
+    state.pricing.set(&model, rule);
+    get_pricing(State(state), Path(model), HeaderMap::new()).await
+}
+
+/// `DELETE /v1/models/:model_id/pricing` - drop a model's prices (admin
+/// only)
+pub async fn delete_pricing(
+    State(state): State<Arc<ServerState>>,
+    Path(model): Path<String>,
+    headers: HeaderMap,
+) -> Response {
+    if let Err(response) = authorize_admin(&headers) {
+        return response;
+    }
+    if state.pricing.remove(&model) {
+        Json(json!({ "id": model, "object": "model.pricing", "deleted": true })).into_response()
+    } else {
+        pricing_not_found(&model)
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_pricing_store() {
+        let store = PricingStore::new();
+        let rule = PricingRule {
+            currency: default_currency(),
+            prompt_per_million: 0.5,
+            completion_per_million: 1.5,
+        };
+        assert!(rule.validate().is_ok());
+        store.set("llama", rule.clone());
+
+        let pricing = store.get("llama").unwrap();
+        assert_eq!(pricing.rule, rule);
+        assert_eq!(pricing.object, "model.pricing");
+        assert!(store.get("mistral").is_none());
+        assert!(store.remove("llama"));
+        assert!(!store.remove("llama"));
+    }
+
+    #[test]
+    fn test_rejects_negative_prices() {
+        let rule = PricingRule {
+            currency: default_currency(),
+            prompt_per_million: -1.0,
+            completion_per_million: 1.0,
+        };
+        assert_eq!(rule.validate().unwrap_err().1, "prompt_per_million");
+    }
+}
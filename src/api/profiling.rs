@@ -0,0 +1,427 @@
+//! Remote Profiling
+//!
+//! Admin endpoints for investigating a production server without shell
+//! access. `GET /admin/profile/cpu?seconds=30` samples every thread's stack
+//! for the given time and returns a pprof protobuf that `go tool pprof` and
+//! other pprof viewers read. CPU profiling needs a Unix build with the
+//! `profiling` feature. Rust has no goroutines or garbage-collected heap, so
+//! the native equivalents are text reports: `threads` lists the process's
+//! threads with their state and CPU time, and `heap` the process's memory
+//! use as the kernel accounts it. Both read `/proc` and are Linux-only.
+
+use crate::api::admin::authorize_admin;
+use axum::{
+    Json,
+    extract::{Path, Query},
+    http::{HeaderMap, HeaderValue, StatusCode, header},
+    response::{IntoResponse, Response},
+};
+use serde::{Deserialize, Serialize};
+use serde_json::json;
+use std::{
+    sync::atomic::{AtomicBool, Ordering},
+    time::Duration,
+};
+use tracing::info;
+
+/// Default and longest CPU profile
+const DEFAULT_PROFILE_SECONDS: u64 = 30;
+const MAX_PROFILE_SECONDS: u64 = 300;
+
+/// Default and highest sampling rate, in samples per second
+const DEFAULT_FREQUENCY: i32 = 100;
+const MAX_FREQUENCY: i32 = 1000;
+
+/// Set while a CPU profile runs; the sampler is process-wide
+static CPU_PROFILE_RUNNING: AtomicBool = AtomicBool::new(false);
+
+/// A profile the server can produce
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Serialize, Deserialize)]
+#[serde(rename_all = "snake_case")]
+pub enum ProfileKind {
+    Cpu,
+    Threads,
+    Heap,
+}
+
+impl ProfileKind {
+    pub const ALL: [ProfileKind; 3] = [ProfileKind::Cpu, ProfileKind::Threads, ProfileKind::Heap];
+
+    pub fn as_str(&self) -> &'static str {
+        match self {
+            ProfileKind::Cpu => "cpu",
+            ProfileKind::Threads => "threads",
+            ProfileKind::Heap => "heap",
+        }
+    }
+
+    fn parse(name: &str) -> Option<Self> {
+        Self::ALL.into_iter().find(|kind| kind.as_str() == name)
+    }
+
+    /// Whether this build and platform can produce the profile
+    pub fn supported(&self) -> bool {
+        match self {
+            ProfileKind::Cpu => cfg!(all(feature = "profiling", unix)),
+            ProfileKind::Threads | ProfileKind::Heap => cfg!(target_os = "linux"),
+        }
+    }
+
+    fn format(&self) -> &'static str {
+        match self {
+            ProfileKind::Cpu => "pprof",
+            ProfileKind::Threads | ProfileKind::Heap => "text",
+        }
+    }
+
+    fn description(&self) -> &'static str {
+        match self {
+            ProfileKind::Cpu => "Sampled stacks of every thread over `seconds`",
+            ProfileKind::Threads => "Every thread with its state and CPU time",
+            ProfileKind::Heap => "Process memory use as the kernel accounts it",
+        }
+    }
+}
+
+#[derive(Debug, Clone, Default, Deserialize)]
+pub struct ProfileParams {
+    /// How long a CPU profile samples
+    #[serde(default)]
+    pub seconds: Option<u64>,
+    /// CPU samples per second
+    #[serde(default)]
+    pub frequency: Option<i32>,
+}
+
+/// Clears [`CPU_PROFILE_RUNNING`] when the profile ends, however it ends
+struct CpuProfileSlot;
+
+impl CpuProfileSlot {
+    fn acquire() -> Option<Self> {
+        CPU_PROFILE_RUNNING
+            .compare_exchange(false, true, Ordering::AcqRel, Ordering::Acquire)
+            .ok()
+            .map(|_| CpuProfileSlot)
+    }
+}
+
+impl Drop for CpuProfileSlot {
+    fn drop(&mut self) {
+        CPU_PROFILE_RUNNING.store(false, Ordering::Release);
+    }
+}
+
+/// Sample every thread for `duration` and encode the result as pprof
+#[cfg(all(feature = "profiling", unix))]
+fn cpu_profile(duration: Duration, frequency: i32) -> Result<Vec<u8>, String> {
+    use pprof::protos::Message;
+
+    let guard = pprof::ProfilerGuardBuilder::default()
+        .frequency(frequency)
+        .blocklist(&["libc", "libgcc", "pthread", "vdso"])
+        .build()
+        .map_err(|e| e.to_string())?;
+    std::thread::sleep(duration);
+
+    let report = guard.report().build().map_err(|e| e.to_string())?;
+    let profile = report.pprof().map_err(|e| e.to_string())?;
+    let mut body = Vec::new();
+    profile.encode(&mut body).map_err(|e| e.to_string())?;
+    Ok(body)
+}
+
+#[cfg(not(all(feature = "profiling", unix)))]
+fn cpu_profile(_duration: Duration, _frequency: i32) -> Result<Vec<u8>, String> {
+    Err("CPU profiling needs a Unix build with the `profiling` feature".to_string())
+}
+
+/// Ticks per second of the CPU times in `/proc/*/stat`
+#[cfg(target_os = "linux")]
+fn clock_ticks() -> f64 {
+    match unsafe { libc::sysconf(libc::_SC_CLK_TCK) } {
+        ticks if ticks > 0 => ticks as f64,
+        _ => 100.0,
+    }
+}
+
+/// One thread's name, state and CPU seconds from its `/proc` entries
+#[derive(Debug, Clone, PartialEq)]
+struct ThreadInfo {
+    tid: u32,
+    name: String,
+    state: String,
+    cpu_seconds: f64,
+}
+
+/// Parse a `/proc/<pid>/task/<tid>/stat` line. The name is in parentheses
+/// and may itself contain spaces or parentheses, so fields are counted from
+/// the last `)`.
+fn parse_thread_stat(tid: u32, stat: &str, ticks_per_second: f64) -> Option<ThreadInfo> {
+    let open = stat.find('(')?;
+    let close = stat.rfind(')')?;
+    let name = stat.get(open + 1..close)?.to_string();
+    let fields: Vec<&str> = stat.get(close + 1..)?.split_whitespace().collect();
+    let utime: u64 = fields.get(11)?.parse().ok()?;
+    let stime: u64 = fields.get(12)?.parse().ok()?;
+    Some(ThreadInfo {
+        tid,
+        name,
+        state: fields.first()?.to_string(),
+        cpu_seconds: (utime + stime) as f64 / ticks_per_second,
+    })
+}
+
+fn render_threads(mut threads: Vec<ThreadInfo>) -> String {
+    threads.sort_by(|a, b| b.cpu_seconds.total_cmp(&a.cpu_seconds));
+    let mut report = format!("threads: {}\n\n", threads.len());
+    for thread in threads {
+        report.push_str(&format!(
+            "tid {} \"{}\" state={} cpu={:.2}s\n",
+            thread.tid, thread.name, thread.state, thread.cpu_seconds
+        ));
+    }
+    report
+}
+
+#[cfg(target_os = "linux")]
+fn thread_report() -> Result<String, String> {
+    let ticks = clock_ticks();
+    let entries = std::fs::read_dir("/proc/self/task").map_err(|e| e.to_string())?;
+    let threads = entries
+        .flatten()
+        .filter_map(|entry| {
+            let tid: u32 = entry.file_name().to_str()?.parse().ok()?;
+            let stat = std::fs::read_to_string(entry.path().join("stat")).ok()?;
+            parse_thread_stat(tid, &stat, ticks)
+        })
+        .collect();
+    Ok(render_threads(threads))
+}
+
+#[cfg(not(target_os = "linux"))]
+fn thread_report() -> Result<String, String> {
+    Err("Thread reports read /proc and are only available on Linux".to_string())
+}
+
+/// The memory lines of `/proc/self/status`
+fn render_memory(status: &str) -> String {
+    let mut report = String::from("memory:\n\n");
+    for line in status.lines() {
+        if line.starts_with("Vm") || line.starts_with("Rss") || line.starts_with("Threads") {
+            report.push_str(line);
+            report.push('\n');
+        }
+    }
+    report
+}
+
+#[cfg(target_os = "linux")]
+fn heap_report() -> Result<String, String> {
+    let status = std::fs::read_to_string("/proc/self/status").map_err(|e| e.to_string())?;
+    Ok(render_memory(&status))
+}
+
+#[cfg(not(target_os = "linux"))]
+fn heap_report() -> Result<String, String> {
+    Err("Memory reports read /proc and are only available on Linux".to_string())
+}
+
+fn profile_error(status: StatusCode, message: String, param: &str, code: &str) -> Response {
+    (
+        status,
+        Json(json!({
+            "error": {
+                "message": message,
+                "type": "invalid_request_error",
+                "param": param,
+                "code": code
+            }
+        })),
+    )
+        .into_response()
+}
+
+fn attachment(body: Vec<u8>, content_type: &'static str, file_name: &str) -> Response {
+    let mut response = body.into_response();
+    let headers = response.headers_mut();
+    headers.insert(header::CONTENT_TYPE, HeaderValue::from_static(content_type));
+    if let Ok(value) = HeaderValue::from_str(&format!("attachment; filename=\"{}\"", file_name)) {
+        headers.insert(header::CONTENT_DISPOSITION, value);
+    }
+    response
+}
+
+// API Handlers
+
+/// `GET /admin/profile` - the profiles this server can produce (admin only)
+pub async fn list_profiles(headers: HeaderMap) -> Response {
+    if let Err(response) = authorize_admin(&headers) {
+        return response;
+    }
+
+    let data: Vec<_> = ProfileKind::ALL
+        .iter()
+        .map(|kind| {
+            json!({
+                "kind": kind,
+                "format": kind.format(),
+                "supported": kind.supported(),
+                "description": kind.description()
+            })
+        })
+        .collect();
+    Json(json!({ "object": "list", "data": data })).into_response()
+}
+
+/// `GET /admin/profile/:kind` - capture a profile (admin only). CPU
+/// profiles hold the request open for `seconds`.
+pub async fn get_profile(
+    Path(kind): Path<String>,
+    headers: HeaderMap,
+    Query(params): Query<ProfileParams>,
+) -> Response {
+    if let Err(response) = authorize_admin(&headers) {
+        return response;
+    }
+
+    let Some(kind) = ProfileKind::parse(&kind) else {
+        return profile_error(
+            StatusCode::NOT_FOUND,
+            format!("Unknown profile '{}'; expected cpu, threads or heap", kind),
+            "kind",
+            "profile_not_found",
+        );
+    };
+    if !kind.supported() {
+        let message = match kind {
+            ProfileKind::Cpu => {
+                "CPU profiling needs a Unix build with the `profiling` feature".to_string()
+            }
+            _ => format!("The {} report is only available on Linux", kind.as_str()),
+        };
+        return profile_error(
+            StatusCode::NOT_IMPLEMENTED,
+            message,
+            "kind",
+            "profile_not_supported",
+        );
+    }
+
+    match kind {
+        ProfileKind::Cpu => {
+            let seconds = params.seconds.unwrap_or(DEFAULT_PROFILE_SECONDS);
+            if seconds == 0 || seconds > MAX_PROFILE_SECONDS {
+                return profile_error(
+                    StatusCode::BAD_REQUEST,
+                    format!("seconds must be between 1 and {}", MAX_PROFILE_SECONDS),
+                    "seconds",
+                    "invalid_profile_duration",
+                );
+            }
+            let frequency = params.frequency.unwrap_or(DEFAULT_FREQUENCY);
+            if !(1..=MAX_FREQUENCY).contains(&frequency) {
+                return profile_error(
+                    StatusCode::BAD_REQUEST,
+                    format!("frequency must be between 1 and {}", MAX_FREQUENCY),
+                    "frequency",
+                    "invalid_profile_frequency",
+                );
+            }
+            let Some(slot) = CpuProfileSlot::acquire() else {
+                return profile_error(
+                    StatusCode::CONFLICT,
+                    "A CPU profile is already running; retry when it finishes".to_string(),
+                    "kind",
+                    "profile_in_progress",
+                );
+            };
+
+            info!("CPU profile started for {}s at {} Hz", seconds, frequency);
+            let duration = Duration::from_secs(seconds);
+            let result = tokio::task::spawn_blocking(move || {
+                let _slot = slot;
+                cpu_profile(duration, frequency)
+            })
+            .await
+            .map_err(|e| e.to_string())
+            .and_then(|result| result);
+
+            match result {
+                Ok(body) => attachment(body, "application/octet-stream", "cpu.pb"),
+                Err(e) => profile_error(
+                    StatusCode::INTERNAL_SERVER_ERROR,
+                    format!("CPU profile failed: {}", e),
+                    "kind",
+                    "profile_failed",
+                ),
+            }
+        }
+        ProfileKind::Threads | ProfileKind::Heap => {
+            let report = if kind == ProfileKind::Threads {
+                thread_report()
+            } else {
+                heap_report()
+            };
+            match report {
+                Ok(report) => attachment(
+                    report.into_bytes(),
+                    "text/plain; charset=utf-8",
+                    &format!("{}.txt", kind.as_str()),
+                ),
+                Err(e) => profile_error(
+                    StatusCode::INTERNAL_SERVER_ERROR,
+                    format!("{} report failed: {}", kind.as_str(), e),
+                    "kind",
+                    "profile_failed",
+                ),
+            }
+        }
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_parse_thread_stat() {
+        let stat = "4242 (tokio-runtime (w)) S 1 4242 4242 0 -1 4194368 10 0 0 0 250 50 0 0 20 0 12 0 100 0 0";
+        let thread = parse_thread_stat(4242, stat, 100.0).unwrap();
+        assert_eq!(thread.name, "tokio-runtime (w)");
+        assert_eq!(thread.state, "S");
+        assert_eq!(thread.cpu_seconds, 3.0);
+        assert!(parse_thread_stat(1, "garbage", 100.0).is_none());
+    }
+
+    #[test]
+    fn test_thread_report_sorts_by_cpu() {
+        let thread = |tid, cpu_seconds| ThreadInfo {
+            tid,
+            name: format!("t{}", tid),
+            state: "R".to_string(),
+            cpu_seconds,
+        };
+        let report = render_threads(vec![thread(1, 0.5), thread(2, 4.0)]);
+        assert!(report.starts_with("threads: 2\n"));
+        assert!(report.find("tid 2").unwrap() < report.find("tid 1").unwrap());
+    }
+
+    #[test]
+    fn test_memory_report_keeps_memory_lines() {
+        let status =
+            "Name:\tinferno\nVmRSS:\t  1024 kB\nRssAnon:\t 900 kB\nThreads:\t12\nUid:\t0\n";
+        let report = render_memory(status);
+        assert!(report.contains("VmRSS"));
+        assert!(report.contains("RssAnon"));
+        assert!(report.contains("Threads"));
+        assert!(!report.contains("Uid"));
+    }
+
+    #[test]
+    fn test_one_cpu_profile_at_a_time() {
+        let slot = CpuProfileSlot::acquire().unwrap();
+        assert!(CpuProfileSlot::acquire().is_none());
+        drop(slot);
+        assert!(CpuProfileSlot::acquire().is_some());
+    }
+}
@@ -0,0 +1,603 @@
+//! Request Queue Introspection
+//!
+//! Tracks every inference request from arrival until its response completes so
+//! operators can see queue depth per model and priority, the estimated wait
+//! for new work and the age of the oldest waiting request. Exposed through
+//! `GET /v1/queue/stats` and the admin-only `GET /v1/queue/requests`.
+//!
+//! A request stays queued until the [`Scheduler`] admits it; see
+//! [`crate::api::scheduler`] for priority classes and fair share.
+
+use crate::{
+    api::{
+        admin::authorize_admin,
+        api_keys::bearer_digest,
+        cancellation::{CancelSignal, request_id_from_headers},
+        scheduler::{DEFAULT_TENANT, PRIORITY_CLASS_HEADER, Scheduler, TENANT_HEADER},
+    },
+    cli::serve::ServerState,
+    operations::queue::Priority,
+};
+use axum::{
+    Json,
+    extract::State,
+    http::{HeaderMap, StatusCode},
+    response::{IntoResponse, Response},
+};
+use serde::{Deserialize, Serialize};
+use std::{
+    collections::{BTreeMap, HashMap},
+    sync::{Arc, Mutex},
+    time::Instant,
+};
+use uuid::Uuid;
+
+/// Header clients use to tag a request with a priority class
+pub const PRIORITY_HEADER: &str = "x-inferno-priority";
+
+/// Smoothing factor for the per-model service time moving average
+const SERVICE_TIME_ALPHA: f64 = 0.2;
+
+/// Lifecycle state of a tracked request
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Serialize, Deserialize)]
+#[serde(rename_all = "snake_case")]
+pub enum RequestState {
+    /// Waiting for a backend to pick the request up
+    Queued,
+    /// Currently generating
+    Running,
+}
+
+#[derive(Debug, Clone)]
+struct QueueEntry {
+    model: String,
+    priority: Priority,
+    state: RequestState,
+    enqueued_at: Instant,
+    enqueued_at_utc: chrono::DateTime<chrono::Utc>,
+    started_at: Option<Instant>,
+    cancel: Arc<CancelSignal>,
+    tenant: String,
+    /// Digest of the bearer token the request was made with; only that
+    /// token, or the admin token, may cancel it
+    owner: Option<String>,
+}
+
+/// Per-model queue statistics
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct ModelQueueStats {
+    pub model: String,
+    pub queued: usize,
+    pub running: usize,
+    pub by_priority: BTreeMap<String, usize>,
+    pub estimated_wait_ms: u64,
+    pub oldest_request_age_ms: u64,
+    pub avg_service_time_ms: u64,
+}
+
+/// Aggregate queue statistics returned by `/v1/queue/stats`
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct QueueStatsResponse {
+    pub total_queued: usize,
+    pub total_running: usize,
+    pub by_priority: BTreeMap<String, usize>,
+    pub oldest_request_age_ms: u64,
+    pub models: Vec<ModelQueueStats>,
+    pub timestamp: chrono::DateTime<chrono::Utc>,
+}
+
+/// A single tracked request returned by `/v1/queue/requests`
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct QueuedRequestInfo {
+    pub request_id: String,
+    pub model: String,
+    pub priority: Priority,
+    pub state: RequestState,
+    pub enqueued_at: chrono::DateTime<chrono::Utc>,
+    pub age_ms: u64,
+}
+
+/// Tracker of in-flight inference requests
+#[derive(Debug, Default)]
+pub struct RequestQueue {
+    entries: Mutex<HashMap<String, QueueEntry>>,
+    service_time_ms: Mutex<HashMap<String, f64>>,
+    scheduler: Scheduler,
+}
+
+impl RequestQueue {
+    pub fn new() -> Self {
+        Self::default()
+    }
+
+    /// Register a request the server started itself. The returned ticket
+    /// removes the request from the queue when dropped, so it must live as
+    /// long as the response.
+    ///
+    /// `request_id` is used unless another in-flight request already holds
+    /// it, in which case a fresh UUID is assigned. Only the admin token can
+    /// cancel the request.
+    pub fn enqueue(
+        self: &Arc<Self>,
+        request_id: Option<String>,
+        model: &str,
+        priority: Priority,
+    ) -> QueueTicket {
+        let mut entries = self.entries.lock().unwrap();
+        let id = match request_id {
+            Some(id) if !entries.contains_key(&id) => id,
+            _ => Uuid::new_v4().to_string(),
+        };
+        self.insert(&mut entries, id, None, model, priority)
+    }
+
+    /// Register a client's request, taking its ID from the `X-Request-ID`
+    /// header if set and noting the bearer token it was made with, which is
+    /// then needed to cancel it. An ID already in flight is refused with
+    /// `409` rather than replaced, so a client never cancels by an ID that
+    /// names someone else's request.
+    pub fn enqueue_request(
+        self: &Arc<Self>,
+        headers: &HeaderMap,
+        model: &str,
+        priority: Priority,
+    ) -> Result<QueueTicket, Response> {
+        let mut entries = self.entries.lock().unwrap();
+        let id = match request_id_from_headers(headers) {
+            Some(id) if entries.contains_key(&id) => return Err(request_id_in_use(&id)),
+            Some(id) => id,
+            None => Uuid::new_v4().to_string(),
+        };
+        Ok(self.insert(&mut entries, id, bearer_digest(headers), model, priority))
+    }
+
+    fn insert(
+        self: &Arc<Self>,
+        entries: &mut HashMap<String, QueueEntry>,
+        id: String,
+        owner: Option<String>,
+        model: &str,
+        priority: Priority,
+    ) -> QueueTicket {
+        let cancel = Arc::new(CancelSignal::new());
+        entries.insert(
+            id.clone(),
+            QueueEntry {
+                model: model.to_string(),
+                priority,
+                state: RequestState::Queued,
+                enqueued_at: Instant::now(),
+                enqueued_at_utc: chrono::Utc::now(),
+                started_at: None,
+                cancel: Arc::clone(&cancel),
+                tenant: DEFAULT_TENANT.to_string(),
+                owner,
+            },
+        );
+
+        QueueTicket {
+            queue: Arc::clone(self),
+            id,
+            cancel,
+            deadline: None,
+            priority,
+            class: None,
+            tenant: None,
+        }
+    }
+
+    /// Signal cancellation of a tracked request. Returns false when no
+    /// request with that ID is in flight.
+    pub fn cancel(&self, id: &str) -> bool {
+        match self.entries.lock().unwrap().get(id) {
+            Some(entry) => {
+                entry.cancel.cancel();
+                true
+            }
+            None => false,
+        }
+    }
+
+    /// Cancel a tracked request on behalf of the caller whose headers are
+    /// given: the admin, or the client whose bearer token made the request.
+    /// Returns false, as for an unknown ID, when the caller may not.
+    pub fn cancel_for(&self, id: &str, headers: &HeaderMap) -> bool {
+        let entries = self.entries.lock().unwrap();
+        let Some(entry) = entries.get(id) else {
+            return false;
+        };
+        let owns = entry.owner.is_some() && entry.owner == bearer_digest(headers);
+        if !owns && authorize_admin(headers).is_err() {
+            return false;
+        }
+        entry.cancel.cancel();
+        true
+    }
+
+    fn mark_running(&self, id: &str) {
+        if let Some(entry) = self.entries.lock().unwrap().get_mut(id) {
+            entry.state = RequestState::Running;
+            entry.started_at = Some(Instant::now());
+        }
+    }
+
+    fn finish(&self, id: &str) {
+        self.scheduler.release(id);
+        let entry = self.entries.lock().unwrap().remove(id);
+        if let Some(started_at) = entry.as_ref().and_then(|e| e.started_at) {
+            let elapsed_ms = started_at.elapsed().as_secs_f64() * 1000.0;
+            let mut service = self.service_time_ms.lock().unwrap();
+            let model = entry.map(|e| e.model).unwrap_or_default();
+            let avg = service.entry(model).or_insert(elapsed_ms);
+            *avg = SERVICE_TIME_ALPHA * elapsed_ms + (1.0 - SERVICE_TIME_ALPHA) * *avg;
+        }
+    }
+
+    /// Admission control deciding when queued requests start
+    pub fn scheduler(&self) -> &Scheduler {
+        &self.scheduler
+    }
+
+    /// Number of requests currently tracked, queued or running
+    pub fn len(&self) -> usize {
+        self.entries.lock().unwrap().len()
+    }
+
+    /// Number of requests one tenant has tracked, queued or running
+    pub fn tenant_len(&self, tenant: &str) -> usize {
+        self.entries
+            .lock()
+            .unwrap()
+            .values()
+            .filter(|entry| entry.tenant == tenant)
+            .count()
+    }
+
+    /// Compute aggregate and per-model statistics
+    pub fn stats(&self) -> QueueStatsResponse {
+        let entries = self.entries.lock().unwrap();
+        let service = self.service_time_ms.lock().unwrap();
+
+        let mut models: BTreeMap<String, ModelQueueStats> = BTreeMap::new();
+        let mut by_priority = BTreeMap::new();
+        let mut total_queued = 0;
+        let mut total_running = 0;
+        let mut oldest_ms = 0;
+
+        for entry in entries.values() {
+            let stats = models
+                .entry(entry.model.clone())
+                .or_insert_with(|| ModelQueueStats {
+                    model: entry.model.clone(),
+                    queued: 0,
+                    running: 0,
+                    by_priority: BTreeMap::new(),
+                    estimated_wait_ms: 0,
+                    oldest_request_age_ms: 0,
+                    avg_service_time_ms: 0,
+                });
+
+            match entry.state {
+                RequestState::Queued => {
+                    let age_ms = entry.enqueued_at.elapsed().as_millis() as u64;
+                    let priority = priority_label(entry.priority);
+                    stats.queued += 1;
+                    stats.oldest_request_age_ms = stats.oldest_request_age_ms.max(age_ms);
+                    *stats.by_priority.entry(priority.clone()).or_insert(0) += 1;
+                    *by_priority.entry(priority).or_insert(0) += 1;
+                    total_queued += 1;
+                    oldest_ms = oldest_ms.max(age_ms);
+                }
+                RequestState::Running => {
+                    stats.running += 1;
+                    total_running += 1;
+                }
+            }
+        }
+
+        for stats in models.values_mut() {
+            let avg = service.get(&stats.model).copied().unwrap_or(0.0);
+            stats.avg_service_time_ms = avg as u64;
+            stats.estimated_wait_ms = estimate_wait_ms(stats.queued, stats.running, avg);
+        }
+
+        QueueStatsResponse {
+            total_queued,
+            total_running,
+            by_priority,
+            oldest_request_age_ms: oldest_ms,
+            models: models.into_values().collect(),
+            timestamp: chrono::Utc::now(),
+        }
+    }
+
+    /// List tracked requests, oldest first
+    pub fn list(&self) -> Vec<QueuedRequestInfo> {
+        let entries = self.entries.lock().unwrap();
+        let mut requests: Vec<QueuedRequestInfo> = entries
+            .iter()
+            .map(|(id, entry)| QueuedRequestInfo {
+                request_id: id.clone(),
+                model: entry.model.clone(),
+                priority: entry.priority,
+                state: entry.state,
+                enqueued_at: entry.enqueued_at_utc,
+                age_ms: entry.enqueued_at.elapsed().as_millis() as u64,
+            })
+            .collect();
+        requests.sort_by(|a, b| b.age_ms.cmp(&a.age_ms));
+        requests
+    }
+}
+
+/// Handle for a tracked request; dropping it removes the request
+#[derive(Debug)]
+pub struct QueueTicket {
+    queue: Arc<RequestQueue>,
+    id: String,
+    cancel: Arc<CancelSignal>,
+    deadline: Option<tokio::time::Instant>,
+    priority: Priority,
+    class: Option<String>,
+    tenant: Option<String>,
+}
+
+impl QueueTicket {
+    pub fn id(&self) -> &str {
+        &self.id
+    }
+
+    /// Signal fired when the request is cancelled through the API
+    pub fn cancel_signal(&self) -> &CancelSignal {
+        &self.cancel
+    }
+
+    /// Attach the client's deadline, after which generation is cut short
+    pub fn with_deadline(mut self, deadline: Option<tokio::time::Instant>) -> Self {
+        self.deadline = deadline;
+        self
+    }
+
+    pub fn deadline(&self) -> Option<tokio::time::Instant> {
+        self.deadline
+    }
+
+    /// Attach the scheduling class and tenant: `class` if given, else the
+    /// `X-Inferno-Priority-Class` header, and the `X-Inferno-Tenant` header
+    pub fn with_scheduling(mut self, class: Option<&str>, headers: &HeaderMap) -> Self {
+        self.class = class
+            .map(str::to_string)
+            .or_else(|| header_value(headers, PRIORITY_CLASS_HEADER));
+        self.tenant = tenant_from_headers(headers);
+        if let Some(tenant) = &self.tenant
+            && let Some(entry) = self.queue.entries.lock().unwrap().get_mut(&self.id)
+        {
+            entry.tenant = tenant.clone();
+        }
+        self
+    }
+
+    /// Wait for the scheduler to admit the request, then record that a
+    /// backend has started working on it
+    pub async fn start(&self) {
+        let scheduler = &self.queue.scheduler;
+        let class = scheduler.resolve_class(self.class.as_deref(), self.priority);
+        let tenant = self.tenant.as_deref().unwrap_or(DEFAULT_TENANT).to_string();
+        scheduler.admit(&self.id, class, tenant, &self.cancel).await;
+        self.queue.mark_running(&self.id);
+    }
+}
+
+impl Drop for QueueTicket {
+    fn drop(&mut self) {
+        self.queue.finish(&self.id);
+    }
+}
+
+fn request_id_in_use(id: &str) -> Response {
+    (
+        StatusCode::CONFLICT,
+        Json(serde_json::json!({
+            "error": {
+                "message": format!("Request ID '{}' is already in use by an in-flight request", id),
+                "type": "invalid_request_error",
+                "param": "x-request-id",
+                "code": "request_id_in_use"
+            }
+        })),
+    )
+        .into_response()
+}
+
+/// Estimate how long a newly queued request would wait for a backend.
+///
+/// Requests are served one at a time per model, so the wait is the work
+/// ahead of it multiplied by the observed average service time.
+fn estimate_wait_ms(queued: usize, running: usize, avg_service_ms: f64) -> u64 {
+    ((queued + running) as f64 * avg_service_ms) as u64
+}
+
+fn priority_label(priority: Priority) -> String {
+    format!("{:?}", priority).to_lowercase()
+}
+
+fn header_value(headers: &HeaderMap, name: &str) -> Option<String> {
+    headers
+        .get(name)
+        .and_then(|v| v.to_str().ok())
+        .map(|v| v.trim().to_string())
+        .filter(|v| !v.is_empty())
+}
+
+/// The tenant named by the `X-Inferno-Tenant` header, if any. Past
+/// [`authenticate_tenant`](crate::api::tenants::authenticate_tenant) this
+/// is the tenant of the request's credential.
+pub fn tenant_from_headers(headers: &HeaderMap) -> Option<String> {
+    header_value(headers, TENANT_HEADER)
+}
+
+/// Read the request priority from the `X-Inferno-Priority` header.
+///
+/// Accepts names (`low`, `normal`, `high`, `vip`) or their numeric values
+/// (1-4); anything else falls back to `Priority::Normal`.
+pub fn priority_from_headers(headers: &HeaderMap) -> Priority {
+    let value = match headers.get(PRIORITY_HEADER).and_then(|v| v.to_str().ok()) {
+        Some(value) => value.trim().to_lowercase(),
+        None => return Priority::Normal,
+    };
+
+    match value.as_str() {
+        "low" => Priority::Low,
+        "normal" => Priority::Normal,
+        "high" => Priority::High,
+        "vip" => Priority::VIP,
+        other => other
+            .parse::<u8>()
+            .ok()
+            .and_then(Priority::from_u8)
+            .unwrap_or(Priority::Normal),
+    }
+}
+
+// API Handlers
+
+/// `GET /v1/queue/stats` - aggregate queue depth and wait estimates
+pub async fn queue_stats(State(state): State<Arc<ServerState>>) -> impl IntoResponse {
+    Json(state.request_queue.stats())
+}
+
+/// `GET /v1/queue/requests` - list tracked request IDs (admin only)
+pub async fn queue_requests(State(state): State<Arc<ServerState>>, headers: HeaderMap) -> Response {
+    if let Err(response) = authorize_admin(&headers) {
+        return response;
+    }
+
+    let requests = state.request_queue.list();
+    Json(serde_json::json!({
+        "object": "list",
+        "total": requests.len(),
+        "data": requests
+    }))
+    .into_response()
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use axum::http::HeaderValue;
+
+    #[tokio::test]
+    async fn test_ticket_lifecycle() {
+        let queue = Arc::new(RequestQueue::new());
+        let ticket = queue.enqueue(None, "llama", Priority::High);
+        let stats = queue.stats();
+        assert_eq!(stats.total_queued, 1);
+        assert_eq!(stats.by_priority.get("high"), Some(&1));
+
+        ticket.start().await;
+        let stats = queue.stats();
+        assert_eq!(stats.total_queued, 0);
+        assert_eq!(stats.total_running, 1);
+
+        drop(ticket);
+        assert_eq!(queue.len(), 0);
+    }
+
+    #[test]
+    fn test_list_contains_ticket_ids() {
+        let queue = Arc::new(RequestQueue::new());
+        let first = queue.enqueue(Some("req-a".to_string()), "a", Priority::Normal);
+        let second = queue.enqueue(Some("req-a".to_string()), "b", Priority::Low);
+        assert_eq!(first.id(), "req-a");
+        assert_ne!(second.id(), "req-a");
+
+        let ids: Vec<String> = queue.list().into_iter().map(|r| r.request_id).collect();
+        assert!(ids.contains(&first.id().to_string()));
+        assert!(ids.contains(&second.id().to_string()));
+    }
+
+    #[test]
+    fn test_cancel_signals_ticket() {
+        let queue = Arc::new(RequestQueue::new());
+        let ticket = queue.enqueue(Some("req-1".to_string()), "llama", Priority::Normal);
+
+        assert!(!queue.cancel("unknown"));
+        assert!(queue.cancel("req-1"));
+        assert!(ticket.cancel_signal().is_cancelled());
+    }
+
+    #[test]
+    fn test_enqueue_request_refuses_id_in_flight() {
+        let queue = Arc::new(RequestQueue::new());
+        let mut headers = HeaderMap::new();
+        headers.insert("x-request-id", HeaderValue::from_static("req-a"));
+
+        let first = queue
+            .enqueue_request(&headers, "llama", Priority::Normal)
+            .unwrap();
+        assert_eq!(first.id(), "req-a");
+        let second = queue.enqueue_request(&headers, "llama", Priority::Normal);
+        assert_eq!(second.err().unwrap().status(), StatusCode::CONFLICT);
+    }
+
+    #[test]
+    fn test_cancel_for_needs_owner_token() {
+        let queue = Arc::new(RequestQueue::new());
+        let mut owner = HeaderMap::new();
+        owner.insert(
+            "authorization",
+            HeaderValue::from_static("Bearer owner-key"),
+        );
+        owner.insert("x-request-id", HeaderValue::from_static("req-1"));
+        let ticket = queue
+            .enqueue_request(&owner, "llama", Priority::Normal)
+            .unwrap();
+
+        let mut other = HeaderMap::new();
+        other.insert(
+            "authorization",
+            HeaderValue::from_static("Bearer other-key"),
+        );
+        assert!(!queue.cancel_for("req-1", &other));
+        assert!(!queue.cancel_for("req-1", &HeaderMap::new()));
+        assert!(!ticket.cancel_signal().is_cancelled());
+
+        assert!(queue.cancel_for("req-1", &owner));
+        assert!(ticket.cancel_signal().is_cancelled());
+    }
+
+    #[test]
+    fn test_tenant_len_follows_header() {
+        let queue = Arc::new(RequestQueue::new());
+        let mut headers = HeaderMap::new();
+        headers.insert(TENANT_HEADER, "acme".parse().unwrap());
+        let _acme = queue
+            .enqueue(None, "llama", Priority::Normal)
+            .with_scheduling(None, &headers);
+        let _other = queue.enqueue(None, "llama", Priority::Normal);
+
+        assert_eq!(queue.tenant_len("acme"), 1);
+        assert_eq!(queue.tenant_len(DEFAULT_TENANT), 1);
+    }
+
+    #[test]
+    fn test_estimate_wait() {
+        assert_eq!(estimate_wait_ms(0, 0, 250.0), 0);
+        assert_eq!(estimate_wait_ms(3, 1, 250.0), 1000);
+    }
+
+    #[test]
+    fn test_priority_from_headers() {
+        let mut headers = HeaderMap::new();
+        assert_eq!(priority_from_headers(&headers), Priority::Normal);
+
+        headers.insert(PRIORITY_HEADER, HeaderValue::from_static("VIP"));
+        assert_eq!(priority_from_headers(&headers), Priority::VIP);
+
+        headers.insert(PRIORITY_HEADER, HeaderValue::from_static("1"));
+        assert_eq!(priority_from_headers(&headers), Priority::Low);
+
+        headers.insert(PRIORITY_HEADER, HeaderValue::from_static("urgent"));
+        assert_eq!(priority_from_headers(&headers), Priority::Normal);
+    }
+}
@@ -0,0 +1,702 @@
+//! Canary Model Rollouts
+//!
+//! A rollout shifts traffic for a routing alias from a baseline model to a
+//! candidate in steps (by default 1% → 10% → 100%). The controller advances
+//! once a step has run long enough with enough candidate traffic, and rolls
+//! back to the baseline as soon as the candidate's error rate or latency
+//! regresses past the configured limits. Progress is published as events on
+//! `GET /v1/rollouts/:rollout_id/events` (server-sent events).
+
+use crate::{
+    api::{
+        admin::authorize_admin,
+        invalid_request,
+        routing::{RouteArm, RoutedModel, RoutingRule},
+    },
+    cli::serve::ServerState,
+};
+use axum::{
+    Json,
+    extract::{Path, State},
+    http::{HeaderMap, StatusCode},
+    response::{
+        IntoResponse, Response,
+        sse::{Event, KeepAlive, Sse},
+    },
+};
+use serde::{Deserialize, Serialize};
+use serde_json::json;
+use std::{collections::HashMap, sync::Arc, time::Duration};
+use tokio::sync::{RwLock, broadcast};
+use tracing::{info, warn};
+use uuid::Uuid;
+
+/// How often the controller re-evaluates active rollouts
+pub const EVALUATION_INTERVAL: Duration = Duration::from_secs(5);
+
+/// Buffered events per subscriber before slow readers start missing some
+const EVENT_CHANNEL_CAPACITY: usize = 256;
+
+fn default_steps() -> Vec<u32> {
+    vec![1, 10, 100]
+}
+
+fn default_step_duration_secs() -> u64 {
+    300
+}
+
+fn default_min_requests_per_step() -> u64 {
+    20
+}
+
+fn default_max_error_rate_increase() -> f64 {
+    0.05
+}
+
+fn default_max_latency_ratio() -> f64 {
+    1.5
+}
+
+/// Parameters of a rollout, supplied when it is created
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct RolloutSpec {
+    /// Routing alias clients call; its rule is managed by the rollout
+    pub alias: String,
+    pub baseline_model: String,
+    pub candidate_model: String,
+    /// Candidate traffic percentage for each step, ending at 100
+    #[serde(default = "default_steps")]
+    pub steps: Vec<u32>,
+    #[serde(default = "default_step_duration_secs")]
+    pub step_duration_secs: u64,
+    /// Candidate requests needed before a step can be judged
+    #[serde(default = "default_min_requests_per_step")]
+    pub min_requests_per_step: u64,
+    /// Roll back when the candidate's error rate exceeds the baseline's by more than this
+    #[serde(default = "default_max_error_rate_increase")]
+    pub max_error_rate_increase: f64,
+    /// Roll back when the candidate's mean latency exceeds the baseline's by this factor
+    #[serde(default = "default_max_latency_ratio")]
+    pub max_latency_ratio: f64,
+}
+
+impl RolloutSpec {
+    pub fn validate(&self) -> Result<(), String> {
+        if self.alias.trim().is_empty() {
+            return Err("alias must not be empty".to_string());
+        }
+        if self.baseline_model == self.candidate_model {
+            return Err("candidate_model must differ from baseline_model".to_string());
+        }
+        if self.alias == self.baseline_model || self.alias == self.candidate_model {
+            return Err("alias must differ from both models".to_string());
+        }
+        if self.steps.is_empty() || self.steps.last() != Some(&100) {
+            return Err("steps must end at 100".to_string());
+        }
+        if self.steps.windows(2).any(|w| w[0] >= w[1]) || self.steps[0] == 0 {
+            return Err("steps must be strictly increasing percentages above 0".to_string());
+        }
+        if self.max_latency_ratio < 1.0 {
+            return Err("max_latency_ratio must be at least 1.0".to_string());
+        }
+        if !(0.0..=1.0).contains(&self.max_error_rate_increase) {
+            return Err("max_error_rate_increase must be between 0.0 and 1.0".to_string());
+        }
+        Ok(())
+    }
+}
+
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Serialize, Deserialize)]
+#[serde(rename_all = "snake_case")]
+pub enum RolloutState {
+    Progressing,
+    Completed,
+    RolledBack,
+}
+
+/// Observed request outcomes for one arm
+#[derive(Debug, Clone, Default, Serialize, Deserialize)]
+pub struct ArmHealth {
+    pub requests: u64,
+    pub errors: u64,
+    pub error_rate: f64,
+    pub avg_latency_ms: f64,
+}
+
+impl ArmHealth {
+    fn record(&mut self, latency: Duration, error: bool) {
+        let latency_ms = latency.as_secs_f64() * 1000.0;
+        self.avg_latency_ms =
+            (self.avg_latency_ms * self.requests as f64 + latency_ms) / (self.requests + 1) as f64;
+        self.requests += 1;
+        if error {
+            self.errors += 1;
+        }
+        self.error_rate = self.errors as f64 / self.requests as f64;
+    }
+}
+
+/// Progress notification published to event subscribers
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct RolloutEvent {
+    pub rollout_id: String,
+    pub state: RolloutState,
+    pub step: usize,
+    pub candidate_percent: u32,
+    pub message: String,
+    pub timestamp: chrono::DateTime<chrono::Utc>,
+}
+
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct Rollout {
+    pub id: String,
+    pub spec: RolloutSpec,
+    pub state: RolloutState,
+    pub step: usize,
+    pub candidate_percent: u32,
+    pub step_started_at: chrono::DateTime<chrono::Utc>,
+    pub created_at: chrono::DateTime<chrono::Utc>,
+    pub finished_at: Option<chrono::DateTime<chrono::Utc>>,
+    /// Baseline outcomes across the whole rollout, the reference for regressions
+    pub baseline: ArmHealth,
+    /// Candidate outcomes during the current step
+    pub candidate: ArmHealth,
+    pub reason: Option<String>,
+}
+
+impl Rollout {
+    fn new(spec: RolloutSpec) -> Self {
+        let now = chrono::Utc::now();
+        Self {
+            id: format!("rollout-{}", Uuid::new_v4()),
+            candidate_percent: spec.steps[0],
+            spec,
+            state: RolloutState::Progressing,
+            step: 0,
+            step_started_at: now,
+            created_at: now,
+            finished_at: None,
+            baseline: ArmHealth::default(),
+            candidate: ArmHealth::default(),
+            reason: None,
+        }
+    }
+
+    /// Routing rule matching the rollout's current traffic split
+    pub fn routing_rule(&self) -> RoutingRule {
+        let arm = |model: &str, weight: u32| RouteArm {
+            model: model.to_string(),
+            weight,
+        };
+        let arms = match (self.state, self.candidate_percent) {
+            (RolloutState::RolledBack, _) => vec![arm(&self.spec.baseline_model, 100)],
+            (_, 100) => vec![arm(&self.spec.candidate_model, 100)],
+            (_, percent) => vec![
+                arm(&self.spec.baseline_model, 100 - percent),
+                arm(&self.spec.candidate_model, percent),
+            ],
+        };
+
+        RoutingRule {
+            alias: self.spec.alias.clone(),
+            arms,
+            description: Some(format!("Managed by rollout {}", self.id)),
+            created_at: self.created_at,
+        }
+    }
+
+    fn event(&self, message: String) -> RolloutEvent {
+        RolloutEvent {
+            rollout_id: self.id.clone(),
+            state: self.state,
+            step: self.step,
+            candidate_percent: self.candidate_percent,
+            message,
+            timestamp: chrono::Utc::now(),
+        }
+    }
+}
+
+/// What the controller should do with a rollout
+#[derive(Debug, Clone, PartialEq)]
+pub enum RolloutDecision {
+    Hold,
+    Advance,
+    Complete,
+    RollBack(String),
+}
+
+/// Judge the current step of a progressing rollout
+pub fn evaluate(rollout: &Rollout, now: chrono::DateTime<chrono::Utc>) -> RolloutDecision {
+    let spec = &rollout.spec;
+    let candidate = &rollout.candidate;
+    let baseline = &rollout.baseline;
+
+    if candidate.requests < spec.min_requests_per_step {
+        return RolloutDecision::Hold;
+    }
+
+    if candidate.error_rate > baseline.error_rate + spec.max_error_rate_increase {
+        return RolloutDecision::RollBack(format!(
+            "candidate error rate {:.1}% exceeds baseline {:.1}%",
+            candidate.error_rate * 100.0,
+            baseline.error_rate * 100.0
+        ));
+    }
+
+    if baseline.requests > 0
+        && candidate.avg_latency_ms > baseline.avg_latency_ms * spec.max_latency_ratio
+    {
+        return RolloutDecision::RollBack(format!(
+            "candidate latency {:.0}ms exceeds baseline {:.0}ms by more than {:.1}x",
+            candidate.avg_latency_ms, baseline.avg_latency_ms, spec.max_latency_ratio
+        ));
+    }
+
+    let step_elapsed = (now - rollout.step_started_at).num_seconds().max(0) as u64;
+    if step_elapsed < spec.step_duration_secs {
+        return RolloutDecision::Hold;
+    }
+
+    if rollout.step + 1 >= spec.steps.len() {
+        RolloutDecision::Complete
+    } else {
+        RolloutDecision::Advance
+    }
+}
+
+/// Registry of rollouts and the event channel their progress is published on
+#[derive(Debug)]
+pub struct RolloutManager {
+    rollouts: RwLock<HashMap<String, Rollout>>,
+    events: broadcast::Sender<RolloutEvent>,
+}
+
+impl Default for RolloutManager {
+    fn default() -> Self {
+        Self::new()
+    }
+}
+
+impl RolloutManager {
+    pub fn new() -> Self {
+        let (events, _) = broadcast::channel(EVENT_CHANNEL_CAPACITY);
+        Self {
+            rollouts: RwLock::new(HashMap::new()),
+            events,
+        }
+    }
+
+    pub async fn get(&self, id: &str) -> Option<Rollout> {
+        self.rollouts.read().await.get(id).cloned()
+    }
+
+    pub async fn list(&self) -> Vec<Rollout> {
+        let mut rollouts: Vec<Rollout> = self.rollouts.read().await.values().cloned().collect();
+        rollouts.sort_by(|a, b| b.created_at.cmp(&a.created_at));
+        rollouts
+    }
+
+    pub fn subscribe(&self) -> broadcast::Receiver<RolloutEvent> {
+        self.events.subscribe()
+    }
+
+    fn publish(&self, event: RolloutEvent) {
+        // Nobody listening is fine; events are also reflected in the rollout itself
+        let _ = self.events.send(event);
+    }
+
+    /// Start a rollout; fails if the alias already has one in progress
+    async fn start(&self, spec: RolloutSpec) -> Result<Rollout, String> {
+        let mut rollouts = self.rollouts.write().await;
+        if rollouts
+            .values()
+            .any(|r| r.spec.alias == spec.alias && r.state == RolloutState::Progressing)
+        {
+            return Err(format!(
+                "alias {} already has a rollout in progress",
+                spec.alias
+            ));
+        }
+
+        let rollout = Rollout::new(spec);
+        rollouts.insert(rollout.id.clone(), rollout.clone());
+        self.publish(rollout.event(format!(
+            "Started: {}% of {} traffic to {}",
+            rollout.candidate_percent, rollout.spec.alias, rollout.spec.candidate_model
+        )));
+        Ok(rollout)
+    }
+
+    /// Record the outcome of a request that was routed through an alias
+    pub async fn record_outcome(&self, route: &RoutedModel, latency: Duration, error: bool) {
+        let mut rollouts = self.rollouts.write().await;
+        let Some(rollout) = rollouts
+            .values_mut()
+            .find(|r| r.spec.alias == route.alias && r.state == RolloutState::Progressing)
+        else {
+            return;
+        };
+
+        if route.model == rollout.spec.candidate_model {
+            rollout.candidate.record(latency, error);
+        } else if route.model == rollout.spec.baseline_model {
+            rollout.baseline.record(latency, error);
+        }
+    }
+
+    /// Apply `decision` to a rollout; returns the updated rollout when it changed
+    async fn apply(&self, id: &str, decision: RolloutDecision) -> Option<Rollout> {
+        let mut rollouts = self.rollouts.write().await;
+        let rollout = rollouts.get_mut(id)?;
+        if rollout.state != RolloutState::Progressing {
+            return None;
+        }
+
+        let now = chrono::Utc::now();
+        let message = match decision {
+            RolloutDecision::Hold => return None,
+            RolloutDecision::Advance => {
+                rollout.step += 1;
+                rollout.candidate_percent = rollout.spec.steps[rollout.step];
+                rollout.step_started_at = now;
+                rollout.candidate = ArmHealth::default();
+                format!(
+                    "Advanced to {}% candidate traffic",
+                    rollout.candidate_percent
+                )
+            }
+            RolloutDecision::Complete => {
+                rollout.state = RolloutState::Completed;
+                rollout.candidate_percent = 100;
+                rollout.finished_at = Some(now);
+                format!(
+                    "Completed: {} serves all traffic",
+                    rollout.spec.candidate_model
+                )
+            }
+            RolloutDecision::RollBack(reason) => {
+                rollout.state = RolloutState::RolledBack;
+                rollout.candidate_percent = 0;
+                rollout.finished_at = Some(now);
+                rollout.reason = Some(reason.clone());
+                format!("Rolled back: {}", reason)
+            }
+        };
+
+        self.publish(rollout.event(message));
+        Some(rollout.clone())
+    }
+
+    async fn progressing_ids(&self) -> Vec<String> {
+        self.rollouts
+            .read()
+            .await
+            .values()
+            .filter(|r| r.state == RolloutState::Progressing)
+            .map(|r| r.id.clone())
+            .collect()
+    }
+}
+
+/// Evaluate every progressing rollout and update the routing rules to match
+pub async fn evaluate_rollouts(state: &ServerState) {
+    for id in state.rollouts.progressing_ids().await {
+        let Some(rollout) = state.rollouts.get(&id).await else {
+            continue;
+        };
+
+        let decision = evaluate(&rollout, chrono::Utc::now());
+        if let RolloutDecision::RollBack(reason) = &decision {
+            warn!("Rolling back {}: {}", id, reason);
+        }
+
+        if let Some(updated) = state.rollouts.apply(&id, decision).await {
+            info!(
+                "Rollout {} is {:?} at {}% candidate traffic",
+                id, updated.state, updated.candidate_percent
+            );
+            state.model_router.upsert(updated.routing_rule()).await;
+        }
+    }
+}
+
+/// Background loop driving rollouts for the lifetime of the server
+pub async fn run_controller(state: Arc<ServerState>) {
+    let mut interval = tokio::time::interval(EVALUATION_INTERVAL);
+    loop {
+        interval.tick().await;
+        evaluate_rollouts(&state).await;
+    }
+}
+
+// API Handlers
+
+/// `POST /v1/rollouts` - start a canary rollout (admin only)
+pub async fn create_rollout(
+    State(state): State<Arc<ServerState>>,
+    headers: HeaderMap,
+    Json(spec): Json<RolloutSpec>,
+) -> Response {
+    if let Err(response) = authorize_admin(&headers) {
+        return response;
+    }
+
+    if let Err(message) = spec.validate() {
+        return invalid_request(message, None, None);
+    }
+
+    match state.rollouts.start(spec).await {
+        Ok(rollout) => {
+            state.model_router.upsert(rollout.routing_rule()).await;
+            info!(
+                "Started rollout {} of {} on alias {}",
+                rollout.id, rollout.spec.candidate_model, rollout.spec.alias
+            );
+            (StatusCode::CREATED, Json(rollout)).into_response()
+        }
+        Err(message) => (
+            StatusCode::CONFLICT,
+            Json(json!({
+                "error": {
+                    "message": message,
+                    "type": "invalid_request_error",
+                    "param": "alias",
+                    "code": "rollout_in_progress"
+                }
+            })),
+        )
+            .into_response(),
+    }
+}
+
+/// `GET /v1/rollouts` - all rollouts, newest first
+pub async fn list_rollouts(State(state): State<Arc<ServerState>>) -> impl IntoResponse {
+    Json(json!({
+        "object": "list",
+        "data": state.rollouts.list().await
+    }))
+}
+
+/// `GET /v1/rollouts/:rollout_id` - rollout status and arm health
+pub async fn get_rollout(
+    State(state): State<Arc<ServerState>>,
+    Path(rollout_id): Path<String>,
+) -> Response {
+    match state.rollouts.get(&rollout_id).await {
+        Some(rollout) => Json(rollout).into_response(),
+        None => rollout_not_found(&rollout_id),
+    }
+}
+
+/// `POST /v1/rollouts/:rollout_id/rollback` - return all traffic to the baseline (admin only)
+pub async fn rollback_rollout(
+    State(state): State<Arc<ServerState>>,
+    Path(rollout_id): Path<String>,
+    headers: HeaderMap,
+) -> Response {
+    manual_decision(
+        state,
+        rollout_id,
+        headers,
+        RolloutDecision::RollBack("manual rollback".to_string()),
+    )
+    .await
+}
+
+/// `POST /v1/rollouts/:rollout_id/promote` - send all traffic to the candidate (admin only)
+pub async fn promote_rollout(
+    State(state): State<Arc<ServerState>>,
+    Path(rollout_id): Path<String>,
+    headers: HeaderMap,
+) -> Response {
+    manual_decision(state, rollout_id, headers, RolloutDecision::Complete).await
+}
+
+async fn manual_decision(
+    state: Arc<ServerState>,
+    rollout_id: String,
+    headers: HeaderMap,
+    decision: RolloutDecision,
+) -> Response {
+    if let Err(response) = authorize_admin(&headers) {
+        return response;
+    }
+
+    match state.rollouts.apply(&rollout_id, decision).await {
+        Some(rollout) => {
+            state.model_router.upsert(rollout.routing_rule()).await;
+            Json(rollout).into_response()
+        }
+        None => match state.rollouts.get(&rollout_id).await {
+            Some(rollout) => invalid_request(
+                format!(
+                    "Rollout {} has already finished ({:?})",
+                    rollout_id, rollout.state
+                ),
+                None,
+                None,
+            ),
+            None => rollout_not_found(&rollout_id),
+        },
+    }
+}
+
+/// `GET /v1/rollouts/:rollout_id/events` - stream progress as server-sent events
+pub async fn rollout_events(
+    State(state): State<Arc<ServerState>>,
+    Path(rollout_id): Path<String>,
+) -> Response {
+    let Some(current) = state.rollouts.get(&rollout_id).await else {
+        return rollout_not_found(&rollout_id);
+    };
+    let mut receiver = state.rollouts.subscribe();
+
+    let stream = async_stream::stream! {
+        let snapshot = current.event("Current status".to_string());
+        yield Ok::<Event, axum::Error>(Event::default().event("rollout").data(serde_json::to_string(&snapshot).unwrap()));
+        if current.state != RolloutState::Progressing {
+            return;
+        }
+
+        loop {
+            match receiver.recv().await {
+                Ok(event) if event.rollout_id == rollout_id => {
+                    let finished = event.state != RolloutState::Progressing;
+                    yield Ok(Event::default().event("rollout").data(serde_json::to_string(&event).unwrap()));
+                    if finished {
+                        break;
+                    }
+                }
+                Ok(_) | Err(broadcast::error::RecvError::Lagged(_)) => continue,
+                Err(broadcast::error::RecvError::Closed) => break,
+            }
+        }
+    };
+
+    Sse::new(stream)
+        .keep_alive(KeepAlive::default())
+        .into_response()
+}
+
+fn rollout_not_found(rollout_id: &str) -> Response {
+    (
+        StatusCode::NOT_FOUND,
+        Json(json!({
+            "error": {
+                "message": format!("No rollout with id {}", rollout_id),
+                "type": "invalid_request_error",
+                "param": "rollout_id",
+                "code": "rollout_not_found"
+            }
+        })),
+    )
+        .into_response()
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn spec() -> RolloutSpec {
+        RolloutSpec {
+            alias: "chat-default".to_string(),
+            baseline_model: "llama-3-8b".to_string(),
+            candidate_model: "llama-3.1-8b".to_string(),
+            steps: default_steps(),
+            step_duration_secs: 60,
+            min_requests_per_step: 10,
+            max_error_rate_increase: 0.05,
+            max_latency_ratio: 1.5,
+        }
+    }
+
+    fn fill(health: &mut ArmHealth, requests: u64, errors: u64, latency_ms: u64) {
+        for i in 0..requests {
+            health.record(Duration::from_millis(latency_ms), i < errors);
+        }
+    }
+
+    #[test]
+    fn test_validate_steps() {
+        assert!(spec().validate().is_ok());
+
+        let mut bad = spec();
+        bad.steps = vec![10, 50];
+        assert!(bad.validate().is_err());
+
+        let mut bad = spec();
+        bad.steps = vec![10, 10, 100];
+        assert!(bad.validate().is_err());
+    }
+
+    #[test]
+    fn test_hold_until_enough_traffic_and_time() {
+        let mut rollout = Rollout::new(spec());
+        assert_eq!(
+            evaluate(&rollout, chrono::Utc::now()),
+            RolloutDecision::Hold
+        );
+
+        fill(&mut rollout.baseline, 100, 0, 100);
+        fill(&mut rollout.candidate, 20, 0, 110);
+        assert_eq!(
+            evaluate(&rollout, chrono::Utc::now()),
+            RolloutDecision::Hold
+        );
+
+        let later = chrono::Utc::now() + chrono::Duration::seconds(61);
+        assert_eq!(evaluate(&rollout, later), RolloutDecision::Advance);
+
+        rollout.step = 2;
+        assert_eq!(evaluate(&rollout, later), RolloutDecision::Complete);
+    }
+
+    #[test]
+    fn test_rollback_on_regression() {
+        let mut errors = Rollout::new(spec());
+        fill(&mut errors.baseline, 100, 1, 100);
+        fill(&mut errors.candidate, 20, 5, 100);
+        assert!(matches!(
+            evaluate(&errors, chrono::Utc::now()),
+            RolloutDecision::RollBack(_)
+        ));
+
+        let mut slow = Rollout::new(spec());
+        fill(&mut slow.baseline, 100, 0, 100);
+        fill(&mut slow.candidate, 20, 0, 200);
+        assert!(matches!(
+            evaluate(&slow, chrono::Utc::now()),
+            RolloutDecision::RollBack(_)
+        ));
+    }
+
+    #[test]
+    fn test_routing_rule_follows_state() {
+        let mut rollout = Rollout::new(spec());
+        let rule = rollout.routing_rule();
+        assert_eq!(rule.arms.len(), 2);
+        assert_eq!(rule.arms[1].weight, 1);
+        assert!(rule.validate().is_ok());
+
+        rollout.candidate_percent = 100;
+        assert_eq!(rollout.routing_rule().arms[0].model, "llama-3.1-8b");
+
+        rollout.state = RolloutState::RolledBack;
+        assert_eq!(rollout.routing_rule().arms[0].model, "llama-3-8b");
+    }
+
+    #[tokio::test]
+    async fn test_one_active_rollout_per_alias() {
+        let manager = RolloutManager::new();
+        let first = manager.start(spec()).await.unwrap();
+        assert!(manager.start(spec()).await.is_err());
+
+        manager
+            .apply(&first.id, RolloutDecision::RollBack("test".to_string()))
+            .await
+            .unwrap();
+        assert!(manager.start(spec()).await.is_ok());
+    }
+}
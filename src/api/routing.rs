@@ -0,0 +1,395 @@
+//! Model Routing Rules (A/B Traffic Splitting)
+//!
+//! A routing rule maps an alias such as `chat-default` to weighted arms, e.g.
+//! 90% `llama-3-8b` and 10% `llama-3-8b-experimental`. Requests naming the
+//! alias are served by one arm; the response's `model` field and the
+//! `X-Inferno-Route-Alias` header identify the arm and the alias. Requests
+//! that carry a `user` are assigned to the same arm on every call so a user
+//! sees consistent behaviour for the duration of an experiment.
+
+use crate::{api::admin::authorize_admin, cli::serve::ServerState};
+use axum::{
+    Json,
+    extract::{Path, State},
+    http::{HeaderMap, HeaderValue, StatusCode},
+    response::{IntoResponse, Response},
+};
+use serde::{Deserialize, Serialize};
+use serde_json::json;
+use sha2::{Digest, Sha256};
+use std::{collections::HashMap, sync::Arc};
+use tokio::sync::RwLock;
+
+/// Response header naming the alias a request was routed through
+pub const ROUTE_ALIAS_HEADER: &str = "x-inferno-route-alias";
+
+/// One model in a routing rule and its share of traffic
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct RouteArm {
+    pub model: String,
+    /// Relative weight; shares are `weight / sum(weights)`
+    pub weight: u32,
+}
+
+/// Alias resolved to weighted arms
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct RoutingRule {
+    pub alias: String,
+    pub arms: Vec<RouteArm>,
+    #[serde(default)]
+    pub description: Option<String>,
+    #[serde(default = "chrono::Utc::now")]
+    pub created_at: chrono::DateTime<chrono::Utc>,
+}
+
+impl RoutingRule {
+    pub fn validate(&self) -> Result<(), String> {
+        if self.alias.trim().is_empty() {
+            return Err("alias must not be empty".to_string());
+        }
+        if self.arms.is_empty() {
+            return Err("a routing rule needs at least one arm".to_string());
+        }
+        for arm in &self.arms {
+            if arm.model.trim().is_empty() {
+                return Err("arm model must not be empty".to_string());
+            }
+            if arm.model == self.alias {
+                return Err("an arm cannot route back to its own alias".to_string());
+            }
+            if arm.weight == 0 {
+                return Err(format!("arm {} must have a positive weight", arm.model));
+            }
+        }
+        Ok(())
+    }
+
+    fn total_weight(&self) -> u64 {
+        self.arms.iter().map(|arm| arm.weight as u64).sum()
+    }
+
+    /// Pick the arm at `point`, a value in `[0, total_weight)`
+    fn arm_at(&self, point: u64) -> &RouteArm {
+        let mut cumulative = 0;
+        for arm in &self.arms {
+            cumulative += arm.weight as u64;
+            if point < cumulative {
+                return arm;
+            }
+        }
+        self.arms.last().expect("validated rules have arms")
+    }
+}
+
+/// Per-arm traffic counters
+#[derive(Debug, Clone, Default, Serialize, Deserialize)]
+pub struct ArmUsage {
+    pub model: String,
+    pub weight: u32,
+    pub configured_share: f64,
+    pub requests: u64,
+    pub observed_share: f64,
+}
+
+/// Rule plus per-arm usage, returned by the routing endpoints
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct RoutingRuleStatus {
+    #[serde(flatten)]
+    pub rule: RoutingRule,
+    pub total_requests: u64,
+    pub usage: Vec<ArmUsage>,
+}
+
+/// Outcome of routing a request through an alias
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct RoutedModel {
+    pub alias: String,
+    pub model: String,
+}
+
+/// Registry of routing rules keyed by alias
+#[derive(Debug, Default)]
+pub struct ModelRouter {
+    rules: RwLock<HashMap<String, RoutingRule>>,
+    /// Requests served per alias and arm model
+    usage: RwLock<HashMap<String, HashMap<String, u64>>>,
+}
+
+impl ModelRouter {
+    pub fn new() -> Self {
+        Self::default()
+    }
+
+    /// Route `model` through its rule, if it names an alias.
+    ///
+    /// Requests with a `user` are hashed onto a fixed arm; anonymous requests
+    /// are assigned at random in proportion to the weights.
+    pub async fn route(&self, model: &str, user: Option<&str>) -> Option<RoutedModel> {
+        let chosen = {
+            let rules = self.rules.read().await;
+            let rule = rules.get(model)?;
+            let total = rule.total_weight();
+            let point = match user {
+                Some(user) => sticky_point(model, user) % total,
+                None => rand::random_range(0..total),
+            };
+            rule.arm_at(point).model.clone()
+        };
+
+        *self
+            .usage
+            .write()
+            .await
+            .entry(model.to_string())
+            .or_default()
+            .entry(chosen.clone())
+            .or_default() += 1;
+
+        Some(RoutedModel {
+            alias: model.to_string(),
+            model: chosen,
+        })
+    }
+
+    /// Create or replace a rule; usage counters restart for the alias
+    pub async fn upsert(&self, rule: RoutingRule) {
+        self.usage.write().await.remove(&rule.alias);
+        self.rules.write().await.insert(rule.alias.clone(), rule);
+    }
+
+    pub async fn remove(&self, alias: &str) -> bool {
+        self.usage.write().await.remove(alias);
+        self.rules.write().await.remove(alias).is_some()
+    }
+
+    pub async fn status(&self, alias: &str) -> Option<RoutingRuleStatus> {
+        let rule = self.rules.read().await.get(alias)?.clone();
+        Some(self.with_usage(rule).await)
+    }
+
+    pub async fn list(&self) -> Vec<RoutingRuleStatus> {
+        let mut rules: Vec<RoutingRule> = self.rules.read().await.values().cloned().collect();
+        rules.sort_by(|a, b| a.alias.cmp(&b.alias));
+
+        let mut statuses = Vec::with_capacity(rules.len());
+        for rule in rules {
+            statuses.push(self.with_usage(rule).await);
+        }
+        statuses
+    }
+
+    async fn with_usage(&self, rule: RoutingRule) -> RoutingRuleStatus {
+        let counts = self
+            .usage
+            .read()
+            .await
+            .get(&rule.alias)
+            .cloned()
+            .unwrap_or_default();
+        let total_requests: u64 = counts.values().sum();
+        let total_weight = rule.total_weight() as f64;
+
+        let usage = rule
+            .arms
+            .iter()
+            .map(|arm| {
+                let requests = counts.get(&arm.model).copied().unwrap_or(0);
+                ArmUsage {
+                    model: arm.model.clone(),
+                    weight: arm.weight,
+                    configured_share: arm.weight as f64 / total_weight,
+                    requests,
+                    observed_share: if total_requests > 0 {
+                        requests as f64 / total_requests as f64
+                    } else {
+                        0.0
+                    },
+                }
+            })
+            .collect();
+
+        RoutingRuleStatus {
+            rule,
+            total_requests,
+            usage,
+        }
+    }
+}
+
+/// Stable per-user position on the weight line
+fn sticky_point(alias: &str, user: &str) -> u64 {
+    let digest = Sha256::new()
+        .chain_update(alias.as_bytes())
+        .chain_update([0u8])
+        .chain_update(user.as_bytes())
+        .finalize();
+    u64::from_be_bytes(digest[..8].try_into().expect("digest is 32 bytes"))
+}
+
+/// Tag a response with the alias it was routed through
+pub fn with_route(mut response: Response, route: Option<&RoutedModel>) -> Response {
+    if let Some(route) = route {
+        if let Ok(value) = HeaderValue::from_str(&route.alias) {
+            response.headers_mut().insert(ROUTE_ALIAS_HEADER, value);
+        }
+    }
+    response
+}
+
+// API Handlers
+
+/// `GET /v1/routes` - all routing rules with per-arm usage
+pub async fn list_routes(State(state): State<Arc<ServerState>>) -> impl IntoResponse {
+    let rules = state.model_router.list().await;
+    Json(json!({
+        "object": "list",
+        "data": rules
+    }))
+}
+
+/// `GET /v1/routes/:alias` - one routing rule with per-arm usage
+pub async fn get_route(
+    State(state): State<Arc<ServerState>>,
+    Path(alias): Path<String>,
+) -> Response {
+    match state.model_router.status(&alias).await {
+        Some(status) => Json(status).into_response(),
+        None => route_not_found(&alias),
+    }
+}
+
+/// `PUT /v1/routes/:alias` - create or replace a routing rule (admin only)
+pub async fn put_route(
+    State(state): State<Arc<ServerState>>,
+    Path(alias): Path<String>,
+    headers: HeaderMap,
+    Json(mut rule): Json<RoutingRule>,
+) -> Response {
+    if let Err(response) = authorize_admin(&headers) {
+        return response;
+    }
+
+    rule.alias = alias.clone();
+    if let Err(message) = rule.validate() {
+        return (
+            StatusCode::BAD_REQUEST,
+            Json(json!({
+                "error": {
+                    "message": message,
+                    "type": "invalid_request_error",
+                    "param": null,
+                    "code": null
+                }
+            })),
+        )
+            .into_response();
+    }
+
+    state.model_router.upsert(rule).await;
+    match state.model_router.status(&alias).await {
+        Some(status) => Json(status).into_response(),
+        None => route_not_found(&alias),
+    }
+}
+
+/// `DELETE /v1/routes/:alias` - remove a routing rule (admin only)
+pub async fn delete_route(
+    State(state): State<Arc<ServerState>>,
+    Path(alias): Path<String>,
+    headers: HeaderMap,
+) -> Response {
+    if let Err(response) = authorize_admin(&headers) {
+        return response;
+    }
+
+    if state.model_router.remove(&alias).await {
+        StatusCode::NO_CONTENT.into_response()
+    } else {
+        route_not_found(&alias)
+    }
+}
+
+fn route_not_found(alias: &str) -> Response {
+    (
+        StatusCode::NOT_FOUND,
+        Json(json!({
+            "error": {
+                "message": format!("No routing rule for alias {}", alias),
+                "type": "invalid_request_error",
+                "param": "alias",
+                "code": "route_not_found"
+            }
+        })),
+    )
+        .into_response()
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn rule() -> RoutingRule {
+        RoutingRule {
+            alias: "chat-default".to_string(),
+            arms: vec![
+                RouteArm {
+                    model: "llama-3-8b".to_string(),
+                    weight: 90,
+                },
+                RouteArm {
+                    model: "llama-3-8b-experimental".to_string(),
+                    weight: 10,
+                },
+            ],
+            description: None,
+            created_at: chrono::Utc::now(),
+        }
+    }
+
+    #[test]
+    fn test_validate() {
+        assert!(rule().validate().is_ok());
+
+        let mut zero_weight = rule();
+        zero_weight.arms[1].weight = 0;
+        assert!(zero_weight.validate().is_err());
+
+        let mut self_loop = rule();
+        self_loop.arms[0].model = "chat-default".to_string();
+        assert!(self_loop.validate().is_err());
+    }
+
+    #[test]
+    fn test_arm_at_boundaries() {
+        let rule = rule();
+        assert_eq!(rule.arm_at(0).model, "llama-3-8b");
+        assert_eq!(rule.arm_at(89).model, "llama-3-8b");
+        assert_eq!(rule.arm_at(90).model, "llama-3-8b-experimental");
+        assert_eq!(rule.arm_at(99).model, "llama-3-8b-experimental");
+    }
+
+    #[tokio::test]
+    async fn test_route_is_sticky_per_user_and_counted() {
+        let router = ModelRouter::new();
+        router.upsert(rule()).await;
+
+        assert!(router.route("llama-3-8b", None).await.is_none());
+
+        let first = router.route("chat-default", Some("user-1")).await.unwrap();
+        for _ in 0..10 {
+            assert_eq!(
+                router.route("chat-default", Some("user-1")).await.unwrap(),
+                first
+            );
+        }
+
+        let status = router.status("chat-default").await.unwrap();
+        assert_eq!(status.total_requests, 11);
+        let arm = status
+            .usage
+            .iter()
+            .find(|u| u.model == first.model)
+            .unwrap();
+        assert_eq!(arm.requests, 11);
+    }
+}
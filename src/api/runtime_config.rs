@@ -0,0 +1,444 @@
+//! Runtime Configuration
+//!
+//! A small set of settings can change while the server runs, without a
+//! restart: the generation concurrency limit, the session cache and the
+//! sampling defaults applied when a request leaves a field out.
+//! `GET /admin/config` returns them and `PATCH /admin/config` changes any
+//! subset. Every change is validated as a whole before it is applied and
+//! recorded with the old and new value of each field it touched;
+//! `GET /admin/config/history` lists the most recent changes.
+//!
+//! Settings that need resources rebuilt (bind address, backend, model
+//! directories) stay in the config file. Sampling defaults are also
+//! published process-wide, because serde fills omitted request fields
+//! before any handler sees the server state.
+
+use crate::{
+    api::{admin::authorize_admin, cancellation::request_id_from_headers, invalid_request},
+    cli::serve::ServerState,
+    config::Config,
+};
+use axum::{
+    Json,
+    extract::{Request, State},
+    http::{HeaderMap, HeaderValue, StatusCode, header},
+    middleware::Next,
+    response::{IntoResponse, Response},
+};
+use chrono::{DateTime, Utc};
+use serde::{Deserialize, Serialize};
+use serde_json::{Value, json};
+use std::{
+    collections::{BTreeMap, VecDeque},
+    sync::{Arc, Mutex, RwLock},
+};
+use tracing::info;
+
+/// Changes kept in the audit history, newest last
+const MAX_HISTORY: usize = 100;
+
+/// Shortest idle window sessions may be given, so a typo cannot drop every
+/// open conversation at once
+const MIN_SESSION_IDLE_SECONDS: u64 = 60;
+
+/// Longest idle window sessions may be given (a year)
+const MAX_SESSION_IDLE_SECONDS: u64 = 365 * 24 * 60 * 60;
+
+/// Sampling parameters used when a request omits them
+#[derive(Debug, Clone, Copy, PartialEq, Serialize, Deserialize)]
+pub struct SamplingDefaults {
+    pub max_tokens: u32,
+    pub temperature: f32,
+    pub top_p: f32,
+    pub top_k: u32,
+}
+
+impl SamplingDefaults {
+    const INITIAL: Self = Self {
+        max_tokens: 512,
+        temperature: 0.7,
+        top_p: 0.9,
+        top_k: 40,
+    };
+}
+
+impl Default for SamplingDefaults {
+    fn default() -> Self {
+        Self::INITIAL
+    }
+}
+
+static SAMPLING_DEFAULTS: RwLock<SamplingDefaults> = RwLock::new(SamplingDefaults::INITIAL);
+
+/// The sampling defaults currently in effect
+pub fn sampling_defaults() -> SamplingDefaults {
+    *SAMPLING_DEFAULTS.read().unwrap()
+}
+
+/// Settings that can change without a restart
+#[derive(Debug, Clone, PartialEq, Serialize, Deserialize)]
+pub struct RuntimeSettings {
+    /// Generation requests allowed in flight at once; 0 for no limit
+    pub max_concurrent_requests: u32,
+    /// Sessions kept in memory; 0 for no limit
+    pub max_sessions: usize,
+    /// Sessions unused for this long are dropped
+    pub session_idle_seconds: u64,
+    pub sampling: SamplingDefaults,
+}
+
+impl RuntimeSettings {
+    fn from_config(config: &Config) -> Self {
+        Self {
+            max_concurrent_requests: config.server.max_concurrent_requests,
+            max_sessions: 0,
+            session_idle_seconds: 24 * 60 * 60,
+            sampling: SamplingDefaults::INITIAL,
+        }
+    }
+
+    fn validate(&self) -> Result<(), (String, &'static str)> {
+        if !(MIN_SESSION_IDLE_SECONDS..=MAX_SESSION_IDLE_SECONDS)
+            .contains(&self.session_idle_seconds)
+        {
+            return Err((
+                format!(
+                    "session_idle_seconds must be between {} and {}",
+                    MIN_SESSION_IDLE_SECONDS, MAX_SESSION_IDLE_SECONDS
+                ),
+                "session_idle_seconds",
+            ));
+        }
+        let sampling = &self.sampling;
+        if sampling.max_tokens == 0 {
+            return Err((
+                "max_tokens must be at least 1".to_string(),
+                "sampling.max_tokens",
+            ));
+        }
+        if !(0.0..=2.0).contains(&sampling.temperature) {
+            return Err((
+                "temperature must be between 0 and 2".to_string(),
+                "sampling.temperature",
+            ));
+        }
+        if !(sampling.top_p > 0.0 && sampling.top_p <= 1.0) {
+            return Err((
+                "top_p must be greater than 0 and at most 1".to_string(),
+                "sampling.top_p",
+            ));
+        }
+        Ok(())
+    }
+}
+
+/// Partial update; omitted fields keep their current value
+#[derive(Debug, Clone, Default, Deserialize)]
+pub struct RuntimeSettingsUpdate {
+    pub max_concurrent_requests: Option<u32>,
+    pub max_sessions: Option<usize>,
+    pub session_idle_seconds: Option<u64>,
+    #[serde(default)]
+    pub sampling: SamplingDefaultsUpdate,
+    /// Why the change was made, kept in the audit history
+    pub reason: Option<String>,
+}
+
+#[derive(Debug, Clone, Default, Deserialize)]
+pub struct SamplingDefaultsUpdate {
+    pub max_tokens: Option<u32>,
+    pub temperature: Option<f32>,
+    pub top_p: Option<f32>,
+    pub top_k: Option<u32>,
+}
+
+impl RuntimeSettingsUpdate {
+    fn apply(&self, mut settings: RuntimeSettings) -> RuntimeSettings {
+        if let Some(max) = self.max_concurrent_requests {
+            settings.max_concurrent_requests = max;
+        }
+        if let Some(max) = self.max_sessions {
+            settings.max_sessions = max;
+        }
+        if let Some(seconds) = self.session_idle_seconds {
+            settings.session_idle_seconds = seconds;
+        }
+        if let Some(max_tokens) = self.sampling.max_tokens {
+            settings.sampling.max_tokens = max_tokens;
+        }
+        if let Some(temperature) = self.sampling.temperature {
+            settings.sampling.temperature = temperature;
+        }
+        if let Some(top_p) = self.sampling.top_p {
+            settings.sampling.top_p = top_p;
+        }
+        if let Some(top_k) = self.sampling.top_k {
+            settings.sampling.top_k = top_k;
+        }
+        settings
+    }
+}
+
+/// Old and new value of one setting
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct FieldChange {
+    pub from: Value,
+    pub to: Value,
+}
+
+/// One applied update, as kept in the audit history
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct ConfigChange {
+    pub changed_at: DateTime<Utc>,
+    /// Settings that changed, by dotted path such as `sampling.top_p`
+    pub changes: BTreeMap<String, FieldChange>,
+    pub reason: Option<String>,
+    /// `X-Request-ID` of the update, when the client sent one
+    pub request_id: Option<String>,
+}
+
+/// Current runtime settings and the history of changes to them
+#[derive(Debug)]
+pub struct RuntimeConfigStore {
+    settings: RwLock<RuntimeSettings>,
+    updated_at: RwLock<Option<DateTime<Utc>>>,
+    history: Mutex<VecDeque<ConfigChange>>,
+}
+
+impl RuntimeConfigStore {
+    pub fn new(config: &Config) -> Self {
+        let settings = RuntimeSettings::from_config(config);
+        *SAMPLING_DEFAULTS.write().unwrap() = settings.sampling;
+        Self {
+            settings: RwLock::new(settings),
+            updated_at: RwLock::new(None),
+            history: Mutex::new(VecDeque::new()),
+        }
+    }
+
+    pub fn current(&self) -> RuntimeSettings {
+        self.settings.read().unwrap().clone()
+    }
+
+    /// Validate and apply an update, returning the new settings and the
+    /// recorded change (`None` when nothing changed)
+    pub fn update(
+        &self,
+        update: &RuntimeSettingsUpdate,
+        request_id: Option<String>,
+    ) -> Result<(RuntimeSettings, Option<ConfigChange>), (String, &'static str)> {
+        let mut settings = self.settings.write().unwrap();
+        let next = update.apply(settings.clone());
+        next.validate()?;
+
+        let changes = diff(&settings, &next);
+        if changes.is_empty() {
+            return Ok((next, None));
+        }
+
+        let change = ConfigChange {
+            changed_at: Utc::now(),
+            changes,
+            reason: update.reason.clone(),
+            request_id,
+        };
+        *settings = next.clone();
+        *SAMPLING_DEFAULTS.write().unwrap() = next.sampling;
+        *self.updated_at.write().unwrap() = Some(change.changed_at);
+
+        let mut history = self.history.lock().unwrap();
+        history.push_back(change.clone());
+        if history.len() > MAX_HISTORY {
+            history.pop_front();
+        }
+        Ok((next, Some(change)))
+    }
+
+    pub fn updated_at(&self) -> Option<DateTime<Utc>> {
+        *self.updated_at.read().unwrap()
+    }
+
+    /// Recorded changes, newest first
+    pub fn history(&self) -> Vec<ConfigChange> {
+        self.history.lock().unwrap().iter().rev().cloned().collect()
+    }
+}
+
+/// Fields that differ between two settings, by dotted path
+fn diff(before: &RuntimeSettings, after: &RuntimeSettings) -> BTreeMap<String, FieldChange> {
+    fn walk(path: &str, before: &Value, after: &Value, out: &mut BTreeMap<String, FieldChange>) {
+        match (before, after) {
+            (Value::Object(before), Value::Object(after)) => {
+                for (key, value) in before {
+                    let path = if path.is_empty() {
+                        key.clone()
+                    } else {
+                        format!("{}.{}", path, key)
+                    };
+                    walk(&path, value, &after[key], out);
+                }
+            }
+            _ if before != after => {
+                out.insert(
+                    path.to_string(),
+                    FieldChange {
+                        from: before.clone(),
+                        to: after.clone(),
+                    },
+                );
+            }
+            _ => {}
+        }
+    }
+
+    let mut changes = BTreeMap::new();
+    walk(
+        "",
+        &serde_json::to_value(before).unwrap_or_default(),
+        &serde_json::to_value(after).unwrap_or_default(),
+        &mut changes,
+    );
+    changes
+}
+
+/// Middleware refusing generation requests beyond `max_concurrent_requests`
+/// with 429, counting the requests the queue is tracking
+pub async fn limit_concurrency(
+    State(state): State<Arc<ServerState>>,
+    request: Request,
+    next: Next,
+) -> Response {
+    let limit = state.runtime_config.current().max_concurrent_requests as usize;
+    if limit > 0 && state.request_queue.len() >= limit {
+        let mut response = (
+            StatusCode::TOO_MANY_REQUESTS,
+            Json(json!({
+                "error": {
+                    "message": format!(
+                        "The server is at its limit of {} concurrent generation requests; retry shortly",
+                        limit
+                    ),
+                    "type": "rate_limit_error",
+                    "param": null,
+                    "code": "concurrency_limit_exceeded"
+                }
+            })),
+        )
+            .into_response();
+        response
+            .headers_mut()
+            .insert(header::RETRY_AFTER, HeaderValue::from_static("1"));
+        return response;
+    }
+    next.run(request).await
+}
+
+// API Handlers
+
+/// `GET /admin/config` - the runtime settings (admin only)
+pub async fn get_config(State(state): State<Arc<ServerState>>, headers: HeaderMap) -> Response {
+    if let Err(response) = authorize_admin(&headers) {
+        return response;
+    }
+
+    Json(json!({
+        "settings": state.runtime_config.current(),
+        "updated_at": state.runtime_config.updated_at(),
+    }))
+    .into_response()
+}
+
+/// `PATCH /admin/config` - change runtime settings (admin only)
+pub async fn patch_config(
+    State(state): State<Arc<ServerState>>,
+    headers: HeaderMap,
+    Json(update): Json<RuntimeSettingsUpdate>,
+) -> Response {
+    if let Err(response) = authorize_admin(&headers) {
+        return response;
+    }
+
+    match state
+        .runtime_config
+        .update(&update, request_id_from_headers(&headers))
+    {
+        Ok((settings, change)) => {
+            if let Some(change) = &change {
+                let fields: Vec<&str> = change.changes.keys().map(String::as_str).collect();
+                info!("Runtime configuration changed: {}", fields.join(", "));
+            }
+            Json(json!({
+                "settings": settings,
+                "updated_at": state.runtime_config.updated_at(),
+                "change": change,
+            }))
+            .into_response()
+        }
+        Err((message, param)) => invalid_request(message, Some(param), None),
+    }
+}
+
+/// `GET /admin/config/history` - recent changes, newest first (admin only)
+pub async fn config_history(State(state): State<Arc<ServerState>>, headers: HeaderMap) -> Response {
+    if let Err(response) = authorize_admin(&headers) {
+        return response;
+    }
+
+    Json(json!({
+        "object": "list",
+        "data": state.runtime_config.history(),
+    }))
+    .into_response()
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_update_records_changed_fields() {
+        let store = RuntimeConfigStore::new(&Config::default());
+        // Sampling defaults are process-wide, so leave them as they are
+        let update = RuntimeSettingsUpdate {
+            max_sessions: Some(500),
+            session_idle_seconds: Some(3600),
+            sampling: SamplingDefaultsUpdate {
+                top_k: Some(SamplingDefaults::INITIAL.top_k),
+                ..Default::default()
+            },
+            reason: Some("load test".to_string()),
+            ..Default::default()
+        };
+
+        let (settings, change) = store.update(&update, None).unwrap();
+        assert_eq!(settings.max_sessions, 500);
+        let change = change.unwrap();
+        let fields: Vec<&str> = change.changes.keys().map(String::as_str).collect();
+        assert_eq!(fields, ["max_sessions", "session_idle_seconds"]);
+        assert_eq!(store.history().len(), 1);
+
+        let (_, change) = store.update(&update, None).unwrap();
+        assert!(change.is_none());
+    }
+
+    #[test]
+    fn test_invalid_update_changes_nothing() {
+        let store = RuntimeConfigStore::new(&Config::default());
+        let update = RuntimeSettingsUpdate {
+            max_concurrent_requests: Some(4),
+            sampling: SamplingDefaultsUpdate {
+                top_p: Some(0.0),
+                ..Default::default()
+            },
+            ..Default::default()
+        };
+
+        let (_, param) = store.update(&update, None).unwrap_err();
+        assert_eq!(param, "sampling.top_p");
+        assert_eq!(
+            store.current().max_concurrent_requests,
+            Config::default().server.max_concurrent_requests
+        );
+        assert!(store.history().is_empty());
+    }
+}
@@ -0,0 +1,254 @@
+//! vLLM Sampling Extensions
+//!
+//! vLLM's OpenAI server accepts sampling fields beyond the OpenAI spec, and
+//! clients migrating from it send them as a matter of course. The fields are
+//! flattened into the chat and text completion requests so they are applied
+//! rather than silently ignored:
+//!
+//! - `stop_token_ids`, `ignore_eos` and `skip_special_tokens` map directly
+//!   onto [`InferenceParams`]
+//! - `best_of` samples that many candidates and returns the one with the
+//!   highest length-normalised log-likelihood, with `length_penalty` as the
+//!   normalisation exponent (1.0, the default, ranks by mean token logprob as
+//!   OpenAI does)
+//!
+//! Beam search is not implemented; `use_beam_search: true` is rejected with a
+//! pointer to `best_of` instead of falling back to sampling unannounced.
+
+use crate::{
+    api::cancellation::{CancelSignal, FinishReason, Generation, generate_cancellable},
+    backends::{BackendHandle, InferenceParams, ScoredText},
+};
+use serde::{Deserialize, Serialize};
+use tokio::time::Instant;
+
+/// Largest accepted `best_of`; every candidate is a full generation
+pub const MAX_BEST_OF: u32 = 20;
+
+/// vLLM sampling fields shared by the chat and text completion requests
+#[derive(Debug, Clone, Default, Serialize, Deserialize)]
+pub struct SamplingExtensions {
+    /// Sample this many candidates and return the most likely
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub best_of: Option<u32>,
+    /// Not supported; accepted only so it can be rejected explicitly
+    #[serde(default)]
+    pub use_beam_search: bool,
+    /// Exponent of the candidate length when ranking `best_of` candidates
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub length_penalty: Option<f32>,
+    /// Token ids that end generation like EOS
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub stop_token_ids: Option<Vec<u32>>,
+    /// Keep generating past the model's EOS token
+    #[serde(default)]
+    pub ignore_eos: bool,
+    /// Strip special tokens from the output (backend default when unset)
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub skip_special_tokens: Option<bool>,
+}
+
+impl SamplingExtensions {
+    /// Check the fields against each other and the request's `n` and
+    /// `stream`, returning the message and offending parameter on failure
+    pub fn validate(&self, n: Option<u32>, stream: bool) -> Result<(), (String, &'static str)> {
+        if self.use_beam_search {
+            return Err((
+                "Beam search is not supported; use best_of to sample several candidates \
+                 and return the most likely"
+                    .to_string(),
+                "use_beam_search",
+            ));
+        }
+
+        if let Some(best_of) = self.best_of {
+            let n = n.unwrap_or(1);
+            if best_of == 0 {
+                return Err(("best_of must be at least 1".to_string(), "best_of"));
+            }
+            if best_of < n {
+                return Err((
+                    format!(
+                        "best_of ({}) must be greater than or equal to n ({})",
+                        best_of, n
+                    ),
+                    "best_of",
+                ));
+            }
+            if best_of > MAX_BEST_OF {
+                return Err((
+                    format!("best_of must be at most {}", MAX_BEST_OF),
+                    "best_of",
+                ));
+            }
+            if best_of > 1 && stream {
+                return Err((
+                    "best_of cannot be combined with stream".to_string(),
+                    "best_of",
+                ));
+            }
+        }
+
+        if let Some(length_penalty) = self.length_penalty {
+            if !length_penalty.is_finite() {
+                return Err((
+                    "length_penalty must be a finite number".to_string(),
+                    "length_penalty",
+                ));
+            }
+            if length_penalty != 1.0 && self.candidates() <= 1 {
+                return Err((
+                    "length_penalty only affects ranking of best_of candidates; \
+                     set best_of greater than 1"
+                        .to_string(),
+                    "length_penalty",
+                ));
+            }
+        }
+
+        Ok(())
+    }
+
+    /// Candidates to sample per request
+    pub fn candidates(&self) -> u32 {
+        self.best_of.unwrap_or(1).max(1)
+    }
+
+    /// Copy the fields the backends honour into `params`
+    pub fn apply(&self, params: &mut InferenceParams) {
+        params.stop_token_ids = self.stop_token_ids.clone().unwrap_or_default();
+        params.ignore_eos = self.ignore_eos;
+        params.skip_special_tokens = self.skip_special_tokens;
+    }
+}
+
+/// Rank a scored candidate: total log-likelihood over length^`length_penalty`.
+/// An empty candidate ranks last.
+pub fn ranking_score(scored: &ScoredText, length_penalty: f32) -> f64 {
+    if scored.tokens.is_empty() {
+        return f64::NEG_INFINITY;
+    }
+    scored.log_likelihood() / (scored.tokens.len() as f64).powf(length_penalty as f64)
+}
+
+/// Sample `extensions.candidates()` generations and return the best-ranked.
+///
+/// Candidates run one after another on the same backend; with a fixed seed
+/// candidate `i` uses `seed + i` so the result stays reproducible. The
+/// backend must support scoring. A cancelled or timed-out candidate is
+/// returned as is, since a partial generation cannot be ranked fairly.
+pub async fn generate_best_of(
+    backend: &BackendHandle,
+    prompt: &str,
+    params: &InferenceParams,
+    extensions: &SamplingExtensions,
+    cancel: &CancelSignal,
+    deadline: Option<Instant>,
+) -> anyhow::Result<Generation> {
+    let length_penalty = extensions.length_penalty.unwrap_or(1.0);
+    let mut best: Option<(f64, Generation)> = None;
+
+    for i in 0..extensions.candidates() {
+        let mut candidate_params = params.clone();
+        candidate_params.seed = params.seed.map(|seed| seed.wrapping_add(i as u64));
+
+        let generation =
+            generate_cancellable(backend, prompt, &candidate_params, cancel, deadline).await?;
+        if generation.finish_reason != FinishReason::Stop {
+            return Ok(generation);
+        }
+
+        let score = ranking_score(
+            &backend.score(prompt, &generation.text).await?,
+            length_penalty,
+        );
+        if best.as_ref().is_none_or(|(top, _)| score > *top) {
+            best = Some((score, generation));
+        }
+    }
+
+    best.map(|(_, generation)| generation)
+        .ok_or_else(|| anyhow::anyhow!("best_of produced no candidates"))
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::backends::TokenLogprob;
+
+    fn scored(logprobs: &[f32]) -> ScoredText {
+        ScoredText {
+            context_tokens: 1,
+            tokens: logprobs
+                .iter()
+                .map(|&logprob| TokenLogprob {
+                    token: "t".to_string(),
+                    logprob,
+                })
+                .collect(),
+        }
+    }
+
+    #[test]
+    fn test_validate_rejects_unsupported_combinations() {
+        let beam = SamplingExtensions {
+            use_beam_search: true,
+            ..Default::default()
+        };
+        assert_eq!(beam.validate(None, false).unwrap_err().1, "use_beam_search");
+
+        let best_of = |n| SamplingExtensions {
+            best_of: Some(n),
+            ..Default::default()
+        };
+        assert!(best_of(0).validate(None, false).is_err());
+        assert!(best_of(2).validate(Some(3), false).is_err());
+        assert!(best_of(MAX_BEST_OF + 1).validate(None, false).is_err());
+        assert!(best_of(3).validate(None, true).is_err());
+        assert!(best_of(1).validate(None, true).is_ok());
+        assert!(best_of(3).validate(Some(2), false).is_ok());
+
+        let penalty = SamplingExtensions {
+            length_penalty: Some(0.5),
+            ..Default::default()
+        };
+        assert_eq!(
+            penalty.validate(None, false).unwrap_err().1,
+            "length_penalty"
+        );
+        let neutral = SamplingExtensions {
+            length_penalty: Some(1.0),
+            ..Default::default()
+        };
+        assert!(neutral.validate(None, false).is_ok());
+    }
+
+    #[test]
+    fn test_apply_copies_token_controls() {
+        let extensions: SamplingExtensions = serde_json::from_value(serde_json::json!({
+            "stop_token_ids": [2, 32000],
+            "ignore_eos": true,
+            "skip_special_tokens": false
+        }))
+        .unwrap();
+        let mut params = InferenceParams::default();
+        extensions.apply(&mut params);
+
+        assert_eq!(params.stop_token_ids, vec![2, 32000]);
+        assert!(params.ignore_eos);
+        assert_eq!(params.skip_special_tokens, Some(false));
+    }
+
+    #[test]
+    fn test_ranking_score_normalises_by_length() {
+        // Mean logprob prefers the longer, more confident candidate
+        let short = scored(&[-1.0]);
+        let long = scored(&[-0.5, -0.5, -0.5]);
+        assert!(ranking_score(&long, 1.0) > ranking_score(&short, 1.0));
+
+        // Without normalisation the raw sum wins for the short one
+        assert!(ranking_score(&short, 0.0) > ranking_score(&long, 0.0));
+
+        assert_eq!(ranking_score(&scored(&[]), 1.0), f64::NEG_INFINITY);
+    }
+}
@@ -0,0 +1,794 @@
+//! Request Scheduler
+//!
+//! Decides when a tracked request may start generating. Every request
+//! belongs to a priority class and a tenant. While fewer than
+//! `max_concurrent` requests are generating, requests start as soon as they
+//! arrive. Past that they wait. A free slot goes to the highest-priority
+//! class with someone waiting, and within it to the tenant that has had the
+//! least service for its fair-share weight; ties go to the oldest request.
+//!
+//! Preemption lets background work yield to interactive traffic. When a
+//! request of a non-preemptible class has waited `preemption.after_ms` with
+//! every slot taken, the most recently started request of a preemptible
+//! class is cancelled to free its slot. It ends the way any cancelled
+//! request does, and its client retries.
+//!
+//! The policy is read and replaced through `GET`/`PUT /admin/scheduler`, and
+//! per-class queue metrics are served at `GET /v1/queue/classes`.
+
+use crate::{
+    api::{admin::authorize_admin, cancellation::CancelSignal},
+    cli::serve::ServerState,
+    operations::queue::Priority,
+};
+use axum::{
+    Json,
+    extract::State,
+    http::{HeaderMap, StatusCode},
+    response::{IntoResponse, Response},
+};
+use serde::{Deserialize, Serialize};
+use serde_json::json;
+use std::{
+    collections::{BTreeMap, HashMap},
+    sync::{Arc, Mutex},
+    time::{Duration, Instant},
+};
+use tokio::sync::Notify;
+use tracing::{info, warn};
+
+/// Header naming a request's priority class
+pub const PRIORITY_CLASS_HEADER: &str = "x-inferno-priority-class";
+
+/// Header naming the tenant a request is scheduled for
+pub const TENANT_HEADER: &str = "x-inferno-tenant";
+
+/// Tenant of requests that do not name one
+pub const DEFAULT_TENANT: &str = "default";
+
+/// Class `Priority::Low` requests fall into when it is defined, so internal
+/// batch work such as evaluations and distillation yields by default
+pub const BACKGROUND_CLASS: &str = "background";
+
+/// Most requests the policy may let generate at once
+const MAX_CONCURRENT_LIMIT: usize = 4096;
+
+/// Smoothing factor for the per-class wait time moving average
+const WAIT_ALPHA: f64 = 0.2;
+
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct PriorityClass {
+    pub name: String,
+    /// Higher priorities are admitted first
+    pub priority: u32,
+    /// Running requests of this class may be cancelled for waiting
+    /// non-preemptible ones
+    #[serde(default)]
+    pub preemptible: bool,
+}
+
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct PreemptionPolicy {
+    #[serde(default)]
+    pub enabled: bool,
+    /// How long a non-preemptible request waits before one is preempted
+    #[serde(default = "default_preempt_after_ms")]
+    pub after_ms: u64,
+}
+
+fn default_preempt_after_ms() -> u64 {
+    1000
+}
+
+impl Default for PreemptionPolicy {
+    fn default() -> Self {
+        Self {
+            enabled: false,
+            after_ms: default_preempt_after_ms(),
+        }
+    }
+}
+
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct SchedulerPolicy {
+    /// Requests generating at once; 0 admits every request immediately
+    #[serde(default)]
+    pub max_concurrent: usize,
+    /// Class of requests that name none
+    pub default_class: String,
+    pub classes: Vec<PriorityClass>,
+    /// Fair-share weight per tenant; tenants not listed weigh 1
+    #[serde(default)]
+    pub tenant_weights: BTreeMap<String, f64>,
+    #[serde(default)]
+    pub preemption: PreemptionPolicy,
+}
+
+impl Default for SchedulerPolicy {
+    fn default() -> Self {
+        Self {
+            max_concurrent: 0,
+            default_class: "interactive".to_string(),
+            classes: vec![
+                PriorityClass {
+                    name: "interactive".to_string(),
+                    priority: 100,
+                    preemptible: false,
+                },
+                PriorityClass {
+                    name: "batch".to_string(),
+                    priority: 50,
+                    preemptible: false,
+                },
+                PriorityClass {
+                    name: BACKGROUND_CLASS.to_string(),
+                    priority: 10,
+                    preemptible: true,
+                },
+            ],
+            tenant_weights: BTreeMap::new(),
+            preemption: PreemptionPolicy::default(),
+        }
+    }
+}
+
+impl SchedulerPolicy {
+    fn validate(&self) -> Result<(), (String, &'static str)> {
+        if self.max_concurrent > MAX_CONCURRENT_LIMIT {
+            return Err((
+                format!("max_concurrent must be at most {}", MAX_CONCURRENT_LIMIT),
+                "max_concurrent",
+            ));
+        }
+        if self.classes.is_empty() {
+            return Err(("classes must not be empty".to_string(), "classes"));
+        }
+        for (index, class) in self.classes.iter().enumerate() {
+            if class.name.trim().is_empty() {
+                return Err((format!("class {} has no name", index), "classes"));
+            }
+            if self.classes[..index].iter().any(|c| c.name == class.name) {
+                return Err((
+                    format!("class '{}' is defined more than once", class.name),
+                    "classes",
+                ));
+            }
+        }
+        if self.class(&self.default_class).is_none() {
+            return Err((
+                format!("default_class '{}' is not defined", self.default_class),
+                "default_class",
+            ));
+        }
+        if let Some((tenant, _)) = self
+            .tenant_weights
+            .iter()
+            .find(|(_, weight)| !weight.is_finite() || **weight <= 0.0)
+        {
+            return Err((
+                format!("weight for tenant '{}' must be positive", tenant),
+                "tenant_weights",
+            ));
+        }
+        Ok(())
+    }
+
+    fn class(&self, name: &str) -> Option<&PriorityClass> {
+        self.classes.iter().find(|class| class.name == name)
+    }
+
+    /// Priority of a class; classes removed while requests held them rank
+    /// lowest
+    fn priority(&self, name: &str) -> u32 {
+        self.class(name).map_or(0, |class| class.priority)
+    }
+
+    fn preemptible(&self, name: &str) -> bool {
+        self.class(name).is_some_and(|class| class.preemptible)
+    }
+
+    fn weight(&self, tenant: &str) -> f64 {
+        self.tenant_weights.get(tenant).copied().unwrap_or(1.0)
+    }
+
+    fn has_capacity(&self, running: usize) -> bool {
+        self.max_concurrent == 0 || running < self.max_concurrent
+    }
+}
+
+/// Queue metrics for one priority class
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct ClassStats {
+    pub class: String,
+    pub priority: u32,
+    pub preemptible: bool,
+    pub queued: usize,
+    pub running: usize,
+    pub admitted: u64,
+    pub preempted: u64,
+    pub avg_wait_ms: u64,
+    pub oldest_wait_ms: u64,
+}
+
+/// Fair-share accounting for one tenant
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct TenantStats {
+    pub tenant: String,
+    pub weight: f64,
+    pub queued: usize,
+    pub running: usize,
+    pub admitted: u64,
+    /// Fraction of all admissions that went to this tenant
+    pub share: f64,
+}
+
+#[derive(Debug)]
+struct Waiter {
+    id: String,
+    class: String,
+    tenant: String,
+    since: Instant,
+    notify: Arc<Notify>,
+    cancel: Arc<CancelSignal>,
+}
+
+#[derive(Debug)]
+struct Slot {
+    class: String,
+    tenant: String,
+    started: Instant,
+    cancel: Arc<CancelSignal>,
+}
+
+#[derive(Debug, Default)]
+struct ClassCounters {
+    admitted: u64,
+    preempted: u64,
+    avg_wait_ms: f64,
+}
+
+#[derive(Debug, Default)]
+struct TenantCounters {
+    admitted: u64,
+    /// Service received divided by weight; the tenant with the least goes
+    /// next
+    virtual_time: f64,
+}
+
+#[derive(Debug, Default)]
+struct Inner {
+    policy: SchedulerPolicy,
+    waiting: Vec<Waiter>,
+    running: HashMap<String, Slot>,
+    classes: HashMap<String, ClassCounters>,
+    tenants: HashMap<String, TenantCounters>,
+    /// Virtual time of the last admission; tenants that were idle start
+    /// from here rather than with credit saved up
+    virtual_clock: f64,
+}
+
+impl Inner {
+    fn active(&self, tenant: &str) -> bool {
+        self.waiting.iter().any(|w| w.tenant == tenant)
+            || self.running.values().any(|s| s.tenant == tenant)
+    }
+
+    fn enqueue(&mut self, waiter: Waiter) {
+        if !self.active(&waiter.tenant) {
+            let clock = self.virtual_clock;
+            let tenant = self.tenants.entry(waiter.tenant.clone()).or_default();
+            tenant.virtual_time = tenant.virtual_time.max(clock);
+        }
+        self.waiting.push(waiter);
+    }
+
+    /// Start waiting requests while there are free slots
+    fn dispatch(&mut self) {
+        while self.policy.has_capacity(self.running.len()) && !self.waiting.is_empty() {
+            let next = (0..self.waiting.len())
+                .min_by(|&a, &b| {
+                    let (a, b) = (&self.waiting[a], &self.waiting[b]);
+                    let vt =
+                        |tenant: &str| self.tenants.get(tenant).map_or(0.0, |t| t.virtual_time);
+                    self.policy
+                        .priority(&b.class)
+                        .cmp(&self.policy.priority(&a.class))
+                        .then(vt(&a.tenant).total_cmp(&vt(&b.tenant)))
+                        .then(a.since.cmp(&b.since))
+                })
+                .unwrap();
+            let waiter = self.waiting.remove(next);
+            self.admit(waiter);
+        }
+    }
+
+    fn admit(&mut self, waiter: Waiter) {
+        let waited_ms = waiter.since.elapsed().as_secs_f64() * 1000.0;
+        let class = self.classes.entry(waiter.class.clone()).or_default();
+        class.avg_wait_ms = if class.admitted == 0 {
+            waited_ms
+        } else {
+            WAIT_ALPHA * waited_ms + (1.0 - WAIT_ALPHA) * class.avg_wait_ms
+        };
+        class.admitted += 1;
+
+        let weight = self.policy.weight(&waiter.tenant);
+        let tenant = self.tenants.entry(waiter.tenant.clone()).or_default();
+        self.virtual_clock = tenant.virtual_time;
+        tenant.virtual_time += 1.0 / weight;
+        tenant.admitted += 1;
+
+        self.running.insert(
+            waiter.id,
+            Slot {
+                class: waiter.class,
+                tenant: waiter.tenant,
+                started: Instant::now(),
+                cancel: waiter.cancel,
+            },
+        );
+        waiter.notify.notify_one();
+    }
+}
+
+/// Admission control for tracked requests
+#[derive(Debug, Default)]
+pub struct Scheduler {
+    inner: Mutex<Inner>,
+}
+
+impl Scheduler {
+    pub fn new() -> Self {
+        Self::default()
+    }
+
+    pub fn policy(&self) -> SchedulerPolicy {
+        self.inner.lock().unwrap().policy.clone()
+    }
+
+    /// Replace the policy, admitting waiting requests a larger limit makes
+    /// room for
+    pub fn set_policy(&self, policy: SchedulerPolicy) -> Result<(), (String, &'static str)> {
+        policy.validate()?;
+        let mut inner = self.inner.lock().unwrap();
+        inner.policy = policy;
+        inner.dispatch();
+        Ok(())
+    }
+
+    pub fn has_class(&self, name: &str) -> bool {
+        self.inner.lock().unwrap().policy.class(name).is_some()
+    }
+
+    /// The class a request runs in: the one it names if defined, else
+    /// `background` for low-priority requests when that class exists, else
+    /// the default class
+    pub fn resolve_class(&self, requested: Option<&str>, priority: Priority) -> String {
+        let inner = self.inner.lock().unwrap();
+        let policy = &inner.policy;
+        match requested {
+            Some(name) if policy.class(name).is_some() => name.to_string(),
+            _ if priority == Priority::Low && policy.class(BACKGROUND_CLASS).is_some() => {
+                BACKGROUND_CLASS.to_string()
+            }
+            _ => policy.default_class.clone(),
+        }
+    }
+
+    /// Wait until the request may start generating. Returns early, without
+    /// a slot, if the request is cancelled while waiting.
+    pub async fn admit(&self, id: &str, class: String, tenant: String, cancel: &Arc<CancelSignal>) {
+        let notify = Arc::new(Notify::new());
+        {
+            let mut inner = self.inner.lock().unwrap();
+            inner.enqueue(Waiter {
+                id: id.to_string(),
+                class,
+                tenant,
+                since: Instant::now(),
+                notify: Arc::clone(&notify),
+                cancel: Arc::clone(cancel),
+            });
+            inner.dispatch();
+        }
+
+        loop {
+            let (admitted, preempt_after) = {
+                let inner = self.inner.lock().unwrap();
+                let preempt_after = inner
+                    .waiting
+                    .iter()
+                    .find(|w| w.id == id)
+                    .filter(|w| {
+                        inner.policy.preemption.enabled && !inner.policy.preemptible(&w.class)
+                    })
+                    .map(|_| Duration::from_millis(inner.policy.preemption.after_ms));
+                (inner.running.contains_key(id), preempt_after)
+            };
+            if admitted {
+                return;
+            }
+
+            tokio::select! {
+                _ = notify.notified() => {}
+                _ = cancel.cancelled() => {
+                    self.release(id);
+                    return;
+                }
+                _ = tokio::time::sleep(preempt_after.unwrap_or_default()), if preempt_after.is_some() => {
+                    self.preempt_for(id);
+                }
+            }
+        }
+    }
+
+    /// Free a request's slot, or drop it from the wait list
+    pub fn release(&self, id: &str) {
+        let mut inner = self.inner.lock().unwrap();
+        inner.waiting.retain(|w| w.id != id);
+        if inner.running.remove(id).is_some() {
+            inner.dispatch();
+        }
+    }
+
+    /// Cancel a running preemptible request to make room for a waiting one
+    fn preempt_for(&self, id: &str) {
+        let mut inner = self.inner.lock().unwrap();
+        let Some(waiter) = inner.waiting.iter().find(|w| w.id == id) else {
+            return;
+        };
+        if inner.policy.has_capacity(inner.running.len()) || inner.policy.preemptible(&waiter.class)
+        {
+            return;
+        }
+        let waiter_priority = inner.policy.priority(&waiter.class);
+
+        let candidates = || {
+            inner
+                .running
+                .iter()
+                .filter(|(_, slot)| inner.policy.preemptible(&slot.class))
+                .filter(|(_, slot)| inner.policy.priority(&slot.class) < waiter_priority)
+        };
+        // One preemption at a time: wait for the last victim to stop
+        if candidates().any(|(_, slot)| slot.cancel.is_cancelled()) {
+            return;
+        }
+        let victim = candidates()
+            .min_by(|(_, a), (_, b)| {
+                inner
+                    .policy
+                    .priority(&a.class)
+                    .cmp(&inner.policy.priority(&b.class))
+                    .then(b.started.cmp(&a.started))
+            })
+            .map(|(victim, slot)| (victim.clone(), slot.class.clone(), Arc::clone(&slot.cancel)));
+
+        if let Some((victim, class, cancel)) = victim {
+            warn!("Preempting request {} ({}) for {}", victim, class, id);
+            cancel.cancel();
+            inner.classes.entry(class).or_default().preempted += 1;
+        }
+    }
+
+    pub fn class_stats(&self) -> Vec<ClassStats> {
+        let inner = self.inner.lock().unwrap();
+        let mut names: Vec<String> = inner
+            .policy
+            .classes
+            .iter()
+            .map(|c| c.name.clone())
+            .collect();
+        // Classes removed from the policy while requests still held them
+        for name in inner
+            .waiting
+            .iter()
+            .map(|w| &w.class)
+            .chain(inner.running.values().map(|s| &s.class))
+        {
+            if !names.contains(name) {
+                names.push(name.clone());
+            }
+        }
+
+        names
+            .into_iter()
+            .map(|name| {
+                let counters = inner.classes.get(&name);
+                let waiting = inner.waiting.iter().filter(|w| w.class == name);
+                ClassStats {
+                    priority: inner.policy.priority(&name),
+                    preemptible: inner.policy.preemptible(&name),
+                    queued: waiting.clone().count(),
+                    running: inner.running.values().filter(|s| s.class == name).count(),
+                    admitted: counters.map_or(0, |c| c.admitted),
+                    preempted: counters.map_or(0, |c| c.preempted),
+                    avg_wait_ms: counters.map_or(0, |c| c.avg_wait_ms as u64),
+                    oldest_wait_ms: waiting
+                        .map(|w| w.since.elapsed().as_millis() as u64)
+                        .max()
+                        .unwrap_or(0),
+                    class: name,
+                }
+            })
+            .collect()
+    }
+
+    pub fn tenant_stats(&self) -> Vec<TenantStats> {
+        let inner = self.inner.lock().unwrap();
+        let total: u64 = inner.tenants.values().map(|t| t.admitted).sum();
+        let mut tenants: Vec<TenantStats> = inner
+            .tenants
+            .iter()
+            .map(|(tenant, counters)| TenantStats {
+                tenant: tenant.clone(),
+                weight: inner.policy.weight(tenant),
+                queued: inner.waiting.iter().filter(|w| &w.tenant == tenant).count(),
+                running: inner
+                    .running
+                    .values()
+                    .filter(|s| &s.tenant == tenant)
+                    .count(),
+                admitted: counters.admitted,
+                share: if total == 0 {
+                    0.0
+                } else {
+                    counters.admitted as f64 / total as f64
+                },
+            })
+            .collect();
+        tenants.sort_by(|a, b| a.tenant.cmp(&b.tenant));
+        tenants
+    }
+}
+
+// API Handlers
+
+/// `GET /admin/scheduler` - the policy and per-tenant fair-share accounting
+/// (admin only)
+pub async fn get_policy(State(state): State<Arc<ServerState>>, headers: HeaderMap) -> Response {
+    if let Err(response) = authorize_admin(&headers) {
+        return response;
+    }
+
+    let scheduler = state.request_queue.scheduler();
+    Json(json!({
+        "policy": scheduler.policy(),
+        "tenants": scheduler.tenant_stats(),
+    }))
+    .into_response()
+}
+
+/// `PUT /admin/scheduler` - replace the policy (admin only)
+pub async fn put_policy(
+    State(state): State<Arc<ServerState>>,
+    headers: HeaderMap,
+    Json(policy): Json<SchedulerPolicy>,
+) -> Response {
+    if let Err(response) = authorize_admin(&headers) {
+        return response;
+    }
+
+    let scheduler = state.request_queue.scheduler();
+    if let Err((message, param)) = scheduler.set_policy(policy) {
+        return (
+            StatusCode::BAD_REQUEST,
+            Json(json!({
+                "error": {
+                    "message": message,
+                    "type": "invalid_request_error",
+                    "param": param,
+                    "code": null
+                }
+            })),
+        )
+            .into_response();
+    }
+
+    let policy = scheduler.policy();
+    info!(
+        "Scheduler policy updated: max_concurrent {}, {} classes, preemption {}",
+        policy.max_concurrent,
+        policy.classes.len(),
+        if policy.preemption.enabled {
+            "on"
+        } else {
+            "off"
+        }
+    );
+    Json(json!({
+        "policy": policy,
+        "tenants": scheduler.tenant_stats(),
+    }))
+    .into_response()
+}
+
+/// `GET /v1/queue/classes` - queue depth, waits and preemptions per class
+pub async fn class_stats(State(state): State<Arc<ServerState>>) -> Response {
+    let scheduler = state.request_queue.scheduler();
+    let policy = scheduler.policy();
+    Json(json!({
+        "object": "list",
+        "max_concurrent": policy.max_concurrent,
+        "data": scheduler.class_stats(),
+    }))
+    .into_response()
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn scheduler(max_concurrent: usize) -> Arc<Scheduler> {
+        let scheduler = Arc::new(Scheduler::new());
+        scheduler
+            .set_policy(SchedulerPolicy {
+                max_concurrent,
+                preemption: PreemptionPolicy {
+                    enabled: true,
+                    after_ms: 10,
+                },
+                ..SchedulerPolicy::default()
+            })
+            .unwrap();
+        scheduler
+    }
+
+    fn running(scheduler: &Scheduler, id: &str) -> bool {
+        scheduler.inner.lock().unwrap().running.contains_key(id)
+    }
+
+    #[test]
+    fn test_validate_policy() {
+        assert!(SchedulerPolicy::default().validate().is_ok());
+
+        let mut policy = SchedulerPolicy::default();
+        policy.default_class = "missing".to_string();
+        assert!(policy.validate().is_err());
+
+        let mut policy = SchedulerPolicy::default();
+        policy.tenant_weights.insert("acme".to_string(), 0.0);
+        assert!(policy.validate().is_err());
+    }
+
+    #[test]
+    fn test_resolve_class() {
+        let scheduler = Scheduler::new();
+        assert_eq!(
+            scheduler.resolve_class(Some("batch"), Priority::Normal),
+            "batch"
+        );
+        assert_eq!(
+            scheduler.resolve_class(Some("nope"), Priority::Normal),
+            "interactive"
+        );
+        assert_eq!(
+            scheduler.resolve_class(None, Priority::Low),
+            BACKGROUND_CLASS
+        );
+    }
+
+    #[tokio::test]
+    async fn test_priority_order_when_full() {
+        let scheduler = scheduler(1);
+        let cancel = Arc::new(CancelSignal::new());
+        scheduler
+            .admit(
+                "first",
+                "batch".to_string(),
+                DEFAULT_TENANT.to_string(),
+                &cancel,
+            )
+            .await;
+
+        let low = {
+            let scheduler = Arc::clone(&scheduler);
+            let cancel = Arc::new(CancelSignal::new());
+            tokio::spawn(async move {
+                scheduler
+                    .admit(
+                        "low",
+                        "batch".to_string(),
+                        DEFAULT_TENANT.to_string(),
+                        &cancel,
+                    )
+                    .await
+            })
+        };
+        tokio::time::sleep(Duration::from_millis(5)).await;
+        let high = {
+            let scheduler = Arc::clone(&scheduler);
+            let cancel = Arc::new(CancelSignal::new());
+            tokio::spawn(async move {
+                scheduler
+                    .admit(
+                        "high",
+                        "interactive".to_string(),
+                        DEFAULT_TENANT.to_string(),
+                        &cancel,
+                    )
+                    .await
+            })
+        };
+        tokio::time::sleep(Duration::from_millis(5)).await;
+
+        scheduler.release("first");
+        high.await.unwrap();
+        assert!(running(&scheduler, "high"));
+        assert!(!running(&scheduler, "low"));
+
+        scheduler.release("high");
+        low.await.unwrap();
+        assert!(running(&scheduler, "low"));
+    }
+
+    #[tokio::test]
+    async fn test_background_is_preempted() {
+        let scheduler = scheduler(1);
+        let background = Arc::new(CancelSignal::new());
+        scheduler
+            .admit(
+                "bg",
+                BACKGROUND_CLASS.to_string(),
+                DEFAULT_TENANT.to_string(),
+                &background,
+            )
+            .await;
+
+        let waiting = {
+            let scheduler = Arc::clone(&scheduler);
+            let cancel = Arc::new(CancelSignal::new());
+            tokio::spawn(async move {
+                scheduler
+                    .admit(
+                        "chat",
+                        "interactive".to_string(),
+                        DEFAULT_TENANT.to_string(),
+                        &cancel,
+                    )
+                    .await
+            })
+        };
+
+        background.cancelled().await;
+        scheduler.release("bg");
+        waiting.await.unwrap();
+        assert!(running(&scheduler, "chat"));
+        let stats = scheduler.class_stats();
+        let bg = stats.iter().find(|s| s.class == BACKGROUND_CLASS).unwrap();
+        assert_eq!(bg.preempted, 1);
+    }
+
+    #[test]
+    fn test_fair_share_prefers_lighter_tenant() {
+        let scheduler = Scheduler::new();
+        let mut policy = SchedulerPolicy {
+            max_concurrent: 1,
+            ..SchedulerPolicy::default()
+        };
+        policy.tenant_weights.insert("big".to_string(), 3.0);
+        scheduler.set_policy(policy).unwrap();
+
+        let mut inner = scheduler.inner.lock().unwrap();
+        let waiter = |id: &str, tenant: &str| Waiter {
+            id: id.to_string(),
+            class: "interactive".to_string(),
+            tenant: tenant.to_string(),
+            since: Instant::now(),
+            notify: Arc::new(Notify::new()),
+            cancel: Arc::new(CancelSignal::new()),
+        };
+        // Four admissions in turn: "big" weighs three times "small"
+        let mut order = Vec::new();
+        for round in 0..4 {
+            inner.enqueue(waiter(&format!("big-{}", round), "big"));
+            inner.enqueue(waiter(&format!("small-{}", round), "small"));
+        }
+        for _ in 0..4 {
+            inner.dispatch();
+            let id = inner.running.keys().next().unwrap().clone();
+            order.push(inner.running.remove(&id).unwrap().tenant);
+        }
+        assert_eq!(order.iter().filter(|t| *t == "big").count(), 3);
+    }
+}
@@ -0,0 +1,808 @@
+//! Conversation Sessions
+//!
+//! `POST /v1/sessions` opens a server-side conversation, and each
+//! `POST /v1/sessions/:session_id/messages` appends a user turn and returns
+//! the model's reply, so clients no longer resend the whole history.
+//!
+//! When a turn's prompt would pass `compaction.threshold` of the context
+//! window, older turns are compacted before generating: with the
+//! `summarize` strategy they are folded into a rolling memory block that
+//! stays at the top of the prompt, with `truncate` they are dropped. Only
+//! the `keep_recent` latest messages are kept verbatim. Every compaction is
+//! reported on the turn that triggered it and kept in the session's history,
+//! and the memory can be read, replaced or compacted on demand.
+
+use crate::{
+    api::{
+        cancellation::{FinishReason, generate_cancellable, with_request_id},
+        deadline::resolve_deadline,
+        invalid_request,
+        openai::{ChatMessage, estimate_tokens, format_chat_messages, get_or_load_backend},
+        queue::{QueueTicket, priority_from_headers},
+        runtime_config::{RuntimeSettings, sampling_defaults},
+    },
+    backends::{BackendHandle, InferenceParams},
+    cli::serve::ServerState,
+};
+use axum::{
+    Json,
+    extract::{Path, State},
+    http::{HeaderMap, StatusCode},
+    response::{IntoResponse, Response},
+};
+use chrono::{DateTime, Utc};
+use serde::{Deserialize, Serialize};
+use serde_json::json;
+use std::{collections::HashMap, sync::Arc, time::Duration};
+use tokio::sync::{Mutex, RwLock};
+use uuid::Uuid;
+
+/// Compaction events kept per session, newest last
+const MAX_COMPACTION_HISTORY: usize = 20;
+
+fn default_threshold() -> f32 {
+    0.75
+}
+
+fn default_keep_recent() -> usize {
+    6
+}
+
+fn default_memory_words() -> u32 {
+    200
+}
+
+fn default_max_tokens() -> u32 {
+    sampling_defaults().max_tokens
+}
+
+fn default_temperature() -> f32 {
+    sampling_defaults().temperature
+}
+
+/// What happens to older turns when history approaches the context limit
+#[derive(Debug, Clone, Copy, Default, PartialEq, Eq, Serialize, Deserialize)]
+#[serde(rename_all = "lowercase")]
+pub enum CompactionStrategy {
+    /// Fold older turns into the rolling memory block
+    #[default]
+    Summarize,
+    /// Drop older turns, leaving the memory as it is
+    Truncate,
+    /// Never compact; turns that no longer fit are rejected
+    None,
+}
+
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct CompactionConfig {
+    #[serde(default)]
+    pub strategy: CompactionStrategy,
+    /// Fraction of the context window the prompt plus `max_tokens` may fill
+    /// before older turns are compacted
+    #[serde(default = "default_threshold")]
+    pub threshold: f32,
+    /// Latest messages that are never compacted (at least 1, so the turn
+    /// being answered always survives)
+    #[serde(default = "default_keep_recent")]
+    pub keep_recent: usize,
+    /// Length the summarized memory is held to
+    #[serde(default = "default_memory_words")]
+    pub memory_words: u32,
+}
+
+impl Default for CompactionConfig {
+    fn default() -> Self {
+        Self {
+            strategy: CompactionStrategy::default(),
+            threshold: default_threshold(),
+            keep_recent: default_keep_recent(),
+            memory_words: default_memory_words(),
+        }
+    }
+}
+
+impl CompactionConfig {
+    fn validate(&self) -> Result<(), String> {
+        if !(self.threshold > 0.0 && self.threshold <= 1.0) {
+            return Err("compaction.threshold must be above 0 and at most 1".to_string());
+        }
+        if !(1..=1000).contains(&self.keep_recent) {
+            return Err("compaction.keep_recent must be between 1 and 1000".to_string());
+        }
+        if !(10..=2000).contains(&self.memory_words) {
+            return Err("compaction.memory_words must be between 10 and 2000".to_string());
+        }
+        Ok(())
+    }
+}
+
+/// A record of older turns being compacted
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct CompactionEvent {
+    pub strategy: CompactionStrategy,
+    /// Messages removed from the verbatim history
+    pub compacted_messages: usize,
+    /// Prompt tokens before and after compaction
+    pub tokens_before: u32,
+    pub tokens_after: u32,
+    /// Triggered by `POST /v1/sessions/:session_id/compact` rather than by
+    /// the history nearing the context limit
+    pub manual: bool,
+    pub created_at: DateTime<Utc>,
+}
+
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct Session {
+    pub id: String,
+    pub object: String,
+    pub model: String,
+    pub system: Option<String>,
+    pub compaction: CompactionConfig,
+    /// Rolling summary of compacted turns, placed after the system prompt
+    pub memory: Option<String>,
+    /// Turns not yet compacted, oldest first
+    pub messages: Vec<ChatMessage>,
+    /// Messages compacted over the session's lifetime
+    pub compacted_messages: usize,
+    pub compactions: Vec<CompactionEvent>,
+    pub created_at: DateTime<Utc>,
+    pub updated_at: DateTime<Utc>,
+}
+
+impl Session {
+    /// The messages a turn is generated from: the system prompt with the
+    /// memory block appended, then the uncompacted turns
+    fn prompt_messages(&self) -> Vec<ChatMessage> {
+        let mut system = self.system.clone().unwrap_or_default();
+        if let Some(memory) = &self.memory {
+            if !system.is_empty() {
+                system.push_str("\n\n");
+            }
+            system.push_str("Summary of the earlier conversation:\n");
+            system.push_str(memory);
+        }
+
+        let mut messages = Vec::with_capacity(self.messages.len() + 1);
+        if !system.is_empty() {
+            messages.push(message("system", system));
+        }
+        messages.extend(self.messages.iter().cloned());
+        messages
+    }
+
+    fn prompt(&self) -> String {
+        format_chat_messages(&self.prompt_messages())
+    }
+
+    /// Index of the first message kept verbatim, never splitting a user turn
+    /// from the reply that follows it
+    fn compaction_split(&self) -> usize {
+        let mut split = self
+            .messages
+            .len()
+            .saturating_sub(self.compaction.keep_recent);
+        while split > 0 && self.messages[split].role != "user" {
+            split -= 1;
+        }
+        split
+    }
+
+    fn record(&mut self, event: CompactionEvent) {
+        self.compacted_messages += event.compacted_messages;
+        self.compactions.push(event);
+        if self.compactions.len() > MAX_COMPACTION_HISTORY {
+            self.compactions.remove(0);
+        }
+    }
+}
+
+fn message(role: &str, content: String) -> ChatMessage {
+    ChatMessage {
+        role: role.to_string(),
+        content,
+        name: None,
+        tool_calls: None,
+        tool_call_id: None,
+    }
+}
+
+/// In-memory store of open sessions. Each session has its own lock, so turns
+/// on one session run one at a time while sessions proceed independently.
+#[derive(Debug, Default)]
+pub struct SessionStore {
+    sessions: RwLock<HashMap<String, Arc<Mutex<Session>>>>,
+}
+
+impl SessionStore {
+    pub fn new() -> Self {
+        Self::default()
+    }
+
+    /// Add a session after dropping idle ones. Returns false, storing
+    /// nothing, when the store is still at `max_sessions`.
+    async fn insert(&self, session: Session, settings: &RuntimeSettings) -> bool {
+        let mut sessions = self.sessions.write().await;
+        prune_idle(
+            &mut sessions,
+            Duration::from_secs(settings.session_idle_seconds),
+        );
+        if settings.max_sessions > 0 && sessions.len() >= settings.max_sessions {
+            return false;
+        }
+        sessions.insert(session.id.clone(), Arc::new(Mutex::new(session)));
+        true
+    }
+
+    async fn get(&self, id: &str) -> Option<Arc<Mutex<Session>>> {
+        self.sessions.read().await.get(id).cloned()
+    }
+
+    async fn remove(&self, id: &str) -> bool {
+        self.sessions.write().await.remove(id).is_some()
+    }
+
+    /// Ids of sessions unused for longer than `idle`, least recently used
+    /// first; sessions busy with a turn are skipped
+    pub(crate) async fn idle_ids(&self, idle: Duration) -> Vec<String> {
+        let cutoff = Utc::now() - chrono::Duration::from_std(idle).unwrap();
+        let sessions = self.sessions.read().await;
+        let mut idle: Vec<(DateTime<Utc>, String)> = sessions
+            .iter()
+            .filter_map(|(id, session)| {
+                let session = session.try_lock().ok()?;
+                (session.updated_at <= cutoff).then(|| (session.updated_at, id.clone()))
+            })
+            .collect();
+        idle.sort();
+        idle.into_iter().map(|(_, id)| id).collect()
+    }
+
+    /// Drop the given sessions, except any that became busy or were used
+    /// again within `idle`. Returns how many were dropped.
+    pub(crate) async fn evict(&self, ids: &[String], idle: Duration) -> usize {
+        let cutoff = Utc::now() - chrono::Duration::from_std(idle).unwrap();
+        let mut sessions = self.sessions.write().await;
+        let mut evicted = 0;
+        for id in ids {
+            let still_idle = sessions.get(id).is_some_and(|session| {
+                session
+                    .try_lock()
+                    .is_ok_and(|session| session.updated_at <= cutoff)
+            });
+            if still_idle {
+                sessions.remove(id);
+                evicted += 1;
+            }
+        }
+        evicted
+    }
+}
+
+/// Drop sessions nobody has used within the idle window; sessions busy with
+/// a turn are kept
+fn prune_idle(sessions: &mut HashMap<String, Arc<Mutex<Session>>>, idle: Duration) {
+    let cutoff = Utc::now() - chrono::Duration::from_std(idle).unwrap();
+    sessions.retain(|_, session| {
+        session
+            .try_lock()
+            .map_or(true, |session| session.updated_at > cutoff)
+    });
+}
+
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct CreateSessionRequest {
+    pub model: String,
+    #[serde(default)]
+    pub system: Option<String>,
+    #[serde(default)]
+    pub compaction: CompactionConfig,
+}
+
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct SessionMessageRequest {
+    pub content: String,
+    #[serde(default = "default_max_tokens")]
+    pub max_tokens: u32,
+    #[serde(default = "default_temperature")]
+    pub temperature: f32,
+    #[serde(default)]
+    pub top_p: Option<f32>,
+    #[serde(default)]
+    pub seed: Option<u64>,
+    /// Server-enforced time budget for compaction and reply, in milliseconds
+    #[serde(default)]
+    pub timeout_ms: Option<u64>,
+}
+
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct SessionMessageResponse {
+    pub object: String,
+    pub session_id: String,
+    pub message: ChatMessage,
+    pub finish_reason: String,
+    /// Set when older turns were compacted before this reply
+    pub compaction: Option<CompactionEvent>,
+    /// Tokens in the prompt the reply was generated from
+    pub context_tokens: u32,
+    pub context_window: u32,
+}
+
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct SessionMemory {
+    pub object: String,
+    pub session_id: String,
+    pub memory: Option<String>,
+    pub compacted_messages: usize,
+    pub compactions: Vec<CompactionEvent>,
+}
+
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct UpdateMemoryRequest {
+    /// Replacement memory block; `null` clears it
+    pub memory: Option<String>,
+}
+
+#[derive(Debug, Clone, Default, Serialize, Deserialize)]
+pub struct CompactRequest {
+    /// Overrides the session's strategy for this compaction
+    #[serde(default)]
+    pub strategy: Option<CompactionStrategy>,
+}
+
+/// Why a turn could not be completed
+enum TurnError {
+    Interrupted(FinishReason),
+    Failed(anyhow::Error),
+}
+
+async fn count_tokens(backend: &BackendHandle, text: &str) -> u32 {
+    match backend.tokenize(text).await {
+        Ok(tokens) => tokens.len() as u32,
+        Err(_) => estimate_tokens(text),
+    }
+}
+
+/// Compact the turns before the verbatim tail of `session` with `strategy`.
+/// Returns `None` when there is nothing old enough to compact.
+async fn compact(
+    session: &mut Session,
+    strategy: CompactionStrategy,
+    backend: &BackendHandle,
+    ticket: &QueueTicket,
+    manual: bool,
+) -> Result<Option<CompactionEvent>, TurnError> {
+    let split = session.compaction_split();
+    if split == 0 || strategy == CompactionStrategy::None {
+        return Ok(None);
+    }
+    let tokens_before = count_tokens(backend, &session.prompt()).await;
+
+    if strategy == CompactionStrategy::Summarize {
+        let prompt = format!(
+            "Update the running memory of a conversation with the turns below. Write \
+             concise notes of at most {} words that keep facts, decisions, names, the \
+             user's preferences and open questions; drop small talk.\n\n\
+             Current memory:\n{}\n\nNew turns:\n{}\n\nUpdated memory:",
+            session.compaction.memory_words,
+            session.memory.as_deref().unwrap_or("(empty)"),
+            format_chat_messages(&session.messages[..split])
+        );
+        let params = InferenceParams {
+            max_tokens: session.compaction.memory_words * 2 + 32,
+            temperature: 0.2,
+            ..Default::default()
+        };
+        let generation = generate_cancellable(
+            backend,
+            &prompt,
+            &params,
+            ticket.cancel_signal(),
+            ticket.deadline(),
+        )
+        .await
+        .map_err(TurnError::Failed)?;
+        if generation.finish_reason != FinishReason::Stop {
+            return Err(TurnError::Interrupted(generation.finish_reason));
+        }
+        session.memory = Some(generation.text.trim().to_string());
+    }
+
+    session.messages.drain(..split);
+    let event = CompactionEvent {
+        strategy,
+        compacted_messages: split,
+        tokens_before,
+        tokens_after: count_tokens(backend, &session.prompt()).await,
+        manual,
+        created_at: Utc::now(),
+    };
+    session.record(event.clone());
+    Ok(Some(event))
+}
+
+fn session_not_found(session_id: &str) -> Response {
+    (
+        StatusCode::NOT_FOUND,
+        Json(json!({
+            "error": {
+                "message": format!("No session with id {}", session_id),
+                "type": "invalid_request_error",
+                "param": "session_id",
+                "code": "session_not_found"
+            }
+        })),
+    )
+        .into_response()
+}
+
+fn turn_error(error: TurnError) -> Response {
+    let (status, message, code) = match error {
+        TurnError::Interrupted(reason) => (
+            StatusCode::REQUEST_TIMEOUT,
+            format!("Generation stopped early: {}", reason.as_str()),
+            reason.as_str(),
+        ),
+        TurnError::Failed(e) => (
+            StatusCode::INTERNAL_SERVER_ERROR,
+            format!("Inference failed: {}", e),
+            "inference_failed",
+        ),
+    };
+    (
+        status,
+        Json(json!({
+            "error": {
+                "message": message,
+                "type": "server_error",
+                "param": null,
+                "code": code
+            }
+        })),
+    )
+        .into_response()
+}
+
+fn memory_view(session: &Session) -> SessionMemory {
+    SessionMemory {
+        object: "session.memory".to_string(),
+        session_id: session.id.clone(),
+        memory: session.memory.clone(),
+        compacted_messages: session.compacted_messages,
+        compactions: session.compactions.clone(),
+    }
+}
+
+// API Handlers
+
+/// `POST /v1/sessions` - open a conversation session
+pub async fn create_session(
+    State(state): State<Arc<ServerState>>,
+    headers: HeaderMap,
+    Json(request): Json<CreateSessionRequest>,
+) -> Response {
+    if let Err(response) = state.flags.require("sessions", &headers).await {
+        return response;
+    }
+    if let Err(message) = request.compaction.validate() {
+        return invalid_request(message, Some("compaction"), None);
+    }
+
+    let now = Utc::now();
+    let session = Session {
+        id: format!("sess-{}", Uuid::new_v4()),
+        object: "session".to_string(),
+        model: request.model,
+        system: request.system,
+        compaction: request.compaction,
+        memory: None,
+        messages: Vec::new(),
+        compacted_messages: 0,
+        compactions: Vec::new(),
+        created_at: now,
+        updated_at: now,
+    };
+    if !state
+        .sessions
+        .insert(session.clone(), &state.runtime_config.current())
+        .await
+    {
+        return (
+            StatusCode::TOO_MANY_REQUESTS,
+            Json(json!({
+                "error": {
+                    "message": "The server is holding as many sessions as it allows; close unused sessions or retry later",
+                    "type": "rate_limit_error",
+                    "param": null,
+                    "code": "session_limit_reached"
+                }
+            })),
+        )
+            .into_response();
+    }
+
+    (StatusCode::CREATED, Json(session)).into_response()
+}
+
+/// `GET /v1/sessions/:session_id` - the session with its memory and turns
+pub async fn get_session(
+    State(state): State<Arc<ServerState>>,
+    Path(session_id): Path<String>,
+) -> Response {
+    match state.sessions.get(&session_id).await {
+        Some(session) => Json(session.lock().await.clone()).into_response(),
+        None => session_not_found(&session_id),
+    }
+}
+
+/// `DELETE /v1/sessions/:session_id` - close a session
+pub async fn delete_session(
+    State(state): State<Arc<ServerState>>,
+    Path(session_id): Path<String>,
+) -> Response {
+    if state.sessions.remove(&session_id).await {
+        StatusCode::NO_CONTENT.into_response()
+    } else {
+        session_not_found(&session_id)
+    }
+}
+
+/// `POST /v1/sessions/:session_id/messages` - add a user turn and reply,
+/// compacting older turns first when the history nears the context limit
+pub async fn send_message(
+    State(state): State<Arc<ServerState>>,
+    Path(session_id): Path<String>,
+    headers: HeaderMap,
+    Json(request): Json<SessionMessageRequest>,
+) -> Response {
+    if request.content.trim().is_empty() {
+        return invalid_request(
+            "content must not be empty".to_string(),
+            Some("content"),
+            None,
+        );
+    }
+    let Some(session) = state.sessions.get(&session_id).await else {
+        return session_not_found(&session_id);
+    };
+    // Held for the whole turn so concurrent turns cannot interleave
+    let mut session = session.lock().await;
+
+    let ticket = match state.request_queue.enqueue_request(
+        &headers,
+        &session.model,
+        priority_from_headers(&headers),
+    ) {
+        Ok(ticket) => ticket
+            .with_scheduling(None, &headers)
+            .with_deadline(resolve_deadline(request.timeout_ms, None)),
+        Err(response) => return response,
+    };
+    let request_id = ticket.id().to_string();
+
+    let backend = match get_or_load_backend(&state, &session.model).await {
+        Ok(backend) => backend,
+        Err(e) => {
+            return invalid_request(format!("Failed to load model: {}", e), Some("model"), None);
+        }
+    };
+
+    ticket.start().await;
+    let context_window = state.config.backend_config.context_size;
+    // Work on a copy so a failed turn leaves the session untouched
+    let mut draft = session.clone();
+    draft.messages.push(message("user", request.content));
+
+    let mut context_tokens = count_tokens(&backend, &draft.prompt()).await;
+    let budget = (context_window as f32 * draft.compaction.threshold) as u32;
+    let mut compaction = None;
+    if context_tokens + request.max_tokens > budget {
+        let strategy = draft.compaction.strategy;
+        compaction = match compact(&mut draft, strategy, &backend, &ticket, false).await {
+            Ok(event) => event,
+            Err(error) => return turn_error(error),
+        };
+        if let Some(event) = &compaction {
+            context_tokens = event.tokens_after;
+        }
+    }
+    if context_tokens + request.max_tokens > context_window {
+        return invalid_request(
+            format!(
+                "The session needs {} prompt tokens plus max_tokens {}, more than the \
+                 context window of {}",
+                context_tokens, request.max_tokens, context_window
+            ),
+            Some("content"),
+            Some("context_length_exceeded"),
+        );
+    }
+
+    let params = InferenceParams {
+        max_tokens: request.max_tokens,
+        temperature: request.temperature,
+        top_p: request.top_p.unwrap_or(sampling_defaults().top_p),
+        seed: request.seed,
+        ..Default::default()
+    };
+    let prompt = draft.prompt();
+    let generation = match generate_cancellable(
+        &backend,
+        &prompt,
+        &params,
+        ticket.cancel_signal(),
+        ticket.deadline(),
+    )
+    .await
+    {
+        Ok(generation) => generation,
+        Err(e) => return turn_error(TurnError::Failed(e)),
+    };
+    if generation.finish_reason != FinishReason::Stop {
+        return turn_error(TurnError::Interrupted(generation.finish_reason));
+    }
+
+    let reply = message("assistant", generation.text.trim().to_string());
+    draft.messages.push(reply.clone());
+    draft.updated_at = Utc::now();
+    *session = draft;
+
+    let response = Json(SessionMessageResponse {
+        object: "session.message".to_string(),
+        session_id,
+        message: reply,
+        finish_reason: generation.finish_reason.as_str().to_string(),
+        compaction,
+        context_tokens,
+        context_window,
+    })
+    .into_response();
+
+    with_request_id(response, &request_id)
+}
+
+/// `POST /v1/sessions/:session_id/compact` - compact older turns now
+pub async fn compact_session(
+    State(state): State<Arc<ServerState>>,
+    Path(session_id): Path<String>,
+    headers: HeaderMap,
+    request: Option<Json<CompactRequest>>,
+) -> Response {
+    let Some(session) = state.sessions.get(&session_id).await else {
+        return session_not_found(&session_id);
+    };
+    let mut session = session.lock().await;
+    let strategy = request
+        .and_then(|Json(request)| request.strategy)
+        .unwrap_or(session.compaction.strategy);
+    if strategy == CompactionStrategy::None {
+        return invalid_request(
+            "Compaction is disabled for this session; pass a strategy".to_string(),
+            Some("strategy"),
+            None,
+        );
+    }
+
+    let ticket = match state.request_queue.enqueue_request(
+        &headers,
+        &session.model,
+        priority_from_headers(&headers),
+    ) {
+        Ok(ticket) => ticket.with_scheduling(None, &headers),
+        Err(response) => return response,
+    };
+    let request_id = ticket.id().to_string();
+
+    let backend = match get_or_load_backend(&state, &session.model).await {
+        Ok(backend) => backend,
+        Err(e) => {
+            return invalid_request(format!("Failed to load model: {}", e), Some("model"), None);
+        }
+    };
+
+    ticket.start().await;
+    let mut draft = session.clone();
+    if let Err(error) = compact(&mut draft, strategy, &backend, &ticket, true).await {
+        return turn_error(error);
+    }
+    draft.updated_at = Utc::now();
+    *session = draft;
+
+    with_request_id(Json(memory_view(&session)).into_response(), &request_id)
+}
+
+/// `GET /v1/sessions/:session_id/memory` - the rolling memory block and
+/// compaction history
+pub async fn get_memory(
+    State(state): State<Arc<ServerState>>,
+    Path(session_id): Path<String>,
+) -> Response {
+    match state.sessions.get(&session_id).await {
+        Some(session) => Json(memory_view(&*session.lock().await)).into_response(),
+        None => session_not_found(&session_id),
+    }
+}
+
+/// `PUT /v1/sessions/:session_id/memory` - replace or clear the memory block
+pub async fn put_memory(
+    State(state): State<Arc<ServerState>>,
+    Path(session_id): Path<String>,
+    Json(request): Json<UpdateMemoryRequest>,
+) -> Response {
+    let Some(session) = state.sessions.get(&session_id).await else {
+        return session_not_found(&session_id);
+    };
+    let mut session = session.lock().await;
+    session.memory = request.memory.filter(|memory| !memory.trim().is_empty());
+    session.updated_at = Utc::now();
+
+    Json(memory_view(&session)).into_response()
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn session(roles: &[&str]) -> Session {
+        let now = Utc::now();
+        Session {
+            id: "sess-1".to_string(),
+            object: "session".to_string(),
+            model: "m".to_string(),
+            system: Some("Be brief.".to_string()),
+            compaction: CompactionConfig {
+                keep_recent: 2,
+                ..Default::default()
+            },
+            memory: None,
+            messages: roles
+                .iter()
+                .enumerate()
+                .map(|(i, role)| message(role, format!("turn {}", i)))
+                .collect(),
+            compacted_messages: 0,
+            compactions: Vec::new(),
+            created_at: now,
+            updated_at: now,
+        }
+    }
+
+    #[test]
+    fn test_split_keeps_whole_turns() {
+        // Keeping two would start the tail on a reply, so its question stays too
+        let tail_on_reply = session(&["user", "assistant", "user", "assistant", "user"]);
+        assert_eq!(tail_on_reply.compaction_split(), 2);
+
+        let even = session(&["user", "assistant", "user", "assistant"]);
+        assert_eq!(even.compaction_split(), 2);
+
+        assert_eq!(session(&["user"]).compaction_split(), 0);
+    }
+
+    #[test]
+    fn test_memory_follows_system_prompt() {
+        let mut session = session(&["user"]);
+        session.memory = Some("The user is called Ada.".to_string());
+        let messages = session.prompt_messages();
+        assert_eq!(messages.len(), 2);
+        assert_eq!(
+            messages[0].content,
+            "Be brief.\n\nSummary of the earlier conversation:\nThe user is called Ada."
+        );
+    }
+
+    #[test]
+    fn test_compaction_defaults() {
+        let request: CreateSessionRequest =
+            serde_json::from_value(json!({ "model": "m", "compaction": { "threshold": 0.5 } }))
+                .unwrap();
+        assert_eq!(request.compaction.strategy, CompactionStrategy::Summarize);
+        assert_eq!(request.compaction.keep_recent, 6);
+        assert!(request.compaction.validate().is_ok());
+
+        let bad = CompactionConfig {
+            threshold: 1.5,
+            ..Default::default()
+        };
+        assert!(bad.validate().is_err());
+    }
+}
@@ -0,0 +1,512 @@
+//! Shadow Traffic Mirroring
+//!
+//! A shadow configuration mirrors a sample of the requests served by one
+//! model to a second model. The shadow generation runs in the background
+//! after the caller has been answered and its output is never returned to
+//! the caller; instead both outputs are kept for offline comparison and
+//! exposed through the admin-only `GET /v1/shadow/:model_id/results`.
+
+use crate::{
+    api::{
+        admin::authorize_admin,
+        cancellation::{CancelSignal, generate_cancellable},
+        openai::get_or_load_backend,
+    },
+    backends::InferenceParams,
+    cli::serve::ServerState,
+};
+use axum::{
+    Json,
+    body::Body,
+    extract::{Path, Query, State},
+    http::{HeaderMap, StatusCode},
+    response::{IntoResponse, Response},
+};
+use serde::{Deserialize, Serialize};
+use serde_json::json;
+use std::{
+    collections::{HashMap, HashSet, VecDeque},
+    sync::Arc,
+    time::{Duration, Instant},
+};
+use tokio::sync::{RwLock, Semaphore};
+use tracing::{debug, warn};
+use uuid::Uuid;
+
+/// Mirrored results kept in memory across all source models
+const MAX_SHADOW_RESULTS: usize = 1000;
+
+/// Upper bound on a single shadow generation
+const SHADOW_TIMEOUT: Duration = Duration::from_secs(300);
+
+/// Largest primary response body buffered for comparison
+const MAX_CAPTURED_BODY_BYTES: usize = 4 * 1024 * 1024;
+
+fn default_max_concurrent() -> usize {
+    2
+}
+
+/// Mirroring settings for one source model
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct ShadowConfig {
+    pub target_model: String,
+    /// Percentage of the source model's requests to mirror
+    pub sample_percent: f64,
+    /// Shadow generations allowed at once; excess samples are dropped
+    #[serde(default = "default_max_concurrent")]
+    pub max_concurrent: usize,
+}
+
+impl ShadowConfig {
+    pub fn validate(&self, source_model: &str) -> Result<(), String> {
+        if self.target_model.trim().is_empty() {
+            return Err("target_model must not be empty".to_string());
+        }
+        if self.target_model == source_model {
+            return Err("target_model must differ from the mirrored model".to_string());
+        }
+        if !(0.0..=100.0).contains(&self.sample_percent) {
+            return Err("sample_percent must be between 0 and 100".to_string());
+        }
+        if self.max_concurrent == 0 {
+            return Err("max_concurrent must be at least 1".to_string());
+        }
+        Ok(())
+    }
+}
+
+/// Mirroring counters for one source model
+#[derive(Debug, Clone, Default, Serialize, Deserialize)]
+pub struct ShadowStats {
+    pub mirrored: u64,
+    /// Samples skipped because `max_concurrent` shadow generations were running
+    pub dropped: u64,
+    pub failed: u64,
+}
+
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct ShadowStatus {
+    pub model: String,
+    pub config: ShadowConfig,
+    pub stats: ShadowStats,
+}
+
+/// Comparison record for one mirrored request
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct ShadowResult {
+    pub id: String,
+    pub request_id: String,
+    pub source_model: String,
+    pub target_model: String,
+    pub prompt: String,
+    /// Primary output, when the primary response was not streamed
+    pub primary_output: Option<String>,
+    pub primary_latency_ms: u64,
+    pub shadow_output: Option<String>,
+    pub shadow_latency_ms: u64,
+    pub shadow_error: Option<String>,
+    /// Jaccard similarity of the two outputs' whitespace-separated tokens
+    pub similarity: Option<f64>,
+    pub created_at: chrono::DateTime<chrono::Utc>,
+}
+
+struct ShadowEntry {
+    config: ShadowConfig,
+    stats: ShadowStats,
+    permits: Arc<Semaphore>,
+}
+
+/// A request selected for mirroring, holding a concurrency permit
+pub struct ShadowSample {
+    source_model: String,
+    target_model: String,
+    permit: tokio::sync::OwnedSemaphorePermit,
+}
+
+/// Registry of shadow configurations and their recorded results
+#[derive(Default)]
+pub struct ShadowManager {
+    entries: RwLock<HashMap<String, ShadowEntry>>,
+    results: RwLock<VecDeque<ShadowResult>>,
+}
+
+impl ShadowManager {
+    pub fn new() -> Self {
+        Self::default()
+    }
+
+    pub async fn set(&self, model: &str, config: ShadowConfig) {
+        let permits = Arc::new(Semaphore::new(config.max_concurrent));
+        self.entries.write().await.insert(
+            model.to_string(),
+            ShadowEntry {
+                config,
+                stats: ShadowStats::default(),
+                permits,
+            },
+        );
+    }
+
+    pub async fn remove(&self, model: &str) -> bool {
+        self.entries.write().await.remove(model).is_some()
+    }
+
+    pub async fn list(&self) -> Vec<ShadowStatus> {
+        let mut statuses: Vec<ShadowStatus> = self
+            .entries
+            .read()
+            .await
+            .iter()
+            .map(|(model, entry)| ShadowStatus {
+                model: model.clone(),
+                config: entry.config.clone(),
+                stats: entry.stats.clone(),
+            })
+            .collect();
+        statuses.sort_by(|a, b| a.model.cmp(&b.model));
+        statuses
+    }
+
+    /// Decide whether a request served by `model` should be mirrored
+    pub async fn sample(&self, model: &str) -> Option<ShadowSample> {
+        let mut entries = self.entries.write().await;
+        let entry = entries.get_mut(model)?;
+        if rand::random::<f64>() * 100.0 >= entry.config.sample_percent {
+            return None;
+        }
+
+        match Arc::clone(&entry.permits).try_acquire_owned() {
+            Ok(permit) => {
+                entry.stats.mirrored += 1;
+                Some(ShadowSample {
+                    source_model: model.to_string(),
+                    target_model: entry.config.target_model.clone(),
+                    permit,
+                })
+            }
+            Err(_) => {
+                entry.stats.dropped += 1;
+                None
+            }
+        }
+    }
+
+    async fn record(&self, result: ShadowResult) {
+        if result.shadow_error.is_some() {
+            if let Some(entry) = self.entries.write().await.get_mut(&result.source_model) {
+                entry.stats.failed += 1;
+            }
+        }
+
+        let mut results = self.results.write().await;
+        if results.len() == MAX_SHADOW_RESULTS {
+            results.pop_front();
+        }
+        results.push_back(result);
+    }
+
+    /// Recorded results for a source model, newest first
+    pub async fn results(&self, model: &str, limit: usize) -> Vec<ShadowResult> {
+        self.results
+            .read()
+            .await
+            .iter()
+            .rev()
+            .filter(|r| r.source_model == model)
+            .take(limit)
+            .cloned()
+            .collect()
+    }
+}
+
+/// Details of the primary request needed to replay it against the shadow
+pub struct MirroredRequest {
+    pub request_id: String,
+    pub prompt: String,
+    pub params: InferenceParams,
+    pub primary_latency: Duration,
+}
+
+/// Mirror a served request to the sample's target model in the background.
+///
+/// Non-streaming responses are buffered so the primary output can be stored
+/// alongside the shadow output; the rebuilt response is returned unchanged.
+pub async fn mirror(
+    state: &Arc<ServerState>,
+    sample: ShadowSample,
+    request: MirroredRequest,
+    response: Response,
+) -> Response {
+    let (response, primary_output) = if request.params.stream || !response.status().is_success() {
+        (response, None)
+    } else {
+        capture_output(response).await
+    };
+
+    let state = Arc::clone(state);
+    tokio::spawn(async move {
+        let ShadowSample {
+            source_model,
+            target_model,
+            permit,
+        } = sample;
+        let _permit = permit;
+
+        let started = Instant::now();
+        let outcome = match get_or_load_backend(&state, &target_model).await {
+            Ok(backend) => generate_cancellable(
+                &backend,
+                &request.prompt,
+                &request.params,
+                &CancelSignal::new(),
+                Some(tokio::time::Instant::now() + SHADOW_TIMEOUT),
+            )
+            .await
+            .map(|generation| generation.text),
+            Err(e) => Err(e),
+        };
+        let shadow_latency = started.elapsed();
+
+        let (shadow_output, shadow_error) = match outcome {
+            Ok(text) => (Some(text), None),
+            Err(e) => {
+                warn!("Shadow request to {} failed: {}", target_model, e);
+                (None, Some(e.to_string()))
+            }
+        };
+        let similarity = match (&primary_output, &shadow_output) {
+            (Some(primary), Some(shadow)) => Some(token_similarity(primary, shadow)),
+            _ => None,
+        };
+
+        debug!(
+            "Mirrored request {} from {} to {}",
+            request.request_id, source_model, target_model
+        );
+        state
+            .shadow
+            .record(ShadowResult {
+                id: format!("shadow-{}", Uuid::new_v4()),
+                request_id: request.request_id,
+                source_model,
+                target_model,
+                prompt: request.prompt,
+                primary_output,
+                primary_latency_ms: request.primary_latency.as_millis() as u64,
+                shadow_output,
+                shadow_latency_ms: shadow_latency.as_millis() as u64,
+                shadow_error,
+                similarity,
+                created_at: chrono::Utc::now(),
+            })
+            .await;
+    });
+
+    response
+}
+
+/// Buffer a completion response and pull the generated text out of it
+async fn capture_output(response: Response) -> (Response, Option<String>) {
+    let (parts, body) = response.into_parts();
+    let bytes = match axum::body::to_bytes(body, MAX_CAPTURED_BODY_BYTES).await {
+        Ok(bytes) => bytes,
+        Err(e) => {
+            warn!(
+                "Failed to buffer primary response for shadow comparison: {}",
+                e
+            );
+            return (
+                (StatusCode::INTERNAL_SERVER_ERROR, "response too large").into_response(),
+                None,
+            );
+        }
+    };
+
+    let output = serde_json::from_slice::<serde_json::Value>(&bytes)
+        .ok()
+        .and_then(|value| {
+            let choice = value.get("choices")?.get(0)?;
+            choice
+                .get("message")
+                .and_then(|message| message.get("content"))
+                .or_else(|| choice.get("text"))
+                .and_then(|text| text.as_str())
+                .map(str::to_string)
+        });
+
+    (Response::from_parts(parts, Body::from(bytes)), output)
+}
+
+/// Jaccard similarity of two texts' whitespace-separated tokens
+pub fn token_similarity(a: &str, b: &str) -> f64 {
+    let a: HashSet<&str> = a.split_whitespace().collect();
+    let b: HashSet<&str> = b.split_whitespace().collect();
+    if a.is_empty() && b.is_empty() {
+        return 1.0;
+    }
+    a.intersection(&b).count() as f64 / a.union(&b).count() as f64
+}
+
+// API Handlers
+
+#[derive(Debug, Deserialize)]
+pub struct ShadowResultsQuery {
+    pub limit: Option<usize>,
+}
+
+/// `GET /v1/shadow` - mirroring configurations and counters
+pub async fn list_shadow(State(state): State<Arc<ServerState>>) -> impl IntoResponse {
+    Json(json!({
+        "object": "list",
+        "data": state.shadow.list().await
+    }))
+}
+
+/// `PUT /v1/shadow/:model_id` - mirror a model's traffic to another model (admin only)
+pub async fn put_shadow(
+    State(state): State<Arc<ServerState>>,
+    Path(model_id): Path<String>,
+    headers: HeaderMap,
+    Json(config): Json<ShadowConfig>,
+) -> Response {
+    if let Err(response) = authorize_admin(&headers) {
+        return response;
+    }
+
+    if let Err(message) = config.validate(&model_id) {
+        return (
+            StatusCode::BAD_REQUEST,
+            Json(json!({
+                "error": {
+                    "message": message,
+                    "type": "invalid_request_error",
+                    "param": null,
+                    "code": null
+                }
+            })),
+        )
+            .into_response();
+    }
+
+    state.shadow.set(&model_id, config.clone()).await;
+    Json(ShadowStatus {
+        model: model_id,
+        config,
+        stats: ShadowStats::default(),
+    })
+    .into_response()
+}
+
+/// `DELETE /v1/shadow/:model_id` - stop mirroring (admin only)
+pub async fn delete_shadow(
+    State(state): State<Arc<ServerState>>,
+    Path(model_id): Path<String>,
+    headers: HeaderMap,
+) -> Response {
+    if let Err(response) = authorize_admin(&headers) {
+        return response;
+    }
+
+    if state.shadow.remove(&model_id).await {
+        StatusCode::NO_CONTENT.into_response()
+    } else {
+        (
+            StatusCode::NOT_FOUND,
+            Json(json!({
+                "error": {
+                    "message": format!("Shadow traffic is not configured for {}", model_id),
+                    "type": "invalid_request_error",
+                    "param": "model_id",
+                    "code": null
+                }
+            })),
+        )
+            .into_response()
+    }
+}
+
+/// `GET /v1/shadow/:model_id/results` - recorded comparisons (admin only,
+/// since they contain other clients' prompts)
+pub async fn shadow_results(
+    State(state): State<Arc<ServerState>>,
+    Path(model_id): Path<String>,
+    Query(query): Query<ShadowResultsQuery>,
+    headers: HeaderMap,
+) -> Response {
+    if let Err(response) = authorize_admin(&headers) {
+        return response;
+    }
+
+    let results = state
+        .shadow
+        .results(&model_id, query.limit.unwrap_or(100))
+        .await;
+    Json(json!({
+        "object": "list",
+        "data": results
+    }))
+    .into_response()
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn config(sample_percent: f64, max_concurrent: usize) -> ShadowConfig {
+        ShadowConfig {
+            target_model: "candidate".to_string(),
+            sample_percent,
+            max_concurrent,
+        }
+    }
+
+    #[test]
+    fn test_validate() {
+        assert!(config(10.0, 2).validate("primary").is_ok());
+        assert!(config(10.0, 2).validate("candidate").is_err());
+        assert!(config(150.0, 2).validate("primary").is_err());
+        assert!(config(10.0, 0).validate("primary").is_err());
+    }
+
+    #[test]
+    fn test_token_similarity() {
+        assert_eq!(token_similarity("a b c", "a b c"), 1.0);
+        assert_eq!(token_similarity("a b", "c d"), 0.0);
+        assert!((token_similarity("a b c", "a b d") - 0.5).abs() < f64::EPSILON);
+    }
+
+    #[tokio::test]
+    async fn test_sample_respects_concurrency() {
+        let manager = ShadowManager::new();
+        manager.set("primary", config(100.0, 1)).await;
+
+        let held = manager.sample("primary").await;
+        assert!(held.is_some());
+        assert!(manager.sample("primary").await.is_none());
+        assert!(manager.sample("other").await.is_none());
+
+        drop(held);
+        assert!(manager.sample("primary").await.is_some());
+
+        let stats = &manager.list().await[0].stats;
+        assert_eq!(stats.mirrored, 2);
+        assert_eq!(stats.dropped, 1);
+    }
+
+    #[tokio::test]
+    async fn test_capture_output_preserves_body() {
+        let body = json!({"choices": [{"message": {"role": "assistant", "content": "hi"}}]});
+        let response = Json(body.clone()).into_response();
+
+        let (response, output) = capture_output(response).await;
+        assert_eq!(output.as_deref(), Some("hi"));
+
+        let bytes = axum::body::to_bytes(response.into_body(), usize::MAX)
+            .await
+            .unwrap();
+        assert_eq!(
+            serde_json::from_slice::<serde_json::Value>(&bytes).unwrap(),
+            body
+        );
+    }
+}
@@ -0,0 +1,357 @@
+//! Request Signing
+//!
+//! Bearer keys can leak through proxies and logs, and a captured request
+//! can be replayed. Where that is not acceptable, clients sign each request
+//! with a secret shared with the server and send
+//!
+//! ```text
+//! X-Inferno-Signature: key_id=prod,timestamp=1718000000,nonce=9f2c...,signature=4b1e...
+//! ```
+//!
+//! `signature` is the hex HMAC-SHA256, under the secret named by `key_id`,
+//! of the method, the path with its query, the timestamp, the nonce and the
+//! hex SHA-256 of the body, each on its own line. Secrets come from
+//! `INFERNO_SIGNING_SECRETS` as `key_id:secret` pairs separated by commas.
+//!
+//! Requests signed more than five minutes from the server's clock are
+//! refused, and each nonce is accepted once while its signature could still
+//! be valid. Signed requests are always verified; unsigned ones are refused
+//! too when `INFERNO_REQUIRE_SIGNATURES` is `true`, except health probes.
+
+use crate::{
+    api::{
+        audit_events::{self, AuditKind},
+        usage_export,
+    },
+    cli::serve::ServerState,
+};
+use axum::{
+    Json,
+    body::Body,
+    extract::{Request, State},
+    http::{Method, StatusCode},
+    middleware::Next,
+    response::{IntoResponse, Response},
+};
+use ring::hmac;
+use serde_json::json;
+use sha2::{Digest, Sha256};
+use std::{
+    collections::HashMap,
+    sync::{Arc, Mutex},
+};
+
+pub const SIGNATURE_HEADER: &str = "x-inferno-signature";
+
+/// Environment variable holding the signing secrets
+pub const SECRETS_ENV: &str = "INFERNO_SIGNING_SECRETS";
+
+/// Environment variable that, when `true`, refuses unsigned requests
+pub const REQUIRE_ENV: &str = "INFERNO_REQUIRE_SIGNATURES";
+
+/// How far a signature's timestamp may be from the server's clock, seconds
+const MAX_CLOCK_SKEW_SECS: i64 = 300;
+
+/// Largest body a signed request may have, since it is hashed in memory
+const MAX_SIGNED_BODY_BYTES: usize = 64 * 1024 * 1024;
+
+/// Signing secrets and the nonces seen recently
+pub struct RequestSigning {
+    keys: HashMap<String, hmac::Key>,
+    required: bool,
+    /// Nonce, by key, to the timestamp after which its signature expires
+    seen: Mutex<HashMap<(String, String), i64>>,
+}
+
+// Secrets never reach logs, not even through Debug
+impl std::fmt::Debug for RequestSigning {
+    fn fmt(&self, f: &mut std::fmt::Formatter<'_>) -> std::fmt::Result {
+        let mut ids: Vec<_> = self.keys.keys().collect();
+        ids.sort();
+        f.debug_struct("RequestSigning")
+            .field("key_ids", &ids)
+            .field("required", &self.required)
+            .finish()
+    }
+}
+
+/// A parsed `X-Inferno-Signature` header
+#[derive(Debug, Default)]
+struct SignatureHeader {
+    key_id: String,
+    timestamp: i64,
+    nonce: String,
+    signature: Vec<u8>,
+}
+
+impl SignatureHeader {
+    fn parse(value: &str) -> Result<Self, String> {
+        let mut header = SignatureHeader::default();
+        for part in value.split(',') {
+            let (name, value) = part
+                .trim()
+                .split_once('=')
+                .ok_or_else(|| format!("Malformed signature parameter '{}'", part.trim()))?;
+            match name {
+                "key_id" => header.key_id = value.to_string(),
+                "timestamp" => {
+                    header.timestamp = value
+                        .parse()
+                        .map_err(|_| "timestamp must be Unix seconds".to_string())?
+                }
+                "nonce" => header.nonce = value.to_string(),
+                "signature" => {
+                    header.signature =
+                        hex::decode(value).map_err(|_| "signature must be hex".to_string())?
+                }
+                _ => {}
+            }
+        }
+        if header.key_id.is_empty() || header.nonce.is_empty() || header.signature.is_empty() {
+            return Err("key_id, timestamp, nonce and signature are all required".to_string());
+        }
+        Ok(header)
+    }
+}
+
+/// The text a request's signature covers
+pub fn string_to_sign(
+    method: &str,
+    path_and_query: &str,
+    timestamp: i64,
+    nonce: &str,
+    body: &[u8],
+) -> String {
+    format!(
+        "{}\n{}\n{}\n{}\n{}",
+        method,
+        path_and_query,
+        timestamp,
+        nonce,
+        hex::encode(Sha256::digest(body))
+    )
+}
+
+impl RequestSigning {
+    /// Secrets from `INFERNO_SIGNING_SECRETS`; none when it is unset
+    pub fn from_env() -> anyhow::Result<Self> {
+        let spec = std::env::var(SECRETS_ENV).unwrap_or_default();
+        let required = std::env::var(REQUIRE_ENV).is_ok_and(|value| value == "true");
+        let signing = Self::parse(&spec, required)?;
+        if required && signing.keys.is_empty() {
+            anyhow::bail!("{} is true but {} has no secrets", REQUIRE_ENV, SECRETS_ENV);
+        }
+        Ok(signing)
+    }
+
+    fn parse(spec: &str, required: bool) -> anyhow::Result<Self> {
+        let mut keys = HashMap::new();
+        for entry in spec.split(',').map(str::trim).filter(|e| !e.is_empty()) {
+            let (id, secret) = entry
+                .split_once(':')
+                .ok_or_else(|| anyhow::anyhow!("{} entries must be key_id:secret", SECRETS_ENV))?;
+            if secret.len() < 16 {
+                anyhow::bail!("Signing secret '{}' must be at least 16 bytes", id);
+            }
+            keys.insert(
+                id.trim().to_string(),
+                hmac::Key::new(hmac::HMAC_SHA256, secret.as_bytes()),
+            );
+        }
+        Ok(Self {
+            keys,
+            required,
+            seen: Mutex::new(HashMap::new()),
+        })
+    }
+
+    /// Check `header` against the request, recording its nonce. Errors are
+    /// the error code and message to refuse the request with.
+    fn verify(
+        &self,
+        header: &SignatureHeader,
+        method: &str,
+        path_and_query: &str,
+        body: &[u8],
+        now: i64,
+    ) -> Result<(), (&'static str, String)> {
+        let key = self.keys.get(&header.key_id).ok_or((
+            "invalid_signature",
+            format!("Unknown signing key_id '{}'", header.key_id),
+        ))?;
+        if (now - header.timestamp).abs() > MAX_CLOCK_SKEW_SECS {
+            return Err((
+                "signature_expired",
+                format!(
+                    "Signature timestamp is more than {} seconds from the server's clock",
+                    MAX_CLOCK_SKEW_SECS
+                ),
+            ));
+        }
+
+        let signed = string_to_sign(
+            method,
+            path_and_query,
+            header.timestamp,
+            &header.nonce,
+            body,
+        );
+        hmac::verify(key, signed.as_bytes(), &header.signature).map_err(|_| {
+            (
+                "invalid_signature",
+                "Signature does not match the request".to_string(),
+            )
+        })?;
+
+        // Only verified nonces are recorded, so forgeries cannot use them up
+        let mut seen = self.seen.lock().unwrap();
+        seen.retain(|_, expires| *expires >= now);
+        let expires = header.timestamp + MAX_CLOCK_SKEW_SECS;
+        if seen
+            .insert((header.key_id.clone(), header.nonce.clone()), expires)
+            .is_some()
+        {
+            return Err((
+                "signature_replayed",
+                "This signature's nonce was already used".to_string(),
+            ));
+        }
+        Ok(())
+    }
+}
+
+/// True for requests that never need a signature
+fn exempt(request: &Request) -> bool {
+    request.method() == Method::OPTIONS
+        || request.uri().path().starts_with("/health")
+        || usage_export::is_signed_download(request)
+}
+
+fn signature_error(status: StatusCode, code: &str, message: String) -> Response {
+    let response = (
+        status,
+        Json(json!({
+            "error": {
+                "message": message,
+                "type": "authentication_error",
+                "param": null,
+                "code": code
+            }
+        })),
+    )
+        .into_response();
+    audit_events::mark(response, AuditKind::AuthFailure, Some(code), &message)
+}
+
+/// Middleware verifying `X-Inferno-Signature`, and refusing unsigned
+/// requests when signatures are required
+pub async fn verify_signature(
+    State(state): State<Arc<ServerState>>,
+    request: Request,
+    next: Next,
+) -> Response {
+    let signing = &state.request_signing;
+    let Some(value) = request.headers().get(SIGNATURE_HEADER) else {
+        if signing.required && !exempt(&request) {
+            return signature_error(
+                StatusCode::UNAUTHORIZED,
+                "signature_required",
+                "This server requires requests signed with X-Inferno-Signature".to_string(),
+            );
+        }
+        return next.run(request).await;
+    };
+
+    let header = match value
+        .to_str()
+        .map_err(|_| "Signature header is not ASCII".to_string())
+        .and_then(SignatureHeader::parse)
+    {
+        Ok(header) => header,
+        Err(message) => {
+            return signature_error(StatusCode::UNAUTHORIZED, "invalid_signature", message);
+        }
+    };
+
+    let (parts, body) = request.into_parts();
+    let body = match axum::body::to_bytes(body, MAX_SIGNED_BODY_BYTES).await {
+        Ok(body) => body,
+        Err(_) => {
+            return signature_error(
+                StatusCode::PAYLOAD_TOO_LARGE,
+                "invalid_signature",
+                format!(
+                    "Signed request bodies are limited to {} bytes",
+                    MAX_SIGNED_BODY_BYTES
+                ),
+            );
+        }
+    };
+    let path_and_query = parts
+        .uri
+        .path_and_query()
+        .map(|p| p.as_str())
+        .unwrap_or("/");
+    if let Err((code, message)) = signing.verify(
+        &header,
+        parts.method.as_str(),
+        path_and_query,
+        &body,
+        chrono::Utc::now().timestamp(),
+    ) {
+        return signature_error(StatusCode::UNAUTHORIZED, code, message);
+    }
+
+    next.run(Request::from_parts(parts, Body::from(body))).await
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    const SECRET: &str = "0123456789abcdef0123";
+
+    fn signed(timestamp: i64, nonce: &str, body: &[u8]) -> SignatureHeader {
+        let key = hmac::Key::new(hmac::HMAC_SHA256, SECRET.as_bytes());
+        let text = string_to_sign("POST", "/v1/completions", timestamp, nonce, body);
+        SignatureHeader {
+            key_id: "prod".to_string(),
+            timestamp,
+            nonce: nonce.to_string(),
+            signature: hmac::sign(&key, text.as_bytes()).as_ref().to_vec(),
+        }
+    }
+
+    #[test]
+    fn test_verify_and_replay() {
+        let signing = RequestSigning::parse(&format!("prod:{}", SECRET), true).unwrap();
+        let header = signed(1_000, "n1", b"{}");
+
+        assert!(
+            signing
+                .verify(&header, "POST", "/v1/completions", b"{}", 1_010)
+                .is_ok()
+        );
+        let replayed = signing.verify(&header, "POST", "/v1/completions", b"{}", 1_020);
+        assert_eq!(replayed.unwrap_err().0, "signature_replayed");
+
+        let tampered = signed(1_000, "n2", b"{}");
+        let result = signing.verify(&tampered, "POST", "/v1/completions", b"{\"x\":1}", 1_010);
+        assert_eq!(result.unwrap_err().0, "invalid_signature");
+
+        let stale = signed(1_000, "n3", b"{}");
+        let result = signing.verify(&stale, "POST", "/v1/completions", b"{}", 2_000);
+        assert_eq!(result.unwrap_err().0, "signature_expired");
+    }
+
+    #[test]
+    fn test_parse_header() {
+        let header =
+            SignatureHeader::parse("key_id=prod, timestamp=17, nonce=abc, signature=0aff").unwrap();
+        assert_eq!(header.key_id, "prod");
+        assert_eq!(header.timestamp, 17);
+        assert_eq!(header.signature, vec![0x0a, 0xff]);
+        assert!(SignatureHeader::parse("key_id=prod,timestamp=17").is_err());
+        assert!(RequestSigning::parse("prod:short", false).is_err());
+    }
+}
@@ -0,0 +1,302 @@
+//! Speculative Decoding Configuration
+//!
+//! Lets operators attach a draft model to a target model and tune how many
+//! tokens it proposes per step, without editing server configuration files.
+//! Backends that support drafting report proposed/accepted token counts back
+//! through [`SpeculativeRegistry::record_step`], which feeds the acceptance
+//! statistics returned alongside the configuration.
+
+use crate::{api::admin::authorize_admin, cli::serve::ServerState};
+use axum::{
+    Json,
+    extract::{Path, State},
+    http::{HeaderMap, StatusCode},
+    response::{IntoResponse, Response},
+};
+use serde::{Deserialize, Serialize};
+use serde_json::json;
+use std::{collections::HashMap, sync::Arc};
+use tokio::sync::RwLock;
+
+/// Largest number of draft tokens proposed per verification step
+pub const MAX_LOOKAHEAD_TOKENS: usize = 16;
+
+/// Draft-model configuration for one target model
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct SpeculativeConfig {
+    #[serde(default = "default_enabled")]
+    pub enabled: bool,
+    pub draft_model: String,
+    #[serde(default = "default_lookahead_tokens")]
+    pub lookahead_tokens: usize,
+    /// Minimum draft-token probability for a proposal to be kept
+    #[serde(default = "default_acceptance_threshold")]
+    pub acceptance_threshold: f32,
+    /// Shrink or grow the lookahead based on the observed acceptance rate
+    #[serde(default)]
+    pub adaptive_lookahead: bool,
+}
+
+fn default_enabled() -> bool {
+    true
+}
+
+fn default_lookahead_tokens() -> usize {
+    4
+}
+
+fn default_acceptance_threshold() -> f32 {
+    0.8
+}
+
+impl SpeculativeConfig {
+    /// Check the configuration against the target model it will be applied to
+    pub fn validate(&self, target_model: &str) -> Result<(), String> {
+        if self.draft_model.trim().is_empty() {
+            return Err("draft_model must not be empty".to_string());
+        }
+        if self.draft_model == target_model {
+            return Err("draft_model must differ from the target model".to_string());
+        }
+        if self.lookahead_tokens == 0 || self.lookahead_tokens > MAX_LOOKAHEAD_TOKENS {
+            return Err(format!(
+                "lookahead_tokens must be between 1 and {}",
+                MAX_LOOKAHEAD_TOKENS
+            ));
+        }
+        if !(0.0..=1.0).contains(&self.acceptance_threshold) {
+            return Err("acceptance_threshold must be between 0.0 and 1.0".to_string());
+        }
+        Ok(())
+    }
+}
+
+/// Acceptance statistics for a target model's draft proposals
+#[derive(Debug, Clone, Default, Serialize, Deserialize)]
+pub struct SpeculativeStats {
+    pub verification_steps: u64,
+    pub proposed_tokens: u64,
+    pub accepted_tokens: u64,
+    pub acceptance_rate: f64,
+    /// Mean number of tokens accepted per target-model forward pass
+    pub mean_accepted_per_step: f64,
+}
+
+impl SpeculativeStats {
+    fn record(&mut self, proposed: usize, accepted: usize) {
+        self.verification_steps += 1;
+        self.proposed_tokens += proposed as u64;
+        self.accepted_tokens += accepted.min(proposed) as u64;
+
+        if self.proposed_tokens > 0 {
+            self.acceptance_rate = self.accepted_tokens as f64 / self.proposed_tokens as f64;
+        }
+        self.mean_accepted_per_step = self.accepted_tokens as f64 / self.verification_steps as f64;
+    }
+}
+
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct SpeculativeStatus {
+    pub model: String,
+    pub config: Option<SpeculativeConfig>,
+    pub stats: SpeculativeStats,
+}
+
+/// Per-model speculative decoding settings and statistics
+#[derive(Debug, Default)]
+pub struct SpeculativeRegistry {
+    configs: RwLock<HashMap<String, SpeculativeConfig>>,
+    stats: RwLock<HashMap<String, SpeculativeStats>>,
+}
+
+impl SpeculativeRegistry {
+    pub fn new() -> Self {
+        Self::default()
+    }
+
+    /// Active configuration for a target model, if speculative decoding is on
+    pub async fn active_config(&self, model: &str) -> Option<SpeculativeConfig> {
+        self.configs
+            .read()
+            .await
+            .get(model)
+            .filter(|config| config.enabled)
+            .cloned()
+    }
+
+    pub async fn status(&self, model: &str) -> SpeculativeStatus {
+        SpeculativeStatus {
+            model: model.to_string(),
+            config: self.configs.read().await.get(model).cloned(),
+            stats: self
+                .stats
+                .read()
+                .await
+                .get(model)
+                .cloned()
+                .unwrap_or_default(),
+        }
+    }
+
+    pub async fn set(&self, model: &str, config: SpeculativeConfig) {
+        self.configs.write().await.insert(model.to_string(), config);
+        // Statistics describe the previous draft model, so start afresh
+        self.stats.write().await.remove(model);
+    }
+
+    pub async fn remove(&self, model: &str) -> bool {
+        self.stats.write().await.remove(model);
+        self.configs.write().await.remove(model).is_some()
+    }
+
+    /// Record one verification step reported by a backend
+    pub async fn record_step(&self, model: &str, proposed: usize, accepted: usize) {
+        self.stats
+            .write()
+            .await
+            .entry(model.to_string())
+            .or_default()
+            .record(proposed, accepted);
+    }
+}
+
+// API Handlers
+
+/// `GET /v1/models/:model_id/speculative` - configuration and acceptance stats
+pub async fn get_speculative(
+    State(state): State<Arc<ServerState>>,
+    Path(model_id): Path<String>,
+) -> impl IntoResponse {
+    Json(state.speculative.status(&model_id).await)
+}
+
+/// `PUT /v1/models/:model_id/speculative` - enable or retune (admin only)
+pub async fn put_speculative(
+    State(state): State<Arc<ServerState>>,
+    Path(model_id): Path<String>,
+    headers: HeaderMap,
+    Json(config): Json<SpeculativeConfig>,
+) -> Response {
+    if let Err(response) = authorize_admin(&headers) {
+        return response;
+    }
+
+    if let Err(message) = config.validate(&model_id) {
+        return (
+            StatusCode::BAD_REQUEST,
+            Json(json!({
+                "error": {
+                    "message": message,
+                    "type": "invalid_request_error",
+                    "param": null,
+                    "code": null
+                }
+            })),
+        )
+            .into_response();
+    }
+
+    if let Err(e) = state.model_manager.resolve_model(&config.draft_model).await {
+        return (
+            StatusCode::BAD_REQUEST,
+            Json(json!({
+                "error": {
+                    "message": format!("Draft model not found: {}", e),
+                    "type": "invalid_request_error",
+                    "param": "draft_model",
+                    "code": "model_not_found"
+                }
+            })),
+        )
+            .into_response();
+    }
+
+    state.speculative.set(&model_id, config).await;
+    Json(state.speculative.status(&model_id).await).into_response()
+}
+
+/// `DELETE /v1/models/:model_id/speculative` - disable speculative decoding (admin only)
+pub async fn delete_speculative(
+    State(state): State<Arc<ServerState>>,
+    Path(model_id): Path<String>,
+    headers: HeaderMap,
+) -> Response {
+    if let Err(response) = authorize_admin(&headers) {
+        return response;
+    }
+
+    if state.speculative.remove(&model_id).await {
+        StatusCode::NO_CONTENT.into_response()
+    } else {
+        (
+            StatusCode::NOT_FOUND,
+            Json(json!({
+                "error": {
+                    "message": format!("Speculative decoding is not configured for {}", model_id),
+                    "type": "invalid_request_error",
+                    "param": "model_id",
+                    "code": null
+                }
+            })),
+        )
+            .into_response()
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn config(draft: &str) -> SpeculativeConfig {
+        SpeculativeConfig {
+            enabled: true,
+            draft_model: draft.to_string(),
+            lookahead_tokens: 4,
+            acceptance_threshold: 0.8,
+            adaptive_lookahead: false,
+        }
+    }
+
+    #[test]
+    fn test_validate() {
+        assert!(config("tiny").validate("big").is_ok());
+        assert!(config("big").validate("big").is_err());
+        assert!(config("").validate("big").is_err());
+
+        let mut bad = config("tiny");
+        bad.lookahead_tokens = MAX_LOOKAHEAD_TOKENS + 1;
+        assert!(bad.validate("big").is_err());
+
+        let mut bad = config("tiny");
+        bad.acceptance_threshold = 1.5;
+        assert!(bad.validate("big").is_err());
+    }
+
+    #[tokio::test]
+    async fn test_stats_accumulate_and_reset() {
+        let registry = SpeculativeRegistry::new();
+        registry.set("big", config("tiny")).await;
+        registry.record_step("big", 4, 3).await;
+        registry.record_step("big", 4, 1).await;
+
+        let status = registry.status("big").await;
+        assert_eq!(status.stats.verification_steps, 2);
+        assert_eq!(status.stats.accepted_tokens, 4);
+        assert!((status.stats.acceptance_rate - 0.5).abs() < f64::EPSILON);
+
+        registry.set("big", config("other")).await;
+        assert_eq!(registry.status("big").await.stats.verification_steps, 0);
+    }
+
+    #[tokio::test]
+    async fn test_disabled_config_is_inactive() {
+        let registry = SpeculativeRegistry::new();
+        let mut disabled = config("tiny");
+        disabled.enabled = false;
+        registry.set("big", disabled).await;
+
+        assert!(registry.active_config("big").await.is_none());
+        assert!(registry.remove("big").await);
+        assert!(!registry.remove("big").await);
+    }
+}
@@ -0,0 +1,459 @@
+//! Long-Document Summarization
+//!
+//! `POST /v1/summarize` summarizes documents of any length with a
+//! map-reduce pass. Text that does not fit the context window is split on
+//! paragraph and sentence boundaries, each chunk is summarized on its own
+//! (several at a time), the chunk summaries are merged in further rounds
+//! until they fit together, and a final pass writes the summary in the
+//! requested style and length.
+
+use crate::{
+    api::{
+        cancellation::{CancelSignal, FinishReason, generate_cancellable, with_request_id},
+        deadline::resolve_deadline,
+        invalid_request,
+        openai::{estimate_tokens, get_or_load_backend},
+        queue::priority_from_headers,
+    },
+    backends::{BackendHandle, InferenceParams},
+    cli::serve::ServerState,
+};
+use axum::{
+    Json,
+    extract::State,
+    http::{HeaderMap, StatusCode},
+    response::{IntoResponse, Response},
+};
+use futures::{StreamExt, stream};
+use serde::{Deserialize, Serialize};
+use serde_json::json;
+use std::{ops::Range, sync::Arc};
+use tokio::time::Instant;
+
+/// Chunk summaries generated at the same time
+const MAP_CONCURRENCY: usize = 4;
+
+/// Most chunks one document may be split into
+const MAX_SUMMARY_CHUNKS: usize = 256;
+
+/// Length each chunk is condensed to before merging
+const CHUNK_SUMMARY_WORDS: u32 = 150;
+
+/// Merge rounds before the final pass gives up on shrinking further
+const MAX_REDUCE_ROUNDS: u32 = 4;
+
+/// Smallest chunk budget that leaves room for meaningful sections
+const MIN_CHUNK_TOKENS: u32 = 128;
+
+fn default_max_words() -> u32 {
+    200
+}
+
+/// Shape of the final summary
+#[derive(Debug, Clone, Copy, Default, PartialEq, Eq, Serialize, Deserialize)]
+#[serde(rename_all = "lowercase")]
+pub enum SummaryStyle {
+    /// Flowing prose
+    #[default]
+    Paragraph,
+    /// One `- ` line per point
+    Bullets,
+    /// One or two sentences
+    Tldr,
+}
+
+impl SummaryStyle {
+    fn instruction(&self, max_words: u32) -> String {
+        match self {
+            SummaryStyle::Paragraph => {
+                format!("Write a summary in prose of at most {} words.", max_words)
+            }
+            SummaryStyle::Bullets => format!(
+                "Write the summary as a bulleted list, one \"- \" line per key point, \
+                 at most {} words in total.",
+                max_words
+            ),
+            SummaryStyle::Tldr => format!(
+                "Write a TL;DR of one or two sentences, at most {} words.",
+                max_words.min(60)
+            ),
+        }
+    }
+}
+
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct SummarizeRequest {
+    pub model: String,
+    pub text: String,
+    #[serde(default)]
+    pub style: SummaryStyle,
+    /// Upper bound on the final summary's length
+    #[serde(default = "default_max_words")]
+    pub max_words: u32,
+    /// What the summary should concentrate on, e.g. "risks and deadlines"
+    #[serde(default)]
+    pub focus: Option<String>,
+    /// Tokens per chunk; defaults to half the context window, leaving room
+    /// for the prompt and the chunk's summary
+    #[serde(default)]
+    pub chunk_tokens: Option<u32>,
+    #[serde(default)]
+    pub temperature: Option<f32>,
+    /// Server-enforced time budget for the whole pipeline, in milliseconds
+    #[serde(default)]
+    pub timeout_ms: Option<u64>,
+}
+
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct SummarizeResponse {
+    pub object: String,
+    pub model: String,
+    pub summary: String,
+    pub style: SummaryStyle,
+    /// Chunks the document was split into; 1 when it fit the context window
+    pub chunks: usize,
+    /// Merge rounds between the chunk summaries and the final pass
+    pub reduce_rounds: u32,
+    /// Tokens in the document, exact when the backend exposes its tokenizer
+    pub document_tokens: u32,
+}
+
+/// Why the pipeline stopped before producing a summary
+enum SummarizeError {
+    Interrupted(FinishReason),
+    Failed(anyhow::Error),
+}
+
+/// One generation budget shared by every pass of a request
+struct Pipeline<'a> {
+    backend: &'a BackendHandle,
+    cancel: &'a CancelSignal,
+    deadline: Option<Instant>,
+    temperature: f32,
+    focus: String,
+}
+
+impl Pipeline<'_> {
+    async fn generate(&self, prompt: String, words: u32) -> Result<String, SummarizeError> {
+        let params = InferenceParams {
+            // Roughly 1.3 tokens per English word, with headroom
+            max_tokens: words * 2 + 32,
+            temperature: self.temperature,
+            ..Default::default()
+        };
+        let generation =
+            generate_cancellable(self.backend, &prompt, &params, self.cancel, self.deadline)
+                .await
+                .map_err(SummarizeError::Failed)?;
+        match generation.finish_reason {
+            FinishReason::Stop => Ok(generation.text.trim().to_string()),
+            reason => Err(SummarizeError::Interrupted(reason)),
+        }
+    }
+
+    /// Summarize each section, a few at a time, keeping their order
+    async fn map(&self, sections: &[String], merging: bool) -> Result<Vec<String>, SummarizeError> {
+        let total = sections.len();
+        let summaries: Vec<_> = stream::iter(sections.iter().enumerate())
+            .map(|(index, section)| {
+                let prompt = if merging {
+                    format!(
+                        "Merge these summaries of consecutive parts of a document into one \
+                         summary of at most {} words, keeping names, figures and \
+                         conclusions.{}\n\n\"\"\"\n{}\n\"\"\"\n\nSummary:",
+                        CHUNK_SUMMARY_WORDS, self.focus, section
+                    )
+                } else {
+                    format!(
+                        "Summarize part {} of {} of a longer document in at most {} words, \
+                         keeping names, figures and conclusions.{}\n\n\"\"\"\n{}\n\"\"\"\n\n\
+                         Summary:",
+                        index + 1,
+                        total,
+                        CHUNK_SUMMARY_WORDS,
+                        self.focus,
+                        section
+                    )
+                };
+                self.generate(prompt, CHUNK_SUMMARY_WORDS)
+            })
+            .buffered(MAP_CONCURRENCY)
+            .collect()
+            .await;
+        summaries.into_iter().collect()
+    }
+
+    async fn finish(
+        &self,
+        text: &str,
+        style: SummaryStyle,
+        max_words: u32,
+        from_summaries: bool,
+    ) -> Result<String, SummarizeError> {
+        let source = if from_summaries {
+            "the following summaries of consecutive parts of a document"
+        } else {
+            "the following document"
+        };
+        let prompt = format!(
+            "Summarize {}. {}{}\n\n\"\"\"\n{}\n\"\"\"\n\nSummary:",
+            source,
+            style.instruction(max_words),
+            self.focus,
+            text
+        );
+        self.generate(prompt, max_words).await
+    }
+}
+
+/// Byte ranges of `text` holding chunks of at most `max_chars` bytes,
+/// breaking between paragraphs where possible, then between sentences, then
+/// between words. Chunks are trimmed; whatever lies between two ranges is the
+/// original separator, so callers can reassemble processed chunks.
+pub(crate) fn chunk_ranges(text: &str, max_chars: usize) -> Vec<Range<usize>> {
+    let mut pieces = Vec::new();
+    let mut offset = 0;
+    for paragraph in text.split("\n\n") {
+        let paragraph_start = offset;
+        offset += paragraph.len() + 2;
+        if paragraph.trim().is_empty() {
+            continue;
+        }
+        let start = paragraph_start + (paragraph.len() - paragraph.trim_start().len());
+        let mut rest = start..paragraph_start + paragraph.trim_end().len();
+
+        while rest.len() > max_chars {
+            let mut cut = rest.start + max_chars;
+            while !text.is_char_boundary(cut) {
+                cut -= 1;
+            }
+            let head = &text[rest.start..cut];
+            // Only break early when it keeps at least half the chunk
+            let boundary = [". ", "\n", " "].iter().find_map(|separator| {
+                head.rfind(separator)
+                    .map(|at| at + separator.len())
+                    .filter(|&at| at >= head.len() / 2)
+            });
+            let at = rest.start + boundary.unwrap_or(head.len()).max(1);
+            pieces.push(rest.start..rest.start + text[rest.start..at].trim_end().len());
+            rest.start = rest.end - text[at..rest.end].trim_start().len();
+        }
+        if !rest.is_empty() {
+            pieces.push(rest);
+        }
+    }
+
+    let mut chunks: Vec<Range<usize>> = Vec::new();
+    for piece in pieces {
+        match chunks.last_mut() {
+            Some(chunk) if piece.end - chunk.start <= max_chars => chunk.end = piece.end,
+            _ => chunks.push(piece),
+        }
+    }
+    chunks
+}
+
+fn split_chunks(text: &str, max_chars: usize) -> Vec<String> {
+    chunk_ranges(text, max_chars)
+        .into_iter()
+        .map(|range| text[range].to_string())
+        .collect()
+}
+
+/// Tokens in `text` and the bytes one token spans on average, used to turn
+/// a token budget into chunk lengths without tokenizing every candidate.
+/// Falls back to an estimate when the backend does not expose a tokenizer.
+pub(crate) async fn measure_tokens(backend: &BackendHandle, text: &str) -> (u32, f64) {
+    let tokens = match backend.tokenize(text).await {
+        Ok(tokens) => tokens.len() as u32,
+        Err(_) => estimate_tokens(text),
+    }
+    .max(1);
+    (tokens, (text.len() as f64 / tokens as f64).max(1.0))
+}
+
+fn summarize_error(error: SummarizeError) -> Response {
+    let (status, message, code) = match error {
+        SummarizeError::Interrupted(reason) => (
+            StatusCode::REQUEST_TIMEOUT,
+            format!("Summarization stopped early: {}", reason.as_str()),
+            reason.as_str(),
+        ),
+        SummarizeError::Failed(e) => (
+            StatusCode::INTERNAL_SERVER_ERROR,
+            format!("Inference failed: {}", e),
+            "inference_failed",
+        ),
+    };
+    (
+        status,
+        Json(json!({
+            "error": {
+                "message": message,
+                "type": "server_error",
+                "param": null,
+                "code": code
+            }
+        })),
+    )
+        .into_response()
+}
+
+// API Handlers
+
+/// `POST /v1/summarize` - map-reduce summary of a document of any length
+pub async fn summarize(
+    State(state): State<Arc<ServerState>>,
+    headers: HeaderMap,
+    Json(request): Json<SummarizeRequest>,
+) -> Response {
+    if let Err(response) = state.flags.require("summarize", &headers).await {
+        return response;
+    }
+    if request.text.trim().is_empty() {
+        return invalid_request("text must not be empty".to_string(), Some("text"), None);
+    }
+    if !(10..=2000).contains(&request.max_words) {
+        return invalid_request(
+            "max_words must be between 10 and 2000".to_string(),
+            Some("max_words"),
+            None,
+        );
+    }
+    let context_size = state.config.backend_config.context_size;
+    let chunk_tokens = request.chunk_tokens.unwrap_or(context_size / 2);
+    if chunk_tokens < MIN_CHUNK_TOKENS || chunk_tokens >= context_size {
+        return invalid_request(
+            format!(
+                "chunk_tokens must be at least {} and below the context window of {}",
+                MIN_CHUNK_TOKENS, context_size
+            ),
+            Some("chunk_tokens"),
+            None,
+        );
+    }
+
+    let ticket = match state.request_queue.enqueue_request(
+        &headers,
+        &request.model,
+        priority_from_headers(&headers),
+    ) {
+        Ok(ticket) => ticket
+            .with_scheduling(None, &headers)
+            .with_deadline(resolve_deadline(request.timeout_ms, None)),
+        Err(response) => return response,
+    };
+    let request_id = ticket.id().to_string();
+
+    let backend = match get_or_load_backend(&state, &request.model).await {
+        Ok(backend) => backend,
+        Err(e) => {
+            return invalid_request(format!("Failed to load model: {}", e), Some("model"), None);
+        }
+    };
+
+    let (document_tokens, bytes_per_token) = measure_tokens(&backend, &request.text).await;
+    let max_chars = (chunk_tokens as f64 * bytes_per_token) as usize;
+
+    let chunks = split_chunks(&request.text, max_chars);
+    if chunks.len() > MAX_SUMMARY_CHUNKS {
+        return invalid_request(
+            format!(
+                "The document splits into {} chunks; at most {} are summarized per request",
+                chunks.len(),
+                MAX_SUMMARY_CHUNKS
+            ),
+            Some("text"),
+            Some("document_too_long"),
+        );
+    }
+
+    let pipeline = Pipeline {
+        backend: &backend,
+        cancel: ticket.cancel_signal(),
+        deadline: ticket.deadline(),
+        temperature: request.temperature.unwrap_or(0.2),
+        focus: request
+            .focus
+            .as_deref()
+            .map(|focus| format!(" Focus on {}.", focus.trim()))
+            .unwrap_or_default(),
+    };
+
+    ticket.start().await;
+    let mut reduce_rounds = 0;
+    let result = async {
+        if document_tokens <= chunk_tokens {
+            return pipeline
+                .finish(&request.text, request.style, request.max_words, false)
+                .await;
+        }
+
+        let mut summaries = pipeline.map(&chunks, false).await?;
+        loop {
+            let merged = summaries.join("\n\n");
+            let groups = split_chunks(&merged, max_chars);
+            if groups.len() <= 1
+                || groups.len() >= summaries.len()
+                || reduce_rounds >= MAX_REDUCE_ROUNDS
+            {
+                return pipeline
+                    .finish(&merged, request.style, request.max_words, true)
+                    .await;
+            }
+            reduce_rounds += 1;
+            summaries = pipeline.map(&groups, true).await?;
+        }
+    }
+    .await;
+
+    let summary = match result {
+        Ok(summary) => summary,
+        Err(error) => return summarize_error(error),
+    };
+
+    let response = Json(SummarizeResponse {
+        object: "summary".to_string(),
+        model: request.model.clone(),
+        summary,
+        style: request.style,
+        chunks: chunks.len(),
+        reduce_rounds,
+        document_tokens,
+    })
+    .into_response();
+
+    with_request_id(response, &request_id)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_chunks_pack_whole_paragraphs() {
+        let text = "one one one\n\ntwo two two\n\nthree three";
+        assert_eq!(
+            split_chunks(text, 26),
+            vec!["one one one\n\ntwo two two", "three three"]
+        );
+        assert_eq!(split_chunks(text, 100).len(), 1);
+    }
+
+    #[test]
+    fn test_long_paragraphs_break_between_sentences() {
+        let text = "First sentence here. Second sentence here. Third one.";
+        let chunks = split_chunks(text, 30);
+        assert_eq!(chunks[0], "First sentence here.");
+        assert!(chunks.iter().all(|chunk| chunk.len() <= 30));
+        assert_eq!(chunks.join(" "), text);
+    }
+
+    #[test]
+    fn test_splitting_respects_char_boundaries() {
+        let text = "ééééééééééééééééééééé";
+        let chunks = split_chunks(text, 5);
+        assert!(chunks.iter().all(|chunk| chunk.len() <= 5));
+        assert_eq!(chunks.concat(), text);
+    }
+}
@@ -0,0 +1,1390 @@
+//! Per-Tenant Limits
+//!
+//! A request's tenant comes from its credential: the tenant of the managed
+//! API key it presents, or the `tenant` claim of its JWT. The
+//! [`authenticate_tenant`] middleware refuses an `X-Inferno-Tenant` header
+//! naming any other tenant and sets the header to the credential's tenant,
+//! so everything downstream reads a tenant the caller has proven. Callers
+//! whose credential names no tenant are the `default` tenant; only the admin
+//! token may act for any tenant through the header.
+//!
+//! An admin can cap a tenant's concurrent requests, requests per minute and
+//! tokens per minute, and reserve models for its sole use, through
+//! `/admin/tenants/{id}/limits`. The [`enforce_limits`] middleware checks
+//! generation requests against those limits before they are queued and
+//! answers with 429 and a retry hint when a tenant is over one, so a busy
+//! tenant cannot starve the rest.
+//!
+//! Tokens are counted as the completion tokens a request asks for
+//! (`max_tokens` times `n`), so a budget holds before generation starts.
+//!
+//! Platform teams onboard tenants through `/admin/tenants`: a tenant is
+//! created with a display name, the models it may use and its limits, and
+//! can be suspended, which refuses its generation requests until it is
+//! resumed. Suspension holds for every credential belonging to the tenant,
+//! whatever header the request sends. Tenants, limits and usage windows are
+//! held in memory.
+//!
+//! Callers see what their tenant may still send, per window, at
+//! `GET /usage/quota`.
+
+use crate::{
+    api::{
+        admin::authorize_admin,
+        audit_events::{self, AuditKind},
+        error_response,
+        jwt_auth::JwtClaims,
+        queue::tenant_from_headers,
+        runtime_config::sampling_defaults,
+        scheduler::{DEFAULT_TENANT, TENANT_HEADER},
+    },
+    cli::serve::ServerState,
+};
+use axum::{
+    Json,
+    body::Body,
+    extract::{Path, Request, State},
+    http::{HeaderMap, HeaderValue, StatusCode, header},
+    middleware::Next,
+    response::{IntoResponse, Response},
+};
+use chrono::{DateTime, Utc};
+use serde::{Deserialize, Serialize};
+use serde_json::json;
+use std::{
+    collections::{BTreeMap, HashMap, VecDeque},
+    sync::{Arc, Mutex},
+    time::{Duration, Instant},
+};
+use tracing::info;
+
+/// Window the per-minute limits are measured over
+const RATE_WINDOW: Duration = Duration::from_secs(60);
+
+/// Largest body the middleware buffers to read `model` and `max_tokens`,
+/// matching axum's default JSON body limit
+const MAX_INSPECTED_BODY: usize = 2 * 1024 * 1024;
+
+/// Retry hint for a tenant at its concurrency cap
+const CONCURRENCY_RETRY: Duration = Duration::from_secs(1);
+
+/// Longest tenant id
+const MAX_TENANT_ID_LEN: usize = 64;
+
+/// JWT claim naming the caller's tenant
+pub const TENANT_CLAIM: &str = "tenant";
+
+/// Limits applied to one tenant; unset fields are unlimited
+#[derive(Debug, Clone, Default, PartialEq, Serialize, Deserialize)]
+pub struct TenantLimits {
+    /// Requests queued or generating at once
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub max_concurrent: Option<u32>,
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub requests_per_minute: Option<u32>,
+    /// Completion tokens requested per minute
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub tokens_per_minute: Option<u64>,
+    /// Models only this tenant may use
+    #[serde(default)]
+    pub dedicated_models: Vec<String>,
+}
+
+impl TenantLimits {
+    fn validate(&self) -> Result<(), (String, &'static str)> {
+        if self.max_concurrent == Some(0) {
+            return Err((
+                "max_concurrent must be positive; omit it for no limit".to_string(),
+                "max_concurrent",
+            ));
+        }
+        if self.requests_per_minute == Some(0) {
+            return Err((
+                "requests_per_minute must be positive; omit it for no limit".to_string(),
+                "requests_per_minute",
+            ));
+        }
+        if self.tokens_per_minute == Some(0) {
+            return Err((
+                "tokens_per_minute must be positive; omit it for no limit".to_string(),
+                "tokens_per_minute",
+            ));
+        }
+        if self.dedicated_models.iter().any(|m| m.trim().is_empty()) {
+            return Err((
+                "dedicated_models may not contain empty model ids".to_string(),
+                "dedicated_models",
+            ));
+        }
+        Ok(())
+    }
+}
+
+/// A tenant's recent traffic, as counted against its limits
+#[derive(Debug, Clone, Default, Serialize, Deserialize)]
+pub struct TenantUsage {
+    pub in_flight: usize,
+    pub requests_last_minute: usize,
+    pub tokens_last_minute: u64,
+    /// Requests refused for exceeding a limit since the limits were set
+    pub throttled: u64,
+}
+
+/// Limits and usage returned by the admin endpoints
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct TenantInfo {
+    pub object: String,
+    pub tenant: String,
+    pub limits: TenantLimits,
+    pub usage: TenantUsage,
+    pub updated_at: DateTime<Utc>,
+}
+
+/// One rate window of a tenant's quota
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct QuotaWindow {
+    /// `requests` or `tokens`
+    pub unit: String,
+    pub limit: u64,
+    pub used: u64,
+    pub remaining: u64,
+    pub window_seconds: u64,
+    /// When the usage counted now has all left the window
+    pub resets_at: DateTime<Utc>,
+}
+
+/// A tenant's concurrency cap and how much of it is in use
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct QuotaConcurrency {
+    pub limit: u64,
+    pub in_flight: u64,
+    pub remaining: u64,
+}
+
+/// What a tenant may still send, from `GET /usage/quota`
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct TenantQuota {
+    pub object: String,
+    pub tenant: String,
+    /// False when the tenant has no limits, so every window is unlimited
+    pub limited: bool,
+    pub suspended: bool,
+    pub windows: Vec<QuotaWindow>,
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub concurrency: Option<QuotaConcurrency>,
+}
+
+/// A tenant as returned by `/admin/tenants`
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct Tenant {
+    pub object: String,
+    pub id: String,
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub name: Option<String>,
+    /// Models the tenant may use; empty allows every model
+    pub allowed_models: Vec<String>,
+    pub limits: TenantLimits,
+    pub suspended: bool,
+    pub usage: TenantUsage,
+    pub created_at: DateTime<Utc>,
+    pub updated_at: DateTime<Utc>,
+}
+
+#[derive(Debug, Clone, Deserialize)]
+pub struct CreateTenantRequest {
+    /// The value API keys and JWTs name as their tenant
+    pub id: String,
+    #[serde(default)]
+    pub name: Option<String>,
+    #[serde(default)]
+    pub allowed_models: Vec<String>,
+    #[serde(default)]
+    pub limits: TenantLimits,
+}
+
+/// Fields to change on a tenant; absent fields are left as they are
+#[derive(Debug, Clone, Default, Deserialize)]
+pub struct UpdateTenantRequest {
+    #[serde(default)]
+    pub name: Option<String>,
+    #[serde(default)]
+    pub allowed_models: Option<Vec<String>>,
+    #[serde(default)]
+    pub limits: Option<TenantLimits>,
+}
+
+pub fn validate_tenant_id(id: &str) -> Result<(), String> {
+    if id.is_empty() || id.len() > MAX_TENANT_ID_LEN {
+        return Err(format!(
+            "Tenant ids must be 1 to {} characters",
+            MAX_TENANT_ID_LEN
+        ));
+    }
+    if !id
+        .chars()
+        .all(|c| c.is_ascii_alphanumeric() || matches!(c, '-' | '_' | '.'))
+    {
+        return Err("Tenant ids may only contain letters, digits, '-', '_' and '.'".to_string());
+    }
+    Ok(())
+}
+
+fn validate_models(models: &[String]) -> Result<(), String> {
+    if models.iter().any(|m| m.trim().is_empty()) {
+        return Err("allowed_models may not contain empty model ids".to_string());
+    }
+    Ok(())
+}
+
+/// What the middleware needs to know about a request's tenant
+#[derive(Debug, Clone)]
+struct TenantPolicy {
+    limits: TenantLimits,
+    allowed_models: Vec<String>,
+    suspended: bool,
+}
+
+impl TenantPolicy {
+    fn allows(&self, model: &str) -> bool {
+        self.allowed_models.is_empty()
+            || self.allowed_models.iter().any(|m| m == model)
+            || self.limits.dedicated_models.iter().any(|m| m == model)
+    }
+}
+
+/// Why a request was refused
+#[derive(Debug, Clone, PartialEq)]
+pub struct Throttle {
+    pub code: &'static str,
+    pub message: String,
+    pub limit: u64,
+    pub retry_after: Duration,
+}
+
+#[derive(Debug)]
+struct TenantState {
+    name: Option<String>,
+    allowed_models: Vec<String>,
+    limits: TenantLimits,
+    suspended: bool,
+    created_at: DateTime<Utc>,
+    updated_at: DateTime<Utc>,
+    /// Admission time and requested tokens of each request in the window
+    window: VecDeque<(Instant, u64)>,
+    throttled: u64,
+}
+
+impl TenantState {
+    fn new(limits: TenantLimits) -> Self {
+        let now = Utc::now();
+        Self {
+            name: None,
+            allowed_models: Vec::new(),
+            limits,
+            suspended: false,
+            created_at: now,
+            updated_at: now,
+            window: VecDeque::new(),
+            throttled: 0,
+        }
+    }
+
+    fn prune(&mut self, now: Instant) {
+        while let Some(&(at, _)) = self.window.front() {
+            if now.duration_since(at) < RATE_WINDOW {
+                break;
+            }
+            self.window.pop_front();
+        }
+    }
+
+    fn tokens(&self) -> u64 {
+        self.window.iter().map(|&(_, tokens)| tokens).sum()
+    }
+
+    /// How long until the oldest `count` window entries have expired
+    fn expiry_of(&self, count: usize, now: Instant) -> Duration {
+        self.window
+            .get(count.saturating_sub(1))
+            .map(|&(at, _)| (at + RATE_WINDOW).saturating_duration_since(now))
+            .unwrap_or_default()
+    }
+
+    /// Check a request against the limits, recording it if it is allowed
+    fn admit(&mut self, in_flight: usize, tokens: u64, now: Instant) -> Result<(), Throttle> {
+        self.prune(now);
+        let result = self.check(in_flight, tokens, now);
+        match result {
+            Ok(()) => self.window.push_back((now, tokens)),
+            Err(_) => self.throttled += 1,
+        }
+        result
+    }
+
+    fn check(&self, in_flight: usize, tokens: u64, now: Instant) -> Result<(), Throttle> {
+        if let Some(limit) = self.limits.max_concurrent
+            && in_flight >= limit as usize
+        {
+            return Err(Throttle {
+                code: "tenant_concurrency_exceeded",
+                message: format!("at its limit of {} concurrent requests", limit),
+                limit: limit as u64,
+                retry_after: CONCURRENCY_RETRY,
+            });
+        }
+
+        if let Some(limit) = self.limits.requests_per_minute
+            && self.window.len() >= limit as usize
+        {
+            let excess = self.window.len() + 1 - limit as usize;
+            return Err(Throttle {
+                code: "tenant_request_rate_exceeded",
+                message: format!("at its limit of {} requests per minute", limit),
+                limit: limit as u64,
+                retry_after: self.expiry_of(excess, now),
+            });
+        }
+
+        if let Some(limit) = self.limits.tokens_per_minute {
+            let used = self.tokens();
+            if used + tokens > limit {
+                // Wait until enough of the window expires to fit this request
+                let mut freed = 0;
+                let mut count = 0;
+                for &(_, entry) in &self.window {
+                    if used - freed + tokens <= limit {
+                        break;
+                    }
+                    freed += entry;
+                    count += 1;
+                }
+                return Err(Throttle {
+                    code: "tenant_token_rate_exceeded",
+                    message: format!(
+                        "at its limit of {} tokens per minute ({} used, {} requested)",
+                        limit, used, tokens
+                    ),
+                    limit,
+                    retry_after: self.expiry_of(count, now),
+                });
+            }
+        }
+        Ok(())
+    }
+
+    fn usage(&self, in_flight: usize) -> TenantUsage {
+        TenantUsage {
+            in_flight,
+            requests_last_minute: self.window.len(),
+            tokens_last_minute: self.tokens(),
+            throttled: self.throttled,
+        }
+    }
+
+    fn quota(&self, tenant: &str, in_flight: usize, now: Instant) -> TenantQuota {
+        // The window is empty once its newest entry expires
+        let resets_at = Utc::now()
+            + self
+                .window
+                .back()
+                .map(|&(at, _)| (at + RATE_WINDOW).saturating_duration_since(now))
+                .unwrap_or_default();
+        let window = |unit: &str, limit: u64, used: u64| QuotaWindow {
+            unit: unit.to_string(),
+            limit,
+            used,
+            remaining: limit.saturating_sub(used),
+            window_seconds: RATE_WINDOW.as_secs(),
+            resets_at,
+        };
+
+        let mut windows = Vec::new();
+        if let Some(limit) = self.limits.requests_per_minute {
+            windows.push(window("requests", limit as u64, self.window.len() as u64));
+        }
+        if let Some(limit) = self.limits.tokens_per_minute {
+            windows.push(window("tokens", limit, self.tokens()));
+        }
+        let concurrency = self.limits.max_concurrent.map(|limit| QuotaConcurrency {
+            limit: limit as u64,
+            in_flight: in_flight as u64,
+            remaining: (limit as u64).saturating_sub(in_flight as u64),
+        });
+        TenantQuota {
+            object: "usage.quota".to_string(),
+            tenant: tenant.to_string(),
+            limited: !windows.is_empty() || concurrency.is_some(),
+            suspended: self.suspended,
+            windows,
+            concurrency,
+        }
+    }
+
+    fn info(&self, tenant: &str, in_flight: usize) -> TenantInfo {
+        TenantInfo {
+            object: "tenant.limits".to_string(),
+            tenant: tenant.to_string(),
+            limits: self.limits.clone(),
+            usage: self.usage(in_flight),
+            updated_at: self.updated_at,
+        }
+    }
+
+    fn tenant(&self, id: &str, in_flight: usize) -> Tenant {
+        Tenant {
+            object: "tenant".to_string(),
+            id: id.to_string(),
+            name: self.name.clone(),
+            allowed_models: self.allowed_models.clone(),
+            limits: self.limits.clone(),
+            suspended: self.suspended,
+            usage: self.usage(in_flight),
+            created_at: self.created_at,
+            updated_at: self.updated_at,
+        }
+    }
+}
+
+/// Why a tenant could not be created or changed
+#[derive(Debug, Clone, PartialEq)]
+enum TenantError {
+    NotFound,
+    AlreadyExists,
+    /// A dedicated model, and the tenant that already holds it
+    ModelDedicated(String, String),
+}
+
+/// The first of `limits`' dedicated models another tenant already holds
+fn dedication_conflict(
+    tenants: &HashMap<String, TenantState>,
+    tenant: &str,
+    limits: &TenantLimits,
+) -> Option<TenantError> {
+    tenants
+        .iter()
+        .filter(|(other, _)| other.as_str() != tenant)
+        .find_map(|(other, state)| {
+            limits
+                .dedicated_models
+                .iter()
+                .find(|model| state.limits.dedicated_models.contains(model))
+                .map(|model| TenantError::ModelDedicated(model.clone(), other.clone()))
+        })
+}
+
+/// Tenant limits and the usage counted against them
+#[derive(Debug, Default)]
+pub struct TenantRegistry {
+    tenants: Mutex<HashMap<String, TenantState>>,
+}
+
+impl TenantRegistry {
+    pub fn new() -> Self {
+        Self::default()
+    }
+
+    fn create(&self, request: CreateTenantRequest) -> Result<(), TenantError> {
+        let mut tenants = self.tenants.lock().unwrap();
+        if tenants.contains_key(&request.id) {
+            return Err(TenantError::AlreadyExists);
+        }
+        if let Some(conflict) = dedication_conflict(&tenants, &request.id, &request.limits) {
+            return Err(conflict);
+        }
+
+        let mut state = TenantState::new(request.limits);
+        state.name = request.name;
+        state.allowed_models = request.allowed_models;
+        tenants.insert(request.id, state);
+        Ok(())
+    }
+
+    fn update(&self, tenant: &str, request: UpdateTenantRequest) -> Result<(), TenantError> {
+        let mut tenants = self.tenants.lock().unwrap();
+        if let Some(limits) = &request.limits
+            && let Some(conflict) = dedication_conflict(&tenants, tenant, limits)
+        {
+            return Err(conflict);
+        }
+
+        let state = tenants.get_mut(tenant).ok_or(TenantError::NotFound)?;
+        if let Some(name) = request.name {
+            state.name = Some(name).filter(|name| !name.is_empty());
+        }
+        if let Some(models) = request.allowed_models {
+            state.allowed_models = models;
+        }
+        if let Some(limits) = request.limits {
+            state.limits = limits;
+        }
+        state.updated_at = Utc::now();
+        Ok(())
+    }
+
+    /// Replace a tenant's limits, keeping its usage window, and create the
+    /// tenant if it does not exist yet
+    fn set_limits(&self, tenant: &str, limits: TenantLimits) -> Result<(), TenantError> {
+        let mut tenants = self.tenants.lock().unwrap();
+        if let Some(conflict) = dedication_conflict(&tenants, tenant, &limits) {
+            return Err(conflict);
+        }
+
+        let state = tenants
+            .entry(tenant.to_string())
+            .or_insert_with(|| TenantState::new(TenantLimits::default()));
+        state.limits = limits;
+        state.updated_at = Utc::now();
+        Ok(())
+    }
+
+    fn set_suspended(&self, tenant: &str, suspended: bool) -> bool {
+        let mut tenants = self.tenants.lock().unwrap();
+        match tenants.get_mut(tenant) {
+            Some(state) => {
+                state.suspended = suspended;
+                state.updated_at = Utc::now();
+                true
+            }
+            None => false,
+        }
+    }
+
+    fn remove(&self, tenant: &str) -> bool {
+        self.tenants.lock().unwrap().remove(tenant).is_some()
+    }
+
+    fn tenant(&self, tenant: &str, in_flight: usize) -> Option<Tenant> {
+        let mut tenants = self.tenants.lock().unwrap();
+        let state = tenants.get_mut(tenant)?;
+        state.prune(Instant::now());
+        Some(state.tenant(tenant, in_flight))
+    }
+
+    fn info(&self, tenant: &str, in_flight: usize) -> Option<TenantInfo> {
+        let mut tenants = self.tenants.lock().unwrap();
+        let state = tenants.get_mut(tenant)?;
+        state.prune(Instant::now());
+        Some(state.info(tenant, in_flight))
+    }
+
+    /// A tenant's remaining quota; unlimited for tenants without limits
+    fn quota(&self, tenant: &str, in_flight: usize) -> TenantQuota {
+        let mut tenants = self.tenants.lock().unwrap();
+        let now = Instant::now();
+        match tenants.get_mut(tenant) {
+            Some(state) => {
+                state.prune(now);
+                state.quota(tenant, in_flight, now)
+            }
+            None => TenantState::new(TenantLimits::default()).quota(tenant, in_flight, now),
+        }
+    }
+
+    fn tenants(&self) -> Vec<String> {
+        let mut tenants: Vec<String> = self.tenants.lock().unwrap().keys().cloned().collect();
+        tenants.sort();
+        tenants
+    }
+
+    /// The tenant a model is dedicated to, if any
+    pub fn owner_of(&self, model: &str) -> Option<String> {
+        let tenants = self.tenants.lock().unwrap();
+        tenants
+            .iter()
+            .find(|(_, state)| state.limits.dedicated_models.iter().any(|m| m == model))
+            .map(|(tenant, _)| tenant.clone())
+    }
+
+    /// Every dedicated model and the tenant it belongs to
+    pub fn dedicated_models(&self) -> BTreeMap<String, String> {
+        let tenants = self.tenants.lock().unwrap();
+        tenants
+            .iter()
+            .flat_map(|(tenant, state)| {
+                state
+                    .limits
+                    .dedicated_models
+                    .iter()
+                    .map(move |model| (model.clone(), tenant.clone()))
+            })
+            .collect()
+    }
+
+    fn policy(&self, tenant: &str) -> Option<TenantPolicy> {
+        let tenants = self.tenants.lock().unwrap();
+        tenants.get(tenant).map(|state| TenantPolicy {
+            limits: state.limits.clone(),
+            allowed_models: state.allowed_models.clone(),
+            suspended: state.suspended,
+        })
+    }
+
+    /// Count a request against a tenant's limits. Tenants without limits
+    /// are always admitted.
+    pub fn admit(&self, tenant: &str, in_flight: usize, tokens: u64) -> Result<(), Throttle> {
+        let mut tenants = self.tenants.lock().unwrap();
+        match tenants.get_mut(tenant) {
+            Some(state) => state.admit(in_flight, tokens, Instant::now()),
+            None => Ok(()),
+        }
+    }
+}
+
+/// The fields of a generation request the limits look at
+#[derive(Debug, Default, Deserialize)]
+struct RequestSummary {
+    #[serde(default)]
+    model: Option<String>,
+    #[serde(default, alias = "max_completion_tokens")]
+    max_tokens: Option<u64>,
+    #[serde(default)]
+    n: Option<u64>,
+}
+
+impl RequestSummary {
+    fn requested_tokens(&self) -> u64 {
+        let max_tokens = self
+            .max_tokens
+            .unwrap_or_else(|| sampling_defaults().max_tokens as u64);
+        max_tokens * self.n.unwrap_or(1).max(1)
+    }
+}
+
+/// The model named in a KServe `/v2/models/{name}/...` path
+pub(crate) fn model_from_path(path: &str) -> Option<String> {
+    let rest = path.strip_prefix("/v2/models/")?;
+    rest.split('/')
+        .next()
+        .filter(|name| !name.is_empty())
+        .map(str::to_string)
+}
+
+fn throttled(tenant: &str, throttle: &Throttle) -> Response {
+    let retry_ms = throttle.retry_after.as_millis() as u64;
+    let message = format!(
+        "Tenant '{}' is {}; retry in {} ms",
+        tenant, throttle.message, retry_ms
+    );
+    let mut response = (
+        StatusCode::TOO_MANY_REQUESTS,
+        Json(json!({
+            "error": {
+                "message": message,
+                "type": "rate_limit_error",
+                "param": null,
+                "code": throttle.code,
+                "tenant": tenant,
+                "limit": throttle.limit,
+                "retry_after_ms": retry_ms
+            }
+        })),
+    )
+        .into_response();
+    let seconds = throttle.retry_after.as_secs_f64().ceil().max(1.0) as u64;
+    if let Ok(value) = HeaderValue::from_str(&seconds.to_string()) {
+        response.headers_mut().insert(header::RETRY_AFTER, value);
+    }
+    audit_events::mark(
+        response,
+        AuditKind::PolicyViolation,
+        Some(throttle.code),
+        &message,
+    )
+}
+
+/// A refusal recorded as a policy violation audit event
+fn violation(status: StatusCode, message: String, param: &str, code: &str) -> Response {
+    let response = error_response(status, message.clone(), Some(param), Some(code));
+    audit_events::mark(response, AuditKind::PolicyViolation, Some(code), &message)
+}
+
+fn tenant_not_found(tenant: &str) -> Response {
+    error_response(
+        StatusCode::NOT_FOUND,
+        format!("Tenant '{}' not found", tenant),
+        Some("tenant_id"),
+        Some("tenant_not_found"),
+    )
+}
+
+fn tenant_error(tenant: &str, error: TenantError) -> Response {
+    match error {
+        TenantError::NotFound => tenant_not_found(tenant),
+        TenantError::AlreadyExists => error_response(
+            StatusCode::CONFLICT,
+            format!("Tenant '{}' already exists", tenant),
+            Some("id"),
+            Some("tenant_already_exists"),
+        ),
+        TenantError::ModelDedicated(model, owner) => error_response(
+            StatusCode::CONFLICT,
+            format!(
+                "Model '{}' is already dedicated to tenant '{}'",
+                model, owner
+            ),
+            Some("dedicated_models"),
+            Some("model_already_dedicated"),
+        ),
+    }
+}
+
+/// The tenant a request acts for, given the tenant its credential belongs
+/// to and the one its header names; the error holds the message and code
+fn resolve_tenant(
+    bound: Option<&str>,
+    claimed: Option<&str>,
+    admin: bool,
+) -> Result<String, (String, &'static str)> {
+    match (bound, claimed) {
+        (Some(bound), Some(claimed)) if bound != claimed => Err((
+            format!(
+                "The credential belongs to tenant '{}', not '{}'",
+                bound, claimed
+            ),
+            "tenant_mismatch",
+        )),
+        (Some(bound), _) => Ok(bound.to_string()),
+        (None, Some(claimed)) if admin || claimed == DEFAULT_TENANT => Ok(claimed.to_string()),
+        (None, Some(claimed)) => Err((
+            format!(
+                "The credential does not belong to tenant '{}'; use a key or JWT issued for it",
+                claimed
+            ),
+            "tenant_not_authenticated",
+        )),
+        (None, None) => Ok(DEFAULT_TENANT.to_string()),
+    }
+}
+
+/// Middleware tying a request's tenant to its credential: a managed key's
+/// tenant or the JWT's `tenant` claim. A header naming another tenant is
+/// refused with 403, and the header is then set to the tenant the request
+/// acts for.
+pub async fn authenticate_tenant(
+    State(state): State<Arc<ServerState>>,
+    mut request: Request,
+    next: Next,
+) -> Response {
+    let bound = state
+        .api_keys
+        .scope(request.headers())
+        .and_then(|key| key.tenant)
+        .or_else(|| {
+            request
+                .extensions()
+                .get::<JwtClaims>()
+                .and_then(|JwtClaims(claims)| claims.get(TENANT_CLAIM))
+                .and_then(|tenant| tenant.as_str())
+                .map(str::to_string)
+        });
+    let claimed = tenant_from_headers(request.headers());
+    let admin = authorize_admin(request.headers()).is_ok();
+
+    let tenant = match resolve_tenant(bound.as_deref(), claimed.as_deref(), admin) {
+        Ok(tenant) => tenant,
+        Err((message, code)) => {
+            return violation(StatusCode::FORBIDDEN, message, "tenant", code);
+        }
+    };
+    let Ok(value) = HeaderValue::from_str(&tenant) else {
+        return violation(
+            StatusCode::FORBIDDEN,
+            "The credential's tenant is not a valid tenant id".to_string(),
+            "tenant",
+            "invalid_tenant_id",
+        );
+    };
+    request.headers_mut().insert(TENANT_HEADER, value);
+    next.run(request).await
+}
+
+/// Middleware holding generation requests to their tenant's limits and
+/// allowed models, refusing suspended tenants and keeping other tenants off
+/// dedicated models
+pub async fn enforce_limits(
+    State(state): State<Arc<ServerState>>,
+    request: Request,
+    next: Next,
+) -> Response {
+    let tenant =
+        tenant_from_headers(request.headers()).unwrap_or_else(|| DEFAULT_TENANT.to_string());
+    let policy = state.tenants.policy(&tenant);
+    let dedicated = state.tenants.dedicated_models();
+    if policy.is_none() && dedicated.is_empty() {
+        return next.run(request).await;
+    }
+    if let Some(policy) = &policy
+        && policy.suspended
+    {
+        return violation(
+            StatusCode::FORBIDDEN,
+            format!("Tenant '{}' is suspended", tenant),
+            "tenant",
+            "tenant_suspended",
+        );
+    }
+
+    // Buffer the body to see the model and token budget, then hand it on
+    let (parts, body) = request.into_parts();
+    let bytes = match axum::body::to_bytes(body, MAX_INSPECTED_BODY).await {
+        Ok(bytes) => bytes,
+        Err(_) => {
+            return error_response(
+                StatusCode::PAYLOAD_TOO_LARGE,
+                "Request body is too large".to_string(),
+                Some("body"),
+                Some("body_too_large"),
+            );
+        }
+    };
+    let summary: RequestSummary = serde_json::from_slice(&bytes).unwrap_or_default();
+    let request = Request::from_parts(parts, Body::from(bytes));
+
+    let model = summary
+        .model
+        .clone()
+        .or_else(|| model_from_path(request.uri().path()));
+    if let Some(model) = &model
+        && let Some(owner) = dedicated.get(model)
+        && owner != &tenant
+    {
+        return violation(
+            StatusCode::FORBIDDEN,
+            format!("Model '{}' is dedicated to another tenant", model),
+            "model",
+            "model_dedicated",
+        );
+    }
+    if let Some(model) = &model
+        && let Some(policy) = &policy
+        && !policy.allows(model)
+    {
+        return violation(
+            StatusCode::FORBIDDEN,
+            format!("Tenant '{}' may not use model '{}'", tenant, model),
+            "model",
+            "model_not_allowed",
+        );
+    }
+
+    if let Some(TenantPolicy { limits, .. }) = policy {
+        let tokens = summary.requested_tokens();
+        if let Some(limit) = limits.tokens_per_minute
+            && tokens > limit
+        {
+            return violation(
+                StatusCode::BAD_REQUEST,
+                format!(
+                    "The request asks for {} tokens, more than tenant '{}' may use in a minute ({})",
+                    tokens, tenant, limit
+                ),
+                "max_tokens",
+                "tenant_token_limit_exceeded",
+            );
+        }
+
+        let in_flight = state.request_queue.tenant_len(&tenant);
+        if let Err(throttle) = state.tenants.admit(&tenant, in_flight, tokens) {
+            return throttled(&tenant, &throttle);
+        }
+    }
+
+    next.run(request).await
+}
+
+// API Handlers
+
+/// `GET /usage/quota` - the requests and tokens the caller's tenant may
+/// still send in each window, and when the windows reset
+pub async fn get_quota(State(state): State<Arc<ServerState>>, headers: HeaderMap) -> Response {
+    let tenant = tenant_from_headers(&headers).unwrap_or_else(|| DEFAULT_TENANT.to_string());
+    let in_flight = state.request_queue.tenant_len(&tenant);
+    Json(state.tenants.quota(&tenant, in_flight)).into_response()
+}
+
+/// `GET /admin/tenants` - every tenant and its usage (admin only)
+pub async fn list_tenants(State(state): State<Arc<ServerState>>, headers: HeaderMap) -> Response {
+    if let Err(response) = authorize_admin(&headers) {
+        return response;
+    }
+
+    let data: Vec<Tenant> = state
+        .tenants
+        .tenants()
+        .iter()
+        .filter_map(|tenant| {
+            let in_flight = state.request_queue.tenant_len(tenant);
+            state.tenants.tenant(tenant, in_flight)
+        })
+        .collect();
+    Json(json!({ "object": "list", "data": data })).into_response()
+}
+
+/// `POST /admin/tenants` - create a tenant (admin only)
+pub async fn create_tenant(
+    State(state): State<Arc<ServerState>>,
+    headers: HeaderMap,
+    Json(request): Json<CreateTenantRequest>,
+) -> Response {
+    if let Err(response) = authorize_admin(&headers) {
+        return response;
+    }
+
+    if let Err(message) = validate_tenant_id(&request.id) {
+        return error_response(
+            StatusCode::BAD_REQUEST,
+            message,
+            Some("id"),
+            Some("invalid_tenant_id"),
+        );
+    }
+    if let Err(message) = validate_models(&request.allowed_models) {
+        return error_response(
+            StatusCode::BAD_REQUEST,
+            message,
+            Some("allowed_models"),
+            Some("invalid_tenant"),
+        );
+    }
+    if let Err((message, param)) = request.limits.validate() {
+        return error_response(
+            StatusCode::BAD_REQUEST,
+            message,
+            Some(param),
+            Some("invalid_limits"),
+        );
+    }
+
+    let id = request.id.clone();
+    if let Err(error) = state.tenants.create(request) {
+        return tenant_error(&id, error);
+    }
+
+    info!("Tenant {} created", id);
+    let tenant = state.tenants.tenant(&id, 0);
+    (StatusCode::CREATED, Json(tenant)).into_response()
+}
+
+/// `GET /admin/tenants/:tenant_id` - one tenant and its usage (admin only)
+pub async fn get_tenant(
+    State(state): State<Arc<ServerState>>,
+    Path(tenant): Path<String>,
+    headers: HeaderMap,
+) -> Response {
+    if let Err(response) = authorize_admin(&headers) {
+        return response;
+    }
+
+    let in_flight = state.request_queue.tenant_len(&tenant);
+    match state.tenants.tenant(&tenant, in_flight) {
+        Some(record) => Json(record).into_response(),
+        None => tenant_not_found(&tenant),
+    }
+}
+
+/// `PATCH /admin/tenants/:tenant_id` - change a tenant's name, allowed
+/// models or limits (admin only)
+pub async fn update_tenant(
+    State(state): State<Arc<ServerState>>,
+    Path(tenant): Path<String>,
+    headers: HeaderMap,
+    Json(request): Json<UpdateTenantRequest>,
+) -> Response {
+    if let Err(response) = authorize_admin(&headers) {
+        return response;
+    }
+
+    if let Some(models) = &request.allowed_models
+        && let Err(message) = validate_models(models)
+    {
+        return error_response(
+            StatusCode::BAD_REQUEST,
+            message,
+            Some("allowed_models"),
+            Some("invalid_tenant"),
+        );
+    }
+    if let Some(limits) = &request.limits
+        && let Err((message, param)) = limits.validate()
+    {
+        return error_response(
+            StatusCode::BAD_REQUEST,
+            message,
+            Some(param),
+            Some("invalid_limits"),
+        );
+    }
+
+    if let Err(error) = state.tenants.update(&tenant, request) {
+        return tenant_error(&tenant, error);
+    }
+    get_tenant(State(state), Path(tenant), headers).await
+}
+
+/// `DELETE /admin/tenants/:tenant_id` - remove a tenant, lifting its limits
+/// and releasing its dedicated models (admin only)
+pub async fn delete_tenant(
+    State(state): State<Arc<ServerState>>,
+    Path(tenant): Path<String>,
+    headers: HeaderMap,
+) -> Response {
+    if let Err(response) = authorize_admin(&headers) {
+        return response;
+    }
+
+    if state.tenants.remove(&tenant) {
+        info!("Tenant {} deleted", tenant);
+        Json(json!({ "id": tenant, "object": "tenant", "deleted": true })).into_response()
+    } else {
+        tenant_not_found(&tenant)
+    }
+}
+
+/// `POST /admin/tenants/:tenant_id/suspend` - refuse a tenant's generation
+/// requests until it is resumed (admin only). Requests already running
+/// finish.
+pub async fn suspend_tenant(
+    State(state): State<Arc<ServerState>>,
+    Path(tenant): Path<String>,
+    headers: HeaderMap,
+) -> Response {
+    set_suspended(state, tenant, headers, true).await
+}
+
+/// `POST /admin/tenants/:tenant_id/resume` - lift a suspension (admin only)
+pub async fn resume_tenant(
+    State(state): State<Arc<ServerState>>,
+    Path(tenant): Path<String>,
+    headers: HeaderMap,
+) -> Response {
+    set_suspended(state, tenant, headers, false).await
+}
+
+async fn set_suspended(
+    state: Arc<ServerState>,
+    tenant: String,
+    headers: HeaderMap,
+    suspended: bool,
+) -> Response {
+    if let Err(response) = authorize_admin(&headers) {
+        return response;
+    }
+
+    if !state.tenants.set_suspended(&tenant, suspended) {
+        return tenant_not_found(&tenant);
+    }
+    info!(
+        "Tenant {} {}",
+        tenant,
+        if suspended { "suspended" } else { "resumed" }
+    );
+    get_tenant(State(state), Path(tenant), headers).await
+}
+
+/// `GET /admin/tenants/:tenant_id/limits` - a tenant's limits and usage
+/// (admin only)
+pub async fn get_limits(
+    State(state): State<Arc<ServerState>>,
+    Path(tenant): Path<String>,
+    headers: HeaderMap,
+) -> Response {
+    if let Err(response) = authorize_admin(&headers) {
+        return response;
+    }
+
+    let in_flight = state.request_queue.tenant_len(&tenant);
+    match state.tenants.info(&tenant, in_flight) {
+        Some(info) => Json(info).into_response(),
+        None => tenant_not_found(&tenant),
+    }
+}
+
+/// `PUT /admin/tenants/:tenant_id/limits` - set a tenant's limits, creating
+/// the tenant if needed (admin only)
+pub async fn put_limits(
+    State(state): State<Arc<ServerState>>,
+    Path(tenant): Path<String>,
+    headers: HeaderMap,
+    Json(limits): Json<TenantLimits>,
+) -> Response {
+    if let Err(response) = authorize_admin(&headers) {
+        return response;
+    }
+
+    if let Err((message, param)) = limits.validate() {
+        return error_response(
+            StatusCode::BAD_REQUEST,
+            message,
+            Some(param),
+            Some("invalid_limits"),
+        );
+    }
+    if let Err(message) = validate_tenant_id(&tenant) {
+        return error_response(
+            StatusCode::BAD_REQUEST,
+            message,
+            Some("tenant_id"),
+            Some("invalid_tenant_id"),
+        );
+    }
+    if let Err(error) = state.tenants.set_limits(&tenant, limits.clone()) {
+        return tenant_error(&tenant, error);
+    }
+
+    info!(
+        "Limits for tenant {} set: concurrent {:?}, rpm {:?}, tpm {:?}, {} dedicated models",
+        tenant,
+        limits.max_concurrent,
+        limits.requests_per_minute,
+        limits.tokens_per_minute,
+        limits.dedicated_models.len()
+    );
+    get_limits(State(state), Path(tenant), headers).await
+}
+
+/// `DELETE /admin/tenants/:tenant_id/limits` - lift a tenant's limits and
+/// release its dedicated models, keeping the tenant (admin only)
+pub async fn delete_limits(
+    State(state): State<Arc<ServerState>>,
+    Path(tenant): Path<String>,
+    headers: HeaderMap,
+) -> Response {
+    if let Err(response) = authorize_admin(&headers) {
+        return response;
+    }
+
+    if state.tenants.policy(&tenant).is_none() {
+        return tenant_not_found(&tenant);
+    }
+    if let Err(error) = state.tenants.set_limits(&tenant, TenantLimits::default()) {
+        return tenant_error(&tenant, error);
+    }
+    Json(json!({ "id": tenant, "object": "tenant.limits", "deleted": true })).into_response()
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn state(limits: TenantLimits) -> TenantState {
+        TenantState::new(limits)
+    }
+
+    #[test]
+    fn test_tenant_follows_credential() {
+        assert_eq!(resolve_tenant(Some("acme"), None, false).unwrap(), "acme");
+        assert_eq!(
+            resolve_tenant(Some("acme"), Some("acme"), false).unwrap(),
+            "acme"
+        );
+        let (_, code) = resolve_tenant(Some("acme"), Some("globex"), false).unwrap_err();
+        assert_eq!(code, "tenant_mismatch");
+        let (_, code) = resolve_tenant(Some("acme"), Some("globex"), true).unwrap_err();
+        assert_eq!(code, "tenant_mismatch");
+
+        assert_eq!(resolve_tenant(None, None, false).unwrap(), DEFAULT_TENANT);
+        assert_eq!(
+            resolve_tenant(None, Some(DEFAULT_TENANT), false).unwrap(),
+            DEFAULT_TENANT
+        );
+        let (_, code) = resolve_tenant(None, Some("acme"), false).unwrap_err();
+        assert_eq!(code, "tenant_not_authenticated");
+        assert_eq!(resolve_tenant(None, Some("acme"), true).unwrap(), "acme");
+    }
+
+    #[test]
+    fn test_concurrency_cap() {
+        let mut tenant = state(TenantLimits {
+            max_concurrent: Some(2),
+            ..Default::default()
+        });
+        let now = Instant::now();
+        assert!(tenant.admit(1, 10, now).is_ok());
+        let throttle = tenant.admit(2, 10, now).unwrap_err();
+        assert_eq!(throttle.code, "tenant_concurrency_exceeded");
+        assert_eq!(throttle.limit, 2);
+        assert_eq!(tenant.throttled, 1);
+    }
+
+    #[test]
+    fn test_request_rate_retry_hint() {
+        let mut tenant = state(TenantLimits {
+            requests_per_minute: Some(2),
+            ..Default::default()
+        });
+        let start = Instant::now();
+        assert!(tenant.admit(0, 1, start).is_ok());
+        assert!(tenant.admit(0, 1, start + Duration::from_secs(10)).is_ok());
+
+        let throttle = tenant
+            .admit(0, 1, start + Duration::from_secs(20))
+            .unwrap_err();
+        assert_eq!(throttle.code, "tenant_request_rate_exceeded");
+        assert_eq!(throttle.retry_after, Duration::from_secs(40));
+
+        // The first request leaves the window after a minute
+        assert!(tenant.admit(0, 1, start + Duration::from_secs(61)).is_ok());
+    }
+
+    #[test]
+    fn test_token_rate_waits_for_enough_to_expire() {
+        let mut tenant = state(TenantLimits {
+            tokens_per_minute: Some(1000),
+            ..Default::default()
+        });
+        let start = Instant::now();
+        assert!(tenant.admit(0, 400, start).is_ok());
+        assert!(tenant.admit(0, 400, start + Duration::from_secs(5)).is_ok());
+
+        // 800 used; 700 more needs both earlier requests to expire
+        let throttle = tenant
+            .admit(0, 700, start + Duration::from_secs(10))
+            .unwrap_err();
+        assert_eq!(throttle.code, "tenant_token_rate_exceeded");
+        assert_eq!(throttle.retry_after, Duration::from_secs(55));
+
+        // 300 more fits once the first has expired
+        let throttle = tenant
+            .admit(0, 300, start + Duration::from_secs(10))
+            .unwrap_err();
+        assert_eq!(throttle.retry_after, Duration::from_secs(50));
+        assert!(
+            tenant
+                .admit(0, 200, start + Duration::from_secs(10))
+                .is_ok()
+        );
+    }
+
+    #[test]
+    fn test_dedicated_models_are_exclusive() {
+        let registry = TenantRegistry::new();
+        let dedicated = TenantLimits {
+            dedicated_models: vec!["llama".to_string()],
+            ..Default::default()
+        };
+        assert!(registry.set_limits("acme", dedicated.clone()).is_ok());
+        assert_eq!(registry.owner_of("llama"), Some("acme".to_string()));
+        assert_eq!(
+            registry.set_limits("globex", dedicated.clone()),
+            Err(TenantError::ModelDedicated(
+                "llama".to_string(),
+                "acme".to_string()
+            ))
+        );
+        // A tenant may restate its own dedication
+        assert!(registry.set_limits("acme", dedicated).is_ok());
+
+        assert!(registry.remove("acme"));
+        assert_eq!(registry.owner_of("llama"), None);
+    }
+
+    #[test]
+    fn test_validate_and_summary() {
+        let zero = TenantLimits {
+            requests_per_minute: Some(0),
+            ..Default::default()
+        };
+        assert_eq!(zero.validate().unwrap_err().1, "requests_per_minute");
+        assert!(TenantLimits::default().validate().is_ok());
+
+        let summary: RequestSummary =
+            serde_json::from_value(json!({ "model": "m", "max_completion_tokens": 50, "n": 3 }))
+                .unwrap();
+        assert_eq!(summary.requested_tokens(), 150);
+        assert_eq!(
+            model_from_path("/v2/models/resnet/versions/1/infer"),
+            Some("resnet".to_string())
+        );
+        assert_eq!(model_from_path("/v1/chat/completions"), None);
+    }
+
+    #[test]
+    fn test_tenant_lifecycle() {
+        let registry = TenantRegistry::new();
+        let create = CreateTenantRequest {
+            id: "acme".to_string(),
+            name: Some("Acme Corp".to_string()),
+            allowed_models: vec!["llama".to_string()],
+            limits: TenantLimits::default(),
+        };
+        assert!(registry.create(create.clone()).is_ok());
+        assert_eq!(registry.create(create), Err(TenantError::AlreadyExists));
+
+        let policy = registry.policy("acme").unwrap();
+        assert!(policy.allows("llama"));
+        assert!(!policy.allows("mistral"));
+
+        let update = UpdateTenantRequest {
+            allowed_models: Some(Vec::new()),
+            ..Default::default()
+        };
+        assert!(registry.update("acme", update.clone()).is_ok());
+        assert!(registry.policy("acme").unwrap().allows("mistral"));
+        assert_eq!(
+            registry.update("globex", update),
+            Err(TenantError::NotFound)
+        );
+
+        assert!(registry.set_suspended("acme", true));
+        let tenant = registry.tenant("acme", 0).unwrap();
+        assert!(tenant.suspended);
+        assert_eq!(tenant.name.as_deref(), Some("Acme Corp"));
+        assert!(!registry.set_suspended("globex", true));
+    }
+
+    #[test]
+    fn test_suspension_follows_credential() {
+        let registry = TenantRegistry::new();
+        registry
+            .create(CreateTenantRequest {
+                id: "acme".to_string(),
+                name: None,
+                allowed_models: Vec::new(),
+                limits: TenantLimits::default(),
+            })
+            .unwrap();
+        registry.set_suspended("acme", true);
+
+        // A key of a suspended tenant stays that tenant with the header
+        // left out, and cannot name another one to get around the check
+        let tenant = resolve_tenant(Some("acme"), None, false).unwrap();
+        assert!(registry.policy(&tenant).unwrap().suspended);
+        for claimed in [DEFAULT_TENANT, "globex"] {
+            assert!(resolve_tenant(Some("acme"), Some(claimed), false).is_err());
+        }
+    }
+
+    #[test]
+    fn test_quota() {
+        let mut tenant = state(TenantLimits {
+            max_concurrent: Some(4),
+            requests_per_minute: Some(10),
+            tokens_per_minute: Some(1_000),
+            ..Default::default()
+        });
+        let now = Instant::now();
+        assert!(tenant.admit(0, 300, now).is_ok());
+        assert!(tenant.admit(1, 200, now).is_ok());
+
+        let quota = tenant.quota("acme", 1, now);
+        assert!(quota.limited);
+        assert_eq!(quota.windows[0].unit, "requests");
+        assert_eq!(quota.windows[0].remaining, 8);
+        assert_eq!(quota.windows[1].unit, "tokens");
+        assert_eq!(quota.windows[1].remaining, 500);
+        assert_eq!(quota.concurrency.unwrap().remaining, 3);
+
+        let registry = TenantRegistry::new();
+        let quota = registry.quota("globex", 0);
+        assert!(!quota.limited);
+        assert!(quota.windows.is_empty());
+    }
+
+    #[test]
+    fn test_tenant_ids() {
+        assert!(validate_tenant_id("team-a.prod_1").is_ok());
+        assert!(validate_tenant_id("").is_err());
+        assert!(validate_tenant_id("a/b").is_err());
+        assert!(validate_tenant_id(&"x".repeat(65)).is_err());
+    }
+}
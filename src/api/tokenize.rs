@@ -0,0 +1,140 @@
+//! Tokenization
+//!
+//! `POST /v1/tokenize` returns the token IDs a model's own tokenizer produces
+//! for one text or a list of them, so clients can fit prompts to a context
+//! window exactly instead of estimating. The model is loaded on first use
+//! like any other request; backends that do not expose a tokenizer answer
+//! with a 400.
+
+use crate::{
+    api::{
+        invalid_request,
+        openai::{StringOrArray, get_or_load_backend},
+    },
+    cli::serve::ServerState,
+};
+use axum::{
+    Json,
+    extract::State,
+    response::{IntoResponse, Response},
+};
+use serde::{Deserialize, Serialize};
+use serde_json::json;
+use std::sync::Arc;
+
+/// Most texts a single tokenize request may carry
+const MAX_TOKENIZE_INPUTS: usize = 2048;
+
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct TokenizeRequest {
+    pub model: String,
+    pub input: StringOrArray,
+    /// Return only counts, leaving out the token IDs
+    #[serde(default)]
+    pub count_only: bool,
+}
+
+/// Tokens of one input text
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct TokenizedText {
+    pub index: usize,
+    pub count: usize,
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub tokens: Option<Vec<u32>>,
+}
+
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct TokenizeResponse {
+    pub model: String,
+    pub data: Vec<TokenizedText>,
+    /// Sum of every input's count
+    pub total_tokens: usize,
+}
+
+// API Handlers
+
+/// `POST /v1/tokenize` - token IDs of each input under the model's tokenizer
+pub async fn tokenize(
+    State(state): State<Arc<ServerState>>,
+    Json(request): Json<TokenizeRequest>,
+) -> Response {
+    let inputs = match request.input {
+        StringOrArray::String(text) => vec![text],
+        StringOrArray::Array(texts) => texts,
+    };
+    if inputs.len() > MAX_TOKENIZE_INPUTS {
+        return invalid_request(
+            format!(
+                "at most {} inputs may be tokenized per request",
+                MAX_TOKENIZE_INPUTS
+            ),
+            Some("input"),
+            None,
+        );
+    }
+
+    let backend = match get_or_load_backend(&state, &request.model).await {
+        Ok(backend) => backend,
+        Err(e) => {
+            return invalid_request(format!("Failed to load model: {}", e), Some("model"), None);
+        }
+    };
+
+    let mut data = Vec::with_capacity(inputs.len());
+    for (index, text) in inputs.iter().enumerate() {
+        let tokens = match backend.tokenize(text).await {
+            Ok(tokens) => tokens,
+            Err(e) => {
+                return invalid_request(
+                    e.to_string(),
+                    Some("model"),
+                    Some("tokenization_not_supported"),
+                );
+            }
+        };
+        data.push(TokenizedText {
+            index,
+            count: tokens.len(),
+            tokens: (!request.count_only).then_some(tokens),
+        });
+    }
+
+    let total_tokens = data.iter().map(|text| text.count).sum();
+    Json(TokenizeResponse {
+        model: request.model,
+        data,
+        total_tokens,
+    })
+    .into_response()
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_accepts_single_text_and_lists() {
+        let single: TokenizeRequest =
+            serde_json::from_value(json!({ "model": "m", "input": "hello" })).unwrap();
+        assert!(matches!(single.input, StringOrArray::String(_)));
+        assert!(!single.count_only);
+
+        let list: TokenizeRequest = serde_json::from_value(
+            json!({ "model": "m", "input": ["a", "b"], "count_only": true }),
+        )
+        .unwrap();
+        assert!(matches!(list.input, StringOrArray::Array(ref texts) if texts.len() == 2));
+        assert!(list.count_only);
+    }
+
+    #[test]
+    fn test_count_only_omits_token_ids() {
+        let counted = TokenizedText {
+            index: 0,
+            count: 3,
+            tokens: None,
+        };
+        let encoded = serde_json::to_value(counted).unwrap();
+        assert_eq!(encoded, json!({ "index": 0, "count": 3 }));
+    }
+}
@@ -0,0 +1,501 @@
+//! Tool Calling for Chat Completions
+//!
+//! Local models have no native function-calling API, so tools are offered
+//! through the prompt: the request's `tools` are described in a system
+//! message that asks the model to answer with
+//! `<tool_call>{"name": ..., "arguments": {...}}</tool_call>` blocks, and
+//! those blocks are parsed back out of the output into OpenAI `tool_calls`.
+//! Earlier tool calls and `tool` role results in the conversation are
+//! rendered in the same markup so the model sees its own history.
+//!
+//! Output that names an undeclared tool or does not parse as JSON is returned
+//! as ordinary content.
+
+use crate::api::openai::ChatMessage;
+use serde::{Deserialize, Serialize};
+use serde_json::Value;
+use uuid::Uuid;
+
+const CALL_OPEN: &str = "<tool_call>";
+const CALL_CLOSE: &str = "</tool_call>";
+
+/// An entry of a chat request's `tools`
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct ChatTool {
+    /// Always "function"
+    #[serde(rename = "type")]
+    pub kind: String,
+    pub function: FunctionDefinition,
+}
+
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct FunctionDefinition {
+    pub name: String,
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub description: Option<String>,
+    /// JSON Schema of the arguments object
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub parameters: Option<Value>,
+}
+
+/// `tool_choice`: "none", "auto", "required" or a named function
+#[derive(Debug, Clone, Serialize, Deserialize)]
+#[serde(untagged)]
+pub enum ToolChoice {
+    Mode(String),
+    Function(NamedToolChoice),
+}
+
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct NamedToolChoice {
+    #[serde(rename = "type")]
+    pub kind: String,
+    pub function: NamedFunction,
+}
+
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct NamedFunction {
+    pub name: String,
+}
+
+/// A tool call made by the assistant
+#[derive(Debug, Clone, PartialEq, Serialize, Deserialize)]
+pub struct ToolCall {
+    pub id: String,
+    #[serde(rename = "type")]
+    pub kind: String,
+    pub function: FunctionCall,
+}
+
+#[derive(Debug, Clone, PartialEq, Serialize, Deserialize)]
+pub struct FunctionCall {
+    pub name: String,
+    /// The arguments object, JSON-encoded
+    pub arguments: String,
+}
+
+/// A tool call in a streamed chunk. Inferno sends each call whole, in the
+/// chunk after the model finishes it.
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct ToolCallDelta {
+    pub index: u32,
+    pub id: String,
+    #[serde(rename = "type")]
+    pub kind: String,
+    pub function: FunctionCall,
+}
+
+impl ToolCallDelta {
+    pub fn new(index: usize, call: ToolCall) -> Self {
+        Self {
+            index: index as u32,
+            id: call.id,
+            kind: call.kind,
+            function: call.function,
+        }
+    }
+}
+
+/// Check `tools` and `tool_choice`, returning the message and parameter of
+/// the first problem
+pub fn validate_tools(
+    tools: &[ChatTool],
+    choice: Option<&ToolChoice>,
+) -> Result<(), (String, &'static str)> {
+    for (index, tool) in tools.iter().enumerate() {
+        if tool.kind != "function" {
+            return Err((
+                format!("tools[{}].type must be \"function\"", index),
+                "tools",
+            ));
+        }
+        let name = &tool.function.name;
+        if name.is_empty()
+            || name.len() > 64
+            || !name
+                .chars()
+                .all(|c| c.is_ascii_alphanumeric() || c == '_' || c == '-')
+        {
+            return Err((
+                format!(
+                    "tools[{}].function.name must be 1-64 letters, digits, underscores or dashes",
+                    index
+                ),
+                "tools",
+            ));
+        }
+        if tools[..index]
+            .iter()
+            .any(|other| &other.function.name == name)
+        {
+            return Err((format!("duplicate tool name \"{}\"", name), "tools"));
+        }
+    }
+
+    match choice {
+        None => Ok(()),
+        Some(ToolChoice::Mode(mode)) => match mode.as_str() {
+            "none" | "auto" => Ok(()),
+            "required" if !tools.is_empty() => Ok(()),
+            "required" => Err((
+                "tool_choice \"required\" needs at least one tool".to_string(),
+                "tool_choice",
+            )),
+            other => Err((
+                format!(
+                    "tool_choice must be \"none\", \"auto\", \"required\" or a function, not \"{}\"",
+                    other
+                ),
+                "tool_choice",
+            )),
+        },
+        Some(ToolChoice::Function(named)) => {
+            if tools
+                .iter()
+                .any(|tool| tool.function.name == named.function.name)
+            {
+                Ok(())
+            } else {
+                Err((
+                    format!("tool_choice names unknown tool \"{}\"", named.function.name),
+                    "tool_choice",
+                ))
+            }
+        }
+    }
+}
+
+/// The tools the model may call under `choice`
+pub fn offered_tools<'a>(tools: &'a [ChatTool], choice: Option<&ToolChoice>) -> Vec<&'a ChatTool> {
+    match choice {
+        Some(ToolChoice::Mode(mode)) if mode == "none" => Vec::new(),
+        Some(ToolChoice::Function(named)) => tools
+            .iter()
+            .filter(|tool| tool.function.name == named.function.name)
+            .collect(),
+        _ => tools.iter().collect(),
+    }
+}
+
+/// The conversation as the model should see it: tool instructions merged
+/// into the leading system message and earlier tool calls rendered as
+/// `<tool_call>` blocks
+pub fn prompt_messages(
+    messages: &[ChatMessage],
+    tools: &[&ChatTool],
+    choice: Option<&ToolChoice>,
+) -> Vec<ChatMessage> {
+    let mut rendered: Vec<ChatMessage> = messages
+        .iter()
+        .map(|message| {
+            let mut message = message.clone();
+            for call in message.tool_calls.take().unwrap_or_default() {
+                if !message.content.is_empty() {
+                    message.content.push('\n');
+                }
+                message.content.push_str(&render_call(&call));
+            }
+            message
+        })
+        .collect();
+
+    if tools.is_empty() {
+        return rendered;
+    }
+
+    let instructions = tool_instructions(tools, choice);
+    match rendered.first_mut() {
+        Some(first) if first.role == "system" => {
+            first.content = format!("{}\n\n{}", first.content, instructions);
+        }
+        _ => rendered.insert(
+            0,
+            ChatMessage {
+                role: "system".to_string(),
+                content: instructions,
+                name: None,
+                tool_calls: None,
+                tool_call_id: None,
+            },
+        ),
+    }
+    rendered
+}
+
+fn tool_instructions(tools: &[&ChatTool], choice: Option<&ToolChoice>) -> String {
+    let mut text = format!(
+        "You can call tools. To call one, reply with {}{{\"name\": <tool name>, \"arguments\": <arguments object>}}{} \
+         and nothing after it; use one block per call. Tool results are returned in tool messages.",
+        CALL_OPEN, CALL_CLOSE
+    );
+    match choice {
+        Some(ToolChoice::Mode(mode)) if mode == "required" => {
+            text.push_str(" You must call at least one tool.");
+        }
+        Some(ToolChoice::Function(named)) => {
+            text.push_str(&format!(" You must call {}.", named.function.name));
+        }
+        _ => {}
+    }
+
+    text.push_str("\n\nAvailable tools:");
+    for tool in tools {
+        let definition = serde_json::json!({
+            "name": tool.function.name,
+            "description": tool.function.description,
+            "parameters": tool.function.parameters,
+        });
+        text.push('\n');
+        text.push_str(&definition.to_string());
+    }
+    text
+}
+
+fn render_call(call: &ToolCall) -> String {
+    let arguments: Value = serde_json::from_str(&call.function.arguments)
+        .unwrap_or_else(|_| Value::String(call.function.arguments.clone()));
+    format!(
+        "{}{}{}",
+        CALL_OPEN,
+        serde_json::json!({"name": call.function.name, "arguments": arguments}),
+        CALL_CLOSE
+    )
+}
+
+/// Split `output` into its content and tool calls. Returns `None` when the
+/// output has no well-formed call to an offered tool, in which case all of
+/// it is content; otherwise the content is the text before the first call.
+pub fn parse_tool_calls(
+    output: &str,
+    tools: &[&ChatTool],
+    parallel: bool,
+) -> Option<(String, Vec<ToolCall>)> {
+    let start = output.find(CALL_OPEN)?;
+
+    let mut calls = Vec::new();
+    let mut rest = &output[start..];
+    while let Some(open) = rest.find(CALL_OPEN) {
+        let body_start = open + CALL_OPEN.len();
+        let (body, next) = match rest[body_start..].find(CALL_CLOSE) {
+            Some(close) => (
+                &rest[body_start..body_start + close],
+                body_start + close + CALL_CLOSE.len(),
+            ),
+            // A call cut off by the end of generation may still be whole
+            None => (&rest[body_start..], rest.len()),
+        };
+        calls.push(parse_call(body, tools)?);
+        rest = &rest[next..];
+    }
+
+    if !parallel {
+        calls.truncate(1);
+    }
+    Some((output[..start].trim().to_string(), calls))
+}
+
+fn parse_call(body: &str, tools: &[&ChatTool]) -> Option<ToolCall> {
+    let value: Value = serde_json::from_str(body.trim()).ok()?;
+    let name = value.get("name")?.as_str()?;
+    if !tools.iter().any(|tool| tool.function.name == name) {
+        return None;
+    }
+
+    let arguments = match value.get("arguments").or_else(|| value.get("parameters")) {
+        None | Some(Value::Null) => "{}".to_string(),
+        Some(Value::String(encoded)) => encoded.clone(),
+        Some(arguments) => arguments.to_string(),
+    };
+    Some(ToolCall {
+        id: format!("call_{}", &Uuid::new_v4().simple().to_string()[..24]),
+        kind: "function".to_string(),
+        function: FunctionCall {
+            name: name.to_string(),
+            arguments,
+        },
+    })
+}
+
+/// Streams content while holding back anything that may be the start of a
+/// tool call, so `<tool_call>` markup never reaches the client as text
+#[derive(Debug, Default)]
+pub struct ToolCallStream {
+    output: String,
+    sent: usize,
+    in_call: bool,
+}
+
+impl ToolCallStream {
+    /// Add a token, returning the content that is now safe to send
+    pub fn push(&mut self, token: &str) -> Option<String> {
+        self.output.push_str(token);
+        if self.in_call {
+            return None;
+        }
+
+        let end = match self.output[self.sent..].find(CALL_OPEN) {
+            Some(position) => {
+                self.in_call = true;
+                self.sent + position
+            }
+            None => {
+                // Keep back a suffix that could grow into CALL_OPEN; it is
+                // ASCII, so the cut is always on a character boundary
+                let held = (1..CALL_OPEN.len())
+                    .rev()
+                    .find(|&len| self.output.ends_with(&CALL_OPEN[..len]))
+                    .unwrap_or(0);
+                self.output.len() - held
+            }
+        };
+        self.take(end.max(self.sent))
+    }
+
+    /// The full output generated so far
+    pub fn output(&self) -> &str {
+        &self.output
+    }
+
+    /// End the stream, returning the content not yet sent and the tool calls
+    pub fn finish(
+        mut self,
+        tools: &[&ChatTool],
+        parallel: bool,
+    ) -> (Option<String>, Vec<ToolCall>) {
+        match parse_tool_calls(&self.output, tools, parallel) {
+            Some((_, calls)) => {
+                let start = self.output.find(CALL_OPEN).unwrap_or(self.output.len());
+                (self.take(start.max(self.sent)), calls)
+            }
+            None => {
+                let end = self.output.len();
+                (self.take(end), Vec::new())
+            }
+        }
+    }
+
+    fn take(&mut self, end: usize) -> Option<String> {
+        let text = self.output[self.sent..end].to_string();
+        self.sent = end;
+        (!text.is_empty()).then_some(text)
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn tool(name: &str) -> ChatTool {
+        ChatTool {
+            kind: "function".to_string(),
+            function: FunctionDefinition {
+                name: name.to_string(),
+                description: Some("Look something up".to_string()),
+                parameters: Some(serde_json::json!({"type": "object"})),
+            },
+        }
+    }
+
+    #[test]
+    fn parses_calls_and_leading_content() {
+        let weather = tool("get_weather");
+        let tools = [&weather];
+        let output = "Checking.\n<tool_call>{\"name\": \"get_weather\", \"arguments\": {\"city\": \"Oslo\"}}</tool_call>\n\
+                      <tool_call>{\"name\": \"get_weather\", \"arguments\": {\"city\": \"Rome\"}}";
+
+        let (content, calls) = parse_tool_calls(output, &tools, true).unwrap();
+        assert_eq!(content, "Checking.");
+        assert_eq!(calls.len(), 2);
+        assert_eq!(calls[0].function.name, "get_weather");
+        assert_eq!(calls[1].function.arguments, r#"{"city":"Rome"}"#);
+        assert!(calls[0].id.starts_with("call_"));
+
+        let (_, calls) = parse_tool_calls(output, &tools, false).unwrap();
+        assert_eq!(calls.len(), 1);
+    }
+
+    #[test]
+    fn ignores_unknown_tools_and_bad_json() {
+        let weather = tool("get_weather");
+        let tools = [&weather];
+        assert!(parse_tool_calls("plain answer", &tools, true).is_none());
+        assert!(
+            parse_tool_calls("<tool_call>{\"name\": \"rm_rf\"}</tool_call>", &tools, true)
+                .is_none()
+        );
+        assert!(parse_tool_calls("<tool_call>not json</tool_call>", &tools, true).is_none());
+    }
+
+    #[test]
+    fn stream_holds_back_call_markup() {
+        let weather = tool("get_weather");
+        let tools = [&weather];
+        let mut stream = ToolCallStream::default();
+
+        let mut sent = String::new();
+        for token in [
+            "Sure",
+            ". <tool",
+            "_call>{\"name\": \"get_weather\",",
+            " \"arguments\": {}}</tool_call>",
+        ] {
+            if let Some(text) = stream.push(token) {
+                sent.push_str(&text);
+            }
+        }
+        let (rest, calls) = stream.finish(&tools, true);
+        sent.push_str(&rest.unwrap_or_default());
+        assert_eq!(sent, "Sure. ");
+        assert_eq!(calls.len(), 1);
+        assert_eq!(calls[0].function.arguments, "{}");
+
+        // A near miss is released once it can no longer become a call
+        let mut stream = ToolCallStream::default();
+        assert_eq!(stream.push("a <to").as_deref(), Some("a "));
+        assert_eq!(stream.push("ast>").as_deref(), Some("<toast>"));
+        assert_eq!(stream.finish(&tools, true), (None, Vec::new()));
+    }
+
+    #[test]
+    fn renders_history_and_instructions() {
+        let weather = tool("get_weather");
+        let messages = vec![
+            ChatMessage {
+                role: "user".to_string(),
+                content: "Weather in Oslo?".to_string(),
+                name: None,
+                tool_calls: None,
+                tool_call_id: None,
+            },
+            ChatMessage {
+                role: "assistant".to_string(),
+                content: String::new(),
+                name: None,
+                tool_calls: Some(vec![ToolCall {
+                    id: "call_1".to_string(),
+                    kind: "function".to_string(),
+                    function: FunctionCall {
+                        name: "get_weather".to_string(),
+                        arguments: r#"{"city":"Oslo"}"#.to_string(),
+                    },
+                }]),
+                tool_call_id: None,
+            },
+        ];
+
+        let rendered = prompt_messages(&messages, &[&weather], None);
+        assert_eq!(rendered.len(), 3);
+        assert_eq!(rendered[0].role, "system");
+        assert!(rendered[0].content.contains("\"name\":\"get_weather\""));
+        assert!(rendered[2].content.starts_with("<tool_call>{"));
+        assert!(
+            rendered[2]
+                .content
+                .contains(r#""arguments":{"city":"Oslo"}"#)
+        );
+
+        let choice = ToolChoice::Mode("none".to_string());
+        assert!(offered_tools(&[weather.clone()], Some(&choice)).is_empty());
+        assert!(validate_tools(&[weather.clone(), weather], None).is_err());
+    }
+}
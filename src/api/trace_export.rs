@@ -0,0 +1,680 @@
+//! Runtime Trace Export
+//!
+//! Every HTTP request gets a server span. A W3C `traceparent` header from
+//! the client makes the span a child of the caller's span in the same
+//! trace; otherwise the request starts a new trace. The span's ids are put
+//! on a `tracing` span around the handler, so log lines carry them, and
+//! returned in a `traceparent` response header.
+//!
+//! While export is enabled, sampled spans are batched and sent as OTLP/HTTP
+//! JSON to the configured collector. `GET /admin/tracing` shows the exporter
+//! settings and counters and `PUT /admin/tracing` changes them, so traces
+//! can be turned on during an incident without a restart. The initial
+//! settings come from the `observability.otel_*` configuration.
+//!
+//! Sampling follows the caller: a `traceparent` with the sampled flag set
+//! is always exported and one without it never is. Requests without trace
+//! context are sampled at `sampling_ratio`.
+//!
+//! A generation request's `metadata` tags become `inferno.metadata.<key>`
+//! span attributes.
+
+use crate::{
+    api::{
+        admin::authorize_admin, cancellation::REQUEST_ID_HEADER, queue::tenant_from_headers,
+        usage::UsageMetadata,
+    },
+    cli::serve::ServerState,
+    observability::ObservabilityConfig,
+};
+use axum::{
+    Json,
+    extract::{MatchedPath, Request, State},
+    http::{HeaderMap, HeaderValue, StatusCode},
+    middleware::Next,
+    response::{IntoResponse, Response},
+};
+use chrono::{DateTime, Utc};
+use serde::{Deserialize, Serialize};
+use serde_json::{Value, json};
+use std::{
+    collections::BTreeMap,
+    sync::{
+        Arc, Mutex, RwLock,
+        atomic::{AtomicU64, Ordering},
+    },
+    time::{Duration, SystemTime, UNIX_EPOCH},
+};
+use tokio::sync::mpsc;
+use tracing::{Instrument, info, info_span, warn};
+
+/// W3C trace context headers
+pub const TRACEPARENT_HEADER: &str = "traceparent";
+pub const TRACESTATE_HEADER: &str = "tracestate";
+
+/// Spans waiting for export before new ones are dropped
+const EXPORT_QUEUE_CAPACITY: usize = 4096;
+
+/// Most spans sent in one export request
+const MAX_EXPORT_BATCH: usize = 512;
+
+/// How often a partial batch is sent
+const EXPORT_INTERVAL: Duration = Duration::from_secs(5);
+
+/// How long a collector may take to accept a batch
+const EXPORT_TIMEOUT: Duration = Duration::from_secs(10);
+
+/// OTLP/HTTP path spans are posted to
+const TRACES_PATH: &str = "/v1/traces";
+
+/// Exporter settings
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct TraceExportConfig {
+    pub enabled: bool,
+    /// OTLP/HTTP collector, such as `http://otel-collector:4318`;
+    /// `/v1/traces` is appended unless the URL already ends with it
+    pub endpoint: String,
+    pub service_name: String,
+    /// Fraction of requests without trace context that are exported
+    pub sampling_ratio: f64,
+}
+
+impl From<&ObservabilityConfig> for TraceExportConfig {
+    fn from(config: &ObservabilityConfig) -> Self {
+        Self {
+            enabled: config.otel_enabled,
+            endpoint: config.otel_endpoint.clone(),
+            service_name: config.otel_service_name.clone(),
+            sampling_ratio: config.otel_sampling_ratio.clamp(0.0, 1.0),
+        }
+    }
+}
+
+impl TraceExportConfig {
+    fn validate(&self) -> Result<(), (String, &'static str)> {
+        if !(0.0..=1.0).contains(&self.sampling_ratio) {
+            return Err((
+                "sampling_ratio must be between 0.0 and 1.0".to_string(),
+                "sampling_ratio",
+            ));
+        }
+        if self.service_name.trim().is_empty() {
+            return Err(("service_name must not be empty".to_string(), "service_name"));
+        }
+        match reqwest::Url::parse(&self.endpoint) {
+            Ok(url) if matches!(url.scheme(), "http" | "https") => Ok(()),
+            _ => Err((
+                format!("endpoint '{}' is not an http or https URL", self.endpoint),
+                "endpoint",
+            )),
+        }
+    }
+
+    fn traces_url(&self) -> String {
+        let endpoint = self.endpoint.trim_end_matches('/');
+        if endpoint.ends_with(TRACES_PATH) {
+            endpoint.to_string()
+        } else {
+            format!("{}{}", endpoint, TRACES_PATH)
+        }
+    }
+}
+
+/// Partial update; omitted fields keep their current value
+#[derive(Debug, Clone, Default, Deserialize)]
+pub struct TraceExportUpdate {
+    pub enabled: Option<bool>,
+    pub endpoint: Option<String>,
+    pub service_name: Option<String>,
+    pub sampling_ratio: Option<f64>,
+}
+
+impl TraceExportUpdate {
+    fn apply(self, mut config: TraceExportConfig) -> TraceExportConfig {
+        if let Some(enabled) = self.enabled {
+            config.enabled = enabled;
+        }
+        if let Some(endpoint) = self.endpoint {
+            config.endpoint = endpoint;
+        }
+        if let Some(service_name) = self.service_name {
+            config.service_name = service_name;
+        }
+        if let Some(ratio) = self.sampling_ratio {
+            config.sampling_ratio = ratio;
+        }
+        config
+    }
+}
+
+/// Trace context of the request being served
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct TraceContext {
+    /// 32 lowercase hex digits
+    pub trace_id: String,
+    /// This server's span, 16 lowercase hex digits
+    pub span_id: String,
+    /// The caller's span, when the request carried a `traceparent`
+    pub parent_span_id: Option<String>,
+    pub sampled: bool,
+    pub tracestate: Option<String>,
+}
+
+impl TraceContext {
+    /// The `traceparent` value naming this server's span
+    pub fn traceparent(&self) -> String {
+        format!(
+            "00-{}-{}-{}",
+            self.trace_id,
+            self.span_id,
+            if self.sampled { "01" } else { "00" }
+        )
+    }
+}
+
+fn is_lower_hex(value: &str, len: usize) -> bool {
+    value.len() == len
+        && value
+            .bytes()
+            .all(|b| b.is_ascii_digit() || (b'a'..=b'f').contains(&b))
+}
+
+/// Trace id, parent span id and sampled flag of a W3C `traceparent`
+fn parse_traceparent(value: &str) -> Option<(String, String, bool)> {
+    let mut parts = value.trim().split('-');
+    let version = parts.next()?;
+    let trace_id = parts.next()?;
+    let parent_id = parts.next()?;
+    let flags = parts.next()?;
+
+    // Later versions may append fields; version 00 has exactly four
+    if !is_lower_hex(version, 2) || version == "ff" || (version == "00" && parts.next().is_some()) {
+        return None;
+    }
+    if !is_lower_hex(trace_id, 32) || trace_id.bytes().all(|b| b == b'0') {
+        return None;
+    }
+    if !is_lower_hex(parent_id, 16) || parent_id.bytes().all(|b| b == b'0') {
+        return None;
+    }
+    if !is_lower_hex(flags, 2) {
+        return None;
+    }
+    let sampled = u8::from_str_radix(flags, 16).ok()? & 0x01 == 1;
+    Some((trace_id.to_string(), parent_id.to_string(), sampled))
+}
+
+fn new_span_id() -> String {
+    // Zero is invalid, so draw again in the unlikely case
+    loop {
+        let id: u64 = rand::random();
+        if id != 0 {
+            return format!("{:016x}", id);
+        }
+    }
+}
+
+fn new_trace_id() -> String {
+    loop {
+        let id: u128 = rand::random();
+        if id != 0 {
+            return format!("{:032x}", id);
+        }
+    }
+}
+
+/// The request's trace context: continuing the caller's trace when it sent
+/// a valid `traceparent`, otherwise a new trace sampled at `sampling_ratio`
+fn trace_context(headers: &HeaderMap, sampling_ratio: f64) -> TraceContext {
+    let header = |name: &str| {
+        headers
+            .get(name)
+            .and_then(|v| v.to_str().ok())
+            .map(str::to_string)
+    };
+
+    match header(TRACEPARENT_HEADER).and_then(|value| parse_traceparent(&value)) {
+        Some((trace_id, parent_span_id, sampled)) => TraceContext {
+            trace_id,
+            span_id: new_span_id(),
+            parent_span_id: Some(parent_span_id),
+            sampled,
+            tracestate: header(TRACESTATE_HEADER),
+        },
+        None => TraceContext {
+            trace_id: new_trace_id(),
+            span_id: new_span_id(),
+            parent_span_id: None,
+            sampled: rand::random::<f64>() < sampling_ratio,
+            tracestate: None,
+        },
+    }
+}
+
+/// A finished server span waiting for export
+#[derive(Debug, Clone)]
+struct SpanRecord {
+    context: TraceContext,
+    name: String,
+    start: SystemTime,
+    end: SystemTime,
+    attributes: Vec<(&'static str, Value)>,
+    /// The request's metadata tags, exported as `inferno.metadata.<key>`
+    metadata: BTreeMap<String, String>,
+    status: u16,
+}
+
+fn unix_nanos(time: SystemTime) -> String {
+    time.duration_since(UNIX_EPOCH)
+        .unwrap_or_default()
+        .as_nanos()
+        .to_string()
+}
+
+fn otlp_value(value: &Value) -> Value {
+    match value {
+        Value::Bool(b) => json!({ "boolValue": b }),
+        Value::Number(n) if n.is_i64() || n.is_u64() => json!({ "intValue": n.to_string() }),
+        Value::Number(n) => json!({ "doubleValue": n }),
+        Value::String(s) => json!({ "stringValue": s }),
+        other => json!({ "stringValue": other.to_string() }),
+    }
+}
+
+impl SpanRecord {
+    fn to_otlp(&self) -> Value {
+        let attributes: Vec<Value> = self
+            .attributes
+            .iter()
+            .map(|(key, value)| json!({ "key": key, "value": otlp_value(value) }))
+            .chain(self.metadata.iter().map(|(key, value)| {
+                json!({
+                    "key": format!("inferno.metadata.{}", key),
+                    "value": { "stringValue": value }
+                })
+            }))
+            .collect();
+        // OTLP status codes: 0 unset, 2 error; client errors are not the
+        // server's failure
+        let status = if self.status >= 500 {
+            json!({ "code": 2, "message": format!("HTTP {}", self.status) })
+        } else {
+            json!({ "code": 0 })
+        };
+
+        let mut span = json!({
+            "traceId": self.context.trace_id,
+            "spanId": self.context.span_id,
+            "name": self.name,
+            // SPAN_KIND_SERVER
+            "kind": 2,
+            "startTimeUnixNano": unix_nanos(self.start),
+            "endTimeUnixNano": unix_nanos(self.end),
+            "attributes": attributes,
+            "status": status,
+        });
+        if let Some(parent) = &self.context.parent_span_id {
+            span["parentSpanId"] = json!(parent);
+        }
+        if let Some(tracestate) = &self.context.tracestate {
+            span["traceState"] = json!(tracestate);
+        }
+        span
+    }
+}
+
+/// OTLP/HTTP JSON body for a batch of spans
+fn export_request(service_name: &str, spans: &[SpanRecord]) -> Value {
+    json!({
+        "resourceSpans": [{
+            "resource": {
+                "attributes": [
+                    { "key": "service.name", "value": { "stringValue": service_name } },
+                    { "key": "service.version", "value": { "stringValue": env!("CARGO_PKG_VERSION") } }
+                ]
+            },
+            "scopeSpans": [{
+                "scope": { "name": "inferno", "version": env!("CARGO_PKG_VERSION") },
+                "spans": spans.iter().map(SpanRecord::to_otlp).collect::<Vec<_>>()
+            }]
+        }]
+    })
+}
+
+/// Exporter counters reported by `GET /admin/tracing`
+#[derive(Debug, Clone, Default, Serialize)]
+pub struct TraceExportStats {
+    pub spans_exported: u64,
+    /// Spans dropped because the export queue was full
+    pub spans_dropped: u64,
+    pub export_failures: u64,
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub last_export_at: Option<DateTime<Utc>>,
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub last_error: Option<String>,
+}
+
+#[derive(Debug, Serialize)]
+pub struct TraceExportStatus {
+    pub object: &'static str,
+    pub config: TraceExportConfig,
+    pub stats: TraceExportStats,
+}
+
+/// Exporter settings, counters and the queue of spans to send
+#[derive(Debug)]
+pub struct TraceExporter {
+    config: RwLock<TraceExportConfig>,
+    sender: mpsc::Sender<SpanRecord>,
+    /// Taken by the export task when it starts
+    receiver: Mutex<Option<mpsc::Receiver<SpanRecord>>>,
+    exported: AtomicU64,
+    dropped: AtomicU64,
+    failures: AtomicU64,
+    last_export: Mutex<(Option<DateTime<Utc>>, Option<String>)>,
+    client: reqwest::Client,
+}
+
+impl TraceExporter {
+    pub fn new(config: TraceExportConfig) -> Self {
+        let (sender, receiver) = mpsc::channel(EXPORT_QUEUE_CAPACITY);
+        let client = reqwest::Client::builder()
+            .user_agent("inferno/1.0")
+            .timeout(EXPORT_TIMEOUT)
+            .build()
+            .unwrap_or_default();
+        Self {
+            config: RwLock::new(config),
+            sender,
+            receiver: Mutex::new(Some(receiver)),
+            exported: AtomicU64::new(0),
+            dropped: AtomicU64::new(0),
+            failures: AtomicU64::new(0),
+            last_export: Mutex::new((None, None)),
+            client,
+        }
+    }
+
+    pub fn config(&self) -> TraceExportConfig {
+        self.config.read().unwrap().clone()
+    }
+
+    fn set_config(&self, config: TraceExportConfig) {
+        *self.config.write().unwrap() = config;
+    }
+
+    pub fn stats(&self) -> TraceExportStats {
+        let (last_export_at, last_error) = self.last_export.lock().unwrap().clone();
+        TraceExportStats {
+            spans_exported: self.exported.load(Ordering::Relaxed),
+            spans_dropped: self.dropped.load(Ordering::Relaxed),
+            export_failures: self.failures.load(Ordering::Relaxed),
+            last_export_at,
+            last_error,
+        }
+    }
+
+    fn status(&self) -> TraceExportStatus {
+        TraceExportStatus {
+            object: "tracing.exporter",
+            config: self.config(),
+            stats: self.stats(),
+        }
+    }
+
+    fn enqueue(&self, span: SpanRecord) {
+        if self.sender.try_send(span).is_err() {
+            self.dropped.fetch_add(1, Ordering::Relaxed);
+        }
+    }
+
+    async fn export(&self, spans: &[SpanRecord]) {
+        let config = self.config();
+        let result = self
+            .client
+            .post(config.traces_url())
+            .json(&export_request(&config.service_name, spans))
+            .send()
+            .await
+            .and_then(|response| response.error_for_status());
+
+        let mut last_export = self.last_export.lock().unwrap();
+        match result {
+            Ok(_) => {
+                self.exported
+                    .fetch_add(spans.len() as u64, Ordering::Relaxed);
+                last_export.0 = Some(Utc::now());
+            }
+            Err(e) => {
+                self.failures.fetch_add(1, Ordering::Relaxed);
+                last_export.1 = Some(e.to_string());
+                warn!("Trace export to {} failed: {}", config.traces_url(), e);
+            }
+        }
+    }
+}
+
+/// Background loop sending queued spans in batches for the lifetime of the
+/// server
+pub async fn run_exporter(state: Arc<ServerState>) {
+    let Some(mut receiver) = state.trace_exporter.receiver.lock().unwrap().take() else {
+        return;
+    };
+
+    let mut batch = Vec::with_capacity(MAX_EXPORT_BATCH);
+    let mut interval = tokio::time::interval(EXPORT_INTERVAL);
+    loop {
+        tokio::select! {
+            span = receiver.recv() => match span {
+                Some(span) => {
+                    batch.push(span);
+                    if batch.len() < MAX_EXPORT_BATCH {
+                        continue;
+                    }
+                }
+                None => break,
+            },
+            _ = interval.tick() => {}
+        }
+        if batch.is_empty() {
+            continue;
+        }
+        // Spans queued before export was turned off are discarded
+        if state.trace_exporter.config().enabled {
+            state.trace_exporter.export(&batch).await;
+        }
+        batch.clear();
+    }
+}
+
+/// Middleware giving each request a server span in the caller's trace,
+/// recording it for export when sampled
+pub async fn propagate_context(
+    State(state): State<Arc<ServerState>>,
+    mut request: Request,
+    next: Next,
+) -> Response {
+    let config = state.trace_exporter.config();
+    let context = trace_context(request.headers(), config.sampling_ratio);
+    let record = config.enabled && context.sampled;
+
+    let method = request.method().to_string();
+    let route = request
+        .extensions()
+        .get::<MatchedPath>()
+        .map(|path| path.as_str().to_string())
+        .unwrap_or_else(|| request.uri().path().to_string());
+    let path = request.uri().path().to_string();
+    let tenant = tenant_from_headers(request.headers());
+
+    let span = info_span!(
+        "request",
+        trace_id = %context.trace_id,
+        span_id = %context.span_id,
+    );
+    let start = SystemTime::now();
+    request.extensions_mut().insert(context.clone());
+    let mut response = next.run(request).instrument(span).await;
+
+    if let Ok(value) = HeaderValue::from_str(&context.traceparent()) {
+        response.headers_mut().insert(TRACEPARENT_HEADER, value);
+    }
+
+    if record {
+        let status = response.status().as_u16();
+        let mut attributes = vec![
+            ("http.request.method", json!(method)),
+            ("http.route", json!(route)),
+            ("url.path", json!(path)),
+            ("http.response.status_code", json!(status)),
+        ];
+        if let Some(tenant) = tenant {
+            attributes.push(("inferno.tenant", json!(tenant)));
+        }
+        if let Some(id) = response
+            .headers()
+            .get(REQUEST_ID_HEADER)
+            .and_then(|v| v.to_str().ok())
+        {
+            attributes.push(("inferno.request_id", json!(id)));
+        }
+        state.trace_exporter.enqueue(SpanRecord {
+            name: format!("{} {}", method, route),
+            context,
+            start,
+            end: SystemTime::now(),
+            attributes,
+            metadata: response
+                .extensions()
+                .get::<UsageMetadata>()
+                .map(|metadata| metadata.0.clone())
+                .unwrap_or_default(),
+            status,
+        });
+    }
+    response
+}
+
+// API Handlers
+
+/// `GET /admin/tracing` - exporter settings and counters (admin only)
+pub async fn get_tracing(State(state): State<Arc<ServerState>>, headers: HeaderMap) -> Response {
+    if let Err(response) = authorize_admin(&headers) {
+        return response;
+    }
+    Json(state.trace_exporter.status()).into_response()
+}
+
+/// `PUT /admin/tracing` - change the exporter target or sampling at runtime
+/// (admin only)
+pub async fn update_tracing(
+    State(state): State<Arc<ServerState>>,
+    headers: HeaderMap,
+    Json(update): Json<TraceExportUpdate>,
+) -> Response {
+    if let Err(response) = authorize_admin(&headers) {
+        return response;
+    }
+
+    let config = update.apply(state.trace_exporter.config());
+    if let Err((message, param)) = config.validate() {
+        return (
+            StatusCode::BAD_REQUEST,
+            Json(json!({
+                "error": {
+                    "message": message,
+                    "type": "invalid_request_error",
+                    "param": param,
+                    "code": null
+                }
+            })),
+        )
+            .into_response();
+    }
+
+    info!(
+        "Trace export {} to {} at sampling ratio {}",
+        if config.enabled {
+            "enabled"
+        } else {
+            "disabled"
+        },
+        config.traces_url(),
+        config.sampling_ratio
+    );
+    state.trace_exporter.set_config(config);
+    Json(state.trace_exporter.status()).into_response()
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_parse_traceparent() {
+        let parsed =
+            parse_traceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01").unwrap();
+        assert_eq!(parsed.0, "4bf92f3577b34da6a3ce929d0e0e4736");
+        assert_eq!(parsed.1, "00f067aa0ba902b7");
+        assert!(parsed.2);
+
+        let unsampled =
+            parse_traceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00").unwrap();
+        assert!(!unsampled.2);
+
+        // Zero ids, uppercase hex, version ff and trailing fields on 00
+        assert!(
+            parse_traceparent("00-00000000000000000000000000000000-00f067aa0ba902b7-01").is_none()
+        );
+        assert!(
+            parse_traceparent("00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01").is_none()
+        );
+        assert!(
+            parse_traceparent("ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01").is_none()
+        );
+        assert!(
+            parse_traceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-x")
+                .is_none()
+        );
+    }
+
+    #[test]
+    fn test_context_continues_caller_trace() {
+        let mut headers = HeaderMap::new();
+        headers.insert(
+            TRACEPARENT_HEADER,
+            HeaderValue::from_static("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"),
+        );
+        // A sampled parent is exported even at a zero ratio
+        let context = trace_context(&headers, 0.0);
+        assert_eq!(context.trace_id, "4bf92f3577b34da6a3ce929d0e0e4736");
+        assert_eq!(context.parent_span_id.as_deref(), Some("00f067aa0ba902b7"));
+        assert_ne!(context.span_id, "00f067aa0ba902b7");
+        assert!(context.sampled);
+        assert!(
+            context
+                .traceparent()
+                .starts_with("00-4bf92f3577b34da6a3ce929d0e0e4736-")
+        );
+
+        let fresh = trace_context(&HeaderMap::new(), 1.0);
+        assert!(fresh.parent_span_id.is_none());
+        assert!(fresh.sampled);
+        assert!(is_lower_hex(&fresh.trace_id, 32));
+    }
+
+    #[test]
+    fn test_traces_url_and_validation() {
+        let mut config = TraceExportConfig::from(&ObservabilityConfig::default());
+        config.endpoint = "http://collector:4318/".to_string();
+        assert_eq!(config.traces_url(), "http://collector:4318/v1/traces");
+        config.endpoint = "http://collector:4318/v1/traces".to_string();
+        assert_eq!(config.traces_url(), "http://collector:4318/v1/traces");
+
+        config.sampling_ratio = 1.5;
+        assert!(config.validate().is_err());
+        config.sampling_ratio = 0.25;
+        config.endpoint = "collector:4318".to_string();
+        assert!(config.validate().is_err());
+    }
+}
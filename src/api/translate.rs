@@ -0,0 +1,399 @@
+//! Translation
+//!
+//! `POST /v1/translate` translates one text or a batch of them between
+//! languages, with an optional register (formal or informal) and a glossary
+//! of terms that must be rendered a fixed way. Texts longer than a chunk are
+//! split on paragraph and sentence boundaries, the chunks are translated a
+//! few at a time and reassembled with the original separators, so layout
+//! survives and no text has to fit the context window.
+
+use crate::{
+    api::{
+        cancellation::{FinishReason, generate_cancellable, with_request_id},
+        deadline::resolve_deadline,
+        invalid_request,
+        openai::{StringOrArray, get_or_load_backend},
+        queue::priority_from_headers,
+        summarize::{chunk_ranges, measure_tokens},
+    },
+    backends::InferenceParams,
+    cli::serve::ServerState,
+};
+use axum::{
+    Json,
+    extract::State,
+    http::{HeaderMap, StatusCode},
+    response::{IntoResponse, Response},
+};
+use futures::{StreamExt, stream};
+use serde::{Deserialize, Serialize};
+use serde_json::json;
+use std::{collections::BTreeMap, ops::Range, sync::Arc};
+
+/// Chunks translated at the same time, across the whole batch
+const TRANSLATE_CONCURRENCY: usize = 4;
+
+/// Most texts one request may carry
+const MAX_TRANSLATE_INPUTS: usize = 64;
+
+/// Most chunks one request may be split into, across the whole batch
+const MAX_TRANSLATE_CHUNKS: usize = 512;
+
+/// Most glossary entries one request may carry
+const MAX_GLOSSARY_TERMS: usize = 200;
+
+/// Smallest chunk budget that keeps sentences together
+const MIN_CHUNK_TOKENS: u32 = 64;
+
+/// Register the translation is written in
+#[derive(Debug, Clone, Copy, Default, PartialEq, Eq, Serialize, Deserialize)]
+#[serde(rename_all = "lowercase")]
+pub enum Formality {
+    /// Whatever the source's register suggests
+    #[default]
+    Default,
+    Formal,
+    Informal,
+}
+
+impl Formality {
+    fn instruction(&self) -> &'static str {
+        match self {
+            Formality::Default => "",
+            Formality::Formal => " Use a formal register, including formal forms of address.",
+            Formality::Informal => " Use an informal, familiar register.",
+        }
+    }
+}
+
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct TranslateRequest {
+    pub model: String,
+    /// One text or a batch of texts, translated independently
+    pub text: StringOrArray,
+    /// Language of the source; detected by the model when omitted
+    #[serde(default)]
+    pub source_language: Option<String>,
+    pub target_language: String,
+    #[serde(default)]
+    pub formality: Formality,
+    /// Source terms and the exact translation each must be given
+    #[serde(default)]
+    pub glossary: BTreeMap<String, String>,
+    /// Tokens per chunk; defaults to a third of the context window, leaving
+    /// room for the prompt and the translation
+    #[serde(default)]
+    pub chunk_tokens: Option<u32>,
+    /// Server-enforced time budget for the whole batch, in milliseconds
+    #[serde(default)]
+    pub timeout_ms: Option<u64>,
+}
+
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct Translation {
+    pub index: usize,
+    pub translation: String,
+    /// Chunks the text was split into; 1 when it fit in one
+    pub chunks: usize,
+}
+
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct TranslateResponse {
+    pub object: String,
+    pub model: String,
+    pub source_language: Option<String>,
+    pub target_language: String,
+    pub data: Vec<Translation>,
+}
+
+/// A chunk of one input text awaiting translation
+struct Chunk<'a> {
+    input: usize,
+    range: Range<usize>,
+    text: &'a str,
+}
+
+impl TranslateRequest {
+    fn validate(&self, inputs: &[String]) -> Result<(), (String, &'static str)> {
+        if inputs.is_empty() || inputs.len() > MAX_TRANSLATE_INPUTS {
+            return Err((
+                format!("text must hold 1 to {} texts", MAX_TRANSLATE_INPUTS),
+                "text",
+            ));
+        }
+        if inputs.iter().any(|text| text.trim().is_empty()) {
+            return Err(("Texts must not be empty".to_string(), "text"));
+        }
+        if self.target_language.trim().is_empty() {
+            return Err((
+                "target_language must not be empty".to_string(),
+                "target_language",
+            ));
+        }
+        if self.glossary.len() > MAX_GLOSSARY_TERMS {
+            return Err((
+                format!("At most {} glossary terms are allowed", MAX_GLOSSARY_TERMS),
+                "glossary",
+            ));
+        }
+        Ok(())
+    }
+
+    fn prompt(&self, chunk: &str) -> String {
+        let source = self
+            .source_language
+            .as_deref()
+            .unwrap_or("its original language");
+        let mut prompt = format!(
+            "Translate the text between the triple quotes from {} into {}.{} Reply with \
+             the translation only, keeping line breaks, formatting, names and numbers.\n",
+            source,
+            self.target_language,
+            self.formality.instruction()
+        );
+
+        // Only the terms this chunk uses, to keep prompts short
+        let lowered = chunk.to_lowercase();
+        let terms: Vec<_> = self
+            .glossary
+            .iter()
+            .filter(|(term, _)| lowered.contains(&term.to_lowercase()))
+            .collect();
+        if !terms.is_empty() {
+            prompt.push_str("Always translate these terms exactly as given:\n");
+            for (term, translation) in terms {
+                prompt.push_str(&format!("- {} => {}\n", term, translation));
+            }
+        }
+
+        prompt.push_str(&format!("\n\"\"\"\n{}\n\"\"\"\n\nTranslation:", chunk));
+        prompt
+    }
+}
+
+/// Rebuild `text` with each chunk replaced by its translation, keeping the
+/// whitespace between chunks as it was
+fn reassemble(text: &str, ranges: &[Range<usize>], translations: &[String]) -> String {
+    let mut out = String::with_capacity(text.len());
+    let mut end = 0;
+    for (range, translation) in ranges.iter().zip(translations) {
+        out.push_str(&text[end..range.start]);
+        out.push_str(translation);
+        end = range.end;
+    }
+    out.push_str(&text[end..]);
+    out
+}
+
+/// Strip the quotes models sometimes echo around their translation
+fn clean_translation(output: &str) -> String {
+    let trimmed = output.trim();
+    trimmed
+        .strip_prefix("\"\"\"")
+        .and_then(|rest| rest.strip_suffix("\"\"\""))
+        .unwrap_or(trimmed)
+        .trim()
+        .to_string()
+}
+
+fn translation_error(status: StatusCode, message: String, code: &str) -> Response {
+    (
+        status,
+        Json(json!({
+            "error": {
+                "message": message,
+                "type": "server_error",
+                "param": null,
+                "code": code
+            }
+        })),
+    )
+        .into_response()
+}
+
+// API Handlers
+
+/// `POST /v1/translate` - translate one text or a batch, chunking long ones
+pub async fn translate(
+    State(state): State<Arc<ServerState>>,
+    headers: HeaderMap,
+    Json(request): Json<TranslateRequest>,
+) -> Response {
+    if let Err(response) = state.flags.require("translate", &headers).await {
+        return response;
+    }
+    let inputs = match &request.text {
+        StringOrArray::String(text) => vec![text.clone()],
+        StringOrArray::Array(texts) => texts.clone(),
+    };
+    if let Err((message, param)) = request.validate(&inputs) {
+        return invalid_request(message, Some(param), None);
+    }
+    let context_size = state.config.backend_config.context_size;
+    let chunk_tokens = request.chunk_tokens.unwrap_or(context_size / 3);
+    if chunk_tokens < MIN_CHUNK_TOKENS || chunk_tokens >= context_size / 2 {
+        return invalid_request(
+            format!(
+                "chunk_tokens must be at least {} and below half the context window of {}",
+                MIN_CHUNK_TOKENS, context_size
+            ),
+            Some("chunk_tokens"),
+            None,
+        );
+    }
+
+    let ticket = match state.request_queue.enqueue_request(
+        &headers,
+        &request.model,
+        priority_from_headers(&headers),
+    ) {
+        Ok(ticket) => ticket
+            .with_scheduling(None, &headers)
+            .with_deadline(resolve_deadline(request.timeout_ms, None)),
+        Err(response) => return response,
+    };
+    let request_id = ticket.id().to_string();
+
+    let backend = match get_or_load_backend(&state, &request.model).await {
+        Ok(backend) => backend,
+        Err(e) => {
+            return invalid_request(format!("Failed to load model: {}", e), Some("model"), None);
+        }
+    };
+
+    let mut chunks = Vec::new();
+    let mut ranges = Vec::with_capacity(inputs.len());
+    for (input, text) in inputs.iter().enumerate() {
+        let (_, bytes_per_token) = measure_tokens(&backend, text).await;
+        let max_chars = (chunk_tokens as f64 * bytes_per_token) as usize;
+        let text_ranges = chunk_ranges(text, max_chars);
+        chunks.extend(text_ranges.iter().map(|range| Chunk {
+            input,
+            range: range.clone(),
+            text: &text[range.clone()],
+        }));
+        ranges.push(text_ranges);
+    }
+    if chunks.len() > MAX_TRANSLATE_CHUNKS {
+        return invalid_request(
+            format!(
+                "The texts split into {} chunks; at most {} are translated per request",
+                chunks.len(),
+                MAX_TRANSLATE_CHUNKS
+            ),
+            Some("text"),
+            Some("document_too_long"),
+        );
+    }
+
+    ticket.start().await;
+    let backend = &backend;
+    let cancel = ticket.cancel_signal();
+    let deadline = ticket.deadline();
+    let results: Vec<_> = stream::iter(&chunks)
+        .map(|chunk| {
+            let prompt = request.prompt(chunk.text);
+            let params = InferenceParams {
+                // Translations run longer than their source in many languages
+                max_tokens: chunk_tokens * 2 + 64,
+                temperature: 0.1,
+                ..Default::default()
+            };
+            async move { generate_cancellable(backend, &prompt, &params, cancel, deadline).await }
+        })
+        .buffered(TRANSLATE_CONCURRENCY)
+        .collect()
+        .await;
+
+    let mut translated: Vec<Vec<String>> = vec![Vec::new(); inputs.len()];
+    for (chunk, result) in chunks.iter().zip(results) {
+        let generation = match result {
+            Ok(generation) => generation,
+            Err(e) => {
+                return translation_error(
+                    StatusCode::INTERNAL_SERVER_ERROR,
+                    format!("Inference failed: {}", e),
+                    "inference_failed",
+                );
+            }
+        };
+        if generation.finish_reason != FinishReason::Stop {
+            let reason = generation.finish_reason.as_str();
+            return translation_error(
+                StatusCode::REQUEST_TIMEOUT,
+                format!(
+                    "Translation stopped early in text {} at byte {}: {}",
+                    chunk.input, chunk.range.start, reason
+                ),
+                reason,
+            );
+        }
+        translated[chunk.input].push(clean_translation(&generation.text));
+    }
+
+    let data = inputs
+        .iter()
+        .zip(&ranges)
+        .zip(&translated)
+        .enumerate()
+        .map(|(index, ((text, ranges), translations))| Translation {
+            index,
+            translation: reassemble(text, ranges, translations),
+            chunks: ranges.len(),
+        })
+        .collect();
+
+    let response = Json(TranslateResponse {
+        object: "list".to_string(),
+        model: request.model.clone(),
+        source_language: request.source_language.clone(),
+        target_language: request.target_language.clone(),
+        data,
+    })
+    .into_response();
+
+    with_request_id(response, &request_id)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn request() -> TranslateRequest {
+        serde_json::from_value(json!({
+            "model": "m",
+            "text": "The invoice is due.",
+            "target_language": "German",
+            "formality": "formal",
+            "glossary": { "invoice": "Rechnung", "warranty": "Garantie" }
+        }))
+        .unwrap()
+    }
+
+    #[test]
+    fn test_prompt_lists_only_glossary_terms_in_chunk() {
+        let prompt = request().prompt("The Invoice is due.");
+        assert!(prompt.contains("- invoice => Rechnung"));
+        assert!(!prompt.contains("warranty"));
+        assert!(prompt.contains("formal register"));
+        assert!(prompt.contains("from its original language into German"));
+    }
+
+    #[test]
+    fn test_reassembly_keeps_separators() {
+        let text = "  First part.\n\n\nSecond part.\n";
+        let ranges = chunk_ranges(text, 14);
+        assert_eq!(ranges.len(), 2);
+        let translations = vec!["Erster Teil.".to_string(), "Zweiter Teil.".to_string()];
+        assert_eq!(
+            reassemble(text, &ranges, &translations),
+            "  Erster Teil.\n\n\nZweiter Teil.\n"
+        );
+    }
+
+    #[test]
+    fn test_echoed_quotes_are_stripped() {
+        assert_eq!(clean_translation("\"\"\"\nHallo\n\"\"\"\n"), "Hallo");
+        assert_eq!(clean_translation(" Hallo "), "Hallo");
+    }
+}
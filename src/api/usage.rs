@@ -0,0 +1,662 @@
+//! Usage Attribution
+//!
+//! Generation requests may carry `metadata`, string key/value tags such as
+//! a team, feature or customer id. The [`attribute_usage`] middleware checks
+//! the tags, attaches them to the response for the audit log and the
+//! request's trace span, and records each completed request's token usage
+//! with its tenant, key, model and tags. Asynchronous jobs record theirs
+//! when they finish.
+//!
+//! `GET /usage` totals the recorded usage over a time range, grouped by any
+//! of `model`, `tenant`, `key`, `endpoint` and `metadata.<key>`, and
+//! filtered by the same dimensions. Callers see the usage of the tenant
+//! their API key or JWT belongs to, and a managed key that belongs to no
+//! tenant sees only its own; the admin token sees every tenant's. Records are held in memory, up to
+//! [`RECENT_RECORDS`]; `api::usage_export` writes them out as files.
+
+use crate::{
+    api::{
+        admin::authorize_admin, error_response, queue::tenant_from_headers,
+        scheduler::DEFAULT_TENANT,
+    },
+    cli::serve::ServerState,
+};
+use axum::{
+    Json,
+    body::Body,
+    extract::{Query, Request, State},
+    http::{HeaderMap, StatusCode, header},
+    middleware::Next,
+    response::{IntoResponse, Response},
+};
+use chrono::{DateTime, Utc};
+use futures::StreamExt;
+use serde::{Deserialize, Serialize};
+use serde_json::{Map, Value, json};
+use std::{
+    collections::{BTreeMap, HashMap, VecDeque},
+    sync::{Arc, Mutex},
+};
+
+/// Usage records kept for `/usage`
+pub const RECENT_RECORDS: usize = 50_000;
+
+/// Largest request body read for `metadata`, matching axum's default JSON
+/// body limit
+const MAX_INSPECTED_BODY: usize = 2 * 1024 * 1024;
+
+/// Limits on a request's metadata, as in the OpenAI API
+const MAX_METADATA_PAIRS: usize = 16;
+const MAX_METADATA_KEY_LEN: usize = 64;
+const MAX_METADATA_VALUE_LEN: usize = 512;
+
+/// A request's metadata tags, attached to its response for the audit log
+/// and trace export
+#[derive(Debug, Clone, Default, PartialEq, Serialize, Deserialize)]
+pub struct UsageMetadata(pub BTreeMap<String, String>);
+
+impl UsageMetadata {
+    /// The tags of a request's `metadata` field
+    fn parse(value: Option<&Value>) -> Result<Self, String> {
+        let object = match value {
+            None | Some(Value::Null) => return Ok(Self::default()),
+            Some(Value::Object(object)) => object,
+            Some(_) => return Err("metadata must be an object of strings".to_string()),
+        };
+        if object.len() > MAX_METADATA_PAIRS {
+            return Err(format!(
+                "metadata may have at most {} keys",
+                MAX_METADATA_PAIRS
+            ));
+        }
+
+        let mut tags = BTreeMap::new();
+        for (key, value) in object {
+            if key.is_empty() || key.chars().count() > MAX_METADATA_KEY_LEN {
+                return Err(format!(
+                    "metadata keys must be 1 to {} characters",
+                    MAX_METADATA_KEY_LEN
+                ));
+            }
+            let Value::String(value) = value else {
+                return Err(format!("metadata value of '{}' must be a string", key));
+            };
+            if value.chars().count() > MAX_METADATA_VALUE_LEN {
+                return Err(format!(
+                    "metadata value of '{}' is over {} characters",
+                    key, MAX_METADATA_VALUE_LEN
+                ));
+            }
+            tags.insert(key.clone(), value.clone());
+        }
+        Ok(Self(tags))
+    }
+
+    pub fn is_empty(&self) -> bool {
+        self.0.is_empty()
+    }
+}
+
+/// One completed request's token usage
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct UsageRecord {
+    pub timestamp: DateTime<Utc>,
+    pub endpoint: String,
+    pub model: String,
+    pub tenant: String,
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub key_id: Option<String>,
+    pub prompt_tokens: u64,
+    pub completion_tokens: u64,
+    #[serde(default, skip_serializing_if = "BTreeMap::is_empty")]
+    pub metadata: BTreeMap<String, String>,
+}
+
+impl UsageRecord {
+    /// A record of a request to `endpoint`, attributed from its headers
+    pub fn new(state: &ServerState, headers: &HeaderMap, endpoint: &str, model: &str) -> Self {
+        Self {
+            timestamp: Utc::now(),
+            endpoint: endpoint.to_string(),
+            model: model.to_string(),
+            tenant: tenant_from_headers(headers).unwrap_or_else(|| DEFAULT_TENANT.to_string()),
+            key_id: state.api_keys.scope(headers).map(|key| key.id),
+            prompt_tokens: 0,
+            completion_tokens: 0,
+            metadata: BTreeMap::new(),
+        }
+    }
+
+    /// The record's value of a grouping or filter dimension
+    pub(crate) fn dimension(&self, dimension: &Dimension) -> Option<&str> {
+        match dimension {
+            Dimension::Model => Some(&self.model),
+            Dimension::Tenant => Some(&self.tenant),
+            Dimension::Key => self.key_id.as_deref(),
+            Dimension::Endpoint => Some(&self.endpoint),
+            Dimension::Metadata(key) => self.metadata.get(key).map(String::as_str),
+        }
+    }
+}
+
+/// Recent usage records
+#[derive(Debug, Default)]
+pub struct UsageLog {
+    records: Mutex<VecDeque<UsageRecord>>,
+}
+
+impl UsageLog {
+    pub fn new() -> Self {
+        Self::default()
+    }
+
+    pub fn record(&self, record: UsageRecord) {
+        let mut records = self.records.lock().unwrap();
+        if records.len() == RECENT_RECORDS {
+            records.pop_front();
+        }
+        records.push_back(record);
+    }
+
+    /// The records matching `query`, oldest first
+    pub(crate) fn records(&self, query: &ParsedQuery) -> Vec<UsageRecord> {
+        let records = self.records.lock().unwrap();
+        records
+            .iter()
+            .filter(|record| query.matches(record))
+            .cloned()
+            .collect()
+    }
+
+    /// Totals of the records matching `query`, one per group
+    pub(crate) fn summarize(&self, query: &ParsedQuery) -> Vec<UsageGroup> {
+        let records = self.records.lock().unwrap();
+        let mut groups: HashMap<Vec<Option<String>>, UsageGroup> = HashMap::new();
+        for record in records.iter().filter(|record| query.matches(record)) {
+            let values: Vec<Option<String>> = query
+                .group_by
+                .iter()
+                .map(|dimension| record.dimension(dimension).map(str::to_string))
+                .collect();
+            let group = groups.entry(values.clone()).or_insert_with(|| UsageGroup {
+                object: "usage.group".to_string(),
+                group: query
+                    .group_by
+                    .iter()
+                    .zip(values)
+                    .map(|(dimension, value)| (dimension.name(), json!(value)))
+                    .collect(),
+                ..UsageGroup::default()
+            });
+            group.requests += 1;
+            group.prompt_tokens += record.prompt_tokens;
+            group.completion_tokens += record.completion_tokens;
+            group.total_tokens += record.prompt_tokens + record.completion_tokens;
+        }
+
+        // Largest first, ties in a stable order
+        let mut groups: Vec<_> = groups.into_iter().collect();
+        groups.sort_by(|(a_values, a), (b_values, b)| {
+            b.total_tokens
+                .cmp(&a.total_tokens)
+                .then_with(|| a_values.cmp(b_values))
+        });
+        groups.into_iter().map(|(_, group)| group).collect()
+    }
+}
+
+/// What `/usage` groups and filters by
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub(crate) enum Dimension {
+    Model,
+    Tenant,
+    Key,
+    Endpoint,
+    /// A metadata key; records without it have no value
+    Metadata(String),
+}
+
+impl Dimension {
+    pub(crate) fn parse(name: &str) -> Option<Self> {
+        match name {
+            "model" => Some(Dimension::Model),
+            "tenant" => Some(Dimension::Tenant),
+            "key" => Some(Dimension::Key),
+            "endpoint" => Some(Dimension::Endpoint),
+            _ => name
+                .strip_prefix("metadata.")
+                .filter(|key| !key.is_empty())
+                .map(|key| Dimension::Metadata(key.to_string())),
+        }
+    }
+
+    pub(crate) fn name(&self) -> String {
+        match self {
+            Dimension::Model => "model".to_string(),
+            Dimension::Tenant => "tenant".to_string(),
+            Dimension::Key => "key".to_string(),
+            Dimension::Endpoint => "endpoint".to_string(),
+            Dimension::Metadata(key) => format!("metadata.{}", key),
+        }
+    }
+}
+
+/// Totals of one group of usage records
+#[derive(Debug, Clone, Default, Serialize, Deserialize)]
+pub struct UsageGroup {
+    pub object: String,
+    /// The group's value of each `group_by` dimension; null for records
+    /// without it
+    pub group: Map<String, Value>,
+    pub requests: u64,
+    pub prompt_tokens: u64,
+    pub completion_tokens: u64,
+    pub total_tokens: u64,
+}
+
+/// `/usage` query: `start`, `end`, a comma-separated `group_by`, and any
+/// dimension as an equality filter, such as `metadata.team=search`
+#[derive(Debug, Clone, Default, Deserialize)]
+pub struct UsageQuery {
+    #[serde(default)]
+    pub start: Option<DateTime<Utc>>,
+    #[serde(default)]
+    pub end: Option<DateTime<Utc>>,
+    #[serde(default)]
+    pub group_by: Option<String>,
+    #[serde(flatten)]
+    pub filters: HashMap<String, String>,
+}
+
+#[derive(Debug)]
+pub(crate) struct ParsedQuery {
+    start: Option<DateTime<Utc>>,
+    end: Option<DateTime<Utc>>,
+    group_by: Vec<Dimension>,
+    filters: Vec<(Dimension, String)>,
+}
+
+impl ParsedQuery {
+    pub(crate) fn parse(query: UsageQuery) -> Result<Self, (String, String)> {
+        let invalid = |name: &str, param: &str| {
+            (
+                format!(
+                    "Unknown usage dimension '{}'; use model, tenant, key, endpoint or metadata.<key>",
+                    name
+                ),
+                param.to_string(),
+            )
+        };
+
+        let mut group_by = Vec::new();
+        for name in query
+            .group_by
+            .iter()
+            .flat_map(|names| names.split(','))
+            .map(str::trim)
+            .filter(|name| !name.is_empty())
+        {
+            let dimension = Dimension::parse(name).ok_or_else(|| invalid(name, "group_by"))?;
+            if !group_by.contains(&dimension) {
+                group_by.push(dimension);
+            }
+        }
+
+        let mut filters = Vec::new();
+        for (name, value) in query.filters {
+            let dimension = Dimension::parse(&name).ok_or_else(|| invalid(&name, &name))?;
+            filters.push((dimension, value));
+        }
+
+        Ok(Self {
+            start: query.start,
+            end: query.end,
+            group_by,
+            filters,
+        })
+    }
+
+    /// Limit the query to what the caller may see unless the caller has
+    /// the admin token: the tenant its credential belongs to, as set in the
+    /// header by `authenticate_tenant`, and for a managed key without a
+    /// tenant, that key's own usage
+    pub(crate) fn scope_to_caller(&mut self, state: &ServerState, headers: &HeaderMap) {
+        if authorize_admin(headers).is_ok() {
+            return;
+        }
+        let tenant = tenant_from_headers(headers).unwrap_or_else(|| DEFAULT_TENANT.to_string());
+        let key = state
+            .api_keys
+            .scope(headers)
+            .filter(|key| key.tenant.is_none())
+            .map(|key| key.id);
+        self.limit_to(tenant, key);
+    }
+
+    /// Replace any tenant filter with `tenant`, and any key filter with
+    /// `key` when one is given
+    fn limit_to(&mut self, tenant: String, key: Option<String>) {
+        self.filters
+            .retain(|(dimension, _)| *dimension != Dimension::Tenant);
+        self.filters.push((Dimension::Tenant, tenant));
+        if let Some(key) = key {
+            self.filters
+                .retain(|(dimension, _)| *dimension != Dimension::Key);
+            self.filters.push((Dimension::Key, key));
+        }
+    }
+
+    /// The tenant the query is limited to, if any
+    pub(crate) fn tenant(&self) -> Option<&str> {
+        self.filter(&Dimension::Tenant)
+    }
+
+    /// The managed key the query is limited to, if any
+    pub(crate) fn key(&self) -> Option<&str> {
+        self.filter(&Dimension::Key)
+    }
+
+    fn filter(&self, dimension: &Dimension) -> Option<&str> {
+        self.filters
+            .iter()
+            .find(|(filtered, _)| filtered == dimension)
+            .map(|(_, value)| value.as_str())
+    }
+
+    fn matches(&self, record: &UsageRecord) -> bool {
+        self.start.is_none_or(|start| record.timestamp >= start)
+            && self.end.is_none_or(|end| record.timestamp < end)
+            && self
+                .filters
+                .iter()
+                .all(|(dimension, value)| record.dimension(dimension) == Some(value.as_str()))
+    }
+}
+
+/// Token counts from a response's `usage` object, OpenAI or Anthropic style
+#[derive(Debug, Default, Clone, Copy)]
+struct Tokens {
+    prompt: u64,
+    completion: u64,
+}
+
+impl Tokens {
+    /// Take the larger counts of `usage`, as streams report them in parts
+    fn merge(&mut self, usage: &Value) {
+        let count = |names: [&str; 2]| {
+            names
+                .iter()
+                .find_map(|name| usage.get(*name).and_then(Value::as_u64))
+                .unwrap_or(0)
+        };
+        self.prompt = self.prompt.max(count(["prompt_tokens", "input_tokens"]));
+        self.completion = self
+            .completion
+            .max(count(["completion_tokens", "output_tokens"]));
+    }
+
+    /// Merge the `usage` of a JSON body or event, at its top level or in an
+    /// Anthropic `message`
+    fn merge_body(&mut self, body: &Value) {
+        for usage in [
+            body.get("usage"),
+            body.get("message").and_then(|m| m.get("usage")),
+        ]
+        .into_iter()
+        .flatten()
+        {
+            self.merge(usage);
+        }
+    }
+}
+
+/// The fields of a generation request usage is attributed by
+#[derive(Debug, Default, Deserialize)]
+struct RequestSummary {
+    #[serde(default)]
+    model: Option<String>,
+    #[serde(default)]
+    metadata: Option<Value>,
+}
+
+/// Middleware checking a generation request's `metadata`, attaching it to
+/// the response and recording the request's token usage when it completes
+pub async fn attribute_usage(
+    State(state): State<Arc<ServerState>>,
+    request: Request,
+    next: Next,
+) -> Response {
+    let (parts, body) = request.into_parts();
+    let bytes = match axum::body::to_bytes(body, MAX_INSPECTED_BODY).await {
+        Ok(bytes) => bytes,
+        Err(_) => {
+            return error_response(
+                StatusCode::PAYLOAD_TOO_LARGE,
+                "Request body is too large".to_string(),
+                Some("body"),
+                Some("body_too_large"),
+            );
+        }
+    };
+    let summary: RequestSummary = serde_json::from_slice(&bytes).unwrap_or_default();
+    let metadata = match UsageMetadata::parse(summary.metadata.as_ref()) {
+        Ok(metadata) => metadata,
+        Err(message) => {
+            return error_response(
+                StatusCode::BAD_REQUEST,
+                message,
+                Some("metadata"),
+                Some("invalid_metadata"),
+            );
+        }
+    };
+
+    let mut record = UsageRecord::new(
+        &state,
+        &parts.headers,
+        parts.uri.path(),
+        summary.model.as_deref().unwrap_or_default(),
+    );
+    record.metadata = metadata.0.clone();
+    let request = Request::from_parts(parts, Body::from(bytes));
+
+    let mut response = next.run(request).await;
+    if !metadata.is_empty() {
+        response.extensions_mut().insert(metadata);
+    }
+    // Accepted async jobs record their usage when they finish
+    if response.status() != StatusCode::OK {
+        return response;
+    }
+
+    let is_stream = response
+        .headers()
+        .get(header::CONTENT_TYPE)
+        .and_then(|v| v.to_str().ok())
+        .is_some_and(|v| v.starts_with("text/event-stream"));
+    let (parts, body) = response.into_parts();
+    if is_stream {
+        // Count the usage events streams send, recording at the end
+        let stream = async_stream::stream! {
+            let mut data = body.into_data_stream();
+            let mut tokens = Tokens::default();
+            let mut pending = Vec::new();
+            while let Some(chunk) = data.next().await {
+                if let Ok(bytes) = &chunk {
+                    pending.extend_from_slice(bytes);
+                    while let Some(end) = pending.iter().position(|&b| b == b'\n') {
+                        let line: Vec<u8> = pending.drain(..=end).collect();
+                        if let Some(event) = line.strip_prefix(b"data:")
+                            && let Ok(event) = serde_json::from_slice::<Value>(event.trim_ascii())
+                        {
+                            tokens.merge_body(&event);
+                        }
+                    }
+                }
+                yield chunk;
+            }
+            record.prompt_tokens = tokens.prompt;
+            record.completion_tokens = tokens.completion;
+            state.billing_webhooks.observe(&record);
+            state.usage.record(record);
+        };
+        return Response::from_parts(parts, Body::from_stream(stream));
+    }
+
+    let bytes = match axum::body::to_bytes(body, usize::MAX).await {
+        Ok(bytes) => bytes,
+        Err(e) => {
+            return error_response(
+                StatusCode::INTERNAL_SERVER_ERROR,
+                format!("Failed to read the response: {}", e),
+                Some("body"),
+                Some("internal_error"),
+            );
+        }
+    };
+    if let Ok(body) = serde_json::from_slice::<Value>(&bytes) {
+        let mut tokens = Tokens::default();
+        tokens.merge_body(&body);
+        record.prompt_tokens = tokens.prompt;
+        record.completion_tokens = tokens.completion;
+    }
+    state.billing_webhooks.observe(&record);
+    state.usage.record(record);
+    Response::from_parts(parts, Body::from(bytes))
+}
+
+// API Handlers
+
+/// `GET /usage` - token usage over a time range, grouped and filtered by
+/// model, tenant, key, endpoint or metadata; the caller's tenant only
+/// without the admin token
+pub async fn get_usage(
+    State(state): State<Arc<ServerState>>,
+    headers: HeaderMap,
+    Query(query): Query<UsageQuery>,
+) -> Response {
+    let mut query = match ParsedQuery::parse(query) {
+        Ok(query) => query,
+        Err((message, param)) => {
+            return error_response(
+                StatusCode::BAD_REQUEST,
+                message,
+                Some(&param),
+                Some("invalid_dimension"),
+            );
+        }
+    };
+    query.scope_to_caller(&state, &headers);
+
+    let data = state.usage.summarize(&query);
+    Json(json!({
+        "object": "list",
+        "start": query.start,
+        "end": query.end,
+        "group_by": query.group_by.iter().map(Dimension::name).collect::<Vec<_>>(),
+        "data": data
+    }))
+    .into_response()
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn record(model: &str, team: Option<&str>, completion_tokens: u64) -> UsageRecord {
+        UsageRecord {
+            timestamp: Utc::now(),
+            endpoint: "/v1/chat/completions".to_string(),
+            model: model.to_string(),
+            tenant: "acme".to_string(),
+            key_id: None,
+            prompt_tokens: 10,
+            completion_tokens,
+            metadata: team
+                .map(|team| BTreeMap::from([("team".to_string(), team.to_string())]))
+                .unwrap_or_default(),
+        }
+    }
+
+    fn query(group_by: &str, filters: &[(&str, &str)]) -> ParsedQuery {
+        ParsedQuery::parse(UsageQuery {
+            group_by: Some(group_by.to_string()),
+            filters: filters
+                .iter()
+                .map(|(k, v)| (k.to_string(), v.to_string()))
+                .collect(),
+            ..UsageQuery::default()
+        })
+        .unwrap()
+    }
+
+    #[test]
+    fn test_group_by_metadata() {
+        let log = UsageLog::new();
+        log.record(record("llama", Some("search"), 100));
+        log.record(record("llama", Some("search"), 50));
+        log.record(record("mistral", Some("ads"), 20));
+        log.record(record("llama", None, 5));
+
+        let groups = log.summarize(&query("metadata.team", &[]));
+        assert_eq!(groups.len(), 3);
+        assert_eq!(groups[0].group["metadata.team"], json!("search"));
+        assert_eq!(groups[0].requests, 2);
+        assert_eq!(groups[0].total_tokens, 170);
+        assert_eq!(groups[2].group["metadata.team"], Value::Null);
+
+        let groups = log.summarize(&query("model", &[("metadata.team", "search")]));
+        assert_eq!(groups.len(), 1);
+        assert_eq!(groups[0].completion_tokens, 150);
+    }
+
+    #[test]
+    fn test_scope_replaces_caller_filters() {
+        let log = UsageLog::new();
+        log.record(record("llama", None, 100));
+        log.record(UsageRecord {
+            key_id: Some("key_1".to_string()),
+            ..record("llama", None, 20)
+        });
+        log.record(UsageRecord {
+            tenant: "globex".to_string(),
+            ..record("llama", None, 5)
+        });
+
+        // A filter naming another tenant or key is replaced, not combined
+        let mut scoped = query("model", &[("tenant", "globex")]);
+        scoped.limit_to("acme".to_string(), None);
+        assert_eq!(scoped.tenant(), Some("acme"));
+        assert_eq!(log.summarize(&scoped)[0].completion_tokens, 120);
+
+        let mut scoped = query("model", &[("key", "key_2")]);
+        scoped.limit_to("acme".to_string(), Some("key_1".to_string()));
+        assert_eq!(scoped.key(), Some("key_1"));
+        assert_eq!(log.summarize(&scoped)[0].completion_tokens, 20);
+    }
+
+    #[test]
+    fn test_metadata_validation() {
+        assert!(UsageMetadata::parse(None).unwrap().is_empty());
+        assert!(UsageMetadata::parse(Some(&json!({"team": "search"}))).is_ok());
+        assert!(UsageMetadata::parse(Some(&json!({"team": 3}))).is_err());
+        assert!(UsageMetadata::parse(Some(&json!(["team"]))).is_err());
+        let many: Map<String, Value> = (0..17).map(|i| (i.to_string(), json!("x"))).collect();
+        assert!(UsageMetadata::parse(Some(&Value::Object(many))).is_err());
+        assert!(Dimension::parse("metadata.").is_none());
+    }
+
+    #[test]
+    fn test_usage_from_bodies() {
+        let mut tokens = Tokens::default();
+        tokens.merge_body(&json!({"usage": {"prompt_tokens": 7, "completion_tokens": 3}}));
+        assert_eq!((tokens.prompt, tokens.completion), (7, 3));
+
+        let mut tokens = Tokens::default();
+        tokens.merge_body(
+            &json!({"type": "message_start", "message": {"usage": {"input_tokens": 9}}}),
+        );
+        tokens.merge_body(&json!({"type": "message_delta", "usage": {"output_tokens": 4}}));
+        assert_eq!((tokens.prompt, tokens.completion), (9, 4));
+    }
+}
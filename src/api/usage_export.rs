@@ -0,0 +1,1067 @@
+//! Usage Export
+//!
+//! Finance teams want the raw usage records, not dashboards.
+//! `POST /usage/export` starts a job writing the records of a time range as
+//! CSV or Parquet, one row per request with the chosen dimensions (`model`,
+//! `tenant`, `key`, `endpoint`, `metadata.<key>`) and its token counts. The
+//! job runs in the background; `GET /usage/exports/:export_id` reports it
+//! and, once it completes, a signed `download_url`.
+//!
+//! The download URL carries an expiry and an HMAC-SHA256 signature under a
+//! key drawn at startup, so it can be handed to tools that hold no
+//! credentials; it works on this server until it expires, and JWT and
+//! request signing do not apply to it. Exports are held in memory for
+//! [`EXPORT_RETENTION`]. Without the admin token an export covers only what
+//! the caller sees at `/usage`, and only that caller can look it up.
+
+use crate::{
+    api::{
+        error_response,
+        usage::{Dimension, ParsedQuery, UsageQuery, UsageRecord},
+    },
+    cli::serve::ServerState,
+};
+use axum::{
+    Json,
+    extract::{Path, Query, Request, State},
+    http::{HeaderMap, HeaderValue, StatusCode, header},
+    response::{IntoResponse, Response},
+};
+use chrono::{DateTime, Utc};
+use ring::hmac;
+use serde::{Deserialize, Serialize};
+use std::{
+    collections::HashMap,
+    sync::{Arc, RwLock},
+    time::Duration,
+};
+use tracing::{info, warn};
+use uuid::Uuid;
+
+/// How long finished exports and their download URLs last
+pub const EXPORT_RETENTION: Duration = Duration::from_secs(60 * 60);
+
+/// Columns of an export that names no dimensions
+const DEFAULT_DIMENSIONS: &[&str] = &["model", "tenant", "key", "endpoint"];
+
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Serialize, Deserialize)]
+#[serde(rename_all = "snake_case")]
+pub enum ExportFormat {
+    Csv,
+    Parquet,
+}
+
+impl ExportFormat {
+    fn extension(&self) -> &'static str {
+        match self {
+            ExportFormat::Csv => "csv",
+            ExportFormat::Parquet => "parquet",
+        }
+    }
+
+    fn content_type(&self) -> &'static str {
+        match self {
+            ExportFormat::Csv => "text/csv; charset=utf-8",
+            ExportFormat::Parquet => "application/vnd.apache.parquet",
+        }
+    }
+}
+
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Serialize, Deserialize)]
+#[serde(rename_all = "snake_case")]
+pub enum ExportStatus {
+    Running,
+    Completed,
+    Failed,
+}
+
+/// `POST /usage/export` body
+#[derive(Debug, Clone, Deserialize)]
+pub struct ExportRequest {
+    pub format: ExportFormat,
+    #[serde(default)]
+    pub start: Option<DateTime<Utc>>,
+    #[serde(default)]
+    pub end: Option<DateTime<Utc>>,
+    /// Columns besides the timestamp and token counts; model, tenant, key
+    /// and endpoint when omitted
+    #[serde(default)]
+    pub dimensions: Option<Vec<String>>,
+    /// Keep records whose dimension has the given value
+    #[serde(default)]
+    pub filters: HashMap<String, String>,
+}
+
+/// An export job
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct UsageExport {
+    pub id: String,
+    pub object: String,
+    pub status: ExportStatus,
+    pub format: ExportFormat,
+    pub start: Option<DateTime<Utc>>,
+    pub end: Option<DateTime<Utc>>,
+    pub dimensions: Vec<String>,
+    /// The tenant a non-admin export is limited to
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub tenant: Option<String>,
+    /// The managed key an export made with a key outside any tenant is
+    /// limited to
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub key_id: Option<String>,
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub rows: Option<usize>,
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub bytes: Option<usize>,
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub filename: Option<String>,
+    /// Signed, and valid until `expires_at`
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub download_url: Option<String>,
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub error: Option<String>,
+    pub created_at: DateTime<Utc>,
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub completed_at: Option<DateTime<Utc>>,
+    pub expires_at: DateTime<Utc>,
+}
+
+/// Export jobs, their files and the download URL key
+pub struct UsageExportStore {
+    exports: RwLock<HashMap<String, (UsageExport, Option<Arc<Vec<u8>>>)>>,
+    key: hmac::Key,
+}
+
+impl std::fmt::Debug for UsageExportStore {
+    fn fmt(&self, f: &mut std::fmt::Formatter<'_>) -> std::fmt::Result {
+        f.debug_struct("UsageExportStore").finish_non_exhaustive()
+    }
+}
+
+impl Default for UsageExportStore {
+    fn default() -> Self {
+        Self {
+            exports: RwLock::new(HashMap::new()),
+            key: hmac::Key::new(hmac::HMAC_SHA256, &rand::random::<[u8; 32]>()),
+        }
+    }
+}
+
+impl UsageExportStore {
+    pub fn new() -> Self {
+        Self::default()
+    }
+
+    fn insert(&self, export: UsageExport) {
+        let now = Utc::now();
+        let mut exports = self.exports.write().unwrap();
+        exports.retain(|_, (export, _)| export.expires_at > now);
+        exports.insert(export.id.clone(), (export, None));
+    }
+
+    fn get(&self, id: &str) -> Option<UsageExport> {
+        let exports = self.exports.read().unwrap();
+        exports
+            .get(id)
+            .map(|(export, _)| export.clone())
+            .filter(|export| export.expires_at > Utc::now())
+    }
+
+    fn file(&self, id: &str) -> Option<(UsageExport, Arc<Vec<u8>>)> {
+        let exports = self.exports.read().unwrap();
+        let (export, file) = exports.get(id)?;
+        Some((export.clone(), Arc::clone(file.as_ref()?)))
+    }
+
+    fn complete(&self, id: &str, result: Result<(Vec<u8>, usize), String>) {
+        let mut exports = self.exports.write().unwrap();
+        let Some((export, file)) = exports.get_mut(id) else {
+            return;
+        };
+        export.completed_at = Some(Utc::now());
+        match result {
+            Ok((bytes, rows)) => {
+                export.status = ExportStatus::Completed;
+                export.rows = Some(rows);
+                export.bytes = Some(bytes.len());
+                export.filename = Some(format!("usage-{}.{}", id, export.format.extension()));
+                let expires = export.expires_at.timestamp();
+                export.download_url = Some(format!(
+                    "/usage/exports/{}/download?expires={}&signature={}",
+                    id,
+                    expires,
+                    self.signature(id, expires)
+                ));
+                *file = Some(Arc::new(bytes));
+            }
+            Err(e) => {
+                export.status = ExportStatus::Failed;
+                export.error = Some(e);
+            }
+        }
+    }
+
+    fn signature(&self, id: &str, expires: i64) -> String {
+        let tag = hmac::sign(&self.key, format!("{}:{}", id, expires).as_bytes());
+        hex::encode(tag.as_ref())
+    }
+
+    fn verify(&self, id: &str, expires: i64, signature: &str) -> bool {
+        hex::decode(signature).is_ok_and(|signature| {
+            hmac::verify(
+                &self.key,
+                format!("{}:{}", id, expires).as_bytes(),
+                &signature,
+            )
+            .is_ok()
+        })
+    }
+}
+
+/// True for a download carrying a signature, which authenticates it in
+/// place of a token
+pub fn is_signed_download(request: &Request) -> bool {
+    request.uri().path().starts_with("/usage/exports/")
+        && request.uri().path().ends_with("/download")
+        && request
+            .uri()
+            .query()
+            .is_some_and(|query| query.contains("signature="))
+}
+
+/// `records` as CSV: the timestamp, the dimension columns and token counts
+fn write_csv(records: &[UsageRecord], dimensions: &[Dimension]) -> Result<Vec<u8>, String> {
+    let mut writer = csv::Writer::from_writer(Vec::new());
+    let mut header = vec!["timestamp".to_string()];
+    header.extend(dimensions.iter().map(Dimension::name));
+    header.extend(
+        ["prompt_tokens", "completion_tokens", "total_tokens"]
+            .iter()
+            .map(|s| s.to_string()),
+    );
+    writer.write_record(&header).map_err(|e| e.to_string())?;
+
+    for record in records {
+        let mut row = vec![record.timestamp.to_rfc3339()];
+        row.extend(
+            dimensions
+                .iter()
+                .map(|dimension| record.dimension(dimension).unwrap_or_default().to_string()),
+        );
+        row.push(record.prompt_tokens.to_string());
+        row.push(record.completion_tokens.to_string());
+        row.push((record.prompt_tokens + record.completion_tokens).to_string());
+        writer.write_record(&row).map_err(|e| e.to_string())?;
+    }
+    writer.into_inner().map_err(|e| e.to_string())
+}
+
+/// `records` as Parquet, with the same columns as the CSV; missing
+/// dimension values are nulls
+fn records_to_parquet(records: &[UsageRecord], dimensions: &[Dimension]) -> Vec<u8> {
+    let tokens = |f: fn(&UsageRecord) -> u64| records.iter().map(|r| f(r) as i64).collect();
+    let mut columns = vec![Column::Timestamp(
+        "timestamp".to_string(),
+        records
+            .iter()
+            .map(|r| r.timestamp.timestamp_millis())
+            .collect(),
+    )];
+    for dimension in dimensions {
+        columns.push(Column::Utf8(
+            dimension.name(),
+            records
+                .iter()
+                .map(|r| r.dimension(dimension).map(str::to_string))
+                .collect(),
+        ));
+    }
+    columns.push(Column::Int64(
+        "prompt_tokens".to_string(),
+        tokens(|r| r.prompt_tokens),
+    ));
+    columns.push(Column::Int64(
+        "completion_tokens".to_string(),
+        tokens(|r| r.completion_tokens),
+    ));
+    columns.push(Column::Int64(
+        "total_tokens".to_string(),
+        tokens(|r| r.prompt_tokens + r.completion_tokens),
+    ));
+    write_parquet(&columns, records.len())
+}
+
+// Parquet
+
+/// A column of an export file
+#[derive(Debug, Clone)]
+enum Column {
+    /// Milliseconds since the Unix epoch, a Parquet `TIMESTAMP_MILLIS`
+    Timestamp(String, Vec<i64>),
+    Int64(String, Vec<i64>),
+    /// Optional UTF-8 strings
+    Utf8(String, Vec<Option<String>>),
+}
+
+impl Column {
+    fn name(&self) -> &str {
+        match self {
+            Column::Timestamp(name, _) | Column::Int64(name, _) | Column::Utf8(name, _) => name,
+        }
+    }
+}
+
+// Parquet physical types, repetitions, converted types and encodings
+const TYPE_INT64: i32 = 2;
+const TYPE_BYTE_ARRAY: i32 = 6;
+const REQUIRED: i32 = 0;
+const OPTIONAL: i32 = 1;
+const CONVERTED_UTF8: i32 = 0;
+const CONVERTED_TIMESTAMP_MILLIS: i32 = 9;
+const ENCODING_PLAIN: i32 = 0;
+const ENCODING_RLE: i32 = 3;
+
+// Thrift compact protocol field types
+const CT_I32: u8 = 5;
+const CT_I64: u8 = 6;
+const CT_BINARY: u8 = 8;
+const CT_LIST: u8 = 9;
+const CT_STRUCT: u8 = 12;
+
+/// A Thrift compact protocol encoder, enough for Parquet's metadata
+struct Compact {
+    buf: Vec<u8>,
+    /// The last field id of each open struct
+    last_ids: Vec<i16>,
+}
+
+impl Compact {
+    fn new() -> Self {
+        Self {
+            buf: Vec::new(),
+            last_ids: vec![0],
+        }
+    }
+
+    fn varint(&mut self, mut value: u64) {
+        while value >= 0x80 {
+            self.buf.push((value as u8) | 0x80);
+            value >>= 7;
+        }
+        self.buf.push(value as u8);
+    }
+
+    fn zigzag(&mut self, value: i64) {
+        self.varint(((value << 1) ^ (value >> 63)) as u64);
+    }
+
+    fn field(&mut self, id: i16, field_type: u8) {
+        let last = self.last_ids.last_mut().expect("an open struct");
+        let delta = id - *last;
+        *last = id;
+        if (1..=15).contains(&delta) {
+            self.buf.push(((delta as u8) << 4) | field_type);
+        } else {
+            self.buf.push(field_type);
+            self.zigzag(id as i64);
+        }
+    }
+
+    fn i32(&mut self, id: i16, value: i32) {
+        self.field(id, CT_I32);
+        self.zigzag(value as i64);
+    }
+
+    fn i64(&mut self, id: i16, value: i64) {
+        self.field(id, CT_I64);
+        self.zigzag(value);
+    }
+
+    fn binary(&mut self, id: i16, value: &[u8]) {
+        self.field(id, CT_BINARY);
+        self.varint(value.len() as u64);
+        self.buf.extend_from_slice(value);
+    }
+
+    fn list(&mut self, id: i16, element_type: u8, len: usize) {
+        self.field(id, CT_LIST);
+        if len < 15 {
+            self.buf.push(((len as u8) << 4) | element_type);
+        } else {
+            self.buf.push(0xf0 | element_type);
+            self.varint(len as u64);
+        }
+    }
+
+    fn i32_element(&mut self, value: i32) {
+        self.zigzag(value as i64);
+    }
+
+    fn binary_element(&mut self, value: &[u8]) {
+        self.varint(value.len() as u64);
+        self.buf.extend_from_slice(value);
+    }
+
+    /// Open a struct field, or a struct list element when `id` is `None`
+    fn begin_struct(&mut self, id: Option<i16>) {
+        if let Some(id) = id {
+            self.field(id, CT_STRUCT);
+        }
+        self.last_ids.push(0);
+    }
+
+    fn end_struct(&mut self) {
+        self.buf.push(0);
+        self.last_ids.pop();
+    }
+
+    fn finish(mut self) -> Vec<u8> {
+        self.buf.push(0);
+        self.buf
+    }
+}
+
+/// `values` as the RLE/bit-packing hybrid Parquet stores levels in, with
+/// a bit width of one, as runs only
+fn rle_levels(values: &[bool]) -> Vec<u8> {
+    let mut out = Vec::new();
+    let mut i = 0;
+    while i < values.len() {
+        let run = values[i..].iter().take_while(|&&v| v == values[i]).count();
+        let mut header = (run as u64) << 1;
+        while header >= 0x80 {
+            out.push((header as u8) | 0x80);
+            header >>= 7;
+        }
+        out.push(header as u8);
+        out.push(values[i] as u8);
+        i += run;
+    }
+    out
+}
+
+/// One PLAIN-encoded data page holding all of `column`'s values
+fn data_page(column: &Column) -> Vec<u8> {
+    let mut data = Vec::new();
+    match column {
+        Column::Timestamp(_, values) | Column::Int64(_, values) => {
+            for value in values {
+                data.extend_from_slice(&value.to_le_bytes());
+            }
+        }
+        Column::Utf8(_, values) => {
+            let defined: Vec<bool> = values.iter().map(Option::is_some).collect();
+            let levels = rle_levels(&defined);
+            data.extend_from_slice(&(levels.len() as u32).to_le_bytes());
+            data.extend_from_slice(&levels);
+            for value in values.iter().flatten() {
+                data.extend_from_slice(&(value.len() as u32).to_le_bytes());
+                data.extend_from_slice(value.as_bytes());
+            }
+        }
+    }
+    data
+}
+
+/// `columns`, all `rows` long, as an uncompressed Parquet file with one row
+/// group
+fn write_parquet(columns: &[Column], rows: usize) -> Vec<u8> {
+    let mut out = b"PAR1".to_vec();
+    let mut chunks = Vec::new();
+
+    for column in columns {
+        let data = data_page(column);
+        let mut header = Compact::new();
+        header.i32(1, 0); // DATA_PAGE
+        header.i32(2, data.len() as i32);
+        header.i32(3, data.len() as i32);
+        header.begin_struct(Some(5));
+        header.i32(1, rows as i32);
+        header.i32(2, ENCODING_PLAIN);
+        header.i32(3, ENCODING_RLE);
+        header.i32(4, ENCODING_RLE);
+        header.end_struct();
+        let header = header.finish();
+
+        let offset = out.len() as i64;
+        out.extend_from_slice(&header);
+        out.extend_from_slice(&data);
+        chunks.push((offset, (header.len() + data.len()) as i64));
+    }
+
+    let mut meta = Compact::new();
+    meta.i32(1, 1);
+    meta.list(2, CT_STRUCT, columns.len() + 1);
+    meta.begin_struct(None);
+    meta.binary(4, b"schema");
+    meta.i32(5, columns.len() as i32);
+    meta.end_struct();
+    for column in columns {
+        let (physical, repetition, converted) = match column {
+            Column::Timestamp(..) => (TYPE_INT64, REQUIRED, Some(CONVERTED_TIMESTAMP_MILLIS)),
+            Column::Int64(..) => (TYPE_INT64, REQUIRED, None),
+            Column::Utf8(..) => (TYPE_BYTE_ARRAY, OPTIONAL, Some(CONVERTED_UTF8)),
+        };
+        meta.begin_struct(None);
+        meta.i32(1, physical);
+        meta.i32(3, repetition);
+        meta.binary(4, column.name().as_bytes());
+        if let Some(converted) = converted {
+            meta.i32(6, converted);
+        }
+        meta.end_struct();
+    }
+    meta.i64(3, rows as i64);
+
+    meta.list(4, CT_STRUCT, 1);
+    meta.begin_struct(None);
+    meta.list(1, CT_STRUCT, columns.len());
+    for (column, (offset, size)) in columns.iter().zip(&chunks) {
+        meta.begin_struct(None);
+        meta.i64(2, *offset);
+        meta.begin_struct(Some(3));
+        meta.i32(
+            1,
+            match column {
+                Column::Utf8(..) => TYPE_BYTE_ARRAY,
+                _ => TYPE_INT64,
+            },
+        );
+        meta.list(2, CT_I32, 2);
+        meta.i32_element(ENCODING_PLAIN);
+        meta.i32_element(ENCODING_RLE);
+        meta.list(3, CT_BINARY, 1);
+        meta.binary_element(column.name().as_bytes());
+        meta.i32(4, 0); // UNCOMPRESSED
+        meta.i64(5, rows as i64);
+        meta.i64(6, *size);
+        meta.i64(7, *size);
+        meta.i64(9, *offset);
+        meta.end_struct();
+        meta.end_struct();
+    }
+    meta.i64(2, chunks.iter().map(|(_, size)| size).sum());
+    meta.i64(3, rows as i64);
+    meta.end_struct();
+    meta.binary(
+        6,
+        format!("inferno version {}", env!("CARGO_PKG_VERSION")).as_bytes(),
+    );
+    let meta = meta.finish();
+
+    out.extend_from_slice(&meta);
+    out.extend_from_slice(&(meta.len() as u32).to_le_bytes());
+    out.extend_from_slice(b"PAR1");
+    out
+}
+
+fn export_not_found(id: &str) -> Response {
+    error_response(
+        StatusCode::NOT_FOUND,
+        format!("Usage export '{}' not found or expired", id),
+        Some("export_id"),
+        Some("export_not_found"),
+    )
+}
+
+// API Handlers
+
+/// `POST /usage/export` - start writing usage records to a CSV or Parquet
+/// file
+pub async fn create_export(
+    State(state): State<Arc<ServerState>>,
+    headers: HeaderMap,
+    Json(request): Json<ExportRequest>,
+) -> Response {
+    let names = request.dimensions.clone().unwrap_or_else(|| {
+        DEFAULT_DIMENSIONS
+            .iter()
+            .map(|name| name.to_string())
+            .collect()
+    });
+    let mut dimensions = Vec::new();
+    for name in &names {
+        match Dimension::parse(name) {
+            Some(dimension) if !dimensions.contains(&dimension) => dimensions.push(dimension),
+            Some(_) => {}
+            None => {
+                return error_response(
+                    StatusCode::BAD_REQUEST,
+                    format!(
+                        "Unknown usage dimension '{}'; use model, tenant, key, endpoint or metadata.<key>",
+                        name
+                    ),
+                    Some("dimensions"),
+                    Some("invalid_dimension"),
+                );
+            }
+        }
+    }
+
+    let mut query = match ParsedQuery::parse(UsageQuery {
+        start: request.start,
+        end: request.end,
+        group_by: None,
+        filters: request.filters.clone(),
+    }) {
+        Ok(query) => query,
+        Err((message, param)) => {
+            return error_response(
+                StatusCode::BAD_REQUEST,
+                message,
+                Some(&format!("filters.{}", param)),
+                Some("invalid_dimension"),
+            );
+        }
+    };
+    query.scope_to_caller(&state, &headers);
+
+    let now = Utc::now();
+    let export = UsageExport {
+        id: format!("export_{}", Uuid::new_v4().simple()),
+        object: "usage.export".to_string(),
+        status: ExportStatus::Running,
+        format: request.format,
+        start: request.start,
+        end: request.end,
+        dimensions: dimensions.iter().map(Dimension::name).collect(),
+        tenant: query.tenant().map(str::to_string),
+        key_id: query.key().map(str::to_string),
+        rows: None,
+        bytes: None,
+        filename: None,
+        download_url: None,
+        error: None,
+        created_at: now,
+        completed_at: None,
+        expires_at: now + chrono::Duration::from_std(EXPORT_RETENTION).unwrap(),
+    };
+    state.usage_exports.insert(export.clone());
+    info!("Usage export {} started ({:?})", export.id, export.format);
+
+    let task_state = Arc::clone(&state);
+    let id = export.id.clone();
+    let format = export.format;
+    tokio::spawn(async move {
+        let records = task_state.usage.records(&query);
+        let rows = records.len();
+        let result = tokio::task::spawn_blocking(move || match format {
+            ExportFormat::Csv => write_csv(&records, &dimensions),
+            ExportFormat::Parquet => Ok(records_to_parquet(&records, &dimensions)),
+        })
+        .await
+        .map_err(|e| e.to_string())
+        .and_then(|result| result);
+        if let Err(e) = &result {
+            warn!("Usage export {} failed: {}", id, e);
+        }
+        task_state
+            .usage_exports
+            .complete(&id, result.map(|bytes| (bytes, rows)));
+    });
+
+    (StatusCode::ACCEPTED, Json(export)).into_response()
+}
+
+/// `GET /usage/exports/:export_id` - an export's status and, once
+/// complete, its download URL
+pub async fn get_export(
+    State(state): State<Arc<ServerState>>,
+    Path(id): Path<String>,
+    headers: HeaderMap,
+) -> Response {
+    let Some(export) = state.usage_exports.get(&id) else {
+        return export_not_found(&id);
+    };
+    // Another caller's export is not found rather than forbidden
+    let mut caller = ParsedQuery::parse(UsageQuery::default()).expect("an empty query");
+    caller.scope_to_caller(&state, &headers);
+    let other_tenant = caller
+        .tenant()
+        .is_some_and(|tenant| export.tenant.as_deref() != Some(tenant));
+    let other_key = caller
+        .key()
+        .is_some_and(|key| export.key_id.as_deref() != Some(key));
+    if other_tenant || other_key {
+        return export_not_found(&id);
+    }
+    Json(export).into_response()
+}
+
+#[derive(Debug, Deserialize)]
+pub struct DownloadQuery {
+    pub expires: i64,
+    pub signature: String,
+}
+
+/// `GET /usage/exports/:export_id/download` - the export's file, with the
+/// signature from its `download_url`
+pub async fn download_export(
+    State(state): State<Arc<ServerState>>,
+    Path(id): Path<String>,
+    Query(query): Query<DownloadQuery>,
+) -> Response {
+    if !state
+        .usage_exports
+        .verify(&id, query.expires, &query.signature)
+    {
+        return error_response(
+            StatusCode::FORBIDDEN,
+            "The download URL's signature is not valid".to_string(),
+            Some("signature"),
+            Some("invalid_signature"),
+        );
+    }
+    if query.expires <= Utc::now().timestamp() {
+        return error_response(
+            StatusCode::FORBIDDEN,
+            "The download URL has expired; fetch the export again for a new one".to_string(),
+            Some("expires"),
+            Some("download_url_expired"),
+        );
+    }
+    let Some((export, file)) = state.usage_exports.file(&id) else {
+        return export_not_found(&id);
+    };
+
+    let mut response = file.as_ref().clone().into_response();
+    let headers = response.headers_mut();
+    headers.insert(
+        header::CONTENT_TYPE,
+        HeaderValue::from_static(export.format.content_type()),
+    );
+    if let Some(filename) = &export.filename
+        && let Ok(value) = HeaderValue::from_str(&format!("attachment; filename=\"{}\"", filename))
+    {
+        headers.insert(header::CONTENT_DISPOSITION, value);
+    }
+    response
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use std::collections::BTreeMap;
+
+    fn record(model: &str, team: Option<&str>) -> UsageRecord {
+        UsageRecord {
+            timestamp: Utc::now(),
+            endpoint: "/v1/completions".to_string(),
+            model: model.to_string(),
+            tenant: "acme".to_string(),
+            key_id: None,
+            prompt_tokens: 12,
+            completion_tokens: 30,
+            metadata: team
+                .map(|team| BTreeMap::from([("team".to_string(), team.to_string())]))
+                .unwrap_or_default(),
+        }
+    }
+
+    #[test]
+    fn test_csv_columns() {
+        let dimensions = vec![Dimension::Model, Dimension::Metadata("team".to_string())];
+        let csv = write_csv(
+            &[record("llama", Some("search")), record("mistral", None)],
+            &dimensions,
+        )
+        .unwrap();
+        let csv = String::from_utf8(csv).unwrap();
+        let lines: Vec<&str> = csv.lines().collect();
+        assert_eq!(
+            lines[0],
+            "timestamp,model,metadata.team,prompt_tokens,completion_tokens,total_tokens"
+        );
+        assert!(lines[1].ends_with(",llama,search,12,30,42"));
+        assert!(lines[2].ends_with(",mistral,,12,30,42"));
+    }
+
+    #[test]
+    fn test_parquet_framing() {
+        let file = records_to_parquet(&[record("llama", None)], &[Dimension::Model]);
+        assert_eq!(&file[..4], b"PAR1");
+        assert_eq!(&file[file.len() - 4..], b"PAR1");
+        let footer = u32::from_le_bytes(file[file.len() - 8..file.len() - 4].try_into().unwrap());
+        assert!((footer as usize) < file.len() - 12);
+    }
+
+    /// A Thrift compact protocol value, decoded by field type alone so the
+    /// reader below shares nothing with the writer
+    #[derive(Debug, Clone)]
+    enum Thrift {
+        Int(i64),
+        Binary(Vec<u8>),
+        List(Vec<Thrift>),
+        Struct(BTreeMap<i16, Thrift>),
+    }
+
+    impl Thrift {
+        fn int(&self) -> i64 {
+            match self {
+                Thrift::Int(value) => *value,
+                other => panic!("expected an integer, got {other:?}"),
+            }
+        }
+
+        fn text(&self) -> &str {
+            match self {
+                Thrift::Binary(value) => std::str::from_utf8(value).unwrap(),
+                other => panic!("expected binary, got {other:?}"),
+            }
+        }
+
+        fn list(&self) -> &[Thrift] {
+            match self {
+                Thrift::List(values) => values,
+                other => panic!("expected a list, got {other:?}"),
+            }
+        }
+
+        fn get(&self, id: i16) -> Option<&Thrift> {
+            match self {
+                Thrift::Struct(fields) => fields.get(&id),
+                other => panic!("expected a struct, got {other:?}"),
+            }
+        }
+
+        fn field(&self, id: i16) -> &Thrift {
+            self.get(id)
+                .unwrap_or_else(|| panic!("missing field {id} in {self:?}"))
+        }
+    }
+
+    struct ThriftReader<'a> {
+        buf: &'a [u8],
+        pos: usize,
+    }
+
+    impl ThriftReader<'_> {
+        fn byte(&mut self) -> u8 {
+            self.pos += 1;
+            self.buf[self.pos - 1]
+        }
+
+        fn varint(&mut self) -> u64 {
+            let (mut value, mut shift) = (0u64, 0);
+            loop {
+                let byte = self.byte();
+                value |= ((byte & 0x7f) as u64) << shift;
+                if byte & 0x80 == 0 {
+                    return value;
+                }
+                shift += 7;
+            }
+        }
+
+        fn zigzag(&mut self) -> i64 {
+            let value = self.varint();
+            (value >> 1) as i64 ^ -((value & 1) as i64)
+        }
+
+        fn value(&mut self, field_type: u8) -> Thrift {
+            match field_type {
+                1 | 2 => Thrift::Int((field_type == 1) as i64),
+                3 => Thrift::Int(self.byte() as i8 as i64),
+                4..=6 => Thrift::Int(self.zigzag()),
+                8 => {
+                    let len = self.varint() as usize;
+                    self.pos += len;
+                    Thrift::Binary(self.buf[self.pos - len..self.pos].to_vec())
+                }
+                9 | 10 => {
+                    let header = self.byte();
+                    let len = match header >> 4 {
+                        15 => self.varint() as usize,
+                        len => len as usize,
+                    };
+                    Thrift::List((0..len).map(|_| self.value(header & 0x0f)).collect())
+                }
+                12 => self.structure(),
+                other => panic!("unsupported compact type {other}"),
+            }
+        }
+
+        fn structure(&mut self) -> Thrift {
+            let mut fields = BTreeMap::new();
+            let mut last = 0i16;
+            loop {
+                let header = self.byte();
+                if header == 0 {
+                    return Thrift::Struct(fields);
+                }
+                let id = match header >> 4 {
+                    0 => self.zigzag() as i16,
+                    delta => last + delta as i16,
+                };
+                last = id;
+                let value = self.value(header & 0x0f);
+                fields.insert(id, value);
+            }
+        }
+    }
+
+    /// Definition levels of bit width one, in either form of the
+    /// RLE/bit-packing hybrid
+    fn read_levels(data: &[u8], count: usize) -> Vec<bool> {
+        let mut reader = ThriftReader { buf: data, pos: 0 };
+        let mut levels = Vec::new();
+        while levels.len() < count {
+            let header = reader.varint();
+            if header & 1 == 0 {
+                let value = reader.byte() != 0;
+                levels.extend(std::iter::repeat_n(value, (header >> 1) as usize));
+            } else {
+                for _ in 0..(header >> 1) {
+                    let byte = reader.byte();
+                    levels.extend((0..8).map(|bit| byte >> bit & 1 == 1));
+                }
+            }
+        }
+        levels.truncate(count);
+        levels
+    }
+
+    /// Each column of a Parquet file by name, read by following the footer
+    /// to every column chunk as a Parquet reader does: PLAIN INT64 values,
+    /// or optional PLAIN BYTE_ARRAY values rendered as strings
+    fn read_parquet(file: &[u8]) -> (Thrift, Vec<(String, Vec<Option<String>>)>) {
+        assert_eq!(&file[..4], b"PAR1");
+        assert_eq!(&file[file.len() - 4..], b"PAR1");
+        let len = u32::from_le_bytes(file[file.len() - 8..file.len() - 4].try_into().unwrap());
+        let start = file.len() - 8 - len as usize;
+        let mut reader = ThriftReader {
+            buf: &file[..file.len() - 8],
+            pos: start,
+        };
+        let meta = reader.structure();
+        assert_eq!(reader.pos, file.len() - 8, "footer length");
+
+        let row_groups = meta.field(4).list();
+        assert_eq!(row_groups.len(), 1);
+        let rows = meta.field(3).int() as usize;
+        assert_eq!(row_groups[0].field(3).int() as usize, rows);
+
+        let mut columns = Vec::new();
+        for chunk in row_groups[0].field(1).list() {
+            let chunk = chunk.field(3);
+            assert_eq!(chunk.field(4).int(), 0, "uncompressed");
+            assert_eq!(chunk.field(5).int() as usize, rows);
+            let offset = chunk.field(9).int() as usize;
+            let mut reader = ThriftReader {
+                buf: file,
+                pos: offset,
+            };
+            let page = reader.structure();
+            assert_eq!(page.field(1).int(), 0, "a data page");
+            let size = page.field(3).int() as usize;
+            assert_eq!(reader.pos + size - offset, chunk.field(7).int() as usize);
+            let header = page.field(5);
+            assert_eq!(header.field(1).int() as usize, rows);
+            assert_eq!(header.field(2).int(), 0, "PLAIN");
+            let data = &file[reader.pos..reader.pos + size];
+
+            let values = match chunk.field(1).int() {
+                2 => data
+                    .chunks_exact(8)
+                    .map(|v| Some(i64::from_le_bytes(v.try_into().unwrap()).to_string()))
+                    .collect(),
+                6 => {
+                    let levels_len = u32::from_le_bytes(data[..4].try_into().unwrap()) as usize;
+                    let levels = read_levels(&data[4..4 + levels_len], rows);
+                    let mut pos = 4 + levels_len;
+                    levels
+                        .into_iter()
+                        .map(|defined| {
+                            defined.then(|| {
+                                let len =
+                                    u32::from_le_bytes(data[pos..pos + 4].try_into().unwrap());
+                                pos += 4 + len as usize;
+                                String::from_utf8(data[pos - len as usize..pos].to_vec()).unwrap()
+                            })
+                        })
+                        .collect()
+                }
+                other => panic!("unexpected physical type {other}"),
+            };
+            let path = chunk.field(3).list();
+            columns.push((path[0].text().to_string(), values));
+        }
+        (meta, columns)
+    }
+
+    #[test]
+    fn test_parquet_reads_back() {
+        let records = [record("llama", Some("search")), record("mistral", None)];
+        let dimensions = vec![Dimension::Model, Dimension::Metadata("team".to_string())];
+        let (meta, columns) = read_parquet(&records_to_parquet(&records, &dimensions));
+
+        // The schema is a root with one leaf per column, typed as written
+        let schema = meta.field(2).list();
+        assert_eq!(schema[0].field(5).int(), 6);
+        let leaves: Vec<(&str, i64, i64, Option<i64>)> = schema[1..]
+            .iter()
+            .map(|element| {
+                (
+                    element.field(4).text(),
+                    element.field(1).int(),
+                    element.field(3).int(),
+                    element.get(6).map(Thrift::int),
+                )
+            })
+            .collect();
+        assert_eq!(
+            leaves,
+            vec![
+                ("timestamp", 2, 0, Some(9)),
+                ("model", 6, 1, Some(0)),
+                ("metadata.team", 6, 1, Some(0)),
+                ("prompt_tokens", 2, 0, None),
+                ("completion_tokens", 2, 0, None),
+                ("total_tokens", 2, 0, None),
+            ]
+        );
+
+        let column = |name: &str| {
+            columns
+                .iter()
+                .find(|(column, _)| column == name)
+                .map(|(_, values)| values.clone())
+                .unwrap()
+        };
+        let some = |values: &[&str]| -> Vec<Option<String>> {
+            values.iter().map(|v| Some(v.to_string())).collect()
+        };
+        let millis: Vec<String> = records
+            .iter()
+            .map(|r| r.timestamp.timestamp_millis().to_string())
+            .collect();
+        assert_eq!(
+            column("timestamp"),
+            some(&[millis[0].as_str(), millis[1].as_str()])
+        );
+        assert_eq!(column("model"), some(&["llama", "mistral"]));
+        assert_eq!(
+            column("metadata.team"),
+            vec![Some("search".to_string()), None]
+        );
+        assert_eq!(column("prompt_tokens"), some(&["12", "12"]));
+        assert_eq!(column("completion_tokens"), some(&["30", "30"]));
+        assert_eq!(column("total_tokens"), some(&["42", "42"]));
+    }
+
+    #[test]
+    fn test_rle_levels() {
+        assert_eq!(rle_levels(&[true, true, false]), vec![4, 1, 2, 0]);
+        assert!(rle_levels(&[]).is_empty());
+    }
+
+    #[test]
+    fn test_download_signature() {
+        let store = UsageExportStore::new();
+        let signature = store.signature("export_1", 1700000000);
+        assert!(store.verify("export_1", 1700000000, &signature));
+        assert!(!store.verify("export_1", 1700000001, &signature));
+        assert!(!store.verify("export_2", 1700000000, &signature));
+        assert!(!store.verify("export_1", 1700000000, "zz"));
+    }
+}
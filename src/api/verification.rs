@@ -0,0 +1,73 @@
+//! Model Verification
+//!
+//! `POST /v1/models/:model_id/verify` runs the checks a model goes through
+//! before it is loaded (see [`crate::models::verification`]) without loading
+//! it: the file is hashed and compared with its recorded digest, and its
+//! `.sig` file is checked against `model_security.trusted_keys`. The response
+//! says whether the current policy would let the model load and, if not, why.
+//! Hashing reads the whole file and a first check records the digest, so the
+//! endpoint is admin-only.
+
+use crate::{
+    api::{admin::authorize_admin, error_response, internal_error},
+    cli::serve::ServerState,
+    models::verification::VerificationPolicy,
+};
+use axum::{
+    Json,
+    extract::{Path, State},
+    http::{HeaderMap, StatusCode},
+    response::{IntoResponse, Response},
+};
+use serde_json::json;
+use std::sync::Arc;
+use tracing::info;
+
+/// `POST /v1/models/:model_id/verify` - check a model's digest and signature
+/// (admin only)
+pub async fn verify_model(
+    State(state): State<Arc<ServerState>>,
+    Path(model_id): Path<String>,
+    headers: HeaderMap,
+) -> Response {
+    if let Err(response) = authorize_admin(&headers) {
+        return response;
+    }
+
+    let model = match state.model_manager.resolve_model(&model_id).await {
+        Ok(model) => model,
+        Err(_) => {
+            return error_response(
+                StatusCode::NOT_FOUND,
+                format!("Model {} not found", model_id),
+                Some("model_id"),
+                Some("model_not_found"),
+            );
+        }
+    };
+
+    let policy = match VerificationPolicy::from_config(state.config.model_security.as_ref()) {
+        Ok(policy) => policy,
+        Err(e) => return internal_error(format!("invalid model_security config: {}", e)),
+    };
+    let verification = match state.model_manager.verify_model(&model.path, &policy).await {
+        Ok(verification) => verification,
+        Err(e) => return internal_error(format!("cannot verify {}: {}", model.name, e)),
+    };
+    info!(
+        "Verified {}: checksum {:?}, signature {:?}, trusted {}",
+        model.name, verification.checksum, verification.signature, verification.trusted
+    );
+
+    let mut body = json!({
+        "object": "model.verification",
+        "model": model.name,
+        "path": model.path,
+    });
+    if let (Some(body), Ok(serde_json::Value::Object(fields))) =
+        (body.as_object_mut(), serde_json::to_value(&verification))
+    {
+        body.extend(fields);
+    }
+    Json(body).into_response()
+}
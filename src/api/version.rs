@@ -0,0 +1,179 @@
+//! API Version Negotiation
+//!
+//! The HTTP API is versioned as `major.minor`, bumped in minor steps as
+//! endpoints are added. Clients list the versions they speak in an
+//! `Accept-Version` header (`"1.2, 1.1"`, or a bare major such as `"1"` for
+//! any 1.x) and every response names the version the server picked in
+//! `API-Version`: the highest one both sides support. Requests without the
+//! header get the current version, and a header naming no version this
+//! server speaks is refused with 406 so old servers fail loudly rather than
+//! answering a newer client in a dialect it does not expect. `GET /version`
+//! lists the supported versions and the version each newer feature needs.
+
+use axum::{
+    Json,
+    extract::Request,
+    http::{HeaderValue, StatusCode},
+    middleware::Next,
+    response::{IntoResponse, Response},
+};
+use serde_json::json;
+use std::fmt;
+
+/// Request header listing the API versions a client accepts
+pub const ACCEPT_VERSION_HEADER: &str = "accept-version";
+
+/// Response header naming the negotiated API version
+pub const API_VERSION_HEADER: &str = "api-version";
+
+#[derive(Debug, Clone, Copy, PartialEq, Eq, PartialOrd, Ord)]
+pub struct ApiVersion {
+    pub major: u16,
+    pub minor: u16,
+}
+
+impl ApiVersion {
+    pub const fn new(major: u16, minor: u16) -> Self {
+        Self { major, minor }
+    }
+}
+
+impl fmt::Display for ApiVersion {
+    fn fmt(&self, f: &mut fmt::Formatter<'_>) -> fmt::Result {
+        write!(f, "{}.{}", self.major, self.minor)
+    }
+}
+
+/// Versions this server speaks, oldest first
+pub const API_VERSIONS: &[ApiVersion] = &[
+    // OpenAI- and Anthropic-compatible inference, tokenize, hidden states, scoring
+    ApiVersion::new(1, 0),
+    // Document endpoints: extract, summarize, translate
+    ApiVersion::new(1, 1),
+    // Server-side sessions
+    ApiVersion::new(1, 2),
+];
+
+/// The newest version, used when a request does not ask for one
+pub const CURRENT_API_VERSION: ApiVersion = API_VERSIONS[API_VERSIONS.len() - 1];
+
+/// Features added after 1.0 and the version that introduced them
+pub const FEATURES: &[(&str, ApiVersion)] = &[
+    ("extract", ApiVersion::new(1, 1)),
+    ("summarize", ApiVersion::new(1, 1)),
+    ("translate", ApiVersion::new(1, 1)),
+    ("sessions", ApiVersion::new(1, 2)),
+];
+
+/// Pick the highest supported version an `Accept-Version` value allows.
+///
+/// Entries are `major.minor` or a bare `major`; unparseable entries are
+/// skipped. Returns `None` when no entry matches a supported version.
+pub fn negotiate(accept: &str) -> Option<ApiVersion> {
+    accept
+        .split(',')
+        .filter_map(|entry| {
+            let entry = entry.trim();
+            match entry.split_once('.') {
+                Some((major, minor)) => {
+                    let wanted = ApiVersion::new(major.parse().ok()?, minor.parse().ok()?);
+                    API_VERSIONS.contains(&wanted).then_some(wanted)
+                }
+                None => {
+                    let major: u16 = entry.parse().ok()?;
+                    API_VERSIONS
+                        .iter()
+                        .rev()
+                        .find(|v| v.major == major)
+                        .copied()
+                }
+            }
+        })
+        .max()
+}
+
+/// Middleware negotiating the API version of every request
+pub async fn negotiate_version(request: Request, next: Next) -> Response {
+    let version = match request.headers().get(ACCEPT_VERSION_HEADER) {
+        None => CURRENT_API_VERSION,
+        Some(accept) => match accept.to_str().ok().and_then(negotiate) {
+            Some(version) => version,
+            None => {
+                let requested = String::from_utf8_lossy(accept.as_bytes()).into_owned();
+                return unsupported_version(&requested);
+            }
+        },
+    };
+
+    let mut response = next.run(request).await;
+    if let Ok(value) = HeaderValue::from_str(&version.to_string()) {
+        response.headers_mut().insert(API_VERSION_HEADER, value);
+    }
+    response
+}
+
+fn unsupported_version(requested: &str) -> Response {
+    let supported: Vec<String> = API_VERSIONS.iter().map(ToString::to_string).collect();
+    (
+        StatusCode::NOT_ACCEPTABLE,
+        Json(json!({
+            "error": {
+                "message": format!(
+                    "This server does not support API version {}; supported versions are {}",
+                    requested,
+                    supported.join(", ")
+                ),
+                "type": "invalid_request_error",
+                "param": ACCEPT_VERSION_HEADER,
+                "code": "unsupported_api_version"
+            }
+        })),
+    )
+        .into_response()
+}
+
+// API Handlers
+
+/// `GET /version` - supported API versions and when each feature arrived
+pub async fn get_version() -> impl IntoResponse {
+    let features: serde_json::Map<String, serde_json::Value> = FEATURES
+        .iter()
+        .map(|(name, since)| (name.to_string(), json!(since.to_string())))
+        .collect();
+
+    Json(json!({
+        "server_version": env!("CARGO_PKG_VERSION"),
+        "api_versions": API_VERSIONS.iter().map(ToString::to_string).collect::<Vec<_>>(),
+        "current": CURRENT_API_VERSION.to_string(),
+        "features": features,
+    }))
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_versions_are_ordered() {
+        assert!(API_VERSIONS.windows(2).all(|pair| pair[0] < pair[1]));
+        assert!(
+            FEATURES
+                .iter()
+                .all(|(_, since)| API_VERSIONS.contains(since))
+        );
+    }
+
+    #[test]
+    fn test_negotiate_picks_highest_mutual_version() {
+        assert_eq!(negotiate("1.0, 1.1"), Some(ApiVersion::new(1, 1)));
+        assert_eq!(negotiate("1.1, 9.0"), Some(ApiVersion::new(1, 1)));
+        assert_eq!(negotiate("1"), Some(CURRENT_API_VERSION));
+    }
+
+    #[test]
+    fn test_negotiate_rejects_unknown_versions() {
+        assert_eq!(negotiate("2.0"), None);
+        assert_eq!(negotiate("1.99, 2"), None);
+        assert_eq!(negotiate("latest"), None);
+    }
+}
@@ -0,0 +1,689 @@
+//! Model Watchdog
+//!
+//! A background task checks the model loaded at startup every
+//! `interval_secs`. The backend counts as crashed when it no longer has the
+//! model loaded (or, with `probe_inference`, a one-token generation fails)
+//! and as hung when it does not answer within `hang_timeout_secs`. Either
+//! opens an incident and the watchdog reloads the model, retrying with
+//! exponential backoff up to `max_attempts` times before giving up until
+//! the next manual recovery.
+//!
+//! A backend holds its lock for the length of a generation, so a hang
+//! timeout shorter than the longest legitimate request reports false hangs.
+//! Reloading a hung backend also waits for its lock; the incident stays
+//! `recovering` until the stuck call returns.
+//!
+//! `GET /admin/watchdog` reports the watchdog's state, `PUT` changes its
+//! settings, `GET /admin/watchdog/incidents` lists recent incidents and
+//! `POST /admin/watchdog/recover` reloads the model now. Incidents are also
+//! recorded on the audit event stream as `model_incident` events.
+
+use crate::{
+    api::{
+        admin::authorize_admin,
+        audit_events::{AuditEvent, AuditKind},
+        invalid_request,
+        model_events::ModelEventType,
+    },
+    backends::{BackendHandle, InferenceParams},
+    cli::serve::ServerState,
+    models::verification::VerificationPolicy,
+};
+use axum::{
+    Json,
+    extract::{Query, State},
+    http::{HeaderMap, StatusCode},
+    response::{IntoResponse, Response},
+};
+use chrono::{DateTime, Utc};
+use serde::{Deserialize, Serialize};
+use serde_json::json;
+use std::{
+    collections::VecDeque,
+    sync::{Arc, Mutex},
+    time::{Duration, Instant},
+};
+use tracing::{error, info, warn};
+
+/// Incidents kept for `/admin/watchdog/incidents`
+const RECENT_INCIDENTS: usize = 100;
+
+/// Path recorded on the audit events the watchdog emits
+const EVENT_PATH: &str = "/admin/watchdog";
+
+/// Watchdog settings
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct WatchdogConfig {
+    pub enabled: bool,
+    /// Seconds between checks
+    pub interval_secs: u64,
+    /// Seconds the backend may take to answer a check before it counts as hung
+    pub hang_timeout_secs: u64,
+    /// Also run a one-token generation on each check
+    pub probe_inference: bool,
+    /// Reload attempts per incident before giving up
+    pub max_attempts: u32,
+    /// Delay before the second attempt, doubling after each failure
+    pub backoff_initial_secs: u64,
+    pub backoff_max_secs: u64,
+}
+
+impl Default for WatchdogConfig {
+    fn default() -> Self {
+        Self {
+            enabled: true,
+            interval_secs: 30,
+            hang_timeout_secs: 300,
+            probe_inference: false,
+            max_attempts: 5,
+            backoff_initial_secs: 5,
+            backoff_max_secs: 300,
+        }
+    }
+}
+
+impl WatchdogConfig {
+    fn validate(&self) -> Result<(), String> {
+        if self.interval_secs == 0 {
+            return Err("interval_secs must be at least 1".to_string());
+        }
+        if self.hang_timeout_secs == 0 {
+            return Err("hang_timeout_secs must be at least 1".to_string());
+        }
+        if self.max_attempts == 0 {
+            return Err("max_attempts must be at least 1".to_string());
+        }
+        if self.backoff_max_secs < self.backoff_initial_secs {
+            return Err("backoff_max_secs must be at least backoff_initial_secs".to_string());
+        }
+        Ok(())
+    }
+
+    /// Delay before reload attempt `attempt` (1-based); the first is immediate
+    fn backoff(&self, attempt: u32) -> Duration {
+        if attempt <= 1 {
+            return Duration::ZERO;
+        }
+        let secs = self
+            .backoff_initial_secs
+            .saturating_mul(1u64 << (attempt - 2).min(32))
+            .min(self.backoff_max_secs);
+        Duration::from_secs(secs)
+    }
+}
+
+/// Partial update; omitted fields keep their current value
+#[derive(Debug, Clone, Default, Deserialize)]
+pub struct WatchdogConfigUpdate {
+    pub enabled: Option<bool>,
+    pub interval_secs: Option<u64>,
+    pub hang_timeout_secs: Option<u64>,
+    pub probe_inference: Option<bool>,
+    pub max_attempts: Option<u32>,
+    pub backoff_initial_secs: Option<u64>,
+    pub backoff_max_secs: Option<u64>,
+}
+
+impl WatchdogConfigUpdate {
+    fn apply(self, mut config: WatchdogConfig) -> WatchdogConfig {
+        if let Some(enabled) = self.enabled {
+            config.enabled = enabled;
+        }
+        if let Some(interval) = self.interval_secs {
+            config.interval_secs = interval;
+        }
+        if let Some(timeout) = self.hang_timeout_secs {
+            config.hang_timeout_secs = timeout;
+        }
+        if let Some(probe) = self.probe_inference {
+            config.probe_inference = probe;
+        }
+        if let Some(attempts) = self.max_attempts {
+            config.max_attempts = attempts;
+        }
+        if let Some(initial) = self.backoff_initial_secs {
+            config.backoff_initial_secs = initial;
+        }
+        if let Some(max) = self.backoff_max_secs {
+            config.backoff_max_secs = max;
+        }
+        config
+    }
+}
+
+/// Why an incident was opened
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Serialize, Deserialize)]
+#[serde(rename_all = "snake_case")]
+pub enum IncidentCause {
+    /// The backend lost its model or failed the probe generation
+    Crashed,
+    /// The backend did not answer within `hang_timeout_secs`
+    Hung,
+    /// An admin asked for a reload
+    Manual,
+}
+
+impl IncidentCause {
+    fn code(&self) -> &'static str {
+        match self {
+            IncidentCause::Crashed => "model_crashed",
+            IncidentCause::Hung => "model_hung",
+            IncidentCause::Manual => "model_reload_requested",
+        }
+    }
+}
+
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Serialize, Deserialize)]
+#[serde(rename_all = "snake_case")]
+pub enum IncidentStatus {
+    Recovering,
+    Recovered,
+    /// Every attempt failed; the watchdog leaves the model alone until a
+    /// manual recovery
+    Failed,
+}
+
+/// One reload attempt
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct RecoveryAttempt {
+    pub attempt: u32,
+    pub started_at: DateTime<Utc>,
+    pub duration_ms: u64,
+    pub succeeded: bool,
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub error: Option<String>,
+}
+
+#[derive(Debug, Clone, Serialize)]
+pub struct Incident {
+    pub object: &'static str,
+    pub id: String,
+    pub model: String,
+    pub cause: IncidentCause,
+    pub status: IncidentStatus,
+    /// What the check saw, or who asked for the reload
+    pub message: String,
+    pub detected_at: DateTime<Utc>,
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub resolved_at: Option<DateTime<Utc>>,
+    pub attempts: Vec<RecoveryAttempt>,
+}
+
+/// Overall state reported by `GET /admin/watchdog`
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Serialize, Deserialize)]
+#[serde(rename_all = "snake_case")]
+pub enum WatchdogState {
+    /// No model was loaded at startup, so there is nothing to watch
+    Idle,
+    Disabled,
+    Healthy,
+    Recovering,
+    /// The last incident's attempts all failed
+    Failed,
+}
+
+#[derive(Debug, Clone, Serialize)]
+pub struct WatchdogStatus {
+    pub object: &'static str,
+    pub state: WatchdogState,
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub model: Option<String>,
+    pub config: WatchdogConfig,
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub last_check_at: Option<DateTime<Utc>>,
+    pub incidents: usize,
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub last_incident: Option<Incident>,
+}
+
+#[derive(Debug, Default)]
+struct Inner {
+    config: WatchdogConfig,
+    incidents: VecDeque<Incident>,
+    last_check_at: Option<DateTime<Utc>>,
+    next_incident: u64,
+}
+
+/// Watchdog settings and incident history
+#[derive(Debug, Default)]
+pub struct Watchdog {
+    inner: Mutex<Inner>,
+    /// Held for the length of a recovery so checks and manual triggers do
+    /// not reload concurrently
+    recovery: tokio::sync::Mutex<()>,
+}
+
+impl Watchdog {
+    pub fn new() -> Self {
+        Self::default()
+    }
+
+    pub fn config(&self) -> WatchdogConfig {
+        self.inner.lock().unwrap().config.clone()
+    }
+
+    fn set_config(&self, config: WatchdogConfig) {
+        self.inner.lock().unwrap().config = config;
+    }
+
+    /// Recent incidents, newest first
+    pub fn incidents(&self) -> Vec<Incident> {
+        self.inner
+            .lock()
+            .unwrap()
+            .incidents
+            .iter()
+            .rev()
+            .cloned()
+            .collect()
+    }
+
+    fn status(&self, model: Option<String>) -> WatchdogStatus {
+        let inner = self.inner.lock().unwrap();
+        let last_incident = inner.incidents.back().cloned();
+        let state = if model.is_none() {
+            WatchdogState::Idle
+        } else if !inner.config.enabled {
+            WatchdogState::Disabled
+        } else {
+            match last_incident.as_ref().map(|incident| incident.status) {
+                Some(IncidentStatus::Recovering) => WatchdogState::Recovering,
+                Some(IncidentStatus::Failed) => WatchdogState::Failed,
+                _ => WatchdogState::Healthy,
+            }
+        };
+        WatchdogStatus {
+            object: "watchdog",
+            state,
+            model,
+            config: inner.config.clone(),
+            last_check_at: inner.last_check_at,
+            incidents: inner.incidents.len(),
+            last_incident,
+        }
+    }
+
+    fn checked(&self) {
+        self.inner.lock().unwrap().last_check_at = Some(Utc::now());
+    }
+
+    /// Whether the last incident gave up; the watchdog then waits for a
+    /// manual recovery instead of retrying every interval
+    fn gave_up(&self) -> bool {
+        self.inner
+            .lock()
+            .unwrap()
+            .incidents
+            .back()
+            .is_some_and(|incident| incident.status == IncidentStatus::Failed)
+    }
+
+    fn open(&self, model: &str, cause: IncidentCause, message: String) -> Incident {
+        let mut inner = self.inner.lock().unwrap();
+        inner.next_incident += 1;
+        let incident = Incident {
+            object: "watchdog.incident",
+            id: format!("incident-{}", inner.next_incident),
+            model: model.to_string(),
+            cause,
+            status: IncidentStatus::Recovering,
+            message,
+            detected_at: Utc::now(),
+            resolved_at: None,
+            attempts: Vec::new(),
+        };
+        if inner.incidents.len() == RECENT_INCIDENTS {
+            inner.incidents.pop_front();
+        }
+        inner.incidents.push_back(incident.clone());
+        incident
+    }
+
+    fn update(&self, id: &str, change: impl FnOnce(&mut Incident)) -> Option<Incident> {
+        let mut inner = self.inner.lock().unwrap();
+        let incident = inner.incidents.iter_mut().find(|i| i.id == id)?;
+        change(incident);
+        Some(incident.clone())
+    }
+}
+
+/// What one check found wrong, if anything
+async fn check_backend(
+    backend: &BackendHandle,
+    config: &WatchdogConfig,
+) -> Option<(IncidentCause, String)> {
+    let timeout = Duration::from_secs(config.hang_timeout_secs);
+    match tokio::time::timeout(timeout, backend.is_loaded()).await {
+        Err(_) => {
+            return Some((
+                IncidentCause::Hung,
+                format!(
+                    "backend did not answer within {}s",
+                    config.hang_timeout_secs
+                ),
+            ));
+        }
+        Ok(false) => {
+            return Some((
+                IncidentCause::Crashed,
+                "backend no longer has the model loaded".to_string(),
+            ));
+        }
+        Ok(true) => {}
+    }
+
+    if config.probe_inference {
+        let params = InferenceParams {
+            max_tokens: 1,
+            temperature: 0.0,
+            stream: false,
+            ..Default::default()
+        };
+        match tokio::time::timeout(timeout, backend.infer("Hello", &params)).await {
+            Err(_) => {
+                return Some((
+                    IncidentCause::Hung,
+                    format!(
+                        "probe generation did not finish within {}s",
+                        config.hang_timeout_secs
+                    ),
+                ));
+            }
+            Ok(Err(e)) => {
+                return Some((
+                    IncidentCause::Crashed,
+                    format!("probe generation failed: {}", e),
+                ));
+            }
+            Ok(Ok(_)) => {}
+        }
+    }
+    None
+}
+
+/// Unload whatever is left of the model and load it again
+async fn reload(state: &ServerState, backend: &BackendHandle, model: &str) -> anyhow::Result<()> {
+    let events = &state.model_events;
+    match backend.unload_model().await {
+        Ok(()) => events.publish(ModelEventType::Unloaded, model, "watchdog"),
+        // A crashed backend may have nothing left to unload
+        Err(e) => warn!("Watchdog unload of {} failed: {}", model, e),
+    }
+    let loaded = async {
+        let mut model_info = state.model_manager.resolve_model(model).await?;
+        let policy = VerificationPolicy::from_config(state.config.model_security.as_ref())?;
+        state
+            .model_manager
+            .verify_for_load(&mut model_info, &policy)
+            .await?;
+        backend.load_model(&model_info).await
+    }
+    .await;
+    match &loaded {
+        Ok(()) => events.publish(ModelEventType::Loaded, model, "watchdog"),
+        Err(e) => events.publish_failure(ModelEventType::Loaded, model, "watchdog", e),
+    }
+    loaded
+}
+
+/// Reload the model for `incident` with backoff until it succeeds or the
+/// attempts run out
+async fn recover(state: &ServerState, backend: &BackendHandle, incident: Incident) -> Incident {
+    let _recovery = state.watchdog.recovery.lock().await;
+    let config = state.watchdog.config();
+    let model = incident.model.clone();
+    let mut incident = incident;
+
+    for attempt in 1..=config.max_attempts {
+        tokio::time::sleep(config.backoff(attempt)).await;
+
+        let started_at = Utc::now();
+        let started = Instant::now();
+        let result = reload(state, backend, &model).await;
+        let record = RecoveryAttempt {
+            attempt,
+            started_at,
+            duration_ms: started.elapsed().as_millis() as u64,
+            succeeded: result.is_ok(),
+            error: result.as_ref().err().map(|e| e.to_string()),
+        };
+
+        let done = result.is_ok() || attempt == config.max_attempts;
+        let status = match &result {
+            Ok(()) => IncidentStatus::Recovered,
+            Err(_) if done => IncidentStatus::Failed,
+            Err(_) => IncidentStatus::Recovering,
+        };
+        incident = state
+            .watchdog
+            .update(&incident.id, |incident| {
+                incident.attempts.push(record);
+                incident.status = status;
+                if done {
+                    incident.resolved_at = Some(Utc::now());
+                }
+            })
+            .unwrap_or(incident);
+
+        match result {
+            Ok(()) => {
+                info!(
+                    "Watchdog reloaded {} on attempt {} ({})",
+                    model, attempt, incident.id
+                );
+                emit(
+                    state,
+                    &incident,
+                    "model_recovered",
+                    format!("{} reloaded after {} attempt(s)", model, attempt),
+                );
+                return incident;
+            }
+            Err(e) => warn!("Watchdog reload {} of {} failed: {}", attempt, model, e),
+        }
+    }
+
+    error!(
+        "Watchdog gave up reloading {} after {} attempts ({})",
+        model, config.max_attempts, incident.id
+    );
+    emit(
+        state,
+        &incident,
+        "model_recovery_failed",
+        format!(
+            "{} could not be reloaded in {} attempts",
+            model, config.max_attempts
+        ),
+    );
+    incident
+}
+
+/// Record an incident change on the audit event stream
+fn emit(state: &ServerState, incident: &Incident, code: &str, message: String) {
+    state.audit.record(AuditEvent {
+        id: 0,
+        timestamp: Utc::now(),
+        kind: AuditKind::ModelIncident,
+        method: String::new(),
+        path: format!("{}/incidents/{}", EVENT_PATH, incident.id),
+        status: 0,
+        code: Some(code.to_string()),
+        message: Some(message),
+        tenant: None,
+        client_ip: None,
+        request_id: None,
+        metadata: None,
+        details: None,
+    });
+}
+
+fn open_incident(
+    state: &ServerState,
+    model: &str,
+    cause: IncidentCause,
+    message: String,
+) -> Incident {
+    let incident = state.watchdog.open(model, cause, message.clone());
+    emit(state, &incident, cause.code(), message);
+    incident
+}
+
+/// Background loop watching the startup model for the lifetime of the
+/// server
+pub async fn run(state: Arc<ServerState>) {
+    let (Some(backend), Some(model)) = (state.backend.clone(), state.loaded_model.clone()) else {
+        return;
+    };
+
+    loop {
+        let config = state.watchdog.config();
+        tokio::time::sleep(Duration::from_secs(config.interval_secs)).await;
+        // An admin unloading the model is not a crash
+        if !config.enabled || state.watchdog.gave_up() || state.loaded_models.startup_unloaded() {
+            continue;
+        }
+
+        let finding = check_backend(&backend, &config).await;
+        state.watchdog.checked();
+        if let Some((cause, message)) = finding {
+            warn!("Watchdog: {} ({})", message, model);
+            let incident = open_incident(&state, &model, cause, message);
+            recover(&state, &backend, incident).await;
+        }
+    }
+}
+
+// API Handlers
+
+/// `GET /admin/watchdog` - watchdog state and settings (admin only)
+pub async fn get_watchdog(State(state): State<Arc<ServerState>>, headers: HeaderMap) -> Response {
+    if let Err(response) = authorize_admin(&headers) {
+        return response;
+    }
+    Json(state.watchdog.status(state.loaded_model.clone())).into_response()
+}
+
+/// `PUT /admin/watchdog` - change watchdog settings (admin only)
+pub async fn update_watchdog(
+    State(state): State<Arc<ServerState>>,
+    headers: HeaderMap,
+    Json(update): Json<WatchdogConfigUpdate>,
+) -> Response {
+    if let Err(response) = authorize_admin(&headers) {
+        return response;
+    }
+
+    let config = update.apply(state.watchdog.config());
+    if let Err(message) = config.validate() {
+        return invalid_request(message, None, None);
+    }
+    state.watchdog.set_config(config);
+    Json(state.watchdog.status(state.loaded_model.clone())).into_response()
+}
+
+#[derive(Debug, Default, Deserialize)]
+pub struct IncidentsQuery {
+    pub limit: Option<usize>,
+}
+
+/// `GET /admin/watchdog/incidents` - recent incidents, newest first (admin only)
+pub async fn list_incidents(
+    State(state): State<Arc<ServerState>>,
+    headers: HeaderMap,
+    Query(query): Query<IncidentsQuery>,
+) -> Response {
+    if let Err(response) = authorize_admin(&headers) {
+        return response;
+    }
+
+    let mut incidents = state.watchdog.incidents();
+    incidents.truncate(query.limit.unwrap_or(RECENT_INCIDENTS));
+    Json(json!({ "object": "list", "data": incidents })).into_response()
+}
+
+/// `POST /admin/watchdog/recover` - reload the startup model now and wait
+/// for the outcome (admin only)
+pub async fn trigger_recovery(
+    State(state): State<Arc<ServerState>>,
+    headers: HeaderMap,
+) -> Response {
+    if let Err(response) = authorize_admin(&headers) {
+        return response;
+    }
+
+    let (Some(backend), Some(model)) = (state.backend.clone(), state.loaded_model.clone()) else {
+        return (
+            StatusCode::CONFLICT,
+            Json(json!({
+                "error": {
+                    "message": "No model was loaded at startup; the watchdog has nothing to recover",
+                    "type": "invalid_request_error",
+                    "param": null,
+                    "code": "no_model_loaded"
+                }
+            })),
+        )
+            .into_response();
+    };
+
+    info!("Watchdog reload of {} requested by an admin", model);
+    let incident = open_incident(
+        &state,
+        &model,
+        IncidentCause::Manual,
+        "reload requested by an admin".to_string(),
+    );
+    let incident = recover(&state, &backend, incident).await;
+    let code = if incident.status == IncidentStatus::Recovered {
+        StatusCode::OK
+    } else {
+        StatusCode::BAD_GATEWAY
+    };
+    (code, Json(incident)).into_response()
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_backoff_doubles_up_to_max() {
+        let config = WatchdogConfig {
+            backoff_initial_secs: 5,
+            backoff_max_secs: 30,
+            ..Default::default()
+        };
+        assert_eq!(config.backoff(1), Duration::ZERO);
+        assert_eq!(config.backoff(2), Duration::from_secs(5));
+        assert_eq!(config.backoff(3), Duration::from_secs(10));
+        assert_eq!(config.backoff(4), Duration::from_secs(20));
+        assert_eq!(config.backoff(5), Duration::from_secs(30));
+        assert_eq!(config.backoff(60), Duration::from_secs(30));
+    }
+
+    #[test]
+    fn test_update_rejects_zero_attempts() {
+        let update: WatchdogConfigUpdate = serde_json::from_str(r#"{"max_attempts": 0}"#).unwrap();
+        assert!(update.apply(WatchdogConfig::default()).validate().is_err());
+    }
+
+    #[test]
+    fn test_incident_history_is_bounded() {
+        let watchdog = Watchdog::new();
+        for _ in 0..RECENT_INCIDENTS + 5 {
+            watchdog.open("m", IncidentCause::Crashed, "gone".to_string());
+        }
+        let incidents = watchdog.incidents();
+        assert_eq!(incidents.len(), RECENT_INCIDENTS);
+        assert_eq!(
+            incidents[0].id,
+            format!("incident-{}", RECENT_INCIDENTS + 5)
+        );
+        assert_eq!(
+            watchdog.status(Some("m".to_string())).state,
+            WatchdogState::Recovering
+        );
+        assert_eq!(watchdog.status(None).state, WatchdogState::Idle);
+    }
+}
@@ -1,11 +1,15 @@
 #![allow(dead_code, unused_imports, unused_variables)]
 use crate::{
     InfernoError,
+    api::model_events::ModelEvent,
     api::openai::{
         ChatChunkChoice, ChatCompletionChunk, ChatCompletionRequest, ChatDelta, ChatMessage,
+        system_fingerprint,
     },
+    api::operations::ServerMode,
     backends::{Backend, InferenceParams},
     cli::serve::ServerState,
+    models::verification::VerificationPolicy,
     streaming::{StreamingConfig, StreamingManager},
     upgrade::{ApplicationVersion, UpgradeEvent, UpgradeStatus},
 };
@@ -67,6 +71,17 @@ pub enum WSMessage {
     },
     #[serde(rename = "upgrade_event")]
     UpgradeEvent { event: UpgradeEvent },
+    /// A model was downloaded, loaded, unloaded, evicted or failed; see
+    /// `api::model_events`
+    #[serde(rename = "model_event")]
+    ModelEvent { event: ModelEvent },
+    /// The server is draining: streams in progress finish, but new requests
+    /// belong on another instance and the client should close the socket
+    #[serde(rename = "goaway")]
+    GoAway {
+        reason: Option<String>,
+        active_streams: usize,
+    },
     #[serde(rename = "upgrade_check_request")]
     UpgradeCheckRequest { id: String, force: bool },
     #[serde(rename = "upgrade_install_request")]
@@ -119,6 +134,7 @@ async fn handle_websocket(socket: WebSocket, state: Arc<ServerState>) {
             "heartbeat".to_string(),
             "upgrade_notifications".to_string(),
             "upgrade_management".to_string(),
+            "model_events".to_string(),
         ],
     };
 
@@ -163,10 +179,74 @@ async fn handle_websocket(socket: WebSocket, state: Arc<ServerState>) {
         }
     });
 
+    // Tell the client to move on once the server starts draining
+    let goaway_sender = sender.clone();
+    let goaway_manager = streaming_manager.clone();
+    let goaway_state = state.clone();
+    let mut mode = state.operations.subscribe();
+
+    let goaway_handle = tokio::spawn(async move {
+        if mode
+            .wait_for(|mode| *mode == ServerMode::Draining)
+            .await
+            .is_err()
+        {
+            return;
+        }
+
+        let goaway = WSMessage::GoAway {
+            reason: goaway_state.operations.status().reason,
+            active_streams: goaway_manager.get_metrics().active_streams,
+        };
+        if let Ok(msg) = serde_json::to_string(&goaway) {
+            let _ = goaway_sender.lock().await.send(Message::Text(msg)).await;
+        }
+    });
+
+    // Forward model lifecycle events as they are published
+    let model_event_sender = sender.clone();
+    let mut model_events = state.model_events.subscribe();
+
+    let model_event_handle = tokio::spawn(async move {
+        loop {
+            let event = match model_events.recv().await {
+                Ok(event) => event,
+                Err(tokio::sync::broadcast::error::RecvError::Lagged(_)) => continue,
+                Err(tokio::sync::broadcast::error::RecvError::Closed) => break,
+            };
+            let Ok(msg) = serde_json::to_string(&WSMessage::ModelEvent { event }) else {
+                continue;
+            };
+            if model_event_sender
+                .lock()
+                .await
+                .send(Message::Text(msg))
+                .await
+                .is_err()
+            {
+                break;
+            }
+        }
+    });
+
     // Handle incoming messages
     while let Some(msg) = receiver.next().await {
         match msg {
             Ok(Message::Text(text)) => match serde_json::from_str::<WSMessage>(&text) {
+                Ok(WSMessage::ChatRequest { id, .. })
+                    if state.operations.mode() != ServerMode::Serving =>
+                {
+                    let error_msg = WSMessage::Error {
+                        id: Some(id),
+                        message: "The server is not accepting new requests; reconnect to another instance"
+                            .to_string(),
+                        code: "SERVER_DRAINING".to_string(),
+                    };
+
+                    if let Ok(error_json) = serde_json::to_string(&error_msg) {
+                        let _ = sender.lock().await.send(Message::Text(error_json)).await;
+                    }
+                }
                 Ok(ws_message) => {
                     if let Err(e) = handle_ws_message(
                         ws_message,
@@ -227,6 +307,8 @@ async fn handle_websocket(socket: WebSocket, state: Arc<ServerState>) {
 
     // Cleanup
     heartbeat_handle.abort();
+    goaway_handle.abort();
+    model_event_handle.abort();
     info!("WebSocket connection handler finished: {}", connection_id);
 }
 
@@ -250,6 +332,10 @@ async fn handle_ws_message(
 
             // Convert chat messages to prompt
             let prompt = format_chat_messages(&data.messages);
+            let fingerprint = Some(system_fingerprint(
+                &data.model,
+                backend.lock().await.get_backend_type(),
+            ));
 
             let inference_params = InferenceParams {
                 max_tokens: data.max_tokens,
@@ -258,7 +344,11 @@ async fn handle_ws_message(
                 top_p: data.top_p,
                 stream: true, // Always stream for WebSocket
                 stop_sequences: data.stop.unwrap_or_default(),
-                seed: None,
+                seed: data.seed,
+                stop_token_ids: vec![],
+                ignore_eos: false,
+                skip_special_tokens: None,
+                prompt_token_ids: None,
             };
 
             // Create streaming session
@@ -270,23 +360,31 @@ async fn handle_ws_message(
             let sender_clone = sender.clone();
             let request_id = id.clone();
             let model_name = data.model.clone();
+            // Drains wait for the stream to finish
+            let in_flight = state.operations.track_stream();
 
             // Spawn streaming task
             tokio::spawn(async move {
+                let _in_flight = in_flight;
+
                 // Send initial chunk with role
                 let initial_chunk = ChatCompletionChunk {
                     id: request_id.clone(),
                     object: "chat.completion.chunk".to_string(),
                     created: chrono::Utc::now().timestamp(),
                     model: model_name.clone(),
+                    system_fingerprint: fingerprint.clone(),
                     choices: vec![ChatChunkChoice {
                         index: 0,
                         delta: ChatDelta {
                             role: Some("assistant".to_string()),
                             content: None,
+                            tool_calls: None,
                         },
+                        logprobs: None,
                         finish_reason: None,
                     }],
+                    usage: None,
                 };
 
                 let initial_ws_msg = WSMessage::ChatChunk {
@@ -312,14 +410,18 @@ async fn handle_ws_message(
                                     object: "chat.completion.chunk".to_string(),
                                     created: chrono::Utc::now().timestamp(),
                                     model: model_name.clone(),
+                                    system_fingerprint: fingerprint.clone(),
                                     choices: vec![ChatChunkChoice {
                                         index: 0,
                                         delta: ChatDelta {
                                             role: None,
                                             content: Some(streaming_token.content),
+                                            tool_calls: None,
                                         },
+                                        logprobs: None,
                                         finish_reason: None,
                                     }],
+                                    usage: None,
                                 };
 
                                 let ws_msg = WSMessage::ChatChunk {
@@ -367,14 +469,18 @@ async fn handle_ws_message(
                     object: "chat.completion.chunk".to_string(),
                     created: chrono::Utc::now().timestamp(),
                     model: model_name,
+                    system_fingerprint: fingerprint,
                     choices: vec![ChatChunkChoice {
                         index: 0,
                         delta: ChatDelta {
                             role: None,
                             content: None,
+                            tool_calls: None,
                         },
+                        logprobs: None,
                         finish_reason: Some("stop".to_string()),
                     }],
+                    usage: None,
                 };
 
                 let final_ws_msg = WSMessage::ChatChunk {
@@ -558,19 +664,29 @@ async fn get_or_load_backend_for_ws(
 
     // Check if we have a loaded backend matching the model
     if let Some(ref loaded_model) = state.loaded_model {
-        if loaded_model == model_name {
+        if loaded_model == model_name && !state.loaded_models.startup_unloaded() {
             if let Some(ref backend) = state.backend {
                 return Ok(backend.inner().clone());
             }
         }
     }
+    if let Some(backend) = state.loaded_models.get(model_name).await {
+        return Ok(backend.inner().clone());
+    }
 
     // Load new backend for this model
-    let model_info = state
+    let mut model_info = state
         .model_manager
         .resolve_model(model_name)
         .await
         .map_err(|e| InfernoError::WebSocket(format!("Model resolution failed: {}", e)))?;
+    let policy = VerificationPolicy::from_config(state.config.model_security.as_ref())
+        .map_err(|e| InfernoError::WebSocket(format!("Invalid model security config: {}", e)))?;
+    state
+        .model_manager
+        .verify_for_load(&mut model_info, &policy)
+        .await
+        .map_err(|e| InfernoError::WebSocket(format!("Model verification failed: {}", e)))?;
 
     let backend_type =
         crate::backends::BackendType::from_model_path(&model_info.path).ok_or_else(|| {
@@ -9,18 +9,22 @@ use crate::{
     ai_features::sampling::{Sampler, SamplingConfig, SamplingStrategy},
     ai_features::streaming::{StreamConfig, StreamToken, create_stream_channel},
     backends::{
-        BackendConfig, BackendType, InferenceBackend, InferenceMetrics, InferenceParams,
-        TokenStream,
+        BackendConfig, BackendType, HiddenStates, InferenceBackend, InferenceMetrics,
+        InferenceParams, LogitCandidate, LogitStep, LogitTrace, RenderedTemplate, ScoredText,
+        TokenLogprob, TokenStream,
     },
     models::ModelInfo,
 };
 use anyhow::Result;
 use async_stream::stream;
 use llama_cpp_2::{
-    context::{LlamaContext, params::LlamaContextParams},
+    context::{
+        LlamaContext,
+        params::{LlamaContextParams, LlamaPoolingType},
+    },
     llama_backend::LlamaBackend,
     llama_batch::LlamaBatch,
-    model::{AddBos, LlamaModel, Special, params::LlamaModelParams},
+    model::{AddBos, LlamaChatMessage, LlamaModel, Special, params::LlamaModelParams},
     sampling::LlamaSampler,
     token::LlamaToken,
 };
@@ -119,6 +123,22 @@ impl GgufBackend {
         Ok(token_ids)
     }
 
+    /// Caller-supplied token IDs, checked against the vocabulary first since
+    /// llama.cpp does not bounds-check the IDs it is given
+    fn prompt_tokens(
+        model: &LlamaModel,
+        ids: &[u32],
+    ) -> std::result::Result<Vec<LlamaToken>, InfernoError> {
+        let n_vocab = model.n_vocab().max(0) as u32;
+        if let Some(id) = ids.iter().find(|&&id| id >= n_vocab) {
+            return Err(InfernoError::Backend(format!(
+                "Token ID {} is outside the {}-token vocabulary",
+                id, n_vocab
+            )));
+        }
+        Ok(ids.iter().map(|&id| LlamaToken(id as i32)).collect())
+    }
+
     async fn real_detokenize(&self, tokens: &[i32]) -> Result<String> {
         let model = self
             .model
@@ -192,6 +212,15 @@ impl GgufBackend {
         let top_p = params.top_p;
         let seed = params.seed;
         let stop_sequences = params.stop_sequences.clone();
+        let stop_token_ids = params.stop_token_ids.clone();
+        let ignore_eos = params.ignore_eos;
+        let prompt_token_ids = params.prompt_token_ids.clone();
+        // llama.cpp renders special tokens unless asked for plain text
+        let special = if params.skip_special_tokens == Some(true) {
+            Special::Plaintext
+        } else {
+            Special::Tokenize
+        };
 
         // Perform inference in spawn_blocking since LlamaContext is !Send
         let response = tokio::task::spawn_blocking(move || {
@@ -204,10 +233,13 @@ impl GgufBackend {
                 .new_context(&backend, ctx_params)
                 .map_err(|e| InfernoError::Backend(format!("Failed to create context: {}", e)))?;
 
-            // Tokenize input
-            let input_tokens = model
-                .str_to_token(&input_str, AddBos::Always)
-                .map_err(|e| InfernoError::Backend(format!("Failed to tokenize: {}", e)))?;
+            // Tokenize input, unless the caller supplied the token IDs
+            let input_tokens = match &prompt_token_ids {
+                Some(ids) => GgufBackend::prompt_tokens(&model, ids)?,
+                None => model
+                    .str_to_token(&input_str, AddBos::Always)
+                    .map_err(|e| InfernoError::Backend(format!("Failed to tokenize: {}", e)))?,
+            };
 
             debug!("📝 Tokenized {} tokens from input", input_tokens.len());
 
@@ -300,16 +332,16 @@ impl GgufBackend {
                 })?;
 
                 // Check for end of sequence - use model's token methods
-                if next_token == model.token_eos().0 {
+                if (!ignore_eos && next_token == model.token_eos().0)
+                    || stop_token_ids.contains(&(next_token as u32))
+                {
                     debug!("🏁 End of generation token encountered");
                     break;
                 }
 
                 // Accumulate text and check stop sequences before committing token to output
                 if !stop_sequences.is_empty() {
-                    if let Ok(tok_str) =
-                        model.token_to_str(LlamaToken(next_token), Special::Tokenize)
-                    {
+                    if let Ok(tok_str) = model.token_to_str(LlamaToken(next_token), special) {
                         generated_text.push_str(&tok_str);
                         if stop_sequences.iter().any(|s| generated_text.contains(s)) {
                             debug!("Stop sequence matched, stopping generation");
@@ -343,7 +375,7 @@ impl GgufBackend {
             let llama_tokens: Vec<LlamaToken> =
                 output_tokens.iter().map(|&t| LlamaToken(t)).collect();
             let response = model
-                .tokens_to_str(&llama_tokens, Special::Tokenize)
+                .tokens_to_str(&llama_tokens, special)
                 .map_err(|e| InfernoError::Backend(format!("Failed to detokenize: {}", e)))?;
 
             debug!("✅ Generated {} tokens via Metal GPU", output_tokens.len());
@@ -383,6 +415,15 @@ impl GgufBackend {
         let top_p = params.top_p;
         let seed = params.seed;
         let stop_sequences = params.stop_sequences.clone();
+        let stop_token_ids = params.stop_token_ids.clone();
+        let ignore_eos = params.ignore_eos;
+        let prompt_token_ids = params.prompt_token_ids.clone();
+        // llama.cpp renders special tokens unless asked for plain text
+        let special = if params.skip_special_tokens == Some(true) {
+            Special::Plaintext
+        } else {
+            Special::Tokenize
+        };
 
         // Create streaming channel
         let stream_config = StreamConfig {
@@ -414,20 +455,25 @@ impl GgufBackend {
                 }
             };
 
-            // Tokenize input
-            let input_tokens =
-                match model.str_to_token(&input_str, llama_cpp_2::model::AddBos::Always) {
-                    Ok(tokens) => tokens,
-                    Err(e) => {
-                        let _ = tx.blocking_send(StreamToken {
-                            content: format!("Error: Tokenization failed: {}", e),
-                            sequence: 0,
-                            is_valid: false,
-                            timestamp_ms: Some(start_time.elapsed().as_millis() as u64),
-                        });
-                        return;
-                    }
-                };
+            // Tokenize input, unless the caller supplied the token IDs
+            let tokenized = match &prompt_token_ids {
+                Some(ids) => GgufBackend::prompt_tokens(&model, ids).map_err(|e| e.to_string()),
+                None => model
+                    .str_to_token(&input_str, llama_cpp_2::model::AddBos::Always)
+                    .map_err(|e| format!("Tokenization failed: {}", e)),
+            };
+            let input_tokens = match tokenized {
+                Ok(tokens) => tokens,
+                Err(e) => {
+                    let _ = tx.blocking_send(StreamToken {
+                        content: format!("Error: {}", e),
+                        sequence: 0,
+                        is_valid: false,
+                        timestamp_ms: Some(start_time.elapsed().as_millis() as u64),
+                    });
+                    return;
+                }
+            };
 
             debug!("📝 Tokenized {} tokens from input", input_tokens.len());
 
@@ -541,16 +587,15 @@ impl GgufBackend {
                 };
 
                 // Check for end of sequence
-                if next_token == model.token_eos().0 {
+                if (!ignore_eos && next_token == model.token_eos().0)
+                    || stop_token_ids.contains(&(next_token as u32))
+                {
                     debug!("🏁 End of generation token encountered");
                     break;
                 }
 
                 // Detokenize immediately and send
-                match model.token_to_str(
-                    llama_cpp_2::token::LlamaToken(next_token),
-                    llama_cpp_2::model::Special::Tokenize,
-                ) {
+                match model.token_to_str(llama_cpp_2::token::LlamaToken(next_token), special) {
                     Ok(token_str) => {
                         // Check stop sequences on accumulated text
                         if !stop_sequences.is_empty() {
@@ -650,6 +695,462 @@ impl GgufBackend {
         }
         exps.iter().map(|&e| e / sum).collect()
     }
+
+    /// Log-softmax of `logits` evaluated at `token`
+    fn token_logprob(logits: &[f32], token: usize) -> f32 {
+        let max_logit = logits.iter().copied().fold(f32::NEG_INFINITY, f32::max);
+        let log_sum: f32 = logits
+            .iter()
+            .map(|&l| (l - max_logit).exp())
+            .sum::<f32>()
+            .ln();
+        logits.get(token).copied().unwrap_or(f32::NEG_INFINITY) - max_logit - log_sum
+    }
+
+    async fn score_continuation(&self, context: &str, continuation: &str) -> Result<ScoredText> {
+        let model = self
+            .model
+            .as_ref()
+            .ok_or_else(|| InfernoError::Backend("Model not loaded".to_string()))?
+            .clone();
+
+        let backend = self
+            .backend
+            .as_ref()
+            .ok_or_else(|| InfernoError::Backend("Backend not initialized".to_string()))?
+            .clone();
+
+        let context_str = context.to_string();
+        let continuation_str = continuation.to_string();
+        let context_size = self.config.context_size;
+
+        // One forward pass over context + continuation; the logits at each
+        // position give the distribution over the token that follows it
+        let scored = tokio::task::spawn_blocking(move || {
+            // The whole sequence is decoded as one batch
+            let ctx_params = LlamaContextParams::default()
+                .with_n_ctx(NonZeroU32::new(context_size))
+                .with_n_batch(context_size);
+
+            let mut ctx = model
+                .new_context(&backend, ctx_params)
+                .map_err(|e| InfernoError::Backend(format!("Failed to create context: {}", e)))?;
+
+            // BOS goes on the context so even an empty context conditions the
+            // first continuation token
+            let context_tokens = model
+                .str_to_token(&context_str, AddBos::Always)
+                .map_err(|e| InfernoError::Backend(format!("Failed to tokenize: {}", e)))?;
+            let continuation_tokens = model
+                .str_to_token(&continuation_str, AddBos::Never)
+                .map_err(|e| InfernoError::Backend(format!("Failed to tokenize: {}", e)))?;
+
+            let total = context_tokens.len() + continuation_tokens.len();
+            let n_ctx = ctx.n_ctx() as usize;
+            if total > n_ctx {
+                return Err(InfernoError::Backend(format!(
+                    "Text is {} tokens, which does not fit the {}-token context window",
+                    total, n_ctx
+                )));
+            }
+            if continuation_tokens.is_empty() {
+                return Ok(ScoredText {
+                    context_tokens: context_tokens.len() as u32,
+                    tokens: Vec::new(),
+                });
+            }
+
+            let mut batch = LlamaBatch::new(total, 1);
+            let first_scored = context_tokens.len() - 1;
+            for (i, token) in context_tokens
+                .iter()
+                .chain(continuation_tokens.iter())
+                .enumerate()
+            {
+                // Only positions that predict a continuation token need logits
+                let wants_logits = i >= first_scored && i < total - 1;
+                batch
+                    .add(*token, i as i32, &[0], wants_logits)
+                    .map_err(|e| {
+                        InfernoError::Backend(format!("Failed to add token to batch: {}", e))
+                    })?;
+            }
+
+            ctx.decode(&mut batch)
+                .map_err(|e| InfernoError::Backend(format!("Failed to decode batch: {}", e)))?;
+
+            let tokens = continuation_tokens
+                .iter()
+                .enumerate()
+                .map(|(i, token)| {
+                    let logits = ctx.get_logits_ith((first_scored + i) as i32);
+                    TokenLogprob {
+                        token: model
+                            .token_to_str(*token, Special::Tokenize)
+                            .unwrap_or_else(|_| format!("[UNK_{}]", token.0)),
+                        logprob: GgufBackend::token_logprob(logits, token.0 as usize),
+                    }
+                })
+                .collect();
+
+            Ok::<ScoredText, InfernoError>(ScoredText {
+                context_tokens: context_tokens.len() as u32,
+                tokens,
+            })
+        })
+        .await
+        .map_err(|e| InfernoError::Backend(format!("Scoring task failed: {}", e)))??;
+
+        Ok(scored)
+    }
+
+    /// A numeric `<arch>.<key>` metadata value
+    fn architecture_metadata(model: &LlamaModel, key: &str) -> Option<u32> {
+        let architecture = model.meta_val_str("general.architecture").ok()?;
+        model
+            .meta_val_str(&format!("{}.{}", architecture, key))
+            .ok()?
+            .parse()
+            .ok()
+    }
+
+    /// Number of transformer blocks, from the `<arch>.block_count` metadata
+    fn block_count(model: &LlamaModel) -> Option<u32> {
+        GgufBackend::architecture_metadata(model, "block_count")
+    }
+
+    /// Run each (query, candidate) pair through a cross-encoder and return
+    /// its classifier outputs. Pairs are laid out the way llama.cpp's
+    /// reranker expects: `BOS query EOS SEP candidate EOS`.
+    async fn rank_with_classifier(&self, pairs: &[(String, String)]) -> Result<Vec<Vec<f32>>> {
+        let model = self
+            .model
+            .as_ref()
+            .ok_or_else(|| InfernoError::Backend("Model not loaded".to_string()))?
+            .clone();
+
+        let backend = self
+            .backend
+            .as_ref()
+            .ok_or_else(|| InfernoError::Backend("Backend not initialized".to_string()))?
+            .clone();
+
+        // Converted rerankers record rank pooling (4); running rank pooling
+        // on a model without a classifier head aborts inside llama.cpp
+        if GgufBackend::architecture_metadata(&model, "pooling_type") != Some(4) {
+            return Err(InfernoError::Backend(
+                "Model is not a cross-encoder: its metadata does not declare rank pooling"
+                    .to_string(),
+            )
+            .into());
+        }
+
+        let pairs = pairs.to_vec();
+        let context_size = self.config.context_size;
+
+        let outputs = tokio::task::spawn_blocking(move || {
+            // Encoders attend both ways, so a pair must fit one micro-batch
+            let ctx_params = LlamaContextParams::default()
+                .with_n_ctx(NonZeroU32::new(context_size))
+                .with_n_batch(context_size)
+                .with_n_ubatch(context_size)
+                .with_embeddings(true);
+
+            let mut ctx = model
+                .new_context(&backend, ctx_params)
+                .map_err(|e| InfernoError::Backend(format!("Failed to create context: {}", e)))?;
+            let n_ctx = ctx.n_ctx() as usize;
+
+            let eos = model.token_eos();
+            let sep = model
+                .meta_val_str("tokenizer.ggml.seperator_token_id")
+                .ok()
+                .and_then(|id| id.parse().ok())
+                .map(LlamaToken);
+
+            let mut outputs = Vec::with_capacity(pairs.len());
+            for (index, (query, candidate)) in pairs.iter().enumerate() {
+                let mut tokens = model
+                    .str_to_token(query, AddBos::Always)
+                    .map_err(|e| InfernoError::Backend(format!("Failed to tokenize: {}", e)))?;
+                tokens.push(eos);
+                tokens.extend(sep);
+                tokens.extend(
+                    model
+                        .str_to_token(candidate, AddBos::Never)
+                        .map_err(|e| InfernoError::Backend(format!("Failed to tokenize: {}", e)))?,
+                );
+                tokens.push(eos);
+
+                if tokens.len() > n_ctx {
+                    return Err(InfernoError::Backend(format!(
+                        "Pair {} is {} tokens, which does not fit the {}-token context window",
+                        index,
+                        tokens.len(),
+                        n_ctx
+                    )));
+                }
+
+                ctx.clear_kv_cache();
+                let mut batch = LlamaBatch::new(tokens.len(), 1);
+                for (i, token) in tokens.iter().enumerate() {
+                    batch.add(*token, i as i32, &[0], true).map_err(|e| {
+                        InfernoError::Backend(format!("Failed to add token to batch: {}", e))
+                    })?;
+                }
+                ctx.decode(&mut batch)
+                    .map_err(|e| InfernoError::Backend(format!("Failed to decode batch: {}", e)))?;
+
+                let output = ctx.embeddings_seq_ith(0).map_err(|e| {
+                    InfernoError::Backend(format!("Failed to read classifier output: {}", e))
+                })?;
+                outputs.push(output.to_vec());
+            }
+
+            Ok::<Vec<Vec<f32>>, InfernoError>(outputs)
+        })
+        .await
+        .map_err(|e| InfernoError::Backend(format!("Ranking task failed: {}", e)))??;
+
+        Ok(outputs)
+    }
+
+    /// One forward pass over `input` with embeddings output on and pooling
+    /// off, so llama.cpp hands back each token's final hidden state (after
+    /// the output norm, before the LM head)
+    async fn extract_hidden_states(&self, input: &str) -> Result<HiddenStates> {
+        let model = self
+            .model
+            .as_ref()
+            .ok_or_else(|| InfernoError::Backend("Model not loaded".to_string()))?
+            .clone();
+
+        let backend = self
+            .backend
+            .as_ref()
+            .ok_or_else(|| InfernoError::Backend("Backend not initialized".to_string()))?
+            .clone();
+
+        let input_str = input.to_string();
+        let context_size = self.config.context_size;
+
+        let states = tokio::task::spawn_blocking(move || {
+            let ctx_params = LlamaContextParams::default()
+                .with_n_ctx(NonZeroU32::new(context_size))
+                .with_n_batch(context_size)
+                .with_embeddings(true)
+                .with_pooling_type(LlamaPoolingType::None);
+
+            let mut ctx = model
+                .new_context(&backend, ctx_params)
+                .map_err(|e| InfernoError::Backend(format!("Failed to create context: {}", e)))?;
+
+            let tokens = model
+                .str_to_token(&input_str, AddBos::Always)
+                .map_err(|e| InfernoError::Backend(format!("Failed to tokenize: {}", e)))?;
+
+            let n_ctx = ctx.n_ctx() as usize;
+            if tokens.len() > n_ctx {
+                return Err(InfernoError::Backend(format!(
+                    "Text is {} tokens, which does not fit the {}-token context window",
+                    tokens.len(),
+                    n_ctx
+                )));
+            }
+
+            let mut batch = LlamaBatch::new(tokens.len(), 1);
+            for (i, token) in tokens.iter().enumerate() {
+                batch.add(*token, i as i32, &[0], true).map_err(|e| {
+                    InfernoError::Backend(format!("Failed to add token to batch: {}", e))
+                })?;
+            }
+
+            ctx.decode(&mut batch)
+                .map_err(|e| InfernoError::Backend(format!("Failed to decode batch: {}", e)))?;
+
+            let hidden = (0..tokens.len())
+                .map(|i| {
+                    ctx.embeddings_ith(i as i32)
+                        .map(|state| state.to_vec())
+                        .map_err(|e| {
+                            InfernoError::Backend(format!("Failed to read hidden state: {}", e))
+                        })
+                })
+                .collect::<std::result::Result<Vec<_>, _>>()?;
+
+            Ok::<HiddenStates, InfernoError>(HiddenStates {
+                tokens: hidden,
+                num_layers: GgufBackend::block_count(&model),
+            })
+        })
+        .await
+        .map_err(|e| InfernoError::Backend(format!("Hidden state task failed: {}", e)))??;
+
+        Ok(states)
+    }
+
+    /// Sample like `infer`, keeping the top `top_k` logits of every step
+    async fn trace_generation(
+        &self,
+        input: &str,
+        params: &InferenceParams,
+        top_k: usize,
+    ) -> Result<LogitTrace> {
+        let model = self
+            .model
+            .as_ref()
+            .ok_or_else(|| InfernoError::Backend("Model not loaded".to_string()))?
+            .clone();
+
+        let backend = self
+            .backend
+            .as_ref()
+            .ok_or_else(|| InfernoError::Backend("Backend not initialized".to_string()))?
+            .clone();
+
+        let input_str = input.to_string();
+        let params = params.clone();
+        let context_size = self.config.context_size;
+        let batch_size = self.config.batch_size;
+
+        let trace = tokio::task::spawn_blocking(move || {
+            let ctx_params = LlamaContextParams::default()
+                .with_n_ctx(NonZeroU32::new(context_size))
+                .with_n_batch(batch_size);
+
+            let mut context = model
+                .new_context(&backend, ctx_params)
+                .map_err(|e| InfernoError::Backend(format!("Failed to create context: {}", e)))?;
+
+            let input_tokens = match &params.prompt_token_ids {
+                Some(ids) => GgufBackend::prompt_tokens(&model, ids)?,
+                None => model
+                    .str_to_token(&input_str, AddBos::Always)
+                    .map_err(|e| InfernoError::Backend(format!("Failed to tokenize: {}", e)))?,
+            };
+
+            let n_ctx = context.n_ctx() as usize;
+            if input_tokens.is_empty() || input_tokens.len() >= n_ctx {
+                return Err(InfernoError::Backend(format!(
+                    "Input is {} tokens; it must be at least one and fit the {}-token context window",
+                    input_tokens.len(),
+                    n_ctx
+                )));
+            }
+
+            let mut batch = LlamaBatch::new(n_ctx, 1);
+            for (i, token) in input_tokens.iter().enumerate() {
+                let is_last = i == input_tokens.len() - 1;
+                batch.add(*token, i as i32, &[0], is_last).map_err(|e| {
+                    InfernoError::Backend(format!("Failed to add token to batch: {}", e))
+                })?;
+            }
+            context
+                .decode(&mut batch)
+                .map_err(|e| InfernoError::Backend(format!("Failed to decode batch: {}", e)))?;
+
+            let mut sampler = Sampler::new(SamplingConfig {
+                strategy: if params.temperature.abs() < 0.01 {
+                    SamplingStrategy::Greedy
+                } else {
+                    SamplingStrategy::TopKP
+                },
+                temperature: params.temperature.max(0.1).min(2.0),
+                top_k: params.top_k.max(1),
+                top_p: params.top_p.max(0.0).min(1.0),
+                repeat_penalty: 1.1,
+                seed: params.seed,
+            });
+
+            let special = if params.skip_special_tokens == Some(true) {
+                Special::Plaintext
+            } else {
+                Special::Tokenize
+            };
+            let candidate = |id: i32, logit: f32, logprob: f32| LogitCandidate {
+                id: id as u32,
+                token: model
+                    .token_to_str(LlamaToken(id), special)
+                    .unwrap_or_else(|_| format!("[UNK_{}]", id)),
+                logit,
+                logprob,
+            };
+
+            let mut trace = LogitTrace {
+                prompt_tokens: input_tokens.len() as u32,
+                ..Default::default()
+            };
+            let max_steps = (params.max_tokens as usize).min(n_ctx - input_tokens.len());
+
+            for step in 0..max_steps {
+                let candidates: Vec<_> = context.candidates().collect();
+                let logits: Vec<f32> = candidates.iter().map(|c| c.logit()).collect();
+                let probs = GgufBackend::softmax(&logits);
+                let entropy = -probs
+                    .iter()
+                    .filter(|&&p| p > 0.0)
+                    .map(|&p| p * p.ln())
+                    .sum::<f32>();
+
+                let sampled: Vec<(i32, f32, f32)> = candidates
+                    .iter()
+                    .zip(probs.iter())
+                    .map(|(c, &p)| (c.id().0, c.logit(), p))
+                    .collect();
+                let next_token = sampler.sample_from_candidates(&sampled).ok_or_else(|| {
+                    InfernoError::Backend("No candidates available for sampling".to_string())
+                })?;
+
+                let logprob = |index: usize| probs[index].max(f32::MIN_POSITIVE).ln();
+                let mut ranked: Vec<usize> = (0..candidates.len()).collect();
+                ranked.sort_unstable_by(|&a, &b| logits[b].total_cmp(&logits[a]));
+                let top = ranked
+                    .iter()
+                    .take(top_k)
+                    .map(|&i| candidate(candidates[i].id().0, logits[i], logprob(i)))
+                    .collect();
+                let chosen = candidates
+                    .iter()
+                    .position(|c| c.id().0 == next_token)
+                    .map(|i| candidate(next_token, logits[i], logprob(i)))
+                    .unwrap_or_else(|| candidate(next_token, f32::NEG_INFINITY, f32::NEG_INFINITY));
+
+                trace.steps.push(LogitStep {
+                    chosen,
+                    top,
+                    entropy,
+                });
+
+                if (!params.ignore_eos && next_token == model.token_eos().0)
+                    || params.stop_token_ids.contains(&(next_token as u32))
+                {
+                    trace.stopped = true;
+                    break;
+                }
+
+                batch.clear();
+                batch
+                    .add(
+                        LlamaToken(next_token),
+                        (input_tokens.len() + step) as i32,
+                        &[0],
+                        true,
+                    )
+                    .map_err(|e| {
+                        InfernoError::Backend(format!("Failed to add output token: {}", e))
+                    })?;
+                context.decode(&mut batch).map_err(|e| {
+                    InfernoError::Backend(format!("Failed to decode output token: {}", e))
+                })?;
+            }
+
+            Ok::<LogitTrace, InfernoError>(trace)
+        })
+        .await
+        .map_err(|e| InfernoError::Backend(format!("Logit trace task failed: {}", e)))??;
+
+        Ok(trace)
+    }
 }
 
 #[async_trait::async_trait]
@@ -866,6 +1367,112 @@ impl InferenceBackend for GgufBackend {
         Ok(embeddings)
     }
 
+    fn supports_scoring(&self) -> bool {
+        true
+    }
+
+    async fn score(&mut self, context: &str, continuation: &str) -> Result<ScoredText> {
+        if !self.is_loaded().await {
+            return Err(InfernoError::Backend("Model not loaded".to_string()).into());
+        }
+
+        debug!(
+            "Scoring continuation of length {} against context of length {}",
+            continuation.len(),
+            context.len()
+        );
+        self.score_continuation(context, continuation).await
+    }
+
+    async fn tokenize(&self, text: &str) -> Result<Vec<u32>> {
+        let tokens = self.real_tokenize(text).await?;
+        Ok(tokens.into_iter().map(|token| token as u32).collect())
+    }
+
+    async fn rank_pairs(&mut self, pairs: &[(String, String)]) -> Result<Vec<Vec<f32>>> {
+        if !self.is_loaded().await {
+            return Err(InfernoError::Backend("Model not loaded".to_string()).into());
+        }
+
+        debug!("Ranking {} query/candidate pairs", pairs.len());
+        self.rank_with_classifier(pairs).await
+    }
+
+    async fn hidden_states(&mut self, input: &str) -> Result<HiddenStates> {
+        if !self.is_loaded().await {
+            return Err(InfernoError::Backend("Model not loaded".to_string()).into());
+        }
+
+        debug!(
+            "Extracting hidden states for input of length {}",
+            input.len()
+        );
+        self.extract_hidden_states(input).await
+    }
+
+    async fn trace_logits(
+        &mut self,
+        input: &str,
+        params: &InferenceParams,
+        top_k: usize,
+    ) -> Result<LogitTrace> {
+        if !self.is_loaded().await {
+            return Err(InfernoError::Backend("Model not loaded".to_string()).into());
+        }
+
+        debug!(
+            "Tracing up to {} tokens with the top {} logits per step",
+            params.max_tokens, top_k
+        );
+        self.trace_generation(input, params, top_k).await
+    }
+
+    fn supports_token_input(&self) -> bool {
+        true
+    }
+
+    async fn detokenize(&self, tokens: &[u32]) -> Result<String> {
+        let model = self
+            .model
+            .as_ref()
+            .ok_or_else(|| InfernoError::Backend("Model not loaded".to_string()))?;
+        let tokens: Vec<i32> = GgufBackend::prompt_tokens(model, tokens)?
+            .into_iter()
+            .map(|token| token.0)
+            .collect();
+        self.real_detokenize(&tokens).await
+    }
+
+    async fn apply_chat_template(
+        &self,
+        messages: &[(String, String)],
+        add_generation_prompt: bool,
+    ) -> Result<RenderedTemplate> {
+        let model = self
+            .model
+            .as_ref()
+            .ok_or_else(|| InfernoError::Backend("Model not loaded".to_string()))?;
+
+        // llama.cpp recognises the common template families from the GGUF
+        // `tokenizer.chat_template` metadata rather than running its Jinja
+        let template = model.chat_template(None).map_err(|e| {
+            InfernoError::Backend(format!("Model has no usable chat template: {}", e))
+        })?;
+        let chat = messages
+            .iter()
+            .map(|(role, content)| LlamaChatMessage::new(role.clone(), content.clone()))
+            .collect::<std::result::Result<Vec<_>, _>>()
+            .map_err(|e| InfernoError::Backend(format!("Invalid chat message: {}", e)))?;
+        let prompt = model
+            .apply_chat_template(&template, &chat, add_generation_prompt)
+            .map_err(|e| InfernoError::Backend(format!("Failed to apply chat template: {}", e)))?;
+
+        Ok(RenderedTemplate {
+            prompt,
+            template: template.to_str().unwrap_or_default().to_string(),
+        })
+    }
+
     fn get_backend_type(&self) -> BackendType {
         BackendType::Gguf
     }
@@ -931,6 +1538,7 @@ mod tests {
             checksum: None,
             modified: Utc::now(),
             metadata: std::collections::HashMap::new(),
+            verification: None,
         };
 
         let result = backend.load_model(&model_info).await;
@@ -959,6 +1567,7 @@ mod tests {
             checksum: None,
             modified: Utc::now(),
             metadata: std::collections::HashMap::new(),
+            verification: None,
         };
 
         let result = backend.load_model(&model_info).await;
@@ -992,6 +1601,7 @@ mod tests {
             checksum: None,
             modified: Utc::now(),
             metadata: std::collections::HashMap::new(),
+            verification: None,
         };
 
         let result = backend.load_model(&model_info).await;
@@ -1015,6 +1625,23 @@ mod tests {
         assert!(result.unwrap_err().to_string().contains("Model not loaded"));
     }
 
+    #[tokio::test]
+    async fn test_gguf_score_without_model() {
+        let config = BackendConfig::default();
+        let mut backend = GgufBackend::new(config).expect("Failed to create GgufBackend for test");
+
+        let result = backend.score("The capital of France is", " Paris").await;
+        assert!(result.is_err());
+        assert!(result.unwrap_err().to_string().contains("Model not loaded"));
+    }
+
+    #[test]
+    fn test_gguf_token_logprob() {
+        let logits = [0.0f32, 0.0, 0.0, 0.0];
+        let logprob = GgufBackend::token_logprob(&logits, 2);
+        assert!((logprob - (0.25f32).ln()).abs() < 1e-6);
+    }
+
     #[tokio::test]
     async fn test_gguf_estimate_token_count() {
         let config = BackendConfig::default();
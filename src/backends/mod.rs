@@ -146,6 +146,20 @@ pub struct InferenceParams {
     pub stream: bool,
     pub stop_sequences: Vec<String>,
     pub seed: Option<u64>,
+    /// Token ids that end generation as if they were EOS
+    #[serde(default)]
+    pub stop_token_ids: Vec<u32>,
+    /// Keep generating past the model's EOS token
+    #[serde(default)]
+    pub ignore_eos: bool,
+    /// Strip special tokens from the decoded output (`None` keeps the
+    /// backend's default)
+    #[serde(default)]
+    pub skip_special_tokens: Option<bool>,
+    /// Generate from these token IDs instead of tokenizing the input text;
+    /// only honored by backends whose `supports_token_input` is true
+    #[serde(default)]
+    pub prompt_token_ids: Option<Vec<u32>>,
 }
 
 impl Default for InferenceParams {
@@ -158,6 +172,10 @@ impl Default for InferenceParams {
             stream: false,
             stop_sequences: vec![],
             seed: None,
+            stop_token_ids: vec![],
+            ignore_eos: false,
+            skip_special_tokens: None,
+            prompt_token_ids: None,
         }
     }
 }
@@ -175,6 +193,90 @@ pub struct InferenceMetrics {
 
 pub type TokenStream = Pin<Box<dyn Stream<Item = Result<String, InfernoError>> + Send>>;
 
+/// Log-probability the model assigned to one token
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct TokenLogprob {
+    pub token: String,
+    /// Natural-log probability of the token given everything before it
+    pub logprob: f32,
+}
+
+/// Per-token log-probabilities of a continuation given its context
+#[derive(Debug, Clone, Default, Serialize, Deserialize)]
+pub struct ScoredText {
+    /// Tokens in the conditioning context, including any BOS token
+    pub context_tokens: u32,
+    /// Scored continuation tokens, in order
+    pub tokens: Vec<TokenLogprob>,
+}
+
+impl ScoredText {
+    /// Total log-likelihood of the continuation
+    pub fn log_likelihood(&self) -> f64 {
+        self.tokens.iter().map(|t| t.logprob as f64).sum()
+    }
+
+    /// `exp(-mean logprob)`, or `None` when nothing was scored
+    pub fn perplexity(&self) -> Option<f64> {
+        if self.tokens.is_empty() {
+            return None;
+        }
+        Some((-self.log_likelihood() / self.tokens.len() as f64).exp())
+    }
+}
+
+/// A token the model could produce at one step, with its scores
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct LogitCandidate {
+    pub id: u32,
+    pub token: String,
+    /// Raw, unnormalized logit
+    pub logit: f32,
+    /// Log-softmax of the logit over the whole vocabulary
+    pub logprob: f32,
+}
+
+/// The model's output distribution at one generation step
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct LogitStep {
+    /// The token that was sampled
+    pub chosen: LogitCandidate,
+    /// Highest-logit candidates, best first
+    pub top: Vec<LogitCandidate>,
+    /// Entropy of the distribution in nats; high values mean the model was
+    /// unsure
+    pub entropy: f32,
+}
+
+/// Step-by-step record of a generation's logits
+#[derive(Debug, Clone, Default, Serialize, Deserialize)]
+pub struct LogitTrace {
+    /// Tokens in the prompt, including any BOS token
+    pub prompt_tokens: u32,
+    pub steps: Vec<LogitStep>,
+    /// Whether generation ended on EOS or a stop token rather than the step
+    /// limit
+    pub stopped: bool,
+}
+
+/// Final-layer hidden states of every token of an input
+#[derive(Debug, Clone, Default, Serialize, Deserialize)]
+pub struct HiddenStates {
+    /// One vector per input token, in order, including any BOS token
+    pub tokens: Vec<Vec<f32>>,
+    /// Transformer blocks in the model, when its metadata records them
+    pub num_layers: Option<u32>,
+}
+
+/// A message list rendered through a model's own chat template
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct RenderedTemplate {
+    /// The prompt text the template produces
+    pub prompt: String,
+    /// Source of the template that was applied
+    pub template: String,
+}
+
 #[async_trait::async_trait]
 pub trait InferenceBackend: Send + Sync {
     async fn load_model(&mut self, model_info: &ModelInfo) -> Result<()>;
@@ -186,6 +288,92 @@ pub trait InferenceBackend: Send + Sync {
     async fn infer_stream(&mut self, input: &str, params: &InferenceParams) -> Result<TokenStream>;
     async fn get_embeddings(&mut self, input: &str) -> Result<Vec<f32>>;
 
+    /// Whether `score` is implemented; backends without token probabilities
+    /// keep the defaults for both
+    fn supports_scoring(&self) -> bool {
+        false
+    }
+
+    /// Score `continuation` as a follow-on to `context` without generating
+    async fn score(&mut self, context: &str, continuation: &str) -> Result<ScoredText> {
+        Err(InfernoError::Backend(format!(
+            "The {} backend does not support log-likelihood scoring",
+            self.get_backend_type()
+        ))
+        .into())
+    }
+
+    /// Token IDs of `text` as the loaded model would see it in a prompt
+    async fn tokenize(&self, text: &str) -> Result<Vec<u32>> {
+        Err(InfernoError::Backend(format!(
+            "The {} backend does not expose its tokenizer",
+            self.get_backend_type()
+        ))
+        .into())
+    }
+
+    /// Generate up to `params.max_tokens` tokens, recording the `top_k`
+    /// highest logits at every step
+    async fn trace_logits(
+        &mut self,
+        input: &str,
+        params: &InferenceParams,
+        top_k: usize,
+    ) -> Result<LogitTrace> {
+        Err(InfernoError::Backend(format!(
+            "The {} backend does not expose its logits",
+            self.get_backend_type()
+        ))
+        .into())
+    }
+
+    /// Classifier outputs of a cross-encoder for each (query, candidate)
+    /// pair; relevance models have a single output per pair
+    async fn rank_pairs(&mut self, pairs: &[(String, String)]) -> Result<Vec<Vec<f32>>> {
+        Err(InfernoError::Backend(format!(
+            "The {} backend does not support cross-encoder scoring",
+            self.get_backend_type()
+        ))
+        .into())
+    }
+
+    /// Hidden states the last transformer layer produces for `input`
+    async fn hidden_states(&mut self, input: &str) -> Result<HiddenStates> {
+        Err(InfernoError::Backend(format!(
+            "The {} backend does not expose hidden states",
+            self.get_backend_type()
+        ))
+        .into())
+    }
+
+    /// Whether generation honors `InferenceParams::prompt_token_ids`
+    fn supports_token_input(&self) -> bool {
+        false
+    }
+
+    /// Text of `tokens` under the loaded model's vocabulary
+    async fn detokenize(&self, tokens: &[u32]) -> Result<String> {
+        Err(InfernoError::Backend(format!(
+            "The {} backend does not expose its tokenizer",
+            self.get_backend_type()
+        ))
+        .into())
+    }
+
+    /// Render `(role, content)` messages through the model's chat template,
+    /// ending with the assistant header when `add_generation_prompt` is set
+    async fn apply_chat_template(
+        &self,
+        messages: &[(String, String)],
+        add_generation_prompt: bool,
+    ) -> Result<RenderedTemplate> {
+        Err(InfernoError::Backend(format!(
+            "The {} backend does not support chat templates",
+            self.get_backend_type()
+        ))
+        .into())
+    }
+
     fn get_backend_type(&self) -> BackendType;
     fn get_metrics(&self) -> Option<InferenceMetrics>;
 }
@@ -280,10 +468,57 @@ impl Backend {
         self.backend_impl.get_embeddings(input).await
     }
 
+    pub async fn score(&mut self, context: &str, continuation: &str) -> Result<ScoredText> {
+        self.backend_impl.score(context, continuation).await
+    }
+
+    pub async fn rank_pairs(&mut self, pairs: &[(String, String)]) -> Result<Vec<Vec<f32>>> {
+        self.backend_impl.rank_pairs(pairs).await
+    }
+
+    pub async fn hidden_states(&mut self, input: &str) -> Result<HiddenStates> {
+        self.backend_impl.hidden_states(input).await
+    }
+
+    pub async fn trace_logits(
+        &mut self,
+        input: &str,
+        params: &InferenceParams,
+        top_k: usize,
+    ) -> Result<LogitTrace> {
+        self.backend_impl.trace_logits(input, params, top_k).await
+    }
+
+    pub async fn tokenize(&self, text: &str) -> Result<Vec<u32>> {
+        self.backend_impl.tokenize(text).await
+    }
+
+    pub async fn detokenize(&self, tokens: &[u32]) -> Result<String> {
+        self.backend_impl.detokenize(tokens).await
+    }
+
+    pub async fn apply_chat_template(
+        &self,
+        messages: &[(String, String)],
+        add_generation_prompt: bool,
+    ) -> Result<RenderedTemplate> {
+        self.backend_impl
+            .apply_chat_template(messages, add_generation_prompt)
+            .await
+    }
+
     pub fn get_backend_type(&self) -> BackendType {
         self.backend_impl.get_backend_type()
     }
 
+    pub fn supports_scoring(&self) -> bool {
+        self.backend_impl.supports_scoring()
+    }
+
+    pub fn supports_token_input(&self) -> bool {
+        self.backend_impl.supports_token_input()
+    }
+
     pub fn get_metrics(&self) -> Option<InferenceMetrics> {
         self.backend_impl.get_metrics()
     }
@@ -294,15 +529,21 @@ impl Backend {
 pub struct BackendHandle {
     inner: Arc<Mutex<Backend>>,
     backend_type: BackendType,
+    supports_scoring: bool,
+    supports_token_input: bool,
 }
 
 impl BackendHandle {
     /// Create a new backend handle from a backend instance
     pub fn new(backend: Backend) -> Self {
         let backend_type = backend.get_backend_type();
+        let supports_scoring = backend.supports_scoring();
+        let supports_token_input = backend.supports_token_input();
         Self {
             inner: Arc::new(Mutex::new(backend)),
             backend_type,
+            supports_scoring,
+            supports_token_input,
         }
     }
 
@@ -354,11 +595,74 @@ impl BackendHandle {
         backend.get_embeddings(input).await
     }
 
+    /// Log-probabilities of `continuation` given `context`
+    pub async fn score(&self, context: &str, continuation: &str) -> Result<ScoredText> {
+        let mut backend = self.inner.lock().await;
+        backend.score(context, continuation).await
+    }
+
+    /// Cross-encoder outputs for each (query, candidate) pair
+    pub async fn rank_pairs(&self, pairs: &[(String, String)]) -> Result<Vec<Vec<f32>>> {
+        let mut backend = self.inner.lock().await;
+        backend.rank_pairs(pairs).await
+    }
+
+    /// Final-layer hidden states of every token of `input`
+    pub async fn hidden_states(&self, input: &str) -> Result<HiddenStates> {
+        let mut backend = self.inner.lock().await;
+        backend.hidden_states(input).await
+    }
+
+    /// Generate while recording the top logits at every step
+    pub async fn trace_logits(
+        &self,
+        input: &str,
+        params: &InferenceParams,
+        top_k: usize,
+    ) -> Result<LogitTrace> {
+        let mut backend = self.inner.lock().await;
+        backend.trace_logits(input, params, top_k).await
+    }
+
+    /// Token IDs of `text` under the loaded model's tokenizer
+    pub async fn tokenize(&self, text: &str) -> Result<Vec<u32>> {
+        let backend = self.inner.lock().await;
+        backend.tokenize(text).await
+    }
+
+    /// Text of `tokens` under the loaded model's vocabulary
+    pub async fn detokenize(&self, tokens: &[u32]) -> Result<String> {
+        let backend = self.inner.lock().await;
+        backend.detokenize(tokens).await
+    }
+
+    /// Render messages through the loaded model's chat template
+    pub async fn apply_chat_template(
+        &self,
+        messages: &[(String, String)],
+        add_generation_prompt: bool,
+    ) -> Result<RenderedTemplate> {
+        let backend = self.inner.lock().await;
+        backend
+            .apply_chat_template(messages, add_generation_prompt)
+            .await
+    }
+
     /// Get the backend type
     pub fn get_backend_type(&self) -> BackendType {
         self.backend_type
     }
 
+    /// Whether the backend can score text with `score`
+    pub fn supports_scoring(&self) -> bool {
+        self.supports_scoring
+    }
+
+    /// Whether generation can start from token IDs instead of text
+    pub fn supports_token_input(&self) -> bool {
+        self.supports_token_input
+    }
+
     /// Get current metrics from the backend
     pub async fn get_metrics(&self) -> Option<InferenceMetrics> {
         let backend = self.inner.lock().await;
@@ -209,12 +209,16 @@ impl OnnxBackend {
     }
 
     fn detokenize(&self, token_ids: &[u32]) -> Result<String> {
+        self.detokenize_with(token_ids, true)
+    }
+
+    fn detokenize_with(&self, token_ids: &[u32], skip_special_tokens: bool) -> Result<String> {
         let tokenizer = self
             .tokenizer
             .as_ref()
             .ok_or_else(|| anyhow!("No tokenizer available"))?;
         tokenizer
-            .decode(token_ids, true)
+            .decode(token_ids, skip_special_tokens)
             .map_err(|e| anyhow!("Detokenization failed: {}", e))
     }
 
@@ -431,6 +435,7 @@ impl OnnxBackend {
     ) -> Result<Vec<u32>> {
         let mut all_tokens = initial_tokens.clone();
         let mut sampler = Sampler::new(Self::build_sampling_config(params));
+        let skip_special_tokens = params.skip_special_tokens.unwrap_or(true);
 
         for _ in 0..params.max_tokens {
             let logits = Self::forward_pass(session, &all_tokens, input_names)?;
@@ -449,12 +454,11 @@ impl OnnxBackend {
                 None => break,
             };
 
-            // Check for EOS token
-            if let Some(eos_id) = eos_token_id {
-                if next_token as u32 == eos_id {
-                    debug!("EOS token encountered, stopping generation");
-                    break;
-                }
+            // Check for EOS and caller-supplied stop tokens
+            let is_eos = !params.ignore_eos && eos_token_id == Some(next_token as u32);
+            if is_eos || params.stop_token_ids.contains(&(next_token as u32)) {
+                debug!("EOS token encountered, stopping generation");
+                break;
             }
 
             all_tokens.push(next_token as i64);
@@ -466,7 +470,7 @@ impl OnnxBackend {
                         .iter()
                         .map(|&t| t as u32)
                         .collect();
-                    if let Ok(text) = tok.decode(&generated, true) {
+                    if let Ok(text) = tok.decode(&generated, skip_special_tokens) {
                         if params.stop_sequences.iter().any(|stop| text.contains(stop)) {
                             debug!("Stop sequence matched, stopping generation");
                             break;
@@ -639,7 +643,10 @@ impl InferenceBackend for OnnxBackend {
                 let total_time = start_time.elapsed();
                 let completion_tokens = generated_tokens.len() as u32;
 
-                let response = self.detokenize(&generated_tokens)?;
+                let response = self.detokenize_with(
+                    &generated_tokens,
+                    params.skip_special_tokens.unwrap_or(true),
+                )?;
 
                 *metrics.lock().unwrap() = Some(InferenceMetrics {
                     total_tokens: prompt_tokens + completion_tokens,
@@ -734,6 +741,9 @@ impl InferenceBackend for OnnxBackend {
         let max_tokens = params.max_tokens;
         let sampling_config = Self::build_sampling_config(params);
         let stop_sequences = params.stop_sequences.clone();
+        let stop_token_ids = params.stop_token_ids.clone();
+        let ignore_eos = params.ignore_eos;
+        let skip_special_tokens = params.skip_special_tokens.unwrap_or(true);
         let eos_token_id = self.eos_token_id;
 
         let tokenizer = self
@@ -808,18 +818,17 @@ impl InferenceBackend for OnnxBackend {
                     }
                 };
 
-                // Check for EOS token
-                if let Some(eos_id) = eos_token_id {
-                    if next_token as u32 == eos_id {
-                        debug!("EOS token encountered in stream, stopping generation");
-                        break;
-                    }
+                // Check for EOS and caller-supplied stop tokens
+                let is_eos = !ignore_eos && eos_token_id == Some(next_token as u32);
+                if is_eos || stop_token_ids.contains(&(next_token as u32)) {
+                    debug!("EOS token encountered in stream, stopping generation");
+                    break;
                 }
 
                 all_tokens.push(next_token as i64);
                 completion_tokens += 1;
 
-                match tokenizer.decode(&[next_token as u32], true) {
+                match tokenizer.decode(&[next_token as u32], skip_special_tokens) {
                     Ok(token_str) => {
                         generated_text.push_str(&token_str);
 
@@ -972,6 +981,10 @@ impl InferenceBackend for OnnxBackend {
         Ok(embeddings)
     }
 
+    async fn tokenize(&self, text: &str) -> Result<Vec<u32>> {
+        OnnxBackend::tokenize(self, text)
+    }
+
     fn get_backend_type(&self) -> BackendType {
         BackendType::Onnx
     }
@@ -1009,6 +1022,7 @@ mod tests {
             format: "onnx".to_string(),
             checksum: None,
             metadata: std::collections::HashMap::new(),
+            verification: None,
         };
         let result = backend.load_model(&model_info).await;
         assert!(result.is_err());
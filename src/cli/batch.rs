@@ -246,6 +246,10 @@ pub async fn execute(args: BatchArgs, config: &Config) -> Result<()> {
         stream: false, // Batch processing uses non-streaming
         stop_sequences: vec![],
         seed: None,
+        stop_token_ids: vec![],
+        ignore_eos: false,
+        skip_special_tokens: None,
+        prompt_token_ids: None,
     };
 
     // Estimate total items for progress tracking
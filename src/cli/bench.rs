@@ -99,6 +99,10 @@ pub async fn execute(args: BenchArgs, config: &Config) -> Result<()> {
         stream: false,
         stop_sequences: vec![],
         seed: None,
+        stop_token_ids: vec![],
+        ignore_eos: false,
+        skip_special_tokens: None,
+        prompt_token_ids: None,
     };
 
     println!("Benchmark Configuration:");
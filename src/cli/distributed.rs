@@ -337,6 +337,10 @@ async fn benchmark_distributed_inference(
                     stream: false,
                     stop_sequences: vec![],
                     seed: None,
+                    stop_token_ids: vec![],
+                    ignore_eos: false,
+                    skip_special_tokens: None,
+                    prompt_token_ids: None,
                 };
 
                 match distributed_clone.infer(&model_name, &prompt, &params).await {
@@ -481,6 +485,10 @@ async fn test_inference(
         stream,
         stop_sequences: vec![],
         seed: None,
+        stop_token_ids: vec![],
+        ignore_eos: false,
+        skip_special_tokens: None,
+        prompt_token_ids: None,
     };
 
     let start_time = Instant::now();
@@ -0,0 +1,57 @@
+use crate::{api::mcp, cli::serve::build_state, config::Config};
+use anyhow::Result;
+use clap::Args;
+use tokio::{
+    io::{AsyncBufReadExt, AsyncWriteExt, BufReader},
+    sync::mpsc,
+};
+use tracing::info;
+
+#[derive(Args)]
+pub struct McpArgs {
+    #[arg(short, long, help = "Model to load on startup")]
+    pub model: Option<String>,
+}
+
+/// Serve MCP over stdio: one JSON-RPC message per line on stdin, responses
+/// on stdout. Requests run concurrently so a long generation does not hold
+/// up pings or listings; logs go to stderr.
+pub async fn execute(args: McpArgs, config: &Config) -> Result<()> {
+    let state = build_state(config, args.model.as_deref(), false, 0).await?;
+    info!("MCP server listening on stdio");
+
+    // A single writer keeps concurrent responses from interleaving
+    let (tx, mut rx) = mpsc::unbounded_channel::<String>();
+    let writer = tokio::spawn(async move {
+        let mut stdout = tokio::io::stdout();
+        while let Some(line) = rx.recv().await {
+            if stdout.write_all(line.as_bytes()).await.is_err()
+                || stdout.write_all(b"\n").await.is_err()
+                || stdout.flush().await.is_err()
+            {
+                break;
+            }
+        }
+    });
+
+    let mut lines = BufReader::new(tokio::io::stdin()).lines();
+    while let Some(line) = lines.next_line().await? {
+        if line.trim().is_empty() {
+            continue;
+        }
+        let state = state.clone();
+        let tx = tx.clone();
+        tokio::spawn(async move {
+            if let Some(response) = mcp::handle_text(&state, &line).await {
+                let _ = tx.send(response.to_string());
+            }
+        });
+    }
+
+    // stdin closed: let in-flight requests finish writing, then exit
+    drop(tx);
+    let _ = writer.await;
+    info!("MCP client disconnected");
+
+    Ok(())
+}
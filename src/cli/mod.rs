@@ -12,6 +12,7 @@ pub mod enhanced_parser;
 pub mod fuzzy;
 pub mod gpu;
 pub mod help;
+pub mod mcp;
 pub mod metrics;
 pub mod model_versioning;
 pub mod models;
@@ -54,6 +55,9 @@ pub enum Commands {
     #[command(about = "Start local HTTP API server")]
     Serve(serve::ServeArgs),
 
+    #[command(about = "Serve models to MCP clients (desktop agents, editors) over stdio")]
+    Mcp(mcp::McpArgs),
+
     #[command(about = "Manage and list available models")]
     Models(models::ModelsArgs),
 
@@ -1,5 +1,8 @@
 use crate::config::Config;
-use crate::models::ModelManager;
+use crate::models::{
+    ModelManager,
+    verification::{self, ChecksumStatus, SignatureStatus, VerificationPolicy},
+};
 use crate::resilience::{RetryConfig, RetryPolicy};
 use anyhow::Result;
 use clap::{Args, Subcommand};
@@ -70,6 +73,30 @@ pub enum ModelsCommand {
 
     #[command(about = "Show usage statistics for local models")]
     Stats,
+
+    #[command(about = "Check a model's SHA-256 and signature against the trust policy")]
+    Verify {
+        #[arg(help = "Model name or path")]
+        model: String,
+    },
+
+    #[command(about = "Sign a model with an Ed25519 key, writing <model>.sig")]
+    Sign {
+        #[arg(help = "Model name or path")]
+        model: String,
+
+        #[arg(
+            long,
+            help = "PKCS#8 Ed25519 private key (PEM), e.g. from `models keygen`"
+        )]
+        key: PathBuf,
+    },
+
+    #[command(about = "Generate an Ed25519 key pair for signing models")]
+    Keygen {
+        #[arg(long, help = "Where to write the private key")]
+        output: PathBuf,
+    },
 }
 
 fn validate_command(command: &ModelsCommand, config: &Config) -> Result<()> {
@@ -113,6 +140,24 @@ fn validate_command(command: &ModelsCommand, config: &Config) -> Result<()> {
                 anyhow::bail!("Provide at least one tag.");
             }
         }
+        ModelsCommand::Verify { model } => {
+            if model.is_empty() {
+                anyhow::bail!("Model name or path cannot be empty.");
+            }
+        }
+        ModelsCommand::Sign { model, key } => {
+            if model.is_empty() {
+                anyhow::bail!("Model name or path cannot be empty.");
+            }
+            if !key.is_file() {
+                anyhow::bail!("Signing key does not exist: {}", key.display());
+            }
+        }
+        ModelsCommand::Keygen { output } => {
+            if output.exists() {
+                anyhow::bail!("Refusing to overwrite {}", output.display());
+            }
+        }
     }
     Ok(())
 }
@@ -397,6 +442,76 @@ pub async fn execute(args: ModelsArgs, config: &Config) -> Result<()> {
                 );
             }
         }
+
+        ModelsCommand::Verify { model } => {
+            let model_info = model_manager.resolve_model(&model).await?;
+            let policy = VerificationPolicy::from_config(config.model_security.as_ref())?;
+            let verification = model_manager
+                .verify_model(&model_info.path, &policy)
+                .await?;
+
+            println!("Model: {}", model_info.name);
+            println!("  SHA256: {}", verification.sha256);
+            match verification.checksum {
+                ChecksumStatus::Recorded => println!("  Checksum: recorded (first check)"),
+                ChecksumStatus::Matched => println!("  Checksum: ✓ matches recorded digest"),
+                ChecksumStatus::Mismatch => println!(
+                    "  Checksum: ✗ recorded digest was {}",
+                    verification.expected_sha256.as_deref().unwrap_or("unknown")
+                ),
+            }
+            match verification.signature {
+                SignatureStatus::Unsigned => println!("  Signature: none"),
+                SignatureStatus::Valid => println!(
+                    "  Signature: ✓ trusted key {}",
+                    verification.key_id.as_deref().unwrap_or("unknown")
+                ),
+                SignatureStatus::Untrusted => println!("  Signature: ✗ not from a trusted key"),
+            }
+            match &verification.reason {
+                None => println!("✓ Model can be loaded"),
+                Some(reason) => {
+                    println!("✗ Model would be refused: {}", reason);
+                    std::process::exit(1);
+                }
+            }
+        }
+
+        ModelsCommand::Sign { model, key } => {
+            let model_info = model_manager.resolve_model(&model).await?;
+            let private_key = tokio::fs::read_to_string(&key).await?;
+
+            let sha256 = model_manager.compute_checksum(&model_info.path).await?;
+            let signature = verification::sign_digest(&sha256, &private_key)?;
+            let signature_path = verification::signature_path(&model_info.path);
+            tokio::fs::write(&signature_path, format!("{}\n", signature)).await?;
+            model_manager
+                .record_digest(&model_info.path, &sha256)
+                .await?;
+
+            let public_key = verification::public_key_of(&private_key)?;
+            println!("Signed {} (SHA256 {})", model_info.name, sha256);
+            println!("Signature: {}", signature_path.display());
+            println!(
+                "Key: {} (id {})",
+                public_key,
+                verification::parse_public_key(&public_key)?.id
+            );
+        }
+
+        ModelsCommand::Keygen { output } => {
+            let (private_key, public_key) = verification::generate_signing_key()?;
+            tokio::fs::write(&output, private_key).await?;
+            #[cfg(unix)]
+            {
+                use std::os::unix::fs::PermissionsExt;
+                tokio::fs::set_permissions(&output, std::fs::Permissions::from_mode(0o600)).await?;
+            }
+
+            println!("Private key: {}", output.display());
+            println!("Public key:  {}", public_key);
+            println!("Add the public key to model_security.trusted_keys to trust its signatures.");
+        }
     }
 
     Ok(())
@@ -575,8 +690,10 @@ async fn post_install(manager: &ModelManager, path: &PathBuf) -> Result<()> {
     let valid = manager.validate_model(path).await?;
     if valid {
         println!(" ✓");
-        manager.register_model(path).await?;
+        let sha256 = manager.compute_checksum(path).await?;
+        manager.record_digest(path, &sha256).await?;
         println!("Installed: {}", path.display());
+        println!("SHA256: {}", sha256);
     } else {
         println!(" ✗");
         tokio::fs::remove_file(path).await.ok();
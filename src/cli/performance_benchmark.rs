@@ -430,6 +430,7 @@ async fn run_inference_benchmark(
         format: "gguf".to_string(),
         checksum: None,
         metadata: HashMap::new(),
+        verification: None,
     };
 
     let backend_config = BackendConfig::default();
@@ -445,6 +446,10 @@ async fn run_inference_benchmark(
         stream: false,
         stop_sequences: vec![],
         seed: None,
+        stop_token_ids: vec![],
+        ignore_eos: false,
+        skip_special_tokens: None,
+        prompt_token_ids: None,
     };
 
     let test_prompts = vec![
@@ -578,6 +583,7 @@ async fn run_memory_benchmark(
             format: "gguf".to_string(),
             checksum: None,
             metadata: HashMap::new(),
+            verification: None,
         };
 
         let backend_config = BackendConfig::default();
@@ -604,6 +610,10 @@ async fn run_memory_benchmark(
                 stream: false,
                 stop_sequences: vec![],
                 seed: None,
+                stop_token_ids: vec![],
+                ignore_eos: false,
+                skip_special_tokens: None,
+                prompt_token_ids: None,
             };
 
             for _ in 0..5 {
@@ -708,6 +718,7 @@ async fn run_concurrent_benchmark(
         format: "gguf".to_string(),
         checksum: None,
         metadata: HashMap::new(),
+        verification: None,
     };
 
     let concurrency_levels = vec![1, 2, 4, 8, 16, 32];
@@ -729,6 +740,10 @@ async fn run_concurrent_benchmark(
             stream: false,
             stop_sequences: vec![],
             seed: None,
+            stop_token_ids: vec![],
+            ignore_eos: false,
+            skip_special_tokens: None,
+            prompt_token_ids: None,
         };
 
         let start_time = Instant::now();
@@ -1234,6 +1249,7 @@ async fn memory_profile(
             format: "gguf".to_string(),
             checksum: None,
             metadata: std::collections::HashMap::new(),
+            verification: None,
         };
 
         tracing::info!("Loading model for memory profiling");
@@ -1267,6 +1283,10 @@ async fn memory_profile(
         stream: false,
         stop_sequences: vec![],
         seed: Some(42),
+        stop_token_ids: vec![],
+        ignore_eos: false,
+        skip_special_tokens: None,
+        prompt_token_ids: None,
     };
 
     for cycle in 1..=cycles {
@@ -2,7 +2,7 @@
 use crate::backends::{Backend, BackendType};
 use crate::config::Config;
 use crate::io::{InputFormat, OutputFormat};
-use crate::models::ModelManager;
+use crate::models::{ModelManager, verification::VerificationPolicy};
 use anyhow::Result;
 use clap::Args;
 use futures::StreamExt;
@@ -86,7 +86,11 @@ pub async fn execute(args: RunArgs, config: &Config) -> Result<()> {
     info!("Running inference with model: {}", args.model);
 
     let model_manager = ModelManager::new(&config.models_dir);
-    let model_info = model_manager.resolve_model(&args.model).await?;
+    let mut model_info = model_manager.resolve_model(&args.model).await?;
+    let policy = VerificationPolicy::from_config(config.model_security.as_ref())?;
+    model_manager
+        .verify_for_load(&mut model_info, &policy)
+        .await?;
 
     let backend_type = args
         .backend
@@ -131,6 +135,10 @@ pub async fn execute(args: RunArgs, config: &Config) -> Result<()> {
             stream: false,
             stop_sequences: vec![],
             seed: None,
+            stop_token_ids: vec![],
+            ignore_eos: false,
+            skip_special_tokens: None,
+            prompt_token_ids: None,
         };
 
         let progress = processor
@@ -221,6 +229,10 @@ async fn process_single(backend: &mut Backend, args: &RunArgs, _config: &Config)
         stream: args.stream,
         stop_sequences: vec![],
         seed: None,
+        stop_token_ids: vec![],
+        ignore_eos: false,
+        skip_special_tokens: None,
+        prompt_token_ids: None,
     };
 
     let start = std::time::Instant::now();
@@ -276,6 +288,10 @@ async fn process_batch(backend: &mut Backend, args: &RunArgs, _config: &Config)
         stream: false, // No streaming in batch mode
         stop_sequences: vec![],
         seed: None,
+        stop_token_ids: vec![],
+        ignore_eos: false,
+        skip_special_tokens: None,
+        prompt_token_ids: None,
     };
 
     let mut results = Vec::new();
@@ -1,20 +1,33 @@
 #![allow(dead_code, unused_imports, unused_variables)]
 use crate::{
-    api::{openai, websocket},
+    api::{
+        anthropic, api_keys, async_jobs, audit_events, batches, batching, benchmark,
+        billing_webhooks, budgets, bundles, cancellation, capabilities, chat_template, cluster,
+        completion_batch, cross_encoder, datasets, disk_cache, distillation, envelope, evals,
+        evaluation, extract, files, fine_tuning, flags, gpu_telemetry, health, hidden_states, hub,
+        jwt_auth, kserve, logits, logs, mcp, memory_pressure, model_catalog,
+        model_events::{self, ModelEventType},
+        model_stores, openai, operations, parallel, placement, pricing, profiling, queue, rollout,
+        routing, runtime_config, scheduler, sessions, shadow, signing, speculative, summarize,
+        tenants, tokenize, trace_export, translate, usage, usage_export, verification, version,
+        watchdog, websocket,
+    },
     backends::{BackendHandle, BackendType},
     config::Config,
     distributed::DistributedInference,
     metrics::MetricsCollector,
-    models::ModelManager,
+    models::{ModelManager, verification::VerificationPolicy},
+    optimization::batching::{BatchingConfig, DynamicBatcher},
+    response_cache::ResponseCache,
     upgrade::UpgradeManager,
 };
 use anyhow::Result;
 use axum::{
     Json, Router,
-    extract::State,
+    extract::{DefaultBodyLimit, State},
     http::StatusCode,
     response::IntoResponse,
-    routing::{get, post},
+    routing::{delete, get, post, put},
 };
 use clap::Args;
 use serde_json::json;
@@ -90,12 +103,15 @@ fn is_running_as_root() -> bool {
     unsafe { libc::geteuid() == 0 }
 }
 
-pub async fn execute(args: ServeArgs, config: &Config) -> Result<()> {
-    // Validate arguments before proceeding
-    validate_args(&args)?;
-
-    info!("Starting HTTP server on {}", args.bind);
-
+/// Build the shared state behind the HTTP API: metrics, the model manager, an
+/// optional distributed worker pool or startup model, and the per-feature
+/// stores. The MCP stdio server serves the same state without HTTP.
+pub async fn build_state(
+    config: &Config,
+    model: Option<&str>,
+    distributed_mode: bool,
+    workers: usize,
+) -> Result<Arc<ServerState>> {
     // Initialize metrics collector
     let (metrics_collector, processor) = MetricsCollector::new();
     processor.start();
@@ -104,12 +120,12 @@ pub async fn execute(args: ServeArgs, config: &Config) -> Result<()> {
     let model_manager = Arc::new(ModelManager::new(&config.models_dir));
 
     // Optionally initialize distributed inference
-    let distributed = if args.distributed {
+    let distributed = if distributed_mode {
         info!("Initializing distributed inference with worker pools");
 
         let mut distributed_config = config.distributed.clone();
-        if args.workers > 0 {
-            distributed_config.worker_count = args.workers;
+        if workers > 0 {
+            distributed_config.worker_count = workers;
         }
 
         match DistributedInference::new(
@@ -134,13 +150,18 @@ pub async fn execute(args: ServeArgs, config: &Config) -> Result<()> {
     };
 
     // Optionally load a model on startup (only if not using distributed)
-    let (backend, loaded_model) = if !args.distributed {
-        if let Some(model_name) = &args.model {
+    let model_events = model_events::ModelEventLog::new();
+    let (backend, loaded_model) = if !distributed_mode {
+        if let Some(model_name) = model {
             info!("Loading model on startup: {}", model_name);
             match load_model_on_startup(model_name, &model_manager, config).await {
-                Ok((backend_handle, model_name)) => (Some(backend_handle), Some(model_name)),
+                Ok((backend_handle, model_name)) => {
+                    model_events.publish(ModelEventType::Loaded, &model_name, "startup");
+                    (Some(backend_handle), Some(model_name))
+                }
                 Err(e) => {
                     warn!("Failed to load startup model: {}", e);
+                    model_events.publish_failure(ModelEventType::Loaded, model_name, "startup", &e);
                     (None, None)
                 }
             }
@@ -169,6 +190,13 @@ pub async fn execute(args: ServeArgs, config: &Config) -> Result<()> {
         }
     };
 
+    let batcher = Arc::new(DynamicBatcher::new(BatchingConfig::default()).await?);
+    let response_cache = ResponseCache::new(
+        config.response_cache.clone(),
+        Some(Arc::new(metrics_collector.clone())),
+    )
+    .await?;
+
     // Create shared application state
     let state = Arc::new(ServerState {
         config: config.clone(),
@@ -178,26 +206,579 @@ pub async fn execute(args: ServeArgs, config: &Config) -> Result<()> {
         model_manager: (*model_manager).clone(),
         distributed,
         upgrade_manager,
+        request_queue: Arc::new(queue::RequestQueue::new()),
+        inference_jobs: async_jobs::InferenceJobStore::new(),
+        sessions: sessions::SessionStore::new(),
+        flags: flags::FlagStore::new(),
+        runtime_config: runtime_config::RuntimeConfigStore::new(config),
+        operations: Arc::new(operations::Operations::new()),
+        cluster: cluster::ClusterRegistry::new(),
+        parallel_plans: parallel::ParallelPlanStore::new(),
+        placement: placement::PlacementStore::new(),
+        tenants: tenants::TenantRegistry::new(),
+        audit: audit_events::AuditLog::new(),
+        trace_exporter: trace_export::TraceExporter::new((&config.observability).into()),
+        watchdog: watchdog::Watchdog::new(),
+        gpu_telemetry: gpu_telemetry::GpuTelemetry::new(),
+        memory_pressure: memory_pressure::MemoryPressureMonitor::new(),
+        disk_cache: disk_cache::DiskCache::new(),
+        response_cache,
+        speculative: speculative::SpeculativeRegistry::new(),
+        batcher,
+        model_router: routing::ModelRouter::new(),
+        rollouts: rollout::RolloutManager::new(),
+        shadow: shadow::ShadowManager::new(),
+        evals: evals::EvalStore::new(),
+        fine_tuning: fine_tuning::FineTuningStore::new(config.fine_tuning.max_concurrent_jobs),
+        datasets: datasets::DatasetStore::new(config.fine_tuning.datasets_dir.clone()),
+        files: files::FileStore::new(config.cache_dir.join("files")),
+        batches: batches::BatchStore::new(),
+        distillation: distillation::DistillationStore::new(),
+        model_downloads: hub::ModelDownloadStore::new(),
+        model_stores: model_stores::ModelStoreRegistry::open(&config.cache_dir),
+        model_catalog: model_catalog::ModelCatalog::new(),
+        model_events,
+        loaded_models: openai::LoadedModels::new(),
+        envelope_keys: envelope::EnvelopeKeys::from_env()?,
+        request_signing: signing::RequestSigning::from_env()?,
+        jwt_auth: jwt_auth::JwtAuth::from_env()?,
+        api_keys: api_keys::ApiKeyStore::new(),
+        pricing: pricing::PricingStore::new(),
+        budgets: budgets::BudgetStore::new(),
+        usage: usage::UsageLog::new(),
+        usage_exports: usage_export::UsageExportStore::new(),
+        billing_webhooks: billing_webhooks::WebhookStore::new(),
     });
 
+    tokio::spawn(rollout::run_controller(Arc::clone(&state)));
+    tokio::spawn(watchdog::run(Arc::clone(&state)));
+    tokio::spawn(trace_export::run_exporter(Arc::clone(&state)));
+    tokio::spawn(gpu_telemetry::run_sampler(Arc::clone(&state)));
+    tokio::spawn(memory_pressure::run(Arc::clone(&state)));
+    tokio::spawn(disk_cache::run_enforcer(Arc::clone(&state)));
+    tokio::spawn(model_catalog::run(Arc::clone(&state)));
+    tokio::spawn(billing_webhooks::run_summaries(Arc::clone(&state)));
+
+    Ok(state)
+}
+
+pub async fn execute(args: ServeArgs, config: &Config) -> Result<()> {
+    // Validate arguments before proceeding
+    validate_args(&args)?;
+
+    info!("Starting HTTP server on {}", args.bind);
+
+    let state = build_state(
+        config,
+        args.model.as_deref(),
+        args.distributed,
+        args.workers,
+    )
+    .await?;
+
+    // Generation endpoints have their usage attributed, are refused beyond
+    // the runtime concurrency limit and their tenant's limits, and are
+    // charged to their budgets
+    let limited = ServiceBuilder::new()
+        .layer(axum::middleware::from_fn_with_state(
+            Arc::clone(&state),
+            usage::attribute_usage,
+        ))
+        .layer(axum::middleware::from_fn_with_state(
+            Arc::clone(&state),
+            runtime_config::limit_concurrency,
+        ))
+        .layer(axum::middleware::from_fn_with_state(
+            Arc::clone(&state),
+            tenants::enforce_limits,
+        ))
+        .layer(axum::middleware::from_fn_with_state(
+            Arc::clone(&state),
+            budgets::enforce_budgets,
+        ));
+
     // Build the router with all endpoints
     let app = Router::new()
         // Health and status endpoints
         .route("/health", get(health_check))
+        .route("/health/live", get(health::live))
+        .route("/health/ready", get(health::ready))
+        .route("/health/deep", get(health::deep))
         .route("/", get(root_handler))
+        .route("/version", get(version::get_version))
+        .route("/capabilities", get(capabilities::get_capabilities))
         // Metrics endpoints
         .route("/metrics", get(metrics_prometheus))
         .route("/metrics/json", get(metrics_json))
         .route("/metrics/snapshot", get(metrics_snapshot))
+        .route("/v1/telemetry/gpus", get(gpu_telemetry::list_gpus))
+        .route("/cache/stats", get(disk_cache::cache_stats))
+        .route("/cache/clear", post(disk_cache::clear_cache))
+        .route(
+            "/cache/config",
+            get(disk_cache::get_cache_config).put(disk_cache::update_cache_config),
+        )
+        .route(
+            "/v1/telemetry/gpus/:index/samples",
+            get(gpu_telemetry::gpu_samples),
+        )
         // OpenAI-compatible API endpoints
         .route("/v1/models", get(openai::list_models))
-        .route("/v1/chat/completions", post(openai::chat_completions))
-        .route("/v1/completions", post(openai::completions))
+        .route("/v1/keys/current", get(api_keys::current_key))
+        .route("/usage", get(usage::get_usage))
+        .route("/usage/quota", get(tenants::get_quota))
+        .route("/usage/export", post(usage_export::create_export))
+        .route("/usage/exports/:export_id", get(usage_export::get_export))
+        .route(
+            "/usage/exports/:export_id/download",
+            get(usage_export::download_export),
+        )
+        .route("/v1/budget", get(budgets::current_budget))
+        .route("/v1/models/events", get(model_events::stream_events))
+        .route(
+            "/v1/models/:model_id",
+            get(openai::retrieve_model).delete(openai::delete_model),
+        )
+        .route("/v1/models/:model_id/load", post(openai::load_model))
+        .route("/v1/models/:model_id/unload", post(openai::unload_model))
+        .route(
+            "/v1/models/:model_id/metadata",
+            get(openai::retrieve_model_metadata),
+        )
+        .route(
+            "/v1/models/:model_id/pricing",
+            get(pricing::get_pricing)
+                .put(pricing::put_pricing)
+                .delete(pricing::delete_pricing),
+        )
+        .route(
+            "/v1/chat/completions",
+            post(openai::chat_completions).layer(limited.clone()),
+        )
+        .route(
+            "/v1/completions",
+            post(openai::completions).layer(limited.clone()),
+        )
+        .route(
+            "/v1/completions/batch",
+            post(completion_batch::create_batch).layer(limited.clone()),
+        )
         .route("/v1/embeddings", post(openai::embeddings))
+        .route("/v1/tokenize", post(tokenize::tokenize))
+        .route("/v1/hidden_states", post(hidden_states::hidden_states))
+        .route("/v1/score", post(cross_encoder::score_pairs))
+        .route("/score", post(cross_encoder::score_pairs))
+        .route("/v1/extract", post(extract::extract).layer(limited.clone()))
+        .route(
+            "/v1/summarize",
+            post(summarize::summarize).layer(limited.clone()),
+        )
+        .route(
+            "/v1/translate",
+            post(translate::translate).layer(limited.clone()),
+        )
+        .route(
+            "/v1/files",
+            get(files::list_files)
+                .post(files::upload_file)
+                // Uploads are parsed as they stream in and capped by the store
+                .layer(DefaultBodyLimit::disable()),
+        )
+        .route(
+            "/v1/files/:file_id",
+            get(files::get_file).delete(files::delete_file),
+        )
+        .route("/v1/files/:file_id/content", get(files::file_content))
+        .route(
+            "/v1/batches",
+            get(batches::list_batches).post(batches::create_batch),
+        )
+        .route("/v1/batches/:batch_id", get(batches::get_batch))
+        .route("/v1/batches/:batch_id/cancel", post(batches::cancel_batch))
+        // Anthropic-compatible API endpoints
+        .route(
+            "/v1/messages",
+            post(anthropic::create_message).layer(limited.clone()),
+        )
+        .route("/v1/messages/count_tokens", post(anthropic::count_tokens))
+        // Model Context Protocol endpoint
+        .route("/mcp", get(mcp::mcp_websocket).post(mcp::mcp_http))
+        // KServe v2 (Open Inference Protocol) endpoints
+        .route("/v2", get(kserve::server_metadata))
+        .route("/v2/health/live", get(kserve::server_live))
+        .route("/v2/health/ready", get(kserve::server_ready))
+        .route("/v2/models/:model_name", get(kserve::model_metadata))
+        .route("/v2/models/:model_name/ready", get(kserve::model_ready))
+        .route(
+            "/v2/models/:model_name/infer",
+            post(kserve::model_infer).layer(limited.clone()),
+        )
+        .route(
+            "/v2/models/:model_name/versions/:model_version",
+            get(kserve::model_metadata),
+        )
+        .route(
+            "/v2/models/:model_name/versions/:model_version/ready",
+            get(kserve::model_ready),
+        )
+        .route(
+            "/v2/models/:model_name/versions/:model_version/infer",
+            post(kserve::model_infer).layer(limited.clone()),
+        )
+        .route(
+            "/v1/models/:model_id/speculative",
+            get(speculative::get_speculative)
+                .put(speculative::put_speculative)
+                .delete(speculative::delete_speculative),
+        )
+        .route(
+            "/v1/models/:model_id/benchmark",
+            post(benchmark::benchmark_model),
+        )
+        .route(
+            "/v1/models/:model_id/apply_template",
+            post(chat_template::apply_template),
+        )
+        .route(
+            "/v1/models/:model_id/evaluate/perplexity",
+            post(evaluation::evaluate_perplexity),
+        )
+        .route(
+            "/v1/models/:model_id/verify",
+            post(verification::verify_model),
+        )
+        // Debugging endpoints
+        .route("/v1/debug/logits", post(logits::inspect_logits))
         // WebSocket streaming endpoints
         .route("/ws/stream", get(websocket::websocket_handler))
         // API v1 endpoints
         .route("/v1/status", get(server_status))
+        // In-flight request control
+        .route(
+            "/v1/inference/:request_id/cancel",
+            post(cancellation::cancel_inference),
+        )
+        // Asynchronous inference jobs
+        .route(
+            "/v1/inference/async",
+            post(async_jobs::submit_inference).layer(limited.clone()),
+        )
+        .route(
+            "/v1/inference/jobs/:job_id",
+            get(async_jobs::inference_job_status),
+        )
+        .route(
+            "/v1/inference/jobs/:job_id/result",
+            get(async_jobs::inference_job_result),
+        )
+        // Conversation sessions with memory compaction
+        .route("/v1/sessions", post(sessions::create_session))
+        .route(
+            "/v1/sessions/:session_id",
+            get(sessions::get_session).delete(sessions::delete_session),
+        )
+        .route(
+            "/v1/sessions/:session_id/messages",
+            post(sessions::send_message).layer(limited.clone()),
+        )
+        .route(
+            "/v1/sessions/:session_id/compact",
+            post(sessions::compact_session),
+        )
+        .route(
+            "/v1/sessions/:session_id/memory",
+            get(sessions::get_memory).put(sessions::put_memory),
+        )
+        // Queue introspection endpoints
+        .route("/v1/queue/stats", get(queue::queue_stats))
+        .route("/v1/queue/requests", get(queue::queue_requests))
+        .route("/v1/queue/classes", get(scheduler::class_stats))
+        // Model routing (A/B traffic splitting) endpoints
+        .route("/v1/routes", get(routing::list_routes))
+        .route(
+            "/v1/routes/:alias",
+            get(routing::get_route)
+                .put(routing::put_route)
+                .delete(routing::delete_route),
+        )
+        // Canary rollout endpoints
+        .route(
+            "/v1/rollouts",
+            get(rollout::list_rollouts).post(rollout::create_rollout),
+        )
+        .route("/v1/rollouts/:rollout_id", get(rollout::get_rollout))
+        .route(
+            "/v1/rollouts/:rollout_id/rollback",
+            post(rollout::rollback_rollout),
+        )
+        .route(
+            "/v1/rollouts/:rollout_id/promote",
+            post(rollout::promote_rollout),
+        )
+        .route(
+            "/v1/rollouts/:rollout_id/events",
+            get(rollout::rollout_events),
+        )
+        // Shadow traffic endpoints
+        .route("/v1/shadow", get(shadow::list_shadow))
+        .route(
+            "/v1/shadow/:model_id",
+            put(shadow::put_shadow).delete(shadow::delete_shadow),
+        )
+        .route("/v1/shadow/:model_id/results", get(shadow::shadow_results))
+        // Batching tuning endpoints
+        .route(
+            "/v1/batching/config",
+            get(batching::get_batching_config).put(batching::update_batching_config),
+        )
+        .route("/v1/batching/stats", get(batching::batching_stats))
+        // Eval suite endpoints
+        .route(
+            "/v1/evals",
+            get(evals::list_suites).post(evals::create_suite),
+        )
+        .route(
+            "/v1/evals/:suite_id",
+            get(evals::get_suite)
+                .put(evals::put_suite)
+                .delete(evals::delete_suite),
+        )
+        .route(
+            "/v1/evals/:suite_id/runs",
+            get(evals::list_runs).post(evals::create_run),
+        )
+        .route("/v1/evals/:suite_id/runs/:run_id", get(evals::get_run))
+        .route(
+            "/v1/evals/:suite_id/runs/:run_id/results",
+            get(evals::run_results),
+        )
+        // Fine-tuning endpoints
+        .route(
+            "/v1/fine_tuning/jobs",
+            get(fine_tuning::list_jobs).post(fine_tuning::create_job),
+        )
+        .route("/v1/fine_tuning/jobs/:job_id", get(fine_tuning::get_job))
+        .route(
+            "/v1/fine_tuning/jobs/:job_id/events",
+            get(fine_tuning::job_events),
+        )
+        .route(
+            "/v1/fine_tuning/jobs/:job_id/metrics",
+            get(fine_tuning::job_metrics),
+        )
+        .route(
+            "/v1/fine_tuning/jobs/:job_id/stream",
+            get(fine_tuning::stream_job),
+        )
+        .route(
+            "/v1/fine_tuning/jobs/:job_id/cancel",
+            post(fine_tuning::cancel_job),
+        )
+        .route(
+            "/v1/fine_tuning/jobs/:job_id/register",
+            post(fine_tuning::register_job),
+        )
+        // Distillation endpoints
+        .route(
+            "/v1/distillation/jobs",
+            get(distillation::list_jobs).post(distillation::create_job),
+        )
+        .route("/v1/distillation/jobs/:job_id", get(distillation::get_job))
+        .route(
+            "/v1/distillation/jobs/:job_id/cancel",
+            post(distillation::cancel_job),
+        )
+        // Dataset endpoints
+        .route("/v1/datasets", get(datasets::list_datasets))
+        .route(
+            "/v1/datasets/:name",
+            get(datasets::get_dataset)
+                .put(datasets::upload_dataset)
+                .delete(datasets::delete_dataset)
+                // Uploads are streamed to disk and capped by the store
+                .layer(DefaultBodyLimit::disable()),
+        )
+        .route(
+            "/v1/datasets/:name/versions/:version",
+            get(datasets::get_version),
+        )
+        .route(
+            "/v1/datasets/:name/versions/:version/sample",
+            get(datasets::sample_version),
+        )
+        // Model download endpoints
+        .route("/v1/models/download", post(hub::download_model))
+        .route("/v1/models/downloads", get(hub::list_downloads))
+        .route("/v1/models/downloads/:download_id", get(hub::get_download))
+        .route(
+            "/v1/models/downloads/:download_id/cancel",
+            post(hub::cancel_download),
+        )
+        // Model store endpoints
+        .route("/v1/model_stores", get(model_stores::list_stores))
+        .route(
+            "/v1/model_stores/:name",
+            get(model_stores::get_store)
+                .put(model_stores::put_store)
+                .delete(model_stores::delete_store),
+        )
+        .route(
+            "/v1/model_stores/:name/models",
+            get(model_stores::list_models),
+        )
+        .route(
+            "/v1/model_stores/:name/models/*key",
+            delete(model_stores::evict_model),
+        )
+        .route(
+            "/v1/model_stores/:name/prefetch",
+            post(model_stores::prefetch_models),
+        )
+        // Bundle endpoints
+        .route("/v1/export/bundle", post(bundles::export_bundle))
+        .route(
+            "/v1/import/bundle",
+            post(bundles::import_bundle_handler)
+                // Bundles are unpacked as they arrive and can be many gigabytes
+                .layer(DefaultBodyLimit::disable()),
+        )
+        // Feature flag endpoints
+        .route("/admin/flags", get(flags::list_flags))
+        .route(
+            "/admin/flags/:name",
+            get(flags::get_flag)
+                .put(flags::put_flag)
+                .delete(flags::reset_flag),
+        )
+        // Runtime configuration endpoints
+        .route(
+            "/admin/config",
+            get(runtime_config::get_config).patch(runtime_config::patch_config),
+        )
+        .route("/admin/config/history", get(runtime_config::config_history))
+        .route("/admin/status", get(operations::get_status))
+        .route("/admin/maintenance", post(operations::set_maintenance))
+        .route("/admin/drain", post(operations::drain))
+        .route("/admin/workers/restart", post(operations::restart_workers))
+        .route(
+            "/admin/scheduler",
+            get(scheduler::get_policy).put(scheduler::put_policy),
+        )
+        .route("/admin/profile", get(profiling::list_profiles))
+        .route("/admin/profile/:kind", get(profiling::get_profile))
+        .route("/admin/audit/events", get(audit_events::list_events))
+        .route("/admin/audit/stream", get(audit_events::stream_events))
+        .route(
+            "/admin/audit/ws",
+            get(audit_events::stream_events_websocket),
+        )
+        .route("/admin/logs", get(logs::get_logs))
+        .route(
+            "/admin/tracing",
+            get(trace_export::get_tracing).put(trace_export::update_tracing),
+        )
+        .route(
+            "/admin/memory",
+            get(memory_pressure::get_memory).put(memory_pressure::update_memory),
+        )
+        .route(
+            "/admin/watchdog",
+            get(watchdog::get_watchdog).put(watchdog::update_watchdog),
+        )
+        .route("/admin/watchdog/incidents", get(watchdog::list_incidents))
+        .route("/admin/watchdog/recover", post(watchdog::trigger_recovery))
+        .route(
+            "/admin/keys",
+            get(api_keys::list_keys).post(api_keys::create_key),
+        )
+        .route(
+            "/admin/keys/:key_id",
+            get(api_keys::get_key)
+                .patch(api_keys::update_key)
+                .delete(api_keys::revoke_key),
+        )
+        .route("/admin/budgets", get(budgets::list_budgets))
+        .route(
+            "/admin/budgets/:kind/:id",
+            get(budgets::get_budgets)
+                .put(budgets::put_budgets)
+                .delete(budgets::delete_budgets),
+        )
+        .route(
+            "/admin/webhooks",
+            get(billing_webhooks::list_subscriptions).post(billing_webhooks::create_subscription),
+        )
+        .route(
+            "/admin/webhooks/:webhook_id",
+            get(billing_webhooks::get_subscription).delete(billing_webhooks::delete_subscription),
+        )
+        .route(
+            "/admin/tenants",
+            get(tenants::list_tenants).post(tenants::create_tenant),
+        )
+        .route(
+            "/admin/tenants/:tenant_id",
+            get(tenants::get_tenant)
+                .patch(tenants::update_tenant)
+                .delete(tenants::delete_tenant),
+        )
+        .route(
+            "/admin/tenants/:tenant_id/suspend",
+            post(tenants::suspend_tenant),
+        )
+        .route(
+            "/admin/tenants/:tenant_id/resume",
+            post(tenants::resume_tenant),
+        )
+        .route(
+            "/admin/tenants/:tenant_id/limits",
+            get(tenants::get_limits)
+                .put(tenants::put_limits)
+                .delete(tenants::delete_limits),
+        )
+        // Cluster membership endpoints
+        .route(
+            "/cluster/nodes",
+            get(cluster::list_nodes).post(cluster::join_node),
+        )
+        .route(
+            "/cluster/nodes/:node_id",
+            get(cluster::get_node).delete(cluster::leave_node),
+        )
+        .route(
+            "/cluster/nodes/:node_id/heartbeat",
+            post(cluster::node_heartbeat),
+        )
+        .route("/cluster/events", get(cluster::list_events))
+        // Parallel serving plan endpoints
+        .route("/cluster/parallel", get(parallel::list_plans))
+        .route(
+            "/cluster/parallel/:model",
+            get(parallel::get_plan)
+                .put(parallel::put_plan)
+                .delete(parallel::delete_plan),
+        )
+        .route(
+            "/cluster/parallel/:model/assignments/:node_id",
+            get(parallel::get_assignment),
+        )
+        .route(
+            "/cluster/parallel/:model/status",
+            get(parallel::get_status).post(parallel::report_status),
+        )
+        // Model placement endpoints
+        .route("/cluster/placement", get(placement::list_placements))
+        .route("/cluster/placement/rebalance", post(placement::rebalance))
+        .route(
+            "/cluster/placement/:model",
+            get(placement::get_placement)
+                .put(placement::put_placement)
+                .delete(placement::delete_placement),
+        )
+        .route(
+            "/cluster/placement/nodes/:node_id",
+            get(placement::node_placement),
+        )
         // Upgrade API endpoints
         .route("/v1/upgrade/status", get(upgrade_status))
         .route("/v1/upgrade/check", post(upgrade_check))
@@ -206,21 +787,62 @@ pub async fn execute(args: ServeArgs, config: &Config) -> Result<()> {
         .layer(
             ServiceBuilder::new()
                 .layer(TraceLayer::new_for_http())
-                .layer(CorsLayer::permissive()),
+                .layer(axum::middleware::from_fn_with_state(
+                    Arc::clone(&state),
+                    trace_export::propagate_context,
+                ))
+                .layer(CorsLayer::permissive())
+                .layer(axum::middleware::from_fn_with_state(
+                    Arc::clone(&state),
+                    audit_events::record_events,
+                ))
+                .layer(axum::middleware::from_fn_with_state(
+                    Arc::clone(&state),
+                    signing::verify_signature,
+                ))
+                .layer(axum::middleware::from_fn_with_state(
+                    Arc::clone(&state),
+                    jwt_auth::authenticate,
+                ))
+                .layer(axum::middleware::from_fn_with_state(
+                    Arc::clone(&state),
+                    api_keys::enforce_scopes,
+                ))
+                .layer(axum::middleware::from_fn_with_state(
+                    Arc::clone(&state),
+                    tenants::authenticate_tenant,
+                ))
+                .layer(axum::middleware::from_fn(version::negotiate_version))
+                .layer(axum::middleware::from_fn_with_state(
+                    Arc::clone(&state),
+                    operations::guard_availability,
+                )),
         )
-        .with_state(state);
+        .with_state(Arc::clone(&state));
 
     info!("HTTP API server is running on http://{}", args.bind);
     info!("Available endpoints:");
     info!("  GET  /             - Server information");
     info!("  GET  /health       - Health check");
+    info!("  GET  /health/live  - Liveness probe");
+    info!("  GET  /health/ready - Readiness probe");
+    info!("  GET  /health/deep  - GPU, disk and test inference checks");
+    info!("  GET  /version      - Supported API versions");
+    info!("  GET  /capabilities - Server features and per-model capabilities");
     info!("  GET  /metrics      - Prometheus metrics");
     info!("  GET  /metrics/json - JSON metrics");
     info!("  GET  /v1/models           - List available models (OpenAI-compatible)");
     info!("  POST /v1/chat/completions - Chat completions (OpenAI-compatible)");
     info!("  POST /v1/completions      - Text completions (OpenAI-compatible)");
     info!("  POST /v1/embeddings       - Generate embeddings (OpenAI-compatible)");
+    info!("  POST /v1/tokenize         - Token IDs under a model's tokenizer");
+    info!("  POST /v1/score            - Cross-encoder pair scores");
+    info!("  POST /v1/files            - Upload files (OpenAI-compatible)");
+    info!("  POST /v1/messages         - Messages (Anthropic-compatible)");
+    info!("  POST /v2/models/{{name}}/infer - Inference (KServe v2)");
+    info!("  WS   /mcp                 - Model Context Protocol");
     info!("  GET  /v1/status           - Server status");
+    info!("  GET  /v1/queue/stats      - Queue depth and wait estimates");
     info!("  WS   /ws/stream           - WebSocket streaming inference");
 
     // Create the listener
@@ -228,7 +850,7 @@ pub async fn execute(args: ServeArgs, config: &Config) -> Result<()> {
 
     // Run the server with graceful shutdown
     axum::serve(listener, app)
-        .with_graceful_shutdown(shutdown_signal())
+        .with_graceful_shutdown(shutdown_signal(state))
         .await?;
 
     info!("Server shut down gracefully");
@@ -243,6 +865,62 @@ pub struct ServerState {
     pub model_manager: ModelManager,
     pub distributed: Option<Arc<DistributedInference>>,
     pub upgrade_manager: Option<Arc<UpgradeManager>>,
+    pub request_queue: Arc<queue::RequestQueue>,
+    pub inference_jobs: async_jobs::InferenceJobStore,
+    pub sessions: sessions::SessionStore,
+    pub flags: flags::FlagStore,
+    pub runtime_config: runtime_config::RuntimeConfigStore,
+    pub operations: Arc<operations::Operations>,
+    pub cluster: cluster::ClusterRegistry,
+    pub parallel_plans: parallel::ParallelPlanStore,
+    pub placement: placement::PlacementStore,
+    pub tenants: tenants::TenantRegistry,
+    pub audit: audit_events::AuditLog,
+    pub trace_exporter: trace_export::TraceExporter,
+    pub watchdog: watchdog::Watchdog,
+    pub gpu_telemetry: gpu_telemetry::GpuTelemetry,
+    pub memory_pressure: memory_pressure::MemoryPressureMonitor,
+    pub disk_cache: disk_cache::DiskCache,
+    /// Completions replayed for identical deterministic requests; see
+    /// `api::completion_cache`
+    pub response_cache: ResponseCache,
+    pub speculative: speculative::SpeculativeRegistry,
+    pub batcher: Arc<DynamicBatcher>,
+    pub model_router: routing::ModelRouter,
+    pub rollouts: rollout::RolloutManager,
+    pub shadow: shadow::ShadowManager,
+    pub evals: evals::EvalStore,
+    pub fine_tuning: fine_tuning::FineTuningStore,
+    pub datasets: datasets::DatasetStore,
+    pub files: files::FileStore,
+    pub batches: batches::BatchStore,
+    pub distillation: distillation::DistillationStore,
+    pub model_downloads: hub::ModelDownloadStore,
+    pub model_stores: model_stores::ModelStoreRegistry,
+    pub model_catalog: model_catalog::ModelCatalog,
+    pub model_events: model_events::ModelEventLog,
+    /// Models loaded on request besides the startup model; see
+    /// `openai::load_model`
+    pub loaded_models: openai::LoadedModels,
+    /// Key-encryption keys for prompts sent encrypted; see `api::envelope`
+    pub envelope_keys: envelope::EnvelopeKeys,
+    /// Secrets for `X-Inferno-Signature`; see `api::signing`
+    pub request_signing: signing::RequestSigning,
+    /// JWKS and claims for JWT bearer tokens; see `api::jwt_auth`
+    pub jwt_auth: jwt_auth::JwtAuth,
+    /// Managed API keys and their scopes; see `api::api_keys`
+    pub api_keys: api_keys::ApiKeyStore,
+    /// Per-model prices for cost estimates; see `api::pricing`
+    pub pricing: pricing::PricingStore,
+    /// Daily and monthly budgets of tenants and keys; see `api::budgets`
+    pub budgets: budgets::BudgetStore,
+    /// Token usage of generation requests by tenant, key and metadata; see
+    /// `api::usage`
+    pub usage: usage::UsageLog,
+    /// Usage export jobs and their files; see `api::usage_export`
+    pub usage_exports: usage_export::UsageExportStore,
+    /// Billing webhook subscriptions; see `api::billing_webhooks`
+    pub billing_webhooks: billing_webhooks::WebhookStore,
 }
 
 // Helper functions
@@ -252,7 +930,11 @@ async fn load_model_on_startup(
     model_manager: &ModelManager,
     config: &Config,
 ) -> Result<(BackendHandle, String)> {
-    let model_info = model_manager.resolve_model(model_name).await?;
+    let mut model_info = model_manager.resolve_model(model_name).await?;
+    let policy = VerificationPolicy::from_config(config.model_security.as_ref())?;
+    model_manager
+        .verify_for_load(&mut model_info, &policy)
+        .await?;
     let backend_type = BackendType::from_model_path(&model_info.path).ok_or_else(|| {
         anyhow::anyhow!(
             "No suitable backend found for model: {}",
@@ -273,25 +955,160 @@ async fn root_handler() -> impl IntoResponse {
         "description": "Offline AI/ML model runner for GGUF and ONNX models",
         "endpoints": {
             "/health": "Health check",
+            "/health/live": "Liveness probe",
+            "/health/ready": "Readiness probe: serving mode, startup model loaded, queue below its limit",
+            "/health/deep": "Readiness plus GPU probe, models disk space and a test inference",
+            "/version": "Supported API versions (negotiated with Accept-Version) and the version each feature needs",
+            "/capabilities": "Server features and what each model supports",
             "/metrics": "Prometheus metrics",
             "/metrics/json": "JSON formatted metrics",
             "/metrics/snapshot": "Detailed metrics snapshot",
-            "/v1/models": "List available models (OpenAI-compatible)",
+            "/cache/stats": "Size and age of the download, partial download and metadata caches, with the download hit rate",
+            "/cache/clear": "Remove cached files by type and age (admin)",
+            "/cache/config": "Size limit per cache type (PUT sets them; admin)",
+            "/v1/telemetry/gpus": "Per-GPU temperature, power, clocks and throttling",
+            "/v1/telemetry/gpus/{index}/samples": "One GPU's telemetry samples over the last hour",
+            "/v1/models": "List available models (OpenAI-compatible); ?watch=true&since= returns catalog changes",
+            "/v1/keys/current": "The managed API key making the request and what it may do",
+            "/usage": "Token usage over a time range, grouped by model, tenant, key, endpoint or metadata.<key> (all tenants: admin)",
+            "/usage/quota": "Requests and tokens the caller's tenant may still send per window, and when each resets",
+            "/usage/export": "POST starts writing usage records for a time range to a CSV or Parquet file",
+            "/usage/exports/{export_id}": "A usage export's status and, once complete, its signed download URL",
+            "/usage/exports/{export_id}/download": "The export file; needs the URL's signature rather than credentials",
+            "/v1/budget": "Usage of the caller's tenant and key budgets this period, and when each resets",
+            "/v1/models/events": "Model lifecycle events (downloaded, loaded, unloaded, evicted, deleted, failed) as server-sent events",
+            "/v1/models/{model_id}": "Retrieve a model (OpenAI-compatible); DELETE removes its file (admin)",
+            "/v1/models/{model_id}/load": "Load a model and keep it loaded; /unload frees it (admin)",
+            "/v1/models/{model_id}/metadata": "Format, size, GGUF header and verification of a model file",
+            "/v1/models/{model_id}/pricing": "Price per million prompt and completion tokens, for cost estimates (PUT and DELETE: admin)",
             "/v1/chat/completions": "Chat completions (OpenAI-compatible)",
             "/v1/completions": "Text completions (OpenAI-compatible)",
+            "/v1/completions/batch": "Several completions of one model in one request, answered together",
             "/v1/embeddings": "Generate embeddings (OpenAI-compatible)",
+            "/v1/tokenize": "Token IDs and counts under a model's tokenizer",
+            "/v1/hidden_states": "Final-layer hidden states of a generative model, per token or pooled",
+            "/v1/score": "Cross-encoder relevance scores for query/candidate pairs (also at /score)",
+            "/v1/extract": "Entities and typed fields from a text, with offsets and confidence",
+            "/v1/summarize": "Map-reduce summary of documents longer than the context window",
+            "/v1/translate": "Translate one text or a batch, with formality, glossary and chunking",
+            "/v1/files": "Upload and list files (OpenAI-compatible; uploads require admin)",
+            "/v1/files/{file_id}/content": "Download an uploaded file",
+            "/v1/batches": "Run a file of chat, completion or embedding requests in the background (OpenAI-compatible; admin)",
+            "/v1/batches/{batch_id}": "A batch's status and request counts; POST .../cancel stops it",
+            "/v1/messages": "Messages (Anthropic-compatible)",
+            "/v1/messages/count_tokens": "Count a message request's input tokens (Anthropic-compatible)",
+            "/mcp": "Model Context Protocol over WebSocket (GET) or JSON-RPC POST",
+            "/v2/health/live": "Server liveness (KServe v2)",
+            "/v2/health/ready": "Server readiness (KServe v2)",
+            "/v2/models/{model_name}": "Model metadata (KServe v2)",
+            "/v2/models/{model_name}/ready": "Model readiness (KServe v2)",
+            "/v2/models/{model_name}/infer": "Inference with text_input/text_output tensors (KServe v2)",
+            "/v1/models/{model_id}/speculative": "Speculative decoding config and acceptance stats",
+            "/v1/models/{model_id}/benchmark": "Run the benchmark suite against a model (admin)",
+            "/v1/models/{model_id}/apply_template": "Render messages through the model's chat template",
+            "/v1/models/{model_id}/evaluate/perplexity": "Score a text corpus and report perplexity",
+            "/v1/debug/logits": "Top logits and entropy at each step of a short generation (admin)",
+            "/v1/models/{model_id}/verify": "Check a model's SHA-256 and signature against the trust policy (admin)",
+            "/v1/models/download": "Pull a GGUF model from the Hugging Face Hub or an Ollama registry (admin)",
+            "/v1/models/downloads/{download_id}": "Model download progress (admin)",
+            "/v1/model_stores/{name}": "S3, GCS or Azure Blob model stores (writes require admin)",
+            "/v1/model_stores/{name}/models": "Models in a remote store and their cache state",
+            "/v1/model_stores/{name}/prefetch": "Cache remote models ahead of use (admin)",
+            "/v1/export/bundle": "Download models, adapters, templates and config as one archive (admin)",
+            "/v1/import/bundle": "Install a bundle archive for air-gapped deployments (admin)",
+            "/admin/flags": "Feature flags with per-environment and per-API-key overrides (admin)",
+            "/admin/config": "Runtime settings: concurrency limit, session cache, sampling defaults (admin)",
+            "/admin/config/history": "Audit history of runtime setting changes (admin)",
+            "/admin/status": "Server mode, in-flight requests and workers (admin)",
+            "/admin/maintenance": "Turn maintenance mode on or off (admin)",
+            "/admin/drain": "Refuse new work, wait for in-flight requests, optionally shut down (admin)",
+            "/admin/workers/restart": "Reload backend workers while out of service (admin)",
+            "/admin/scheduler": "Priority classes, tenant fair-share weights and preemption (admin)",
+            "/admin/profile": "Profiles this build can capture (admin)",
+            "/admin/profile/{kind}": "CPU profile as pprof, or thread and memory reports (admin)",
+            "/admin/audit/events": "Recent auth failures, admin actions, policy violations, model incidents and memory pressure (admin)",
+            "/admin/audit/stream": "Live audit events as server-sent events, with filters (admin)",
+            "/admin/audit/ws": "Live audit events over WebSocket, with filters (admin)",
+            "/admin/logs": "Recent structured log lines, or follow=true to stream them, filtered by level and component (admin)",
+            "/admin/tracing": "OTLP trace exporter target, sampling ratio and counters (PUT changes them at runtime; admin)",
+            "/admin/memory": "RAM and GPU memory pressure, thresholds and eviction settings (admin)",
+            "/admin/watchdog": "Model watchdog state and settings (admin)",
+            "/admin/watchdog/incidents": "Crashed and hung model incidents with their reload attempts (admin)",
+            "/admin/watchdog/recover": "Reload the startup model now (admin)",
+            "/admin/keys": "Managed API keys and their model, endpoint and sampling scopes; POST creates one (admin)",
+            "/admin/keys/{key_id}": "One API key; PATCH changes its scopes, DELETE revokes it (admin)",
+            "/admin/budgets": "Every tenant and key budget and its usage this period (admin)",
+            "/admin/budgets/{tenants|keys}/{id}": "A tenant's or key's daily and monthly budgets; PUT replaces them, DELETE removes them (admin)",
+            "/admin/webhooks": "Billing webhook subscriptions for usage thresholds, budget exhaustion and period summaries; POST creates one (admin)",
+            "/admin/webhooks/{webhook_id}": "One webhook subscription with its delivery counts; DELETE removes it (admin)",
+            "/admin/tenants": "Tenants with allowed models, limits and recent usage; POST creates one (admin)",
+            "/admin/tenants/{tenant_id}": "One tenant; PATCH changes it, DELETE removes it (admin)",
+            "/admin/tenants/{tenant_id}/suspend": "Refuse a tenant's generation requests until resumed (admin)",
+            "/admin/tenants/{tenant_id}/resume": "Lift a tenant's suspension (admin)",
+            "/admin/tenants/{tenant_id}/limits": "A tenant's concurrency, rate and token limits and dedicated models (admin)",
+            "/cluster/nodes": "Cluster nodes with roles, loaded models, GPUs and health (joining requires admin)",
+            "/cluster/nodes/{node_id}": "One node; DELETE removes it from the cluster (admin)",
+            "/cluster/nodes/{node_id}/heartbeat": "A member node's periodic state report (admin)",
+            "/cluster/events": "Node join and leave events",
+            "/cluster/parallel": "Tensor/pipeline parallel serving plans by model",
+            "/cluster/parallel/{model}": "A model's layer ranges per device; PUT and DELETE require admin",
+            "/cluster/parallel/{model}/assignments/{node_id}": "The stages and ranks one node serves",
+            "/cluster/parallel/{model}/status": "Device state and interconnect stats; nodes POST reports (admin)",
+            "/cluster/placement": "Per-model replica counts and pins with current and target placement",
+            "/cluster/placement/{model}": "A model's placement; PUT and DELETE require admin",
+            "/cluster/placement/rebalance": "Recompute placement and list loads and unloads (admin)",
+            "/cluster/placement/nodes/{node_id}": "The models a node should serve",
             "/v1/status": "Server status",
+            "/v1/inference/{request_id}/cancel": "Cancel an in-flight generation",
+            "/v1/inference/async": "Submit a completion as an asynchronous job",
+            "/v1/inference/jobs/{job_id}": "Asynchronous job status",
+            "/v1/inference/jobs/{job_id}/result": "Asynchronous job result",
+            "/v1/sessions": "Open a conversation session kept on the server",
+            "/v1/sessions/{session_id}": "Inspect or close a session",
+            "/v1/sessions/{session_id}/messages": "Add a user turn and get the reply, compacting old turns as needed",
+            "/v1/sessions/{session_id}/compact": "Compact older turns into the session memory now",
+            "/v1/sessions/{session_id}/memory": "Read or replace the session's rolling memory block",
+            "/v1/queue/stats": "Queue depth, wait estimates and oldest request age",
+            "/v1/queue/requests": "Queued request IDs (admin)",
+            "/v1/queue/classes": "Queue depth, waits and preemptions per priority class",
+            "/v1/routes": "Model routing rules with per-arm usage",
+            "/v1/routes/{alias}": "Create, inspect or delete a routing rule (writes require admin)",
+            "/v1/rollouts": "Canary rollouts (POST requires admin)",
+            "/v1/rollouts/{rollout_id}": "Rollout status and arm health",
+            "/v1/rollouts/{rollout_id}/events": "Rollout progress as server-sent events",
+            "/v1/shadow": "Shadow traffic mirroring configuration (writes require admin)",
+            "/v1/shadow/{model_id}/results": "Mirrored output comparisons (admin)",
+            "/v1/batching/config": "Dynamic batching limits (PUT requires admin)",
+            "/v1/batching/stats": "Batching metrics and recent per-batch statistics",
+            "/v1/evals": "Eval suites (writes require admin)",
+            "/v1/evals/{suite_id}/runs": "Run a suite against models (admin) and list its runs",
+            "/v1/evals/{suite_id}/runs/{run_id}/results": "Per-case eval results",
+            "/v1/fine_tuning/jobs": "LoRA fine-tuning jobs (admin)",
+            "/v1/fine_tuning/jobs/{job_id}/events": "Recent trainer output for a job (admin)",
+            "/v1/fine_tuning/jobs/{job_id}/stream": "Live training metrics as server-sent events (admin)",
+            "/v1/distillation/jobs": "Teacher-to-student distillation pipelines (admin)",
+            "/v1/datasets": "Versioned chat-format datasets (uploads require admin)",
+            "/v1/datasets/{name}/versions/{version}/sample": "Random records from a dataset version",
             "/ws/stream": "WebSocket streaming inference"
         }
     }))
 }
 
-async fn health_check() -> impl IntoResponse {
-    Json(json!({
-        "status": "healthy",
-        "timestamp": chrono::Utc::now().to_rfc3339(),
-        "uptime": "unknown" // Could track actual uptime
-    }))
+async fn health_check(State(state): State<Arc<ServerState>>) -> impl IntoResponse {
+    // Out of service reports 503 so load balancers stop routing here
+    let (status, code) = match state.operations.mode() {
+        operations::ServerMode::Serving => ("healthy", StatusCode::OK),
+        operations::ServerMode::Maintenance => ("maintenance", StatusCode::SERVICE_UNAVAILABLE),
+        operations::ServerMode::Draining => ("draining", StatusCode::SERVICE_UNAVAILABLE),
+    };
+    (
+        code,
+        Json(json!({
+            "status": status,
+            "timestamp": chrono::Utc::now().to_rfc3339(),
+            "uptime": "unknown" // Could track actual uptime
+        })),
+    )
 }
 
 async fn metrics_prometheus(State(state): State<Arc<ServerState>>) -> impl IntoResponse {
@@ -523,7 +1340,7 @@ async fn upgrade_install(
     }
 }
 
-async fn shutdown_signal() {
+async fn shutdown_signal(state: Arc<ServerState>) {
     let ctrl_c = async {
         signal::ctrl_c()
             .await
@@ -544,6 +1361,7 @@ async fn shutdown_signal() {
     tokio::select! {
         _ = ctrl_c => {},
         _ = terminate => {},
+        _ = state.operations.shutdown_requested() => {},
     }
 
     info!("Shutdown signal received");
@@ -318,6 +318,10 @@ async fn execute_interactive(
         stream: true,
         stop_sequences: vec![],
         seed: None,
+        stop_token_ids: vec![],
+        ignore_eos: false,
+        skip_special_tokens: None,
+        prompt_token_ids: None,
     };
 
     loop {
@@ -435,6 +439,10 @@ async fn execute_benchmark(
         stream: true,
         stop_sequences: vec![],
         seed: None,
+        stop_token_ids: vec![],
+        ignore_eos: false,
+        skip_special_tokens: None,
+        prompt_token_ids: None,
     };
 
     // Start concurrent streams
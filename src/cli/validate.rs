@@ -349,6 +349,7 @@ async fn deep_validate_model(path: &PathBuf, config: &Config) -> Result<bool> {
             .to_string(),
         checksum: None,
         metadata: std::collections::HashMap::new(),
+        verification: None,
     };
 
     match backend.load_model(&model_info).await {
@@ -365,6 +366,10 @@ async fn deep_validate_model(path: &PathBuf, config: &Config) -> Result<bool> {
                 stream: false,
                 stop_sequences: vec![],
                 seed: None,
+                stop_token_ids: vec![],
+                ignore_eos: false,
+                skip_special_tokens: None,
+                prompt_token_ids: None,
             };
 
             match backend.infer(test_input, &inference_params).await {
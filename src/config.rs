@@ -1,8 +1,14 @@
 use crate::{
-    backends::BackendConfig, cache::CacheConfig, deployment::DeploymentConfig,
-    distributed::DistributedConfig, logging_audit::LoggingAuditConfig,
-    model_versioning::ModelVersioningConfig, monitoring::MonitoringConfig,
-    observability::ObservabilityConfig, response_cache::ResponseCacheConfig,
+    api::{fine_tuning::FineTuningConfig, hub::HubConfig},
+    backends::BackendConfig,
+    cache::CacheConfig,
+    deployment::DeploymentConfig,
+    distributed::DistributedConfig,
+    logging_audit::LoggingAuditConfig,
+    model_versioning::ModelVersioningConfig,
+    monitoring::MonitoringConfig,
+    observability::ObservabilityConfig,
+    response_cache::ResponseCacheConfig,
 };
 use anyhow::Result;
 use figment::{
@@ -33,6 +39,10 @@ pub struct Config {
     pub deployment: DeploymentConfig,
     pub model_versioning: ModelVersioningConfig,
     pub logging_audit: LoggingAuditConfig,
+    #[serde(default)]
+    pub fine_tuning: FineTuningConfig,
+    #[serde(default)]
+    pub hub: HubConfig,
 }
 
 #[derive(Debug, Clone, Serialize, Deserialize)]
@@ -49,6 +59,12 @@ pub struct ModelSecurityConfig {
     pub allowed_model_extensions: Vec<String>,
     pub max_model_size_gb: f64,
     pub sandbox_enabled: bool,
+    /// Refuse models without a valid signature from a trusted key
+    #[serde(default)]
+    pub require_signatures: bool,
+    /// Ed25519 public keys (base64 or PEM) that model signatures are checked against
+    #[serde(default)]
+    pub trusted_keys: Vec<String>,
 }
 
 #[derive(Debug, Clone, Serialize, Deserialize)]
@@ -88,6 +104,8 @@ impl Default for Config {
             deployment: DeploymentConfig::default(),
             model_versioning: ModelVersioningConfig::default(),
             logging_audit: LoggingAuditConfig::default(),
+            fine_tuning: FineTuningConfig::default(),
+            hub: HubConfig::default(),
         }
     }
 }
@@ -110,6 +128,8 @@ impl Default for ModelSecurityConfig {
             allowed_model_extensions: vec!["gguf".to_string(), "onnx".to_string()],
             max_model_size_gb: 50.0,
             sandbox_enabled: true,
+            require_signatures: false,
+            trusted_keys: Vec::new(),
         }
     }
 }
@@ -298,6 +318,7 @@ impl Config {
             if sec_config.max_model_size_gb == 0.0 {
                 return Err(anyhow::anyhow!("Max model size must be greater than 0"));
             }
+            crate::models::verification::VerificationPolicy::from_config(Some(sec_config))?;
         }
 
         Ok(())
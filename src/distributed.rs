@@ -142,6 +142,7 @@ enum WorkerMessage {
     GetStats {
         response_tx: oneshot::Sender<WorkerStats>,
     },
+    Restart,
     Shutdown,
 }
 
@@ -275,6 +276,9 @@ impl DistributedInference {
                         Some(WorkerMessage::GetStats { response_tx }) => {
                             let _ = response_tx.send(worker.stats.clone());
                         }
+                        Some(WorkerMessage::Restart) => {
+                            worker.restart();
+                        }
                         Some(WorkerMessage::Shutdown) | None => {
                             info!("Worker {} shutting down", worker.worker_id);
                             break;
@@ -457,6 +461,24 @@ impl DistributedInference {
         Ok(detailed_stats)
     }
 
+    /// Drop every worker's loaded backends so models are reloaded, returning
+    /// the number of workers restarted. Each worker restarts after the
+    /// requests already sent to it.
+    pub async fn restart_workers(&self) -> Result<usize> {
+        for worker in &self.workers {
+            worker
+                .request_tx
+                .send(WorkerMessage::Restart)
+                .map_err(|_| anyhow!("Worker {} is not running", worker.worker_id))?;
+        }
+
+        if self.config.preload_models {
+            self.preload_common_models().await?;
+        }
+
+        Ok(self.workers.len())
+    }
+
     /// Graceful shutdown
     pub async fn shutdown(&mut self) -> Result<()> {
         info!("Shutting down distributed inference system");
@@ -639,6 +661,13 @@ impl Worker {
         }
     }
 
+    /// Drop all loaded backends; models load again on their next request
+    fn restart(&mut self) {
+        self.backends.clear();
+        self.stats.loaded_models.clear();
+        info!("Worker {} restarted", self.worker_id);
+    }
+
     /// Evict the least recently used model
     async fn evict_least_used_model(&mut self) {
         // Simple LRU - in practice, you'd want better tracking
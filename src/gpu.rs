@@ -496,6 +496,14 @@ impl GpuManager {
             .collect()
     }
 
+    /// Every detected GPU, whatever its status, by id
+    pub async fn list_gpus(&self) -> Vec<GpuInfo> {
+        let gpus = self.gpus.read().await;
+        let mut gpus: Vec<GpuInfo> = gpus.values().cloned().collect();
+        gpus.sort_by_key(|gpu| gpu.id);
+        gpus
+    }
+
     pub async fn get_gpu_info(&self, gpu_id: u32) -> Option<GpuInfo> {
         let gpus = self.gpus.read().await;
         gpus.get(&gpu_id).cloned()
@@ -342,6 +342,10 @@ impl BackendManager {
             stream: params.stream.unwrap_or(false),
             stop_sequences: params.stop_sequences.clone().unwrap_or_default(),
             seed: params.seed,
+            stop_token_ids: vec![],
+            ignore_eos: false,
+            skip_special_tokens: None,
+            prompt_token_ids: None,
         };
 
         // Track active inference count while the request is in-flight
@@ -451,6 +455,10 @@ impl BackendManager {
             stream: true,
             stop_sequences: params.stop_sequences.clone().unwrap_or_default(),
             seed: params.seed,
+            stop_token_ids: vec![],
+            ignore_eos: false,
+            skip_special_tokens: None,
+            prompt_token_ids: None,
         };
 
         backend_handle.infer_stream(prompt, &inferno_params).await
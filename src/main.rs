@@ -1,5 +1,6 @@
 use anyhow::Result;
 use inferno::{
+    api::logs::LogCapture,
     cli::{Commands, enhanced_parser::EnhancedCliParser, help::HelpSystem},
     config::Config,
     upgrade::{
@@ -9,7 +10,7 @@ use inferno::{
 use std::sync::Arc;
 use tokio::sync::broadcast;
 use tracing::{error, info, warn};
-use tracing_subscriber::{EnvFilter, fmt};
+use tracing_subscriber::{EnvFilter, fmt, layer::SubscriberExt};
 
 #[tokio::main]
 async fn main() -> Result<()> {
@@ -39,7 +40,7 @@ async fn main() -> Result<()> {
         Config::default()
     });
 
-    setup_logging();
+    setup_logging(matches!(cli.command, Commands::Mcp(_)));
     info!(
         "Starting Inferno AI/ML model runner v{}",
         std::env::var("CARGO_PKG_VERSION").unwrap_or_else(|_| "0.1.0".to_string())
@@ -65,6 +66,7 @@ async fn main() -> Result<()> {
         Commands::Run(args) => inferno::cli::run::execute(args, &config).await,
         Commands::Batch(args) => inferno::cli::batch::execute(args, &config).await,
         Commands::Serve(args) => inferno::cli::serve::execute(args, &config).await,
+        Commands::Mcp(args) => inferno::cli::mcp::execute(args, &config).await,
         Commands::Models(args) => inferno::cli::models::execute(args, &config).await,
         Commands::Metrics(args) => inferno::cli::metrics::execute(args, &config).await,
         Commands::Bench(args) => inferno::cli::bench::execute(args, &config).await,
@@ -176,9 +178,9 @@ async fn init_background_update_service(config: &Config) -> Result<BackgroundUpd
 }
 
 /// Set up comprehensive logging and tracing
-fn setup_logging() {
+fn setup_logging(to_stderr: bool) {
     // Create a subscriber with environment filter support
-    let subscriber = fmt::Subscriber::builder()
+    let builder = fmt::Subscriber::builder()
         .with_env_filter(
             EnvFilter::from_default_env()
                 .add_directive("inferno=info".parse().unwrap())
@@ -187,9 +189,19 @@ fn setup_logging() {
         .with_target(false)
         .with_thread_ids(true)
         .with_file(true)
-        .with_line_number(true)
-        .finish();
-
-    tracing::subscriber::set_global_default(subscriber)
-        .expect("Failed to initialize tracing subscriber");
+        .with_line_number(true);
+
+    // The MCP stdio transport owns stdout, so its logs go to stderr. Lines
+    // are also captured for `/admin/logs`.
+    if to_stderr {
+        tracing::subscriber::set_global_default(
+            builder
+                .with_writer(std::io::stderr)
+                .finish()
+                .with(LogCapture::new()),
+        )
+    } else {
+        tracing::subscriber::set_global_default(builder.finish().with(LogCapture::new()))
+    }
+    .expect("Failed to initialize tracing subscriber");
 }
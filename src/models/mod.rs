@@ -9,6 +9,12 @@ use std::io::{Cursor, Read as StdRead};
 use std::path::{Path, PathBuf};
 use tokio::fs as async_fs;
 use tracing::{error, info, warn};
+use verification::{
+    ChecksumStatus, ModelVerification, SignatureStatus, VerificationPolicy, signature_path,
+    verify_signature,
+};
+
+pub mod verification;
 
 #[derive(Debug, Clone, Serialize, Deserialize)]
 pub struct ModelInfo {
@@ -22,6 +28,9 @@ pub struct ModelInfo {
     pub format: String,
     pub checksum: Option<String>,
     pub metadata: HashMap<String, String>,
+    /// Digest and signature checks, once the model has been verified
+    #[serde(default)]
+    pub verification: Option<ModelVerification>,
 }
 
 #[derive(Debug, Clone, Serialize, Deserialize)]
@@ -118,6 +127,9 @@ pub struct RegistryEntry {
     pub use_count: u64,
     pub last_used: Option<chrono::DateTime<chrono::Utc>>,
     pub added_at: chrono::DateTime<chrono::Utc>,
+    /// SHA-256 recorded when the model was installed or first verified
+    #[serde(default)]
+    pub sha256: Option<String>,
 }
 
 #[derive(Clone)]
@@ -191,7 +203,9 @@ impl ModelManager {
     }
 
     pub async fn resolve_model(&self, model_name_or_path: &str) -> Result<ModelInfo> {
-        let path = if model_name_or_path.contains('/') || model_name_or_path.contains('\\') {
+        let path = if let Some(path) = self.find_model_by_alias(model_name_or_path).await {
+            path
+        } else if model_name_or_path.contains('/') || model_name_or_path.contains('\\') {
             PathBuf::from(model_name_or_path)
         } else {
             self.find_model_by_name(model_name_or_path).await?
@@ -204,6 +218,18 @@ impl ModelManager {
         self.create_model_info(&path).await
     }
 
+    /// Look up a registered model tagged `alias:<name>`, such as the Ollama
+    /// name of a pulled model
+    async fn find_model_by_alias(&self, name: &str) -> Option<PathBuf> {
+        let registry = self.load_registry().await.ok()?;
+        let tag = format!("alias:{}", name);
+        registry
+            .entries
+            .into_values()
+            .find(|entry| entry.tags.contains(&tag) && entry.path.exists())
+            .map(|entry| entry.path)
+    }
+
     async fn find_model_by_name(&self, name: &str) -> Result<PathBuf> {
         let models = self.list_models().await?;
         for model in &models {
@@ -244,6 +270,7 @@ impl ModelManager {
             format: backend_type,
             checksum: None,
             metadata: HashMap::new(),
+            verification: None,
         })
     }
 
@@ -358,11 +385,12 @@ impl ModelManager {
             .file_name()
             .and_then(|n| n.to_str())
             .unwrap_or("unknown");
-        self.cache_dir()
+        self.metadata_cache_dir()
             .join(format!("{}-{}.json", filename, &hash[..12]))
     }
 
-    fn cache_dir(&self) -> PathBuf {
+    /// Directory of parsed model metadata, safe to clear
+    pub fn metadata_cache_dir(&self) -> PathBuf {
         self.models_dir.join(".inferno_cache")
     }
 
@@ -435,6 +463,7 @@ impl ModelManager {
                 use_count: 0,
                 last_used: None,
                 added_at: chrono::Utc::now(),
+                sha256: None,
             });
         entry.use_count += 1;
         entry.last_used = Some(chrono::Utc::now());
@@ -466,6 +495,7 @@ impl ModelManager {
                 use_count: 0,
                 last_used: None,
                 added_at: chrono::Utc::now(),
+                sha256: None,
             });
         for tag in tags {
             if !entry.tags.contains(tag) {
@@ -496,11 +526,32 @@ impl ModelManager {
                 use_count: 0,
                 last_used: None,
                 added_at: chrono::Utc::now(),
+                sha256: None,
             });
         self.save_registry(&registry).await?;
         Ok(())
     }
 
+    /// Delete a model file, its detached signature and its registry entry.
+    pub async fn remove_model(&self, path: &Path) -> Result<()> {
+        let canonical = path.canonicalize().unwrap_or_else(|_| path.to_path_buf());
+        let key = canonical.to_string_lossy().to_string();
+
+        async_fs::remove_file(path).await?;
+        match async_fs::remove_file(verification::signature_path(path)).await {
+            Err(e) if e.kind() != std::io::ErrorKind::NotFound => {
+                warn!("Could not remove signature of {}: {}", path.display(), e)
+            }
+            _ => {}
+        }
+
+        let mut registry = self.load_registry().await.unwrap_or_default();
+        if registry.entries.remove(&key).is_some() {
+            self.save_registry(&registry).await?;
+        }
+        Ok(())
+    }
+
     // ── Compatibility ─────────────────────────────────────────────────────────
 
     /// Estimate whether the current system can run this model.
@@ -799,6 +850,116 @@ impl ModelManager {
         }
         Ok(format!("{:x}", hasher.finalize()))
     }
+
+    // ── Verification ─────────────────────────────────────────────────────────
+
+    /// Record the SHA-256 of a model, adding it to the registry if needed.
+    pub async fn record_digest(&self, path: &Path, sha256: &str) -> Result<()> {
+        let mut registry = self.load_registry().await.unwrap_or_default();
+        let canonical = path.canonicalize().unwrap_or_else(|_| path.to_path_buf());
+        let key = canonical.to_string_lossy().to_string();
+        let name = path
+            .file_name()
+            .and_then(|n| n.to_str())
+            .unwrap_or("")
+            .to_string();
+
+        let entry = registry
+            .entries
+            .entry(key)
+            .or_insert_with(|| RegistryEntry {
+                name,
+                path: path.to_path_buf(),
+                tags: Vec::new(),
+                use_count: 0,
+                last_used: None,
+                added_at: chrono::Utc::now(),
+                sha256: None,
+            });
+        entry.sha256 = Some(sha256.to_lowercase());
+        self.save_registry(&registry).await?;
+        Ok(())
+    }
+
+    /// Hash a model and check it against its recorded digest and `.sig` file.
+    /// A model seen for the first time has its digest recorded; a changed one
+    /// only when a trusted signature vouches for it.
+    pub async fn verify_model(
+        &self,
+        path: &Path,
+        policy: &VerificationPolicy,
+    ) -> Result<ModelVerification> {
+        let sha256 = self.compute_checksum(path).await?;
+
+        let registry = self.load_registry().await.unwrap_or_default();
+        let canonical = path.canonicalize().unwrap_or_else(|_| path.to_path_buf());
+        let expected_sha256 = registry
+            .entries
+            .get(&canonical.to_string_lossy().to_string())
+            .and_then(|entry| entry.sha256.clone());
+        let checksum = match &expected_sha256 {
+            None => ChecksumStatus::Recorded,
+            Some(expected) if expected.eq_ignore_ascii_case(&sha256) => ChecksumStatus::Matched,
+            Some(_) => ChecksumStatus::Mismatch,
+        };
+
+        let (signature, key_id) = match async_fs::read_to_string(signature_path(path)).await {
+            Ok(signature) => match verify_signature(&sha256, &signature, &policy.trusted_keys) {
+                Some(key_id) => (SignatureStatus::Valid, Some(key_id)),
+                None => (SignatureStatus::Untrusted, None),
+            },
+            Err(e) if e.kind() == std::io::ErrorKind::NotFound => (SignatureStatus::Unsigned, None),
+            Err(e) => return Err(e.into()),
+        };
+
+        let reason = policy.evaluate(checksum, signature);
+        let vouched = checksum == ChecksumStatus::Mismatch && signature == SignatureStatus::Valid;
+        if checksum == ChecksumStatus::Recorded || vouched {
+            self.record_digest(path, &sha256).await?;
+        }
+
+        Ok(ModelVerification {
+            sha256,
+            expected_sha256,
+            checksum,
+            signature,
+            key_id,
+            trusted: reason.is_none(),
+            reason,
+            verified_at: chrono::Utc::now(),
+        })
+    }
+
+    /// Verify a resolved model before it is loaded, failing if the policy does
+    /// not trust it. Does nothing when the policy checks nothing.
+    pub async fn verify_for_load(
+        &self,
+        model_info: &mut ModelInfo,
+        policy: &VerificationPolicy,
+    ) -> Result<()> {
+        if !policy.is_enabled() {
+            return Ok(());
+        }
+
+        let verification = self.verify_model(&model_info.path, policy).await?;
+        if let Some(reason) = &verification.reason {
+            return Err(InfernoError::SecurityValidation(format!(
+                "Refusing to load {}: {}",
+                model_info.name, reason
+            ))
+            .into());
+        }
+        if verification.checksum == ChecksumStatus::Mismatch {
+            warn!(
+                "{} changed since its digest was recorded (now {})",
+                model_info.name, verification.sha256
+            );
+        }
+
+        model_info.checksum = Some(verification.sha256.clone());
+        model_info.verification = Some(verification);
+        Ok(())
+    }
 }
 
 // ── GGUF binary parsing ───────────────────────────────────────────────────────
@@ -1264,6 +1425,28 @@ mod tests {
         assert!(entry.last_used.is_some());
     }
 
+    #[tokio::test]
+    async fn test_remove_model() {
+        let temp_dir = tempdir().expect("Failed to create temp dir");
+        let models_dir = temp_dir.path().join("models");
+        fs::create_dir_all(&models_dir).await.unwrap();
+        let manager = ModelManager::new(&models_dir);
+
+        let model_path = models_dir.join("test.gguf");
+        fs::write(&model_path, b"GGUF\x03\x00\x00\x00data")
+            .await
+            .unwrap();
+        let signature = verification::signature_path(&model_path);
+        fs::write(&signature, b"sig").await.unwrap();
+        manager.register_model(&model_path).await.unwrap();
+
+        manager.remove_model(&model_path).await.unwrap();
+        assert!(!model_path.exists());
+        assert!(!signature.exists());
+        assert!(manager.load_registry().await.unwrap().entries.is_empty());
+        assert!(manager.list_models().await.unwrap().is_empty());
+    }
+
     #[tokio::test]
     async fn test_search_local() {
         let temp_dir = tempdir().expect("Failed to create temp dir");
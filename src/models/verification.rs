@@ -0,0 +1,313 @@
+//! Model integrity and signature checks
+//!
+//! Every model's SHA-256 digest is recorded in the registry the first time it
+//! is seen (or when it is installed) and compared on each load. A model can
+//! also carry a detached Ed25519 signature in `<model>.sig`: the base64
+//! signature of the file's raw 32-byte SHA-256 digest, made with
+//! `inferno models sign`. Signatures are checked against
+//! `model_security.trusted_keys`; with `require_signatures` only models signed
+//! by one of those keys can be loaded.
+
+use crate::config::ModelSecurityConfig;
+use anyhow::Result;
+use base64::{Engine as _, engine::general_purpose};
+use ring::{
+    rand::SystemRandom,
+    signature::{self, Ed25519KeyPair, KeyPair, UnparsedPublicKey},
+};
+use serde::{Deserialize, Serialize};
+use sha2::{Digest, Sha256};
+use std::path::{Path, PathBuf};
+
+/// DER prefix of an Ed25519 SubjectPublicKeyInfo, ahead of the 32 key bytes
+const ED25519_SPKI_PREFIX: [u8; 12] = [
+    0x30, 0x2a, 0x30, 0x05, 0x06, 0x03, 0x2b, 0x65, 0x70, 0x03, 0x21, 0x00,
+];
+
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Serialize, Deserialize)]
+#[serde(rename_all = "snake_case")]
+pub enum ChecksumStatus {
+    /// First time the model was seen; its digest is now recorded
+    Recorded,
+    Matched,
+    /// The file changed since its digest was recorded
+    Mismatch,
+}
+
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Serialize, Deserialize)]
+#[serde(rename_all = "snake_case")]
+pub enum SignatureStatus {
+    /// No `.sig` file
+    Unsigned,
+    /// Signed by a trusted key
+    Valid,
+    /// A signature that no trusted key verifies
+    Untrusted,
+}
+
+/// The outcome of checking a model before it is loaded
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct ModelVerification {
+    pub sha256: String,
+    /// The digest recorded before this check, if any
+    pub expected_sha256: Option<String>,
+    pub checksum: ChecksumStatus,
+    pub signature: SignatureStatus,
+    /// Trusted key that verified the signature
+    pub key_id: Option<String>,
+    /// Whether the policy allows loading the model
+    pub trusted: bool,
+    /// Why the model may not be loaded
+    pub reason: Option<String>,
+    pub verified_at: chrono::DateTime<chrono::Utc>,
+}
+
+/// A public key models may be signed with
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct TrustedKey {
+    pub id: String,
+    pub key: [u8; 32],
+}
+
+/// What a model must pass to be loaded, from `model_security`
+#[derive(Debug, Clone, Default)]
+pub struct VerificationPolicy {
+    pub verify_checksums: bool,
+    pub require_signatures: bool,
+    pub trusted_keys: Vec<TrustedKey>,
+}
+
+impl VerificationPolicy {
+    pub fn from_config(config: Option<&ModelSecurityConfig>) -> Result<Self> {
+        let Some(config) = config else {
+            return Ok(Self::default());
+        };
+        let trusted_keys = config
+            .trusted_keys
+            .iter()
+            .map(|key| parse_public_key(key))
+            .collect::<Result<Vec<_>>>()?;
+        if config.require_signatures && trusted_keys.is_empty() {
+            anyhow::bail!("model_security.require_signatures needs at least one trusted key");
+        }
+        Ok(Self {
+            verify_checksums: config.verify_checksums,
+            require_signatures: config.require_signatures,
+            trusted_keys,
+        })
+    }
+
+    /// Whether loading a model involves any check
+    pub fn is_enabled(&self) -> bool {
+        self.verify_checksums || self.require_signatures || !self.trusted_keys.is_empty()
+    }
+
+    /// Decide whether a model may be loaded, returning the reason if not
+    pub fn evaluate(&self, checksum: ChecksumStatus, signature: SignatureStatus) -> Option<String> {
+        if signature == SignatureStatus::Untrusted && !self.trusted_keys.is_empty() {
+            return Some("the model's signature does not match any trusted key".to_string());
+        }
+        if self.require_signatures && signature != SignatureStatus::Valid {
+            return Some("the model is not signed by a trusted key".to_string());
+        }
+        // A trusted signature vouches for a file that was replaced on purpose
+        if self.verify_checksums
+            && checksum == ChecksumStatus::Mismatch
+            && signature != SignatureStatus::Valid
+        {
+            return Some("the model's SHA-256 differs from the recorded digest".to_string());
+        }
+        None
+    }
+}
+
+/// Short identifier of a public key: the start of its SHA-256
+pub fn key_id(key: &[u8]) -> String {
+    hex::encode(Sha256::digest(key))[..16].to_string()
+}
+
+/// Parse an Ed25519 public key given as base64 of the raw 32 bytes, or as a
+/// PEM `PUBLIC KEY` (as written by `openssl pkey -pubout`)
+pub fn parse_public_key(text: &str) -> Result<TrustedKey> {
+    let der = decode_pem_or_base64(text)
+        .map_err(|e| anyhow::anyhow!("invalid trusted key encoding: {}", e))?;
+    let key: [u8; 32] = match der.len() {
+        32 => der.as_slice().try_into()?,
+        44 if der.starts_with(&ED25519_SPKI_PREFIX) => der[12..].try_into()?,
+        _ => anyhow::bail!("trusted keys must be Ed25519 public keys"),
+    };
+    Ok(TrustedKey {
+        id: key_id(&key),
+        key,
+    })
+}
+
+fn decode_pem_or_base64(text: &str) -> Result<Vec<u8>, base64::DecodeError> {
+    let body: String = text
+        .lines()
+        .map(str::trim)
+        .filter(|line| !line.starts_with("-----"))
+        .collect();
+    general_purpose::STANDARD.decode(body)
+}
+
+fn pem(label: &str, der: &[u8]) -> String {
+    let encoded = general_purpose::STANDARD.encode(der);
+    let mut pem = format!("-----BEGIN {}-----\n", label);
+    for line in encoded.as_bytes().chunks(64) {
+        pem.push_str(&String::from_utf8_lossy(line));
+        pem.push('\n');
+    }
+    pem.push_str(&format!("-----END {}-----\n", label));
+    pem
+}
+
+/// Where a model's detached signature lives
+pub fn signature_path(model: &Path) -> PathBuf {
+    let mut path = model.as_os_str().to_owned();
+    path.push(".sig");
+    PathBuf::from(path)
+}
+
+fn digest_bytes(sha256: &str) -> Result<Vec<u8>> {
+    let digest = hex::decode(sha256)?;
+    if digest.len() != 32 {
+        anyhow::bail!("{} is not a SHA-256 digest", sha256);
+    }
+    Ok(digest)
+}
+
+/// Check a base64 signature of a digest, returning the verifying key's id
+pub fn verify_signature(sha256: &str, signature: &str, keys: &[TrustedKey]) -> Option<String> {
+    let digest = digest_bytes(sha256).ok()?;
+    let signature = general_purpose::STANDARD.decode(signature.trim()).ok()?;
+    keys.iter()
+        .find(|trusted| {
+            UnparsedPublicKey::new(&signature::ED25519, trusted.key)
+                .verify(&digest, &signature)
+                .is_ok()
+        })
+        .map(|trusted| trusted.id.clone())
+}
+
+/// Sign a digest with a PEM or base64 PKCS#8 Ed25519 private key, returning
+/// the base64 signature
+pub fn sign_digest(sha256: &str, private_key: &str) -> Result<String> {
+    let pkcs8 = decode_pem_or_base64(private_key)
+        .map_err(|e| anyhow::anyhow!("invalid private key encoding: {}", e))?;
+    let key_pair = Ed25519KeyPair::from_pkcs8_maybe_unchecked(&pkcs8)
+        .map_err(|e| anyhow::anyhow!("not an Ed25519 PKCS#8 private key: {}", e))?;
+    let signature = key_pair.sign(&digest_bytes(sha256)?);
+    Ok(general_purpose::STANDARD.encode(signature.as_ref()))
+}
+
+/// Generate a signing key, returning the PKCS#8 private key as PEM and the
+/// public key as base64 (the form `trusted_keys` takes)
+pub fn generate_signing_key() -> Result<(String, String)> {
+    let pkcs8 = Ed25519KeyPair::generate_pkcs8(&SystemRandom::new())
+        .map_err(|_| anyhow::anyhow!("could not generate an Ed25519 key"))?;
+    let key_pair = Ed25519KeyPair::from_pkcs8(pkcs8.as_ref())
+        .map_err(|e| anyhow::anyhow!("generated key is unusable: {}", e))?;
+    Ok((
+        pem("PRIVATE KEY", pkcs8.as_ref()),
+        general_purpose::STANDARD.encode(key_pair.public_key().as_ref()),
+    ))
+}
+
+/// The public key of a private key, as base64
+pub fn public_key_of(private_key: &str) -> Result<String> {
+    let pkcs8 = decode_pem_or_base64(private_key)
+        .map_err(|e| anyhow::anyhow!("invalid private key encoding: {}", e))?;
+    let key_pair = Ed25519KeyPair::from_pkcs8_maybe_unchecked(&pkcs8)
+        .map_err(|e| anyhow::anyhow!("not an Ed25519 PKCS#8 private key: {}", e))?;
+    Ok(general_purpose::STANDARD.encode(key_pair.public_key().as_ref()))
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    const DIGEST: &str = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855";
+
+    #[test]
+    fn signs_and_verifies_digests() {
+        let (private_key, public_key) = generate_signing_key().unwrap();
+        assert_eq!(public_key_of(&private_key).unwrap(), public_key);
+        let trusted = parse_public_key(&public_key).unwrap();
+
+        let signature = sign_digest(DIGEST, &private_key).unwrap();
+        assert_eq!(
+            verify_signature(DIGEST, &signature, std::slice::from_ref(&trusted)),
+            Some(trusted.id.clone())
+        );
+
+        let other = "a".repeat(64);
+        assert_eq!(
+            verify_signature(&other, &signature, &[trusted.clone()]),
+            None
+        );
+        let (_, stranger) = generate_signing_key().unwrap();
+        assert_eq!(
+            verify_signature(DIGEST, &signature, &[parse_public_key(&stranger).unwrap()]),
+            None
+        );
+        assert_eq!(verify_signature(DIGEST, "not base64!", &[trusted]), None);
+    }
+
+    #[test]
+    fn parses_pem_public_keys() {
+        // RFC 8410 example public key
+        let pem = "-----BEGIN PUBLIC KEY-----\n\
+                   MCowBQYDK2VwAyEAGb9ECWmEzf6FQbrBZ9w7lshQhqowtrbLDFw4rXAxZuE=\n\
+                   -----END PUBLIC KEY-----\n";
+        let key = parse_public_key(pem).unwrap();
+        assert_eq!(
+            hex::encode(key.key),
+            "19bf44096984cdfe8541bac167dc3b96c85086aa30b6b6cb0c5c38ad703166e1"
+        );
+        let raw = general_purpose::STANDARD.encode(key.key);
+        assert_eq!(parse_public_key(&raw).unwrap(), key);
+
+        assert!(parse_public_key("c2hvcnQ=").is_err());
+        assert!(parse_public_key("%%%").is_err());
+    }
+
+    #[test]
+    fn applies_the_policy() {
+        let (_, public_key) = generate_signing_key().unwrap();
+        let mut policy = VerificationPolicy {
+            verify_checksums: true,
+            ..Default::default()
+        };
+        use ChecksumStatus::*;
+        use SignatureStatus::*;
+
+        assert_eq!(policy.evaluate(Recorded, Unsigned), None);
+        assert!(policy.evaluate(Mismatch, Unsigned).is_some());
+        assert_eq!(policy.evaluate(Mismatch, Valid), None);
+        // Without trusted keys nothing can vouch for a signature
+        assert_eq!(policy.evaluate(Matched, Untrusted), None);
+
+        policy
+            .trusted_keys
+            .push(parse_public_key(&public_key).unwrap());
+        assert!(policy.evaluate(Matched, Untrusted).is_some());
+        assert_eq!(policy.evaluate(Matched, Unsigned), None);
+
+        policy.require_signatures = true;
+        assert!(policy.evaluate(Matched, Unsigned).is_some());
+        assert_eq!(policy.evaluate(Matched, Valid), None);
+
+        policy.verify_checksums = false;
+        policy.require_signatures = false;
+        assert_eq!(policy.evaluate(Mismatch, Unsigned), None);
+    }
+
+    #[test]
+    fn names_signature_files() {
+        assert_eq!(
+            signature_path(Path::new("/models/llama.Q4_K_M.gguf")),
+            PathBuf::from("/models/llama.Q4_K_M.gguf.sig")
+        );
+    }
+}
@@ -39,6 +39,34 @@ impl Default for BatchingConfig {
     }
 }
 
+impl BatchingConfig {
+    /// Check that the limits are usable before applying them at runtime
+    pub fn validate(&self) -> Result<()> {
+        if self.max_batch_size == 0 {
+            anyhow::bail!("max_batch_size must be at least 1");
+        }
+        if self.min_batch_size == 0 || self.min_batch_size > self.max_batch_size {
+            anyhow::bail!("min_batch_size must be between 1 and max_batch_size");
+        }
+        if self.max_wait_time_ms > MAX_WAIT_TIME_LIMIT_MS {
+            anyhow::bail!(
+                "max_wait_time_ms must not exceed {}",
+                MAX_WAIT_TIME_LIMIT_MS
+            );
+        }
+        if self.throughput_target <= 0.0 {
+            anyhow::bail!("throughput_target must be positive");
+        }
+        Ok(())
+    }
+}
+
+/// Longest batching window accepted from runtime configuration
+pub const MAX_WAIT_TIME_LIMIT_MS: u64 = 10_000;
+
+/// Number of completed batches kept for per-batch statistics
+const RECENT_BATCH_HISTORY: usize = 100;
+
 /// Padding strategies for batching
 #[derive(Debug, Clone, Serialize, Deserialize)]
 pub enum PaddingStrategy {
@@ -137,9 +165,30 @@ pub struct BatchingMetrics {
     pub requests_per_second: f64,
 }
 
+/// Statistics for a single completed batch
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct BatchRecord {
+    pub id: Uuid,
+    pub size: usize,
+    pub avg_sequence_length: f64,
+    /// Time the oldest request in the batch spent queued
+    pub max_queue_wait_ms: u64,
+    pub processing_time_ms: u64,
+    pub completed_at: chrono::DateTime<chrono::Utc>,
+}
+
+/// Limits currently in force after adaptive adjustment
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct EffectiveBatchingParams {
+    pub current_batch_size: usize,
+    pub current_wait_time_ms: u64,
+    pub recent_throughput: f64,
+}
+
 /// Dynamic batcher implementation
 pub struct DynamicBatcher {
-    config: BatchingConfig,
+    config: Arc<RwLock<BatchingConfig>>,
+    recent_batches: Arc<RwLock<VecDeque<BatchRecord>>>,
     metrics: Arc<RwLock<BatchingMetrics>>,
     request_queues: Arc<RwLock<HashMap<Priority, VecDeque<BatchRequest>>>>,
     batch_sender: mpsc::UnboundedSender<Batch>,
@@ -174,7 +223,8 @@ impl DynamicBatcher {
         };
 
         Ok(Self {
-            config,
+            config: Arc::new(RwLock::new(config)),
+            recent_batches: Arc::new(RwLock::new(VecDeque::with_capacity(RECENT_BATCH_HISTORY))),
             metrics: Arc::new(RwLock::new(BatchingMetrics::default())),
             request_queues: Arc::new(RwLock::new(request_queues)),
             batch_sender,
@@ -239,7 +289,7 @@ impl DynamicBatcher {
             }
 
             // Adaptive parameter adjustment
-            if self.config.adaptive_batching {
+            if self.config.read().await.adaptive_batching {
                 self.adjust_adaptive_parameters().await;
             }
         }
@@ -247,6 +297,7 @@ impl DynamicBatcher {
 
     /// Try to create a batch from queued requests
     async fn try_create_batch(&self) -> Option<Batch> {
+        let config = self.config.read().await.clone();
         let mut queues = self.request_queues.write().await;
         let adaptive_params = self.adaptive_params.read().await;
 
@@ -258,7 +309,7 @@ impl DynamicBatcher {
             if let Some(queue) = queues.get_mut(&priority) {
                 while batch_requests.len() < max_batch_size && !queue.is_empty() {
                     // Check if we should wait for more requests
-                    if batch_requests.len() < self.config.min_batch_size {
+                    if batch_requests.len() < config.min_batch_size {
                         if let Some(oldest_request) = queue.front() {
                             if oldest_request.received_at.elapsed()
                                 < adaptive_params.current_wait_time
@@ -279,10 +330,11 @@ impl DynamicBatcher {
             }
         }
 
-        if !batch_requests.is_empty() && self.should_create_batch(&batch_requests, &adaptive_params)
+        if !batch_requests.is_empty()
+            && Self::should_create_batch(&config, &batch_requests, &adaptive_params)
         {
             // Group by sequence length if enabled
-            if self.config.sequence_length_grouping {
+            if config.sequence_length_grouping {
                 batch_requests = self.group_by_sequence_length(batch_requests);
             }
 
@@ -294,7 +346,7 @@ impl DynamicBatcher {
 
     /// Determine if a batch should be created
     fn should_create_batch(
-        &self,
+        config: &BatchingConfig,
         requests: &[BatchRequest],
         adaptive_params: &AdaptiveParams,
     ) -> bool {
@@ -308,7 +360,7 @@ impl DynamicBatcher {
         }
 
         // Create if minimum batch size is met and timeout exceeded
-        if requests.len() >= self.config.min_batch_size {
+        if requests.len() >= config.min_batch_size {
             if let Some(oldest) = requests.iter().min_by_key(|r| r.received_at) {
                 return oldest.received_at.elapsed() >= adaptive_params.current_wait_time;
             }
@@ -388,6 +440,13 @@ impl DynamicBatcher {
     async fn process_batch(&self, batch: Batch) {
         let start_time = Instant::now();
         let batch_size = batch.size();
+        let avg_sequence_length = batch.avg_sequence_length();
+        let max_queue_wait = batch
+            .requests
+            .iter()
+            .map(|r| batch.created_at.saturating_duration_since(r.received_at))
+            .max()
+            .unwrap_or_default();
 
         tracing::debug!("Processing batch {} with {} requests", batch.id, batch_size);
 
@@ -404,6 +463,15 @@ impl DynamicBatcher {
         // Update metrics
         let processing_time = start_time.elapsed();
         self.update_metrics(batch_size, processing_time).await;
+        self.record_batch(BatchRecord {
+            id: batch.id,
+            size: batch_size,
+            avg_sequence_length,
+            max_queue_wait_ms: max_queue_wait.as_millis() as u64,
+            processing_time_ms: processing_time.as_millis() as u64,
+            completed_at: chrono::Utc::now(),
+        })
+        .await;
 
         tracing::debug!("Batch processing completed in {:?}", processing_time);
     }
@@ -454,7 +522,7 @@ impl DynamicBatcher {
         }
 
         // Calculate efficiency ratio (actual vs ideal throughput)
-        let ideal_throughput = self.config.throughput_target;
+        let ideal_throughput = self.config.read().await.throughput_target;
         metrics.efficiency_ratio = metrics.requests_per_second / ideal_throughput;
 
         // Calculate throughput improvement (vs single request processing)
@@ -463,6 +531,7 @@ impl DynamicBatcher {
 
     /// Adjust adaptive parameters based on performance
     async fn adjust_adaptive_parameters(&self) {
+        let config = self.config.read().await.clone();
         let mut adaptive_params = self.adaptive_params.write().await;
         let metrics = self.metrics.read().await;
 
@@ -472,7 +541,7 @@ impl DynamicBatcher {
         }
 
         let current_throughput = metrics.requests_per_second;
-        let target_throughput = self.config.throughput_target;
+        let target_throughput = config.throughput_target;
 
         tracing::debug!(
             "Adjusting adaptive parameters: current_throughput={:.2}, target={:.2}",
@@ -484,12 +553,11 @@ impl DynamicBatcher {
         if current_throughput < target_throughput * 0.8 {
             // Increase batch size to improve throughput
             adaptive_params.current_batch_size =
-                (adaptive_params.current_batch_size + 2).min(self.config.max_batch_size);
+                (adaptive_params.current_batch_size + 2).min(config.max_batch_size);
         } else if current_throughput > target_throughput * 1.2 {
             // Decrease batch size to reduce latency
             adaptive_params.current_batch_size =
-                (adaptive_params.current_batch_size.saturating_sub(1))
-                    .max(self.config.min_batch_size);
+                (adaptive_params.current_batch_size.saturating_sub(1)).max(config.min_batch_size);
         }
 
         // Adjust wait time based on efficiency
@@ -497,7 +565,7 @@ impl DynamicBatcher {
             // Increase wait time to allow larger batches
             adaptive_params.current_wait_time = (adaptive_params.current_wait_time
                 + Duration::from_millis(5))
-            .min(Duration::from_millis(self.config.max_wait_time_ms));
+            .min(Duration::from_millis(config.max_wait_time_ms));
         } else if metrics.efficiency_ratio > 1.3 {
             // Decrease wait time to reduce latency
             adaptive_params.current_wait_time = adaptive_params
@@ -521,6 +589,67 @@ impl DynamicBatcher {
         self.metrics.read().await.clone()
     }
 
+    /// Get the configured batching limits
+    pub async fn config(&self) -> BatchingConfig {
+        self.config.read().await.clone()
+    }
+
+    /// Replace the batching limits without restarting the batcher.
+    ///
+    /// Adaptive parameters are clamped into the new bounds so the change
+    /// takes effect on the next batch.
+    pub async fn update_config(&self, config: BatchingConfig) -> Result<()> {
+        config.validate()?;
+
+        let mut adaptive_params = self.adaptive_params.write().await;
+        adaptive_params.current_batch_size = adaptive_params
+            .current_batch_size
+            .clamp(config.min_batch_size, config.max_batch_size);
+        if !config.adaptive_batching {
+            adaptive_params.current_batch_size = config.max_batch_size;
+        }
+        adaptive_params.current_wait_time = Duration::from_millis(config.max_wait_time_ms);
+
+        tracing::info!(
+            "Batching configuration updated: max_batch_size={}, max_wait_time_ms={}, padding={:?}",
+            config.max_batch_size,
+            config.max_wait_time_ms,
+            config.padding_strategy
+        );
+        *self.config.write().await = config;
+        Ok(())
+    }
+
+    /// Get the limits currently in force after adaptive adjustment
+    pub async fn effective_params(&self) -> EffectiveBatchingParams {
+        let adaptive_params = self.adaptive_params.read().await;
+        EffectiveBatchingParams {
+            current_batch_size: adaptive_params.current_batch_size,
+            current_wait_time_ms: adaptive_params.current_wait_time.as_millis() as u64,
+            recent_throughput: adaptive_params.recent_throughput,
+        }
+    }
+
+    /// Get statistics for the most recent batches, newest first
+    pub async fn recent_batches(&self, limit: usize) -> Vec<BatchRecord> {
+        self.recent_batches
+            .read()
+            .await
+            .iter()
+            .rev()
+            .take(limit)
+            .cloned()
+            .collect()
+    }
+
+    async fn record_batch(&self, record: BatchRecord) {
+        let mut recent = self.recent_batches.write().await;
+        if recent.len() == RECENT_BATCH_HISTORY {
+            recent.pop_front();
+        }
+        recent.push_back(record);
+    }
+
     /// Benchmark batching performance
     pub async fn benchmark(&self, _model_path: &str, num_requests: usize) -> Result<f64> {
         tracing::info!("Benchmarking batching with {} requests", num_requests);
@@ -573,7 +702,8 @@ impl DynamicBatcher {
 impl Clone for DynamicBatcher {
     fn clone(&self) -> Self {
         Self {
-            config: self.config.clone(),
+            config: Arc::clone(&self.config),
+            recent_batches: Arc::clone(&self.recent_batches),
             metrics: Arc::clone(&self.metrics),
             request_queues: Arc::clone(&self.request_queues),
             batch_sender: self.batch_sender.clone(),
@@ -618,6 +748,28 @@ mod tests {
         assert!(batch.avg_sequence_length() > 0.0);
     }
 
+    #[tokio::test]
+    async fn test_update_config() {
+        let batcher = DynamicBatcher::new(BatchingConfig::default())
+            .await
+            .unwrap();
+
+        let mut config = BatchingConfig::default();
+        config.max_batch_size = 8;
+        config.max_wait_time_ms = 5;
+        batcher.update_config(config).await.unwrap();
+
+        assert_eq!(batcher.config().await.max_batch_size, 8);
+        let params = batcher.effective_params().await;
+        assert!(params.current_batch_size <= 8);
+        assert_eq!(params.current_wait_time_ms, 5);
+
+        let mut invalid = BatchingConfig::default();
+        invalid.min_batch_size = 64;
+        assert!(batcher.update_config(invalid).await.is_err());
+        assert_eq!(batcher.config().await.max_batch_size, 8);
+    }
+
     #[test]
     fn test_priority_ordering() {
         assert!(Priority::High > Priority::Normal);
@@ -189,6 +189,7 @@ impl PerformanceBaseline {
                 format: "gguf".to_string(),
                 checksum: None,
                 metadata: std::collections::HashMap::new(),
+                verification: None,
             });
         }
 
@@ -211,6 +212,7 @@ impl PerformanceBaseline {
                 format: "onnx".to_string(),
                 checksum: None,
                 metadata: std::collections::HashMap::new(),
+                verification: None,
             });
         }
 
@@ -248,6 +250,10 @@ impl PerformanceBaseline {
             stream: false,
             stop_sequences: vec![],
             seed: None,
+            stop_token_ids: vec![],
+            ignore_eos: false,
+            skip_special_tokens: None,
+            prompt_token_ids: None,
         };
 
         let test_prompts = vec![
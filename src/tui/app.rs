@@ -893,6 +893,10 @@ impl App {
             stream: true,
             seed: None,
             stop_sequences: vec![],
+            stop_token_ids: vec![],
+            ignore_eos: false,
+            skip_special_tokens: None,
+            prompt_token_ids: None,
         };
 
         // Create channel for streaming
@@ -39,6 +39,7 @@ fn test_model_info() {
         format: "gguf".to_string(),
         checksum: None,
         metadata: HashMap::new(),
+        verification: None,
     };
 
     assert_eq!(model.name, "test-model");
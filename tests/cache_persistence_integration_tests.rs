@@ -113,6 +113,7 @@ mod cache_test_utils {
             format: "gguf".to_string(),
             checksum: None,
             metadata: HashMap::new(),
+            verification: None,
         }
     }
 
@@ -319,6 +319,7 @@ impl DataFixtures {
                 ("test_model".to_string(), "true".to_string()),
                 ("created_by".to_string(), "inferno_test".to_string()),
             ]),
+            verification: None,
             backend_type: None,
             created_at: SystemTime::now(),
             modified_at: SystemTime::now(),
@@ -336,6 +337,10 @@ impl DataFixtures {
             stream: false,
             stop_sequences: vec![],
             seed: None,
+            stop_token_ids: vec![],
+            ignore_eos: false,
+            skip_special_tokens: None,
+            prompt_token_ids: None,
         }
     }
 
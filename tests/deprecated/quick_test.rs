@@ -23,6 +23,7 @@ fn test_core_types() -> Result<(), Box<dyn std::error::Error>> {
         backend_type: BackendType::GGUF,
         size_bytes: 1000,
         metadata: Default::default(),
+        verification: None,
     };
     println!("✅ Model types work: {}", model_info.name);
 
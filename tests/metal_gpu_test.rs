@@ -48,6 +48,10 @@ mod metal_gpu_tests {
             stream: false,
             stop_sequences: vec![],
             seed: Some(42), // Deterministic output
+            stop_token_ids: vec![],
+            ignore_eos: false,
+            skip_special_tokens: None,
+            prompt_token_ids: None,
         };
 
         let result = backend_handle
@@ -195,6 +195,7 @@ mod metal_tests {
             backend_type: "gguf".to_string(),
             checksum: None,
             metadata: Default::default(),
+            verification: None,
         };
 
         // ── Load model ──────────────────────────────────────────────────────
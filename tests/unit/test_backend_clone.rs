@@ -38,6 +38,7 @@ async fn main() -> anyhow::Result<()> {
         loaded: false,
         checksum: None,
         metadata: std::collections::HashMap::new(),
+        verification: None,
     };
 
     let cached_model = CachedModel {